@@ -8,6 +8,8 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/olivere/elastic/v7"
 	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
 	"os"
 	"strings"
 	"sync"
@@ -15,16 +17,19 @@ import (
 )
 
 const (
-	esAggsSize             = 100000
-	cveScanLogsEsIndex     = "cve-scan"
-	complianceLogsEsIndex  = "compliance-scan-logs"
-	scanStatusNeverScanned = "never_scanned"
-	nodeSeverityRedisKey   = "NODE_SEVERITY"
+	esAggsSize            = 100000
+	cveScanLogsEsIndex    = "cve-scan"
+	cveEsIndex            = "cve"
+	complianceLogsEsIndex = "compliance-scan-logs"
+	nodeSeverityRedisKey  = "NODE_SEVERITY"
 )
 
 var (
-	vulnerabilityStatusMap map[string]string
-	nStatus                *Status
+	vulnerabilityStatusMap = map[string]string{
+		"QUEUED": "queued", "STARTED": "in_progress", "SCAN_IN_PROGRESS": "in_progress", "WARN": "in_progress",
+		"COMPLETED": "complete", "ERROR": "error", "STOPPED": "error", "UPLOADING_IMAGE": "in_progress",
+		"UPLOAD_COMPLETE": "in_progress"}
+	nStatus *Status
 )
 
 type Status struct {
@@ -40,23 +45,20 @@ type NodeSeverityData struct {
 }
 
 type NodeStatus struct {
-	VulnerabilityScanStatus     map[string]string
-	VulnerabilityScanStatusTime map[string]string
-	ComplianceScanStatus        map[string]string
-	ComplianceScanStatusTime    map[string]string
-	NodeSeverity                map[string]string
+	VulnerabilityScanStatus map[string]report.ScanStatus // by host name or image
+	ComplianceScanStatus    map[string]report.ScanStatus // by node ID
+	NodeSeverity            map[string]string            // by host name
+	generation              uint64                       // of VulnerabilityScanStatus
 	sync.RWMutex
 }
 
-func (st *Status) getNodeStatus() (map[string]string, map[string]string, map[string]string, map[string]string, map[string]string) {
+func (st *Status) getNodeStatus() (map[string]report.ScanStatus, map[string]report.ScanStatus, map[string]string) {
 	st.nodeStatus.RLock()
 	nodeIdVulnerabilityStatusMap := st.nodeStatus.VulnerabilityScanStatus
-	nodeIdVulnerabilityStatusTimeMap := st.nodeStatus.VulnerabilityScanStatusTime
 	nodeIdComplianceStatusMap := st.nodeStatus.ComplianceScanStatus
-	nodeIdComplianceStatusTimeMap := st.nodeStatus.ComplianceScanStatusTime
 	nodeSeverityMap := st.nodeStatus.NodeSeverity
 	st.nodeStatus.RUnlock()
-	return nodeIdVulnerabilityStatusMap, nodeIdVulnerabilityStatusTimeMap, nodeIdComplianceStatusMap, nodeIdComplianceStatusTimeMap, nodeSeverityMap
+	return nodeIdVulnerabilityStatusMap, nodeIdComplianceStatusMap, nodeSeverityMap
 }
 
 func (st *Status) getNodeSeverity() (map[string]string, error) {
@@ -117,57 +119,30 @@ func (st *Status) updateScanStatusData() error {
 	esQuery = elastic.NewSearchRequest().Index(complianceLogsEsIndex).Query(boolQuery).Size(0).Aggregation("node_id", nodeIdAggs)
 	mSearch.Add(esQuery)
 
+	// The vulnerabilities found by the latest scan of each image or host
+	scanIdAggs := elastic.NewTermsAggregation().Field("scan_id.keyword").Size(1).OrderByAggregation("scan_recent_timestamp", false)
+	scanIdAggs.SubAggregation("scan_recent_timestamp", elastic.NewMaxAggregation().Field("@timestamp"))
+	scanIdAggs.SubAggregation("cve_severity", elastic.NewTermsAggregation().Field("cve_severity.keyword").Size(50))
+	imageAggs := elastic.NewTermsAggregation().Field("cve_container_image.keyword").Size(esAggsSize).SubAggregation("scan_id", scanIdAggs)
+	esQuery = elastic.NewSearchRequest().Index(cveEsIndex).Query(elastic.NewMatchAllQuery()).Size(0).Aggregation("cve_container_image", imageAggs)
+	mSearch.Add(esQuery)
+
 	mSearchResult, err := mSearch.Do(context.Background())
 	if err != nil {
 		return err
 	}
-	nodeIdVulnerabilityStatusMap := make(map[string]string)
-	nodeIdVulnerabilityStatusTimeMap := make(map[string]string)
-	cveResp := mSearchResult.Responses[0]
-	nodeIdAggsBkt, ok := cveResp.Aggregations.Terms("node_id")
+	nodeIdVulnerabilityStatusMap, ok := vulnerabilityStatuses(mSearchResult.Responses[0].Aggregations, mSearchResult.Responses[2].Aggregations)
 	if !ok {
 		return nil
 	}
-	for _, nodeIdAggs := range nodeIdAggsBkt.Buckets {
-		if nodeIdAggs.Key.(string) == "" {
-			continue
-		}
-		latestScanTime := 0.0
-		var latestStatus, latestScanTimeStr string
-		scanStatusBkt, ok := nodeIdAggs.Aggregations.Terms("action")
-		if !ok {
-			continue
-		}
-		for _, scanStatusAggs := range scanStatusBkt.Buckets {
-			recentTimestampBkt, ok := scanStatusAggs.Aggregations.Max("scan_recent_timestamp")
-			if !ok || recentTimestampBkt == nil || recentTimestampBkt.Value == nil {
-				continue
-			}
-			if *recentTimestampBkt.Value > latestScanTime {
-				latestScanTime = *recentTimestampBkt.Value
-				latestStatus = scanStatusAggs.Key.(string)
-				valueAsStr, ok := recentTimestampBkt.Aggregations["value_as_string"]
-				if ok {
-					latestScanTimeStr = strings.ReplaceAll(string(valueAsStr), "\"", "")
-				}
-			}
-		}
-		latestStatus, ok = vulnerabilityStatusMap[latestStatus]
-		if !ok {
-			latestStatus = scanStatusNeverScanned
-		}
-		nodeIdVulnerabilityStatusMap[nodeIdAggs.Key.(string)] = latestStatus
-		nodeIdVulnerabilityStatusTimeMap[nodeIdAggs.Key.(string)] = latestScanTimeStr
-	}
 	st.nodeStatus.Lock()
 	st.nodeStatus.VulnerabilityScanStatus = nodeIdVulnerabilityStatusMap
-	st.nodeStatus.VulnerabilityScanStatusTime = nodeIdVulnerabilityStatusTimeMap
+	st.nodeStatus.generation++
 	st.nodeStatus.Unlock()
 
-	nodeIdComplianceStatusMap := make(map[string]string)
-	nodeIdComplianceStatusTimeMap := make(map[string]string)
+	nodeIdComplianceStatusMap := make(map[string]report.ScanStatus)
 	complianceResp := mSearchResult.Responses[1]
-	nodeIdAggsBkt, ok = complianceResp.Aggregations.Terms("node_id")
+	nodeIdAggsBkt, ok := complianceResp.Aggregations.Terms("node_id")
 	if !ok {
 		return nil
 	}
@@ -229,16 +204,122 @@ func (st *Status) updateScanStatusData() error {
 		if summary == "" {
 			summary = "Never Scanned"
 		}
-		nodeIdComplianceStatusMap[nodeIdAggs.Key.(string)] = summary
-		nodeIdComplianceStatusTimeMap[nodeIdAggs.Key.(string)] = nodeLatestScanTimeStr
+		nodeIdComplianceStatusMap[nodeIdAggs.Key.(string)] = report.ScanStatus{
+			Type:     report.ComplianceScan,
+			Status:   summary,
+			LastScan: parseScanTime(nodeLatestScanTimeStr),
+		}
 	}
 	st.nodeStatus.Lock()
 	st.nodeStatus.ComplianceScanStatus = nodeIdComplianceStatusMap
-	st.nodeStatus.ComplianceScanStatusTime = nodeIdComplianceStatusTimeMap
 	st.nodeStatus.Unlock()
 	return nil
 }
 
+// vulnerabilityStatuses returns the statuses of the latest vulnerability
+// scans of hosts and images, by host name or image name with its tag, from
+// the aggregations of the scan logs by node and action, and of the
+// vulnerabilities found by node, latest scan and severity.  It returns false
+// if the scan logs have no aggregation by node.
+func vulnerabilityStatuses(scanLogs, cves elastic.Aggregations) (map[string]report.ScanStatus, bool) {
+	nodeIdAggsBkt, ok := scanLogs.Terms("node_id")
+	if !ok {
+		return nil, false
+	}
+	counts := vulnerabilityCounts(cves)
+	statuses := make(map[string]report.ScanStatus)
+	for _, nodeIdAggs := range nodeIdAggsBkt.Buckets {
+		if nodeIdAggs.Key.(string) == "" {
+			continue
+		}
+		latestScanTime := 0.0
+		var latestStatus, latestScanTimeStr string
+		scanStatusBkt, ok := nodeIdAggs.Aggregations.Terms("action")
+		if !ok {
+			continue
+		}
+		for _, scanStatusAggs := range scanStatusBkt.Buckets {
+			recentTimestampBkt, ok := scanStatusAggs.Aggregations.Max("scan_recent_timestamp")
+			if !ok || recentTimestampBkt == nil || recentTimestampBkt.Value == nil {
+				continue
+			}
+			if *recentTimestampBkt.Value > latestScanTime {
+				latestScanTime = *recentTimestampBkt.Value
+				latestStatus = scanStatusAggs.Key.(string)
+				valueAsStr, ok := recentTimestampBkt.Aggregations["value_as_string"]
+				if ok {
+					latestScanTimeStr = strings.ReplaceAll(string(valueAsStr), "\"", "")
+				}
+			}
+		}
+		latestStatus, ok = vulnerabilityStatusMap[latestStatus]
+		if !ok {
+			latestStatus = report.ScanStatusNeverScanned
+		}
+		statuses[nodeIdAggs.Key.(string)] = vulnerabilityScanStatus(latestStatus, counts[nodeIdAggs.Key.(string)], parseScanTime(latestScanTimeStr))
+	}
+	return statuses, true
+}
+
+// vulnerabilityCounts returns the numbers of vulnerabilities found by the
+// latest scan of each host or image, by severity, from the aggregation of
+// the vulnerabilities found by node, latest scan and severity.
+func vulnerabilityCounts(cves elastic.Aggregations) map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	imageAggsBkt, ok := cves.Terms("cve_container_image")
+	if !ok {
+		return counts
+	}
+	for _, imageAggs := range imageAggsBkt.Buckets {
+		scanIdAggsBkt, ok := imageAggs.Aggregations.Terms("scan_id")
+		if !ok || len(scanIdAggsBkt.Buckets) == 0 {
+			continue
+		}
+		severityAggsBkt, ok := scanIdAggsBkt.Buckets[0].Aggregations.Terms("cve_severity")
+		if !ok {
+			continue
+		}
+		bySeverity := map[string]int{}
+		for _, severityAggs := range severityAggsBkt.Buckets {
+			bySeverity[strings.ToLower(fmt.Sprint(severityAggs.Key))] += int(severityAggs.DocCount)
+		}
+		counts[fmt.Sprint(imageAggs.Key)] = bySeverity
+	}
+	return counts
+}
+
+// vulnerabilityScanStatus returns the status of a vulnerability scan, with
+// the numbers of vulnerabilities found by the latest scan with results, and
+// the worst severity of them.
+func vulnerabilityScanStatus(status string, counts map[string]int, lastScan time.Time) report.ScanStatus {
+	s := report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   status,
+		Counts:   counts,
+		LastScan: lastScan,
+	}
+	if status == report.ScanStatusComplete {
+		s.Severity = render.SeverityNone
+	}
+	for _, severity := range render.Severities[:4] {
+		if counts[severity] > 0 {
+			s.Severity = severity
+			break
+		}
+	}
+	return s
+}
+
+// parseScanTime parses the time of a scan as elasticsearch formats it, or
+// returns the zero time if it can't.
+func parseScanTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 func formatComplianceStatus(count int, status string) string {
 	if count > 1 {
 		return fmt.Sprintf("%d scans %s", count, status)
@@ -248,11 +329,6 @@ func formatComplianceStatus(count int, status string) string {
 }
 
 func NewStatus() (*Status, error) {
-	vulnerabilityStatusMap = map[string]string{
-		"QUEUED": "queued", "STARTED": "in_progress", "SCAN_IN_PROGRESS": "in_progress", "WARN": "in_progress",
-		"COMPLETED": "complete", "ERROR": "error", "STOPPED": "error", "UPLOADING_IMAGE": "in_progress",
-		"UPLOAD_COMPLETE": "in_progress"}
-
 	esHost := os.Getenv("ELASTICSEARCH_HOST")
 	if esHost == "" {
 		esHost = "deepfence-es"
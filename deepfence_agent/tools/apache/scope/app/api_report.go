@@ -6,6 +6,9 @@ import (
 
 	"context"
 
+	"github.com/gorilla/mux"
+	"github.com/weaveworks/common/mtime"
+
 	"github.com/weaveworks/scope/report"
 )
 
@@ -23,15 +26,35 @@ func makeRawReportHandler(rep Reporter) CtxHandlerFunc {
 	}
 }
 
-type probeDesc struct {
-	ID       string    `json:"id"`
-	Hostname string    `json:"hostname"`
-	Version  string    `json:"version"`
-	LastSeen time.Time `json:"lastSeen"`
+// reportRangesLookback is how far back ranges of reports are listed by
+// default.
+const reportRangesLookback = 24 * time.Hour
+
+// Report ranges handler, listing the spans of time between the from and to
+// query parameters (by default the last day) that reports are held for.
+func makeReportRangesHandler(rep Reporter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		end, err := parseTimestampParam(r, "to", mtime.Now())
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		start, err := parseTimestampParam(r, "from", end.Add(-reportRangesLookback))
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		ranges, err := rep.ReportRanges(ctx, start, end)
+		if err != nil {
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		respondWith(ctx, w, http.StatusOK, ranges)
+	}
 }
 
 // Probe handler
-func makeProbeHandler(rep Reporter) CtxHandlerFunc {
+func makeProbeHandler(rep Reporter, probes ProbeRegistry) CtxHandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		if _, sparse := r.Form["sparse"]; sparse {
@@ -43,23 +66,29 @@ func makeProbeHandler(rep Reporter) CtxHandlerFunc {
 			respondWith(ctx, w, http.StatusOK, hasProbes)
 			return
 		}
-		rpt, err := rep.Report(ctx, time.Now())
+		statuses, err := probes.Probes(ctx)
 		if err != nil {
 			respondWith(ctx, w, http.StatusInternalServerError, err)
 			return
 		}
-		result := []probeDesc{}
-		for _, n := range rpt.Host.Nodes {
-			id, _ := n.Latest.Lookup(report.ControlProbeID)
-			hostname, _ := n.Latest.Lookup(report.HostName)
-			//version, dt, _ := n.Latest.LookupEntry(report.ScopeVersion)
-			result = append(result, probeDesc{
-				ID:       id,
-				Hostname: hostname,
-				//Version:  version,
-				//LastSeen: dt,
-			})
+		respondWith(ctx, w, http.StatusOK, statuses.List(mtime.Now()))
+	}
+}
+
+// Probe detail handler
+func makeProbeDetailHandler(probes ProbeRegistry) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		statuses, err := probes.Probes(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		status, ok := statuses[mux.Vars(r)["probeID"]]
+		if !ok {
+			http.NotFound(w, r)
+			return
 		}
-		respondWith(ctx, w, http.StatusOK, result)
+		status.Stale = status.IsStale(mtime.Now())
+		respondWith(ctx, w, http.StatusOK, status)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,10 +15,11 @@ import (
 
 	"github.com/gorilla/mux"
 	opentracing "github.com/opentracing/opentracing-go"
-	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/common/mtime"
 
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/probe/kubernetes"
+	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/report"
 )
@@ -36,11 +38,13 @@ const (
 	hostsID                = "hosts"
 	cloudProvidersID       = "cloud-providers"
 	cloudRegionsID         = "cloud-regions"
+	cloudHierarchyID       = "cloud-hierarchy"
 	kubernetesClustersID   = "kubernetes-clusters"
 	weaveID                = "weave"
 	ecsTasksID             = "ecs-tasks"
 	ecsServicesID          = "ecs-services"
 	swarmServicesID        = "swarm-services"
+	workloadsID            = "workloads"
 )
 
 var (
@@ -78,6 +82,45 @@ var (
 		SelectType: "union",
 		NoneLabel:  "All Controllers",
 	}
+	severityGrouping = APITopologyOptionGroup{
+		ID:      "group_by",
+		Default: "none",
+		Options: []APITopologyOption{
+			{Value: "none", Label: "Ungrouped", filter: nil, filterPseudo: false},
+			{Value: "severity", Label: "Grouped by severity", filter: nil, filterPseudo: false, transform: render.GroupBySeverity{}},
+		},
+	}
+	// The internet nodes are merged back by default, as the UI and the
+	// console know external traffic by their IDs
+	externalGrouping = APITopologyOptionGroup{
+		ID:      "external",
+		Default: "internet",
+		Options: []APITopologyOption{
+			{Value: "internet", Label: "External traffic as the Internet", filter: nil, filterPseudo: false, transform: render.MergeExternal{}},
+			{Value: "split", Label: "External traffic by destination", filter: nil, filterPseudo: false},
+		},
+	}
+	// The nodes of each host past the top few of longTailTop, ranked by
+	// longTailRank, are collapsed into one; see longTails.
+	longTailTop = APITopologyOptionGroup{
+		ID:      "top",
+		Default: "100",
+		Options: []APITopologyOption{
+			{Value: "10", Label: "Top 10 per host", filter: nil, filterPseudo: false},
+			{Value: "25", Label: "Top 25 per host", filter: nil, filterPseudo: false},
+			{Value: "100", Label: "Top 100 per host", filter: nil, filterPseudo: false},
+			{Value: "all", Label: "All per host", filter: nil, filterPseudo: false},
+		},
+	}
+	longTailRank = APITopologyOptionGroup{
+		ID:      "rank_by",
+		Default: "cpu",
+		Options: []APITopologyOption{
+			{Value: "cpu", Label: "By CPU", filter: nil, filterPseudo: false},
+			{Value: "memory", Label: "By memory", filter: nil, filterPseudo: false},
+			{Value: "connections", Label: "By connections", filter: nil, filterPseudo: false},
+		},
+	}
 	//storageFilter = APITopologyOptionGroup{
 	//	ID:      "storage",
 	//	Default: "hide",
@@ -110,7 +153,7 @@ func namespaceFilters(namespaces []string, noneLabel string) APITopologyOptionGr
 // updateFilters updates the available filters based on the current report.
 func updateFilters(rpt report.Report, topologies []APITopologyDesc) []APITopologyDesc {
 	//topologies = updateKubeFilters(rpt, topologies)
-	//topologies = updateSwarmFilters(rpt, topologies)
+	topologies = updateSwarmFilters(rpt, topologies)
 	topologies = updateMetadataFilters(rpt, topologies)
 	return topologies
 }
@@ -243,7 +286,7 @@ func updateSwarmFilters(rpt report.Report, topologies []APITopologyDesc) []APITo
 	}
 	topologies = append([]APITopologyDesc{}, topologies...) // Make a copy so we can make changes safely
 	for i, t := range topologies {
-		if t.id == containersID || t.id == swarmServicesID {
+		if t.id == containersID || t.id == swarmServicesID || t.id == workloadsID {
 			topologies[i] = mergeTopologyFilters(t, []APITopologyOptionGroup{
 				namespaceFilters(ns, "All Stacks"),
 			})
@@ -270,7 +313,7 @@ func updateKubeFilters(rpt report.Report, topologies []APITopologyDesc) []APITop
 	sort.Strings(ns)
 	topologies = append([]APITopologyDesc{}, topologies...) // Make a copy so we can make changes safely
 	for i, t := range topologies {
-		if t.id == containersID || t.id == podsID || t.id == servicesID || t.id == kubeControllersID {
+		if t.id == containersID || t.id == podsID || t.id == servicesID || t.id == kubeControllersID || t.id == workloadsID {
 			topologies[i] = mergeTopologyFilters(t, []APITopologyOptionGroup{
 				namespaceFilters(ns, "All Namespaces"),
 			})
@@ -337,8 +380,19 @@ func MakeRegistry() *Registry {
 				{Value: "hide", Label: "Hide uncontained", filter: render.IsNotPseudo, filterPseudo: true},
 			},
 		},
+		{
+			ID:      "secrets",
+			Default: "all",
+			Options: []APITopologyOption{
+				{Value: "all", Label: "All containers", filter: nil, filterPseudo: false},
+				{Value: "found", Label: "Containers with secrets", filter: render.HasSecrets, filterPseudo: false},
+			},
+		},
 		immediateParentFilter,
+		externalGrouping,
 	}
+	containerGroupings := append(append([]APITopologyOptionGroup{}, containerFilters...), severityGrouping)
+	containerLongTails := append(append([]APITopologyOptionGroup{}, containerGroupings...), longTailTop, longTailRank)
 
 	processFilter := []APITopologyOptionGroup{
 		{
@@ -350,7 +404,9 @@ func MakeRegistry() *Registry {
 			},
 		},
 		immediateParentFilter,
+		externalGrouping,
 	}
+	processLongTails := append(append([]APITopologyOptionGroup{}, processFilter...), longTailTop, longTailRank)
 
 	// Topology option labels should tell the current state. The first item must
 	// be the verb to get to that state
@@ -360,7 +416,7 @@ func MakeRegistry() *Registry {
 			renderer:    render.ConnectedProcessRenderer,
 			Name:        "Processes",
 			Rank:        1,
-			Options:     processFilter,
+			Options:     processLongTails,
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -374,16 +430,18 @@ func MakeRegistry() *Registry {
 		APITopologyDesc{
 			id:       containersID,
 			renderer: render.ContainerWithImageNameRenderer,
+			scored:   true,
 			Name:     "Containers",
 			Rank:     2,
-			Options:  containerFilters,
+			Options:  containerLongTails,
 		},
 		APITopologyDesc{
 			id:       containersByHostnameID,
 			parent:   containersID,
 			renderer: render.ContainerHostnameRenderer,
+			scored:   true,
 			Name:     "Containers by name",
-			Options:  containerFilters,
+			Options:  containerGroupings,
 		},
 		APITopologyDesc{
 			id:       containersByImageID,
@@ -395,9 +453,10 @@ func MakeRegistry() *Registry {
 		APITopologyDesc{
 			id:          podsID,
 			renderer:    render.PodRenderer,
+			scored:      true,
 			Name:        "Pods",
 			Rank:        3,
-			Options:     []APITopologyOptionGroup{unmanagedFilter, immediateParentFilter},
+			Options:     []APITopologyOptionGroup{unmanagedFilter, immediateParentFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -405,7 +464,7 @@ func MakeRegistry() *Registry {
 			parent:      podsID,
 			renderer:    render.KubeControllerRenderer,
 			Name:        "Kube controllers",
-			Options:     []APITopologyOptionGroup{k8sControllerTypeFilter, unmanagedFilter},
+			Options:     []APITopologyOptionGroup{k8sControllerTypeFilter, unmanagedFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -413,7 +472,15 @@ func MakeRegistry() *Registry {
 			parent:      podsID,
 			renderer:    render.PodServiceRenderer,
 			Name:        "Kube services",
-			Options:     []APITopologyOptionGroup{unmanagedFilter, immediateParentFilter},
+			Options:     []APITopologyOptionGroup{unmanagedFilter, immediateParentFilter, externalGrouping},
+			HideIfEmpty: true,
+		},
+		APITopologyDesc{
+			id:          workloadsID,
+			renderer:    render.WorkloadRenderer,
+			Name:        "Workloads",
+			Rank:        3,
+			Options:     []APITopologyOptionGroup{unmanagedFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -421,7 +488,7 @@ func MakeRegistry() *Registry {
 			renderer:    render.ECSTaskRenderer,
 			Name:        "ECS tasks",
 			Rank:        3,
-			Options:     []APITopologyOptionGroup{unmanagedFilter},
+			Options:     []APITopologyOptionGroup{unmanagedFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -429,7 +496,7 @@ func MakeRegistry() *Registry {
 			parent:      ecsTasksID,
 			renderer:    render.ECSServiceRenderer,
 			Name:        "ECS services",
-			Options:     []APITopologyOptionGroup{unmanagedFilter},
+			Options:     []APITopologyOptionGroup{unmanagedFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -437,7 +504,7 @@ func MakeRegistry() *Registry {
 			renderer:    render.SwarmServiceRenderer,
 			Name:        "Swarm services",
 			Rank:        3,
-			Options:     []APITopologyOptionGroup{unmanagedFilter},
+			Options:     []APITopologyOptionGroup{unmanagedFilter, externalGrouping},
 			HideIfEmpty: true,
 		},
 		APITopologyDesc{
@@ -445,7 +512,7 @@ func MakeRegistry() *Registry {
 			renderer: render.HostRenderer,
 			Name:     "Hosts",
 			Rank:     4,
-			Options:  []APITopologyOptionGroup{immediateParentFilter},
+			Options:  []APITopologyOptionGroup{immediateParentFilter, externalGrouping},
 		},
 		APITopologyDesc{
 			id:          cloudProvidersID,
@@ -461,6 +528,13 @@ func MakeRegistry() *Registry {
 			Name:        "Cloud Regions",
 			HideIfEmpty: true,
 		},
+		APITopologyDesc{
+			id:          cloudHierarchyID,
+			parent:      cloudProvidersID,
+			renderer:    render.CloudHierarchyRenderer,
+			Name:        "Cloud Hierarchy",
+			HideIfEmpty: true,
+		},
 		APITopologyDesc{
 			id:          kubernetesClustersID,
 			parent:      podsID,
@@ -490,6 +564,10 @@ type APITopologyDesc struct {
 	HideIfEmpty bool                     `json:"hide_if_empty"`
 	Options     []APITopologyOptionGroup `json:"options"`
 
+	// scored topologies have the exposure of their containers or pods
+	// scored, before they're filtered.
+	scored bool
+
 	URL           string            `json:"url"`
 	SubTopologies []APITopologyDesc `json:"sub_topologies,omitempty"`
 	Stats         topologyStats     `json:"stats,omitempty"`
@@ -554,6 +632,17 @@ func (g APITopologyOptionGroup) filter(value string) render.FilterFunc {
 	return render.AnyFilterFunc(filters...)
 }
 
+// Get the transformer of the option picked from this option group, if any,
+// or nil otherwise.
+func (g APITopologyOptionGroup) transformer(value string) render.Transformer {
+	for _, opt := range g.Options {
+		if opt.Value == value {
+			return opt.transform
+		}
+	}
+	return nil
+}
+
 // APITopologyOption describes a &param=value to a given topology.
 type APITopologyOption struct {
 	Value string `json:"value"`
@@ -561,6 +650,10 @@ type APITopologyOption struct {
 
 	filter       render.FilterFunc
 	filterPseudo bool
+
+	// transform, if any, rearranges the nodes once they are filtered, such
+	// as grouping them.
+	transform render.Transformer
 }
 
 type topologyStats struct {
@@ -583,6 +676,60 @@ func deserializeTimestamp(timestamp string) time.Time {
 	return time.Now()
 }
 
+// parseTimestampParam parses the RFC3339 timestamp of a query parameter,
+// which is def when not given.
+func parseTimestampParam(req *http.Request, name string, def time.Time) (time.Time, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// MaxHistoryWindow is the longest window requests may play back the
+// reports of, as all the reports within it are fetched and merged.  Zero
+// means no limit.
+var MaxHistoryWindow = time.Hour
+
+// reportForRequest returns the report a request is rendered from, with the
+// status to respond with if it can't be had.  Requests with a window play
+// back history: they are rendered from the reports received within the
+// window before their timestamp, and there must be some.  Others are
+// rendered from the collector's report at their timestamp.
+func reportForRequest(ctx context.Context, rep Reporter, req *http.Request) (report.Report, int, error) {
+	windowParam := req.URL.Query().Get("window")
+	if windowParam == "" {
+		rpt, err := rep.Report(ctx, deserializeTimestamp(req.URL.Query().Get("timestamp")))
+		if err != nil {
+			return rpt, http.StatusInternalServerError, err
+		}
+		return rpt, http.StatusOK, nil
+	}
+	window, err := time.ParseDuration(windowParam)
+	if err != nil || window <= 0 {
+		return report.MakeReport(), http.StatusBadRequest, fmt.Errorf("invalid window: %q", windowParam)
+	}
+	if MaxHistoryWindow > 0 && window > MaxHistoryWindow {
+		return report.MakeReport(), http.StatusBadRequest, fmt.Errorf("window %v is longer than %v", window, MaxHistoryWindow)
+	}
+	timestamp, err := parseTimestampParam(req, "timestamp", mtime.Now())
+	if err != nil {
+		return report.MakeReport(), http.StatusBadRequest, err
+	}
+	rpt, found, err := rep.HistoricReport(ctx, timestamp, window)
+	if err != nil {
+		return rpt, http.StatusInternalServerError, err
+	}
+	if !found {
+		return rpt, http.StatusNotFound, fmt.Errorf("no reports within %v before %v", window, timestamp.Format(time.RFC3339))
+	}
+	return rpt, http.StatusOK, nil
+}
+
 // AddContainerFilters adds to the default Registry (topologyRegistry)'s containerFilters
 func AddContainerFilters(newFilters ...APITopologyOption) {
 	topologyRegistry.AddContainerFilters(newFilters...)
@@ -664,9 +811,13 @@ func (r *Registry) renderTopologies(ctx context.Context, rpt report.Report, req
 	r.walk(func(desc APITopologyDesc) {
 		renderer, filter, _ := r.RendererForTopology(desc.id, req.Form, rpt)
 		desc.Stats = computeStats(ctx, rpt, renderer, filter)
+		desc.URL = URLPrefix + desc.URL
+		// The sub-topologies are shared with the registry
+		desc.SubTopologies = append([]APITopologyDesc(nil), desc.SubTopologies...)
 		for i, sub := range desc.SubTopologies {
 			renderer, filter, _ := r.RendererForTopology(sub.id, req.Form, rpt)
 			desc.SubTopologies[i].Stats = computeStats(ctx, rpt, renderer, filter)
+			desc.SubTopologies[i].URL = URLPrefix + sub.URL
 		}
 		topologies = append(topologies, desc)
 	})
@@ -705,12 +856,38 @@ func (r *Registry) RendererForTopology(topologyID string, values url.Values, rpt
 	}
 	topology = updateFilters(rpt, []APITopologyDesc{topology})[0]
 
+	var scorer []render.Transformer
+	if topology.scored {
+		scorer = []render.Transformer{render.ScoreExposure{Report: rpt, Weights: ExposureWeights}}
+	}
+
 	if len(values) == 0 {
 		// if no options where provided, only apply base filter
+		if scorer != nil {
+			return topology.renderer, render.Transformers(append(scorer, render.FilterUnconnectedPseudo)), nil
+		}
 		return topology.renderer, render.FilterUnconnectedPseudo, nil
 	}
 
-	var filters []render.FilterFunc
+	// Nodes matching the query, if any
+	filters, err := queryFilters(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	edgeFilter, err := edgeFilterFromValues(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	collapse, err := collapseFromValues(topologyID, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The namespace and cluster prune the report before it's rendered
+	renderer := render.Scoped(render.KubernetesScope{
+		Namespace: values.Get("namespace"),
+		Cluster:   values.Get("cluster"),
+	}, topology.renderer)
+	var transformers []render.Transformer
 	for _, group := range topology.Options {
 		value := group.Default
 		if vs := values[group.ID]; len(vs) > 0 {
@@ -719,11 +896,158 @@ func (r *Registry) RendererForTopology(topologyID string, values url.Values, rpt
 		if filter := group.filter(value); filter != nil {
 			filters = append(filters, filter)
 		}
+		if transformer := group.transformer(value); transformer != nil {
+			transformers = append(transformers, transformer)
+		}
+	}
+	// Edges are filtered before the nodes are grouped, while they still
+	// have their endpoints as children
+	if edgeFilter != nil {
+		transformers = append([]render.Transformer{edgeFilter}, transformers...)
+	}
+	// The long tail is collapsed last, ranking the nodes as shown
+	if collapse != nil {
+		transformers = append(transformers, collapse)
 	}
 	if len(filters) > 0 {
-		return topology.renderer, render.Transformers([]render.Transformer{render.ComposeFilterFuncs(filters...), render.FilterUnconnectedPseudo}), nil
+		transformers = append([]render.Transformer{render.ComposeFilterFuncs(filters...), render.FilterUnconnectedPseudo}, transformers...)
+	} else {
+		transformers = append([]render.Transformer{render.FilterUnconnectedPseudo}, transformers...)
+	}
+	// Exposure is scored first, on all the nodes and edges
+	transformers = append(scorer, transformers...)
+	if len(transformers) == 1 {
+		return renderer, transformers[0], nil
+	}
+	return renderer, render.Transformers(transformers), nil
+}
+
+// queryFilters returns the filter of the query of a request, if it has one.
+// The error of a query which doesn't parse is a *render.QueryError.
+func queryFilters(values url.Values) ([]render.FilterFunc, error) {
+	filter, err := render.ParseQuery(values.Get("q"))
+	if err != nil || filter == nil {
+		return nil, err
 	}
-	return topology.renderer, render.FilterUnconnectedPseudo, nil
+	return []render.FilterFunc{filter}, nil
+}
+
+// edgeProtocols are the protocols edges may be filtered by.
+var edgeProtocols = map[string]struct{}{render.ProtocolTCP: {}, "udp": {}, "quic": {}}
+
+// edgeFilterFromValues returns the filter of the edges of a topology by
+// ?port= and ?protocol=, each of which may be given several times or as a
+// list, and ?keep-isolated=true; nil if neither is given.
+func edgeFilterFromValues(values url.Values) (render.Transformer, error) {
+	var filter render.EdgeFilter
+	for _, port := range splitValues(values["port"]) {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, &optionError{name: "port", value: port}
+		}
+		filter.Ports = filter.Ports.Add(port)
+	}
+	for _, protocol := range splitValues(values["protocol"]) {
+		protocol = strings.ToLower(protocol)
+		if _, ok := edgeProtocols[protocol]; !ok {
+			return nil, &optionError{name: "protocol", value: protocol}
+		}
+		filter.Protocols = filter.Protocols.Add(protocol)
+	}
+	if len(filter.Ports) == 0 && len(filter.Protocols) == 0 {
+		return nil, nil
+	}
+	filter.KeepIsolated, _ = strconv.ParseBool(values.Get("keep-isolated"))
+	return filter, nil
+}
+
+// longTail is what of a topology is collapsed past the top few nodes of
+// each host: the topology of its nodes, and the metrics they're ranked by.
+type longTail struct {
+	topology string
+	metrics  map[string]string
+}
+
+// longTails are the topologies whose long tails are collapsed, by ID.
+var longTails = map[string]longTail{
+	processesID: {
+		topology: report.Process,
+		metrics:  map[string]string{"cpu": process.CPUUsage, "memory": process.MemoryUsage},
+	},
+	containersID: {
+		topology: report.Container,
+		metrics:  map[string]string{"cpu": docker.CPUTotalUsage, "memory": docker.MemoryUsage},
+	},
+}
+
+// collapseFromValues returns the transformer collapsing the long tail of a
+// topology by ?top=, a number or "all", and ?rank_by=, and keeping all the
+// nodes of the hosts of ?expand=, which may be given several times; nil if
+// the topology has none or all its nodes are asked for.
+func collapseFromValues(topologyID string, values url.Values) (render.Transformer, error) {
+	tail, ok := longTails[topologyID]
+	if !ok {
+		return nil, nil
+	}
+	top := longTailTop.Default
+	if v := values.Get(longTailTop.ID); v != "" {
+		top = v
+	}
+	if top == "all" {
+		return nil, nil
+	}
+	k, err := strconv.Atoi(top)
+	if err != nil || k < 1 {
+		return nil, &optionError{name: longTailTop.ID, value: top}
+	}
+	rank := longTailRank.Default
+	if v := values.Get(longTailRank.ID); v != "" {
+		rank = v
+	}
+	metric := render.RankByConnections
+	if rank != "connections" {
+		if metric, ok = tail.metrics[rank]; !ok {
+			return nil, &optionError{name: longTailRank.ID, value: rank}
+		}
+	}
+	return render.CollapseLongTail{
+		Topology:       tail.topology,
+		ParentTopology: report.Host,
+		K:              k,
+		Metric:         metric,
+		Expand:         report.MakeStringSet(values["expand"]...),
+	}, nil
+}
+
+// splitValues splits values given as comma separated lists.
+func splitValues(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// optionError is the error of an option of a topology with an invalid value.
+type optionError struct {
+	name, value string
+}
+
+func (e *optionError) Error() string {
+	return fmt.Sprintf("invalid %s %q", e.name, e.value)
+}
+
+// rendererErrorStatus is the status of the response to a request whose
+// renderer couldn't be made.
+func rendererErrorStatus(err error) int {
+	switch err.(type) {
+	case *render.QueryError, *optionError:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
 
 type reporterHandler func(context.Context, Reporter, http.ResponseWriter, *http.Request)
@@ -736,25 +1060,26 @@ func captureReporter(rep Reporter, f reporterHandler) CtxHandlerFunc {
 
 func (r *Registry) captureRenderer(rep Reporter, f rendererHandler) CtxHandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-		var (
-			topologyID = mux.Vars(req)["topology"]
-			timestamp  = deserializeTimestamp(req.URL.Query().Get("timestamp"))
-		)
+		topologyID := mux.Vars(req)["topology"]
 		if _, ok := r.get(topologyID); !ok {
 			http.NotFound(w, req)
 			return
 		}
-		rpt, err := rep.Report(ctx, timestamp)
+		span, ctx := opentracing.StartSpanFromContext(ctx, "app.renderTopology", opentracing.Tag{Key: "topology", Value: topologyID})
+		defer span.Finish()
+		rpt, status, err := reportForRequest(ctx, rep, req)
 		if err != nil {
-			respondWith(ctx, w, http.StatusInternalServerError, err)
+			respondWith(ctx, w, status, err)
 			return
 		}
 		req.ParseForm()
 		renderer, filter, err := r.RendererForTopology(topologyID, req.Form, rpt)
 		if err != nil {
-			respondWith(ctx, w, http.StatusInternalServerError, err)
+			respondWith(ctx, w, rendererErrorStatus(err), err)
 			return
 		}
+		start := time.Now()
 		f(ctx, renderer, filter, RenderContextForReporter(rep, rpt), w, req)
+		observeRender(topologyID, start)
 	}
 }
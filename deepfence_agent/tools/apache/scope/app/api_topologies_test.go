@@ -42,7 +42,7 @@ func TestAPITopology(t *testing.T) {
 	if err := decoder.Decode(&topologies); err != nil {
 		t.Fatalf("JSON parse error: %s", err)
 	}
-	equals(t, 6, len(topologies))
+	equals(t, 8, len(topologies))
 
 	for _, topology := range topologies {
 		is200(t, ts, topology.URL)
@@ -51,8 +51,9 @@ func TestAPITopology(t *testing.T) {
 			is200(t, ts, subTopology.URL)
 		}
 
-		// TODO: add ECS nodes in report fixture
-		if topology.Name == "Tasks" || topology.Name == "Services" {
+		// TODO: add cloud, ECS, Swarm and deployment nodes in report fixture
+		switch topology.Name {
+		case "Cloud Providers", "ECS tasks", "Swarm services", "Workloads":
 			continue
 		}
 
@@ -185,13 +186,13 @@ func getTestContainerLabelFilterTopologySummary(t *testing.T, exclude bool) (det
 	}
 
 	ctx := context.Background()
-	return detailed.Summaries(ctx, detailed.RenderContext{Report: fixture.Report}, render.Render(ctx, fixture.Report, renderer, filter).Nodes), nil
+	return detailed.Summaries(ctx, detailed.RenderContext{Report: fixture.Report}, render.Render(ctx, fixture.Report, renderer, filter).Nodes, true), nil
 }
 
 func TestAPITopologyAddsKubernetes(t *testing.T) {
 	router := mux.NewRouter()
 	c := app.NewCollector(1 * time.Minute)
-	app.RegisterReportPostHandler(c, router)
+	app.RegisterReportPostHandler(c, router, nil)
 	app.RegisterTopologyRoutes(router, c, map[string]bool{"foo_capability": true})
 	ts := httptest.NewServer(router)
 	defer ts.Close()
@@ -203,7 +204,7 @@ func TestAPITopologyAddsKubernetes(t *testing.T) {
 	if err := decoder.Decode(&topologies); err != nil {
 		t.Fatalf("JSON parse error: %s", err)
 	}
-	equals(t, 6, len(topologies))
+	equals(t, 8, len(topologies))
 
 	// Enable the kubernetes topologies
 	rpt := report.MakeReport()
@@ -237,7 +238,7 @@ func TestAPITopologyAddsKubernetes(t *testing.T) {
 	if err := decoder.Decode(&topologies); err != nil {
 		t.Fatalf("JSON parse error: %s", err)
 	}
-	equals(t, 6, len(topologies))
+	equals(t, 8, len(topologies))
 
 	found := false
 	for _, topology := range topologies {
@@ -3,6 +3,9 @@ package app
 import (
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"context"
@@ -11,7 +14,6 @@ import (
 	ot "github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/render"
@@ -23,19 +25,47 @@ const (
 	websocketLoop = 1 * time.Second
 )
 
-// APITopology is returned by the /api/topology/{name} handler.
+// APITopology is returned by the /api/topology/{name} handler.  With
+// ?limit, the nodes are a page of them, in order of ID, or most exposed
+// first with ?sort=exposure_score, from ?offset; the counts are of all of
+// them.  With ?edges=true, the edges from the nodes
+// are summarised too.
+//
+// Topologies with more nodes to summarise than RenderMaxNodes have their
+// nodes counted by group instead.  Those which take longer than
+// RenderTimeout to render are truncated to the nodes rendered in time, and
+// given with 206 Partial Content.
 type APITopology struct {
-	Nodes detailed.NodeSummaries `json:"nodes"`
+	Nodes         detailed.NodeSummaries `json:"nodes"`
+	NodeCount     int                    `json:"node_count"`
+	FilteredNodes int                    `json:"filtered_nodes"`
+	NextOffset    int                    `json:"next_offset,omitempty"` // of the next page, if there is one
+	Groups        map[string]int         `json:"groups,omitempty"`      // counts of nodes by host, instead of nodes
+	Truncated     bool                   `json:"truncated,omitempty"`
+	Edges         []detailed.EdgeSummary `json:"edges,omitempty"`
 }
 
 // APINode is returned by the /api/topology/{name}/{id} handler.
 type APINode struct {
-	Node detailed.Node `json:"node"`
+	Node    detailed.Node                `json:"node"`
+	Origins map[string]map[string]Origin `json:"origins,omitempty"`
+}
+
+// APIReachability is returned by the /api/topology/{name}/{id}/reachability
+// handler: the nodes reachable from the node within ?hops of adjacency, and
+// how many hops away each is.
+type APIReachability struct {
+	Nodes detailed.NodeSummaries `json:"nodes"`
+	Hops  map[string]int         `json:"hops"`
 }
 
+// maxReachabilityHops bounds the hops reachability may be asked within.
+const maxReachabilityHops = 10
+
 // RenderContextForReporter creates the rendering context for the given reporter.
+// The templates contributed by plugins are resolved against the built-in ones.
 func RenderContextForReporter(rep Reporter, r report.Report) detailed.RenderContext {
-	rc := detailed.RenderContext{Report: r}
+	rc := detailed.RenderContext{Report: r.ResolvePluginTemplates()}
 	if wrep, ok := rep.(WebReporter); ok {
 		rc.MetricsGraphURL = wrep.MetricsGraphURL
 	}
@@ -46,15 +76,203 @@ type rendererHandler func(context.Context, render.Renderer, render.Transformer,
 
 // Full topology.
 func handleTopology(ctx context.Context, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageFromRequest(r.Form)
+	if err != nil {
+		respondWith(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+	byExposure, err := sortFromRequest(r.Form)
+	if err != nil {
+		respondWith(ctx, w, http.StatusBadRequest, err)
+		return
+	}
 	censorCfg := report.GetCensorConfigFromRequest(r)
-	nodeSummaries := detailed.Summaries(ctx, rc, render.Render(ctx, rc.Report, renderer, transformer).Nodes, true)
-	respondWith(ctx, w, http.StatusOK, APITopology{
-		Nodes: detailed.CensorNodeSummaries(nodeSummaries, censorCfg),
+	topologyID := mux.Vars(r)["topology"]
+
+	tenant, ok, err := tenantRenders.acquire(ctx)
+	if err != nil {
+		respondWith(ctx, w, http.StatusInternalServerError, err)
+		return
+	} else if !ok {
+		renderGuards.WithLabelValues(topologyID, guardConcurrency).Inc()
+		respondWith(ctx, w, http.StatusTooManyRequests, "Too many topologies being rendered")
+		return
+	}
+	defer tenantRenders.release(tenant)
+	renderCtx := ctx
+	if RenderTimeout > 0 {
+		renderCtx = render.WithDeadline(ctx, time.Now().Add(RenderTimeout))
+	}
+
+	cacheKey, err := topologyRenders.key(ctx, topologyID, r.Form, rc.Report)
+	if err != nil {
+		respondWith(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	rendered, cached := topologyRenders.get(cacheKey)
+	if !cached {
+		rendered = render.Render(renderCtx, rc.Report, renderer, transformer)
+		// Renders cut short aren't kept
+		if !render.CutShort(renderCtx) {
+			topologyRenders.set(cacheKey, rendered)
+		}
+	}
+	// Only the nodes on the page are summarised, and none of a topology
+	// over the ceiling
+	var (
+		nodes  report.Nodes
+		next   int
+		groups map[string]int
+	)
+	if RenderMaxNodes > 0 && len(rendered.Nodes) > RenderMaxNodes {
+		renderGuards.WithLabelValues(topologyID, guardMaxNodes).Inc()
+		groups = groupCounts(rendered.Nodes)
+	} else {
+		nodes, next = pageNodes(rendered.Nodes, limit, offset, byExposure)
+	}
+	nodeSummaries := detailed.Summaries(renderCtx, rc, nodes, true)
+	var edges []detailed.EdgeSummary
+	if r.Form.Get("edges") == "true" {
+		edges = detailed.EdgeSummaries(rc.Report, nodes, rendered.Nodes)
+	}
+	status, truncated := http.StatusOK, false
+	if render.CutShort(renderCtx) {
+		renderGuards.WithLabelValues(topologyID, guardDeadline).Inc()
+		status, truncated, next = http.StatusPartialContent, true, 0
+	}
+	respondWith(ctx, w, status, APITopology{
+		Nodes: detailed.SelectFields(
+			detailed.CensorNodeSummaries(nodeSummaries, censorCfg),
+			fieldsFromRequest(r.Form),
+		),
+		NodeCount:     len(rendered.Nodes),
+		FilteredNodes: rendered.Filtered,
+		NextOffset:    next,
+		Groups:        groups,
+		Truncated:     truncated,
+		Edges:         edges,
 	})
 }
 
+// pageFromRequest returns the limit and offset of the page of nodes asked
+// for; a limit of 0 is all of them.
+func pageFromRequest(values url.Values) (limit, offset int, err error) {
+	for _, p := range []struct {
+		key   string
+		value *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		s := values.Get(p.key)
+		if s == "" {
+			continue
+		}
+		if *p.value, err = strconv.Atoi(s); err != nil || *p.value < 0 {
+			return 0, 0, errors.Errorf("invalid %s %q", p.key, s)
+		}
+	}
+	return limit, offset, nil
+}
+
+// sortFromRequest returns whether the nodes are paged most exposed first,
+// with ?sort=exposure_score, rather than by ID.
+func sortFromRequest(values url.Values) (bool, error) {
+	switch s := values.Get("sort"); s {
+	case "", "id":
+		return false, nil
+	case render.ExposureScore:
+		return true, nil
+	default:
+		return false, errors.Errorf("invalid sort %q", s)
+	}
+}
+
+// pageNodes returns the page of nodes in order of ID, or of exposure score
+// and then ID, and the offset of the next page, or 0 if this is the last.
+func pageNodes(nodes report.Nodes, limit, offset int, byExposure bool) (report.Nodes, int) {
+	if limit == 0 && offset == 0 {
+		return nodes, 0
+	}
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if byExposure {
+		scores := make(map[string]float64, len(ids))
+		for _, id := range ids {
+			score, _ := nodes[id].Latest.Lookup(render.ExposureScore)
+			scores[id], _ = strconv.ParseFloat(score, 64)
+		}
+		sort.SliceStable(ids, func(i, j int) bool { return scores[ids[i]] > scores[ids[j]] })
+	}
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	end, next := len(ids), 0
+	if limit > 0 && offset+limit < len(ids) {
+		end, next = offset+limit, offset+limit
+	}
+	page := make(report.Nodes, end-offset)
+	for _, id := range ids[offset:end] {
+		page[id] = nodes[id]
+	}
+	return page, next
+}
+
+// fieldsFromRequest returns the IDs of the metadata, metrics and tables to
+// send of each node, given as ?fields=a,b or ?fields=a&fields=b; nil for all.
+func fieldsFromRequest(values url.Values) []string {
+	var fields []string
+	for _, v := range values["fields"] {
+		for _, field := range strings.Split(v, ",") {
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// Nodes reachable from a node, within ?hops (1 by default).
+func handleReachability(ctx context.Context, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["id"]
+	hops := 1
+	if s := r.Form.Get("hops"); s != "" {
+		var err error
+		if hops, err = strconv.Atoi(s); err != nil || hops < 1 || hops > maxReachabilityHops {
+			respondWith(ctx, w, http.StatusBadRequest, errors.Errorf("invalid hops %q, expected 1 to %d", s, maxReachabilityHops))
+			return
+		}
+	}
+	reachable := render.Transformers{transformer, render.Reachable{From: nodeID, Hops: hops}}
+	rendered := render.Render(ctx, rc.Report, renderer, reachable)
+	if _, ok := rendered.Nodes[nodeID]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	result := APIReachability{Hops: map[string]int{}}
+	for id, node := range rendered.Nodes {
+		hop, _ := node.Latest.Lookup(render.HopsMark)
+		result.Hops[id], _ = strconv.Atoi(hop)
+	}
+	result.Nodes = detailed.CensorNodeSummaries(
+		detailed.Summaries(ctx, rc, rendered.Nodes, true),
+		report.GetCensorConfigFromRequest(r),
+	)
+	respondWith(ctx, w, http.StatusOK, result)
+}
+
 // Individual nodes.
-func handleNode(ctx context.Context, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
+//
+// With origins=true, the response says where the latest values of the node,
+// and of the nodes in the report it was rendered from, came from, by the ID
+// of the node in the report, if the reporter knows.
+func makeNodeHandler(rep Reporter) rendererHandler {
+	return func(ctx context.Context, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
+		handleNode(ctx, rep, renderer, transformer, rc, w, r)
+	}
+}
+
+func handleNode(ctx context.Context, rep Reporter, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
 	var (
 		censorCfg  = report.GetCensorConfigFromRequest(r)
 		vars       = mux.Vars(r)
@@ -80,7 +298,30 @@ func handleNode(ctx context.Context, renderer render.Renderer, transformer rende
 		nodes.Filtered--
 	}
 	rawNode := detailed.MakeNode(topologyID, rc, nodes.Nodes, node)
-	respondWith(ctx, w, http.StatusOK, APINode{Node: detailed.CensorNode(rawNode, censorCfg)})
+	apiNode := APINode{Node: detailed.CensorNode(rawNode, censorCfg)}
+	if withOrigins, _ := strconv.ParseBool(r.Form.Get("origins")); withOrigins {
+		if web, ok := rep.(WebReporter); ok {
+			rep = web.Reporter
+		}
+		origins, ok := rep.(OriginReporter)
+		if !ok {
+			respondWith(ctx, w, http.StatusNotImplemented, errors.New("origins are not tracked by this collector"))
+			return
+		}
+		// Rendered nodes may be of no node in the report, such as those
+		// of processes by name, so their children are looked up too
+		nodeIDs := []string{nodeID}
+		node.Children.ForEach(func(child report.Node) {
+			nodeIDs = append(nodeIDs, child.ID)
+		})
+		var err error
+		apiNode.Origins, err = origins.Origins(ctx, deserializeTimestamp(r.Form.Get("timestamp")), nodeIDs)
+		if err != nil {
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	respondWith(ctx, w, http.StatusOK, apiNode)
 }
 
 // Websocket for the full topology.
@@ -103,12 +344,21 @@ func handleWebsocket(
 		}
 	}
 
+	// The query is applied to each report, so the diffs sent are of the
+	// nodes matching it; one which doesn't parse fails now, rather than
+	// after the upgrade
+	if _, err := queryFilters(r.Form); err != nil {
+		respondWith(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+
 	conn, err := xfer.Upgrade(w, r, nil)
 	if err != nil {
 		// log.Info("Upgrade:", err)
 		return
 	}
 	defer conn.Close()
+	defer trackWebsocket(topologyWebsocket)()
 
 	quit := make(chan struct{})
 	go func(c xfer.Websocket) {
@@ -130,8 +380,11 @@ func handleWebsocket(
 		topologyID:       mux.Vars(r)["topology"],
 		startReportingAt: deserializeTimestamp(r.Form.Get("timestamp")),
 		censorCfg:        report.GetCensorConfigFromRequest(r),
+		fields:           fieldsFromRequest(r.Form),
 		channelOpenedAt:  time.Now(),
+		sender:           newDiffSender(conn, topologyWebsocket, WebsocketSendDeadline),
 	}
+	defer wc.sender.Stop()
 	adjacencyStr := r.Form.Get("adjacency")
 	if adjacencyStr == "false" {
 		wc.adjacency = false
@@ -146,7 +399,7 @@ func handleWebsocket(
 	tick := time.Tick(loop)
 	for {
 		if err := wc.update(ctx); err != nil {
-			log.Errorf("%v", err)
+			log.WithField("topology", wc.topologyID).Errorf("%v", err)
 			return
 		}
 
@@ -163,11 +416,12 @@ type websocketState struct {
 	rep              Reporter
 	values           url.Values
 	conn             xfer.Websocket
-	previousTopo     detailed.NodeSummaries
+	sender           *diffSender
 	topologyID       string
 	startReportingAt time.Time
 	reportTimestamp  time.Time
 	censorCfg        report.CensorConfig
+	fields           []string
 	channelOpenedAt  time.Time
 	adjacency        bool
 }
@@ -198,21 +452,51 @@ func (wc *websocketState) update(ctx context.Context) error {
 		return errors.Wrap(err, "Error generating report")
 	}
 
-	newTopo := detailed.CensorNodeSummaries(
-		detailed.Summaries(
-			ctx,
-			RenderContextForReporter(wc.rep, re),
-			render.Render(ctx, re, renderer, filter).Nodes,
-			wc.adjacency,
+	// The render guards apply as to the topology, but a topology the
+	// guards stop being sent in full isn't sent at all, as diffs of it
+	// would remove the nodes missing from it
+	tenant, ok, err := tenantRenders.acquire(ctx)
+	if err != nil {
+		return err
+	} else if !ok {
+		renderGuards.WithLabelValues(wc.topologyID, guardConcurrency).Inc()
+		return nil
+	}
+	defer tenantRenders.release(tenant)
+	renderCtx := ctx
+	if RenderTimeout > 0 {
+		renderCtx = render.WithDeadline(ctx, time.Now().Add(RenderTimeout))
+	}
+
+	start := time.Now()
+	rendered := render.Render(renderCtx, re, renderer, filter)
+	observeRender(wc.topologyID, start)
+	if RenderMaxNodes > 0 && len(rendered.Nodes) > RenderMaxNodes {
+		renderGuards.WithLabelValues(wc.topologyID, guardMaxNodes).Inc()
+		return nil
+	}
+	summaries := detailed.Summaries(
+		renderCtx,
+		RenderContextForReporter(wc.rep, re),
+		rendered.Nodes,
+		wc.adjacency,
+	)
+	if render.CutShort(renderCtx) {
+		renderGuards.WithLabelValues(wc.topologyID, guardDeadline).Inc()
+		return nil
+	}
+	newTopo := detailed.SelectFields(
+		detailed.CensorNodeSummaries(
+			summaries,
+			wc.censorCfg,
 		),
-		wc.censorCfg,
+		wc.fields,
 	)
-	diff := detailed.TopoDiff(wc.previousTopo, newTopo)
-	wc.previousTopo = newTopo
 
-	if err := wc.conn.WriteJSON(diff); err != nil {
+	// The diff sent is from the topology the client last received
+	if err := wc.sender.Send(newTopo); err != nil {
 		if !xfer.IsExpectedWSCloseError(err) {
-			return errors.Wrap(err, "cannot serialize topology diff")
+			return errors.Wrap(err, "cannot send topology diff")
 		}
 	}
 	return nil
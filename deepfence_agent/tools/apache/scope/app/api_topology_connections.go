@@ -6,7 +6,6 @@ import (
 	ot "github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/detailed"
@@ -19,13 +18,7 @@ import (
 )
 
 const (
-	connectionWebsocketLoop      = 5 * time.Second
-	vulnerabilityScanStatusKey   = "vulnerability_scan_status"
-	vulnerabilityScanStatusLabel = "Vulnerability Scan Status"
-	complianceScanStatusKey      = "compliance_scan_status"
-	complianceScanStatusLabel    = "Compliance Scan Status"
-	nodeSeverityKey              = "node_severity"
-	nodeSeverityLabel            = "Node Severity"
+	connectionWebsocketLoop = 5 * time.Second
 )
 
 var (
@@ -79,6 +72,7 @@ func handleConnectionsWebsocket(
 		return
 	}
 	defer conn.Close()
+	defer trackWebsocket(connectionsWebsocket)()
 
 	ignoreCollapsed, ignoreConnections, ignoreMetadata, ignoreMetrics := false, false, false, false
 	if r.Form.Get("ignore_collapsed") == "true" {
@@ -262,9 +256,10 @@ func (wc *connectionWebsocketState) update(ctx context.Context) error {
 		}
 	}
 
-	var nodeIdVulnerabilityStatusMap, nodeIdComplianceStatusMap, nodeSeverityMap map[string]string
+	var nodeIdVulnerabilityStatusMap, nodeIdComplianceStatusMap map[string]report.ScanStatus
+	var nodeSeverityMap map[string]string
 	if ignoreMetadata == false {
-		nodeIdVulnerabilityStatusMap, _, nodeIdComplianceStatusMap, _, nodeSeverityMap = nStatus.getNodeStatus()
+		nodeIdVulnerabilityStatusMap, nodeIdComplianceStatusMap, nodeSeverityMap = nStatus.getNodeStatus()
 	}
 	childrenCount := make(map[string]map[string]int)
 
@@ -298,40 +293,35 @@ func (wc *connectionWebsocketState) update(ctx context.Context) error {
 				),
 				wc.censorCfg,
 			)
-			var vulnerabilityScanStatus, complianceScanStatus, nodeSeverity string
+			var vulnerabilityScanStatus, complianceScanStatus report.ScanStatus
 			var ok bool
 			counter := 0
 			for k, v := range nodeSummaries {
 				if adjacency == false && v.Pseudo == true {
 					continue
 				}
-				if ignoreMetadata == false {
-					vulnerabilityScanStatus = ""
-					complianceScanStatus = ""
-					nodeSeverity = ""
-					if c.TopologyID == hostsID && v.Pseudo == false {
-						vulnerabilityScanStatus, ok = nodeIdVulnerabilityStatusMap[v.Label]
-						if !ok {
-							vulnerabilityScanStatus = scanStatusNeverScanned
+				if ignoreMetadata == false && (c.TopologyID == hostsID || c.TopologyID == containersID || c.TopologyID == containersByImageID) {
+					statuses := report.ScanStatuses{}
+					if v.Pseudo == false {
+						// Hosts are scanned by name, containers by image
+						scanned := v.Image
+						if c.TopologyID == hostsID {
+							scanned = v.Label
 						}
-						nodeSeverity, _ = nodeSeverityMap[v.Label]
-					} else if (c.TopologyID == containersID || c.TopologyID == containersByImageID) && v.Pseudo == false {
-						vulnerabilityScanStatus, ok = nodeIdVulnerabilityStatusMap[v.Image]
+						vulnerabilityScanStatus, ok = nodeIdVulnerabilityStatusMap[scanned]
 						if !ok {
-							vulnerabilityScanStatus = scanStatusNeverScanned
+							vulnerabilityScanStatus = report.ScanStatus{Type: report.VulnerabilityScan, Status: report.ScanStatusNeverScanned}
 						}
-					}
-					if c.TopologyID == hostsID || c.TopologyID == containersID || c.TopologyID == containersByImageID {
-						complianceScanStatus, ok = nodeIdComplianceStatusMap[v.ID]
-						if !ok {
-							complianceScanStatus = scanStatusNeverScanned
+						if c.TopologyID == hostsID {
+							vulnerabilityScanStatus.Severity = nodeSeverityMap[v.Label]
 						}
-						v.Metadata = append(v.Metadata, []report.MetadataRow{
-							{ID: vulnerabilityScanStatusKey, Label: vulnerabilityScanStatusLabel, Value: vulnerabilityScanStatus, Priority: 50.0},
-							{ID: complianceScanStatusKey, Label: complianceScanStatusLabel, Value: complianceScanStatus, Priority: 51.0},
-							{ID: nodeSeverityKey, Label: nodeSeverityLabel, Value: nodeSeverity, Priority: 52.0},
-						}...)
+						statuses = statuses.Add(vulnerabilityScanStatus)
+					}
+					complianceScanStatus, ok = nodeIdComplianceStatusMap[v.ID]
+					if !ok {
+						complianceScanStatus = report.ScanStatus{Type: report.ComplianceScan, Status: report.ScanStatusNeverScanned}
 					}
+					v.ScanStatuses = v.ScanStatuses.Merge(statuses.Add(complianceScanStatus))
 				}
 				v.ImmediateParentID = nodeFilter.NodeId
 				newTopo[k] = v
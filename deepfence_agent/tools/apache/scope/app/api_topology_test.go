@@ -1,16 +1,27 @@
 package app_test
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/common/mtime"
 
 	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/probe/process"
+	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/detailed"
 	"github.com/weaveworks/scope/render/expected"
+	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test/fixture"
 )
 
@@ -111,13 +122,377 @@ func TestAPITopologyHosts(t *testing.T) {
 			t.Fatal(err)
 		}
 		equals(t, fixture.ServerHostNodeID, node.Node.ID)
-		equals(t, "server", node.Node.Label)
+		equals(t, "server.hostname.com", node.Node.Label)
 		equals(t, false, node.Node.Pseudo)
 		// Let's not unit-test the specific content of the detail tables
 	}
 }
 
+func TestAPITopologyQuery(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+
+	body := getRawJSON(t, ts, "/topology-api/topology/containers?q="+url.QueryEscape("name:"+fixture.ClientContainerName))
+	var topo app.APITopology
+	decoder := codec.NewDecoderBytes(body, &codec.JsonHandle{})
+	if err := decoder.Decode(&topo); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := topo.Nodes[fixture.ClientContainerNodeID]; !ok || len(topo.Nodes) != 1 {
+		t.Errorf("Expected only the client container, got %v", topo.Nodes)
+	}
+
+	is400(t, ts, "/topology-api/topology/containers?q="+url.QueryEscape(`name:"client`))
+	is400(t, ts, "/topology-api/topology/containers/ws?q="+url.QueryEscape("name:"))
+}
+
+func TestAPITopologyPages(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+
+	getTopology := func(query string) app.APITopology {
+		body := getRawJSON(t, ts, "/topology-api/topology/processes?"+query)
+		var topo app.APITopology
+		decoder := codec.NewDecoderBytes(body, &codec.JsonHandle{})
+		if err := decoder.Decode(&topo); err != nil {
+			t.Fatal(err)
+		}
+		return topo
+	}
+
+	all := getTopology("")
+	if all.NodeCount != len(all.Nodes) || all.NextOffset != 0 {
+		t.Fatalf("Expected all nodes, got %d of %d, next %d", len(all.Nodes), all.NodeCount, all.NextOffset)
+	}
+
+	// Pages have every node once, whatever their size
+	seen := map[string]int{}
+	offset := 0
+	for {
+		page := getTopology(fmt.Sprintf("limit=2&offset=%d", offset))
+		if page.NodeCount != all.NodeCount || page.FilteredNodes != all.FilteredNodes {
+			t.Errorf("Expected the counts of all nodes, got %d and %d", page.NodeCount, page.FilteredNodes)
+		}
+		if len(page.Nodes) > 2 {
+			t.Errorf("Expected at most 2 nodes, got %d", len(page.Nodes))
+		}
+		for id := range page.Nodes {
+			seen[id]++
+		}
+		if page.NextOffset == 0 {
+			break
+		}
+		offset = page.NextOffset
+	}
+	for id := range all.Nodes {
+		if seen[id] != 1 {
+			t.Errorf("Expected node %s once, got it %d times", id, seen[id])
+		}
+	}
+
+	// Only the fields asked for
+	for _, node := range getTopology("fields=" + url.QueryEscape("pid,no_such_field")).Nodes {
+		for _, row := range node.Metadata {
+			if row.ID != "pid" {
+				t.Errorf("Expected only pid, got %s in %s", row.ID, node.ID)
+			}
+		}
+		if len(node.Metrics) != 0 || len(node.Tables) != 0 {
+			t.Errorf("Expected no metrics or tables, got %v and %v", node.Metrics, node.Tables)
+		}
+	}
+
+	is400(t, ts, "/topology-api/topology/processes?limit=-1")
+	is400(t, ts, "/topology-api/topology/processes?offset=x")
+}
+
 // Basic websocket test
+func TestAPITopologyHistory(t *testing.T) {
+	// The fixture, then an empty report a minute later
+	now := fixture.Now.Truncate(time.Second)
+	defer mtime.NowReset()
+	ctx := context.Background()
+	c := app.NewCollector(time.Hour)
+	mtime.NowForce(now)
+	c.Add(ctx, fixture.Report, nil)
+	mtime.NowForce(now.Add(time.Minute))
+	c.Add(ctx, report.MakeReport(), nil)
+
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.WebReporter{Reporter: c}, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	pathAt := func(at time.Time, query string) string {
+		return "/topology-api/topology/containers?window=15s&timestamp=" + url.QueryEscape(at.Format(time.RFC3339)) + query
+	}
+	getContainers := func(at time.Time, query string) app.APITopology {
+		body := getRawJSON(t, ts, pathAt(at, query))
+		var topo app.APITopology
+		decoder := codec.NewDecoderBytes(body, &codec.JsonHandle{})
+		if err := decoder.Decode(&topo); err != nil {
+			t.Fatal(err)
+		}
+		return topo
+	}
+
+	if topo := getContainers(now.Add(10*time.Second), ""); len(topo.Nodes) < 2 {
+		t.Errorf("Expected the fixture's containers, got %v", topo.Nodes)
+	}
+	// Filters apply as they do to the live topology
+	topo := getContainers(now.Add(10*time.Second), "&q="+url.QueryEscape("name:"+fixture.ClientContainerName))
+	if _, ok := topo.Nodes[fixture.ClientContainerNodeID]; !ok || len(topo.Nodes) != 1 {
+		t.Errorf("Expected only the client container, got %v", topo.Nodes)
+	}
+	if topo := getContainers(now.Add(70*time.Second), ""); len(topo.Nodes) != 0 {
+		t.Errorf("Expected no containers after the fixture, got %v", topo.Nodes)
+	}
+
+	is404(t, ts, pathAt(now.Add(40*time.Second), ""))
+	is400(t, ts, "/topology-api/topology/containers?window=soon")
+	is400(t, ts, "/topology-api/topology/containers?window=15s&timestamp=yesterday")
+	is400(t, ts, "/topology-api/topology/containers?window=2h")
+
+	var ranges []app.TimeRange
+	body := getRawJSON(t, ts, "/topology-api/report/ranges?from="+url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)))
+	if err := codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&ranges); err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || !ranges[0].Start.Equal(now) || !ranges[0].End.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected one range over both reports, got %v", ranges)
+	}
+	is400(t, ts, "/topology-api/report/ranges?to=tomorrow")
+}
+
+func TestAPITopologyNodeOrigins(t *testing.T) {
+	now := fixture.Now.Truncate(time.Second)
+	defer mtime.NowReset()
+	fromProbe := func(probeID string) context.Context {
+		req := httptest.NewRequest("POST", "/topology-api/report", nil)
+		req.Header.Set(xfer.ScopeProbeIDHeader, probeID)
+		return context.WithValue(context.Background(), app.RequestCtxKey, req)
+	}
+
+	// probe2 reports a newer hostname of the client container, but an
+	// older name, before probe1 reports the fixture
+	c := app.NewCollector(time.Minute)
+	mtime.NowForce(now)
+	rpt := report.MakeReport()
+	rpt.Container.AddNode(report.MakeNode(fixture.ClientContainerNodeID).
+		WithLatest(docker.ContainerHostname, fixture.Now.Add(time.Second), "renamed").
+		WithLatest(docker.ContainerName, fixture.Now.Add(-time.Minute), "old-name"))
+	c.Add(fromProbe("probe2"), rpt, nil)
+	mtime.NowForce(now.Add(15 * time.Second))
+	c.Add(fromProbe("probe1"), fixture.Report, nil)
+
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.WebReporter{Reporter: c}, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	getNode := func(topologyID, nodeID, query string) app.APINode {
+		body := getRawJSON(t, ts, "/topology-api/topology/"+topologyID+"/"+url.QueryEscape(nodeID)+query)
+		var node app.APINode
+		if err := codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&node); err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+	if node := getNode("containers", fixture.ClientContainerNodeID, ""); node.Origins != nil {
+		t.Errorf("Expected no origins unless asked for, got %v", node.Origins)
+	}
+	at := func(t time.Time) string {
+		return "?origins=true&timestamp=" + url.QueryEscape(t.Format(time.RFC3339))
+	}
+	origins := getNode("containers", fixture.ClientContainerNodeID, at(now.Add(20*time.Second))).Origins[fixture.ClientContainerNodeID]
+	if origin := origins[docker.ContainerHostname]; origin.ProbeID != "probe2" || !origin.ReportTimestamp.Equal(now) {
+		t.Errorf("Expected the hostname from probe2's report, got %v", origin)
+	}
+	if origin := origins[docker.ContainerName]; origin.ProbeID != "probe1" || !origin.ReportTimestamp.Equal(now.Add(15*time.Second)) {
+		t.Errorf("Expected the name from probe1's report, got %v", origin)
+	}
+
+	// Reports received before the window are not origins
+	later, err := c.(app.OriginReporter).Origins(context.Background(), now.Add(70*time.Second), []string{fixture.ClientContainerNodeID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin := later[fixture.ClientContainerNodeID][docker.ContainerHostname]; origin.ProbeID != "probe1" {
+		t.Errorf("Expected the hostname from probe1's report, got %v", origin)
+	}
+
+	// Nodes which are of none in the report have the origins of their children
+	node := getNode("processes-by-name", fixture.ServerName, at(now.Add(20*time.Second)))
+	if origin := node.Origins[fixture.ServerProcessNodeID][process.Name]; origin.ProbeID != "probe1" {
+		t.Errorf("Expected the name of the process from probe1's report, got %v", node.Origins)
+	}
+
+	// Not all reporters know where reports came from
+	static := topologyServer()
+	defer static.Close()
+	res, _ := checkGet(t, static, "/topology-api/topology/containers/"+url.QueryEscape(fixture.ClientContainerNodeID)+"?origins=true")
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected %d, got %d", http.StatusNotImplemented, res.StatusCode)
+	}
+}
+
+func TestAPITopologyReachability(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+	path := "/topology-api/topology/containers/" + url.QueryEscape(fixture.ClientContainerNodeID) + "/reachability"
+
+	body := getRawJSON(t, ts, path+"?hops=2")
+	var reachability app.APIReachability
+	ok(t, codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&reachability))
+	equals(t, 0, reachability.Hops[fixture.ClientContainerNodeID])
+	equals(t, 1, reachability.Hops[fixture.ServerContainerNodeID])
+	for id := range reachability.Hops {
+		if _, ok := reachability.Nodes[id]; !ok {
+			t.Errorf("Expected a summary of %s", id)
+		}
+	}
+
+	is400(t, ts, path+"?hops=0")
+	is400(t, ts, path+"?hops=x")
+	is404(t, ts, "/topology-api/topology/containers/"+url.QueryEscape("missing;<container>")+"/reachability")
+}
+
+func TestAPITopologySeverityGroups(t *testing.T) {
+	rpt := giantReport(1, 4)
+	scans := map[string]map[string]int{
+		"container0-0": {"critical": 2, "low": 1},
+		"container0-1": {"critical": 1},
+		"container0-3": {"high": 5},
+	}
+	for id, counts := range scans {
+		nodeID := report.MakeContainerNodeID(id)
+		rpt.Container.Nodes[nodeID] = rpt.Container.Nodes[nodeID].WithScanStatus(report.ScanStatus{
+			Type:     report.VulnerabilityScan,
+			Status:   report.ScanStatusComplete,
+			Counts:   counts,
+			LastScan: time.Now(),
+		})
+	}
+	// Stopped containers are hidden, and aren't counted
+	stopped := report.MakeContainerNodeID("container0-1")
+	rpt.Container.Nodes[stopped] = rpt.Container.Nodes[stopped].WithLatests(map[string]string{
+		docker.ContainerState:      report.StateExited,
+		docker.ContainerStateHuman: report.StateExited,
+	})
+
+	router := mux.NewRouter().SkipClean(true)
+	app.RegisterTopologyRoutes(router, app.StaticCollector(rpt), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	_, topo := getTopology(t, ts, "/topology-api/topology/containers?group_by=severity")
+	labels := map[string]string{}
+	for id, n := range topo.Nodes {
+		labels[id] = n.Label
+		if !n.Pseudo {
+			t.Errorf("Expected only groups, have %s", id)
+		}
+	}
+	equals(t, map[string]string{
+		render.SeverityIDPrefix + render.SeverityCritical:  "Critical (1)",
+		render.SeverityIDPrefix + render.SeverityHigh:      "High (1)",
+		render.SeverityIDPrefix + render.SeverityUnscanned: "Unscanned (1)",
+	}, labels)
+
+	_, topo = getTopology(t, ts, "/topology-api/topology/containers?group_by=severity&stopped=both")
+	equals(t, "Critical (2)", topo.Nodes[render.SeverityIDPrefix+render.SeverityCritical].Label)
+
+	_, topo = getTopology(t, ts, "/topology-api/topology/containers?group_by=none")
+	equals(t, 3, len(topo.Nodes))
+}
+
+func TestAPITopologyNamespaceScope(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+
+	_, topo := getTopology(t, ts, "/topology-api/topology/pods?namespace="+fixture.KubernetesNamespace)
+	if _, ok := topo.Nodes[fixture.ClientPodNodeID]; !ok {
+		t.Errorf("Expected the pods of %s, have %v", fixture.KubernetesNamespace, topo.Nodes)
+	}
+	_, topo = getTopology(t, ts, "/topology-api/topology/pods?namespace=payments")
+	equals(t, 0, len(topo.Nodes))
+	_, topo = getTopology(t, ts, "/topology-api/topology/containers?cluster=staging")
+	equals(t, 0, len(topo.Nodes))
+}
+
+func TestAPITopologyEdges(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+
+	_, topo := getTopology(t, ts, "/topology-api/topology/pods")
+	equals(t, 0, len(topo.Edges))
+
+	_, topo = getTopology(t, ts, "/topology-api/topology/pods?edges=true")
+	var edge *detailed.EdgeSummary
+	for i, e := range topo.Edges {
+		if e.Source == fixture.ClientPodNodeID && e.Target == fixture.ServerPodNodeID {
+			edge = &topo.Edges[i]
+		}
+	}
+	if edge == nil {
+		t.Fatalf("Expected an edge from the client pod to the server pod, have %v", topo.Edges)
+	}
+	equals(t, []string{fixture.ServerPort}, edge.Ports)
+}
+
+func TestAPITopologyEdgeFilter(t *testing.T) {
+	ts := topologyServer()
+	defer ts.Close()
+
+	_, topo := getTopology(t, ts, "/topology-api/topology/pods?port="+fixture.ServerPort+"&protocol=TCP")
+	if !topo.Nodes[fixture.ClientPodNodeID].Adjacency.Contains(fixture.ServerPodNodeID) {
+		t.Errorf("Expected the client pod connected to the server pod, have %v", topo.Nodes)
+	}
+
+	// Pods left without edges are dropped, unless kept
+	_, topo = getTopology(t, ts, "/topology-api/topology/pods?protocol=udp")
+	if _, ok := topo.Nodes[fixture.ClientPodNodeID]; ok {
+		t.Errorf("Expected the client pod dropped, have %v", topo.Nodes)
+	}
+	_, topo = getTopology(t, ts, "/topology-api/topology/pods?protocol=udp&keep-isolated=true")
+	if n, ok := topo.Nodes[fixture.ClientPodNodeID]; !ok || len(n.Adjacency) != 0 {
+		t.Errorf("Expected the client pod without edges, have %v", topo.Nodes)
+	}
+
+	for _, query := range []string{"port=abc", "port=0", "port=80,70000", "protocol=sctp"} {
+		res, _ := checkGet(t, ts, "/topology-api/topology/pods?"+query)
+		equals(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestAPITopologyLongTail(t *testing.T) {
+	router := mux.NewRouter().SkipClean(true)
+	app.RegisterTopologyRoutes(router, app.StaticCollector(giantReport(2, 30)), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	_, topo := getTopology(t, ts, "/topology-api/topology/containers?top=10")
+	equals(t, 2*(10+1), len(topo.Nodes))
+	host0 := report.MakeHostNodeID("host0")
+	others, ok := topo.Nodes[render.MakeOthersNodeID(report.Container, host0)]
+	if !ok {
+		t.Fatalf("Expected the others of host0, have %v", topo.Nodes)
+	}
+	equals(t, "20 others", others.Label)
+	equals(t, "host0", others.LabelMinor)
+
+	// Expanded, all the containers of the host are listed
+	_, topo = getTopology(t, ts, "/topology-api/topology/containers?top=10&rank_by=connections&expand="+url.QueryEscape(host0))
+	equals(t, 30+10+1, len(topo.Nodes))
+	_, topo = getTopology(t, ts, "/topology-api/topology/containers?top=all")
+	equals(t, 2*30, len(topo.Nodes))
+
+	for _, query := range []string{"top=abc", "top=0", "rank_by=disk"} {
+		res, _ := checkGet(t, ts, "/topology-api/topology/containers?"+query)
+		equals(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
+
 func TestAPITopologyWebsocket(t *testing.T) {
 	ts := topologyServer()
 	defer ts.Close()
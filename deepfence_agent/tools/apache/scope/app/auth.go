@@ -0,0 +1,212 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Scopes of API tokens.  Tokens with the admin scope have all the others.
+const (
+	ScopeReadTopology  = "read-topology"
+	ScopeWriteControls = "write-controls"
+	ScopeAdmin         = "admin"
+
+	// scopeProbe is required of the routes probes use, which take the
+	// tokens of probes rather than API tokens
+	scopeProbe = "probe"
+
+	// ProbeKeyHeader carries the token of probes which don't send it as a
+	// bearer token.
+	ProbeKeyHeader = "deepfence-key"
+)
+
+var validScopes = map[string]bool{
+	ScopeReadTopology:  true,
+	ScopeWriteControls: true,
+	ScopeAdmin:         true,
+}
+
+// TokenAuth is middleware authorizing requests by bearer token, with the
+// scope each route requires.  API tokens are read from a file of lines of a
+// token and its scopes, separated by commas:
+//
+//	# comment
+//	3f2c9a... read-topology,write-controls
+//
+// and the tokens of probes from a file of one token per line.  Files are
+// read again by Reload.
+type TokenAuth struct {
+	tokensFile      string
+	probeTokensFile string
+
+	mtx         sync.RWMutex
+	tokens      map[string]map[string]bool
+	probeTokens map[string]bool
+}
+
+// NewTokenAuth creates TokenAuth from the files of API and probe tokens.
+// Without a file of probe tokens, probes aren't authorized.
+func NewTokenAuth(tokensFile, probeTokensFile string) (*TokenAuth, error) {
+	a := &TokenAuth{
+		tokensFile:      tokensFile,
+		probeTokensFile: probeTokensFile,
+	}
+	return a, a.Reload()
+}
+
+// Reload reads the files of tokens again.  The tokens already read are kept
+// if either can't be read.
+func (a *TokenAuth) Reload() error {
+	tokens := map[string]map[string]bool{}
+	err := readTokenFile(a.tokensFile, func(token string, scopes []string) error {
+		if len(scopes) == 0 {
+			return fmt.Errorf("token without scopes")
+		}
+		tokens[token] = map[string]bool{}
+		for _, scope := range scopes {
+			if !validScopes[scope] {
+				return fmt.Errorf("unknown scope %q", scope)
+			}
+			tokens[token][scope] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	probeTokens := map[string]bool{}
+	if a.probeTokensFile != "" {
+		err := readTokenFile(a.probeTokensFile, func(token string, scopes []string) error {
+			if len(scopes) > 0 {
+				return fmt.Errorf("probe token with scopes")
+			}
+			probeTokens[token] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.tokens, a.probeTokens = tokens, probeTokens
+	return nil
+}
+
+func readTokenFile(filename string, f func(token string, scopes []string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var scopes []string
+		switch len(fields) {
+		case 1:
+		case 2:
+			scopes = strings.Split(fields[1], ",")
+		default:
+			return fmt.Errorf("%s:%d: expected a token and its scopes", filename, line)
+		}
+		if err := f(fields[0], scopes); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Wrap implements middleware.Interface.  Requests without a known token are
+// refused with 401, and those whose token lacks the scope of the route with
+// 403.
+func (a *TokenAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, probes := routeScope(r)
+		if scope == "" && !probes {
+			next.ServeHTTP(w, r)
+			return
+		}
+		known, allowed := a.authorize(r, scope, probes)
+		switch {
+		case !known:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scope"`)
+			http.Error(w, "Unauthorized: missing or unknown token", http.StatusUnauthorized)
+		case !allowed && scope == "":
+			http.Error(w, "Forbidden: only probes may use this route", http.StatusForbidden)
+		case !allowed:
+			http.Error(w, fmt.Sprintf("Forbidden: token lacks scope %q", scope), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authorize reports whether the token of a request is known, and if so
+// whether it is allowed a route requiring scope, and taking the tokens of
+// probes if probes is set.
+func (a *TokenAuth) authorize(r *http.Request, scope string, probes bool) (known, allowed bool) {
+	token := bearerToken(r)
+	if token == "" && probes {
+		token = r.Header.Get(ProbeKeyHeader)
+	}
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	if probes && a.probeTokens[token] {
+		return true, true
+	}
+	scopes, known := a.tokens[token]
+	if scope == "" {
+		// Only probes may use the route
+		return known, false
+	}
+	return known, scopes[scope] || scopes[ScopeAdmin]
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return header[len(prefix):]
+	}
+	return ""
+}
+
+// routeScope returns the scope of API tokens a request requires, if any,
+// and whether the tokens of probes are taken instead.  The UI's static files
+// are served to anyone.
+func routeScope(r *http.Request) (scope string, probes bool) {
+	path := r.URL.Path
+	switch {
+	// Probes shake hands, publish reports, take controls, and open pipes...
+	case path == "/topology-api/handshake" && r.Method == "POST",
+		path == "/topology-api/report" && r.Method == "POST",
+		path == "/topology-api/control/ws",
+		strings.HasPrefix(path, "/topology-api/pipe/") && strings.HasSuffix(path, "/probe"):
+		return "", true
+	// ...and close them, and ask for the details of the app
+	case strings.HasPrefix(path, "/topology-api/pipe/") && r.Method == "DELETE":
+		return ScopeWriteControls, true
+	case path == "/topology-api":
+		return ScopeReadTopology, true
+	// Controls, and the pipes of the terminals and logs they open
+	case strings.HasPrefix(path, "/topology-api/control/"),
+		strings.HasPrefix(path, "/topology-api/pipe/"):
+		return ScopeWriteControls, false
+	case strings.HasPrefix(path, "/admin/"), path == "/metrics",
+		strings.HasPrefix(path, "/topology-api/probes/") && r.Method == "POST",
+		strings.HasPrefix(path, "/topology-api/scans/") && r.Method == "POST":
+		return ScopeAdmin, false
+	case strings.HasPrefix(path, "/topology-api/"):
+		return ScopeReadTopology, false
+	}
+	return "", false
+}
@@ -0,0 +1,114 @@
+package app_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/scope/app"
+)
+
+func writeTokens(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestTokenAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-auth")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	tokens := writeTokens(t, dir, "tokens", `
+# viewers
+viewer read-topology
+operator read-topology,write-controls
+root admin
+`)
+	probeTokens := writeTokens(t, dir, "probe-tokens", "probe\n")
+
+	auth, err := app.NewTokenAuth(tokens, probeTokens)
+	ok(t, err)
+	handler := auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	check := func(method, path string, header http.Header, want int) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		for k, vs := range header {
+			req.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s %s %v: expected %d, got %d", method, path, header, want, w.Code)
+		}
+		if want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: expected WWW-Authenticate", method, path)
+		}
+	}
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+
+	for _, tc := range []struct {
+		method, path string
+		header       http.Header
+		want         int
+	}{
+		// The UI's static files are served to anyone
+		{"GET", "/", nil, http.StatusOK},
+		{"GET", "/ui/index.html", nil, http.StatusOK},
+
+		{"GET", "/topology-api/topology/hosts", nil, http.StatusUnauthorized},
+		{"GET", "/topology-api/topology/hosts", bearer("unknown"), http.StatusUnauthorized},
+		{"GET", "/topology-api/topology/hosts", bearer("viewer"), http.StatusOK},
+		{"GET", "/topology-api/topology/hosts", bearer("root"), http.StatusOK},
+		{"GET", "/topology-api/topology/hosts", bearer("probe"), http.StatusUnauthorized},
+
+		{"POST", "/topology-api/control/probe1/node1/docker_exec_container", bearer("viewer"), http.StatusForbidden},
+		{"POST", "/topology-api/control/probe1/node1/docker_exec_container", bearer("operator"), http.StatusOK},
+		{"GET", "/topology-api/pipe/pipe1", bearer("viewer"), http.StatusForbidden},
+		{"GET", "/topology-api/pipe/pipe1", bearer("operator"), http.StatusOK},
+
+		{"GET", "/admin/summary", bearer("operator"), http.StatusForbidden},
+		{"GET", "/admin/summary", bearer("root"), http.StatusOK},
+		{"GET", "/metrics", bearer("viewer"), http.StatusForbidden},
+		{"POST", "/topology-api/probes/probe1/report", bearer("operator"), http.StatusForbidden},
+		{"POST", "/topology-api/probes/probe1/report", bearer("root"), http.StatusOK},
+		{"POST", "/topology-api/scans/secrets", bearer("operator"), http.StatusForbidden},
+		{"POST", "/topology-api/scans/secrets", bearer("root"), http.StatusOK},
+		{"POST", "/topology-api/scans/compliance", bearer("operator"), http.StatusForbidden},
+		{"POST", "/topology-api/scans/compliance", bearer("root"), http.StatusOK},
+
+		// Probes use their own tokens, as bearer tokens or not
+		{"POST", "/topology-api/report", bearer("probe"), http.StatusOK},
+		{"POST", "/topology-api/report", http.Header{"Deepfence-Key": {"probe"}}, http.StatusOK},
+		{"POST", "/topology-api/report", bearer("root"), http.StatusForbidden},
+		{"POST", "/topology-api/report", nil, http.StatusUnauthorized},
+		{"POST", "/topology-api/handshake", bearer("probe"), http.StatusOK},
+		{"POST", "/topology-api/handshake", bearer("root"), http.StatusForbidden},
+		{"GET", "/topology-api/control/ws", bearer("probe"), http.StatusOK},
+		{"GET", "/topology-api/pipe/pipe1/probe", bearer("probe"), http.StatusOK},
+		{"DELETE", "/topology-api/pipe/pipe1", bearer("probe"), http.StatusOK},
+		{"DELETE", "/topology-api/pipe/pipe1", bearer("operator"), http.StatusOK},
+		{"GET", "/topology-api", bearer("probe"), http.StatusOK},
+		{"GET", "/topology-api", bearer("viewer"), http.StatusOK},
+	} {
+		check(tc.method, tc.path, tc.header, tc.want)
+	}
+
+	// Tokens are read again on reload, unless the files are invalid
+	writeTokens(t, dir, "tokens", "viewer2 read-topology\n")
+	ok(t, auth.Reload())
+	check("GET", "/topology-api/topology/hosts", bearer("viewer"), http.StatusUnauthorized)
+	check("GET", "/topology-api/topology/hosts", bearer("viewer2"), http.StatusOK)
+
+	writeTokens(t, dir, "tokens", "viewer3 no-such-scope\n")
+	if err := auth.Reload(); err == nil {
+		t.Error("Expected an error for an unknown scope")
+	}
+	check("GET", "/topology-api/topology/hosts", bearer("viewer2"), http.StatusOK)
+}
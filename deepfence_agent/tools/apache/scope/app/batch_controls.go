@@ -0,0 +1,338 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+// BatchControlParallelism and BatchControlTimeout - set at runtime, how
+// many nodes of a batch its control may be invoked on at once, and how long
+// a batch may take unless it gives its own ?timeout=.
+var (
+	BatchControlParallelism = 10
+	BatchControlTimeout     = time.Minute
+)
+
+// Statuses of the nodes of a batch.  Those timed out, skipped and rate
+// limited are retried by a batch of the same ID; the others are final.
+const (
+	BatchControlOK          = "ok"
+	BatchControlError       = "error"
+	BatchControlTimedOut    = "timeout"
+	BatchControlSkipped     = "skipped"
+	BatchControlRateLimited = "rate_limited"
+)
+
+// Batches are remembered for retries for a while, keyed by tenant and ID.
+const (
+	batchControlMemory     = 1000
+	batchControlExpiration = time.Hour
+)
+
+// BatchControlRequest is the body of a batch control request: a control,
+// its arguments, and the nodes to invoke it on, by ID or by a query as
+// taken by render.ParseQuery, of the nodes with the control.
+type BatchControlRequest struct {
+	ID          string            `json:"id,omitempty"`
+	Control     string            `json:"control"`
+	ControlArgs map[string]string `json:"controlArgs,omitempty"`
+	NodeIDs     []string          `json:"nodeIds,omitempty"`
+	Query       string            `json:"query,omitempty"`
+	Parallelism int               `json:"parallelism,omitempty"`
+}
+
+// BatchControlResponse is the result of a batch, by node, and the nodes to
+// retry by posting the batch again with its ID.
+type BatchControlResponse struct {
+	ID      string                        `json:"id"`
+	Results map[string]BatchControlResult `json:"results"`
+	Retry   []string                      `json:"retry,omitempty"`
+}
+
+// BatchControlResult is the result of the control of a batch on a node.
+type BatchControlResult struct {
+	ProbeID      string          `json:"probeId,omitempty"`
+	Status       string          `json:"status"`
+	Error        string          `json:"error,omitempty"`
+	Value        interface{}     `json:"value,omitempty"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	ResultSchema string          `json:"resultSchema,omitempty"`
+}
+
+func (r BatchControlResult) retryable() bool {
+	switch r.Status {
+	case BatchControlTimedOut, BatchControlSkipped, BatchControlRateLimited:
+		return true
+	}
+	return false
+}
+
+// batchControls remembers the batches run, and those running, so that
+// retries only invoke the control on the nodes it didn't get to.
+type batchControls struct {
+	mtx     sync.Mutex
+	done    gcache.Cache
+	running map[string]bool
+}
+
+// batchControlsRun is what's remembered of a batch.
+type batchControlsRun struct {
+	control string
+	results map[string]BatchControlResult
+}
+
+var batches = &batchControls{
+	done:    gcache.New(batchControlMemory).LRU().Expiration(batchControlExpiration).Build(),
+	running: map[string]bool{},
+}
+
+// start marks a batch running, returning what's remembered of it, and
+// false if it's running already.
+func (b *batchControls) start(key string) (batchControlsRun, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.running[key] {
+		return batchControlsRun{}, false
+	}
+	b.running[key] = true
+	if run, err := b.done.Get(key); err == nil {
+		return run.(batchControlsRun), true
+	}
+	return batchControlsRun{}, true
+}
+
+func (b *batchControls) finish(key string, run batchControlsRun) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	delete(b.running, key)
+	b.done.Set(key, run)
+}
+
+// batchNode is a node of a batch, and the probe and target of its control.
+type batchNode struct {
+	id      string
+	probeID string
+	target  controlTarget
+}
+
+// handleBatchControl invokes a control on many nodes, found in the latest
+// report by ID or by query, on up to BatchControlParallelism at once, until
+// done or ?timeout= passes, which is BatchControlTimeout by default.  It
+// responds with 200 and the result of each node however many fail:
+// failures are independent, and nodes whose probe isn't connected are
+// skipped.  Posting a batch again with the ID responded invokes the control
+// on the nodes which timed out, were skipped or were rate limited, and not
+// again on the others, whose results are repeated.
+func handleBatchControl(cr ControlRouter, rep Reporter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		var req BatchControlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Control == "" {
+			respondWith(ctx, w, http.StatusBadRequest, "control is required")
+			return
+		}
+		if (len(req.NodeIDs) == 0) == (req.Query == "") {
+			respondWith(ctx, w, http.StatusBadRequest, "either nodeIds or query is required")
+			return
+		}
+		var query render.FilterFunc
+		if req.Query != "" {
+			var err error
+			if query, err = render.ParseQuery(req.Query); err != nil {
+				respondWith(ctx, w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		timeout := BatchControlTimeout
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			var err error
+			if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+				respondWith(ctx, w, http.StatusBadRequest, fmt.Sprintf("invalid timeout %q", t))
+				return
+			}
+		}
+		parallelism := BatchControlParallelism
+		if req.Parallelism > 0 && (req.Parallelism < parallelism || parallelism <= 0) {
+			parallelism = req.Parallelism
+		}
+		if parallelism <= 0 {
+			parallelism = 1
+		}
+		if rep == nil {
+			respondWith(ctx, w, http.StatusNotImplemented, "batch controls need reports")
+			return
+		}
+
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		if req.ID == "" {
+			req.ID = strconv.FormatInt(rand.Int63(), 36)
+		}
+		key := tenant + "/" + req.ID
+		previous, ok := batches.start(key)
+		if !ok {
+			respondWith(ctx, w, http.StatusConflict, fmt.Sprintf("batch %s is running", req.ID))
+			return
+		}
+		run := batchControlsRun{control: req.Control, results: map[string]BatchControlResult{}}
+		defer func() { batches.finish(key, run) }()
+		if previous.control != "" && previous.control != req.Control {
+			run = previous
+			respondWith(ctx, w, http.StatusConflict, fmt.Sprintf("batch %s is of control %s", req.ID, previous.control))
+			return
+		}
+		for id, result := range previous.results {
+			run.results[id] = result
+		}
+
+		rpt, err := rep.Report(ctx, mtime.Now())
+		if err != nil {
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		nodes, missing := batchNodes(rpt, req, query)
+		response := BatchControlResponse{ID: req.ID, Results: map[string]BatchControlResult{}}
+		for _, id := range missing {
+			run.results[id] = BatchControlResult{Status: BatchControlError, Error: fmt.Sprintf("node has no control %s", req.Control)}
+		}
+		var todo []batchNode
+		for _, n := range nodes {
+			if result, ok := previous.results[n.id]; ok && !result.retryable() {
+				continue
+			}
+			todo = append(todo, n)
+		}
+
+		batchCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		results := runBatchControl(batchCtx, cr, controlUser(ctx, r), req, todo, parallelism)
+		for id, result := range results {
+			run.results[id] = result
+		}
+		for _, id := range append(missing, nodeIDs(nodes)...) {
+			result := run.results[id]
+			response.Results[id] = result
+			if result.retryable() {
+				response.Retry = append(response.Retry, id)
+			}
+		}
+		sort.Strings(response.Retry)
+		respondWith(ctx, w, http.StatusOK, response)
+	}
+}
+
+// batchNodes returns the nodes of rpt with the control of a batch, either
+// those given by ID or those matching its query, and the IDs given of
+// nodes without it.
+func batchNodes(rpt report.Report, req BatchControlRequest, query render.FilterFunc) ([]batchNode, []string) {
+	wanted := report.MakeStringSet(req.NodeIDs...)
+	found := map[string]bool{}
+	var nodes []batchNode
+	rpt.WalkTopologies(func(t *report.Topology) {
+		if _, ok := t.Controls[req.Control]; !ok {
+			return
+		}
+		for id, n := range t.Nodes {
+			if found[id] || (query == nil && !wanted.Contains(id)) || (query != nil && !query(n)) {
+				continue
+			}
+			probeID, ok := n.Latest.Lookup(report.ControlProbeID)
+			if !ok || !report.MakeStringSet(n.ActiveControls()...).Contains(req.Control) {
+				continue
+			}
+			found[id] = true
+			nodes = append(nodes, batchNode{id: id, probeID: probeID, target: controlTargetOf(rpt, probeID, id, req.Control)})
+		}
+	})
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	var missing []string
+	for _, id := range wanted {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return nodes, missing
+}
+
+func nodeIDs(nodes []batchNode) []string {
+	ids := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, n.id)
+	}
+	return ids
+}
+
+// runBatchControl invokes the control of a batch on nodes, parallelism at
+// once, until done or ctx is, whereupon those not done have timed out.
+func runBatchControl(ctx context.Context, cr ControlRouter, user string, req BatchControlRequest, nodes []batchNode, parallelism int) map[string]BatchControlResult {
+	var (
+		mtx     sync.Mutex
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, parallelism)
+		results = make(map[string]BatchControlResult, len(nodes))
+	)
+	for _, n := range nodes {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			mtx.Lock()
+			results[n.id] = BatchControlResult{ProbeID: n.probeID, Status: BatchControlTimedOut, Error: "not invoked before the deadline"}
+			mtx.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(n batchNode) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result := invokeBatchControl(ctx, cr, user, req, n)
+			mtx.Lock()
+			results[n.id] = result
+			mtx.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	return results
+}
+
+func invokeBatchControl(ctx context.Context, cr ControlRouter, user string, req BatchControlRequest, n batchNode) BatchControlResult {
+	res, err := invokeControl(ctx, cr, user, n.probeID, n.target, xfer.Request{
+		NodeID:      n.id,
+		Control:     req.Control,
+		ControlArgs: req.ControlArgs,
+	}, nil)
+	result := BatchControlResult{ProbeID: n.probeID, Status: BatchControlOK, Value: res.Value, Result: res.Result, ResultSchema: res.ResultSchema}
+	if _, ok := err.(probeNotConnectedError); ok {
+		result.Status, result.Error = BatchControlSkipped, err.Error()
+	} else if err == errControlRateLimited {
+		result.Status, result.Error = BatchControlRateLimited, err.Error()
+	} else if err != nil {
+		result.Status, result.Error = BatchControlError, err.Error()
+	} else if res.Error != "" && ctx.Err() == context.DeadlineExceeded {
+		result.Status, result.Error = BatchControlTimedOut, res.Error
+	} else if res.Error != "" {
+		result.Status, result.Error = BatchControlError, res.Error
+	}
+	return result
+}
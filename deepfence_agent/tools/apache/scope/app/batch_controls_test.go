@@ -0,0 +1,180 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/appclient"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+// batchReport is a report of containers a, b and c of probe foo, of image
+// nginx but for c, and d of probe bar, which all have the restart and slow
+// controls.
+func batchReport() report.Report {
+	rpt := report.MakeReport()
+	rpt.Container.Controls.AddControls([]report.Control{
+		{ID: "restart", Human: "Restart"},
+		{ID: "slow", Human: "Slow"},
+	})
+	for _, c := range []struct{ id, probeID, image string }{
+		{"a", "foo", "nginx"},
+		{"b", "foo", "nginx"},
+		{"c", "foo", "redis"},
+		{"d", "bar", "nginx"},
+	} {
+		rpt.Container.AddNode(report.MakeNodeWith(c.id, map[string]string{
+			report.ControlProbeID:  c.probeID,
+			report.DockerImageName: c.image,
+		}).WithLatestActiveControls("restart", "slow").WithTopology(report.Container))
+	}
+	return rpt
+}
+
+// batchControlServer serves the batch controls of batchReport, of which
+// probe foo is connected: restart fails on c, and slow waits the delay of
+// each node in delays until cancelled.  The function returned counts the
+// invocations of a control on a node, as "control/node".
+func batchControlServer(t *testing.T, delays *sync.Map) (*httptest.Server, func(string) int, func()) {
+	router := mux.NewRouter()
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), app.StaticCollector(batchReport()))
+	server := httptest.NewServer(router)
+
+	var mtx sync.Mutex
+	invoked := map[string]int{}
+	count := func(req xfer.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		invoked[req.Control+"/"+req.NodeID]++
+	}
+	registry := controls.NewDefaultHandlerRegistry()
+	registry.Register("restart", func(req xfer.Request) xfer.Response {
+		count(req)
+		if req.NodeID == "c" {
+			return xfer.ResponseErrorf("no such container")
+		}
+		return xfer.Response{Value: "restarted " + req.NodeID}
+	})
+	registry.RegisterContext("slow", func(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+		count(req)
+		var delay time.Duration
+		if d, ok := delays.Load(req.NodeID); ok {
+			delay = d.(time.Duration)
+		}
+		select {
+		case <-time.After(delay):
+			return xfer.Response{Value: "done"}
+		case <-ctx.Done():
+			return xfer.ResponseError(ctx.Err())
+		}
+	})
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	client, err := appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "foo"}, host, url.URL{Scheme: "http", Host: host}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ControlConnection()
+	time.Sleep(100 * time.Millisecond)
+	invocations := func(key string) int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return invoked[key]
+	}
+	return server, invocations, func() {
+		client.Stop()
+		server.Close()
+	}
+}
+
+func postBatch(t *testing.T, url string, req app.BatchControlRequest) (int, app.BatchControlResponse) {
+	body, err := json.Marshal(req)
+	ok(t, err)
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(body)))
+	ok(t, err)
+	defer resp.Body.Close()
+	var response app.BatchControlResponse
+	if resp.StatusCode == http.StatusOK {
+		ok(t, json.NewDecoder(resp.Body).Decode(&response))
+	}
+	return resp.StatusCode, response
+}
+
+func TestBatchControl(t *testing.T) {
+	server, invocations, stop := batchControlServer(t, &sync.Map{})
+	defer stop()
+
+	status, response := postBatch(t, server.URL+"/topology-api/control/batch", app.BatchControlRequest{
+		Control: "restart",
+		NodeIDs: []string{"a", "c", "d", "e"},
+	})
+	equals(t, http.StatusOK, status)
+	equals(t, app.BatchControlResult{ProbeID: "foo", Status: app.BatchControlOK, Value: "restarted a"}, response.Results["a"])
+	equals(t, app.BatchControlResult{ProbeID: "foo", Status: app.BatchControlError, Error: "no such container"}, response.Results["c"])
+	equals(t, app.BatchControlSkipped, response.Results["d"].Status)
+	equals(t, app.BatchControlError, response.Results["e"].Status)
+	equals(t, 4, len(response.Results))
+	equals(t, []string{"d"}, response.Retry)
+
+	// By query
+	status, response = postBatch(t, server.URL+"/topology-api/control/batch", app.BatchControlRequest{
+		Control:     "restart",
+		Query:       "image:nginx",
+		Parallelism: 1,
+	})
+	equals(t, http.StatusOK, status)
+	equals(t, 3, len(response.Results))
+	equals(t, app.BatchControlOK, response.Results["b"].Status)
+	equals(t, 2, invocations("restart/a"))
+
+	for _, req := range []app.BatchControlRequest{
+		{NodeIDs: []string{"a"}},
+		{Control: "restart"},
+		{Control: "restart", NodeIDs: []string{"a"}, Query: "image:nginx"},
+		{Control: "restart", Query: "image:{nginx"},
+	} {
+		status, _ := postBatch(t, server.URL+"/topology-api/control/batch", req)
+		equals(t, http.StatusBadRequest, status)
+	}
+}
+
+func TestBatchControlRetry(t *testing.T) {
+	delays := &sync.Map{}
+	delays.Store("b", time.Minute)
+	server, invocations, stop := batchControlServer(t, delays)
+	defer stop()
+
+	// b times out, and only it is retried
+	req := app.BatchControlRequest{ID: "retry", Control: "slow", NodeIDs: []string{"a", "b"}}
+	status, response := postBatch(t, server.URL+"/topology-api/control/batch?timeout=200ms", req)
+	equals(t, http.StatusOK, status)
+	equals(t, "retry", response.ID)
+	equals(t, app.BatchControlOK, response.Results["a"].Status)
+	equals(t, app.BatchControlTimedOut, response.Results["b"].Status)
+	equals(t, []string{"b"}, response.Retry)
+
+	delays.Store("b", time.Duration(0))
+	status, response = postBatch(t, server.URL+"/topology-api/control/batch?timeout=5s", req)
+	equals(t, http.StatusOK, status)
+	equals(t, app.BatchControlOK, response.Results["a"].Status)
+	equals(t, app.BatchControlOK, response.Results["b"].Status)
+	equals(t, []string(nil), response.Retry)
+	equals(t, 1, invocations("slow/a"))
+	equals(t, 2, invocations("slow/b"))
+
+	// The ID is of a batch of slow
+	status, _ = postBatch(t, server.URL+"/topology-api/control/batch", app.BatchControlRequest{ID: "retry", Control: "restart", NodeIDs: []string{"a"}})
+	equals(t, http.StatusConflict, status)
+}
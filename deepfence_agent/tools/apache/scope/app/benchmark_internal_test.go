@@ -147,7 +147,7 @@ func benchmarkSummarizeTopology(b *testing.B, topologyID string) {
 	nodes := renderForTopology(b, topologyID, r)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		detailed.Summaries(ctx, rc, nodes)
+		detailed.Summaries(ctx, rc, nodes, true)
 	}
 }
 
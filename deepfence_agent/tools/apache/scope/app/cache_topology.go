@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/gomodule/redigo/redis"
-	log "github.com/sirupsen/logrus"
 	"github.com/ugorji/go/codec"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/detailed"
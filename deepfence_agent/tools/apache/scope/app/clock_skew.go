@@ -0,0 +1,38 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/weaveworks/scope/probe/host"
+	"github.com/weaveworks/scope/report"
+)
+
+// ClockSkewThreshold is the skew of the clocks of probes versus the app
+// above which their hosts get a warning - set at runtime.
+var ClockSkewThreshold = 5 * time.Second
+
+// addClockSkew estimates the skew of the clocks of the hosts in a report
+// just received, from the wall time of their probes.  The estimate includes
+// the delay between the probe reporting and publishing, of at most its spy
+// interval, and the time in flight.
+func addClockSkew(rpt report.Report, now time.Time) {
+	for id, node := range rpt.Host.Nodes {
+		wallTime, ok := node.Latest.Lookup(host.ClockWallTime)
+		if !ok {
+			continue
+		}
+		wallTimeMs, err := strconv.ParseInt(wallTime, 10, 64)
+		if err != nil {
+			continue
+		}
+		skew := time.Duration(wallTimeMs)*time.Millisecond - time.Duration(now.UnixNano())
+		latests := map[string]string{
+			host.ClockSkew: strconv.FormatInt(int64(skew/time.Millisecond), 10),
+		}
+		if skew > ClockSkewThreshold || -skew > ClockSkewThreshold {
+			latests[host.ClockSkewWarning] = "true"
+		}
+		rpt.Host.Nodes[id] = node.WithLatests(latests)
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/weaveworks/scope/render"
+)
+
+// CloudRangeSources are the URLs of the IP ranges published by cloud
+// providers; an empty URL is skipped.
+type CloudRangeSources struct {
+	AWS   string
+	GCP   string
+	Azure string // the service tags file, whose URL changes with each release
+}
+
+// DefaultCloudRangeSources are the IP ranges AWS and GCP publish.
+var DefaultCloudRangeSources = CloudRangeSources{
+	AWS: "https://ip-ranges.amazonaws.com/ip-ranges.json",
+	GCP: "https://www.gstatic.com/ipranges/cloud.json",
+}
+
+// FetchCloudRanges fetches the IP ranges of cloud services from the
+// sources.  Ranges of a provider as a whole come after those of its
+// services, so that the services match first where they overlap.
+func FetchCloudRanges(ctx context.Context, client *http.Client, sources CloudRangeSources) ([]render.CloudRange, error) {
+	var services, providers []render.CloudRange
+	for _, source := range []struct {
+		url   string
+		parse func([]byte) ([]render.CloudRange, error)
+	}{
+		{sources.AWS, parseAWSRanges},
+		{sources.GCP, parseGCPRanges},
+		{sources.Azure, parseAzureRanges},
+	} {
+		if source.url == "" {
+			continue
+		}
+		body, err := fetchJSON(ctx, client, source.url)
+		if err != nil {
+			return nil, err
+		}
+		ranges, err := source.parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", source.url, err)
+		}
+		for _, r := range ranges {
+			if r.Service == "" {
+				providers = append(providers, r)
+			} else {
+				services = append(services, r)
+			}
+		}
+	}
+	return append(services, providers...), nil
+}
+
+// RefreshCloudRanges fetches the IP ranges of cloud services from the
+// sources, for the external destinations of topologies, and then again
+// every interval until the context is done.  Failed fetches keep the ranges
+// fetched before.
+func RefreshCloudRanges(ctx context.Context, sources CloudRangeSources, interval time.Duration) {
+	client := &http.Client{Timeout: time.Minute}
+	refresh := func() {
+		ranges, err := FetchCloudRanges(ctx, client, sources)
+		if err != nil {
+			log.Warnf("Error fetching cloud IP ranges: %v", err)
+			return
+		}
+		render.SetCloudRanges(ranges)
+		log.Infof("Fetched %d cloud IP ranges", len(ranges))
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func fetchJSON(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("fetching %s: %v", url, err)
+	}
+	return body, nil
+}
+
+// appendRange appends the range of a CIDR, skipping those which don't parse.
+func appendRange(ranges []render.CloudRange, r render.CloudRange, cidr string) []render.CloudRange {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ranges
+	}
+	r.Net = network
+	return append(ranges, r)
+}
+
+func parseAWSRanges(body []byte) ([]render.CloudRange, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	// AMAZON is every range of AWS, and the others those of its services
+	service := func(s string) string {
+		if s == "AMAZON" {
+			return ""
+		}
+		return s
+	}
+	var ranges []render.CloudRange
+	for _, p := range doc.Prefixes {
+		ranges = appendRange(ranges, render.CloudRange{Provider: "AWS", Service: service(p.Service), Region: p.Region}, p.IPPrefix)
+	}
+	for _, p := range doc.IPv6Prefixes {
+		ranges = appendRange(ranges, render.CloudRange{Provider: "AWS", Service: service(p.Service), Region: p.Region}, p.IPv6Prefix)
+	}
+	return ranges, nil
+}
+
+func parseGCPRanges(body []byte) ([]render.CloudRange, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	// The ranges are of customers' instances, not of services
+	var ranges []render.CloudRange
+	for _, p := range doc.Prefixes {
+		for _, cidr := range []string{p.IPv4Prefix, p.IPv6Prefix} {
+			if cidr != "" {
+				ranges = appendRange(ranges, render.CloudRange{Provider: "GCP", Region: p.Scope}, cidr)
+			}
+		}
+	}
+	return ranges, nil
+}
+
+func parseAzureRanges(body []byte) ([]render.CloudRange, error) {
+	var doc struct {
+		Values []struct {
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	var ranges []render.CloudRange
+	for _, v := range doc.Values {
+		p := v.Properties
+		for _, cidr := range p.AddressPrefixes {
+			ranges = appendRange(ranges, render.CloudRange{Provider: "Azure", Service: p.SystemService, Region: p.Region}, cidr)
+		}
+	}
+	return ranges, nil
+}
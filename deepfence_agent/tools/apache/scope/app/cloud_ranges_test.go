@@ -0,0 +1,61 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weaveworks/scope/app"
+)
+
+func TestFetchCloudRanges(t *testing.T) {
+	files := map[string]string{
+		"/aws": `{"prefixes": [
+			{"ip_prefix": "52.0.0.0/8", "region": "GLOBAL", "service": "AMAZON"},
+			{"ip_prefix": "52.216.0.0/15", "region": "us-east-1", "service": "S3"},
+			{"ip_prefix": "not a prefix", "region": "us-east-1", "service": "S3"}
+		], "ipv6_prefixes": [
+			{"ipv6_prefix": "2600:1f00::/24", "region": "GLOBAL", "service": "ROUTE53"}
+		]}`,
+		"/gcp": `{"prefixes": [{"ipv4Prefix": "34.1.208.0/20", "scope": "africa-south1"}, {"ipv6Prefix": "2600:1900:8000::/44", "scope": "us-east1"}]}`,
+		"/azure": `{"values": [{"properties": {"region": "", "systemService": "AzureStorage", "addressPrefixes": ["13.65.0.0/16", "2603:1030::/45"]}},
+			{"properties": {"region": "", "systemService": "", "addressPrefixes": ["13.64.0.0/11"]}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(file))
+	}))
+	defer server.Close()
+
+	ranges, err := app.FetchCloudRanges(context.Background(), server.Client(), app.CloudRangeSources{
+		AWS:   server.URL + "/aws",
+		GCP:   server.URL + "/gcp",
+		Azure: server.URL + "/azure",
+	})
+	ok(t, err)
+	have := []string{}
+	for _, r := range ranges {
+		have = append(have, r.Provider+"/"+r.Service+" "+r.Net.String())
+	}
+	// Services first, and then providers as a whole
+	equals(t, []string{
+		"AWS/S3 52.216.0.0/15",
+		"AWS/ROUTE53 2600:1f00::/24",
+		"Azure/AzureStorage 13.65.0.0/16",
+		"Azure/AzureStorage 2603:1030::/45",
+		"AWS/ 52.0.0.0/8",
+		"GCP/ 34.1.208.0/20",
+		"GCP/ 2600:1900:8000::/44",
+		"Azure/ 13.64.0.0/11",
+	}, have)
+
+	_, err = app.FetchCloudRanges(context.Background(), server.Client(), app.CloudRangeSources{AWS: server.URL + "/missing"})
+	if err == nil {
+		t.Error("Expected an error fetching missing ranges")
+	}
+}
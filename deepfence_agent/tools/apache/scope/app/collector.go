@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/weaveworks/scope/common/xfer"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +17,7 @@ import (
 
 	"context"
 
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/report"
 )
@@ -34,11 +38,39 @@ type Reporter interface {
 	Report(context.Context, time.Time) (report.Report, error)
 	HasReports(context.Context, time.Time) (bool, error)
 	HasHistoricReports() bool
+	HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error)
+	ReportRanges(ctx context.Context, start, end time.Time) ([]TimeRange, error)
 	AdminSummary(context.Context, time.Time) (string, error)
 	WaitOn(context.Context, chan struct{})
 	UnWait(context.Context, chan struct{})
 }
 
+// TimeRange is a span of time over which reports were received.
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// reportRangeGap is the longest time between reports within a TimeRange.
+const reportRangeGap = time.Minute
+
+// TimeRanges returns the spans of time covered by reports received at
+// timestamps, which need not be sorted.  Reports less than a minute apart
+// are in the same span.
+func TimeRanges(timestamps []time.Time) []TimeRange {
+	sorted := append([]time.Time{}, timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	ranges := []TimeRange{}
+	for _, t := range sorted {
+		if n := len(ranges); n > 0 && t.Sub(ranges[n-1].End) <= reportRangeGap {
+			ranges[n-1].End = t
+			continue
+		}
+		ranges = append(ranges, TimeRange{Start: t, End: t})
+	}
+	return ranges
+}
+
 // WebReporter is a reporter that creates reports whose data is eventually
 // displayed on websites. It carries fields that will be forwarded to the
 // detailed.RenderContext
@@ -52,7 +84,10 @@ type WebReporter struct {
 // arguments:
 // - context.Context: the request context
 // - report.Report: the deserialised report
-// - []byte: the serialised report (as gzip'd msgpack)
+// - []byte: the serialised report (as gzip'd msgpack), or nil
+//
+// The serialised report is the one received, so it's nil for reports which
+// weren't received as such, or have been changed since.
 type Adder interface {
 	Add(context.Context, report.Report, []byte) error
 }
@@ -64,6 +99,17 @@ type Collector interface {
 	Close()
 }
 
+// StateStore keeps state of the app besides reports, by the tenant of a
+// context.  Collectors which can implement it, in the store they keep
+// reports in, shared by the replicas of the app as those are.
+type StateStore interface {
+	// LoadState returns the state saved under key for the tenant of ctx,
+	// nil if there's none.
+	LoadState(ctx context.Context, key string) ([]byte, error)
+	// SaveState replaces the state under key for the tenant of ctx.
+	SaveState(ctx context.Context, key string, buf []byte) error
+}
+
 // Collector receives published reports from multiple producers. It yields a
 // single merged report, representing all collected reports.
 type collector struct {
@@ -73,6 +119,11 @@ type collector struct {
 	window     time.Duration
 	cached     *report.Report
 	merger     Merger
+	// The reports as received, not quantised, with the probes they came
+	// from, for the origins of the values of nodes only.
+	received []receivedReport
+	// State of the app, by tenant and key, kept in memory as reports are.
+	state map[string][]byte
 	waitableCondition
 }
 
@@ -113,6 +164,7 @@ func NewCollector(window time.Duration) Collector {
 			waiters: map[chan struct{}]struct{}{},
 		},
 		merger: NewFastMerger(),
+		state:  map[string][]byte{},
 	}
 }
 
@@ -120,14 +172,19 @@ func NewCollector(window time.Duration) Collector {
 func (c *collector) Close() {}
 
 // Add adds a report to the collector's internal state. It implements Adder.
-func (c *collector) Add(_ context.Context, rpt report.Report, _ []byte) error {
+func (c *collector) Add(ctx context.Context, rpt report.Report, _ []byte) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	c.reports = append(c.reports, rpt)
-	c.timestamps = append(c.timestamps, mtime.Now())
+	// Back-dated reports go in their place, keeping the timestamps ordered
+	timestamp := ReportTimestamp(ctx)
+	i := insertionIndex(c.timestamps, timestamp)
+	c.reports = append(c.reports[:i], append([]report.Report{rpt}, c.reports[i:]...)...)
+	c.timestamps = append(c.timestamps[:i], append([]time.Time{timestamp}, c.timestamps[i:]...)...)
+	c.received = append(c.received, receivedReport{report: rpt, timestamp: timestamp, probeID: probeIDFromContext(ctx)})
 
 	c.clean()
 	c.cached = nil
+	collectorReports.Set(float64(len(c.reports)))
 	if rpt.Shortcut {
 		c.Broadcast()
 	}
@@ -136,7 +193,9 @@ func (c *collector) Add(_ context.Context, rpt report.Report, _ []byte) error {
 
 // Report returns a merged report over all added reports. It implements
 // Reporter.
-func (c *collector) Report(_ context.Context, timestamp time.Time) (report.Report, error) {
+func (c *collector) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "collector.Report")
+	defer span.Finish()
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
@@ -145,18 +204,26 @@ func (c *collector) Report(_ context.Context, timestamp time.Time) (report.Repor
 	if c.cached != nil && len(c.reports) > 0 {
 		oldest := timestamp.Add(-c.window)
 		if c.timestamps[0].After(oldest) {
+			span.SetTag("cached", true)
 			return *c.cached, nil
 		}
 	}
 
 	c.clean()
 	c.quantise()
+	collectorReports.Set(float64(len(c.reports)))
 
 	for i := range c.reports {
 		c.reports[i] = c.reports[i].Upgrade()
 	}
 
-	rpt := c.merger.Merge(c.reports)
+	span.SetTag("reports", len(c.reports))
+	mergeSpan, _ := opentracing.StartSpanFromContext(ctx, "collector.Merge")
+	start := time.Now()
+	// Nodes are timestamped by the clocks of probes, which may be behind
+	rpt := c.merger.MergeWindowed(c.reports, timestamp.Add(-c.window-ClockSkewThreshold))
+	collectorMergeDuration.Observe(time.Since(start).Seconds())
+	mergeSpan.Finish()
 	c.cached = &rpt
 	return rpt, nil
 }
@@ -174,12 +241,192 @@ func (c *collector) HasReports(ctx context.Context, timestamp time.Time) (bool,
 	return !c.timestamps[0].After(timestamp) && !c.timestamps[len(c.reports)-1].Before(timestamp.Add(-c.window)), nil
 }
 
+// LoadState implements StateStore.
+func (c *collector) LoadState(ctx context.Context, key string) ([]byte, error) {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.state[tenant+"/"+key], nil
+}
+
+// SaveState implements StateStore.
+func (c *collector) SaveState(ctx context.Context, key string, buf []byte) error {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.state[tenant+"/"+key] = buf
+	return nil
+}
+
+type fileStateStore struct {
+	dir string
+}
+
+// NewFileStateStore makes a StateStore keeping the state of each tenant in
+// a directory of its own in dir, for state to outlive the app when its
+// collector keeps it in memory, or not at all.
+func NewFileStateStore(dir string) (StateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return fileStateStore{dir: dir}, nil
+}
+
+func (s fileStateStore) path(tenant, key string) string {
+	return filepath.Join(s.dir, "tenant-"+url.PathEscape(tenant), url.PathEscape(key))
+}
+
+// LoadState implements StateStore.
+func (s fileStateStore) LoadState(ctx context.Context, key string) ([]byte, error) {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadFile(s.path(tenant, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buf, err
+}
+
+// SaveState implements StateStore, replacing the file of the key whole.
+func (s fileStateStore) SaveState(ctx context.Context, key string, buf []byte) error {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return err
+	}
+	path := s.path(tenant, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // HasHistoricReports indicates whether the collector contains reports
 // older than now-app.window.
 func (c *collector) HasHistoricReports() bool {
 	return false
 }
 
+// HistoricReport returns the merger of the reports received within window
+// before timestamp, and whether there were any.  Reports are only held for
+// the collector's window, to the resolution of their quantisation.
+func (c *collector) HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "collector.HistoricReport")
+	defer span.Finish()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return mergeHistoric(c.merger, c.reports, c.timestamps, timestamp, window)
+}
+
+// ReportRanges returns the spans of time between start and end over which
+// the collector holds reports.
+func (c *collector) ReportRanges(_ context.Context, start, end time.Time) ([]TimeRange, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return TimeRanges(timestampsBetween(c.timestamps, start, end)), nil
+}
+
+// Origins returns the origins of the latest values of the nodes with the
+// given IDs in the reports received within the window before timestamp.
+// It implements OriginReporter.
+func (c *collector) Origins(_ context.Context, timestamp time.Time, nodeIDs []string) (map[string]map[string]Origin, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var received []receivedReport
+	for _, r := range c.received {
+		if r.timestamp.After(timestamp.Add(-c.window)) && !r.timestamp.After(timestamp) {
+			received = append(received, r)
+		}
+	}
+	return latestOrigins(received, nodeIDs), nil
+}
+
+// probeIDFromContext returns the ID of the probe which sent the report
+// being added in ctx, if known.
+func probeIDFromContext(ctx context.Context) string {
+	if request, ok := ctx.Value(RequestCtxKey).(*http.Request); ok && request != nil {
+		return request.Header.Get(xfer.ScopeProbeIDHeader)
+	}
+	return ""
+}
+
+// reportTimestampCtxKey is the key of the time a back-dated report being
+// added was made, in its context.
+const reportTimestampCtxKey contextKey = contextKey("reportTimestamp")
+
+// ReportTimestamp returns the time the report being added in ctx was made:
+// now, unless its probe published it late.
+func ReportTimestamp(ctx context.Context) time.Time {
+	if timestamp, ok := ctx.Value(reportTimestampCtxKey).(time.Time); ok {
+		return timestamp
+	}
+	return mtime.Now()
+}
+
+// reportChecksumCtxKey is the key of the checksum of a report being added,
+// checked on receipt, in its context.
+const reportChecksumCtxKey contextKey = contextKey("reportChecksum")
+
+// ReportChecksum returns the checksum of the report being added in ctx, if
+// its probe sent one.
+func ReportChecksum(ctx context.Context) (report.Checksum, bool) {
+	checksum, ok := ctx.Value(reportChecksumCtxKey).(report.Checksum)
+	return checksum, ok
+}
+
+// insertionIndex returns where a report made at timestamp goes among
+// those made at the ordered timestamps: after all those not after it.
+func insertionIndex(timestamps []time.Time, timestamp time.Time) int {
+	return sort.Search(len(timestamps), func(i int) bool {
+		return timestamps[i].After(timestamp)
+	})
+}
+
+// mergeHistoric merges those of reports received at timestamps within
+// window before timestamp.
+func mergeHistoric(merger Merger, reports []report.Report, timestamps []time.Time, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	var inWindow []report.Report
+	for i, t := range timestamps {
+		if t.After(timestamp.Add(-window)) && !t.After(timestamp) {
+			inWindow = append(inWindow, reports[i].Upgrade())
+		}
+	}
+	if len(inWindow) == 0 {
+		return report.MakeReport(), false, nil
+	}
+	// Nodes are timestamped by the clocks of probes, which may be behind
+	return merger.MergeWindowed(inWindow, timestamp.Add(-window-ClockSkewThreshold)), true, nil
+}
+
+func timestampsBetween(timestamps []time.Time, start, end time.Time) []time.Time {
+	var between []time.Time
+	for _, t := range timestamps {
+		if !t.Before(start) && !t.After(end) {
+			between = append(between, t)
+		}
+	}
+	return between
+}
+
 // AdminSummary returns a string with some internal information about
 // the report, which may be useful to troubleshoot.
 func (c *collector) AdminSummary(ctx context.Context, timestamp time.Time) (string, error) {
@@ -209,6 +456,16 @@ func (c *collector) clean() {
 	}
 	c.reports = cleanedReports
 	c.timestamps = cleanedTimestamps
+	received := c.received[:0]
+	for _, r := range c.received {
+		if r.timestamp.After(oldest) {
+			received = append(received, r)
+		}
+	}
+	for i := len(received); i < len(c.received); i++ {
+		c.received[i] = receivedReport{}
+	}
+	c.received = received
 }
 
 // Merge reports received within the same reportQuantisationInterval.
@@ -253,6 +510,16 @@ func (c StaticCollector) Report(context.Context, time.Time) (report.Report, erro
 // Close is a no-op for the static collector
 func (c StaticCollector) Close() {}
 
+// HistoricReport returns the given report, which is for all time.
+func (c StaticCollector) HistoricReport(context.Context, time.Time, time.Duration) (report.Report, bool, error) {
+	return report.Report(c), true, nil
+}
+
+// ReportRanges returns no ranges, as the given report isn't from any time.
+func (c StaticCollector) ReportRanges(context.Context, time.Time, time.Time) ([]TimeRange, error) {
+	return []TimeRange{}, nil
+}
+
 // HasReports indicates whether the collector contains reports between
 // timestamp-app.window and timestamp.
 func (c StaticCollector) HasReports(context.Context, time.Time) (bool, error) {
@@ -383,7 +650,9 @@ type AsyncCollector struct {
 	waitableCondition
 }
 
-func (c *AsyncCollector) Report(_ context.Context, timestamp time.Time) (report.Report, error) {
+func (c *AsyncCollector) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "AsyncCollector.Report")
+	defer span.Finish()
 	c.cached.mtx.RLock()
 	defer c.cached.mtx.RUnlock()
 	if c.cached.cached == nil {
@@ -435,8 +704,10 @@ func (c *AsyncCollector) channelListener() {
 				c.reports.mtx.Lock()
 				currentReports, ok := c.reports.reports[msg.probeId]
 				if ok {
-					c.reports.reports[msg.probeId] = append(currentReports, msg.rpt)
-					c.reports.timestamps[msg.probeId] = append(c.reports.timestamps[msg.probeId], msg.ts)
+					timestamps := c.reports.timestamps[msg.probeId]
+					i := insertionIndex(timestamps, msg.ts)
+					c.reports.reports[msg.probeId] = append(currentReports[:i], append([]report.Report{msg.rpt}, currentReports[i:]...)...)
+					c.reports.timestamps[msg.probeId] = append(timestamps[:i], append([]time.Time{msg.ts}, timestamps[i:]...)...)
 				} else {
 					c.reports.reports[msg.probeId] = []report.Report{msg.rpt}
 					c.reports.timestamps[msg.probeId] = []time.Time{msg.ts}
@@ -452,9 +723,9 @@ func (c *AsyncCollector) channelListener() {
 func (c *AsyncCollector) Add(ctx context.Context, rpt report.Report, _ []byte) error {
 	request, ok := ctx.Value(RequestCtxKey).(*http.Request)
 	if ok && request != nil {
-		c.reportChannel <- rptStruct{rpt: rpt, ts: mtime.Now(), probeId: request.Header.Get(xfer.ScopeProbeIDHeader)}
+		c.reportChannel <- rptStruct{rpt: rpt, ts: ReportTimestamp(ctx), probeId: request.Header.Get(xfer.ScopeProbeIDHeader)}
 	} else {
-		c.reportChannel <- rptStruct{rpt: rpt, ts: mtime.Now(), probeId: "unknown"}
+		c.reportChannel <- rptStruct{rpt: rpt, ts: ReportTimestamp(ctx), probeId: "unknown"}
 	}
 	if rpt.Shortcut {
 		c.Broadcast()
@@ -466,6 +737,48 @@ func (c *AsyncCollector) HasHistoricReports() bool {
 	return false
 }
 
+// HistoricReport implements Reporter
+func (c *AsyncCollector) HistoricReport(_ context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	c.reports.mtx.Lock()
+	defer c.reports.mtx.Unlock()
+	var (
+		reports    []report.Report
+		timestamps []time.Time
+	)
+	for key := range c.reports.reports {
+		reports = append(reports, c.reports.reports[key]...)
+		timestamps = append(timestamps, c.reports.timestamps[key]...)
+	}
+	return mergeHistoric(c.merger, reports, timestamps, timestamp, window)
+}
+
+// Origins implements OriginReporter.  The reports of each probe are held
+// apart, so it needs none of its own.
+func (c *AsyncCollector) Origins(_ context.Context, timestamp time.Time, nodeIDs []string) (map[string]map[string]Origin, error) {
+	c.reports.mtx.Lock()
+	defer c.reports.mtx.Unlock()
+	var received []receivedReport
+	for probeID, probeReports := range c.reports.reports {
+		for i, t := range c.reports.timestamps[probeID] {
+			if t.After(timestamp.Add(-c.window)) && !t.After(timestamp) {
+				received = append(received, receivedReport{report: probeReports[i], timestamp: t, probeID: probeID})
+			}
+		}
+	}
+	return latestOrigins(received, nodeIDs), nil
+}
+
+// ReportRanges implements Reporter
+func (c *AsyncCollector) ReportRanges(_ context.Context, start, end time.Time) ([]TimeRange, error) {
+	c.reports.mtx.Lock()
+	defer c.reports.mtx.Unlock()
+	var timestamps []time.Time
+	for _, ts := range c.reports.timestamps {
+		timestamps = append(timestamps, timestampsBetween(ts, start, end)...)
+	}
+	return TimeRanges(timestamps), nil
+}
+
 // AdminSummary implements Reporter
 func (c *AsyncCollector) AdminSummary(ctx context.Context, timestamp time.Time) (string, error) {
 	return "not implemented", nil
@@ -112,6 +112,81 @@ func TestCollectorExpire(t *testing.T) {
 	}
 }
 
+func TestCollectorStaleNodes(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	ctx := context.Background()
+	window := 10 * time.Second
+	c := app.NewCollector(window)
+
+	// A container which exited long ago, still in the report of its
+	// probe, is left out; those with data in the window, and those with
+	// none to tell their age by, are kept
+	stale := now.Add(-window - app.ClockSkewThreshold - time.Minute)
+	r := report.MakeReport()
+	r.Container.AddNode(report.MakeNode("exited").WithLatest("state", stale, "exited"))
+	r.Container.AddNode(report.MakeNode("running").WithLatest("state", now, "running"))
+	r.Endpoint.AddNode(report.MakeNode("remote"))
+	c.Add(ctx, r, nil)
+
+	have, err := c.Report(ctx, mtime.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := have.Container.Nodes["exited"]; ok {
+		t.Error("Expected the exited container to be left out")
+	}
+	if _, ok := have.Container.Nodes["running"]; !ok {
+		t.Error("Expected the running container")
+	}
+	if _, ok := have.Endpoint.Nodes["remote"]; !ok {
+		t.Error("Expected the remote endpoint")
+	}
+}
+
+func TestCollectorHistoricReport(t *testing.T) {
+	now := time.Now()
+	defer mtime.NowReset()
+
+	ctx := context.Background()
+	c := app.NewCollector(time.Hour)
+	for _, offset := range []time.Duration{0, 5 * time.Second, 2 * time.Minute} {
+		mtime.NowForce(now.Add(offset))
+		r := report.MakeReport()
+		r.Endpoint.AddNode(report.MakeNode(offset.String()))
+		c.Add(ctx, r, nil)
+	}
+
+	// Only the reports within the window before the timestamp
+	have, found, err := c.HistoricReport(ctx, now.Add(10*time.Second), 8*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || len(have.Endpoint.Nodes) != 1 {
+		t.Fatalf("Expected the report from 5s, got %v", have.Endpoint.Nodes)
+	}
+	if _, ok := have.Endpoint.Nodes["5s"]; !ok {
+		t.Errorf("Expected the report from 5s, got %v", have.Endpoint.Nodes)
+	}
+	if _, found, _ := c.HistoricReport(ctx, now.Add(time.Minute), 15*time.Second); found {
+		t.Error("Expected no reports between them")
+	}
+
+	ranges, err := c.ReportRanges(ctx, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []app.TimeRange{
+		{Start: now, End: now.Add(5 * time.Second)},
+		{Start: now.Add(2 * time.Minute), End: now.Add(2 * time.Minute)},
+	}
+	if !reflect.DeepEqual(want, ranges) {
+		t.Error(test.Diff(want, ranges))
+	}
+}
+
 func TestCollectorWait(t *testing.T) {
 	ctx := context.Background()
 	window := time.Millisecond
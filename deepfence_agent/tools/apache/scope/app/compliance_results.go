@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// benchmarkRegexp matches the names of benchmarks, such as cis-docker.
+var benchmarkRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// ComplianceCounts are the numbers of checks of a category which passed,
+// failed, or warned.
+type ComplianceCounts struct {
+	Pass int `json:"pass"`
+	Fail int `json:"fail"`
+	Warn int `json:"warn"`
+}
+
+// ComplianceResults are the results of the compliance checks of a host or
+// container against a benchmark, by category of check, as posted by the
+// compliance scanner.
+type ComplianceResults struct {
+	HostName         string                      `json:"host_name,omitempty"`
+	ContainerID      string                      `json:"container_id,omitempty"`
+	Benchmark        string                      `json:"benchmark"` // e.g. cis-docker
+	BenchmarkVersion string                      `json:"benchmark_version,omitempty"`
+	Categories       map[string]ComplianceCounts `json:"categories"`
+	ScanTime         time.Time                   `json:"scan_time"`
+}
+
+func (c ComplianceResults) validate() error {
+	if (c.HostName == "") == (c.ContainerID == "") {
+		return fmt.Errorf("Results of neither or both of a host and a container")
+	}
+	if !benchmarkRegexp.MatchString(c.Benchmark) {
+		return fmt.Errorf("Invalid benchmark %q", c.Benchmark)
+	}
+	if len(c.Categories) == 0 {
+		return fmt.Errorf("Results of %s without categories", c.Benchmark)
+	}
+	if c.ScanTime.IsZero() {
+		return fmt.Errorf("Results without a scan time")
+	}
+	return nil
+}
+
+// scanStatus is the status of the compliance scan the results are of, with
+// the totals of the checks of all categories.
+func (c ComplianceResults) scanStatus() report.ScanStatus {
+	s := report.ScanStatus{
+		Type:       report.ComplianceScanType(c.Benchmark),
+		Status:     report.ScanStatusComplete,
+		Version:    c.BenchmarkVersion,
+		Counts:     map[string]int{"pass": 0, "fail": 0, "warn": 0},
+		Categories: make(map[string]map[string]int, len(c.Categories)),
+		LastScan:   c.ScanTime,
+	}
+	for category, counts := range c.Categories {
+		s.Categories[category] = map[string]int{"pass": counts.Pass, "fail": counts.Fail, "warn": counts.Warn}
+		s.Counts["pass"] += counts.Pass
+		s.Counts["fail"] += counts.Fail
+		s.Counts["warn"] += counts.Warn
+	}
+	return s
+}
+
+// heldResults are the statuses of the latest compliance scans of a host or
+// container, by benchmark, and when they were last received or matched a
+// node.
+type heldResults struct {
+	statuses  map[string]report.ScanStatus
+	lastMatch time.Time
+}
+
+// tenantResults are the results held of the hosts and containers of a
+// tenant, and their generation, which changes as they, or the number of
+// them which are stale, do.
+type tenantResults struct {
+	hosts      map[string]*heldResults // by host name
+	containers map[string]*heldResults // by container ID
+	stale      int
+	generation uint64
+}
+
+// ComplianceResultsStore keeps the results of the latest compliance scans of
+// hosts and containers of each tenant against each benchmark, adding them to
+// the nodes of their reports.  Results older than the stale age are flagged
+// as stale, and those of hosts and containers not reported for that long
+// are forgotten.
+type ComplianceResultsStore struct {
+	staleAfter time.Duration
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantResults
+}
+
+// NewComplianceResultsStore makes a new ComplianceResultsStore, flagging
+// results older than staleAfter.  Zero never flags nor forgets results.
+func NewComplianceResultsStore(staleAfter time.Duration) *ComplianceResultsStore {
+	return &ComplianceResultsStore{
+		staleAfter: staleAfter,
+		tenants:    map[string]*tenantResults{},
+	}
+}
+
+// Add keeps results of a host or container of tenant received at now,
+// unless more recent results of the same benchmark are kept for it.
+func (s *ComplianceResultsStore) Add(tenant string, c ComplianceResults, now time.Time) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	status := c.scanStatus()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tr, ok := s.tenants[tenant]
+	if !ok {
+		tr = &tenantResults{
+			hosts:      map[string]*heldResults{},
+			containers: map[string]*heldResults{},
+		}
+		s.tenants[tenant] = tr
+	}
+	tr.generation++
+	held, id := tr.hosts, c.HostName
+	if c.ContainerID != "" {
+		held, id = tr.containers, c.ContainerID
+	}
+	h, ok := held[id]
+	if !ok {
+		h = &heldResults{statuses: map[string]report.ScanStatus{}}
+		held[id] = h
+	}
+	if prev, ok := h.statuses[status.Type]; ok {
+		status = prev.Merge(status)
+	}
+	h.statuses[status.Type], h.lastMatch = status, now
+	return nil
+}
+
+// Decorate returns rpt, of tenant, with the results held added to its host
+// and container nodes, as of now, forgetting those which have matched no
+// nodes for the stale age.  Its ID is decorated by the generation of the
+// results, so that its renders aren't those of rpt.  rpt is not modified.
+func (s *ComplianceResultsStore) Decorate(rpt report.Report, tenant string, now time.Time) report.Report {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.expire(now)
+	tr, ok := s.tenants[tenant]
+	if !ok {
+		return rpt
+	}
+	stale := 0
+	rpt.Host.Nodes = s.decorate(rpt.Host.Nodes, tr.hosts, report.ParseHostNodeID, now, &stale)
+	rpt.Container.Nodes = s.decorate(rpt.Container.Nodes, tr.containers, report.ParseContainerNodeID, now, &stale)
+	if stale != tr.stale {
+		tr.stale = stale
+		tr.generation++
+	}
+	rpt.ID = decoratedID(rpt.ID, "compliance", tr.generation)
+	return rpt
+}
+
+// decorate returns nodes with the results held by the IDs parsed from their
+// node IDs added, copying nodes if any are, and counts those stale.
+func (s *ComplianceResultsStore) decorate(nodes report.Nodes, held map[string]*heldResults, parse func(string) (string, bool), now time.Time, stale *int) report.Nodes {
+	var decorated []report.Node
+	for nodeID, n := range nodes {
+		id, ok := parse(nodeID)
+		if !ok {
+			continue
+		}
+		h, ok := held[id]
+		if !ok {
+			continue
+		}
+		h.lastMatch = now
+		for _, status := range h.statuses {
+			if s.staleAfter > 0 && now.Sub(status.LastScan) > s.staleAfter {
+				status.Status = report.ScanStatusStale
+				*stale++
+			}
+			n = n.WithScanStatus(status)
+		}
+		decorated = append(decorated, n)
+	}
+	if len(decorated) == 0 {
+		return nodes
+	}
+	nodes = nodes.Copy()
+	for _, n := range decorated {
+		nodes[n.ID] = n
+	}
+	return nodes
+}
+
+func (s *ComplianceResultsStore) expire(now time.Time) {
+	if s.staleAfter <= 0 {
+		return
+	}
+	for tenant, tr := range s.tenants {
+		for _, held := range []map[string]*heldResults{tr.hosts, tr.containers} {
+			for id, h := range held {
+				if now.Sub(h.lastMatch) > s.staleAfter {
+					delete(held, id)
+					tr.generation++
+				}
+			}
+		}
+		if len(tr.hosts)+len(tr.containers) == 0 {
+			delete(s.tenants, tenant)
+		}
+	}
+}
+
+// ComplianceResultsReporter is a Reporter whose reports have the compliance
+// results held in Results of the tenant of the request added to their host
+// and container nodes.
+type ComplianceResultsReporter struct {
+	Reporter
+	Results *ComplianceResultsStore
+}
+
+// Report implements Reporter
+func (r ComplianceResultsReporter) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	rpt, err := r.Reporter.Report(ctx, timestamp)
+	if err != nil {
+		return rpt, err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return rpt, err
+	}
+	return r.Results.Decorate(rpt, tenant, mtime.Now()), nil
+}
+
+// HistoricReport implements Reporter
+func (r ComplianceResultsReporter) HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	rpt, ok, err := r.Reporter.HistoricReport(ctx, timestamp, window)
+	if err != nil || !ok {
+		return rpt, ok, err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return rpt, false, err
+	}
+	return r.Results.Decorate(rpt, tenant, mtime.Now()), true, nil
+}
+
+// RegisterComplianceResultsHandler registers the handler for the compliance
+// scanner to post its results to, as a JSON list of ComplianceResults of the
+// tenant of the request.
+func RegisterComplianceResultsHandler(router *mux.Router, results *ComplianceResultsStore) {
+	post := router.Methods("POST").Subrouter()
+	post.HandleFunc("/topology-api/scans/compliance", requestContextDecorator(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		var list []ComplianceResults
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		for _, c := range list {
+			if err := c.validate(); err != nil {
+				respondWith(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		now := mtime.Now()
+		for _, c := range list {
+			results.Add(tenant, c, now)
+		}
+		respondWith(ctx, w, http.StatusOK, struct {
+			Accepted int `json:"accepted"`
+		}{len(list)})
+	}))
+}
@@ -0,0 +1,124 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+func TestComplianceResults(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	results := app.NewComplianceResultsStore(24 * time.Hour)
+	router := mux.NewRouter()
+	app.RegisterComplianceResultsHandler(router, results)
+	reporter := app.ComplianceResultsReporter{Reporter: app.StaticCollector(fixture.Report), Results: results}
+	app.RegisterTopologyRoutes(router, reporter, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	post := func(list []app.ComplianceResults) int {
+		buf, err := json.Marshal(list)
+		ok(t, err)
+		resp, err := http.Post(ts.URL+"/topology-api/scans/compliance", "application/json", bytes.NewReader(buf))
+		ok(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// The docker benchmark of the server host is stale
+	stale, scanned := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	equals(t, http.StatusOK, post([]app.ComplianceResults{
+		{HostName: fixture.ServerHostID, Benchmark: "cis-docker", BenchmarkVersion: "1.2.0", ScanTime: stale, Categories: map[string]app.ComplianceCounts{
+			"host":   {Pass: 10, Fail: 2, Warn: 1},
+			"daemon": {Pass: 5},
+		}},
+		{HostName: fixture.ServerHostID, Benchmark: "cis-kubernetes", BenchmarkVersion: "1.6.0", ScanTime: scanned, Categories: map[string]app.ComplianceCounts{
+			"worker": {Pass: 20, Fail: 1},
+		}},
+		{ContainerID: fixture.ServerContainerID, Benchmark: "cis-docker", ScanTime: scanned, Categories: map[string]app.ComplianceCounts{
+			"container": {Pass: 3, Warn: 4},
+		}},
+	}))
+	for _, bad := range []app.ComplianceResults{
+		{Benchmark: "cis-docker", ScanTime: scanned, Categories: map[string]app.ComplianceCounts{"host": {}}},
+		{HostName: "h", ContainerID: "c", Benchmark: "cis-docker", ScanTime: scanned, Categories: map[string]app.ComplianceCounts{"host": {}}},
+		{HostName: "h", Benchmark: "CIS docker", ScanTime: scanned, Categories: map[string]app.ComplianceCounts{"host": {}}},
+		{HostName: "h", Benchmark: "cis-docker", ScanTime: scanned},
+		{HostName: "h", Benchmark: "cis-docker", Categories: map[string]app.ComplianceCounts{"host": {}}},
+	} {
+		equals(t, http.StatusBadRequest, post([]app.ComplianceResults{bad}))
+	}
+	// Older results don't replace newer ones
+	before := results.Decorate(fixture.Report, "", now).ID
+	equals(t, http.StatusOK, post([]app.ComplianceResults{
+		{HostName: fixture.ServerHostID, Benchmark: "cis-kubernetes", ScanTime: stale, Categories: map[string]app.ComplianceCounts{"worker": {Fail: 9}}},
+	}))
+
+	// The results of both benchmarks are on the host node, with the stale
+	// ones flagged
+	rpt, err := reporter.Report(context.Background(), now)
+	ok(t, err)
+	statuses := rpt.Host.Nodes[fixture.ServerHostNodeID].ScanStatuses()
+	docker, kubernetes := statuses[report.ComplianceScanType("cis-docker")], statuses[report.ComplianceScanType("cis-kubernetes")]
+	equals(t, report.ScanStatusStale, docker.Status)
+	equals(t, map[string]int{"pass": 15, "fail": 2, "warn": 1}, docker.Counts)
+	equals(t, report.ScanStatusComplete, kubernetes.Status)
+	equals(t, "1.6.0", kubernetes.Version)
+	equals(t, map[string]int{"pass": 20, "fail": 1, "warn": 0}, kubernetes.Counts)
+	container, _ := rpt.Container.Nodes[fixture.ServerContainerNodeID].LookupScanStatus(report.ComplianceScanType("cis-docker"))
+	equals(t, map[string]int{"pass": 3, "fail": 0, "warn": 4}, container.Counts)
+	// Its ID is that of neither the fixture nor the report before the last
+	// results, so that renders of them aren't served
+	if rpt.ID == fixture.Report.ID || rpt.ID == before {
+		t.Errorf("Expected a new ID, got %q", rpt.ID)
+	}
+	// Results are only those of the tenant
+	other := results.Decorate(fixture.Report, "other-tenant", now)
+	equals(t, 0, len(other.Host.Nodes[fixture.ServerHostNodeID].ScanStatuses()))
+	equals(t, fixture.Report.ID, other.ID)
+
+	// The details of the host have a row per benchmark and category
+	var node app.APINode
+	body := getRawJSON(t, ts, "/topology-api/topology/hosts/"+fixture.ServerHostNodeID)
+	ok(t, codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&node))
+	var table *report.Table
+	for i := range node.Node.Tables {
+		if node.Node.Tables[i].ID == "compliance" {
+			table = &node.Node.Tables[i]
+		}
+	}
+	if table == nil {
+		t.Fatalf("Expected a compliance table, got %v", node.Node.Tables)
+	}
+	equals(t, 3, len(table.Rows))
+	equals(t, "cis-docker/daemon", table.Rows[0].ID)
+	equals(t, map[string]string{
+		"benchmark": "cis-kubernetes",
+		"version":   "1.6.0",
+		"category":  "worker",
+		"pass":      "20",
+		"fail":      "1",
+		"warn":      "0",
+		"status":    report.ScanStatusComplete,
+		"last_scan": scanned.UTC().Format(time.RFC3339Nano),
+	}, table.Rows[2].Entries)
+
+	// Results matching no nodes for the stale age are forgotten, even if
+	// their hosts come back
+	mtime.NowForce(now.Add(25 * time.Hour))
+	rpt = results.Decorate(fixture.Report, "", now.Add(25*time.Hour))
+	equals(t, 0, len(rpt.Host.Nodes[fixture.ServerHostNodeID].ScanStatuses()))
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/common/mtime"
+	"golang.org/x/time/rate"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// ControlAuditLog - set at runtime, where the entry of each control invoked
+// is written.
+var ControlAuditLog = logrus.StandardLogger()
+
+// Results of controls, as audited
+const (
+	controlResultOK          = "ok"
+	controlResultError       = "error"
+	controlResultRateLimited = "rate_limited"
+)
+
+// maxControlLimiters is how many users, and how many hosts, have their
+// invocations of destructive controls limited at once; the least recently
+// seen are forgotten past it.
+const maxControlLimiters = 10000
+
+// controlLimits rate limits the invocation of destructive controls, per
+// user and per target host.
+type controlLimits struct {
+	mtx          sync.Mutex
+	userRate     int
+	hostRate     int
+	users, hosts gcache.Cache
+}
+
+var destructiveControls = newControlLimits(0, 0)
+
+func newControlLimits(userRate, hostRate int) *controlLimits {
+	return &controlLimits{
+		userRate: userRate,
+		hostRate: hostRate,
+		users:    gcache.New(maxControlLimiters).LRU().Build(),
+		hosts:    gcache.New(maxControlLimiters).LRU().Build(),
+	}
+}
+
+// ConfigureControlRateLimits sets how many destructive controls, such as
+// those deleting pods, each user and each host may have invoked per
+// minute, bursting up to as many, forgetting those invoked so far.  Zero
+// is unlimited.
+func ConfigureControlRateLimits(userRate, hostRate int) {
+	destructiveControls = newControlLimits(userRate, hostRate)
+}
+
+// allow takes a destructive control of user on host from their limits,
+// returning false, and taking nothing, if either has none left.
+func (l *controlLimits) allow(user, host string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := time.Now()
+	var reservations []*rate.Reservation
+	for _, limit := range []struct {
+		cache gcache.Cache
+		key   string
+		rate  int
+	}{
+		{l.users, user, l.userRate},
+		{l.hosts, host, l.hostRate},
+	} {
+		if limit.rate <= 0 {
+			continue
+		}
+		r := limiter(limit.cache, limit.key, limit.rate).ReserveN(now, 1)
+		if !r.OK() || r.DelayFrom(now) > 0 {
+			r.CancelAt(now)
+			for _, taken := range reservations {
+				taken.CancelAt(now)
+			}
+			return false
+		}
+		reservations = append(reservations, r)
+	}
+	return true
+}
+
+// limiter returns the limiter of key in cache, of perMinute, making it if
+// need be.
+func limiter(cache gcache.Cache, key string, perMinute int) *rate.Limiter {
+	if l, err := cache.Get(key); err == nil {
+		return l.(*rate.Limiter)
+	}
+	l := rate.NewLimiter(rate.Limit(float64(perMinute)/time.Minute.Seconds()), perMinute)
+	cache.Set(key, l)
+	return l
+}
+
+// controlTarget is what a control is invoked on, as classified by the
+// latest report.
+type controlTarget struct {
+	host        string
+	destructive bool
+}
+
+// findControlTarget classifies a control by the latest report of rep, as
+// controlTargetOf does.  Without a report, controls are destructive.
+func findControlTarget(ctx context.Context, rep Reporter, probeID, nodeID, control string) (controlTarget, error) {
+	if rep == nil {
+		return controlTarget{host: probeID, destructive: true}, nil
+	}
+	rpt, err := rep.Report(ctx, mtime.Now())
+	if err != nil {
+		return controlTarget{host: probeID, destructive: true}, err
+	}
+	return controlTargetOf(rpt, probeID, nodeID, control), nil
+}
+
+// controlTargetOf returns whether a control is destructive, as registered
+// by any topology of rpt, whether or not the node is in it, and the host of
+// the node, or the probe if the node isn't on one or isn't in rpt.
+// Controls no topology registers are destructive, as far as the app knows:
+// probes act on nodes they've yet to report, or have stopped reporting.
+func controlTargetOf(rpt report.Report, probeID, nodeID, control string) controlTarget {
+	target := controlTarget{host: probeID}
+	registered := false
+	rpt.WalkTopologies(func(t *report.Topology) {
+		if c, ok := t.Controls[control]; ok {
+			registered = true
+			target.destructive = target.destructive || c.Destructive
+		}
+		if n, ok := t.Nodes[nodeID]; ok {
+			if host := report.ExtractHostID(n); host != "" {
+				target.host = host
+			}
+		}
+	})
+	if !registered {
+		target.destructive = true
+	}
+	return target
+}
+
+// errControlRateLimited is the error of destructive controls refused past
+// the rate limits.
+var errControlRateLimited = errors.New("too many destructive controls; try again later")
+
+// invokeControl invokes a control of a probe through cr, unless it's
+// destructive and user or the target host is past their rate limit,
+// whereupon it fails with errControlRateLimited, and audits it.
+func invokeControl(ctx context.Context, cr ControlRouter, user, probeID string, target controlTarget, req xfer.Request, progress func(xfer.Progress)) (xfer.Response, error) {
+	start := time.Now()
+	if target.destructive && !destructiveControls.allow(user, target.host) {
+		auditControl(user, probeID, req.NodeID, req.Control, target, controlResultRateLimited, "", time.Since(start))
+		return xfer.Response{}, errControlRateLimited
+	}
+	result, err := cr.Handle(ctx, probeID, req, progress)
+	success := err == nil && result.Error == ""
+	controlRoundTripDuration.WithLabelValues(strconv.FormatBool(success)).Observe(time.Since(start).Seconds())
+	switch {
+	case err != nil:
+		auditControl(user, probeID, req.NodeID, req.Control, target, controlResultError, err.Error(), time.Since(start))
+	case result.Error != "":
+		auditControl(user, probeID, req.NodeID, req.Control, target, controlResultError, result.Error, time.Since(start))
+	default:
+		auditControl(user, probeID, req.NodeID, req.Control, target, controlResultOK, "", time.Since(start))
+	}
+	return result, err
+}
+
+// controlUser identifies who invokes a control: the tenant, if any, and
+// the fingerprint of their API token, or their address without one.
+func controlUser(ctx context.Context, r *http.Request) string {
+	user := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		user = host
+	}
+	if token := bearerToken(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		user = "token:" + hex.EncodeToString(sum[:4])
+	}
+	if tenant, err := TenantID(ctx); err == nil && tenant != "" {
+		user = tenant + "/" + user
+	}
+	return user
+}
+
+// auditControl writes the entry of a control invoked to ControlAuditLog.
+func auditControl(user, probeID, nodeID, control string, target controlTarget, result string, err string, duration time.Duration) {
+	entry := ControlAuditLog.WithFields(logrus.Fields{
+		"audit":       "control",
+		"user":        user,
+		"probe":       probeID,
+		"node":        nodeID,
+		"host":        target.host,
+		"control":     control,
+		"destructive": target.destructive,
+		"result":      result,
+		"duration":    duration.String(),
+	})
+	if err != "" {
+		entry = entry.WithField("error", err)
+	}
+	entry.Info("control invoked")
+}
@@ -13,13 +13,26 @@ import (
 )
 
 // ControlRouter is a thing that can route control requests and responses
-// between the UI and a probe.
+// between the UI and a probe.  Requests are handled until done or ctx is,
+// and the progress of those given a progress function is relayed to it,
+// where the router and probe can; the handlers registered are given nil
+// progress functions otherwise.
 type ControlRouter interface {
-	Handle(ctx context.Context, probeID string, req xfer.Request) (xfer.Response, error)
-	Register(ctx context.Context, probeID string, handler xfer.ControlHandlerFunc) (int64, error)
+	Handle(ctx context.Context, probeID string, req xfer.Request, progress func(xfer.Progress)) (xfer.Response, error)
+	Register(ctx context.Context, probeID string, handler xfer.ControlContextHandlerFunc) (int64, error)
 	Deregister(ctx context.Context, probeID string, id int64) error
 }
 
+// probeNotConnectedError is the error of controls of a probe without a
+// control connection to the app.
+type probeNotConnectedError struct {
+	probeID string
+}
+
+func (e probeNotConnectedError) Error() string {
+	return fmt.Sprintf("probe %s is not connected right now", e.probeID)
+}
+
 // NewLocalControlRouter creates a new ControlRouter that does everything
 // locally, in memory.
 func NewLocalControlRouter() ControlRouter {
@@ -35,20 +48,20 @@ type localControlRouter struct {
 
 type probe struct {
 	id      int64
-	handler xfer.ControlHandlerFunc
+	handler xfer.ControlContextHandlerFunc
 }
 
-func (l *localControlRouter) Handle(_ context.Context, probeID string, req xfer.Request) (xfer.Response, error) {
+func (l *localControlRouter) Handle(ctx context.Context, probeID string, req xfer.Request, progress func(xfer.Progress)) (xfer.Response, error) {
 	l.Lock()
 	probe, ok := l.probes[probeID]
 	l.Unlock()
 	if !ok {
-		return xfer.Response{}, fmt.Errorf("probe %s is not connected right now", probeID)
+		return xfer.Response{}, probeNotConnectedError{probeID}
 	}
-	return probe.handler(req), nil
+	return probe.handler(ctx, req, progress), nil
 }
 
-func (l *localControlRouter) Register(_ context.Context, probeID string, handler xfer.ControlHandlerFunc) (int64, error) {
+func (l *localControlRouter) Register(_ context.Context, probeID string, handler xfer.ControlContextHandlerFunc) (int64, error) {
 	l.Lock()
 	defer l.Unlock()
 	id := rand.Int63()
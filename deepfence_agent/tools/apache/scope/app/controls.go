@@ -1,34 +1,71 @@
 package app
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
 
 	"context"
 
 	"github.com/gorilla/mux"
-	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/websocket"
 	"github.com/ugorji/go/codec"
 
 	"github.com/weaveworks/scope/common/xfer"
+	scopeprobe "github.com/weaveworks/scope/probe"
 )
 
-// RegisterControlRoutes registers the various control routes with a http mux.
-func RegisterControlRoutes(router *mux.Router, cr ControlRouter) {
+// ProbeReportTimeout and ProbeReportMaxBytes - set at runtime, how long to
+// wait for the reports asked of probes on demand, and how large they may be.
+var (
+	ProbeReportTimeout  = 30 * time.Second
+	ProbeReportMaxBytes = 64 << 20
+)
+
+// goodbyeTimeout is how long probes are given to close their control
+// websockets once the app has said goodbye.
+const goodbyeTimeout = 5 * time.Second
+
+// RegisterControlRoutes registers the various control routes with a http
+// mux.  Controls are classified as destructive or not by the latest report
+// of rep; without one, they're all destructive.
+func RegisterControlRoutes(router *mux.Router, cr ControlRouter, rep Reporter) {
 	router.
 		Methods("GET").
 		Path("/topology-api/control/ws").
 		HandlerFunc(requestContextDecorator(handleProbeWS(cr)))
+	router.
+		Methods("POST").
+		Name("api_control_batch").
+		Path("/topology-api/control/batch").
+		HandlerFunc(requestContextDecorator(handleBatchControl(cr, rep)))
 	router.
 		Methods("POST").
 		Name("api_control_probeid_nodeid_control").
 		MatcherFunc(URLMatcher("/topology-api/control/{probeID}/{nodeID}/{control}")).
-		HandlerFunc(requestContextDecorator(handleControl(cr)))
+		HandlerFunc(requestContextDecorator(handleControl(cr, rep)))
+	router.
+		Methods("POST").
+		Name("api_probes_probeid_report").
+		MatcherFunc(URLMatcher("/topology-api/probes/{probeID}/report")).
+		HandlerFunc(requestContextDecorator(handleProbeReport(cr)))
 }
 
 // handleControl routes control requests from the client to the appropriate
-// probe.  Its is blocking.
-func handleControl(cr ControlRouter) CtxHandlerFunc {
+// probe.  Its is blocking, until the control is done, the client goes away
+// or the ?timeout= given passes, whereupon the probe is asked to cancel it.
+// With ?progress=true, the progress the probe sends is streamed as lines
+// of JSON, followed by the response.  Destructive controls are refused with
+// 429 past the rate limits of the user and of the target host, and every
+// control is audited.  Responses, and their structured results, are
+// passed on as the probe sent them.
+func handleControl(cr ControlRouter, rep Reporter) CtxHandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		var (
 			vars        = mux.Vars(r)
@@ -46,12 +83,46 @@ func handleControl(cr ControlRouter) CtxHandlerFunc {
 				return
 			}
 		}
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			timeout, err := time.ParseDuration(t)
+			if err != nil || timeout <= 0 {
+				respondWith(ctx, w, http.StatusBadRequest, fmt.Sprintf("invalid timeout %q", t))
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		var stream *progressStream
+		var progress func(xfer.Progress)
+		if wantProgress, _ := strconv.ParseBool(r.URL.Query().Get("progress")); wantProgress {
+			stream = &progressStream{w: w}
+			progress = stream.progress
+		}
 
-		result, err := cr.Handle(ctx, probeID, xfer.Request{
+		user := controlUser(ctx, r)
+		target, err := findControlTarget(ctx, rep, probeID, nodeID, control)
+		if err != nil {
+			auditControl(user, probeID, nodeID, control, target, controlResultError, err.Error(), 0)
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		result, err := invokeControl(ctx, cr, user, probeID, target, xfer.Request{
 			NodeID:      nodeID,
 			Control:     control,
 			ControlArgs: controlArgs,
-		})
+		}, progress)
+		if err == errControlRateLimited {
+			respondWith(ctx, w, http.StatusTooManyRequests, fmt.Sprintf("too many destructive controls by %s or on %s; try again later", user, target.host))
+			return
+		}
+		if stream != nil && stream.finish(result, err) {
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			respondWith(ctx, w, http.StatusGatewayTimeout, fmt.Sprintf("control %s timed out", control))
+			return
+		}
 		if err != nil {
 			respondWith(ctx, w, http.StatusBadRequest, err.Error())
 			return
@@ -64,6 +135,120 @@ func handleControl(cr ControlRouter) CtxHandlerFunc {
 	}
 }
 
+// progressStream streams the progress of a control as lines of JSON, once
+// there is any, and then its response.
+type progressStream struct {
+	mtx      sync.Mutex
+	w        http.ResponseWriter
+	started  bool
+	finished bool
+}
+
+// progressLine is a line of the progress of a control.
+type progressLine struct {
+	Progress xfer.Progress `json:"progress"`
+}
+
+func (s *progressStream) progress(p xfer.Progress) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.finished {
+		return
+	}
+	if !s.started {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.w.Header().Add("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	s.write(progressLine{Progress: p})
+}
+
+// finish writes the response of the control, with err as its error if
+// any, if there was any progress, returning whether there was; the
+// response is left to the caller otherwise.
+func (s *progressStream) finish(result xfer.Response, err error) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.finished = true
+	if !s.started {
+		return false
+	}
+	if err != nil {
+		result = xfer.ResponseError(err)
+	}
+	s.write(result)
+	return true
+}
+
+func (s *progressStream) write(v interface{}) {
+	if err := json.NewEncoder(s.w).Encode(v); err != nil {
+		log.Warnf("Error streaming control progress: %v", err)
+		return
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// handleProbeReport asks a probe for a report there and then, over its
+// control connection, and responds with the report as indented JSON.  It is
+// blocking, for up to ProbeReportTimeout.
+func handleProbeReport(cr ControlRouter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		probeID := mux.Vars(r)["probeID"]
+		type handled struct {
+			result xfer.Response
+			err    error
+		}
+		timeoutCtx, cancel := context.WithTimeout(ctx, ProbeReportTimeout)
+		defer cancel()
+		done := make(chan handled, 1)
+		go func() {
+			result, err := cr.Handle(timeoutCtx, probeID, xfer.Request{
+				Control:     scopeprobe.ReportControl,
+				ControlArgs: map[string]string{scopeprobe.MaxBytesArg: strconv.Itoa(ProbeReportMaxBytes)},
+			}, nil)
+			done <- handled{result, err}
+		}()
+
+		var h handled
+		select {
+		case h = <-done:
+		case <-timeoutCtx.Done():
+			respondWith(ctx, w, http.StatusGatewayTimeout, fmt.Sprintf("probe %s didn't report within %v", probeID, ProbeReportTimeout))
+			return
+		}
+		if h.err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, h.err.Error())
+			return
+		}
+		if h.result.Error != "" {
+			respondWith(ctx, w, http.StatusBadGateway, h.result.Error)
+			return
+		}
+		rpt, ok := h.result.Value.(string)
+		if !ok {
+			respondWith(ctx, w, http.StatusBadGateway, "probe didn't respond with a report")
+			return
+		}
+		if len(rpt) > ProbeReportMaxBytes {
+			respondWith(ctx, w, http.StatusBadGateway, fmt.Sprintf("report of %d bytes is larger than %d", len(rpt), ProbeReportMaxBytes))
+			return
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(rpt), "", "  "); err != nil {
+			respondWith(ctx, w, http.StatusBadGateway, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := buf.WriteTo(w); err != nil {
+			log.Errorf("Error writing report of probe %s: %v", probeID, err)
+		}
+	}
+}
+
 // handleProbeWS accepts websocket connections from the probe and registers
 // them in the control router, such that HandleControl calls can find them.
 func handleProbeWS(cr ControlRouter) CtxHandlerFunc {
@@ -80,25 +265,99 @@ func handleProbeWS(cr ControlRouter) CtxHandlerFunc {
 			return
 		}
 		defer conn.Close()
+		defer trackWebsocket(controlWebsocket)()
 
 		codec := xfer.NewJSONWebsocketCodec(conn)
-		client := rpc.NewClientWithCodec(codec)
-		defer client.Close()
+		controls := &probeControls{progress: map[string]func(xfer.Progress){}}
+		codec.OnProgress(controls.relay)
+		controls.client = rpc.NewClientWithCodec(codec)
+		defer controls.client.Close()
 
-		id, err := cr.Register(ctx, probeID, func(req xfer.Request) xfer.Response {
-			var res xfer.Response
-			if err := client.Call("control.Handle", req, &res); err != nil {
-				return xfer.ResponseError(err)
-			}
-			return res
-		})
+		id, err := cr.Register(ctx, probeID, controls.call)
 		if err != nil {
 			respondWith(ctx, w, http.StatusBadRequest, err)
 			return
 		}
 		defer cr.Deregister(ctx, probeID, id)
+		done := make(chan struct{})
+		defer close(done)
+		go sayGoodbye(ctx, conn, done)
 		if err := codec.WaitForReadError(); err != nil && !xfer.IsExpectedWSCloseError(err) {
 			log.Errorf("Error on websocket: %v", err)
 		}
 	}
 }
+
+// probeControls calls the controls of a probe over its control connection.
+type probeControls struct {
+	client   *rpc.Client
+	mtx      sync.Mutex
+	progress map[string]func(xfer.Progress)
+}
+
+// call calls a control of the probe, relaying its progress to progress, if
+// not nil, and asking the probe to cancel it once ctx is done, whereupon
+// it responds with the error of ctx.
+func (c *probeControls) call(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+	req.ID = strconv.FormatInt(rand.Int63(), 36)
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline)
+	}
+	if progress != nil {
+		req.WantProgress = true
+		c.mtx.Lock()
+		c.progress[req.ID] = progress
+		c.mtx.Unlock()
+		defer func() {
+			c.mtx.Lock()
+			delete(c.progress, req.ID)
+			c.mtx.Unlock()
+		}()
+	}
+
+	var res xfer.Response
+	call := c.client.Go("control.Handle", req, &res, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return xfer.ResponseError(call.Error)
+		}
+		return res
+	case <-ctx.Done():
+		c.client.Go("control.Handle", xfer.Request{
+			Control:     xfer.CancelControl,
+			ControlArgs: map[string]string{xfer.RequestIDArg: req.ID},
+		}, &xfer.Response{}, make(chan *rpc.Call, 1))
+		return xfer.ResponseError(ctx.Err())
+	}
+}
+
+// relay passes progress read from the probe on to the caller of its
+// control, if still waiting for it.
+func (c *probeControls) relay(p xfer.Progress) {
+	c.mtx.Lock()
+	progress, ok := c.progress[p.RequestID]
+	c.mtx.Unlock()
+	if ok {
+		progress(p)
+	}
+}
+
+// sayGoodbye closes the control websocket of a probe as going away once ctx
+// is done, unless done first, so that the probe reconnects straight away.
+func sayGoodbye(ctx context.Context, conn xfer.Websocket, done <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+	case <-done:
+		return
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "app shutting down")
+	if err := conn.WriteMessage(websocket.CloseMessage, msg); err != nil {
+		log.Warnf("Error saying goodbye on control websocket: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(goodbyeTimeout):
+		conn.Close()
+	}
+}
@@ -1,25 +1,35 @@
 package app_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"github.com/ugorji/go/codec"
 
 	"github.com/weaveworks/scope/app"
 	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe"
 	"github.com/weaveworks/scope/probe/appclient"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
 )
 
 func TestControl(t *testing.T) {
 	router := mux.NewRouter()
-	app.RegisterControlRoutes(router, app.NewLocalControlRouter())
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), nil)
 	server := httptest.NewServer(router)
 	defer server.Close()
 
@@ -77,3 +87,335 @@ func TestControl(t *testing.T) {
 		t.Fatalf("'%s' != 'foo'", response.Value)
 	}
 }
+
+type nullPublisher struct{}
+
+func (nullPublisher) Publish(report.Report) error { return nil }
+
+func TestProbeReport(t *testing.T) {
+	router := mux.NewRouter()
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), nil)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ip, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The probe only reports when asked to
+	p := probe.New(time.Hour, time.Hour, nullPublisher{}, 1, false)
+	p.AddReporter(probe.ReporterFunc("test", func() (report.Report, error) {
+		rpt := report.MakeReport()
+		rpt.Host.AddNode(report.MakeNode("host1"))
+		return rpt, nil
+	}))
+	p.Start()
+	defer p.Stop()
+	registry := controls.NewDefaultHandlerRegistry()
+	registry.Register(probe.ReportControl, p.HandleReportControl)
+
+	url := url.URL{Scheme: "http", Host: ip + ":" + port}
+	client, err := appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "foo"}, ip+":"+port, url, xfer.ControlHandlerFunc(registry.HandleControlRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ControlConnection()
+	defer client.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	post := func(probeID string) (int, string) {
+		resp, err := http.Post(server.URL+"/topology-api/probes/"+probeID+"/report", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := post("foo")
+	if status != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, status, body)
+	}
+	if !strings.Contains(body, `"host1"`) || !strings.Contains(body, "\n  ") {
+		t.Errorf("Expected the probe's report, indented, got %s", body)
+	}
+
+	if status, _ := post("bar"); status != http.StatusBadRequest {
+		t.Errorf("Expected %d for an unknown probe, got %d", http.StatusBadRequest, status)
+	}
+
+	defer func(maxBytes int) { app.ProbeReportMaxBytes = maxBytes }(app.ProbeReportMaxBytes)
+	app.ProbeReportMaxBytes = 10
+	if status, body := post("foo"); status != http.StatusBadGateway || !strings.Contains(body, "larger than 10") {
+		t.Errorf("Expected %d for a report over the cap, got %d: %s", http.StatusBadGateway, status, body)
+	}
+}
+
+// slowControlServer serves the controls of a probe "foo" with a registry,
+// over its control connection to an app, which gets the "slow" control
+// through three steps, waiting the "delay" of its arguments at each and
+// reporting them as progress, and sends the error of any cancellation of
+// it to cancelled.
+func slowControlServer(t *testing.T, cancelled chan<- error) (*httptest.Server, func()) {
+	router := mux.NewRouter()
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), nil)
+	server := httptest.NewServer(router)
+
+	registry := controls.NewDefaultHandlerRegistry()
+	registry.RegisterContext("slow", func(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+		delay, err := time.ParseDuration(req.ControlArgs["delay"])
+		if err != nil {
+			return xfer.ResponseError(err)
+		}
+		for step := 1; step <= 3; step++ {
+			progress(xfer.Progress{Message: fmt.Sprintf("step %d", step), Fraction: float64(step) / 3})
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				cancelled <- ctx.Err()
+				return xfer.ResponseError(ctx.Err())
+			}
+		}
+		return xfer.Response{Value: "done"}
+	})
+	registry.Register("quick", func(req xfer.Request) xfer.Response {
+		return xfer.Response{Value: "quick"}
+	})
+	registry.Register("structured", func(req xfer.Request) xfer.Response {
+		return xfer.ResponseResult("test/v1", map[string]interface{}{"delay": req.ControlArgs["delay"], "steps": []int{1, 2, 3}})
+	})
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	client, err := appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "foo"}, host, url.URL{Scheme: "http", Host: host}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ControlConnection()
+	time.Sleep(100 * time.Millisecond)
+	return server, func() {
+		client.Stop()
+		server.Close()
+	}
+}
+
+func postControl(ctx context.Context, t *testing.T, url, delay string) *http.Response {
+	req, err := http.NewRequest("POST", url, strings.NewReader(fmt.Sprintf(`{"delay": %q}`, delay)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func expectCancelled(t *testing.T, cancelled <-chan error) {
+	select {
+	case err := <-cancelled:
+		if err == nil {
+			t.Error("Expected the control's context done")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the control cancelled")
+	}
+}
+
+func TestControlProgress(t *testing.T) {
+	server, stop := slowControlServer(t, make(chan error, 1))
+	defer stop()
+
+	resp := postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/slow?progress=true", "10ms")
+	defer resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	decoder := json.NewDecoder(resp.Body)
+	for step := 1; step <= 3; step++ {
+		var line struct {
+			Progress xfer.Progress `json:"progress"`
+		}
+		ok(t, decoder.Decode(&line))
+		equals(t, fmt.Sprintf("step %d", step), line.Progress.Message)
+	}
+	var response xfer.Response
+	ok(t, decoder.Decode(&response))
+	equals(t, "done", response.Value)
+
+	// Controls which don't take progress respond as they always have
+	resp = postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/quick?progress=true", "")
+	defer resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, "application/json", resp.Header.Get("Content-Type"))
+	ok(t, json.NewDecoder(resp.Body).Decode(&response))
+	equals(t, "quick", response.Value)
+}
+
+func TestControlStructuredResult(t *testing.T) {
+	server, stop := slowControlServer(t, make(chan error, 1))
+	defer stop()
+
+	resp := postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/structured", "1s")
+	defer resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	var response struct {
+		Value        interface{}     `json:"value"`
+		Result       json.RawMessage `json:"result"`
+		ResultSchema string          `json:"result_schema"`
+	}
+	ok(t, json.NewDecoder(resp.Body).Decode(&response))
+	equals(t, `{"delay":"1s","steps":[1,2,3]}`, string(response.Result))
+	equals(t, "test/v1", response.ResultSchema)
+	equals(t, nil, response.Value)
+
+	// Controls which only respond with text have no result
+	resp = postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/quick", "")
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	ok(t, err)
+	equals(t, `{"value":"quick"}`, strings.TrimSpace(string(body)))
+}
+
+func TestControlTimeout(t *testing.T) {
+	cancelled := make(chan error, 1)
+	server, stop := slowControlServer(t, cancelled)
+	defer stop()
+
+	resp := postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/slow?timeout=100ms", "1m")
+	resp.Body.Close()
+	equals(t, http.StatusGatewayTimeout, resp.StatusCode)
+	expectCancelled(t, cancelled)
+
+	resp = postControl(context.Background(), t, server.URL+"/topology-api/control/foo/nodeid/slow?timeout=soon", "1ms")
+	resp.Body.Close()
+	equals(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestControlCancel(t *testing.T) {
+	cancelled := make(chan error, 1)
+	server, stop := slowControlServer(t, cancelled)
+	defer stop()
+
+	// The control is cancelled once its client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := postControl(ctx, t, server.URL+"/topology-api/control/foo/nodeid/slow?progress=true", "1m")
+	var line map[string]interface{}
+	ok(t, json.NewDecoder(resp.Body).Decode(&line))
+	cancel()
+	resp.Body.Close()
+	expectCancelled(t, cancelled)
+}
+
+// lockedBuffer is a buffer written by the app and read by tests.
+type lockedBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func TestControlRateLimit(t *testing.T) {
+	rpt := report.MakeReport()
+	rpt.Pod.Controls.AddControls([]report.Control{
+		{ID: "delete", Human: "Delete", Destructive: true},
+		{ID: "quick", Human: "Quick"},
+	})
+	for _, pod := range []struct{ id, host string }{{"a", "host1"}, {"b", "host1"}, {"c", "host2"}} {
+		rpt.Pod.AddNode(report.MakeNodeWith(pod.id, map[string]string{
+			report.HostNodeID: report.MakeHostNodeID(pod.host),
+		}).WithTopology(report.Pod))
+	}
+
+	router := mux.NewRouter()
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), app.StaticCollector(rpt))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	registry := controls.NewDefaultHandlerRegistry()
+	for _, control := range []string{"delete", "quick"} {
+		registry.Register(control, func(req xfer.Request) xfer.Response {
+			return xfer.Response{Value: req.Control}
+		})
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	client, err := appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "foo"}, host, url.URL{Scheme: "http", Host: host}, registry)
+	ok(t, err)
+	client.ControlConnection()
+	defer client.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	var audit lockedBuffer
+	app.ControlAuditLog = log.New()
+	app.ControlAuditLog.Out = &audit
+	app.ControlAuditLog.Formatter = &log.JSONFormatter{}
+	app.ConfigureControlRateLimits(3, 2)
+	defer func() {
+		app.ControlAuditLog = log.StandardLogger()
+		app.ConfigureControlRateLimits(0, 0)
+	}()
+
+	invoke := func(nodeID, control string) int {
+		resp := postControl(context.Background(), t, server.URL+"/topology-api/control/foo/"+nodeID+"/"+control, "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Two destructive controls on host1, and it's had its lot...
+	equals(t, http.StatusOK, invoke("a", "delete"))
+	equals(t, http.StatusOK, invoke("b", "delete"))
+	equals(t, http.StatusTooManyRequests, invoke("a", "delete"))
+	// ...but controls which aren't destructive may still be invoked
+	equals(t, http.StatusOK, invoke("a", "quick"))
+	// The user has had their lot on another host after one more
+	equals(t, http.StatusOK, invoke("c", "delete"))
+	equals(t, http.StatusTooManyRequests, invoke("c", "delete"))
+	// Nodes missing from the report, and unknown controls, don't get round
+	// the limits
+	equals(t, http.StatusTooManyRequests, invoke("gone", "delete"))
+	equals(t, http.StatusTooManyRequests, invoke("a", "unknown"))
+
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(audit.String()))
+	for decoder.More() {
+		var entry map[string]interface{}
+		ok(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	equals(t, 8, len(entries))
+	for i, want := range []struct {
+		node, control, host, result string
+		destructive                 bool
+	}{
+		{"a", "delete", "host1", "ok", true},
+		{"b", "delete", "host1", "ok", true},
+		{"a", "delete", "host1", "rate_limited", true},
+		{"a", "quick", "host1", "ok", false},
+		{"c", "delete", "host2", "ok", true},
+		{"c", "delete", "host2", "rate_limited", true},
+		{"gone", "delete", "foo", "rate_limited", true},
+		{"a", "unknown", "host1", "rate_limited", true},
+	} {
+		entry := entries[i]
+		equals(t, want.node, entry["node"])
+		equals(t, want.control, entry["control"])
+		equals(t, want.host, entry["host"])
+		equals(t, want.result, entry["result"])
+		equals(t, want.destructive, entry["destructive"])
+		equals(t, "foo", entry["probe"])
+		equals(t, "127.0.0.1", entry["user"])
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// The last reports of probes which haven't published in this long are
+// forgotten, and their next deltas answered with a request for a full
+// report.
+const deltaBaseExpiry = 10 * time.Minute
+
+// errDeltaGap is returned when a delta isn't from the last report of the
+// probe we have, so it needs to publish a full report.
+type errDeltaGap struct {
+	probeID   string
+	have, got uint64
+}
+
+func (e errDeltaGap) Error() string {
+	if e.have == 0 {
+		return fmt.Sprintf("no report of probe %s to apply delta from %d to", e.probeID, e.got)
+	}
+	return fmt.Sprintf("delta of probe %s is from report %d, but the last we have is %d", e.probeID, e.got, e.have)
+}
+
+// deltaPatcher keeps the last report of each probe publishing deltas, by
+// tenant and probe ID, to patch the next one from.  Probes of different
+// tenants may share IDs, and a probe may publish to several tenants.
+type deltaPatcher struct {
+	mtx   sync.Mutex
+	bases map[deltaKey]deltaBase
+	swept time.Time
+}
+
+type deltaKey struct {
+	tenant, probeID string
+}
+
+type deltaBase struct {
+	seq      uint64
+	rpt      report.Report
+	received time.Time
+}
+
+func newDeltaPatcher() *deltaPatcher {
+	return &deltaPatcher{bases: map[deltaKey]deltaBase{}, swept: mtime.Now()}
+}
+
+// Store keeps a full report of a probe of tenant, numbered seq, for its
+// next delta.
+func (p *deltaPatcher) Store(tenant, probeID string, seq uint64, rpt report.Report) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.sweep()
+	p.bases[deltaKey{tenant, probeID}] = deltaBase{seq: seq, rpt: rpt, received: mtime.Now()}
+}
+
+// Patch applies a delta of a probe of tenant, from report base to report
+// seq, and keeps the result for its next delta.  It fails with errDeltaGap
+// if base isn't the last report of the probe it has.
+func (p *deltaPatcher) Patch(tenant, probeID string, seq, base uint64, delta report.Delta) (report.Report, error) {
+	key := deltaKey{tenant, probeID}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.sweep()
+	last, ok := p.bases[key]
+	if !ok || last.seq != base {
+		return report.Report{}, errDeltaGap{probeID: probeID, have: last.seq, got: base}
+	}
+	rpt, err := last.rpt.Patch(delta)
+	if err != nil {
+		// Whatever went wrong, the probe had better start afresh
+		delete(p.bases, key)
+		return report.Report{}, fmt.Errorf("delta of probe %s from report %d: %v", probeID, base, err)
+	}
+	p.bases[key] = deltaBase{seq: seq, rpt: rpt, received: mtime.Now()}
+	return rpt, nil
+}
+
+// sweep forgets the reports of probes which have stopped publishing.
+func (p *deltaPatcher) sweep() {
+	now := mtime.Now()
+	if now.Sub(p.swept) < deltaBaseExpiry {
+		return
+	}
+	for key, base := range p.bases {
+		if now.Sub(base.received) >= deltaBaseExpiry {
+			delete(p.bases, key)
+		}
+	}
+	p.swept = now
+}
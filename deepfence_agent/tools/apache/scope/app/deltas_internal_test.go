@@ -0,0 +1,143 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func deltaTestReports(n int) []report.Report {
+	reports := make([]report.Report, n)
+	for i := range reports {
+		reports[i] = report.MakeReport()
+		for j := 0; j <= i; j++ {
+			id := report.MakeProcessNodeID("host", string(rune('a'+j)))
+			reports[i].Process.AddNode(report.MakeNodeWith(id, map[string]string{"seen": string(rune('0' + i))}).WithTopology(report.Process))
+		}
+	}
+	return reports
+}
+
+func TestDeltaPatcherSequence(t *testing.T) {
+	var (
+		p       = newDeltaPatcher()
+		reports = deltaTestReports(5)
+	)
+	p.Store("", "probe", 1, reports[0])
+	for i := 1; i < len(reports); i++ {
+		have, err := p.Patch("", "probe", uint64(i+1), uint64(i), reports[i-1].Diff(reports[i]))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(reports[i], have) {
+			t.Fatalf("%d: expected %v, got %v", i, reports[i], have)
+		}
+	}
+}
+
+func TestDeltaPatcherGaps(t *testing.T) {
+	var (
+		p       = newDeltaPatcher()
+		reports = deltaTestReports(4)
+		delta   = func(i int) report.Delta { return reports[i-1].Diff(reports[i]) }
+		isGap   = func(err error) bool { _, ok := err.(errDeltaGap); return ok }
+	)
+
+	// Nothing to patch from for an unknown probe
+	if _, err := p.Patch("", "probe", 2, 1, delta(1)); !isGap(err) {
+		t.Errorf("Expected a gap for an unknown probe, got %v", err)
+	}
+
+	p.Store("", "probe", 1, reports[0])
+	if _, err := p.Patch("", "probe", 2, 1, delta(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Report 3 lost, so the delta from it doesn't apply
+	if _, err := p.Patch("", "probe", 4, 3, delta(3)); !isGap(err) {
+		t.Errorf("Expected a gap, got %v", err)
+	}
+	// Nor does a delta replayed
+	if _, err := p.Patch("", "probe", 2, 1, delta(1)); !isGap(err) {
+		t.Errorf("Expected a gap replaying a delta, got %v", err)
+	}
+	// Still patching from report 2, which the app has
+	if _, err := p.Patch("", "probe", 3, 2, delta(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Probes are kept apart
+	if _, err := p.Patch("", "other", 4, 3, delta(3)); !isGap(err) {
+		t.Errorf("Expected a gap for another probe, got %v", err)
+	}
+
+	// A delta which doesn't apply loses the report, until the next full one
+	if _, err := p.Patch("", "probe", 4, 3, reports[3].Diff(reports[0])); err == nil || isGap(err) {
+		t.Errorf("Expected the delta to fail, got %v", err)
+	}
+	if _, err := p.Patch("", "probe", 4, 3, delta(3)); !isGap(err) {
+		t.Errorf("Expected a gap after a failed delta, got %v", err)
+	}
+	p.Store("", "probe", 4, reports[3])
+	if _, err := p.Patch("", "probe", 5, 4, reports[3].Diff(reports[3])); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted probe numbers its reports from 1 again
+	p.Store("", "probe", 1, reports[0])
+	if _, err := p.Patch("", "probe", 2, 1, delta(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeltaPatcherExpiry(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	p := newDeltaPatcher()
+	reports := deltaTestReports(2)
+	p.Store("", "stopped", 1, reports[0])
+	p.Store("", "running", 1, reports[0])
+
+	mtime.NowForce(now.Add(deltaBaseExpiry / 2))
+	if _, err := p.Patch("", "running", 2, 1, reports[0].Diff(reports[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime.NowForce(now.Add(deltaBaseExpiry))
+	if _, err := p.Patch("", "running", 3, 2, reports[1].Diff(reports[1])); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.bases[deltaKey{"", "stopped"}]; ok {
+		t.Error("Expected the report of the stopped probe to have been forgotten")
+	}
+}
+
+func TestDeltaPatcherTenants(t *testing.T) {
+	var (
+		p       = newDeltaPatcher()
+		reports = deltaTestReports(3)
+	)
+	// Probes of two tenants sharing an ID publish different reports
+	p.Store("tenant1", "probe", 1, reports[0])
+	p.Store("tenant2", "probe", 1, reports[2])
+
+	have, err := p.Patch("tenant1", "probe", 2, 1, reports[0].Diff(reports[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reports[1], have) {
+		t.Errorf("Expected the delta patched onto the report of its tenant, got %v", have)
+	}
+	if _, err := p.Patch("tenant3", "probe", 2, 1, reports[0].Diff(reports[1])); err == nil {
+		t.Error("Expected no report to patch for a tenant without one")
+	}
+	if last := p.bases[deltaKey{"tenant2", "probe"}]; last.seq != 1 || !reflect.DeepEqual(reports[2], last.rpt) {
+		t.Errorf("Expected the report of the other tenant untouched, got %d: %v", last.seq, last.rpt)
+	}
+}
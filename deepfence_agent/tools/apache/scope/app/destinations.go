@@ -0,0 +1,495 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/render/detailed"
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	// destinationsObserveInterval is how often the destinations of the
+	// workloads of a tenant are observed, at most.
+	destinationsObserveInterval = 15 * time.Second
+
+	// forgetWorkloadsAfter is how long the destinations of workloads no
+	// longer seen are kept for.
+	forgetWorkloadsAfter = 30 * 24 * time.Hour
+)
+
+// DestinationIgnores are the external destinations never flagged as new:
+// those all of whose addresses are in Networks, and the names in Domains,
+// and their subdomains.
+type DestinationIgnores struct {
+	Networks []*net.IPNet
+	Domains  []string
+}
+
+// ParseDestinationIgnores parses a comma separated list of CIDRs, addresses
+// and domains to ignore.
+func ParseDestinationIgnores(s string) (DestinationIgnores, error) {
+	var ignores DestinationIgnores
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			ignores.Networks = append(ignores.Networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ignores.Networks = append(ignores.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		domain := strings.Trim(strings.TrimPrefix(strings.ToLower(entry), "*."), ".")
+		if domain == "" || strings.ContainsAny(domain, "/: ") {
+			return DestinationIgnores{}, fmt.Errorf("invalid destination to ignore %q", entry)
+		}
+		ignores.Domains = append(ignores.Domains, domain)
+	}
+	return ignores, nil
+}
+
+// ignored says whether the external destination of node ID id is ignored.
+// Those without known addresses are only ignored by name.
+func (ig DestinationIgnores) ignored(id string, nodes report.Nodes) bool {
+	name, named := "", false
+	if _, kind, n, ok := render.ParseExternalNodeID(id); ok && kind == render.ExternalDNS {
+		name, named = n, true
+	} else if _, hostname, ok := render.ParseServiceNodeID(id); ok {
+		name, named = hostname, true
+	}
+	if named {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		for _, domain := range ig.Domains {
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return true
+			}
+		}
+	}
+	if len(ig.Networks) == 0 {
+		return false
+	}
+	addresses := 0
+	ignored := true
+	nodes[id].Children.ForEach(func(child report.Node) {
+		_, addr, _, ok := report.ParseEndpointNodeID(child.ID)
+		ip := net.ParseIP(addr)
+		if !ok || ip == nil {
+			return
+		}
+		addresses++
+		if !ig.containsIP(ip) {
+			ignored = false
+		}
+	})
+	return addresses > 0 && ignored
+}
+
+func (ig DestinationIgnores) containsIP(ip net.IP) bool {
+	for _, network := range ig.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationName names the external destination of node ID id, if it's
+// one outgoing connections go to: the internet, a known service, a cloud
+// service, or a name.
+func destinationName(id string) (string, bool) {
+	if id == render.OutgoingInternetID {
+		return "internet", true
+	}
+	if _, hostname, ok := render.ParseServiceNodeID(id); ok {
+		return "service:" + hostname, true
+	}
+	incoming, kind, name, ok := render.ParseExternalNodeID(id)
+	if !ok || incoming || kind == render.ExternalInternal {
+		return "", false
+	}
+	return kind + ":" + name, true
+}
+
+// WorkloadDestinations are the external destinations of a workload, with
+// when each was first seen.
+type WorkloadDestinations struct {
+	FirstSeen    time.Time            `json:"first_seen"`
+	LastSeen     time.Time            `json:"last_seen"`
+	Destinations map[string]time.Time `json:"destinations"`
+}
+
+// merge merges other into w, keeping the earliest of when each was first
+// seen, and the latest of when the workload was last, returning whether w
+// changed.
+func (w *WorkloadDestinations) merge(other *WorkloadDestinations) bool {
+	changed := false
+	if other.FirstSeen.Before(w.FirstSeen) {
+		w.FirstSeen, changed = other.FirstSeen, true
+	}
+	if other.LastSeen.After(w.LastSeen) {
+		w.LastSeen, changed = other.LastSeen, true
+	}
+	for name, firstSeen := range other.Destinations {
+		if seen, ok := w.Destinations[name]; !ok || firstSeen.Before(seen) {
+			w.Destinations[name], changed = firstSeen, true
+		}
+	}
+	return changed
+}
+
+// destinationsStateKey is the key of the destinations of the workloads of
+// a tenant, in DestinationOptions.Store.
+const destinationsStateKey = "destinations.json"
+
+// DestinationOptions are the options of a DestinationTracker.
+type DestinationOptions struct {
+	// Learning is how long the destinations of a workload are learned for,
+	// from when it's first seen, before new ones are flagged.
+	Learning time.Duration
+	// FlagFor is how long new destinations are flagged for.
+	FlagFor time.Duration
+	Ignore  DestinationIgnores
+	// Store keeps the destinations learned, for the replicas of the app to
+	// share and across restarts; nil keeps them in memory.
+	Store StateStore
+}
+
+// tenantDestinations are the destinations of the workloads of a tenant,
+// and the generation of those flagged, which changes as they do.
+type tenantDestinations struct {
+	workloads    map[string]*WorkloadDestinations
+	lastObserved time.Time
+	loaded       time.Time           // from the store
+	unsaved      bool                // learned since last saved to the store
+	flagged      map[string][]string // new destinations, by workload node ID
+	generation   uint64
+}
+
+// DestinationTracker learns the external destinations of the workloads of
+// each tenant, and flags those they talk to for the first time after their
+// learning period, publishing an event for each.
+type DestinationTracker struct {
+	opts   DestinationOptions
+	events *EventStream
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantDestinations
+}
+
+// NewDestinationTracker makes a new DestinationTracker, publishing the
+// events of new destinations to events.
+func NewDestinationTracker(opts DestinationOptions, events *EventStream) *DestinationTracker {
+	return &DestinationTracker{
+		opts:    opts,
+		events:  events,
+		tenants: map[string]*tenantDestinations{},
+	}
+}
+
+func (t *DestinationTracker) tenant(tenant string) *tenantDestinations {
+	td, ok := t.tenants[tenant]
+	if !ok {
+		td = &tenantDestinations{workloads: map[string]*WorkloadDestinations{}}
+		t.tenants[tenant] = td
+	}
+	return td
+}
+
+// claim returns whether the workloads of a tenant are due to be observed,
+// every destinationsObserveInterval at most, taking them to be observed if
+// so.
+func (t *DestinationTracker) claim(tenant string, now time.Time) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	td := t.tenant(tenant)
+	if !td.lastObserved.IsZero() && now.Sub(td.lastObserved) < destinationsObserveInterval {
+		return false
+	}
+	td.lastObserved = now
+	return true
+}
+
+// load returns the destinations of the workloads of the tenant of ctx in
+// the store, if any.
+func (t *DestinationTracker) load(ctx context.Context) (map[string]*WorkloadDestinations, error) {
+	workloads := map[string]*WorkloadDestinations{}
+	if t.opts.Store == nil {
+		return workloads, nil
+	}
+	buf, err := t.opts.Store.LoadState(ctx, destinationsStateKey)
+	if err != nil || buf == nil {
+		return workloads, err
+	}
+	if err := json.Unmarshal(buf, &workloads); err != nil {
+		return nil, err
+	}
+	return workloads, nil
+}
+
+// mergeStored merges the destinations of workloads from the store into
+// those of td, as learned by other replicas of the app, or before it
+// restarted.
+func mergeStored(td *tenantDestinations, stored map[string]*WorkloadDestinations) {
+	for id, w := range stored {
+		if have, ok := td.workloads[id]; ok {
+			have.merge(w)
+		} else {
+			td.workloads[id] = w
+		}
+	}
+}
+
+// refresh recomputes the destinations of td flagged as of now, moving on
+// their generation if they changed.
+func (t *DestinationTracker) refresh(td *tenantDestinations, now time.Time) {
+	if flagged := t.flagged(td.workloads, now); !reflect.DeepEqual(flagged, td.flagged) {
+		td.flagged = flagged
+		td.generation++
+	}
+}
+
+// Observe learns the external destinations of the workloads of a tenant
+// in rpt, as of now, returning the new destinations of the workloads
+// flagged, and their generation.  Workloads are observed every
+// destinationsObserveInterval at most; those flagged last are returned in
+// between.  ctx is of the requests of the tenant, for the store.
+func (t *DestinationTracker) Observe(ctx context.Context, tenant string, rpt report.Report, now time.Time) (map[string][]string, uint64) {
+	if t.claim(tenant, now) {
+		t.observe(ctx, tenant, rpt, now)
+	}
+	return t.Flagged(ctx, tenant, now)
+}
+
+func (t *DestinationTracker) observe(ctx context.Context, tenant string, rpt report.Report, now time.Time) {
+	// Load and render without holding the lock, as they take a while.
+	// What's stored is merged before learning, so that destinations
+	// learned by other replicas of the app aren't flagged again.
+	stored, err := t.load(ctx)
+	if err != nil {
+		log.Errorf("Error loading the destinations of workloads of tenant %q: %v", tenant, err)
+	}
+	nodes := render.WorkloadRenderer.Render(ctx, rpt).Nodes
+
+	t.mtx.Lock()
+	td := t.tenant(tenant)
+	if err == nil {
+		mergeStored(td, stored)
+		td.loaded = now
+	}
+	var events []Event
+	for id, n := range nodes {
+		if n.Topology == render.Pseudo {
+			continue
+		}
+		w, ok := td.workloads[id]
+		if !ok {
+			w = &WorkloadDestinations{FirstSeen: now, Destinations: map[string]time.Time{}}
+			td.workloads[id] = w
+			td.unsaved = true
+		}
+		w.LastSeen = now
+		for _, adj := range n.Adjacency {
+			name, ok := destinationName(adj)
+			if !ok {
+				continue
+			}
+			if _, ok := w.Destinations[name]; ok || t.opts.Ignore.ignored(adj, nodes) {
+				continue
+			}
+			w.Destinations[name] = now
+			td.unsaved = true
+			if now.Sub(w.FirstSeen) >= t.opts.Learning {
+				summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+				events = append(events, Event{
+					Type:        render.NewExternalDestination,
+					Time:        now,
+					NodeID:      id,
+					Label:       summary.Label,
+					TopologyID:  workloadsID,
+					Destination: name,
+				})
+			}
+		}
+	}
+	for id, w := range td.workloads {
+		if now.Sub(w.LastSeen) > forgetWorkloadsAfter {
+			delete(td.workloads, id)
+			td.unsaved = true
+		}
+	}
+	t.refresh(td, now)
+	var buf []byte
+	if td.unsaved && t.opts.Store != nil && err == nil {
+		if buf, err = json.Marshal(td.workloads); err != nil {
+			log.Errorf("Error encoding the destinations of workloads of tenant %q: %v", tenant, err)
+		}
+	}
+	t.mtx.Unlock()
+
+	if buf != nil {
+		if err := t.opts.Store.SaveState(ctx, destinationsStateKey, buf); err != nil {
+			log.Errorf("Error saving the destinations of workloads of tenant %q: %v", tenant, err)
+		} else {
+			t.mtx.Lock()
+			td.unsaved = false
+			t.mtx.Unlock()
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].NodeID != events[j].NodeID {
+			return events[i].NodeID < events[j].NodeID
+		}
+		return events[i].Destination < events[j].Destination
+	})
+	for _, e := range events {
+		t.events.Publish(tenant, e)
+	}
+}
+
+// Flagged returns the new destinations of the workloads of a tenant
+// flagged as of now, and their generation.  Those learned by other
+// replicas of the app are loaded from the store every
+// destinationsObserveInterval at most.
+func (t *DestinationTracker) Flagged(ctx context.Context, tenant string, now time.Time) (map[string][]string, uint64) {
+	t.mtx.Lock()
+	td := t.tenant(tenant)
+	stale := t.opts.Store != nil && now.Sub(td.loaded) >= destinationsObserveInterval
+	if stale {
+		td.loaded = now
+	}
+	t.mtx.Unlock()
+
+	var stored map[string]*WorkloadDestinations
+	if stale {
+		var err error
+		if stored, err = t.load(ctx); err != nil {
+			log.Errorf("Error loading the destinations of workloads of tenant %q: %v", tenant, err)
+		}
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	mergeStored(td, stored)
+	t.refresh(td, now)
+	return td.flagged, td.generation
+}
+
+// flagged returns the destinations of workloads first seen after their
+// learning period, within FlagFor of now.
+func (t *DestinationTracker) flagged(workloads map[string]*WorkloadDestinations, now time.Time) map[string][]string {
+	result := map[string][]string{}
+	for id, w := range workloads {
+		learned := w.FirstSeen.Add(t.opts.Learning)
+		for name, firstSeen := range w.Destinations {
+			if !firstSeen.Before(learned) && now.Sub(firstSeen) <= t.opts.FlagFor {
+				result[id] = append(result[id], name)
+			}
+		}
+		sort.Strings(result[id])
+	}
+	return result
+}
+
+// Decorate returns rpt with the render.NewExternalDestination of the workloads
+// flagged set on their nodes, and its ID decorated by their generation, so
+// that its renders aren't those of rpt.  rpt is not modified.
+func (t *DestinationTracker) Decorate(rpt report.Report, flagged map[string][]string, generation uint64, now time.Time) report.Report {
+	if len(flagged) == 0 {
+		return rpt
+	}
+	rpt.ID = decoratedID(rpt.ID, "destinations", generation)
+	// The topologies of the nodes of the workloads rendered by
+	// render.WorkloadRenderer
+	for _, topology := range []*report.Topology{&rpt.Deployment, &rpt.StatefulSet, &rpt.DaemonSet, &rpt.SwarmService} {
+		var decorated []report.Node
+		for id, destinations := range flagged {
+			if n, ok := topology.Nodes[id]; ok {
+				decorated = append(decorated, n.WithLatest(render.NewExternalDestination, now, strings.Join(destinations, ",")))
+			}
+		}
+		if len(decorated) == 0 {
+			continue
+		}
+		topology.Nodes = topology.Nodes.Copy()
+		for _, n := range decorated {
+			topology.Nodes[n.ID] = n
+		}
+	}
+	return rpt
+}
+
+// DestinationsCollector is a Collector which learns the external
+// destinations of the workloads of the tenants of the reports added, as
+// Tracker does, from the reports of the Collector.
+type DestinationsCollector struct {
+	Collector
+	Tracker *DestinationTracker
+}
+
+// Add implements Adder, observing the workloads of the tenant of ctx once
+// every destinationsObserveInterval at most, in the background.
+func (c DestinationsCollector) Add(ctx context.Context, rpt report.Report, buf []byte) error {
+	if err := c.Collector.Add(ctx, rpt, buf); err != nil {
+		return err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return err
+	}
+	now := mtime.Now()
+	if !c.Tracker.claim(tenant, now) {
+		return nil
+	}
+	// Not the context of the request, which is done once it's answered
+	ctx = WithTenantID(context.Background(), tenant)
+	go func() {
+		merged, err := c.Collector.Report(ctx, now)
+		if err != nil {
+			log.Warnf("Error observing the destinations of workloads of tenant %q: %v", tenant, err)
+			return
+		}
+		c.Tracker.observe(ctx, tenant, merged, now)
+	}()
+	return nil
+}
+
+// DestinationsReporter is a Reporter whose reports have the workloads
+// talking to new external destinations flagged, as learned by Tracker.
+// Historic reports aren't flagged.
+type DestinationsReporter struct {
+	Reporter
+	Tracker *DestinationTracker
+}
+
+// Report implements Reporter
+func (r DestinationsReporter) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	rpt, err := r.Reporter.Report(ctx, timestamp)
+	if err != nil {
+		return rpt, err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return rpt, err
+	}
+	now := mtime.Now()
+	flagged, generation := r.Tracker.Flagged(ctx, tenant, now)
+	return r.Tracker.Decorate(rpt, flagged, generation, now), nil
+}
@@ -0,0 +1,213 @@
+package app_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+var webDeploymentID = report.MakeDeploymentNodeID("web-uid")
+
+// destinationsReport is the fixture with the client pod in the web
+// deployment, and its first process connecting to the addresses given, by
+// the names given, if any.
+func destinationsReport(destinations map[string]string) report.Report {
+	rpt := fixture.Report.Copy()
+	rpt.Deployment = report.MakeTopology()
+	rpt.Deployment.AddNode(report.MakeNodeWith(webDeploymentID, map[string]string{
+		report.KubernetesName:      "web",
+		report.KubernetesNamespace: fixture.KubernetesNamespace,
+	}).WithTopology(report.Deployment))
+	rpt.Pod.Nodes[fixture.ClientPodNodeID] = rpt.Pod.Nodes[fixture.ClientPodNodeID].
+		WithParent(report.Deployment, webDeploymentID)
+	rpt.DNS = report.DNSRecords{}
+	port := 55000
+	for addr, name := range destinations {
+		// An endpoint per connection, as those of several aren't
+		// attributed to processes
+		port++
+		id := report.MakeEndpointNodeID(fixture.ClientHostID, "", addr, "443")
+		rpt.Endpoint.AddNode(report.MakeNode(id).WithTopology(report.Endpoint))
+		rpt.Endpoint.AddNode(report.MakeNodeWith(report.MakeEndpointNodeID(fixture.ClientHostID, "", fixture.ClientIP, strconv.Itoa(port)), map[string]string{
+			report.PID:        fixture.Client1PID,
+			report.HostNodeID: fixture.ClientHostNodeID,
+		}).WithTopology(report.Endpoint).WithAdjacent(id))
+		if name != "" {
+			rpt.DNS[addr] = report.DNSRecord{Forward: report.MakeStringSet(name)}
+		}
+	}
+	return rpt
+}
+
+func TestParseDestinationIgnores(t *testing.T) {
+	ignores, err := app.ParseDestinationIgnores("203.0.113.0/24, 198.51.100.7,*.Example.org., ")
+	ok(t, err)
+	equals(t, []string{"203.0.113.0/24", "198.51.100.7/32"}, []string{ignores.Networks[0].String(), ignores.Networks[1].String()})
+	equals(t, []string{"example.org"}, ignores.Domains)
+
+	for _, bad := range []string{"http://example.org", "*.", "10.0.0.0/33:1"} {
+		if _, err := app.ParseDestinationIgnores(bad); err == nil {
+			t.Errorf("Expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestDestinationTracker(t *testing.T) {
+	store := app.NewCollector(time.Minute).(app.StateStore)
+	// The internet is ignored as all its addresses are: those of the fixture,
+	// and that talked to here
+	ignores, err := app.ParseDestinationIgnores("203.0.113.0/24," + fixture.GoogleIP + ",example.org")
+	ok(t, err)
+	opts := app.DestinationOptions{Learning: time.Hour, FlagFor: 24 * time.Hour, Ignore: ignores, Store: store}
+	events := app.NewEventStream(10)
+	tracker := app.NewDestinationTracker(opts, events)
+	ctx := context.Background()
+	start := time.Now()
+
+	// Destinations talked to while learning aren't flagged
+	flagged, _ := tracker.Observe(ctx, "", destinationsReport(nil), start)
+	equals(t, 0, len(flagged))
+	flagged, _ = tracker.Observe(ctx, "", destinationsReport(map[string]string{
+		"198.51.100.1": "api.github.com",
+	}), start.Add(30*time.Minute))
+	equals(t, 0, len(flagged))
+
+	// After, those new are, unless ignored by address or name
+	now := start.Add(2 * time.Hour)
+	rpt := destinationsReport(map[string]string{
+		"198.51.100.1": "api.github.com",
+		"198.51.100.2": "pastebin.com",
+		"198.51.100.3": "cdn.example.org",
+		"198.51.100.4": "files.attacker.net",
+		"203.0.113.9":  "",
+	})
+	flagged, generation := tracker.Observe(ctx, "", rpt, now)
+	want := map[string][]string{webDeploymentID: {"dns:files.attacker.net", "service:pastebin.com"}}
+	if !reflect.DeepEqual(want, flagged) {
+		t.Errorf("want %v, have %v", want, flagged)
+	}
+	decorated := tracker.Decorate(rpt, flagged, generation, now)
+	if decorated.ID == rpt.ID {
+		t.Error("Expected the decorated report to have an ID of its own")
+	}
+	value, _ := decorated.Deployment.Nodes[webDeploymentID].Latest.Lookup(render.NewExternalDestination)
+	equals(t, "dns:files.attacker.net,service:pastebin.com", value)
+	if _, ok := rpt.Deployment.Nodes[webDeploymentID].Latest.Lookup(render.NewExternalDestination); ok {
+		t.Error("Expected the report not to be modified")
+	}
+
+	// An event of it is streamed, and only to the tenant (here, the single
+	// tenant of the app)
+	router := mux.NewRouter()
+	app.RegisterEventRoutes(router, events)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/topology-api/events?since=" + start.UTC().Format(time.RFC3339))
+	ok(t, err)
+	defer resp.Body.Close()
+	equals(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	var event app.Event
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	ok(t, err)
+	ok(t, json.Unmarshal(line, &event))
+	equals(t, app.Event{
+		Type:        render.NewExternalDestination,
+		Time:        now.UTC(),
+		NodeID:      webDeploymentID,
+		Label:       "web",
+		TopologyID:  "workloads",
+		Destination: "dns:files.attacker.net",
+	}, app.Event{
+		Type:        event.Type,
+		Time:        event.Time.UTC(),
+		NodeID:      event.NodeID,
+		Label:       event.Label,
+		TopologyID:  event.TopologyID,
+		Destination: event.Destination,
+	})
+	recent, _, cancel := events.Subscribe("other-tenant")
+	cancel()
+	equals(t, 0, len(recent))
+
+	// What's learned is kept in the store of the collector, for other
+	// replicas of the app, or after a restart, and flags expire
+	tracker = app.NewDestinationTracker(opts, app.NewEventStream(10))
+	flagged, _ = tracker.Flagged(ctx, "", now.Add(time.Minute))
+	if !reflect.DeepEqual(want, flagged) {
+		t.Errorf("want %v, have %v", want, flagged)
+	}
+	flagged, _ = tracker.Flagged(ctx, "", now.Add(25*time.Hour))
+	equals(t, 0, len(flagged))
+}
+
+func TestDestinationsCollector(t *testing.T) {
+	collector := app.NewCollector(time.Minute)
+	store := collector.(app.StateStore)
+	opts := app.DestinationOptions{FlagFor: time.Hour, Store: store}
+	ctx := context.Background()
+
+	// Destinations are learned as reports are added, without their reports
+	// being asked for, and kept in the store of the collector...
+	rpt := destinationsReport(map[string]string{"198.51.100.4": "files.attacker.net"})
+	ok(t, app.DestinationsCollector{Collector: collector, Tracker: app.NewDestinationTracker(opts, app.NewEventStream(10))}.Add(ctx, rpt, nil))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		buf, err := store.LoadState(ctx, "destinations.json")
+		ok(t, err)
+		if buf != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the destinations learned to be saved")
+		}
+	}
+
+	// ...so that the replicas rendering reports flag them
+	reporter := app.DestinationsReporter{Reporter: collector, Tracker: app.NewDestinationTracker(opts, app.NewEventStream(10))}
+	decorated, err := reporter.Report(ctx, time.Now())
+	ok(t, err)
+	value, _ := decorated.Deployment.Nodes[webDeploymentID].Latest.Lookup(render.NewExternalDestination)
+	equals(t, "dns:files.attacker.net", value)
+}
+
+func TestFileStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	store, err := app.NewFileStateStore(dir)
+	ok(t, err)
+	opts := app.DestinationOptions{FlagFor: time.Hour, Store: store}
+	ctx := context.Background()
+	now := time.Now()
+
+	rpt := destinationsReport(map[string]string{"198.51.100.4": "files.attacker.net"})
+	want, _ := app.NewDestinationTracker(opts, app.NewEventStream(10)).Observe(ctx, "", rpt, now)
+	equals(t, map[string][]string{webDeploymentID: {"dns:files.attacker.net"}}, want)
+
+	// What's learned outlives the app, as a tracker on a store of the same
+	// directory has it
+	store, err = app.NewFileStateStore(dir)
+	ok(t, err)
+	opts.Store = store
+	flagged, _ := app.NewDestinationTracker(opts, app.NewEventStream(10)).Flagged(ctx, "", now.Add(time.Minute))
+	equals(t, want, flagged)
+
+	// Other keys have no state yet
+	buf, err := store.LoadState(ctx, "other.json")
+	ok(t, err)
+	equals(t, 0, len(buf))
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/render/detailed"
+)
+
+// WebsocketSendDeadline - set at runtime, how long a write to a topology
+// websocket may block before the connection is closed.
+var WebsocketSendDeadline = 30 * time.Second
+
+// diffSender sends the diffs of topologies to a websocket, from a goroutine
+// of its own so that slow clients don't hold up rendering.  Topologies sent
+// while a diff is being written replace each other, and the next diff is
+// from the topology last written to the latest, so intermediate topologies
+// are dropped but the client always ends up with the latest.
+type diffSender struct {
+	conn     xfer.Websocket
+	kind     string
+	deadline time.Duration
+
+	mtx          sync.Mutex
+	pending      detailed.NodeSummaries
+	hasPending   bool
+	writingSince time.Time // zero unless writing
+	err          error
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newDiffSender(conn xfer.Websocket, kind string, deadline time.Duration) *diffSender {
+	s := &diffSender{
+		conn:     conn,
+		kind:     kind,
+		deadline: deadline,
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+// Send queues a topology to be sent.  It fails once a write has failed, or
+// has been blocked for longer than the deadline, when the connection is
+// closed.
+func (s *diffSender) Send(topo detailed.NodeSummaries) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.writingSince.IsZero() && mtime.Now().Sub(s.writingSince) > s.deadline {
+		s.err = fmt.Errorf("websocket blocked for over %v", s.deadline)
+		websocketSlowCloses.WithLabelValues(s.kind).Inc()
+		s.conn.Close()
+		return s.err
+	}
+	s.pending, s.hasPending = topo, true
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stop stops sending.  A write in progress isn't waited for; it fails once
+// the connection is closed.
+func (s *diffSender) Stop() {
+	close(s.quit)
+}
+
+func (s *diffSender) loop() {
+	defer close(s.done)
+	var sent detailed.NodeSummaries // as last written to the client
+	for {
+		select {
+		case <-s.wake:
+		case <-s.quit:
+			return
+		}
+
+		s.mtx.Lock()
+		topo, ok := s.pending, s.hasPending
+		s.pending, s.hasPending = nil, false
+		if ok {
+			s.writingSince = mtime.Now()
+		}
+		s.mtx.Unlock()
+		if !ok {
+			continue
+		}
+
+		err := s.conn.WriteJSON(detailed.TopoDiff(sent, topo))
+		s.mtx.Lock()
+		s.writingSince = time.Time{}
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		s.mtx.Unlock()
+		if err != nil {
+			return
+		}
+		sent = topo
+	}
+}
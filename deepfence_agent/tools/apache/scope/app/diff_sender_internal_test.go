@@ -0,0 +1,147 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/render/detailed"
+)
+
+// blockingWebsocket records the diffs written to it, each write blocking
+// until released.
+type blockingWebsocket struct {
+	mtx     sync.Mutex
+	diffs   []detailed.Diff
+	release chan struct{}
+	closed  chan struct{}
+	writing chan struct{}
+}
+
+func newBlockingWebsocket() *blockingWebsocket {
+	return &blockingWebsocket{
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+		writing: make(chan struct{}, 100),
+	}
+}
+
+func (b *blockingWebsocket) WriteJSON(v interface{}) error {
+	b.writing <- struct{}{}
+	select {
+	case <-b.release:
+	case <-b.closed:
+		return io.ErrClosedPipe
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.diffs = append(b.diffs, v.(detailed.Diff))
+	return nil
+}
+
+func (b *blockingWebsocket) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func (b *blockingWebsocket) ReadMessage() (int, []byte, error) { return 0, nil, io.EOF }
+func (b *blockingWebsocket) WriteMessage(int, []byte) error    { return nil }
+func (b *blockingWebsocket) ReadJSON(interface{}) error        { return io.EOF }
+
+func (b *blockingWebsocket) written() (diffs []detailed.Diff) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return append(diffs, b.diffs...)
+}
+
+func topologyOf(labels ...string) detailed.NodeSummaries {
+	topo := detailed.NodeSummaries{}
+	for i, label := range labels {
+		id := fmt.Sprintf("node%d", i)
+		topo[id] = detailed.NodeSummary{BasicNodeSummary: detailed.BasicNodeSummary{ID: id, Label: label}}
+	}
+	return topo
+}
+
+func TestDiffSenderCoalesces(t *testing.T) {
+	conn := newBlockingWebsocket()
+	s := newDiffSender(conn, "test", time.Hour)
+	defer s.Stop()
+
+	// The first topology is being written while the others arrive; only
+	// the last of them is sent after it
+	if err := s.Send(topologyOf("a")); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.writing
+	for _, topo := range []detailed.NodeSummaries{topologyOf("b", "b"), topologyOf("c", "c", "c"), topologyOf("d")} {
+		if err := s.Send(topo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.release <- struct{}{}
+	<-conn.writing
+	conn.release <- struct{}{}
+
+	var diffs []detailed.Diff
+	for i := 0; i < 100; i++ {
+		if diffs = conn.written(); len(diffs) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("Expected 2 diffs, got %v", diffs)
+	}
+	client := detailed.NodeSummaries{}
+	for _, diff := range diffs {
+		if diff.Reset {
+			client = detailed.NodeSummaries{}
+		}
+		for _, n := range append(diff.Add, diff.Update...) {
+			client[n.ID] = n
+		}
+		for _, id := range diff.Remove {
+			delete(client, id)
+		}
+	}
+	if want := topologyOf("d"); !reflect.DeepEqual(want, client) {
+		t.Errorf("Expected the client to end up with %v, got %v", want, client)
+	}
+}
+
+func TestDiffSenderDeadline(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	conn := newBlockingWebsocket()
+	s := newDiffSender(conn, "test", time.Minute)
+	defer s.Stop()
+	if err := s.Send(topologyOf("a")); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.writing
+
+	mtime.NowForce(now.Add(30 * time.Second))
+	if err := s.Send(topologyOf("b")); err != nil {
+		t.Fatalf("Expected no error within the deadline, got %v", err)
+	}
+	mtime.NowForce(now.Add(2 * time.Minute))
+	if err := s.Send(topologyOf("c")); err == nil {
+		t.Fatal("Expected an error past the deadline")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("Expected the connection to be closed")
+	}
+	if err := s.Send(topologyOf("d")); err == nil {
+		t.Error("Expected errors once closed")
+	}
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Drainer is middleware draining the app before it exits, so that rolling
+// restarts don't lose reports.  Once draining, publishes are refused with 503
+// and a Retry-After, for probes to publish them again, usually to another
+// replica; reports being added are waited for; and control websockets are
+// closed with a goodbye, for probes to reconnect straight away.
+type Drainer struct {
+	retryAfter time.Duration
+
+	mtx       sync.Mutex
+	draining  bool
+	publishes sync.WaitGroup
+	goodbye   chan struct{}
+}
+
+// NewDrainer creates a Drainer asking probes to publish again after
+// retryAfter, rounded up to whole seconds.
+func NewDrainer(retryAfter time.Duration) *Drainer {
+	return &Drainer{
+		retryAfter: retryAfter,
+		goodbye:    make(chan struct{}),
+	}
+}
+
+// Wrap implements middleware.Interface.
+func (d *Drainer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/topology-api/report" && r.Method == "POST":
+			if !d.startPublish() {
+				seconds := (d.retryAfter + time.Second - 1) / time.Second
+				w.Header().Set("Retry-After", fmt.Sprint(int64(seconds)))
+				http.Error(w, "App shutting down, publish again", http.StatusServiceUnavailable)
+				return
+			}
+			defer d.publishes.Done()
+		case r.URL.Path == "/topology-api/control/ws":
+			select {
+			case <-d.goodbye:
+				http.Error(w, "App shutting down", http.StatusServiceUnavailable)
+				return
+			default:
+			}
+			// Control websockets say goodbye once their context is done
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-d.goodbye:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Drainer) startPublish() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.draining {
+		return false
+	}
+	d.publishes.Add(1)
+	return true
+}
+
+// Drain starts draining, and waits for the reports being added until ctx is
+// done.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mtx.Lock()
+	if !d.draining {
+		d.draining = true
+		close(d.goodbye)
+	}
+	d.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.publishes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
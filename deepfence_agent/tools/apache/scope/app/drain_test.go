@@ -0,0 +1,181 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// slowAdder takes a while to add reports, so that some are being added when
+// the app drains.
+type slowAdder struct {
+	app.Adder
+	delay time.Duration
+}
+
+func (a slowAdder) Add(ctx context.Context, rpt report.Report, buf []byte) error {
+	time.Sleep(a.delay)
+	return a.Adder.Add(ctx, rpt, buf)
+}
+
+type replica struct {
+	collector app.Collector
+	drainer   *app.Drainer
+	handler   http.Handler
+}
+
+func newReplica(delay time.Duration) replica {
+	c := app.NewCollector(time.Minute)
+	router := mux.NewRouter()
+	app.RegisterReportPostHandler(slowAdder{c, delay}, router, nil)
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), nil)
+	d := app.NewDrainer(0)
+	return replica{collector: c, drainer: d, handler: d.Wrap(router)}
+}
+
+func (r replica) hosts(t *testing.T) map[string]bool {
+	rpt, err := r.collector.Report(context.Background(), time.Now())
+	ok(t, err)
+	hosts := map[string]bool{}
+	for id := range rpt.Host.Nodes {
+		hosts[id] = true
+	}
+	return hosts
+}
+
+// publishUntil publishes a report of another host at a time, as a probe
+// would through a load balancer, until stop is closed, publishing again
+// those refused with a Retry-After.  It returns the hosts published.
+func publishUntil(url string, stop <-chan struct{}, published *int32) (map[string]bool, error) {
+	hosts := map[string]bool{}
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return hosts, nil
+		default:
+		}
+		id := report.MakeHostNodeID(fmt.Sprintf("host%d", i))
+		rpt := report.MakeReport()
+		rpt.Host.AddNode(report.MakeNode(id))
+		for {
+			buf, err := rpt.WriteProtobuf()
+			if err != nil {
+				return nil, err
+			}
+			req, err := http.NewRequest("POST", url+"/topology-api/report", buf)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", xfer.ProtobufContentType)
+			req.Header.Set("Content-Encoding", "gzip")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				break
+			}
+			seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+			if res.StatusCode != http.StatusServiceUnavailable || err != nil {
+				return nil, fmt.Errorf("publish refused with %s", res.Status)
+			}
+			time.Sleep(time.Duration(seconds)*time.Second + 5*time.Millisecond)
+		}
+		hosts[id] = true
+		atomic.AddInt32(published, 1)
+	}
+}
+
+func TestDrainRestart(t *testing.T) {
+	old, replacement := newReplica(10*time.Millisecond), newReplica(0)
+	var (
+		mtx     sync.Mutex
+		current = old.handler
+	)
+	lb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		handler := current
+		mtx.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer lb.Close()
+	oldServer := httptest.NewServer(old.handler)
+	defer oldServer.Close()
+
+	// A probe's control websocket is told the app is going away
+	wsURL := "ws" + strings.TrimPrefix(oldServer.URL, "http") + "/topology-api/control/ws"
+	header := http.Header{xfer.ScopeProbeIDHeader: []string{"probe1"}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	ok(t, err)
+	defer conn.Close()
+
+	var published int32
+	stop := make(chan struct{})
+	var (
+		hosts      map[string]bool
+		publishErr error
+		done       = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		hosts, publishErr = publishUntil(lb.URL, stop, &published)
+	}()
+	waitPublished := func(n int32) {
+		for i := 0; atomic.LoadInt32(&published) < n; i++ {
+			select {
+			case <-done:
+				t.Fatal(publishErr)
+			default:
+			}
+			if i == 500 {
+				t.Fatalf("Only %d reports published", atomic.LoadInt32(&published))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Restart: the old replica drains before exiting, and the load
+	// balancer moves on to the replacement
+	waitPublished(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ok(t, old.drainer.Drain(ctx))
+	mtx.Lock()
+	current = replacement.handler
+	mtx.Unlock()
+
+	waitPublished(atomic.LoadInt32(&published) + 10)
+	close(stop)
+	<-done
+	ok(t, publishErr)
+
+	added := old.hosts(t)
+	for id := range replacement.hosts(t) {
+		added[id] = true
+	}
+	equals(t, hosts, added)
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a goodbye, got %v", err)
+	}
+	_, res, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err == nil || res == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected control websockets refused once draining, got %v", err)
+	}
+}
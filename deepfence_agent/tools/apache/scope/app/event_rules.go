@@ -0,0 +1,324 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/render/detailed"
+	"github.com/weaveworks/scope/report"
+)
+
+// The built-in rules of events of changes to the topologies, each
+// publishing events of its name as their type.
+const (
+	// CriticalImageRule is of containers starting to run images with
+	// critical vulnerabilities, or images of running containers being
+	// found to have some.
+	CriticalImageRule = "critical_image"
+	// PrivilegedHostRule is of hosts starting to run privileged containers.
+	PrivilegedHostRule = "privileged_host"
+	// SilentProbeRule is of probes going silent.
+	SilentProbeRule = "silent_probe"
+)
+
+// EventRules are the names of the built-in rules.
+var EventRules = []string{CriticalImageRule, PrivilegedHostRule, SilentProbeRule}
+
+// eventRulesInterval is how often the rules are evaluated over the
+// topologies of a tenant, at most.
+const eventRulesInterval = 15 * time.Second
+
+// ParseEventRules parses a comma separated list of the names of rules.
+func ParseEventRules(s string) ([]string, error) {
+	var rules []string
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if !isEventRule(rule) {
+			return nil, fmt.Errorf("unknown rule %q, not one of %s", rule, strings.Join(EventRules, ", "))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func isEventRule(rule string) bool {
+	for _, r := range EventRules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantMatches are the events of what each rule matched when the
+// topologies of a tenant were last evaluated, by what they're of.
+type tenantMatches struct {
+	lastEvaluated time.Time
+	matches       map[string]map[string]Event // by rule, then key
+}
+
+// RuleEvaluator evaluates the rules enabled over successive topologies of
+// each tenant, publishing an event when a rule matches something it
+// didn't match the time before.  Nothing is published for what's matched
+// the first time the topologies of a tenant are evaluated, so that
+// restarting the app doesn't publish again what was.  The current
+// topologies of the tenants are evaluated by Watch.
+type RuleEvaluator struct {
+	rules  []string
+	probes ProbeRegistry
+	events *EventStream
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantMatches
+}
+
+// NewRuleEvaluator makes a new RuleEvaluator of the rules given, publishing
+// their events to events.  The statuses of probes in probes are what
+// SilentProbeRule is evaluated over.
+func NewRuleEvaluator(rules []string, probes ProbeRegistry, events *EventStream) *RuleEvaluator {
+	return &RuleEvaluator{
+		rules:   rules,
+		probes:  probes,
+		events:  events,
+		tenants: map[string]*tenantMatches{},
+	}
+}
+
+// Evaluate evaluates the rules over the topologies of a tenant in rpt, as
+// of now.  Tenants are evaluated every eventRulesInterval at most.
+func (e *RuleEvaluator) Evaluate(ctx context.Context, tenant string, rpt report.Report, now time.Time) error {
+	e.mtx.Lock()
+	tm, ok := e.tenants[tenant]
+	if ok && now.Sub(tm.lastEvaluated) < eventRulesInterval {
+		e.mtx.Unlock()
+		return nil
+	}
+	first := !ok
+	if first {
+		tm = &tenantMatches{}
+		e.tenants[tenant] = tm
+	}
+	tm.lastEvaluated = now
+	e.mtx.Unlock()
+
+	// Evaluate without holding the lock, as rendering takes a while
+	matches := map[string]map[string]Event{}
+	for _, rule := range e.rules {
+		var err error
+		switch rule {
+		case CriticalImageRule:
+			matches[rule] = criticalImages(ctx, rpt, now)
+		case PrivilegedHostRule:
+			matches[rule] = privilegedHosts(ctx, rpt, now)
+		case SilentProbeRule:
+			matches[rule], err = e.silentProbes(ctx, now)
+		}
+		if err != nil {
+			if first {
+				// So the next evaluation isn't taken for not the first
+				e.mtx.Lock()
+				delete(e.tenants, tenant)
+				e.mtx.Unlock()
+			}
+			return err
+		}
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	var events []Event
+	if !first {
+		for rule, matched := range matches {
+			for key, event := range matched {
+				if _, ok := tm.matches[rule][key]; !ok {
+					events = append(events, event)
+				}
+			}
+		}
+	}
+	tm.matches = matches
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Type != events[j].Type {
+			return events[i].Type < events[j].Type
+		}
+		return events[i].NodeID < events[j].NodeID
+	})
+	for _, event := range events {
+		e.events.Publish(tenant, event)
+	}
+	return nil
+}
+
+// containerHost returns the node ID and name of the host of a container.
+func containerHost(n report.Node) (string, string) {
+	if hostNodeIDs, ok := n.Parents.Lookup(report.Host); ok && len(hostNodeIDs) > 0 {
+		hostName, _ := report.ParseHostNodeID(hostNodeIDs[0])
+		return hostNodeIDs[0], hostName
+	}
+	hostNodeID, _ := n.Latest.Lookup(report.HostNodeID)
+	hostName, _ := report.ParseHostNodeID(hostNodeID)
+	return hostNodeID, hostName
+}
+
+// criticalImages matches the running containers whose images have critical
+// vulnerabilities, by container and image.
+func criticalImages(ctx context.Context, rpt report.Report, now time.Time) map[string]Event {
+	matched := map[string]Event{}
+	for id, n := range render.ContainerWithImageNameRenderer.Render(ctx, rpt).Nodes {
+		if n.Topology != report.Container {
+			continue
+		}
+		if state, _ := n.Latest.Lookup(report.DockerContainerState); state != report.StateRunning {
+			continue
+		}
+		if render.WorstSeverity(n) != render.SeverityCritical {
+			continue
+		}
+		image, _ := n.Latest.Lookup(report.DockerImageName)
+		if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && image != "" {
+			image += ":" + tag
+		}
+		critical := 0
+		scan, _ := n.LookupScanStatus(report.VulnerabilityScan)
+		for severity, count := range scan.Counts {
+			if strings.ToLower(severity) == render.SeverityCritical {
+				critical += count
+			}
+		}
+		_, hostName := containerHost(n)
+		summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+		matched[id+"/"+image] = Event{
+			Type:       CriticalImageRule,
+			Time:       now,
+			NodeID:     id,
+			Label:      summary.Label,
+			TopologyID: containersID,
+			Details: map[string]string{
+				"image":    image,
+				"host":     hostName,
+				"critical": strconv.Itoa(critical),
+			},
+		}
+	}
+	return matched
+}
+
+// privilegedHosts matches the hosts running privileged containers, by host.
+func privilegedHosts(ctx context.Context, rpt report.Report, now time.Time) map[string]Event {
+	containers := map[string][]string{} // names, by host node ID
+	hostNames := map[string]string{}
+	for _, n := range render.ContainerRenderer.Render(ctx, rpt).Nodes {
+		if n.Topology != report.Container {
+			continue
+		}
+		if state, _ := n.Latest.Lookup(report.DockerContainerState); state != report.StateRunning {
+			continue
+		}
+		if privileged, _ := n.Latest.Lookup(report.DockerPrivileged); privileged != "true" {
+			continue
+		}
+		hostNodeID, hostName := containerHost(n)
+		summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+		containers[hostNodeID] = append(containers[hostNodeID], summary.Label)
+		hostNames[hostNodeID] = hostName
+	}
+	matched := map[string]Event{}
+	for hostNodeID, names := range containers {
+		sort.Strings(names)
+		matched[hostNodeID] = Event{
+			Type:       PrivilegedHostRule,
+			Time:       now,
+			NodeID:     hostNodeID,
+			Label:      hostNames[hostNodeID],
+			TopologyID: hostsID,
+			Details:    map[string]string{"containers": strings.Join(names, ",")},
+		}
+	}
+	return matched
+}
+
+// silentProbes matches the probes gone stale, by probe ID.
+func (e *RuleEvaluator) silentProbes(ctx context.Context, now time.Time) (map[string]Event, error) {
+	statuses, err := e.probes.Probes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matched := map[string]Event{}
+	for id, status := range statuses {
+		if !status.IsStale(now) {
+			continue
+		}
+		matched[id] = Event{
+			Type:       SilentProbeRule,
+			Time:       now,
+			NodeID:     report.MakeHostNodeID(status.Hostname),
+			Label:      status.Hostname,
+			TopologyID: hostsID,
+			Details: map[string]string{
+				"probe_id":  id,
+				"last_seen": status.LastSeen.UTC().Format(time.RFC3339),
+			},
+		}
+	}
+	return matched, nil
+}
+
+// Watch evaluates the rules over the current reports of rep every
+// eventRulesInterval, until ctx is done, so that events are published
+// whether or not anyone is looking.  Only here are they evaluated, as
+// requests may be of any time.
+func (e *RuleEvaluator) Watch(ctx context.Context, rep Reporter) {
+	ticker := time.NewTicker(eventRulesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.evaluateTenants(ctx, rep)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluateTenants evaluates the rules over the current reports of rep of
+// each tenant the probe registry knows of, if it's a TenantLister, or else
+// of the tenant of ctx.
+func (e *RuleEvaluator) evaluateTenants(ctx context.Context, rep Reporter) {
+	var tenants []string
+	if lister, ok := e.probes.(TenantLister); ok {
+		var err error
+		if tenants, err = lister.Tenants(ctx); err != nil {
+			log.Warnf("Error listing the tenants to evaluate the rules of events of: %v", err)
+			return
+		}
+	} else {
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			log.Warnf("Error evaluating the rules of events: %v", err)
+			return
+		}
+		tenants = []string{tenant}
+	}
+	for _, tenant := range tenants {
+		tenantCtx := WithTenantID(ctx, tenant)
+		now := mtime.Now()
+		rpt, err := rep.Report(tenantCtx, now)
+		if err == nil {
+			err = e.Evaluate(tenantCtx, tenant, rpt, now)
+		}
+		if err != nil {
+			log.Warnf("Error evaluating the rules of events of tenant %q: %v", tenant, err)
+		}
+	}
+}
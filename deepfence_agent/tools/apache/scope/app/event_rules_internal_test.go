@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// listedProbes is a ProbeRegistry knowing the tenants with probes.
+type listedProbes struct {
+	ProbeRegistry
+	tenants []string
+}
+
+func (p listedProbes) Tenants(context.Context) ([]string, error) {
+	return p.tenants, nil
+}
+
+func TestRuleEvaluatorTenants(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	ctx := context.Background()
+	local := NewLocalProbeRegistry(time.Hour)
+	if err := local.UpdateProbe(ctx, "probe-1", func(s ProbeStatus) ProbeStatus {
+		return s.Reported("host1", "1.0", now)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventStream(10)
+	e := NewRuleEvaluator([]string{SilentProbeRule}, listedProbes{local, []string{"tenant"}}, events)
+	rep := StaticCollector(report.MakeReport())
+
+	// The tenants are evaluated with no one asking for their topologies,
+	// so probes going silent are noticed
+	e.evaluateTenants(ctx, rep)
+	mtime.NowForce(now.Add(time.Hour))
+	e.evaluateTenants(ctx, rep)
+
+	recent, _, cancel := events.Subscribe("tenant")
+	defer cancel()
+	if len(recent) != 1 || recent[0].Type != SilentProbeRule || recent[0].Details["probe_id"] != "probe-1" {
+		t.Errorf("Expected the probe to go silent, got %+v", recent)
+	}
+}
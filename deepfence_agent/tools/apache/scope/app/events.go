@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// eventBuffer is how many events a subscriber may fall behind by before
+// events are dropped for it.
+const eventBuffer = 100
+
+// Event is something of note seen in the topologies of a tenant, as
+// streamed by the /topology-api/events handler.
+type Event struct {
+	Type        string            `json:"type"`
+	Time        time.Time         `json:"time"`
+	NodeID      string            `json:"node_id"`
+	Label       string            `json:"label,omitempty"`
+	TopologyID  string            `json:"topology_id"`
+	Destination string            `json:"destination,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// EventSink is sent the events of all tenants as they are published.  Send
+// must not block.
+type EventSink interface {
+	Send(tenant string, e Event)
+}
+
+// EventStream keeps the recent events of each tenant, and passes events on
+// to the subscribers of their tenant, and to the sinks, as they are
+// published.
+type EventStream struct {
+	size int
+
+	mtx         sync.Mutex
+	recent      map[string][]Event
+	subscribers map[string]map[chan Event]struct{}
+	sinks       []EventSink
+}
+
+// NewEventStream makes a new EventStream, keeping the last size events of
+// each tenant.
+func NewEventStream(size int) *EventStream {
+	return &EventStream{
+		size:        size,
+		recent:      map[string][]Event{},
+		subscribers: map[string]map[chan Event]struct{}{},
+	}
+}
+
+// Publish publishes an event of a tenant.  Subscribers too far behind miss
+// it.
+func (s *EventStream) Publish(tenant string, e Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	recent := append(s.recent[tenant], e)
+	if len(recent) > s.size {
+		recent = recent[len(recent)-s.size:]
+	}
+	s.recent[tenant] = recent
+	for c := range s.subscribers[tenant] {
+		select {
+		case c <- e:
+		default:
+			log.Warnf("Dropping %s event of %s for a slow subscriber", e.Type, e.NodeID)
+		}
+	}
+	for _, sink := range s.sinks {
+		sink.Send(tenant, e)
+	}
+}
+
+// AddSink adds a sink to send the events published after to.
+func (s *EventStream) AddSink(sink EventSink) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sinks = append(s.sinks, sink)
+}
+
+// Subscribe returns the recent events of a tenant, and a channel of the
+// events published after, until cancel is called.
+func (s *EventStream) Subscribe(tenant string) (recent []Event, events <-chan Event, cancel func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c := make(chan Event, eventBuffer)
+	if s.subscribers[tenant] == nil {
+		s.subscribers[tenant] = map[chan Event]struct{}{}
+	}
+	s.subscribers[tenant][c] = struct{}{}
+	recent = append([]Event(nil), s.recent[tenant]...)
+	return recent, c, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		delete(s.subscribers[tenant], c)
+		if len(s.subscribers[tenant]) == 0 {
+			delete(s.subscribers, tenant)
+		}
+	}
+}
+
+// RegisterEventRoutes registers the handler streaming the events of the
+// tenant of the request as lines of JSON: the recent ones since ?since, if
+// given, and then each as it's published.
+func RegisterEventRoutes(router *mux.Router, events *EventStream) {
+	get := router.Methods("GET").Subrouter()
+	get.HandleFunc("/topology-api/events", requestContextDecorator(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		since, err := parseTimestampParam(r, "since", time.Time{})
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		recent, stream, cancel := events.Subscribe(tenant)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Add("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		write := func(e Event) bool {
+			if err := encoder.Encode(e); err != nil {
+				return false
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return true
+		}
+		for _, e := range recent {
+			if !e.Time.Before(since) && !write(e) {
+				return
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		for {
+			select {
+			case e := <-stream:
+				if !write(e) {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+}
@@ -0,0 +1,472 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/render/detailed"
+	"github.com/weaveworks/scope/report"
+)
+
+// Formats of the exports of topologies and of the inventory.
+const (
+	formatDOT     = "dot"
+	formatGraphML = "graphml"
+	formatJSON    = "json"
+	formatCSV     = "csv"
+)
+
+// severityColors are the colours nodes of each severity are filled with in
+// exported graphs.
+var severityColors = map[string]string{
+	render.SeverityCritical:  "#d0021b",
+	render.SeverityHigh:      "#f5a623",
+	render.SeverityMedium:    "#f8e71c",
+	render.SeverityLow:       "#4a90e2",
+	render.SeverityNone:      "#7ed321",
+	render.SeverityUnscanned: "#9b9b9b",
+}
+
+// graphAttributes are the attributes of the nodes of exported graphs, in
+// the order they're written in.
+var graphAttributes = []string{"label", "topology", "severity", "color", render.ExposureScore}
+
+// exportWriter writes to w until a write fails, as when the client goes
+// away, and then nothing more.
+type exportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *exportWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+// imageNode returns the node of the image of a container, if reported.
+func imageNode(rpt report.Report, container report.Node) (report.Node, bool) {
+	imageID, _ := container.Latest.Lookup(report.DockerImageID)
+	image, ok := rpt.ContainerImage.Nodes[report.MakeContainerImageNodeID(imageID)]
+	return image, ok
+}
+
+// severityOf returns the worst severity of the vulnerabilities of a
+// container or image, or those scanned for them, and whether it has one.
+// Containers not scanned themselves take those of their images.
+func severityOf(rpt report.Report, n report.Node) (string, bool) {
+	if _, ok := n.LookupScanStatus(report.VulnerabilityScan); ok {
+		return render.WorstSeverity(n), true
+	}
+	switch n.Topology {
+	case report.Container:
+		if image, ok := imageNode(rpt, n); ok {
+			return render.WorstSeverity(image), true
+		}
+		return render.SeverityUnscanned, true
+	case report.ContainerImage:
+		return render.SeverityUnscanned, true
+	}
+	return "", false
+}
+
+// graphNodeAttributes returns the attributes of a node in exported graphs:
+// its label and topology, the worst severity of its vulnerabilities and
+// the colour of it, and its exposure score, if scored.
+func graphNodeAttributes(rpt report.Report, n report.Node) map[string]string {
+	summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+	attrs := map[string]string{
+		"label":    summary.Label,
+		"topology": n.Topology,
+	}
+	if severity, ok := severityOf(rpt, n); ok {
+		attrs["severity"], attrs["color"] = severity, severityColors[severity]
+	}
+	if score, ok := n.Latest.Lookup(render.ExposureScore); ok {
+		attrs[render.ExposureScore] = score
+	}
+	return attrs
+}
+
+// dotQuote quotes s as a DOT ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// xmlEscape escapes s as XML text, or the value of an attribute.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// writeDOT writes nodes, in order of ids, and their edges as a DOT digraph,
+// with the nodes filled with the colours of their severities.
+func writeDOT(w *exportWriter, rpt report.Report, topologyID string, ids []string, nodes report.Nodes) {
+	w.printf("digraph %s {\n", dotQuote(topologyID))
+	for _, id := range ids {
+		attrs := graphNodeAttributes(rpt, nodes[id])
+		w.printf("\t%s [", dotQuote(id))
+		for i, name := range graphAttributes {
+			if value, ok := attrs[name]; ok {
+				if i > 0 {
+					w.printf(" ")
+				}
+				w.printf("%s=%s", name, dotQuote(value))
+			}
+		}
+		if _, ok := attrs["color"]; ok {
+			w.printf(" fillcolor=%s style=\"filled\"", dotQuote(attrs["color"]))
+		}
+		w.printf("];\n")
+	}
+	for _, id := range ids {
+		for _, adj := range nodes[id].Adjacency {
+			if _, ok := nodes[adj]; ok {
+				w.printf("\t%s -> %s;\n", dotQuote(id), dotQuote(adj))
+			}
+		}
+	}
+	w.printf("}\n")
+}
+
+// writeGraphML writes nodes, in order of ids, and their edges as a GraphML
+// graph, with a key per attribute.
+func writeGraphML(w *exportWriter, rpt report.Report, topologyID string, ids []string, nodes report.Nodes) {
+	w.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	w.printf("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	for _, name := range graphAttributes {
+		w.printf("  <key id=%q for=\"node\" attr.name=%q attr.type=\"string\"/>\n", name, name)
+	}
+	w.printf("  <graph id=\"%s\" edgedefault=\"directed\">\n", xmlEscape(topologyID))
+	for _, id := range ids {
+		attrs := graphNodeAttributes(rpt, nodes[id])
+		w.printf("    <node id=\"%s\">\n", xmlEscape(id))
+		for _, name := range graphAttributes {
+			if value, ok := attrs[name]; ok {
+				w.printf("      <data key=%q>%s</data>\n", name, xmlEscape(value))
+			}
+		}
+		w.printf("    </node>\n")
+	}
+	for _, id := range ids {
+		for _, adj := range nodes[id].Adjacency {
+			if _, ok := nodes[adj]; ok {
+				w.printf("    <edge source=\"%s\" target=\"%s\"/>\n", xmlEscape(id), xmlEscape(adj))
+			}
+		}
+	}
+	w.printf("  </graph>\n</graphml>\n")
+}
+
+// handleExport exports a topology, rendered with the same options as by
+// the topology handler, as a graph in the ?format given: dot (the
+// default) or graphml.  The graph is written as it's made, rather than
+// buffered.
+func handleExport(ctx context.Context, renderer render.Renderer, transformer render.Transformer, rc detailed.RenderContext, w http.ResponseWriter, r *http.Request) {
+	topologyID := mux.Vars(r)["topology"]
+	format := r.Form.Get("format")
+	var contentType string
+	switch format {
+	case "", formatDOT:
+		format, contentType = formatDOT, "text/vnd.graphviz"
+	case formatGraphML:
+		contentType = "application/graphml+xml"
+	default:
+		respondWith(ctx, w, http.StatusBadRequest, errors.Errorf("invalid format %q, expected dot or graphml", format))
+		return
+	}
+
+	tenant, ok, err := tenantRenders.acquire(ctx)
+	if err != nil {
+		respondWith(ctx, w, http.StatusInternalServerError, err)
+		return
+	} else if !ok {
+		renderGuards.WithLabelValues(topologyID, guardConcurrency).Inc()
+		respondWith(ctx, w, http.StatusTooManyRequests, "Too many topologies being rendered")
+		return
+	}
+	defer tenantRenders.release(tenant)
+	nodes := render.Render(ctx, rc.Report, renderer, transformer).Nodes
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", topologyID+"."+format))
+	ew := &exportWriter{w: w}
+	if format == formatDOT {
+		writeDOT(ew, rc.Report, topologyID, ids, nodes)
+	} else {
+		writeGraphML(ew, rc.Report, topologyID, ids, nodes)
+	}
+	if ew.err != nil {
+		log.Debugf("Error exporting %s: %v", topologyID, ew.err)
+	}
+}
+
+// InventoryItem is a host, container or image listed by the
+// /topology-api/inventory handler, with its key fields.
+type InventoryItem struct {
+	Kind          string              `json:"kind"` // host, container or image
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	Host          string              `json:"host,omitempty"`
+	Image         string              `json:"image,omitempty"` // name:tag
+	ImageID       string              `json:"image_id,omitempty"`
+	ImageDigests  []string            `json:"image_digests,omitempty"`
+	Cluster       string              `json:"cluster,omitempty"`
+	Namespace     string              `json:"namespace,omitempty"`
+	ExposureScore string              `json:"exposure_score,omitempty"`
+	Severity      string              `json:"severity,omitempty"`
+	Scans         []report.ScanStatus `json:"scans,omitempty"`
+}
+
+// inventoryColumns are the columns of the CSV inventory.
+var inventoryColumns = []string{"kind", "id", "name", "host", "image", "image_id", "image_digests", "cluster", "namespace", "exposure_score", "severity", "scans"}
+
+// csvRecord returns the item as a record of the CSV inventory.  Its scans
+// are summarised as type:status(result=count,...), separated by semicolons.
+func (i InventoryItem) csvRecord() []string {
+	scans := make([]string, 0, len(i.Scans))
+	for _, scan := range i.Scans {
+		counts := make([]string, 0, len(scan.Counts))
+		for result, count := range scan.Counts {
+			counts = append(counts, result+"="+strconv.Itoa(count))
+		}
+		sort.Strings(counts)
+		scans = append(scans, scan.Type+":"+scan.Status+"("+strings.Join(counts, ",")+")")
+	}
+	return []string{
+		i.Kind, i.ID, i.Name, i.Host, i.Image, i.ImageID, strings.Join(i.ImageDigests, ";"),
+		i.Cluster, i.Namespace, i.ExposureScore, i.Severity, strings.Join(scans, ";"),
+	}
+}
+
+// scanStatuses returns the scan statuses of a node, in order of type.
+func scanStatuses(n report.Node) []report.ScanStatus {
+	statuses := n.ScanStatuses()
+	result := make([]report.ScanStatus, 0, len(statuses))
+	for _, s := range statuses {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+	return result
+}
+
+// clusterOf returns the name of the kubernetes cluster of a node, or of
+// its pod.
+func clusterOf(rpt report.Report, n report.Node) string {
+	ids, _ := n.Parents.Lookup(report.KubernetesCluster)
+	if len(ids) == 0 {
+		pods, _ := n.Parents.Lookup(report.Pod)
+		for _, pod := range pods {
+			if ids, _ = rpt.Pod.Nodes[pod].Parents.Lookup(report.KubernetesCluster); len(ids) > 0 {
+				break
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	if name, ok := rpt.KubernetesCluster.Nodes[ids[0]].Latest.Lookup(report.KubernetesClusterName); ok {
+		return name
+	}
+	name, _ := report.ParseKubernetesClusterNodeID(ids[0])
+	return name
+}
+
+// imageItem returns the item of an image node.
+func imageItem(rpt report.Report, n report.Node) InventoryItem {
+	imageID, _ := report.ParseContainerImageNodeID(n.ID)
+	name, _ := n.Latest.Lookup(report.DockerImageName)
+	if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && name != "" {
+		name += ":" + tag
+	}
+	if name == "" {
+		name = imageID
+	}
+	digests, _ := n.Sets.Lookup(report.DockerImageRepoDigests)
+	severity, _ := severityOf(rpt, n)
+	return InventoryItem{
+		Kind:         "image",
+		ID:           n.ID,
+		Name:         name,
+		Image:        name,
+		ImageID:      imageID,
+		ImageDigests: digests,
+		Severity:     severity,
+		Scans:        scanStatuses(n),
+	}
+}
+
+// inventoryItems calls f with the hosts, containers, and then the images of
+// the containers, in rpt, as rendered with the topology options in values,
+// each in order of ID, until f fails.
+func inventoryItems(ctx context.Context, rpt report.Report, values map[string][]string, f func(InventoryItem) error) error {
+	rendered := func(topologyID string) ([]report.Node, error) {
+		renderer, transformer, err := topologyRegistry.RendererForTopology(topologyID, values, rpt)
+		if err != nil {
+			return nil, err
+		}
+		nodes := render.Render(ctx, rpt, renderer, transformer).Nodes
+		result := make([]report.Node, 0, len(nodes))
+		for _, n := range nodes {
+			if n.Topology != render.Pseudo {
+				result = append(result, n)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+		return result, nil
+	}
+	hosts, err := rendered(hostsID)
+	if err != nil {
+		return err
+	}
+	for _, n := range hosts {
+		summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+		severity, _ := severityOf(rpt, n)
+		if err := f(InventoryItem{
+			Kind:     "host",
+			ID:       n.ID,
+			Name:     summary.Label,
+			Host:     summary.Label,
+			Cluster:  clusterOf(rpt, n),
+			Severity: severity,
+			Scans:    scanStatuses(n),
+		}); err != nil {
+			return err
+		}
+	}
+
+	containers, err := rendered(containersID)
+	if err != nil {
+		return err
+	}
+	images := map[string]report.Node{}
+	for _, n := range containers {
+		summary, _ := detailed.MakeBasicNodeSummary(rpt, n)
+		_, hostName := containerHost(n)
+		imageID, _ := n.Latest.Lookup(report.DockerImageID)
+		image, _ := n.Latest.Lookup(report.DockerImageName)
+		if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && image != "" {
+			image += ":" + tag
+		}
+		var digests []string
+		if node, ok := imageNode(rpt, n); ok {
+			images[node.ID] = node
+			digests, _ = node.Sets.Lookup(report.DockerImageRepoDigests)
+			if image == "" {
+				image = imageItem(rpt, node).Name
+			}
+		}
+		namespace, _ := n.Latest.Lookup(report.KubernetesNamespace)
+		score, _ := n.Latest.Lookup(render.ExposureScore)
+		severity, _ := severityOf(rpt, n)
+		if err := f(InventoryItem{
+			Kind:          "container",
+			ID:            n.ID,
+			Name:          summary.Label,
+			Host:          hostName,
+			Image:         image,
+			ImageID:       imageID,
+			ImageDigests:  digests,
+			Cluster:       clusterOf(rpt, n),
+			Namespace:     namespace,
+			ExposureScore: score,
+			Severity:      severity,
+			Scans:         scanStatuses(n),
+		}); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, 0, len(images))
+	for id := range images {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := f(imageItem(rpt, images[id])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeInventoryHandler makes the handler listing the hosts, containers,
+// and images of the containers, of the tenant of the request, with their
+// key fields, in the ?format given: json (the default), a list of
+// InventoryItems, or csv.  The topology options of the request, such as
+// namespace, apply as they do to the hosts and containers topologies.
+// Items are written as they're listed, rather than buffered.
+func makeInventoryHandler(rep Reporter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		format := r.FormValue("format")
+		switch format {
+		case "", formatJSON:
+			format = formatJSON
+		case formatCSV:
+		default:
+			respondWith(ctx, w, http.StatusBadRequest, errors.Errorf("invalid format %q, expected json or csv", format))
+			return
+		}
+		rpt, status, err := reportForRequest(ctx, rep, r)
+		if err != nil {
+			respondWith(ctx, w, status, err)
+			return
+		}
+		// Topology options are checked before anything is written
+		for _, topologyID := range []string{hostsID, containersID} {
+			if _, _, err := topologyRegistry.RendererForTopology(topologyID, r.Form, rpt); err != nil {
+				respondWith(ctx, w, rendererErrorStatus(err), err)
+				return
+			}
+		}
+
+		if format == formatCSV {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="inventory.csv"`)
+			cw := csv.NewWriter(w)
+			cw.Write(inventoryColumns)
+			err = inventoryItems(ctx, rpt, r.Form, func(i InventoryItem) error {
+				cw.Write(i.csvRecord())
+				return cw.Error()
+			})
+			cw.Flush()
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			ew := &exportWriter{w: w}
+			ew.printf("[")
+			sep := "\n"
+			err = inventoryItems(ctx, rpt, r.Form, func(i InventoryItem) error {
+				buf, err := json.Marshal(i)
+				if err != nil {
+					return err
+				}
+				ew.printf("%s%s", sep, buf)
+				sep = ",\n"
+				return ew.err
+			})
+			ew.printf("\n]\n")
+		}
+		if err != nil {
+			log.Debugf("Error listing the inventory: %v", err)
+		}
+	}
+}
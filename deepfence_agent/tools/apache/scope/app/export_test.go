@@ -0,0 +1,101 @@
+package app_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+var update = flag.Bool("update", false, "update the golden files of exports")
+
+// checkGolden checks body is the golden file testdata/name, or with
+// -update, makes it so.
+func checkGolden(t *testing.T, name string, body []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		ok(t, ioutil.WriteFile(path, body, 0644))
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	ok(t, err)
+	if !bytes.Equal(want, body) {
+		t.Errorf("%s: want\n%s\nhave\n%s", name, want, body)
+	}
+}
+
+func exportServer() *httptest.Server {
+	// The client container runs privileged, the server one has a critical
+	// vulnerability, and its image is pulled by digest
+	rpt := fixture.Report.Copy()
+	rpt.ID = "export"
+	rpt.Container.Nodes = rpt.Container.Nodes.Copy()
+	client := rpt.Container.Nodes[fixture.ClientContainerNodeID]
+	rpt.Container.Nodes[client.ID] = client.WithLatests(map[string]string{report.DockerPrivileged: "true"})
+	server := rpt.Container.Nodes[fixture.ServerContainerNodeID]
+	rpt.Container.Nodes[server.ID] = server.WithScanStatus(report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusComplete,
+		Counts:   map[string]int{"critical": 1, "low": 3},
+		LastScan: time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC), // for the golden files
+	})
+	rpt.ContainerImage.Nodes = rpt.ContainerImage.Nodes.Copy()
+	image := rpt.ContainerImage.Nodes[fixture.ServerContainerImageNodeID]
+	rpt.ContainerImage.Nodes[image.ID] = image.WithSet(report.DockerImageRepoDigests,
+		report.MakeStringSet(fixture.ServerContainerImageName+"@sha256:2bd1ed"))
+
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.StaticCollector(rpt), nil)
+	return httptest.NewServer(router)
+}
+
+func TestAPITopologyExport(t *testing.T) {
+	ts := exportServer()
+	defer ts.Close()
+
+	for _, format := range []string{"dot", "graphml"} {
+		res, body := checkGet(t, ts, "/topology-api/topology/containers/export?format="+format)
+		equals(t, 200, res.StatusCode)
+		equals(t, `attachment; filename="containers.`+format+`"`, res.Header.Get("Content-Disposition"))
+		checkGolden(t, "containers."+format, body)
+	}
+
+	// The same filters apply as to the topology
+	_, body := checkGet(t, ts, "/topology-api/topology/containers/export?stopped=stopped")
+	if strings.Contains(string(body), "<container>") {
+		t.Errorf("Expected no running containers, got %s", body)
+	}
+
+	is400(t, ts, "/topology-api/topology/containers/export?format=svg")
+	res, _ := checkGet(t, ts, "/topology-api/topology/no-such-topology/export")
+	equals(t, 404, res.StatusCode)
+}
+
+func TestAPIInventory(t *testing.T) {
+	ts := exportServer()
+	defer ts.Close()
+
+	for _, format := range []string{"json", "csv"} {
+		res, body := checkGet(t, ts, "/topology-api/inventory?format="+format)
+		equals(t, 200, res.StatusCode)
+		checkGolden(t, "inventory."+format, body)
+	}
+
+	// Only the containers of the namespace, and their images, are listed
+	_, body := checkGet(t, ts, "/topology-api/inventory?format=csv&namespace=no-such-namespace")
+	if strings.Contains(string(body), "\ncontainer,") || strings.Contains(string(body), "\nimage,") {
+		t.Errorf("Expected no containers, got %s", body)
+	}
+
+	is400(t, ts, "/topology-api/inventory?format=xlsx")
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/render/detailed"
+)
+
+// ExposureWeights - set at runtime, weigh the factors of the exposure
+// scores of containers and pods.
+var ExposureWeights = render.DefaultExposureWeights
+
+const (
+	defaultExposedNodes = 10
+	maxExposedNodes     = 1000
+)
+
+// exposureTopologies are the topologies whose nodes are ranked by exposure.
+var exposureTopologies = []string{containersID, podsID}
+
+// APIExposure is returned by the /topology-api/exposure handler: the
+// containers and pods most exposed to attack, most exposed first.
+type APIExposure struct {
+	Nodes []ExposedNode `json:"nodes"`
+}
+
+// ExposedNode is a node ranked by APIExposure, with what its score is made
+// of.
+type ExposedNode struct {
+	ID         string `json:"id"`
+	Label      string `json:"label"`
+	TopologyID string `json:"topology_id"`
+	render.Exposure
+}
+
+// makeExposureHandler makes the handler of the ?limit (10 by default)
+// containers and pods most exposed to attack, of the tenant of the request.
+// Nodes with no exposure are left out.
+func makeExposureHandler(rep Reporter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		limit := defaultExposedNodes
+		if s := r.FormValue("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxExposedNodes {
+				respondWith(ctx, w, http.StatusBadRequest, errors.Errorf("invalid limit %q, expected 1 to %d", s, maxExposedNodes))
+				return
+			}
+		}
+		result, status, err := mostExposed(ctx, rep, r, limit)
+		if err != nil {
+			respondWith(ctx, w, status, err)
+			return
+		}
+		respondWith(ctx, w, http.StatusOK, result)
+	}
+}
+
+func mostExposed(ctx context.Context, rep Reporter, r *http.Request, limit int) (APIExposure, int, error) {
+	rpt, status, err := reportForRequest(ctx, rep, r)
+	if err != nil {
+		return APIExposure{}, status, err
+	}
+	scorer := render.ScoreExposure{Report: rpt, Weights: ExposureWeights}
+	result := APIExposure{Nodes: []ExposedNode{}}
+	for _, topologyID := range exposureTopologies {
+		topology, ok := topologyRegistry.get(topologyID)
+		if !ok {
+			continue
+		}
+		nodes := topology.renderer.Render(ctx, rpt).Nodes
+		for id, exposure := range scorer.Exposures(nodes) {
+			if exposure.Score <= 0 {
+				continue
+			}
+			summary, _ := detailed.MakeBasicNodeSummary(rpt, nodes[id])
+			result.Nodes = append(result.Nodes, ExposedNode{
+				ID:         id,
+				Label:      summary.Label,
+				TopologyID: topologyID,
+				Exposure:   exposure,
+			})
+		}
+	}
+	// The order of nodes of equal scores is by ID, so the ranking doesn't
+	// change between requests
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		a, b := result.Nodes[i], result.Nodes[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.TopologyID != b.TopologyID {
+			return a.TopologyID < b.TopologyID
+		}
+		return a.ID < b.ID
+	})
+	if len(result.Nodes) > limit {
+		result.Nodes = result.Nodes[:limit]
+	}
+	return result, http.StatusOK, nil
+}
@@ -0,0 +1,96 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ugorji/go/codec"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+func TestAPIExposure(t *testing.T) {
+	// The client container runs privileged, the server one has a critical
+	// vulnerability
+	rpt := fixture.Report.Copy()
+	rpt.Container.Nodes = rpt.Container.Nodes.Copy()
+	client := rpt.Container.Nodes[fixture.ClientContainerNodeID]
+	rpt.Container.Nodes[client.ID] = client.WithLatests(map[string]string{report.DockerPrivileged: "true"})
+	server := rpt.Container.Nodes[fixture.ServerContainerNodeID]
+	rpt.Container.Nodes[server.ID] = server.WithScanStatus(report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusComplete,
+		Counts:   map[string]int{"critical": 1},
+		LastScan: fixture.Now.Add(-time.Hour),
+	})
+
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.StaticCollector(rpt), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	var exposure app.APIExposure
+	if err := json.Unmarshal(getRawJSON(t, ts, "/topology-api/exposure"), &exposure); err != nil {
+		t.Fatal(err)
+	}
+	// The server takes connections from the internet, and its pod takes
+	// its vulnerabilities; the client's pod its privileges
+	want := []struct {
+		id, topologyID string
+		factors        []string
+	}{
+		{fixture.ServerContainerNodeID, "containers", []string{render.ExposureInternetInbound, render.ExposureVulnerabilities}},
+		{fixture.ServerPodNodeID, "pods", []string{render.ExposureInternetInbound, render.ExposureVulnerabilities}},
+		{fixture.ClientContainerNodeID, "containers", []string{render.ExposurePrivileged}},
+		{fixture.ClientPodNodeID, "pods", []string{render.ExposurePrivileged}},
+	}
+	if len(exposure.Nodes) != len(want) {
+		t.Fatalf("Expected the containers and pods, got %+v", exposure.Nodes)
+	}
+	for i, w := range want {
+		have := exposure.Nodes[i]
+		if have.ID != w.id || have.TopologyID != w.topologyID || len(have.Factors) != len(w.factors) {
+			t.Errorf("%d: expected %s of %s, got %+v", i, w.id, w.topologyID, have)
+		}
+		for _, factor := range w.factors {
+			if have.Factors[factor] <= 0 {
+				t.Errorf("%d: expected %s to count, got %+v", i, factor, have)
+			}
+		}
+	}
+
+	if err := json.Unmarshal(getRawJSON(t, ts, "/topology-api/exposure?limit=1"), &exposure); err != nil {
+		t.Fatal(err)
+	}
+	if len(exposure.Nodes) != 1 || exposure.Nodes[0].ID != fixture.ServerContainerNodeID {
+		t.Errorf("Expected only the server container, got %+v", exposure.Nodes)
+	}
+	is400(t, ts, "/topology-api/exposure?limit=0")
+
+	// The topology pages the most exposed first, with their scores
+	var topo app.APITopology
+	body := getRawJSON(t, ts, "/topology-api/topology/containers?limit=1&sort=exposure_score")
+	if err := codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&topo); err != nil {
+		t.Fatal(err)
+	}
+	node, ok := topo.Nodes[fixture.ServerContainerNodeID]
+	if !ok || len(topo.Nodes) != 1 {
+		t.Fatalf("Expected the server container, got %v", topo.Nodes)
+	}
+	found := false
+	for _, row := range node.Metadata {
+		if row.ID == render.ExposureScore {
+			found = row.Datatype == report.Number && row.Value != ""
+		}
+	}
+	if !found {
+		t.Errorf("Expected the exposure score of the server container, got %v", node.Metadata)
+	}
+	is400(t, ts, "/topology-api/topology/containers?sort=cpu")
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+// How containers are matched to the image asked for, as reported in
+// ImageContainer.Match
+const (
+	matchDigest  = "digest"
+	matchImageID = "image_id"
+	matchTag     = "tag"
+)
+
+// imageIDRegexp matches image IDs and bare digests, with or without their
+// algorithm, and the short image IDs docker shows.
+var imageIDRegexp = regexp.MustCompile(`^(sha256:)?[0-9a-f]{12,64}$`)
+
+// APIImageContainers is returned by the /topology-api/images/containers
+// handler: the running containers of an image.
+type APIImageContainers struct {
+	Image      string           `json:"image"`
+	Containers []ImageContainer `json:"containers"`
+}
+
+// ImageContainer is a running container of the image of APIImageContainers,
+// with the tags and digests its image has on the host it runs on.
+type ImageContainer struct {
+	Tenant   string   `json:"tenant,omitempty"`
+	ID       string   `json:"id"`
+	Label    string   `json:"label"`
+	HostName string   `json:"host_name,omitempty"`
+	ImageID  string   `json:"image_id"`
+	Tags     []string `json:"tags,omitempty"`
+	Digests  []string `json:"digests,omitempty"`
+	Match    string   `json:"match"`
+}
+
+// imageQuery is an image reference, digest or ID asked for.
+type imageQuery struct {
+	id     string // an image ID or digest, without algorithm
+	tag    string // repository:tag
+	digest string // repository@algorithm:digest
+}
+
+// parseImageQuery parses the ?image of a request, as an image ID, a digest,
+// or a reference by tag or digest.  References by both tag and digest are
+// taken by digest, as docker pulls them.
+func parseImageQuery(image string) (imageQuery, error) {
+	image = strings.TrimSpace(image)
+	switch {
+	case image == "":
+		return imageQuery{}, errors.New("missing image")
+	case imageIDRegexp.MatchString(image):
+		return imageQuery{id: strings.TrimPrefix(image, "sha256:")}, nil
+	case strings.Contains(image, "@"):
+		ref := docker.NormalizeReference(image)
+		i := strings.Index(ref, "@")
+		repository := ref[:i]
+		if j := strings.LastIndex(repository, ":"); j > strings.LastIndex(repository, "/") {
+			repository = repository[:j]
+		}
+		if repository == "" || i == len(ref)-1 {
+			return imageQuery{}, errors.Errorf("invalid image reference %q", image)
+		}
+		return imageQuery{digest: repository + ref[i:]}, nil
+	}
+	return imageQuery{tag: docker.NormalizeReference(image)}, nil
+}
+
+// match returns how an image of imageID, known by tags and digests, matches
+// the query, if it does.  Digests are compared in preference to tags, which
+// may point to different images on different hosts.
+func (q imageQuery) match(imageID string, tags, digests report.StringSet) (string, bool) {
+	switch {
+	case q.id != "":
+		for _, digest := range digests {
+			if i := strings.Index(digest, "@"); i >= 0 && strings.TrimPrefix(digest[i+1:], "sha256:") == q.id {
+				return matchDigest, true
+			}
+		}
+		if strings.HasPrefix(imageID, q.id) {
+			return matchImageID, true
+		}
+	case q.digest != "":
+		if digests.Contains(q.digest) {
+			return matchDigest, true
+		}
+	case q.tag != "":
+		if tags.Contains(q.tag) {
+			return matchTag, true
+		}
+	}
+	return "", false
+}
+
+// makeImageContainersHandler makes the handler of the running containers of
+// ?image, an image reference, digest or ID, of the tenant of the request.
+func makeImageContainersHandler(rep Reporter) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		image := r.FormValue("image")
+		query, err := parseImageQuery(image)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		rpt, status, err := reportForRequest(ctx, rep, r)
+		if err != nil {
+			respondWith(ctx, w, status, err)
+			return
+		}
+		respondWith(ctx, w, http.StatusOK, APIImageContainers{
+			Image:      image,
+			Containers: imageContainers(rpt, query),
+		})
+	}
+}
+
+// TenantLister is a ProbeRegistry which knows the tenants with probes, for
+// looking across them.
+type TenantLister interface {
+	Tenants(ctx context.Context) ([]string, error)
+}
+
+// makeAdminImageContainersHandler makes the handler of the running
+// containers of ?image across all tenants with probes, for admins.
+func makeAdminImageContainersHandler(rep Reporter, probes ProbeRegistry) CtxHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		image := r.FormValue("image")
+		query, err := parseImageQuery(image)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		lister, ok := probes.(TenantLister)
+		if !ok {
+			respondWith(ctx, w, http.StatusNotImplemented, errors.New("tenants are not known to this probe registry"))
+			return
+		}
+		tenants, err := lister.Tenants(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		sort.Strings(tenants)
+		result := APIImageContainers{Image: image, Containers: []ImageContainer{}}
+		for _, tenant := range tenants {
+			rpt, err := rep.Report(WithTenantID(ctx, tenant), mtime.Now())
+			if err != nil {
+				respondWith(ctx, w, http.StatusInternalServerError, errors.Wrapf(err, "tenant %s", tenant))
+				return
+			}
+			for _, c := range imageContainers(rpt, query) {
+				c.Tenant = tenant
+				result.Containers = append(result.Containers, c)
+			}
+		}
+		respondWith(ctx, w, http.StatusOK, result)
+	}
+}
+
+// imageContainers returns the running containers of rpt whose image matches
+// query.  Images are looked up in the ContainerImage topology, by the image
+// IDs of the containers; containers whose image isn't reported are matched
+// by the image name and tag they were started from.
+func imageContainers(rpt report.Report, query imageQuery) []ImageContainer {
+	result := []ImageContainer{}
+	for id, n := range rpt.Container.Nodes {
+		if state, _ := n.Latest.Lookup(report.DockerContainerState); state != report.StateRunning {
+			continue
+		}
+		imageID, _ := n.Latest.Lookup(report.DockerImageID)
+		var tags, digests report.StringSet
+		if image, ok := rpt.ContainerImage.Nodes[report.MakeContainerImageNodeID(imageID)]; ok {
+			tags, _ = image.Sets.Lookup(report.DockerImageRepoTags)
+			digests, _ = image.Sets.Lookup(report.DockerImageRepoDigests)
+		} else if name, ok := n.Latest.Lookup(report.DockerImageName); ok {
+			if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && tag != "" {
+				name += ":" + tag
+			}
+			tags = report.MakeStringSet(docker.NormalizeReference(name))
+		}
+		match, ok := query.match(imageID, tags, digests)
+		if !ok {
+			continue
+		}
+		label, _ := n.Latest.Lookup(report.DockerContainerName)
+		hostName, _ := n.Latest.Lookup(report.HostName)
+		result = append(result, ImageContainer{
+			ID:       id,
+			Label:    label,
+			HostName: hostName,
+			ImageID:  imageID,
+			Tags:     tags,
+			Digests:  digests,
+			Match:    match,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].HostName != result[j].HostName {
+			return result[i].HostName < result[j].HostName
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
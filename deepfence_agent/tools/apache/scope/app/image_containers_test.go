@@ -0,0 +1,186 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	imageID1 = "1111111111111111111111111111111111111111111111111111111111111111"
+	imageID2 = "2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// imageContainersReport is of the mutable tag app:1.0, pointing to
+// different images on two hosts:
+//   - host1 runs web-1 of image 1, pulled as app@sha256:aaa
+//   - host2 runs web-2 of image 2, pulled as app@sha256:bbb, and the stopped
+//     web-3 of the same image
+//   - host3 runs web-4, of an image it doesn't report, started as app:1.0
+func imageContainersReport() report.Report {
+	rpt := report.MakeReport()
+	image := func(id, digest string) {
+		rpt.ContainerImage.AddNode(report.MakeNode(report.MakeContainerImageNodeID(id)).
+			WithTopology(report.ContainerImage).
+			WithSet(report.DockerImageRepoTags, report.MakeStringSet("app:1.0", "quay.io/team/app:1.0")).
+			WithSet(report.DockerImageRepoDigests, report.MakeStringSet(digest)))
+	}
+	image(imageID1, "app@sha256:aaa")
+	image(imageID2, "app@sha256:bbb")
+	container := func(id, host, state string, latests map[string]string) {
+		latests[report.DockerContainerName] = id
+		latests[report.DockerContainerState] = state
+		latests[report.HostName] = host
+		rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID(id), latests).
+			WithTopology(report.Container))
+	}
+	container("web-1", "host1", report.StateRunning, map[string]string{report.DockerImageID: imageID1})
+	container("web-2", "host2", report.StateRunning, map[string]string{report.DockerImageID: imageID2})
+	container("web-3", "host2", report.StateExited, map[string]string{report.DockerImageID: imageID2})
+	container("web-4", "host3", report.StateRunning, map[string]string{
+		report.DockerImageID:   "3333333333333333333333333333333333333333333333333333333333333333",
+		report.DockerImageName: "docker.io/library/app",
+		report.DockerImageTag:  "1.0",
+	})
+	return rpt
+}
+
+func TestAPIImageContainers(t *testing.T) {
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.StaticCollector(imageContainersReport()), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		image string
+		want  map[string]string // match of each container
+	}{
+		// A tag matches wherever it points, as docker shows it
+		{"app:1.0", map[string]string{"web-1": "tag", "web-2": "tag", "web-4": "tag"}},
+		{"docker.io/library/app:1.0", map[string]string{"web-1": "tag", "web-2": "tag", "web-4": "tag"}},
+		{"quay.io/team/app:1.0", map[string]string{"web-1": "tag", "web-2": "tag"}},
+		{"app", map[string]string{}},
+		// A digest only where the tag points to it
+		{"app@sha256:aaa", map[string]string{"web-1": "digest"}},
+		{"docker.io/app@sha256:bbb", map[string]string{"web-2": "digest"}},
+		{"app:1.0@sha256:bbb", map[string]string{"web-2": "digest"}},
+		{"quay.io/team/app@sha256:aaa", map[string]string{}},
+		// Image IDs, in full or short
+		{"sha256:" + imageID1, map[string]string{"web-1": "image_id"}},
+		{imageID2[:12], map[string]string{"web-2": "image_id"}},
+	} {
+		var result app.APIImageContainers
+		body := getRawJSON(t, ts, "/topology-api/images/containers?image="+url.QueryEscape(c.image))
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatal(err)
+		}
+		have := map[string]string{}
+		for _, container := range result.Containers {
+			have[container.Label] = container.Match
+		}
+		if !reflect.DeepEqual(c.want, have) {
+			t.Errorf("%s: want %v, have %v", c.image, c.want, have)
+		}
+	}
+
+	// The digests of each container's image tell where the tag points
+	var result app.APIImageContainers
+	if err := json.Unmarshal(getRawJSON(t, ts, "/topology-api/images/containers?image=app:1.0"), &result); err != nil {
+		t.Fatal(err)
+	}
+	want := app.ImageContainer{
+		ID:       report.MakeContainerNodeID("web-2"),
+		Label:    "web-2",
+		HostName: "host2",
+		ImageID:  imageID2,
+		Tags:     []string{"app:1.0", "quay.io/team/app:1.0"},
+		Digests:  []string{"app@sha256:bbb"},
+		Match:    "tag",
+	}
+	if len(result.Containers) != 3 || !reflect.DeepEqual(want, result.Containers[1]) {
+		t.Errorf("want %+v second, have %+v", want, result.Containers)
+	}
+
+	is400(t, ts, "/topology-api/images/containers")
+	is400(t, ts, "/topology-api/images/containers?image=app@")
+}
+
+// tenantKey is of the tenant of contexts in tests looking across tenants.
+type tenantKey struct{}
+
+// tenantReporter reports the report of the tenant of the context.
+type tenantReporter struct {
+	app.StaticCollector
+	reports map[string]report.Report
+}
+
+func (r tenantReporter) Report(ctx context.Context, _ time.Time) (report.Report, error) {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return r.reports[tenant], nil
+}
+
+// tenantRegistry knows the tenants with probes.
+type tenantRegistry struct {
+	app.ProbeRegistry
+	tenants []string
+}
+
+func (r tenantRegistry) Tenants(context.Context) ([]string, error) {
+	return r.tenants, nil
+}
+
+func TestAPIAdminImageContainers(t *testing.T) {
+	app.WithTenantID = func(ctx context.Context, tenant string) context.Context {
+		return context.WithValue(ctx, tenantKey{}, tenant)
+	}
+	defer func() {
+		app.WithTenantID = func(ctx context.Context, _ string) context.Context { return ctx }
+	}()
+	other := report.MakeReport()
+	other.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID("api-1"), map[string]string{
+		report.DockerContainerName:  "api-1",
+		report.DockerContainerState: report.StateRunning,
+		report.DockerImageID:        imageID1,
+	}).WithTopology(report.Container))
+	reporter := tenantReporter{reports: map[string]report.Report{
+		"tenant1": imageContainersReport(),
+		"tenant2": other,
+	}}
+
+	router := mux.NewRouter()
+	app.RegisterProbeRoutes(router, reporter, tenantRegistry{app.NewLocalProbeRegistry(time.Minute), []string{"tenant2", "tenant1"}})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	var result app.APIImageContainers
+	if err := json.Unmarshal(getRawJSON(t, ts, "/admin/images/containers?image="+imageID1), &result); err != nil {
+		t.Fatal(err)
+	}
+	have := map[string]string{}
+	for _, container := range result.Containers {
+		have[container.Label] = container.Tenant
+	}
+	if want := map[string]string{"web-1": "tenant1", "api-1": "tenant2"}; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	// Without knowing the tenants, there's no looking across them
+	single := mux.NewRouter()
+	app.RegisterProbeRoutes(single, reporter, app.NewLocalProbeRegistry(time.Minute))
+	singleServer := httptest.NewServer(single)
+	defer singleServer.Close()
+	res, _ := checkGet(t, singleServer, "/admin/images/containers?image="+imageID1)
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected %d, got %d", http.StatusNotImplemented, res.StatusCode)
+	}
+}
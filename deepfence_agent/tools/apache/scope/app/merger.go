@@ -2,6 +2,7 @@ package app
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spaolacci/murmur3"
 
@@ -11,6 +12,10 @@ import (
 // Merger is the type for a thing that can merge reports.
 type Merger interface {
 	Merge([]report.Report) report.Report
+
+	// MergeWindowed merges reports as Merge does, leaving out the nodes
+	// whose newest data is from before cutoff.
+	MergeWindowed(reports []report.Report, cutoff time.Time) report.Report
 }
 
 type fastMerger struct{}
@@ -22,11 +27,30 @@ func NewFastMerger() Merger {
 
 func (fastMerger) Merge(reports []report.Report) report.Report {
 	rpt := report.MakeReport()
+	rpt.UnsafeMergeAll(reports)
+	rpt.ID = mergedID(reports)
+	return rpt
+}
+
+func (fastMerger) MergeWindowed(reports []report.Report, cutoff time.Time) report.Report {
+	rpt := report.MakeReport()
+	rpt.UnsafeMergeAllWindowed(reports, cutoff)
+	rpt.ID = mergedID(reports)
+	return rpt
+}
+
+// mergedID is the ID of the report merged from reports.
+func mergedID(reports []report.Report) string {
 	id := murmur3.New64()
 	for _, r := range reports {
-		rpt.UnsafeMerge(r)
 		id.Write([]byte(r.ID))
 	}
-	rpt.ID = fmt.Sprintf("%x", id.Sum64())
-	return rpt
+	return fmt.Sprintf("%x", id.Sum64())
+}
+
+// decoratedID is the ID of a report with ID id decorated by the generation
+// of a store, so that renders of the report as it was decorated by earlier
+// generations, or not at all, aren't taken for renders of it.
+func decoratedID(id, store string, generation uint64) string {
+	return fmt.Sprintf("%s-%s%d", id, store, generation)
 }
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of websockets, as counted by websocketConnections
+const (
+	topologyWebsocket    = "topology"
+	connectionsWebsocket = "connections"
+	controlWebsocket     = "control"
+	pipeWebsocket        = "pipe"
+)
+
+var (
+	reportsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "reports_received_total",
+		Help:      "Total count of reports received from probes, by probe version.",
+	}, []string{"probe_version"})
+	reportBytesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "report_received_bytes_total",
+		Help:      "Total bytes of reports received from probes, as sent, by probe version.",
+	}, []string{"probe_version"})
+	reportChecksumFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "report_checksum_failures_total",
+		Help:      "Total count of reports refused for not matching their checksums, by probe version.",
+	}, []string{"probe_version"})
+	reportViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "report_violations_total",
+		Help:      "Total count of violations of the report limits, by class.",
+	}, []string{"class"})
+	renderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "scope",
+		Name:      "render_duration_seconds",
+		Help:      "Time in seconds spent rendering topologies, by topology.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topology"})
+	renderGuards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "render_guards_total",
+		Help:      "Total count of renders of topologies cut short by the render guards, by topology and guard.",
+	}, []string{"topology", "guard"})
+	renderCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "render_cache_requests_total",
+		Help:      "Total count of lookups of rendered topologies in the render cache, by result (hit or miss).",
+	}, []string{"result"})
+	websocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "scope",
+		Name:      "websocket_connections",
+		Help:      "Number of open websocket connections, by kind.",
+	}, []string{"kind"})
+	websocketSlowCloses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "websocket_slow_closes_total",
+		Help:      "Total count of websockets closed for being blocked on writes for too long, by kind.",
+	}, []string{"kind"})
+	controlRoundTripDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "scope",
+		Name:      "control_round_trip_duration_seconds",
+		Help:      "Time in seconds from sending controls to probes to their responses, by whether they succeeded.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"success"})
+	webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scope",
+		Name:      "webhook_deliveries_total",
+		Help:      "Total count of events posted to webhooks, by host of the webhook and result (delivered, retried or dead_letter).",
+	}, []string{"host", "result"})
+	collectorReports = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "scope",
+		Name:      "collector_reports",
+		Help:      "Number of reports held by the in-memory collector.",
+	})
+	collectorMergeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "scope",
+		Name:      "collector_merge_duration_seconds",
+		Help:      "Time in seconds spent merging the reports held by the in-memory collector.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// MustRegisterMetrics registers the metrics of the app with prometheus.
+func MustRegisterMetrics() {
+	prometheus.MustRegister(reportsReceived)
+	prometheus.MustRegister(reportBytesReceived)
+	prometheus.MustRegister(reportChecksumFailures)
+	prometheus.MustRegister(reportViolations)
+	prometheus.MustRegister(renderDuration)
+	prometheus.MustRegister(renderGuards)
+	prometheus.MustRegister(renderCacheRequests)
+	prometheus.MustRegister(websocketConnections)
+	prometheus.MustRegister(websocketSlowCloses)
+	prometheus.MustRegister(controlRoundTripDuration)
+	prometheus.MustRegister(webhookDeliveries)
+	prometheus.MustRegister(collectorReports)
+	prometheus.MustRegister(collectorMergeDuration)
+}
+
+// trackWebsocket counts a websocket of a kind as open, until the function
+// returned is called.
+func trackWebsocket(kind string) func() {
+	gauge := websocketConnections.WithLabelValues(kind)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// observeRender records the time a topology took to render, from start.
+func observeRender(topologyID string, start time.Time) {
+	renderDuration.WithLabelValues(topologyID).Observe(time.Since(start).Seconds())
+}
+
+// probeVersionPattern matches the versions probes are released with, and
+// what of them is kept as the probe_version label: the build metadata of
+// versions such as 1.13.2-12-gabcdef is left out.
+var probeVersionPattern = regexp.MustCompile(`^v?([0-9]{1,4}\.[0-9]{1,4}\.[0-9]{1,4})(?:[-+.].*)?$`)
+
+// probeVersionLabel is the probe_version label for the version a probe
+// sends.  The header isn't to be trusted, so anything other than a release
+// or dev build is counted as "other", to bound the number of series.
+func probeVersionLabel(version string) string {
+	switch version {
+	case "":
+		return "unknown"
+	case "dev":
+		return version
+	}
+	if m := probeVersionPattern.FindStringSubmatch(version); m != nil {
+		return m[1]
+	}
+	return "other"
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	count int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += n
+	return n, err
+}
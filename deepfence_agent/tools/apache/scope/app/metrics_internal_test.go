@@ -0,0 +1,24 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestProbeVersionLabel(t *testing.T) {
+	for version, want := range map[string]string{
+		"":                   "unknown",
+		"dev":                "dev",
+		"1.13.2":             "1.13.2",
+		"v1.13.2":            "1.13.2",
+		"1.13.2-12-gabcdef0": "1.13.2",
+		"1.13.2+build.5":     "1.13.2",
+		"1.13":               "other",
+		"12345.0.0":          "other",
+		"1.2.3x":             "other",
+		"not a version":      "other",
+	} {
+		if have := probeVersionLabel(version); have != want {
+			t.Errorf("%q: expected %q, got %q", version, want, have)
+		}
+	}
+}
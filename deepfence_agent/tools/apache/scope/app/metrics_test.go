@@ -0,0 +1,92 @@
+package app_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+var registerMetricsOnce sync.Once
+
+func TestMetrics(t *testing.T) {
+	registerMetricsOnce.Do(app.MustRegisterMetrics)
+
+	router := mux.NewRouter().SkipClean(true)
+	router.Path("/metrics").Handler(promhttp.Handler())
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	app.RegisterControlRoutes(router, app.NewLocalControlRouter(), nil)
+	app.RegisterTopologyRoutes(router, c, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// A report from a probe, rendered over HTTP and a websocket, and a
+	// control for a probe which isn't connected
+	buf, err := fixture.Report.WriteProtobuf()
+	ok(t, err)
+	req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", buf)
+	ok(t, err)
+	req.Header.Set("Content-Type", xfer.ProtobufContentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(xfer.ScopeProbeVersionHeader, "1.2.3")
+	res, err := http.DefaultClient.Do(req)
+	ok(t, err)
+	res.Body.Close()
+	equals(t, http.StatusOK, res.StatusCode)
+
+	getRawJSON(t, ts, "/topology-api/topology/hosts")
+
+	ws, _, err := (&websocket.Dialer{}).Dial("ws"+ts.URL[len("http"):]+"/topology-api/topology/containers/ws", nil)
+	ok(t, err)
+	defer ws.Close()
+	_, _, err = ws.ReadMessage()
+	ok(t, err)
+
+	checkRequest(t, ts, "POST", "/topology-api/control/probe/node/control", nil)
+
+	// Other tests add to the same series, so only they are checked for
+	_, body := checkGet(t, ts, "/metrics")
+	for _, series := range []string{
+		`scope_reports_received_total{probe_version="1.2.3"}`,
+		`scope_report_received_bytes_total{probe_version="1.2.3"}`,
+		`scope_render_duration_seconds_count{topology="hosts"}`,
+		`scope_render_duration_seconds_count{topology="containers"}`,
+		`scope_websocket_connections{kind="topology"}`,
+		`scope_control_round_trip_duration_seconds_count{success="false"}`,
+		`scope_collector_reports`,
+		`scope_collector_merge_duration_seconds_count`,
+	} {
+		if !strings.Contains(string(body), "\n"+series+" ") {
+			t.Errorf("Expected series %s", series)
+		}
+	}
+
+	// The websocket is no longer counted once closed
+	ws.Close()
+	for i := 0; ; i++ {
+		res, err := http.Get(ts.URL + "/metrics")
+		ok(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ok(t, err)
+		if strings.Contains(string(body), "\n"+`scope_websocket_connections{kind="topology"} 0`+"\n") {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected the websocket not to be counted once closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
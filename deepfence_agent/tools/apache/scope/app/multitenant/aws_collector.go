@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -141,6 +142,12 @@ type AWSCollectorConfig struct {
 	MemcacheClient *MemcacheClient
 	Window         time.Duration
 	MaxTopNodes    int
+	LatestMaxAge   time.Duration // latest values older than this are pruned from stored reports; 0 keeps them
+
+	// Samples of metrics older than MetricsDownsampleAge are downsampled to
+	// MetricsDownsamplePoints per metric in stored reports; 0 keeps them.
+	MetricsDownsampleAge    time.Duration
+	MetricsDownsamplePoints int
 }
 
 // if StoreInterval is set, reports are merged into here and held until flushed to store
@@ -251,6 +258,12 @@ func (c *awsCollector) flushPending(ctx context.Context) {
 			entry.Unlock()
 
 			if count > 0 {
+				if c.cfg.LatestMaxAge > 0 {
+					rpt.PruneLatest(time.Now().Add(-c.cfg.LatestMaxAge))
+				}
+				if c.cfg.MetricsDownsampleAge > 0 && c.cfg.MetricsDownsamplePoints > 0 {
+					rpt.DownsampleMetrics(time.Now().Add(-c.cfg.MetricsDownsampleAge), c.cfg.MetricsDownsamplePoints)
+				}
 				// serialise reports on one goroutine to limit CPU usage
 				buf, err := rpt.WriteBinary()
 				if err != nil {
@@ -476,11 +489,30 @@ func (c *awsCollector) Report(ctx context.Context, timestamp time.Time) (report.
 		return report.MakeReport(), err
 	}
 	span.SetTag("userid", userid)
+	rpt, _, err := c.reportInWindow(ctx, userid, timestamp, c.cfg.Window)
+	return rpt, err
+}
+
+// HistoricReport returns the report merged from those stored within window
+// before timestamp, and whether there were any.
+func (c *awsCollector) HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "awsCollector.HistoricReport")
+	defer span.Finish()
+	userid, err := c.cfg.UserIDer(ctx)
+	if err != nil {
+		return report.MakeReport(), false, err
+	}
+	span.SetTag("userid", userid)
+	return c.reportInWindow(ctx, userid, timestamp, window)
+}
+
+func (c *awsCollector) reportInWindow(ctx context.Context, userid string, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	span := opentracing.SpanFromContext(ctx)
 	end := timestamp
-	start := end.Add(-c.cfg.Window)
+	start := end.Add(-window)
 	reportKeys, err := c.getReportKeys(ctx, userid, start, end)
 	if err != nil {
-		return report.MakeReport(), err
+		return report.MakeReport(), false, err
 	}
 	span.LogFields(otlog.Int("keys", len(reportKeys)), otlog.String("timestamp", timestamp.String()))
 
@@ -491,18 +523,42 @@ func (c *awsCollector) Report(ctx context.Context, timestamp time.Time) (report.
 	for ; ts+(reportQuantisationInterval+gracePeriod).Nanoseconds() < endTS; ts += reportQuantisationInterval.Nanoseconds() {
 		quantumReport, err := c.reportForQuantum(ctx, userid, reportKeys, ts)
 		if err != nil {
-			return report.MakeReport(), err
+			return report.MakeReport(), false, err
 		}
 		reports = append(reports, quantumReport)
 	}
 	// Fetch individual reports for the period after the last quantum
 	last, err := c.reportsForKeysInRange(ctx, userid, reportKeys, ts, endTS)
 	if err != nil {
-		return report.MakeReport(), err
+		return report.MakeReport(), false, err
 	}
 	reports = append(reports, last...)
 	span.LogFields(otlog.Int("merging", len(reports)))
-	return c.merger.Merge(reports), nil
+	span.SetTag("reports", len(reports))
+	mergeSpan, _ := opentracing.StartSpanFromContext(ctx, "awsCollector.Merge")
+	defer mergeSpan.Finish()
+	// Nodes are timestamped by the clocks of probes, which may be behind
+	return c.merger.MergeWindowed(reports, start.Add(-app.ClockSkewThreshold)), len(reportKeys) > 0, nil
+}
+
+// ReportRanges returns the spans of time between start and end over which
+// reports are stored.
+func (c *awsCollector) ReportRanges(ctx context.Context, start, end time.Time) ([]app.TimeRange, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "awsCollector.ReportRanges")
+	defer span.Finish()
+	userid, err := c.cfg.UserIDer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reportKeys, err := c.getReportKeys(ctx, userid, start, end)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := make([]time.Time, 0, len(reportKeys))
+	for _, k := range reportKeys {
+		timestamps = append(timestamps, time.Unix(0, k.ts))
+	}
+	return app.TimeRanges(timestamps), nil
 }
 
 // Fetch a merged report either from cache or from store which we put in cache
@@ -593,6 +649,31 @@ func calculateReportKeys(userid string, now time.Time) (string, string, string)
 	return rowKey, colKey, fmt.Sprintf("%x/%s", rowKeyHash.Sum(nil), colKey)
 }
 
+// stateKey is the key of the state saved under key for userid, apart from
+// the keys of reports.
+func stateKey(userid, key string) string {
+	return "state/" + url.PathEscape(userid) + "/" + key
+}
+
+// LoadState implements app.StateStore, from S3.
+func (c *awsCollector) LoadState(ctx context.Context, key string) ([]byte, error) {
+	userid, err := c.cfg.UserIDer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.cfg.S3Store.FetchBytes(ctx, stateKey(userid, key))
+}
+
+// SaveState implements app.StateStore, in S3.
+func (c *awsCollector) SaveState(ctx context.Context, key string, buf []byte) error {
+	userid, err := c.cfg.UserIDer(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.cfg.S3Store.StoreReportBytes(ctx, stateKey(userid, key), buf)
+	return err
+}
+
 func (c *awsCollector) persistReport(ctx context.Context, userid, rowKey, colKey, reportKey string, buf []byte) error {
 	// Put in S3 and cache before index, so it is fetchable before it is discoverable
 	reportSize, err := c.cfg.S3Store.StoreReportBytes(ctx, reportKey, buf)
@@ -677,6 +758,15 @@ func (c *awsCollector) Add(ctx context.Context, rep report.Report, buf []byte) e
 		return err
 	}
 
+	if buf == nil && (rep.Shortcut && c.nats != nil || !rep.Shortcut && c.cfg.StoreInterval == 0) {
+		// The report wasn't received as gzipped msgpack
+		encoded, err := rep.WriteBinary()
+		if err != nil {
+			return err
+		}
+		buf = encoded.Bytes()
+	}
+
 	// Shortcut reports are published to nats but not persisted -
 	// we'll get a full report from the same probe in a few seconds
 	if rep.Shortcut {
@@ -698,7 +788,7 @@ func (c *awsCollector) Add(ctx context.Context, rep report.Report, buf []byte) e
 	}
 
 	if c.cfg.StoreInterval == 0 {
-		rowKey, colKey, reportKey := calculateReportKeys(userid, time.Now())
+		rowKey, colKey, reportKey := calculateReportKeys(userid, app.ReportTimestamp(ctx))
 		err = c.persistReport(ctx, userid, rowKey, colKey, reportKey, buf)
 		if err != nil {
 			return err
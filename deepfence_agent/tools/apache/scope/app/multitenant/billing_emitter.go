@@ -1,7 +1,6 @@
 package multitenant
 
 import (
-	"crypto/sha256"
 	"encoding/base64"
 	"flag"
 	"math"
@@ -64,7 +63,9 @@ func (e *BillingEmitter) Add(ctx context.Context, rep report.Report, buf []byte)
 	}
 	rowKey, colKey := calculateDynamoKeys(userID, now)
 
-	interval := e.reportInterval(rep)
+	// The report is already decoded, so its encoding isn't read again
+	partial := report.MakePartialReport(rep, report.Overlay, report.Process)
+	interval := e.reportInterval(partial)
 	// Cache the last-known value of interval for this user, and use
 	// it if we didn't find one in this report.
 	e.Lock()
@@ -78,22 +79,26 @@ func (e *BillingEmitter) Add(ctx context.Context, rep report.Report, buf []byte)
 		}
 	}
 	// Billing takes an integer number of seconds, so keep track of the amount lost to rounding
-	nodeSeconds := interval.Seconds()*float64(len(rep.Host.Nodes)) + e.rounding[userID]
+	nodeSeconds := interval.Seconds()*float64(partial.NodeCounts[report.Host]) + e.rounding[userID]
 	rounding := nodeSeconds - math.Floor(nodeSeconds)
 	e.rounding[userID] = rounding
 	e.Unlock()
 
-	hasher := sha256.New()
-	hasher.Write(buf)
-	hash := "sha256:" + base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+	// The checksum the probe sent, checked on receipt, saves hashing the
+	// report again
+	sum, ok := app.ReportChecksum(ctx)
+	if !ok {
+		sum = report.SumPayload(buf)
+	}
+	hash := "sha256:" + base64.URLEncoding.EncodeToString(sum.Bytes())
 
 	weaveNetCount := 0
-	if hasWeaveNet(rep) {
+	if hasWeaveNet(partial.Topologies[report.Overlay]) {
 		weaveNetCount = 1
 	}
 
 	amounts := billing.Amounts{
-		billing.ContainerSeconds: int64(interval/time.Second) * int64(len(rep.Container.Nodes)),
+		billing.ContainerSeconds: int64(interval/time.Second) * int64(partial.NodeCounts[report.Container]),
 		billing.NodeSeconds:      int64(nodeSeconds),
 		billing.WeaveNetSeconds:  int64(interval/time.Second) * int64(weaveNetCount),
 	}
@@ -121,12 +126,12 @@ func (e *BillingEmitter) Add(ctx context.Context, rep report.Report, buf []byte)
 
 // reportInterval tries to find the custom report interval of this report. If
 // it is malformed, or not set, it returns zero.
-func (e *BillingEmitter) reportInterval(r report.Report) time.Duration {
+func (e *BillingEmitter) reportInterval(r *report.PartialReport) time.Duration {
 	if r.Window != 0 {
 		return r.Window
 	}
 	var inter string
-	for _, c := range r.Process.Nodes {
+	for _, c := range r.Topologies[report.Process].Nodes {
 		cmd, ok := c.Latest.Lookup("cmdline")
 		if !ok {
 			continue
@@ -154,8 +159,8 @@ func (e *BillingEmitter) reportInterval(r report.Report) time.Duration {
 }
 
 // Tries to determine if this report came from a host running Weave Net
-func hasWeaveNet(r report.Report) bool {
-	for _, n := range r.Overlay.Nodes {
+func hasWeaveNet(overlay report.Topology) bool {
+	for _, n := range overlay.Nodes {
 		overlayType, _ := report.ParseOverlayNodeID(n.ID)
 		if overlayType == report.WeaveOverlayPeerPrefix {
 			return true
@@ -21,6 +21,7 @@ const (
 type ConsulClient interface {
 	Get(ctx context.Context, key string, out interface{}) error
 	CAS(ctx context.Context, key string, out interface{}, f CASCallback) error
+	List(ctx context.Context, prefix string, out interface{}, f func(string, interface{})) error
 	WatchPrefix(prefix string, out interface{}, done chan struct{}, f func(string, interface{}) bool)
 }
 
@@ -73,6 +74,25 @@ func (c *consulClient) Get(ctx context.Context, key string, out interface{}) err
 	return json.NewDecoder(bytes.NewReader(kvp.Value)).Decode(out)
 }
 
+// List deserialises the JSON values of the keys with prefix, calling f with
+// each key and value.  out is reused for each value.
+func (c *consulClient) List(ctx context.Context, prefix string, out interface{}, f func(string, interface{})) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Consul List", opentracing.Tag{Key: "prefix", Value: prefix})
+	defer span.Finish()
+	kvps, _, err := c.kv.List(prefix, queryOptions)
+	if err != nil {
+		return err
+	}
+	for _, kvp := range kvps {
+		if err := json.NewDecoder(bytes.NewReader(kvp.Value)).Decode(out); err != nil {
+			log.Errorf("Error deserialising %s: %v", kvp.Key, err)
+			continue
+		}
+		f(kvp.Key, out)
+	}
+	return nil
+}
+
 // CAS atomically modify a value in a callback.
 // If value doesn't exist you'll get nil as a argument to your callback.
 func (c *consulClient) CAS(ctx context.Context, key string, out interface{}, f CASCallback) error {
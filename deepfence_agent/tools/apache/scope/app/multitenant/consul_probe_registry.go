@@ -0,0 +1,152 @@
+package multitenant
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/app"
+)
+
+// consulProbeFlushInterval is how long the updates of the statuses of the
+// probes of a user are batched for, before they're written to consul
+// together.  It's well within the interval the probes are stale after.
+const consulProbeFlushInterval = time.Second
+
+type probeUpdate func(app.ProbeStatus) app.ProbeStatus
+
+// consulProbeRegistry keeps the statuses of the probes of each user in a
+// consul key, so that all replicas of the app agree on them.  The updates
+// of each replica are written in batches, rather than one for each report
+// POSTed, which would contend on the key of a user with many probes.
+type consulProbeRegistry struct {
+	client   ConsulClient
+	prefix   string
+	userIDer UserIDer
+	expiry   time.Duration
+
+	mtx     sync.Mutex
+	pending map[string]map[string]probeUpdate // by key, then probe ID
+}
+
+// NewConsulProbeRegistry returns a new probe registry backed by consul,
+// forgetting probes not heard from for expiry.
+func NewConsulProbeRegistry(client ConsulClient, prefix string, userIDer UserIDer, expiry time.Duration) app.ProbeRegistry {
+	return &consulProbeRegistry{
+		client:   client,
+		prefix:   prefix,
+		userIDer: userIDer,
+		expiry:   expiry,
+		pending:  map[string]map[string]probeUpdate{},
+	}
+}
+
+func (pr *consulProbeRegistry) key(ctx context.Context) (string, error) {
+	userID, err := pr.userIDer(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s", pr.prefix, userID), nil
+}
+
+// UpdateProbe implements app.ProbeRegistry.  The update is written with
+// the others of the user within consulProbeFlushInterval.
+func (pr *consulProbeRegistry) UpdateProbe(ctx context.Context, probeID string, f func(app.ProbeStatus) app.ProbeStatus) error {
+	key, err := pr.key(ctx)
+	if err != nil {
+		return err
+	}
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	updates, ok := pr.pending[key]
+	if !ok {
+		updates = map[string]probeUpdate{}
+		pr.pending[key] = updates
+		time.AfterFunc(consulProbeFlushInterval, func() {
+			if err := pr.flush(key); err != nil {
+				log.Warnf("Error updating statuses of probes in %q: %v", key, err)
+			}
+		})
+	}
+	if previous, ok := updates[probeID]; ok {
+		updates[probeID] = func(s app.ProbeStatus) app.ProbeStatus { return f(previous(s)) }
+	} else {
+		updates[probeID] = f
+	}
+	return nil
+}
+
+// flush writes the pending updates of the probes under key.
+func (pr *consulProbeRegistry) flush(key string) error {
+	pr.mtx.Lock()
+	updates := pr.pending[key]
+	delete(pr.pending, key)
+	pr.mtx.Unlock()
+	if len(updates) == 0 {
+		return nil
+	}
+	return pr.client.CAS(context.Background(), key, &app.ProbeStatuses{}, func(in interface{}) (interface{}, bool, error) {
+		// The value decoded is reused across retries, so isn't modified
+		probes := app.ProbeStatuses{}
+		if in != nil {
+			for id, status := range *(in.(*app.ProbeStatuses)) {
+				probes[id] = status
+			}
+		}
+		for probeID, f := range updates {
+			status := probes[probeID]
+			status.ID = probeID
+			probes[probeID] = f(status)
+		}
+		probes.Expire(mtime.Now().Add(-pr.expiry))
+		return &probes, false, nil
+	})
+}
+
+// Tenants implements app.TenantLister, listing the users with probes not
+// yet expired.
+func (pr *consulProbeRegistry) Tenants(ctx context.Context) ([]string, error) {
+	var (
+		tenants []string
+		oldest  = mtime.Now().Add(-pr.expiry)
+	)
+	err := pr.client.List(ctx, pr.prefix, &app.ProbeStatuses{}, func(key string, value interface{}) {
+		// The value is decoded into the same map for each key
+		probes := *(value.(*app.ProbeStatuses))
+		probes.Expire(oldest)
+		if len(probes) > 0 {
+			tenants = append(tenants, strings.TrimPrefix(key, pr.prefix))
+		}
+		for id := range probes {
+			delete(probes, id)
+		}
+	})
+	return tenants, err
+}
+
+func (pr *consulProbeRegistry) Probes(ctx context.Context) (app.ProbeStatuses, error) {
+	key, err := pr.key(ctx)
+	if err != nil {
+		return nil, err
+	}
+	probes := app.ProbeStatuses{}
+	if err := pr.client.Get(ctx, key, &probes); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	// This replica's updates not yet written are seen at once
+	pr.mtx.Lock()
+	for probeID, f := range pr.pending[key] {
+		status := probes[probeID]
+		status.ID = probeID
+		probes[probeID] = f(status)
+	}
+	pr.mtx.Unlock()
+	probes.Expire(mtime.Now().Add(-pr.expiry))
+	return probes, nil
+}
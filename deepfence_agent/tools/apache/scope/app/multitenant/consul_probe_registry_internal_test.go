@@ -0,0 +1,130 @@
+package multitenant
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/app"
+)
+
+func TestConsulProbeRegistry(t *testing.T) {
+	var (
+		client = newMockConsulClient()
+		now    = time.Now()
+		ctx    = context.Background()
+	)
+	userIDer := func(context.Context) (string, error) { return "user1", nil }
+	// Replicas of the app share the statuses of probes
+	replicas := []app.ProbeRegistry{
+		NewConsulProbeRegistry(client, "probes/", userIDer, time.Hour),
+		NewConsulProbeRegistry(client, "probes/", userIDer, time.Hour),
+	}
+	for i, id := range []string{"probe1", "probe2"} {
+		if err := replicas[i].UpdateProbe(ctx, id, func(s app.ProbeStatus) app.ProbeStatus {
+			return s.Reported("host-"+id, "1.0", now)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each replica sees its own updates at once, and the others' once
+	// they're written
+	for i, r := range replicas {
+		probes, err := r.Probes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(probes) != 1 || probes[fmt.Sprintf("probe%d", i+1)].ID == "" {
+			t.Errorf("Expected only the probe of replica %d, got %v", i, probes)
+		}
+	}
+	for _, r := range replicas {
+		if err := r.(*consulProbeRegistry).flush("probes/user1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range replicas {
+		probes, err := r.Probes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(probes) != 2 || probes["probe2"].Hostname != "host-probe2" {
+			t.Errorf("Expected both probes, got %v", probes)
+		}
+	}
+
+	// Other users have probes of their own
+	other := NewConsulProbeRegistry(client, "probes/", func(context.Context) (string, error) { return "user2", nil }, time.Hour)
+	if probes, err := other.Probes(ctx); err != nil || len(probes) != 0 {
+		t.Errorf("Expected no probes, got %v, %v", probes, err)
+	}
+}
+
+func TestConsulProbeRegistryTenants(t *testing.T) {
+	var (
+		client = newMockConsulClient()
+		now    = time.Now()
+	)
+	for _, user := range []string{"user1", "user2"} {
+		user := user
+		registry := NewConsulProbeRegistry(client, "probes/", func(context.Context) (string, error) { return user, nil }, time.Hour)
+		heard := now
+		if user == "user2" {
+			heard = now.Add(-2 * time.Hour)
+		}
+		if err := registry.UpdateProbe(context.Background(), "probe-"+user, func(s app.ProbeStatus) app.ProbeStatus {
+			return s.Reported("host", "1.0", heard)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := registry.(*consulProbeRegistry).flush("probes/" + user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The probes of user2 have expired
+	tenants, err := NewConsulProbeRegistry(client, "probes/", NoopUserIDer, time.Hour).(app.TenantLister).Tenants(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 1 || tenants[0] != "user1" {
+		t.Errorf("Expected only user1, got %v", tenants)
+	}
+}
+
+func TestConsulProbeRegistryBatches(t *testing.T) {
+	var (
+		client = newMockConsulClient()
+		now    = time.Now()
+		ctx    = context.Background()
+	)
+	registry := NewConsulProbeRegistry(client, "probes/", func(context.Context) (string, error) { return "user1", nil }, time.Hour)
+	for i := 0; i < 3; i++ {
+		for _, id := range []string{"probe1", "probe2"} {
+			heard := now.Add(time.Duration(i) * time.Second)
+			if err := registry.UpdateProbe(ctx, id, func(s app.ProbeStatus) app.ProbeStatus {
+				return s.Reported("host", "1.0", heard)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := registry.(*consulProbeRegistry).flush("probes/user1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// All the updates are written at once, in order
+	if writes := client.(*consulClient).kv.(*mockKV).kvps["probes/user1"].ModifyIndex; writes != 1 {
+		t.Errorf("Expected a single write, got %d", writes)
+	}
+	probes, err := registry.Probes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"probe1", "probe2"} {
+		if status := probes[id]; !status.LastSeen.Equal(now.Add(2*time.Second)) || status.Interval != time.Second {
+			t.Errorf("Expected %s last seen at %v a second after the previous report, got %v", id, now.Add(2*time.Second), status)
+		}
+	}
+}
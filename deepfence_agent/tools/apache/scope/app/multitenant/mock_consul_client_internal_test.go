@@ -1,6 +1,7 @@
 package multitenant
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -81,16 +82,18 @@ func (m *mockKV) Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consu
 func (m *mockKV) List(prefix string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error) {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
-	deadline := time.Now().Add(q.WaitTime)
-	for m.next <= q.WaitIndex && time.Now().Before(deadline) {
-		m.cond.Wait()
-	}
-	if time.Now().After(deadline) {
-		return nil, &consul.QueryMeta{LastIndex: q.WaitIndex}, nil
+	if q.WaitTime > 0 {
+		deadline := time.Now().Add(q.WaitTime)
+		for m.next <= q.WaitIndex && time.Now().Before(deadline) {
+			m.cond.Wait()
+		}
+		if time.Now().After(deadline) {
+			return nil, &consul.QueryMeta{LastIndex: q.WaitIndex}, nil
+		}
 	}
 	result := consul.KVPairs{}
 	for _, kvp := range m.kvps {
-		if kvp.LockIndex >= q.WaitIndex {
+		if kvp.LockIndex >= q.WaitIndex && strings.HasPrefix(kvp.Key, prefix) {
 			result = append(result, copyKVPair(kvp))
 		}
 	}
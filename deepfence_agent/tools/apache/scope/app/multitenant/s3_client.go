@@ -2,12 +2,15 @@ package multitenant
 
 import (
 	"bytes"
+	"io/ioutil"
 	"sync"
 
 	"context"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/weaveworks/common/instrument"
@@ -46,6 +49,8 @@ func NewS3Client(config *aws.Config, bucketName string) S3Store {
 
 // FetchReports fetches multiple reports in parallel from S3.
 func (store *S3Store) FetchReports(ctx context.Context, keys []string) (map[string]report.Report, []string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "S3Store.FetchReports", opentracing.Tag{Key: "keys", Value: len(keys)})
+	defer span.Finish()
 	type result struct {
 		key    string
 		report *report.Report
@@ -90,6 +95,26 @@ func (store *S3Store) fetchReport(ctx context.Context, key string) (*report.Repo
 	return report.MakeFromBinary(ctx, resp.Body, true, 1)
 }
 
+// FetchBytes fetches what's stored under key, nil if nothing is.
+func (store *S3Store) FetchBytes(ctx context.Context, key string) ([]byte, error) {
+	var resp *s3.GetObjectOutput
+	err := instrument.TimeRequestHistogram(ctx, "S3.Get", s3RequestDuration, func(_ context.Context) error {
+		var err error
+		resp, err = store.s3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(store.bucketName),
+			Key:    aws.String(key),
+		})
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
 // StoreReportBytes stores a report.
 func (store *S3Store) StoreReportBytes(ctx context.Context, key string, buf []byte) (int, error) {
 	err := instrument.TimeRequestHistogram(ctx, "S3.Put", s3RequestDuration, func(_ context.Context) error {
@@ -209,7 +209,8 @@ func (cr *sqsControlRouter) sendMessage(ctx context.Context, queueURL *string, m
 	})
 }
 
-func (cr *sqsControlRouter) Handle(ctx context.Context, probeID string, req xfer.Request) (xfer.Response, error) {
+// Handle routes a request to a probe over SQS, which relays no progress.
+func (cr *sqsControlRouter) Handle(ctx context.Context, probeID string, req xfer.Request, _ func(xfer.Progress)) (xfer.Response, error) {
 	// Make sure we know the users
 	userID, err := cr.userIDer(ctx)
 	if err != nil {
@@ -263,10 +264,12 @@ func (cr *sqsControlRouter) Handle(ctx context.Context, probeID string, req xfer
 		return response, nil
 	case <-time.After(cr.rpcTimeout):
 		return xfer.Response{}, fmt.Errorf("request timed out")
+	case <-ctx.Done():
+		return xfer.Response{}, ctx.Err()
 	}
 }
 
-func (cr *sqsControlRouter) Register(ctx context.Context, probeID string, handler xfer.ControlHandlerFunc) (int64, error) {
+func (cr *sqsControlRouter) Register(ctx context.Context, probeID string, handler xfer.ControlContextHandlerFunc) (int64, error) {
 	userID, err := cr.userIDer(ctx)
 	if err != nil {
 		return 0, err
@@ -311,7 +314,7 @@ type probeWorker struct {
 	ctx             context.Context
 	router          *sqsControlRouter
 	requestQueueURL *string
-	handler         xfer.ControlHandlerFunc
+	handler         xfer.ControlContextHandlerFunc
 	quit            chan struct{}
 	done            sync.WaitGroup
 }
@@ -359,7 +362,7 @@ func (pw *probeWorker) loop() {
 				continue
 			}
 
-			response := pw.handler(sqsRequest.Request)
+			response := pw.handler(pw.ctx, sqsRequest.Request, nil)
 
 			if err := pw.router.sendMessage(pw.ctx, &sqsRequest.ResponseQueueURL, sqsResponseMessage{
 				ID:       sqsRequest.ID,
@@ -35,3 +35,19 @@ func UserIDHeader(headerName string) UserIDer {
 func NoopUserIDer(context.Context) (string, error) {
 	return "", nil
 }
+
+// WithUserIDHeader returns a func giving contexts whose requests are those
+// of the given user, as identified by UserIDHeader(headerName).
+func WithUserIDHeader(headerName string) func(context.Context, string) context.Context {
+	return func(ctx context.Context, userID string) context.Context {
+		request, ok := ctx.Value(app.RequestCtxKey).(*http.Request)
+		if !ok || request == nil {
+			request = &http.Request{Header: http.Header{}}
+		} else {
+			request = request.WithContext(ctx)
+			request.Header = request.Header.Clone()
+		}
+		request.Header.Set(headerName, userID)
+		return context.WithValue(ctx, app.RequestCtxKey, request)
+	}
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Origin is where the value of a latest key of a merged node came from:
+// the probe whose report had the newest value, when the report was
+// received, and the timestamp of the value.
+type Origin struct {
+	ProbeID         string    `json:"probeId,omitempty"`
+	ReportTimestamp time.Time `json:"reportTimestamp"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// OriginReporter is a Reporter which knows the probes its reports came
+// from, and so where the values of nodes in them came from.
+type OriginReporter interface {
+	// Origins returns the origins of the latest values of the nodes with
+	// the given IDs in the report at timestamp, by node ID and key.
+	Origins(ctx context.Context, timestamp time.Time, nodeIDs []string) (map[string]map[string]Origin, error)
+}
+
+// receivedReport is a report as received from a probe, before it is
+// merged with others.
+type receivedReport struct {
+	report    report.Report
+	timestamp time.Time
+	probeID   string
+}
+
+// latestOrigins returns the origins of the latest values of the nodes with
+// the given IDs in received, by node ID and key.  As when merging them, the
+// newest value of each key wins.
+func latestOrigins(received []receivedReport, nodeIDs []string) map[string]map[string]Origin {
+	origins := map[string]map[string]Origin{}
+	for _, r := range received {
+		r.report.WalkTopologies(func(t *report.Topology) {
+			for _, nodeID := range nodeIDs {
+				node, ok := t.Nodes[nodeID]
+				if !ok {
+					continue
+				}
+				nodeOrigins, ok := origins[nodeID]
+				if !ok {
+					nodeOrigins = map[string]Origin{}
+					origins[nodeID] = nodeOrigins
+				}
+				node.Latest.ForEach(func(key string, ts time.Time, _ string) {
+					if origin, ok := nodeOrigins[key]; ok && !ts.After(origin.Timestamp) {
+						return
+					}
+					nodeOrigins[key] = Origin{ProbeID: r.probeID, ReportTimestamp: r.timestamp, Timestamp: ts}
+				})
+			}
+		})
+	}
+	return origins
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"context"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/common/xfer"
@@ -7,7 +7,6 @@ import (
 
 	"github.com/gorilla/mux"
 	opentracing "github.com/opentracing/opentracing-go"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/weaveworks/scope/common/xfer"
 )
@@ -67,6 +66,7 @@ func handlePipeWs(pr PipeRouter, end End) CtxHandlerFunc {
 			return
 		}
 		defer conn.Close()
+		defer trackWebsocket(pipeWebsocket)()
 
 		if _, err := pipe.CopyToWebsocket(endIO, conn); err != nil {
 			if span := opentracing.SpanFromContext(ctx); span != nil {
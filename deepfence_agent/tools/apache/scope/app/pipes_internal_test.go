@@ -98,7 +98,7 @@ func TestPipeClose(t *testing.T) {
 	}
 
 	// this is a client to the app
-	pipeURL := fmt.Sprintf("ws://%s:%s/api/pipe/%s", ip, port, pipeID)
+	pipeURL := fmt.Sprintf("ws://%s:%s/topology-api/pipe/%s", ip, port, pipeID)
 	conn, _, err := websocket.DefaultDialer.Dial(pipeURL, http.Header{})
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	// A probe is stale when it hasn't reported for this many of its
+	// intervals between reports
+	probeStaleIntervals = 3
+
+	// The interval assumed of probes until they have reported twice; the
+	// default of probe.publish.interval
+	defaultProbeInterval = 3 * time.Second
+
+	// Weight of the latest interval in the rate of reports of probes
+	probeRateWeight = 0.25
+)
+
+// ProbeStatus is what the app knows of a probe publishing reports to it.
+type ProbeStatus struct {
+	ID            string             `json:"id"`
+	Hostname      string             `json:"hostname"`
+	Version       string             `json:"version"`
+	LastSeen      time.Time          `json:"lastSeen"` // when its last report arrived
+	Interval      time.Duration      `json:"interval"` // between its last two reports
+	ReportRate    float64            `json:"reportsPerSecond"`
+	LastError     string             `json:"lastError,omitempty"`
+	LastErrorAt   time.Time          `json:"lastErrorAt,omitempty"`
+	Capabilities  []string           `json:"capabilities,omitempty"` // accepted in its handshake; none from legacy probes
+	LastHandshake time.Time          `json:"lastHandshake,omitempty"`
+	Truncation    *report.Truncation `json:"truncation,omitempty"` // what it trimmed from its last report; nil if nothing
+	Stripped      map[string]int     `json:"stripped,omitempty"`   // nodes stripped from its last report for violating the report limits, by class of violation
+	Stale         bool               `json:"stale"`                // set when returned by the API
+}
+
+// Reported updates the status of a probe for a report which arrived at
+// now.
+func (s ProbeStatus) Reported(hostname, version string, now time.Time) ProbeStatus {
+	if !s.LastSeen.IsZero() && now.After(s.LastSeen) {
+		s.Interval = now.Sub(s.LastSeen)
+		rate := 1 / s.Interval.Seconds()
+		if s.ReportRate == 0 {
+			s.ReportRate = rate
+		} else {
+			s.ReportRate = probeRateWeight*rate + (1-probeRateWeight)*s.ReportRate
+		}
+	}
+	if hostname != "" {
+		s.Hostname = hostname
+	}
+	if version != "" {
+		s.Version = version
+	}
+	s.LastSeen = now
+	return s
+}
+
+// Truncated updates the status of a probe for what it trimmed from the
+// report it last published.
+func (s ProbeStatus) Truncated(t report.Truncation) ProbeStatus {
+	s.Truncation = nil
+	if t.Truncated() {
+		s.Truncation = &t
+	}
+	return s
+}
+
+// Sanitized updates the status of a probe for the violations of the
+// report limits stripped from the report it last published.
+func (s ProbeStatus) Sanitized(violations []report.Violation) ProbeStatus {
+	s.Stripped = nil
+	for _, v := range violations {
+		if s.Stripped == nil {
+			s.Stripped = map[string]int{}
+		}
+		s.Stripped[v.Class]++
+	}
+	return s
+}
+
+// Handshaken updates the status of a probe for its handshake at now,
+// accepting capabilities.
+func (s ProbeStatus) Handshaken(version string, capabilities map[string]bool, now time.Time) ProbeStatus {
+	if version != "" {
+		s.Version = version
+	}
+	s.LastHandshake = now
+	s.Capabilities = make([]string, 0, len(capabilities))
+	for capability := range capabilities {
+		s.Capabilities = append(s.Capabilities, capability)
+	}
+	sort.Strings(s.Capabilities)
+	return s
+}
+
+// Failed updates the status of a probe for a report which was refused at
+// now.
+func (s ProbeStatus) Failed(version string, err error, now time.Time) ProbeStatus {
+	if version != "" {
+		s.Version = version
+	}
+	s.LastError = err.Error()
+	s.LastErrorAt = now
+	return s
+}
+
+// IsStale reports whether a probe has missed its reports, as of now.
+func (s ProbeStatus) IsStale(now time.Time) bool {
+	interval := s.Interval
+	if interval == 0 {
+		interval = defaultProbeInterval
+	}
+	return now.Sub(s.lastHeard()) > probeStaleIntervals*interval
+}
+
+// lastHeard is when a probe last sent anything, a report or not.
+func (s ProbeStatus) lastHeard() time.Time {
+	last := s.LastSeen
+	for _, t := range []time.Time{s.LastErrorAt, s.LastHandshake} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// ProbeStatuses are the statuses of probes, by ID.
+type ProbeStatuses map[string]ProbeStatus
+
+// Expire drops the probes not heard from since before cutoff.
+func (s ProbeStatuses) Expire(cutoff time.Time) {
+	for id, status := range s {
+		if status.lastHeard().Before(cutoff) {
+			delete(s, id)
+		}
+	}
+}
+
+// List returns the statuses in order of probe ID, marked stale as of now.
+func (s ProbeStatuses) List(now time.Time) []ProbeStatus {
+	result := make([]ProbeStatus, 0, len(s))
+	for _, status := range s {
+		status.Stale = status.IsStale(now)
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// ProbeRegistry tracks the probes publishing reports to the app.
+type ProbeRegistry interface {
+	UpdateProbe(ctx context.Context, probeID string, f func(ProbeStatus) ProbeStatus) error
+	Probes(ctx context.Context) (ProbeStatuses, error)
+}
+
+// NewLocalProbeRegistry creates a new ProbeRegistry that keeps the statuses
+// of probes in memory, forgetting probes not heard from for expiry.
+func NewLocalProbeRegistry(expiry time.Duration) ProbeRegistry {
+	return &localProbeRegistry{
+		expiry: expiry,
+		probes: ProbeStatuses{},
+	}
+}
+
+type localProbeRegistry struct {
+	sync.Mutex
+	expiry time.Duration
+	probes ProbeStatuses
+}
+
+func (l *localProbeRegistry) UpdateProbe(_ context.Context, probeID string, f func(ProbeStatus) ProbeStatus) error {
+	l.Lock()
+	defer l.Unlock()
+	status := l.probes[probeID]
+	status.ID = probeID
+	l.probes[probeID] = f(status)
+	l.probes.Expire(mtime.Now().Add(-l.expiry))
+	return nil
+}
+
+func (l *localProbeRegistry) Probes(_ context.Context) (ProbeStatuses, error) {
+	l.Lock()
+	defer l.Unlock()
+	l.probes.Expire(mtime.Now().Add(-l.expiry))
+	result := make(ProbeStatuses, len(l.probes))
+	for id, status := range l.probes {
+		result[id] = status
+	}
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestProbeStatus(t *testing.T) {
+	now := time.Now()
+	var s app.ProbeStatus
+	s = s.Reported("host1", "1.0", now)
+	equals(t, time.Duration(0), s.Interval)
+	equals(t, 0.0, s.ReportRate)
+
+	s = s.Reported("", "", now.Add(2*time.Second))
+	equals(t, "host1", s.Hostname)
+	equals(t, "1.0", s.Version)
+	equals(t, 2*time.Second, s.Interval)
+	equals(t, 0.5, s.ReportRate)
+
+	// Stale after three intervals without a report
+	equals(t, false, s.IsStale(now.Add(8*time.Second)))
+	equals(t, true, s.IsStale(now.Add(9*time.Second)))
+
+	s = s.Failed("1.1", fmt.Errorf("bad report"), now.Add(4*time.Second))
+	equals(t, "bad report", s.LastError)
+	equals(t, "1.1", s.Version)
+	equals(t, now.Add(2*time.Second), s.LastSeen)
+
+	s = s.Truncated(report.Truncation{Processes: 10})
+	equals(t, &report.Truncation{Processes: 10}, s.Truncation)
+	s = s.Truncated(report.Truncation{})
+	equals(t, (*report.Truncation)(nil), s.Truncation)
+
+	s = s.Sanitized([]report.Violation{
+		{Class: report.ViolationTimestamp, Topology: report.Host, NodeID: "a"},
+		{Class: report.ViolationTimestamp, Topology: report.Host, NodeID: "b"},
+		{Class: report.ViolationNodeCount, Topology: report.Process},
+	})
+	equals(t, map[string]int{report.ViolationTimestamp: 2, report.ViolationNodeCount: 1}, s.Stripped)
+	s = s.Sanitized(nil)
+	equals(t, map[string]int(nil), s.Stripped)
+}
+
+func TestLocalProbeRegistry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	r := app.NewLocalProbeRegistry(time.Minute)
+	for _, id := range []string{"b", "a"} {
+		ok(t, r.UpdateProbe(ctx, id, func(s app.ProbeStatus) app.ProbeStatus {
+			return s.Reported("host-"+id, "1.0", now)
+		}))
+	}
+	probes, err := r.Probes(ctx)
+	ok(t, err)
+	list := probes.List(now)
+	equals(t, 2, len(list))
+	equals(t, "a", list[0].ID)
+	equals(t, "host-a", list[0].Hostname)
+
+	// Probes not heard from are forgotten
+	mtime.NowForce(now.Add(30 * time.Second))
+	ok(t, r.UpdateProbe(ctx, "a", func(s app.ProbeStatus) app.ProbeStatus {
+		return s.Reported("", "", now.Add(30*time.Second))
+	}))
+	mtime.NowForce(now.Add(61 * time.Second))
+	probes, err = r.Probes(ctx)
+	ok(t, err)
+	equals(t, 1, len(probes))
+	_, found := probes["a"]
+	equals(t, true, found)
+}
+
+func TestProbeRoutes(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	probes := app.NewLocalProbeRegistry(time.Hour)
+	app.RegisterReportPostHandler(c, router, probes)
+	app.RegisterProbeRoutes(router, c, probes)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNode("probe-host;<host>").
+		WithLatest(report.HostName, now, "probe-host"))
+	post := func(contentType string) int {
+		buf, err := rpt.WriteProtobuf()
+		ok(t, err)
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", buf)
+		ok(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(xfer.ScopeProbeIDHeader, "probe1")
+		req.Header.Set(xfer.ScopeProbeVersionHeader, "1.2.3")
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	getProbe := func() app.ProbeStatus {
+		var status app.ProbeStatus
+		body := getRawJSON(t, ts, "/topology-api/probes/probe1")
+		ok(t, codec.NewDecoder(bytes.NewReader(body), &codec.JsonHandle{}).Decode(&status))
+		return status
+	}
+
+	equals(t, http.StatusOK, post(xfer.ProtobufContentType))
+	mtime.NowForce(now.Add(time.Second))
+	equals(t, http.StatusOK, post(xfer.ProtobufContentType))
+
+	var list []app.ProbeStatus
+	body := getRawJSON(t, ts, "/topology-api/probes")
+	ok(t, codec.NewDecoder(bytes.NewReader(body), &codec.JsonHandle{}).Decode(&list))
+	equals(t, 1, len(list))
+	equals(t, "probe1", list[0].ID)
+	equals(t, "probe-host", list[0].Hostname)
+	equals(t, "1.2.3", list[0].Version)
+	equals(t, time.Second, list[0].Interval)
+	equals(t, false, list[0].Stale)
+
+	// Refused reports are recorded
+	equals(t, http.StatusBadRequest, post("application/x-unknown"))
+	equals(t, "Unsupported Content-Type: application/x-unknown", getProbe().LastError)
+
+	// Silent probes go stale
+	mtime.NowForce(now.Add(time.Minute))
+	equals(t, true, getProbe().Stale)
+
+	is404(t, ts, "/topology-api/probes/probe2")
+}
+
+func TestHandshake(t *testing.T) {
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	probes := app.NewLocalProbeRegistry(time.Hour)
+	app.RegisterHandshakeHandler(router, probes, map[string]bool{
+		xfer.ProtobufReportsCapability: true,
+		xfer.ReportDeltasCapability:    true,
+	})
+	app.RegisterReportPostHandler(c, router, probes)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post := func(probeID, path string, body *bytes.Buffer, contentType string) *http.Response {
+		req, err := http.NewRequest("POST", ts.URL+path, body)
+		ok(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(xfer.ScopeProbeIDHeader, probeID)
+		resp, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		return resp
+	}
+
+	// New probes shake hands, and are told what of theirs is accepted
+	buf := &bytes.Buffer{}
+	ok(t, codec.NewEncoder(buf, &codec.JsonHandle{}).Encode(xfer.Handshake{
+		Version:      "2.0",
+		Capabilities: map[string]bool{xfer.ReportDeltasCapability: true, "zstd": true},
+	}))
+	resp := post("new", "/topology-api/handshake", buf, "application/json")
+	equals(t, http.StatusOK, resp.StatusCode)
+	var handshake xfer.Handshake
+	ok(t, codec.NewDecoder(resp.Body, &codec.JsonHandle{}).Decode(&handshake))
+	resp.Body.Close()
+	equals(t, map[string]bool{xfer.ReportDeltasCapability: true}, handshake.Capabilities)
+
+	// Legacy probes don't, and publish all the same
+	rpt := report.MakeReport()
+	for _, probeID := range []string{"new", "legacy"} {
+		buf, err := rpt.WriteBinary()
+		ok(t, err)
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", buf)
+		ok(t, err)
+		req.Header.Set("Content-Type", xfer.MsgpackContentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(xfer.ScopeProbeIDHeader, probeID)
+		resp, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		resp.Body.Close()
+		equals(t, http.StatusOK, resp.StatusCode)
+	}
+	statuses, err := probes.Probes(context.Background())
+	ok(t, err)
+	equals(t, "2.0", statuses["new"].Version)
+	equals(t, []string{xfer.ReportDeltasCapability}, statuses["new"].Capabilities)
+	equals(t, 0, len(statuses["legacy"].Capabilities))
+}
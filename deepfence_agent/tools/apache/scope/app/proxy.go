@@ -0,0 +1,112 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// URLPrefix - set at runtime, the path the app is served under behind a
+// reverse proxy, e.g. "/scope", or empty when it is served at the root.
+// The URLs of topologies are given under it.
+var URLPrefix = ""
+
+// StripPrefix serves requests for paths under prefix with handler, with the
+// prefix taken off, and redirects requests for the prefix itself to it with
+// a trailing slash.  Requests for other paths aren't found.
+func StripPrefix(prefix string, handler http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			// URLMatcher matches the raw RequestURI, so that loses the
+			// prefix too
+			stripped := new(http.Request)
+			*stripped = *r
+			stripped.URL = new(url.URL)
+			*stripped.URL = *r.URL
+			stripped.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+			stripped.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+			stripped.RequestURI = strings.TrimPrefix(r.RequestURI, prefix)
+			handler.ServeHTTP(w, stripped)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+const (
+	corsMethods = "GET, POST, DELETE, OPTIONS"
+	corsHeaders = "Authorization, Content-Type"
+	corsMaxAge  = "600"
+)
+
+// CORS is middleware letting pages from other origins call the API, under
+// /topology-api.  Preflight requests are answered without being passed on,
+// as browsers don't authenticate them.
+type CORS struct {
+	// Origins allowed, such as "https://console.example.com", or "*" for
+	// all of them, though without credentials
+	Origins []string
+}
+
+// Wrap implements middleware.Interface.
+func (c CORS) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (r.URL.Path != "/topology-api" && !strings.HasPrefix(r.URL.Path, "/topology-api/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		allowOrigin, credentials := c.allows(origin)
+		allowed := allowOrigin != ""
+		if allowed {
+			header.Set("Access-Control-Allow-Origin", allowOrigin)
+		}
+		if credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Forbidden: origin not allowed", http.StatusForbidden)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", corsMethods)
+		header.Set("Access-Control-Allow-Headers", corsHeaders)
+		header.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allows returns the Access-Control-Allow-Origin of a request from origin,
+// if it's allowed, and whether it may be made with credentials.  Only
+// origins listed may: pages of any origin calling the API as the user
+// would be as good as no authentication at all, so "*" allows calls
+// without credentials only.
+func (c CORS) allows(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range c.Origins {
+		if allowed == origin {
+			return origin, true
+		}
+		wildcard = wildcard || allowed == "*"
+	}
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+package app_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/render/detailed"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+func TestStripPrefix(t *testing.T) {
+	defer func(prefix string) { app.URLPrefix = prefix }(app.URLPrefix)
+	app.URLPrefix = "/scope"
+	router := mux.NewRouter().SkipClean(true)
+	app.RegisterTopologyRoutes(router, app.StaticCollector(fixture.Report), nil)
+	ts := httptest.NewServer(app.StripPrefix(app.URLPrefix, router))
+	defer ts.Close()
+
+	// Topologies are listed with URLs under the prefix
+	var topologies []app.APITopologyDesc
+	body := getRawJSON(t, ts, "/scope/topology-api/topology")
+	ok(t, codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&topologies))
+	assert(t, len(topologies) > 0, "no topologies")
+	for _, topology := range topologies {
+		assert(t, strings.HasPrefix(topology.URL, "/scope/topology-api/topology/"), "topology URL %s", topology.URL)
+		for _, sub := range topology.SubTopologies {
+			assert(t, strings.HasPrefix(sub.URL, "/scope/topology-api/topology/"), "sub-topology URL %s", sub.URL)
+		}
+	}
+	// ...every time
+	body = getRawJSON(t, ts, "/scope/topology-api/topology")
+	ok(t, codec.NewDecoderBytes(body, &codec.JsonHandle{}).Decode(&topologies))
+	assert(t, !strings.HasPrefix(topologies[0].URL, "/scope/scope"), "prefixed twice: %s", topologies[0].URL)
+
+	// Routes matching the raw URI
+	getRawJSON(t, ts, "/scope/topology-api/topology/containers/"+url.QueryEscape(fixture.ClientContainerNodeID))
+	is404(t, ts, "/topology-api/topology")
+	is404(t, ts, "/scopes/topology-api/topology")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(ts.URL + "/scope")
+	ok(t, err)
+	res.Body.Close()
+	equals(t, http.StatusMovedPermanently, res.StatusCode)
+	equals(t, "/scope/", res.Header.Get("Location"))
+
+	// Websockets upgrade under the prefix
+	ws, res, err := (&websocket.Dialer{}).Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/scope/topology-api/topology/processes/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	equals(t, http.StatusSwitchingProtocols, res.StatusCode)
+	var d detailed.Diff
+	_, p, err := ws.ReadMessage()
+	ok(t, err)
+	ok(t, codec.NewDecoderBytes(p, &codec.JsonHandle{}).Decode(&d))
+	assert(t, len(d.Add) > 0, "no nodes added")
+}
+
+func TestCORS(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	ts := httptest.NewServer(app.CORS{Origins: []string{"https://console.example.com"}}.Wrap(api))
+	defer ts.Close()
+
+	do := func(method, path, origin string, preflight bool) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		ok(t, err)
+		req.Header.Set("Origin", origin)
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "authorization")
+		}
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	res := do("OPTIONS", "/topology-api/control/probe1/node1/restart", "https://console.example.com", true)
+	equals(t, http.StatusNoContent, res.StatusCode)
+	equals(t, "https://console.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert(t, strings.Contains(res.Header.Get("Access-Control-Allow-Methods"), "POST"), "methods %q", res.Header.Get("Access-Control-Allow-Methods"))
+	assert(t, strings.Contains(res.Header.Get("Access-Control-Allow-Headers"), "Authorization"), "headers %q", res.Header.Get("Access-Control-Allow-Headers"))
+
+	res = do("OPTIONS", "/topology-api/topology", "https://evil.example.com", true)
+	equals(t, http.StatusForbidden, res.StatusCode)
+	equals(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+
+	res = do("GET", "/topology-api/topology", "https://console.example.com", false)
+	equals(t, http.StatusOK, res.StatusCode)
+	equals(t, "https://console.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	equals(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+	equals(t, "Origin", res.Header.Get("Vary"))
+
+	res = do("GET", "/topology-api/topology", "https://evil.example.com", false)
+	equals(t, http.StatusOK, res.StatusCode)
+	equals(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+
+	// Any origin may call the API with "*", but never with credentials,
+	// unless listed
+	wildcard := httptest.NewServer(app.CORS{Origins: []string{"*", "https://console.example.com"}}.Wrap(api))
+	defer wildcard.Close()
+	for _, tc := range []struct{ origin, allowOrigin, credentials string }{
+		{"https://evil.example.com", "*", ""},
+		{"https://console.example.com", "https://console.example.com", "true"},
+	} {
+		req, err := http.NewRequest("GET", wildcard.URL+"/topology-api/topology", nil)
+		ok(t, err)
+		req.Header.Set("Origin", tc.origin)
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		res.Body.Close()
+		equals(t, tc.allowOrigin, res.Header.Get("Access-Control-Allow-Origin"))
+		equals(t, tc.credentials, res.Header.Get("Access-Control-Allow-Credentials"))
+	}
+
+	// Only the API
+	res = do("OPTIONS", "/ui/index.html", "https://console.example.com", true)
+	equals(t, http.StatusOK, res.StatusCode)
+	equals(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+// Default size and TTL of the cache of rendered topologies, the TTL being
+// the probes' default publish interval.
+const (
+	defaultRenderCacheSize = 64
+	defaultRenderCacheTTL  = 3 * time.Second
+)
+
+// uncachedParams are the query parameters of topologies which don't change
+// how they're rendered, only what of them is returned.
+var uncachedParams = map[string]struct{}{
+	"limit": {}, "offset": {}, "fields": {}, "edges": {}, "t": {},
+}
+
+// renderResults caches rendered topologies, so that polls of a topology
+// between reports are served without rendering it again.  Renders are keyed
+// by tenant, topology, options and the ID of the report rendered, which the
+// merger derives from the IDs of the reports merged, so a report added
+// makes for a miss.  Adding a report of a tenant also invalidates its
+// renders outright, by bumping its generation.
+type renderResults struct {
+	mtx         sync.Mutex
+	cache       gcache.Cache // nil if disabled
+	generations map[string]uint64
+}
+
+var topologyRenders = newRenderResults(defaultRenderCacheSize, defaultRenderCacheTTL)
+
+func newRenderResults(size int, ttl time.Duration) *renderResults {
+	c := &renderResults{generations: map[string]uint64{}}
+	if size > 0 && ttl > 0 {
+		c.cache = gcache.New(size).LRU().Expiration(ttl).Build()
+	}
+	return c
+}
+
+// ConfigureRenderCache sets the size, in topologies, and the TTL of the
+// cache of rendered topologies, dropping those cached.  Either being zero
+// disables it.
+func ConfigureRenderCache(size int, ttl time.Duration) {
+	topologyRenders = newRenderResults(size, ttl)
+}
+
+// key returns the key of the render of a topology of rpt, for the tenant of
+// ctx with the options given in values.
+func (c *renderResults) key(ctx context.Context, topologyID string, values url.Values, rpt report.Report) (string, error) {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return "", err
+	}
+	options := url.Values{}
+	for k, v := range values {
+		if _, ok := uncachedParams[k]; !ok {
+			options[k] = v
+		}
+	}
+	c.mtx.Lock()
+	generation := c.generations[tenant]
+	c.mtx.Unlock()
+	return strings.Join([]string{
+		tenant, strconv.FormatUint(generation, 10), topologyID, options.Encode(), rpt.ID,
+	}, "\x00"), nil
+}
+
+func (c *renderResults) get(key string) (render.Nodes, bool) {
+	if c.cache == nil {
+		return render.Nodes{}, false
+	}
+	v, err := c.cache.Get(key)
+	if err != nil {
+		renderCacheRequests.WithLabelValues("miss").Inc()
+		return render.Nodes{}, false
+	}
+	renderCacheRequests.WithLabelValues("hit").Inc()
+	return v.(render.Nodes), true
+}
+
+// set caches a render; its nodes mustn't be modified once cached.
+func (c *renderResults) set(key string, nodes render.Nodes) {
+	if c.cache != nil {
+		c.cache.Set(key, nodes)
+	}
+}
+
+// invalidate makes the renders of the tenant of ctx unreachable, leaving
+// them to be evicted, on a report of theirs being added.
+func (c *renderResults) invalidate(ctx context.Context) {
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return
+	}
+	c.mtx.Lock()
+	c.generations[tenant]++
+	c.mtx.Unlock()
+}
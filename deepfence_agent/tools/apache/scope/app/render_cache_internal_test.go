@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+func renderCacheCount(result string) float64 {
+	var m dto.Metric
+	if err := renderCacheRequests.WithLabelValues(result).Write(&m); err != nil {
+		panic(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// expectRenderCache gets each of the paths from ts, and checks whether the
+// render of each was cached.
+func expectRenderCache(t *testing.T, ts *httptest.Server, hits bool, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		hit, miss := renderCacheCount("hit"), renderCacheCount("miss")
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s", path, res.Status)
+		}
+		gotHit, gotMiss := renderCacheCount("hit")-hit, renderCacheCount("miss")-miss
+		if hits && (gotHit != 1 || gotMiss != 0) {
+			t.Errorf("%s: expected a hit", path)
+		} else if !hits && (gotHit != 0 || gotMiss != 1) {
+			t.Errorf("%s: expected a miss", path)
+		}
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	ConfigureRenderCache(defaultRenderCacheSize, time.Minute)
+	defer ConfigureRenderCache(defaultRenderCacheSize, defaultRenderCacheTTL)
+
+	collector := NewCollector(time.Minute)
+	ctx := context.Background()
+	collector.Add(ctx, fixture.Report.Copy(), nil)
+	router := mux.NewRouter().SkipClean(true)
+	RegisterTopologyRoutes(router, collector, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	expectRenderCache(t, ts, false, "/topology-api/topology/containers", "/topology-api/topology/containers?stopped=both")
+	// Pages and fields of a topology are of the same render
+	expectRenderCache(t, ts, true, "/topology-api/topology/containers", "/topology-api/topology/containers?limit=1&fields=docker_image_name")
+
+	// A report added changes the reports rendered
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNode(report.MakeHostNodeID("new")).WithTopology(report.Host))
+	collector.Add(ctx, rpt, nil)
+	expectRenderCache(t, ts, false, "/topology-api/topology/containers")
+	expectRenderCache(t, ts, true, "/topology-api/topology/containers")
+
+	// As does a report of the tenant posted, whichever reporter it goes to
+	topologyRenders.invalidate(ctx)
+	expectRenderCache(t, ts, false, "/topology-api/topology/containers")
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	ConfigureRenderCache(2, 50*time.Millisecond)
+	defer ConfigureRenderCache(defaultRenderCacheSize, defaultRenderCacheTTL)
+
+	router := mux.NewRouter().SkipClean(true)
+	RegisterTopologyRoutes(router, StaticCollector(fixture.Report), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// The least recently used is evicted
+	expectRenderCache(t, ts, false, "/topology-api/topology/containers", "/topology-api/topology/hosts", "/topology-api/topology/pods")
+	expectRenderCache(t, ts, true, "/topology-api/topology/pods")
+	expectRenderCache(t, ts, false, "/topology-api/topology/containers")
+
+	// And renders expire
+	time.Sleep(100 * time.Millisecond)
+	expectRenderCache(t, ts, false, "/topology-api/topology/pods")
+
+	disabled := newRenderResults(0, time.Minute)
+	disabled.set("key", render.Nodes{})
+	if _, ok := disabled.get("key"); ok {
+		t.Error("Expected nothing cached when disabled")
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// RenderMaxNodes, RenderTimeout and RenderConcurrency - set at runtime, the
+// guards on rendering topologies for the API, so that one tenant's giant
+// topology doesn't take down the app: the number of nodes over which the
+// nodes of a topology are counted by group rather than summarised, how long
+// rendering may take before the nodes rendered so far are returned, and how
+// many topologies each tenant may render at once.  Zero is unlimited.
+var (
+	RenderMaxNodes    = 0
+	RenderTimeout     = time.Duration(0)
+	RenderConcurrency = 0
+)
+
+// TenantID - set at runtime, identifies the tenant of a request, for the
+// render guards.  Requests are all of one tenant by default.
+var TenantID = func(context.Context) (string, error) { return "", nil }
+
+// WithTenantID - set at runtime, returns a context of the requests of the
+// given tenant, as identified by TenantID, for looking across tenants.
+var WithTenantID = func(ctx context.Context, _ string) context.Context { return ctx }
+
+// Render guards, as counted by renderGuards
+const (
+	guardMaxNodes    = "max_nodes"
+	guardDeadline    = "deadline"
+	guardConcurrency = "concurrency"
+)
+
+// renderSlots counts the topologies being rendered for each tenant.
+type renderSlots struct {
+	mtx     sync.Mutex
+	renders map[string]int
+}
+
+var tenantRenders = renderSlots{renders: map[string]int{}}
+
+// acquire takes a slot for a render of the tenant of ctx, returning false if
+// it's rendering RenderConcurrency topologies already.  Slots taken are
+// given back with release.
+func (s *renderSlots) acquire(ctx context.Context) (tenant string, ok bool, err error) {
+	tenant, err = TenantID(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if RenderConcurrency > 0 && s.renders[tenant] >= RenderConcurrency {
+		return tenant, false, nil
+	}
+	s.renders[tenant]++
+	return tenant, true, nil
+}
+
+func (s *renderSlots) release(tenant string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.renders[tenant]--; s.renders[tenant] <= 0 {
+		delete(s.renders, tenant)
+	}
+}
+
+// groupCounts counts nodes by the host they're on, or by their topology if
+// they aren't on one.
+func groupCounts(nodes report.Nodes) map[string]int {
+	counts := map[string]int{}
+	for _, n := range nodes {
+		group := n.Topology
+		if hosts, ok := n.Parents.Lookup(report.Host); ok && len(hosts) > 0 {
+			group = hosts[0]
+		}
+		counts[group]++
+	}
+	return counts
+}
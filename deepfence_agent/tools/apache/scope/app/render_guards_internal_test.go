@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/report"
+)
+
+func TestRenderConcurrency(t *testing.T) {
+	RenderConcurrency = 1
+	TenantID = func(ctx context.Context) (string, error) {
+		return ctx.Value(RequestCtxKey).(*http.Request).Header.Get("X-Tenant"), nil
+	}
+	defer func() {
+		RenderConcurrency = 0
+		TenantID = func(context.Context) (string, error) { return "", nil }
+	}()
+
+	router := mux.NewRouter().SkipClean(true)
+	RegisterTopologyRoutes(router, StaticCollector(report.MakeReport()), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	get := func(tenant string) int {
+		req, err := http.NewRequest("GET", ts.URL+"/topology-api/topology/containers", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Tenant", tenant)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// A render of tenant a is in progress
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "a")
+	tenant, ok, err := tenantRenders.acquire(context.WithValue(context.Background(), RequestCtxKey, r))
+	if err != nil || !ok {
+		t.Fatalf("Expected a slot, got %v, %v", ok, err)
+	}
+	if status := get("a"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected tenant a refused, got %d", status)
+	}
+	if status := get("b"); status != http.StatusOK {
+		t.Errorf("Expected tenant b rendered, got %d", status)
+	}
+	tenantRenders.release(tenant)
+	if status := get("a"); status != http.StatusOK {
+		t.Errorf("Expected tenant a rendered once done, got %d", status)
+	}
+}
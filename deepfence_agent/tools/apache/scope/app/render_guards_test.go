@@ -0,0 +1,126 @@
+package app_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ugorji/go/codec"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+// giantReport is a report of the containers of hosts, with containers each.
+func giantReport(hosts, containers int) report.Report {
+	rpt := report.MakeReport()
+	for h := 0; h < hosts; h++ {
+		hostID := report.MakeHostNodeID(fmt.Sprintf("host%d", h))
+		rpt.Host.AddNode(report.MakeNode(hostID).WithTopology(report.Host))
+		for c := 0; c < containers; c++ {
+			id := fmt.Sprintf("container%d-%d", h, c)
+			rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID(id), map[string]string{
+				docker.ContainerID:         id,
+				docker.ContainerName:       id,
+				docker.ContainerState:      report.StateRunning,
+				docker.ContainerStateHuman: report.StateRunning,
+				report.HostNodeID:          hostID,
+			}).WithTopology(report.Container).WithParent(report.Host, hostID))
+		}
+	}
+	return rpt
+}
+
+func getTopology(t *testing.T, ts *httptest.Server, path string) (int, app.APITopology) {
+	res, body := checkGet(t, ts, path)
+	var topo app.APITopology
+	ok(t, codec.NewDecoder(bytes.NewReader(body), &codec.JsonHandle{}).Decode(&topo))
+	return res.StatusCode, topo
+}
+
+func TestRenderGuards(t *testing.T) {
+	registerMetricsOnce.Do(app.MustRegisterMetrics)
+	defer func() {
+		app.RenderMaxNodes, app.RenderTimeout = 0, 0
+	}()
+
+	router := mux.NewRouter().SkipClean(true)
+	router.Path("/metrics").Handler(promhttp.Handler())
+	app.RegisterTopologyRoutes(router, app.StaticCollector(giantReport(10, 200)), nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	status, topo := getTopology(t, ts, "/topology-api/topology/containers")
+	equals(t, http.StatusOK, status)
+	equals(t, 2000, len(topo.Nodes))
+
+	// Too many nodes are counted by host instead, even when paged
+	app.RenderMaxNodes = 100
+	status, topo = getTopology(t, ts, "/topology-api/topology/containers")
+	equals(t, http.StatusOK, status)
+	equals(t, 0, len(topo.Nodes))
+	equals(t, 2000, topo.NodeCount)
+	equals(t, 10, len(topo.Groups))
+	equals(t, 200, topo.Groups[report.MakeHostNodeID("host3")])
+	status, topo = getTopology(t, ts, "/topology-api/topology/containers?limit=100")
+	equals(t, http.StatusOK, status)
+	equals(t, 0, len(topo.Nodes))
+	equals(t, 10, len(topo.Groups))
+
+	// Renders taking too long are given as far as they got
+	app.RenderMaxNodes, app.RenderTimeout = 0, time.Nanosecond
+	status, topo = getTopology(t, ts, "/topology-api/topology/containers")
+	equals(t, http.StatusPartialContent, status)
+	equals(t, true, topo.Truncated)
+	if len(topo.Nodes) == 2000 {
+		t.Error("Expected only some of the nodes")
+	}
+
+	_, body := checkGet(t, ts, "/metrics")
+	for _, series := range []string{
+		`scope_render_guards_total{guard="max_nodes",topology="containers"}`,
+		`scope_render_guards_total{guard="deadline",topology="containers"}`,
+	} {
+		if !strings.Contains(string(body), "\n"+series+" ") {
+			t.Errorf("Expected series %s", series)
+		}
+	}
+}
+
+func TestRenderGuardsWebsocket(t *testing.T) {
+	// Set before serving, and reset once the websocket handler has
+	// returned, as it reads the guards in its own goroutine
+	app.RenderMaxNodes = 100
+	var handlers sync.WaitGroup
+	defer func() {
+		handlers.Wait()
+		app.RenderMaxNodes = 0
+	}()
+
+	router := mux.NewRouter()
+	app.RegisterTopologyRoutes(router, app.StaticCollector(giantReport(10, 200)), nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	// Topologies over the ceiling aren't sent
+	ws, _, err := (&websocket.Dialer{}).Dial("ws"+ts.URL[len("http"):]+"/topology-api/topology/containers/ws", nil)
+	ok(t, err)
+	defer ws.Close()
+	ok(t, ws.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	if _, p, err := ws.ReadMessage(); err == nil {
+		t.Errorf("Expected no topology, got %s", p)
+	}
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// maxReplaysInFlight bounds the publishes of a replay in progress at once.
+const maxReplaysInFlight = 64
+
+// ReplayConfig is how to replay reports stored in a directory against an
+// app, to load test it.
+type ReplayConfig struct {
+	Dir    string  // of reports, as written by Report.WriteToFile
+	Target string  // URL of the app
+	Rate   float64 // reports published per second; unlimited if 0
+	Loops  int     // times each report is published, for each tenant
+
+	// Each report is published as Tenants tenants, named in TenantHeader
+	Tenants      int
+	TenantHeader string
+
+	Token string // bearer token of the app, if it takes them
+}
+
+// ReplayStats are the publishes of a replay.
+type ReplayStats struct {
+	Published int
+	Failed    int
+	Errors    map[string]int // of failed publishes, by error
+	Elapsed   time.Duration
+}
+
+func (s ReplayStats) String() string {
+	throughput := float64(s.Published) / s.Elapsed.Seconds()
+	result := fmt.Sprintf("published %d reports in %v (%.1f/s), %d failed", s.Published, s.Elapsed, throughput, s.Failed)
+	errs := make([]string, 0, len(s.Errors))
+	for err, count := range s.Errors {
+		errs = append(errs, fmt.Sprintf("\n  %d: %s", count, err))
+	}
+	sort.Strings(errs)
+	return result + strings.Join(errs, "")
+}
+
+// storedReport is a report to replay, and the time it was recorded.
+type storedReport struct {
+	name string
+	rpt  report.Report
+	at   time.Time
+}
+
+// Replay publishes the reports stored in a directory to an app through the
+// normal ingest path, as probes would, at a rate, with their timestamps
+// moved to the time they're published.  Reports are taken to have been
+// recorded at their timestamp, or the modification time of their file if
+// they have none.
+func Replay(ctx context.Context, cfg ReplayConfig) (ReplayStats, error) {
+	reports, err := readStoredReports(ctx, cfg.Dir)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	if len(reports) == 0 {
+		return ReplayStats{}, fmt.Errorf("no reports in %s", cfg.Dir)
+	}
+	tenants := cfg.Tenants
+	if tenants < 1 || cfg.TenantHeader == "" {
+		tenants = 1
+	}
+
+	limit := rate.Inf
+	if cfg.Rate > 0 {
+		limit = rate.Limit(cfg.Rate)
+	}
+	var (
+		limiter  = rate.NewLimiter(limit, 1)
+		client   = &http.Client{Timeout: 30 * time.Second}
+		start    = time.Now()
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		stats    = ReplayStats{Errors: map[string]int{}}
+		inFlight = make(chan struct{}, maxReplaysInFlight)
+	)
+	record := func(err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err != nil {
+			stats.Failed++
+			stats.Errors[err.Error()]++
+		} else {
+			stats.Published++
+		}
+	}
+replay:
+	for loop := 0; loop < cfg.Loops; loop++ {
+		for _, stored := range reports {
+			for tenant := 0; tenant < tenants; tenant++ {
+				if err := limiter.Wait(ctx); err != nil {
+					break replay
+				}
+				rpt := stored.rpt.Copy()
+				rpt.ShiftTimestamps(time.Now().Sub(stored.at))
+				// The encoding probes publish
+				buf, err := rpt.WriteProtobuf()
+				if err != nil {
+					record(err)
+					continue
+				}
+				req, err := http.NewRequest("POST", strings.TrimSuffix(cfg.Target, "/")+"/topology-api/report", buf)
+				if err != nil {
+					wg.Wait()
+					return stats, err
+				}
+				req = req.WithContext(ctx)
+				req.Header.Set("Content-Type", xfer.ProtobufContentType)
+				req.Header.Set("Content-Encoding", "gzip")
+				req.Header.Set(xfer.ScopeProbeIDHeader, "replay-"+stored.name)
+				if cfg.TenantHeader != "" {
+					req.Header.Set(cfg.TenantHeader, fmt.Sprintf("replay-%d", tenant))
+				}
+				if cfg.Token != "" {
+					req.Header.Set("Authorization", "Bearer "+cfg.Token)
+				}
+				inFlight <- struct{}{}
+				wg.Add(1)
+				go func() {
+					defer func() { <-inFlight; wg.Done() }()
+					record(publishReplay(client, req))
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	stats.Elapsed = time.Since(start)
+	return stats, ctx.Err()
+}
+
+func publishReplay(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
+
+// readStoredReports reads the reports in a directory, in order of name.
+func readStoredReports(ctx context.Context, dir string) ([]storedReport, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var reports []storedReport
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		rpt, err := report.MakeFromFile(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		at := rpt.TS
+		if at.IsZero() {
+			at = file.ModTime()
+		}
+		reports = append(reports, storedReport{name: file.Name(), rpt: *rpt, at: at})
+	}
+	return reports, nil
+}
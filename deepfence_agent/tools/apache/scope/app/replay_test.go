@@ -0,0 +1,65 @@
+package app_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	then := time.Now().Add(-time.Hour)
+	rpt := fixture.Report.Copy()
+	rpt.TS = time.Now()
+	rpt.ShiftTimestamps(then.Sub(rpt.TS))
+	ok(t, rpt.WriteToFile(filepath.Join(dir, "fixture.pb.gz")))
+
+	c := app.NewCollector(time.Minute)
+	router := mux.NewRouter()
+	app.RegisterReportPostHandler(c, router, nil)
+	var (
+		mtx     sync.Mutex
+		tenants = map[string]int{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		tenants[r.Header.Get("X-Tenant")]++
+		mtx.Unlock()
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	stats, err := app.Replay(context.Background(), app.ReplayConfig{
+		Dir:          dir,
+		Target:       ts.URL,
+		Loops:        2,
+		Tenants:      3,
+		TenantHeader: "X-Tenant",
+	})
+	ok(t, err)
+	equals(t, 6, stats.Published)
+	equals(t, 0, stats.Failed)
+	equals(t, map[string]int{"replay-0": 2, "replay-1": 2, "replay-2": 2}, tenants)
+
+	// The reports are of now
+	merged, err := c.Report(context.Background(), time.Now())
+	ok(t, err)
+	_, latest, found := merged.Host.Nodes[fixture.ClientHostNodeID].Latest.LookupEntry(report.HostName)
+	if !found || time.Since(latest) > time.Minute {
+		t.Errorf("Expected the replayed host to be recent, got %v", latest)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,19 +17,37 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/gorilla/mux"
-	log "github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/common/mtime"
 
 	"github.com/weaveworks/scope/common/hostname"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("app")
+
 var (
 	// Version - set at buildtime.
 	Version = "dev"
 
 	// UniqueID - set at runtime.
 	UniqueID = "0"
+
+	// MaxReportSize - set at runtime, advertised to probes.
+	MaxReportSize = 0
+
+	// ReportLimits - set at runtime, checked of reports received.
+	ReportLimits report.Limits
+
+	// SanitizeReports - set at runtime, whether to strip the nodes of
+	// reports which violate ReportLimits, rather than reject the reports.
+	SanitizeReports = false
+
+	// MaxReportAge - set at runtime, how far back-dated reports, published
+	// late by probes, are accepted.
+	MaxReportAge = time.Hour
 )
 
 // contextKey is a wrapper type for use in context.WithValue() to satisfy golint
@@ -109,31 +129,111 @@ func RegisterTopologyRoutes(router *mux.Router, r Reporter, capabilities map[str
 	get.Handle("/topology-api/topology/{topology}/ws",
 		requestContextDecorator(captureReporter(r, handleWebsocket))). // NB not gzip!
 		Name("api_topology_topology_ws")
+	// Before the node handler, which would take export for a node ID
+	get.Handle("/topology-api/topology/{topology}/export",
+		gzipHandler(requestContextDecorator(topologyRegistry.captureRenderer(r, handleExport)))).
+		Name("api_topology_topology_export")
+	get.MatcherFunc(URLMatcher("/topology-api/topology/{topology}/{id}/reachability")).Handler(
+		gzipHandler(requestContextDecorator(topologyRegistry.captureRenderer(r, handleReachability)))).
+		Name("api_topology_topology_id_reachability")
 	get.MatcherFunc(URLMatcher("/topology-api/topology/{topology}/{id}")).Handler(
-		gzipHandler(requestContextDecorator(topologyRegistry.captureRenderer(r, handleNode)))).
+		gzipHandler(requestContextDecorator(topologyRegistry.captureRenderer(r, makeNodeHandler(r))))).
 		Name("api_topology_topology_id")
+	get.Handle("/topology-api/exposure",
+		gzipHandler(requestContextDecorator(makeExposureHandler(r))))
+	get.Handle("/topology-api/inventory",
+		gzipHandler(requestContextDecorator(makeInventoryHandler(r))))
+	get.Handle("/topology-api/images/containers",
+		gzipHandler(requestContextDecorator(makeImageContainersHandler(r))))
 	get.Handle("/topology-api/report",
 		gzipHandler(requestContextDecorator(makeRawReportHandler(r))))
+	get.Handle("/topology-api/report/ranges",
+		gzipHandler(requestContextDecorator(makeReportRangesHandler(r))))
+}
+
+// RegisterProbeRoutes registers the routes for the status of probes with a
+// http mux.
+func RegisterProbeRoutes(router *mux.Router, r Reporter, probes ProbeRegistry) {
+	get := router.Methods("GET").Subrouter()
 	get.Handle("/topology-api/probes",
-		gzipHandler(requestContextDecorator(makeProbeHandler(r))))
+		gzipHandler(requestContextDecorator(makeProbeHandler(r, probes))))
+	get.MatcherFunc(URLMatcher("/topology-api/probes/{probeID}")).Handler(
+		gzipHandler(requestContextDecorator(makeProbeDetailHandler(probes))))
+	// Across tenants, so only for admins
+	get.Handle("/admin/images/containers",
+		gzipHandler(requestContextDecorator(makeAdminImageContainersHandler(r, probes))))
+}
+
+// RegisterHandshakeHandler registers the handler for the handshakes of
+// probes, answering with those of their capabilities the app accepts, and
+// recording them in the statuses of the probes.  Probes which don't shake
+// hands are legacy ones.
+func RegisterHandshakeHandler(router *mux.Router, probes ProbeRegistry, capabilities map[string]bool) {
+	post := router.Methods("POST").Subrouter()
+	post.HandleFunc("/topology-api/handshake", requestContextDecorator(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		var handshake xfer.Handshake
+		if err := codec.NewDecoder(r.Body, &codec.JsonHandle{}).Decode(&handshake); err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		accepted := map[string]bool{}
+		for capability, ok := range handshake.Capabilities {
+			if ok && capabilities[capability] {
+				accepted[capability] = true
+			}
+		}
+		updateProbe(ctx, probes, r.Header.Get(xfer.ScopeProbeIDHeader), func(s ProbeStatus) ProbeStatus {
+			return s.Handshaken(handshake.Version, accepted, mtime.Now())
+		})
+		respondWith(ctx, w, http.StatusOK, xfer.Handshake{Version: Version, Capabilities: accepted})
+	}))
 }
 
-// RegisterReportPostHandler registers the handler for report submission
-func RegisterReportPostHandler(a Adder, router *mux.Router) {
+// RegisterReportPostHandler registers the handler for report submission.
+// The probes publishing them are tracked in probes, unless it is nil.
+func RegisterReportPostHandler(a Adder, router *mux.Router, probes ProbeRegistry) {
+	deltas := newDeltaPatcher()
 	post := router.Methods("POST").Subrouter()
 	post.HandleFunc("/topology-api/report", requestContextDecorator(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		var (
-			buf    = &bytes.Buffer{}
-			reader = io.TeeReader(r.Body, buf)
+			buf          = &bytes.Buffer{}
+			body         = &countingReader{Reader: r.Body}
+			reader       = io.TeeReader(body, buf)
+			gzipWriter   *gzip.Writer
+			probeID      = r.Header.Get(xfer.ScopeProbeIDHeader)
+			probeVersion = r.Header.Get(xfer.ScopeProbeVersionHeader)
 		)
+		// fail responds to a report refused, recording why for its probe.
+		// Reports over the maximum size are cut short, so they are refused
+		// for that, whatever failed of reading them.
+		fail := func(status int, err error) {
+			if tooLarge := reportTooLarge(body); tooLarge != nil {
+				status, err = http.StatusRequestEntityTooLarge, tooLarge
+			}
+			updateProbe(ctx, probes, probeID, func(s ProbeStatus) ProbeStatus {
+				return s.Failed(probeVersion, err, mtime.Now())
+			})
+			respondWith(ctx, w, status, err)
+		}
+
+		if MaxReportSize > 0 {
+			if r.ContentLength > int64(MaxReportSize) {
+				fail(http.StatusRequestEntityTooLarge, fmt.Errorf("Report of %d bytes over the maximum size of %d bytes", r.ContentLength, MaxReportSize))
+				return
+			}
+			// Reading a byte past the maximum is enough to refuse it
+			body.Reader = io.LimitReader(r.Body, int64(MaxReportSize)+1)
+		}
 
 		gzipped := strings.Contains(r.Header.Get("Content-Encoding"), "gzip")
 		if !gzipped {
-			reader = io.TeeReader(r.Body, gzip.NewWriter(buf))
+			gzipWriter = gzip.NewWriter(buf)
+			reader = io.TeeReader(body, gzipWriter)
 		}
 
 		contentType := r.Header.Get("Content-Type")
 		var isMsgpack int
+		isDelta := false
 		switch {
 		case strings.HasPrefix(contentType, "application/msgpack"):
 			isMsgpack = 1
@@ -141,33 +241,233 @@ func RegisterReportPostHandler(a Adder, router *mux.Router) {
 			isMsgpack = 0
 		case strings.HasPrefix(contentType, "application/binc"):
 			isMsgpack = 2
+		case strings.HasPrefix(contentType, xfer.ProtobufContentType):
+			isMsgpack = 3
+		case strings.HasPrefix(contentType, xfer.DeltaContentType):
+			isDelta = true
 		default:
-			respondWith(ctx, w, http.StatusBadRequest, fmt.Errorf("Unsupported Content-Type: %v", contentType))
+			fail(http.StatusBadRequest, fmt.Errorf("Unsupported Content-Type: %v", contentType))
 			return
 		}
 
-		rpt, err := report.MakeFromBinary(ctx, reader, gzipped, isMsgpack)
+		// Probes publishing deltas number their reports, and deltas
+		// are from the report before
+		seq, base, err := reportSeq(r.Header)
 		if err != nil {
-			respondWith(ctx, w, http.StatusBadRequest, err)
+			fail(http.StatusBadRequest, err)
+			return
+		}
+		if isDelta && (probeID == "" || seq == 0 || base == 0) {
+			fail(http.StatusBadRequest, fmt.Errorf("Delta without a probe ID and sequence numbers"))
+			return
+		}
+		timestamp, backdated, err := reportTimestamp(r.Header, mtime.Now())
+		if err != nil {
+			fail(http.StatusBadRequest, err)
 			return
 		}
+		if backdated {
+			if isDelta || seq != 0 {
+				fail(http.StatusBadRequest, fmt.Errorf("Back-dated report with sequence numbers"))
+				return
+			}
+			ctx = context.WithValue(ctx, reportTimestampCtxKey, timestamp)
+		}
+		versionLabel := probeVersionLabel(probeVersion)
+		if v := r.Header.Get(xfer.ScopeReportChecksumHeader); v != "" {
+			checksum, err := report.ParseChecksum(v)
+			if err != nil {
+				fail(http.StatusBadRequest, fmt.Errorf("Invalid %s: %v", xfer.ScopeReportChecksumHeader, err))
+				return
+			}
+			payload, err := readChecksummed(reader, gzipped, checksum)
+			if err != nil {
+				// Distinct from other refusals, for the probe to try again
+				// without compression, in case that's what is corrupted
+				reportChecksumFailures.WithLabelValues(versionLabel).Inc()
+				fail(http.StatusUnprocessableEntity, err)
+				return
+			}
+			reader, gzipped = bytes.NewReader(payload), false
+			ctx = context.WithValue(ctx, reportChecksumCtxKey, checksum)
+		}
 
-		// a.Add(..., buf) assumes buf is gzip'd msgpack
-		//if !isMsgpack {
-		//	buf, _ = rpt.WriteBinary()
-		//}
+		// Delta bases are per tenant, which may share probe IDs
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+		var rpt *report.Report
+		if isDelta {
+			delta, err := report.MakeDeltaFromBinary(ctx, reader, gzipped)
+			if err != nil {
+				fail(http.StatusBadRequest, err)
+				return
+			}
+			patched, err := deltas.Patch(tenant, probeID, seq, base, *delta)
+			if err != nil {
+				// The probe publishes a full report on a conflict
+				respondWith(ctx, w, http.StatusConflict, err)
+				return
+			}
+			rpt = &patched
+		} else {
+			rpt, err = report.MakeFromBinary(ctx, reader, gzipped, isMsgpack)
+			if err != nil {
+				fail(http.StatusBadRequest, err)
+				return
+			}
+			if seq != 0 && probeID != "" {
+				deltas.Store(tenant, probeID, seq, *rpt)
+			}
+		}
+		if err := reportTooLarge(body); err != nil {
+			fail(http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		reportsReceived.WithLabelValues(versionLabel).Inc()
+		reportBytesReceived.WithLabelValues(versionLabel).Add(float64(body.count))
+		violations := rpt.ValidateLimits(ReportLimits, mtime.Now())
+		if len(violations) > 0 {
+			for _, v := range violations {
+				reportViolations.WithLabelValues(v.Class).Inc()
+			}
+			if !SanitizeReports {
+				fail(http.StatusBadRequest, fmt.Errorf("Report violates limits: %d violation(s), first %v", len(violations), violations[0]))
+				return
+			}
+			log.Warnf("Stripping %d violation(s) of limits from report of probe %q, first %v", len(violations), probeID, violations[0])
+			rpt.Sanitize(violations)
+			// What was received is no longer the report
+			buf = nil
+		}
+		if seq != 0 {
+			// Keep the clock skew, which isn't the probe's, out of the
+			// report kept for its next delta
+			rpt.Host.Nodes = rpt.Host.Nodes.Copy()
+		}
+		if !backdated {
+			// The clocks of back-dated reports are long gone
+			addClockSkew(*rpt, mtime.Now())
+		}
 
-		//if err := a.Add(ctx, *rpt, buf.Bytes()); err != nil {
-		var unusedParam []byte
-		if err := a.Add(ctx, *rpt, unusedParam); err != nil {
+		// a.Add(..., buf) assumes buf is gzip'd msgpack, of the whole
+		// report, so it's only passed on for those received as such
+		var encoded []byte
+		if buf != nil && isMsgpack == 1 && !isDelta {
+			if gzipWriter != nil {
+				if err := gzipWriter.Close(); err != nil {
+					fail(http.StatusInternalServerError, err)
+					return
+				}
+			}
+			encoded = buf.Bytes()
+		}
+		if err := a.Add(ctx, *rpt, encoded); err != nil {
 			log.Errorf("Error Adding report: %v", err)
-			respondWith(ctx, w, http.StatusInternalServerError, err)
+			fail(http.StatusInternalServerError, err)
 			return
 		}
+		topologyRenders.invalidate(ctx)
+		hostname := reportHostname(*rpt)
+		updateProbe(ctx, probes, probeID, func(s ProbeStatus) ProbeStatus {
+			return s.Reported(hostname, probeVersion, mtime.Now()).Truncated(rpt.Truncation).Sanitized(violations)
+		})
 		w.WriteHeader(http.StatusOK)
 	}))
 }
 
+// reportTooLarge refuses a report of which more than MaxReportSize bytes
+// were read, which probes trim theirs to.
+func reportTooLarge(body *countingReader) error {
+	if MaxReportSize > 0 && body.count > MaxReportSize {
+		return fmt.Errorf("Report over the maximum size of %d bytes", MaxReportSize)
+	}
+	return nil
+}
+
+// updateProbe updates the status of a probe in probes, if they're tracked
+// and the probe identified itself.
+func updateProbe(ctx context.Context, probes ProbeRegistry, probeID string, f func(ProbeStatus) ProbeStatus) {
+	if probes == nil || probeID == "" {
+		return
+	}
+	if err := probes.UpdateProbe(ctx, probeID, f); err != nil {
+		log.Warnf("Error updating status of probe %q: %v", probeID, err)
+	}
+}
+
+// reportHostname is the name of the host a report is of.
+func reportHostname(rpt report.Report) string {
+	for _, n := range rpt.Host.Nodes {
+		if hostname, ok := n.Latest.Lookup(report.HostName); ok {
+			return hostname
+		}
+	}
+	return ""
+}
+
+// readChecksummed reads the payload of a report, gunzipping it if gzipped,
+// checking it against its checksum.  Failing to gunzip it is as much a sign
+// of corruption on the way as a mismatch.
+func readChecksummed(r io.Reader, gzipped bool, checksum report.Checksum) ([]byte, error) {
+	if gzipped {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt report: %v", err)
+		}
+		r = gzr
+	}
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupt report: %v", err)
+	}
+	if sum := report.SumPayload(payload); sum != checksum {
+		return nil, fmt.Errorf("Corrupt report: checksum %s, expected %s", sum, checksum)
+	}
+	return payload, nil
+}
+
+// reportSeq parses the sequence numbers of a report, and of the report a
+// delta is from; zero if not given.
+func reportSeq(header http.Header) (seq, base uint64, err error) {
+	for _, h := range []struct {
+		name  string
+		value *uint64
+	}{
+		{xfer.ScopeReportSeqHeader, &seq},
+		{xfer.ScopeReportBaseHeader, &base},
+	} {
+		if v := header.Get(h.name); v != "" {
+			if *h.value, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return 0, 0, fmt.Errorf("Invalid %s: %v", h.name, err)
+			}
+		}
+	}
+	return seq, base, nil
+}
+
+// reportTimestamp parses the time a report published late was made, and
+// whether it was, refusing those older than MaxReportAge.  Reports from
+// probes whose clocks are ahead are taken as of now.
+func reportTimestamp(header http.Header, now time.Time) (time.Time, bool, error) {
+	v := header.Get(xfer.ScopeReportTimestampHeader)
+	if v == "" {
+		return now, false, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return now, false, fmt.Errorf("Invalid %s: %v", xfer.ScopeReportTimestampHeader, err)
+	}
+	if age := now.Sub(timestamp); age > MaxReportAge {
+		return now, false, fmt.Errorf("Report too old: made %v ago, accepting up to %v", age, MaxReportAge)
+	} else if age < 0 {
+		timestamp = now
+	}
+	return timestamp, true, nil
+}
+
 // RegisterAdminRoutes registers routes for admin calls with a http mux.
 func RegisterAdminRoutes(router *mux.Router, reporter Reporter) {
 	get := router.Methods("GET").Subrouter()
@@ -203,12 +503,13 @@ func apiHandler(rep Reporter, capabilities map[string]bool) CtxHandlerFunc {
 		//	return
 		//}
 		respondWith(ctx, w, http.StatusOK, xfer.Details{
-			ID:           UniqueID,
-			Version:      Version,
-			Hostname:     hostname.Get(),
-			Plugins:      report.Report{}.Plugins,
-			Capabilities: capabilities,
-			NewVersion:   newVersion.NewVersionInfo,
+			ID:            UniqueID,
+			Version:       Version,
+			Hostname:      hostname.Get(),
+			Plugins:       report.Report{}.Plugins,
+			Capabilities:  capabilities,
+			NewVersion:    newVersion.NewVersionInfo,
+			MaxReportSize: MaxReportSize,
 		})
 	}
 }
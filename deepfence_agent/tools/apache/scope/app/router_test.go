@@ -2,6 +2,8 @@ package app_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -13,8 +15,11 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/ugorji/go/codec"
 
+	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/common/test"
 	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test/fixture"
 )
 
@@ -43,7 +48,7 @@ func TestReportPostHandler(t *testing.T) {
 	test := func(contentType string, encoder func(interface{}) ([]byte, error)) {
 		router := mux.NewRouter()
 		c := app.NewCollector(1 * time.Minute)
-		app.RegisterReportPostHandler(c, router)
+		app.RegisterReportPostHandler(c, router, nil)
 		ts := httptest.NewServer(router)
 		defer ts.Close()
 
@@ -94,3 +99,388 @@ func TestReportPostHandler(t *testing.T) {
 		return buf.Bytes(), err
 	})
 }
+
+// encodedAdder keeps the encoding of the last report added.
+type encodedAdder struct {
+	encoded []byte
+}
+
+func (a *encodedAdder) Add(_ context.Context, _ report.Report, buf []byte) error {
+	a.encoded = buf
+	return nil
+}
+
+func TestReportPostHandlerEncoded(t *testing.T) {
+	router := mux.NewRouter()
+	a := &encodedAdder{}
+	app.RegisterReportPostHandler(a, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	gzipped, err := fixture.Report.WriteBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &bytes.Buffer{}
+	if err := codec.NewEncoder(plain, &codec.MsgpackHandle{}).Encode(fixture.Report); err != nil {
+		t.Fatal(err)
+	}
+	json := &bytes.Buffer{}
+	if err := codec.NewEncoder(json, &codec.JsonHandle{}).Encode(fixture.Report); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		contentType, encoding string
+		body                  []byte
+		encoded               bool
+	}{
+		{"application/msgpack", "gzip", gzipped.Bytes(), true},
+		{"application/msgpack", "", plain.Bytes(), true},
+		{"application/json", "", json.Bytes(), false},
+	} {
+		a.encoded = nil
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", bytes.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.encoding != "" {
+			req.Header.Set("Content-Encoding", tc.encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %d", tc.contentType, tc.encoding, resp.StatusCode)
+		}
+		if !tc.encoded {
+			if a.encoded != nil {
+				t.Errorf("%s %s: expected no encoding", tc.contentType, tc.encoding)
+			}
+			continue
+		}
+		// The encoding passed on can be read without decoding the report
+		partial, err := report.ReadPartial(context.Background(), bytes.NewReader(a.encoded), true)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.contentType, tc.encoding, err)
+		}
+		if want, have := len(fixture.Report.Endpoint.Nodes), partial.NodeCounts[report.Endpoint]; want != have {
+			t.Errorf("%s %s: expected %d endpoints, got %d", tc.contentType, tc.encoding, want, have)
+		}
+	}
+}
+
+func TestReportPostHandlerDeltas(t *testing.T) {
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post := func(probeID, contentType string, body *bytes.Buffer, seq, base uint64) int {
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(xfer.ScopeProbeIDHeader, probeID)
+		if seq != 0 {
+			req.Header.Set(xfer.ScopeReportSeqHeader, fmt.Sprint(seq))
+		}
+		if base != 0 {
+			req.Header.Set(xfer.ScopeReportBaseHeader, fmt.Sprint(base))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	full := func(probeID string, seq uint64) int {
+		buf, err := report.MakeReport().WriteBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post(probeID, xfer.MsgpackContentType, buf, seq, 0)
+	}
+	delta := func(probeID string, seq, base uint64) int {
+		rpt := report.MakeReport()
+		buf, err := rpt.Diff(rpt).WriteBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post(probeID, xfer.DeltaContentType, buf, seq, base)
+	}
+
+	for i, step := range []struct {
+		status int
+		post   func() int
+	}{
+		{http.StatusConflict, func() int { return delta("probe", 2, 1) }},
+		{http.StatusOK, func() int { return full("probe", 1) }},
+		{http.StatusOK, func() int { return delta("probe", 2, 1) }},
+		{http.StatusOK, func() int { return delta("probe", 3, 2) }},
+		{http.StatusConflict, func() int { return delta("probe", 5, 4) }}, // report 4 lost
+		{http.StatusConflict, func() int { return delta("other", 4, 3) }},
+		{http.StatusOK, func() int { return full("probe", 6) }},
+		{http.StatusOK, func() int { return delta("probe", 7, 6) }},
+		{http.StatusBadRequest, func() int { return delta("", 8, 7) }},
+		{http.StatusBadRequest, func() int { return delta("probe", 8, 0) }},
+	} {
+		if have := step.post(); have != step.status {
+			t.Errorf("%d: expected %d, got %d", i, step.status, have)
+		}
+	}
+}
+
+func TestReportPostHandlerLimits(t *testing.T) {
+	defer func(limits report.Limits, sanitize bool) {
+		app.ReportLimits, app.SanitizeReports = limits, sanitize
+	}(app.ReportLimits, app.SanitizeReports)
+	app.ReportLimits = report.Limits{MaxNodes: 2, MaxIDLength: 64, MaxClockSkew: time.Hour}
+
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNode("good;<host>"))
+	rpt.Host.AddNode(report.MakeNode("bad\x01;<host>"))
+	rpt.Container.AddNode(report.MakeNode("future;<container>").
+		WithLatest("name", time.Now().Add(24*time.Hour), "future"))
+	post := func() int {
+		buf, err := rpt.WriteProtobuf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", xfer.ProtobufContentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	app.SanitizeReports = false
+	if have := post(); have != http.StatusBadRequest {
+		t.Fatalf("Expected the report to be rejected, got %d", have)
+	}
+	if have, _ := c.Report(context.Background(), time.Now()); len(have.Host.Nodes) != 0 {
+		t.Fatalf("Expected no hosts, got %v", have.Host.Nodes)
+	}
+
+	app.SanitizeReports = true
+	if have := post(); have != http.StatusOK {
+		t.Fatalf("Expected the report to be sanitized, got %d", have)
+	}
+	have, err := c.Report(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := have.Host.Nodes["good;<host>"]; !ok || len(have.Host.Nodes) != 1 {
+		t.Errorf("Expected only the good host, got %v", have.Host.Nodes)
+	}
+	if len(have.Container.Nodes) != 0 {
+		t.Errorf("Expected no containers, got %v", have.Container.Nodes)
+	}
+}
+
+func TestReportPostHandlerMaxSize(t *testing.T) {
+	defer func(max int) { app.MaxReportSize = max }(app.MaxReportSize)
+
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	rpt := report.MakeReport()
+	for i := 0; i < 100; i++ {
+		rpt.Host.AddNode(report.MakeNode(fmt.Sprintf("host%d;<host>", i)))
+	}
+	buf, err := rpt.WriteProtobuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := buf.Len()
+	post := func(chunked bool) int {
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunked {
+			// Without a length, the report is only found too large as it's read
+			req.ContentLength = -1
+			req.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+		}
+		req.Header.Set("Content-Type", xfer.ProtobufContentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	app.MaxReportSize = size - 1
+	for _, chunked := range []bool{false, true} {
+		if have := post(chunked); have != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected the report to be refused, chunked %v, got %d", chunked, have)
+		}
+	}
+	if have, _ := c.Report(context.Background(), time.Now()); len(have.Host.Nodes) != 0 {
+		t.Fatalf("Expected no hosts, got %d", len(have.Host.Nodes))
+	}
+
+	app.MaxReportSize = size
+	for _, chunked := range []bool{false, true} {
+		if have := post(chunked); have != http.StatusOK {
+			t.Errorf("Expected the report to be taken, chunked %v, got %d", chunked, have)
+		}
+	}
+}
+
+func TestReportPostHandlerBackdated(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post := func(hostID string, timestamp time.Time) int {
+		rpt := report.MakeReport()
+		rpt.Host.AddNode(report.MakeNode(hostID))
+		buf, err := rpt.WriteProtobuf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", xfer.ProtobufContentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		if !timestamp.IsZero() {
+			req.Header.Set(xfer.ScopeReportTimestampHeader, timestamp.Format(time.RFC3339Nano))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if have := post("live;<host>", time.Time{}); have != http.StatusOK {
+		t.Fatalf("Expected the live report to be taken, got %d", have)
+	}
+	if have := post("late;<host>", now.Add(-30*time.Second)); have != http.StatusOK {
+		t.Fatalf("Expected the back-dated report to be taken, got %d", have)
+	}
+	if have := post("old;<host>", now.Add(-app.MaxReportAge-time.Second)); have != http.StatusBadRequest {
+		t.Fatalf("Expected the report too old to be rejected, got %d", have)
+	}
+
+	// The back-dated report is had as of when it was made
+	have, ok, err := c.HistoricReport(context.Background(), now.Add(-20*time.Second), 15*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("Expected a historic report: %v", err)
+	}
+	if _, ok := have.Host.Nodes["late;<host>"]; !ok || len(have.Host.Nodes) != 1 {
+		t.Errorf("Expected only the late host, got %v", have.Host.Nodes)
+	}
+	have, err = c.Report(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have.Host.Nodes) != 2 {
+		t.Errorf("Expected the live and late hosts, got %v", have.Host.Nodes)
+	}
+}
+
+func TestReportPostHandlerChecksum(t *testing.T) {
+	router := mux.NewRouter()
+	c := app.NewCollector(1 * time.Minute)
+	app.RegisterReportPostHandler(c, router, nil)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post := func(hostID string, gzipped bool, checksum func(report.Checksum) string) int {
+		rpt := report.MakeReport()
+		rpt.Host.AddNode(report.MakeNode(hostID))
+		buf, sum, err := rpt.WriteProtobufChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := buf.Bytes()
+		if !gzipped {
+			gzr, err := gzip.NewReader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = ioutil.ReadAll(gzr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest("POST", ts.URL+"/topology-api/report", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", xfer.ProtobufContentType)
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req.Header.Set(xfer.ScopeReportChecksumHeader, checksum(sum))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	intact := report.Checksum.String
+	corrupt := func(sum report.Checksum) string {
+		return report.SumPayload([]byte(sum)).String()
+	}
+
+	for i, step := range []struct {
+		hostID   string
+		gzipped  bool
+		checksum func(report.Checksum) string
+		status   int
+	}{
+		{"intact;<host>", true, intact, http.StatusOK},
+		{"uncompressed;<host>", false, intact, http.StatusOK},
+		{"corrupt;<host>", true, corrupt, http.StatusUnprocessableEntity},
+		{"corrupt;<host>", false, corrupt, http.StatusUnprocessableEntity},
+		{"invalid;<host>", true, func(report.Checksum) string { return "sha256" }, http.StatusBadRequest},
+	} {
+		if have := post(step.hostID, step.gzipped, step.checksum); have != step.status {
+			t.Errorf("%d: expected %d, got %d", i, step.status, have)
+		}
+	}
+	have, err := c.Report(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have.Host.Nodes) != 2 {
+		t.Errorf("Expected only the intact hosts, got %v", have.Host.Nodes)
+	}
+}
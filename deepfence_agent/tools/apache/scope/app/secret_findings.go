@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+// SecretFindings are the findings of a secret scan of a container, or of
+// an image by its ID or name, as posted by the secret scanner.
+type SecretFindings struct {
+	ContainerID string         `json:"container_id,omitempty"`
+	ImageID     string         `json:"image_id,omitempty"`
+	ImageName   string         `json:"image_name,omitempty"` // with its tag, e.g. nginx:1.21
+	Counts      map[string]int `json:"counts"`               // by severity
+	TopRules    []string       `json:"top_rules,omitempty"`
+	ScanTime    time.Time      `json:"scan_time"`
+}
+
+func (f SecretFindings) validate() error {
+	if f.ContainerID == "" && f.ImageID == "" && f.ImageName == "" {
+		return fmt.Errorf("Findings of neither a container nor an image")
+	}
+	if f.ContainerID != "" && (f.ImageID != "" || f.ImageName != "") {
+		return fmt.Errorf("Findings of both container %s and an image", f.ContainerID)
+	}
+	if f.ScanTime.IsZero() {
+		return fmt.Errorf("Findings without a scan time")
+	}
+	return nil
+}
+
+// scanStatus is the status of the secret scan the findings are of, with
+// the worst severity found.
+func (f SecretFindings) scanStatus() report.ScanStatus {
+	s := report.ScanStatus{
+		Type:     report.SecretScan,
+		Status:   report.ScanStatusComplete,
+		Severity: render.SeverityNone,
+		Counts:   f.Counts,
+		TopRules: f.TopRules,
+		LastScan: f.ScanTime,
+	}
+	for _, severity := range render.Severities[:4] {
+		if f.Counts[severity] > 0 {
+			s.Severity = severity
+			break
+		}
+	}
+	return s
+}
+
+// heldFindings is the status of the latest secret scan of a container or
+// image, and when it was last received or matched a node.
+type heldFindings struct {
+	status    report.ScanStatus
+	lastMatch time.Time
+}
+
+// tenantFindings are the findings held of the containers and images of a
+// tenant, and their generation, which changes as they do.
+type tenantFindings struct {
+	containers map[string]*heldFindings // by container ID
+	imageIDs   map[string]*heldFindings // by image ID
+	imageNames map[string]*heldFindings // by image name, with its tag
+	generation uint64
+}
+
+// SecretFindingsStore keeps the findings of the latest secret scans of
+// containers and images of each tenant, adding them to the nodes of their
+// reports.  The findings of images also go to their containers.  Findings
+// are kept while they match nodes, and for the TTL after, so that those of
+// images not running are there for their containers when they start.
+type SecretFindingsStore struct {
+	ttl time.Duration
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantFindings
+}
+
+// NewSecretFindingsStore makes a new SecretFindingsStore, keeping findings
+// matching no nodes for ttl.
+func NewSecretFindingsStore(ttl time.Duration) *SecretFindingsStore {
+	return &SecretFindingsStore{
+		ttl:     ttl,
+		tenants: map[string]*tenantFindings{},
+	}
+}
+
+// Add keeps findings of the containers and images of tenant received at
+// now, unless a more recent scan of the same container or image is kept.
+func (s *SecretFindingsStore) Add(tenant string, f SecretFindings, now time.Time) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	status := f.scanStatus()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tf, ok := s.tenants[tenant]
+	if !ok {
+		tf = &tenantFindings{
+			containers: map[string]*heldFindings{},
+			imageIDs:   map[string]*heldFindings{},
+			imageNames: map[string]*heldFindings{},
+		}
+		s.tenants[tenant] = tf
+	}
+	tf.generation++
+	for _, key := range []struct {
+		held map[string]*heldFindings
+		id   string
+	}{
+		{tf.containers, f.ContainerID},
+		{tf.imageIDs, strings.TrimPrefix(f.ImageID, "sha256:")},
+		{tf.imageNames, f.ImageName},
+	} {
+		if key.id == "" {
+			continue
+		}
+		if prev, ok := key.held[key.id]; ok {
+			prev.status, prev.lastMatch = prev.status.Merge(status), now
+			continue
+		}
+		key.held[key.id] = &heldFindings{status: status, lastMatch: now}
+	}
+	return nil
+}
+
+// lookup returns the findings held by id, marking them matched at now.
+func lookup(held map[string]*heldFindings, id string, now time.Time) (report.ScanStatus, bool) {
+	if id == "" {
+		return report.ScanStatus{}, false
+	}
+	h, ok := held[id]
+	if !ok {
+		return report.ScanStatus{}, false
+	}
+	h.lastMatch = now
+	return h.status, true
+}
+
+// Decorate returns rpt, of tenant, with the findings held added to its
+// container and image nodes, as of now, forgetting those which have matched
+// no nodes for the TTL.  Its ID is decorated by the generation of the
+// findings, so that its renders aren't those of rpt.  rpt is not modified.
+func (s *SecretFindingsStore) Decorate(rpt report.Report, tenant string, now time.Time) report.Report {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.expire(now)
+	tf, ok := s.tenants[tenant]
+	if !ok {
+		return rpt
+	}
+
+	images := map[string]report.ScanStatus{} // by image ID
+	var imageNodes []report.Node
+	for id, n := range rpt.ContainerImage.Nodes {
+		imageID, ok := report.ParseContainerImageNodeID(id)
+		if !ok {
+			continue
+		}
+		status, found := lookup(tf.imageIDs, imageID, now)
+		name, _ := n.Latest.Lookup(report.DockerImageName)
+		if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && name != "" {
+			name += ":" + tag
+		}
+		if byName, ok := lookup(tf.imageNames, name, now); ok {
+			status, found = status.Merge(byName), true
+		}
+		if found {
+			images[imageID] = status
+			imageNodes = append(imageNodes, n.WithScanStatus(status))
+		}
+	}
+
+	var containerNodes []report.Node
+	for id, n := range rpt.Container.Nodes {
+		containerID, ok := report.ParseContainerNodeID(id)
+		if !ok {
+			continue
+		}
+		status, found := lookup(tf.containers, containerID, now)
+		imageID, _ := n.Latest.Lookup(report.DockerImageID)
+		byImage, ok := images[imageID]
+		if !ok {
+			// Images of containers may be missing from the report
+			byImage, ok = lookup(tf.imageIDs, imageID, now)
+		}
+		if ok {
+			status, found = status.Merge(byImage), true
+		}
+		if found {
+			containerNodes = append(containerNodes, n.WithScanStatus(status))
+		}
+	}
+
+	rpt.ID = decoratedID(rpt.ID, "secrets", tf.generation)
+	if len(imageNodes) > 0 {
+		rpt.ContainerImage.Nodes = rpt.ContainerImage.Nodes.Copy()
+		for _, n := range imageNodes {
+			rpt.ContainerImage.Nodes[n.ID] = n
+		}
+	}
+	if len(containerNodes) > 0 {
+		rpt.Container.Nodes = rpt.Container.Nodes.Copy()
+		for _, n := range containerNodes {
+			rpt.Container.Nodes[n.ID] = n
+		}
+	}
+	return rpt
+}
+
+func (s *SecretFindingsStore) expire(now time.Time) {
+	for tenant, tf := range s.tenants {
+		for _, held := range []map[string]*heldFindings{tf.containers, tf.imageIDs, tf.imageNames} {
+			for id, h := range held {
+				if now.Sub(h.lastMatch) > s.ttl {
+					delete(held, id)
+					tf.generation++
+				}
+			}
+		}
+		if len(tf.containers)+len(tf.imageIDs)+len(tf.imageNames) == 0 {
+			delete(s.tenants, tenant)
+		}
+	}
+}
+
+// SecretFindingsReporter is a Reporter whose reports have the secret
+// findings held in Findings of the tenant of the request added to their
+// container and image nodes.
+type SecretFindingsReporter struct {
+	Reporter
+	Findings *SecretFindingsStore
+}
+
+// Report implements Reporter
+func (r SecretFindingsReporter) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	rpt, err := r.Reporter.Report(ctx, timestamp)
+	if err != nil {
+		return rpt, err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return rpt, err
+	}
+	return r.Findings.Decorate(rpt, tenant, mtime.Now()), nil
+}
+
+// HistoricReport implements Reporter
+func (r SecretFindingsReporter) HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	rpt, ok, err := r.Reporter.HistoricReport(ctx, timestamp, window)
+	if err != nil || !ok {
+		return rpt, ok, err
+	}
+	tenant, err := TenantID(ctx)
+	if err != nil {
+		return rpt, false, err
+	}
+	return r.Findings.Decorate(rpt, tenant, mtime.Now()), true, nil
+}
+
+// RegisterSecretFindingsHandler registers the handler for the secret
+// scanner to post its findings to, as a JSON list of SecretFindings of the
+// tenant of the request.
+func RegisterSecretFindingsHandler(router *mux.Router, findings *SecretFindingsStore) {
+	post := router.Methods("POST").Subrouter()
+	post.HandleFunc("/topology-api/scans/secrets", requestContextDecorator(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		tenant, err := TenantID(ctx)
+		if err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		var list []SecretFindings
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			respondWith(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		for _, f := range list {
+			if err := f.validate(); err != nil {
+				respondWith(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		now := mtime.Now()
+		for _, f := range list {
+			findings.Add(tenant, f, now)
+		}
+		respondWith(ctx, w, http.StatusOK, struct {
+			Accepted int `json:"accepted"`
+		}{len(list)})
+	}))
+}
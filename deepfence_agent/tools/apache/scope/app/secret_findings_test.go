@@ -0,0 +1,104 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestSecretFindings(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	findings := app.NewSecretFindingsStore(time.Hour)
+	router := mux.NewRouter()
+	app.RegisterSecretFindingsHandler(router, findings)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	post := func(list []app.SecretFindings) int {
+		buf, err := json.Marshal(list)
+		ok(t, err)
+		resp, err := http.Post(ts.URL+"/topology-api/scans/secrets", "application/json", bytes.NewReader(buf))
+		ok(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	scanned := now.Add(-time.Minute)
+	equals(t, http.StatusOK, post([]app.SecretFindings{
+		{ImageName: "nginx:1.21", Counts: map[string]int{"high": 2, "low": 1}, TopRules: []string{"aws_access_key"}, ScanTime: scanned},
+		{ImageID: "sha256:redis", Counts: map[string]int{"low": 1}, ScanTime: scanned},
+		{ContainerID: "c2", Counts: map[string]int{"critical": 1}, ScanTime: scanned},
+	}))
+	equals(t, http.StatusBadRequest, post([]app.SecretFindings{{Counts: map[string]int{"low": 1}, ScanTime: scanned}}))
+	equals(t, http.StatusBadRequest, post([]app.SecretFindings{{ContainerID: "c1", ImageID: "nginx"}}))
+
+	c := app.NewCollector(time.Minute)
+	reporter := app.SecretFindingsReporter{Reporter: c, Findings: findings}
+	rpt := report.MakeReport()
+	rpt.ContainerImage.AddNode(report.MakeNodeWith(report.MakeContainerImageNodeID("nginx"), map[string]string{
+		report.DockerImageName: "nginx",
+		report.DockerImageTag:  "1.21",
+	}))
+	rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID("c1"), map[string]string{report.DockerImageID: "nginx"}))
+	rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID("c2"), map[string]string{report.DockerImageID: "busybox"}))
+	ok(t, c.Add(context.Background(), rpt, nil))
+
+	have, err := reporter.Report(context.Background(), now)
+	ok(t, err)
+	image, _ := have.ContainerImage.Nodes[report.MakeContainerImageNodeID("nginx")].LookupScanStatus(report.SecretScan)
+	equals(t, report.ScanStatus{
+		Type:     report.SecretScan,
+		Status:   report.ScanStatusComplete,
+		Severity: render.SeverityHigh,
+		Counts:   map[string]int{"high": 2, "low": 1},
+		TopRules: []string{"aws_access_key"},
+		LastScan: scanned.UTC(),
+	}, image)
+	// Containers get the findings of their images
+	equals(t, true, render.HasSecrets(have.Container.Nodes[report.MakeContainerNodeID("c1")]))
+	container, _ := have.Container.Nodes[report.MakeContainerNodeID("c2")].LookupScanStatus(report.SecretScan)
+	equals(t, render.SeverityCritical, container.Severity)
+	// The collector's report is left as it is
+	plain, err := c.Report(context.Background(), now)
+	ok(t, err)
+	equals(t, false, render.HasSecrets(plain.Container.Nodes[report.MakeContainerNodeID("c1")]))
+
+	// The findings of the redis image, which isn't running, are kept for
+	// the TTL, for its containers to get them when they start
+	mtime.NowForce(now.Add(50 * time.Minute))
+	rpt = report.MakeReport()
+	rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID("c3"), map[string]string{report.DockerImageID: "redis"}))
+	ok(t, c.Add(context.Background(), rpt, nil))
+	have, err = reporter.Report(context.Background(), now.Add(50*time.Minute))
+	ok(t, err)
+	equals(t, true, render.HasSecrets(have.Container.Nodes[report.MakeContainerNodeID("c3")]))
+	// New findings give the report a new ID, for it not to be rendered
+	// from the cache, and are only those of their tenant
+	id := have.ID
+	equals(t, http.StatusOK, post([]app.SecretFindings{{ContainerID: "c3", Counts: map[string]int{"low": 1}, ScanTime: now}}))
+	have, err = reporter.Report(context.Background(), now.Add(50*time.Minute))
+	ok(t, err)
+	if have.ID == id {
+		t.Errorf("Expected a new ID, got %q", have.ID)
+	}
+	other := findings.Decorate(rpt, "other-tenant", now.Add(50*time.Minute))
+	equals(t, false, render.HasSecrets(other.Container.Nodes[report.MakeContainerNodeID("c3")]))
+	equals(t, rpt.ID, other.ID)
+
+	// Findings of images no longer running are forgotten after the TTL
+	mtime.NowForce(now.Add(200 * time.Minute))
+	have = findings.Decorate(rpt, "", now.Add(200*time.Minute))
+	equals(t, false, render.HasSecrets(have.Container.Nodes[report.MakeContainerNodeID("c3")]))
+}
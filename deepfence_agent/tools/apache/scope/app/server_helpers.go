@@ -6,8 +6,6 @@ import (
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/ugorji/go/codec"
-
-	log "github.com/sirupsen/logrus"
 )
 
 func respondWith(ctx context.Context, w http.ResponseWriter, code int, response interface{}) {
@@ -0,0 +1,81 @@
+package app_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/weaveworks/common/middleware"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+// finishedSpans returns the spans reported to reporter, by the prefixes of
+// their names, waiting for those of all the prefixes to be.
+func finishedSpans(t *testing.T, reporter *jaeger.InMemoryReporter, prefixes ...string) map[string]*jaeger.Span {
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		found := map[string]*jaeger.Span{}
+		for _, s := range reporter.GetSpans() {
+			span := s.(*jaeger.Span)
+			for _, prefix := range prefixes {
+				if _, ok := found[prefix]; !ok && strings.HasPrefix(span.OperationName(), prefix) {
+					found[prefix] = span
+				}
+			}
+		}
+		if len(found) == len(prefixes) {
+			return found
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected spans %v, got %v", prefixes, reporter.GetSpans())
+		}
+	}
+}
+
+func TestTopologyTracing(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("scope-app", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	// Renders cached by other tests would have no spans
+	app.ConfigureRenderCache(0, 0)
+	defer app.ConfigureRenderCache(64, 3*time.Second)
+
+	c := app.NewCollector(time.Minute)
+	c.Add(context.Background(), fixture.Report, nil)
+	router := mux.NewRouter().SkipClean(true)
+	app.RegisterTopologyRoutes(router, c, map[string]bool{})
+	ts := httptest.NewServer(middleware.Tracer{RouteMatcher: router}.Wrap(router))
+	defer ts.Close()
+
+	getRawJSON(t, ts, "/topology-api/topology/containers")
+
+	spans := finishedSpans(t, reporter, "HTTP GET", "app.renderTopology", "collector.Report", "collector.Merge", "Render:")
+	request := spans["HTTP GET"].SpanContext()
+	topology := spans["app.renderTopology"]
+	collect := spans["collector.Report"]
+	merge := spans["collector.Merge"]
+	render := spans["Render:"]
+
+	equals(t, jaeger.SpanID(0), request.ParentID())
+	equals(t, request.SpanID(), topology.SpanContext().ParentID())
+	equals(t, topology.SpanContext().SpanID(), collect.SpanContext().ParentID())
+	equals(t, collect.SpanContext().SpanID(), merge.SpanContext().ParentID())
+	equals(t, topology.SpanContext().SpanID(), render.SpanContext().ParentID())
+	for _, s := range []*jaeger.Span{topology, collect, merge, render} {
+		equals(t, request.TraceID(), s.SpanContext().TraceID())
+	}
+
+	equals(t, "containers", topology.Tags()["topology"])
+	equals(t, 1, collect.Tags()["reports"])
+	if n, _ := render.Tags()["nodes"].(int); n == 0 {
+		t.Errorf("Expected the render span to count nodes, got %v", render.Tags())
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// VulnerabilityStatuses returns the statuses of the latest vulnerability
+// scans of hosts and images as last read from elasticsearch, by host name
+// or image name with its tag, and the number of times they've been read;
+// nil until they have been.
+func VulnerabilityStatuses() (map[string]report.ScanStatus, uint64) {
+	if nStatus == nil {
+		return nil, 0
+	}
+	nStatus.nodeStatus.RLock()
+	defer nStatus.nodeStatus.RUnlock()
+	return nStatus.nodeStatus.VulnerabilityScanStatus, nStatus.nodeStatus.generation
+}
+
+// VulnerabilityScansReporter is a Reporter whose reports have the statuses
+// of the vulnerability scans given by Statuses added to their host, image
+// and container nodes, those of images also going to their containers.
+// Statuses gives them with their generation, which changes as they do.
+type VulnerabilityScansReporter struct {
+	Reporter
+	Statuses func() (map[string]report.ScanStatus, uint64)
+}
+
+// Report implements Reporter
+func (r VulnerabilityScansReporter) Report(ctx context.Context, timestamp time.Time) (report.Report, error) {
+	rpt, err := r.Reporter.Report(ctx, timestamp)
+	if err != nil {
+		return rpt, err
+	}
+	statuses, generation := r.Statuses()
+	return decorateVulnerabilityScans(rpt, statuses, generation), nil
+}
+
+// HistoricReport implements Reporter
+func (r VulnerabilityScansReporter) HistoricReport(ctx context.Context, timestamp time.Time, window time.Duration) (report.Report, bool, error) {
+	rpt, ok, err := r.Reporter.HistoricReport(ctx, timestamp, window)
+	if err != nil || !ok {
+		return rpt, ok, err
+	}
+	statuses, generation := r.Statuses()
+	return decorateVulnerabilityScans(rpt, statuses, generation), true, nil
+}
+
+// decorateVulnerabilityScans returns rpt with statuses, by host name or
+// image name with its tag, added to its host, image and container nodes,
+// where more recent than those the probes reported, and its ID decorated
+// by the generation of statuses.  rpt is not modified.
+func decorateVulnerabilityScans(rpt report.Report, statuses map[string]report.ScanStatus, generation uint64) report.Report {
+	if len(statuses) == 0 {
+		return rpt
+	}
+
+	withStatus := func(n report.Node, status report.ScanStatus) report.Node {
+		if prev, ok := n.LookupScanStatus(report.VulnerabilityScan); ok {
+			status = prev.Merge(status)
+		}
+		return n.WithScanStatus(status)
+	}
+
+	var hostNodes []report.Node
+	for _, n := range rpt.Host.Nodes {
+		name, _ := n.Latest.Lookup(report.HostName)
+		if status, ok := statuses[name]; ok && name != "" {
+			hostNodes = append(hostNodes, withStatus(n, status))
+		}
+	}
+
+	images := map[string]report.ScanStatus{} // by image ID
+	var imageNodes []report.Node
+	for id, n := range rpt.ContainerImage.Nodes {
+		imageID, ok := report.ParseContainerImageNodeID(id)
+		if !ok {
+			continue
+		}
+		name, _ := n.Latest.Lookup(report.DockerImageName)
+		if tag, ok := n.Latest.Lookup(report.DockerImageTag); ok && name != "" {
+			name += ":" + tag
+		}
+		if status, ok := statuses[name]; ok && name != "" {
+			images[imageID] = status
+			imageNodes = append(imageNodes, withStatus(n, status))
+		}
+	}
+
+	var containerNodes []report.Node
+	for _, n := range rpt.Container.Nodes {
+		imageID, _ := n.Latest.Lookup(report.DockerImageID)
+		if status, ok := images[imageID]; ok {
+			containerNodes = append(containerNodes, withStatus(n, status))
+		}
+	}
+
+	rpt.ID = decoratedID(rpt.ID, "vulnerabilities", generation)
+	for _, t := range []struct {
+		topology *report.Topology
+		nodes    []report.Node
+	}{
+		{&rpt.Host, hostNodes},
+		{&rpt.ContainerImage, imageNodes},
+		{&rpt.Container, containerNodes},
+	} {
+		if len(t.nodes) == 0 {
+			continue
+		}
+		t.topology.Nodes = t.topology.Nodes.Copy()
+		for _, n := range t.nodes {
+			t.topology.Nodes[n.ID] = n
+		}
+	}
+	return rpt
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+// The aggregations of the scan logs and vulnerabilities elasticsearch
+// gives: the server image was scanned twice, finding a critical
+// vulnerability the second time, and a scan of the client image is queued.
+const (
+	scanLogsAggregations = `{"node_id": {"buckets": [
+		{"key": "image/server", "doc_count": 4, "action": {"buckets": [
+			{"key": "QUEUED", "doc_count": 2, "scan_recent_timestamp": {"value": 1614592800000, "value_as_string": "2021-03-01T10:00:00.000Z"}},
+			{"key": "COMPLETED", "doc_count": 2, "scan_recent_timestamp": {"value": 1614600000000, "value_as_string": "2021-03-01T12:00:00.000Z"}}
+		]}},
+		{"key": "image/client", "doc_count": 1, "action": {"buckets": [
+			{"key": "QUEUED", "doc_count": 1, "scan_recent_timestamp": {"value": 1614600000000, "value_as_string": "2021-03-01T12:00:00.000Z"}}
+		]}}
+	]}}`
+	cveAggregations = `{"cve_container_image": {"buckets": [
+		{"key": "image/server", "doc_count": 4, "scan_id": {"buckets": [
+			{"key": "image/server_2021-03-01T12:00:00.000Z", "doc_count": 4, "cve_severity": {"buckets": [
+				{"key": "low", "doc_count": 3},
+				{"key": "Critical", "doc_count": 1}
+			]}}
+		]}}
+	]}}`
+)
+
+func aggregations(t *testing.T, s string) elastic.Aggregations {
+	var aggs elastic.Aggregations
+	if err := json.Unmarshal([]byte(s), &aggs); err != nil {
+		t.Fatal(err)
+	}
+	return aggs
+}
+
+func TestVulnerabilityScans(t *testing.T) {
+	statuses, ok := vulnerabilityStatuses(aggregations(t, scanLogsAggregations), aggregations(t, cveAggregations))
+	if !ok {
+		t.Fatal("Expected statuses")
+	}
+	server := statuses[fixture.ServerContainerImageName]
+	if server.Status != report.ScanStatusComplete || server.Severity != render.SeverityCritical ||
+		server.Counts["critical"] != 1 || server.Counts["low"] != 3 {
+		t.Errorf("Expected a complete scan finding a critical vulnerability, got %+v", server)
+	}
+	if want := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC); !server.LastScan.Equal(want) {
+		t.Errorf("Expected the scan at %v, got %v", want, server.LastScan)
+	}
+	if client := statuses[fixture.ClientContainerImageName]; client.Status != report.ScanStatusQueued || client.Severity != "" || client.Counts != nil {
+		t.Errorf("Expected a queued scan with no findings, got %+v", client)
+	}
+
+	// The images, and their containers, have the statuses
+	rpt := decorateVulnerabilityScans(fixture.Report, statuses, 1)
+	for _, id := range []string{fixture.ServerContainerImageNodeID, fixture.ServerContainerNodeID} {
+		n, ok := rpt.ContainerImage.Nodes[id]
+		if !ok {
+			n = rpt.Container.Nodes[id]
+		}
+		if severity := render.WorstSeverity(n); severity != render.SeverityCritical {
+			t.Errorf("%s: expected critical, got %s", id, severity)
+		}
+	}
+	if _, ok := fixture.Report.Container.Nodes[fixture.ServerContainerNodeID].LookupScanStatus(report.VulnerabilityScan); ok {
+		t.Error("Expected the report not to be modified")
+	}
+
+	// Which rank the server container exposed by its vulnerabilities, and
+	// match the rule on critical images, as do the other containers of the
+	// image
+	exposure := render.ScoreExposure{Report: rpt, Weights: render.DefaultExposureWeights}
+	if e := exposure.Score(rpt.Container.Nodes[fixture.ServerContainerNodeID], false); e.Factors[render.ExposureVulnerabilities] <= 0 {
+		t.Errorf("Expected the vulnerabilities to count, got %+v", e)
+	}
+	matched := map[string]bool{}
+	for _, event := range criticalImages(context.Background(), rpt, fixture.Now) {
+		matched[event.NodeID] = true
+		if event.Details["critical"] != "1" {
+			t.Errorf("Expected a critical vulnerability, got %+v", event)
+		}
+	}
+	if !matched[fixture.ServerContainerNodeID] || matched[fixture.ClientContainerNodeID] {
+		t.Errorf("Expected the server containers, got %v", matched)
+	}
+}
@@ -0,0 +1,268 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Headers of the requests posting events to webhooks.
+const (
+	WebhookEventHeader     = "X-Scope-Event"
+	WebhookSignatureHeader = "X-Scope-Signature" // sha256=<hex HMAC of the body>
+)
+
+// webhookQueue is how many events a webhook may fall behind by before
+// further ones are dead letters.
+const webhookQueue = 1000
+
+// WebhookTarget is a URL events are posted to, signed with Secret if
+// given.
+type WebhookTarget struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"` // types of events posted; all if none
+}
+
+func (t WebhookTarget) validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", t.URL)
+	}
+	return nil
+}
+
+func (t WebhookTarget) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookConfig is the YAML config file of the rules of events enabled, and
+// the webhooks to post them to.
+type WebhookConfig struct {
+	Rules   []string        `json:"rules,omitempty"`
+	Targets []WebhookTarget `json:"targets,omitempty"`
+}
+
+// ReadWebhookConfig reads a WebhookConfig from a YAML file.
+func ReadWebhookConfig(path string) (WebhookConfig, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return WebhookConfig{}, err
+	}
+	var config WebhookConfig
+	if err := yaml.UnmarshalStrict(buf, &config); err != nil {
+		return WebhookConfig{}, err
+	}
+	for _, rule := range config.Rules {
+		if !isEventRule(rule) {
+			return WebhookConfig{}, fmt.Errorf("unknown rule %q", rule)
+		}
+	}
+	for _, target := range config.Targets {
+		if err := target.validate(); err != nil {
+			return WebhookConfig{}, err
+		}
+	}
+	return config, nil
+}
+
+// WebhookOptions are the options of a WebhookSink.
+type WebhookOptions struct {
+	Attempts       int           // to post each event, at most
+	InitialBackoff time.Duration // between the first attempts, doubling after
+	MaxBackoff     time.Duration
+	Client         *http.Client
+}
+
+// DefaultWebhookOptions are the options webhooks are posted to with,
+// unless set otherwise.
+var DefaultWebhookOptions = WebhookOptions{
+	Attempts:       5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Client:         &http.Client{Timeout: 10 * time.Second},
+}
+
+// webhookPayload is the body of the requests posting events.
+type webhookPayload struct {
+	Tenant string `json:"tenant,omitempty"`
+	Event
+}
+
+type webhook struct {
+	WebhookTarget
+	host  string // for logs and metrics, as URLs may have tokens in them
+	queue chan webhookPayload
+}
+
+// WebhookSink is an EventSink posting events as JSON to webhooks, each
+// in the order published.  Posts failing with server errors, or not at
+// all, are retried with backoff; events which can't be posted are dead
+// letters, counted and dropped.
+type WebhookSink struct {
+	opts     WebhookOptions
+	webhooks []webhook
+
+	deadLetters int64 // atomic
+}
+
+// NewWebhookSink makes a WebhookSink posting events to targets.
+func NewWebhookSink(targets []WebhookTarget, opts WebhookOptions) (*WebhookSink, error) {
+	s := &WebhookSink{opts: opts}
+	for _, target := range targets {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(target.URL)
+		s.webhooks = append(s.webhooks, webhook{
+			WebhookTarget: target,
+			host:          u.Host,
+			queue:         make(chan webhookPayload, webhookQueue),
+		})
+	}
+	return s, nil
+}
+
+// Send implements EventSink, queueing the event for the webhooks wanting
+// it.
+func (s *WebhookSink) Send(tenant string, e Event) {
+	for _, w := range s.webhooks {
+		if !w.wants(e.Type) {
+			continue
+		}
+		select {
+		case w.queue <- webhookPayload{Tenant: tenant, Event: e}:
+		default:
+			log.Warnf("Dropping %s event of %s for webhook %s, too far behind", e.Type, e.NodeID, w.host)
+			s.deadLetter(w)
+		}
+	}
+}
+
+// DeadLetters returns how many events couldn't be posted to webhooks.
+func (s *WebhookSink) DeadLetters() int64 {
+	return atomic.LoadInt64(&s.deadLetters)
+}
+
+func (s *WebhookSink) deadLetter(w webhook) {
+	atomic.AddInt64(&s.deadLetters, 1)
+	webhookDeliveries.WithLabelValues(w.host, "dead_letter").Inc()
+}
+
+// Run posts the events queued to each webhook until ctx is done.
+func (s *WebhookSink) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, w := range s.webhooks {
+		wg.Add(1)
+		go func(w webhook) {
+			defer wg.Done()
+			for {
+				select {
+				case payload := <-w.queue:
+					s.deliver(ctx, w, payload)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// deliver posts an event to a webhook, retrying with backoff until it's
+// accepted, refused, or the attempts run out.
+func (s *WebhookSink) deliver(ctx context.Context, w webhook, payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("Error encoding %s event for webhook %s: %v", payload.Type, w.host, err)
+		s.deadLetter(w)
+		return
+	}
+	backoff := s.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, w, payload.Type, body)
+		if err == nil {
+			webhookDeliveries.WithLabelValues(w.host, "delivered").Inc()
+			return
+		}
+		if !retry || attempt >= s.opts.Attempts {
+			log.Warnf("Error posting %s event of %s to webhook %s, giving up after %d attempts: %v", payload.Type, payload.NodeID, w.host, attempt, err)
+			s.deadLetter(w)
+			return
+		}
+		log.Infof("Error posting %s event to webhook %s, backing off %s: %v", payload.Type, w.host, backoff, err)
+		webhookDeliveries.WithLabelValues(w.host, "retried").Inc()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			s.deadLetter(w)
+			return
+		}
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+}
+
+// post posts the body of an event to a webhook once, returning whether
+// it's worth retrying if it fails.
+func (s *WebhookSink) post(ctx context.Context, w webhook, eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventType)
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, body))
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		return true, fmt.Errorf("%s", resp.Status)
+	default:
+		return false, fmt.Errorf("%s", resp.Status)
+	}
+}
+
+// SignWebhook returns the signature of the body of a post to a webhook
+// with a secret, as in its WebhookSignatureHeader: the hex HMAC-SHA256 of
+// the body, prefixed by sha256=.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,184 @@
+package app_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+)
+
+type webhookPost struct {
+	Tenant string `json:"tenant"`
+	app.Event
+}
+
+// webhookReceiver is a webhook checking the signatures of the events
+// posted to it with secret, failing the first post with a server error.
+func webhookReceiver(t *testing.T, secret string, posts chan<- webhookPost) *httptest.Server {
+	var requests int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(want), []byte(r.Header.Get(app.WebhookSignatureHeader))) {
+			t.Errorf("Bad signature %q of %s", r.Header.Get(app.WebhookSignatureHeader), body)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var post webhookPost
+		if err := json.Unmarshal(body, &post); err != nil {
+			t.Error(err)
+		}
+		if post.Type != r.Header.Get(app.WebhookEventHeader) {
+			t.Errorf("Event of type %s posted as %s", post.Type, r.Header.Get(app.WebhookEventHeader))
+		}
+		posts <- post
+	}))
+}
+
+func TestWebhooks(t *testing.T) {
+	posts := make(chan webhookPost, 10)
+	receiver := webhookReceiver(t, "s3cret", posts)
+	defer receiver.Close()
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer refusing.Close()
+
+	sink, err := app.NewWebhookSink([]app.WebhookTarget{
+		{URL: receiver.URL, Secret: "s3cret"},
+		{URL: refusing.URL, Events: []string{app.PrivilegedHostRule}},
+	}, app.WebhookOptions{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Client: http.DefaultClient})
+	ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+	events := app.NewEventStream(10)
+	events.AddSink(sink)
+
+	now := time.Now()
+	probes := app.NewLocalProbeRegistry(time.Hour)
+	ok(t, probes.UpdateProbe(ctx, "probe-1", func(s app.ProbeStatus) app.ProbeStatus {
+		return s.Reported(fixture.ServerHostID, "1.0", now)
+	}))
+	rules := app.NewRuleEvaluator(app.EventRules, probes, events)
+
+	// Nothing is posted of what's there when first evaluated
+	rpt := fixture.Report.Copy()
+	rpt.ID = "webhooks-before"
+	ok(t, rules.Evaluate(ctx, "", rpt, now))
+
+	// Then the client container runs privileged, the server one has a
+	// critical vulnerability, and the probe goes silent
+	rpt = fixture.Report.Copy()
+	rpt.ID = "webhooks-after"
+	rpt.Container.Nodes = rpt.Container.Nodes.Copy()
+	client := rpt.Container.Nodes[fixture.ClientContainerNodeID]
+	rpt.Container.Nodes[client.ID] = client.WithLatests(map[string]string{report.DockerPrivileged: "true"})
+	server := rpt.Container.Nodes[fixture.ServerContainerNodeID]
+	rpt.Container.Nodes[server.ID] = server.WithScanStatus(report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusComplete,
+		Counts:   map[string]int{"critical": 2},
+		LastScan: now.Add(-time.Hour),
+	})
+	ok(t, rules.Evaluate(ctx, "", rpt, now.Add(time.Minute)))
+	// Only once
+	ok(t, rules.Evaluate(ctx, "", rpt, now.Add(2*time.Minute)))
+
+	var have []webhookPost
+	for len(have) < 3 {
+		select {
+		case post := <-posts:
+			have = append(have, post)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 events, got %+v", have)
+		}
+	}
+	sort.Slice(have, func(i, j int) bool { return have[i].Type < have[j].Type })
+	for i, want := range []struct {
+		eventType, nodeID, topologyID, detail, value string
+	}{
+		{app.CriticalImageRule, fixture.ServerContainerNodeID, "containers", "critical", "2"},
+		{app.PrivilegedHostRule, fixture.ClientHostNodeID, "hosts", "containers", fixture.ClientContainerName},
+		{app.SilentProbeRule, fixture.ServerHostNodeID, "hosts", "probe_id", "probe-1"},
+	} {
+		post := have[i]
+		if post.Type != want.eventType || post.NodeID != want.nodeID || post.TopologyID != want.topologyID || post.Details[want.detail] != want.value {
+			t.Errorf("Expected %+v, got %+v", want, post)
+		}
+	}
+	select {
+	case post := <-posts:
+		t.Errorf("Unexpected %+v", post)
+	default:
+	}
+
+	// The refusing webhook's event isn't retried
+	for start := time.Now(); sink.DeadLetters() != 1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected a dead letter, have %d", sink.DeadLetters())
+		}
+	}
+}
+
+func TestReadWebhookConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "webhooks.yaml")
+	write := func(config string) {
+		ok(t, ioutil.WriteFile(path, []byte(config), 0600))
+	}
+
+	write(`
+rules: [privileged_host, silent_probe]
+targets:
+- url: https://siem.example.com/events
+  secret: s3cret
+- url: https://hooks.slack.com/services/T0/B0/X
+  events: [silent_probe]
+`)
+	config, err := app.ReadWebhookConfig(path)
+	ok(t, err)
+	equals(t, app.WebhookConfig{
+		Rules: []string{app.PrivilegedHostRule, app.SilentProbeRule},
+		Targets: []app.WebhookTarget{
+			{URL: "https://siem.example.com/events", Secret: "s3cret"},
+			{URL: "https://hooks.slack.com/services/T0/B0/X", Events: []string{app.SilentProbeRule}},
+		},
+	}, config)
+
+	for _, bad := range []string{
+		"rules: [no_such_rule]",
+		"targets: [{url: 'ftp://example.com'}]",
+		"targets: [{uri: 'https://example.com'}]",
+	} {
+		write(bad)
+		if _, err := app.ReadWebhookConfig(path); err == nil {
+			t.Errorf("Expected an error reading %q", bad)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentField is the field naming the component of each log line.
+const ComponentField = "component"
+
+var (
+	mtx        sync.Mutex
+	components = map[string]*logrus.Logger{}
+)
+
+// Component returns the logger of the named component, e.g. docker.  Its
+// lines carry the ComponentField, and are written through the standard
+// logger, at the level of the component if SetLevels gave it one.
+func Component(name string) *logrus.Entry {
+	mtx.Lock()
+	defer mtx.Unlock()
+	l, ok := components[name]
+	if !ok {
+		std := logrus.StandardLogger()
+		l = &logrus.Logger{
+			Out:       stdOut{},
+			Formatter: stdFormatter{},
+			Hooks:     std.Hooks,
+			Level:     std.GetLevel(),
+			ExitFunc:  os.Exit,
+		}
+		components[name] = l
+	}
+	return l.WithField(ComponentField, name)
+}
+
+// Levels are the levels of the standard logger and of components.
+type Levels struct {
+	Default     logrus.Level
+	ByComponent map[string]logrus.Level
+}
+
+// ParseLevels parses levels, comma-separated, each either the default or
+// that of a component, e.g. "info,endpoint=debug,docker=warn".  The
+// default is info unless given.
+func ParseLevels(levels string) (Levels, error) {
+	result := Levels{Default: logrus.InfoLevel, ByComponent: map[string]logrus.Level{}}
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, l := range strings.Split(levels, ",") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		name, levelName := "", l
+		if i := strings.Index(l, "="); i >= 0 {
+			name, levelName = l[:i], l[i+1:]
+		}
+		level, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			return Levels{}, err
+		}
+		if name == "" {
+			result.Default = level
+			continue
+		}
+		if _, ok := components[name]; !ok {
+			return Levels{}, fmt.Errorf("unknown log component %q, not one of %s", name, strings.Join(componentNames(), ", "))
+		}
+		result.ByComponent[name] = level
+	}
+	return result, nil
+}
+
+// Set sets the level of the standard logger to the default, and those of
+// components to theirs, or the default if not given one.
+func (l Levels) Set() {
+	mtx.Lock()
+	defer mtx.Unlock()
+	logrus.SetLevel(l.Default)
+	for name, c := range components {
+		if level, ok := l.ByComponent[name]; ok {
+			c.SetLevel(level)
+		} else {
+			c.SetLevel(l.Default)
+		}
+	}
+}
+
+// SetLevels parses and sets levels, as ParseLevels and Set.
+func SetLevels(levels string) error {
+	l, err := ParseLevels(levels)
+	if err != nil {
+		return err
+	}
+	l.Set()
+	return nil
+}
+
+func componentNames() []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stdOut writes to the output of the standard logger, whatever it is at
+// the time.
+type stdOut struct{}
+
+func (stdOut) Write(p []byte) (int, error) {
+	return logrus.StandardLogger().Out.Write(p)
+}
+
+// stdFormatter formats entries as the standard logger does at the time.
+type stdFormatter struct{}
+
+func (stdFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return logrus.StandardLogger().Formatter.Format(entry)
+}
@@ -0,0 +1,71 @@
+package logger_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/weaveworks/scope/common/logger"
+)
+
+func TestComponentLevelsAndJSON(t *testing.T) {
+	std := logrus.StandardLogger()
+	defer func(out io.Writer, formatter logrus.Formatter) {
+		logrus.SetOutput(out)
+		logrus.SetFormatter(formatter)
+		logger.SetLevels("info")
+	}(std.Out, std.Formatter)
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	endpoint := logger.Component("endpoint")
+	docker := logger.Component("docker")
+	if err := logger.SetLevels("warn,endpoint=debug,docker=info"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SetLevels("info,nosuchcomponent=debug"); err == nil {
+		t.Error("Expected levels of unknown components to be refused")
+	}
+	if err := logger.SetLevels("endpoint=loud"); err == nil {
+		t.Error("Expected invalid levels to be refused")
+	}
+
+	endpoint.Debug("endpoint debug")
+	docker.Debug("docker debug")
+	docker.WithField("container_id", "abc").Info("docker info")
+	logrus.Info("default info")
+	logrus.Warn("default warn")
+
+	type line struct {
+		Msg         string `json:"msg"`
+		Component   string `json:"component"`
+		ContainerID string `json:"container_id"`
+	}
+	var lines []line
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("Expected lines of JSON, got %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+	want := []line{
+		{Msg: "endpoint debug", Component: "endpoint"},
+		{Msg: "docker info", Component: "docker", ContainerID: "abc"},
+		{Msg: "default warn"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], lines[i])
+		}
+	}
+}
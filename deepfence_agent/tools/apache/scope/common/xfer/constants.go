@@ -12,12 +12,54 @@ const (
 
 	// ScopeProbeVersionHeader is the header we use to carry the probe's version.
 	ScopeProbeVersionHeader = "X-deepfence-discovery-Version"
+
+	// ScopeReportSeqHeader is the header we use to carry the sequence number
+	// of reports, from probes publishing deltas between them.
+	ScopeReportSeqHeader = "X-Deepfence-Discovery-Report-Seq"
+
+	// ScopeReportBaseHeader is the header we use to carry the sequence number
+	// of the report a delta is from.
+	ScopeReportBaseHeader = "X-Deepfence-Discovery-Report-Base"
+
+	// ScopeReportTimestampHeader is the header we use to carry the time,
+	// RFC 3339 formatted, of reports published late by probes which
+	// couldn't reach the app when they were made.
+	ScopeReportTimestampHeader = "X-Deepfence-Discovery-Report-Timestamp"
+
+	// ScopeReportChecksumHeader is the header we use to carry the sha256,
+	// hex encoded, of reports as encoded before being gzipped, for apps to
+	// check reports arrive intact.
+	ScopeReportChecksumHeader = "X-Deepfence-Discovery-Report-Sha256"
 )
 
 // HistoricReportsCapability indicates whether reports older than the
 // current time (-app.window) can be retrieved.
 const HistoricReportsCapability = "historic_reports"
 
+// ProtobufReportsCapability indicates whether the app takes reports encoded
+// as protobuf, rather than msgpack.
+const ProtobufReportsCapability = "protobuf_reports"
+
+// ReportDeltasCapability indicates whether the app takes the deltas between
+// consecutive reports of probes, rather than only whole reports.
+const ReportDeltasCapability = "report_deltas"
+
+// ProtobufIDTableCapability indicates whether the app takes protobuf reports
+// with the node IDs of adjacencies and parents in a table.
+const ProtobufIDTableCapability = "protobuf_id_table"
+
+// ReportChecksumCapability indicates whether the app checks the checksums
+// of reports, refusing those which don't match with
+// http.StatusUnprocessableEntity.
+const ReportChecksumCapability = "report_checksum"
+
+// Content types of the reports probes publish.
+const (
+	MsgpackContentType  = "application/msgpack"
+	ProtobufContentType = "application/x-protobuf"
+	DeltaContentType    = "application/x-scope-delta+msgpack"
+)
+
 // Details are some generic details that can be fetched from /api
 type Details struct {
 	ID           string          `json:"id"`
@@ -25,10 +67,22 @@ type Details struct {
 	Hostname     string          `json:"hostname"`
 	Plugins      PluginSpecs     `json:"plugins,omitempty"`
 	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	// MaxReportSize is the size of the largest reports the app takes, so
+	// probes trim theirs to it; zero means no limit.
+	MaxReportSize int `json:"maxReportSize,omitempty"`
 
 	NewVersion *NewVersionInfo `json:"newVersion,omitempty"`
 }
 
+// Handshake is what probes and apps tell each other on connecting, at
+// /topology-api/handshake: the probe its version and the capabilities it has, the
+// app its version and those of them it accepts.  Legacy apps don't take
+// handshakes, and probes go by the capabilities in their Details instead.
+type Handshake struct {
+	Version      string          `json:"version"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
 // NewVersionInfo is the struct exposed in /api when there is a new
 // version of Scope available.
 type NewVersionInfo struct {
@@ -1,10 +1,13 @@
 package xfer
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/rpc"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // ErrInvalidMessage is the error returned when the on-wire message is unexpected.
@@ -16,6 +19,29 @@ type Request struct {
 	NodeID      string
 	Control     string
 	ControlArgs map[string]string
+
+	// Set by apps which can cancel requests and relay their progress, and
+	// ignored by probes which can't
+	ID           string        // to cancel the request by, and of its Progress
+	Timeout      time.Duration // after which the request is cancelled, if any
+	WantProgress bool          // whether to send Progress while handling it
+}
+
+// CancelControl is the control an app asks a probe to cancel the request of
+// ID RequestIDArg with, once the caller of the request gives up on it.
+// Probes which don't know it respond with an error, which is ignored.
+const (
+	CancelControl = "cancel_control"
+	RequestIDArg  = "request_id"
+)
+
+// Progress is the Probe -> App -> UI message type of how a long running
+// control is getting on, which probes send while handling the requests
+// which want it.
+type Progress struct {
+	RequestID string  `json:"request_id,omitempty"`
+	Message   string  `json:"message,omitempty"`
+	Fraction  float64 `json:"fraction,omitempty"` // of the control done, if known
 }
 
 // Response is the Probe -> App -> UI message type for the control RPCs.
@@ -23,6 +49,12 @@ type Response struct {
 	Value interface{} `json:"value,omitempty"`
 	Error string      `json:"error,omitempty"`
 
+	// Structured results, as JSON, for callers to decode rather than parse
+	// Value, and a hint of their schema.  Probes which only send Value
+	// leave them empty.
+	Result       json.RawMessage `json:"result,omitempty"`
+	ResultSchema string          `json:"result_schema,omitempty"`
+
 	// Pipe specific fields
 	Pipe             string `json:"pipe,omitempty"`
 	RawTTY           bool   `json:"raw_tty,omitempty"`
@@ -41,11 +73,12 @@ type Response struct {
 	ImagesList              []string                 `json:"images_list,omitempty"`
 }
 
-// Message is the unions of Request, Response and arbitrary Value.
+// Message is the unions of Request, Response, Progress and arbitrary Value.
 type Message struct {
 	Request  *rpc.Request
 	Response *rpc.Response
 	Value    interface{}
+	Progress *Progress `json:",omitempty"`
 }
 
 // ControlHandler is interface used in the app and the probe to represent
@@ -64,6 +97,28 @@ func (c ControlHandlerFunc) Handle(req Request, res *Response) error {
 	return nil
 }
 
+// ControlContextHandler is a ControlHandler of controls which may run for
+// long, which handles requests until they're done or their context is, and
+// may report how they're getting on as they go.
+type ControlContextHandler interface {
+	ControlHandler
+	HandleContext(ctx context.Context, req Request, progress func(Progress)) Response
+}
+
+// ControlContextHandlerFunc is an adapter for ControlContextHandler.
+type ControlContextHandlerFunc func(ctx context.Context, req Request, progress func(Progress)) Response
+
+// HandleContext implements ControlContextHandler
+func (c ControlContextHandlerFunc) HandleContext(ctx context.Context, req Request, progress func(Progress)) Response {
+	return c(ctx, req, progress)
+}
+
+// Handle handles a request without cancellation, dropping its progress.
+func (c ControlContextHandlerFunc) Handle(req Request, res *Response) error {
+	*res = c(context.Background(), req, func(Progress) {})
+	return nil
+}
+
 // ResizeTTYControlWrapper extracts the arguments needed by the resize tty control handler
 func ResizeTTYControlWrapper(next func(pipeID string, height, width uint) Response) ControlHandlerFunc {
 	return func(req Request) Response {
@@ -116,12 +171,26 @@ func ResponseError(err error) Response {
 	return Response{}
 }
 
+// ResponseResult creates a new Response with the given structured result,
+// of the given schema.
+func ResponseResult(schema string, result interface{}) Response {
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return ResponseErrorf("Error encoding result: %v", err)
+	}
+	return Response{
+		Result:       buf,
+		ResultSchema: schema,
+	}
+}
+
 // JSONWebsocketCodec is golang rpc compatible Server and Client Codec
 // that transmits and receives RPC messages over a websocker, as JSON.
 type JSONWebsocketCodec struct {
 	sync.Mutex
-	conn Websocket
-	err  chan error
+	conn       Websocket
+	err        chan error
+	onProgress func(Progress)
 }
 
 // NewJSONWebsocketCodec makes a new JSONWebsocketCodec
@@ -160,14 +229,36 @@ func (j *JSONWebsocketCodec) WriteResponse(r *rpc.Response, v interface{}) error
 	return j.conn.WriteJSON(Message{Value: v})
 }
 
+// OnProgress sets the function the Progress read on the codec is passed
+// to, which mustn't block for long; it must be set before the codec is used.
+// Progress is dropped otherwise.
+func (j *JSONWebsocketCodec) OnProgress(f func(Progress)) {
+	j.onProgress = f
+}
+
+// WriteProgress sends the progress of a request, between RPC messages.
+func (j *JSONWebsocketCodec) WriteProgress(p Progress) error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.conn.WriteJSON(Message{Progress: &p})
+}
+
 func (j *JSONWebsocketCodec) readMessage(v interface{}) (*Message, error) {
-	m := Message{Value: v}
-	if err := j.conn.ReadJSON(&m); err != nil {
-		j.err <- err
-		close(j.err)
-		return nil, err
+	for {
+		m := Message{Value: v}
+		if err := j.conn.ReadJSON(&m); err != nil {
+			j.err <- err
+			close(j.err)
+			return nil, err
+		}
+		if m.Progress == nil {
+			return &m, nil
+		}
+		if j.onProgress != nil {
+			j.onProgress(*m.Progress)
+		}
 	}
-	return &m, nil
 }
 
 // ReadResponseHeader implements rpc.ClientCodec
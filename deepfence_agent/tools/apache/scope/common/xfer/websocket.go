@@ -157,10 +157,9 @@ func (p *pingingWebsocket) ReadJSON(v interface{}) error {
 	return err
 }
 
-// Close closes the connection
+// Close closes the connection.  It doesn't wait for writes in progress,
+// which fail, so that connections blocked on writes can be closed.
 func (p *pingingWebsocket) Close() error {
-	p.writeLock.Lock()
-	defer p.writeLock.Unlock()
 	p.pinger.Stop()
 	return p.conn.Close()
 }
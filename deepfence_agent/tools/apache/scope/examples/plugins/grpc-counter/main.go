@@ -0,0 +1,108 @@
+// Command grpc-counter is an example gRPC probe plugin, written with the
+// sdk package.  It shows a counter on the probe's host, which its control
+// increments, and streams the counter every interval.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/plugins/sdk"
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	counterKey       = "grpc_counter_value"
+	incrementControl = "grpc_counter_increment"
+)
+
+type counter struct {
+	hostID   string
+	interval time.Duration
+
+	mtx   sync.Mutex
+	count int
+}
+
+func (c *counter) Spec() xfer.PluginSpec {
+	return xfer.PluginSpec{
+		ID:          "grpc-counter",
+		Label:       "gRPC Counter",
+		Description: "Counts clicks on the host",
+	}
+}
+
+func (c *counter) Report(ctx context.Context) (report.Report, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	rpt := report.MakeReport()
+	rpt.Host = rpt.Host.WithMetadataTemplates(report.MetadataTemplates{
+		counterKey: {ID: counterKey, Label: "Counter", From: report.FromLatest, Datatype: report.Number},
+	})
+	rpt.Host.Controls.AddControl(report.Control{ID: incrementControl, Human: "Increment", Icon: "fa fa-plus", Rank: 1})
+	rpt.Host.AddNode(report.MakeNodeWith(report.MakeHostNodeID(c.hostID), map[string]string{
+		counterKey: strconv.Itoa(c.count),
+	}).WithLatestActiveControls(incrementControl))
+	return rpt, nil
+}
+
+func (c *counter) HandleControl(ctx context.Context, req xfer.Request) (xfer.Response, *report.Report) {
+	if req.Control != incrementControl {
+		return xfer.ResponseErrorf("unknown control %s", req.Control), nil
+	}
+	c.mtx.Lock()
+	c.count++
+	c.mtx.Unlock()
+	rpt, _ := c.Report(ctx)
+	return xfer.Response{}, &rpt
+}
+
+func (c *counter) Updates(ctx context.Context, updates chan<- report.Report) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rpt, _ := c.Report(ctx)
+			select {
+			case updates <- rpt:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func main() {
+	hostname, _ := os.Hostname()
+	var (
+		dir      = flag.String("plugins.root", "/var/run/scope/plugins", "Directory of the probe's plugins")
+		hostID   = flag.String("host.id", hostname, "ID of the probe's host")
+		interval = flag.Duration("interval", 10*time.Second, "How often to stream the counter")
+	)
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		cancel()
+	}()
+
+	if err := sdk.Serve(ctx, *dir, &counter{hostID: *hostID, interval: *interval}); err != nil {
+		log.Fatal(err)
+	}
+}
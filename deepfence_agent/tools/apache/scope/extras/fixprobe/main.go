@@ -66,6 +66,6 @@ func main() {
 		log.Fatal(err)
 	}
 	for range time.Tick(*publishInterval) {
-		client.Publish(bytes.NewReader(buf.Bytes()), fixedReport.Shortcut)
+		client.Publish(appclient.Publication{Reader: bytes.NewReader(buf.Bytes()), ContentType: xfer.MsgpackContentType, Shortcut: fixedReport.Shortcut})
 	}
 }
@@ -1,6 +1,8 @@
 package appclient
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,31 +10,43 @@ import (
 	"net/http"
 	"net/rpc"
 	"net/url"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
 	"github.com/gorilla/websocket"
 	"github.com/hashicorp/go-cleanhttp"
-	log "github.com/sirupsen/logrus"
 	"github.com/ugorji/go/codec"
 
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("appclient")
+
 const (
 	httpClientTimeout = 12 * time.Second // a bit less than default app.window
 	initialBackoff    = 1 * time.Second
 	maxBackoff        = 60 * time.Second
+
+	// maxPublishRetries is how many times a report is published again
+	// when the app asks for it, shutting down.
+	maxPublishRetries = 3
 )
 
 // AppClient is a client to an app, dealing with report publishing, controls and pipes.
 type AppClient interface {
 	Details() (xfer.Details, error)
+	Handshake(capabilities map[string]bool) (map[string]bool, bool, error)
 	ControlConnection()
 	PipeConnection(string, xfer.Pipe)
 	PipeClose(string) error
-	Publish(io.Reader, bool) error
+	Publish(p Publication) error
+	FullReportRequested() bool
+	PublishStats() PublishStats
 	Target() url.URL
 	ReTarget(url.URL)
 	Stop()
@@ -57,8 +71,10 @@ type appClient struct {
 	conns map[string]xfer.Websocket
 
 	// For publish
-	publishLoop sync.Once
-	readers     chan io.Reader
+	publishLoop   sync.Once
+	readers       chan Publication
+	fullRequested bool         // guarded by mtx
+	stats         PublishStats // guarded by mtx
 
 	// For controls
 	control xfer.ControlHandler
@@ -78,11 +94,12 @@ func NewAppClient(pc ProbeConfig, hostname string, target url.URL, control xfer.
 		target:      target,
 		client:      httpClient,
 		wsDialer: websocket.Dialer{
+			Proxy:            httpTransport.Proxy,
 			TLSClientConfig:  httpTransport.TLSClientConfig,
 			HandshakeTimeout: httpClientTimeout,
 		},
 		conns:   map[string]xfer.Websocket{},
-		readers: make(chan io.Reader, 2),
+		readers: make(chan Publication, 2),
 		control: control,
 	}, nil
 }
@@ -203,6 +220,45 @@ func (c *appClient) Details() (xfer.Details, error) {
 	return result, nil
 }
 
+// Handshake tells the app the version of the probe and the capabilities
+// it has, returning those of them the app accepts.  Legacy apps, which
+// don't take handshakes, return false.
+func (c *appClient) Handshake(capabilities map[string]bool) (map[string]bool, bool, error) {
+	buf := &bytes.Buffer{}
+	handshake := xfer.Handshake{Version: c.ProbeVersion, Capabilities: capabilities}
+	if err := codec.NewEncoder(buf, &codec.JsonHandle{}).Encode(handshake); err != nil {
+		return nil, false, err
+	}
+	req, err := c.ProbeConfig.authorizedRequest("POST", c.url("/topology-api/handshake"), buf)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("error response from %s: %s", c.url("/topology-api/handshake"), resp.Status)
+	}
+	var result xfer.Handshake
+	if err := codec.NewDecoder(resp.Body, &codec.JsonHandle{}).Decode(&result); err != nil {
+		return nil, false, err
+	}
+	accepted := map[string]bool{}
+	for capability, ok := range result.Capabilities {
+		if ok && capabilities[capability] {
+			accepted[capability] = true
+		}
+	}
+	return accepted, true, nil
+}
+
 func (c *appClient) doWithBackoff(msg string, f func() (bool, error)) {
 	if !c.retainGoroutine() {
 		return
@@ -226,11 +282,11 @@ func (c *appClient) doWithBackoff(msg string, f func() (bool, error)) {
 			// further delays. Moreover, any delays between publishing
 			// reports that exceed the app.window (defaults to 15s)
 			// cause the UI to display no data, which is debilitating.
-			log.Errorf("Error doing %s for %s: %v", msg, c.hostname, err)
+			log.Errorf("Error doing %s for %s: %s", msg, c.hostname, describeConnError(err))
 			backoff = initialBackoff
 			continue
 		}
-		log.Errorf("Error doing %s for %s, backing off %s: %v", msg, c.hostname, backoff, err)
+		log.Errorf("Error doing %s for %s, backing off %s: %s", msg, c.hostname, backoff, describeConnError(err))
 		select {
 		case <-time.After(backoff):
 		case <-c.quit:
@@ -253,16 +309,9 @@ func (c *appClient) controlConnection() (bool, error) {
 	}
 	defer conn.Close()
 
-	doControl := func(req xfer.Request) xfer.Response {
-		req.AppID = c.appID
-		var res xfer.Response
-		c.control.Handle(req, &res)
-		return res
-	}
-
 	codec := xfer.NewJSONWebsocketCodec(conn)
 	server := rpc.NewServer()
-	if err := server.RegisterName("control", xfer.ControlHandlerFunc(doControl)); err != nil {
+	if err := server.RegisterName("control", newControlServer(c, codec)); err != nil {
 		return false, err
 	}
 
@@ -284,14 +333,118 @@ func (c *appClient) ControlConnection() {
 	}()
 }
 
-func (c *appClient) publish(r io.Reader) error {
+// Publication is an encoded report, or delta between reports, to publish.
+type Publication struct {
+	io.Reader
+	ContentType string
+	Shortcut    bool
+
+	// Seq is the sequence number of the report, from probes publishing
+	// deltas, and Base that of the report a delta is from; zero otherwise.
+	Seq, Base uint64
+
+	// Timestamp is when the report was made, set on publishing if zero.
+	Timestamp time.Time
+
+	// Checksum is that of the report, as encoded before gzipping, for apps
+	// checking them; nil for the others.
+	Checksum *report.Checksum
+
+	// backdated is whether the report is published late, from the spool.
+	backdated bool
+
+	// uncompressed is whether the report has been gunzipped, after the app
+	// found it corrupt.
+	uncompressed bool
+}
+
+// retryError is the app refusing a report, asking for it to be published
+// again after a while.
+type retryError struct {
+	status string
+	after  time.Duration
+}
+
+func (e retryError) Error() string {
+	return fmt.Sprintf("%s: publish again in %v", e.status, e.after)
+}
+
+// publish publishes a report, again if the app asks for it, which by then is
+// usually another replica.
+func (c *appClient) publish(p Publication) error {
+	for retries := 0; ; retries++ {
+		err := c.publishOnce(p)
+		if statusErr, ok := err.(statusError); ok && statusErr.code == http.StatusUnprocessableEntity {
+			return c.publishUncompressed(p, err)
+		}
+		retry, ok := err.(retryError)
+		seeker, seekable := p.Reader.(io.Seeker)
+		if !ok || !seekable || retries == maxPublishRetries {
+			return err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		log.Infof("%s asked for the report again in %v", c.hostname, retry.after)
+		select {
+		case <-time.After(retry.after):
+		case <-c.quit:
+			return err
+		}
+	}
+}
+
+// publishUncompressed publishes a report the app found corrupt once more,
+// not gzipped, to tell whether it's the compressed stream which is being
+// corrupted on the way, e.g. by a middlebox.
+func (c *appClient) publishUncompressed(p Publication, err error) error {
+	seeker, seekable := p.Reader.(io.Seeker)
+	if p.Checksum == nil || p.uncompressed || !seekable {
+		return err
+	}
+	log.Warnf("%s found the report corrupt, publishing it again uncompressed: %v", c.hostname, err)
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	gzr, err := gzip.NewReader(p.Reader)
+	if err != nil {
+		return err
+	}
+	payload, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		return err
+	}
+	p.Reader, p.uncompressed = bytes.NewReader(payload), true
+	if err := c.publishOnce(p); err != nil {
+		log.Warnf("%s found the report corrupt even uncompressed: %v", c.hostname, err)
+		return err
+	}
+	log.Warnf("%s took the report uncompressed: something between us corrupts compressed reports", c.hostname)
+	return nil
+}
+
+func (c *appClient) publishOnce(p Publication) error {
 	url := c.url("/topology-api/report")
-	req, err := c.ProbeConfig.authorizedRequest("POST", url, r)
+	req, err := c.ProbeConfig.authorizedRequest("POST", url, p.Reader)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Encoding", "gzip")
-	req.Header.Set("Content-Type", "application/msgpack")
+	if !p.uncompressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Content-Type", p.ContentType)
+	if p.Checksum != nil {
+		req.Header.Set(xfer.ScopeReportChecksumHeader, p.Checksum.String())
+	}
+	if p.Seq != 0 {
+		req.Header.Set(xfer.ScopeReportSeqHeader, fmt.Sprint(p.Seq))
+	}
+	if p.Base != 0 {
+		req.Header.Set(xfer.ScopeReportBaseHeader, fmt.Sprint(p.Base))
+	}
+	if p.backdated {
+		req.Header.Set(xfer.ScopeReportTimestampHeader, p.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
 	// req.Header.Set("Content-Type", "application/binary") // TODO: we should use http.DetectContentType(..) on the gob'ed
 
 	// Make sure this request is cancelled when we stop the client
@@ -307,47 +460,233 @@ func (c *appClient) publish(r io.Reader) error {
 		{Name: "destination", Value: req.Host},
 		{Name: "status", Value: fmt.Sprint(resp.StatusCode)},
 	})
+	if resp.StatusCode == http.StatusConflict && p.Base != 0 {
+		// The app can't apply the delta, not having the report it's from
+		log.Infof("%s requested a full report", c.hostname)
+		c.mtx.Lock()
+		c.fullRequested = true
+		c.mtx.Unlock()
+		return nil
+	}
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			after := time.Duration(seconds) * time.Second
+			if after > maxBackoff {
+				after = maxBackoff
+			}
+			return retryError{status: resp.Status, after: after}
+		}
+	}
 	if resp.StatusCode != http.StatusOK {
 		text, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf(resp.Status + ": " + string(text))
+		return statusError{code: resp.StatusCode, text: resp.Status + ": " + string(text)}
 	}
 	return nil
 }
 
+// statusError is the app refusing a report.
+type statusError struct {
+	code int
+	text string
+}
+
+func (e statusError) Error() string {
+	return e.text
+}
+
+// refused returns whether err is the app refusing a report for good, so
+// that it's of no use publishing it again: any 4xx but 408 Request Timeout
+// and 429 Too Many Requests, e.g. 413 for a report too large.
+func refused(err error) bool {
+	statusErr, ok := err.(statusError)
+	return ok && statusErr.code >= 400 && statusErr.code < 500 &&
+		statusErr.code != http.StatusRequestTimeout && statusErr.code != http.StatusTooManyRequests
+}
+
 func (c *appClient) startPublishing() {
 	go func() {
 		log.Infof("Publish loop for %s starting", c.hostname)
 		defer log.Infof("Publish loop for %s exiting", c.hostname)
 		c.doWithBackoff("publish", func() (bool, error) {
-			r := <-c.readers
-			if r == nil {
+			p, ok := <-c.readers
+			if !ok {
 				return true, nil
 			}
-			return false, c.publish(r)
+			err := c.publish(p)
+			c.recordPublish(err)
+			switch {
+			case err == nil:
+				c.startReplay()
+			case !refused(err): // Or it would be refused late too
+				c.spool(p)
+			}
+			return false, err
 		})
 	}()
 }
 
+// spool keeps a report which couldn't be published in the spool, if any,
+// to publish it late. Shortcut reports and deltas are of no use late.
+func (c *appClient) spool(p Publication) {
+	if c.Spool == nil || p.Shortcut || p.Base != 0 {
+		return
+	}
+	seeker, ok := p.Reader.(io.Seeker)
+	if !ok {
+		return
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		log.Warnf("Error spooling report to %s: %v", c.hostname, err)
+		return
+	}
+	buf, err := ioutil.ReadAll(p.Reader)
+	if err == nil {
+		err = c.Spool.Add(p.Timestamp, p.ContentType, p.Checksum, buf)
+	}
+	if err != nil {
+		log.Warnf("Error spooling report to %s: %v", c.hostname, err)
+	}
+}
+
+// startReplay starts publishing the reports spooled, if any, unless they
+// are already being published.
+func (c *appClient) startReplay() {
+	if c.Spool == nil || !c.Spool.startReplay() {
+		return
+	}
+	if !c.retainGoroutine() {
+		c.Spool.stopReplay()
+		return
+	}
+	go func() {
+		defer c.releaseGoroutine()
+		defer c.Spool.stopReplay()
+		c.replay()
+	}()
+}
+
+// replay publishes the reports spooled, oldest first with the time they
+// were made, at most one every spoolReplayInterval and only while no live
+// reports are waiting.  It gives up on the first failure, until the next
+// live report is published.
+func (c *appClient) replay() {
+	log.Infof("Publishing %d spooled report(s) to %s", c.Spool.Len(), c.hostname)
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+		if len(c.readers) > 0 {
+			continue
+		}
+		rpt, ok, err := c.Spool.Oldest()
+		if !ok {
+			return
+		}
+		if err == nil {
+			err = c.publishOnce(Publication{
+				Reader:      bytes.NewReader(rpt.Buf),
+				ContentType: rpt.ContentType,
+				Timestamp:   rpt.Timestamp,
+				Checksum:    rpt.Checksum,
+				backdated:   true,
+			})
+		}
+		switch {
+		case err == nil, os.IsNotExist(err): // Evicted meanwhile
+		case refused(err):
+			// The app won't ever take it, e.g. being too old, or corrupted
+			// on disk
+			log.Warnf("Dropping spooled report to %s: %v", c.hostname, err)
+		default:
+			log.Warnf("Error publishing spooled report to %s: %v", c.hostname, err)
+			return
+		}
+		if err := c.Spool.Remove(rpt); err != nil {
+			log.Warnf("Error removing spooled report: %v", err)
+		}
+	}
+}
+
+// PublishStats is how publishing to an app is getting on.
+type PublishStats struct {
+	Published           int       `json:"published"`
+	Failed              int       `json:"failed"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Dropped             int       `json:"dropped"`
+	Pending             int       `json:"pending"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastPublished       time.Time `json:"lastPublished,omitempty"`
+}
+
+func (c *appClient) recordPublish(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err != nil {
+		c.stats.Failed++
+		c.stats.ConsecutiveFailures++
+		c.stats.LastError = err.Error()
+		return
+	}
+	c.stats.Published++
+	c.stats.ConsecutiveFailures = 0
+	c.stats.LastPublished = time.Now()
+}
+
+// PublishStats returns how publishing to the app is getting on.
+func (c *appClient) PublishStats() PublishStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	stats := c.stats
+	stats.Pending = len(c.readers)
+	return stats
+}
+
+// FullReportRequested returns whether the app has asked for a full report,
+// not having been able to apply a delta, since last called.
+func (c *appClient) FullReportRequested() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	requested := c.fullRequested
+	c.fullRequested = false
+	return requested
+}
+
 // Publish implements Publisher
-func (c *appClient) Publish(r io.Reader, shortcut bool) error {
+func (c *appClient) Publish(p Publication) error {
 	// Lazily start the background publishing loop.
 	c.publishLoop.Do(c.startPublishing)
+	if p.Timestamp.IsZero() {
+		p.Timestamp = time.Now()
+	}
 	// enqueue report
 	select {
-	case c.readers <- r:
+	case c.readers <- p:
 	default:
 		log.Warnf("Dropping report to %s", c.hostname)
-		if shortcut {
+		c.mtx.Lock()
+		c.stats.Dropped++
+		if p.Shortcut {
+			c.mtx.Unlock()
 			return nil
 		}
-		// drop an old report to make way for new one
-		c.mtx.Lock()
-		defer c.mtx.Unlock()
+		// drop an old report to make way for new one, spooling it
+		var (
+			dropped Publication
+			ok      bool
+		)
 		select {
-		case <-c.readers:
+		case dropped, ok = <-c.readers:
 		default:
 		}
-		c.readers <- r
+		c.readers <- p
+		c.mtx.Unlock()
+		if ok {
+			c.spool(dropped)
+		}
 	}
 	return nil
 }
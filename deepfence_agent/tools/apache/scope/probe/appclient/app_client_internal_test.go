@@ -1,12 +1,16 @@
 package appclient
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +19,7 @@ import (
 	"github.com/weaveworks/common/test"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/report"
+	scopetest "github.com/weaveworks/scope/test"
 	"github.com/weaveworks/scope/test/reflect"
 )
 
@@ -102,7 +107,7 @@ func TestAppClientPublish(t *testing.T) {
 	// First few reports might be dropped as the client is spinning up.
 	for i := 0; i < 10; i++ {
 		buf, _ := rpt.WriteBinary()
-		if err := p.Publish(buf, false); err != nil {
+		if err := p.Publish(Publication{Reader: buf, ContentType: xfer.MsgpackContentType}); err != nil {
 			t.Error(err)
 		}
 		time.Sleep(10 * time.Millisecond)
@@ -115,6 +120,203 @@ func TestAppClientPublish(t *testing.T) {
 	}
 }
 
+func TestAppClientFullReportRequested(t *testing.T) {
+	done := make(chan struct{}, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if have := r.Header.Get(xfer.ScopeReportSeqHeader); have != "2" {
+			t.Errorf("want seq 2, have %q", have)
+		}
+		if have := r.Header.Get(xfer.ScopeReportBaseHeader); have != "1" {
+			t.Errorf("want base 1, have %q", have)
+		}
+		w.WriteHeader(http.StatusConflict)
+		done <- struct{}{}
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewAppClient(ProbeConfig{}, u.Host, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if p.FullReportRequested() {
+		t.Error("Expected no full report to have been requested yet")
+	}
+	if err := p.Publish(Publication{Reader: strings.NewReader("delta"), ContentType: xfer.DeltaContentType, Seq: 2, Base: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	scopetest.Poll(t, 100*time.Millisecond, true, func() interface{} {
+		return p.FullReportRequested()
+	})
+	if p.FullReportRequested() {
+		t.Error("Expected the request to have been cleared")
+	}
+}
+
+func TestAppClientPublishRetry(t *testing.T) {
+	bodies := make(chan string, 10)
+	var requests int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		// The app is shutting down the first time
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewAppClient(ProbeConfig{}, u.Host, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if err := p.Publish(Publication{Reader: strings.NewReader("report"), ContentType: xfer.ProtobufContentType}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-bodies:
+			if body != "report" {
+				t.Errorf("want the report published, have %q", body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestAppClientPublishUncompressed(t *testing.T) {
+	type request struct {
+		encoding, checksum, body string
+	}
+	requests := make(chan request, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{r.Header.Get("Content-Encoding"), r.Header.Get(xfer.ScopeReportChecksumHeader), string(body)}
+		// A middlebox corrupts compressed reports
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewAppClient(ProbeConfig{}, u.Host, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	rpt := report.MakeReport()
+	buf, sum, err := rpt.WriteProtobufChecksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped := buf.String()
+	if err := p.Publish(Publication{Reader: bytes.NewReader(buf.Bytes()), ContentType: xfer.ProtobufContentType, Checksum: &sum}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []request{
+		{"gzip", sum.String(), gzipped},
+		{"", sum.String(), ""},
+	} {
+		select {
+		case have := <-requests:
+			if have.encoding != want.encoding || have.checksum != want.checksum {
+				t.Errorf("want encoding %q and checksum %q, have %q and %q", want.encoding, want.checksum, have.encoding, have.checksum)
+			}
+			if want.encoding == "" && report.SumPayload([]byte(have.body)) != sum {
+				t.Errorf("want the report uncompressed, have %q", have.body)
+			} else if want.encoding != "" && have.body != want.body {
+				t.Errorf("want the report compressed, have %q", have.body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	select {
+	case have := <-requests:
+		t.Errorf("want the report published uncompressed only once, have %v", have)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if stats := p.PublishStats(); stats.Published != 1 {
+		t.Errorf("want the report published, have %+v", stats)
+	}
+}
+
+func TestAppClientHandshake(t *testing.T) {
+	offered := map[string]bool{xfer.ProtobufReportsCapability: true, xfer.ReportDeltasCapability: true}
+	for _, tc := range []struct {
+		name       string
+		handler    http.HandlerFunc
+		accepted   map[string]bool
+		handshaken bool
+	}{
+		{
+			// Apps from before handshakes don't have the route
+			name:    "legacy app",
+			handler: http.NotFound,
+		},
+		{
+			name: "new app",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var handshake xfer.Handshake
+				if err := codec.NewDecoder(r.Body, &codec.JsonHandle{}).Decode(&handshake); err != nil {
+					t.Error(err)
+				}
+				if handshake.Version != "1.0" || !reflect.DeepEqual(handshake.Capabilities, offered) {
+					t.Errorf("want version 1.0 and %v offered, have %+v", offered, handshake)
+				}
+				codec.NewEncoder(w, &codec.JsonHandle{}).Encode(xfer.Handshake{
+					Version:      "2.0",
+					Capabilities: map[string]bool{xfer.ReportDeltasCapability: true, "zstd": true},
+				})
+			},
+			accepted:   map[string]bool{xfer.ReportDeltasCapability: true},
+			handshaken: true,
+		},
+	} {
+		s := httptest.NewServer(tc.handler)
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewAppClient(ProbeConfig{ProbeVersion: "1.0"}, u.Host, *u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted, handshaken, err := p.Handshake(offered)
+		if err != nil || handshaken != tc.handshaken || !reflect.DeepEqual(accepted, tc.accepted) {
+			t.Errorf("%s: want %v accepted (%v), have %v (%v): %v", tc.name, tc.accepted, tc.handshaken, accepted, handshaken, err)
+		}
+		p.Stop()
+		s.Close()
+	}
+}
+
 func TestAppClientDetails(t *testing.T) {
 	var (
 		id      = "foobarbaz"
@@ -207,7 +409,7 @@ func TestStop(t *testing.T) {
 			done = true
 		default:
 			buf, _ := rpt.WriteBinary()
-			if err := p.Publish(buf, false); err != nil {
+			if err := p.Publish(Publication{Reader: buf, ContentType: xfer.MsgpackContentType}); err != nil {
 				t.Error(err)
 			}
 			time.Sleep(10 * time.Millisecond)
@@ -220,3 +422,132 @@ func TestStop(t *testing.T) {
 	// Let the server go so that the test can end
 	close(stopHanging)
 }
+
+func TestAppClientSpool(t *testing.T) {
+	defer func(interval time.Duration) { spoolReplayInterval = interval }(spoolReplayInterval)
+	spoolReplayInterval = 10 * time.Millisecond
+
+	type published struct {
+		body, timestamp, checksum string
+	}
+	var up int32
+	bodies := make(chan published, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The app is unreachable to begin with
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- published{string(body), r.Header.Get(xfer.ScopeReportTimestampHeader), r.Header.Get(xfer.ScopeReportChecksumHeader)}
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewAppClient(ProbeConfig{Spool: spool}, u.Host, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	made := time.Now().Add(-time.Minute)
+	checksum := report.SumPayload([]byte("late"))
+	if err := p.Publish(Publication{Reader: strings.NewReader("late"), ContentType: xfer.ProtobufContentType, Timestamp: made, Checksum: &checksum}); err != nil {
+		t.Fatal(err)
+	}
+	scopetest.Poll(t, time.Second, 1, func() interface{} { return spool.Len() })
+
+	// The app is reachable again, taking the live report, then the one
+	// spooled as of when it was made, with its checksum
+	atomic.StoreInt32(&up, 1)
+	if err := p.Publish(Publication{Reader: strings.NewReader("live"), ContentType: xfer.ProtobufContentType}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []published{
+		{"live", "", ""},
+		{"late", made.UTC().Format(time.RFC3339Nano), checksum.String()},
+	} {
+		select {
+		case have := <-bodies:
+			if have != want {
+				t.Errorf("want %v published, have %v", want, have)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	scopetest.Poll(t, time.Second, 0, func() interface{} { return spool.Len() })
+}
+
+func TestAppClientSpoolRefused(t *testing.T) {
+	defer func(interval time.Duration) { spoolReplayInterval = interval }(spoolReplayInterval)
+	spoolReplayInterval = 10 * time.Millisecond
+
+	var tooLarge int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch string(body) {
+		case "too large":
+			atomic.AddInt32(&tooLarge, 1)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		case "too many":
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Spooled while the app was unreachable
+	made := time.Now().Add(-time.Minute)
+	for i, body := range []string{"too large", "too many"} {
+		if err := spool.Add(made.Add(time.Duration(i)*time.Second), xfer.ProtobufContentType, nil, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewAppClient(ProbeConfig{Spool: spool}, u.Host, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// A report refused for good isn't spooled, and those spooled are
+	// dropped once refused for good, but kept when refused for now
+	for _, body := range []string{"too large", "live"} {
+		if err := p.Publish(Publication{Reader: strings.NewReader(body), ContentType: xfer.ProtobufContentType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scopetest.Poll(t, 5*time.Second, 1, func() interface{} { return spool.Len() })
+	if rpt, _, _ := spool.Oldest(); string(rpt.Buf) != "too many" {
+		t.Errorf("Expected the report refused for now to be left spooled, got %q", rpt.Buf)
+	}
+	if have := atomic.LoadInt32(&tooLarge); have != 2 {
+		t.Errorf("Expected the report refused for good to be published twice, live and spooled, got %d", have)
+	}
+}
@@ -0,0 +1,103 @@
+package appclient
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/certifi/gocertifi"
+)
+
+// CACertFile is a PEM file of the certificates of CAs trusted besides the
+// usual ones, read again whenever it changes, so certificates can be
+// rotated without restarting the probe.
+type CACertFile struct {
+	path string
+
+	mtx     sync.Mutex
+	modTime time.Time
+	size    int64
+	pool    *x509.CertPool
+}
+
+// NewCACertFile reads the CA certificates at path.
+func NewCACertFile(path string) (*CACertFile, error) {
+	f := &CACertFile{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.read(info); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *CACertFile) read(info os.FileInfo) error {
+	pem, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	pool, err := gocertifi.CACerts()
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", f.path)
+	}
+	f.pool, f.modTime, f.size = pool, info.ModTime(), info.Size()
+	return nil
+}
+
+// Pool returns the CAs trusted, reading the file again if it has changed.
+// Should that fail, the CAs last read are kept.
+func (f *CACertFile) Pool() *x509.CertPool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Warnf("Error checking CA certificates %s, keeping those read: %v", f.path, err)
+		return f.pool
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.pool
+	}
+	if err := f.read(info); err != nil {
+		log.Warnf("Error reading CA certificates %s, keeping those read before: %v", f.path, err)
+		return f.pool
+	}
+	log.Infof("Read CA certificates %s again", f.path)
+	return f.pool
+}
+
+// verifyPeerCertificate verifies the certificates of the app named
+// serverName against the CAs trusted at the time, in place of the
+// verification done by crypto/tls against fixed ones.
+func (f *CACertFile) verifyPeerCertificate(serverName string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("x509: no certificates presented by %s", serverName)
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{
+			Roots:         f.Pool(),
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
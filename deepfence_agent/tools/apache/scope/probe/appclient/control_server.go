@@ -0,0 +1,82 @@
+package appclient
+
+import (
+	"context"
+	"sync"
+
+	"github.com/weaveworks/scope/common/xfer"
+)
+
+// controlServer serves the control requests of an app over its control
+// connection.  Requests the app gives an ID are cancelled once it asks or
+// their timeout passes, and their progress is sent back if it wants it,
+// if the handler takes them.
+type controlServer struct {
+	client  *appClient
+	codec   *xfer.JSONWebsocketCodec
+	mtx     sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newControlServer(client *appClient, codec *xfer.JSONWebsocketCodec) *controlServer {
+	return &controlServer{
+		client:  client,
+		codec:   codec,
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// Handle handles a control request of the app.
+func (s *controlServer) Handle(req xfer.Request, res *xfer.Response) error {
+	req.AppID = s.client.appID
+	if req.Control == xfer.CancelControl {
+		s.cancel(req.ControlArgs[xfer.RequestIDArg])
+		return nil
+	}
+	handler, ok := s.client.control.(xfer.ControlContextHandler)
+	if !ok || req.ID == "" {
+		s.client.control.Handle(req, res)
+		return nil
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if req.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), req.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	s.mtx.Lock()
+	s.cancels[req.ID] = cancel
+	s.mtx.Unlock()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.cancels, req.ID)
+		s.mtx.Unlock()
+		cancel()
+	}()
+
+	progress := func(xfer.Progress) {}
+	if req.WantProgress {
+		progress = func(p xfer.Progress) {
+			p.RequestID = req.ID
+			if err := s.codec.WriteProgress(p); err != nil {
+				log.Warnf("Error sending progress of control %s: %v", req.Control, err)
+			}
+		}
+	}
+	*res = handler.HandleContext(ctx, req, progress)
+	return nil
+}
+
+// cancel cancels the request of an ID, if it's still being handled.
+func (s *controlServer) cancel(id string) {
+	s.mtx.Lock()
+	cancel, ok := s.cancels[id]
+	s.mtx.Unlock()
+	if ok {
+		cancel()
+	}
+}
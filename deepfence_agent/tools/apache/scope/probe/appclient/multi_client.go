@@ -5,10 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
-
-	log "github.com/sirupsen/logrus"
+	"time"
 
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/report"
@@ -16,6 +16,14 @@ import (
 
 const maxConcurrentGET = 10
 
+// probeCapabilities are those the probe offers apps in handshakes.
+var probeCapabilities = map[string]bool{
+	xfer.ProtobufReportsCapability: true,
+	xfer.ProtobufIDTableCapability: true,
+	xfer.ReportDeltasCapability:    true,
+	xfer.ReportChecksumCapability:  true,
+}
+
 // ClientFactory is a thing thats makes AppClients
 type ClientFactory func(string, url.URL) (AppClient, error)
 
@@ -24,8 +32,18 @@ type multiClient struct {
 
 	mtx        sync.Mutex
 	sema       semaphore
-	clients    map[string]AppClient     // holds map from app id -> client
-	ids        map[string]report.IDList // holds map from hostname -> app ids
+	clients    map[string]AppClient       // holds map from app id -> client
+	ids        map[string]report.IDList   // holds map from hostname -> app ids
+	maxSizes   map[string]int             // holds map from app id -> max report size
+	protobuf   map[string]bool            // holds map from app id -> whether it takes protobuf
+	idTables   map[string]bool            // holds map from app id -> whether it takes protobuf with ID tables
+	deltas     map[string]bool            // holds map from app id -> whether it takes deltas
+	checksums  map[string]bool            // holds map from app id -> whether it checks report checksums
+	handshakes map[string]map[string]bool // holds map from app id -> capabilities it accepted, nil from legacy apps
+	synced     map[string]bool            // holds map from app id -> whether it has report seq, as far as we know
+	disabled   map[string]bool            // holds map from hostname -> whether publishing to it is disabled
+	errs       map[string]string          // holds map from hostname -> why none of its apps could be reached
+	seq        uint64                     // the sequence number of the last report published with PublishDelta
 	quit       chan struct{}
 	noControls bool
 }
@@ -43,6 +61,11 @@ type MultiAppClient interface {
 	PipeClose(appID, pipeID string) error
 	Stop()
 	Publish(r report.Report) error
+	PublishDelta(r report.Report, delta *report.Delta) error
+	MaxReportSize() int
+	SetEnabled(hostname string, enabled bool) error
+	Targets() []TargetStats
+	LastPublished() time.Time
 }
 
 // NewMultiAppClient creates a new MultiAppClient.
@@ -53,16 +76,29 @@ func NewMultiAppClient(clientFactory ClientFactory, noControls bool) MultiAppCli
 		sema:       newSemaphore(maxConcurrentGET),
 		clients:    map[string]AppClient{},
 		ids:        map[string]report.IDList{},
+		maxSizes:   map[string]int{},
+		protobuf:   map[string]bool{},
+		idTables:   map[string]bool{},
+		deltas:     map[string]bool{},
+		checksums:  map[string]bool{},
+		handshakes: map[string]map[string]bool{},
+		synced:     map[string]bool{},
+		disabled:   map[string]bool{},
+		errs:       map[string]string{},
 		quit:       make(chan struct{}),
 		noControls: noControls,
 	}
 }
 
-// Set the list of endpoints for the given hostname.
+// Set the list of endpoints for the given hostname.  Apps no longer
+// referenced are stopped once the lock is released, as stopping waits for
+// their connections, so that an unresponsive app doesn't hold up
+// publishing to the others.
 func (c *multiClient) Set(hostname string, urls []url.URL) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(urls))
 	clients := make(chan clientTuple, len(urls))
+	errs := make(chan error, len(urls))
 	for _, u := range urls {
 		go func(u url.URL) {
 			c.sema.acquire()
@@ -72,14 +108,17 @@ func (c *multiClient) Set(hostname string, urls []url.URL) {
 			client, err := c.clientFactory(hostname, u)
 			if err != nil {
 				log.Errorf("Error creating new app client: %v", err)
+				errs <- err
 				return
 			}
 
 			details, err := client.Details()
 			if err != nil {
-				log.Errorf("Error fetching app details: %v", err)
+				log.Errorf("Error fetching app details: %s", describeConnError(err))
+				errs <- err
 				return
 			}
+			details.Capabilities = c.negotiate(client, details)
 
 			clients <- clientTuple{details, client}
 		}(u)
@@ -87,6 +126,15 @@ func (c *multiClient) Set(hostname string, urls []url.URL) {
 
 	wg.Wait()
 	close(clients)
+	close(errs)
+	for _, client := range c.set(hostname, clients, errs) {
+		client.Stop()
+	}
+}
+
+// set starts the apps of a hostname and replaces its list of app ids,
+// returning the apps no longer referenced from any hostname.
+func (c *multiClient) set(hostname string, clients <-chan clientTuple, errs <-chan error) []AppClient {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
@@ -94,6 +142,11 @@ func (c *multiClient) Set(hostname string, urls []url.URL) {
 	hostIDs := report.MakeIDList()
 	for tuple := range clients {
 		hostIDs = hostIDs.Add(tuple.ID)
+		c.maxSizes[tuple.ID] = tuple.MaxReportSize
+		c.protobuf[tuple.ID] = tuple.Capabilities[xfer.ProtobufReportsCapability]
+		c.idTables[tuple.ID] = tuple.Capabilities[xfer.ProtobufIDTableCapability]
+		c.deltas[tuple.ID] = tuple.Capabilities[xfer.ReportDeltasCapability]
+		c.checksums[tuple.ID] = tuple.Capabilities[xfer.ReportChecksumCapability]
 		if client, ok := c.clients[tuple.ID]; ok {
 			client.ReTarget(tuple.AppClient.Target())
 		} else {
@@ -104,18 +157,66 @@ func (c *multiClient) Set(hostname string, urls []url.URL) {
 		}
 	}
 	c.ids[hostname] = hostIDs
+	delete(c.errs, hostname)
+	if len(hostIDs) == 0 {
+		c.errs[hostname] = "no addresses resolved"
+		for err := range errs {
+			c.errs[hostname] = err.Error()
+		}
+	}
 
 	// Remove apps that are no longer referenced (by id) from any hostname
 	allReferencedIDs := report.MakeIDList()
 	for _, ids := range c.ids {
 		allReferencedIDs = allReferencedIDs.Add(ids...)
 	}
+	var stale []AppClient
 	for id, client := range c.clients {
 		if !allReferencedIDs.Contains(id) {
-			client.Stop()
+			stale = append(stale, client)
 			delete(c.clients, id)
+			delete(c.maxSizes, id)
+			delete(c.protobuf, id)
+			delete(c.idTables, id)
+			delete(c.deltas, id)
+			delete(c.checksums, id)
+			delete(c.handshakes, id)
+			delete(c.synced, id)
 		}
 	}
+	return stale
+}
+
+// negotiate returns the capabilities of an app which the probe goes by:
+// those it accepted in the handshake, or those in its details for legacy
+// apps.  Handshakes are cached per app, and shaken again on reconnecting
+// to apps which the probe failed to publish to meanwhile.
+func (c *multiClient) negotiate(client AppClient, details xfer.Details) map[string]bool {
+	c.mtx.Lock()
+	accepted, ok := c.handshakes[details.ID]
+	if connected, found := c.clients[details.ID]; !found || connected.PublishStats().ConsecutiveFailures > 0 {
+		ok = false
+	}
+	c.mtx.Unlock()
+	if !ok {
+		capabilities, handshaken, err := client.Handshake(probeCapabilities)
+		if err != nil {
+			// Not cached, to shake hands again next time
+			log.Warnf("Error shaking hands with app %s, going by its details: %s", details.ID, describeConnError(err))
+			return details.Capabilities
+		}
+		accepted = nil
+		if handshaken {
+			accepted = capabilities
+		}
+		c.mtx.Lock()
+		c.handshakes[details.ID] = accepted
+		c.mtx.Unlock()
+	}
+	if accepted == nil {
+		return details.Capabilities
+	}
+	return accepted
 }
 
 func (c *multiClient) withClient(appID string, f func(AppClient) error) error {
@@ -141,6 +242,20 @@ func (c *multiClient) PipeClose(appID, pipeID string) error {
 	})
 }
 
+// MaxReportSize is the size of the largest reports all the apps take, as
+// they advertise it; zero means no limit.
+func (c *multiClient) MaxReportSize() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	result := 0
+	for _, size := range c.maxSizes {
+		if size > 0 && (result == 0 || size < result) {
+			result = size
+		}
+	}
+	return result
+}
+
 // Stop the MultiAppClient.
 func (c *multiClient) Stop() {
 	c.mtx.Lock()
@@ -159,16 +274,99 @@ func (c *multiClient) Stop() {
 func (c *multiClient) Publish(r report.Report) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
+	return c.publish(r, nil, false)
+}
+
+// PublishDelta publishes a report as Publish does, but numbered, so that
+// apps taking deltas can patch the next report from it.  To those of them
+// which have the report before, it publishes the delta from that instead,
+// if given.  Apps which ask for a full report, not having been able to
+// apply a delta, get the next one whole.
+func (c *multiClient) PublishDelta(r report.Report, delta *report.Delta) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.seq++
+	return c.publish(r, delta, true)
+}
 
-	buf, err := r.WriteBinary()
-	if err != nil {
-		return err
+func (c *multiClient) publish(r report.Report, delta *report.Delta, sequenced bool) error {
+	// Encoded once for all the apps taking each encoding, as they
+	// advertise it; older apps only take msgpack, and protobuf without
+	// ID tables.  Checksums are summed while encoding, which costs little
+	// next to gzipping, for the apps checking them
+	type encoding struct {
+		contentType string
+		idTable     bool
+	}
+	type checksummed struct {
+		buf *bytes.Buffer
+		sum report.Checksum
+	}
+	encoded := map[encoding]checksummed{}
+	encode := func(e encoding) (checksummed, error) {
+		if result, ok := encoded[e]; ok {
+			return result, nil
+		}
+		var (
+			result checksummed
+			err    error
+		)
+		switch {
+		case e.contentType == xfer.ProtobufContentType && e.idTable:
+			result.buf, result.sum, err = r.WriteProtobufIDTableChecksum()
+		case e.contentType == xfer.ProtobufContentType:
+			result.buf, result.sum, err = r.WriteProtobufChecksum()
+		case e.contentType == xfer.DeltaContentType:
+			result.buf, result.sum, err = delta.WriteBinaryChecksum()
+		default:
+			result.buf, result.sum, err = r.WriteBinaryChecksum()
+		}
+		encoded[e] = result
+		return result, err
 	}
 
+	enabled := report.MakeIDList()
+	for hostname, ids := range c.ids {
+		if !c.disabled[hostname] {
+			enabled = enabled.Add(ids...)
+		}
+	}
 	errs := []string{}
-	for _, c := range c.clients {
-		if err := c.Publish(bytes.NewReader(buf.Bytes()), r.Shortcut); err != nil {
+	for id, client := range c.clients {
+		if !enabled.Contains(id) {
+			// Given the next report whole once enabled again
+			c.synced[id] = false
+			continue
+		}
+		p := Publication{ContentType: xfer.MsgpackContentType, Shortcut: r.Shortcut}
+		if c.protobuf[id] {
+			p.ContentType = xfer.ProtobufContentType
+		}
+		if sequenced && c.deltas[id] {
+			if client.FullReportRequested() {
+				c.synced[id] = false
+			}
+			p.Seq = c.seq
+			if delta != nil && c.synced[id] {
+				p.ContentType, p.Base = xfer.DeltaContentType, c.seq-1
+			}
+			c.synced[id] = true
+		}
+		e := encoding{contentType: p.ContentType}
+		if p.ContentType == xfer.ProtobufContentType {
+			e.idTable = c.idTables[id]
+		}
+		result, err := encode(e)
+		if err != nil {
+			return err
+		}
+		p.Reader = bytes.NewReader(result.buf.Bytes())
+		if c.checksums[id] {
+			p.Checksum = &result.sum
+		}
+		if err := client.Publish(p); err != nil {
 			errs = append(errs, err.Error())
+			c.synced[id] = false
 		}
 	}
 	if len(errs) > 0 {
@@ -177,6 +375,99 @@ func (c *multiClient) Publish(r report.Report) error {
 	return nil
 }
 
+// SetEnabled enables or disables publishing to the apps of a hostname.
+// Apps also reached at other hostnames which are enabled are still
+// published to.
+func (c *multiClient) SetEnabled(hostname string, enabled bool) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.ids[hostname]; !ok {
+		return fmt.Errorf("No such target: %s", hostname)
+	}
+	if enabled {
+		delete(c.disabled, hostname)
+	} else {
+		c.disabled[hostname] = true
+	}
+	return nil
+}
+
+// Target statuses.
+const (
+	TargetOK          = "ok"
+	TargetFailing     = "failing"
+	TargetDisabled    = "disabled"
+	TargetUnreachable = "unreachable"
+)
+
+// TargetStats is how publishing to an app of a target, by the hostname it
+// was given, is getting on.  Targets none of whose apps could be reached
+// have a single TargetUnreachable entry, with the error.
+type TargetStats struct {
+	Hostname string `json:"hostname"`
+	AppID    string `json:"appId,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Status   string `json:"status"`
+	PublishStats
+}
+
+// Targets returns how publishing to each app of each target is getting
+// on, by hostname and app id.
+func (c *multiClient) Targets() []TargetStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	hostnames := make([]string, 0, len(c.ids))
+	for hostname := range c.ids {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	var targets []TargetStats
+	for _, hostname := range hostnames {
+		if len(c.ids[hostname]) == 0 {
+			stats := TargetStats{Hostname: hostname, Status: TargetUnreachable}
+			stats.LastError = c.errs[hostname]
+			targets = append(targets, stats)
+			continue
+		}
+		for _, id := range c.ids[hostname] {
+			client, ok := c.clients[id]
+			if !ok {
+				continue
+			}
+			target := client.Target()
+			stats := TargetStats{
+				Hostname:     hostname,
+				AppID:        id,
+				URL:          target.String(),
+				Status:       TargetOK,
+				PublishStats: client.PublishStats(),
+			}
+			switch {
+			case c.disabled[hostname]:
+				stats.Status = TargetDisabled
+			case stats.ConsecutiveFailures > 0:
+				stats.Status = TargetFailing
+			}
+			targets = append(targets, stats)
+		}
+	}
+	return targets
+}
+
+// LastPublished returns when a report was last published to any app, as
+// opposed to queued for one; zero if none has been.
+func (c *multiClient) LastPublished() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var last time.Time
+	for _, client := range c.clients {
+		if published := client.PublishStats().LastPublished; published.After(last) {
+			last = published
+		}
+	}
+	return last
+}
+
 type semaphore chan struct{}
 
 func newSemaphore(n int) semaphore {
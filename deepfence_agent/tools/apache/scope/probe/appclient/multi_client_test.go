@@ -1,25 +1,57 @@
 package appclient_test
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/appclient"
 	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
 )
 
 type mockClient struct {
-	id      string
-	count   int
-	stopped int
-	publish int
+	id           string
+	capabilities map[string]bool
+	count        int
+	stopped      int
+	publish      int
+	contentType  string
+	body         []byte
+	seq, base    uint64
+	wantsFull    bool
+	checksum     *report.Checksum
+	accepts      map[string]bool // in handshakes; nil for legacy apps
+	handshakes   int
+	failures     int
 }
 
 func (c *mockClient) Details() (xfer.Details, error) {
-	return xfer.Details{ID: c.id}, nil
+	return xfer.Details{ID: c.id, Capabilities: c.capabilities}, nil
+}
+
+func (c *mockClient) Handshake(capabilities map[string]bool) (map[string]bool, bool, error) {
+	c.handshakes++
+	if c.accepts == nil {
+		return nil, false, nil
+	}
+	accepted := map[string]bool{}
+	for capability := range capabilities {
+		if c.accepts[capability] {
+			accepted[capability] = true
+		}
+	}
+	return accepted, true, nil
 }
 
 func (c *mockClient) ControlConnection() {
@@ -37,11 +69,30 @@ func (c *mockClient) Stop() {
 	c.stopped++
 }
 
-func (c *mockClient) Publish(io.Reader, bool) error {
+func (c *mockClient) Publish(p appclient.Publication) error {
 	c.publish++
+	c.contentType = p.ContentType
+	c.seq, c.base = p.Seq, p.Base
+	c.checksum = p.Checksum
+	if p.Reader != nil {
+		var err error
+		if c.body, err = ioutil.ReadAll(p.Reader); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (c *mockClient) FullReportRequested() bool {
+	wantsFull := c.wantsFull
+	c.wantsFull = false
+	return wantsFull
+}
+
+func (c *mockClient) PublishStats() appclient.PublishStats {
+	return appclient.PublishStats{Published: c.publish, ConsecutiveFailures: c.failures}
+}
+
 func (c *mockClient) PipeConnection(_ string, _ xfer.Pipe) {}
 func (c *mockClient) PipeClose(_ string) error             { return nil }
 
@@ -115,3 +166,317 @@ func TestMultiClientPublish(t *testing.T) {
 		}
 	}
 }
+
+func TestMultiClientPublishNegotiation(t *testing.T) {
+	var (
+		oldApp  = &mockClient{id: "old"}
+		newApp  = &mockClient{id: "new", capabilities: map[string]bool{xfer.ProtobufReportsCapability: true}}
+		idApp   = &mockClient{id: "ids", capabilities: map[string]bool{xfer.ProtobufReportsCapability: true, xfer.ProtobufIDTableCapability: true}}
+		factory = func(hostname string, url url.URL) (appclient.AppClient, error) {
+			switch url.Host {
+			case "new":
+				return newApp, nil
+			case "ids":
+				return idApp, nil
+			}
+			return oldApp, nil
+		}
+	)
+	mp := appclient.NewMultiAppClient(factory, false)
+	defer mp.Stop()
+	mp.Set("a", []url.URL{{Host: "old"}, {Host: "new"}, {Host: "ids"}})
+
+	rpt := report.MakeReport()
+	for _, id := range []string{"a", "b", "c"} {
+		rpt.Endpoint.AddNode(report.MakeNode(report.MakeEndpointNodeID("host", "", "10.0.0."+id, "80")).
+			WithAdjacent(report.MakeEndpointNodeID("host", "", "10.0.0.1", "443")))
+	}
+	if err := mp.Publish(rpt); err != nil {
+		t.Fatal(err)
+	}
+	if oldApp.contentType != xfer.MsgpackContentType {
+		t.Errorf("Expected msgpack for the old app, got %q", oldApp.contentType)
+	}
+	if newApp.contentType != xfer.ProtobufContentType {
+		t.Errorf("Expected protobuf for the new app, got %q", newApp.contentType)
+	}
+	if idApp.contentType != xfer.ProtobufContentType {
+		t.Errorf("Expected protobuf for the app taking ID tables, got %q", idApp.contentType)
+	}
+
+	// Only the app taking them gets the report with an ID table, which
+	// reads the same
+	if bytes.Equal(newApp.body, idApp.body) {
+		t.Error("Expected the app taking ID tables to get a different encoding")
+	}
+	for _, c := range []*mockClient{newApp, idApp} {
+		have, err := report.MakeFromBinary(context.Background(), bytes.NewReader(c.body), true, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rpt.Endpoint.Nodes, have.Endpoint.Nodes) {
+			t.Errorf("%s: expected %v, got %v", c.id, rpt.Endpoint.Nodes, have.Endpoint.Nodes)
+		}
+	}
+}
+
+func TestMultiClientHandshake(t *testing.T) {
+	all := map[string]bool{
+		xfer.ProtobufReportsCapability: true,
+		xfer.ProtobufIDTableCapability: true,
+		xfer.ReportDeltasCapability:    true,
+		xfer.ReportChecksumCapability:  true,
+	}
+	var (
+		// Apps from before handshakes, going by what they advertise
+		legacyApp = &mockClient{id: "legacy", capabilities: map[string]bool{xfer.ProtobufReportsCapability: true}}
+		// Apps shaking hands, taking less than they advertise to older
+		// probes, and what the probe can't do
+		newApp = &mockClient{id: "new", capabilities: all, accepts: map[string]bool{
+			xfer.ReportChecksumCapability: true,
+			"zstd":                        true,
+		}}
+		factory = func(hostname string, url url.URL) (appclient.AppClient, error) {
+			if url.Host == "new" {
+				return newApp, nil
+			}
+			return legacyApp, nil
+		}
+	)
+	mp := appclient.NewMultiAppClient(factory, false)
+	defer mp.Stop()
+	mp.Set("a", []url.URL{{Host: "legacy"}, {Host: "new"}})
+
+	rpt := report.MakeReport()
+	if err := mp.PublishDelta(rpt, nil); err != nil {
+		t.Fatal(err)
+	}
+	if legacyApp.contentType != xfer.ProtobufContentType || legacyApp.checksum != nil {
+		t.Errorf("Expected the legacy app to get protobuf without a checksum, got %q %v", legacyApp.contentType, legacyApp.checksum)
+	}
+	if newApp.contentType != xfer.MsgpackContentType || newApp.seq != 0 || newApp.checksum == nil {
+		t.Errorf("Expected the new app to get msgpack, unnumbered, with a checksum, got %q %d %v", newApp.contentType, newApp.seq, newApp.checksum)
+	}
+
+	// Handshakes are cached, until publishing to the app fails
+	mp.Set("a", []url.URL{{Host: "legacy"}, {Host: "new"}})
+	if legacyApp.handshakes != 1 || newApp.handshakes != 1 {
+		t.Errorf("Expected a handshake per app, got %d and %d", legacyApp.handshakes, newApp.handshakes)
+	}
+	newApp.failures = 1
+	newApp.accepts = all
+	mp.Set("a", []url.URL{{Host: "legacy"}, {Host: "new"}})
+	if legacyApp.handshakes != 1 || newApp.handshakes != 2 {
+		t.Errorf("Expected another handshake with the app reconnected to, got %d and %d", legacyApp.handshakes, newApp.handshakes)
+	}
+	if err := mp.PublishDelta(rpt, nil); err != nil {
+		t.Fatal(err)
+	}
+	if newApp.contentType != xfer.ProtobufContentType || newApp.seq == 0 {
+		t.Errorf("Expected the new app to get numbered protobuf, got %q %d", newApp.contentType, newApp.seq)
+	}
+}
+
+func TestMultiClientPublishDelta(t *testing.T) {
+	var (
+		oldApp   = &mockClient{id: "old"}
+		deltaApp = &mockClient{id: "delta", capabilities: map[string]bool{xfer.ReportDeltasCapability: true}}
+		lateApp  = &mockClient{id: "late", capabilities: map[string]bool{xfer.ReportDeltasCapability: true}}
+		factory  = func(hostname string, url url.URL) (appclient.AppClient, error) {
+			switch url.Host {
+			case "delta":
+				return deltaApp, nil
+			case "late":
+				return lateApp, nil
+			}
+			return oldApp, nil
+		}
+		rpt   = report.MakeReport()
+		delta = rpt.Diff(rpt)
+	)
+	mp := appclient.NewMultiAppClient(factory, false)
+	defer mp.Stop()
+	mp.Set("a", []url.URL{{Host: "old"}, {Host: "delta"}})
+
+	expect := func(c *mockClient, contentType string, seq, base uint64) {
+		_, file, line, _ := runtime.Caller(1)
+		if c.contentType != contentType || c.seq != seq || c.base != base {
+			t.Errorf("%s:%d: %s: expected %s %d from %d, got %s %d from %d", file, line, c.id, contentType, seq, base, c.contentType, c.seq, c.base)
+		}
+	}
+
+	// The first report is whole, as there's nothing to diff it from
+	if err := mp.PublishDelta(rpt, nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(oldApp, xfer.MsgpackContentType, 0, 0)
+	expect(deltaApp, xfer.MsgpackContentType, 1, 0)
+
+	if err := mp.PublishDelta(rpt, &delta); err != nil {
+		t.Fatal(err)
+	}
+	expect(oldApp, xfer.MsgpackContentType, 0, 0)
+	expect(deltaApp, xfer.DeltaContentType, 2, 1)
+
+	// An app which couldn't apply a delta gets the next report whole, and
+	// deltas from it after
+	deltaApp.wantsFull = true
+	if err := mp.PublishDelta(rpt, &delta); err != nil {
+		t.Fatal(err)
+	}
+	expect(deltaApp, xfer.MsgpackContentType, 3, 0)
+	if err := mp.PublishDelta(rpt, &delta); err != nil {
+		t.Fatal(err)
+	}
+	expect(deltaApp, xfer.DeltaContentType, 4, 3)
+
+	// Shortcut reports aren't numbered
+	if err := mp.Publish(rpt); err != nil {
+		t.Fatal(err)
+	}
+	expect(deltaApp, xfer.MsgpackContentType, 0, 0)
+
+	// Nor do apps which join get deltas from reports they haven't had
+	mp.Set("b", []url.URL{{Host: "late"}})
+	if err := mp.PublishDelta(rpt, &delta); err != nil {
+		t.Fatal(err)
+	}
+	expect(deltaApp, xfer.DeltaContentType, 5, 4)
+	expect(lateApp, xfer.MsgpackContentType, 5, 0)
+	if err := mp.PublishDelta(rpt, &delta); err != nil {
+		t.Fatal(err)
+	}
+	expect(lateApp, xfer.DeltaContentType, 6, 5)
+}
+
+// appServer serves an app of the given id, counting the reports published
+// to it, until they're blackholed.
+type appServer struct {
+	*httptest.Server
+	reports   chan struct{}
+	blackhole chan struct{}
+}
+
+func newAppServer(id string, blackhole bool) *appServer {
+	s := &appServer{reports: make(chan struct{}, 10), blackhole: make(chan struct{})}
+	if !blackhole {
+		close(s.blackhole)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/topology-api":
+			fmt.Fprintf(w, `{"id": %q}`, id)
+		case "/topology-api/report":
+			select {
+			case <-s.blackhole:
+			case <-r.Context().Done():
+				return
+			}
+			s.reports <- struct{}{}
+		}
+	}))
+	return s
+}
+
+func (s *appServer) url(t *testing.T) url.URL {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *u
+}
+
+func TestMultiClientPublishesPastDeadTargets(t *testing.T) {
+	healthy := newAppServer("healthy", false)
+	defer healthy.Close()
+	dead := newAppServer("dead", true)
+	defer dead.Close()
+	defer close(dead.blackhole)
+	// Accepts connections, and never responds
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blackhole.Close()
+
+	mp := appclient.NewMultiAppClient(func(hostname string, url url.URL) (appclient.AppClient, error) {
+		return appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "probe"}, hostname, url, nil)
+	}, true)
+	defer mp.Stop()
+	mp.Set("healthy", []url.URL{healthy.url(t)})
+	mp.Set("dead", []url.URL{dead.url(t)})
+	go mp.Set("blackhole", []url.URL{{Scheme: "http", Host: blackhole.Addr().String()}})
+
+	published := func() bool {
+		select {
+		case <-healthy.reports:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	rpt := report.MakeReport()
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		if err := mp.Publish(rpt); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("publishing took %v", elapsed)
+		}
+		if !published() {
+			t.Fatalf("report %d not published to the healthy target", i)
+		}
+	}
+
+	stats := func() map[string]appclient.TargetStats {
+		byHostname := map[string]appclient.TargetStats{}
+		for _, target := range mp.Targets() {
+			byHostname[target.Hostname] = target
+		}
+		return byHostname
+	}
+	// Recorded once the app responds
+	test.Poll(t, time.Second, 5, func() interface{} { return stats()["healthy"].Published })
+	if s := stats()["healthy"]; s.Status != appclient.TargetOK || s.AppID != "healthy" {
+		t.Errorf("unexpected healthy target: %+v", s)
+	}
+	if s := stats()["dead"]; s.Published != 0 || s.Dropped == 0 {
+		t.Errorf("unexpected dead target: %+v", s)
+	}
+
+	// Disabled targets aren't published to
+	if err := mp.SetEnabled("healthy", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Publish(rpt); err != nil {
+		t.Fatal(err)
+	}
+	if published() {
+		t.Error("report published to a disabled target")
+	}
+	if s := stats()["healthy"]; s.Status != appclient.TargetDisabled {
+		t.Errorf("unexpected disabled target: %+v", s)
+	}
+	if err := mp.SetEnabled("healthy", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Publish(rpt); err != nil {
+		t.Fatal(err)
+	}
+	if !published() {
+		t.Error("report not published to a target enabled again")
+	}
+	if err := mp.SetEnabled("nonexistent", false); err == nil {
+		t.Error("expected an error disabling a nonexistent target")
+	}
+
+	// Removing the dead target doesn't hold up publishing either
+	mp.Set("dead", nil)
+	if err := mp.Publish(rpt); err != nil {
+		t.Fatal(err)
+	}
+	if !published() {
+		t.Error("report not published after removing the dead target")
+	}
+}
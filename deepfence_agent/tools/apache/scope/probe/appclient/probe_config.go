@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/certifi/gocertifi"
@@ -36,6 +38,18 @@ type ProbeConfig struct {
 	ProbeVersion string
 	ProbeID      string
 	Insecure     bool
+
+	// Proxy is the proxy to publish reports and connect to the app
+	// through, overriding those of the environment (HTTPS_PROXY,
+	// NO_PROXY); nil to use those.
+	Proxy *url.URL
+
+	// CACerts are the CAs trusted besides the usual ones; nil for none.
+	CACerts *CACertFile
+
+	// Spool keeps the reports which couldn't be published, to publish
+	// them late; nil to drop them.
+	Spool *Spool
 }
 
 func (pc ProbeConfig) authorizeHeaders(headers http.Header) {
@@ -63,9 +77,19 @@ func (pc ProbeConfig) getHTTPTransport(hostname string) *http.Transport {
 		Timeout:   dialTimeout,
 		KeepAlive: 30 * time.Second,
 	}).DialContext
-	if pc.Insecure {
+	transport.Proxy = pc.proxy()
+	switch {
+	case pc.Insecure:
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	} else {
+	case pc.CACerts != nil:
+		// Verified against the CAs of the moment, rather than those
+		// at the time the transport was made
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: pc.CACerts.verifyPeerCertificate(hostname),
+			ServerName:            hostname,
+		}
+	default:
 		transport.TLSClientConfig = &tls.Config{
 			RootCAs:    certPool,
 			ServerName: hostname,
@@ -73,3 +97,25 @@ func (pc ProbeConfig) getHTTPTransport(hostname string) *http.Transport {
 	}
 	return transport
 }
+
+func (pc ProbeConfig) proxy() func(*http.Request) (*url.URL, error) {
+	if pc.Proxy != nil {
+		return http.ProxyURL(pc.Proxy)
+	}
+	return http.ProxyFromEnvironment
+}
+
+// describeConnError tells failures to get through a proxy, and to trust
+// the app, from others in the log.
+func describeConnError(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, http.StatusText(http.StatusProxyAuthRequired)):
+		return "proxy authentication failed: " + msg
+	case strings.Contains(msg, "proxyconnect"):
+		return "proxy connection failed: " + msg
+	case strings.Contains(msg, "x509:") || strings.Contains(msg, "tls:"):
+		return "TLS handshake failed: " + msg
+	}
+	return msg
+}
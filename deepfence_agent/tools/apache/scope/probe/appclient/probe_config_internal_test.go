@@ -0,0 +1,201 @@
+package appclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/scope/common/xfer"
+)
+
+// appServer is an app serving its details and websockets over TLS.
+func appServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topology-api", func(w http.ResponseWriter, r *http.Request) {
+		codec.NewEncoder(w, &codec.JsonHandle{}).Encode(xfer.Details{ID: "app"})
+	})
+	mux.HandleFunc("/topology-api/control/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := xfer.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	return httptest.NewTLSServer(mux)
+}
+
+func writeCert(t *testing.T, path string, cert *x509.Certificate) {
+	buf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// otherCA makes the certificate of a CA other than that of test servers.
+func otherCA(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCACertFileReload(t *testing.T) {
+	s := appServer(t)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	writeCert(t, path, otherCA(t))
+	caCerts, err := NewCACertFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewAppClient(ProbeConfig{CACerts: caCerts}, u.Hostname(), *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	_, err = c.Details()
+	if err == nil || !strings.HasPrefix(describeConnError(err), "TLS handshake failed") {
+		t.Fatalf("Expected the app not to be trusted, got %v", err)
+	}
+
+	// The CA certificates are rotated
+	writeCert(t, path, s.Certificate())
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if details, err := c.Details(); err != nil || details.ID != "app" {
+		t.Fatalf("Expected the app to be trusted, got %v %v", details, err)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	s := appServer(t)
+	defer s.Close()
+
+	// The proxy tunnels to the app, given the right credentials
+	credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("probe:secret"))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != credentials {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	writeCert(t, path, s.Certificate())
+	caCerts, err := NewCACertFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := func(password string) *appClient {
+		proxyURL, err := url.Parse(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxyURL.User = url.UserPassword("probe", password)
+		c, err := NewAppClient(ProbeConfig{Proxy: proxyURL, CACerts: caCerts}, u.Hostname(), *u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.(*appClient)
+	}
+
+	c := client("wrong")
+	defer c.Stop()
+	if _, err := c.Details(); err == nil || !strings.HasPrefix(describeConnError(err), "proxy authentication failed") {
+		t.Errorf("Expected the proxy to refuse the report publisher, got %v", err)
+	}
+	if _, _, err := xfer.DialWS(&c.wsDialer, c.wsURL("/topology-api/control/ws"), http.Header{}); err == nil || !strings.HasPrefix(describeConnError(err), "proxy authentication failed") {
+		t.Errorf("Expected the proxy to refuse the websocket, got %v", err)
+	}
+
+	c = client("secret")
+	defer c.Stop()
+	if details, err := c.Details(); err != nil || details.ID != "app" {
+		t.Errorf("Expected the app details through the proxy, got %v %v", details, err)
+	}
+	conn, _, err := xfer.DialWS(&c.wsDialer, c.wsURL("/topology-api/control/ws"), http.Header{})
+	if err != nil {
+		t.Fatalf("Expected a websocket through the proxy, got %v", err)
+	}
+	conn.Close()
+}
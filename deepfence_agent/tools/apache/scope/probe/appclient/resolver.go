@@ -5,10 +5,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/weaveworks/scope/common/xfer"
 )
@@ -186,12 +186,20 @@ func ParseTargets(urls []string) ([]Target, error) {
 	return targets, nil
 }
 
+// resolve resolves the targets, and sets them at once, so that a target
+// whose apps don't respond doesn't hold up setting the others.
 func (r staticResolver) resolve() {
+	var wg sync.WaitGroup
 	for _, t := range r.Targets {
 		ips := r.resolveOne(t)
 		urls := makeURLs(t, ips)
-		r.Set(t.hostname, urls)
+		wg.Add(1)
+		go func(hostname string, urls []url.URL) {
+			defer wg.Done()
+			r.Set(hostname, urls)
+		}(t.hostname, urls)
 	}
+	wg.Wait()
 }
 
 func makeURLs(t Target, ips []string) []url.URL {
@@ -199,6 +199,44 @@ func TestResolver(t *testing.T) {
 	}
 }
 
+func TestResolverSetsTargetsIndependently(t *testing.T) {
+	c := make(chan time.Time)
+	ticker := func(_ time.Duration) <-chan time.Time { return c }
+
+	targets, err := ParseTargets([]string{"10.0.0.1", "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setting the first target hangs, as for apps which don't respond
+	hung := make(chan struct{})
+	defer close(hung)
+	sets := make(chan string, 1)
+	r, err := NewResolver(ResolverConfig{
+		Targets: targets,
+		Set: func(hostname string, _ []url.URL) {
+			if hostname == "10.0.0.1" {
+				<-hung
+				return
+			}
+			sets <- hostname
+		},
+		Ticker: ticker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	select {
+	case hostname := <-sets:
+		if hostname != "10.0.0.2" {
+			t.Errorf("unexpected target set: %s", hostname)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("didn't set the second target while the first hung")
+	}
+}
+
 func makeIPs(addrs ...string) []net.IP {
 	var ips []net.IP
 	for _, addr := range addrs {
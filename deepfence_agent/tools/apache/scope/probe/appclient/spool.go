@@ -0,0 +1,286 @@
+package appclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// spoolReplayInterval is how often spooled reports are published, once the
+// app is reachable again, alongside the live ones.
+var spoolReplayInterval = 1 * time.Second
+
+const spoolTempSuffix = ".tmp"
+
+// spoolExtensions are the file name extensions of spooled reports, by
+// their content type. Deltas aren't spooled, being of no use late.
+var spoolExtensions = map[string]string{
+	xfer.MsgpackContentType:  ".msgpack.gz",
+	xfer.ProtobufContentType: ".protobuf.gz",
+}
+
+// SpooledReport is a report kept in a Spool, as published: gzipped.
+type SpooledReport struct {
+	Timestamp   time.Time
+	ContentType string
+	// Checksum is that of the report as published, nil if it wasn't.
+	Checksum *report.Checksum
+	Buf      []byte
+	name     string
+}
+
+type spoolFile struct {
+	name      string
+	timestamp time.Time
+	size      int64
+}
+
+// Spool keeps the reports which couldn't be published to the apps of a
+// target on disk, up to a number of bytes past which the oldest are
+// evicted, to publish them late.  Reports are kept in files named by the
+// nanoseconds since epoch they were made at, as NewFileCollector reads
+// them, and their checksum, if any, for the app to tell them corrupted on
+// disk.  They're written whole before being renamed into place, so the
+// spool survives the probe being restarted, or crashing.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mtx       sync.Mutex
+	files     []spoolFile // oldest first
+	bytes     int64
+	replaying bool
+}
+
+// NewSpool opens the spool in dir, making it if need be, and picks up the
+// reports left there.
+func NewSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(info.Name(), spoolTempSuffix) {
+			// Left half-written
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		timestamp, _, _, ok := parseSpoolName(info.Name())
+		if !ok {
+			continue
+		}
+		s.files = append(s.files, spoolFile{name: info.Name(), timestamp: timestamp, size: info.Size()})
+		s.bytes += info.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].timestamp.Before(s.files[j].timestamp) })
+	s.evict()
+	return s, nil
+}
+
+// parseSpoolName parses the name of a spooled report, as
+// "<nanoseconds>[.<checksum>]<extension>".
+func parseSpoolName(name string) (time.Time, string, *report.Checksum, bool) {
+	for contentType, ext := range spoolExtensions {
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(name, ext), ".", 2)
+		nanos, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return time.Time{}, "", nil, false
+		}
+		var checksum *report.Checksum
+		if len(parts) == 2 {
+			sum, err := report.ParseChecksum(parts[1])
+			if err != nil {
+				return time.Time{}, "", nil, false
+			}
+			checksum = &sum
+		}
+		return time.Unix(0, nanos), contentType, checksum, true
+	}
+	return time.Time{}, "", nil, false
+}
+
+// Add spools a report made at timestamp, with its checksum, if any.
+func (s *Spool) Add(timestamp time.Time, contentType string, checksum *report.Checksum, buf []byte) error {
+	ext, ok := spoolExtensions[contentType]
+	if !ok {
+		return fmt.Errorf("Unsupported Content-Type: %v", contentType)
+	}
+	if int64(len(buf)) > s.maxBytes {
+		return fmt.Errorf("report of %d bytes exceeds spool of %d", len(buf), s.maxBytes)
+	}
+	tmp, err := ioutil.TempFile(s.dir, "report-*"+spoolTempSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// Reports made at the same time are told apart by the nanosecond
+	for s.index(timestamp) >= 0 {
+		timestamp = timestamp.Add(time.Nanosecond)
+	}
+	name := strconv.FormatInt(timestamp.UnixNano(), 10)
+	if checksum != nil {
+		name += "." + checksum.String()
+	}
+	name += ext
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].timestamp.After(timestamp) })
+	s.files = append(s.files[:i], append([]spoolFile{{name: name, timestamp: timestamp, size: int64(len(buf))}}, s.files[i:]...)...)
+	s.bytes += int64(len(buf))
+	s.evict()
+	return nil
+}
+
+func (s *Spool) index(timestamp time.Time) int {
+	for i, f := range s.files {
+		if f.timestamp.Equal(timestamp) {
+			return i
+		}
+	}
+	return -1
+}
+
+// evict removes the oldest reports past maxBytes. Call with mtx held.
+func (s *Spool) evict() {
+	for s.bytes > s.maxBytes && len(s.files) > 0 {
+		f := s.files[0]
+		log.Warnf("Evicting spooled report %s", f.name)
+		if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Error evicting spooled report %s: %v", f.name, err)
+		}
+		s.files = s.files[1:]
+		s.bytes -= f.size
+	}
+}
+
+// Oldest returns the oldest report spooled, if any.
+func (s *Spool) Oldest() (SpooledReport, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.files) == 0 {
+		return SpooledReport{}, false, nil
+	}
+	f := s.files[0]
+	_, contentType, checksum, _ := parseSpoolName(f.name)
+	rpt := SpooledReport{Timestamp: f.timestamp, ContentType: contentType, Checksum: checksum, name: f.name}
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, f.name))
+	if err != nil {
+		return rpt, true, err
+	}
+	rpt.Buf = buf
+	return rpt, true, nil
+}
+
+// Remove removes a report from the spool, once published.
+func (s *Spool) Remove(rpt SpooledReport) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i, f := range s.files {
+		if f.name == rpt.name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			s.bytes -= f.size
+			break
+		}
+	}
+	if err := os.Remove(filepath.Join(s.dir, rpt.name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of reports spooled.
+func (s *Spool) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.files)
+}
+
+// startReplay returns whether the caller is to publish the reports
+// spooled, with none doing so already.
+func (s *Spool) startReplay() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.replaying || len(s.files) == 0 {
+		return false
+	}
+	s.replaying = true
+	return true
+}
+
+func (s *Spool) stopReplay() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.replaying = false
+}
+
+// Spools are the spools of the targets of a probe, each in a directory of
+// its own.  The apps of a target, usually replicas, share its spool.
+type Spools struct {
+	dir      string
+	maxBytes int64
+
+	mtx    sync.Mutex
+	spools map[string]*Spool
+}
+
+// NewSpools makes new Spools in dir, each of maxBytes.
+func NewSpools(dir string, maxBytes int64) *Spools {
+	return &Spools{
+		dir:      dir,
+		maxBytes: maxBytes,
+		spools:   map[string]*Spool{},
+	}
+}
+
+// Open returns the spool of the target hostname.
+func (s *Spools) Open(hostname string) (*Spool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if spool, ok := s.spools[hostname]; ok {
+		return spool, nil
+	}
+	spool, err := NewSpool(filepath.Join(s.dir, url.PathEscape(hostname)), s.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.spools[hostname] = spool
+	return spool, nil
+}
@@ -0,0 +1,116 @@
+package appclient_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/appclient"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	spool, err := appclient.NewSpool(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		ago time.Duration
+		buf string
+	}{
+		{3 * time.Second, "one"},
+		{1 * time.Second, "three"},
+		{2 * time.Second, "two"},
+	} {
+		if err := spool.Add(now.Add(-r.ago), xfer.ProtobufContentType, nil, []byte(r.buf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := spool.Add(now, xfer.DeltaContentType, nil, []byte("delta")); err == nil {
+		t.Error("Expected deltas not to be spooled")
+	}
+	if err := spool.Add(now, xfer.ProtobufContentType, nil, []byte("too big to spool")); err == nil {
+		t.Error("Expected reports bigger than the spool not to be spooled")
+	}
+
+	// The probe restarts, having crashed writing a report
+	if err := ioutil.WriteFile(filepath.Join(dir, "report-1.tmp"), []byte("half"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spool, err = appclient.NewSpool(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oldest report was evicted, past 10 bytes
+	for _, want := range []struct {
+		ago time.Duration
+		buf string
+	}{
+		{2 * time.Second, "two"},
+		{1 * time.Second, "three"},
+	} {
+		rpt, ok, err := spool.Oldest()
+		if err != nil || !ok {
+			t.Fatalf("Expected report %q: %v", want.buf, err)
+		}
+		if string(rpt.Buf) != want.buf || rpt.ContentType != xfer.ProtobufContentType {
+			t.Errorf("Expected report %q, got %q (%s)", want.buf, rpt.Buf, rpt.ContentType)
+		}
+		if !rpt.Timestamp.Equal(now.Add(-want.ago)) {
+			t.Errorf("Expected report %q made at %v, got %v", want.buf, now.Add(-want.ago), rpt.Timestamp)
+		}
+		if err := spool.Remove(rpt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := spool.Oldest(); ok {
+		t.Error("Expected the spool to be empty")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected no files left, got %d", len(files))
+	}
+}
+
+func TestSpoolChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	checksum := report.SumPayload([]byte("report"))
+	spool, err := appclient.NewSpool(dir, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Add(now, xfer.MsgpackContentType, &checksum, []byte("gzipped report")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The checksum is spooled with the report, across restarts
+	spool, err = appclient.NewSpool(dir, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt, ok, err := spool.Oldest()
+	if err != nil || !ok {
+		t.Fatalf("Expected the report spooled: %v", err)
+	}
+	if rpt.Checksum == nil || *rpt.Checksum != checksum {
+		t.Errorf("Expected checksum %v, got %v", checksum, rpt.Checksum)
+	}
+	if !rpt.Timestamp.Equal(now) || string(rpt.Buf) != "gzipped report" {
+		t.Errorf("Expected the report made at %v, got %q made at %v", now, rpt.Buf, rpt.Timestamp)
+	}
+}
@@ -0,0 +1,156 @@
+package appclient
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+// Controls enabling and disabling publishing to the apps of the target
+// of the TargetArg hostname.
+const (
+	EnablePublishTarget  = "host_enable_publish_target"
+	DisablePublishTarget = "host_disable_publish_target"
+	TargetArg            = "target"
+)
+
+// Keys for the publish targets table of the host node.
+const (
+	PublishTargetPrefix    = report.HostPublishTargetPrefix
+	PublishTargetHostname  = "host_publish_target_hostname"
+	PublishTargetApp       = "host_publish_target_app"
+	PublishTargetStatus    = "host_publish_target_status"
+	PublishTargetPublished = "host_publish_target_published"
+	PublishTargetFailed    = "host_publish_target_failed"
+	PublishTargetDropped   = "host_publish_target_dropped"
+	PublishTargetLastError = "host_publish_target_last_error"
+)
+
+// PublishTargetTableTemplates is the table of the publish targets on the
+// host node.
+var PublishTargetTableTemplates = report.TableTemplates{
+	PublishTargetPrefix: {
+		ID:     PublishTargetPrefix,
+		Label:  "Publish targets",
+		Type:   report.MulticolumnTableType,
+		Prefix: PublishTargetPrefix,
+		Columns: []report.Column{
+			{ID: PublishTargetHostname, Label: "Target"},
+			{ID: PublishTargetApp, Label: "App"},
+			{ID: PublishTargetStatus, Label: "Status"},
+			{ID: PublishTargetPublished, Label: "Published", DataType: report.Number},
+			{ID: PublishTargetFailed, Label: "Failed", DataType: report.Number},
+			{ID: PublishTargetDropped, Label: "Dropped", DataType: report.Number},
+			{ID: PublishTargetLastError, Label: "Last error"},
+		},
+	},
+}
+
+var (
+	enablePublishTargetControl = report.Control{
+		ID:    EnablePublishTarget,
+		Human: "Enable publish target",
+		Icon:  "fa fa-play",
+		Rank:  11,
+	}
+	disablePublishTargetControl = report.Control{
+		ID:           DisablePublishTarget,
+		Human:        "Disable publish target",
+		Icon:         "fa fa-pause",
+		Confirmation: "Are you sure you want to stop publishing reports to this target?",
+		Rank:         11,
+	}
+)
+
+// PublishTargets reports how publishing to the targets of a
+// MultiAppClient is getting on, on the host node, and handles the controls
+// enabling and disabling them.
+type PublishTargets struct {
+	hostID          string
+	clients         MultiAppClient
+	handlerRegistry *controls.HandlerRegistry
+}
+
+// NewPublishTargets makes a new PublishTargets, registering its controls.
+func NewPublishTargets(hostID string, clients MultiAppClient, handlerRegistry *controls.HandlerRegistry) *PublishTargets {
+	t := &PublishTargets{
+		hostID:          hostID,
+		clients:         clients,
+		handlerRegistry: handlerRegistry,
+	}
+	handlerRegistry.Batch(nil, map[string]xfer.ControlHandlerFunc{
+		EnablePublishTarget:  t.setEnabled(true),
+		DisablePublishTarget: t.setEnabled(false),
+	})
+	return t
+}
+
+// Stop deregisters the controls.
+func (t *PublishTargets) Stop() {
+	t.handlerRegistry.Batch([]string{EnablePublishTarget, DisablePublishTarget}, nil)
+}
+
+func (t *PublishTargets) setEnabled(enabled bool) xfer.ControlHandlerFunc {
+	return func(req xfer.Request) xfer.Response {
+		hostname, ok := req.ControlArgs[TargetArg]
+		if !ok {
+			return xfer.ResponseErrorf("Missing argument: %s", TargetArg)
+		}
+		return xfer.ResponseError(t.clients.SetEnabled(hostname, enabled))
+	}
+}
+
+// Name of this reporter.
+func (*PublishTargets) Name() string { return "PublishTargets" }
+
+// Report adds the publish targets table to the host node, with the control
+// enabling targets active if any is disabled, and that disabling them if
+// any is enabled.
+func (t *PublishTargets) Report() (report.Report, error) {
+	rpt := report.MakeReport()
+	targets := t.clients.Targets()
+	if len(targets) == 0 {
+		return rpt, nil
+	}
+	var (
+		rows          = make([]report.Row, 0, len(targets))
+		activeControl = map[string]struct{}{}
+	)
+	for _, target := range targets {
+		if target.Status == TargetDisabled {
+			activeControl[EnablePublishTarget] = struct{}{}
+		} else {
+			activeControl[DisablePublishTarget] = struct{}{}
+		}
+		entries := map[string]string{
+			PublishTargetHostname:  target.Hostname,
+			PublishTargetStatus:    target.Status,
+			PublishTargetPublished: strconv.Itoa(target.Published),
+			PublishTargetFailed:    strconv.Itoa(target.Failed),
+			PublishTargetDropped:   strconv.Itoa(target.Dropped),
+		}
+		id := target.Hostname
+		if target.AppID != "" {
+			entries[PublishTargetApp] = target.AppID
+			id += "/" + target.AppID
+		}
+		if target.LastError != "" {
+			entries[PublishTargetLastError] = target.LastError
+		}
+		rows = append(rows, report.Row{ID: id, Entries: entries})
+	}
+	activeControls := make([]string, 0, len(activeControl))
+	for id := range activeControl {
+		activeControls = append(activeControls, id)
+	}
+	sort.Strings(activeControls)
+	rpt.Host.AddNode(report.MakeNode(report.MakeHostNodeID(t.hostID)).
+		AddPrefixMulticolumnTable(PublishTargetPrefix, rows).
+		WithLatestActiveControls(activeControls...))
+	rpt.Host = rpt.Host.WithTableTemplates(PublishTargetTableTemplates)
+	rpt.Host.Controls.AddControls([]report.Control{enablePublishTargetControl, disablePublishTargetControl})
+	return rpt, nil
+}
@@ -0,0 +1,56 @@
+package appclient_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/appclient"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestPublishTargets(t *testing.T) {
+	app := &mockClient{id: "app1", publish: 3}
+	mp := appclient.NewMultiAppClient(func(string, url.URL) (appclient.AppClient, error) { return app, nil }, true)
+	defer mp.Stop()
+	mp.Set("console", []url.URL{{Host: "app1"}})
+
+	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	targets := appclient.NewPublishTargets("host1", mp, handlerRegistry)
+	defer targets.Stop()
+
+	res := handlerRegistry.HandleControlRequest(xfer.Request{
+		Control:     appclient.DisablePublishTarget,
+		ControlArgs: map[string]string{appclient.TargetArg: "console"},
+	})
+	if res.Error != "" {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	res = handlerRegistry.HandleControlRequest(xfer.Request{
+		Control:     appclient.EnablePublishTarget,
+		ControlArgs: map[string]string{appclient.TargetArg: "nonexistent"},
+	})
+	if res.Error != "No such target: nonexistent" {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	rpt, err := targets.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := rpt.Host.Nodes[report.MakeHostNodeID("host1")].ExtractMulticolumnTable(appclient.PublishTargetTableTemplates[appclient.PublishTargetPrefix])
+	if len(rows) != 1 || rows[0].ID != "console/app1" {
+		t.Fatalf("unexpected publish targets table: %v", rows)
+	}
+	if e := rows[0].Entries; e[appclient.PublishTargetStatus] != appclient.TargetDisabled || e[appclient.PublishTargetPublished] != "3" || e[appclient.PublishTargetApp] != "app1" {
+		t.Errorf("unexpected publish target: %v", e)
+	}
+	if _, ok := rpt.Host.Controls[appclient.EnablePublishTarget]; !ok {
+		t.Errorf("expected the %s control, got %v", appclient.EnablePublishTarget, rpt.Host.Controls)
+	}
+	if active := rpt.Host.Nodes[report.MakeHostNodeID("host1")].ActiveControls(); !reflect.DeepEqual(active, []string{appclient.EnablePublishTarget}) {
+		t.Errorf("expected only the %s control active, got %v", appclient.EnablePublishTarget, active)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("awsecs")
+
 // TaskFamily is the key that stores the task family of an ECS Task
 const (
 	Cluster             = report.ECSCluster
@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"compress/gzip"
+	"sort"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// ReportBudget keeps the reports of the probe under a maximum size, by
+// trimming what matters least from them.
+type ReportBudget struct {
+	// MaxSize is the size in bytes of the largest encoded reports; zero
+	// means no limit.
+	MaxSize int
+	// Negotiated returns the size the apps take, which is used when it's
+	// smaller than MaxSize; zero means no limit.
+	Negotiated func() int
+	// MaxProcesses is the number of processes kept, when dropping them.
+	MaxProcesses int
+}
+
+// trimmers trim reports in order, from what matters least to what matters
+// most, returning what they dropped.  Hosts and containers are never
+// trimmed.
+var trimmers = []func(rpt *report.Report, b ReportBudget) (dropped []string){
+	trimEndpointAdjacencies,
+	trimCommandLines,
+	trimProcesses,
+}
+
+func (b ReportBudget) maxSize() int {
+	max := b.MaxSize
+	if b.Negotiated != nil {
+		if negotiated := b.Negotiated(); negotiated > 0 && (max <= 0 || negotiated < max) {
+			max = negotiated
+		}
+	}
+	return max
+}
+
+// encodedSize is the size of the report as it's published.
+func encodedSize(rpt report.Report) (int, error) {
+	buf, err := rpt.WriteBinary()
+	if err != nil {
+		return 0, err
+	}
+	return buf.Len(), nil
+}
+
+// droppedSize estimates how much smaller the encoded report is without
+// what was dropped from it: what it compresses down to on its own.
+func droppedSize(dropped []string) int {
+	var counter byteCounter
+	w := gzip.NewWriter(&counter)
+	for _, s := range dropped {
+		w.Write([]byte(s))
+	}
+	w.Close()
+	return counter.n
+}
+
+// byteCounter is a writer which only counts what is written to it.
+type byteCounter struct {
+	n int
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+// Trim trims the report, in place, until it's under the maximum size or
+// there is nothing left to trim.  What was trimmed is counted in the
+// truncation of the report.  Reports are big, so they are encoded once:
+// their size once trimmed is estimated from what was dropped.
+func (b ReportBudget) Trim(rpt *report.Report) error {
+	max := b.maxSize()
+	if max <= 0 {
+		return nil
+	}
+	size, err := encodedSize(*rpt)
+	if err != nil {
+		return err
+	}
+	for _, trim := range trimmers {
+		if size <= max {
+			return nil
+		}
+		size -= droppedSize(trim(rpt, b))
+	}
+	if size > max {
+		log.Warnf("Report is still about %d bytes once trimmed, over the maximum of %d", size, max)
+	}
+	return nil
+}
+
+// trimEndpointAdjacencies drops the connections of endpoints.
+func trimEndpointAdjacencies(rpt *report.Report, _ ReportBudget) (dropped []string) {
+	for id, node := range rpt.Endpoint.Nodes {
+		if len(node.Adjacency) == 0 {
+			continue
+		}
+		rpt.Truncation.EndpointAdjacencies += len(node.Adjacency)
+		dropped = append(dropped, node.Adjacency...)
+		node.Adjacency = report.MakeIDList()
+		rpt.Endpoint.Nodes[id] = node
+	}
+	return dropped
+}
+
+// trimCommandLines strips the arguments off the command lines of processes.
+func trimCommandLines(rpt *report.Report, _ ReportBudget) (dropped []string) {
+	for id, node := range rpt.Process.Nodes {
+		cmdline, ts, ok := node.Latest.LookupEntry(report.Cmdline)
+		if !ok {
+			continue
+		}
+		if stripped := report.StripCommandArgs(cmdline); stripped != cmdline {
+			rpt.Truncation.CommandLines++
+			rpt.Process.Nodes[id] = node.WithLatest(report.Cmdline, ts, stripped)
+			dropped = append(dropped, cmdline[len(stripped):])
+		}
+	}
+	return dropped
+}
+
+// trimProcesses drops the processes past the maximum number of them.  The
+// processes kept are the first by ID, so the same ones are kept from one
+// report to the next.
+func trimProcesses(rpt *report.Report, b ReportBudget) (dropped []string) {
+	if b.MaxProcesses <= 0 || len(rpt.Process.Nodes) <= b.MaxProcesses {
+		return nil
+	}
+	ids := make([]string, 0, len(rpt.Process.Nodes))
+	for id := range rpt.Process.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids[b.MaxProcesses:] {
+		dropped = append(dropped, id)
+		rpt.Process.Nodes[id].Latest.ForEach(func(k string, _ time.Time, v string) {
+			dropped = append(dropped, k, v)
+		})
+		delete(rpt.Process.Nodes, id)
+		rpt.Truncation.Processes++
+	}
+	return dropped
+}
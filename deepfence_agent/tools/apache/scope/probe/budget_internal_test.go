@@ -0,0 +1,164 @@
+package probe
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// makeBigReport makes the report of a host with n processes, each with a
+// long command line and connected to a server of its own.  The arguments
+// and addresses are random, so they don't compress away.
+func makeBigReport(n int) report.Report {
+	random := rand.New(rand.NewSource(42))
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNodeWith(report.MakeHostNodeID("host1"), map[string]string{report.HostName: "host1"}))
+	rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID("abc"), map[string]string{report.DockerContainerName: "nginx"}))
+	for i := 0; i < n; i++ {
+		pid := fmt.Sprint(1000 + i)
+		rpt.Process.AddNode(report.MakeNodeWith(report.MakeProcessNodeID("host1", pid), map[string]string{
+			report.PID:     pid,
+			report.Cmdline: fmt.Sprintf("/usr/bin/worker --id %d --token %016x%016x", i, random.Uint64(), random.Uint64()),
+		}))
+		server := report.MakeEndpointNodeID("", "", fmt.Sprintf("10.%d.%d.%d", random.Intn(256), random.Intn(256), random.Intn(256)), "443")
+		client := report.MakeEndpointNodeID("host1", "", "10.0.0.1", fmt.Sprint(30000+i))
+		rpt.Endpoint.AddNode(report.MakeNode(server))
+		rpt.Endpoint.AddNode(report.MakeNodeWith(client, map[string]string{report.PID: pid}).WithAdjacent(server))
+	}
+	return rpt
+}
+
+func mustEncodedSize(t *testing.T, rpt report.Report) int {
+	size, err := encodedSize(rpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestEncodedSize(t *testing.T) {
+	rpt := makeBigReport(100)
+	buf, err := rpt.WriteBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reports encode their maps in no particular order, which compresses
+	// slightly differently each time
+	size := mustEncodedSize(t, rpt)
+	if diff := size - buf.Len(); diff < -buf.Len()/100 || diff > buf.Len()/100 {
+		t.Errorf("Expected the size of the published report, %d, got %d", buf.Len(), size)
+	}
+
+	// Trimming makes the report smaller
+	for _, trim := range trimmers {
+		trim(&rpt, ReportBudget{MaxProcesses: 10})
+	}
+	if trimmed := mustEncodedSize(t, rpt); trimmed >= size {
+		t.Errorf("Expected the trimmed report to be smaller than %d bytes, got %d", size, trimmed)
+	}
+}
+
+func TestDroppedSize(t *testing.T) {
+	rpt := makeBigReport(100)
+	size := mustEncodedSize(t, rpt)
+	estimate := size - droppedSize(trimEndpointAdjacencies(&rpt, ReportBudget{}))
+	// Within a tenth of the size, as what's dropped compresses a little
+	// differently on its own
+	size = mustEncodedSize(t, rpt)
+	if diff := estimate - size; diff < -size/10 || diff > size/10 {
+		t.Errorf("Expected an estimate close to %d bytes, got %d", size, estimate)
+	}
+}
+
+func TestReportBudgetStages(t *testing.T) {
+	// With some slack, as the sizes vary by a few bytes
+	const slack = 100
+	full := makeBigReport(100)
+	fullSize := mustEncodedSize(t, full)
+	adjacencies := full.Copy()
+	trimEndpointAdjacencies(&adjacencies, ReportBudget{})
+	adjacenciesSize := mustEncodedSize(t, adjacencies)
+
+	for _, tc := range []struct {
+		name    string
+		maxSize int
+		want    report.Truncation
+	}{
+		{"under", fullSize + slack, report.Truncation{}},
+		{"adjacencies", adjacenciesSize + slack, report.Truncation{EndpointAdjacencies: 100}},
+		{"processes", 1, report.Truncation{EndpointAdjacencies: 100, CommandLines: 100, Processes: 90}},
+	} {
+		rpt := full.Copy()
+		b := ReportBudget{MaxSize: tc.maxSize, MaxProcesses: 10}
+		if err := b.Trim(&rpt); err != nil {
+			t.Fatal(err)
+		}
+		if rpt.Truncation != tc.want {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.want, rpt.Truncation)
+		}
+		if len(rpt.Host.Nodes) != 1 || len(rpt.Container.Nodes) != 1 {
+			t.Errorf("%s: expected hosts and containers to be kept, got %v and %v", tc.name, rpt.Host.Nodes, rpt.Container.Nodes)
+		}
+	}
+}
+
+func TestReportBudgetKeepsTheSameProcesses(t *testing.T) {
+	b := ReportBudget{MaxSize: 1, MaxProcesses: 10}
+	first, second := makeBigReport(100), makeBigReport(100)
+	if err := b.Trim(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Trim(&second); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Process.Nodes) != 10 {
+		t.Fatalf("Expected 10 processes, got %d", len(first.Process.Nodes))
+	}
+	for id, node := range first.Process.Nodes {
+		if _, ok := second.Process.Nodes[id]; !ok {
+			t.Errorf("Expected %s to be kept in both reports", id)
+		}
+		if cmdline, _ := node.Latest.Lookup(report.Cmdline); cmdline != "/usr/bin/worker" {
+			t.Errorf("Expected the command line to be stripped of its arguments, got %q", cmdline)
+		}
+	}
+}
+
+func TestReportBudgetNegotiated(t *testing.T) {
+	for _, tc := range []struct {
+		maxSize, negotiated, want int
+	}{
+		{0, 0, 0},
+		{1000, 0, 1000},
+		{0, 1000, 1000},
+		{1000, 2000, 1000},
+		{2000, 1000, 1000},
+	} {
+		negotiated := tc.negotiated
+		b := ReportBudget{MaxSize: tc.maxSize, Negotiated: func() int { return negotiated }}
+		if have := b.maxSize(); have != tc.want {
+			t.Errorf("%d, %d: expected %d, got %d", tc.maxSize, tc.negotiated, tc.want, have)
+		}
+	}
+}
+
+func TestProbeTrimsReports(t *testing.T) {
+	publisher := mockPublisher{make(chan report.Report, 10)}
+	p := New(10*time.Millisecond, 100*time.Millisecond, publisher, 1, false)
+	p.SetReportBudget(ReportBudget{MaxSize: 1, MaxProcesses: 10})
+	p.AddReporter(mockReporter{makeBigReport(100)})
+	p.Start()
+	defer p.Stop()
+
+	select {
+	case rpt := <-publisher.have:
+		if len(rpt.Process.Nodes) != 10 || !rpt.Truncation.Truncated() {
+			t.Errorf("Expected the report to be trimmed, got %d processes and %+v", len(rpt.Process.Nodes), rpt.Truncation)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	}
+}
@@ -0,0 +1,73 @@
+package probe
+
+import (
+	"hash"
+	"hash/fnv"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// topologyHashes hashes the content of each topology of a report, as far
+// as telling whether anything changed goes: the nodes, with their latest
+// values, sets, adjacencies and parents.  Metrics, which change all the
+// time, are left out, as are the latest values of the volatile keys, like
+// uptimes, and the times all were set.
+func topologyHashes(rpt report.Report, volatile map[string]struct{}) map[string]uint64 {
+	hashes := map[string]uint64{}
+	h := fnv.New64a()
+	rpt.WalkNamedTopologies(func(name string, t *report.Topology) {
+		// Nodes hashed one by one and summed, whatever their order
+		var sum uint64
+		for id, n := range t.Nodes {
+			h.Reset()
+			io.WriteString(h, id)
+			n.Latest.ForEach(func(k string, _ time.Time, v string) {
+				if _, ok := volatile[k]; !ok {
+					writeStrings(h, k, v)
+				}
+			})
+			writeSets(h, n.Sets)
+			writeStrings(h, n.Adjacency...)
+			writeSets(h, n.Parents)
+			sum += h.Sum64()
+		}
+		hashes[name] = sum
+	})
+	return hashes
+}
+
+func writeSets(h hash.Hash64, sets report.Sets) {
+	keys := sets.Keys()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := sets.Lookup(k)
+		writeStrings(h, k)
+		writeStrings(h, v...)
+	}
+}
+
+// writeStrings writes strings to h, each terminated so that their
+// boundaries count.
+func writeStrings(h hash.Hash64, strs ...string) {
+	for _, s := range strs {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+}
+
+// sameHashes returns whether the hashes of the topologies of two reports
+// are the same.
+func sameHashes(a, b map[string]uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, h := range a {
+		if other, ok := b[name]; !ok || other != h {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,17 @@
 package controls
 
 import (
+	"context"
 	"sync"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/common/xfer"
 )
 
+var log = logger.Component("controls")
+
 // HandlerRegistryBackend is an interface for storing control request
 // handlers.
 type HandlerRegistryBackend interface {
@@ -72,6 +78,15 @@ func (b *defaultBackend) Handler(control string) (xfer.ControlHandlerFunc, bool)
 // requests handlers.
 type HandlerRegistry struct {
 	backend HandlerRegistryBackend
+	// Of the handlers of controls which may run for long, guarded by the
+	// backend's lock; they're in the backend as well
+	contextHandlers map[string]xfer.ControlContextHandlerFunc
+	// Of the controls refused whatever the app asks, guarded by the
+	// backend's lock
+	denied map[string]struct{}
+	// Called with the control requests handled, guarded by the backend's
+	// lock
+	watchers []func(xfer.Request)
 }
 
 // NewDefaultHandlerRegistry creates a registry with a default
@@ -83,7 +98,36 @@ func NewDefaultHandlerRegistry() *HandlerRegistry {
 // NewHandlerRegistry creates a registry with a custom backend.
 func NewHandlerRegistry(backend HandlerRegistryBackend) *HandlerRegistry {
 	return &HandlerRegistry{
-		backend: backend,
+		backend:         backend,
+		contextHandlers: map[string]xfer.ControlContextHandlerFunc{},
+		denied:          map[string]struct{}{},
+	}
+}
+
+// Deny refuses requests for the given controls from then on, whether or
+// not they have handlers.
+func (r *HandlerRegistry) Deny(controls ...string) {
+	r.backend.Lock()
+	defer r.backend.Unlock()
+	for _, control := range controls {
+		r.denied[control] = struct{}{}
+	}
+}
+
+// Watch calls f with every control request handled from then on, before
+// its handler.  Requests for controls denied or not recognised aren't.
+func (r *HandlerRegistry) Watch(f func(xfer.Request)) {
+	r.backend.Lock()
+	defer r.backend.Unlock()
+	r.watchers = append(r.watchers, f)
+}
+
+func (r *HandlerRegistry) notify(req xfer.Request) {
+	r.backend.Lock()
+	watchers := r.watchers
+	r.backend.Unlock()
+	for _, f := range watchers {
+		f(req)
 	}
 }
 
@@ -94,11 +138,27 @@ func (r *HandlerRegistry) Register(control string, f xfer.ControlHandlerFunc) {
 	r.backend.Register(control, f)
 }
 
+// RegisterContext registers a new handler of a control which may run for
+// long under a given name.  Its context is done once the app cancels the
+// request or the request's timeout passes, and it may report its progress,
+// which is dropped unless the app wants it.
+func (r *HandlerRegistry) RegisterContext(control string, f xfer.ControlContextHandlerFunc) {
+	r.backend.Lock()
+	defer r.backend.Unlock()
+	r.backend.Register(control, func(req xfer.Request) xfer.Response {
+		var res xfer.Response
+		f.Handle(req, &res)
+		return res
+	})
+	r.contextHandlers[control] = f
+}
+
 // Rm deletes the handler for a given name.
 func (r *HandlerRegistry) Rm(control string) {
 	r.backend.Lock()
 	defer r.backend.Unlock()
 	r.backend.Rm(control)
+	delete(r.contextHandlers, control)
 }
 
 // Batch first deletes handlers for given names in toRemove then
@@ -108,24 +168,72 @@ func (r *HandlerRegistry) Batch(toRemove []string, toAdd map[string]xfer.Control
 	defer r.backend.Unlock()
 	for _, control := range toRemove {
 		r.backend.Rm(control)
+		delete(r.contextHandlers, control)
 	}
 	for control, handler := range toAdd {
 		r.backend.Register(control, handler)
+		delete(r.contextHandlers, control)
 	}
 }
 
 // HandleControlRequest performs a control request.
 func (r *HandlerRegistry) HandleControlRequest(req xfer.Request) xfer.Response {
-	h, ok := r.handler(req.Control)
+	h, ok, denied := r.handler(req.Control)
+	if denied {
+		return deniedResponse(req)
+	}
 	if !ok {
 		return xfer.ResponseErrorf("Control %q not recognised", req.Control)
 	}
 
+	r.notify(req)
 	return h(req)
 }
 
-func (r *HandlerRegistry) handler(control string) (xfer.ControlHandlerFunc, bool) {
+// HandleControlRequestContext performs a control request until it's done
+// or ctx is, passing ctx and progress on to the handler of the control if
+// it takes them.
+func (r *HandlerRegistry) HandleControlRequestContext(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+	r.backend.Lock()
+	f, ok := r.contextHandlers[req.Control]
+	_, denied := r.denied[req.Control]
+	r.backend.Unlock()
+	if denied {
+		return deniedResponse(req)
+	}
+	if !ok {
+		return r.HandleControlRequest(req)
+	}
+	r.notify(req)
+	return f(ctx, req, progress)
+}
+
+// Handle implements xfer.ControlHandler
+func (r *HandlerRegistry) Handle(req xfer.Request, res *xfer.Response) error {
+	*res = r.HandleControlRequest(req)
+	return nil
+}
+
+// HandleContext implements xfer.ControlContextHandler
+func (r *HandlerRegistry) HandleContext(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+	return r.HandleControlRequestContext(ctx, req, progress)
+}
+
+func (r *HandlerRegistry) handler(control string) (xfer.ControlHandlerFunc, bool, bool) {
 	r.backend.Lock()
 	defer r.backend.Unlock()
-	return r.backend.Handler(control)
+	if _, denied := r.denied[control]; denied {
+		return nil, false, true
+	}
+	h, ok := r.backend.Handler(control)
+	return h, ok, false
+}
+
+func deniedResponse(req xfer.Request) xfer.Response {
+	log.WithFields(logrus.Fields{
+		"control": req.Control,
+		"node":    req.NodeID,
+		"app":     req.AppID,
+	}).Warn("Refused denied control")
+	return xfer.ResponseErrorf("Control %q is denied on this probe", req.Control)
 }
@@ -1,6 +1,7 @@
 package controls_test
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -41,3 +42,91 @@ func TestControlsNotFound(t *testing.T) {
 		t.Fatal(test.Diff(want, have))
 	}
 }
+
+func TestControlsContext(t *testing.T) {
+	registry := controls.NewDefaultHandlerRegistry()
+	registry.RegisterContext("foo", func(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+		progress(xfer.Progress{Message: "half way"})
+		return xfer.ResponseError(ctx.Err())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var progress []xfer.Progress
+	want := xfer.Response{
+		Error: context.Canceled.Error(),
+	}
+	have := registry.HandleControlRequestContext(ctx, xfer.Request{Control: "foo"}, func(p xfer.Progress) {
+		progress = append(progress, p)
+	})
+	if !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+	if len(progress) != 1 {
+		t.Errorf("Expected the progress passed on, have %v", progress)
+	}
+
+	// Without a context, it's as any other control
+	if have := registry.HandleControlRequest(xfer.Request{Control: "foo"}); have.Error != "" {
+		t.Errorf("Expected no error, have %q", have.Error)
+	}
+
+	registry.Rm("foo")
+	if have := registry.HandleControlRequestContext(ctx, xfer.Request{Control: "foo"}, nil); have.Error == "" {
+		t.Error("Expected the control removed")
+	}
+}
+
+func TestControlsDenied(t *testing.T) {
+	registry := controls.NewDefaultHandlerRegistry()
+	handled := false
+	registry.Register("foo", func(req xfer.Request) xfer.Response {
+		handled = true
+		return xfer.Response{}
+	})
+	registry.RegisterContext("bar", func(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+		handled = true
+		return xfer.Response{}
+	})
+	registry.Deny("foo", "bar")
+
+	for _, have := range []xfer.Response{
+		registry.HandleControlRequest(xfer.Request{Control: "foo"}),
+		registry.HandleControlRequestContext(context.Background(), xfer.Request{Control: "bar"}, func(xfer.Progress) {}),
+	} {
+		if have.Error == "" {
+			t.Error("Expected the denied control refused")
+		}
+	}
+	if handled {
+		t.Error("Expected the handlers of denied controls not called")
+	}
+}
+
+func TestControlsWatch(t *testing.T) {
+	registry := controls.NewDefaultHandlerRegistry()
+	registry.Register("foo", func(req xfer.Request) xfer.Response {
+		return xfer.Response{}
+	})
+	registry.RegisterContext("bar", func(ctx context.Context, req xfer.Request, progress func(xfer.Progress)) xfer.Response {
+		return xfer.Response{}
+	})
+	registry.Register("denied", func(req xfer.Request) xfer.Response {
+		return xfer.Response{}
+	})
+	registry.Deny("denied")
+	var watched []string
+	registry.Watch(func(req xfer.Request) {
+		watched = append(watched, req.Control)
+	})
+
+	registry.HandleControlRequest(xfer.Request{Control: "foo"})
+	registry.HandleControlRequestContext(context.Background(), xfer.Request{Control: "bar"}, func(xfer.Progress) {})
+	registry.HandleControlRequestContext(context.Background(), xfer.Request{Control: "foo"}, func(xfer.Progress) {})
+	registry.HandleControlRequest(xfer.Request{Control: "denied"})
+	registry.HandleControlRequest(xfer.Request{Control: "baz"})
+
+	if want := []string{"foo", "bar", "foo"}; !reflect.DeepEqual(want, watched) {
+		t.Error(test.Diff(want, watched))
+	}
+}
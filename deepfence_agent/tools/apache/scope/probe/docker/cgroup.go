@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"path"
+	"strings"
+)
+
+// Prefixes and suffixes wrapped around container IDs in cgroup paths by
+// the various container runtimes and cgroup drivers.
+var (
+	cgroupIDPrefixes = []string{"docker-", "cri-containerd-", "crio-", "libpod-"}
+	cgroupIDSuffix   = ".scope"
+)
+
+const containerIDLength = 64
+
+func isContainerID(s string) bool {
+	if len(s) != containerIDLength {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// containerIDFromCgroupPath extracts the container ID from a single cgroup
+// path, e.g.
+//
+//	/docker/<id>                                  (cgroup v1, cgroupfs driver)
+//	/kubepods/besteffort/pod<uid>/<id>            (cgroup v1, kubelet)
+//	/system.slice/docker-<id>.scope               (systemd driver, v1 or v2)
+//	/kubepods.slice/.../cri-containerd-<id>.scope (containerd, systemd driver)
+//	/kubepods.slice/.../crio-<id>.scope           (cri-o, systemd driver)
+//	/system.slice/containerd.service/kubepods-besteffort-pod<uid>.slice:cri-containerd:<id>
+func containerIDFromCgroupPath(cgroupPath string) (string, bool) {
+	name := path.Base(cgroupPath)
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, cgroupIDSuffix)
+	for _, prefix := range cgroupIDPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+	return name, isContainerID(name)
+}
+
+// ContainerIDFromCgroup returns the ID of the container a process belongs
+// to, given the contents of its /proc/<pid>/cgroup.  It handles cgroup v1,
+// the cgroup v2 unified hierarchy and hybrid layouts which have both.
+func ContainerIDFromCgroup(cgroups string) (string, bool) {
+	for _, line := range strings.Split(cgroups, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if id, ok := containerIDFromCgroupPath(fields[2]); ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// processContainerID returns the ID of the container the process pid
+// belongs to, according to its cgroups as read by cgroup.
+func processContainerID(cgroup func(pid int) (string, bool), pid int) (string, bool) {
+	if cgroup == nil {
+		return "", false
+	}
+	cgroups, ok := cgroup(pid)
+	if !ok {
+		return "", false
+	}
+	return ContainerIDFromCgroup(cgroups)
+}
+
+// ProcessInContainer returns a function saying whether a process belongs to
+// a container, according to its cgroups as read by cgroup.
+func ProcessInContainer(cgroup func(pid int) (string, bool)) func(pid int) bool {
+	return func(pid int) bool {
+		_, ok := processContainerID(cgroup, pid)
+		return ok
+	}
+}
@@ -0,0 +1,198 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/weaveworks/common/fs"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Modes of --probe.cgroup-metrics.
+const (
+	CgroupMetricsAuto = "auto"
+	CgroupMetricsV1   = "v1"
+	CgroupMetricsV2   = "v2"
+	CgroupMetricsOff  = "off"
+
+	// DefaultCgroupRoot is where the cgroup hierarchies are mounted.
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+
+	// Memory limits above this are no limit; cgroup v1 says so with the
+	// largest multiple of the page size.
+	noMemoryLimit = 1 << 62
+)
+
+// cgroupUsage is what a container used, as read from its cgroup.
+type cgroupUsage struct {
+	cpu         uint64 // nanoseconds, since the container started
+	memory      uint64 // bytes, less the page cache
+	memoryLimit uint64
+}
+
+// CgroupMetrics reports the CPU and memory of containers straight from
+// their cgroups, rather than asking the container runtime for their stats.
+// Reading a couple of files per container is far cheaper than a stats
+// query per container, on hosts with many of them.
+type CgroupMetrics struct {
+	root    string
+	unified bool // whether it's the unified hierarchy of cgroup v2
+
+	previous     map[string]cgroupUsage
+	previousTime time.Time
+}
+
+// NewCgroupMetrics makes a new CgroupMetrics for the hierarchies mounted
+// at root, or returns nil when mode is off.  In auto mode, the unified
+// hierarchy of cgroup v2 is used when it's mounted at root, and cgroup v1
+// otherwise, including on hybrid hosts.
+func NewCgroupMetrics(mode, root string) (*CgroupMetrics, error) {
+	var stat syscall.Stat_t
+	unified := fs.Stat(path.Join(root, "cgroup.controllers"), &stat) == nil
+	switch mode {
+	case CgroupMetricsOff:
+		return nil, nil
+	case CgroupMetricsAuto:
+	case CgroupMetricsV1:
+		if unified {
+			return nil, fmt.Errorf("cgroup v1 is not mounted at %s", root)
+		}
+	case CgroupMetricsV2:
+		if !unified {
+			return nil, fmt.Errorf("cgroup v2 is not mounted at %s", root)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cgroup metrics mode %q: should be one of %s, %s, %s or %s",
+			mode, CgroupMetricsAuto, CgroupMetricsV1, CgroupMetricsV2, CgroupMetricsOff)
+	}
+	return &CgroupMetrics{
+		root:     root,
+		unified:  unified,
+		previous: map[string]cgroupUsage{},
+	}, nil
+}
+
+// Name of this reporter, for metrics gathering
+func (*CgroupMetrics) Name() string { return "CgroupMetrics" }
+
+// Report implements Reporter.  The CPU of containers is reported from
+// their second report on, as it's relative to the previous one.
+func (c *CgroupMetrics) Report() (report.Report, error) {
+	rpt := report.MakeReport()
+	now := mtime.Now()
+	usages := c.read()
+	// Like the stats of docker, 100% is all the CPUs of the host
+	available := float64(now.Sub(c.previousTime).Nanoseconds()) * float64(runtime.NumCPU())
+	for id, usage := range usages {
+		memory := report.MakeSingletonMetric(now, float64(usage.memory))
+		if usage.memoryLimit > 0 && usage.memoryLimit < noMemoryLimit {
+			memory = memory.WithMax(float64(usage.memoryLimit))
+		}
+		metrics := report.Metrics{MemoryUsage: memory}
+		// The counter of a restarted container starts again
+		if prev, ok := c.previous[id]; ok && available > 0 && usage.cpu >= prev.cpu {
+			percent := float64(usage.cpu-prev.cpu) / available * 100
+			metrics[CPUTotalUsage] = report.MakeSingletonMetric(now, percent).WithMax(100)
+		}
+		rpt.Container.AddNode(report.MakeNode(report.MakeContainerNodeID(id)).WithMetrics(metrics))
+	}
+	c.previous, c.previousTime = usages, now
+	rpt.Container = rpt.Container.WithMetricTemplates(ContainerMetricTemplates)
+	return rpt, nil
+}
+
+// read returns the usage of the containers, by ID.
+func (c *CgroupMetrics) read() map[string]cgroupUsage {
+	result := map[string]cgroupUsage{}
+	if c.unified {
+		walkContainerCgroups(c.root, "", func(id, dir string) {
+			cpu, ok := readCgroupStat(path.Join(c.root, dir, "cpu.stat"), "usage_usec")
+			if !ok {
+				return
+			}
+			usage := cgroupUsage{cpu: cpu * 1000}
+			usage.memory, _ = readCgroupValue(path.Join(c.root, dir, "memory.current"))
+			if file, ok := readCgroupStat(path.Join(c.root, dir, "memory.stat"), "file"); ok && file <= usage.memory {
+				usage.memory -= file
+			}
+			// memory.max is "max" when there is no limit
+			usage.memoryLimit, _ = readCgroupValue(path.Join(c.root, dir, "memory.max"))
+			result[id] = usage
+		})
+		return result
+	}
+
+	cpuRoot, memoryRoot := path.Join(c.root, "cpuacct"), path.Join(c.root, "memory")
+	walkContainerCgroups(cpuRoot, "", func(id, dir string) {
+		cpu, ok := readCgroupValue(path.Join(cpuRoot, dir, "cpuacct.usage"))
+		if !ok {
+			return
+		}
+		// The container has the same cgroup path in each hierarchy
+		usage := cgroupUsage{cpu: cpu}
+		usage.memory, _ = readCgroupValue(path.Join(memoryRoot, dir, "memory.usage_in_bytes"))
+		if cache, ok := readCgroupStat(path.Join(memoryRoot, dir, "memory.stat"), "cache"); ok && cache <= usage.memory {
+			usage.memory -= cache
+		}
+		usage.memoryLimit, _ = readCgroupValue(path.Join(memoryRoot, dir, "memory.limit_in_bytes"))
+		result[id] = usage
+	})
+	return result
+}
+
+// walkContainerCgroups calls f with the ID and the path of the cgroups of
+// the containers under dir, relative to root.  It doesn't go into those of
+// containers, whose processes are all theirs.
+func walkContainerCgroups(root, dir string, f func(id, dir string)) {
+	entries, err := fs.ReadDir(path.Join(root, dir))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sub := path.Join(dir, entry.Name())
+		if id, ok := containerIDFromCgroupPath(sub); ok {
+			f(id, sub)
+			continue
+		}
+		walkContainerCgroups(root, sub, f)
+	}
+}
+
+// readCgroupValue reads a file of a single number.
+func readCgroupValue(filename string) (uint64, bool) {
+	buf, err := fs.ReadFile(filename)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	return value, err == nil
+}
+
+// readCgroupStat reads the value of key from a file of "key value" lines.
+func readCgroupStat(filename, key string) (uint64, bool) {
+	buf, err := fs.ReadFile(filename)
+	if err != nil {
+		return 0, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			return value, err == nil
+		}
+	}
+	return 0, false
+}
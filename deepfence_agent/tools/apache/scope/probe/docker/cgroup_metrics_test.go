@@ -0,0 +1,165 @@
+package docker_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	fs_hook "github.com/weaveworks/common/fs"
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/common/test/fs"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+func containerCgroupV2(id string, usageUsec int) fs.Entry {
+	return fs.Dir("docker-"+id+".scope",
+		fs.File{FName: "cpu.stat", FContents: fmt.Sprintf("usage_usec %d\nuser_usec 0\nsystem_usec 0\n", usageUsec)},
+		fs.File{FName: "memory.current", FContents: "3000\n"},
+		fs.File{FName: "memory.stat", FContents: "anon 1000\nfile 1000\n"},
+		fs.File{FName: "memory.max", FContents: "max\n"},
+	)
+}
+
+func cgroupV2(usageUsec int, containers ...string) fs.Entry {
+	scopes := []fs.Entry{}
+	for _, id := range containers {
+		scopes = append(scopes, containerCgroupV2(id, usageUsec))
+	}
+	return fs.Dir("",
+		fs.Dir("cgroup",
+			fs.File{FName: "cgroup.controllers", FContents: "cpu memory\n"},
+			fs.Dir("system.slice", scopes...),
+			fs.Dir("user.slice"),
+		),
+	)
+}
+
+var cgroupV1 = fs.Dir("",
+	fs.Dir("cgroup",
+		fs.Dir("cpuacct",
+			fs.Dir("kubepods",
+				fs.Dir("besteffort",
+					fs.Dir("pod1234",
+						fs.Dir(cgroupContainerID,
+							fs.File{FName: "cpuacct.usage", FContents: "5000000\n"},
+						),
+					),
+				),
+			),
+		),
+		fs.Dir("memory",
+			fs.Dir("kubepods",
+				fs.Dir("besteffort",
+					fs.Dir("pod1234",
+						fs.Dir(cgroupContainerID,
+							fs.File{FName: "memory.usage_in_bytes", FContents: "3000\n"},
+							fs.File{FName: "memory.stat", FContents: "cache 1000\nrss 2000\n"},
+							fs.File{FName: "memory.limit_in_bytes", FContents: "4096\n"},
+						),
+					),
+				),
+			),
+		),
+	),
+)
+
+func TestCgroupMetricsModes(t *testing.T) {
+	fs_hook.Mock(cgroupV2(0))
+	defer fs_hook.Restore()
+
+	for _, tc := range []struct {
+		mode    string
+		enabled bool
+		err     bool
+	}{
+		{docker.CgroupMetricsOff, false, false},
+		{docker.CgroupMetricsAuto, true, false},
+		{docker.CgroupMetricsV2, true, false},
+		{docker.CgroupMetricsV1, false, true},
+		{"v3", false, true},
+	} {
+		c, err := docker.NewCgroupMetrics(tc.mode, "/cgroup")
+		if (err != nil) != tc.err || (c != nil) != tc.enabled {
+			t.Errorf("%s: expected enabled=%v err=%v, got %v, %v", tc.mode, tc.enabled, tc.err, c, err)
+		}
+	}
+}
+
+func TestCgroupMetricsV2(t *testing.T) {
+	mtime.NowForce(time.Unix(0, 0))
+	defer mtime.NowReset()
+	fs_hook.Mock(cgroupV2(1000000, cgroupContainerID))
+	defer fs_hook.Restore()
+
+	c, err := docker.NewCgroupMetrics(docker.CgroupMetricsAuto, "/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt, _ := c.Report()
+	node, ok := rpt.Container.Nodes[report.MakeContainerNodeID(cgroupContainerID)]
+	if !ok {
+		t.Fatalf("Expected the container to be reported, got %v", rpt.Container.Nodes)
+	}
+	// Without a limit, the max is the usage
+	if memory, ok := node.Metrics[docker.MemoryUsage]; !ok {
+		t.Errorf("Expected the memory of the container")
+	} else if last, _ := memory.LastSample(); last.Value != 2000 || memory.Max != 2000 {
+		t.Errorf("Expected 2000 bytes of memory, less the page cache, got %v", memory)
+	}
+	if _, ok := node.Metrics[docker.CPUTotalUsage]; ok {
+		t.Errorf("Expected no CPU in the first report")
+	}
+
+	// One CPU of the host fully used for a second
+	mtime.NowForce(time.Unix(1, 0))
+	fs_hook.Mock(cgroupV2(2000000, cgroupContainerID))
+	rpt, _ = c.Report()
+	cpu, ok := rpt.Container.Nodes[report.MakeContainerNodeID(cgroupContainerID)].Metrics[docker.CPUTotalUsage]
+	if !ok {
+		t.Fatalf("Expected the CPU of the container")
+	}
+	if last, _ := cpu.LastSample(); last.Value != 100/float64(runtime.NumCPU()) {
+		t.Errorf("Expected %v%% CPU, got %v", 100/float64(runtime.NumCPU()), last.Value)
+	}
+}
+
+func TestCgroupMetricsV1(t *testing.T) {
+	fs_hook.Mock(cgroupV1)
+	defer fs_hook.Restore()
+
+	c, err := docker.NewCgroupMetrics(docker.CgroupMetricsAuto, "/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt, _ := c.Report()
+	memory, ok := rpt.Container.Nodes[report.MakeContainerNodeID(cgroupContainerID)].Metrics[docker.MemoryUsage]
+	if !ok {
+		t.Fatalf("Expected the memory of the container, got %v", rpt.Container.Nodes)
+	}
+	if last, _ := memory.LastSample(); memory.Max != 4096 || last.Value != 2000 {
+		t.Errorf("Expected 2000 bytes of memory out of 4096, got %v", memory)
+	}
+}
+
+func BenchmarkCgroupMetricsV2(b *testing.B) {
+	containers := []string{}
+	for i := 0; i < 200; i++ {
+		containers = append(containers, fmt.Sprintf("%064x", i))
+	}
+	fs_hook.Mock(cgroupV2(1000000, containers...))
+	defer fs_hook.Restore()
+
+	c, err := docker.NewCgroupMetrics(docker.CgroupMetricsV2, "/cgroup")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rpt, _ := c.Report(); len(rpt.Container.Nodes) != len(containers) {
+			b.Fatalf("Expected %d containers, got %d", len(containers), len(rpt.Container.Nodes))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package docker_test
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/fsouza/go-dockerclient"
+
+	fs_hook "github.com/weaveworks/common/fs"
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/common/test/fs"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/probe/process"
+	"github.com/weaveworks/scope/report"
+)
+
+const cgroupContainerID = "3f4e9c2a1b0d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f"
+
+var cgroupFixtures = []struct {
+	name    string
+	cgroups string
+	want    string
+}{
+	{
+		name:    "ubuntu 22.04 cgroup v2, systemd driver",
+		cgroups: "0::/system.slice/docker-" + cgroupContainerID + ".scope\n",
+		want:    cgroupContainerID,
+	},
+	{
+		name:    "cgroup v2, cgroupfs driver",
+		cgroups: "0::/docker/" + cgroupContainerID + "\n",
+		want:    cgroupContainerID,
+	},
+	{
+		name:    "cgroup v2, containerd under kubelet",
+		cgroups: "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0c3b8a55_7d5e_4a1b_9d6e_0f1a2b3c4d5e.slice/cri-containerd-" + cgroupContainerID + ".scope\n",
+		want:    cgroupContainerID,
+	},
+	{
+		name:    "cgroup v2, cri-o",
+		cgroups: "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod0c3b8a55_7d5e_4a1b_9d6e_0f1a2b3c4d5e.slice/crio-" + cgroupContainerID + ".scope\n",
+		want:    cgroupContainerID,
+	},
+	{
+		name: "cgroup v1",
+		cgroups: "12:pids:/docker/" + cgroupContainerID + "\n" +
+			"11:memory:/docker/" + cgroupContainerID + "\n" +
+			"10:cpu,cpuacct:/docker/" + cgroupContainerID + "\n" +
+			"1:name=systemd:/docker/" + cgroupContainerID + "\n",
+		want: cgroupContainerID,
+	},
+	{
+		name: "cgroup v1, kubepods",
+		cgroups: "11:memory:/kubepods/besteffort/pod0c3b8a55-7d5e-4a1b-9d6e-0f1a2b3c4d5e/" + cgroupContainerID + "\n" +
+			"1:name=systemd:/kubepods/besteffort/pod0c3b8a55-7d5e-4a1b-9d6e-0f1a2b3c4d5e/" + cgroupContainerID + "\n",
+		want: cgroupContainerID,
+	},
+	{
+		name:    "cgroup v1, containerd systemd driver",
+		cgroups: "11:memory:/system.slice/containerd.service/kubepods-besteffort-pod0c3b8a55_7d5e_4a1b_9d6e_0f1a2b3c4d5e.slice:cri-containerd:" + cgroupContainerID + "\n",
+		want:    cgroupContainerID,
+	},
+	{
+		name: "hybrid",
+		cgroups: "12:memory:/system.slice/docker-" + cgroupContainerID + ".scope\n" +
+			"1:name=systemd:/system.slice/docker-" + cgroupContainerID + ".scope\n" +
+			"0::/system.slice/docker-" + cgroupContainerID + ".scope\n",
+		want: cgroupContainerID,
+	},
+	{
+		name:    "host process, cgroup v2",
+		cgroups: "0::/user.slice/user-1000.slice/session-3.scope\n",
+	},
+	{
+		name: "host process, cgroup v1",
+		cgroups: "12:memory:/user.slice\n" +
+			"1:name=systemd:/system.slice/containerd.service\n",
+	},
+	{
+		name:    "cri-o conmon",
+		cgroups: "0::/kubepods.slice/crio-conmon-" + cgroupContainerID + ".scope\n",
+	},
+	{
+		name:    "cgroup namespace",
+		cgroups: "0::/\n",
+	},
+}
+
+func TestContainerIDFromCgroup(t *testing.T) {
+	for _, tc := range cgroupFixtures {
+		have, ok := docker.ContainerIDFromCgroup(tc.cgroups)
+		if have != tc.want || ok != (tc.want != "") {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.name, tc.want, have, ok)
+		}
+	}
+}
+
+func TestProcessInContainer(t *testing.T) {
+	fs_hook.Mock(fs.Dir("",
+		fs.Dir("proc",
+			fs.Dir("7", fs.File{FName: "cgroup", FContents: "0::/system.slice/docker-" + cgroupContainerID + ".scope\n"}),
+			fs.Dir("6", fs.File{FName: "cgroup", FContents: "0::/system.slice/containerd.service\n"}),
+		),
+	))
+	defer fs_hook.Restore()
+
+	inContainer := docker.ProcessInContainer(process.ReadCgroup("/proc"))
+	if !inContainer(7) {
+		t.Errorf("Expected process 7 to be in a container")
+	}
+	if inContainer(6) || inContainer(5) {
+		t.Errorf("Expected processes 6 and 5 not to be in a container")
+	}
+}
+
+func TestTaggerCgroup(t *testing.T) {
+	mtime.NowForce(time.Now())
+	defer mtime.NowReset()
+
+	oldProcessTree := docker.NewProcessTreeStub
+	defer func() { docker.NewProcessTreeStub = oldProcessTree }()
+	docker.NewProcessTreeStub = func(_ process.Walker) (process.Tree, error) {
+		return &mockProcessTree{map[int]int{7: 6, 6: 1}}, nil
+	}
+
+	// Process 7 was exec'd into the container, so it is a child of the
+	// containerd-shim (6) rather than of the container's init process.
+	fs_hook.Mock(fs.Dir("",
+		fs.Dir("proc",
+			fs.Dir("7", fs.File{FName: "cgroup", FContents: "0::/system.slice/docker-" + cgroupContainerID + ".scope\n"}),
+			fs.Dir("6", fs.File{FName: "cgroup", FContents: "0::/system.slice/containerd.service\n"}),
+		),
+	))
+	defer fs_hook.Restore()
+
+	exec := *container1
+	exec.ID = cgroupContainerID
+	exec.State = client.State{Pid: 5, Running: true}
+	registry := &mockRegistry{
+		containersByPID: map[int]docker.Container{5: &mockContainer{&exec}},
+	}
+
+	var (
+		execNodeID = report.MakeProcessNodeID("somehost.com", "7")
+		shimNodeID = report.MakeProcessNodeID("somehost.com", "6")
+	)
+	input := report.MakeReport()
+	input.Process.AddNode(report.MakeNodeWith(execNodeID, map[string]string{process.PID: "7"}))
+	input.Process.AddNode(report.MakeNodeWith(shimNodeID, map[string]string{process.PID: "6"}))
+
+	have, err := docker.NewTagger(registry, nil, process.ReadCgroup("/proc")).Tag(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := have.Process.Nodes[execNodeID].Latest.Lookup(docker.ContainerID); !ok || id != cgroupContainerID {
+		t.Errorf("Expected process node %s to have container id %q, got %q", execNodeID, cgroupContainerID, id)
+	}
+	if id, ok := have.Process.Nodes[shimNodeID].Latest.Lookup(docker.ContainerID); ok {
+		t.Errorf("Expected process node %s to have no container, got %q", shimNodeID, id)
+	}
+}
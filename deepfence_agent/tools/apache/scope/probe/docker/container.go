@@ -10,7 +10,6 @@ import (
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/report"
@@ -47,6 +46,8 @@ type StatsGatherer interface {
 // Container represents a Docker container
 type Container interface {
 	UpdateState(*docker.Container)
+	MarkOOMKilled()
+	RecordExit(die *docker.APIEvents)
 
 	ID() string
 	Image() string
@@ -57,8 +58,7 @@ type Container interface {
 	StateString() string
 	HasTTY() bool
 	Container() *docker.Container
-	StartGatheringStats(StatsGatherer) error
-	StopGatheringStats()
+	GatherStats(StatsGatherer) error
 	NetworkMode() (string, bool)
 	NetworkInfo([]net.IP) report.Sets
 }
@@ -66,12 +66,14 @@ type Container interface {
 type container struct {
 	sync.RWMutex
 	container              *docker.Container
-	stopStats              chan<- bool
 	latestStats            docker.Stats
 	pendingStats           [60]docker.Stats
 	numPending             int
 	hostID                 string
 	baseNode               report.Node
+	pendingOOMKill         bool
+	oomKilled              bool
+	exits                  []containerExit
 	noCommandLineArguments bool
 	noEnvironmentVariables bool
 }
@@ -94,6 +96,28 @@ func (c *container) UpdateState(container *docker.Container) {
 	c.container = container
 }
 
+// MarkOOMKilled records that the kernel OOM killer hit the container; docker
+// sends the oom event just before the corresponding die event.
+func (c *container) MarkOOMKilled() {
+	c.Lock()
+	defer c.Unlock()
+	c.pendingOOMKill = true
+}
+
+// RecordExit adds the exit of the container of its die event to its exit
+// history.
+func (c *container) RecordExit(die *docker.APIEvents) {
+	c.Lock()
+	defer c.Unlock()
+	exit := makeContainerExit(die, c.container.State, c.pendingOOMKill)
+	c.oomKilled = c.oomKilled || exit.oomKilled
+	c.exits = append(c.exits, exit)
+	if len(c.exits) > maxExitHistory {
+		c.exits = c.exits[len(c.exits)-maxExitHistory:]
+	}
+	c.pendingOOMKill = false
+}
+
 func (c *container) ID() string {
 	return c.container.ID
 }
@@ -131,62 +155,39 @@ func (c *container) Container() *docker.Container {
 	return c.container
 }
 
-func (c *container) StartGatheringStats(client StatsGatherer) error {
-	c.Lock()
-	defer c.Unlock()
-
-	if c.stopStats != nil {
-		return nil
-	}
-	done := make(chan bool)
-	c.stopStats = done
-
-	stats := make(chan *docker.Stats)
-	opts := docker.StatsOptions{
-		ID:     c.container.ID,
-		Stats:  stats,
-		Stream: true,
-		Done:   done,
-	}
-
-	log.Debugf("docker container: collecting stats for %s", c.container.ID)
+// GatherStats queries docker for a single sample of the container's stats.
+func (c *container) GatherStats(client StatsGatherer) error {
+	c.RLock()
+	id := c.container.ID
+	c.RUnlock()
 
+	stats := make(chan *docker.Stats, 1)
+	errc := make(chan error, 1)
 	go func() {
-		if err := client.Stats(opts); err != nil && err != io.EOF && err != io.ErrClosedPipe {
-			log.Errorf("docker container: error collecting stats for %s: %v", c.container.ID, err)
-		}
+		errc <- client.Stats(docker.StatsOptions{
+			ID:      id,
+			Stats:   stats,
+			Stream:  false,
+			Timeout: statsTimeout,
+		})
 	}()
 
-	go func() {
-		for s := range stats {
-			c.Lock()
-			if c.numPending >= len(c.pendingStats) {
-				log.Warnf("docker container: dropping stats for %s", c.container.ID)
-			} else {
-				c.latestStats = *s
-				c.pendingStats[c.numPending] = *s
-				c.numPending++
-			}
-			c.Unlock()
-		}
-		log.Debugf("docker container: stopped collecting stats for %s", c.container.ID)
+	// Stats closes the channel when it returns.
+	for s := range stats {
 		c.Lock()
-		if c.stopStats == done {
-			c.stopStats = nil
+		if c.numPending >= len(c.pendingStats) {
+			log.WithField("container_id", id).Warnf("docker container: dropping stats for %s", id)
+		} else {
+			c.latestStats = *s
+			c.pendingStats[c.numPending] = *s
+			c.numPending++
 		}
 		c.Unlock()
-	}()
-
-	return nil
-}
-
-func (c *container) StopGatheringStats() {
-	c.Lock()
-	defer c.Unlock()
-	if c.stopStats != nil {
-		close(c.stopStats)
-		c.stopStats = nil
 	}
+	if err := <-errc; err != nil && err != io.EOF && err != io.ErrClosedPipe {
+		return err
+	}
+	return nil
 }
 
 func (c *container) ports(localAddrs []net.IP) report.StringSet {
@@ -247,7 +248,7 @@ func (c *container) NetworkInfo(localAddrs []net.IP) report.Sets {
 		// Fetch IP addresses from the container's namespace
 		cidrs, err := namespaceIPAddresses(c.container.State.Pid)
 		if err != nil {
-			log.Debugf("container %s: failed to get addresses: %s", c.container.ID, err)
+			log.WithField("container_id", c.container.ID).Debugf("container %s: failed to get addresses: %s", c.container.ID, err)
 		}
 		for _, cidr := range cidrs {
 			// This address can duplicate an address fetched from Docker earlier,
@@ -321,9 +322,17 @@ func (c *container) cpuPercentMetric(stats []docker.Stats) report.Metric {
 		return report.MakeMetric(nil)
 	}
 
-	samples := make([]report.Sample, len(stats)-1)
+	// The rate is taken over the time since the previous sample, however
+	// long ago that was, so it stays correct when a container is sampled
+	// less often than every report.
+	samples := make([]report.Sample, 0, len(stats)-1)
 	previous := stats[0]
-	for i, s := range stats[1:] {
+	for _, s := range stats[1:] {
+		if s.CPUStats.CPUUsage.TotalUsage < previous.CPUStats.CPUUsage.TotalUsage {
+			// The counters were reset by a container restart.
+			previous = s
+			continue
+		}
 		// Copies from docker/api/client/stats.go#L205
 		cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage - previous.CPUStats.CPUUsage.TotalUsage)
 		systemDelta := float64(s.CPUStats.SystemCPUUsage - previous.CPUStats.SystemCPUUsage)
@@ -331,8 +340,7 @@ func (c *container) cpuPercentMetric(stats []docker.Stats) report.Metric {
 		if systemDelta > 0.0 && cpuDelta > 0.0 {
 			cpuPercent = (cpuDelta / systemDelta) * 100.0
 		}
-		samples[i].Timestamp = s.Read
-		samples[i].Value = cpuPercent
+		samples = append(samples, report.Sample{Timestamp: s.Read, Value: cpuPercent})
 		previous = s
 	}
 	return report.MakeMetric(samples).WithMax(100.0)
@@ -382,6 +390,7 @@ func (c *container) getBaseNode() report.Node {
 		ImageID:           c.Image(),
 		ContainerHostname: c.Hostname(),
 	}).WithParent(report.ContainerImage, report.MakeContainerImageNodeID(c.Image()))
+	result = withSecurityPosture(result, c.container)
 	result = result.AddPrefixPropertyList(LabelPrefix, c.container.Config.Labels)
 	if !c.noEnvironmentVariables {
 		result = result.AddPrefixPropertyList(EnvPrefix, c.env())
@@ -410,6 +419,7 @@ func (c *container) GetNode() report.Node {
 	}
 
 	result := c.baseNode.WithLatests(latest)
+	result = withExits(result, c.exits, c.oomKilled)
 	result = result.WithMetrics(c.metrics())
 	return result
 }
@@ -17,25 +17,17 @@ import (
 )
 
 type mockStatsGatherer struct {
-	opts  client.StatsOptions
-	ready chan bool
-}
-
-func newMockStatsGatherer() *mockStatsGatherer {
-	return &mockStatsGatherer{ready: make(chan bool)}
+	stats []*client.Stats
 }
 
 func (s *mockStatsGatherer) Stats(opts client.StatsOptions) error {
-	s.opts = opts
-	close(s.ready)
+	for _, stats := range s.stats {
+		opts.Stats <- stats
+	}
+	close(opts.Stats)
 	return nil
 }
 
-func (s *mockStatsGatherer) Send(stats *client.Stats) {
-	<-s.ready
-	s.opts.Stats <- stats
-}
-
 func TestContainer(t *testing.T) {
 	now := time.Unix(12345, 67890).UTC()
 	mtime.NowForce(now)
@@ -43,28 +35,19 @@ func TestContainer(t *testing.T) {
 
 	const hostID = "scope"
 	c := docker.NewContainer(container1, hostID, false, false)
-	s := newMockStatsGatherer()
-	err := c.StartGatheringStats(s)
-	if err != nil {
-		t.Errorf("%v", err)
-	}
-	defer c.StopGatheringStats()
 
 	// Send some stats to the docker container
 	stats := &client.Stats{}
 	stats.Read = now
 	stats.MemoryStats.Usage = 12345
 	stats.MemoryStats.Limit = 45678
-	s.Send(stats)
+	if err := c.GatherStats(&mockStatsGatherer{stats: []*client.Stats{stats}}); err != nil {
+		t.Errorf("%v", err)
+	}
 
 	// Now see if we go them
 	{
 		uptimeSeconds := int(now.Sub(startTime) / time.Second)
-		controls := []string{
-			docker.RestartContainer,
-			docker.StopContainer,
-			docker.PauseContainer,
-		}
 		want := report.MakeNodeWith("ping;<container>", map[string]string{
 			"docker_container_command":     "ping foo.bar.local",
 			"docker_container_created":     "0001-01-01T00:00:00Z",
@@ -77,9 +60,8 @@ func TestContainer(t *testing.T) {
 			"docker_container_state_human": c.Container().State.String(),
 			"docker_container_uptime":      strconv.Itoa(uptimeSeconds),
 			"docker_env_FOO":               "secret-bar",
-		}).WithLatestActiveControls(
-			controls...,
-		).WithMetrics(report.Metrics{
+			"docker_security_score":        strconv.Itoa(docker.SecurityScore(container1)),
+		}).WithMetrics(report.Metrics{
 			"docker_cpu_total_usage": report.MakeMetric(nil),
 			"docker_memory_usage":    report.MakeSingletonMetric(now, 12345).WithMax(45678),
 		}).WithParents(report.MakeSets().
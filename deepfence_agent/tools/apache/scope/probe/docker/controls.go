@@ -14,9 +14,14 @@ const (
 	ContainerDeleteUserDefinedTags = "container_delete_user_defined_tags"
 	ImageAddUserDefinedTags        = "image_add_user_defined_tags"
 	ImageDeleteUserDefinedTags     = "image_delete_user_defined_tags"
+	InspectContainer               = "docker_inspect_container"
 	waitTime = 10
 )
 
+// InspectContainerSchema is the schema of the result of InspectContainer:
+// the container, as the docker API inspects it.
+const InspectContainerSchema = "docker/container/v1"
+
 func (r *registry) addContainerUserDefinedTags(containerID string, req xfer.Request) xfer.Response {
 	tags := strings.Split(fmt.Sprintf("%s", req.ControlArgs["user_defined_tags"]), ",")
 	r.userDefinedContainerTags.Lock()
@@ -95,6 +100,14 @@ func (r *registry) deleteImageUserDefinedTags(imageID string, req xfer.Request)
 	return xfer.Response{TagsInfo: "Tags deleted"}
 }
 
+func (r *registry) inspectContainer(containerID string, _ xfer.Request) xfer.Response {
+	container, err := r.client.InspectContainer(containerID)
+	if err != nil {
+		return xfer.ResponseError(err)
+	}
+	return xfer.ResponseResult(InspectContainerSchema, container)
+}
+
 func captureContainerID(f func(string, xfer.Request) xfer.Response) func(xfer.Request) xfer.Response {
 	return func(req xfer.Request) xfer.Response {
 		containerID, ok := report.ParseContainerNodeID(req.NodeID)
@@ -123,6 +136,8 @@ func (r *registry) registerControls() {
 		ContainerDeleteUserDefinedTags: captureContainerID(r.deleteContainerUserDefinedTags),
 		ImageAddUserDefinedTags:        captureImageName(r.addImageUserDefinedTags),
 		ImageDeleteUserDefinedTags:     captureImageName(r.deleteImageUserDefinedTags),
+		InspectContainer:               captureContainerID(r.inspectContainer),
+		ScanVulnerabilities:            r.scanVulnerabilities,
 	}
 	r.handlerRegistry.Batch(nil, controls)
 }
@@ -133,6 +148,8 @@ func (r *registry) deregisterControls() {
 		ContainerDeleteUserDefinedTags,
 		ImageAddUserDefinedTags,
 		ImageDeleteUserDefinedTags,
+		InspectContainer,
+		ScanVulnerabilities,
 	}
 	r.handlerRegistry.Batch(controls, nil)
 }
@@ -1,11 +1,14 @@
 package docker_test
 
 import (
+	"encoding/json"
 	"io"
 	"reflect"
 	"testing"
 	"time"
 
+	client "github.com/fsouza/go-dockerclient"
+
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/probe/docker"
@@ -22,19 +25,19 @@ func TestControls(t *testing.T) {
 		})
 		defer registry.Stop()
 
-		for _, tc := range []struct{ command, result string }{
-			{docker.StopContainer, "stopped"},
-			{docker.StartContainer, "started"},
-			{docker.RestartContainer, "restarted"},
-			{docker.PauseContainer, "paused"},
-			{docker.UnpauseContainer, "unpaused"},
+		for _, tc := range []struct{ command, nodeID, result string }{
+			{docker.ContainerAddUserDefinedTags, report.MakeContainerNodeID("a1b2c3d4e5"), "Tags added"},
+			{docker.ContainerDeleteUserDefinedTags, report.MakeContainerNodeID("a1b2c3d4e5"), "Tags deleted"},
+			{docker.ImageAddUserDefinedTags, report.MakeContainerImageNodeID("baz"), "Tags added"},
+			{docker.ImageDeleteUserDefinedTags, report.MakeContainerImageNodeID("baz"), "Tags deleted"},
 		} {
 			result := hr.HandleControlRequest(xfer.Request{
-				Control: tc.command,
-				NodeID:  report.MakeContainerNodeID("a1b2c3d4e5"),
+				Control:     tc.command,
+				NodeID:      tc.nodeID,
+				ControlArgs: map[string]string{"user_defined_tags": "prod"},
 			})
 			if !reflect.DeepEqual(result, xfer.Response{
-				Error: tc.result,
+				TagsInfo: tc.result,
 			}) {
 				t.Error(result)
 			}
@@ -42,6 +45,41 @@ func TestControls(t *testing.T) {
 	})
 }
 
+func TestInspectContainer(t *testing.T) {
+	mdc := newMockClient()
+	setupStubs(mdc, func() {
+		hr := controls.NewDefaultHandlerRegistry()
+		registry, _ := docker.NewRegistry(docker.RegistryOptions{
+			Interval:        10 * time.Second,
+			HandlerRegistry: hr,
+		})
+		defer registry.Stop()
+
+		result := hr.HandleControlRequest(xfer.Request{
+			Control: docker.InspectContainer,
+			NodeID:  report.MakeContainerNodeID("ping"),
+		})
+		if result.Error != "" || result.ResultSchema != docker.InspectContainerSchema {
+			t.Fatal(result)
+		}
+		var container client.Container
+		if err := json.Unmarshal(result.Result, &container); err != nil {
+			t.Fatal(err)
+		}
+		if container.ID != "ping" || container.Name != "pong" || !reflect.DeepEqual(container.Args, []string{"foo.bar.local"}) {
+			t.Errorf("unexpected container: %+v", container)
+		}
+
+		result = hr.HandleControlRequest(xfer.Request{
+			Control: docker.InspectContainer,
+			NodeID:  report.MakeContainerNodeID("missing"),
+		})
+		if result.Error == "" || result.Result != nil {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	})
+}
+
 type mockPipe struct{}
 
 func (mockPipe) Ends() (io.ReadWriter, io.ReadWriter)                        { return nil, nil }
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"strconv"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for container exits, populated from the docker die and oom events.
+const (
+	ContainerOOMKilled              = report.DockerOOMKilled
+	ContainerLastExitCode           = report.DockerLastExitCode
+	ContainerLastExitTime           = report.DockerLastExitTime
+	ContainerExitHistoryTablePrefix = report.DockerExitHistoryTablePrefix
+
+	ContainerExitCode       = "docker_exit_code"
+	ContainerExitOOMKilled  = "docker_exit_oom_killed"
+	ContainerExitFinishedAt = "docker_exit_finished_at"
+
+	// maxExitHistory is the number of exits kept per container.
+	maxExitHistory = 5
+)
+
+type containerExit struct {
+	exitCode   int
+	oomKilled  bool
+	finishedAt time.Time
+}
+
+// makeContainerExit makes the exit of a container from its die event, as by
+// the time the container is inspected a restart policy may have run it
+// again.  Its inspected state is only taken to be of the exit if it isn't
+// running, or if the event doesn't give the exit code.
+func makeContainerExit(die *docker.APIEvents, state docker.State, oomKilled bool) containerExit {
+	exited := !state.Running && !state.Restarting
+	exitCode, err := strconv.Atoi(die.Actor.Attributes["exitCode"])
+	if err != nil {
+		// Docker before API 1.22 has no attributes of events
+		exitCode = state.ExitCode
+	}
+	var finishedAt time.Time
+	switch {
+	case exited && !state.FinishedAt.IsZero():
+		finishedAt = state.FinishedAt
+	case die.TimeNano != 0:
+		finishedAt = time.Unix(0, die.TimeNano)
+	case die.Time != 0:
+		finishedAt = time.Unix(die.Time, 0)
+	default:
+		finishedAt = mtime.Now()
+	}
+	return containerExit{
+		exitCode:   exitCode,
+		oomKilled:  oomKilled || exited && state.OOMKilled,
+		finishedAt: finishedAt.UTC(),
+	}
+}
+
+// withExits adds the last exit and the exit history to node. oomKilled
+// records whether the container was ever OOM killed, even if that exit has
+// since dropped out of the history.
+func withExits(node report.Node, exits []containerExit, oomKilled bool) report.Node {
+	if len(exits) == 0 {
+		return node
+	}
+	rows := make([]report.Row, 0, len(exits))
+	for _, exit := range exits {
+		finishedAt := exit.finishedAt.Format(time.RFC3339Nano)
+		rows = append(rows, report.Row{
+			ID: finishedAt,
+			Entries: map[string]string{
+				ContainerExitCode:       strconv.Itoa(exit.exitCode),
+				ContainerExitOOMKilled:  strconv.FormatBool(exit.oomKilled),
+				ContainerExitFinishedAt: finishedAt,
+			},
+		})
+	}
+	last := exits[len(exits)-1]
+	node = node.WithLatests(map[string]string{
+		ContainerOOMKilled:    strconv.FormatBool(oomKilled),
+		ContainerLastExitCode: strconv.Itoa(last.exitCode),
+		ContainerLastExitTime: last.finishedAt.Format(time.RFC3339Nano),
+	})
+	return node.AddPrefixMulticolumnTable(ContainerExitHistoryTablePrefix, rows)
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// LabelSelector matches containers having label Key with a value matching
+// the glob pattern Value.
+type LabelSelector struct {
+	Key   string
+	Value string
+}
+
+// ParseLabelSelector parses a key=value label selector, as given on the
+// command line.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return LabelSelector{}, fmt.Errorf("label selector %q isn't in the key=value format", s)
+	}
+	if _, err := path.Match(parts[1], ""); err != nil {
+		return LabelSelector{}, fmt.Errorf("label selector %q has an invalid pattern: %v", s, err)
+	}
+	return LabelSelector{Key: parts[0], Value: parts[1]}, nil
+}
+
+func (s LabelSelector) String() string {
+	return s.Key + "=" + s.Value
+}
+
+// Matches returns true if labels has a label matching s.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	value, ok := labels[s.Key]
+	if !ok {
+		return false
+	}
+	matched, _ := path.Match(s.Value, value)
+	return matched
+}
+
+// LabelSelectors is a repeatable flag.Value of label selectors.
+type LabelSelectors []LabelSelector
+
+func (s *LabelSelectors) String() string {
+	selectors := make([]string, 0, len(*s))
+	for _, selector := range *s {
+		selectors = append(selectors, selector.String())
+	}
+	return strings.Join(selectors, ",")
+}
+
+// Set implements flag.Value
+func (s *LabelSelectors) Set(value string) error {
+	selector, err := ParseLabelSelector(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, selector)
+	return nil
+}
+
+func (s LabelSelectors) matchAny(labels map[string]string) bool {
+	for _, selector := range s {
+		if selector.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelFilter decides which containers are reported, based on their labels.
+// With no Include selectors every container not excluded is reported;
+// otherwise a container has to match one of them.  Exclude takes
+// precedence over Include.
+type LabelFilter struct {
+	Include LabelSelectors
+	Exclude LabelSelectors
+}
+
+// Allows returns true if a container with the given labels should be
+// reported.
+func (f LabelFilter) Allows(labels map[string]string) bool {
+	if f.Exclude.matchAny(labels) {
+		return false
+	}
+	return len(f.Include) == 0 || f.Include.matchAny(labels)
+}
@@ -0,0 +1,85 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/weaveworks/scope/probe/docker"
+)
+
+func TestLabelFilter(t *testing.T) {
+	selectors := func(ss ...string) docker.LabelSelectors {
+		var result docker.LabelSelectors
+		for _, s := range ss {
+			if err := result.Set(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result
+	}
+	infra := map[string]string{"role": "infra", "team": "platform"}
+	payments := map[string]string{"role": "app", "team": "payments-eu"}
+	unlabelled := map[string]string{}
+
+	for _, tc := range []struct {
+		name    string
+		filter  docker.LabelFilter
+		allowed []map[string]string
+		denied  []map[string]string
+	}{
+		{
+			name:    "no selectors",
+			filter:  docker.LabelFilter{},
+			allowed: []map[string]string{infra, payments, unlabelled},
+		},
+		{
+			name:    "exclude",
+			filter:  docker.LabelFilter{Exclude: selectors("role=infra")},
+			allowed: []map[string]string{payments, unlabelled},
+			denied:  []map[string]string{infra},
+		},
+		{
+			name:    "include glob",
+			filter:  docker.LabelFilter{Include: selectors("team=payments-*")},
+			allowed: []map[string]string{payments},
+			denied:  []map[string]string{infra, unlabelled},
+		},
+		{
+			name:    "any include matches",
+			filter:  docker.LabelFilter{Include: selectors("team=payments-*", "role=infra")},
+			allowed: []map[string]string{infra, payments},
+			denied:  []map[string]string{unlabelled},
+		},
+		{
+			name:    "exclude takes precedence",
+			filter:  docker.LabelFilter{Include: selectors("team=*"), Exclude: selectors("role=in?ra")},
+			allowed: []map[string]string{payments},
+			denied:  []map[string]string{infra, unlabelled},
+		},
+	} {
+		for _, labels := range tc.allowed {
+			if !tc.filter.Allows(labels) {
+				t.Errorf("%s: expected %v to be allowed", tc.name, labels)
+			}
+		}
+		for _, labels := range tc.denied {
+			if tc.filter.Allows(labels) {
+				t.Errorf("%s: expected %v to be filtered out", tc.name, labels)
+			}
+		}
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	for _, invalid := range []string{"", "role", "=infra", "role=[infra"} {
+		if _, err := docker.ParseLabelSelector(invalid); err == nil {
+			t.Errorf("Expected an error parsing %q", invalid)
+		}
+	}
+	selector, err := docker.ParseLabelSelector("com.example/tier=a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector.Key != "com.example/tier" || selector.Value != "a=b" {
+		t.Errorf("Unexpected selector %v", selector)
+	}
+}
@@ -0,0 +1,180 @@
+package docker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	docker_client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for image provenance.
+const (
+	ImageRegistry              = report.DockerImageRegistry
+	ImageProvenanceTablePrefix = report.DockerImageProvenancePrefix
+	ImageRepoTags              = report.DockerImageRepoTags
+	ImageRepoDigests           = report.DockerImageRepoDigests
+
+	ImageProvenanceRegistry   = "docker_image_provenance_registry"
+	ImageProvenanceFirstSeen  = "docker_image_provenance_first_seen"
+	ImageProvenanceLastPulled = "docker_image_provenance_last_pulled"
+	ImageProvenancePulledBy   = "docker_image_provenance_pulled_by"
+
+	defaultRegistry = "docker.io"
+	pulledByDigest  = "digest"
+	pulledByTag     = "tag"
+)
+
+// ImageProvenance records where an image was obtained from: one entry is
+// kept per repository the image is known under.
+type ImageProvenance struct {
+	Repository string
+	Registry   string
+	FirstSeen  time.Time
+	LastPulled time.Time // zero if no pull was seen since the probe started
+	PulledBy   string    // "digest" or "tag"; empty if no pull was seen
+}
+
+// splitReference splits an image reference such as "nginx:1.19" or
+// "quay.io/foo/bar@sha256:..." into its repository, and reports whether it
+// refers to a digest rather than a tag.
+func splitReference(ref string) (string, bool) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i], true
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], false
+	}
+	return ref, false
+}
+
+// familiarReference strips the default registry from ref, the way docker
+// shows it in RepoTags.
+func familiarReference(ref string) string {
+	ref = strings.TrimPrefix(ref, defaultRegistry+"/")
+	return strings.TrimPrefix(ref, "library/")
+}
+
+// registryHost returns the registry a repository lives in, following the
+// docker rule that the first path component is a host only if it looks like
+// one.
+func registryHost(repository string) string {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return defaultRegistry
+}
+
+// NormalizeReference returns ref the way docker shows it in RepoTags or
+// RepoDigests: without the default registry, and tagged "latest" if it has
+// neither tag nor digest.
+func NormalizeReference(ref string) string {
+	ref = familiarReference(ref)
+	if repository, byDigest := splitReference(ref); repository == ref && !byDigest {
+		ref += ":latest"
+	}
+	return ref
+}
+
+// imageRepoTags returns the familiar RepoTags and RepoDigests of image.
+func imageRepoTags(image docker_client.APIImages) (tags, digests report.StringSet) {
+	tags, digests = report.MakeStringSet(), report.MakeStringSet()
+	for _, ref := range imageReferences(image) {
+		if _, byDigest := splitReference(ref); byDigest {
+			digests = digests.Add(familiarReference(ref))
+		} else {
+			tags = tags.Add(familiarReference(ref))
+		}
+	}
+	return tags, digests
+}
+
+func imageReferences(image docker_client.APIImages) []string {
+	refs := []string{}
+	for _, ref := range append(image.RepoTags, image.RepoDigests...) {
+		if ref == "" || strings.HasPrefix(ref, "<none>") || strings.HasSuffix(ref, ":<none>") {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// hasReference checks whether image is known under the pulled reference
+// ref.  A pull without tag or digest pulls "latest".
+func hasReference(image docker_client.APIImages, ref string) bool {
+	ref = NormalizeReference(ref)
+	for _, imageRef := range imageReferences(image) {
+		if familiarReference(imageRef) == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// updateProvenance returns the provenance of images, keyed by image ID and
+// then by repository, carrying over what is already known in previous.
+func updateProvenance(previous map[string]map[string]ImageProvenance, images map[string]docker_client.APIImages) map[string]map[string]ImageProvenance {
+	now := mtime.Now()
+	result := make(map[string]map[string]ImageProvenance, len(images))
+	for imageID, image := range images {
+		entries := map[string]ImageProvenance{}
+		for _, ref := range imageReferences(image) {
+			repository, _ := splitReference(familiarReference(ref))
+			if _, ok := entries[repository]; ok {
+				continue
+			}
+			entry, ok := previous[imageID][repository]
+			if !ok {
+				entry = ImageProvenance{
+					Repository: repository,
+					Registry:   registryHost(repository),
+					FirstSeen:  now,
+				}
+			}
+			entries[repository] = entry
+		}
+		if len(entries) > 0 {
+			result[imageID] = entries
+		}
+	}
+	return result
+}
+
+func sortedProvenance(entries map[string]ImageProvenance) []ImageProvenance {
+	result := make([]ImageProvenance, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Repository < result[j].Repository })
+	return result
+}
+
+// imageRegistries returns the distinct registries in provenance.
+func imageRegistries(provenance []ImageProvenance) []string {
+	registries := report.MakeStringSet()
+	for _, entry := range provenance {
+		registries = registries.Add(entry.Registry)
+	}
+	return []string(registries)
+}
+
+func provenanceTable(provenance []ImageProvenance) []report.Row {
+	rows := make([]report.Row, 0, len(provenance))
+	for _, entry := range provenance {
+		entries := map[string]string{
+			ImageProvenanceRegistry:  entry.Registry,
+			ImageProvenanceFirstSeen: entry.FirstSeen.UTC().Format(time.RFC3339Nano),
+		}
+		if !entry.LastPulled.IsZero() {
+			entries[ImageProvenanceLastPulled] = entry.LastPulled.UTC().Format(time.RFC3339Nano)
+			entries[ImageProvenancePulledBy] = entry.PulledBy
+		}
+		rows = append(rows, report.Row{ID: entry.Repository, Entries: entries})
+	}
+	return rows
+}
@@ -1,7 +1,7 @@
 package docker
 
 import (
-	scopeHostname "github.com/weaveworks/scope/common/hostname"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -9,13 +9,18 @@ import (
 
 	"github.com/armon/go-radix"
 	dfUtils "github.com/deepfence/df-utils"
+	"github.com/docker/docker/api/types/swarm"
 	docker_client "github.com/fsouza/go-dockerclient"
-	log "github.com/sirupsen/logrus"
 
+	"github.com/weaveworks/common/mtime"
+	scopeHostname "github.com/weaveworks/scope/common/hostname"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("docker")
+
 // Consts exported for testing.
 const (
 	CreateEvent            = "create"
@@ -23,6 +28,8 @@ const (
 	RenameEvent            = "rename"
 	StartEvent             = "start"
 	DieEvent               = "die"
+	OOMEvent               = "oom"
+	PullEvent              = "pull"
 	PauseEvent             = "pause"
 	UnpauseEvent           = "unpause"
 	NetworkConnectEvent    = "network:connect"
@@ -45,12 +52,18 @@ type Registry interface {
 	WalkContainers(f func(Container))
 	WalkImages(f func(docker_client.APIImages))
 	WalkNetworks(f func(docker_client.Network))
+	WalkSwarmServices(f func(SwarmService))
 	WatchContainerUpdates(ContainerUpdateWatcher)
 	GetContainer(string) (Container, bool)
 	GetContainerByPrefix(string) (Container, bool)
 	GetContainerImage(string) (docker_client.APIImages, bool)
 	GetContainerTags() map[string][]string
 	GetImageTags() map[string][]string
+	GetImageProvenance(string) []ImageProvenance
+	Connected() bool
+	SetLabelFilter(LabelFilter)
+	CanScanImages() bool
+	ImageScanStatus(string) (report.ScanStatus, bool)
 }
 
 // ContainerUpdateWatcher is the type of functions that get called when containers are updated.
@@ -65,19 +78,27 @@ type registry struct {
 	sync.RWMutex
 	quit                   chan chan struct{}
 	interval               time.Duration
+	connected              bool
 	collectStats           bool
+	stats                  *statsCollector
 	client                 Client
 	pipes                  controls.PipeClient
 	hostID                 string
 	handlerRegistry        *controls.HandlerRegistry
 	noCommandLineArguments bool
 	noEnvironmentVariables bool
+	labelFilter            LabelFilter
+	refilter               chan struct{}
+	scanAPI                *scanAPIClient
 
 	watchers        []ContainerUpdateWatcher
 	containers      *radix.Tree
 	containersByPID map[int]Container
 	images          map[string]docker_client.APIImages
+	provenance      map[string]map[string]ImageProvenance
+	scanStatuses    map[string]report.ScanStatus // by image ID
 	networks        []docker_client.Network
+	swarmServices   []SwarmService
 	pipeIDToexecID  map[string]string
 	userDefinedContainerTags UserDefinedTags
 	userDefinedImageTags     UserDefinedTags
@@ -92,17 +113,50 @@ type Client interface {
 	InspectContainer(string) (*docker_client.Container, error)
 	ListImages(docker_client.ListImagesOptions) ([]docker_client.APIImages, error)
 	ListNetworks() ([]docker_client.Network, error)
+	Info() (*docker_client.DockerInfo, error)
+	ListServices(docker_client.ListServicesOptions) ([]swarm.Service, error)
+	ListTasks(docker_client.ListTasksOptions) ([]swarm.Task, error)
 	AddEventListener(chan<- *docker_client.APIEvents) error
 	RemoveEventListener(chan *docker_client.APIEvents) error
 
 	Stats(docker_client.StatsOptions) error
 }
 
-func newDockerClient(endpoint string) (Client, error) {
+// TLSOptions are the paths of the certificates used to talk to a
+// TLS-protected docker daemon, as with the docker CLI's --tlscert,
+// --tlskey and --tlscacert.
+type TLSOptions struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+func (o TLSOptions) enabled() bool {
+	return o.Cert != "" || o.Key != "" || o.CA != ""
+}
+
+// newDockerClient connects to endpoint, defaulting to $DOCKER_HOST (and
+// honouring $DOCKER_TLS_VERIFY / $DOCKER_CERT_PATH) when it is empty.
+func newDockerClient(endpoint string, tlsOptions TLSOptions) (Client, error) {
+	if !tlsOptions.enabled() {
+		if endpoint == "" {
+			return docker_client.NewClientFromEnv()
+		}
+		return docker_client.NewClient(endpoint)
+	}
+
+	if endpoint == "" {
+		endpoint = os.Getenv("DOCKER_HOST")
+	}
 	if endpoint == "" {
-		return docker_client.NewClientFromEnv()
+		return nil, fmt.Errorf("docker TLS options given without a docker endpoint")
 	}
-	return docker_client.NewClient(endpoint)
+	// Without a CA the client library would skip verification of the
+	// server certificate altogether.
+	if tlsOptions.CA == "" {
+		return nil, fmt.Errorf("docker TLS requires a CA certificate to verify %s", endpoint)
+	}
+	return docker_client.NewTLSClient(endpoint, tlsOptions.Cert, tlsOptions.Key, tlsOptions.CA)
 }
 
 // RegistryOptions are used to initialize the Registry
@@ -110,16 +164,21 @@ type RegistryOptions struct {
 	Interval               time.Duration
 	Pipes                  controls.PipeClient
 	CollectStats           bool
+	StatsInterval          time.Duration // Interval if not positive
+	StatsMaxContainers     int
 	HostID                 string
 	HandlerRegistry        *controls.HandlerRegistry
 	DockerEndpoint         string
+	DockerTLS              TLSOptions
+	LabelFilter            LabelFilter
 	NoCommandLineArguments bool
 	NoEnvironmentVariables bool
+	ScanAPI                ScanAPIOptions
 }
 
 // NewRegistry returns a usable Registry. Don't forget to Stop it.
 func NewRegistry(options RegistryOptions) (Registry, error) {
-	client, err := NewDockerClientStub(options.DockerEndpoint)
+	client, err := NewDockerClientStub(options.DockerEndpoint, options.DockerTLS)
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +191,8 @@ func NewRegistry(options RegistryOptions) (Registry, error) {
 		containers:      radix.New(),
 		containersByPID: map[int]Container{},
 		images:          map[string]docker_client.APIImages{},
+		provenance:      map[string]map[string]ImageProvenance{},
+		scanStatuses:    map[string]report.ScanStatus{},
 		pipeIDToexecID:  map[string]string{},
 
 		client:          client,
@@ -141,8 +202,11 @@ func NewRegistry(options RegistryOptions) (Registry, error) {
 		hostID:          options.HostID,
 		handlerRegistry: options.HandlerRegistry,
 		quit:            make(chan chan struct{}),
+		refilter:        make(chan struct{}, 1),
 		noCommandLineArguments: options.NoCommandLineArguments,
 		noEnvironmentVariables: options.NoEnvironmentVariables,
+		labelFilter:            options.LabelFilter,
+		scanAPI:                newScanAPIClient(options.ScanAPI),
 		userDefinedContainerTags: UserDefinedTags{
 			tags: make(map[string][]string),
 		},
@@ -153,6 +217,14 @@ func NewRegistry(options RegistryOptions) (Registry, error) {
 		kubernetesClusterId:   os.Getenv(k8sClusterId),
 		kubernetesClusterName: os.Getenv(k8sClusterName),
 	}
+	if r.collectStats {
+		statsInterval := options.StatsInterval
+		if statsInterval <= 0 {
+			// Or stats would never be sampled, nor failures backed off
+			statsInterval = options.Interval
+		}
+		r.stats = newStatsCollector(client, statsWorkers, options.StatsMaxContainers, statsInterval)
+	}
 	hostName = scopeHostname.Get()
 	r.registerControls()
 	go r.loop()
@@ -218,7 +290,12 @@ func (r *registry) WatchContainerUpdates(f ContainerUpdateWatcher) {
 	r.watchers = append(r.watchers, f)
 }
 
+// maxReconnectBackoff bounds the wait between attempts to reconnect to an
+// unreachable docker daemon.
+const maxReconnectBackoff = 5 * time.Minute
+
 func (r *registry) loop() {
+	backoff := r.interval
 	for {
 		// NB listenForEvents blocks.
 		// Returning false means we should exit.
@@ -226,9 +303,45 @@ func (r *registry) loop() {
 			return
 		}
 
-		// Sleep here so we don't hammer the
-		// logs if docker is down
-		time.Sleep(r.interval)
+		// If we lost an established connection retry promptly, otherwise
+		// back off so we don't hammer the logs (or a remote daemon) if
+		// docker is down.
+		if r.Connected() {
+			backoff = r.interval
+		} else {
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+		}
+		r.setConnected(false)
+		time.Sleep(backoff)
+	}
+}
+
+func (r *registry) setConnected(connected bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.connected = connected
+}
+
+// Connected returns true if the registry is currently receiving events
+// from the docker daemon.
+func (r *registry) Connected() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.connected
+}
+
+// SetLabelFilter changes which containers are reported, tracking those
+// the new filter allows and forgetting the others.
+func (r *registry) SetLabelFilter(f LabelFilter) {
+	r.Lock()
+	r.labelFilter = f
+	r.Unlock()
+	select {
+	case r.refilter <- struct{}{}:
+	default:
 	}
 }
 
@@ -243,6 +356,10 @@ func (r *registry) listenForEvents() bool {
 	// after listing but before listening for events.
 	// Use a buffered chan so the client library can run ahead of the listener
 	// - Docker will drop an event if it is not collected quickly enough.
+	// The client library transparently reconnects on transient failures
+	// (e.g. a dropped TCP connection), resuming from the last event seen
+	// via the since cursor; if it gives up the channel is closed and we
+	// start over from a fresh listing.
 	events := make(chan *docker_client.APIEvents, 1024)
 	if err := r.client.AddEventListener(events); err != nil {
 		log.Errorf("docker registry: %s", err)
@@ -269,7 +386,14 @@ func (r *registry) listenForEvents() bool {
 		return true
 	}
 
+	r.updateSwarmServices()
+	r.setConnected(true)
+
 	otherUpdates := time.Tick(r.interval)
+	var statsUpdates <-chan time.Time
+	if r.collectStats {
+		statsUpdates = time.Tick(r.stats.interval)
+	}
 	for {
 		select {
 		case event, ok := <-events:
@@ -279,6 +403,12 @@ func (r *registry) listenForEvents() bool {
 			}
 			r.handleEvent(event)
 
+		case <-r.refilter:
+			if err := r.updateContainers(); err != nil {
+				log.Errorf("docker registry: %s", err)
+				return true
+			}
+
 		case <-otherUpdates:
 			if err := r.updateImages(); err != nil {
 				log.Errorf("docker registry: %s", err)
@@ -288,17 +418,12 @@ func (r *registry) listenForEvents() bool {
 				log.Errorf("docker registry: %s", err)
 				return true
 			}
+			r.updateSwarmServices()
+
+		case <-statsUpdates:
+			r.gatherStats()
 
 		case ch := <-r.quit:
-			r.Lock()
-			defer r.Unlock()
-
-			if r.collectStats {
-				r.containers.Walk(func(_ string, c interface{}) bool {
-					c.(Container).StopGatheringStats()
-					return false
-				})
-			}
 			close(ch)
 			return false
 		}
@@ -309,17 +434,11 @@ func (r *registry) reset() {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.collectStats {
-		r.containers.Walk(func(_ string, c interface{}) bool {
-			c.(Container).StopGatheringStats()
-			return false
-		})
-	}
-
 	r.containers = radix.New()
 	r.containersByPID = map[int]Container{}
 	r.images = map[string]docker_client.APIImages{}
 	r.networks = r.networks[:0]
+	r.swarmServices = nil
 }
 
 func (r *registry) updateContainers() error {
@@ -329,7 +448,7 @@ func (r *registry) updateContainers() error {
 	}
 
 	for _, apiContainer := range apiContainers {
-		r.updateContainerState(apiContainer.ID)
+		r.updateContainerState(apiContainer.ID, nil)
 	}
 
 	return nil
@@ -350,10 +469,44 @@ func (r *registry) updateImages() error {
 		}
 		r.images[trimImageID(image.ID)] = image
 	}
+	r.provenance = updateProvenance(r.provenance, r.images)
+	for imageID := range r.scanStatuses {
+		if _, ok := r.images[imageID]; !ok {
+			delete(r.scanStatuses, imageID)
+		}
+	}
 
 	return nil
 }
 
+// recordImagePull notes that ref was pulled in the provenance of the image
+// it now refers to.
+func (r *registry) recordImagePull(ref string) {
+	if err := r.updateImages(); err != nil {
+		log.Errorf("Error updating images after pull of %s: %v", ref, err)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	repository, byDigest := splitReference(familiarReference(ref))
+	for imageID, image := range r.images {
+		if !hasReference(image, ref) {
+			continue
+		}
+		entry, ok := r.provenance[imageID][repository]
+		if !ok {
+			continue
+		}
+		entry.LastPulled = mtime.Now()
+		entry.PulledBy = pulledByTag
+		if byDigest {
+			entry.PulledBy = pulledByDigest
+		}
+		r.provenance[imageID][repository] = entry
+	}
+}
+
 func (r *registry) updateNetworks() error {
 	networks, err := r.client.ListNetworks()
 	if err != nil {
@@ -367,11 +520,67 @@ func (r *registry) updateNetworks() error {
 	return nil
 }
 
+// updateSwarmServices refreshes the list of Swarm services. Failures are
+// only logged: a broken swarm must not stop us reporting containers.
+func (r *registry) updateSwarmServices() {
+	services, err := r.listSwarmServices()
+	if err != nil {
+		log.Warnf("docker registry: unable to list swarm services: %s", err)
+	}
+
+	r.Lock()
+	r.swarmServices = services
+	r.Unlock()
+}
+
+func (r *registry) listSwarmServices() ([]SwarmService, error) {
+	info, err := r.client.Info()
+	if err != nil {
+		return nil, err
+	}
+	if !isSwarmManager(info) {
+		return nil, nil
+	}
+
+	services, err := r.client.ListServices(docker_client.ListServicesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := r.client.ListTasks(docker_client.ListTasksOptions{
+		Filters: map[string][]string{"desired-state": {"running"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	running := map[string]int{}
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning {
+			running[task.ServiceID]++
+		}
+	}
+	result := make([]SwarmService, 0, len(services))
+	for _, service := range services {
+		result = append(result, SwarmService{Service: service, RunningTasks: running[service.ID]})
+	}
+	return result, nil
+}
+
 func (r *registry) handleEvent(event *docker_client.APIEvents) {
 	// TODO: Send shortcut reports on networks being created/destroyed?
 	switch event.Status {
-	case CreateEvent, RenameEvent, StartEvent, DieEvent, PauseEvent, UnpauseEvent, NetworkConnectEvent, NetworkDisconnectEvent:
-		r.updateContainerState(event.ID)
+	case CreateEvent, RenameEvent, StartEvent, PauseEvent, UnpauseEvent, NetworkConnectEvent, NetworkDisconnectEvent:
+		r.updateContainerState(event.ID, nil)
+	case DieEvent:
+		r.updateContainerState(event.ID, event)
+	case PullEvent:
+		r.recordImagePull(event.ID)
+	case OOMEvent:
+		r.RLock()
+		if c, ok := r.containers.Get(event.ID); ok {
+			c.(Container).MarkOOMKilled()
+		}
+		r.RUnlock()
 	case DestroyEvent:
 		r.Lock()
 		r.deleteContainer(event.ID)
@@ -380,14 +589,16 @@ func (r *registry) handleEvent(event *docker_client.APIEvents) {
 	}
 }
 
-func (r *registry) updateContainerState(containerID string) {
+// updateContainerState inspects a container, recording its exit if given the
+// die event it's inspected on.
+func (r *registry) updateContainerState(containerID string, die *docker_client.APIEvents) {
 	r.Lock()
 	defer r.Unlock()
 
 	dockerContainer, err := r.client.InspectContainer(containerID)
 	if err != nil {
 		if _, ok := err.(*docker_client.NoSuchContainer); !ok {
-			log.Errorf("Unable to get status for container %s: %v", containerID, err)
+			log.WithField("container_id", containerID).Errorf("Unable to get status for container %s: %v", containerID, err)
 			return
 		}
 		// Docker says the container doesn't exist - remove it from our data
@@ -395,6 +606,17 @@ func (r *registry) updateContainerState(containerID string) {
 		return
 	}
 
+	// Containers filtered out by label are never tracked, so they don't
+	// show up in the report nor get processes attributed to them.
+	var labels map[string]string
+	if dockerContainer.Config != nil {
+		labels = dockerContainer.Config.Labels
+	}
+	if !r.labelFilter.Allows(labels) {
+		r.deleteContainer(containerID)
+		return
+	}
+
 	// Container exists, ensure we have it
 	o, ok := r.containers.Get(containerID)
 	var c Container
@@ -407,6 +629,9 @@ func (r *registry) updateContainerState(containerID string) {
 		delete(r.containersByPID, c.PID())
 		c.UpdateState(dockerContainer)
 	}
+	if die != nil {
+		c.RecordExit(die)
+	}
 
 	// Update PID index
 	if c.PID() > 1 {
@@ -418,18 +643,21 @@ func (r *registry) updateContainerState(containerID string) {
 	for _, f := range r.watchers {
 		f(node)
 	}
+}
 
-	// And finally, ensure we gather stats for it
-	if r.collectStats {
-		if dockerContainer.State.Running {
-			if err := c.StartGatheringStats(r.client); err != nil {
-				log.Errorf("Error gathering stats for container %s: %s", containerID, err)
-				return
-			}
-		} else {
-			c.StopGatheringStats()
+// gatherStats samples the stats of the running containers in the
+// background.
+func (r *registry) gatherStats() {
+	r.RLock()
+	running := []Container{}
+	r.containers.Walk(func(_ string, c interface{}) bool {
+		if c.(Container).Container().State.Running {
+			running = append(running, c.(Container))
 		}
-	}
+		return false
+	})
+	r.RUnlock()
+	r.stats.collect(running)
 }
 
 func (r *registry) deleteContainer(containerID string) {
@@ -442,9 +670,6 @@ func (r *registry) deleteContainer(containerID string) {
 
 	r.containers.Delete(containerID)
 	delete(r.containersByPID, container.PID())
-	if r.collectStats {
-		container.StopGatheringStats()
-	}
 }
 
 func (r *registry) sendDeletedUpdate(containerID string) {
@@ -539,6 +764,14 @@ func (r *registry) GetImageTags() map[string][]string {
 	return r.userDefinedImageTags.tags
 }
 
+// GetImageProvenance returns where the image with the given ID was obtained
+// from, one entry per repository, sorted by repository.
+func (r *registry) GetImageProvenance(imageID string) []ImageProvenance {
+	r.RLock()
+	defer r.RUnlock()
+	return sortedProvenance(r.provenance[imageID])
+}
+
 // WalkImages runs f on every image of running containers the registry
 // knows of.  f may be run on the same image more than once.
 func (r *registry) WalkImages(f func(docker_client.APIImages)) {
@@ -569,3 +802,14 @@ func (r *registry) WalkNetworks(f func(docker_client.Network)) {
 		f(network)
 	}
 }
+
+// WalkSwarmServices runs f on every Swarm service the registry knows of.
+// There are none unless this host is a swarm manager.
+func (r *registry) WalkSwarmServices(f func(SwarmService)) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, service := range r.swarmServices {
+		f(service)
+	}
+}
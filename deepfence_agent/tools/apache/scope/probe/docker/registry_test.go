@@ -5,10 +5,12 @@ import (
 	"net"
 	"runtime"
 	"sort"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types/swarm"
 	client "github.com/fsouza/go-dockerclient"
 
 	"github.com/weaveworks/common/mtime"
@@ -34,6 +36,10 @@ type mockContainer struct {
 
 func (c *mockContainer) UpdateState(_ *client.Container) {}
 
+func (c *mockContainer) MarkOOMKilled() {}
+
+func (c *mockContainer) RecordExit(*client.APIEvents) {}
+
 func (c *mockContainer) ID() string {
 	return c.c.ID
 }
@@ -58,12 +64,10 @@ func (c *mockContainer) StateString() string {
 	return report.StateRunning
 }
 
-func (c *mockContainer) StartGatheringStats(docker.StatsGatherer) error {
+func (c *mockContainer) GatherStats(docker.StatsGatherer) error {
 	return nil
 }
 
-func (c *mockContainer) StopGatheringStats() {}
-
 func (c *mockContainer) GetNode() report.Node {
 	return report.MakeNodeWith(report.MakeContainerNodeID(c.c.ID), map[string]string{
 		docker.ContainerID:   c.c.ID,
@@ -93,6 +97,9 @@ type mockDockerClient struct {
 	containers    map[string]*client.Container
 	apiImages     []client.APIImages
 	networks      []client.Network
+	swarmInfo     swarm.Info
+	services      []swarm.Service
+	tasks         []swarm.Task
 	events        []chan<- *client.APIEvents
 }
 
@@ -124,6 +131,24 @@ func (m *mockDockerClient) ListNetworks() ([]client.Network, error) {
 	return m.networks, nil
 }
 
+func (m *mockDockerClient) Info() (*client.DockerInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return &client.DockerInfo{Swarm: m.swarmInfo}, nil
+}
+
+func (m *mockDockerClient) ListServices(client.ListServicesOptions) ([]swarm.Service, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.services, nil
+}
+
+func (m *mockDockerClient) ListTasks(client.ListTasksOptions) ([]swarm.Task, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.tasks, nil
+}
+
 func (m *mockDockerClient) AddEventListener(events chan<- *client.APIEvents) error {
 	m.Lock()
 	defer m.Unlock()
@@ -299,7 +324,7 @@ func setupStubs(mdc *mockDockerClient, f func()) {
 	oldDockerClient, oldNewContainer := docker.NewDockerClientStub, docker.NewContainerStub
 	defer func() { docker.NewDockerClientStub, docker.NewContainerStub = oldDockerClient, oldNewContainer }()
 
-	docker.NewDockerClientStub = func(endpoint string) (docker.Client, error) {
+	docker.NewDockerClientStub = func(endpoint string, _ docker.TLSOptions) (docker.Client, error) {
 		return mdc, nil
 	}
 
@@ -501,6 +526,8 @@ func TestRegistryDelete(t *testing.T) {
 				report.MakeNodeWith(report.MakeContainerNodeID("ping"), map[string]string{
 					docker.ContainerID:    "ping",
 					docker.ContainerState: "deleted",
+					docker.IsUiVm:         "false",
+					docker.UserDfndTags:   "",
 				}),
 			}
 			test.Poll(t, 100*time.Millisecond, want, func() interface{} {
@@ -516,3 +543,232 @@ func TestRegistryDelete(t *testing.T) {
 		}
 	})
 }
+
+// gatheringContainer signals gathered when its stats are gathered.
+type gatheringContainer struct {
+	mockContainer
+	gathered chan struct{}
+}
+
+func (c *gatheringContainer) GatherStats(docker.StatsGatherer) error {
+	select {
+	case c.gathered <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestRegistryStatsInterval(t *testing.T) {
+	mdc := newMockClient()
+	setupStubs(mdc, func() {
+		gathered := make(chan struct{}, 1)
+		docker.NewContainerStub = func(c *client.Container, _ string, _ bool, _ bool) docker.Container {
+			return &gatheringContainer{mockContainer{c}, gathered}
+		}
+		// With no stats interval, stats are sampled every interval
+		registry, err := docker.NewRegistry(docker.RegistryOptions{
+			Interval:        10 * time.Millisecond,
+			CollectStats:    true,
+			HandlerRegistry: controls.NewDefaultHandlerRegistry(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer registry.Stop()
+
+		select {
+		case <-gathered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the stats of containers to be gathered")
+		}
+	})
+}
+
+func TestDockerClientTLSRequiresCA(t *testing.T) {
+	_, err := docker.NewDockerClientStub("tcp://127.0.0.1:2376", docker.TLSOptions{Cert: "cert.pem", Key: "key.pem"})
+	if err == nil {
+		t.Fatal("Expected an error when no CA is given for a TLS endpoint")
+	}
+}
+
+func TestRegistryContainerExits(t *testing.T) {
+	mdc := newMockClient()
+	setupStubs(mdc, func() {
+		docker.NewContainerStub = docker.NewContainer
+		registry := testRegistry()
+		defer registry.Stop()
+		runtime.Gosched()
+
+		exit := func(exitCode int, finishedAt time.Time) {
+			exited := *container1
+			exited.State = client.State{ExitCode: exitCode, FinishedAt: finishedAt}
+			mdc.Lock()
+			mdc.containers["ping"] = &exited
+			mdc.Unlock()
+		}
+		die := func(exitCode int) *client.APIEvents {
+			return &client.APIEvents{Status: docker.DieEvent, ID: "ping", Actor: client.APIActor{
+				ID:         "ping",
+				Attributes: map[string]string{"exitCode": strconv.Itoa(exitCode)},
+			}}
+		}
+		check := func(want map[string]string, wantHistory int) {
+			keys := []string{docker.ContainerOOMKilled, docker.ContainerLastExitCode, docker.ContainerLastExitTime}
+			test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+				have := map[string]string{}
+				c, ok := registry.GetContainer("ping")
+				if !ok {
+					return have
+				}
+				node := c.GetNode()
+				for _, k := range keys {
+					if v, ok := node.Latest.Lookup(k); ok {
+						have[k] = v
+					}
+				}
+				return have
+			})
+			c, _ := registry.GetContainer("ping")
+			rows, _ := c.GetNode().ExtractTable(docker.ContainerTableTemplates[docker.ContainerExitHistoryTablePrefix])
+			if len(rows) != wantHistory {
+				t.Errorf("Expected %d exits in history, got %d: %v", wantHistory, len(rows), rows)
+			}
+		}
+
+		// The OOM killer hits the container, which then dies.
+		exit(137, startTime.Add(time.Minute))
+		mdc.send(&client.APIEvents{Status: docker.OOMEvent, ID: "ping"})
+		mdc.send(die(137))
+		check(map[string]string{
+			docker.ContainerOOMKilled:    "true",
+			docker.ContainerLastExitCode: "137",
+			docker.ContainerLastExitTime: startTime.Add(time.Minute).Format(time.RFC3339Nano),
+		}, 1)
+
+		// After a restart, the OOM kill is still reported alongside later exits.
+		mdc.Lock()
+		mdc.containers["ping"] = container1
+		mdc.Unlock()
+		mdc.send(&client.APIEvents{Status: docker.StartEvent, ID: "ping"})
+		exit(1, startTime.Add(2*time.Minute))
+		mdc.send(die(1))
+		check(map[string]string{
+			docker.ContainerOOMKilled:    "true",
+			docker.ContainerLastExitCode: "1",
+			docker.ContainerLastExitTime: startTime.Add(2 * time.Minute).Format(time.RFC3339Nano),
+		}, 2)
+
+		// Only the last few exits are kept, but the OOM kill is remembered.
+		for i := 3; i <= 7; i++ {
+			exit(i, startTime.Add(time.Duration(i)*time.Minute))
+			mdc.send(die(i))
+			wantHistory := i
+			if wantHistory > 5 {
+				wantHistory = 5
+			}
+			check(map[string]string{
+				docker.ContainerOOMKilled:    "true",
+				docker.ContainerLastExitCode: strconv.Itoa(i),
+				docker.ContainerLastExitTime: startTime.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+			}, wantHistory)
+		}
+
+		// The container is restarted before it's inspected on dying, so the
+		// exit is only known of the event.
+		restarted := *container1
+		restarted.State.ExitCode = 0
+		mdc.Lock()
+		mdc.containers["ping"] = &restarted
+		mdc.Unlock()
+		dying := die(2)
+		dying.TimeNano = startTime.Add(8 * time.Minute).UnixNano()
+		mdc.send(dying)
+		check(map[string]string{
+			docker.ContainerOOMKilled:    "true",
+			docker.ContainerLastExitCode: "2",
+			docker.ContainerLastExitTime: startTime.Add(8 * time.Minute).UTC().Format(time.RFC3339Nano),
+		}, 5)
+	})
+}
+
+func TestRegistryImagePull(t *testing.T) {
+	mtime.NowForce(startTime)
+	defer mtime.NowReset()
+
+	// The same image is known under tags from two registries.
+	image := apiImage1
+	image.RepoTags = []string{"bang:latest", "quay.io/foo/bang:1.0"}
+	image.RepoDigests = []string{"quay.io/foo/bang@sha256:0123"}
+	mdc := newMockClient()
+	mdc.apiImages = []client.APIImages{image}
+
+	setupStubs(mdc, func() {
+		registry := testRegistry()
+		defer registry.Stop()
+
+		want := []docker.ImageProvenance{
+			{Repository: "bang", Registry: "docker.io", FirstSeen: startTime},
+			{Repository: "quay.io/foo/bang", Registry: "quay.io", FirstSeen: startTime},
+		}
+		test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+			return registry.GetImageProvenance("baz")
+		})
+
+		mtime.NowForce(startTime.Add(time.Hour))
+		mdc.send(&client.APIEvents{Status: docker.PullEvent, ID: "quay.io/foo/bang@sha256:0123"})
+		want = []docker.ImageProvenance{
+			{Repository: "bang", Registry: "docker.io", FirstSeen: startTime},
+			{Repository: "quay.io/foo/bang", Registry: "quay.io", FirstSeen: startTime, LastPulled: startTime.Add(time.Hour), PulledBy: "digest"},
+		}
+		test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+			return registry.GetImageProvenance("baz")
+		})
+
+		mtime.NowForce(startTime.Add(2 * time.Hour))
+		mdc.send(&client.APIEvents{Status: docker.PullEvent, ID: "docker.io/library/bang"})
+		want = []docker.ImageProvenance{
+			{Repository: "bang", Registry: "docker.io", FirstSeen: startTime, LastPulled: startTime.Add(2 * time.Hour), PulledBy: "tag"},
+			{Repository: "quay.io/foo/bang", Registry: "quay.io", FirstSeen: startTime, LastPulled: startTime.Add(time.Hour), PulledBy: "digest"},
+		}
+		test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+			return registry.GetImageProvenance("baz")
+		})
+	})
+}
+
+func TestRegistryLabelFilter(t *testing.T) {
+	infra := *container2
+	infra.State = client.State{Pid: 3, Running: true}
+	infra.Config = &client.Config{Labels: map[string]string{"role": "infra-dns"}}
+
+	mdc := newMockClient()
+	mdc.apiContainers = []client.APIContainers{apiContainer1, apiContainer2}
+	mdc.containers["wiff"] = &infra
+	setupStubs(mdc, func() {
+		registry, _ := docker.NewRegistry(docker.RegistryOptions{
+			Interval:        10 * time.Second,
+			HandlerRegistry: controls.NewDefaultHandlerRegistry(),
+			LabelFilter: docker.LabelFilter{
+				Exclude: docker.LabelSelectors{{Key: "role", Value: "infra-*"}},
+			},
+		})
+		defer registry.Stop()
+
+		want := []docker.Container{&mockContainer{container1}}
+		test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+			return allContainers(registry)
+		})
+		registry.LockedPIDLookup(func(lookup func(int) docker.Container) {
+			if c := lookup(infra.State.Pid); c != nil {
+				t.Errorf("Expected no container for pid %d, got %s", infra.State.Pid, c.ID())
+			}
+		})
+
+		// The filter is lifted, e.g. on a config reload
+		registry.SetLabelFilter(docker.LabelFilter{})
+		want = []docker.Container{&mockContainer{container1}, &mockContainer{&infra}}
+		test.Poll(t, 100*time.Millisecond, want, func() interface{} {
+			return allContainers(registry)
+		})
+	})
+}
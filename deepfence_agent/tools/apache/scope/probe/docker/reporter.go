@@ -10,6 +10,7 @@ import (
 
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,6 +36,7 @@ const (
 	ImageCreatedAt   = report.DockerImageCreatedAt
 	k8sClusterId     = report.KubernetesClusterId
 	k8sClusterName   = report.KubernetesClusterName
+	Connected        = report.DockerConnected
 )
 
 // Exposed for testing
@@ -56,6 +58,19 @@ var (
 		ImageID:           {ID: ImageID, Label: "Image ID", From: report.FromLatest, Truncate: 12, Priority: 14},
 		k8sClusterId:      {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 15},
 		k8sClusterName:    {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 16},
+
+		ContainerSecurityScore:  {ID: ContainerSecurityScore, Label: "Security score", From: report.FromLatest, Datatype: report.Number, Priority: 17},
+		ContainerPrivileged:     {ID: ContainerPrivileged, Label: "Privileged", From: report.FromLatest, Priority: 18},
+		ContainerHostNetwork:    {ID: ContainerHostNetwork, Label: "Host network", From: report.FromLatest, Priority: 19},
+		ContainerHostPID:        {ID: ContainerHostPID, Label: "Host PID", From: report.FromLatest, Priority: 20},
+		ContainerReadonlyRootfs: {ID: ContainerReadonlyRootfs, Label: "Read-only rootfs", From: report.FromLatest, Priority: 21},
+		ContainerCapAdd:         {ID: ContainerCapAdd, Label: "Added capabilities", From: report.FromSets, Priority: 22},
+		ContainerSecurityOpt:    {ID: ContainerSecurityOpt, Label: "Security options", From: report.FromSets, Priority: 23},
+
+		ContainerOOMKilled:    {ID: ContainerOOMKilled, Label: "OOM killed", From: report.FromLatest, Priority: 24},
+		ContainerLastExitCode: {ID: ContainerLastExitCode, Label: "Last exit code", From: report.FromLatest, Datatype: report.Number, Priority: 25},
+		ContainerLastExitTime: {ID: ContainerLastExitTime, Label: "Last exited", From: report.FromLatest, Datatype: report.DateTime, Priority: 26},
+		ImageRegistry:         {ID: ImageRegistry, Label: "Image registry", From: report.FromLatest, Priority: 27},
 	}
 
 	ContainerMetricTemplates = report.MetricTemplates{
@@ -100,6 +115,17 @@ var (
 			Type:   report.PropertyListType,
 			Prefix: EnvPrefix,
 		},
+		ContainerExitHistoryTablePrefix: {
+			ID:     ContainerExitHistoryTablePrefix,
+			Label:  "Exit history",
+			Type:   report.MulticolumnTableType,
+			Prefix: ContainerExitHistoryTablePrefix,
+			Columns: []report.Column{
+				{ID: ContainerExitFinishedAt, Label: "Exited", DataType: report.DateTime},
+				{ID: ContainerExitCode, Label: "Exit code", DataType: report.Number},
+				{ID: ContainerExitOOMKilled, Label: "OOM killed"},
+			},
+		},
 	}
 
 	ContainerImageTableTemplates = report.TableTemplates{
@@ -109,11 +135,32 @@ var (
 			Type:   report.PropertyListType,
 			Prefix: ImageLabelPrefix,
 		},
+		ImageProvenanceTablePrefix: {
+			ID:     ImageProvenanceTablePrefix,
+			Label:  "Provenance",
+			Type:   report.MulticolumnTableType,
+			Prefix: ImageProvenanceTablePrefix,
+			Columns: []report.Column{
+				{ID: ImageProvenanceRegistry, Label: "Registry"},
+				{ID: ImageProvenanceFirstSeen, Label: "First seen", DataType: report.DateTime},
+				{ID: ImageProvenanceLastPulled, Label: "Last pulled", DataType: report.DateTime},
+				{ID: ImageProvenancePulledBy, Label: "Pulled by"},
+			},
+		},
+	}
+
+	HostMetadataTemplates = report.MetadataTemplates{
+		Connected: {ID: Connected, Label: "Docker connected", From: report.FromLatest, Priority: 20},
 	}
 
 	SwarmServiceMetadataTemplates = report.MetadataTemplates{
-		ServiceName:    {ID: ServiceName, Label: "Service name", From: report.FromLatest, Priority: 0},
-		StackNamespace: {ID: StackNamespace, Label: "Stack namespace", From: report.FromLatest, Priority: 1},
+		ServiceName:          {ID: ServiceName, Label: "Service name", From: report.FromLatest, Priority: 0},
+		StackNamespace:       {ID: StackNamespace, Label: "Stack namespace", From: report.FromLatest, Priority: 1},
+		SwarmServiceImage:    {ID: SwarmServiceImage, Label: "Image", From: report.FromLatest, Priority: 2},
+		SwarmServiceMode:     {ID: SwarmServiceMode, Label: "Mode", From: report.FromLatest, Priority: 3},
+		SwarmServiceReplicas: {ID: SwarmServiceReplicas, Label: "Desired replicas", From: report.FromLatest, Datatype: report.Number, Priority: 4},
+		SwarmServiceRunning:  {ID: SwarmServiceRunning, Label: "Running replicas", From: report.FromLatest, Datatype: report.Number, Priority: 5},
+		SwarmServiceUpdate:   {ID: SwarmServiceUpdate, Label: "Update status", From: report.FromLatest, Priority: 6},
 	}
 )
 
@@ -167,6 +214,10 @@ func (r *Reporter) Report() (report.Report, error) {
 	}
 
 	result := report.MakeReport()
+	result.Host = result.Host.Merge(r.hostTopology())
+	if !r.registry.Connected() {
+		return result, nil
+	}
 	result.Container = result.Container.Merge(r.containerTopology(localAddrs))
 	result.ContainerImage = result.ContainerImage.Merge(r.containerImageTopology())
 	result.Overlay = result.Overlay.Merge(r.overlayTopology())
@@ -200,6 +251,10 @@ func (r *Reporter) containerTopology(localAddrs []net.IP) report.Topology {
 	r.registry.WalkContainers(func(c Container) {
 		nodes = append(nodes, c.GetNode().WithLatests(metadata))
 	})
+	canScan := r.registry.CanScanImages()
+	if canScan {
+		result.Controls.AddControl(scanVulnerabilitiesControl)
+	}
 
 	// Copy the IP addresses from other containers where they share network
 	// namespaces & deal with containers in the host net namespace.  This
@@ -253,6 +308,14 @@ func (r *Reporter) containerTopology(localAddrs []net.IP) report.Topology {
 			if isInHostNamespace {
 				latest[IsInHostNetwork] = "true"
 			}
+			if imageID, ok := node.Latest.Lookup(ImageID); ok {
+				if status, ok := r.registry.ImageScanStatus(imageID); ok {
+					node = node.WithScanStatus(status)
+				}
+				if registries := imageRegistries(r.registry.GetImageProvenance(imageID)); len(registries) > 0 {
+					latest[ImageRegistry] = strings.Join(registries, ",")
+				}
+			}
 			if r.kubernetesClusterName != "" {
 				latest[k8sClusterName] = r.kubernetesClusterName
 			}
@@ -260,6 +323,9 @@ func (r *Reporter) containerTopology(localAddrs []net.IP) report.Topology {
 				latest[k8sClusterId] = r.kubernetesClusterId
 			}
 			node = node.WithLatests(latest)
+			if canScan {
+				node = node.WithLatestActiveControls(ScanVulnerabilities)
+			}
 			result.AddNode(node)
 
 		}
@@ -268,11 +334,26 @@ func (r *Reporter) containerTopology(localAddrs []net.IP) report.Topology {
 	return result
 }
 
+// hostTopology records on the host node whether we can reach docker, so a
+// broken docker connection is visible rather than looking like a host
+// without containers.
+func (r *Reporter) hostTopology() report.Topology {
+	result := report.MakeTopology().WithMetadataTemplates(HostMetadataTemplates)
+	result.AddNode(report.MakeNodeWith(report.MakeHostNodeID(r.hostID), map[string]string{
+		Connected: strconv.FormatBool(r.registry.Connected()),
+	}))
+	return result
+}
+
 func (r *Reporter) containerImageTopology() report.Topology {
 	result := report.MakeTopology().
 		WithMetadataTemplates(ContainerImageMetadataTemplates).
 		WithTableTemplates(ContainerImageTableTemplates)
 
+	canScan := r.registry.CanScanImages()
+	if canScan {
+		result.Controls.AddControl(scanVulnerabilitiesControl)
+	}
 	imageTagsMap := r.registry.GetImageTags()
 	r.registry.WalkImages(func(image docker_client.APIImages) {
 		imageID := trimImageID(image.ID)
@@ -299,6 +380,16 @@ func (r *Reporter) containerImageTopology() report.Topology {
 		latests[UserDfndTags] = strings.Join(tags, ",")
 		node := report.MakeNodeWith(nodeID, latests)
 		node = node.AddPrefixPropertyList(ImageLabelPrefix, image.Labels)
+		node = node.AddPrefixMulticolumnTable(ImageProvenanceTablePrefix, provenanceTable(r.registry.GetImageProvenance(imageID)))
+		repoTags, repoDigests := imageRepoTags(image)
+		node = node.WithSet(ImageRepoTags, repoTags).WithSet(ImageRepoDigests, repoDigests)
+		if status, ok := r.registry.ImageScanStatus(imageID); ok {
+			node = node.WithScanStatus(status)
+		}
+		if canScan {
+			node = node.WithLatests(map[string]string{report.ControlProbeID: r.probeID}).
+				WithLatestActiveControls(ScanVulnerabilities)
+		}
 		result.AddNode(node)
 	})
 
@@ -323,7 +414,11 @@ func (r *Reporter) overlayTopology() report.Topology {
 }
 
 func (r *Reporter) swarmServiceTopology() report.Topology {
-	return report.MakeTopology().WithMetadataTemplates(SwarmServiceMetadataTemplates)
+	result := report.MakeTopology().WithMetadataTemplates(SwarmServiceMetadataTemplates)
+	r.registry.WalkSwarmServices(func(service SwarmService) {
+		result.AddNode(service.node())
+	})
+	return result
 }
 
 // Docker sometimes prefixes ids with a "type" annotation, but it renders a bit
@@ -1,8 +1,11 @@
 package docker_test
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types/swarm"
 	client "github.com/fsouza/go-dockerclient"
 
 	"github.com/weaveworks/scope/probe/docker"
@@ -13,6 +16,9 @@ type mockRegistry struct {
 	containersByPID map[int]docker.Container
 	images          map[string]client.APIImages
 	networks        []client.Network
+	swarmServices   []docker.SwarmService
+	provenance      map[string][]docker.ImageProvenance
+	disconnected    bool
 }
 
 func (r *mockRegistry) Stop() {}
@@ -41,9 +47,22 @@ func (r *mockRegistry) WalkNetworks(f func(client.Network)) {
 	}
 }
 
+func (r *mockRegistry) WalkSwarmServices(f func(docker.SwarmService)) {
+	for _, s := range r.swarmServices {
+		f(s)
+	}
+}
+
 func (r *mockRegistry) WatchContainerUpdates(_ docker.ContainerUpdateWatcher) {}
 
-func (r *mockRegistry) GetContainer(_ string) (docker.Container, bool) { return nil, false }
+func (r *mockRegistry) GetContainer(id string) (docker.Container, bool) {
+	for _, c := range r.containersByPID {
+		if c.ID() == id {
+			return c, true
+		}
+	}
+	return nil, false
+}
 
 func (r *mockRegistry) GetContainerByPrefix(_ string) (docker.Container, bool) { return nil, false }
 
@@ -52,6 +71,24 @@ func (r *mockRegistry) GetContainerImage(id string) (client.APIImages, bool) {
 	return image, ok
 }
 
+func (r *mockRegistry) GetContainerTags() map[string][]string { return map[string][]string{} }
+
+func (r *mockRegistry) GetImageTags() map[string][]string { return map[string][]string{} }
+
+func (r *mockRegistry) GetImageProvenance(imageID string) []docker.ImageProvenance {
+	return r.provenance[imageID]
+}
+
+func (r *mockRegistry) Connected() bool { return !r.disconnected }
+
+func (r *mockRegistry) SetLabelFilter(docker.LabelFilter) {}
+
+func (r *mockRegistry) CanScanImages() bool { return false }
+
+func (r *mockRegistry) ImageScanStatus(string) (report.ScanStatus, bool) {
+	return report.ScanStatus{}, false
+}
+
 var (
 	imageID              = "baz"
 	mockRegistryInstance = &mockRegistry{
@@ -96,9 +133,9 @@ func TestReporter(t *testing.T) {
 			}
 		}
 
-		// container should have controls
-		if len(rpt.Container.Controls) == 0 {
-			t.Errorf("Container should have some controls")
+		// container should have no controls, as images can't be scanned
+		if len(rpt.Container.Controls) != 0 {
+			t.Errorf("Container should have no controls, got %v", rpt.Container.Controls)
 		}
 
 		// container should have the image as a parent
@@ -146,3 +183,161 @@ func TestReporter(t *testing.T) {
 
 	}
 }
+
+func TestReporterSwarmServices(t *testing.T) {
+	replicas := uint64(3)
+	registry := &mockRegistry{
+		swarmServices: []docker.SwarmService{
+			{
+				Service: swarm.Service{
+					ID: "svc1",
+					Spec: swarm.ServiceSpec{
+						Annotations: swarm.Annotations{
+							Name:   "shop_web",
+							Labels: map[string]string{"com.docker.stack.namespace": "shop"},
+						},
+						TaskTemplate: swarm.TaskSpec{
+							ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.21@sha256:deadbeef"},
+						},
+						Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+					},
+					UpdateStatus: &swarm.UpdateStatus{State: swarm.UpdateStateUpdating},
+				},
+				RunningTasks: 2,
+			},
+			{
+				Service: swarm.Service{
+					ID: "svc2",
+					Spec: swarm.ServiceSpec{
+						Annotations: swarm.Annotations{Name: "agent"},
+						Mode:        swarm.ServiceMode{Global: &swarm.GlobalService{}},
+					},
+				},
+			},
+		},
+	}
+
+	rpt, err := docker.NewReporter(registry, "host1", "a1b2c3d4", nil).Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]map[string]string{
+		"svc1": {
+			docker.ServiceName:          "web",
+			docker.StackNamespace:       "shop",
+			docker.SwarmServiceImage:    "nginx:1.21",
+			docker.SwarmServiceMode:     "replicated",
+			docker.SwarmServiceReplicas: "3",
+			docker.SwarmServiceRunning:  "2",
+			docker.SwarmServiceUpdate:   "updating",
+		},
+		"svc2": {
+			docker.ServiceName:         "agent",
+			docker.StackNamespace:      docker.DefaultNamespace,
+			docker.SwarmServiceMode:    "global",
+			docker.SwarmServiceRunning: "0",
+		},
+	} {
+		nodeID := report.MakeSwarmServiceNodeID(id)
+		node, ok := rpt.SwarmService.Nodes[nodeID]
+		if !ok {
+			t.Fatalf("Expected report to have swarm service %q, but not found", nodeID)
+		}
+		for k, v := range want {
+			if have, ok := node.Latest.Lookup(k); !ok || have != v {
+				t.Errorf("Expected swarm service %s latest %q: %q, got %q", nodeID, k, v, have)
+			}
+		}
+	}
+}
+
+func TestReporterDisconnected(t *testing.T) {
+	registry := &mockRegistry{
+		containersByPID: mockRegistryInstance.containersByPID,
+		disconnected:    true,
+	}
+	rpt, err := docker.NewReporter(registry, "host1", "a1b2c3d4", nil).Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostNodeID := report.MakeHostNodeID("host1")
+	if have, ok := rpt.Host.Nodes[hostNodeID].Latest.Lookup(docker.Connected); !ok || have != "false" {
+		t.Errorf("Expected host %s to have %s=false, got %q", hostNodeID, docker.Connected, have)
+	}
+	if len(rpt.Container.Nodes) != 0 {
+		t.Errorf("Expected no containers while disconnected, got %d", len(rpt.Container.Nodes))
+	}
+}
+
+func TestReporterImageProvenance(t *testing.T) {
+	firstSeen := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	registry := &mockRegistry{
+		containersByPID: mockRegistryInstance.containersByPID,
+		images:          mockRegistryInstance.images,
+		provenance: map[string][]docker.ImageProvenance{
+			imageID: {
+				{Repository: "bang", Registry: "docker.io", FirstSeen: firstSeen},
+				{Repository: "quay.io/foo/bang", Registry: "quay.io", FirstSeen: firstSeen, LastPulled: firstSeen.Add(time.Hour), PulledBy: "digest"},
+			},
+		},
+	}
+	rpt, err := docker.NewReporter(registry, "host1", "a1b2c3d4", nil).Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containerNodeID := report.MakeContainerNodeID("ping")
+	if have, ok := rpt.Container.Nodes[containerNodeID].Latest.Lookup(docker.ImageRegistry); !ok || have != "docker.io,quay.io" {
+		t.Errorf("Expected container %s to have %s=docker.io,quay.io, got %q", containerNodeID, docker.ImageRegistry, have)
+	}
+
+	containerImageNodeID := report.MakeContainerImageNodeID(imageID)
+	rows, _ := rpt.ContainerImage.Nodes[containerImageNodeID].ExtractTable(docker.ContainerImageTableTemplates[docker.ImageProvenanceTablePrefix])
+	want := []report.Row{
+		{
+			ID: "bang",
+			Entries: map[string]string{
+				docker.ImageProvenanceRegistry:  "docker.io",
+				docker.ImageProvenanceFirstSeen: firstSeen.Format(time.RFC3339Nano),
+			},
+		},
+		{
+			ID: "quay.io/foo/bang",
+			Entries: map[string]string{
+				docker.ImageProvenanceRegistry:   "quay.io",
+				docker.ImageProvenanceFirstSeen:  firstSeen.Format(time.RFC3339Nano),
+				docker.ImageProvenanceLastPulled: firstSeen.Add(time.Hour).Format(time.RFC3339Nano),
+				docker.ImageProvenancePulledBy:   "digest",
+			},
+		},
+	}
+	if !reflect.DeepEqual(want, rows) {
+		t.Errorf("Expected provenance table %v, got %v", want, rows)
+	}
+}
+
+func TestReporterImageRepoTags(t *testing.T) {
+	image := apiImage1
+	image.RepoTags = []string{"docker.io/library/nginx:1.19", "quay.io/foo/nginx:1.19", "<none>:<none>"}
+	image.RepoDigests = []string{"nginx@sha256:0123", "<none>@<none>"}
+	registry := &mockRegistry{
+		containersByPID: mockRegistryInstance.containersByPID,
+		images:          map[string]client.APIImages{imageID: image},
+	}
+	rpt, err := docker.NewReporter(registry, "host1", "a1b2c3d4", nil).Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := rpt.ContainerImage.Nodes[report.MakeContainerImageNodeID(imageID)]
+	for key, want := range map[string]report.StringSet{
+		docker.ImageRepoTags:    report.MakeStringSet("nginx:1.19", "quay.io/foo/nginx:1.19"),
+		docker.ImageRepoDigests: report.MakeStringSet("nginx@sha256:0123"),
+	} {
+		if have, _ := node.Sets.Lookup(key); !reflect.DeepEqual(want, have) {
+			t.Errorf("Expected %s %v, got %v", key, want, have)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	docker_client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// ScanVulnerabilities is the ID of the control asking the console to scan
+// the image of a container, or an image, for vulnerabilities.
+const ScanVulnerabilities = "scan_vulnerabilities"
+
+// ScanVulnerabilitiesSchema is the schema of the result of
+// ScanVulnerabilities: a ScanResult.
+const ScanVulnerabilitiesSchema = "scan/vulnerability/v1"
+
+const scanAPITimeout = 30 * time.Second
+
+var scanVulnerabilitiesControl = report.Control{
+	ID:    ScanVulnerabilities,
+	Human: "Scan for vulnerabilities",
+	Icon:  "fa fa-shield-alt",
+	Rank:  1,
+}
+
+// ScanAPIOptions locate the console's scan API, which ScanVulnerabilities
+// asks to scan images.  Without a URL the control isn't offered.
+type ScanAPIOptions struct {
+	URL      string
+	Token    string
+	Insecure bool
+}
+
+// ScanRequest is what the probe posts to the scan API: the image to scan,
+// by a reference the scanner can pull it with, and hints of the registry
+// credentials to pull it with.  It carries no credentials itself.
+type ScanRequest struct {
+	ImageID   string `json:"image_id"`
+	ImageName string `json:"image_name,omitempty"` // with its tag, as shown
+	Reference string `json:"reference"`            // by digest
+	Registry  string `json:"registry"`
+
+	// CredentialsKey is the key the credentials of Registry go by in docker
+	// credential stores and config.json, e.g. https://index.docker.io/v1/.
+	CredentialsKey string `json:"credentials_key"`
+
+	NodeID   string `json:"node_id"`
+	HostName string `json:"host_name"`
+}
+
+// ScanResult is the result of ScanVulnerabilities.  Images which are only
+// present locally, not in any registry, can't be pulled by the scanner, so
+// no scan is started and LocalScanRequired is set instead.
+type ScanResult struct {
+	ScanID            string `json:"scan_id,omitempty"`
+	ImageID           string `json:"image_id"`
+	Reference         string `json:"reference,omitempty"`
+	LocalScanRequired bool   `json:"local_scan_required,omitempty"`
+	Message           string `json:"message,omitempty"`
+}
+
+type scanAPIClient struct {
+	options ScanAPIOptions
+	client  *http.Client
+}
+
+func newScanAPIClient(options ScanAPIOptions) *scanAPIClient {
+	if options.URL == "" {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &scanAPIClient{
+		options: options,
+		client:  &http.Client{Transport: transport, Timeout: scanAPITimeout},
+	}
+}
+
+// startScan posts req to the scan API, returning the ID of the scan.
+func (c *scanAPIClient) startScan(req ScanRequest) (string, error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequest("POST", c.options.URL, bytes.NewReader(buf))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.options.Token != "" {
+		httpReq.Header.Set("deepfence-key", c.options.Token)
+	}
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("scan API: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var started struct {
+		ScanID string `json:"scan_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		return "", fmt.Errorf("scan API: decoding response: %v", err)
+	}
+	if started.ScanID == "" {
+		return "", fmt.Errorf("scan API: no scan ID in response")
+	}
+	return started.ScanID, nil
+}
+
+// scanRequest returns what to ask the scan API to scan image by, or false
+// if it is only present locally.  Images pulled from or pushed to a
+// registry have digests there; those built locally have none.
+func scanRequest(imageID string, image docker_client.APIImages) (ScanRequest, bool) {
+	var reference string
+	for _, ref := range image.RepoDigests {
+		if ref != "" && !strings.HasPrefix(ref, "<none>") {
+			reference = ref
+			break
+		}
+	}
+	if reference == "" {
+		return ScanRequest{}, false
+	}
+	repository, _ := splitReference(reference)
+	registry := registryHost(repository)
+	req := ScanRequest{
+		ImageID:        imageID,
+		Reference:      reference,
+		Registry:       registry,
+		CredentialsKey: registry,
+	}
+	if registry == defaultRegistry {
+		req.CredentialsKey = "https://index.docker.io/v1/"
+	}
+	for _, ref := range image.RepoTags {
+		if !strings.HasSuffix(ref, ":<none>") && !strings.HasPrefix(ref, "<none>") {
+			req.ImageName = ref
+			break
+		}
+	}
+	return req, true
+}
+
+// scanImageID returns the ID of the image to scan for a control request on
+// a container or image node.
+func (r *registry) scanImageID(nodeID string) (string, error) {
+	if imageID, ok := report.ParseContainerImageNodeID(nodeID); ok {
+		return trimImageID(imageID), nil
+	}
+	containerID, ok := report.ParseContainerNodeID(nodeID)
+	if !ok {
+		return "", fmt.Errorf("Invalid ID: %s", nodeID)
+	}
+	c, ok := r.GetContainer(containerID)
+	if !ok {
+		return "", fmt.Errorf("No container with ID %s", containerID)
+	}
+	return c.Image(), nil
+}
+
+func (r *registry) scanVulnerabilities(req xfer.Request) xfer.Response {
+	if r.scanAPI == nil {
+		return xfer.ResponseErrorf("No scan API configured on this probe")
+	}
+	imageID, err := r.scanImageID(req.NodeID)
+	if err != nil {
+		return xfer.ResponseError(err)
+	}
+	image, ok := r.GetContainerImage(imageID)
+	if !ok {
+		return xfer.ResponseErrorf("No image with ID %s", imageID)
+	}
+	scanReq, ok := scanRequest(imageID, image)
+	if !ok {
+		return xfer.ResponseResult(ScanVulnerabilitiesSchema, ScanResult{
+			ImageID:           imageID,
+			LocalScanRequired: true,
+			Message:           fmt.Sprintf("Image %s is only present locally, not in any registry: it needs a local scan", imageID),
+		})
+	}
+	scanReq.NodeID, scanReq.HostName = req.NodeID, r.hostID
+	scanID, err := r.scanAPI.startScan(scanReq)
+	if err != nil {
+		log.Warnf("Error starting vulnerability scan of %s: %v", scanReq.Reference, err)
+		return xfer.ResponseError(err)
+	}
+	log.Infof("Started vulnerability scan %s of %s", scanID, scanReq.Reference)
+
+	// It has no findings yet: merged with the status of the last scan in
+	// the app, it has the findings of that until the console reports
+	r.Lock()
+	r.scanStatuses[imageID] = report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusQueued,
+		LastScan: mtime.Now(),
+	}
+	r.Unlock()
+	return xfer.ResponseResult(ScanVulnerabilitiesSchema, ScanResult{
+		ScanID:    scanID,
+		ImageID:   imageID,
+		Reference: scanReq.Reference,
+	})
+}
+
+// CanScanImages says whether there's a scan API for ScanVulnerabilities.
+func (r *registry) CanScanImages() bool {
+	return r.scanAPI != nil
+}
+
+// ImageScanStatus returns the status of the vulnerability scan of an image
+// last started by the probe, which is queued until the console reports on
+// it, by a more recent status.
+func (r *registry) ImageScanStatus(imageID string) (report.ScanStatus, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	s, ok := r.scanStatuses[imageID]
+	return s, ok
+}
@@ -0,0 +1,149 @@
+package docker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+)
+
+func TestScanVulnerabilities(t *testing.T) {
+	var posted []docker.ScanRequest
+	scanAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("deepfence-key") != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req docker.ScanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, req)
+		json.NewEncoder(w).Encode(map[string]string{"scan_id": "scan-1"})
+	}))
+	defer scanAPI.Close()
+
+	pulled := apiImage1
+	pulled.RepoTags = []string{"quay.io/foo/bang:1.0"}
+	pulled.RepoDigests = []string{"quay.io/foo/bang@sha256:0123"}
+	local := client.APIImages{ID: "sha256:local", RepoTags: []string{"mine:latest"}}
+	mdc := newMockClient()
+	mdc.apiImages = []client.APIImages{pulled, local}
+
+	setupStubs(mdc, func() {
+		hr := controls.NewDefaultHandlerRegistry()
+		registry, _ := docker.NewRegistry(docker.RegistryOptions{
+			Interval:        10 * time.Second,
+			HandlerRegistry: hr,
+			HostID:          "host1",
+			ScanAPI:         docker.ScanAPIOptions{URL: scanAPI.URL, Token: "token"},
+		})
+		defer registry.Stop()
+		test.Poll(t, 100*time.Millisecond, 2, func() interface{} {
+			return len(allImages(registry))
+		})
+
+		// By the image of a container, as of an image
+		for _, nodeID := range []string{report.MakeContainerNodeID("ping"), report.MakeContainerImageNodeID("baz")} {
+			result := hr.HandleControlRequest(xfer.Request{
+				Control: docker.ScanVulnerabilities,
+				NodeID:  nodeID,
+			})
+			if result.Error != "" || result.ResultSchema != docker.ScanVulnerabilitiesSchema {
+				t.Fatal(result)
+			}
+			var scan docker.ScanResult
+			if err := json.Unmarshal(result.Result, &scan); err != nil {
+				t.Fatal(err)
+			}
+			if scan.ScanID != "scan-1" || scan.ImageID != "baz" || scan.LocalScanRequired {
+				t.Errorf("%s: unexpected result: %+v", nodeID, scan)
+			}
+		}
+		want := docker.ScanRequest{
+			ImageID:        "baz",
+			ImageName:      "quay.io/foo/bang:1.0",
+			Reference:      "quay.io/foo/bang@sha256:0123",
+			Registry:       "quay.io",
+			CredentialsKey: "quay.io",
+			NodeID:         report.MakeContainerImageNodeID("baz"),
+			HostName:       "host1",
+		}
+		if len(posted) != 2 || posted[1] != want {
+			t.Errorf("expected scans requested of %+v, got %+v", want, posted)
+		}
+		if status, ok := registry.ImageScanStatus("baz"); !ok || status.Type != report.VulnerabilityScan || status.Status != report.ScanStatusQueued {
+			t.Errorf("expected a queued scan, got %+v", status)
+		}
+
+		// Images not in any registry need scanning where they are
+		result := hr.HandleControlRequest(xfer.Request{
+			Control: docker.ScanVulnerabilities,
+			NodeID:  report.MakeContainerImageNodeID("local"),
+		})
+		var scan docker.ScanResult
+		if err := json.Unmarshal(result.Result, &scan); err != nil {
+			t.Fatal(err)
+		}
+		if !scan.LocalScanRequired || scan.ScanID != "" || len(posted) != 2 {
+			t.Errorf("expected a local scan to be required, got %+v", result)
+		}
+		if _, ok := registry.ImageScanStatus("local"); ok {
+			t.Error("expected no scan of a local image")
+		}
+
+		result = hr.HandleControlRequest(xfer.Request{
+			Control: docker.ScanVulnerabilities,
+			NodeID:  report.MakeContainerImageNodeID("missing"),
+		})
+		if result.Error == "" {
+			t.Errorf("expected an error scanning a missing image, got %+v", result)
+		}
+	})
+}
+
+func TestScanVulnerabilitiesAPIErrors(t *testing.T) {
+	scanAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "scanner busy", http.StatusServiceUnavailable)
+	}))
+	defer scanAPI.Close()
+
+	image := apiImage1
+	image.RepoDigests = []string{"bang@sha256:0123"}
+	mdc := newMockClient()
+	mdc.apiImages = []client.APIImages{image}
+
+	setupStubs(mdc, func() {
+		hr := controls.NewDefaultHandlerRegistry()
+		registry, _ := docker.NewRegistry(docker.RegistryOptions{
+			Interval:        10 * time.Second,
+			HandlerRegistry: hr,
+			ScanAPI:         docker.ScanAPIOptions{URL: scanAPI.URL},
+		})
+		defer registry.Stop()
+		test.Poll(t, 100*time.Millisecond, 1, func() interface{} {
+			return len(allImages(registry))
+		})
+
+		result := hr.HandleControlRequest(xfer.Request{
+			Control: docker.ScanVulnerabilities,
+			NodeID:  report.MakeContainerImageNodeID("baz"),
+		})
+		if result.Error == "" || result.Result != nil {
+			t.Errorf("expected the scan API's error, got %+v", result)
+		}
+		if _, ok := registry.ImageScanStatus("baz"); ok {
+			t.Error("expected no scan status after an error")
+		}
+	})
+}
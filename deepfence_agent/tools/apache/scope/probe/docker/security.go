@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Security posture keys, populated from docker inspect.
+const (
+	ContainerPrivileged     = report.DockerPrivileged
+	ContainerHostNetwork    = report.DockerHostNetwork
+	ContainerHostPID        = report.DockerHostPID
+	ContainerCapAdd         = report.DockerCapAdd
+	ContainerSecurityOpt    = report.DockerSecurityOpt
+	ContainerReadonlyRootfs = report.DockerReadonlyRootfs
+	ContainerSecurityScore  = report.DockerSecurityScore
+)
+
+// Penalties subtracted from a perfect score of 100 by SecurityScore.
+const (
+	maxSecurityScore          = 100
+	privilegedPenalty         = 50
+	hostNetworkPenalty        = 15
+	hostPIDPenalty            = 15
+	capAddPenalty             = 5
+	dangerousCapAddPenalty    = 20
+	unconfinedSeccompPenalty  = 10
+	unconfinedAppArmorPenalty = 10
+	writableRootfsPenalty     = 5
+)
+
+// Capabilities which on their own are close to equivalent to running
+// privileged.
+var dangerousCapabilities = map[string]struct{}{
+	"ALL":             {},
+	"SYS_ADMIN":       {},
+	"SYS_MODULE":      {},
+	"SYS_PTRACE":      {},
+	"SYS_RAWIO":       {},
+	"DAC_READ_SEARCH": {},
+	"NET_ADMIN":       {},
+}
+
+func isUnconfined(securityOpts []string, kind string) bool {
+	for _, opt := range securityOpts {
+		// docker accepts both "seccomp=unconfined" and the legacy "seccomp:unconfined"
+		opt = strings.Replace(opt, ":", "=", 1)
+		if opt == kind+"=unconfined" {
+			return true
+		}
+	}
+	return false
+}
+
+// SecurityScore summarises the security posture of a container as a number
+// between 0 (no isolation at all) and 100 (nothing weakened compared to the
+// docker defaults).
+func SecurityScore(c *docker.Container) int {
+	hostConfig := c.HostConfig
+	if hostConfig == nil {
+		return maxSecurityScore
+	}
+
+	score := maxSecurityScore
+	if hostConfig.Privileged {
+		score -= privilegedPenalty
+	}
+	if hostConfig.NetworkMode == "host" {
+		score -= hostNetworkPenalty
+	}
+	if hostConfig.PidMode == "host" {
+		score -= hostPIDPenalty
+	}
+	for _, capability := range hostConfig.CapAdd {
+		if _, ok := dangerousCapabilities[strings.TrimPrefix(strings.ToUpper(capability), "CAP_")]; ok {
+			score -= dangerousCapAddPenalty
+		} else {
+			score -= capAddPenalty
+		}
+	}
+	// Privileged containers run without seccomp and AppArmor confinement.
+	if hostConfig.Privileged || isUnconfined(hostConfig.SecurityOpt, "seccomp") {
+		score -= unconfinedSeccompPenalty
+	}
+	if hostConfig.Privileged || c.AppArmorProfile == "unconfined" || isUnconfined(hostConfig.SecurityOpt, "apparmor") {
+		score -= unconfinedAppArmorPenalty
+	}
+	if !hostConfig.ReadonlyRootfs {
+		score -= writableRootfsPenalty
+	}
+
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// withSecurityPosture adds the security posture fields of c to node.
+func withSecurityPosture(node report.Node, c *docker.Container) report.Node {
+	node = node.WithLatests(map[string]string{
+		ContainerSecurityScore: strconv.Itoa(SecurityScore(c)),
+	})
+	hostConfig := c.HostConfig
+	if hostConfig == nil {
+		return node
+	}
+	node = node.WithLatests(map[string]string{
+		ContainerPrivileged:     strconv.FormatBool(hostConfig.Privileged),
+		ContainerHostNetwork:    strconv.FormatBool(hostConfig.NetworkMode == "host"),
+		ContainerHostPID:        strconv.FormatBool(hostConfig.PidMode == "host"),
+		ContainerReadonlyRootfs: strconv.FormatBool(hostConfig.ReadonlyRootfs),
+	})
+	if len(hostConfig.CapAdd) > 0 {
+		node = node.WithSet(ContainerCapAdd, report.MakeStringSet(hostConfig.CapAdd...))
+	}
+	if len(hostConfig.SecurityOpt) > 0 {
+		node = node.WithSet(ContainerSecurityOpt, report.MakeStringSet(hostConfig.SecurityOpt...))
+	}
+	return node
+}
@@ -0,0 +1,112 @@
+package docker_test
+
+import (
+	"testing"
+
+	client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/scope/probe/docker"
+)
+
+func TestSecurityScore(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		container *client.Container
+		want      int
+	}{
+		{
+			name:      "no host config",
+			container: &client.Container{},
+			want:      100,
+		},
+		{
+			name: "read-only defaults",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{ReadonlyRootfs: true},
+			},
+			want: 100,
+		},
+		{
+			name: "docker run defaults",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{NetworkMode: "bridge"},
+			},
+			want: 95,
+		},
+		{
+			name: "privileged",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{Privileged: true},
+			},
+			want: 25,
+		},
+		{
+			name: "host namespaces",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{NetworkMode: "host", PidMode: "host", ReadonlyRootfs: true},
+			},
+			want: 70,
+		},
+		{
+			name: "added capabilities",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{CapAdd: []string{"NET_BIND_SERVICE", "CAP_SYS_ADMIN"}, ReadonlyRootfs: true},
+			},
+			want: 75,
+		},
+		{
+			name: "unconfined profiles",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{SecurityOpt: []string{"seccomp:unconfined", "apparmor=unconfined"}, ReadonlyRootfs: true},
+			},
+			want: 80,
+		},
+		{
+			name: "everything",
+			container: &client.Container{
+				HostConfig: &client.HostConfig{
+					Privileged:  true,
+					NetworkMode: "host",
+					PidMode:     "host",
+					CapAdd:      []string{"ALL"},
+				},
+			},
+			want: 0,
+		},
+	} {
+		if have := docker.SecurityScore(tc.container); have != tc.want {
+			t.Errorf("%s: expected score %d, got %d", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestContainerSecurityPosture(t *testing.T) {
+	c := docker.NewContainer(&client.Container{
+		ID:     "secure",
+		Config: &client.Config{},
+		HostConfig: &client.HostConfig{
+			NetworkMode: "host",
+			CapAdd:      []string{"NET_ADMIN"},
+			SecurityOpt: []string{"no-new-privileges"},
+		},
+	}, "scope", false, false)
+	node := c.GetNode()
+
+	for k, want := range map[string]string{
+		docker.ContainerPrivileged:     "false",
+		docker.ContainerHostNetwork:    "true",
+		docker.ContainerHostPID:        "false",
+		docker.ContainerReadonlyRootfs: "false",
+		docker.ContainerSecurityScore:  "60",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected latest %q: %q, got %q", k, want, have)
+		}
+	}
+	if have, ok := node.Sets.Lookup(docker.ContainerCapAdd); !ok || !have.Contains("NET_ADMIN") {
+		t.Errorf("Expected cap_add to contain NET_ADMIN, got %v", have)
+	}
+	if have, ok := node.Sets.Lookup(docker.ContainerSecurityOpt); !ok || !have.Contains("no-new-privileges") {
+		t.Errorf("Expected security_opt to contain no-new-privileges, got %v", have)
+	}
+}
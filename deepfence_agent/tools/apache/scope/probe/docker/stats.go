@@ -0,0 +1,161 @@
+package docker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+)
+
+const (
+	// statsWorkers bounds the number of concurrent stats queries to dockerd.
+	statsWorkers = 8
+	// statsTimeout bounds a single stats query.
+	statsTimeout = 10 * time.Second
+	// maxStatsBackoff bounds how long we stop sampling a container whose
+	// stats keep failing.
+	maxStatsBackoff = 5 * time.Minute
+)
+
+// statsCollector periodically gathers one-shot stats for running
+// containers, instead of keeping a stats stream open per container.
+// Queries are spread over a bounded pool of workers, containers whose
+// queries fail are backed off exponentially, and if maxContainers is set
+// only that many containers are sampled per cycle, round-robin.
+type statsCollector struct {
+	sync.Mutex
+	client        StatsGatherer
+	workers       int
+	maxContainers int
+	interval      time.Duration
+	cursor        string // ID of the last container sampled
+	backoff       map[string]statsBackoff
+	busy          chan struct{}
+}
+
+type statsBackoff struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+func newStatsCollector(client StatsGatherer, workers, maxContainers int, interval time.Duration) *statsCollector {
+	return &statsCollector{
+		client:        client,
+		workers:       workers,
+		maxContainers: maxContainers,
+		interval:      interval,
+		backoff:       map[string]statsBackoff{},
+		busy:          make(chan struct{}, 1),
+	}
+}
+
+// schedule returns the IDs of the containers to sample this cycle, out of
+// the running containers ids.  Must be called with the lock held.
+func (s *statsCollector) schedule(ids []string, now time.Time) []string {
+	sort.Strings(ids)
+
+	// Forget about containers which went away.
+	running := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		running[id] = struct{}{}
+	}
+	for id := range s.backoff {
+		if _, ok := running[id]; !ok {
+			delete(s.backoff, id)
+		}
+	}
+
+	eligible := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if b, ok := s.backoff[id]; ok && now.Before(b.nextAttempt) {
+			continue
+		}
+		eligible = append(eligible, id)
+	}
+	if s.maxContainers <= 0 || len(eligible) <= s.maxContainers {
+		return eligible
+	}
+
+	// Carry on after the last container sampled in the previous cycle, so
+	// every container gets its turn even as containers come and go.
+	start := sort.Search(len(eligible), func(i int) bool { return eligible[i] > s.cursor })
+	picked := make([]string, 0, s.maxContainers)
+	for i := 0; i < s.maxContainers; i++ {
+		picked = append(picked, eligible[(start+i)%len(eligible)])
+	}
+	s.cursor = picked[len(picked)-1]
+	return picked
+}
+
+// recordResult updates the backoff of container id after a stats query.
+// Must be called with the lock held.
+func (s *statsCollector) recordResult(id string, err error, now time.Time) {
+	if err == nil {
+		delete(s.backoff, id)
+		return
+	}
+	b := s.backoff[id]
+	b.failures++
+	delay := maxStatsBackoff
+	if b.failures < 16 {
+		if d := s.interval << uint(b.failures-1); d < maxStatsBackoff {
+			delay = d
+		}
+	}
+	b.nextAttempt = now.Add(delay)
+	s.backoff[id] = b
+}
+
+// collect starts a cycle of stats gathering in the background, unless the
+// previous one is still in progress.
+func (s *statsCollector) collect(containers []Container) {
+	select {
+	case s.busy <- struct{}{}:
+	default:
+		log.Warnf("docker container: still collecting stats from the previous cycle, skipping")
+		return
+	}
+	go func() {
+		defer func() { <-s.busy }()
+		s.gather(containers)
+	}()
+}
+
+// gather samples the scheduled containers and waits for all queries to
+// complete.
+func (s *statsCollector) gather(containers []Container) {
+	byID := make(map[string]Container, len(containers))
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		byID[c.ID()] = c
+		ids = append(ids, c.ID())
+	}
+
+	s.Lock()
+	scheduled := s.schedule(ids, mtime.Now())
+	s.Unlock()
+
+	work := make(chan Container)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				err := c.GatherStats(s.client)
+				if err != nil {
+					log.WithField("container_id", c.ID()).Debugf("docker container: error collecting stats for %s: %v", c.ID(), err)
+				}
+				s.Lock()
+				s.recordResult(c.ID(), err, mtime.Now())
+				s.Unlock()
+			}
+		}()
+	}
+	for _, id := range scheduled {
+		work <- byID[id]
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestStatsScheduleRoundRobin(t *testing.T) {
+	s := newStatsCollector(nil, statsWorkers, 2, time.Second)
+	now := time.Now()
+	ids := []string{"e", "a", "c", "b", "d"}
+
+	for _, want := range [][]string{
+		{"a", "b"},
+		{"c", "d"},
+		{"e", "a"},
+		{"b", "c"},
+		{"d", "e"},
+	} {
+		if have := s.schedule(ids, now); !reflect.DeepEqual(want, have) {
+			t.Errorf("Expected %v, got %v", want, have)
+		}
+	}
+
+	// A container going away doesn't make anyone lose their turn.
+	ids = []string{"a", "b", "d", "e"}
+	if have, want := s.schedule(ids, now), []string{"a", "b"}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	if have, want := s.schedule(ids, now), []string{"d", "e"}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+}
+
+func TestStatsScheduleFairness(t *testing.T) {
+	s := newStatsCollector(nil, statsWorkers, 3, time.Second)
+	now := time.Now()
+	ids := []string{}
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("container%d", i))
+	}
+
+	counts := map[string]int{}
+	for cycle := 0; cycle < 10; cycle++ {
+		for _, id := range s.schedule(ids, now) {
+			counts[id]++
+		}
+	}
+	for _, id := range ids {
+		if counts[id] != 3 {
+			t.Errorf("Expected %s to be sampled 3 times in 10 cycles, got %d", id, counts[id])
+		}
+	}
+}
+
+func TestStatsScheduleBackoff(t *testing.T) {
+	s := newStatsCollector(nil, statsWorkers, 0, time.Second)
+	now := time.Now()
+	ids := []string{"bad", "good"}
+	err := fmt.Errorf("no stats")
+
+	s.recordResult("bad", err, now)
+	s.recordResult("bad", err, now)
+	s.recordResult("good", nil, now)
+
+	// Two failures: backed off for two intervals.
+	for _, tc := range []struct {
+		at   time.Duration
+		want []string
+	}{
+		{0, []string{"good"}},
+		{time.Second, []string{"good"}},
+		{2 * time.Second, []string{"bad", "good"}},
+	} {
+		if have := s.schedule(ids, now.Add(tc.at)); !reflect.DeepEqual(tc.want, have) {
+			t.Errorf("At %v: expected %v, got %v", tc.at, tc.want, have)
+		}
+	}
+
+	// A success resets the backoff.
+	s.recordResult("bad", nil, now)
+	if have, want := s.schedule(ids, now), ids; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+
+	// The backoff is bounded.
+	for i := 0; i < 100; i++ {
+		s.recordResult("bad", err, now)
+	}
+	if have, want := s.schedule(ids, now.Add(maxStatsBackoff)), ids; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+
+	// Containers which went away are forgotten.
+	s.schedule([]string{"good"}, now)
+	if _, ok := s.backoff["bad"]; ok {
+		t.Errorf("Expected backoff of removed container to be dropped")
+	}
+}
+
+// cpuStatsGatherer returns ever increasing cumulative CPU counters, and
+// records how many queries are in flight at once.
+type cpuStatsGatherer struct {
+	sync.Mutex
+	inFlight, maxInFlight int
+	reads                 map[string]int
+}
+
+func (g *cpuStatsGatherer) Stats(opts docker.StatsOptions) error {
+	g.Lock()
+	g.inFlight++
+	if g.inFlight > g.maxInFlight {
+		g.maxInFlight = g.inFlight
+	}
+	g.reads[opts.ID]++
+	n := uint64(g.reads[opts.ID])
+	g.Unlock()
+
+	time.Sleep(time.Millisecond)
+	stats := &docker.Stats{Read: time.Unix(int64(n)*60, 0)}
+	// A quarter of the host's CPU time since the last sample, however long
+	// ago that was.
+	stats.CPUStats.CPUUsage.TotalUsage = n * 1000
+	stats.CPUStats.SystemCPUUsage = n * 4000
+	opts.Stats <- stats
+	close(opts.Stats)
+
+	g.Lock()
+	g.inFlight--
+	g.Unlock()
+	return nil
+}
+
+func TestStatsGather(t *testing.T) {
+	gatherer := &cpuStatsGatherer{reads: map[string]int{}}
+	s := newStatsCollector(gatherer, 2, 0, time.Second)
+	containers := []Container{}
+	for i := 0; i < 6; i++ {
+		containers = append(containers, NewContainer(&docker.Container{
+			ID:     fmt.Sprintf("container%d", i),
+			Config: &docker.Config{},
+			State:  docker.State{Running: true},
+		}, "scope", false, false))
+	}
+
+	s.gather(containers)
+	s.gather(containers)
+
+	if gatherer.maxInFlight > 2 {
+		t.Errorf("Expected at most 2 concurrent stats queries, got %d", gatherer.maxInFlight)
+	}
+	for _, c := range containers {
+		metric, ok := c.GetNode().Metrics.Lookup(CPUTotalUsage)
+		if !ok {
+			t.Fatalf("Expected %s to have a CPU metric", c.ID())
+		}
+		if sample, ok := metric.LastSample(); metric.Len() != 1 || !ok || sample.Value != 25.0 {
+			t.Errorf("Expected %s to have a single 25%% CPU sample, got %v", c.ID(), metric)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+	docker_client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Node metadata keys for Swarm services.
+const (
+	SwarmServiceImage    = report.DockerSwarmServiceImage
+	SwarmServiceMode     = report.DockerSwarmServiceMode
+	SwarmServiceReplicas = report.DockerSwarmServiceReplicas
+	SwarmServiceRunning  = report.DockerSwarmServiceRunning
+	SwarmServiceUpdate   = report.DockerSwarmServiceUpdate
+
+	swarmServiceIDLabel   = "com.docker.swarm.service.id"
+	swarmServiceNameLabel = "com.docker.swarm.service.name"
+	stackNamespaceLabel   = "com.docker.stack.namespace"
+
+	swarmModeReplicated = "replicated"
+	swarmModeGlobal     = "global"
+)
+
+// SwarmService is a Swarm service together with the number of its tasks
+// which are currently running.
+type SwarmService struct {
+	swarm.Service
+	RunningTasks int
+}
+
+// isSwarmManager returns true if this docker daemon is an active member of
+// a swarm and is able to answer queries about services.  Workers have no
+// access to the service list; their containers are still grouped into
+// services by the Tagger using the swarm labels.
+func isSwarmManager(info *docker_client.DockerInfo) bool {
+	return info.Swarm.LocalNodeState == swarm.LocalNodeStateActive && info.Swarm.ControlAvailable
+}
+
+// stackServiceName returns the stack namespace and the name of the service
+// within the stack, given the service labels and its full name.
+func stackServiceName(labels map[string]string, serviceName string) (string, string) {
+	stackNamespace, ok := labels[stackNamespaceLabel]
+	if !ok {
+		return DefaultNamespace, serviceName
+	}
+	return stackNamespace, strings.TrimPrefix(serviceName, stackNamespace+"_")
+}
+
+// swarmServiceImage strips the pinned digest docker adds to service images.
+func swarmServiceImage(service swarm.Service) string {
+	if service.Spec.TaskTemplate.ContainerSpec == nil {
+		return ""
+	}
+	return strings.SplitN(service.Spec.TaskTemplate.ContainerSpec.Image, "@", 2)[0]
+}
+
+func (s SwarmService) node() report.Node {
+	stackNamespace, serviceName := stackServiceName(s.Spec.Labels, s.Spec.Name)
+	latests := map[string]string{
+		ServiceName:         serviceName,
+		StackNamespace:      stackNamespace,
+		SwarmServiceImage:   swarmServiceImage(s.Service),
+		SwarmServiceRunning: strconv.Itoa(s.RunningTasks),
+	}
+	switch {
+	case s.Spec.Mode.Replicated != nil:
+		latests[SwarmServiceMode] = swarmModeReplicated
+		if s.Spec.Mode.Replicated.Replicas != nil {
+			latests[SwarmServiceReplicas] = strconv.FormatUint(*s.Spec.Mode.Replicated.Replicas, 10)
+		}
+	case s.Spec.Mode.Global != nil:
+		latests[SwarmServiceMode] = swarmModeGlobal
+	}
+	if s.UpdateStatus != nil && s.UpdateStatus.State != "" {
+		latests[SwarmServiceUpdate] = string(s.UpdateStatus.State)
+	}
+	return report.MakeNodeWith(report.MakeSwarmServiceNodeID(s.ID), latests)
+}
@@ -2,7 +2,6 @@ package docker
 
 import (
 	"strconv"
-	"strings"
 
 	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/probe/process"
@@ -26,13 +25,17 @@ var (
 type Tagger struct {
 	registry   Registry
 	procWalker process.Walker
+	cgroup     func(pid int) (string, bool)
 }
 
-// NewTagger returns a usable Tagger.
-func NewTagger(registry Registry, procWalker process.Walker) *Tagger {
+// NewTagger returns a usable Tagger.  cgroup reads the cgroups of
+// processes, for those which aren't descendants of the init process of
+// their container; it may be nil.
+func NewTagger(registry Registry, procWalker process.Walker, cgroup func(pid int) (string, bool)) *Tagger {
 	return &Tagger{
 		registry:   registry,
 		procWalker: procWalker,
+		cgroup:     cgroup,
 	}
 }
 
@@ -49,23 +52,19 @@ func (t *Tagger) Tag(r report.Report) (report.Report, error) {
 
 	// Scan for Swarm service info
 	for containerID, container := range r.Container.Nodes {
-		serviceID, ok := container.Latest.Lookup(LabelPrefix + "com.docker.swarm.service.id")
+		serviceID, ok := container.Latest.Lookup(LabelPrefix + swarmServiceIDLabel)
 		if !ok {
 			continue
 		}
-		serviceName, ok := container.Latest.Lookup(LabelPrefix + "com.docker.swarm.service.name")
+		serviceName, ok := container.Latest.Lookup(LabelPrefix + swarmServiceNameLabel)
 		if !ok {
 			continue
 		}
-		stackNamespace, ok := container.Latest.Lookup(LabelPrefix + "com.docker.stack.namespace")
-		if !ok {
-			stackNamespace = DefaultNamespace
-		} else {
-			prefix := stackNamespace + "_"
-			if strings.HasPrefix(serviceName, prefix) {
-				serviceName = serviceName[len(prefix):]
-			}
+		labels := map[string]string{}
+		if stackNamespace, ok := container.Latest.Lookup(LabelPrefix + stackNamespaceLabel); ok {
+			labels[stackNamespaceLabel] = stackNamespace
 		}
+		stackNamespace, serviceName := stackServiceName(labels, serviceName)
 
 		nodeID := report.MakeSwarmServiceNodeID(serviceID)
 		node := report.MakeNodeWith(nodeID, map[string]string{
@@ -111,6 +110,15 @@ func (t *Tagger) tag(tree process.Tree, topology *report.Topology) {
 			}
 		})
 
+		// Processes started in a container through containerd-shim (e.g.
+		// docker exec) are not descendants of the container's init
+		// process, so fall back to the container ID in their cgroups.
+		if c == nil {
+			if id, ok := processContainerID(t.cgroup, int(pid)); ok {
+				c, _ = t.registry.GetContainer(id)
+			}
+		}
+
 		if c == nil || ContainerIsStopped(c) || c.PID() == 1 {
 			continue
 		}
@@ -43,7 +43,7 @@ func TestTagger(t *testing.T) {
 	input.Process.AddNode(report.MakeNodeWith(pid1NodeID, map[string]string{process.PID: "2"}))
 	input.Process.AddNode(report.MakeNodeWith(pid2NodeID, map[string]string{process.PID: "3"}))
 
-	have, err := docker.NewTagger(mockRegistryInstance, nil).Tag(input)
+	have, err := docker.NewTagger(mockRegistryInstance, nil, nil).Tag(input)
 	if err != nil {
 		t.Errorf("%v", err)
 	}
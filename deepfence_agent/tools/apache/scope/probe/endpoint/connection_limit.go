@@ -0,0 +1,145 @@
+package endpoint
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/probe/process"
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys of the connections left out of reports, on hosts with more than
+// --probe.endpoint.max-connections.
+const (
+	// ConnectionsSampled is set on the endpoints standing for several
+	// connections aggregated onto them.
+	ConnectionsSampled = report.ConnectionsSampled
+
+	// ConnectionsAggregated is set on the host node to the number of
+	// connections aggregated onto the endpoints of others.
+	ConnectionsAggregated = "connections_aggregated"
+
+	// ConnectionsTruncated is set on the host node to the number of
+	// connections left out.
+	ConnectionsTruncated = "connections_truncated"
+)
+
+// ConnectionLimitMetadataTemplates are the keys of the host node telling
+// connections were left out.
+var ConnectionLimitMetadataTemplates = report.MetadataTemplates{
+	ConnectionsAggregated: {ID: ConnectionsAggregated, Label: "Connections aggregated", From: report.FromLatest, Datatype: report.Number, Priority: 41},
+	ConnectionsTruncated:  {ID: ConnectionsTruncated, Label: "Connections left out", From: report.FromLatest, Datatype: report.Number, Priority: 42},
+}
+
+// connectionCount is the number of connections the endpoint opened.
+func connectionCount(node report.Node) int {
+	count := 1
+	if value, ok := node.Latest.Lookup(report.ConnectionCount); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			count = i
+		}
+	}
+	return count * len(node.Adjacency)
+}
+
+// limitConnections keeps the number of connections in the report to max,
+// when there are more.  It either aggregates the connections from each
+// source address and process to each destination onto one of their
+// endpoints, as eBPF tracking does, or drops the connections past max.
+//
+// Only the endpoints of clients are aggregated or dropped: the ephemeral
+// ones which opened a connection and aren't connected to.
+func limitConnections(rpt *report.Report, hostID string, max int, truncate bool) {
+	if max <= 0 {
+		return
+	}
+	var (
+		total     = 0
+		connected = map[string]struct{}{}
+		clients   = []string{}
+	)
+	for _, node := range rpt.Endpoint.Nodes {
+		total += connectionCount(node)
+		for _, id := range node.Adjacency {
+			connected[id] = struct{}{}
+		}
+	}
+	if total <= max {
+		return
+	}
+	for id, node := range rpt.Endpoint.Nodes {
+		if _, ok := connected[id]; !ok && len(node.Adjacency) == 1 {
+			clients = append(clients, id)
+		}
+	}
+	// Sorted, the same endpoints are kept from one report to the next
+	sort.Strings(clients)
+
+	now := mtime.Now()
+	host := report.MakeNode(report.MakeHostNodeID(hostID))
+	if truncate {
+		dropped := 0
+		for _, id := range clients {
+			if total-dropped <= max {
+				break
+			}
+			dropped += connectionCount(rpt.Endpoint.Nodes[id])
+			delete(rpt.Endpoint.Nodes, id)
+		}
+		host = host.WithLatest(ConnectionsTruncated, now, strconv.Itoa(dropped))
+	} else {
+		type aggregate struct {
+			source, pid, destination string
+		}
+		var (
+			kept       = map[aggregate]string{}
+			aggregated = 0
+		)
+		for _, id := range clients {
+			node := rpt.Endpoint.Nodes[id]
+			_, address, _, ok := report.ParseEndpointNodeID(id)
+			if !ok {
+				continue
+			}
+			pid, _ := node.Latest.Lookup(process.PID)
+			key := aggregate{source: address, pid: pid, destination: node.Adjacency[0]}
+			keptID, ok := kept[key]
+			if !ok {
+				kept[key] = id
+				continue
+			}
+			keptNode := rpt.Endpoint.Nodes[keptID]
+			count := connectionCount(keptNode) + connectionCount(node)
+			keptNode = keptNode.WithLatests(map[string]string{
+				report.ConnectionCount: strconv.Itoa(count),
+				ConnectionsSampled:     "true",
+			})
+			// The traffic of the connections adds up on the one edge
+			keptNode.Edges = keptNode.Edges.Merge(node.Edges)
+			rpt.Endpoint.Nodes[keptID] = keptNode
+			aggregated += connectionCount(node)
+			delete(rpt.Endpoint.Nodes, id)
+		}
+		host = host.WithLatest(ConnectionsAggregated, now, strconv.Itoa(aggregated))
+	}
+	removeUnconnected(rpt)
+	rpt.Host.AddNode(host)
+	rpt.Host = rpt.Host.WithMetadataTemplates(ConnectionLimitMetadataTemplates)
+}
+
+// removeUnconnected removes the endpoints no longer part of any connection.
+func removeUnconnected(rpt *report.Report) {
+	connected := map[string]struct{}{}
+	for _, node := range rpt.Endpoint.Nodes {
+		for _, id := range node.Adjacency {
+			connected[id] = struct{}{}
+		}
+	}
+	for id, node := range rpt.Endpoint.Nodes {
+		if _, ok := connected[id]; !ok && len(node.Adjacency) == 0 {
+			delete(rpt.Endpoint.Nodes, id)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package endpoint
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/weaveworks/scope/probe/process"
+	"github.com/weaveworks/scope/report"
+)
+
+// makeBusyReport makes the report of a load balancer with n connections
+// to each of its two backends, and one connection to it.
+func makeBusyReport(n int) report.Report {
+	rpt := report.MakeReport()
+	backends := []string{
+		report.MakeEndpointNodeID("host1", "", "10.0.0.2", "80"),
+		report.MakeEndpointNodeID("host1", "", "10.0.0.3", "80"),
+	}
+	for _, backend := range backends {
+		rpt.Endpoint.AddNode(report.MakeNode(backend))
+		for port := 30000; port < 30000+n; port++ {
+			id := report.MakeEndpointNodeID("host1", "", "10.0.0.1", strconv.Itoa(port))
+			if backend == backends[1] {
+				id = report.MakeEndpointNodeID("host1", "", "10.0.0.1", strconv.Itoa(port+n))
+			}
+			rpt.Endpoint.AddNode(report.MakeNodeWith(id, map[string]string{process.PID: "42"}).WithAdjacent(backend))
+		}
+	}
+	server := report.MakeEndpointNodeID("host1", "", "10.0.0.1", "443")
+	rpt.Endpoint.AddNode(report.MakeNodeWith(server, map[string]string{process.PID: "42"}))
+	rpt.Endpoint.AddNode(report.MakeNode(report.MakeEndpointNodeID("host1", "", "1.2.3.4", "50000")).WithAdjacent(server))
+	return rpt
+}
+
+func reportSize(t *testing.T, rpt report.Report) int {
+	buf, err := rpt.WriteBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Len()
+}
+
+func connectionsOf(rpt report.Report) int {
+	total := 0
+	for _, node := range rpt.Endpoint.Nodes {
+		total += connectionCount(node)
+	}
+	return total
+}
+
+func TestLimitConnectionsUnderMax(t *testing.T) {
+	rpt := makeBusyReport(10)
+	limitConnections(&rpt, "host1", 100, false)
+	if have := len(rpt.Endpoint.Nodes); have != 24 {
+		t.Errorf("Expected the report to be left alone, got %d endpoints", have)
+	}
+	if len(rpt.Host.Nodes) != 0 {
+		t.Errorf("Expected no host node, got %v", rpt.Host.Nodes)
+	}
+}
+
+func TestLimitConnectionsSampling(t *testing.T) {
+	rpt := makeBusyReport(1000)
+	before := reportSize(t, rpt)
+	limitConnections(&rpt, "host1", 100, false)
+	after := reportSize(t, rpt)
+	if after*5 > before {
+		t.Errorf("Expected the sampled report to be much smaller: %d bytes before, %d after", before, after)
+	}
+
+	// One client endpoint per backend, the server, its client and the backends
+	if have := len(rpt.Endpoint.Nodes); have != 6 {
+		t.Errorf("Expected 6 endpoints, got %d", have)
+	}
+	if have := connectionsOf(rpt); have != 2001 {
+		t.Errorf("Expected the sampled endpoints to count all connections, got %d", have)
+	}
+	for _, node := range rpt.Endpoint.Nodes {
+		if count, ok := node.Latest.Lookup(report.ConnectionCount); ok {
+			if count != "1000" {
+				t.Errorf("Expected 1000 connections aggregated, got %s", count)
+			}
+			if _, ok := node.Latest.Lookup(ConnectionsSampled); !ok {
+				t.Errorf("Expected %s to be marked as sampled", node.ID)
+			}
+		}
+	}
+	host := rpt.Host.Nodes[report.MakeHostNodeID("host1")]
+	if aggregated, _ := host.Latest.Lookup(ConnectionsAggregated); aggregated != "1998" {
+		t.Errorf("Expected the host to have 1998 connections aggregated, got %q", aggregated)
+	}
+	if _, ok := host.Latest.Lookup(ConnectionsSampled); ok {
+		t.Errorf("Expected the host not to be marked as an endpoint aggregated onto")
+	}
+}
+
+func TestLimitConnectionsTruncation(t *testing.T) {
+	rpt := makeBusyReport(1000)
+	before := reportSize(t, rpt)
+	limitConnections(&rpt, "host1", 100, true)
+	after := reportSize(t, rpt)
+	if after*5 > before {
+		t.Errorf("Expected the truncated report to be much smaller: %d bytes before, %d after", before, after)
+	}
+
+	if have := connectionsOf(rpt); have != 100 {
+		t.Errorf("Expected 100 connections, got %d", have)
+	}
+	// Client endpoints are dropped in the order of their IDs, so only the
+	// second backend is still connected to
+	if _, ok := rpt.Endpoint.Nodes[report.MakeEndpointNodeID("host1", "", "10.0.0.2", "80")]; ok {
+		t.Errorf("Expected the endpoint no longer connected to be removed")
+	}
+	if _, ok := rpt.Endpoint.Nodes[report.MakeEndpointNodeID("host1", "", "10.0.0.3", "80")]; !ok {
+		t.Errorf("Expected the endpoint still connected to be kept")
+	}
+	host := rpt.Host.Nodes[report.MakeHostNodeID("host1")]
+	if dropped, _ := host.Latest.Lookup(ConnectionsTruncated); dropped != "1901" {
+		t.Errorf("Expected 1901 connections left out, got %q", dropped)
+	}
+}
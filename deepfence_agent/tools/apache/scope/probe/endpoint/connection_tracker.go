@@ -7,7 +7,6 @@ import (
 	"strconv"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/typetypetype/conntrack"
 
 	"github.com/weaveworks/scope/probe/endpoint/procspy"
@@ -23,18 +22,37 @@ type connectionTracker struct {
 
 	// time of the previous ebpf failure, or zero if it didn't fail
 	ebpfLastFailureTime time.Time
+
+	// counters of the conntracked connections in the previous report
+	flowCounters map[string]flowCounters
+
+	// backends of the DNAT'd connections, by the key of the tuple they
+	// were opened with, from the NAT mapper before each report
+	backends map[string]backend
+}
+
+// backend is where a connection to a Kubernetes service, or to a host
+// port, went once DNAT'd.
+type backend struct {
+	original fourTuple // as opened, to the service
+	tuple    fourTuple // to the backend
+	service  net.IP
 }
 
 func newConnectionTracker(conf ReporterConfig) connectionTracker {
 	ct := connectionTracker{
 		conf:            conf,
 		reverseResolver: newReverseResolver(),
+		flowCounters:    map[string]flowCounters{},
+		backends:        map[string]backend{},
 	}
 	if conf.UseEbpfConn {
 		et, err := newEbpfTracker()
 		if err == nil {
 			ct.ebpfTracker = et
 			go feedEBPFInitialState(conf, et)
+			// eBPF only tracks TCP, so UDP still comes from conntrack
+			ct.flowWalker = newConntrackFlowWalker(conf.UseConntrack, conf.ProcRoot, conf.BufferSize, false /* natOnly */, false, conf.UDPIdleExpiry)
 			return ct
 		}
 		log.Warnf("Error setting up the eBPF tracker, falling back to proc scanning: %v", err)
@@ -43,22 +61,37 @@ func newConnectionTracker(conf ReporterConfig) connectionTracker {
 	return ct
 }
 
+// flowToTuple returns the tuple of the connection as it was opened, which
+// is how the sockets of its processes see it.
 func flowToTuple(f conntrack.Conn) (ft fourTuple) {
+	return makeFourTuple(f.Orig.Src, f.Orig.Dst, uint16(f.Orig.SrcPort), uint16(f.Orig.DstPort))
+}
+
+// flowToBackend returns where the connection went, if it was DNAT'd: the
+// replies come from the backend, rather than from the address it was
+// opened to.  That's how kube-proxy sends connections to the cluster IPs
+// of services to their pods, with iptables rules as with IPVS, and how
+// host ports are sent to containers.  Connections SNAT'd as well, as by
+// masquerading, are replied to another address, but still come from the
+// original source.
+func flowToBackend(f conntrack.Conn) (backend, bool) {
 	if f.Orig.Dst.Equal(f.Reply.Src) {
-		return makeFourTuple(f.Orig.Src, f.Orig.Dst, uint16(f.Orig.SrcPort), uint16(f.Orig.DstPort))
+		return backend{}, false
 	}
-	// Handle DNAT-ed connections in the initial state
-	return makeFourTuple(f.Orig.Dst, f.Orig.Src, uint16(f.Orig.DstPort), uint16(f.Orig.SrcPort))
+	return backend{
+		original: flowToTuple(f),
+		tuple:    makeFourTuple(f.Orig.Src, f.Reply.Src, uint16(f.Orig.SrcPort), uint16(f.Reply.SrcPort)),
+		service:  f.Orig.Dst,
+	}, true
 }
 
 func (t *connectionTracker) useProcfs() {
 	t.ebpfTracker = nil
-	if t.conf.WalkProc && t.conf.Scanner == nil {
-		t.conf.Scanner = procspy.NewConnectionScanner(t.conf.ProcessCache, t.conf.SpyProcs)
-	}
-	if t.flowWalker == nil {
-		t.flowWalker = newConntrackFlowWalker(t.conf.UseConntrack, t.conf.ProcRoot, t.conf.BufferSize, false /* natOnly */)
+	if t.flowWalker != nil {
+		// Replace the UDP-only walker of the eBPF tracker
+		t.flowWalker.stop()
 	}
+	t.flowWalker = newConntrackFlowWalker(t.conf.UseConntrack, t.conf.ProcRoot, t.conf.BufferSize, false /* natOnly */, true, t.conf.UDPIdleExpiry)
 }
 
 // ReportConnections calls trackers according to the configuration.
@@ -67,6 +100,7 @@ func (t *connectionTracker) ReportConnections(rpt *report.Report) {
 
 	if t.ebpfTracker != nil {
 		if !t.ebpfTracker.isDead() {
+			t.walkFlows(rpt)
 			t.performEbpfTrack(rpt, hostNodeID)
 			return
 		}
@@ -87,6 +121,7 @@ func (t *connectionTracker) ReportConnections(rpt *report.Report) {
 			err := t.ebpfTracker.restart()
 			if err == nil {
 				feedEBPFInitialState(t.conf, t.ebpfTracker)
+				t.walkFlows(rpt)
 				t.performEbpfTrack(rpt, hostNodeID)
 				return
 			}
@@ -95,16 +130,74 @@ func (t *connectionTracker) ReportConnections(rpt *report.Report) {
 		}
 	}
 
-	// consult the flowWalker for short-lived (conntracked) connections
-	seenTuples := map[string]fourTuple{}
+	seenTuples := t.walkFlows(rpt)
+	if t.conf.WalkProc && t.conf.Scanner != nil {
+		t.performWalkProc(rpt, hostNodeID, seenTuples)
+	}
+}
+
+// walkFlows consults the flowWalker for short-lived (conntracked)
+// connections, and returns their tuples.
+func (t *connectionTracker) walkFlows(rpt *report.Report) map[string]fourTuple {
+	var (
+		seenTuples = map[string]fourTuple{}
+		counters   = map[string]flowCounters{}
+	)
 	t.flowWalker.walkFlows(func(f conntrack.Conn, alive bool) {
 		tuple := flowToTuple(f)
 		seenTuples[tuple.key()] = tuple
-		t.addConnection(rpt, "", tuple, 0, 0, 0, 1)
+		fromID, toID := t.addConnection(rpt, "", tuple, 0, 0, 0, 1, f.Orig.Proto == udpProto)
+
+		// Without accounting, all the counters are zero
+		current := countersOf(f)
+		if current == (flowCounters{}) {
+			return
+		}
+		if alive {
+			counters[tuple.key()] = current
+		}
+		if delta := current.since(t.flowCounters[tuple.key()]); delta != (flowCounters{}) {
+			rpt.Endpoint.AddNode(report.MakeNode(fromID).WithEdge(toID, delta.edgeMetadata()))
+		}
 	})
+	t.flowCounters = counters
+	return seenTuples
+}
 
-	if t.conf.WalkProc && t.conf.Scanner != nil {
-		t.performWalkProc(rpt, hostNodeID, seenTuples)
+// flowCounters are the byte and packet counters of a conntracked
+// connection, from the point of view of the endpoint which opened it.
+type flowCounters struct {
+	bytesSent, bytesReceived     uint64
+	packetsSent, packetsReceived uint64
+}
+
+func countersOf(f conntrack.Conn) flowCounters {
+	return flowCounters{f.OrigPktLen, f.ReplyPktLen, f.OrigPktCount, f.ReplyPktCount}
+}
+
+// since returns the counters since the previous ones; all of them when the
+// connection is new, or the tuple was reused by another one.
+func (c flowCounters) since(prev flowCounters) flowCounters {
+	if c.bytesSent < prev.bytesSent || c.bytesReceived < prev.bytesReceived ||
+		c.packetsSent < prev.packetsSent || c.packetsReceived < prev.packetsReceived {
+		return c
+	}
+	return flowCounters{
+		bytesSent:       c.bytesSent - prev.bytesSent,
+		bytesReceived:   c.bytesReceived - prev.bytesReceived,
+		packetsSent:     c.packetsSent - prev.packetsSent,
+		packetsReceived: c.packetsReceived - prev.packetsReceived,
+	}
+}
+
+// edgeMetadata is the metadata of the edge from the endpoint which opened
+// the connection to its peer.
+func (c flowCounters) edgeMetadata() report.EdgeMetadata {
+	return report.EdgeMetadata{
+		EgressByteCount:    c.bytesSent,
+		IngressByteCount:   c.bytesReceived,
+		EgressPacketCount:  c.packetsSent,
+		IngressPacketCount: c.packetsReceived,
 	}
 }
 
@@ -135,15 +228,33 @@ func (t *connectionTracker) performWalkProc(rpt *report.Report, hostNodeID strin
 	}
 	for conn := conns.Next(); conn != nil; conn = conns.Next() {
 		tuple, namespaceID, incoming := connectionTuple(conn, seenTuples)
+		udp := conn.Transport == procspy.UDP
 		if incoming {
-			t.addConnection(rpt, hostNodeID, reverse(tuple), 0, conn.Proc.PID, namespaceID, 1)
+			t.addConnection(rpt, hostNodeID, reverse(tuple), 0, conn.Proc.PID, namespaceID, 1, udp)
 		} else {
-			t.addConnection(rpt, hostNodeID, tuple, conn.Proc.PID, 0, namespaceID, 1)
+			t.addConnection(rpt, hostNodeID, tuple, conn.Proc.PID, 0, namespaceID, 1, udp)
 		}
 	}
 	return nil
 }
 
+// ReportListeningPorts adds up to max of the listening ports of the host
+// to its node.
+func (t *connectionTracker) ReportListeningPorts(rpt *report.Report, max int) {
+	if !t.conf.WalkProc || max <= 0 {
+		return
+	}
+	if t.conf.Scanner == nil {
+		return
+	}
+	listeners, err := t.conf.Scanner.Listeners()
+	if err != nil {
+		log.Errorf("Error listing listening sockets: %v", err)
+		return
+	}
+	addListeningPorts(rpt, report.MakeHostNodeID(t.conf.HostID), listeningPortRows(listeners), max)
+}
+
 // feedEBPFInitialState runs conntrack and proc parsing synchronously only
 // once to initialize ebpfTracker
 // This is run on a background goroutine during initial setup, so does
@@ -154,7 +265,7 @@ func feedEBPFInitialState(conf ReporterConfig, ebpfTracker *EbpfTracker) {
 	processCache = process.NewCachingWalker(walker)
 	processCache.Tick()
 
-	scanner := procspy.NewSyncConnectionScanner(processCache, conf.SpyProcs)
+	scanner := procspy.NewSyncConnectionScanner(processCache, conf.SpyProcs, false)
 
 	// Consult conntrack to get the initial state
 	seenTuples := existingFlowsFromConntrack(conf)
@@ -253,7 +364,7 @@ func (t *connectionTracker) performEbpfTrack(rpt *report.Report, hostNodeID stri
 				// Last one in a group: add in the connections that come after this one.
 				skipped += (len(portToPids) - seen)
 			}
-			t.addConnection(rpt, hostNodeID, tuple, uint(pids.fromPid), uint(pids.toPid), triple.networkNamespace, skipped+1)
+			t.addConnection(rpt, hostNodeID, tuple, uint(pids.fromPid), uint(pids.toPid), triple.networkNamespace, skipped+1, false)
 			skipped = 0
 		}
 	}
@@ -306,8 +417,22 @@ func makeFilter(ports mapPortToPids) (filter func(uint16) bool, count int) {
 	return func(port uint16) bool { return (port % modulus) == 0 }, count
 }
 
+// quicPort is the port of QUIC, which is HTTP/3 over UDP
+const quicPort = 443
+
+// protocolHint labels UDP connections, telling QUIC from the rest by its
+// port, as there are no handshakes to tell them apart.
+func protocolHint(ft fourTuple) string {
+	if ft.fromPort == quicPort || ft.toPort == quicPort {
+		return "quic"
+	}
+	return "udp"
+}
+
 // tuple is canonicalised - always opened from-to
-func (t *connectionTracker) addConnection(rpt *report.Report, hostNodeID string, ft fourTuple, fromPid, toPid uint, namespaceID uint32, connectionCount int) {
+// addConnection adds the endpoints of a connection to the report, and
+// returns their IDs.
+func (t *connectionTracker) addConnection(rpt *report.Report, hostNodeID string, ft fourTuple, fromPid, toPid uint, namespaceID uint32, connectionCount int, udp bool) (fromID, toID string) {
 	extraToNode := map[string]string{}
 	extraFromNode := map[string]string{}
 	if fromPid > 0 {
@@ -316,6 +441,12 @@ func (t *connectionTracker) addConnection(rpt *report.Report, hostNodeID string,
 			report.HostNodeID: hostNodeID,
 		}
 	}
+	// Connections to services never reach their addresses, so they are
+	// reported to their backends, noting the service
+	if backend, ok := t.backends[ft.key()]; ok && backend.original == ft {
+		ft = backend.tuple
+		extraFromNode[ViaServiceIP] = backend.service.String()
+	}
 	if toPid > 0 {
 		extraToNode = map[string]string{
 			process.PID:       strconv.FormatUint(uint64(toPid), 10),
@@ -326,16 +457,30 @@ func (t *connectionTracker) addConnection(rpt *report.Report, hostNodeID string,
 		// Tell the app we have elided several connections to a common IP and port onto this one
 		extraFromNode[report.ConnectionCount] = strconv.Itoa(connectionCount)
 	}
+	if udp {
+		hint := protocolHint(ft)
+		extraFromNode[ProtocolHint] = hint
+		extraToNode[ProtocolHint] = hint
+	}
+	fromAddr, toAddr := net.IP(ft.fromAddr[:]), net.IP(ft.toAddr[:])
+	if name, ok := t.addDNS(rpt, fromAddr.String()); ok {
+		extraFromNode[DNSName] = name
+	}
+	if name, ok := t.addDNS(rpt, toAddr.String()); ok {
+		extraToNode[DNSName] = name
+	}
+	if handshake, ok := t.conf.TLSSnooper.HandshakeLatests(fromAddr, ft.fromPort, toAddr, ft.toPort); ok {
+		for k, v := range handshake {
+			extraFromNode[k] = v
+		}
+	}
 	var (
-		fromAddr = net.IP(ft.fromAddr[:])
 		fromNode = t.makeEndpointNode(namespaceID, fromAddr, ft.fromPort, extraFromNode)
-		toAddr   = net.IP(ft.toAddr[:])
 		toNode   = t.makeEndpointNode(namespaceID, toAddr, ft.toPort, extraToNode)
 	)
 	rpt.Endpoint.AddNode(fromNode.WithAdjacent(toNode.ID))
 	rpt.Endpoint.AddNode(toNode)
-	t.addDNS(rpt, fromAddr.String())
-	t.addDNS(rpt, toAddr.String())
+	return fromNode.ID, toNode.ID
 }
 
 func (t *connectionTracker) makeEndpointNode(namespaceID uint32, addr net.IP, port uint16, extra map[string]string) report.Node {
@@ -346,10 +491,11 @@ func (t *connectionTracker) makeEndpointNode(namespaceID uint32, addr net.IP, po
 	return node
 }
 
-// Add DNS record for address to report, if not already there
-func (t *connectionTracker) addDNS(rpt *report.Report, addr string) {
+// Add DNS record for address to report, if not already there, and return
+// the name last queried for it, if any
+func (t *connectionTracker) addDNS(rpt *report.Report, addr string) (string, bool) {
+	forward := t.conf.DNSSnooper.CachedNamesForIP(addr)
 	if _, found := rpt.DNS[addr]; !found {
-		forward := t.conf.DNSSnooper.CachedNamesForIP(addr)
 		record := report.DNSRecord{
 			Forward: report.MakeStringSet(forward...),
 		}
@@ -358,6 +504,10 @@ func (t *connectionTracker) addDNS(rpt *report.Report, addr string) {
 		}
 		rpt.DNS[addr] = record
 	}
+	if len(forward) == 0 {
+		return "", false
+	}
+	return forward[0], true
 }
 
 func (t *connectionTracker) Stop() error {
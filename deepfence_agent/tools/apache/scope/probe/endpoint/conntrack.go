@@ -6,20 +6,27 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
-	log "github.com/sirupsen/logrus"
 	"github.com/typetypetype/conntrack"
+	"github.com/weaveworks/common/mtime"
 )
 
 const (
 	// From https://www.kernel.org/doc/Documentation/networking/nf_conntrack-sysctl.txt
 	eventsPath = "sys/net/netfilter/nf_conntrack_events"
+	acctPath   = "sys/net/netfilter/nf_conntrack_acct"
 	timeWait   = "TIME_WAIT"
 	tcpClose   = "CLOSE"
 	tcpProto   = 6
+	udpProto   = 17
+
+	// How often the byte and packet counters of the active flows are read,
+	// as conntrack only sends events on changes of state.
+	countersRefreshInterval = 15 * time.Second
 )
 
 // flowWalker is something that maintains flows, and provides an accessor
@@ -42,27 +49,60 @@ type conntrackWalker struct {
 	bufferedFlows []conntrack.Conn          // flows coming out of activeFlows spend 1 walk cycle here
 	bufferSize    int
 	natOnly       bool
+	tcp           bool
 	quit          chan struct{}
+
+	// UDP flows are tracked when udpIdleExpiry isn't zero.  They live until
+	// conntrack times them out and destroys them or, with accounting, until
+	// their packet counters haven't moved for udpIdleExpiry, as conntrack
+	// sends no events for their packets.
+	udpIdleExpiry time.Duration
+	udpActivity   map[uint32]udpActivity
+
+	// Whether conntrack accounting is enabled, so flows have byte and
+	// packet counters.
+	accounting bool
 }
 
-// newConntracker creates and starts a new conntracker.
-func newConntrackFlowWalker(useConntrack bool, procRoot string, bufferSize int, natOnly bool) flowWalker {
-	if !useConntrack {
+// newConntracker creates and starts a new conntracker, of the TCP flows
+// and/or of the UDP flows.
+func newConntrackFlowWalker(useConntrack bool, procRoot string, bufferSize int, natOnly, tcp bool, udpIdleExpiry time.Duration) flowWalker {
+	if !useConntrack || (!tcp && udpIdleExpiry == 0) {
 		return nilFlowWalker{}
 	} else if err := IsConntrackSupported(procRoot); err != nil {
 		log.Warnf("Not using conntrack: not supported by the kernel: %s", err)
 		return nilFlowWalker{}
 	}
-	result := &conntrackWalker{
-		activeFlows: map[uint32]conntrack.Conn{},
-		bufferSize:  bufferSize,
-		natOnly:     natOnly,
-		quit:        make(chan struct{}),
-	}
+	result := makeConntrackWalker(bufferSize, natOnly, tcp, udpIdleExpiry)
+	// The NAT mapper has no use for the counters
+	result.accounting = !natOnly && conntrackAccountingEnabled(procRoot)
 	go result.loop()
 	return result
 }
 
+func makeConntrackWalker(bufferSize int, natOnly, tcp bool, udpIdleExpiry time.Duration) *conntrackWalker {
+	return &conntrackWalker{
+		activeFlows:   map[uint32]conntrack.Conn{},
+		bufferSize:    bufferSize,
+		natOnly:       natOnly,
+		tcp:           tcp,
+		quit:          make(chan struct{}),
+		udpIdleExpiry: udpIdleExpiry,
+		udpActivity:   map[uint32]udpActivity{},
+	}
+}
+
+// udpActivity is the number of packets of a UDP flow, as last counted, and
+// since when it's been that.
+type udpActivity struct {
+	packets uint64
+	since   time.Time
+}
+
+func flowPackets(f conntrack.Conn) uint64 {
+	return f.OrigPktCount + f.ReplyPktCount
+}
+
 // IsConntrackSupported returns true if conntrack is suppported by the kernel
 var IsConntrackSupported = func(procRoot string) error {
 	// Make sure events are enabled, the conntrack CLI doesn't verify it
@@ -77,6 +117,19 @@ var IsConntrackSupported = func(procRoot string) error {
 	return nil
 }
 
+// conntrackAccountingEnabled says whether conntrack counts the bytes and
+// packets of flows.
+func conntrackAccountingEnabled(procRoot string) bool {
+	contents, err := ioutil.ReadFile(filepath.Join(procRoot, acctPath))
+	return err == nil && strings.TrimSpace(string(contents)) == "1"
+}
+
+// enableConntrackAccounting makes conntrack count the bytes and packets of
+// flows, which needs CAP_NET_ADMIN in the initial network namespace.
+func enableConntrackAccounting(procRoot string) error {
+	return ioutil.WriteFile(filepath.Join(procRoot, acctPath), []byte("1"), 0644)
+}
+
 func (c *conntrackWalker) loop() {
 	// conntrack can sometimes fail with ENOBUFS, when there is a particularly
 	// high connection rate.  In these cases just retry in a loop, so we can
@@ -104,18 +157,49 @@ func (c *conntrackWalker) clearFlows() {
 	}
 
 	c.activeFlows = map[uint32]conntrack.Conn{}
+	c.udpActivity = map[uint32]udpActivity{}
 }
 
 func (c *conntrackWalker) relevant(f conntrack.Conn) bool {
-	// For now, we're only interested in tcp connections - there is too much udp
-	// traffic going on (every container talking to dns, for example) to
-	// render nicely. TODO: revisit this.
-	if f.Orig.Proto != tcpProto {
+	switch {
+	case f.Orig.Proto == tcpProto && c.tcp:
+	case f.Orig.Proto == udpProto && c.udpIdleExpiry > 0:
+	default:
 		return false
 	}
 	return !(c.natOnly && (f.Status&conntrack.IPS_NAT_MASK) == 0)
 }
 
+// setActive marks a flow as active, counting the packets of new UDP flows.
+func (c *conntrackWalker) setActive(f conntrack.Conn) {
+	c.activeFlows[f.CtId] = f
+	if _, ok := c.udpActivity[f.CtId]; !ok && f.Orig.Proto == udpProto {
+		c.udpActivity[f.CtId] = udpActivity{packets: flowPackets(f), since: mtime.Now()}
+	}
+}
+
+// setInactive moves a flow out of the active flows into the buffered ones.
+func (c *conntrackWalker) setInactive(id uint32, f conntrack.Conn) {
+	delete(c.activeFlows, id)
+	delete(c.udpActivity, id)
+	c.bufferedFlows = append(c.bufferedFlows, f)
+}
+
+// expireUDPFlows makes inactive the UDP flows whose packet counters haven't
+// moved for longer than the expiry.  Without accounting there are no
+// counters, and they are left for conntrack to time out.
+func (c *conntrackWalker) expireUDPFlows() {
+	if !c.accounting {
+		return
+	}
+	expired := mtime.Now().Add(-c.udpIdleExpiry)
+	for id, activity := range c.udpActivity {
+		if activity.since.Before(expired) {
+			c.setInactive(id, c.activeFlows[id])
+		}
+	}
+}
+
 func (c *conntrackWalker) run() {
 	existingFlows, err := conntrack.ConnectionsSize(c.bufferSize)
 	if err != nil {
@@ -125,7 +209,7 @@ func (c *conntrackWalker) run() {
 	c.Lock()
 	for _, flow := range existingFlows {
 		if c.relevant(flow) && flow.TCPState != tcpClose && flow.TCPState != timeWait {
-			c.activeFlows[flow.CtId] = flow
+			c.setActive(flow)
 		}
 	}
 	c.Unlock()
@@ -136,10 +220,19 @@ func (c *conntrackWalker) run() {
 		return
 	}
 
+	var refreshCounters <-chan time.Time
+	if c.accounting {
+		ticker := time.NewTicker(countersRefreshInterval)
+		defer ticker.Stop()
+		refreshCounters = ticker.C
+	}
+
 	periodicRestart := time.After(6 * time.Hour)
 	// Handle conntrack events from netlink socket
 	for {
 		select {
+		case <-refreshCounters:
+			c.refreshCounters()
 		case <-periodicRestart:
 			log.Debugf("conntrack periodic restart")
 			return
@@ -165,6 +258,35 @@ func (c *conntrackWalker) run() {
 	}
 }
 
+// refreshCounters reads the table for the counters of the active flows.
+func (c *conntrackWalker) refreshCounters() {
+	flows, err := conntrack.ConnectionsSize(c.bufferSize)
+	if err != nil {
+		log.Errorf("conntrack Connections error: %v", err)
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, flow := range flows {
+		c.updateCounters(flow)
+	}
+}
+
+// updateCounters copies the counters of a flow to the active flow, noting
+// when those of UDP flows move.
+func (c *conntrackWalker) updateCounters(f conntrack.Conn) {
+	active, ok := c.activeFlows[f.CtId]
+	if !ok {
+		return
+	}
+	active.OrigPktLen, active.OrigPktCount = f.OrigPktLen, f.OrigPktCount
+	active.ReplyPktLen, active.ReplyPktCount = f.ReplyPktLen, f.ReplyPktCount
+	c.activeFlows[f.CtId] = active
+	if activity, ok := c.udpActivity[f.CtId]; ok && flowPackets(f) != activity.packets {
+		c.udpActivity[f.CtId] = udpActivity{packets: flowPackets(f), since: mtime.Now()}
+	}
+}
+
 func (c *conntrackWalker) stop() {
 	c.Lock()
 	defer c.Unlock()
@@ -180,15 +302,18 @@ func (c *conntrackWalker) handleFlow(f conntrack.Conn) {
 	switch {
 	case f.MsgType == conntrack.NfctMsgUpdate:
 		if f.TCPState != timeWait {
-			c.activeFlows[f.CtId] = f
+			c.setActive(f)
 		} else if _, ok := c.activeFlows[f.CtId]; ok {
-			delete(c.activeFlows, f.CtId)
-			c.bufferedFlows = append(c.bufferedFlows, f)
+			c.setInactive(f.CtId, f)
 		}
 	case f.MsgType == conntrack.NfctMsgDestroy:
-		if active, ok := c.activeFlows[f.CtId]; ok {
-			delete(c.activeFlows, f.CtId)
-			c.bufferedFlows = append(c.bufferedFlows, active)
+		if _, ok := c.activeFlows[f.CtId]; ok {
+			// With accounting, the counters of the flow are final as it's
+			// destroyed; without, they're zero.
+			if countersOf(f) != (flowCounters{}) {
+				c.updateCounters(f)
+			}
+			c.setInactive(f.CtId, c.activeFlows[f.CtId])
 		}
 	}
 }
@@ -198,6 +323,7 @@ func (c *conntrackWalker) handleFlow(f conntrack.Conn) {
 func (c *conntrackWalker) walkFlows(f func(conntrack.Conn, bool)) {
 	c.Lock()
 	defer c.Unlock()
+	c.expireUDPFlows()
 	for _, flow := range c.activeFlows {
 		f(flow, true)
 	}
@@ -0,0 +1,293 @@
+// +build linux
+
+package endpoint
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/typetypetype/conntrack"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func udpFlow(id uint32, msgType conntrack.NfConntrackMsg, client, server, reply net.IP, clientPort, serverPort uint16) conntrack.Conn {
+	return conntrack.Conn{
+		MsgType: msgType,
+		Orig: conntrack.Tuple{
+			Src:     client,
+			Dst:     server,
+			SrcPort: clientPort,
+			DstPort: serverPort,
+			Proto:   syscall.IPPROTO_UDP,
+		},
+		Reply: conntrack.Tuple{
+			Src:     reply,
+			Dst:     client,
+			SrcPort: serverPort,
+			DstPort: clientPort,
+			Proto:   syscall.IPPROTO_UDP,
+		},
+		CtId: id,
+	}
+}
+
+func walkedFlows(c *conntrackWalker) map[uint32]bool {
+	result := map[uint32]bool{}
+	c.walkFlows(func(f conntrack.Conn, active bool) {
+		result[f.CtId] = active
+	})
+	return result
+}
+
+func TestConntrackWalkerUDP(t *testing.T) {
+	mtime.NowForce(time.Unix(0, 0))
+	defer mtime.NowReset()
+
+	var (
+		client = net.ParseIP("10.0.47.2")
+		server = net.ParseIP("8.8.8.8")
+		flow   = udpFlow(1, conntrack.NfctMsgUpdate, client, server, server, 40000, 53)
+	)
+
+	if makeConntrackWalker(0, false, true, 0).relevant(flow) {
+		t.Errorf("Expected UDP flows to be irrelevant without an idle expiry")
+	}
+
+	c := makeConntrackWalker(0, false, true, time.Minute)
+	c.accounting = true
+	if !c.relevant(flow) {
+		t.Fatalf("Expected UDP flows to be relevant")
+	}
+	flow.OrigPktCount, flow.ReplyPktCount = 1, 1
+	c.handleFlow(flow)
+	if want, have := map[uint32]bool{1: true}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+
+	// Its packet counters moving keep it alive, whatever the events
+	mtime.NowForce(time.Unix(50, 0))
+	counted := flow
+	counted.OrigPktCount = 5
+	c.updateCounters(counted)
+	mtime.NowForce(time.Unix(70, 0))
+	c.handleFlow(counted)
+	mtime.NowForce(time.Unix(100, 0))
+	if want, have := map[uint32]bool{1: true}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+
+	// With counters still for longer than the expiry, it's reported once
+	// more as inactive, though it's still in the table
+	mtime.NowForce(time.Unix(111, 0))
+	c.updateCounters(counted)
+	if want, have := map[uint32]bool{1: false}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+	if want, have := map[uint32]bool{}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+
+	// Destroyed flows are inactive straight away
+	c.handleFlow(udpFlow(2, conntrack.NfctMsgUpdate, client, server, server, 40001, 53))
+	c.handleFlow(udpFlow(2, conntrack.NfctMsgDestroy, client, server, server, 40001, 53))
+	if want, have := map[uint32]bool{2: false}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+
+	// Without accounting, flows live until conntrack times them out
+	c = makeConntrackWalker(0, false, true, time.Minute)
+	c.handleFlow(flow)
+	mtime.NowForce(time.Unix(1000, 0))
+	if want, have := map[uint32]bool{1: true}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+	c.handleFlow(udpFlow(1, conntrack.NfctMsgDestroy, client, server, server, 40000, 53))
+	if want, have := map[uint32]bool{1: false}, walkedFlows(c); !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+}
+
+func TestConntrackWalkerUDPOnly(t *testing.T) {
+	c := makeConntrackWalker(0, false, false, time.Minute)
+	tcp := conntrack.Conn{Orig: conntrack.Tuple{Proto: syscall.IPPROTO_TCP}}
+	if c.relevant(tcp) {
+		t.Errorf("Expected TCP flows to be irrelevant")
+	}
+}
+
+func TestConntrackWalkerNATUDP(t *testing.T) {
+	mtime.NowForce(mtime.Now())
+	defer mtime.NowReset()
+
+	// A pod resolving names via the DNS service, whose flows are DNAT'd:
+	// pod (10.0.47.2:40000) -> service (10.96.0.10:53), served by
+	// coredns (10.0.47.1:53)
+	var (
+		pod     = net.ParseIP("10.0.47.2")
+		service = net.ParseIP("10.96.0.10")
+		coredns = net.ParseIP("10.0.47.1")
+		flow    = udpFlow(1, conntrack.NfctMsgUpdate, pod, service, coredns, 40000, 53)
+	)
+
+	c := makeConntrackWalker(0, true /* natOnly */, true, time.Minute)
+	if c.relevant(flow) {
+		t.Errorf("Expected flows without NAT to be irrelevant")
+	}
+	flow.Status = conntrack.IPS_DST_NAT
+	if !c.relevant(flow) {
+		t.Fatalf("Expected DNAT'd UDP flows to be relevant")
+	}
+	c.handleFlow(flow)
+
+	have := report.MakeReport()
+	originalID := report.MakeEndpointNodeID("host1", "", "10.0.47.1", "53")
+	have.Endpoint.AddNode(report.MakeNodeWith(originalID, map[string]string{
+		"foo": "bar",
+	}))
+
+	want := have.Copy()
+	want.Endpoint.AddNode(report.MakeNodeWith(report.MakeEndpointNodeID("host1", "", "10.96.0.10", "53"), map[string]string{
+		CopyOf: originalID,
+		"foo":  "bar",
+	}))
+
+	makeNATMapper(c).applyNAT(have, "host1")
+	if !reflect.DeepEqual(want, have) {
+		t.Fatal(test.Diff(want, have))
+	}
+}
+
+func TestProtocolHint(t *testing.T) {
+	for _, tc := range []struct {
+		tuple fourTuple
+		want  string
+	}{
+		{makeFourTuple(net.ParseIP("10.0.47.2"), net.ParseIP("8.8.8.8"), 40000, 53), "udp"},
+		{makeFourTuple(net.ParseIP("10.0.47.2"), net.ParseIP("142.250.0.1"), 40000, 443), "quic"},
+		{makeFourTuple(net.ParseIP("142.250.0.1"), net.ParseIP("10.0.47.2"), 443, 40000), "quic"},
+	} {
+		if have := protocolHint(tc.tuple); have != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.tuple, tc.want, have)
+		}
+	}
+}
+
+func TestConntrackWalkerUpdateCounters(t *testing.T) {
+	var (
+		client = net.ParseIP("10.0.47.2")
+		server = net.ParseIP("1.2.3.4")
+		flow   = udpFlow(1, conntrack.NfctMsgUpdate, client, server, server, 40000, 53)
+	)
+	c := makeConntrackWalker(0, false, true, time.Minute)
+	c.handleFlow(flow)
+
+	dumped := flow
+	dumped.OrigPktLen, dumped.OrigPktCount = 1000, 10
+	dumped.ReplyPktLen, dumped.ReplyPktCount = 5000, 8
+	c.updateCounters(dumped)
+	// Flows which aren't active are ignored
+	dumped.CtId = 2
+	c.updateCounters(dumped)
+
+	if have := c.activeFlows[1]; have.OrigPktLen != 1000 || have.ReplyPktCount != 8 {
+		t.Errorf("Expected the counters to be updated, got %+v", have)
+	}
+	if _, ok := c.activeFlows[2]; ok {
+		t.Errorf("Expected only active flows to be updated")
+	}
+
+	// Destroyed flows keep their final counters
+	destroyed := flow
+	destroyed.MsgType = conntrack.NfctMsgDestroy
+	destroyed.OrigPktLen, destroyed.OrigPktCount = 1200, 12
+	destroyed.ReplyPktLen, destroyed.ReplyPktCount = 5100, 9
+	c.handleFlow(destroyed)
+	var have []conntrack.Conn
+	c.walkFlows(func(f conntrack.Conn, alive bool) { have = append(have, f) })
+	if len(have) != 1 || countersOf(have[0]) != countersOf(destroyed) {
+		t.Errorf("Expected the final counters of the destroyed flow, got %+v", have)
+	}
+}
+
+func TestFlowCounters(t *testing.T) {
+	var (
+		pod     = net.ParseIP("10.0.47.2")
+		service = net.ParseIP("10.96.0.10")
+		backend = net.ParseIP("10.0.47.1")
+		flow    = udpFlow(1, conntrack.NfctMsgUpdate, pod, backend, backend, 40000, 53)
+	)
+	flow.OrigPktLen, flow.OrigPktCount = 100, 2
+	flow.ReplyPktLen, flow.ReplyPktCount = 300, 3
+
+	if want, have := (flowCounters{100, 300, 2, 3}), countersOf(flow); want != have {
+		t.Errorf("Expected %+v, got %+v", want, have)
+	}
+
+	// The counters of DNAT-ed connections are those of the pod too
+	dnat := udpFlow(2, conntrack.NfctMsgUpdate, pod, service, backend, 40000, 53)
+	dnat.OrigPktLen, dnat.ReplyPktLen = 100, 300
+	if want, have := (flowCounters{bytesSent: 100, bytesReceived: 300}), countersOf(dnat); want != have {
+		t.Errorf("Expected %+v, got %+v", want, have)
+	}
+
+	current := flowCounters{1000, 3000, 20, 30}
+	if want, have := (flowCounters{900, 2700, 18, 27}), current.since(flowCounters{100, 300, 2, 3}); want != have {
+		t.Errorf("Expected %+v, got %+v", want, have)
+	}
+	// A tuple reused by another connection starts again
+	if want, have := current, current.since(flowCounters{2000, 300, 2, 3}); want != have {
+		t.Errorf("Expected %+v, got %+v", want, have)
+	}
+}
+
+func TestWalkFlowsCounters(t *testing.T) {
+	var (
+		client = net.ParseIP("10.0.47.2")
+		server = net.ParseIP("1.2.3.4")
+		flow   = udpFlow(1, conntrack.NfctMsgUpdate, client, server, server, 40000, 53)
+		fromID = report.MakeEndpointNodeID("host1", "", "10.0.47.2", "40000")
+		toID   = report.MakeEndpointNodeID("host1", "", "1.2.3.4", "53")
+		walker = &mockFlowWalker{flows: []conntrack.Conn{flow}}
+	)
+	ct := connectionTracker{
+		conf:            ReporterConfig{HostID: "host1"},
+		flowWalker:      walker,
+		reverseResolver: newReverseResolver(),
+		flowCounters:    map[string]flowCounters{},
+	}
+	defer ct.reverseResolver.stop()
+	ct.reverseResolver.Resolver = func(string) ([]string, error) { return nil, errors.New("no names") }
+
+	// Without accounting there are no counters
+	rpt := report.MakeReport()
+	ct.walkFlows(&rpt)
+	if edges := rpt.Endpoint.Nodes[fromID].Edges; edges.Size() != 0 {
+		t.Errorf("Expected no counters without accounting, got %v", edges)
+	}
+
+	walker.flows[0].OrigPktLen, walker.flows[0].ReplyPktLen = 100, 300
+	rpt = report.MakeReport()
+	ct.walkFlows(&rpt)
+	if edge, _ := rpt.Endpoint.Nodes[fromID].Edges.Lookup(toID); edge.EgressByteCount != 100 {
+		t.Errorf("Expected 100 bytes sent, got %v", edge)
+	}
+
+	// The next report has what was sent since
+	walker.flows[0].OrigPktLen, walker.flows[0].ReplyPktLen = 150, 1300
+	rpt = report.MakeReport()
+	ct.walkFlows(&rpt)
+	node := rpt.Endpoint.Nodes[fromID]
+	if want, have := (report.EdgeMetadata{EgressByteCount: 50, IngressByteCount: 1000}), node.Edges; !reflect.DeepEqual(report.MakeEdgeMetadatas().Add(toID, want), have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	if _, ok := node.Latest.Lookup("bytes_sent"); ok {
+		t.Errorf("Expected no counters in the latests of the endpoint")
+	}
+}
@@ -8,14 +8,19 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/bluele/gcache"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -23,6 +28,17 @@ const (
 	maxReverseDNSrecords        = 10000
 	maxLogsPerDecodingError     = 4
 	maxDecodingErrorCardinality = 1000
+	dnsOverTLSPort              = 853
+	capNetRaw                   = 13
+
+	// minDNSRecordTTL is how long domains are kept at least, however low
+	// the TTL of their records, as connections outlive them.
+	minDNSRecordTTL = time.Minute
+)
+
+// Exposed for testing.
+var (
+	ProcSelfStatus = "/proc/self/status"
 )
 
 // DNSSnooper is a snopper of DNS queries
@@ -31,12 +47,37 @@ type DNSSnooper struct {
 	pcapHandle *pcap.Handle
 	// gcache is goroutine-safe, but the cached values aren't
 	reverseDNSMutex     sync.RWMutex
-	reverseDNSCache     gcache.Cache
+	reverseDNSCache     gcache.Cache      // of the domains queried, by IP
 	decodingErrorCounts map[string]uint64 // for limiting
+	sawDNSOverTLS       int32
+}
+
+// hasCapNetRaw tells whether the probe may open packet sockets.
+func hasCapNetRaw() (bool, error) {
+	buf, err := ioutil.ReadFile(ProcSelfStatus)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false, err
+		}
+		return caps&(1<<capNetRaw) != 0, nil
+	}
+	return false, fmt.Errorf("no CapEff in %s", ProcSelfStatus)
 }
 
-// NewDNSSnooper creates a new snooper of DNS queries
+// NewDNSSnooper creates a new snooper of DNS queries.  Without CAP_NET_RAW
+// it returns no snooper, and no error.
 func NewDNSSnooper() (*DNSSnooper, error) {
+	if ok, err := hasCapNetRaw(); err == nil && !ok {
+		log.Infof("DNSSnooper: no CAP_NET_RAW, not snooping DNS queries")
+		return nil, nil
+	}
 	pcapHandle, err := newPcapHandle()
 	if err != nil {
 		return nil, err
@@ -89,7 +130,7 @@ func newPcapHandle() (*pcap.Handle, error) {
 		pcapHandle.Close()
 		return nil, err
 	}
-	if err := pcapHandle.SetBPFFilter("inbound and port 53"); err != nil {
+	if err := pcapHandle.SetBPFFilter("inbound and (port 53 or tcp port 853)"); err != nil {
 		pcapHandle.Close()
 		return nil, err
 	}
@@ -97,8 +138,15 @@ func newPcapHandle() (*pcap.Handle, error) {
 	return pcapHandle, nil
 }
 
+// snoopedDomain is when a domain was last queried and resolved to an IP,
+// and when that record expires.
+type snoopedDomain struct {
+	queried time.Time
+	expiry  time.Time
+}
+
 // CachedNamesForIP obtains the domains associated to an IP,
-// obtained while snooping A-record queries
+// obtained while snooping A-record queries, the last queried first
 func (s *DNSSnooper) CachedNamesForIP(ip string) []string {
 	result := []string{}
 	if s == nil {
@@ -106,17 +154,40 @@ func (s *DNSSnooper) CachedNamesForIP(ip string) []string {
 	}
 	domains, err := s.reverseDNSCache.Get(ip)
 	if err != nil {
+		metrics.IncrCounter([]string{"dns_snooper", "cache", "misses"}, 1)
 		return result
 	}
+	now := time.Now()
 	s.reverseDNSMutex.RLock()
-	for domain := range domains.(map[string]struct{}) {
-		result = append(result, domain)
+	snooped := domains.(map[string]snoopedDomain)
+	for domain, d := range snooped {
+		if now.Before(d.expiry) {
+			result = append(result, domain)
+		}
 	}
+	sort.Slice(result, func(i, j int) bool {
+		qi, qj := snooped[result[i]].queried, snooped[result[j]].queried
+		if !qi.Equal(qj) {
+			return qi.After(qj)
+		}
+		return result[i] < result[j]
+	})
 	s.reverseDNSMutex.RUnlock()
 
+	if len(result) == 0 {
+		metrics.IncrCounter([]string{"dns_snooper", "cache", "misses"}, 1)
+	} else {
+		metrics.IncrCounter([]string{"dns_snooper", "cache", "hits"}, 1)
+	}
 	return result
 }
 
+// SawDNSOverTLS tells whether the snooper saw DNS over TLS, which it
+// cannot read, so that names may be missing.
+func (s *DNSSnooper) SawDNSOverTLS() bool {
+	return s != nil && atomic.LoadInt32(&s.sawDNSOverTLS) != 0
+}
+
 // Stop makes the snooper stop inspecting DNS communications
 func (s *DNSSnooper) Stop() {
 	if s != nil {
@@ -204,12 +275,17 @@ func (s *DNSSnooper) run() {
 			// LayerTypePayload indicates the TCP payload has non-DNS data, which we are not interested in
 			if layer, ok := err.(gopacket.UnsupportedLayerType); !ok || gopacket.LayerType(layer) != gopacket.LayerTypePayload {
 				s.handleDecodingError(err)
+				continue
 			}
-			continue
 		}
 
 		for _, layerType := range decodedLayers {
-			if layerType == layers.LayerTypeDNS {
+			switch layerType {
+			case layers.LayerTypeTCP:
+				if tcp.tcp.SrcPort == dnsOverTLSPort || tcp.tcp.DstPort == dnsOverTLSPort {
+					atomic.StoreInt32(&s.sawDNSOverTLS, 1)
+				}
+			case layers.LayerTypeDNS:
 				s.processDNSMessage(&dns)
 			}
 		}
@@ -249,8 +325,10 @@ func (s *DNSSnooper) processDNSMessage(dns *layers.DNS) {
 	var (
 		domainQueried = question.Name
 		records       = append(dns.Answers, dns.Additionals...)
-		ips           = map[string]struct{}{}
+		ips           = map[string]uint32{} // the TTL of each IP
 		aliases       = [][]byte{}
+		// the lowest TTL of the CNAME chain
+		aliasesTTL uint32
 	)
 
 	// Traverse all the CNAME records and the get the aliases. There are cases when the A record is for only one of the
@@ -258,6 +336,9 @@ func (s *DNSSnooper) processDNSMessage(dns *layers.DNS) {
 	for _, record := range records {
 		if record.Type == layers.DNSTypeCNAME && record.Class == layers.DNSClassIN {
 			aliases = append(aliases, record.CNAME)
+			if len(aliases) == 1 || record.TTL < aliasesTTL {
+				aliasesTTL = record.TTL
+			}
 		}
 	}
 
@@ -267,12 +348,16 @@ func (s *DNSSnooper) processDNSMessage(dns *layers.DNS) {
 			continue
 		}
 		if bytes.Equal(domainQueried, record.Name) {
-			ips[record.IP.String()] = struct{}{}
+			ips[record.IP.String()] = record.TTL
 			continue
 		}
 		for _, alias := range aliases {
 			if bytes.Equal(alias, record.Name) {
-				ips[record.IP.String()] = struct{}{}
+				ttl := record.TTL
+				if aliasesTTL < ttl {
+					ttl = aliasesTTL
+				}
+				ips[record.IP.String()] = ttl
 				break
 			}
 		}
@@ -281,14 +366,26 @@ func (s *DNSSnooper) processDNSMessage(dns *layers.DNS) {
 	// Update cache
 	newDomain := string(domainQueried)
 	log.Debugf("DNSSnooper: caught DNS lookup: %s -> %v", newDomain, ips)
-	for ip := range ips {
+	now := time.Now()
+	for ip, ttl := range ips {
+		expiry := now.Add(time.Duration(ttl) * time.Second)
+		if minExpiry := now.Add(minDNSRecordTTL); expiry.Before(minExpiry) {
+			expiry = minExpiry
+		}
+		snooped := snoopedDomain{queried: now, expiry: expiry}
 		if existingDomains, err := s.reverseDNSCache.Get(ip); err != nil {
-			s.reverseDNSCache.Set(ip, map[string]struct{}{newDomain: {}})
+			s.reverseDNSCache.Set(ip, map[string]snoopedDomain{newDomain: snooped})
 		} else {
-			// TODO: Be smarter about the expiration of entries with pre-existing associated domains
 			s.reverseDNSMutex.Lock()
-			existingDomains.(map[string]struct{})[newDomain] = struct{}{}
+			domains := existingDomains.(map[string]snoopedDomain)
+			for domain, d := range domains {
+				if now.After(d.expiry) {
+					delete(domains, domain)
+				}
+			}
+			domains[newDomain] = snooped
 			s.reverseDNSMutex.Unlock()
 		}
 	}
+	metrics.SetGauge([]string{"dns_snooper", "cache", "size"}, float32(s.reverseDNSCache.Len()))
 }
@@ -15,11 +15,16 @@ func NewDNSSnooper() (*DNSSnooper, error) {
 }
 
 // CachedNamesForIP obtains the domains associated to an IP,
-// obtained while snooping A-record queries
+// obtained while snooping A-record queries, the last queried first
 func (s *DNSSnooper) CachedNamesForIP(ip string) []string {
 	return []string{}
 }
 
+// SawDNSOverTLS tells whether the snooper saw DNS over TLS
+func (s *DNSSnooper) SawDNSOverTLS() bool {
+	return false
+}
+
 // Stop makes the snooper stop inspecting DNS communications
 func (s *DNSSnooper) Stop() {
 }
@@ -3,8 +3,12 @@
 package endpoint
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/bluele/gcache"
 	"github.com/google/gopacket/layers"
@@ -59,7 +63,79 @@ func TestProcessDNSMessageMultipleCNAME(t *testing.T) {
 		t.Errorf("A domain should have been inserted for the given CNAME IP:%v", err)
 	}
 
-	if _, ok := existingDomains.(map[string]struct{})[domain]; !ok {
+	if _, ok := existingDomains.(map[string]snoopedDomain)[domain]; !ok {
 		t.Errorf("Domain %s should have been inserted", domain)
 	}
 }
+
+func TestCachedNamesForIPExpiry(t *testing.T) {
+	snooper := &DNSSnooper{
+		reverseDNSCache: gcache.New(4).LRU().Build(),
+	}
+	snooper.processDNSMessage(&layers.DNS{
+		QR:           true,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions:    []layers.DNSQuestion{{Name: []byte("fresh.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			// A TTL below the minimum is raised to it
+			{Name: []byte("fresh.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 1, IP: net.ParseIP("10.0.0.1")},
+		},
+	})
+	snooper.reverseDNSMutex.Lock()
+	domains, _ := snooper.reverseDNSCache.Get("10.0.0.1")
+	domains.(map[string]snoopedDomain)["stale.com"] = snoopedDomain{queried: time.Now(), expiry: time.Now().Add(-time.Second)}
+	snooper.reverseDNSMutex.Unlock()
+
+	if names := snooper.CachedNamesForIP("10.0.0.1"); !reflect.DeepEqual(names, []string{"fresh.com"}) {
+		t.Errorf("Expected only the names which haven't expired, got %v", names)
+	}
+}
+
+func TestCachedNamesForIPLastQueried(t *testing.T) {
+	snooper := &DNSSnooper{
+		reverseDNSCache: gcache.New(4).LRU().Build(),
+	}
+	for _, domain := range []string{"a.com", "z.com"} {
+		snooper.processDNSMessage(&layers.DNS{
+			QR:           true,
+			ResponseCode: layers.DNSResponseCodeNoErr,
+			Questions:    []layers.DNSQuestion{{Name: []byte(domain), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+			Answers: []layers.DNSResourceRecord{
+				{Name: []byte(domain), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: net.ParseIP("10.0.0.1")},
+			},
+		})
+	}
+	snooper.reverseDNSMutex.Lock()
+	domains, _ := snooper.reverseDNSCache.Get("10.0.0.1")
+	a := domains.(map[string]snoopedDomain)["a.com"]
+	a.queried = a.queried.Add(-time.Second)
+	domains.(map[string]snoopedDomain)["a.com"] = a
+	snooper.reverseDNSMutex.Unlock()
+
+	if names := snooper.CachedNamesForIP("10.0.0.1"); !reflect.DeepEqual(names, []string{"z.com", "a.com"}) {
+		t.Errorf("Expected the last name queried first, got %v", names)
+	}
+}
+
+func TestHasCapNetRaw(t *testing.T) {
+	f, err := ioutil.TempFile("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer func(procSelfStatus string) { ProcSelfStatus = procSelfStatus }(ProcSelfStatus)
+	ProcSelfStatus = f.Name()
+
+	for capEff, want := range map[string]bool{
+		"000001ffffffffff": true,
+		"00000000a80425fb": true,
+		"00000000a80405fb": false,
+	} {
+		if err := ioutil.WriteFile(f.Name(), []byte("Name:\tscope\nCapEff:\t"+capEff+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if have, err := hasCapNetRaw(); err != nil || have != want {
+			t.Errorf("Expected %v for %s, got %v (%v)", want, capEff, have, err)
+		}
+	}
+}
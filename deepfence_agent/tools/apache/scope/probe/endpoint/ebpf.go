@@ -15,7 +15,6 @@ import (
 	"syscall"
 
 	"github.com/armon/go-metrics"
-	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/common/fs"
 	"github.com/weaveworks/scope/probe/endpoint/procspy"
 	"github.com/weaveworks/scope/probe/host"
@@ -139,10 +138,32 @@ func isKernelSupported() error {
 	return nil
 }
 
+// lockdownPath is where the kernel tells its lockdown mode.
+const lockdownPath = "/sys/kernel/security/lockdown"
+
+// isLockedDown returns an error if the kernel is locked down in the
+// confidentiality mode, in which BPF programs can't read kernel memory, as
+// those of the tracer do.  The tracer guesses the offsets of the fields of
+// sockets it reads at runtime, so it doesn't need the BTF of the kernel.
+func isLockedDown() error {
+	buf, err := fs.ReadFile(lockdownPath)
+	if err != nil {
+		// The kernel has no lockdown, or securityfs isn't mounted
+		return nil
+	}
+	if strings.Contains(string(buf), "[confidentiality]") {
+		return fmt.Errorf("kernel locked down in confidentiality mode")
+	}
+	return nil
+}
+
 func newEbpfTracker() (*EbpfTracker, error) {
 	if err := isKernelSupported(); err != nil {
 		return nil, fmt.Errorf("kernel not supported: %v", err)
 	}
+	if err := isLockedDown(); err != nil {
+		return nil, err
+	}
 
 	var debugBPF bool
 	if os.Getenv("SCOPE_DEBUG_BPF") != "" {
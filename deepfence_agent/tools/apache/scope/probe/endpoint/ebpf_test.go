@@ -3,11 +3,17 @@
 package endpoint
 
 import (
+	"fmt"
 	"net"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	fs_hook "github.com/weaveworks/common/fs"
+	"github.com/weaveworks/common/test/fs"
 	"github.com/weaveworks/tcptracer-bpf/pkg/tracer"
 
 	"github.com/weaveworks/scope/probe/host"
@@ -289,3 +295,144 @@ func TestIsKernelSupported(t *testing.T) {
 		}
 	}
 }
+
+func TestIsLockedDown(t *testing.T) {
+	defer fs_hook.Restore()
+	for _, tc := range []struct {
+		lockdown string
+		locked   bool
+	}{
+		{"", false},
+		{"[none] integrity confidentiality\n", false},
+		{"none [integrity] confidentiality\n", false},
+		{"none integrity [confidentiality]\n", true},
+	} {
+		dir := fs.Dir("sys", fs.Dir("kernel", fs.Dir("security")))
+		if tc.lockdown != "" {
+			dir = fs.Dir("sys", fs.Dir("kernel", fs.Dir("security", fs.File{FName: "lockdown", FContents: tc.lockdown})))
+		}
+		fs_hook.Mock(fs.Dir("", dir))
+		if err := isLockedDown(); (err != nil) != tc.locked {
+			t.Errorf("lockdown %q: expected locked down %v, got %v", tc.lockdown, tc.locked, err)
+		}
+	}
+}
+
+// ebpfEventFixtures are sequences of events of the tracer, one per line of
+// "timestamp type pid saddr:sport daddr:dport netns", and the connections
+// walked after them, as "tuple pid direction".
+var ebpfEventFixtures = []struct {
+	name   string
+	events string
+	want   []string
+}{
+	{
+		name: "outgoing connection",
+		events: `
+			100 connect 43 10.0.0.2:6789 10.0.0.1:80 4026531993`,
+		want: []string{"10.0.0.2:6789-10.0.0.1:80 43 outgoing"},
+	},
+	{
+		name: "accepted and closed connection is walked once more",
+		events: `
+			100 accept 42 10.0.0.1:80 10.0.0.2:6789 4026531993
+			200 close 42 10.0.0.1:80 10.0.0.2:6789 4026531993`,
+		want: []string{"10.0.0.1:80-10.0.0.2:6789 42 incoming"},
+	},
+	{
+		name: "loopback connections are scoped by namespace",
+		events: `
+			100 connect 43 127.0.0.1:6789 127.0.0.1:80 1
+			200 connect 53 127.0.0.1:6789 127.0.0.1:80 2
+			300 close 53 127.0.0.1:6789 127.0.0.1:80 1`,
+		want: []string{
+			"127.0.0.1:6789-127.0.0.1:80 43 outgoing",
+			"127.0.0.1:6789-127.0.0.1:80 53 outgoing",
+		},
+	},
+	{
+		name: "events out of order are ignored",
+		events: `
+			200 connect 43 10.0.0.2:6789 10.0.0.1:80 4026531993
+			100 close 43 10.0.0.2:6789 10.0.0.1:80 4026531993`,
+		want: []string{"10.0.0.2:6789-10.0.0.1:80 43 outgoing"},
+	},
+	{
+		name: "unmatched close",
+		events: `
+			100 close 43 10.0.0.2:6789 10.0.0.1:80 4026531993`,
+		want: []string{},
+	},
+}
+
+var ebpfEventTypes = map[string]tracer.EventType{
+	"connect": tracer.EventConnect,
+	"accept":  tracer.EventAccept,
+	"close":   tracer.EventClose,
+}
+
+func parseEbpfEndpoint(t *testing.T, endpoint string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return net.ParseIP(host), uint16(p)
+}
+
+func parseEbpfEvent(t *testing.T, line string) tracer.TcpV4 {
+	fields := strings.Fields(line)
+	if len(fields) != 6 {
+		t.Fatalf("invalid event fixture %q", line)
+	}
+	var (
+		e   tracer.TcpV4
+		err error
+		ok  bool
+	)
+	if e.Timestamp, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type, ok = ebpfEventTypes[fields[1]]; !ok {
+		t.Fatalf("invalid event type %q", fields[1])
+	}
+	pid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Pid = uint32(pid)
+	e.SAddr, e.SPort = parseEbpfEndpoint(t, fields[3])
+	e.DAddr, e.DPort = parseEbpfEndpoint(t, fields[4])
+	netNS, err := strconv.ParseUint(fields[5], 10, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.NetNS = uint32(netNS)
+	return e
+}
+
+func TestTCPEventV4Fixtures(t *testing.T) {
+	for _, fixture := range ebpfEventFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			mockEbpfTracker := newMockEbpfTracker()
+			for _, line := range strings.Split(strings.TrimSpace(fixture.events), "\n") {
+				mockEbpfTracker.TCPEventV4(parseEbpfEvent(t, line))
+			}
+			have := []string{}
+			mockEbpfTracker.walkConnections(func(k ebpfKey, e ebpfDetail) {
+				direction := "outgoing"
+				if e.incoming {
+					direction = "incoming"
+				}
+				have = append(have, fmt.Sprintf("%s %d %s", k.fourTuple, e.pid, direction))
+			})
+			sort.Strings(have)
+			if !reflect.DeepEqual(have, fixture.want) {
+				t.Errorf("Expected %v, got %v", fixture.want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package endpoint
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/weaveworks/common/fs"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/probe/endpoint/procspy"
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the listening ports table of the host node.
+const (
+	ListeningPortPrefix    = report.HostListeningPortPrefix
+	ListeningPortProtocol  = "host_listening_port_protocol"
+	ListeningPortPort      = "host_listening_port_port"
+	ListeningPortAddress   = "host_listening_port_address"
+	ListeningPortProcess   = "host_listening_port_process"
+	ListeningPortPID       = "host_listening_port_pid"
+	ListeningPortContainer = "host_listening_port_container"
+
+	// DefaultMaxListeningPorts is how many listening ports the host node
+	// lists before saying how many more there are.
+	DefaultMaxListeningPorts = 100
+)
+
+// ListeningPortTableTemplates is the table of listening ports on the host
+// node.
+var ListeningPortTableTemplates = report.TableTemplates{
+	ListeningPortPrefix: {
+		ID:     ListeningPortPrefix,
+		Label:  "Open ports",
+		Type:   report.MulticolumnTableType,
+		Prefix: ListeningPortPrefix,
+		Columns: []report.Column{
+			{ID: ListeningPortProtocol, Label: "Protocol"},
+			{ID: ListeningPortPort, Label: "Port", DataType: report.Number},
+			{ID: ListeningPortAddress, Label: "Address"},
+			{ID: ListeningPortProcess, Label: "Process"},
+			{ID: ListeningPortPID, Label: "PID", DataType: report.Number},
+			{ID: ListeningPortContainer, Label: "Container"},
+		},
+	},
+}
+
+// listeningPortRows describes the listening sockets, sorted by port.
+// Processes forking after listening, or listening with SO_REUSEPORT, make
+// for several sockets on the same address and port; they get the one row,
+// for the lowest PID.
+func listeningPortRows(listeners procspy.ConnIter) []report.Row {
+	byID := map[string]report.Row{}
+	for conn := listeners.Next(); conn != nil; conn = listeners.Next() {
+		protocol := "tcp"
+		if conn.LocalAddress.To4() == nil {
+			protocol = "tcp6"
+		}
+		// The row ID sorts rows by port; the namespace tells apart
+		// containers listening on the same port.
+		id := fmt.Sprintf("%05d_%s_%d", conn.LocalPort, conn.LocalAddress, conn.Proc.NetNamespaceID)
+		if row, ok := byID[id]; ok {
+			if pid, err := strconv.Atoi(row.Entries[ListeningPortPID]); err == nil && (conn.Proc.PID == 0 || uint(pid) <= conn.Proc.PID) {
+				continue
+			}
+		}
+		entries := map[string]string{
+			ListeningPortProtocol: protocol,
+			ListeningPortPort:     strconv.Itoa(int(conn.LocalPort)),
+			ListeningPortAddress:  conn.LocalAddress.String(),
+		}
+		if conn.Proc.PID != 0 {
+			entries[ListeningPortProcess] = conn.Proc.Name
+			entries[ListeningPortPID] = strconv.Itoa(int(conn.Proc.PID))
+		}
+		byID[id] = report.Row{ID: id, Entries: entries}
+	}
+	rows := make([]report.Row, 0, len(byID))
+	for _, row := range byID {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows
+}
+
+// addListeningPorts adds up to max listening ports to the host node, and
+// the number of those left out.
+func addListeningPorts(rpt *report.Report, hostNodeID string, rows []report.Row, max int) {
+	node := report.MakeNode(hostNodeID)
+	if len(rows) > max {
+		node = node.WithTableTruncationCount(ListeningPortPrefix, len(rows)-max)
+		rows = rows[:max]
+	}
+	rpt.Host.AddNode(node.AddPrefixMulticolumnTable(ListeningPortPrefix, rows))
+	rpt.Host = rpt.Host.WithTableTemplates(ListeningPortTableTemplates)
+}
+
+// ListeningPortTagger names the containers of the processes listening on
+// the host, from the containers in the report.  It's a tagger because
+// containers are reported by the docker or CRI reporters.
+type ListeningPortTagger struct {
+	procRoot string
+}
+
+// NewListeningPortTagger makes a new ListeningPortTagger.
+func NewListeningPortTagger(procRoot string) *ListeningPortTagger {
+	return &ListeningPortTagger{procRoot: procRoot}
+}
+
+// Name of this tagger, for metrics gathering
+func (*ListeningPortTagger) Name() string { return "ListeningPorts" }
+
+// Tag implements Tagger.
+func (t *ListeningPortTagger) Tag(rpt report.Report) (report.Report, error) {
+	template := ListeningPortTableTemplates[ListeningPortPrefix]
+	for id, node := range rpt.Host.Nodes {
+		containers := []report.Row{}
+		for _, row := range node.ExtractMulticolumnTable(template) {
+			pid, ok := row.Entries[ListeningPortPID]
+			if !ok {
+				continue
+			}
+			if name, ok := t.containerName(rpt, pid); ok {
+				containers = append(containers, report.Row{
+					ID:      row.ID,
+					Entries: map[string]string{ListeningPortContainer: name},
+				})
+			}
+		}
+		if len(containers) > 0 {
+			rpt.Host.Nodes[id] = node.AddPrefixMulticolumnTable(ListeningPortPrefix, containers)
+		}
+	}
+	return rpt, nil
+}
+
+// containerName is the name of the container of the process, or the short
+// ID of the container when it isn't in the report.
+func (t *ListeningPortTagger) containerName(rpt report.Report, pid string) (string, bool) {
+	buf, err := fs.ReadFile(path.Join(t.procRoot, pid, "cgroup"))
+	if err != nil {
+		return "", false
+	}
+	id, ok := docker.ContainerIDFromCgroup(string(buf))
+	if !ok {
+		return "", false
+	}
+	if container, ok := rpt.Container.Nodes[report.MakeContainerNodeID(id)]; ok {
+		if name, ok := container.Latest.Lookup(docker.ContainerName); ok {
+			return name, true
+		}
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id, true
+}
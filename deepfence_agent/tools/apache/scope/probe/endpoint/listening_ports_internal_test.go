@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/probe/endpoint/procspy"
+	"github.com/weaveworks/scope/report"
+)
+
+const containerID = "4f7a8a0a4b8c4f5a9d8d3f0c2b1a0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+
+func TestListeningPortRows(t *testing.T) {
+	listeners, _ := procspy.FixedScanner{
+		{LocalAddress: net.ParseIP("0.0.0.0").To4(), LocalPort: 8080, Proc: procspy.Proc{PID: 20, Name: "nginx", NetNamespaceID: 1}},
+		{LocalAddress: net.ParseIP("0.0.0.0").To4(), LocalPort: 8080, Proc: procspy.Proc{PID: 10, Name: "nginx", NetNamespaceID: 1}},
+		{LocalAddress: net.ParseIP("0.0.0.0").To4(), LocalPort: 8080, Proc: procspy.Proc{PID: 30, Name: "nginx", NetNamespaceID: 2}},
+		{LocalAddress: net.ParseIP("::1"), LocalPort: 22},
+	}.Connections()
+	rows := listeningPortRows(listeners)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %v", rows)
+	}
+	if rows[0].Entries[ListeningPortProtocol] != "tcp6" || rows[0].Entries[ListeningPortPort] != "22" {
+		t.Errorf("Expected port 22 first, got %v", rows[0])
+	}
+	if _, ok := rows[0].Entries[ListeningPortPID]; ok {
+		t.Errorf("Expected no PID for an unknown process, got %v", rows[0])
+	}
+	if pid := rows[1].Entries[ListeningPortPID]; pid != "10" {
+		t.Errorf("Expected the lowest PID of a shared port, got %v", rows[1])
+	}
+	if pid := rows[2].Entries[ListeningPortPID]; pid != "30" {
+		t.Errorf("Expected the same port in another namespace, got %v", rows[2])
+	}
+
+	rpt := report.MakeReport()
+	hostNodeID := report.MakeHostNodeID("host")
+	addListeningPorts(&rpt, hostNodeID, rows, 2)
+	table, truncated := rpt.Host.Nodes[hostNodeID].ExtractTable(ListeningPortTableTemplates[ListeningPortPrefix])
+	if len(table) != 2 || truncated != 1 {
+		t.Errorf("Expected 2 rows and 1 more, got %v and %d more", table, truncated)
+	}
+}
+
+func TestListeningPortTagger(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procRoot)
+	for pid, cgroup := range map[string]string{
+		"10": "0::/system.slice/docker-" + containerID + ".scope\n",
+		"20": "0::/user.slice\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(procRoot, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(procRoot, pid, "cgroup"), []byte(cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rpt := report.MakeReport()
+	hostNodeID := report.MakeHostNodeID("host")
+	addListeningPorts(&rpt, hostNodeID, []report.Row{
+		{ID: "08080", Entries: map[string]string{ListeningPortPort: "8080", ListeningPortPID: "10"}},
+		{ID: "00022", Entries: map[string]string{ListeningPortPort: "22", ListeningPortPID: "20"}},
+	}, DefaultMaxListeningPorts)
+	rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID(containerID), map[string]string{
+		docker.ContainerName: "web",
+	}))
+
+	rpt, err = NewListeningPortTagger(procRoot).Tag(rpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := rpt.Host.Nodes[hostNodeID].ExtractMulticolumnTable(ListeningPortTableTemplates[ListeningPortPrefix])
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %v", rows)
+	}
+	if container, ok := rows[0].Entries[ListeningPortContainer]; ok {
+		t.Errorf("Expected no container for a host process, got %q", container)
+	}
+	if container := rows[1].Entries[ListeningPortContainer]; container != "web" {
+		t.Errorf("Expected container web, got %q", container)
+	}
+}
@@ -50,10 +50,32 @@ func toMapping(f conntrack.Conn) *endpointMapping {
 	return &mapping
 }
 
+// backends returns the backends of the DNAT'd connections in the NAT
+// table, by the key of the tuple they were opened with, for the connection
+// tracker to report them to whichever way it tracks connections.
+func (n natMapper) backends() map[string]backend {
+	backends := map[string]backend{}
+	n.flowWalker.walkFlows(func(f conntrack.Conn, _ bool) {
+		if b, ok := flowToBackend(f); ok {
+			backends[b.original.key()] = b
+		}
+	})
+	return backends
+}
+
 // applyNAT duplicates Nodes in the endpoint topology of a report, based on
 // the NAT table.
 func (n natMapper) applyNAT(rpt report.Report, scope string) {
 	n.flowWalker.walkFlows(func(f conntrack.Conn, _ bool) {
+		// Connections opened here and only DNAT'd are already reported to
+		// their backends, so a copy of the backend as the address they were
+		// opened to would duplicate them
+		if f.Orig.Src.Equal(f.Reply.Dst) {
+			fromID := report.MakeEndpointNodeIDB(scope, 0, f.Orig.Src, f.Orig.SrcPort)
+			if via, ok := rpt.Endpoint.Nodes[fromID].Latest.Lookup(ViaServiceIP); ok && via == f.Orig.Dst.String() {
+				return
+			}
+		}
 		mapping := toMapping(f)
 
 		realEndpointID := report.MakeEndpointNodeIDB(scope, 0, mapping.originalIP, mapping.originalPort)
@@ -3,7 +3,9 @@
 package endpoint
 
 import (
+	"errors"
 	"net"
+	"strconv"
 	"syscall"
 	"testing"
 
@@ -129,3 +131,140 @@ func TestNat(t *testing.T) {
 		}
 	}
 }
+
+func tcpFlow(orig, reply conntrack.Tuple, status conntrack.CtStatus) conntrack.Conn {
+	orig.Proto, reply.Proto = syscall.IPPROTO_TCP, syscall.IPPROTO_TCP
+	return conntrack.Conn{MsgType: conntrack.NfctMsgUpdate, Orig: orig, Reply: reply, Status: status, CtId: 1}
+}
+
+// Conntrack entries as listed by `conntrack -L`, of a pod (10.244.1.5)
+// connecting to services, and of a client connecting to a host port.
+var backendTests = []struct {
+	name    string
+	flow    conntrack.Conn
+	backend string
+	service string
+}{
+	{
+		// src=10.244.1.5 dst=10.96.0.10 sport=40000 dport=53 src=10.244.2.7 dst=10.244.1.5 sport=53 dport=40000 [ASSURED]
+		name: "iptables cluster IP",
+		flow: tcpFlow(
+			conntrack.Tuple{Src: net.ParseIP("10.244.1.5"), Dst: net.ParseIP("10.96.0.10"), SrcPort: 40000, DstPort: 53},
+			conntrack.Tuple{Src: net.ParseIP("10.244.2.7"), Dst: net.ParseIP("10.244.1.5"), SrcPort: 53, DstPort: 40000},
+			conntrack.IPS_DST_NAT),
+		backend: "10.244.1.5:40000-10.244.2.7:53",
+		service: "10.96.0.10",
+	},
+	{
+		// IPVS with --masquerade-all, replied to the node:
+		// src=10.244.1.5 dst=10.96.12.34 sport=40001 dport=80 src=10.244.3.9 dst=192.168.1.10 sport=8080 dport=40001 [ASSURED]
+		name: "IPVS cluster IP, masqueraded",
+		flow: tcpFlow(
+			conntrack.Tuple{Src: net.ParseIP("10.244.1.5"), Dst: net.ParseIP("10.96.12.34"), SrcPort: 40001, DstPort: 80},
+			conntrack.Tuple{Src: net.ParseIP("10.244.3.9"), Dst: net.ParseIP("192.168.1.10"), SrcPort: 8080, DstPort: 40001},
+			conntrack.IPS_DST_NAT|conntrack.IPS_SRC_NAT),
+		backend: "10.244.1.5:40001-10.244.3.9:8080",
+		service: "10.96.12.34",
+	},
+	{
+		// src=1.2.3.4 dst=192.168.1.10 sport=50000 dport=30080 src=10.244.1.5 dst=1.2.3.4 sport=80 dport=50000 [ASSURED]
+		name: "host port",
+		flow: tcpFlow(
+			conntrack.Tuple{Src: net.ParseIP("1.2.3.4"), Dst: net.ParseIP("192.168.1.10"), SrcPort: 50000, DstPort: 30080},
+			conntrack.Tuple{Src: net.ParseIP("10.244.1.5"), Dst: net.ParseIP("1.2.3.4"), SrcPort: 80, DstPort: 50000},
+			conntrack.IPS_DST_NAT),
+		backend: "1.2.3.4:50000-10.244.1.5:80",
+		service: "192.168.1.10",
+	},
+	{
+		// Masquerading out of the cluster only:
+		// src=10.244.1.5 dst=8.8.8.8 sport=40002 dport=443 src=8.8.8.8 dst=192.168.1.10 sport=443 dport=40002 [ASSURED]
+		name: "SNAT only",
+		flow: tcpFlow(
+			conntrack.Tuple{Src: net.ParseIP("10.244.1.5"), Dst: net.ParseIP("8.8.8.8"), SrcPort: 40002, DstPort: 443},
+			conntrack.Tuple{Src: net.ParseIP("8.8.8.8"), Dst: net.ParseIP("192.168.1.10"), SrcPort: 443, DstPort: 40002},
+			conntrack.IPS_SRC_NAT),
+	},
+	{
+		name: "no NAT",
+		flow: tcpFlow(
+			conntrack.Tuple{Src: net.ParseIP("10.244.1.5"), Dst: net.ParseIP("10.244.2.7"), SrcPort: 40003, DstPort: 80},
+			conntrack.Tuple{Src: net.ParseIP("10.244.2.7"), Dst: net.ParseIP("10.244.1.5"), SrcPort: 80, DstPort: 40003},
+			0),
+	},
+}
+
+func TestFlowToBackend(t *testing.T) {
+	for _, tc := range backendTests {
+		backend, ok := flowToBackend(tc.flow)
+		if ok != (tc.backend != "") {
+			t.Errorf("%s: expected a backend: %v, got %v", tc.name, tc.backend != "", ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if have := backend.tuple.String(); have != tc.backend {
+			t.Errorf("%s: expected the backend %s, got %s", tc.name, tc.backend, have)
+		}
+		if have := backend.service.String(); have != tc.service {
+			t.Errorf("%s: expected the service %s, got %s", tc.name, tc.service, have)
+		}
+		if backend.original != flowToTuple(tc.flow) {
+			t.Errorf("%s: expected the original tuple %v, got %v", tc.name, flowToTuple(tc.flow), backend.original)
+		}
+	}
+}
+
+func TestNATBackends(t *testing.T) {
+	mtime.NowForce(mtime.Now())
+	defer mtime.NowReset()
+
+	for _, tc := range backendTests {
+		// The backends come from the NAT mapper, so connections are reported
+		// to them however they're tracked: here from their process, as by
+		// the eBPF tracker, which walks no TCP flows
+		walker := &mockFlowWalker{}
+		if tc.flow.Status&conntrack.IPS_NAT_MASK != 0 {
+			// As the NAT mapper walks only those NAT'd
+			walker.flows = append(walker.flows, tc.flow)
+		}
+		nat := makeNATMapper(walker)
+		ct := connectionTracker{
+			conf:            ReporterConfig{HostID: "host1"},
+			flowWalker:      nilFlowWalker{},
+			reverseResolver: newReverseResolver(),
+			flowCounters:    map[string]flowCounters{},
+			backends:        nat.backends(),
+		}
+		ct.reverseResolver.Resolver = func(string) ([]string, error) { return nil, errors.New("no names") }
+		rpt := report.MakeReport()
+		ct.addConnection(&rpt, "host1;<host>", flowToTuple(tc.flow), 42, 0, 0, 1, false)
+		ct.reverseResolver.stop()
+		nat.applyNAT(rpt, "host1")
+
+		var (
+			orig   = tc.flow.Orig
+			fromID = report.MakeEndpointNodeID("host1", "", orig.Src.String(), strconv.Itoa(int(orig.SrcPort)))
+			toID   = report.MakeEndpointNodeID("host1", "", orig.Dst.String(), strconv.Itoa(int(orig.DstPort)))
+		)
+		if tc.backend != "" {
+			reply := tc.flow.Reply
+			toID = report.MakeEndpointNodeID("host1", "", reply.Src.String(), strconv.Itoa(int(reply.SrcPort)))
+		}
+		from, ok := rpt.Endpoint.Nodes[fromID]
+		if !ok || !from.Adjacency.Contains(toID) {
+			t.Errorf("%s: expected a connection %s -> %s, got %v", tc.name, fromID, toID, rpt.Endpoint.Nodes)
+			continue
+		}
+		if have, _ := from.Latest.Lookup(ViaServiceIP); have != tc.service {
+			t.Errorf("%s: expected the service %q, got %q", tc.name, tc.service, have)
+		}
+		// Nor is the backend copied as the service
+		for id, n := range rpt.Endpoint.Nodes {
+			if copyOf, ok := n.Latest.Lookup(CopyOf); ok && copyOf == toID {
+				t.Errorf("%s: expected no copy of the backend, got %s", tc.name, id)
+			}
+		}
+	}
+}
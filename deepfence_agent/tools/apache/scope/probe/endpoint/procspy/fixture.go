@@ -23,5 +23,11 @@ func (s FixedScanner) Connections() (ConnIter, error) {
 	return &iter, nil
 }
 
+// Listeners implements ConnectionsScanner.Listeners, with no listening
+// sockets
+func (s FixedScanner) Listeners() (ConnIter, error) {
+	return &fixedConnIter{}, nil
+}
+
 // Stop implements ConnectionsScanner.Stop (dummy since there is no background work)
 func (s FixedScanner) Stop() {}
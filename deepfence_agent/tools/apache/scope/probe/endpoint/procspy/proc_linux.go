@@ -41,13 +41,15 @@ type pidWalker struct {
 	tickc       <-chan time.Time // Rate-limit clock. Sets the pace when traversing namespaces and /proc/PID/fd/* files.
 	stopc       chan struct{}    // Abort walk
 	fdBlockSize uint64           // Maximum number of /proc/PID/fd/* files to stat() per tick
+	udp         bool             // Read /proc/PID/net/udp{,6} too
 }
 
-func newPidWalker(walker process.Walker, tickc <-chan time.Time, fdBlockSize uint64) pidWalker {
+func newPidWalker(walker process.Walker, tickc <-chan time.Time, fdBlockSize uint64, udp bool) pidWalker {
 	w := pidWalker{
 		walker:      walker,
 		tickc:       tickc,
 		fdBlockSize: fdBlockSize,
+		udp:         udp,
 		stopc:       make(chan struct{}),
 	}
 	return w
@@ -98,6 +100,15 @@ func getNetNamespacePathSuffix() string {
 
 // ReadTCPFiles reads the proc files tcp and tcp6 for a pid
 func ReadTCPFiles(pid int, buf *bytes.Buffer) (int64, error) {
+	return readNetFiles(pid, "tcp", buf)
+}
+
+// ReadUDPFiles reads the proc files udp and udp6 for a pid
+func ReadUDPFiles(pid int, buf *bytes.Buffer) (int64, error) {
+	return readNetFiles(pid, "udp", buf)
+}
+
+func readNetFiles(pid int, transport string, buf *bytes.Buffer) (int64, error) {
 	var (
 		errRead  error
 		errRead6 error
@@ -108,9 +119,9 @@ func ReadTCPFiles(pid int, buf *bytes.Buffer) (int64, error) {
 	// even for tcp4 connections, we need to read the "tcp6" file because of IPv4-Mapped IPv6 Addresses
 
 	dirName := strconv.Itoa(pid)
-	read, errRead = readFile(filepath.Join(procRoot, dirName, "/net/tcp"), buf)
+	read, errRead = readFile(filepath.Join(procRoot, dirName, "/net", transport), buf)
 	if ipv6IsSupported {
-		read6, errRead6 = readFile(filepath.Join(procRoot, dirName, "/net/tcp6"), buf)
+		read6, errRead6 = readFile(filepath.Join(procRoot, dirName, "/net", transport+"6"), buf)
 	}
 
 	if errRead != nil {
@@ -120,9 +131,9 @@ func ReadTCPFiles(pid int, buf *bytes.Buffer) (int64, error) {
 }
 
 // Read the connections for a group of processes living in the same namespace,
-// which are found (identically) in /proc/PID/net/tcp{,6} for any of the
-// processes.
-func readProcessConnections(buf *bytes.Buffer, namespaceProcs []*process.Process) (bool, error) {
+// which are found (identically) in /proc/PID/net/tcp{,6} (and udp{,6}) for
+// any of the processes.
+func readProcessConnections(buf *bytes.Buffer, namespaceProcs []*process.Process, udp bool) (bool, error) {
 	var (
 		read int64
 		err  error
@@ -133,6 +144,11 @@ func readProcessConnections(buf *bytes.Buffer, namespaceProcs []*process.Process
 			// try next process
 			continue
 		}
+		if udp {
+			if readUDP, errUDP := ReadUDPFiles(p.PID, buf); errUDP == nil {
+				read += readUDP
+			}
+		}
 		// Return after succeeding on any process
 		// (proc/PID/net/tcp and proc/PID/net/tcp6 are identical for all the processes in the same namespace)
 		return read > 0, nil
@@ -148,7 +164,7 @@ func readProcessConnections(buf *bytes.Buffer, namespaceProcs []*process.Process
 // walkNamespace does the work of walk for a single namespace
 func (w pidWalker) walkNamespace(namespaceID uint32, buf *bytes.Buffer, sockets map[uint64]*Proc, namespaceProcs []*process.Process) error {
 
-	if found, err := readProcessConnections(buf, namespaceProcs); err != nil || !found {
+	if found, err := readProcessConnections(buf, namespaceProcs, w.udp); err != nil || !found {
 		return err
 	}
 
@@ -171,7 +187,7 @@ func (w pidWalker) walkNamespace(namespaceID uint32, buf *bytes.Buffer, sockets
 			fdBlockCount = 0
 			// read the connections again to
 			// avoid the race between between /net/tcp{,6} and /proc/PID/fd/*
-			if found, err := readProcessConnections(buf, namespaceProcs[i:]); err != nil || !found {
+			if found, err := readProcessConnections(buf, namespaceProcs[i:], w.udp); err != nil || !found {
 				return err
 			}
 		}
@@ -5,26 +5,54 @@ import (
 	"net"
 )
 
-// Used to check whether we are parsing a header line
-var slHeader = []byte("sl")
+// Used to check whether we are parsing a header line, and whether it is the
+// header of /proc/net/udp{,6}, which alone has a drops column
+var (
+	slHeader    = []byte("sl")
+	dropsHeader = []byte("drops")
+)
 
-// ProcNet is an iterator to parse /proc/net/tcp{,6} files.
+// ProcNet is an iterator to parse /proc/net/tcp{,6} and /proc/net/udp{,6}
+// files, which may be concatenated.  Connected UDP sockets are in the
+// established state.
 type ProcNet struct {
 	b                       []byte
 	c                       Connection
 	bytesLocal, bytesRemote [16]byte
 	seen                    map[uint64]struct{}
+	listening               bool
 }
 
 // NewProcNet gives a new ProcNet parser.
 func NewProcNet(b []byte) *ProcNet {
 	return &ProcNet{
 		b:    b,
-		c:    Connection{},
+		c:    Connection{Transport: TCP},
 		seen: map[uint64]struct{}{},
 	}
 }
 
+// NewListeningProcNet gives a new ProcNet parser, which returns the
+// listening sockets instead of the connections.
+func NewListeningProcNet(b []byte) *ProcNet {
+	p := NewProcNet(b)
+	p.listening = true
+	return p
+}
+
+// wanted says whether to return sockets in the given state.
+func (p *ProcNet) wanted(state uint) bool {
+	if p.listening {
+		return state == tcpListen
+	}
+	switch state {
+	// Only process established or half-closed connections
+	case tcpEstablished, tcpFinWait1, tcpFinWait2, tcpCloseWait:
+		return true
+	}
+	return false
+}
+
 // Next returns the next connection. All buffers are re-used, so if you want
 // to keep the IPs you have to copy them.
 func (p *ProcNet) Next() *Connection {
@@ -40,17 +68,23 @@ again:
 
 	sl, b = nextField(b) // 'sl' column
 	if bytes.Equal(sl, slHeader) {
-		// Skip header
+		// Skip header, noting the transport of the lines below it
 		p.b = nextLine(b)
+		header := b
+		if len(p.b) > 0 {
+			header = b[:len(b)-len(p.b)]
+		}
+		if bytes.Contains(header, dropsHeader) {
+			p.c.Transport = UDP
+		} else {
+			p.c.Transport = TCP
+		}
 		goto again
 	}
 	local, b = nextField(b)
 	remote, b = nextField(b)
 	state, b = nextField(b)
-	switch parseHex(state) {
-	// Only process established or half-closed connections
-	case tcpEstablished, tcpFinWait1, tcpFinWait2, tcpCloseWait:
-	default:
+	if !p.wanted(parseHex(state)) {
 		p.b = nextLine(b)
 		goto again
 	}
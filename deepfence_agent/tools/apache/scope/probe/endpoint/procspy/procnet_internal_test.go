@@ -16,6 +16,7 @@ func TestProcNet(t *testing.T) {
 	p := NewProcNet([]byte(testString))
 	expected := []Connection{
 		{
+			Transport:     TCP,
 			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
 			LocalPort:     0xa6c0,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
@@ -23,6 +24,7 @@ func TestProcNet(t *testing.T) {
 			Inode:         5107,
 		},
 		{
+			Transport:     TCP,
 			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
 			LocalPort:     0x006f,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
@@ -30,6 +32,7 @@ func TestProcNet(t *testing.T) {
 			Inode:         5084,
 		},
 		{
+			Transport:     TCP,
 			LocalAddress:  net.IP([]byte{0x7f, 0x0, 0x0, 0x01}),
 			LocalPort:     0x0019,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
@@ -37,6 +40,7 @@ func TestProcNet(t *testing.T) {
 			Inode:         10550,
 		},
 		{
+			Transport:     TCP,
 			LocalAddress:  net.IP([]byte{0x2e, 0xf6, 0x2c, 0xa1}),
 			LocalPort:     0xe4d7,
 			RemoteAddress: net.IP([]byte{0xc0, 0x1e, 0xfc, 0x57}),
@@ -68,6 +72,7 @@ func TestTransport6(t *testing.T) {
 	expected := []Connection{
 		{
 			// state:         10,
+			Transport:     TCP,
 			LocalAddress:  net.IP(make([]byte, 16)),
 			LocalPort:     0x19c8,
 			RemoteAddress: net.IP(make([]byte, 16)),
@@ -77,6 +82,7 @@ func TestTransport6(t *testing.T) {
 		},
 		{
 			// state: 1,
+			Transport: TCP,
 			LocalAddress: net.IP([]byte{
 				0x20, 0x03, 0, 0x45,
 				0x2b, 0x69, 0xbe, 0x00,
@@ -117,6 +123,7 @@ broken line
 	p := NewProcNet([]byte(testString))
 	expected := []Connection{
 		{
+			Transport:     TCP,
 			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
 			LocalPort:     0xa6c0,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
@@ -144,6 +151,7 @@ func TestProcNetFiltersDuplicates(t *testing.T) {
 `
 	p := NewProcNet([]byte(testString))
 	expected := Connection{
+		Transport:     TCP,
 		LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
 		LocalPort:     0xa6c0,
 		RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
@@ -160,3 +168,72 @@ func TestProcNetFiltersDuplicates(t *testing.T) {
 	}
 
 }
+
+func TestListeningProcNet(t *testing.T) {
+	testString := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout Inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 5107 1 ffff8800a6aaf040 100 0 0 10 0
+   1: 0100007F:0019 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 10550 1 ffff8800a729b780 100 0 0 10 0
+   2: A12CF62E:0016 57FC1EC0:E4D7 01 00000000:00000000 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1
+`
+	p := NewListeningProcNet([]byte(testString))
+	for _, want := range []Connection{
+		{
+			Transport:     TCP,
+			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
+			LocalPort:     0x0016,
+			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
+			Inode:         5107,
+		},
+		{
+			Transport:     TCP,
+			LocalAddress:  net.IP([]byte{0x7f, 0x0, 0x0, 0x01}),
+			LocalPort:     0x0019,
+			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
+			Inode:         10550,
+		},
+	} {
+		if have := p.Next(); have == nil || !reflect.DeepEqual(*have, want) {
+			t.Errorf("Expected %+v, got %+v", want, have)
+		}
+	}
+	if got := p.Next(); got != nil {
+		t.Errorf("Expected established connections to be skipped, got %+v", got)
+	}
+}
+
+func TestProcNetUDP(t *testing.T) {
+	// /proc/net/tcp followed by /proc/net/udp, as the scanner reads them
+	testString := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout Inode
+   0: A12CF62E:E4D7 57FC1EC0:01BB 01 00000000:00000000 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1
+   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  123: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 18542 2 ffff9d4b4a1e0000 0
+  456: A12CF62E:C5A1 57FC1EC0:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 639480 2 ffff9d4b4a1e0400 0
+`
+	p := NewProcNet([]byte(testString))
+	for _, want := range []Connection{
+		{
+			Transport:     TCP,
+			LocalAddress:  net.IP([]byte{0x2e, 0xf6, 0x2c, 0xa1}),
+			LocalPort:     0xe4d7,
+			RemoteAddress: net.IP([]byte{0xc0, 0x1e, 0xfc, 0x57}),
+			RemotePort:    0x01bb,
+			Inode:         639474,
+		},
+		{
+			// The unconnected socket of the resolver is skipped
+			Transport:     UDP,
+			LocalAddress:  net.IP([]byte{0x2e, 0xf6, 0x2c, 0xa1}),
+			LocalPort:     0xc5a1,
+			RemoteAddress: net.IP([]byte{0xc0, 0x1e, 0xfc, 0x57}),
+			RemotePort:    0x01bb,
+			Inode:         639480,
+		},
+	} {
+		if have := p.Next(); have == nil || !reflect.DeepEqual(*have, want) {
+			t.Errorf("Expected %+v, got %+v", want, have)
+		}
+	}
+	if got := p.Next(); got != nil {
+		t.Errorf("p.Next() wasn't empty")
+	}
+}
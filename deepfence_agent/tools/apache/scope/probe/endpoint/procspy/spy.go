@@ -13,9 +13,17 @@ const (
 	tcpFinWait1    = 4
 	tcpFinWait2    = 5
 	tcpCloseWait   = 8
+	tcpListen      = 10
 )
 
-// Connection is a (TCP) connection. The Proc struct might not be filled in.
+// Transports of connections.
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
+// Connection is a (TCP or connected UDP) connection. The Proc struct might
+// not be filled in.
 type Connection struct {
 	Transport     string
 	LocalAddress  net.IP
@@ -42,6 +50,9 @@ type ConnIter interface {
 type ConnectionScanner interface {
 	// Connections returns all established (TCP) connections.
 	Connections() (ConnIter, error)
+	// Listeners returns all listening (TCP) sockets, as connections with
+	// no remote address.
+	Listeners() (ConnIter, error)
 	// Stops the scanning
 	Stop()
 }
@@ -14,12 +14,13 @@ const (
 )
 
 // NewConnectionScanner creates a new Darwin ConnectionScanner
-func NewConnectionScanner(_ process.Walker, processes bool) ConnectionScanner {
+// UDP is not reported on Darwin.
+func NewConnectionScanner(_ process.Walker, processes, _ bool) ConnectionScanner {
 	return &darwinScanner{processes}
 }
 
 // NewSyncConnectionScanner creates a new synchronous Darwin ConnectionScanner
-func NewSyncConnectionScanner(_ process.Walker, processes bool) ConnectionScanner {
+func NewSyncConnectionScanner(_ process.Walker, processes, _ bool) ConnectionScanner {
 	return &darwinScanner{processes}
 }
 
@@ -75,5 +76,10 @@ func (s *darwinScanner) Connections() (ConnIter, error) {
 	return &f, nil
 }
 
+// Listeners returns no listening sockets, as they aren't reported on Darwin.
+func (s *darwinScanner) Listeners() (ConnIter, error) {
+	return &fixedConnIter{}, nil
+}
+
 // Nothing to stop since there's nothing running in the background
 func (s *darwinScanner) Stop() {}
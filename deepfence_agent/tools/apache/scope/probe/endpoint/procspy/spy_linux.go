@@ -37,29 +37,39 @@ func (c *pnConnIter) Next() *Connection {
 	return n
 }
 
-// NewConnectionScanner creates a new Linux ConnectionScanner
-func NewConnectionScanner(walker process.Walker, processes bool) ConnectionScanner {
-	scanner := &linuxScanner{}
+// NewConnectionScanner creates a new Linux ConnectionScanner, of the
+// connected UDP sockets too if udp is set
+func NewConnectionScanner(walker process.Walker, processes, udp bool) ConnectionScanner {
+	scanner := &linuxScanner{udp: udp}
 	if processes {
-		scanner.r = newBackgroundReader(walker)
+		scanner.r = newBackgroundReader(walker, udp)
 	}
 	return scanner
 }
 
 // NewSyncConnectionScanner creates a new synchronous Linux ConnectionScanner
-func NewSyncConnectionScanner(walker process.Walker, processes bool) ConnectionScanner {
-	scanner := &linuxScanner{}
+func NewSyncConnectionScanner(walker process.Walker, processes, udp bool) ConnectionScanner {
+	scanner := &linuxScanner{udp: udp}
 	if processes {
-		scanner.r = newForegroundReader(walker)
+		scanner.r = newForegroundReader(walker, udp)
 	}
 	return scanner
 }
 
 type linuxScanner struct {
-	r reader
+	r   reader
+	udp bool
 }
 
 func (s *linuxScanner) Connections() (ConnIter, error) {
+	return s.scan(NewProcNet)
+}
+
+func (s *linuxScanner) Listeners() (ConnIter, error) {
+	return s.scan(NewListeningProcNet)
+}
+
+func (s *linuxScanner) scan(newProcNet func([]byte) *ProcNet) (ConnIter, error) {
 	// buffer for contents of /proc/<pid>/net/tcp
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
@@ -77,10 +87,16 @@ func (s *linuxScanner) Connections() (ConnIter, error) {
 		if ipv6IsSupported {
 			readFile(procRoot+"/net/tcp6", buf)
 		}
+		if s.udp {
+			readFile(procRoot+"/net/udp", buf)
+			if ipv6IsSupported {
+				readFile(procRoot+"/net/udp6", buf)
+			}
+		}
 	}
 
 	return &pnConnIter{
-		pn:    NewProcNet(buf.Bytes()),
+		pn:    newProcNet(buf.Bytes()),
 		buf:   buf,
 		procs: procs,
 	}, nil
@@ -14,7 +14,7 @@ import (
 func TestLinuxConnections(t *testing.T) {
 	fs_hook.Mock(mockFS)
 	defer fs_hook.Restore()
-	scanner := NewConnectionScanner(process.NewWalker("/proc", false), true)
+	scanner := NewConnectionScanner(process.NewWalker("/proc", false), true, false)
 	defer scanner.Stop()
 
 	// let the background scanner finish its first pass
@@ -26,6 +26,7 @@ func TestLinuxConnections(t *testing.T) {
 	}
 	have := iter.Next()
 	want := &Connection{
+		Transport:     TCP,
 		LocalAddress:  net.ParseIP("0.0.0.0").To4(),
 		LocalPort:     42688,
 		RemoteAddress: net.ParseIP("0.0.0.0").To4(),
@@ -1,31 +1,65 @@
 package endpoint
 
 import (
+	"time"
+
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/probe/endpoint/procspy"
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("endpoint")
+
 // Node metadata keys.
 const (
 	ReverseDNSNames = report.ReverseDNSNames
 	SnoopedDNSNames = report.SnoopedDNSNames
+	DNSName         = report.DNSName
 	CopyOf          = report.CopyOf
+	ProtocolHint    = report.ProtocolHint
+
+	// ViaServiceIP is set on the endpoint which opened a connection DNAT'd
+	// to a backend, as to the cluster IP of a Kubernetes service, to that
+	// IP.
+	ViaServiceIP = "via_service_ip"
+
+	// DNSOverTLS is set on the host node when the DNS snooper saw DNS over
+	// TLS, which it cannot read.
+	DNSOverTLS = "dns_over_tls"
 )
 
+// DNSMetadataTemplates are the DNS keys of the host node.
+var DNSMetadataTemplates = report.MetadataTemplates{
+	DNSOverTLS: {ID: DNSOverTLS, Label: "DNS over TLS", From: report.FromLatest, Priority: 40},
+}
+
 // ReporterConfig are the config options for the endpoint reporter.
 type ReporterConfig struct {
-	HostID       string
-	HostName     string
-	SpyProcs     bool
-	UseConntrack bool
-	WalkProc     bool
-	UseEbpfConn  bool
-	ProcRoot     string
-	BufferSize   int
-	ProcessCache *process.CachingWalker
-	Scanner      procspy.ConnectionScanner
-	DNSSnooper   *DNSSnooper
+	HostID            string
+	HostName          string
+	SpyProcs          bool
+	UseConntrack      bool
+	WalkProc          bool
+	UseEbpfConn       bool
+	ProcRoot          string
+	BufferSize        int
+	ProcessCache      *process.CachingWalker
+	Scanner           procspy.ConnectionScanner
+	DNSSnooper        *DNSSnooper
+	TLSSnooper        *TLSSnooper
+	MaxListeningPorts int           // Rows of the listening ports table of the host; zero disables it
+	UDPIdleExpiry     time.Duration // How long UDP flows live after their last packet, by conntrack's counters; zero disables UDP
+
+	// Past MaxConnections connections in a report, those of clients are
+	// aggregated by source and destination, or dropped when
+	// TruncateConnections; zero means no limit
+	MaxConnections      int
+	TruncateConnections bool
+
+	// Enable conntrack accounting at startup, for the byte and packet
+	// counters of connections
+	ConntrackAccounting bool
 }
 
 // Name of this reporter, for metrics gathering
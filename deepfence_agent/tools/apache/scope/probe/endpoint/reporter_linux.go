@@ -3,14 +3,23 @@
 package endpoint
 
 import (
+	"sync/atomic"
+
+	"github.com/weaveworks/scope/probe/endpoint/procspy"
 	"github.com/weaveworks/scope/report"
 )
 
+// shedMaxConnections is the most connections reported while shedding
+// detail: past it, those of clients are aggregated by source and
+// destination, keeping every edge.
+const shedMaxConnections = 1
+
 // Reporter generates Reports containing the Endpoint topology.
 type Reporter struct {
 	conf              ReporterConfig
 	connectionTracker connectionTracker
 	natMapper         natMapper
+	shed              int32 // atomic
 }
 
 // NewReporter creates a new Reporter that invokes procspy.Connections to
@@ -19,13 +28,34 @@ type Reporter struct {
 // is stored in the Endpoint topology. It optionally enriches that topology
 // with process (PID) information.
 func NewReporter(conf ReporterConfig) *Reporter {
+	if conf.UseConntrack && conf.ConntrackAccounting {
+		if err := enableConntrackAccounting(conf.ProcRoot); err != nil {
+			log.Warnf("Cannot enable conntrack accounting, not reporting the bytes of connections: %v", err)
+		}
+	}
+	// eBPF tracks connections but not listening sockets, so the scanner
+	// walks /proc for those even when eBPF is used.
+	if conf.WalkProc && conf.Scanner == nil {
+		conf.Scanner = procspy.NewConnectionScanner(conf.ProcessCache, conf.SpyProcs, conf.UDPIdleExpiry > 0)
+	}
 	return &Reporter{
 		conf:              conf,
 		connectionTracker: newConnectionTracker(conf),
-		natMapper:         makeNATMapper(newConntrackFlowWalker(conf.UseConntrack, conf.ProcRoot, conf.BufferSize, true /* natOnly */)),
+		natMapper:         makeNATMapper(newConntrackFlowWalker(conf.UseConntrack, conf.ProcRoot, conf.BufferSize, true /* natOnly */, true, conf.UDPIdleExpiry)),
 	}
 }
 
+// Shed sets the reporter to shed the detail of connections: their
+// endpoints are aggregated, as by -probe.endpoint.max-connections, and the
+// listening ports of the host left out.
+func (r *Reporter) Shed(shed bool) {
+	var value int32
+	if shed {
+		value = 1
+	}
+	atomic.StoreInt32(&r.shed, value)
+}
+
 // Stop stop stop
 func (r *Reporter) Stop() {
 	r.connectionTracker.Stop()
@@ -35,11 +65,30 @@ func (r *Reporter) Stop() {
 	}
 }
 
+// Limit implements probe.Limiter, limiting the connections of each
+// published report to MaxConnections.
+func (r *Reporter) Limit(rpt *report.Report) {
+	limitConnections(rpt, r.conf.HostID, r.conf.MaxConnections, r.conf.TruncateConnections)
+}
+
 // Report implements Reporter.
 func (r *Reporter) Report() (report.Report, error) {
 	rpt := report.MakeReport()
 
+	// The backends are known before reporting any connection
+	r.connectionTracker.backends = r.natMapper.backends()
 	r.connectionTracker.ReportConnections(&rpt)
+	if atomic.LoadInt32(&r.shed) == 1 {
+		// Every connection is still reported, if not every endpoint, and
+		// /proc isn't walked for the listening ports
+		limitConnections(&rpt, r.conf.HostID, shedMaxConnections, false)
+	} else {
+		r.connectionTracker.ReportListeningPorts(&rpt, r.conf.MaxListeningPorts)
+	}
 	r.natMapper.applyNAT(rpt, r.conf.HostID)
+	if r.conf.DNSSnooper.SawDNSOverTLS() {
+		rpt.Host.AddNode(report.MakeNodeWith(report.MakeHostNodeID(r.conf.HostID), map[string]string{DNSOverTLS: "true"}))
+		rpt.Host = rpt.Host.WithMetadataTemplates(DNSMetadataTemplates)
+	}
 	return rpt, nil
 }
@@ -12,6 +12,12 @@ func NewReporter(conf ReporterConfig) *Reporter {
 	return &Reporter{}
 }
 
+// Shed dummy
+func (r *Reporter) Shed(bool) {}
+
+// Limit dummy
+func (r *Reporter) Limit(*report.Report) {}
+
 // Stop dummy
 func (r *Reporter) Stop() {}
 
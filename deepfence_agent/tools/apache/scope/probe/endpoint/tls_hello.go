@@ -0,0 +1,248 @@
+package endpoint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// Node metadata keys of the TLS handshakes of connections, set on the
+// endpoint which opened them.
+const (
+	TLSServerName = "tls_server_name"
+	TLSVersion    = "tls_version"
+	TLSALPN       = "tls_alpn"
+)
+
+const (
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsHandshakeServerHello = 0x02
+
+	tlsExtensionServerName        = 0
+	tlsExtensionALPN              = 16
+	tlsExtensionSupportedVersions = 43
+
+	maxTLSHandshakes       = 10000
+	tlsHandshakeExpiration = 10 * time.Minute
+)
+
+var tlsVersionNames = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// tlsHello is what a ClientHello or ServerHello says about a connection.
+type tlsHello struct {
+	server     bool // whether it's a ServerHello
+	serverName string
+	alpn       []string
+	version    uint16
+}
+
+// tlsReader reads the fields of TLS messages, failing once past their end.
+type tlsReader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if !r.ok || n > len(r.b) {
+		r.ok = false
+		return nil
+	}
+	result := r.b[:n]
+	r.b = r.b[n:]
+	return result
+}
+
+func (r *tlsReader) uint8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *tlsReader) uint16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *tlsReader) uint24() int {
+	if b := r.bytes(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// vector reads a vector with a length of lengthSize bytes.
+func (r *tlsReader) vector(lengthSize int) *tlsReader {
+	var length int
+	switch lengthSize {
+	case 1:
+		length = r.uint8()
+	case 2:
+		length = r.uint16()
+	}
+	return &tlsReader{b: r.bytes(length), ok: r.ok}
+}
+
+// parseTLSHello parses the first TLS record of a TCP payload, if it's a
+// ClientHello or a ServerHello.  Hellos can be split over several
+// segments, so the extensions are parsed as far as they go.
+func parseTLSHello(payload []byte) (tlsHello, bool) {
+	var hello tlsHello
+	record := &tlsReader{b: payload, ok: true}
+	if record.uint8() != tlsRecordHandshake {
+		return hello, false
+	}
+	record.bytes(4) // version and length of the record
+	handshakeType := record.uint8()
+	record.uint24()
+	switch handshakeType {
+	case tlsHandshakeClientHello:
+	case tlsHandshakeServerHello:
+		hello.server = true
+	default:
+		return hello, false
+	}
+	hello.version = uint16(record.uint16())
+	record.bytes(32) // random
+	record.vector(1) // session id
+	if hello.server {
+		record.bytes(3) // cipher suite and compression method
+	} else {
+		record.vector(2) // cipher suites
+		record.vector(1) // compression methods
+	}
+	if !record.ok {
+		return hello, false
+	}
+
+	// Truncated extensions are read up to where they are cut
+	extensionsLength := record.uint16()
+	if extensionsLength < len(record.b) {
+		record.b = record.b[:extensionsLength]
+	}
+	for len(record.b) >= 4 {
+		extensionType := record.uint16()
+		extension := record.vector(2)
+		if !extension.ok {
+			break
+		}
+		switch extensionType {
+		case tlsExtensionServerName:
+			names := extension.vector(2)
+			for names.ok && len(names.b) > 0 {
+				nameType, name := names.uint8(), names.vector(2)
+				if name.ok && nameType == 0 {
+					hello.serverName = string(name.b)
+					break
+				}
+			}
+		case tlsExtensionALPN:
+			protocols := extension.vector(2)
+			for protocols.ok && len(protocols.b) > 0 {
+				if protocol := protocols.vector(1); protocol.ok {
+					hello.alpn = append(hello.alpn, string(protocol.b))
+				}
+			}
+		case tlsExtensionSupportedVersions:
+			// Only the ServerHello has the negotiated version; the
+			// ClientHello lists those offered
+			if hello.server {
+				if version := extension.uint16(); extension.ok {
+					hello.version = uint16(version)
+				}
+			}
+		}
+	}
+	return hello, true
+}
+
+// tlsHandshake is what the hellos of a connection say about it.
+type tlsHandshake struct {
+	serverName string
+	alpn       []string
+	version    uint16
+	done       bool // whether the ServerHello was seen
+}
+
+// tlsHandshakes are the handshakes of connections, by their tuples from
+// the client to the server.
+type tlsHandshakes struct {
+	sync.Mutex
+	cache gcache.Cache
+}
+
+func newTLSHandshakes() *tlsHandshakes {
+	return &tlsHandshakes{
+		cache: gcache.New(maxTLSHandshakes).LRU().Expiration(tlsHandshakeExpiration).Build(),
+	}
+}
+
+func tlsHandshakeKey(client net.IP, clientPort uint16, server net.IP, serverPort uint16) string {
+	return net.JoinHostPort(client.String(), strconv.Itoa(int(clientPort))) + "-" +
+		net.JoinHostPort(server.String(), strconv.Itoa(int(serverPort)))
+}
+
+// record notes a hello of the connection; later segments of the handshake
+// are ignored.
+func (h *tlsHandshakes) record(client net.IP, clientPort uint16, server net.IP, serverPort uint16, hello tlsHello) {
+	key := tlsHandshakeKey(client, clientPort, server, serverPort)
+	h.Lock()
+	defer h.Unlock()
+	value, err := h.cache.Get(key)
+	switch {
+	case !hello.server && err != nil:
+		h.cache.Set(key, tlsHandshake{serverName: hello.serverName, alpn: hello.alpn, version: hello.version})
+	case hello.server && err == nil:
+		handshake := value.(tlsHandshake)
+		if handshake.done {
+			return
+		}
+		handshake.version = hello.version
+		// The ALPN of TLS 1.3 servers is encrypted, so it stays the offered
+		if len(hello.alpn) > 0 {
+			handshake.alpn = hello.alpn
+		}
+		handshake.done = true
+		h.cache.Set(key, handshake)
+	}
+}
+
+// latests returns the node metadata of the handshake of the connection,
+// if it was seen.
+func (h *tlsHandshakes) latests(client net.IP, clientPort uint16, server net.IP, serverPort uint16) (map[string]string, bool) {
+	value, err := h.cache.Get(tlsHandshakeKey(client, clientPort, server, serverPort))
+	if err != nil {
+		return nil, false
+	}
+	handshake := value.(tlsHandshake)
+	result := map[string]string{TLSVersion: tlsVersionName(handshake.version)}
+	if handshake.serverName != "" {
+		result[TLSServerName] = handshake.serverName
+	}
+	if len(handshake.alpn) > 0 {
+		result[TLSALPN] = strings.Join(handshake.alpn, ",")
+	}
+	return result, true
+}
@@ -0,0 +1,120 @@
+package endpoint
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// clientHello captures the first record crypto/tls sends.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	if _, err := io.ReadFull(server, record); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, record...)
+}
+
+// serverHello makes a ServerHello, with the supported_versions extension
+// of TLS 1.3 if version is 0x0304.
+func serverHello(version uint16, alpn string) []byte {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session id
+	body = append(body, 0x13, 0x01, 0)       // cipher suite, compression
+	extensions := []byte{}
+	if version == 0x0304 {
+		extensions = append(extensions, 0, tlsExtensionSupportedVersions, 0, 2, 0x03, 0x04)
+	}
+	if alpn != "" {
+		extensions = append(extensions, 0, tlsExtensionALPN, 0, byte(len(alpn)+3), 0, byte(len(alpn)+1), byte(len(alpn)))
+		extensions = append(extensions, alpn...)
+	}
+	body = append(body, byte(len(extensions)>>8), byte(len(extensions)))
+	body = append(body, extensions...)
+	handshake := append([]byte{tlsHandshakeServerHello, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{tlsRecordHandshake, 0x03, 0x03, byte(len(handshake) >> 8), byte(len(handshake))}, handshake...)
+}
+
+func TestParseTLSClientHello(t *testing.T) {
+	payload := clientHello(t, &tls.Config{ServerName: "api.example.com", NextProtos: []string{"h2", "http/1.1"}})
+	hello, ok := parseTLSHello(payload)
+	if !ok {
+		t.Fatalf("Expected a ClientHello")
+	}
+	want := tlsHello{serverName: "api.example.com", alpn: []string{"h2", "http/1.1"}, version: 0x0303}
+	if !reflect.DeepEqual(want, hello) {
+		t.Errorf("Expected %+v, got %+v", want, hello)
+	}
+
+	// A hello cut before the end of its extensions still has those before
+	if hello, ok := parseTLSHello(payload[:len(payload)-20]); !ok || hello.serverName != "api.example.com" {
+		t.Errorf("Expected the server name of a truncated hello, got %+v", hello)
+	}
+	if _, ok := parseTLSHello(payload[:20]); ok {
+		t.Errorf("Expected a hello cut before its extensions not to parse")
+	}
+	if _, ok := parseTLSHello([]byte("GET / HTTP/1.1\r\n")); ok {
+		t.Errorf("Expected HTTP not to parse")
+	}
+}
+
+func TestParseTLSServerHello(t *testing.T) {
+	hello, ok := parseTLSHello(serverHello(0x0304, ""))
+	if want := (tlsHello{server: true, version: 0x0304}); !ok || !reflect.DeepEqual(want, hello) {
+		t.Errorf("Expected %+v, got %+v", want, hello)
+	}
+	hello, ok = parseTLSHello(serverHello(0x0303, "h2"))
+	if want := (tlsHello{server: true, version: 0x0303, alpn: []string{"h2"}}); !ok || !reflect.DeepEqual(want, hello) {
+		t.Errorf("Expected %+v, got %+v", want, hello)
+	}
+}
+
+func TestTLSHandshakes(t *testing.T) {
+	var (
+		client = net.ParseIP("10.0.47.2")
+		server = net.ParseIP("93.184.216.34")
+		h      = newTLSHandshakes()
+	)
+	if _, ok := h.latests(client, 40000, server, 443); ok {
+		t.Errorf("Expected no handshake")
+	}
+
+	// A ServerHello without a ClientHello is ignored
+	h.record(client, 40000, server, 443, tlsHello{server: true, version: 0x0304})
+	if _, ok := h.latests(client, 40000, server, 443); ok {
+		t.Errorf("Expected no handshake")
+	}
+
+	h.record(client, 40000, server, 443, tlsHello{serverName: "example.com", alpn: []string{"h2", "http/1.1"}, version: 0x0303})
+	want := map[string]string{TLSServerName: "example.com", TLSALPN: "h2,http/1.1", TLSVersion: "TLS 1.2"}
+	if have, _ := h.latests(client, 40000, server, 443); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+
+	h.record(client, 40000, server, 443, tlsHello{server: true, version: 0x0304})
+	want[TLSVersion] = "TLS 1.3"
+	if have, _ := h.latests(client, 40000, server, 443); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+
+	// Hellos after the ServerHello, of a renegotiation, are ignored
+	h.record(client, 40000, server, 443, tlsHello{server: true, version: 0x0301})
+	h.record(client, 40000, server, 443, tlsHello{serverName: "other.com", version: 0x0301})
+	if have, _ := h.latests(client, 40000, server, 443); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+}
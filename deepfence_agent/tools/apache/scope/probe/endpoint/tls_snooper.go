@@ -0,0 +1,179 @@
+// +build linux,amd64 linux,ppc64le
+
+// Build constraint to use this file for amd64 & ppc64le on Linux
+
+package endpoint
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	// Hellos are read from the first segment of their records
+	tlsSnapLen = 2048
+)
+
+// TLSSnooper reads the handshakes of TLS connections, for the server names,
+// ALPN protocols and versions of the servers which workloads talk to.
+type TLSSnooper struct {
+	stop       chan struct{}
+	pcapHandle *pcap.Handle
+	ports      map[uint16]struct{}
+	handshakes *tlsHandshakes
+}
+
+// NewTLSSnooper creates a new snooper of the TLS handshakes of connections
+// to the given ports.  Without CAP_NET_RAW it returns no snooper, and no
+// error.
+func NewTLSSnooper(ports []uint16) (*TLSSnooper, error) {
+	if ok, err := hasCapNetRaw(); err == nil && !ok {
+		log.Infof("TLSSnooper: no CAP_NET_RAW, not snooping TLS handshakes")
+		return nil, nil
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no TLS ports")
+	}
+	pcapHandle, err := newTLSPcapHandle(ports)
+	if err != nil {
+		return nil, err
+	}
+	s := &TLSSnooper{
+		stop:       make(chan struct{}),
+		pcapHandle: pcapHandle,
+		ports:      map[uint16]struct{}{},
+		handshakes: newTLSHandshakes(),
+	}
+	for _, port := range ports {
+		s.ports[port] = struct{}{}
+	}
+	go s.run()
+	return s, nil
+}
+
+// tlsBPFFilter only lets through the segments of the ports which start
+// with a handshake record, so the capture stops at the handshakes.  libpcap
+// can't read the TCP payloads of IPv6, so it's IPv4 only.
+func tlsBPFFilter(ports []uint16) string {
+	portFilters := make([]string, 0, len(ports))
+	for _, port := range ports {
+		portFilters = append(portFilters, "port "+strconv.Itoa(int(port)))
+	}
+	return fmt.Sprintf("tcp and (%s) and tcp[((tcp[12:1] & 0xf0) >> 2):1] = 0x%x", strings.Join(portFilters, " or "), tlsRecordHandshake)
+}
+
+func newTLSPcapHandle(ports []uint16) (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle("any")
+	if err != nil {
+		return nil, err
+	}
+	defer inactive.CleanUp()
+	// As the DNS snooper, see newPcapHandle
+	if err = inactive.SetTimeout(time.Minute * 30); err != nil {
+		return nil, err
+	}
+	if err = inactive.SetImmediateMode(true); err != nil {
+		return nil, err
+	}
+	if err = inactive.SetSnapLen(tlsSnapLen); err != nil {
+		return nil, err
+	}
+	pcapHandle, err := inactive.Activate()
+	if err != nil {
+		return nil, err
+	}
+	if err := pcapHandle.SetBPFFilter(tlsBPFFilter(ports)); err != nil {
+		pcapHandle.Close()
+		return nil, err
+	}
+	return pcapHandle, nil
+}
+
+// HandshakeLatests returns the node metadata of the handshake of the
+// connection from client to server, if it was seen.
+func (s *TLSSnooper) HandshakeLatests(client net.IP, clientPort uint16, server net.IP, serverPort uint16) (map[string]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+	return s.handshakes.latests(client, clientPort, server, serverPort)
+}
+
+// Stop makes the snooper stop inspecting TLS handshakes
+func (s *TLSSnooper) Stop() {
+	if s != nil {
+		close(s.stop)
+	}
+}
+
+func (s *TLSSnooper) run() {
+	var (
+		decodedLayers []gopacket.LayerType
+		tcp           layers.TCP
+		ip4           layers.IPv4
+		ip6           layers.IPv6
+		eth           layers.Ethernet
+		dot1q         layers.Dot1Q
+		sll           layers.LinuxSLL
+	)
+
+	// assumes that the "any" interface is being used (see https://wiki.wireshark.org/SLL)
+	packetParser := gopacket.NewDecodingLayerParser(layers.LayerTypeLinuxSLL, &sll, &dot1q, &eth, &ip4, &ip6, &tcp)
+
+	for {
+		select {
+		case <-s.stop:
+			s.pcapHandle.Close()
+			return
+		default:
+		}
+
+		packet, _, err := s.pcapHandle.ZeroCopyReadPacketData()
+		if err != nil {
+			if err != pcap.NextErrorTimeoutExpired {
+				log.Errorf("TLSSnooper: error reading packet data: %s", err)
+			}
+			continue
+		}
+
+		// The TLS payload is not decoded by gopacket
+		if err := packetParser.DecodeLayers(packet, &decodedLayers); err != nil {
+			if layer, ok := err.(gopacket.UnsupportedLayerType); !ok || gopacket.LayerType(layer) != gopacket.LayerTypePayload {
+				continue
+			}
+		}
+
+		var src, dst net.IP
+		for _, layerType := range decodedLayers {
+			switch layerType {
+			case layers.LayerTypeIPv4:
+				src, dst = ip4.SrcIP, ip4.DstIP
+			case layers.LayerTypeIPv6:
+				src, dst = ip6.SrcIP, ip6.DstIP
+			case layers.LayerTypeTCP:
+				s.handleSegment(src, dst, uint16(tcp.SrcPort), uint16(tcp.DstPort), tcp.LayerPayload())
+			}
+		}
+	}
+}
+
+func (s *TLSSnooper) handleSegment(src, dst net.IP, srcPort, dstPort uint16, payload []byte) {
+	hello, ok := parseTLSHello(payload)
+	if !ok {
+		return
+	}
+	_, toServer := s.ports[dstPort]
+	_, fromServer := s.ports[srcPort]
+	switch {
+	case !hello.server && toServer:
+		s.handshakes.record(src, srcPort, dst, dstPort, hello)
+	case hello.server && fromServer:
+		s.handshakes.record(dst, dstPort, src, srcPort, hello)
+	}
+}
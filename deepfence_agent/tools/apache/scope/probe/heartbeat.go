@@ -0,0 +1,93 @@
+package probe
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+)
+
+// Stages of the probe whose last success is tracked, for readiness.
+const (
+	ReportStage  = "report"
+	PublishStage = "publish"
+)
+
+// heartbeats keeps when each stage of the probe last succeeded.  Until a
+// stage first succeeds, it counts from when the probe started, so a probe
+// starting up gets the same slack as one running.
+type heartbeats struct {
+	mtx     sync.Mutex
+	started time.Time
+	last    map[string]time.Time
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{started: mtime.Now(), last: map[string]time.Time{}}
+}
+
+// start records that the probe started now.
+func (h *heartbeats) start() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.started = mtime.Now()
+}
+
+// beat records that the stage succeeded now.
+func (h *heartbeats) beat(stage string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.last[stage] = mtime.Now()
+}
+
+// observe records that the stage succeeded at the given time, unless it
+// has since; a zero time is no success.
+func (h *heartbeats) observe(stage string, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if at.After(h.last[stage]) {
+		h.last[stage] = at
+	}
+}
+
+// check returns an error if the stage hasn't succeeded for longer than
+// maxAge.
+func (h *heartbeats) check(stage string, maxAge time.Duration) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	last, ok := h.last[stage]
+	if !ok {
+		last = h.started
+	}
+	if age := mtime.Now().Sub(last); age > maxAge {
+		if !ok {
+			return fmt.Errorf("no successful %s in the %v since starting", stage, age.Truncate(time.Second))
+		}
+		return fmt.Errorf("last successful %s %v ago, longer than %v", stage, age.Truncate(time.Second), maxAge)
+	}
+	return nil
+}
+
+// Ready returns an error unless the probe built a report within intervals
+// spy intervals, and published one within intervals publish intervals,
+// stretched as far as they may be.  A wedged probe, e.g. with a reporter
+// or publisher hung, stops being ready.  Publishers which publish in the
+// background, as PublishTrackers, must have had a report reach an app;
+// queueing one for them is not enough.
+func (p *Probe) Ready(intervals int) error {
+	publishInterval := p.getPublishInterval()
+	if p.maxPublishInterval > publishInterval {
+		publishInterval = p.maxPublishInterval
+	}
+	if err := p.heartbeats.check(ReportStage, time.Duration(intervals)*p.spyInterval); err != nil {
+		return err
+	}
+	if tracker, ok := p.publisher.(PublishTracker); ok {
+		p.heartbeats.observe(PublishStage, tracker.LastPublished())
+	}
+	return p.heartbeats.check(PublishStage, time.Duration(intervals)*publishInterval)
+}
@@ -0,0 +1,123 @@
+package probe
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+)
+
+func TestHeartbeats(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	h := newHeartbeats()
+	h.start()
+	if err := h.check(ReportStage, time.Minute); err != nil {
+		t.Errorf("Expected a stage to be given slack on start, got %v", err)
+	}
+	mtime.NowForce(now.Add(2 * time.Minute))
+	if err := h.check(ReportStage, time.Minute); err == nil {
+		t.Error("Expected a stage which never succeeded to fail past its slack")
+	}
+
+	h.beat(ReportStage)
+	mtime.NowForce(now.Add(150 * time.Second))
+	if err := h.check(ReportStage, time.Minute); err != nil {
+		t.Errorf("Expected a stage which recently succeeded to pass, got %v", err)
+	}
+	if err := h.check(PublishStage, time.Minute); err == nil {
+		t.Error("Expected stages to be tracked apart")
+	}
+	mtime.NowForce(now.Add(4 * time.Minute))
+	if err := h.check(ReportStage, time.Minute); err == nil {
+		t.Error("Expected a stage which last succeeded long ago to fail")
+	}
+}
+
+// failingPublisher fails to publish reports while failing is set.
+type failingPublisher struct {
+	failing   *int32
+	published chan struct{}
+}
+
+func (f failingPublisher) Publish(report.Report) error {
+	if atomic.LoadInt32(f.failing) != 0 {
+		return errors.New("failing")
+	}
+	select {
+	case f.published <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestProbeReady(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	rpt := report.MakeReport()
+	rpt.Endpoint.AddNode(report.MakeNode("a"))
+	pub := failingPublisher{new(int32), make(chan struct{}, 1)}
+	p := New(interval, interval, pub, 1, false)
+	p.AddReporter(mockReporter{rpt})
+	p.Start()
+	defer p.Stop()
+
+	published := func() {
+		<-pub.published
+		if err := p.Ready(10); err != nil {
+			t.Errorf("Expected the probe to be ready, got %v", err)
+		}
+	}
+	published()
+
+	// The publisher keeps failing
+	atomic.StoreInt32(pub.failing, 1)
+	test.Poll(t, time.Second, true, func() interface{} {
+		return p.Ready(10) != nil
+	})
+	if err := p.heartbeats.check(ReportStage, 10*interval); err != nil {
+		t.Errorf("Expected reports to be built still, got %v", err)
+	}
+
+	atomic.StoreInt32(pub.failing, 0)
+	<-pub.published
+	published()
+}
+
+// queueingPublisher queues reports, which reach an app only when
+// lastPublished says so.
+type queueingPublisher struct {
+	lastPublished *atomic.Value
+}
+
+func (queueingPublisher) Publish(report.Report) error { return nil }
+
+func (q queueingPublisher) LastPublished() time.Time {
+	last, _ := q.lastPublished.Load().(time.Time)
+	return last
+}
+
+func TestProbeReadyTracked(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	pub := queueingPublisher{&atomic.Value{}}
+	p := New(interval, interval, pub, 1, false)
+	p.AddReporter(mockReporter{report.MakeReport()})
+	p.Start()
+	defer p.Stop()
+
+	// Reports are queued, but none reaches an app
+	test.Poll(t, time.Second, true, func() interface{} {
+		return p.Ready(10) != nil
+	})
+
+	pub.lastPublished.Store(mtime.Now())
+	if err := p.Ready(10); err != nil {
+		t.Errorf("Expected the probe to be ready once a report was published, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package host
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys for the clock of the host.  The probe reports its wall and
+// monotonic clocks, and the app the skew of the wall clock versus its own.
+const (
+	ClockWallTime    = "clock_wall_time_ms"
+	ClockMonotonic   = "clock_monotonic_ms"
+	ClockSkew        = "clock_skew_ms"
+	ClockSkewWarning = "clock_skew_warning"
+	TimeSyncService  = "time_sync_service"
+	TimeSynchronized = "time_synchronized"
+)
+
+// Exposed for testing.
+var (
+	ProcRoot = "/proc"
+)
+
+// timeSyncServices are the time synchronization daemons, by the name of
+// their processes, which is truncated to 15 characters.
+var timeSyncServices = map[string]string{
+	"chronyd":         "chronyd",
+	"ntpd":            "ntpd",
+	"systemd-timesyn": "systemd-timesyncd",
+}
+
+// findTimeSyncService returns the time synchronization daemon running on
+// the host, if any.
+func findTimeSyncService() string {
+	comms, err := filepath.Glob(filepath.Join(ProcRoot, "[0-9]*", "comm"))
+	if err != nil {
+		return ""
+	}
+	for _, comm := range comms {
+		name, err := ioutil.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+		if service, ok := timeSyncServices[strings.TrimSpace(string(name))]; ok {
+			return service
+		}
+	}
+	return ""
+}
+
+// readTimeSync reports the time synchronization daemon of the host, and
+// whether the clock is synchronized.
+func readTimeSync() map[string]string {
+	result := map[string]string{}
+	if service := findTimeSyncService(); service != "" {
+		result[TimeSyncService] = service
+	}
+	if synchronized, err := GetClockSynchronized(); err == nil {
+		result[TimeSynchronized] = strconv.FormatBool(synchronized)
+	}
+	return result
+}
+
+// clockLatests are the clocks of the host at the time of the report.
+func clockLatests(now time.Time) map[string]string {
+	result := map[string]string{
+		ClockWallTime: strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+	}
+	if monotonic, err := GetMonotonicTime(); err == nil {
+		result[ClockMonotonic] = strconv.FormatInt(int64(monotonic/time.Millisecond), 10)
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindTimeSyncService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(procRoot string) { ProcRoot = procRoot }(ProcRoot)
+	ProcRoot = dir
+
+	if service := findTimeSyncService(); service != "" {
+		t.Errorf("Expected no time sync service, got %q", service)
+	}
+	for pid, comm := range map[string]string{"1": "systemd\n", "427": "systemd-timesyn\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, pid, "comm"), []byte(comm), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if service := findTimeSyncService(); service != "systemd-timesyncd" {
+		t.Errorf("Expected systemd-timesyncd, got %q", service)
+	}
+}
+
+func TestClockLatests(t *testing.T) {
+	latests := clockLatests(time.Unix(1600000000, 123456789))
+	if wallTime := latests[ClockWallTime]; wallTime != "1600000000123" {
+		t.Errorf("Expected the wall time in milliseconds, got %q", wallTime)
+	}
+}
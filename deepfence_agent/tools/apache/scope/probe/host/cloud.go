@@ -0,0 +1,316 @@
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	dfUtils "github.com/deepfence/df-utils"
+	"github.com/deepfence/df-utils/cloud_metadata"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the cloud instance details of the host node.
+const (
+	CloudZone         = "cloud_zone"
+	CloudInstanceType = "cloud_instance_type"
+	CloudInstanceID   = "cloud_instance_id"
+	CloudPublicIPs    = "cloud_public_ips"
+
+	cloudProviderUnknown = "unknown"
+
+	// Metadata endpoints are link-local, and answer in a few milliseconds
+	// when they exist at all.
+	cloudMetadataTimeout = 2 * time.Second
+)
+
+// Exposed for testing.
+var (
+	AWSMetadataURL   = "http://169.254.169.254"
+	GCPMetadataURL   = "http://metadata.google.internal"
+	AzureMetadataURL = "http://169.254.169.254"
+	DMIRoot          = "/sys/class/dmi/id"
+)
+
+// dmiClouds tell the cloud of an instance from its DMI, as
+// DetectCloudServiceProvider of df-utils did, when its metadata endpoint
+// can't be reached, as from containers on EC2 instances with a hop limit of
+// one.
+var dmiClouds = []struct {
+	file, contains string
+	metadata       cloud_metadata.CloudMetadata
+}{
+	{"sys_vendor", "Amazon", cloud_metadata.CloudMetadata{CloudProvider: "aws", Label: "AWS"}},
+	{"product_version", "amazon", cloud_metadata.CloudMetadata{CloudProvider: "aws", Label: "AWS"}},
+	{"product_name", "Google", cloud_metadata.CloudMetadata{CloudProvider: "google_cloud", Label: "Google Cloud"}},
+	{"sys_vendor", "Microsoft Corporation", cloud_metadata.CloudMetadata{CloudProvider: "azure", Label: "Azure"}},
+	{"sys_vendor", "DigitalOcean", cloud_metadata.CloudMetadata{CloudProvider: "digital_ocean", Label: "DigitalOcean"}},
+}
+
+// cloudFetcher fetches the metadata of the instance the probe runs on, and
+// fails when it isn't on that fetcher's cloud.
+type cloudFetcher func(client *http.Client) (cloud_metadata.CloudMetadata, error)
+
+var cloudFetchers = []cloudFetcher{
+	fetchAWSMetadata,
+	fetchGCPMetadata,
+	fetchAzureMetadata,
+	legacyCloudFetcher(cloud_metadata.GetDigitalOceanMetadata),
+	legacyCloudFetcher(cloud_metadata.GetSoftlayerMetadata),
+}
+
+func metadataRequest(client *http.Client, method, url string, headers map[string]string) (string, http.Header, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return strings.TrimSpace(string(body)), resp.Header, err
+}
+
+// fetchAWSMetadata uses IMDSv2, which needs a session token for each
+// request.
+func fetchAWSMetadata(client *http.Client) (cloud_metadata.CloudMetadata, error) {
+	metadata := cloud_metadata.CloudMetadata{CloudProvider: "aws", Label: "AWS"}
+	token, _, err := metadataRequest(client, "PUT", AWSMetadataURL+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return metadata, err
+	}
+	get := func(key string) string {
+		value, _, err := metadataRequest(client, "GET", AWSMetadataURL+"/latest/meta-data/"+key,
+			map[string]string{"X-aws-ec2-metadata-token": token})
+		if err != nil {
+			return ""
+		}
+		return value
+	}
+	metadata.InstanceID = get("instance-id")
+	if !strings.HasPrefix(metadata.InstanceID, "i-") {
+		return metadata, fmt.Errorf("not an EC2 instance ID: %q", metadata.InstanceID)
+	}
+	metadata.Hostname = get("hostname")
+	metadata.InstanceType = get("instance-type")
+	metadata.Zone = get("placement/availability-zone")
+	metadata.Region = get("placement/region")
+	// Instances without public addresses get a 404 for them
+	if ip := get("public-ipv4"); ip != "" {
+		metadata.PublicIP = []string{ip}
+	}
+	if ip := get("local-ipv4"); ip != "" {
+		metadata.PrivateIP = []string{ip}
+	}
+	return metadata, nil
+}
+
+func fetchGCPMetadata(client *http.Client) (cloud_metadata.CloudMetadata, error) {
+	metadata := cloud_metadata.CloudMetadata{CloudProvider: "google_cloud", Label: "Google Cloud"}
+	body, header, err := metadataRequest(client, "GET", GCPMetadataURL+"/computeMetadata/v1/instance/?recursive=true",
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return metadata, err
+	}
+	if header.Get("Metadata-Flavor") != "Google" {
+		return metadata, fmt.Errorf("not a GCP metadata server")
+	}
+	var instance cloud_metadata.GoogleCloudMetadataAll
+	if err := json.Unmarshal([]byte(body), &instance); err != nil {
+		return metadata, err
+	}
+	// The zone and machine type are given as projects/123/zones/us-east1-b
+	// and projects/123/machineTypes/n1-standard-1.
+	metadata.InstanceID = strconv.FormatInt(instance.ID, 10)
+	metadata.Hostname = instance.Hostname
+	metadata.Name = instance.Name
+	metadata.Zone = path.Base(instance.Zone)
+	metadata.MachineType = path.Base(instance.MachineType)
+	if i := strings.LastIndex(metadata.Zone, "-"); i > 0 {
+		metadata.Region = metadata.Zone[:i]
+	}
+	for _, iface := range instance.NetworkInterfaces {
+		for _, config := range iface.AccessConfigs {
+			if config.ExternalIP != "" {
+				metadata.PublicIP = append(metadata.PublicIP, config.ExternalIP)
+			}
+		}
+		metadata.PrivateIP = append(metadata.PrivateIP, iface.IP)
+	}
+	return metadata, nil
+}
+
+func fetchAzureMetadata(client *http.Client) (cloud_metadata.CloudMetadata, error) {
+	metadata := cloud_metadata.CloudMetadata{CloudProvider: "azure", Label: "Azure"}
+	body, _, err := metadataRequest(client, "GET", AzureMetadataURL+"/metadata/instance?api-version=2020-06-01",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return metadata, err
+	}
+	var instance cloud_metadata.AzureMetadataAll
+	if err := json.Unmarshal([]byte(body), &instance); err != nil {
+		return metadata, err
+	}
+	if instance.Compute.VMID == "" {
+		return metadata, fmt.Errorf("not an Azure VM")
+	}
+	metadata.VmID = instance.Compute.VMID
+	metadata.Name = instance.Compute.Name
+	metadata.VMSize = instance.Compute.VMSize
+	metadata.Region = instance.Compute.Location
+	metadata.Zone = instance.Compute.Zone
+	metadata.OsType = instance.Compute.OsType
+	metadata.SKU = instance.Compute.Sku
+	metadata.ResourceGroupName = instance.Compute.ResourceGroupName
+	for _, iface := range instance.Network.Interface {
+		for _, address := range iface.Ipv4.IPAddress {
+			if address.PublicIPAddress != "" {
+				metadata.PublicIP = append(metadata.PublicIP, address.PublicIPAddress)
+			}
+			metadata.PrivateIP = append(metadata.PrivateIP, address.PrivateIPAddress)
+		}
+	}
+	return metadata, nil
+}
+
+// legacyCloudFetcher adapts the df-utils fetchers of the other clouds,
+// which have long timeouts of their own, by giving up on their validation
+// after cloudMetadataTimeout.
+func legacyCloudFetcher(get func(onlyValidate bool) (cloud_metadata.CloudMetadata, error)) cloudFetcher {
+	return func(*http.Client) (cloud_metadata.CloudMetadata, error) {
+		validated := make(chan error, 1)
+		go func() {
+			_, err := get(true)
+			validated <- err
+		}()
+		select {
+		case err := <-validated:
+			if err != nil {
+				return cloud_metadata.CloudMetadata{}, err
+			}
+		case <-time.After(cloudMetadataTimeout):
+			return cloud_metadata.CloudMetadata{}, fmt.Errorf("timed out")
+		}
+		return get(false)
+	}
+}
+
+// detectCloudMetadata tries all the fetchers at once, and returns the
+// metadata of the first one to succeed.
+func detectCloudMetadata(fetchers []cloudFetcher) (cloud_metadata.CloudMetadata, bool) {
+	type result struct {
+		metadata cloud_metadata.CloudMetadata
+		err      error
+	}
+	client := &http.Client{Timeout: cloudMetadataTimeout}
+	results := make(chan result, len(fetchers))
+	for _, fetch := range fetchers {
+		go func(fetch cloudFetcher) {
+			metadata, err := fetch(client)
+			results <- result{metadata, err}
+		}(fetch)
+	}
+	for range fetchers {
+		if r := <-results; r.err == nil {
+			return r.metadata, true
+		}
+	}
+	return cloud_metadata.CloudMetadata{}, false
+}
+
+// dmiCloudMetadata tells the cloud of the instance from its DMI, without
+// the details of the instance.
+func dmiCloudMetadata() (cloud_metadata.CloudMetadata, bool) {
+	for _, cloud := range dmiClouds {
+		buf, err := ioutil.ReadFile(path.Join(DMIRoot, cloud.file))
+		if err == nil && strings.Contains(string(buf), cloud.contains) {
+			return cloud.metadata, true
+		}
+	}
+	return cloud_metadata.CloudMetadata{}, false
+}
+
+// genericCloudMetadata describes hosts which aren't on a cloud we know of,
+// or on which fetching cloud metadata is disabled.
+func genericCloudMetadata() cloud_metadata.CloudMetadata {
+	metadata, err := cloud_metadata.GetGenericMetadata(false)
+	if err == nil && !dfUtils.FileExists("/var/run/docker.sock") && !dfUtils.FileExists("/run/containerd/containerd.sock") {
+		metadata.CloudProvider = report.CloudProviderServerless
+		metadata.Region = report.CloudProviderServerless
+	}
+	return metadata
+}
+
+// CloudMeta is the cloud instance the host runs on, fetched once at
+// startup.
+type CloudMeta struct {
+	cloudMetadata      string
+	cloudProvider      string
+	cloudProviderLabel string
+	cloudRegion        string
+	latests            map[string]string
+}
+
+func makeCloudMeta(metadata cloud_metadata.CloudMetadata) CloudMeta {
+	meta := CloudMeta{
+		cloudMetadata:      "{}",
+		cloudProvider:      metadata.CloudProvider,
+		cloudProviderLabel: metadata.Label,
+		cloudRegion:        metadata.Region,
+		latests:            map[string]string{},
+	}
+	if meta.cloudProvider == "" {
+		meta.cloudProvider, meta.cloudProviderLabel = cloudProviderUnknown, "Unknown"
+	}
+	if buf, err := json.Marshal(metadata); err == nil {
+		meta.cloudMetadata = string(buf)
+	}
+	// Each cloud has its own name for the instance ID and type
+	for key, values := range map[string][]string{
+		CloudZone:         {metadata.Zone},
+		CloudInstanceID:   {metadata.InstanceID, metadata.VmID},
+		CloudInstanceType: {metadata.InstanceType, metadata.MachineType, metadata.VMSize},
+	} {
+		for _, value := range values {
+			if value != "" {
+				meta.latests[key] = value
+				break
+			}
+		}
+	}
+	if len(metadata.PublicIP) > 0 && meta.cloudProvider != report.CloudProviderServerless && meta.cloudProvider != cloudProviderUnknown {
+		meta.latests[CloudPublicIPs] = strings.Join(metadata.PublicIP, ", ")
+	}
+	return meta
+}
+
+// loadCloudMetadata finds out which cloud the host is on: from its metadata
+// endpoint, unless disabled, or else from its DMI.
+func loadCloudMetadata(enabled bool) CloudMeta {
+	if enabled {
+		if metadata, ok := detectCloudMetadata(cloudFetchers); ok {
+			log.Infof("Running on %s, in %s", metadata.Label, metadata.Region)
+			return makeCloudMeta(metadata)
+		}
+	}
+	if metadata, ok := dmiCloudMetadata(); ok {
+		log.Infof("Running on %s, without the metadata of the instance", metadata.Label)
+		return makeCloudMeta(metadata)
+	}
+	return makeCloudMeta(genericCloudMetadata())
+}
@@ -0,0 +1,153 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/deepfence/df-utils/cloud_metadata"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func awsMetadataServer() *httptest.Server {
+	values := map[string]string{
+		"instance-id":                 "i-0123456789abcdef0",
+		"instance-type":               "m5.large",
+		"placement/availability-zone": "us-east-1a",
+		"placement/region":            "us-east-1",
+		"public-ipv4":                 "54.1.2.3",
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != "PUT" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprint(w, "token")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		value, ok := values[r.URL.Path[len("/latest/meta-data/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, value)
+	}))
+}
+
+func TestFetchAWSMetadata(t *testing.T) {
+	server := awsMetadataServer()
+	defer server.Close()
+	defer func(url string) { AWSMetadataURL = url }(AWSMetadataURL)
+	AWSMetadataURL = server.URL
+
+	metadata, err := fetchAWSMetadata(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		CloudZone:         "us-east-1a",
+		CloudInstanceType: "m5.large",
+		CloudInstanceID:   "i-0123456789abcdef0",
+		CloudPublicIPs:    "54.1.2.3",
+	}
+	meta := makeCloudMeta(metadata)
+	if meta.cloudProvider != "aws" || meta.cloudRegion != "us-east-1" {
+		t.Errorf("Unexpected provider %q and region %q", meta.cloudProvider, meta.cloudRegion)
+	}
+	if !reflect.DeepEqual(want, meta.latests) {
+		t.Errorf("Expected %v, got %v", want, meta.latests)
+	}
+}
+
+func TestFetchGCPMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		fmt.Fprint(w, `{
+			"id": 1234567890,
+			"machineType": "projects/123/machineTypes/n1-standard-1",
+			"zone": "projects/123/zones/europe-west1-b",
+			"networkInterfaces": [{"ip": "10.0.0.2", "accessConfigs": [{"externalIp": "35.1.2.3"}]}]
+		}`)
+	}))
+	defer server.Close()
+	defer func(url string) { GCPMetadataURL = url }(GCPMetadataURL)
+	GCPMetadataURL = server.URL
+
+	metadata, err := fetchGCPMetadata(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		CloudZone:         "europe-west1-b",
+		CloudInstanceType: "n1-standard-1",
+		CloudInstanceID:   "1234567890",
+		CloudPublicIPs:    "35.1.2.3",
+	}
+	meta := makeCloudMeta(metadata)
+	if meta.cloudRegion != "europe-west1" {
+		t.Errorf("Expected region europe-west1, got %q", meta.cloudRegion)
+	}
+	if !reflect.DeepEqual(want, meta.latests) {
+		t.Errorf("Expected %v, got %v", want, meta.latests)
+	}
+}
+
+func TestDetectCloudMetadata(t *testing.T) {
+	// Azure and AWS share an address, so each must reject the other's answers
+	server := awsMetadataServer()
+	defer server.Close()
+	defer func(aws, azure string) { AWSMetadataURL, AzureMetadataURL = aws, azure }(AWSMetadataURL, AzureMetadataURL)
+	AWSMetadataURL, AzureMetadataURL = server.URL, server.URL
+
+	metadata, ok := detectCloudMetadata([]cloudFetcher{fetchAzureMetadata, fetchAWSMetadata})
+	if !ok || metadata.CloudProvider != "aws" {
+		t.Errorf("Expected to detect AWS, got %v", metadata)
+	}
+
+	failing := func(*http.Client) (cloud_metadata.CloudMetadata, error) {
+		return cloud_metadata.CloudMetadata{}, fmt.Errorf("not here")
+	}
+	if metadata, ok := detectCloudMetadata([]cloudFetcher{fetchAzureMetadata, failing}); ok {
+		t.Errorf("Expected no cloud, got %v", metadata)
+	}
+}
+
+func TestDMICloudMetadata(t *testing.T) {
+	defer func(root string) { DMIRoot = root }(DMIRoot)
+	for _, tc := range []struct {
+		files map[string]string
+		want  string
+	}{
+		{map[string]string{"sys_vendor": "Amazon EC2\n"}, "aws"},
+		{map[string]string{"sys_vendor": "Xen\n", "product_version": "4.11.amazon\n"}, "aws"},
+		{map[string]string{"product_name": "Google Compute Engine\n"}, "google_cloud"},
+		{map[string]string{"sys_vendor": "Microsoft Corporation\n"}, "azure"},
+		{map[string]string{"sys_vendor": "DigitalOcean\n"}, "digital_ocean"},
+		{map[string]string{"sys_vendor": "QEMU\n"}, ""},
+		{nil, ""},
+	} {
+		DMIRoot = t.TempDir()
+		for name, content := range tc.files {
+			if err := ioutil.WriteFile(filepath.Join(DMIRoot, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		metadata, ok := dmiCloudMetadata()
+		if ok != (tc.want != "") || metadata.CloudProvider != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.files, tc.want, metadata.CloudProvider)
+		}
+	}
+}
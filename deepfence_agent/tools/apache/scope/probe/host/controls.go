@@ -16,6 +16,7 @@ const (
 	UploadData                  = "uploadData"
 	AddUserDefinedTags          = "host_add_user_defined_tags"
 	DeleteUserDefinedTags       = "host_delete_user_defined_tags"
+	RefreshPackages             = "host_refresh_packages"
 )
 
 func (r *Reporter) registerControls() {
@@ -23,6 +24,9 @@ func (r *Reporter) registerControls() {
 	r.handlerRegistry.Register(UploadData, r.uploadData)
 	r.handlerRegistry.Register(AddUserDefinedTags, r.addUserDefinedTags)
 	r.handlerRegistry.Register(DeleteUserDefinedTags, r.deleteUserDefinedTags)
+	if r.packages != nil {
+		r.handlerRegistry.Register(RefreshPackages, r.packages.refreshPackages)
+	}
 }
 
 func (r *Reporter) deregisterControls() {
@@ -30,6 +34,9 @@ func (r *Reporter) deregisterControls() {
 	r.handlerRegistry.Rm(UploadData)
 	r.handlerRegistry.Rm(AddUserDefinedTags)
 	r.handlerRegistry.Rm(DeleteUserDefinedTags)
+	if r.packages != nil {
+		r.handlerRegistry.Rm(RefreshPackages)
+	}
 }
 
 func (r *Reporter) addUserDefinedTags(req xfer.Request) xfer.Response {
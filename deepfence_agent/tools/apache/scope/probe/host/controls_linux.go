@@ -8,7 +8,6 @@ import (
 	"syscall"
 
 	dfUtils "github.com/deepfence/df-utils"
-	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/scope/common/xfer"
 
 	"github.com/willdonnelly/passwd"
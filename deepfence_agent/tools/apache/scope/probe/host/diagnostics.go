@@ -0,0 +1,402 @@
+package host
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+// CollectDiagnostics is the control streaming a diagnostics bundle of the
+// probe through a pipe, as a tar.gz.  Its MaxBytesArg caps the bundle
+// below DiagnosticsMaxBytes.  DiagnosticsSummary is the control returning
+// what the bundle would contain, as a structured result of
+// DiagnosticsSummarySchema.
+const (
+	CollectDiagnostics       = "host_collect_diagnostics"
+	MaxBytesArg              = "max_bytes"
+	DiagnosticsSummary       = "host_diagnostics_summary"
+	DiagnosticsSummarySchema = "host/diagnostics_summary/v1"
+)
+
+// Exposed for testing.
+var (
+	// DiagnosticsMaxBytes caps the contents of diagnostics bundles; the
+	// files which don't fit are truncated or left out.
+	DiagnosticsMaxBytes int64 = 64 * 1024 * 1024
+	// diagnosticsLogBytes is how much of the end of each log is collected.
+	diagnosticsLogBytes int64 = 16 * 1024 * 1024
+	// diagnosticsTimeout is how long collecting each file may take.
+	diagnosticsTimeout = 10 * time.Second
+)
+
+// diagnosticsFiles is the contents of diagnostics bundles: their files, in
+// order, and how they're collected.  Files failing to be collected are
+// left out, and listed in errors.txt.
+var diagnosticsFiles = []struct {
+	name    string
+	collect func(*Diagnostics, context.Context) ([]byte, error)
+}{
+	{"probe.log", (*Diagnostics).probeLogs},
+	{"goroutines.txt", (*Diagnostics).goroutines},
+	{"report.json", (*Diagnostics).report},
+	{"docker_info.json", (*Diagnostics).dockerInfo},
+	{"cri_info.json", (*Diagnostics).criInfo},
+	{"sysctls.txt", (*Diagnostics).sysctls},
+	{"environment.txt", (*Diagnostics).environment},
+}
+
+// diagnosticsSysctls are the sysctls collected.
+var diagnosticsSysctls = []string{
+	"fs.file-max",
+	"fs.file-nr",
+	"fs.inotify.max_user_watches",
+	"kernel.pid_max",
+	"net.core.somaxconn",
+	"net.ipv4.ip_forward",
+	"net.ipv4.ip_local_port_range",
+	"net.netfilter.nf_conntrack_count",
+	"net.netfilter.nf_conntrack_max",
+	"net.bridge.bridge-nf-call-iptables",
+	"vm.max_map_count",
+}
+
+// secretName matches the names of environment variables whose values are
+// redacted from diagnostics bundles.
+var secretName = regexp.MustCompile(`(?i)pass|secret|token|key|credential|auth|cert|private`)
+
+const redacted = "<redacted>"
+
+// DiagnosticsSources is where diagnostics bundles are collected from,
+// beside the probe itself.  Sources left nil are left out.
+type DiagnosticsSources struct {
+	// LogFiles are the probe's logs, by default those in
+	// $DF_INSTALL_DIR/var/log/fenced.
+	LogFiles []string
+	// Report spies a report of the probe.
+	Report func() (report.Report, error)
+	// DockerInfo and CRIInfo return the info of the container runtimes,
+	// encoded as JSON.
+	DockerInfo func(context.Context) (interface{}, error)
+	CRIInfo    func(context.Context) (interface{}, error)
+}
+
+// DiagnosticsSummaryResult is the result of DiagnosticsSummary.
+type DiagnosticsSummaryResult struct {
+	HostID     string            `json:"host_id"`
+	GoVersion  string            `json:"go_version"`
+	Goroutines int               `json:"goroutines"`
+	Files      []DiagnosticsFile `json:"files"`
+}
+
+// DiagnosticsFile is a file of diagnostics bundles, and its size or the
+// error collecting it.
+type DiagnosticsFile struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// Diagnostics handles the CollectDiagnostics and DiagnosticsSummary
+// controls of the host.
+type Diagnostics struct {
+	hostID          string
+	sources         DiagnosticsSources
+	pipes           controls.PipeClient
+	handlerRegistry *controls.HandlerRegistry
+}
+
+// NewDiagnostics makes a new Diagnostics, registering its controls.
+func NewDiagnostics(hostID string, sources DiagnosticsSources, pipes controls.PipeClient, handlerRegistry *controls.HandlerRegistry) *Diagnostics {
+	if sources.LogFiles == nil {
+		logDir := filepath.Join(getDfInstallDir(), "var/log/fenced")
+		sources.LogFiles = []string{
+			filepath.Join(logDir, "discovery.logfile"),
+			filepath.Join(logDir, "cve_upload_file.logfile"),
+		}
+	}
+	d := &Diagnostics{
+		hostID:          hostID,
+		sources:         sources,
+		pipes:           pipes,
+		handlerRegistry: handlerRegistry,
+	}
+	handlerRegistry.Register(CollectDiagnostics, d.collectDiagnostics)
+	handlerRegistry.Register(DiagnosticsSummary, d.summary)
+	return d
+}
+
+// Stop deregisters the controls.
+func (d *Diagnostics) Stop() {
+	d.handlerRegistry.Rm(CollectDiagnostics)
+	d.handlerRegistry.Rm(DiagnosticsSummary)
+}
+
+// Name of this reporter.
+func (*Diagnostics) Name() string { return "Diagnostics" }
+
+// Report adds the control to the host node.
+func (d *Diagnostics) Report() (report.Report, error) {
+	rpt := report.MakeReport()
+	rpt.Host.Controls.AddControl(report.Control{
+		ID:    CollectDiagnostics,
+		Human: "Collect diagnostics",
+		Icon:  "fa fa-medkit",
+		Rank:  10,
+	})
+	rpt.Host.AddNode(report.MakeNode(report.MakeHostNodeID(d.hostID)).WithLatestActiveControls(CollectDiagnostics))
+	return rpt, nil
+}
+
+func (d *Diagnostics) collectDiagnostics(req xfer.Request) xfer.Response {
+	maxBytes := DiagnosticsMaxBytes
+	if arg, ok := req.ControlArgs[MaxBytesArg]; ok {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n <= 0 {
+			return xfer.ResponseErrorf("Invalid %s: %q", MaxBytesArg, arg)
+		}
+		if n < maxBytes {
+			maxBytes = n
+		}
+	}
+	reader, writer := io.Pipe()
+	readWriter := struct {
+		io.Reader
+		io.Writer
+	}{
+		reader,
+		ioutil.Discard,
+	}
+	id, pipe, err := controls.NewPipeFromEnds(nil, readWriter, d.pipes, req.AppID)
+	if err != nil {
+		return xfer.ResponseError(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pipe.OnClose(func() {
+		cancel()
+		reader.Close()
+	})
+	go func() {
+		err := d.writeBundle(ctx, writer, maxBytes)
+		if err != nil {
+			log.Warnf("diagnostics: error writing bundle: %v", err)
+		}
+		writer.CloseWithError(err)
+	}()
+	return xfer.Response{Pipe: id}
+}
+
+// writeBundle writes a diagnostics bundle to w, of up to maxBytes of
+// contents.
+func (d *Diagnostics) writeBundle(ctx context.Context, w io.Writer, maxBytes int64) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := mtime.Now()
+	var errs []string
+	left := maxBytes
+	for _, f := range diagnosticsFiles {
+		buf, err := d.collectFile(ctx, f.collect)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.name, err))
+			continue
+		}
+		if int64(len(buf)) > left {
+			errs = append(errs, fmt.Sprintf("%s: truncated from %d to %d bytes", f.name, len(buf), left))
+			buf = buf[:left]
+		}
+		left -= int64(len(buf))
+		if err := writeTarFile(tw, f.name, now, buf); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		if err := writeTarFile(tw, "errors.txt", now, []byte(strings.Join(errs, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// collectFile collects a file of the bundle, within diagnosticsTimeout.
+func (d *Diagnostics) collectFile(ctx context.Context, collect func(*Diagnostics, context.Context) ([]byte, error)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+	defer cancel()
+	return collect(d, ctx)
+}
+
+func (d *Diagnostics) summary(req xfer.Request) xfer.Response {
+	result := DiagnosticsSummaryResult{
+		HostID:     d.hostID,
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Files:      make([]DiagnosticsFile, 0, len(diagnosticsFiles)),
+	}
+	for _, f := range diagnosticsFiles {
+		buf, err := d.collectFile(context.Background(), f.collect)
+		file := DiagnosticsFile{Name: f.name, Bytes: len(buf)}
+		if err != nil {
+			file.Bytes, file.Error = 0, err.Error()
+		}
+		result.Files = append(result.Files, file)
+	}
+	return xfer.ResponseResult(DiagnosticsSummarySchema, result)
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, buf []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(buf)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(buf)
+	return err
+}
+
+func (d *Diagnostics) probeLogs(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	found := false
+	for _, path := range d.sources.LogFiles {
+		tail, err := readTail(path, diagnosticsLogBytes)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		fmt.Fprintf(&buf, "==> %s <==\n", path)
+		buf.Write(tail)
+	}
+	if !found {
+		return nil, fmt.Errorf("no logs in %s", strings.Join(d.sources.LogFiles, ", "))
+	}
+	return buf.Bytes(), nil
+}
+
+// readTail reads up to the last n bytes of a file.
+func readTail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > n {
+		if _, err := f.Seek(info.Size()-n, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(io.LimitReader(f, n))
+}
+
+func (d *Diagnostics) goroutines(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	err := pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	return buf.Bytes(), err
+}
+
+func (d *Diagnostics) report(ctx context.Context) ([]byte, error) {
+	if d.sources.Report == nil {
+		return nil, fmt.Errorf("not available")
+	}
+	rpt, err := d.sources.Report()
+	if err != nil {
+		return nil, err
+	}
+	redactReport(&rpt)
+	var buf bytes.Buffer
+	err = codec.NewEncoder(&buf, &codec.JsonHandle{}).Encode(&rpt)
+	return buf.Bytes(), err
+}
+
+// redactReport redacts the secrets in the environment variables of the
+// containers of a report.
+func redactReport(rpt *report.Report) {
+	rpt.WalkTopologies(func(t *report.Topology) {
+		for id, n := range t.Nodes {
+			redacting := n
+			n.Latest.ForEach(func(k string, ts time.Time, v string) {
+				if strings.HasPrefix(k, report.DockerEnvPrefix) && secretName.MatchString(strings.TrimPrefix(k, report.DockerEnvPrefix)) {
+					redacting = redacting.WithLatest(k, ts, redacted)
+				}
+			})
+			t.Nodes[id] = redacting
+		}
+	})
+}
+
+func (d *Diagnostics) dockerInfo(ctx context.Context) ([]byte, error) {
+	return runtimeInfo(ctx, d.sources.DockerInfo)
+}
+
+func (d *Diagnostics) criInfo(ctx context.Context) ([]byte, error) {
+	return runtimeInfo(ctx, d.sources.CRIInfo)
+}
+
+func runtimeInfo(ctx context.Context, info func(context.Context) (interface{}, error)) ([]byte, error) {
+	if info == nil {
+		return nil, fmt.Errorf("not available")
+	}
+	v, err := info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func (d *Diagnostics) sysctls(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, name := range diagnosticsSysctls {
+		value, err := readTrimmed(filepath.Join(ProcSys, strings.Replace(name, ".", "/", -1)))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", name, strings.Join(strings.Fields(value), " "))
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Diagnostics) environment(ctx context.Context) ([]byte, error) {
+	env := os.Environ()
+	sort.Strings(env)
+	var buf bytes.Buffer
+	for _, kv := range env {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if secretName.MatchString(name) {
+			kv = name + "=" + redacted
+		}
+		fmt.Fprintln(&buf, kv)
+	}
+	return buf.Bytes(), nil
+}
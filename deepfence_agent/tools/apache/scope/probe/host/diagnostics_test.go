@@ -0,0 +1,231 @@
+package host_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/app"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/appclient"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/probe/host"
+	"github.com/weaveworks/scope/report"
+)
+
+type pipeAdapter struct {
+	c appclient.AppClient
+}
+
+func (a pipeAdapter) PipeConnection(_, pipeID string, pipe xfer.Pipe) error {
+	a.c.PipeConnection(pipeID, pipe)
+	return nil
+}
+
+func (a pipeAdapter) PipeClose(_, pipeID string) error {
+	return a.c.PipeClose(pipeID)
+}
+
+// collectBundle invokes the diagnostics control of a test probe connected
+// to a test app, and reads the bundle from its pipe, by file name.
+func collectBundle(t *testing.T, sources host.DiagnosticsSources, args map[string]string) map[string]string {
+	router := mux.NewRouter()
+	pr := app.NewLocalPipeRouter()
+	app.RegisterPipeRoutes(router, pr)
+	defer pr.Stop()
+	// The pipe handlers release their pipes, reading the time, in their own
+	// goroutines, which the test server doesn't wait for once hijacked
+	var handlers sync.WaitGroup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer handlers.Wait()
+
+	hostname := strings.TrimPrefix(server.URL, "http://")
+	client, err := appclient.NewAppClient(appclient.ProbeConfig{ProbeID: "foo"}, hostname, url.URL{Scheme: "http", Host: hostname}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	d := host.NewDiagnostics("host1", sources, pipeAdapter{client}, handlerRegistry)
+	defer d.Stop()
+
+	res := handlerRegistry.HandleControlRequest(xfer.Request{
+		AppID:       "app",
+		NodeID:      report.MakeHostNodeID("host1"),
+		Control:     host.CollectDiagnostics,
+		ControlArgs: args,
+	})
+	if res.Error != "" || res.Pipe == "" {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/topology-api/pipe/%s", hostname, res.Pipe), http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The bundle is read until it's a complete gzip stream
+	var bundle bytes.Buffer
+	complete := func() bool {
+		r, err := gzip.NewReader(bytes.NewReader(bundle.Bytes()))
+		if err != nil {
+			return false
+		}
+		_, err = ioutil.ReadAll(r)
+		return err == nil
+	}
+	for !complete() {
+		_, buf, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle.Write(buf)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(mustGzip(t, bundle.Bytes()))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(buf)
+	}
+	return files
+}
+
+func mustGzip(t *testing.T, buf []byte) io.Reader {
+	r, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCollectDiagnostics(t *testing.T) {
+	logFile, err := ioutil.TempFile("", "probe.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.WriteString("probe started\n")
+	logFile.Close()
+	os.Setenv("DIAGNOSTICS_TEST_TOKEN", "hunter2")
+	defer os.Unsetenv("DIAGNOSTICS_TEST_TOKEN")
+
+	sources := host.DiagnosticsSources{
+		LogFiles: []string{logFile.Name()},
+		Report: func() (report.Report, error) {
+			rpt := report.MakeReport()
+			rpt.Container.AddNode(report.MakeNodeWith("c1", map[string]string{
+				report.DockerEnvPrefix + "DB_PASSWORD": "hunter2",
+				report.DockerEnvPrefix + "PATH":        "/bin",
+			}))
+			return rpt, nil
+		},
+		DockerInfo: func(context.Context) (interface{}, error) {
+			return map[string]string{"ServerVersion": "20.10"}, nil
+		},
+	}
+	files := collectBundle(t, sources, nil)
+
+	if !strings.Contains(files["probe.log"], "probe started") {
+		t.Errorf("unexpected probe.log: %q", files["probe.log"])
+	}
+	if !strings.Contains(files["goroutines.txt"], "goroutine") {
+		t.Errorf("unexpected goroutines.txt: %q", files["goroutines.txt"])
+	}
+	if r := files["report.json"]; !strings.Contains(r, `"c1"`) || strings.Contains(r, "hunter2") {
+		t.Errorf("unexpected report.json: %q", r)
+	}
+	if !strings.Contains(files["docker_info.json"], `"ServerVersion": "20.10"`) {
+		t.Errorf("unexpected docker_info.json: %q", files["docker_info.json"])
+	}
+	if env := files["environment.txt"]; !strings.Contains(env, "DIAGNOSTICS_TEST_TOKEN=<redacted>") || strings.Contains(env, "hunter2") {
+		t.Errorf("unexpected environment.txt: %q", env)
+	}
+	if _, ok := files["cri_info.json"]; ok {
+		t.Errorf("unexpected cri_info.json")
+	}
+	if !strings.Contains(files["errors.txt"], "cri_info.json: not available") {
+		t.Errorf("unexpected errors.txt: %q", files["errors.txt"])
+	}
+
+	// Capped
+	files = collectBundle(t, sources, map[string]string{host.MaxBytesArg: "8"})
+	if len(files["probe.log"]) != 8 || !strings.HasPrefix(files["probe.log"], "==> ") {
+		t.Errorf("unexpected probe.log: %q", files["probe.log"])
+	}
+	if _, ok := files["goroutines.txt"]; !ok || files["goroutines.txt"] != "" {
+		t.Errorf("unexpected goroutines.txt: %q", files["goroutines.txt"])
+	}
+	if !strings.Contains(files["errors.txt"], "probe.log: truncated") {
+		t.Errorf("unexpected errors.txt: %q", files["errors.txt"])
+	}
+}
+
+func TestDiagnosticsSummary(t *testing.T) {
+	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	d := host.NewDiagnostics("host1", host.DiagnosticsSources{
+		LogFiles: []string{"/nonexistent/probe.log"},
+		DockerInfo: func(context.Context) (interface{}, error) {
+			return map[string]string{"ServerVersion": "20.10"}, nil
+		},
+	}, nil, handlerRegistry)
+	defer d.Stop()
+
+	res := handlerRegistry.HandleControlRequest(xfer.Request{
+		NodeID:  report.MakeHostNodeID("host1"),
+		Control: host.DiagnosticsSummary,
+	})
+	if res.Error != "" || res.ResultSchema != host.DiagnosticsSummarySchema {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	var summary host.DiagnosticsSummaryResult
+	if err := json.Unmarshal(res.Result, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.HostID != "host1" || summary.Goroutines == 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	files := map[string]host.DiagnosticsFile{}
+	for _, f := range summary.Files {
+		files[f.Name] = f
+	}
+	if f := files["probe.log"]; f.Bytes != 0 || !strings.Contains(f.Error, "no logs") {
+		t.Errorf("unexpected probe.log: %+v", f)
+	}
+	if f := files["docker_info.json"]; f.Bytes != len("{\n  \"ServerVersion\": \"20.10\"\n}") || f.Error != "" {
+		t.Errorf("unexpected docker_info.json: %+v", f)
+	}
+	if f := files["cri_info.json"]; f.Error != "not available" {
+		t.Errorf("unexpected cri_info.json: %+v", f)
+	}
+}
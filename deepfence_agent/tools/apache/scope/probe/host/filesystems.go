@@ -0,0 +1,215 @@
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"golang.org/x/sys/unix"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the mounts table and disk usage of the host node.
+const (
+	MountPrefix        = report.HostMountPrefix
+	MountDevice        = "host_mount_device"
+	MountPoint         = "host_mount_point"
+	MountFSType        = "host_mount_fstype"
+	MountSize          = "host_mount_size"
+	MountUsed          = "host_mount_used"
+	MountAvailable     = "host_mount_available"
+	MountUsePercent    = "host_mount_use_percent"
+	RootFSUsage        = report.HostRootFSUsage
+	RootFSInodeUsage   = report.HostRootFSInodeUsage
+	DiskPressure       = report.HostDiskPressure
+	rootMountPoint     = "/"
+	mountsFieldsNumber = 6
+
+	// DefaultDiskPressureThreshold is the usage percentage of the space or
+	// inodes of a filesystem above which we warn of disk pressure.
+	DefaultDiskPressureThreshold = 90.0
+)
+
+// Exposed for testing.
+var (
+	// ProcMounts are the mounts of the host's init, rather than the
+	// probe's, whose mount namespace is that of its container.
+	ProcMounts = "/proc/1/mounts"
+)
+
+// pseudoFilesystems are the types of the filesystems which don't use any
+// disk, or which belong to containers.
+var pseudoFilesystems = map[string]struct{}{
+	"autofs": {}, "binfmt_misc": {}, "bpf": {}, "cgroup": {}, "cgroup2": {},
+	"configfs": {}, "debugfs": {}, "devpts": {}, "devtmpfs": {}, "fusectl": {},
+	"hugetlbfs": {}, "mqueue": {}, "nsfs": {}, "overlay": {}, "proc": {},
+	"pstore": {}, "ramfs": {}, "rpc_pipefs": {}, "securityfs": {}, "shm": {},
+	"squashfs": {}, "sysfs": {}, "tmpfs": {}, "tracefs": {},
+}
+
+type mount struct {
+	device, mountPoint, fsType string
+}
+
+// filesystemUsage is what statfs says of a filesystem, in bytes and inodes.
+type filesystemUsage struct {
+	size, free, available uint64
+	inodes, freeInodes    uint64
+}
+
+// usedPercent is the percentage of the space usable by unprivileged users
+// which is used, as df computes it.
+func (u filesystemUsage) usedPercent() float64 {
+	used := u.size - u.free
+	if used+u.available == 0 {
+		return 0
+	}
+	return float64(used) * 100 / float64(used+u.available)
+}
+
+func (u filesystemUsage) inodesUsedPercent() float64 {
+	if u.inodes == 0 {
+		return 0
+	}
+	return float64(u.inodes-u.freeInodes) * 100 / float64(u.inodes)
+}
+
+// unescapeMountField undoes the octal escaping of spaces, tabs, newlines
+// and backslashes in /proc/mounts.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseMounts parses /proc/mounts, leaving out pseudo filesystems and the
+// mounts of devices already mounted elsewhere.
+func parseMounts(r io.Reader) ([]mount, error) {
+	var (
+		mounts  = []mount{}
+		devices = map[string]bool{}
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != mountsFieldsNumber {
+			return nil, fmt.Errorf("invalid format: %q", scanner.Text())
+		}
+		m := mount{
+			device:     unescapeMountField(fields[0]),
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+		}
+		if _, ok := pseudoFilesystems[m.fsType]; ok || devices[m.device] {
+			continue
+		}
+		devices[m.device] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// filesystems is the disk usage of the host, as reported on its node.
+type filesystems struct {
+	rows     []report.Row
+	metrics  report.Metrics
+	pressure bool
+}
+
+// makeFilesystems describes the usage of the mounted filesystems, and warns
+// of disk pressure when any of them has used threshold percent of its space
+// or inodes.
+func makeFilesystems(mounts []mount, stat func(string) (filesystemUsage, error), threshold float64, now time.Time) filesystems {
+	result := filesystems{rows: []report.Row{}, metrics: report.Metrics{}}
+	for _, m := range mounts {
+		usage, err := stat(m.mountPoint)
+		if err != nil || usage.size == 0 {
+			continue
+		}
+		used := usage.usedPercent()
+		result.rows = append(result.rows, report.Row{
+			ID: m.mountPoint,
+			Entries: map[string]string{
+				MountDevice:     m.device,
+				MountPoint:      m.mountPoint,
+				MountFSType:     m.fsType,
+				MountSize:       humanize.IBytes(usage.size),
+				MountUsed:       humanize.IBytes(usage.size - usage.free),
+				MountAvailable:  humanize.IBytes(usage.available),
+				MountUsePercent: fmt.Sprintf("%.0f%%", math.Ceil(used)),
+			},
+		})
+		if m.mountPoint == rootMountPoint {
+			result.metrics[RootFSUsage] = report.MakeSingletonMetric(now, used).WithMax(100)
+			if usage.inodes > 0 {
+				result.metrics[RootFSInodeUsage] = report.MakeSingletonMetric(now, usage.inodesUsedPercent()).WithMax(100)
+			}
+		}
+		if threshold > 0 && (used >= threshold || usage.inodesUsedPercent() >= threshold) {
+			result.pressure = true
+		}
+	}
+	return result
+}
+
+func statFilesystem(path string) (filesystemUsage, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return filesystemUsage{}, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return filesystemUsage{
+		size:       stat.Blocks * blockSize,
+		free:       stat.Bfree * blockSize,
+		available:  stat.Bavail * blockSize,
+		inodes:     stat.Files,
+		freeInodes: stat.Ffree,
+	}, nil
+}
+
+// statHostFilesystem stats the filesystem mounted at path on the host,
+// under HostRoot when the probe runs in a container.
+func statHostFilesystem(path string) (filesystemUsage, error) {
+	return statFilesystem(hostPath(path))
+}
+
+// readFilesystems reads the usage of the filesystems mounted on the host.
+func readFilesystems(threshold float64, now time.Time) (filesystems, error) {
+	f, err := os.Open(ProcMounts)
+	if err != nil {
+		return filesystems{}, err
+	}
+	defer f.Close()
+	mounts, err := parseMounts(f)
+	if err != nil {
+		return filesystems{}, err
+	}
+	return makeFilesystems(mounts, statHostFilesystem, threshold, now), nil
+}
+
+// diskPressureLatests sets the warning when there is disk pressure.
+func diskPressureLatests(f filesystems) map[string]string {
+	if !f.pressure {
+		return map[string]string{}
+	}
+	return map[string]string{DiskPressure: "true"}
+}
@@ -0,0 +1,117 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+const mounts = `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda1 / ext4 rw,relatime 0 0
+tmpfs /run tmpfs rw,nosuid,nodev,mode=755 0 0
+/dev/sda1 /var/lib/kubelet ext4 rw,relatime 0 0
+overlay /var/lib/docker/overlay2/abc/merged overlay rw,relatime 0 0
+/dev/sdb1 /mnt/my\040data xfs rw,relatime 0 0
+`
+
+func TestParseMounts(t *testing.T) {
+	have, err := parseMounts(strings.NewReader(mounts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mount{
+		{device: "/dev/sda1", mountPoint: "/", fsType: "ext4"},
+		{device: "/dev/sdb1", mountPoint: "/mnt/my data", fsType: "xfs"},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	if _, err := parseMounts(strings.NewReader("/dev/sda1 /\n")); err == nil {
+		t.Errorf("Expected truncated line to be an error")
+	}
+}
+
+func TestMakeFilesystems(t *testing.T) {
+	const gb = 1 << 30
+	usages := map[string]filesystemUsage{
+		"/":            {size: 100 * gb, free: 20 * gb, available: 15 * gb, inodes: 1000, freeInodes: 50},
+		"/mnt/my data": {size: 10 * gb, free: 9 * gb, available: 9 * gb, inodes: 100, freeInodes: 90},
+	}
+	stat := func(path string) (filesystemUsage, error) {
+		usage, ok := usages[path]
+		if !ok {
+			return usage, fmt.Errorf("no such file or directory")
+		}
+		return usage, nil
+	}
+	mounts := []mount{
+		{device: "/dev/sda1", mountPoint: "/", fsType: "ext4"},
+		{device: "/dev/sdb1", mountPoint: "/mnt/my data", fsType: "xfs"},
+		{device: "server:/export", mountPoint: "/mnt/gone", fsType: "nfs"},
+	}
+	now := time.Now()
+
+	have := makeFilesystems(mounts, stat, DefaultDiskPressureThreshold, now)
+	if len(have.rows) != 2 {
+		t.Fatalf("Expected a row for each filesystem which could be read, got %v", have.rows)
+	}
+	if want := map[string]string{
+		MountDevice:     "/dev/sda1",
+		MountPoint:      "/",
+		MountFSType:     "ext4",
+		MountSize:       "100 GiB",
+		MountUsed:       "80 GiB",
+		MountAvailable:  "15 GiB",
+		MountUsePercent: "85%",
+	}; !reflect.DeepEqual(want, have.rows[0].Entries) {
+		t.Errorf("Expected %v, got %v", want, have.rows[0].Entries)
+	}
+	for id, want := range map[string]float64{RootFSUsage: 80 * 100 / 95., RootFSInodeUsage: 95} {
+		if sample, ok := have.metrics[id].LastSample(); !ok || sample.Value != want {
+			t.Errorf("Expected %s to be %f, got %v", id, want, sample)
+		}
+	}
+	// The root filesystem has used 95% of its inodes
+	if !have.pressure {
+		t.Errorf("Expected disk pressure")
+	}
+	if have := makeFilesystems(mounts, stat, 99, now); have.pressure {
+		t.Errorf("Expected no disk pressure below the threshold")
+	}
+	if have := makeFilesystems(mounts, stat, 0, now); have.pressure {
+		t.Errorf("Expected no disk pressure with no threshold")
+	}
+}
+
+func TestReadFilesystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystems")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldProcMounts, oldHostRoot := ProcMounts, HostRoot
+	defer func() { ProcMounts, HostRoot = oldProcMounts, oldHostRoot }()
+	ProcMounts, HostRoot = filepath.Join(dir, "mounts"), filepath.Join(dir, "host")
+
+	// The mounts of the host are statted under its root
+	if err := os.MkdirAll(filepath.Join(HostRoot, "only-on-host"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(ProcMounts, []byte("/dev/sdc1 /only-on-host ext4 rw 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	have, err := readFilesystems(0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have.rows) != 1 || have.rows[0].ID != "/only-on-host" {
+		t.Errorf("Expected a row for the mount of the host, got %v", have.rows)
+	}
+}
@@ -0,0 +1,199 @@
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the interfaces table and metrics of the host node.
+const (
+	InterfacePrefix    = report.HostInterfacePrefix
+	InterfaceName      = "host_interface_name"
+	InterfaceMAC       = "host_interface_mac"
+	InterfaceMTU       = "host_interface_mtu"
+	InterfaceAddresses = "host_interface_addresses"
+	InterfaceState     = "host_interface_state"
+	InterfaceSpeed     = "host_interface_speed"
+
+	// Interface metrics are keyed by these prefixes and the interface name.
+	InterfaceRxBytes  = "host_interface_rx_bytes_"
+	InterfaceTxBytes  = "host_interface_tx_bytes_"
+	InterfaceRxErrors = "host_interface_rx_errors_"
+	InterfaceTxErrors = "host_interface_tx_errors_"
+)
+
+// Exposed for testing.
+var (
+	ProcNetDev  = "/proc/net/dev"
+	SysClassNet = "/sys/class/net"
+)
+
+// netDevCounters are the counters of an interface in /proc/net/dev.
+type netDevCounters struct {
+	rxBytes, rxErrors uint64
+	txBytes, txErrors uint64
+}
+
+// parseNetDev parses /proc/net/dev.  After two lines of headers, it has a
+// line of counters per interface, starting with the received bytes, packets
+// and errors, and with the sent ones from the ninth.
+func parseNetDev(r io.Reader) (map[string]netDevCounters, error) {
+	result := map[string]netDevCounters{}
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		colon := strings.IndexByte(scanner.Text(), ':')
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(scanner.Text()[:colon])
+		fields := strings.Fields(scanner.Text()[colon+1:])
+		if len(fields) < 11 {
+			return nil, fmt.Errorf("invalid format for %s: %q", name, scanner.Text())
+		}
+		values := [4]uint64{}
+		for i, field := range []int{0, 2, 8, 10} {
+			v, err := strconv.ParseUint(fields[field], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		result[name] = netDevCounters{
+			rxBytes: values[0], rxErrors: values[1],
+			txBytes: values[2], txErrors: values[3],
+		}
+	}
+	return result, scanner.Err()
+}
+
+// isVirtualInterface says whether an interface is a veth or a bridge, of
+// which container hosts have a lot.
+func isVirtualInterface(name string) bool {
+	if strings.HasPrefix(name, "veth") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(SysClassNet, name, "bridge"))
+	return err == nil
+}
+
+func readSysClassNet(name, attribute string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(SysClassNet, name, attribute))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// interfaceRows describes the interfaces of the host: their link metadata,
+// addresses, and speed where the driver knows it.
+func interfaceRows(interfaces []net.Interface, includeVirtual bool) []report.Row {
+	rows := []report.Row{}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 || (!includeVirtual && isVirtualInterface(iface.Name)) {
+			continue
+		}
+		addresses := []string{}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.String())
+			}
+		}
+		entries := map[string]string{
+			InterfaceName:      iface.Name,
+			InterfaceMAC:       iface.HardwareAddr.String(),
+			InterfaceMTU:       strconv.Itoa(iface.MTU),
+			InterfaceAddresses: strings.Join(addresses, ", "),
+			InterfaceState:     readSysClassNet(iface.Name, "operstate"),
+		}
+		// Reading the speed fails for interfaces which are down, and
+		// virtual ones report -1.
+		if speed, err := strconv.Atoi(readSysClassNet(iface.Name, "speed")); err == nil && speed > 0 {
+			entries[InterfaceSpeed] = fmt.Sprintf("%d Mb/s", speed)
+		}
+		rows = append(rows, report.Row{ID: iface.Name, Entries: entries})
+	}
+	return rows
+}
+
+// interfaceSampler turns the counters of the interfaces into rates.
+type interfaceSampler struct {
+	includeVirtual bool
+	previous       map[string]netDevCounters
+	previousTime   time.Time
+}
+
+func rate(current, previous uint64, seconds float64) (float64, bool) {
+	// A counter going backwards has wrapped, or the interface has been
+	// recreated under the same name since the last sample.
+	if current < previous {
+		return 0, false
+	}
+	return float64(current-previous) / seconds, true
+}
+
+// sample returns the rates of the interfaces since the last sample.
+// Interfaces which are new, or whose counters went backwards, get no
+// rates until the next sample. The priority of a rate depends only on
+// its kind, so it doesn't change as other interfaces come and go; rates
+// of the same kind are ordered by interface name.
+func (s *interfaceSampler) sample(counters map[string]netDevCounters, now time.Time) (report.Metrics, report.MetricTemplates) {
+	metrics, templates := report.Metrics{}, report.MetricTemplates{}
+	seconds := now.Sub(s.previousTime).Seconds()
+	for name, current := range counters {
+		if name == "lo" || (!s.includeVirtual && isVirtualInterface(name)) {
+			continue
+		}
+		previous, ok := s.previous[name]
+		if !ok || seconds <= 0 {
+			continue
+		}
+		for i, m := range []struct {
+			key, label, format string
+			current, previous  uint64
+		}{
+			{InterfaceRxBytes, "received", report.FilesizeFormat, current.rxBytes, previous.rxBytes},
+			{InterfaceTxBytes, "sent", report.FilesizeFormat, current.txBytes, previous.txBytes},
+			{InterfaceRxErrors, "receive errors", report.DefaultFormat, current.rxErrors, previous.rxErrors},
+			{InterfaceTxErrors, "send errors", report.DefaultFormat, current.txErrors, previous.txErrors},
+		} {
+			value, ok := rate(m.current, m.previous, seconds)
+			if !ok {
+				continue
+			}
+			id := m.key + name
+			metrics[id] = report.MakeSingletonMetric(now, value)
+			templates[id] = report.MetricTemplate{
+				ID:       id,
+				Label:    fmt.Sprintf("%s %s/s", name, m.label),
+				Format:   m.format,
+				Group:    name,
+				Priority: float64(21 + i),
+			}
+		}
+	}
+	s.previous, s.previousTime = counters, now
+	return metrics, templates
+}
+
+// readInterfaceCounters reads the counters of the interfaces of the host.
+func readInterfaceCounters() (map[string]netDevCounters, error) {
+	f, err := os.Open(ProcNetDev)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNetDev(f)
+}
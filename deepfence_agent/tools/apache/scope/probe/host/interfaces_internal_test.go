@@ -0,0 +1,96 @@
+package host
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const netDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  123456     100    0    0    0     0          0         0   123456     100    0    0    0     0       0          0
+  eth0: 1000000    2000    3    0    0     0          0         0  500000    1000    1    0    0     0       0          0
+vethab12:    4096      10    0    0    0     0          0         0     2048      5    0    0    0     0       0          0
+`
+
+func TestParseNetDev(t *testing.T) {
+	counters, err := parseNetDev(strings.NewReader(netDev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counters) != 3 {
+		t.Fatalf("Expected 3 interfaces, got %v", counters)
+	}
+	want := netDevCounters{rxBytes: 1000000, rxErrors: 3, txBytes: 500000, txErrors: 1}
+	if have := counters["eth0"]; have != want {
+		t.Errorf("Expected eth0 %+v, got %+v", want, have)
+	}
+	if _, err := parseNetDev(strings.NewReader("\n\neth0: 1 2 3\n")); err == nil {
+		t.Errorf("Expected truncated line to be an error")
+	}
+}
+
+func TestInterfaceSampler(t *testing.T) {
+	var (
+		s   = interfaceSampler{}
+		now = time.Now()
+	)
+	metrics, _ := s.sample(map[string]netDevCounters{
+		"eth0":     {rxBytes: 1000, txBytes: 2000},
+		"lo":       {rxBytes: 1000},
+		"vethab12": {rxBytes: 1000},
+	}, now)
+	if len(metrics) != 0 {
+		t.Errorf("Expected no rates from the first sample, got %v", metrics)
+	}
+
+	now = now.Add(10 * time.Second)
+	metrics, templates := s.sample(map[string]netDevCounters{
+		"eth0":     {rxBytes: 11000, txBytes: 2000, rxErrors: 5},
+		"eth1":     {rxBytes: 1000},
+		"lo":       {rxBytes: 2000},
+		"vethab12": {rxBytes: 2000},
+	}, now)
+	for id, want := range map[string]float64{
+		InterfaceRxBytes + "eth0":  1000,
+		InterfaceTxBytes + "eth0":  0,
+		InterfaceRxErrors + "eth0": 0.5,
+	} {
+		metric, ok := metrics[id]
+		if !ok {
+			t.Errorf("Expected metric %q", id)
+			continue
+		}
+		if sample, _ := metric.LastSample(); sample.Value != want {
+			t.Errorf("Expected %q to be %f, got %f", id, want, sample.Value)
+		}
+		if _, ok := templates[id]; !ok {
+			t.Errorf("Expected a template for %q", id)
+		}
+	}
+	for _, id := range []string{InterfaceRxBytes + "eth1", InterfaceRxBytes + "lo", InterfaceRxBytes + "vethab12"} {
+		if _, ok := metrics[id]; ok {
+			t.Errorf("Expected no metric %q", id)
+		}
+	}
+
+	// eth0 is recreated, and its counters start over
+	now = now.Add(10 * time.Second)
+	metrics, _ = s.sample(map[string]netDevCounters{
+		"eth0": {rxBytes: 100, txBytes: 3000},
+		"eth1": {rxBytes: 3000},
+	}, now)
+	if _, ok := metrics[InterfaceRxBytes+"eth0"]; ok {
+		t.Errorf("Expected no rate for a counter going backwards")
+	}
+	if metric, ok := metrics[InterfaceTxBytes+"eth0"]; !ok {
+		t.Errorf("Expected a rate for a counter going forwards")
+	} else if sample, _ := metric.LastSample(); sample.Value != 100 {
+		t.Errorf("Expected eth0 sent rate to be 100, got %f", sample.Value)
+	}
+	if metric, ok := metrics[InterfaceRxBytes+"eth1"]; !ok {
+		t.Errorf("Expected a rate for eth1 from its second sample")
+	} else if sample, _ := metric.LastSample(); sample.Value < 0 {
+		t.Errorf("Expected no negative rate, got %f", sample.Value)
+	}
+}
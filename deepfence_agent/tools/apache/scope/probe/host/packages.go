@@ -0,0 +1,259 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the package inventory of the host node.
+const (
+	PackagePrefix      = "host_package_"
+	PackageName        = "host_package_name"
+	PackageVersion     = "host_package_version"
+	PackageArch        = "host_package_arch"
+	PackageCount       = "host_packages_count"
+	PackagesCompressed = "host_packages_gzip"
+
+	// Hosts with more packages than this get them as a single compressed
+	// latest value, of a line of name, version and architecture separated
+	// by tabs for each package, instead of a table.
+	maxPackageRows = 250
+
+	packageRefreshInterval = 24 * time.Hour
+)
+
+// The package databases, on the host.  Exposed for testing.
+var (
+	DpkgStatus = "/var/lib/dpkg/status"
+	RpmDB      = "/var/lib/rpm"
+)
+
+var errNoPackageManager = errors.New("no dpkg or rpm package database")
+
+type hostPackage struct {
+	name, version, arch string
+}
+
+// parseDpkgStatus parses the dpkg status file, of a paragraph of fields
+// for each package, listing only those which are installed.
+func parseDpkgStatus(r io.Reader) ([]hostPackage, error) {
+	var (
+		packages  = []hostPackage{}
+		current   hostPackage
+		installed bool
+		scanner   = bufio.NewScanner(r)
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	flush := func() {
+		if installed && current.name != "" {
+			packages = append(packages, current)
+		}
+		current, installed = hostPackage{}, false
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		// Continuation lines of multi-line fields start with a space
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid format: %q", line)
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch line[:colon] {
+		case "Package":
+			current.name = value
+		case "Version":
+			current.version = value
+		case "Architecture":
+			current.arch = value
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
+
+// parsePackageList parses the tab separated name, version and architecture
+// of packages printed by dpkg-query and rpm.
+func parsePackageList(out []byte) []hostPackage {
+	packages := []hostPackage{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] == "" {
+			continue
+		}
+		packages = append(packages, hostPackage{name: fields[0], version: fields[1], arch: fields[2]})
+	}
+	return packages
+}
+
+func readDpkgPackages(status string) ([]hostPackage, error) {
+	f, err := os.Open(status)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	packages, err := parseDpkgStatus(f)
+	if err == nil {
+		return packages, nil
+	}
+	log.Debugf("host: cannot parse %s, asking dpkg-query: %v", status, err)
+	out, err := exec.Command("dpkg-query", "--admindir="+filepath.Dir(status),
+		"-W", "-f", "${Package}\t${Version}\t${Architecture}\n").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parsePackageList(out), nil
+}
+
+// readRpmPackages asks rpm, as there's no Go parser of its Berkeley DB
+// and SQLite databases.
+func readRpmPackages(db string) ([]hostPackage, error) {
+	out, err := exec.Command("rpm", "--dbpath", db,
+		"-qa", "--queryformat", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parsePackageList(out), nil
+}
+
+// readPackages lists the packages installed by the package manager of the
+// host, if it is one we know of, reading its database where the host is
+// mounted rather than that of the probe's container.
+func readPackages() ([]hostPackage, error) {
+	if status := hostPath(DpkgStatus); fileExists(status) {
+		return readDpkgPackages(status)
+	}
+	if db := hostPath(RpmDB); fileExists(db) {
+		return readRpmPackages(db)
+	}
+	return nil, errNoPackageManager
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressPackages packs the packages into a single value, for hosts with
+// too many of them for a table.
+func compressPackages(packages []hostPackage) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	for _, p := range packages {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", p.name, p.version, p.arch); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// packageInventory keeps the packages of the host, reading them once a day
+// or when asked to.
+type packageInventory struct {
+	sync.RWMutex
+	packages []hostPackage
+	refresh  chan struct{}
+	quit     chan struct{}
+}
+
+func newPackageInventory() *packageInventory {
+	p := &packageInventory{
+		refresh: make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *packageInventory) loop() {
+	ticker := time.NewTicker(packageRefreshInterval)
+	defer ticker.Stop()
+	for {
+		packages, err := readPackages()
+		if err != nil && err != errNoPackageManager {
+			log.Warnf("host: cannot read the installed packages: %v", err)
+		}
+		p.Lock()
+		p.packages = packages
+		p.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-p.refresh:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *packageInventory) stop() {
+	close(p.quit)
+}
+
+// refreshPackages is the control reading the packages again.
+func (p *packageInventory) refreshPackages(req xfer.Request) xfer.Response {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+		// A refresh is already pending
+	}
+	return xfer.Response{Value: "Refreshing packages"}
+}
+
+// addTo adds the packages to the host node, as a table or, when there are
+// too many of them, compressed.
+func (p *packageInventory) addTo(node report.Node) report.Node {
+	p.RLock()
+	packages := p.packages
+	p.RUnlock()
+	if packages == nil {
+		return node
+	}
+	node = node.WithLatests(map[string]string{PackageCount: strconv.Itoa(len(packages))})
+	if len(packages) > maxPackageRows {
+		compressed, err := compressPackages(packages)
+		if err != nil {
+			log.Errorf("host: cannot compress the installed packages: %v", err)
+			return node
+		}
+		return node.WithLatests(map[string]string{PackagesCompressed: compressed})
+	}
+	rows := make([]report.Row, 0, len(packages))
+	for _, pkg := range packages {
+		rows = append(rows, report.Row{
+			ID: pkg.name + ":" + pkg.arch,
+			Entries: map[string]string{
+				PackageName:    pkg.name,
+				PackageVersion: pkg.version,
+				PackageArch:    pkg.arch,
+			},
+		})
+	}
+	return node.AddPrefixMulticolumnTable(PackagePrefix, rows)
+}
@@ -0,0 +1,135 @@
+package host
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/scope/report"
+)
+
+const dpkgStatus = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.1-6ubuntu1
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+ .
+ It also incorporates useful features from the Korn and C shells.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: all
+Version: 1.0
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2024a-0ubuntu0.22.04
+`
+
+func TestParseDpkgStatus(t *testing.T) {
+	packages, err := parseDpkgStatus(strings.NewReader(dpkgStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostPackage{
+		{name: "bash", version: "5.1-6ubuntu1", arch: "amd64"},
+		{name: "tzdata", version: "2024a-0ubuntu0.22.04", arch: "all"},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("Expected %v, got %v", want, packages)
+	}
+
+	if _, err := parseDpkgStatus(strings.NewReader("Package bash\n")); err == nil {
+		t.Errorf("Expected a line without a colon to be an error")
+	}
+}
+
+func TestReadPackagesOfHost(t *testing.T) {
+	root, err := ioutil.TempDir("", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	oldRoot := HostRoot
+	defer func() { HostRoot = oldRoot }()
+	HostRoot = root
+
+	// Those of the host, not of the probe's container
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(DpkgStatus)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, DpkgStatus), []byte(dpkgStatus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packages, err := readPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 || packages[0].name != "bash" {
+		t.Errorf("Expected the packages of the host, got %v", packages)
+	}
+}
+
+func TestParsePackageList(t *testing.T) {
+	packages := parsePackageList([]byte("bash\t5.1.8-6.el9\tx86_64\n\tmissing\tname\ninvalid\nzlib\t1.2.11-40.el9\tx86_64\n"))
+	want := []hostPackage{
+		{name: "bash", version: "5.1.8-6.el9", arch: "x86_64"},
+		{name: "zlib", version: "1.2.11-40.el9", arch: "x86_64"},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("Expected %v, got %v", want, packages)
+	}
+}
+
+func TestPackageInventoryAddTo(t *testing.T) {
+	p := &packageInventory{packages: []hostPackage{{name: "bash", version: "5.1", arch: "amd64"}}}
+	node := p.addTo(report.MakeNode("host"))
+	rows := node.ExtractMulticolumnTable(TableTemplates[PackagePrefix])
+	if len(rows) != 1 || rows[0].Entries[PackageVersion] != "5.1" {
+		t.Errorf("Expected a row for bash, got %v", rows)
+	}
+
+	// Above the row threshold the packages are compressed instead
+	p.packages = nil
+	for i := 0; i <= maxPackageRows; i++ {
+		p.packages = append(p.packages, hostPackage{name: fmt.Sprintf("package%d", i), version: "1.0", arch: "all"})
+	}
+	node = p.addTo(report.MakeNode("host"))
+	if rows := node.ExtractMulticolumnTable(TableTemplates[PackagePrefix]); len(rows) != 0 {
+		t.Errorf("Expected no table, got %d rows", len(rows))
+	}
+	if count, _ := node.Latest.Lookup(PackageCount); count != fmt.Sprint(maxPackageRows+1) {
+		t.Errorf("Expected a count of %d, got %q", maxPackageRows+1, count)
+	}
+	compressed, _ := node.Latest.Lookup(PackagesCompressed)
+	data, err := base64.StdEncoding.DecodeString(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packages := parsePackageList(list); !reflect.DeepEqual(packages, p.packages) {
+		t.Errorf("Expected the compressed packages to round trip, got %d packages", len(packages))
+	}
+
+	// Hosts without a known package manager get nothing
+	p.packages = nil
+	if node := p.addTo(report.MakeNode("host")); len(node.Latest) != 0 {
+		t.Errorf("Expected no packages, got %v", node.Latest)
+	}
+}
@@ -0,0 +1,116 @@
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the pressure stall information and steal time of the host.
+const (
+	CPUPressureSome    = "host_cpu_pressure_some_percent"
+	CPUPressureFull    = "host_cpu_pressure_full_percent"
+	MemoryPressureSome = "host_memory_pressure_some_percent"
+	MemoryPressureFull = "host_memory_pressure_full_percent"
+	IOPressureSome     = "host_io_pressure_some_percent"
+	IOPressureFull     = "host_io_pressure_full_percent"
+	CPUSteal           = "host_cpu_steal_percent"
+)
+
+// Exposed for testing.
+var (
+	ProcPressure = "/proc/pressure"
+)
+
+// pressureMetrics are the metrics of each of the files of ProcPressure,
+// by the lines they are on.
+var pressureMetrics = map[string]map[string]string{
+	"cpu":    {"some": CPUPressureSome, "full": CPUPressureFull},
+	"memory": {"some": MemoryPressureSome, "full": MemoryPressureFull},
+	"io":     {"some": IOPressureSome, "full": IOPressureFull},
+}
+
+// parsePressure returns the avg10 of the lines of a /proc/pressure file,
+// which look like "some avg10=1.53 avg60=0.87 avg300=0.26 total=1234".
+// Kernels before 5.13 have no full line for the cpu.
+func parsePressure(r io.Reader) (map[string]float64, error) {
+	result := map[string]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "avg10=") {
+			return nil, fmt.Errorf("invalid format: %q", scanner.Text())
+		}
+		avg10, err := strconv.ParseFloat(strings.TrimPrefix(fields[1], "avg10="), 64)
+		if err != nil {
+			return nil, err
+		}
+		result[fields[0]] = avg10
+	}
+	return result, scanner.Err()
+}
+
+// readPressure reads the pressure stall information of the host, which
+// is missing on kernels before 4.20 or without CONFIG_PSI.
+func readPressure(now time.Time) report.Metrics {
+	metrics := report.Metrics{}
+	for resource, ids := range pressureMetrics {
+		f, err := os.Open(filepath.Join(ProcPressure, resource))
+		if err != nil {
+			continue
+		}
+		averages, err := parsePressure(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		for line, avg10 := range averages {
+			if id, ok := ids[line]; ok {
+				metrics[id] = report.MakeSingletonMetric(now, avg10).WithMax(100)
+			}
+		}
+	}
+	return metrics
+}
+
+// stealSampler turns the CPU time counters into the percentage of time
+// stolen by the hypervisor.
+type stealSampler struct {
+	previous linuxproc.CPUStat
+	sampled  bool
+}
+
+func cpuTotal(s linuxproc.CPUStat) uint64 {
+	return s.User + s.Nice + s.System + s.Idle + s.IOWait + s.IRQ + s.SoftIRQ + s.Steal
+}
+
+// sample returns the steal time since the last sample.  There is none
+// for the first sample, nor when the counters went backwards, as they can
+// after suspend and resume, or a VM migration.
+func (s *stealSampler) sample(current linuxproc.CPUStat) (float64, bool) {
+	previous, sampled := s.previous, s.sampled
+	s.previous, s.sampled = current, true
+	if !sampled || cpuTotal(current) <= cpuTotal(previous) || current.Steal < previous.Steal {
+		return 0, false
+	}
+	return float64(current.Steal-previous.Steal) * 100 / float64(cpuTotal(current)-cpuTotal(previous)), true
+}
+
+// readCPUStat reads the CPU time counters of the host, summed over its
+// CPUs.
+func readCPUStat() (linuxproc.CPUStat, error) {
+	stat, err := linuxproc.ReadStat(ProcStat)
+	if err != nil {
+		return linuxproc.CPUStat{}, err
+	}
+	return stat.CPUStatAll, nil
+}
@@ -0,0 +1,62 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+func TestReadPressure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(procPressure string) { ProcPressure = procPressure }(ProcPressure)
+	ProcPressure = dir
+
+	// No full line for the cpu, as before Linux 5.13, and no io file
+	for resource, content := range map[string]string{
+		"cpu":    "some avg10=1.53 avg60=0.87 avg300=0.26 total=1234\n",
+		"memory": "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\nfull avg10=2.50 avg60=0.00 avg300=0.00 total=0\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, resource), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metrics := readPressure(time.Now())
+	for id, want := range map[string]float64{CPUPressureSome: 1.53, MemoryPressureSome: 0, MemoryPressureFull: 2.5} {
+		if sample, ok := metrics[id].LastSample(); !ok || sample.Value != want {
+			t.Errorf("Expected %s to be %f, got %v", id, want, sample)
+		}
+	}
+	if len(metrics) != 3 {
+		t.Errorf("Expected only the metrics which were read, got %v", metrics)
+	}
+
+	if _, err := parsePressure(strings.NewReader("some total=0\n")); err == nil {
+		t.Errorf("Expected a line without avg10 to be an error")
+	}
+}
+
+func TestStealSampler(t *testing.T) {
+	s := stealSampler{}
+	if _, ok := s.sample(linuxproc.CPUStat{User: 100, Idle: 100, Steal: 10}); ok {
+		t.Errorf("Expected no steal from the first sample")
+	}
+	if steal, ok := s.sample(linuxproc.CPUStat{User: 150, Idle: 130, Steal: 30}); !ok || steal != 20 {
+		t.Errorf("Expected 20%% steal, got %f", steal)
+	}
+	// The counters were reset
+	if steal, ok := s.sample(linuxproc.CPUStat{User: 10, Idle: 10, Steal: 1}); ok {
+		t.Errorf("Expected no steal after the counters went backwards, got %f", steal)
+	}
+	if steal, ok := s.sample(linuxproc.CPUStat{User: 20, Idle: 20, Steal: 1}); !ok || steal != 0 {
+		t.Errorf("Expected no steal, got %f", steal)
+	}
+}
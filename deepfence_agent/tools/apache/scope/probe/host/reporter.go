@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 
-	"github.com/deepfence/df-utils/cloud_metadata"
 	"github.com/sirupsen/logrus"
 
 	//"os/exec"
@@ -18,10 +18,13 @@ import (
 
 	dfUtils "github.com/deepfence/df-utils"
 	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("host")
+
 // Agent version to dispay in metadata
 var (
 	agentVersionNo = "1.0.0"
@@ -53,6 +56,7 @@ const (
 	AgentVersion        = "version"
 	IsUiVm              = "is_ui_vm"
 	AgentRunning        = "agent_running"
+	ShedCollectors      = report.HostShedCollectors
 	nodeTypeHost        = "host"
 	nodeTypeContainer   = "container"
 	nodeTypeImage       = "container_image"
@@ -60,6 +64,22 @@ const (
 	Label               = "label"
 )
 
+// HostRoot is where the filesystem of the host is mounted in the probe's
+// container.  Exposed for testing.
+var HostRoot = "/fenced/mnt/host"
+
+// hostPath returns the path of a file of the host, under HostRoot when the
+// host is mounted there, as it's not when the probe runs on the host.
+func hostPath(path string) string {
+	if HostRoot == "" {
+		return path
+	}
+	if _, err := os.Stat(HostRoot); err != nil {
+		return path
+	}
+	return filepath.Join(HostRoot, path)
+}
+
 // Exposed for testing.
 const (
 	ProcUptime  = "/proc/uptime"
@@ -89,13 +109,117 @@ var (
 		UserDfndTags:        {ID: UserDfndTags, Label: "User Defined Tags", From: report.FromLatest, Priority: 27},
 		AgentVersion:        {ID: AgentVersion, Label: "Agent Version", From: report.FromLatest, Priority: 28},
 		IsUiVm:              {ID: IsUiVm, Label: "UI vm", From: report.FromLatest, Priority: 29},
+		CloudZone:           {ID: CloudZone, Label: "Cloud Zone", From: report.FromLatest, Priority: 30},
+		CloudInstanceType:   {ID: CloudInstanceType, Label: "Instance Type", From: report.FromLatest, Priority: 31},
+		CloudInstanceID:     {ID: CloudInstanceID, Label: "Instance ID", From: report.FromLatest, Priority: 32},
 		AgentRunning:        {ID: AgentRunning, Label: "Agent", From: report.FromLatest, Priority: 33},
+		DiskPressure:        {ID: DiskPressure, Label: "Disk pressure", From: report.FromLatest, Priority: 34},
+		CloudPublicIPs:      {ID: CloudPublicIPs, Label: "Public IPs", From: report.FromLatest, Priority: 35},
+		ClockSkew:           {ID: ClockSkew, Label: "Clock skew (ms)", From: report.FromLatest, Priority: 36, Datatype: report.Number},
+		ClockSkewWarning:    {ID: ClockSkewWarning, Label: "Clock skew", From: report.FromLatest, Priority: 37},
+		TimeSyncService:     {ID: TimeSyncService, Label: "Time sync", From: report.FromLatest, Priority: 38},
+		TimeSynchronized:    {ID: TimeSynchronized, Label: "Clock synchronized", From: report.FromLatest, Priority: 39},
+		ShedCollectors:      {ID: ShedCollectors, Label: "Collectors shedding detail", From: report.FromLatest, Priority: 40},
 	}
 
 	MetricTemplates = report.MetricTemplates{
-		CPUUsage:    {ID: CPUUsage, Label: "CPU", Format: report.PercentFormat, Priority: 1},
-		MemoryUsage: {ID: MemoryUsage, Label: "Memory", Format: report.FilesizeFormat, Priority: 2},
-		Load1:       {ID: Load1, Label: "Load (1m)", Format: report.DefaultFormat, Group: "load", Priority: 11},
+		CPUUsage:           {ID: CPUUsage, Label: "CPU", Format: report.PercentFormat, Priority: 1},
+		MemoryUsage:        {ID: MemoryUsage, Label: "Memory", Format: report.FilesizeFormat, Priority: 2},
+		CPUSteal:           {ID: CPUSteal, Label: "CPU steal", Format: report.PercentFormat, Priority: 3},
+		CPUTemperature:     {ID: CPUTemperature, Label: "CPU temperature (°C)", Format: report.DefaultFormat, Priority: 4},
+		Load1:              {ID: Load1, Label: "Load (1m)", Format: report.DefaultFormat, Group: "load", Priority: 11},
+		RootFSUsage:        {ID: RootFSUsage, Label: "Root filesystem", Format: report.PercentFormat, Group: "disk", Priority: 12},
+		RootFSInodeUsage:   {ID: RootFSInodeUsage, Label: "Root filesystem inodes", Format: report.PercentFormat, Group: "disk", Priority: 13},
+		CPUPressureSome:    {ID: CPUPressureSome, Label: "CPU pressure (some)", Format: report.PercentFormat, Group: "pressure", Priority: 14},
+		CPUPressureFull:    {ID: CPUPressureFull, Label: "CPU pressure (full)", Format: report.PercentFormat, Group: "pressure", Priority: 15},
+		MemoryPressureSome: {ID: MemoryPressureSome, Label: "Memory pressure (some)", Format: report.PercentFormat, Group: "pressure", Priority: 16},
+		MemoryPressureFull: {ID: MemoryPressureFull, Label: "Memory pressure (full)", Format: report.PercentFormat, Group: "pressure", Priority: 17},
+		IOPressureSome:     {ID: IOPressureSome, Label: "IO pressure (some)", Format: report.PercentFormat, Group: "pressure", Priority: 18},
+		IOPressureFull:     {ID: IOPressureFull, Label: "IO pressure (full)", Format: report.PercentFormat, Group: "pressure", Priority: 19},
+		SystemdFailedUnits: {ID: SystemdFailedUnits, Label: "Failed units", Format: report.IntegerFormat, Priority: 20},
+	}
+
+	TableTemplates = report.TableTemplates{
+		InterfacePrefix: {
+			ID:     InterfacePrefix,
+			Label:  "Network interfaces",
+			Type:   report.MulticolumnTableType,
+			Prefix: InterfacePrefix,
+			Columns: []report.Column{
+				{ID: InterfaceName, Label: "Name"},
+				{ID: InterfaceMAC, Label: "MAC"},
+				{ID: InterfaceMTU, Label: "MTU"},
+				{ID: InterfaceAddresses, Label: "Addresses"},
+				{ID: InterfaceState, Label: "State"},
+				{ID: InterfaceSpeed, Label: "Speed"},
+			},
+		},
+		MountPrefix: {
+			ID:     MountPrefix,
+			Label:  "Filesystems",
+			Type:   report.MulticolumnTableType,
+			Prefix: MountPrefix,
+			Columns: []report.Column{
+				{ID: MountDevice, Label: "Device"},
+				{ID: MountPoint, Label: "Mounted on"},
+				{ID: MountFSType, Label: "Type"},
+				{ID: MountSize, Label: "Size"},
+				{ID: MountUsed, Label: "Used"},
+				{ID: MountAvailable, Label: "Available"},
+				{ID: MountUsePercent, Label: "Use%"},
+			},
+		},
+		PackagePrefix: {
+			ID:     PackagePrefix,
+			Label:  "Packages",
+			Type:   report.MulticolumnTableType,
+			Prefix: PackagePrefix,
+			Columns: []report.Column{
+				{ID: PackageName, Label: "Name"},
+				{ID: PackageVersion, Label: "Version"},
+				{ID: PackageArch, Label: "Architecture"},
+			},
+		},
+		SystemdUnitPrefix: {
+			ID:     SystemdUnitPrefix,
+			Label:  "Failed Units",
+			Type:   report.MulticolumnTableType,
+			Prefix: SystemdUnitPrefix,
+			Columns: []report.Column{
+				{ID: SystemdUnitName, Label: "Unit"},
+				{ID: SystemdUnitActive, Label: "Active"},
+				{ID: SystemdUnitSub, Label: "Sub"},
+				{ID: SystemdUnitResult, Label: "Result"},
+				{ID: SystemdUnitExitStatus, Label: "Exit status"},
+			},
+		},
+		SensorPrefix: {
+			ID:     SensorPrefix,
+			Label:  "Sensors",
+			Type:   report.MulticolumnTableType,
+			Prefix: SensorPrefix,
+			Columns: []report.Column{
+				{ID: SensorChip, Label: "Chip"},
+				{ID: SensorLabel, Label: "Sensor"},
+				{ID: SensorValue, Label: "Value"},
+			},
+		},
+		SecurityTableID: {
+			ID:    SecurityTableID,
+			Label: "Security",
+			Type:  report.PropertyListType,
+			FixedRows: map[string]string{
+				KernelVersion:            "Kernel version",
+				SecurityKernelCmdline:    "Kernel command line",
+				SecuritySELinux:          "SELinux",
+				SecurityAppArmor:         "AppArmor",
+				SecurityKptrRestrict:     "kernel.kptr_restrict",
+				SecurityIPForward:        "net.ipv4.ip_forward",
+				SecurityUnprivilegedBPF:  "kernel.unprivileged_bpf_disabled",
+				SecuritySecureBoot:       "Secure boot",
+				SecurityOutOfTreeModules: "Out-of-tree modules",
+			},
+		},
 	}
 
 	CloudProviderMetadataTemplates = report.MetadataTemplates{
@@ -109,57 +233,6 @@ var (
 	}
 )
 
-type CloudMeta struct {
-	cloudMetadata      string
-	cloudProvider      string
-	cloudProviderLabel string
-	cloudRegion        string
-	mtx                sync.RWMutex
-}
-
-func getCloudMetadata(cloudProvider string) (string, string, string, string) {
-	var cloudMetadata cloud_metadata.CloudMetadata
-	if cloudProvider == "aws" {
-		cloudMetadata, _ = cloud_metadata.GetAWSMetadata(false)
-	} else if cloudProvider == "google_cloud" {
-		cloudMetadata, _ = cloud_metadata.GetGoogleCloudMetadata(false)
-	} else if cloudProvider == "azure" {
-		cloudMetadata, _ = cloud_metadata.GetAzureMetadata(false)
-	} else if cloudProvider == "digital_ocean" {
-		cloudMetadata, _ = cloud_metadata.GetDigitalOceanMetadata(false)
-	} else if cloudProvider == "softlayer" {
-		cloudMetadata, _ = cloud_metadata.GetSoftlayerMetadata(false)
-	} else {
-		var err error
-		cloudMetadata, err = cloud_metadata.GetGenericMetadata(false)
-		if err == nil {
-			if !dfUtils.FileExists("/var/run/docker.sock") && !dfUtils.FileExists("/run/containerd/containerd.sock") {
-				cloudProvider = report.CloudProviderServerless
-				cloudMetadata.CloudProvider = report.CloudProviderServerless
-				cloudMetadata.Region = report.CloudProviderServerless
-			}
-		}
-	}
-	cloudMetadataJson, err := json.Marshal(cloudMetadata)
-	if err != nil {
-		return cloudProvider, "Unknown", "unknown", "{}"
-	}
-	return cloudProvider, cloudMetadata.Label, cloudMetadata.Region, string(cloudMetadataJson)
-}
-
-func (r *Reporter) updateCloudMetadata(cloudProvider string) {
-	cloudProvider, cloudProviderLabel, cloudRegion, cloudMetadataJson := getCloudMetadata(cloudProvider)
-	r.cloudMeta.mtx.Lock()
-	r.cloudMeta.cloudProvider = cloudProvider
-	r.cloudMeta.cloudProviderLabel = cloudProviderLabel
-	r.cloudMeta.cloudRegion = cloudRegion
-	r.cloudMeta.cloudMetadata = cloudMetadataJson
-	if r.cloudMeta.cloudMetadata == "" {
-		r.cloudMeta.cloudMetadata = "{}"
-	}
-	r.cloudMeta.mtx.Unlock()
-}
-
 func getAgentTags() []string {
 	var agentTags []string
 	// User defined tags can be set from agent side also
@@ -244,6 +317,10 @@ type HostDetailsEveryMinute struct {
 	InterfaceNames      string
 	InterfaceIPs        string
 	LocalCIDRs          []string
+	InterfaceRows       []report.Row
+	Filesystems         filesystems
+	TimeSync            map[string]string
+	FailedUnits         []report.Row // nil unless the systemd units are known
 	sync.RWMutex
 }
 
@@ -257,6 +334,21 @@ func (r *Reporter) updateHostDetailsEveryMinute() {
 		}
 	}
 	interfaceIPs, _ := getInterfaceIPs()
+	var interfaceRowsFound []report.Row
+	if interfaces, err := net.Interfaces(); err == nil {
+		interfaceRowsFound = interfaceRows(interfaces, r.interfaces.includeVirtual)
+	}
+	filesystems, err := readFilesystems(r.diskThreshold, mtime.Now())
+	if err != nil {
+		logrus.Debugf("host: cannot read the mounted filesystems: %v", err)
+	}
+	timeSync := readTimeSync()
+	var failedUnits []report.Row
+	if r.systemd != nil {
+		if failedUnits, err = r.systemd.failedUnits(); err != nil {
+			logrus.Debugf("host: cannot list the failed systemd units: %v", err)
+		}
+	}
 	var uptimeStr string
 	uptime, err := GetUptime()
 	if err != nil {
@@ -269,11 +361,34 @@ func (r *Reporter) updateHostDetailsEveryMinute() {
 	r.hostDetailsMinute.InterfaceNames = interfaceNames
 	r.hostDetailsMinute.LocalCIDRs = localCIDRs
 	r.hostDetailsMinute.InterfaceIPs = interfaceIPs
+	r.hostDetailsMinute.InterfaceRows = interfaceRowsFound
+	r.hostDetailsMinute.Filesystems = filesystems
+	r.hostDetailsMinute.TimeSync = timeSync
+	r.hostDetailsMinute.FailedUnits = failedUnits
 	r.hostDetailsMinute.Unlock()
 }
 
+type HostDetailsEveryHour struct {
+	Security map[string]string
+	sync.RWMutex
+}
+
+func (r *Reporter) updateHostDetailsEveryHour() {
+	security := readSecurityPosture(securityCollectors)
+	r.hostDetailsHour.Lock()
+	r.hostDetailsHour.Security = security
+	r.hostDetailsHour.Unlock()
+}
+
 type HostDetailsMetrics struct {
-	Metrics report.Metrics
+	Metrics   report.Metrics
+	Templates report.MetricTemplates
+	// MaxSteal is the highest steal time since the last report, or the
+	// last one when there was no sample since.
+	MaxSteal      float64
+	HasSteal      bool
+	stealReported bool
+	SensorRows    []report.Row
 	sync.RWMutex
 }
 
@@ -284,17 +399,48 @@ func (r *Reporter) updateHostDetailsMetrics() {
 	metrics[CPUUsage] = report.MakeSingletonMetric(now, cpuUsage).WithMax(max)
 	memoryUsage, max := GetMemoryUsageBytes()
 	metrics[MemoryUsage] = report.MakeSingletonMetric(now, memoryUsage).WithMax(max)
+	templates := MetricTemplates
+	if counters, err := readInterfaceCounters(); err == nil {
+		interfaceMetrics, interfaceTemplates := r.interfaces.sample(counters, now)
+		for id, metric := range interfaceMetrics {
+			metrics[id] = metric
+		}
+		templates = templates.Merge(interfaceTemplates)
+	}
+	metrics = metrics.Merge(readPressure(now))
+	var sensorRows []report.Row
+	if r.sensors != nil {
+		var sensorMetrics report.Metrics
+		sensorRows, sensorMetrics = r.sensors.read(now)
+		metrics = metrics.Merge(sensorMetrics)
+	}
+	var (
+		steal   float64
+		stealOK bool
+	)
+	if stat, err := readCPUStat(); err == nil {
+		steal, stealOK = r.steal.sample(stat)
+	}
 
 	r.hostDetailsMetrics.Lock()
 	r.hostDetailsMetrics.Metrics = metrics
+	r.hostDetailsMetrics.Templates = templates
+	r.hostDetailsMetrics.SensorRows = sensorRows
+	if stealOK {
+		if r.hostDetailsMetrics.stealReported || steal > r.hostDetailsMetrics.MaxSteal {
+			r.hostDetailsMetrics.MaxSteal = steal
+		}
+		r.hostDetailsMetrics.HasSteal = true
+		r.hostDetailsMetrics.stealReported = false
+	}
 	r.hostDetailsMetrics.Unlock()
 }
 
-func (r *Reporter) updateHostDetails(cloudProvider string) {
+func (r *Reporter) updateHostDetails() {
 	// Set for the first time
 	r.updateHostDetailsMetrics()
 	r.updateHostDetailsEveryMinute()
-	r.updateCloudMetadata(cloudProvider)
+	r.updateHostDetailsEveryHour()
 
 	// Update it every now and then
 	minuteTicker := time.NewTicker(1 * time.Minute)
@@ -310,7 +456,7 @@ func (r *Reporter) updateHostDetails(cloudProvider string) {
 		case <-fiveSecTicker.C:
 			r.updateHostDetailsMetrics()
 		case <-hourTicker.C:
-			r.updateCloudMetadata(cloudProvider)
+			r.updateHostDetailsEveryHour()
 		}
 	}
 }
@@ -332,6 +478,15 @@ type Reporter struct {
 	k8sClusterName     string
 	hostDetailsMetrics HostDetailsMetrics
 	hostDetailsMinute  HostDetailsEveryMinute
+	hostDetailsHour    HostDetailsEveryHour
+	interfaces         interfaceSampler
+	steal              stealSampler
+	packages           *packageInventory // nil unless the package inventory is enabled
+	systemd            *systemdCollector // nil unless the systemd units are enabled and available
+	sensors            *sensorCollector  // nil unless the sensors are enabled
+	// diskThreshold is the usage percentage of a filesystem which sets
+	// the disk pressure warning.  Zero disables it.
+	diskThreshold      float64
 	OSVersion          string
 	KernelVersion      string
 	AgentVersion       string
@@ -339,9 +494,26 @@ type Reporter struct {
 	userDefinedTags    UserDefinedTags
 }
 
+// ReporterConfig holds the configuration of a host Reporter.
+type ReporterConfig struct {
+	HostID          string
+	HostName        string
+	ProbeID         string
+	Version         string
+	Pipes           controls.PipeClient
+	HandlerRegistry *controls.HandlerRegistry
+
+	IncludeVirtualInterfaces bool    // Report veth and bridge interfaces too
+	DiskPressureThreshold    float64 // Filesystem usage percentage of the disk pressure warning; zero disables it
+	CloudMetadata            bool    // Query the metadata services of clouds, rather than only DMI
+	PackageInventory         bool
+	SystemdUnits             bool
+	Sensors                  bool
+}
+
 // NewReporter returns a Reporter which produces a report containing host
 // topology for this host.
-func NewReporter(hostID, hostName, probeID, version string, pipes controls.PipeClient, handlerRegistry *controls.HandlerRegistry) (*Reporter, string, string) {
+func NewReporter(conf ReporterConfig) (*Reporter, string, string) {
 	kernelRelease, kernelVersion, _ := GetKernelReleaseAndVersion()
 	kernel := fmt.Sprintf("%s %s", kernelRelease, kernelVersion)
 	isUIvm := "false"
@@ -349,13 +521,13 @@ func NewReporter(hostID, hostName, probeID, version string, pipes controls.PipeC
 		isUIvm = "true"
 	}
 	r := &Reporter{
-		hostID:          hostID,
-		hostName:        hostName,
-		probeID:         probeID,
-		pipes:           pipes,
-		version:         version,
+		hostID:          conf.HostID,
+		hostName:        conf.HostName,
+		probeID:         conf.ProbeID,
+		pipes:           conf.Pipes,
+		version:         conf.Version,
 		hostShellCmd:    getHostShellCmd(),
-		handlerRegistry: handlerRegistry,
+		handlerRegistry: conf.HandlerRegistry,
 		pipeIDToTTY:     map[string]uintptr{},
 		k8sClusterId:    os.Getenv(report.KubernetesClusterId),
 		k8sClusterName:  os.Getenv(report.KubernetesClusterName),
@@ -367,19 +539,26 @@ func NewReporter(hostID, hostName, probeID, version string, pipes controls.PipeC
 			tags: make([]string, 0),
 		},
 		hostDetailsMinute:  HostDetailsEveryMinute{},
+		interfaces:         interfaceSampler{includeVirtual: conf.IncludeVirtualInterfaces},
+		diskThreshold:      conf.DiskPressureThreshold,
+	}
+	if conf.PackageInventory {
+		r.packages = newPackageInventory()
+	}
+	if conf.SystemdUnits {
+		r.systemd = newSystemdCollector()
+	}
+	if conf.Sensors {
+		r.sensors = newSensorCollector()
 	}
 	if r.k8sClusterId != "" {
 		r.k8sClusterNodeId = report.MakeKubernetesClusterNodeID(r.k8sClusterId)
 	}
 	r.registerControls()
 	go r.updateUserDefinedTags()
-	cloudProvider := cloud_metadata.DetectCloudServiceProvider()
-	r.updateCloudMetadata(cloudProvider)
-	r.cloudMeta.mtx.RLock()
-	cloudRegion := r.cloudMeta.cloudRegion
-	r.cloudMeta.mtx.RUnlock()
-	go r.updateHostDetails(cloudProvider)
-	return r, cloudProvider, cloudRegion
+	r.cloudMeta = loadCloudMetadata(conf.CloudMetadata)
+	go r.updateHostDetails()
+	return r, r.cloudMeta.cloudProvider, r.cloudMeta.cloudRegion
 }
 
 // Name of this reporter, for metrics gathering
@@ -465,21 +644,12 @@ func (r *Reporter) Report() (report.Report, error) {
 	)
 
 	rep.Host = rep.Host.WithMetadataTemplates(MetadataTemplates)
-	rep.Host = rep.Host.WithMetricTemplates(MetricTemplates)
+	rep.Host = rep.Host.WithTableTemplates(TableTemplates)
 
-	r.cloudMeta.mtx.RLock()
 	cloudMetadata := r.cloudMeta.cloudMetadata
 	cloudProvider := r.cloudMeta.cloudProvider
 	cloudProviderLabel := r.cloudMeta.cloudProviderLabel
 	cloudRegion := r.cloudMeta.cloudRegion
-	r.cloudMeta.mtx.RUnlock()
-	if cloudProvider == "" {
-		cloudProvider = "unknown"
-		cloudProviderLabel = "Unknown"
-	}
-	if cloudMetadata == "" {
-		cloudMetadata = "{}"
-	}
 
 	r.userDefinedTags.RLock()
 	userDefinedTags := r.userDefinedTags.tags
@@ -490,11 +660,34 @@ func (r *Reporter) Report() (report.Report, error) {
 	localCIDRs := r.hostDetailsMinute.LocalCIDRs
 	interfaceNames := r.hostDetailsMinute.InterfaceNames
 	interfaceIPs := r.hostDetailsMinute.InterfaceIPs
+	interfaces := r.hostDetailsMinute.InterfaceRows
+	filesystems := r.hostDetailsMinute.Filesystems
+	timeSync := r.hostDetailsMinute.TimeSync
+	failedUnits := r.hostDetailsMinute.FailedUnits
 	r.hostDetailsMinute.RUnlock()
 
-	r.hostDetailsMetrics.RLock()
+	r.hostDetailsHour.RLock()
+	security := r.hostDetailsHour.Security
+	r.hostDetailsHour.RUnlock()
+
+	r.hostDetailsMetrics.Lock()
 	metrics := r.hostDetailsMetrics.Metrics
-	r.hostDetailsMetrics.RUnlock()
+	metricTemplates := r.hostDetailsMetrics.Templates
+	maxSteal, hasSteal := r.hostDetailsMetrics.MaxSteal, r.hostDetailsMetrics.HasSteal
+	r.hostDetailsMetrics.stealReported = true
+	sensorRows := r.hostDetailsMetrics.SensorRows
+	r.hostDetailsMetrics.Unlock()
+	if metricTemplates == nil {
+		metricTemplates = MetricTemplates
+	}
+	rep.Host = rep.Host.WithMetricTemplates(metricTemplates)
+	metrics = metrics.Merge(filesystems.metrics)
+	if failedUnits != nil {
+		metrics = metrics.Merge(report.Metrics{SystemdFailedUnits: report.MakeSingletonMetric(mtime.Now(), float64(len(failedUnits)))})
+	}
+	if hasSteal {
+		metrics = metrics.Merge(report.Metrics{CPUSteal: report.MakeSingletonMetric(mtime.Now(), maxSteal).WithMax(100)})
+	}
 
 	rep.CloudProvider = rep.CloudProvider.WithMetadataTemplates(CloudProviderMetadataTemplates)
 	cloudProviderId := report.MakeCloudProviderNodeID(cloudProvider)
@@ -506,7 +699,7 @@ func (r *Reporter) Report() (report.Report, error) {
 	)
 
 	rep.CloudRegion = rep.CloudRegion.WithMetadataTemplates(CloudRegionMetadataTemplates)
-	cloudRegionId := report.MakeCloudRegionNodeID(cloudRegion + "-" + cloudProvider)
+	cloudRegionId := report.MakeProviderRegionNodeID(cloudProvider, cloudRegion)
 	rep.CloudRegion.AddNode(
 		report.MakeNodeWith(cloudRegionId, map[string]string{
 			Name:          cloudRegion,
@@ -514,33 +707,44 @@ func (r *Reporter) Report() (report.Report, error) {
 		}).WithTopology(CloudRegion).WithParent(CloudProvider, cloudProviderId),
 	)
 
-	rep.Host.AddNode(
-		report.MakeNodeWith(report.MakeHostNodeID(r.hostID), map[string]string{
-			report.ControlProbeID: r.probeID,
-			Timestamp:             mtime.Now().UTC().Format(time.RFC3339Nano),
-			HostName:              r.hostName,
-			OS:                    r.OSVersion,
-			KernelVersion:         r.KernelVersion,
-			Uptime:                uptime,
-			InterfaceNames:        interfaceNames,
-			InterfaceIPs:          interfaceIPs,
-			ProbeId:               r.probeID,
-			CloudProvider:         cloudProvider,
-			CloudRegion:           cloudRegion,
-			CloudMetadata:         cloudMetadata,
-			k8sClusterId:          r.k8sClusterId,
-			k8sClusterName:        r.k8sClusterName,
-			UserDfndTags:          strings.Join(userDefinedTags, ","),
-			AgentVersion:          r.AgentVersion,
-			IsUiVm:                r.IsUiVm,
-			AgentRunning:          agentRunning,
-		}).
-			WithSets(report.MakeSets().
-				Add(LocalNetworks, report.MakeStringSet(localCIDRs...)),
-			).
-			WithMetrics(metrics).
-			WithParent(report.KubernetesCluster, r.k8sClusterNodeId),
-	)
+	node := report.MakeNodeWith(report.MakeHostNodeID(r.hostID), map[string]string{
+		report.ControlProbeID: r.probeID,
+		Timestamp:             mtime.Now().UTC().Format(time.RFC3339Nano),
+		HostName:              r.hostName,
+		OS:                    r.OSVersion,
+		KernelVersion:         r.KernelVersion,
+		Uptime:                uptime,
+		InterfaceNames:        interfaceNames,
+		InterfaceIPs:          interfaceIPs,
+		ProbeId:               r.probeID,
+		CloudProvider:         cloudProvider,
+		CloudRegion:           cloudRegion,
+		CloudMetadata:         cloudMetadata,
+		k8sClusterId:          r.k8sClusterId,
+		k8sClusterName:        r.k8sClusterName,
+		UserDfndTags:          strings.Join(userDefinedTags, ","),
+		AgentVersion:          r.AgentVersion,
+		IsUiVm:                r.IsUiVm,
+		AgentRunning:          agentRunning,
+	}).
+		WithSets(report.MakeSets().
+			Add(LocalNetworks, report.MakeStringSet(localCIDRs...)),
+		).
+		WithMetrics(metrics).
+		AddPrefixMulticolumnTable(InterfacePrefix, interfaces).
+		AddPrefixMulticolumnTable(MountPrefix, filesystems.rows).
+		AddPrefixMulticolumnTable(SystemdUnitPrefix, failedUnits).
+		AddPrefixMulticolumnTable(SensorPrefix, sensorRows).
+		WithLatests(diskPressureLatests(filesystems)).
+		WithLatests(r.cloudMeta.latests).
+		WithLatests(security).
+		WithLatests(timeSync).
+		WithLatests(clockLatests(mtime.Now())).
+		WithParent(report.KubernetesCluster, r.k8sClusterNodeId)
+	if r.packages != nil {
+		node = r.packages.addTo(node)
+	}
+	rep.Host.AddNode(node)
 
 	return rep, nil
 }
@@ -548,4 +752,7 @@ func (r *Reporter) Report() (report.Report, error) {
 // Stop stops the reporter.
 func (r *Reporter) Stop() {
 	r.deregisterControls()
+	if r.packages != nil {
+		r.packages.stop()
+	}
 }
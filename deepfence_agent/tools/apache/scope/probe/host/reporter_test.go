@@ -10,6 +10,7 @@ import (
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/probe/host"
 	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
 )
 
 func TestReporter(t *testing.T) {
@@ -57,12 +58,27 @@ func TestReporter(t *testing.T) {
 	host.GetLocalNetworks = func() ([]*net.IPNet, error) { return []*net.IPNet{ipnet}, nil }
 
 	hr := controls.NewDefaultHandlerRegistry()
-	rpt, err := host.NewReporter(hostID, hostname, "probe-id", "", nil, hr).Report()
+	r, _, _ := host.NewReporter(host.ReporterConfig{
+		HostID:                hostID,
+		HostName:              hostname,
+		ProbeID:               "probe-id",
+		HandlerRegistry:       hr,
+		DiskPressureThreshold: host.DefaultDiskPressureThreshold,
+	})
+	defer r.Stop()
+
+	// The host details are first read in the background
+	nodeID := report.MakeHostNodeID(hostID)
+	test.Poll(t, time.Second, uptime, func() interface{} {
+		rpt, _ := r.Report()
+		have, _ := rpt.Host.Nodes[nodeID].Latest.Lookup(host.Uptime)
+		return have
+	})
+	rpt, err := r.Report()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	nodeID := report.MakeHostNodeID(hostID)
 	node, ok := rpt.Host.Nodes[nodeID]
 	if !ok {
 		t.Errorf("Expected host node %q, but not found", nodeID)
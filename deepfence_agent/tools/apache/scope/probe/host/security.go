@@ -0,0 +1,157 @@
+package host
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys for the security table of the host node.
+const (
+	SecurityTableID          = "host_security_table"
+	SecurityKernelCmdline    = "host_security_kernel_cmdline"
+	SecuritySELinux          = "host_security_selinux"
+	SecurityAppArmor         = "host_security_apparmor"
+	SecurityKptrRestrict     = "host_security_kptr_restrict"
+	SecurityIPForward        = "host_security_ip_forward"
+	SecurityUnprivilegedBPF  = "host_security_unprivileged_bpf_disabled"
+	SecuritySecureBoot       = "host_security_secure_boot"
+	SecurityOutOfTreeModules = "host_security_out_of_tree_modules"
+
+	// The EFI variable holding the secure boot state, after four bytes of
+	// attributes.
+	secureBootVariable = "SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+)
+
+// Exposed for testing.
+var (
+	ProcCmdline = "/proc/cmdline"
+	ProcModules = "/proc/modules"
+	ProcSys     = "/proc/sys"
+	SysFS       = "/sys"
+)
+
+func readTrimmed(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+func sysctl(name string) func() (string, error) {
+	return func() (string, error) {
+		return readTrimmed(filepath.Join(ProcSys, strings.Replace(name, ".", "/", -1)))
+	}
+}
+
+func kernelCmdline() (string, error) {
+	return readTrimmed(ProcCmdline)
+}
+
+// selinuxMode is disabled when selinuxfs isn't mounted, as getenforce says.
+func selinuxMode() (string, error) {
+	enforce, err := readTrimmed(filepath.Join(SysFS, "fs/selinux/enforce"))
+	switch {
+	case os.IsNotExist(err):
+		return "disabled", nil
+	case err != nil:
+		return "", err
+	case enforce == "1":
+		return "enforcing", nil
+	default:
+		return "permissive", nil
+	}
+}
+
+func apparmorMode() (string, error) {
+	enabled, err := readTrimmed(filepath.Join(SysFS, "module/apparmor/parameters/enabled"))
+	switch {
+	case os.IsNotExist(err):
+		return "disabled", nil
+	case err != nil:
+		return "", err
+	case enabled == "Y":
+		return "enabled", nil
+	default:
+		return "disabled", nil
+	}
+}
+
+func secureBoot() (string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(SysFS, "firmware/efi/efivars", secureBootVariable))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < 5 {
+		return "", io.ErrUnexpectedEOF
+	}
+	if buf[4] == 1 {
+		return "enabled", nil
+	}
+	return "disabled", nil
+}
+
+// parseOutOfTreeModules lists the modules of /proc/modules tainting the
+// kernel with O, for out-of-tree.  Their taints are the last field, as in
+// "(OE)", and only there for tainting modules.
+func parseOutOfTreeModules(r io.Reader) ([]string, error) {
+	modules := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		taints := fields[len(fields)-1]
+		if strings.HasPrefix(taints, "(") && strings.ContainsRune(taints, 'O') {
+			modules = append(modules, fields[0])
+		}
+	}
+	return modules, scanner.Err()
+}
+
+func outOfTreeModules() (string, error) {
+	f, err := os.Open(ProcModules)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	modules, err := parseOutOfTreeModules(f)
+	if err != nil {
+		return "", err
+	}
+	if len(modules) == 0 {
+		return "none", nil
+	}
+	return strings.Join(modules, ", "), nil
+}
+
+var securityCollectors = map[string]func() (string, error){
+	SecurityKernelCmdline:    kernelCmdline,
+	SecuritySELinux:          selinuxMode,
+	SecurityAppArmor:         apparmorMode,
+	SecurityKptrRestrict:     sysctl("kernel.kptr_restrict"),
+	SecurityIPForward:        sysctl("net.ipv4.ip_forward"),
+	SecurityUnprivilegedBPF:  sysctl("kernel.unprivileged_bpf_disabled"),
+	SecuritySecureBoot:       secureBoot,
+	SecurityOutOfTreeModules: outOfTreeModules,
+}
+
+// readSecurityPosture runs the collectors, leaving out the fields of
+// those which fail so that the others are still reported.
+func readSecurityPosture(collectors map[string]func() (string, error)) map[string]string {
+	result := map[string]string{}
+	for key, collect := range collectors {
+		value, err := collect()
+		if err != nil {
+			log.Debugf("host: cannot read %s: %v", key, err)
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
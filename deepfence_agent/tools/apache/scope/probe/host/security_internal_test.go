@@ -0,0 +1,73 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+const modules = `nf_conntrack 139264 2 xt_conntrack,nf_nat, Live 0x0000000000000000
+vboxdrv 487424 2 vboxnetadp,vboxnetflt, Live 0x0000000000000000 (OE)
+nvidia 35319808 1 nvidia_modeset, Live 0x0000000000000000 (POE)
+signed_vendor 16384 0 - Live 0x0000000000000000 (E)
+`
+
+func TestParseOutOfTreeModules(t *testing.T) {
+	have, err := parseOutOfTreeModules(strings.NewReader(modules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"vboxdrv", "nvidia"}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+}
+
+func TestReadSecurityPosture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "security")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(procSys, sysFS string) { ProcSys, SysFS = procSys, sysFS }(ProcSys, SysFS)
+	ProcSys, SysFS = filepath.Join(dir, "proc/sys"), filepath.Join(dir, "sys")
+
+	for path, content := range map[string]string{
+		"proc/sys/net/ipv4/ip_forward":                   "1\n",
+		"sys/fs/selinux/enforce":                         "0",
+		"sys/firmware/efi/efivars/" + secureBootVariable: "\x06\x00\x00\x00\x01",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	have := readSecurityPosture(map[string]func() (string, error){
+		SecuritySELinux:      selinuxMode,
+		SecurityAppArmor:     apparmorMode,
+		SecurityIPForward:    sysctl("net.ipv4.ip_forward"),
+		SecurityKptrRestrict: sysctl("kernel.kptr_restrict"),
+		SecuritySecureBoot:   secureBoot,
+		SecurityKernelCmdline: func() (string, error) {
+			return "", fmt.Errorf("permission denied")
+		},
+	})
+	// A missing sysctl, or one failing collector, leaves out only its field
+	want := map[string]string{
+		SecuritySELinux:    "permissive",
+		SecurityAppArmor:   "disabled",
+		SecurityIPForward:  "1",
+		SecuritySecureBoot: "enabled",
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+}
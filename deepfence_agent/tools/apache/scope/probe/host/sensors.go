@@ -0,0 +1,126 @@
+package host
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the hardware sensors of the host node.
+const (
+	SensorPrefix   = "host_sensor_"
+	SensorChip     = "host_sensor_chip"
+	SensorLabel    = "host_sensor_label"
+	SensorValue    = "host_sensor_value"
+	CPUTemperature = "host_cpu_temperature"
+)
+
+// Exposed for testing.
+var (
+	SysClassHwmon = "/sys/class/hwmon"
+)
+
+// cpuSensorChips are the hwmon drivers of the temperature sensors of CPUs.
+var cpuSensorChips = map[string]struct{}{
+	"coretemp": {}, "k10temp": {}, "zenpower": {}, "cpu_thermal": {},
+}
+
+// sensor is an input file of a hwmon chip, of a temperature in millidegrees
+// Celsius or of the speed of a fan in RPM.
+type sensor struct {
+	id, chip, label, path string
+	fan, cpu              bool
+}
+
+// discoverSensors walks the hwmon chips for the temperature and fan inputs,
+// skipping those which cannot be read.
+func discoverSensors() []sensor {
+	sensors := []sensor{}
+	chips, _ := filepath.Glob(filepath.Join(SysClassHwmon, "hwmon*"))
+	for _, chip := range chips {
+		name, err := readTrimmed(filepath.Join(chip, "name"))
+		if err != nil {
+			continue
+		}
+		_, cpuChip := cpuSensorChips[name]
+		inputs, _ := filepath.Glob(filepath.Join(chip, "temp*_input"))
+		fans, _ := filepath.Glob(filepath.Join(chip, "fan*_input"))
+		for _, input := range append(inputs, fans...) {
+			if _, err := readTrimmed(input); err != nil {
+				continue
+			}
+			base := strings.TrimSuffix(filepath.Base(input), "_input")
+			fan := strings.HasPrefix(base, "fan")
+			label, _ := readTrimmed(strings.TrimSuffix(input, "_input") + "_label")
+			if label == "" {
+				label = base
+			}
+			sensors = append(sensors, sensor{
+				id:    filepath.Base(chip) + "/" + base,
+				chip:  name,
+				label: label,
+				path:  input,
+				fan:   fan,
+				cpu:   cpuChip && !fan,
+			})
+		}
+	}
+	return sensors
+}
+
+// sensorCollector reads the sensors discovered when it was made, so as
+// not to walk sysfs every time.
+type sensorCollector struct {
+	sensors []sensor
+}
+
+func newSensorCollector() *sensorCollector {
+	return &sensorCollector{sensors: discoverSensors()}
+}
+
+// read returns a row for each sensor, and the highest temperature of the
+// CPUs, of their packages or cores.  Intel coretemp labels them "Package id
+// 0" and "Core 0", AMD k10temp "Tctl" and "Tccd1".
+func (c *sensorCollector) read(now time.Time) ([]report.Row, report.Metrics) {
+	var (
+		rows    = []report.Row{}
+		metrics = report.Metrics{}
+		maxCPU  float64
+		hasCPU  bool
+	)
+	for _, s := range c.sensors {
+		raw, err := readTrimmed(s.path)
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		formatted := strconv.FormatFloat(value, 'f', 0, 64) + " RPM"
+		if !s.fan {
+			value /= 1000
+			formatted = strconv.FormatFloat(value, 'f', 1, 64) + " °C"
+		}
+		if s.cpu && (!hasCPU || value > maxCPU) {
+			maxCPU, hasCPU = value, true
+		}
+		rows = append(rows, report.Row{
+			ID: s.id,
+			Entries: map[string]string{
+				SensorChip:  s.chip,
+				SensorLabel: s.label,
+				SensorValue: formatted,
+			},
+		})
+	}
+	if hasCPU {
+		metrics[CPUTemperature] = report.MakeSingletonMetric(now, maxCPU)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows, metrics
+}
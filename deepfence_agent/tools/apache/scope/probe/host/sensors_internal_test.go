@@ -0,0 +1,58 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSensorCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hwmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(sysClassHwmon string) { SysClassHwmon = sysClassHwmon }(SysClassHwmon)
+	SysClassHwmon = dir
+
+	for chip, files := range map[string]map[string]string{
+		"hwmon0": {"name": "coretemp\n", "temp1_input": "52000\n", "temp1_label": "Package id 0\n", "temp2_input": "61000\n", "temp2_label": "Core 0\n"},
+		"hwmon1": {"name": "nvme\n", "temp1_input": "38850\n", "temp1_label": "Composite\n"},
+		"hwmon2": {"name": "nct6775\n", "fan1_input": "1200\n", "temp1_input": "99000\n"},
+		// No name, as for a driver which failed to probe
+		"hwmon3": {"temp1_input": "10000\n"},
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, chip), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, chip, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := newSensorCollector()
+	if len(c.sensors) != 5 {
+		t.Fatalf("Expected 5 sensors, got %v", c.sensors)
+	}
+	// The paths are cached, so a sensor which went away is skipped
+	if err := os.Remove(filepath.Join(dir, "hwmon2", "temp1_input")); err != nil {
+		t.Fatal(err)
+	}
+	rows, metrics := c.read(time.Now())
+	if len(rows) != 4 {
+		t.Fatalf("Expected 4 rows, got %v", rows)
+	}
+	if rows[0].ID != "hwmon0/temp1" || rows[0].Entries[SensorLabel] != "Package id 0" || rows[0].Entries[SensorValue] != "52.0 °C" {
+		t.Errorf("Expected the package temperature first, got %v", rows[0])
+	}
+	if rows[3].Entries[SensorLabel] != "fan1" || rows[3].Entries[SensorValue] != "1200 RPM" {
+		t.Errorf("Expected an unlabelled fan, got %v", rows[3])
+	}
+	if sample, ok := metrics[CPUTemperature].LastSample(); !ok || sample.Value != 61 {
+		t.Errorf("Expected the highest CPU temperature of 61, got %v", sample)
+	}
+}
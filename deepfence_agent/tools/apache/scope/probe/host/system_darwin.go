@@ -2,6 +2,7 @@ package host
 
 import (
 	"bytes"
+	"errors"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -84,3 +85,15 @@ var GetCPUUsagePercent = func() (float64, float64) {
 var GetMemoryUsageBytes = func() (float64, float64) {
 	return 0.0, 0.0
 }
+
+// GetMonotonicTime returns the monotonic clock of the host, which doesn't
+// jump when the wall clock is set.
+var GetMonotonicTime = func() (time.Duration, error) {
+	return 0, errors.New("not implemented")
+}
+
+// GetClockSynchronized returns whether the kernel considers the clock
+// synchronized.
+var GetClockSynchronized = func() (bool, error) {
+	return false, errors.New("not implemented")
+}
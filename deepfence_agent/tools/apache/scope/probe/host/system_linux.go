@@ -108,3 +108,30 @@ var GetMemoryUsageBytes = func() (float64, float64) {
 	used := meminfo.MemTotal - meminfo.MemFree - meminfo.Buffers - meminfo.Cached
 	return float64(used * kb), float64(meminfo.MemTotal * kb)
 }
+
+// The adjtimex state and status flag of an unsynchronized clock.
+const (
+	timeError = 5
+	staUnsync = 0x0040
+)
+
+// GetMonotonicTime returns the monotonic clock of the host, which doesn't
+// jump when the wall clock is set.
+var GetMonotonicTime = func() (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// GetClockSynchronized returns whether the kernel considers the clock
+// synchronized, as timedatectl does.
+var GetClockSynchronized = func() (bool, error) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return false, err
+	}
+	return state != timeError && tx.Status&staUnsync == 0, nil
+}
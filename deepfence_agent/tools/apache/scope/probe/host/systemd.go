@@ -0,0 +1,135 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the failed systemd units of the host node.
+const (
+	SystemdUnitPrefix     = "host_systemd_unit_"
+	SystemdUnitName       = "host_systemd_unit_name"
+	SystemdUnitActive     = "host_systemd_unit_active"
+	SystemdUnitSub        = "host_systemd_unit_sub"
+	SystemdUnitResult     = "host_systemd_unit_result"
+	SystemdUnitExitStatus = "host_systemd_unit_exit_status"
+	SystemdFailedUnits    = "host_systemd_failed_units"
+)
+
+// Exposed for testing.
+var (
+	SystemdRun   = "/run/systemd"
+	SystemBusRun = "/run/dbus/system_bus_socket"
+)
+
+// systemdCollector lists the failed units of systemd.  There's no D-Bus
+// client vendored, so it asks systemctl, which talks to the systemd private
+// socket or the system bus.
+type systemdCollector struct {
+	systemctl string
+}
+
+// newSystemdCollector returns nil on hosts not booted with systemd, and in
+// containers without its sockets or systemctl.
+func newSystemdCollector() *systemdCollector {
+	// As sd_booted(3)
+	if _, err := os.Stat(filepath.Join(SystemdRun, "system")); err != nil {
+		log.Debugf("host: not booted with systemd, not reporting its units")
+		return nil
+	}
+	_, privateErr := os.Stat(filepath.Join(SystemdRun, "private"))
+	_, busErr := os.Stat(SystemBusRun)
+	if privateErr != nil && busErr != nil {
+		log.Debugf("host: no systemd socket, not reporting its units")
+		return nil
+	}
+	systemctl, err := exec.LookPath("systemctl")
+	if err != nil {
+		log.Debugf("host: no systemctl, not reporting the systemd units")
+		return nil
+	}
+	return &systemdCollector{systemctl: systemctl}
+}
+
+// parseFailedUnits parses the names of units from the output of
+// systemctl list-units --plain --no-legend, which look like
+// "kubelet.service loaded failed failed kubelet: The Kubernetes Node Agent".
+func parseFailedUnits(out []byte) []string {
+	units := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Older systemctl mark failed units with a bullet despite --plain
+		if len(fields) > 0 && fields[0] == "●" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units
+}
+
+// parseUnitProperties parses the output of systemctl show, of a paragraph
+// of property=value lines for each unit, into a row for each unit.
+func parseUnitProperties(out []byte) []report.Row {
+	var (
+		rows    = []report.Row{}
+		current = map[string]string{}
+		scanner = bufio.NewScanner(bytes.NewReader(out))
+	)
+	flush := func() {
+		if id := current["Id"]; id != "" {
+			entries := map[string]string{
+				SystemdUnitName:   id,
+				SystemdUnitActive: current["ActiveState"],
+				SystemdUnitSub:    current["SubState"],
+				SystemdUnitResult: current["Result"],
+			}
+			// Only services have a main process
+			if status, ok := current["ExecMainStatus"]; ok && strings.HasSuffix(id, ".service") {
+				entries[SystemdUnitExitStatus] = status
+			}
+			rows = append(rows, report.Row{ID: id, Entries: entries})
+		}
+		current = map[string]string{}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i > 0 {
+			current[line[:i]] = line[i+1:]
+		}
+	}
+	flush()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows
+}
+
+// failedUnits returns a row for each failed unit.
+func (s *systemdCollector) failedUnits() ([]report.Row, error) {
+	out, err := exec.Command(s.systemctl, "list-units", "--failed", "--all", "--plain", "--no-legend", "--no-pager").Output()
+	if err != nil {
+		return nil, err
+	}
+	units := parseFailedUnits(out)
+	if len(units) == 0 {
+		return []report.Row{}, nil
+	}
+	args := append([]string{"show", "--no-pager", "--property=Id,ActiveState,SubState,Result,ExecMainStatus", "--"}, units...)
+	out, err = exec.Command(s.systemctl, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseUnitProperties(out), nil
+}
@@ -0,0 +1,56 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseFailedUnits(t *testing.T) {
+	units := parseFailedUnits([]byte("kubelet.service loaded failed failed kubelet: The Kubernetes Node Agent\n● data.mount loaded failed failed /data\n"))
+	if want := []string{"kubelet.service", "data.mount"}; !reflect.DeepEqual(units, want) {
+		t.Errorf("Expected %v, got %v", want, units)
+	}
+}
+
+func TestParseUnitProperties(t *testing.T) {
+	rows := parseUnitProperties([]byte(`Result=exit-code
+ExecMainStatus=1
+Id=kubelet.service
+ActiveState=failed
+SubState=failed
+
+Result=exit-code
+ExecMainStatus=0
+Id=data.mount
+ActiveState=failed
+SubState=failed
+`))
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %v", rows)
+	}
+	if rows[0].ID != "data.mount" {
+		t.Errorf("Expected the rows to be sorted, got %v", rows)
+	}
+	if _, ok := rows[0].Entries[SystemdUnitExitStatus]; ok {
+		t.Errorf("Expected no exit status for a mount, got %v", rows[0])
+	}
+	if status := rows[1].Entries[SystemdUnitExitStatus]; status != "1" {
+		t.Errorf("Expected exit status 1, got %v", rows[1])
+	}
+}
+
+func TestNewSystemdCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(systemdRun string) { SystemdRun = systemdRun }(SystemdRun)
+	SystemdRun = dir
+
+	if collector := newSystemdCollector(); collector != nil {
+		t.Errorf("Expected no collector on a host not booted with systemd")
+	}
+}
@@ -19,7 +19,7 @@ func NewTagger(hostID, cloudProvider, cloudRegion string) Tagger {
 	return Tagger{
 		hostNodeID:          report.MakeHostNodeID(hostID),
 		cloudProviderNodeID: report.MakeCloudProviderNodeID(cloudProvider),
-		cloudRegionNodeID:   report.MakeCloudRegionNodeID(cloudRegion + "-" + cloudProvider),
+		cloudRegionNodeID:   report.MakeProviderRegionNodeID(cloudProvider, cloudRegion),
 	}
 }
 
@@ -10,22 +10,32 @@ import (
 	"time"
 
 	"github.com/weaveworks/common/backoff"
+	"github.com/weaveworks/common/mtime"
 
 	snapshotv1 "github.com/openebs/k8s-snapshot-client/snapshot/pkg/apis/volumesnapshot/v1"
 	snapshot "github.com/openebs/k8s-snapshot-client/snapshot/pkg/client/clientset/versioned"
 	"github.com/pborman/uuid"
-	log "github.com/sirupsen/logrus"
 	apiappsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	apibatchv1 "k8s.io/api/batch/v1"
 	apibatchv1beta1 "k8s.io/api/batch/v1beta1"
 	apiv1 "k8s.io/api/core/v1"
+	apiextensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -61,13 +71,26 @@ type Client interface {
 	WalkVolumeSnapshots(f func(VolumeSnapshot) error) error
 	WalkVolumeSnapshotData(f func(VolumeSnapshotData) error) error
 	WalkJobs(f func(Job) error) error
+	WalkIngresses(f func(Ingress) error) error
+	WalkNetworkPolicies(f func(NetworkPolicy) error) error
+	WalkHorizontalPodAutoscalers(f func(HorizontalPodAutoscaler) error) error
+	WalkResourceQuotas(f func(*apiv1.ResourceQuota) error) error
+	WalkLimitRanges(f func(*apiv1.LimitRange) error) error
+	WalkEvents(f func(*apiv1.Event) error) error
+	WalkServiceAccounts(f func(*apiv1.ServiceAccount) error) error
+	IsClusterAdmin(namespace, serviceAccount string) bool
+	WalkCustomResources(f func(CustomResource) error) error
+	WalkOwnerReferences(f func(uid string, owners []metav1.OwnerReference) error) error
 	WatchPods(f func(Event, Pod))
+	WatchNetworkPolicies(f func(Event, NetworkPolicy))
 
 	CloneVolumeSnapshot(namespaceID, volumeSnapshotID, persistentVolumeClaimID, capacity string) error
 	CreateVolumeSnapshot(namespaceID, persistentVolumeClaimID, capacity string) error
-	GetLogs(namespaceID, podID string, containerNames []string) (io.ReadCloser, error)
+	GetLogs(namespaceID, podID string, containerNames []string, options apiv1.PodLogOptions) (io.ReadCloser, error)
+	CanI(verb, resource, subresource string) (bool, error)
 	//Describe(namespaceID, resourceID string, groupKind schema.GroupKind, restMapping apimeta.RESTMapping) (io.ReadCloser, error)
-	DeletePod(namespaceID, podID string) error
+	DeletePod(namespaceID, podID string, gracePeriodSeconds *int64) error
+	CordonNode(name string, cordon bool) error
 	DeleteVolumeSnapshot(namespaceID, volumeSnapshotID string) error
 	//ScaleUp(namespaceID, id string) error
 	//ScaleDown(namespaceID, id string) error
@@ -95,13 +118,24 @@ type client struct {
 	quit                       chan struct{}
 	client                     *kubernetes.Clientset
 	snapshotClient             *snapshot.Clientset
+	dynamicClient              dynamic.Interface
 	podStore                   cache.Store
 	serviceStore               cache.Store
 	deploymentStore            cache.Store
 	daemonSetStore             cache.Store
 	statefulSetStore           cache.Store
+	replicaSetStore            cache.Store
 	jobStore                   cache.Store
 	cronJobStore               cache.Store
+	ingressStore               cache.Store
+	networkPolicyStore         cache.Store
+	hpaStore                   cache.Store
+	resourceQuotaStore         cache.Store
+	limitRangeStore            cache.Store
+	eventStore                 *boundedEventStore
+	serviceAccountStore        cache.Store
+	roleBindingStore           cache.Store
+	clusterRoleBindingStore    cache.Store
 	nodeStore                  cache.Store
 	namespaceStore             cache.Store
 	persistentVolumeStore      cache.Store
@@ -109,11 +143,22 @@ type client struct {
 	storageClassStore          cache.Store
 	volumeSnapshotStore        cache.Store
 	volumeSnapshotDataStore    cache.Store
+	customResourceStores       []cache.Store
 	//calicoAPIClient            *calico_helper.CalicoAPIClient
 	cniPlugin string
 
+	completedJobMaxAge time.Duration
+
 	podWatchesMutex sync.Mutex
 	podWatches      []func(Event, Pod)
+
+	networkPolicyWatchesMutex sync.Mutex
+	networkPolicyWatches      []func(Event, NetworkPolicy)
+
+	// clusterAdmins is computed from the role bindings when first needed
+	// after they change.
+	clusterAdminsMutex sync.Mutex
+	clusterAdmins      *clusterAdmins
 }
 
 // ClientConfig establishes the configuration for the kubernetes client
@@ -130,47 +175,62 @@ type ClientConfig struct {
 	Token                string
 	User                 string
 	Username             string
+
+	// CompletedJobMaxAge is how long finished jobs keep being reported.
+	// Zero means forever.
+	CompletedJobMaxAge time.Duration
+
+	// EventMaxAge is how long warning events keep being reported after
+	// they last happened.  Zero means for as long as the API server keeps
+	// them.
+	EventMaxAge time.Duration
+
+	// CustomResources is a comma-separated list of the group/version/resource
+	// of custom resources to report, with the CustomResourceFields of their
+	// objects, as comma-separated NAME:JSONPATH.
+	CustomResources      string
+	CustomResourceFields string
 }
 
-// NewClient returns a usable Client. Don't forget to Stop it.
-func NewClient(config ClientConfig) (Client, error) {
-	var restConfig *rest.Config
+// newRestConfig returns the configuration of the connection to the API
+// server.
+func newRestConfig(config ClientConfig) (*rest.Config, error) {
 	if config.Server == "" && config.Kubeconfig == "" {
 		// If no API server address or kubeconfig was provided, assume we are running
 		// inside a pod. Try to connect to the API server through its
 		// Service environment variables, using the default Service
 		// Account Token.
-		var err error
-		if restConfig, err = rest.InClusterConfig(); err != nil {
-			return nil, err
-		}
-	} else {
-		var err error
-		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: config.Kubeconfig},
-			&clientcmd.ConfigOverrides{
-				AuthInfo: clientcmdapi.AuthInfo{
-					ClientCertificate: config.ClientCertificate,
-					ClientKey:         config.ClientKey,
-					Token:             config.Token,
-					Username:          config.Username,
-					Password:          config.Password,
-				},
-				ClusterInfo: clientcmdapi.Cluster{
-					Server:                config.Server,
-					InsecureSkipTLSVerify: config.Insecure,
-					CertificateAuthority:  config.CertificateAuthority,
-				},
-				Context: clientcmdapi.Context{
-					Cluster:  config.Cluster,
-					AuthInfo: config.User,
-				},
-				CurrentContext: config.Context,
+		return rest.InClusterConfig()
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: config.Kubeconfig},
+		&clientcmd.ConfigOverrides{
+			AuthInfo: clientcmdapi.AuthInfo{
+				ClientCertificate: config.ClientCertificate,
+				ClientKey:         config.ClientKey,
+				Token:             config.Token,
+				Username:          config.Username,
+				Password:          config.Password,
 			},
-		).ClientConfig()
-		if err != nil {
-			return nil, err
-		}
+			ClusterInfo: clientcmdapi.Cluster{
+				Server:                config.Server,
+				InsecureSkipTLSVerify: config.Insecure,
+				CertificateAuthority:  config.CertificateAuthority,
+			},
+			Context: clientcmdapi.Context{
+				Cluster:  config.Cluster,
+				AuthInfo: config.User,
+			},
+			CurrentContext: config.Context,
+		},
+	).ClientConfig()
+}
+
+// NewClient returns a usable Client. Don't forget to Stop it.
+func NewClient(config ClientConfig) (Client, error) {
+	restConfig, err := newRestConfig(config)
+	if err != nil {
+		return nil, err
 	}
 	log.Infof("kubernetes: targeting api server %s", restConfig.Host)
 
@@ -184,13 +244,29 @@ func NewClient(config ClientConfig) (Client, error) {
 		return nil, err
 	}
 
+	customResources, err := ParseCustomResources(config.CustomResources)
+	if err != nil {
+		return nil, err
+	}
+	customResourceFields, err := ParseCustomResourceFields(config.CustomResourceFields)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	result := &client{
-		quit:           make(chan struct{}),
-		client:         c,
-		snapshotClient: sc,
+		quit:               make(chan struct{}),
+		client:             c,
+		snapshotClient:     sc,
+		dynamicClient:      dc,
+		completedJobMaxAge: config.CompletedJobMaxAge,
 	}
 
-	result.podStore = NewEventStore(result.triggerPodWatches, cache.MetaNamespaceKeyFunc)
+	result.podStore = NewTransformStore(NewEventStore(result.triggerPodWatches, cache.MetaNamespaceKeyFunc), stripPod)
 	result.runReflectorUntil("pods", result.podStore)
 
 	result.serviceStore = result.setupStore("services")
@@ -201,12 +277,38 @@ func NewClient(config ClientConfig) (Client, error) {
 	result.jobStore = result.setupStore("jobs")
 	result.statefulSetStore = result.setupStore("statefulsets")
 	result.cronJobStore = result.setupStore("cronjobs")
-	//result.persistentVolumeStore = result.setupStore("persistentvolumes")
-	//result.persistentVolumeClaimStore = result.setupStore("persistentvolumeclaims")
-	//result.storageClassStore = result.setupStore("storageclasses")
+	result.ingressStore = NewTransformStore(cache.NewStore(cache.MetaNamespaceKeyFunc), ingressFromV1)
+	result.runPreferredReflector("ingresses", ingressesV1, result.ingressStore)
+	result.networkPolicyStore = NewEventStore(result.triggerNetworkPolicyWatches, cache.MetaNamespaceKeyFunc)
+	result.runReflectorUntil("networkpolicies", result.networkPolicyStore)
+	result.hpaStore = NewTransformStore(cache.NewStore(cache.MetaNamespaceKeyFunc), hpaFromV2)
+	result.runPreferredReflector("horizontalpodautoscalers", hpasV2, result.hpaStore)
+	result.resourceQuotaStore = result.setupStore("resourcequotas")
+	result.limitRangeStore = result.setupStore("limitranges")
+	result.eventStore = newBoundedEventStore(config.EventMaxAge, maxStoredEvents)
+	result.runReflectorUntil("events", NewTransformStore(result.eventStore, stripEvent))
+	result.serviceAccountStore = result.setupStore("serviceaccounts")
+	result.roleBindingStore = newInvalidatingStore(result.invalidateClusterAdmins, cache.MetaNamespaceKeyFunc)
+	result.runReflectorUntil("rolebindings", result.roleBindingStore)
+	result.clusterRoleBindingStore = newInvalidatingStore(result.invalidateClusterAdmins, cache.MetaNamespaceKeyFunc)
+	result.runReflectorUntil("clusterrolebindings", result.clusterRoleBindingStore)
+	result.persistentVolumeStore = result.setupStore("persistentvolumes")
+	result.persistentVolumeClaimStore = result.setupStore("persistentvolumeclaims")
+	result.storageClassStore = result.setupStore("storageclasses")
 	//result.volumeSnapshotStore = result.setupStore("volumesnapshots")
 	//result.volumeSnapshotDataStore = result.setupStore("volumesnapshotdatas")
 
+	if len(customResources) > 0 {
+		// Replica sets are only needed to find the custom resources which
+		// own pods through deployments.
+		result.replicaSetStore = result.setupStore("replicasets")
+		for _, resource := range customResources {
+			store := NewTransformStore(cache.NewStore(cache.MetaNamespaceKeyFunc), customResourceTransform(resource, customResourceFields))
+			result.runCustomResourceReflector(resource, store)
+			result.customResourceStores = append(result.customResourceStores, store)
+		}
+	}
+
 	return result, nil
 }
 
@@ -279,6 +381,9 @@ func (c *client) isResourceSupported(groupVersion schema.GroupVersion, resource
 
 func (c *client) setupStore(resource string) cache.Store {
 	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	if transform, ok := transforms[resource]; ok {
+		store = NewTransformStore(store, transform)
+	}
 	c.runReflectorUntil(resource, store)
 	return store
 }
@@ -307,12 +412,32 @@ func (c *client) clientAndType(resource string) (rest.Interface, interface{}, er
 		return c.client.BatchV1().RESTClient(), &apibatchv1.Job{}, nil
 	case "statefulsets":
 		return c.client.AppsV1().RESTClient(), &apiappsv1.StatefulSet{}, nil
+	case "replicasets":
+		return c.client.AppsV1().RESTClient(), &apiappsv1.ReplicaSet{}, nil
 	case "volumesnapshots":
 		return c.snapshotClient.VolumesnapshotV1().RESTClient(), &snapshotv1.VolumeSnapshot{}, nil
 	case "volumesnapshotdatas":
 		return c.snapshotClient.VolumesnapshotV1().RESTClient(), &snapshotv1.VolumeSnapshotData{}, nil
 	case "cronjobs":
 		return c.client.BatchV1beta1().RESTClient(), &apibatchv1beta1.CronJob{}, nil
+	case "ingresses":
+		return c.client.ExtensionsV1beta1().RESTClient(), &apiextensionsv1beta1.Ingress{}, nil
+	case "networkpolicies":
+		return c.client.NetworkingV1().RESTClient(), &networkingv1.NetworkPolicy{}, nil
+	case "resourcequotas":
+		return c.client.CoreV1().RESTClient(), &apiv1.ResourceQuota{}, nil
+	case "limitranges":
+		return c.client.CoreV1().RESTClient(), &apiv1.LimitRange{}, nil
+	case "serviceaccounts":
+		return c.client.CoreV1().RESTClient(), &apiv1.ServiceAccount{}, nil
+	case "rolebindings":
+		return c.client.RbacV1().RESTClient(), &rbacv1.RoleBinding{}, nil
+	case "clusterrolebindings":
+		return c.client.RbacV1().RESTClient(), &rbacv1.ClusterRoleBinding{}, nil
+	case "events":
+		return c.client.CoreV1().RESTClient(), &apiv1.Event{}, nil
+	case "horizontalpodautoscalers":
+		return c.client.AutoscalingV2beta2().RESTClient(), &autoscalingv2beta2.HorizontalPodAutoscaler{}, nil
 	}
 	return nil, nil, fmt.Errorf("Invalid resource: %v", resource)
 }
@@ -335,7 +460,7 @@ func (c *client) runReflectorUntil(resource string, store cache.Store) {
 				log.Infof("%v are not supported by this Kubernetes version", resource)
 				return true, nil
 			}
-			lw := cache.NewListWatchFromClient(kclient, resource, metav1.NamespaceAll, fields.Everything())
+			lw := cache.NewListWatchFromClient(kclient, resource, metav1.NamespaceAll, fieldSelector(resource))
 			r = cache.NewReflector(lw, itemType, store, 0)
 		}
 
@@ -352,6 +477,95 @@ func (c *client) runReflectorUntil(resource string, store cache.Store) {
 	go bo.Start()
 }
 
+// runCustomResourceReflector lists and watches the objects of a custom
+// resource for as long as the API server serves it.  It keeps checking
+// whether it does, so custom resources can be defined after the probe
+// starts, and forgets the objects of those which are deleted.
+func (c *client) runCustomResourceReflector(resource schema.GroupVersionResource, store cache.Store) {
+	lw := c.dynamicListWatch(resource)
+	listAndWatch := func() (bool, error) {
+		select {
+		case <-c.quit:
+			return true, nil
+		default:
+		}
+		ok, err := c.isResourceSupported(resource.GroupVersion(), resource.Resource)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			store.Replace(nil, "")
+			return false, fmt.Errorf("%s is not served by the API server", resourceString(resource))
+		}
+		r := cache.NewReflector(lw, &unstructured.Unstructured{}, store, 0)
+		return false, r.ListAndWatch(c.quit)
+	}
+	bo := backoff.New(listAndWatch, fmt.Sprintf("Kubernetes reflector (%s)", resourceString(resource)))
+	bo.SetMaxBackoff(5 * time.Minute)
+	go bo.Start()
+}
+
+// runPreferredReflector lists and watches a resource from preferred, a newer
+// version of it whose types aren't vendored, read as unstructured objects
+// for the store to convert.  It falls back to the version of clientAndType
+// on API servers which don't serve preferred, checking again whenever it
+// lists them.
+func (c *client) runPreferredReflector(resource string, preferred schema.GroupVersionResource, store cache.Store) {
+	listAndWatch := func() (bool, error) {
+		select {
+		case <-c.quit:
+			return true, nil
+		default:
+		}
+		ok, err := c.isResourceSupported(preferred.GroupVersion(), preferred.Resource)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			r := cache.NewReflector(c.dynamicListWatch(preferred), &unstructured.Unstructured{}, store, 0)
+			return false, r.ListAndWatch(c.quit)
+		}
+		kclient, itemType, err := c.clientAndType(resource)
+		if err != nil {
+			return false, err
+		}
+		if ok, err = c.isResourceSupported(kclient.APIVersion(), resource); err != nil {
+			return false, err
+		} else if !ok {
+			log.Infof("%v are not supported by this Kubernetes version", resource)
+			return true, nil
+		}
+		lw := cache.NewListWatchFromClient(kclient, resource, metav1.NamespaceAll, fieldSelector(resource))
+		return false, cache.NewReflector(lw, itemType, store, 0).ListAndWatch(c.quit)
+	}
+	bo := backoff.New(listAndWatch, fmt.Sprintf("Kubernetes reflector (%s)", resource))
+	bo.SetMaxBackoff(5 * time.Minute)
+	go bo.Start()
+}
+
+// dynamicListWatch lists and watches the objects of a resource with the
+// dynamic client.
+func (c *client) dynamicListWatch(resource schema.GroupVersionResource) *cache.ListWatch {
+	client := c.dynamicClient.Resource(resource)
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(options)
+		},
+	}
+}
+
+// fieldSelector restricts what we list and watch of a resource.  Of events,
+// only warnings are of interest, and there are a lot of the others.
+func fieldSelector(resource string) fields.Selector {
+	if resource == "events" {
+		return fields.OneTermEqualSelector("type", apiv1.EventTypeWarning)
+	}
+	return fields.Everything()
+}
+
 func (c *client) WatchPods(f func(Event, Pod)) {
 	c.podWatchesMutex.Lock()
 	defer c.podWatchesMutex.Unlock()
@@ -366,6 +580,20 @@ func (c *client) triggerPodWatches(e Event, pod interface{}) {
 	}
 }
 
+func (c *client) WatchNetworkPolicies(f func(Event, NetworkPolicy)) {
+	c.networkPolicyWatchesMutex.Lock()
+	defer c.networkPolicyWatchesMutex.Unlock()
+	c.networkPolicyWatches = append(c.networkPolicyWatches, f)
+}
+
+func (c *client) triggerNetworkPolicyWatches(e Event, policy interface{}) {
+	c.networkPolicyWatchesMutex.Lock()
+	defer c.networkPolicyWatchesMutex.Unlock()
+	for _, watch := range c.networkPolicyWatches {
+		watch(e, NewNetworkPolicy(policy.(*networkingv1.NetworkPolicy)))
+	}
+}
+
 func (c *client) WalkPods(f func(Pod) error) error {
 	for _, m := range c.podStore.List() {
 		pod := m.(*apiv1.Pod)
@@ -426,6 +654,160 @@ func (c *client) WalkServices(f func(Service) error) error {
 	return nil
 }
 
+func (c *client) WalkIngresses(f func(Ingress) error) error {
+	for _, m := range c.ingressStore.List() {
+		// Those of networking.k8s.io/v1 which couldn't be converted
+		i, ok := m.(*apiextensionsv1beta1.Ingress)
+		if !ok {
+			continue
+		}
+		if err := f(NewIngress(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *client) WalkNetworkPolicies(f func(NetworkPolicy) error) error {
+	for _, m := range c.networkPolicyStore.List() {
+		p := m.(*networkingv1.NetworkPolicy)
+		if err := f(NewNetworkPolicy(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkHorizontalPodAutoscalers calls f for each horizontal pod autoscaler
+func (c *client) WalkHorizontalPodAutoscalers(f func(HorizontalPodAutoscaler) error) error {
+	for _, m := range c.hpaStore.List() {
+		// Those of autoscaling/v2 which couldn't be converted
+		h, ok := m.(*autoscalingv2beta2.HorizontalPodAutoscaler)
+		if !ok {
+			continue
+		}
+		if err := f(NewHorizontalPodAutoscaler(h)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkResourceQuotas calls f for each resource quota
+func (c *client) WalkResourceQuotas(f func(*apiv1.ResourceQuota) error) error {
+	for _, m := range c.resourceQuotaStore.List() {
+		if err := f(m.(*apiv1.ResourceQuota)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkLimitRanges calls f for each limit range
+func (c *client) WalkLimitRanges(f func(*apiv1.LimitRange) error) error {
+	for _, m := range c.limitRangeStore.List() {
+		if err := f(m.(*apiv1.LimitRange)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkEvents calls f for each of the latest warning events which happened
+// less than EventMaxAge ago
+func (c *client) WalkEvents(f func(*apiv1.Event) error) error {
+	for _, e := range c.eventStore.Events(mtime.Now()) {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkServiceAccounts calls f for each service account
+func (c *client) WalkServiceAccounts(f func(*apiv1.ServiceAccount) error) error {
+	for _, m := range c.serviceAccountStore.List() {
+		if err := f(m.(*apiv1.ServiceAccount)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateClusterAdmins is called after the role bindings change, as a
+// lookup before the change is in the stores would cache what they held.
+func (c *client) invalidateClusterAdmins() {
+	c.clusterAdminsMutex.Lock()
+	defer c.clusterAdminsMutex.Unlock()
+	c.clusterAdmins = nil
+}
+
+// IsClusterAdmin says whether a service account is bound to the
+// cluster-admin role.
+func (c *client) IsClusterAdmin(namespace, serviceAccount string) bool {
+	c.clusterAdminsMutex.Lock()
+	defer c.clusterAdminsMutex.Unlock()
+	if c.clusterAdmins == nil {
+		roleBindings := []*rbacv1.RoleBinding{}
+		for _, m := range c.roleBindingStore.List() {
+			roleBindings = append(roleBindings, m.(*rbacv1.RoleBinding))
+		}
+		clusterRoleBindings := []*rbacv1.ClusterRoleBinding{}
+		for _, m := range c.clusterRoleBindingStore.List() {
+			clusterRoleBindings = append(clusterRoleBindings, m.(*rbacv1.ClusterRoleBinding))
+		}
+		admins := makeClusterAdmins(roleBindings, clusterRoleBindings)
+		c.clusterAdmins = &admins
+	}
+	return c.clusterAdmins.has(namespace, serviceAccount)
+}
+
+// WalkCustomResources calls f for each object of the custom resources
+// being watched
+func (c *client) WalkCustomResources(f func(CustomResource) error) error {
+	for _, store := range c.customResourceStores {
+		for _, m := range store.List() {
+			if err := f(m.(CustomResource)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkOwnerReferences calls f for each object which may be owned, directly
+// or not, by a custom resource, with its owners
+func (c *client) WalkOwnerReferences(f func(uid string, owners []metav1.OwnerReference) error) error {
+	if len(c.customResourceStores) == 0 {
+		return nil
+	}
+	stores := append([]cache.Store{
+		c.podStore,
+		c.replicaSetStore,
+		c.deploymentStore,
+		c.daemonSetStore,
+		c.statefulSetStore,
+		c.jobStore,
+	}, c.customResourceStores...)
+	for _, store := range stores {
+		if store == nil {
+			continue
+		}
+		for _, m := range store.List() {
+			o, err := apimeta.Accessor(m)
+			if err != nil {
+				return err
+			}
+			if owners := o.GetOwnerReferences(); len(owners) > 0 {
+				if err := f(string(o.GetUID()), owners); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (c *client) WalkDeployments(f func(Deployment) error) error {
 	if c.deploymentStore == nil {
 		return nil
@@ -474,8 +856,7 @@ func (c *client) WalkCronJobs(f func(CronJob) error) error {
 	}
 	// We index jobs by id to make lookup for each cronjob more efficient
 	jobs := map[types.UID]*apibatchv1.Job{}
-	for _, m := range c.jobStore.List() {
-		j := m.(*apibatchv1.Job)
+	for _, j := range c.jobs() {
 		jobs[j.UID] = j
 	}
 	for _, m := range c.cronJobStore.List() {
@@ -517,9 +898,23 @@ func (c *client) WalkVolumeSnapshotData(f func(VolumeSnapshotData) error) error
 	return nil
 }
 
-func (c *client) WalkJobs(f func(Job) error) error {
+// jobs returns the jobs to report, leaving out those which finished more
+// than completedJobMaxAge ago.
+func (c *client) jobs() []*apibatchv1.Job {
+	now := mtime.Now()
+	jobs := []*apibatchv1.Job{}
 	for _, m := range c.jobStore.List() {
-		job := m.(*apibatchv1.Job)
+		j := m.(*apibatchv1.Job)
+		if jobExpired(j, c.completedJobMaxAge, now) {
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+func (c *client) WalkJobs(f func(Job) error) error {
+	for _, job := range c.jobs() {
 		if err := f(NewJob(job)); err != nil {
 			return err
 		}
@@ -610,17 +1005,15 @@ func (c *client) CreateVolumeSnapshot(namespaceID, persistentVolumeClaimID, capa
 	return nil
 }
 
-func (c *client) GetLogs(namespaceID, podID string, containerNames []string) (io.ReadCloser, error) {
+// GetLogs streams the logs of the given containers of a pod.  Logs of
+// more than one container are interleaved, each line prefixed with the name
+// of the container it comes from.
+func (c *client) GetLogs(namespaceID, podID string, containerNames []string, options apiv1.PodLogOptions) (io.ReadCloser, error) {
 	readClosersWithLabel := map[io.ReadCloser]string{}
 	for _, container := range containerNames {
-		req := c.client.CoreV1().Pods(namespaceID).GetLogs(
-			podID,
-			&apiv1.PodLogOptions{
-				Follow:     true,
-				Timestamps: true,
-				Container:  container,
-			},
-		)
+		containerOptions := options
+		containerOptions.Container = container
+		req := c.client.CoreV1().Pods(namespaceID).GetLogs(podID, &containerOptions)
 		readCloser, err := req.Stream()
 		if err != nil {
 			for rc := range readClosersWithLabel {
@@ -630,10 +1023,33 @@ func (c *client) GetLogs(namespaceID, podID string, containerNames []string) (io
 		}
 		readClosersWithLabel[readCloser] = container
 	}
+	if len(readClosersWithLabel) == 1 {
+		for readCloser := range readClosersWithLabel {
+			return readCloser, nil
+		}
+	}
 
 	return NewLogReadCloser(readClosersWithLabel), nil
 }
 
+// CanI asks the API server whether the probe's service account may perform
+// verb on resource (and subresource, if any) in all namespaces.
+func (c *client) CanI(verb, resource, subresource string) (bool, error) {
+	review, err := c.client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        verb,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
 //func (c *client) Describe(namespaceID, resourceID string, groupKind schema.GroupKind, restMapping apimeta.RESTMapping) (io.ReadCloser, error) {
 //	readClosersWithLabel := map[io.ReadCloser]string{}
 //	restConfig, err := rest.InClusterConfig()
@@ -660,8 +1076,16 @@ func (c *client) GetLogs(namespaceID, podID string, containerNames []string) (io
 //	return NewLogReadCloser(readClosersWithLabel), nil
 //}
 
-func (c *client) DeletePod(namespaceID, podID string) error {
-	return c.client.CoreV1().Pods(namespaceID).Delete(podID, &metav1.DeleteOptions{})
+func (c *client) DeletePod(namespaceID, podID string, gracePeriodSeconds *int64) error {
+	return c.client.CoreV1().Pods(namespaceID).Delete(podID, &metav1.DeleteOptions{
+		GracePeriodSeconds: gracePeriodSeconds,
+	})
+}
+
+func (c *client) CordonNode(name string, cordon bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, cordon)
+	_, err := c.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, []byte(patch))
+	return err
 }
 
 func (c *client) DeleteVolumeSnapshot(namespaceID, volumeSnapshotID string) error {
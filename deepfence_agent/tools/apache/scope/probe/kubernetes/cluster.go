@@ -0,0 +1,173 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/backoff"
+
+	"github.com/weaveworks/scope/probe"
+	"github.com/weaveworks/scope/report"
+)
+
+// clusterTopologies are the topologies of Kubernetes objects, whose node IDs
+// are only unique within a cluster.
+var clusterTopologies = map[string]struct{}{
+	report.Pod:                   {},
+	report.Service:               {},
+	report.Deployment:            {},
+	report.DaemonSet:             {},
+	report.StatefulSet:           {},
+	report.CronJob:               {},
+	report.Job:                   {},
+	report.Namespace:             {},
+	report.PersistentVolume:      {},
+	report.PersistentVolumeClaim: {},
+	report.StorageClass:          {},
+	report.Ingress:               {},
+	report.CustomResource:        {},
+	report.Host:                  {},
+}
+
+// NewClusterReporter makes a Reporter for one of several clusters a probe
+// watches.  The IDs of its nodes are prefixed with the cluster name so they
+// can't collide with those of the other clusters.  It offers no controls,
+// which couldn't be told apart from those of the other clusters, and sends
+// no shortcut reports.
+func NewClusterReporter(client Client, probeID string, hostID string, probe *probe.Probe, cluster string, pendingThreshold time.Duration) *Reporter {
+	reporter := &Reporter{
+		client:           client,
+		probeID:          probeID,
+		probe:            probe,
+		hostID:           hostID,
+		cluster:          cluster,
+		pendingThreshold: pendingThreshold,
+	}
+	reporter.k8sClusterTopology, _ = reporter.kubernetesClusterTopology()
+	return reporter
+}
+
+// clusterClientBackoff is how long after failing to start the client of a
+// cluster it is first tried again.
+var clusterClientBackoff = 10 * time.Second
+
+// ClusterReporter reports one of several clusters a probe watches, once
+// the client of the cluster has started.  Starting it is retried with
+// backoff, so a cluster the probe can't connect to when it starts is
+// reported once it can.
+type ClusterReporter struct {
+	mtx      sync.Mutex
+	reporter *Reporter
+	client   Client
+	backoff  backoff.Interface
+}
+
+// StartClusterReporter starts a ClusterReporter for the cluster of the
+// context of config.
+func StartClusterReporter(config ClientConfig, probeID string, hostID string, probe *probe.Probe, pendingThreshold time.Duration) *ClusterReporter {
+	return startClusterReporter(NewClient, config, probeID, hostID, probe, pendingThreshold)
+}
+
+func startClusterReporter(newClient func(ClientConfig) (Client, error), config ClientConfig, probeID string, hostID string, probe *probe.Probe, pendingThreshold time.Duration) *ClusterReporter {
+	r := &ClusterReporter{}
+	r.backoff = backoff.New(func() (bool, error) {
+		client, err := newClient(config)
+		if err != nil {
+			return false, err
+		}
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		r.client = client
+		r.reporter = NewClusterReporter(client, probeID, hostID, probe, config.Context, pendingThreshold)
+		return true, nil
+	}, fmt.Sprintf("starting Kubernetes client for context %s", config.Context))
+	r.backoff.SetInitialBackoff(clusterClientBackoff)
+	r.backoff.SetMaxBackoff(5 * time.Minute)
+	go r.backoff.Start()
+	return r
+}
+
+// Name of this reporter, for metrics gathering
+func (*ClusterReporter) Name() string { return "K8s" }
+
+// Report implements Reporter, with an empty report until the client of the
+// cluster has started.
+func (r *ClusterReporter) Report() (report.Report, error) {
+	r.mtx.Lock()
+	reporter := r.reporter
+	r.mtx.Unlock()
+	if reporter == nil {
+		return report.MakeReport(), nil
+	}
+	return reporter.Report()
+}
+
+// Stop stops retrying to start the client, and the client once started.
+func (r *ClusterReporter) Stop() {
+	r.backoff.Stop()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.reporter != nil {
+		r.reporter.Stop()
+		r.client.Stop()
+	}
+}
+
+// ClusterNodeID returns the ID a node of the given cluster is reported
+// under by a cluster reporter.
+func ClusterNodeID(cluster, id string) string {
+	return cluster + ":" + id
+}
+
+// withClusterPrefix makes the nodes of a report unique to the cluster, and
+// stamps them with its name.
+func withClusterPrefix(rpt report.Report, cluster string) report.Report {
+	clusterNodeID := report.MakeKubernetesClusterNodeID(cluster)
+	latests := map[string]string{
+		k8sClusterId:   cluster,
+		k8sClusterName: cluster,
+	}
+	rpt.WalkNamedTopologies(func(name string, t *report.Topology) {
+		if name == report.KubernetesCluster {
+			nodes := report.Nodes{}
+			for _, n := range t.Nodes {
+				n.ID = clusterNodeID
+				nodes[clusterNodeID] = n.WithLatests(latests)
+			}
+			t.Nodes = nodes
+			return
+		}
+		if _, ok := clusterTopologies[name]; !ok {
+			return
+		}
+		nodes := make(report.Nodes, len(t.Nodes))
+		for id, n := range t.Nodes {
+			n.ID = ClusterNodeID(cluster, id)
+			n.Parents = clusterParents(n.Parents, cluster, clusterNodeID)
+			nodes[n.ID] = n.WithLatests(latests)
+		}
+		t.Nodes = nodes
+	})
+	return rpt
+}
+
+func clusterParents(parents report.Sets, cluster, clusterNodeID string) report.Sets {
+	result := report.MakeSets()
+	for _, topology := range parents.Keys() {
+		ids, _ := parents.Lookup(topology)
+		switch _, ok := clusterTopologies[topology]; {
+		case topology == report.KubernetesCluster:
+			result = result.AddString(topology, clusterNodeID)
+		case ok:
+			prefixed := make([]string, 0, len(ids))
+			for _, id := range ids {
+				prefixed = append(prefixed, ClusterNodeID(cluster, id))
+			}
+			result = result.Add(topology, report.MakeStringSet(prefixed...))
+		default:
+			result = result.Add(topology, ids)
+		}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/test"
+)
+
+type stoppableClient struct {
+	Client
+	mtx     sync.Mutex
+	stopped bool
+}
+
+func (c *stoppableClient) Stop() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.stopped = true
+}
+
+func TestClusterReporterRetries(t *testing.T) {
+	defer func(d time.Duration) { clusterClientBackoff = d }(clusterClientBackoff)
+	clusterClientBackoff = time.Millisecond
+
+	var (
+		mtx      sync.Mutex
+		attempts int
+		client   = &stoppableClient{}
+	)
+	newClient := func(ClientConfig) (Client, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("cluster unreachable")
+		}
+		return client, nil
+	}
+	r := startClusterReporter(newClient, ClientConfig{Context: "staging"}, "probe-id", "host-id", nil, DefaultPendingThreshold)
+
+	test.Poll(t, time.Second, true, func() interface{} {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		return r.reporter != nil
+	})
+	r.mtx.Lock()
+	if r.reporter.cluster != "staging" {
+		t.Errorf("Expected the reporter of cluster staging, got %q", r.reporter.cluster)
+	}
+	r.mtx.Unlock()
+
+	r.Stop()
+	if !client.stopped {
+		t.Error("Expected the client to be stopped")
+	}
+}
+
+func TestClusterReporterUnreachable(t *testing.T) {
+	newClient := func(ClientConfig) (Client, error) {
+		return nil, fmt.Errorf("cluster unreachable")
+	}
+	r := startClusterReporter(newClient, ClientConfig{Context: "staging"}, "probe-id", "host-id", nil, DefaultPendingThreshold)
+	rpt, err := r.Report()
+	if err != nil || len(rpt.Pod.Nodes) != 0 {
+		t.Errorf("Expected an empty report, got %v, %v", rpt, err)
+	}
+	r.Stop()
+}
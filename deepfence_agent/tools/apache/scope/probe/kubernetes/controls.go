@@ -0,0 +1,271 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+// Control IDs used by the kubernetes integration.
+const (
+	GetLogs      = "kubernetes_get_logs"
+	DeletePod    = "kubernetes_delete_pod"
+	CordonNode   = "kubernetes_cordon"
+	UncordonNode = "kubernetes_uncordon"
+
+	// GracePeriodArg is the control argument giving the number of seconds a
+	// deleted pod has to terminate gracefully.
+	GracePeriodArg = "grace_period_seconds"
+
+	// Control arguments of GetLogs.  Without ContainerArg the logs of all
+	// the pod's containers are streamed.
+	ContainerArg    = "container"
+	TailLinesArg    = "tail_lines"
+	SinceSecondsArg = "since_seconds"
+	FollowArg       = "follow"
+
+	// logsPermissionRecheck is how often we ask the API server whether we
+	// may read pod logs.
+	logsPermissionRecheck = 5 * time.Minute
+)
+
+var (
+	getLogsControl = report.Control{
+		ID:    GetLogs,
+		Human: "Get logs",
+		Icon:  "fa fa-desktop",
+		Rank:  0,
+	}
+	deletePodControl = report.Control{
+		ID:           DeletePod,
+		Human:        "Delete",
+		Icon:         "far fa-trash-alt",
+		Confirmation: "Are you sure you want to delete this pod?",
+		Rank:         3,
+		Destructive:  true,
+	}
+	cordonControl = report.Control{
+		ID:           CordonNode,
+		Human:        "Cordon",
+		Icon:         "fa fa-ban",
+		Confirmation: "Are you sure you want to mark this node as unschedulable?",
+		Rank:         4,
+		Destructive:  true,
+	}
+	uncordonControl = report.Control{
+		ID:    UncordonNode,
+		Human: "Uncordon",
+		Icon:  "fa fa-check-circle",
+		Rank:  4,
+	}
+)
+
+// auditControl logs every control invocation, along with whatever the app
+// told us about who requested it.
+func auditControl(req xfer.Request, err error) {
+	fields := logrus.Fields{
+		"control": req.Control,
+		"node":    req.NodeID,
+		"app":     req.AppID,
+	}
+	for k, v := range req.ControlArgs {
+		fields["arg_"+k] = v
+	}
+	if err != nil {
+		log.WithFields(fields).Warnf("kubernetes: control failed: %v", err)
+		return
+	}
+	log.WithFields(fields).Info("kubernetes: control invoked")
+}
+
+// controlResponse turns the outcome of a call to the API server into a
+// control response.  Requests denied by RBAC get an explanation, as
+// they're down to the probe's service account rather than anything the
+// user did.
+func controlResponse(req xfer.Request, err error, verb, resource string) xfer.Response {
+	auditControl(req, err)
+	if err == nil {
+		return xfer.Response{}
+	}
+	if apierrors.IsForbidden(err) {
+		return xfer.ResponseErrorf("not allowed: the probe's service account needs RBAC permission to %s %s (%v)", verb, resource, err)
+	}
+	return xfer.ResponseError(err)
+}
+
+// findPod returns the pod with the given node ID, or nil.
+func (r *Reporter) findPod(nodeID string) (Pod, bool) {
+	uid, ok := report.ParsePodNodeID(nodeID)
+	if !ok {
+		return nil, false
+	}
+	var pod Pod
+	r.client.WalkPods(func(p Pod) error {
+		if p.UID() == uid {
+			pod = p
+		}
+		return nil
+	})
+	return pod, true
+}
+
+// canGetLogs reports whether the probe's service account may read pod logs,
+// asking the API server at most every logsPermissionRecheck.
+func (r *Reporter) canGetLogs() bool {
+	if r.handlerRegistry == nil {
+		return false
+	}
+	now := mtime.Now()
+	if now.Sub(r.logsCheckedAt) < logsPermissionRecheck {
+		return r.logsAllowed
+	}
+	allowed, err := r.client.CanI("get", "pods", "log")
+	if err != nil {
+		log.Warnf("kubernetes: cannot check permission to read pod logs: %v", err)
+		return r.logsAllowed
+	}
+	r.logsAllowed, r.logsCheckedAt = allowed, now
+	return allowed
+}
+
+func logOptions(args map[string]string) (apiv1.PodLogOptions, error) {
+	options := apiv1.PodLogOptions{Timestamps: true}
+	if arg, ok := args[TailLinesArg]; ok {
+		lines, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || lines < 0 {
+			return options, fmt.Errorf("Invalid %s: %q", TailLinesArg, arg)
+		}
+		options.TailLines = &lines
+	}
+	if arg, ok := args[SinceSecondsArg]; ok {
+		seconds, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || seconds <= 0 {
+			return options, fmt.Errorf("Invalid %s: %q", SinceSecondsArg, arg)
+		}
+		options.SinceSeconds = &seconds
+	}
+	options.Follow = true
+	if arg, ok := args[FollowArg]; ok {
+		follow, err := strconv.ParseBool(arg)
+		if err != nil {
+			return options, fmt.Errorf("Invalid %s: %q", FollowArg, arg)
+		}
+		options.Follow = follow
+	}
+	return options, nil
+}
+
+// getLogs streams the logs of a pod through a pipe, until the pipe is
+// closed or, when not following, the logs run out.
+func (r *Reporter) getLogs(req xfer.Request) xfer.Response {
+	pod, ok := r.findPod(req.NodeID)
+	if !ok {
+		return xfer.ResponseErrorf("Invalid ID: %s", req.NodeID)
+	}
+	if pod == nil {
+		return xfer.ResponseErrorf("Pod not found: %s", req.NodeID)
+	}
+	options, err := logOptions(req.ControlArgs)
+	if err != nil {
+		return xfer.ResponseError(err)
+	}
+	containerNames := pod.ContainerNames()
+	if container, ok := req.ControlArgs[ContainerArg]; ok && container != "" {
+		found := false
+		for _, name := range containerNames {
+			found = found || name == container
+		}
+		if !found {
+			return xfer.ResponseErrorf("Pod %s has no container %q", pod.Name(), container)
+		}
+		containerNames = []string{container}
+	}
+
+	readCloser, err := r.client.GetLogs(pod.Namespace(), pod.Name(), containerNames, options)
+	if err != nil {
+		return controlResponse(req, err, "get", "pods/log")
+	}
+	readWriter := struct {
+		io.Reader
+		io.Writer
+	}{
+		readCloser,
+		ioutil.Discard,
+	}
+	id, pipe, err := controls.NewPipeFromEnds(nil, readWriter, r.pipes, req.AppID)
+	if err != nil {
+		readCloser.Close()
+		return xfer.ResponseError(err)
+	}
+	pipe.OnClose(func() {
+		readCloser.Close()
+	})
+	auditControl(req, nil)
+	return xfer.Response{
+		Pipe: id,
+	}
+}
+
+func (r *Reporter) deletePod(req xfer.Request) xfer.Response {
+	var gracePeriod *int64
+	if arg, ok := req.ControlArgs[GracePeriodArg]; ok {
+		seconds, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || seconds < 0 {
+			return xfer.ResponseErrorf("Invalid %s: %q", GracePeriodArg, arg)
+		}
+		gracePeriod = &seconds
+	}
+	pod, ok := r.findPod(req.NodeID)
+	if !ok {
+		return xfer.ResponseErrorf("Invalid ID: %s", req.NodeID)
+	}
+	if pod == nil {
+		return xfer.ResponseErrorf("Pod not found: %s", req.NodeID)
+	}
+	err := r.client.DeletePod(pod.Namespace(), pod.Name(), gracePeriod)
+	return controlResponse(req, err, "delete", "pods")
+}
+
+func (r *Reporter) cordonNode(cordon bool) xfer.ControlHandlerFunc {
+	return func(req xfer.Request) xfer.Response {
+		name, ok := report.ParseHostNodeID(req.NodeID)
+		if !ok {
+			return xfer.ResponseErrorf("Invalid ID: %s", req.NodeID)
+		}
+		err := r.client.CordonNode(name, cordon)
+		return controlResponse(req, err, "patch", "nodes")
+	}
+}
+
+// registerControls registers the read-only controls, and those changing
+// the cluster if they are enabled.
+func (r *Reporter) registerControls() {
+	handlers := map[string]xfer.ControlHandlerFunc{
+		GetLogs: r.getLogs,
+	}
+	if r.controlsEnabled {
+		handlers[DeletePod] = r.deletePod
+		handlers[CordonNode] = r.cordonNode(true)
+		handlers[UncordonNode] = r.cordonNode(false)
+	}
+	r.handlerRegistry.Batch(nil, handlers)
+}
+
+func (r *Reporter) deregisterControls() {
+	if r.handlerRegistry == nil {
+		return
+	}
+	r.handlerRegistry.Batch([]string{GetLogs, DeletePod, CordonNode, UncordonNode}, nil)
+}
@@ -19,6 +19,8 @@ const (
 	Suspended     = report.KubernetesSuspended
 	LastScheduled = report.KubernetesLastScheduled
 	ActiveJobs    = report.KubernetesActiveJobs
+	SucceededJobs = report.KubernetesSucceededJobs
+	FailedJobs    = report.KubernetesFailedJobs
 )
 
 // CronJob represents a Kubernetes cron job
@@ -35,12 +37,25 @@ type cronJob struct {
 }
 
 // NewCronJob creates a new cron job. jobs should be all jobs, which will be filtered
-// for those matching this cron job.
+// for those created by this cron job.
 func NewCronJob(cj *apibatchv1beta1.CronJob, jobs map[types.UID]*batchv1.Job) CronJob {
 	myJobs := []*batchv1.Job{}
+	seen := map[types.UID]struct{}{}
 	for _, o := range cj.Status.Active {
 		if j, ok := jobs[o.UID]; ok {
 			myJobs = append(myJobs, j)
+			seen[j.UID] = struct{}{}
+		}
+	}
+	for _, j := range jobs {
+		if _, ok := seen[j.UID]; ok {
+			continue
+		}
+		for _, owner := range j.OwnerReferences {
+			if owner.UID == cj.UID {
+				myJobs = append(myJobs, j)
+				break
+			}
 		}
 	}
 	return &cronJob{
@@ -63,11 +78,23 @@ func (cj *cronJob) Selectors() ([]labels.Selector, error) {
 }
 
 func (cj *cronJob) GetNode(probeID string) report.Node {
+	active, succeeded, failed := 0, 0, 0
+	for _, j := range cj.jobs {
+		if _, finished := jobFinishedAt(j); !finished {
+			active++
+		} else if jobSucceeded(j) {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
 	latest := map[string]string{
 		NodeType:              "CronJob",
 		Schedule:              cj.Spec.Schedule,
 		Suspended:             fmt.Sprint(cj.Spec.Suspend != nil && *cj.Spec.Suspend), // nil -> false
-		ActiveJobs:            fmt.Sprint(len(cj.jobs)),
+		ActiveJobs:            fmt.Sprint(active),
+		SucceededJobs:         fmt.Sprint(succeeded),
+		FailedJobs:            fmt.Sprint(failed),
 		report.ControlProbeID: probeID,
 		k8sClusterId:          kubernetesClusterId,
 		k8sClusterName:        kubernetesClusterName,
@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	CustomResourceType = report.KubernetesCustomResource
+	CustomFieldPrefix  = report.KubernetesCustomFieldPrefix
+
+	// maxOwnerDepth bounds how far up the owners of a pod we look for
+	// custom resources, e.g. pod, replica set, deployment, custom resource.
+	maxOwnerDepth = 5
+)
+
+// CustomResourceField is a field of the objects of custom resources,
+// reported under a name.
+type CustomResourceField struct {
+	Name string
+	path *jsonpath.JSONPath
+}
+
+// ParseCustomResources parses a comma-separated list of
+// group/version/resource, e.g. "argoproj.io/v1alpha1/rollouts".
+func ParseCustomResources(s string) ([]schema.GroupVersionResource, error) {
+	resources := []schema.GroupVersionResource{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid custom resource %q, expected group/version/resource", spec)
+		}
+		resources = append(resources, schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]})
+	}
+	return resources, nil
+}
+
+// ParseCustomResourceFields parses a comma-separated list of NAME:JSONPATH,
+// like kubectl's custom columns, e.g. "Phase:.status.phase".
+func ParseCustomResourceFields(s string) ([]CustomResourceField, error) {
+	fields := []CustomResourceField{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid custom resource field %q, expected NAME:JSONPATH", spec)
+		}
+		expr := parts[1]
+		if !strings.HasPrefix(expr, "{") {
+			expr = "{" + expr + "}"
+		}
+		path := jsonpath.New(parts[0]).AllowMissingKeys(true)
+		if err := path.Parse(expr); err != nil {
+			return nil, fmt.Errorf("invalid custom resource field %q: %v", spec, err)
+		}
+		fields = append(fields, CustomResourceField{Name: parts[0], path: path})
+	}
+	return fields, nil
+}
+
+func (f CustomResourceField) value(object map[string]interface{}) string {
+	var buf bytes.Buffer
+	if err := f.path.Execute(&buf, object); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+func resourceString(resource schema.GroupVersionResource) string {
+	return resource.Group + "/" + resource.Version + "/" + resource.Resource
+}
+
+// CustomResource represents an object of a custom resource
+type CustomResource interface {
+	Meta
+	Resource() string
+	Kind() string
+	GetNode(probeID string) report.Node
+}
+
+type customResource struct {
+	Meta
+	objectMeta metav1.ObjectMeta
+	resource   string
+	kind       string
+	fields     map[string]string
+}
+
+// NewCustomResource creates a new CustomResource, keeping only the metadata
+// and given fields of the object.
+func NewCustomResource(u *unstructured.Unstructured, resource schema.GroupVersionResource, fields []CustomResourceField) CustomResource {
+	objectMeta := metav1.ObjectMeta{
+		Name:              u.GetName(),
+		Namespace:         u.GetNamespace(),
+		UID:               u.GetUID(),
+		CreationTimestamp: u.GetCreationTimestamp(),
+		Labels:            u.GetLabels(),
+		OwnerReferences:   u.GetOwnerReferences(),
+	}
+	values := map[string]string{}
+	for _, f := range fields {
+		if v := f.value(u.Object); v != "" {
+			values[f.Name] = v
+		}
+	}
+	return &customResource{
+		Meta:       meta{objectMeta},
+		objectMeta: objectMeta,
+		resource:   resourceString(resource),
+		kind:       u.GetKind(),
+		fields:     values,
+	}
+}
+
+// GetObjectMeta lets custom resources be cached like other objects.
+func (c *customResource) GetObjectMeta() metav1.Object {
+	return &c.objectMeta
+}
+
+func (c *customResource) Resource() string {
+	return c.resource
+}
+
+func (c *customResource) Kind() string {
+	return c.kind
+}
+
+func (c *customResource) GetNode(probeID string) report.Node {
+	latest := map[string]string{
+		NodeType:              c.kind,
+		CustomResourceType:    c.resource,
+		k8sClusterId:          kubernetesClusterId,
+		k8sClusterName:        kubernetesClusterName,
+		report.ControlProbeID: probeID,
+	}
+	return c.MetaNode(report.MakeCustomResourceNodeID(c.UID())).WithLatests(latest).
+		AddPrefixPropertyList(CustomFieldPrefix, c.fields).
+		WithParent(report.KubernetesCluster, kubernetesClusterNodeId).
+		WithParent(report.CloudProvider, cloudProviderNodeId)
+}
+
+// customResourceTransform turns the objects of a custom resource into
+// CustomResources before they are cached, which drops most of them.
+func customResourceTransform(resource schema.GroupVersionResource, fields []CustomResourceField) TransformFunc {
+	return func(o interface{}) interface{} {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok {
+			return o
+		}
+		return NewCustomResource(u, resource, fields)
+	}
+}
+
+// ownerGraph maps the UIDs of objects to the UIDs of their owners.
+type ownerGraph map[string][]string
+
+func (g ownerGraph) add(uid string, owners []metav1.OwnerReference) {
+	for _, owner := range owners {
+		g[uid] = append(g[uid], string(owner.UID))
+	}
+}
+
+// customResources follows the owners of an object up to the custom
+// resources among them, e.g. through the replica set and deployment of a
+// pod to the custom resource which created the deployment.
+func (g ownerGraph) customResources(uid string, resources map[string]struct{}) []string {
+	var (
+		result  []string
+		visited = map[string]bool{uid: true}
+		current = []string{uid}
+	)
+	for depth := 0; depth < maxOwnerDepth && len(current) > 0; depth++ {
+		next := []string{}
+		for _, id := range current {
+			for _, owner := range g[id] {
+				if visited[owner] {
+					continue
+				}
+				visited[owner] = true
+				if _, ok := resources[owner]; ok {
+					result = append(result, owner)
+				}
+				next = append(next, owner)
+			}
+		}
+		current = next
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func TestParseCustomResources(t *testing.T) {
+	resources, err := ParseCustomResources("argoproj.io/v1alpha1/rollouts, kafka.strimzi.io/v1beta2/kafkas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []schema.GroupVersionResource{
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"},
+		{Group: "kafka.strimzi.io", Version: "v1beta2", Resource: "kafkas"},
+	}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("Expected %v, got %v", want, resources)
+	}
+	for _, spec := range []string{"rollouts", "v1alpha1/rollouts", "argoproj.io//rollouts"} {
+		if _, err := ParseCustomResources(spec); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+	for _, spec := range []string{"Phase", ":.status.phase", "Phase:{.status.phase"} {
+		if _, err := ParseCustomResourceFields(spec); err == nil {
+			t.Errorf("Expected field %q to be invalid", spec)
+		}
+	}
+}
+
+func TestCustomResourceStore(t *testing.T) {
+	fields, _ := ParseCustomResourceFields("Phase:.status.phase,Replicas:{.status.replicas}")
+	resource := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+	store := NewTransformStore(cache.NewStore(cache.MetaNamespaceKeyFunc), customResourceTransform(resource, fields))
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "Rollout",
+		"metadata": map[string]interface{}{
+			"name":      "pong",
+			"namespace": "ping",
+			"uid":       "rollout1234",
+		},
+		"spec":   map[string]interface{}{"template": map[string]interface{}{"large": "object"}},
+		"status": map[string]interface{}{"phase": "Paused"},
+	}}
+	if err := store.Add(u); err != nil {
+		t.Fatal(err)
+	}
+	items := store.List()
+	if len(items) != 1 {
+		t.Fatalf("Expected one custom resource, got %v", items)
+	}
+	c := items[0].(*customResource)
+	if c.Name() != "pong" || c.Kind() != "Rollout" || c.Resource() != "argoproj.io/v1alpha1/rollouts" {
+		t.Errorf("Unexpected custom resource %v", c)
+	}
+	if want := map[string]string{"Phase": "Paused"}; !reflect.DeepEqual(c.fields, want) {
+		t.Errorf("Expected fields %v, got %v", want, c.fields)
+	}
+	if err := store.Delete(u); err != nil {
+		t.Fatal(err)
+	}
+	if items := store.List(); len(items) != 0 {
+		t.Errorf("Expected custom resource to be deleted, got %v", items)
+	}
+}
+
+func TestOwnerGraphCustomResources(t *testing.T) {
+	owners := ownerGraph{}
+	owner := func(uid string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{UID: types.UID(uid)}}
+	}
+	owners.add("pod", owner("replicaset"))
+	owners.add("replicaset", owner("deployment"))
+	owners.add("deployment", owner("app"))
+	owners.add("app", owner("platform"))
+	// Owner references can't be trusted not to loop
+	owners.add("platform", owner("pod"))
+	resources := map[string]struct{}{"app": {}, "platform": {}}
+
+	if have, want := owners.customResources("pod", resources), []string{"app", "platform"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	if have := owners.customResources("orphan", resources); len(have) != 0 {
+		t.Errorf("Expected no custom resources, got %v", have)
+	}
+}
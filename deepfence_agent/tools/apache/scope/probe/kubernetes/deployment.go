@@ -3,6 +3,7 @@ package kubernetes
 import (
 	"fmt"
 
+	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/report"
 
 	apiappsv1 "k8s.io/api/apps/v1"
@@ -51,6 +52,7 @@ func (d *deployment) GetNode(probeID string) report.Node {
 	if d.Spec.Replicas != nil {
 		desiredReplicas = int(*d.Spec.Replicas)
 	}
+	requests, limits := podResources(d.Spec.Template.Spec)
 	return d.MetaNode(report.MakeDeploymentNodeID(d.UID())).WithLatests(map[string]string{
 		ObservedGeneration:    fmt.Sprint(d.Status.ObservedGeneration),
 		DesiredReplicas:       fmt.Sprint(desiredReplicas),
@@ -64,6 +66,7 @@ func (d *deployment) GetNode(probeID string) report.Node {
 		k8sClusterId:          kubernetesClusterId,
 		k8sClusterName:        kubernetesClusterName,
 	}).
+		WithMetrics(resourceMetrics(requests, limits, int(d.Status.Replicas), mtime.Now())).
 		WithParent(report.KubernetesCluster, kubernetesClusterNodeId).
 		WithParent(report.CloudProvider, cloudProviderNodeId)
 	//.WithLatestActiveControls(ScaleUp, ScaleDown, Describe)
@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	WarningEventPrefix   = report.KubernetesWarningEventPrefix
+	WarningEventReason   = "kubernetes_warning_event_reason"
+	WarningEventMessage  = "kubernetes_warning_event_message"
+	WarningEventCount    = "kubernetes_warning_event_count"
+	WarningEventLastSeen = "kubernetes_warning_event_last_seen"
+
+	// maxWarningEvents bounds the number of warnings reported per node.
+	maxWarningEvents = 5
+	// maxEventMessageLength bounds the length of the messages reported.
+	maxEventMessageLength = 200
+	// maxStoredEvents bounds the number of warning events cached.
+	maxStoredEvents = 2000
+)
+
+// WarningEventTableTemplates show the recent warning events about a pod,
+// deployment or node.
+var WarningEventTableTemplates = report.TableTemplates{
+	WarningEventPrefix: {
+		ID:     WarningEventPrefix,
+		Label:  "Recent warnings",
+		Type:   report.MulticolumnTableType,
+		Prefix: WarningEventPrefix,
+		Columns: []report.Column{
+			{ID: WarningEventReason, Label: "Reason"},
+			{ID: WarningEventMessage, Label: "Message"},
+			{ID: WarningEventCount, Label: "Count", DataType: report.Number},
+			{ID: WarningEventLastSeen, Label: "Last seen", DataType: report.DateTime},
+		},
+	},
+}
+
+type warningSummary struct {
+	reason, message string
+	count           int
+	lastSeen        time.Time
+}
+
+// eventNodeID returns the ID of the node the event is about, if it's about
+// an object we report.
+func eventNodeID(ref apiv1.ObjectReference) (string, bool) {
+	switch ref.Kind {
+	case "Pod":
+		return report.MakePodNodeID(string(ref.UID)), true
+	case "Deployment":
+		return report.MakeDeploymentNodeID(string(ref.UID)), true
+	case "Node":
+		// The kubelet doesn't fill in the UID of the nodes it reports on
+		return report.MakeHostNodeID(ref.Name), true
+	}
+	return "", false
+}
+
+// eventLastSeen returns the last time the event happened, falling back on
+// the timestamps set by older and newer clients.
+func eventLastSeen(e *apiv1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func eventCount(e *apiv1.Event) int {
+	switch {
+	case e.Series != nil && e.Series.Count > 0:
+		return int(e.Series.Count)
+	case e.Count > 0:
+		return int(e.Count)
+	}
+	return 1
+}
+
+func truncateMessage(message string) string {
+	runes := []rune(message)
+	if len(runes) <= maxEventMessageLength {
+		return message
+	}
+	return string(runes[:maxEventMessageLength-1]) + "…"
+}
+
+// warningEventRows summarizes warning events by the node they are about.
+// Events with the same reason are merged, keeping the latest message, and
+// only the maxWarningEvents most recent reasons are kept per node.
+func warningEventRows(events []*apiv1.Event) map[string][]report.Row {
+	summaries := map[string]map[string]*warningSummary{}
+	for _, e := range events {
+		if e.Type != apiv1.EventTypeWarning {
+			continue
+		}
+		nodeID, ok := eventNodeID(e.InvolvedObject)
+		if !ok {
+			continue
+		}
+		if summaries[nodeID] == nil {
+			summaries[nodeID] = map[string]*warningSummary{}
+		}
+		lastSeen := eventLastSeen(e)
+		s, ok := summaries[nodeID][e.Reason]
+		if !ok {
+			s = &warningSummary{reason: e.Reason}
+			summaries[nodeID][e.Reason] = s
+		}
+		s.count += eventCount(e)
+		if !lastSeen.Before(s.lastSeen) {
+			s.lastSeen = lastSeen
+			s.message = e.Message
+		}
+	}
+
+	result := make(map[string][]report.Row, len(summaries))
+	for nodeID, byReason := range summaries {
+		sorted := make([]*warningSummary, 0, len(byReason))
+		for _, s := range byReason {
+			sorted = append(sorted, s)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if !sorted[i].lastSeen.Equal(sorted[j].lastSeen) {
+				return sorted[i].lastSeen.After(sorted[j].lastSeen)
+			}
+			return sorted[i].reason < sorted[j].reason
+		})
+		if len(sorted) > maxWarningEvents {
+			sorted = sorted[:maxWarningEvents]
+		}
+		rows := make([]report.Row, 0, len(sorted))
+		for _, s := range sorted {
+			rows = append(rows, report.Row{
+				ID: s.reason,
+				Entries: map[string]string{
+					WarningEventReason:   s.reason,
+					WarningEventMessage:  truncateMessage(s.message),
+					WarningEventCount:    strconv.Itoa(s.count),
+					WarningEventLastSeen: s.lastSeen.Format(time.RFC3339Nano),
+				},
+			})
+		}
+		result[nodeID] = rows
+	}
+	return result
+}
+
+// withWarningEvents adds the warning event rows to the nodes of a topology
+// they are about.
+func withWarningEvents(topology report.Topology, rows map[string][]report.Row) report.Topology {
+	for id, node := range topology.Nodes {
+		if r, ok := rows[id]; ok {
+			topology.Nodes[id] = node.AddPrefixMulticolumnTable(WarningEventPrefix, r)
+		}
+	}
+	return topology
+}
+
+// eventExpired says whether the event last happened more than maxAge ago.
+func eventExpired(e *apiv1.Event, maxAge time.Duration, now time.Time) bool {
+	return maxAge > 0 && now.Sub(eventLastSeen(e)) > maxAge
+}
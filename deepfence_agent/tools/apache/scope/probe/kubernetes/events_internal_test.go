@@ -0,0 +1,74 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/weaveworks/scope/report"
+)
+
+func TestWarningEventRows(t *testing.T) {
+	now := time.Now()
+	warning := func(reason, message string, count int32, ago time.Duration) *apiv1.Event {
+		return &apiv1.Event{
+			InvolvedObject: apiv1.ObjectReference{Kind: "Pod", UID: types.UID("pod1")},
+			Type:           apiv1.EventTypeWarning,
+			Reason:         reason,
+			Message:        message,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(now.Add(-ago)),
+		}
+	}
+	events := []*apiv1.Event{
+		warning("BackOff", "Back-off restarting failed container a", 3, 2*time.Minute),
+		warning("BackOff", "Back-off restarting failed container b", 2, time.Minute),
+		warning("FailedMount", strings.Repeat("x", 2*maxEventMessageLength), 1, 3*time.Minute),
+		{
+			InvolvedObject: apiv1.ObjectReference{Kind: "Pod", UID: types.UID("pod1")},
+			Type:           apiv1.EventTypeNormal,
+			Reason:         "Pulled",
+		},
+		{
+			InvolvedObject: apiv1.ObjectReference{Kind: "Node", Name: "node1", UID: types.UID("node1")},
+			Type:           apiv1.EventTypeWarning,
+			Reason:         "NodeNotReady",
+		},
+	}
+	for i := 0; i < maxWarningEvents; i++ {
+		events = append(events, warning(fmt.Sprintf("Old%d", i), "", 1, time.Hour))
+	}
+
+	rows := warningEventRows(events)
+	podRows := rows[report.MakePodNodeID("pod1")]
+	if len(podRows) != maxWarningEvents {
+		t.Fatalf("Expected %d rows for the pod, got %v", maxWarningEvents, podRows)
+	}
+	backOff := podRows[0].Entries
+	if backOff[WarningEventReason] != "BackOff" || backOff[WarningEventCount] != "5" ||
+		backOff[WarningEventMessage] != "Back-off restarting failed container b" {
+		t.Errorf("Expected the BackOff events to be merged, got %v", backOff)
+	}
+	if have := []rune(podRows[1].Entries[WarningEventMessage]); podRows[1].ID != "FailedMount" || len(have) != maxEventMessageLength {
+		t.Errorf("Expected a truncated FailedMount message, got %v", podRows[1])
+	}
+	if len(rows[report.MakeHostNodeID("node1")]) != 1 {
+		t.Errorf("Expected a warning on the host, got %v", rows)
+	}
+}
+
+func TestEventExpired(t *testing.T) {
+	now := time.Now()
+	event := &apiv1.Event{LastTimestamp: metav1.NewTime(now.Add(-2 * time.Hour))}
+	if !eventExpired(event, time.Hour, now) {
+		t.Errorf("Expected event to have expired")
+	}
+	if eventExpired(event, 0, now) || eventExpired(event, 3*time.Hour, now) {
+		t.Errorf("Expected event not to have expired")
+	}
+}
@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	HPA                = report.KubernetesHPA
+	HPAMinReplicas     = report.KubernetesHPAMinReplicas
+	HPAMaxReplicas     = report.KubernetesHPAMaxReplicas
+	HPACurrentReplicas = report.KubernetesHPACurrentReplicas
+	HPADesiredReplicas = report.KubernetesHPADesiredReplicas
+	HPATargets         = report.KubernetesHPATargets
+	HPALastScale       = report.KubernetesHPALastScale
+
+	unknownMetricValue = "<unknown>"
+)
+
+// hpasV2 are the horizontal pod autoscalers of autoscaling/v2, the only
+// ones served since Kubernetes 1.26.  Their types aren't vendored, so they
+// are read as unstructured objects; those of autoscaling/v2beta2 are the
+// same.
+var hpasV2 = schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+
+// hpaFromV2 converts the horizontal pod autoscalers of autoscaling/v2 to
+// those of autoscaling/v2beta2, leaving other objects as they are.
+func hpaFromV2(o interface{}) interface{} {
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return o
+	}
+	out := &autoscalingv2beta2.HorizontalPodAutoscaler{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), out); err != nil {
+		log.Warnf("Cannot read horizontal pod autoscaler %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+		return o
+	}
+	stripObjectMeta(&out.ObjectMeta)
+	return out
+}
+
+// HorizontalPodAutoscaler represents a Kubernetes horizontal pod autoscaler
+type HorizontalPodAutoscaler interface {
+	Meta
+	ScaleTarget() (kind, name string)
+	Stamp(node report.Node) report.Node
+}
+
+type horizontalPodAutoscaler struct {
+	*autoscalingv2beta2.HorizontalPodAutoscaler
+	Meta
+}
+
+// NewHorizontalPodAutoscaler creates a new HorizontalPodAutoscaler
+func NewHorizontalPodAutoscaler(h *autoscalingv2beta2.HorizontalPodAutoscaler) HorizontalPodAutoscaler {
+	return &horizontalPodAutoscaler{HorizontalPodAutoscaler: h, Meta: meta{h.ObjectMeta}}
+}
+
+func (h *horizontalPodAutoscaler) ScaleTarget() (string, string) {
+	return h.Spec.ScaleTargetRef.Kind, h.Spec.ScaleTargetRef.Name
+}
+
+// Stamp adds the state of the autoscaler to the node of the workload it
+// scales.
+func (h *horizontalPodAutoscaler) Stamp(node report.Node) report.Node {
+	minReplicas := int32(1)
+	if h.Spec.MinReplicas != nil {
+		minReplicas = *h.Spec.MinReplicas
+	}
+	latests := map[string]string{
+		HPA:                h.Name(),
+		HPAMinReplicas:     fmt.Sprint(minReplicas),
+		HPAMaxReplicas:     fmt.Sprint(h.Spec.MaxReplicas),
+		HPACurrentReplicas: fmt.Sprint(h.Status.CurrentReplicas),
+		HPADesiredReplicas: fmt.Sprint(h.Status.DesiredReplicas),
+	}
+	if targets := h.targets(); targets != "" {
+		latests[HPATargets] = targets
+	}
+	if h.Status.LastScaleTime != nil {
+		latests[HPALastScale] = h.Status.LastScaleTime.Format(time.RFC3339Nano)
+	}
+	return node.WithLatests(latests)
+}
+
+// targets describes each metric the autoscaler tracks as current/target,
+// the way kubectl does, e.g. "cpu: 45%/80%".
+func (h *horizontalPodAutoscaler) targets() string {
+	targets := make([]string, 0, len(h.Spec.Metrics))
+	for i, spec := range h.Spec.Metrics {
+		current := unknownMetricValue
+		if i < len(h.Status.CurrentMetrics) && h.Status.CurrentMetrics[i].Type == spec.Type {
+			current = metricStatusValue(h.Status.CurrentMetrics[i])
+		}
+		name, target := metricSpecTarget(spec)
+		targets = append(targets, fmt.Sprintf("%s: %s/%s", name, current, target))
+	}
+	return strings.Join(targets, ", ")
+}
+
+func metricSpecTarget(spec autoscalingv2beta2.MetricSpec) (string, string) {
+	switch {
+	case spec.Resource != nil:
+		return string(spec.Resource.Name), metricTargetValue(spec.Resource.Target)
+	case spec.Pods != nil:
+		return spec.Pods.Metric.Name, metricTargetValue(spec.Pods.Target)
+	case spec.Object != nil:
+		return spec.Object.Metric.Name, metricTargetValue(spec.Object.Target)
+	case spec.External != nil:
+		return spec.External.Metric.Name, metricTargetValue(spec.External.Target)
+	}
+	return string(spec.Type), unknownMetricValue
+}
+
+func metricTargetValue(target autoscalingv2beta2.MetricTarget) string {
+	switch {
+	case target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.AverageValue != nil:
+		return target.AverageValue.String()
+	case target.Value != nil:
+		return target.Value.String()
+	}
+	return unknownMetricValue
+}
+
+func metricStatusValue(status autoscalingv2beta2.MetricStatus) string {
+	var current autoscalingv2beta2.MetricValueStatus
+	switch {
+	case status.Resource != nil:
+		current = status.Resource.Current
+	case status.Pods != nil:
+		current = status.Pods.Current
+	case status.Object != nil:
+		current = status.Object.Current
+	case status.External != nil:
+		current = status.External.Current
+	default:
+		return unknownMetricValue
+	}
+	switch {
+	case current.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *current.AverageUtilization)
+	case current.AverageValue != nil:
+		return current.AverageValue.String()
+	case current.Value != nil:
+		return current.Value.String()
+	}
+	return unknownMetricValue
+}
@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"testing"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestHPAFromV2(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "autoscaling/v2",
+		"kind":       "HorizontalPodAutoscaler",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "ns"},
+		"spec": map[string]interface{}{
+			"scaleTargetRef": map[string]interface{}{"kind": "Deployment", "name": "web"},
+			"minReplicas":    int64(2),
+			"maxReplicas":    int64(10),
+			// Unknown to autoscaling/v2beta2 as vendored
+			"behavior": map[string]interface{}{"scaleDown": map[string]interface{}{"stabilizationWindowSeconds": int64(300)}},
+		},
+		"status": map[string]interface{}{"currentReplicas": int64(3), "desiredReplicas": int64(4)},
+	}}
+
+	have, ok := hpaFromV2(u).(*autoscalingv2beta2.HorizontalPodAutoscaler)
+	if !ok {
+		t.Fatalf("Expected a horizontal pod autoscaler, got %v", have)
+	}
+	if kind, name := NewHorizontalPodAutoscaler(have).ScaleTarget(); kind != "Deployment" || name != "web" {
+		t.Errorf("Unexpected scale target %s %s", kind, name)
+	}
+	if have.Spec.MinReplicas == nil || *have.Spec.MinReplicas != 2 || have.Spec.MaxReplicas != 10 || have.Status.DesiredReplicas != 4 {
+		t.Errorf("Unexpected autoscaler %v", have)
+	}
+
+	old := &autoscalingv2beta2.HorizontalPodAutoscaler{}
+	if hpaFromV2(old) != old {
+		t.Error("Expected the autoscaler of autoscaling/v2beta2 to be left alone")
+	}
+}
@@ -0,0 +1,263 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	IngressHosts      = report.KubernetesIngressHosts
+	IngressTLSHosts   = report.KubernetesIngressTLSHosts
+	IngressBackends   = report.KubernetesIngressBackends
+	IngressRulePrefix = report.KubernetesIngressRulePrefix
+
+	IngressRuleHost    = "kubernetes_ingress_rule_host"
+	IngressRulePath    = "kubernetes_ingress_rule_path"
+	IngressRuleBackend = "kubernetes_ingress_rule_backend"
+	IngressRuleTLS     = "kubernetes_ingress_rule_tls"
+
+	// anyHost is shown for rules, and the default backend, which apply to
+	// every host.
+	anyHost = "*"
+)
+
+// ingressesV1 are the ingresses of networking.k8s.io/v1, the only ones
+// served since Kubernetes 1.22.  Their types aren't vendored, so they are
+// read as unstructured objects, and converted to those of
+// extensions/v1beta1.
+var ingressesV1 = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+
+// ingressV1 is what is reported of the ingresses of networking.k8s.io/v1.
+type ingressV1 struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		DefaultBackend *ingressBackendV1              `json:"defaultBackend,omitempty"`
+		TLS            []extensionsv1beta1.IngressTLS `json:"tls,omitempty"`
+		Rules          []ingressRuleV1                `json:"rules,omitempty"`
+	} `json:"spec,omitempty"`
+	Status extensionsv1beta1.IngressStatus `json:"status,omitempty"`
+}
+
+type ingressRuleV1 struct {
+	Host string `json:"host,omitempty"`
+	HTTP *struct {
+		Paths []struct {
+			Path    string           `json:"path,omitempty"`
+			Backend ingressBackendV1 `json:"backend"`
+		} `json:"paths"`
+	} `json:"http,omitempty"`
+}
+
+// ingressBackendV1 is a backend of an ingress, a service or, unreported,
+// another resource.
+type ingressBackendV1 struct {
+	Service *struct {
+		Name string `json:"name"`
+		Port struct {
+			Name   string `json:"name,omitempty"`
+			Number int32  `json:"number,omitempty"`
+		} `json:"port,omitempty"`
+	} `json:"service,omitempty"`
+}
+
+// v1beta1 converts a backend, returning false for those of resources
+// other than services.
+func (b ingressBackendV1) v1beta1() (extensionsv1beta1.IngressBackend, bool) {
+	if b.Service == nil {
+		return extensionsv1beta1.IngressBackend{}, false
+	}
+	port := intstr.FromInt(int(b.Service.Port.Number))
+	if b.Service.Port.Name != "" {
+		port = intstr.FromString(b.Service.Port.Name)
+	}
+	return extensionsv1beta1.IngressBackend{ServiceName: b.Service.Name, ServicePort: port}, true
+}
+
+// ingressFromV1 converts the ingresses of networking.k8s.io/v1 to those of
+// extensions/v1beta1, leaving other objects as they are.
+func ingressFromV1(o interface{}) interface{} {
+	u, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		return o
+	}
+	var in ingressV1
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &in); err != nil {
+		log.Warnf("Cannot read ingress %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+		return o
+	}
+	out := &extensionsv1beta1.Ingress{ObjectMeta: in.ObjectMeta, Status: in.Status}
+	stripObjectMeta(&out.ObjectMeta)
+	if in.Spec.DefaultBackend != nil {
+		if backend, ok := in.Spec.DefaultBackend.v1beta1(); ok {
+			out.Spec.Backend = &backend
+		}
+	}
+	out.Spec.TLS = in.Spec.TLS
+	for _, rule := range in.Spec.Rules {
+		converted := extensionsv1beta1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			converted.HTTP = &extensionsv1beta1.HTTPIngressRuleValue{}
+			for _, p := range rule.HTTP.Paths {
+				if backend, ok := p.Backend.v1beta1(); ok {
+					converted.HTTP.Paths = append(converted.HTTP.Paths, extensionsv1beta1.HTTPIngressPath{Path: p.Path, Backend: backend})
+				}
+			}
+		}
+		out.Spec.Rules = append(out.Spec.Rules, converted)
+	}
+	return out
+}
+
+// Ingress represents a Kubernetes ingress
+type Ingress interface {
+	Meta
+	BackendServices() []string
+	GetNode(probeID string) report.Node
+}
+
+type ingress struct {
+	*extensionsv1beta1.Ingress
+	Meta
+}
+
+// NewIngress creates a new Ingress
+func NewIngress(i *extensionsv1beta1.Ingress) Ingress {
+	return &ingress{Ingress: i, Meta: meta{i.ObjectMeta}}
+}
+
+type ingressPath struct {
+	host    string
+	path    string
+	backend extensionsv1beta1.IngressBackend
+}
+
+func (i *ingress) paths() []ingressPath {
+	paths := []ingressPath{}
+	if i.Spec.Backend != nil {
+		paths = append(paths, ingressPath{host: anyHost, backend: *i.Spec.Backend})
+	}
+	for _, rule := range i.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := rule.Host
+		if host == "" {
+			host = anyHost
+		}
+		for _, p := range rule.HTTP.Paths {
+			paths = append(paths, ingressPath{host: host, path: p.Path, backend: p.Backend})
+		}
+	}
+	return paths
+}
+
+// BackendServices returns the names of the services, in the ingress'
+// namespace, which traffic is routed to.
+func (i *ingress) BackendServices() []string {
+	services := report.MakeStringSet()
+	for _, p := range i.paths() {
+		services = services.Add(p.backend.ServiceName)
+	}
+	return []string(services)
+}
+
+func (i *ingress) hosts() []string {
+	hosts := report.MakeStringSet()
+	for _, rule := range i.Spec.Rules {
+		if rule.Host != "" {
+			hosts = hosts.Add(rule.Host)
+		}
+	}
+	return []string(hosts)
+}
+
+func (i *ingress) tlsHosts() []string {
+	hosts := report.MakeStringSet()
+	for _, tls := range i.Spec.TLS {
+		if len(tls.Hosts) == 0 {
+			hosts = hosts.Add(anyHost)
+		}
+		hosts = hosts.Add(tls.Hosts...)
+	}
+	return []string(hosts)
+}
+
+// hostMatches checks whether host is matched by pattern, which may have a
+// wildcard as its first label, as in *.example.com.  As in certificates,
+// the wildcard covers exactly one label.
+func hostMatches(pattern, host string) bool {
+	if pattern == anyHost || pattern == host {
+		return true
+	}
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	label := strings.TrimSuffix(host, pattern[1:])
+	return label != host && label != "" && !strings.Contains(label, ".")
+}
+
+// tlsTerminated checks whether TLS is configured for host.
+func (i *ingress) tlsTerminated(host string) bool {
+	for _, pattern := range i.tlsHosts() {
+		if hostMatches(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *ingress) rules() []report.Row {
+	rows := []report.Row{}
+	for _, p := range i.paths() {
+		tls := "false"
+		if i.tlsTerminated(p.host) {
+			tls = "true"
+		}
+		rows = append(rows, report.Row{
+			ID: p.host + p.path,
+			Entries: map[string]string{
+				IngressRuleHost:    p.host,
+				IngressRulePath:    p.path,
+				IngressRuleBackend: p.backend.ServiceName + ":" + p.backend.ServicePort.String(),
+				IngressRuleTLS:     tls,
+			},
+		})
+	}
+	sort.Slice(rows, func(a, b int) bool { return rows[a].ID < rows[b].ID })
+	return rows
+}
+
+func (i *ingress) GetNode(probeID string) report.Node {
+	latest := map[string]string{
+		NodeType:              "Ingress",
+		k8sClusterId:          kubernetesClusterId,
+		k8sClusterName:        kubernetesClusterName,
+		report.ControlProbeID: probeID,
+	}
+	if hosts := i.hosts(); len(hosts) > 0 {
+		latest[IngressHosts] = strings.Join(hosts, ",")
+	}
+	if hosts := i.tlsHosts(); len(hosts) > 0 {
+		latest[IngressTLSHosts] = strings.Join(hosts, ",")
+	}
+	if services := i.BackendServices(); len(services) > 0 {
+		latest[IngressBackends] = strings.Join(services, ",")
+	}
+	if addresses := loadBalancerAddresses(i.Status.LoadBalancer.Ingress); len(addresses) > 0 {
+		latest[IngressIp] = strings.Join(addresses, ",")
+	}
+	return i.MetaNode(report.MakeIngressNodeID(i.UID())).WithLatests(latest).
+		AddPrefixMulticolumnTable(IngressRulePrefix, i.rules()).
+		WithParent(report.KubernetesCluster, kubernetesClusterNodeId).
+		WithParent(report.CloudProvider, cloudProviderNodeId)
+}
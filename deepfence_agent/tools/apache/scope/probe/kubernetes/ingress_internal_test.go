@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"testing"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func TestIngressFromV1(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "Ingress",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "ns", "uid": "123"},
+		"spec": map[string]interface{}{
+			"defaultBackend": map[string]interface{}{
+				"service": map[string]interface{}{"name": "default", "port": map[string]interface{}{"number": int64(80)}},
+			},
+			"tls": []interface{}{map[string]interface{}{"hosts": []interface{}{"example.com"}}},
+			"rules": []interface{}{map[string]interface{}{
+				"host": "example.com",
+				"http": map[string]interface{}{"paths": []interface{}{
+					map[string]interface{}{
+						"path":     "/api",
+						"pathType": "Prefix",
+						"backend":  map[string]interface{}{"service": map[string]interface{}{"name": "api", "port": map[string]interface{}{"name": "http"}}},
+					},
+					// Backends other than services aren't reported
+					map[string]interface{}{
+						"path":    "/static",
+						"backend": map[string]interface{}{"resource": map[string]interface{}{"kind": "StorageBucket", "name": "static"}},
+					},
+				}},
+			}},
+		},
+		"status": map[string]interface{}{
+			"loadBalancer": map[string]interface{}{"ingress": []interface{}{map[string]interface{}{"ip": "1.2.3.4"}}},
+		},
+	}}
+
+	have, ok := ingressFromV1(u).(*extensionsv1beta1.Ingress)
+	if !ok {
+		t.Fatalf("Expected an ingress, got %v", have)
+	}
+	if have.Name != "web" || have.Namespace != "ns" || have.UID != "123" {
+		t.Errorf("Unexpected metadata %v", have.ObjectMeta)
+	}
+	want := extensionsv1beta1.IngressSpec{
+		Backend: &extensionsv1beta1.IngressBackend{ServiceName: "default", ServicePort: intstr.FromInt(80)},
+		TLS:     []extensionsv1beta1.IngressTLS{{Hosts: []string{"example.com"}}},
+		Rules: []extensionsv1beta1.IngressRule{{
+			Host: "example.com",
+			IngressRuleValue: extensionsv1beta1.IngressRuleValue{HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+				Paths: []extensionsv1beta1.HTTPIngressPath{{
+					Path:    "/api",
+					Backend: extensionsv1beta1.IngressBackend{ServiceName: "api", ServicePort: intstr.FromString("http")},
+				}},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(want, have.Spec) {
+		t.Errorf("Unexpected spec %v", have.Spec)
+	}
+	if ingress := have.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != "1.2.3.4" {
+		t.Errorf("Unexpected status %v", have.Status)
+	}
+
+	// Ingresses of extensions/v1beta1 are left as they are
+	old := &extensionsv1beta1.Ingress{}
+	if ingressFromV1(old) != old {
+		t.Error("Expected the ingress of extensions/v1beta1 to be left alone")
+	}
+}
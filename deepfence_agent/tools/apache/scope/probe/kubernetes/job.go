@@ -1,17 +1,31 @@
 package kubernetes
 
 import (
+	"fmt"
+	"time"
+
 	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/weaveworks/scope/report"
 )
 
+// These constants are keys used in node metadata
+const (
+	ActivePods     = report.KubernetesActivePods
+	SucceededPods  = report.KubernetesSucceededPods
+	FailedPods     = report.KubernetesFailedPods
+	StartTime      = report.KubernetesStartTime
+	CompletionTime = report.KubernetesCompletionTime
+)
+
 //Job represents a Kubernetes job
 type Job interface {
 	Meta
 	Selector() (labels.Selector, error)
+	CronJobUID() string
 	GetNode(probeID string) report.Node
 }
 
@@ -36,14 +50,71 @@ func (j *job) Selector() (labels.Selector, error) {
 	return selector, nil
 }
 
+// CronJobUID returns the UID of the cron job which created this job, or ""
+// if it wasn't created by one.
+func (j *job) CronJobUID() string {
+	for _, owner := range j.OwnerReferences {
+		if owner.Kind == "CronJob" {
+			return string(owner.UID)
+		}
+	}
+	return ""
+}
+
 func (j *job) GetNode(probeID string) report.Node {
 	latests := map[string]string{
 		NodeType:              "Job",
+		ActivePods:            fmt.Sprint(j.Status.Active),
+		SucceededPods:         fmt.Sprint(j.Status.Succeeded),
+		FailedPods:            fmt.Sprint(j.Status.Failed),
 		report.ControlProbeID: probeID,
 	}
-	return j.MetaNode(report.MakeJobNodeID(j.UID())).
+	if j.Status.StartTime != nil {
+		latests[StartTime] = j.Status.StartTime.Format(time.RFC3339Nano)
+	}
+	if finished, ok := jobFinishedAt(j.Job); ok {
+		latests[CompletionTime] = finished.Format(time.RFC3339Nano)
+	}
+	node := j.MetaNode(report.MakeJobNodeID(j.UID())).
 		WithLatests(latests).
 		WithParent(report.KubernetesCluster, kubernetesClusterNodeId).
 		WithParent(report.CloudProvider, cloudProviderNodeId)
 	//.WithLatestActiveControls(Describe)
+	if cronJobUID := j.CronJobUID(); cronJobUID != "" {
+		node = node.WithParent(report.CronJob, report.MakeCronJobNodeID(cronJobUID))
+	}
+	return node
+}
+
+// jobFinishedAt returns when j completed or failed, if it has finished.
+func jobFinishedAt(j *batchv1.Job) (time.Time, bool) {
+	for _, c := range j.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == apiv1.ConditionTrue {
+			if j.Status.CompletionTime != nil {
+				return j.Status.CompletionTime.Time, true
+			}
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// jobSucceeded returns true if j ran to completion.
+func jobSucceeded(j *batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobComplete && c.Status == apiv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobExpired returns true if j finished more than maxAge ago.  A zero
+// maxAge keeps every job.
+func jobExpired(j *batchv1.Job, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	finished, ok := jobFinishedAt(j)
+	return ok && now.Sub(finished) > maxAge
 }
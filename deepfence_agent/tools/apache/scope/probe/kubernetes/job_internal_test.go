@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJobExpired(t *testing.T) {
+	now := time.Now()
+	finished := func(condition batchv1.JobConditionType, ago time.Duration) *batchv1.Job {
+		return &batchv1.Job{Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:               condition,
+				Status:             apiv1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(now.Add(-ago)),
+			}},
+		}}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		job    *batchv1.Job
+		maxAge time.Duration
+		want   bool
+	}{
+		{"running", &batchv1.Job{}, time.Hour, false},
+		{"recently completed", finished(batchv1.JobComplete, time.Minute), time.Hour, false},
+		{"long completed", finished(batchv1.JobComplete, 2*time.Hour), time.Hour, true},
+		{"long failed", finished(batchv1.JobFailed, 2*time.Hour), time.Hour, true},
+		{"no max age", finished(batchv1.JobComplete, 2*time.Hour), 0, false},
+	} {
+		if have := jobExpired(tc.job, tc.maxAge, now); have != tc.want {
+			t.Errorf("%s: expected expired=%v, got %v", tc.name, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	// DefaultKubeletURL is where the kubelet of the node the probe runs on
+	// listens, as seen from the host network.
+	DefaultKubeletURL = "https://127.0.0.1:10250"
+
+	kubeletSummaryPath = "/stats/summary"
+	// The kubelet only refreshes its stats every 10-15s.
+	kubeletSummaryInterval = 15 * time.Second
+	kubeletSummaryTimeout  = 10 * time.Second
+)
+
+// PodStats is the resource usage of a pod and its containers, as reported
+// by the kubelet.
+type PodStats struct {
+	UID string
+	ResourceStats
+	Containers map[string]ResourceStats // by container name
+}
+
+// ResourceStats is the CPU and working set memory usage of a pod or
+// container, and the memory available to it under its limit, if it has
+// one.  Any may be missing.
+type ResourceStats struct {
+	Timestamp       time.Time
+	CPUNanoCores    *uint64
+	WorkingSetBytes *uint64
+	AvailableBytes  *uint64
+}
+
+// The subset of the kubelet's summary API we use.
+type summary struct {
+	Pods []podSummary `json:"pods"`
+}
+
+type podSummary struct {
+	PodRef struct {
+		UID string `json:"uid"`
+	} `json:"podRef"`
+	// Pod level stats are missing from the summaries of kubelets older than
+	// 1.9, whose usage has to be added up from their containers.
+	CPU        *cpuSummary        `json:"cpu"`
+	Memory     *memorySummary     `json:"memory"`
+	Containers []containerSummary `json:"containers"`
+}
+
+type containerSummary struct {
+	Name   string         `json:"name"`
+	CPU    *cpuSummary    `json:"cpu"`
+	Memory *memorySummary `json:"memory"`
+}
+
+type cpuSummary struct {
+	Time           metav1.Time `json:"time"`
+	UsageNanoCores *uint64     `json:"usageNanoCores"`
+}
+
+type memorySummary struct {
+	Time            metav1.Time `json:"time"`
+	WorkingSetBytes *uint64     `json:"workingSetBytes"`
+	// Only set for pods and containers with a memory limit
+	AvailableBytes *uint64 `json:"availableBytes"`
+}
+
+func resourceStats(cpu *cpuSummary, memory *memorySummary) ResourceStats {
+	var stats ResourceStats
+	if cpu != nil && cpu.UsageNanoCores != nil {
+		stats.CPUNanoCores = cpu.UsageNanoCores
+		stats.Timestamp = cpu.Time.Time
+	}
+	if memory != nil && memory.WorkingSetBytes != nil {
+		stats.WorkingSetBytes = memory.WorkingSetBytes
+		stats.AvailableBytes = memory.AvailableBytes
+		if memory.Time.After(stats.Timestamp) {
+			stats.Timestamp = memory.Time.Time
+		}
+	}
+	return stats
+}
+
+func addStats(total *uint64, value *uint64) *uint64 {
+	if value == nil {
+		return total
+	}
+	sum := *value
+	if total != nil {
+		sum += *total
+	}
+	return &sum
+}
+
+// parseSummary decodes the pods of a kubelet stats summary.
+func parseSummary(r io.Reader) ([]PodStats, error) {
+	var s summary
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	result := make([]PodStats, 0, len(s.Pods))
+	for _, p := range s.Pods {
+		stats := PodStats{
+			UID:           p.PodRef.UID,
+			ResourceStats: resourceStats(p.CPU, p.Memory),
+			Containers:    make(map[string]ResourceStats, len(p.Containers)),
+		}
+		var (
+			cpu, memory *uint64
+			latest      time.Time
+		)
+		for _, c := range p.Containers {
+			cs := resourceStats(c.CPU, c.Memory)
+			stats.Containers[c.Name] = cs
+			cpu, memory = addStats(cpu, cs.CPUNanoCores), addStats(memory, cs.WorkingSetBytes)
+			if cs.Timestamp.After(latest) {
+				latest = cs.Timestamp
+			}
+		}
+		if stats.CPUNanoCores == nil {
+			stats.CPUNanoCores = cpu
+		}
+		if stats.WorkingSetBytes == nil {
+			stats.WorkingSetBytes = memory
+		}
+		if stats.Timestamp.IsZero() {
+			stats.Timestamp = latest
+		}
+		result = append(result, stats)
+	}
+	return result, nil
+}
+
+// Metrics turns the stats into the metrics reported for containers, so
+// they show up the same whichever runtime the node uses.  CPU is a
+// percentage of the whole host, like docker's.
+func (s ResourceStats) Metrics() report.Metrics {
+	metrics := report.Metrics{}
+	if s.CPUNanoCores != nil {
+		percent := float64(*s.CPUNanoCores) / float64(runtime.NumCPU()) / 1e7
+		metrics[docker.CPUTotalUsage] = report.MakeSingletonMetric(s.Timestamp, percent).WithMax(100.0)
+	}
+	if s.WorkingSetBytes != nil {
+		metrics[docker.MemoryUsage] = report.MakeSingletonMetric(s.Timestamp, float64(*s.WorkingSetBytes))
+	}
+	return metrics
+}
+
+// withNearOOM flags pods using more than nearOOMRatio of their memory
+// limit, which is what they use plus what's available to them.  Nothing is
+// derived for pods without a limit.
+func withNearOOM(node report.Node, stats ResourceStats) report.Node {
+	if stats.WorkingSetBytes == nil || stats.AvailableBytes == nil {
+		return node
+	}
+	used := float64(*stats.WorkingSetBytes)
+	limit := used + float64(*stats.AvailableBytes)
+	if limit <= 0 {
+		return node
+	}
+	return node.WithLatest(NearOOM, stats.Timestamp, strconv.FormatBool(used >= nearOOMRatio*limit))
+}
+
+// KubeletReporter reports the usage of the pods on the node the probe runs
+// on, and tags their containers with theirs, from the stats summary API of
+// the local kubelet, for clusters without a metrics server.  It's run by
+// probes with the host role, as only they are local to a kubelet.
+type KubeletReporter struct {
+	url    string
+	token  string
+	client *http.Client
+	quit   chan struct{}
+
+	mtx  sync.Mutex
+	pods map[string]PodStats // by pod UID
+}
+
+// NewKubeletReporter makes a KubeletReporter for the kubelet at url,
+// authenticating with the token of the client config.  The kubelet's
+// certificate is checked against the cluster CA unless insecure is set.
+// Don't forget to Stop it.
+func NewKubeletReporter(config ClientConfig, url string, insecure bool) (*KubeletReporter, error) {
+	restConfig, err := newRestConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if !insecure {
+		ca := restConfig.CAData
+		if len(ca) == 0 && restConfig.CAFile != "" {
+			if ca, err = ioutil.ReadFile(restConfig.CAFile); err != nil {
+				return nil, err
+			}
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates found in the cluster CA")
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	k := newKubeletReporter(url, restConfig.BearerToken, &http.Transport{TLSClientConfig: tlsConfig})
+	go k.loop()
+	return k, nil
+}
+
+func newKubeletReporter(url, token string, transport http.RoundTripper) *KubeletReporter {
+	return &KubeletReporter{
+		url:   strings.TrimSuffix(url, "/") + kubeletSummaryPath,
+		token: token,
+		client: &http.Client{
+			Timeout:   kubeletSummaryTimeout,
+			Transport: transport,
+		},
+		quit: make(chan struct{}),
+		pods: map[string]PodStats{},
+	}
+}
+
+// Name of this reporter and tagger, for metrics gathering
+func (*KubeletReporter) Name() string { return "Kubelet" }
+
+// Stop stops fetching stats from the kubelet.
+func (k *KubeletReporter) Stop() {
+	close(k.quit)
+}
+
+func (k *KubeletReporter) fetch() ([]PodStats, error) {
+	req, err := http.NewRequest("GET", k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", k.url, resp.Status)
+	}
+	return parseSummary(resp.Body)
+}
+
+// update replaces the stats held by those fetched from the kubelet.
+func (k *KubeletReporter) update() {
+	pods, err := k.fetch()
+	if err != nil {
+		log.Warnf("cannot get stats summary from the kubelet: %v", err)
+	}
+	byUID := make(map[string]PodStats, len(pods))
+	for _, p := range pods {
+		byUID[p.UID] = p
+	}
+	k.mtx.Lock()
+	k.pods = byUID
+	k.mtx.Unlock()
+}
+
+func (k *KubeletReporter) loop() {
+	ticker := time.NewTicker(kubeletSummaryInterval)
+	defer ticker.Stop()
+	for {
+		k.update()
+		select {
+		case <-ticker.C:
+		case <-k.quit:
+			return
+		}
+	}
+}
+
+// Report reports the usage of the pods the kubelet has stats for, and
+// whether they are near OOM.  The rest of the pod nodes come from the
+// probe with the cluster role.
+func (k *KubeletReporter) Report() (report.Report, error) {
+	result := report.MakeReport()
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	for uid, stats := range k.pods {
+		node := report.MakeNode(report.MakePodNodeID(uid)).WithMetrics(stats.Metrics())
+		result.Pod.AddNode(withNearOOM(node, stats.ResourceStats))
+	}
+	return result, nil
+}
+
+// Tag adds the usage of the containers of the pods the kubelet has stats
+// for to their nodes, found by the labels the kubelet gives them, unless
+// the container runtime reported it already.
+func (k *KubeletReporter) Tag(rpt report.Report) (report.Report, error) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	if len(k.pods) == 0 {
+		return rpt, nil
+	}
+	for id, n := range rpt.Container.Nodes {
+		uid, ok := n.Latest.Lookup(docker.LabelPrefix + "io.kubernetes.pod.uid")
+		if !ok {
+			continue
+		}
+		name, ok := n.Latest.Lookup(docker.LabelPrefix + "io.kubernetes.container.name")
+		if !ok {
+			continue
+		}
+		stats, ok := k.pods[uid].Containers[name]
+		if !ok {
+			continue
+		}
+		metrics := report.Metrics{}
+		for key, m := range stats.Metrics() {
+			if _, ok := n.Metrics.Lookup(key); !ok {
+				metrics[key] = m
+			}
+		}
+		if len(metrics) > 0 {
+			rpt.Container.Nodes[id] = n.WithMetrics(metrics)
+		}
+	}
+	return rpt, nil
+}
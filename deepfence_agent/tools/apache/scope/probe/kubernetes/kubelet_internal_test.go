@@ -0,0 +1,139 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestParseSummary(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		summary string
+	}{
+		{"with pod stats", `{"pods": [{
+			"podRef": {"name": "app", "namespace": "default", "uid": "pod1"},
+			"cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 300},
+			"memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 3000},
+			"containers": [
+				{"name": "app", "cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 100}, "memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 1000}},
+				{"name": "sidecar", "cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 100}, "memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 1000}}
+			]
+		}]}`},
+		// Older kubelets don't report pod level stats
+		{"without pod stats", `{"pods": [{
+			"podRef": {"name": "app", "namespace": "default", "uid": "pod1"},
+			"containers": [
+				{"name": "app", "cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 100}, "memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 1000}},
+				{"name": "sidecar", "cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 200}, "memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 2000}}
+			]
+		}]}`},
+	} {
+		pods, err := parseSummary(strings.NewReader(tc.summary))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(pods) != 1 || pods[0].UID != "pod1" {
+			t.Fatalf("%s: expected stats for pod1, got %v", tc.name, pods)
+		}
+		p := pods[0]
+		if p.CPUNanoCores == nil || *p.CPUNanoCores != 300 || p.WorkingSetBytes == nil || *p.WorkingSetBytes != 3000 {
+			t.Errorf("%s: expected pod to use 300 nanocores and 3000 bytes, got %v", tc.name, p.ResourceStats)
+		}
+		if p.Timestamp.IsZero() {
+			t.Errorf("%s: expected pod stats to have a timestamp", tc.name)
+		}
+		if len(p.Containers) != 2 || *p.Containers["app"].WorkingSetBytes != 1000 {
+			t.Errorf("%s: expected stats for both containers, got %v", tc.name, p.Containers)
+		}
+	}
+}
+
+func TestWithNearOOM(t *testing.T) {
+	now := time.Now()
+	bytes := func(n uint64) *uint64 { return &n }
+
+	for _, tc := range []struct {
+		name   string
+		stats  ResourceStats
+		want   string
+		wantOK bool
+	}{
+		{"no stats", ResourceStats{}, "", false},
+		{"no limit", ResourceStats{Timestamp: now, WorkingSetBytes: bytes(95)}, "", false},
+		{"below", ResourceStats{Timestamp: now, WorkingSetBytes: bytes(89), AvailableBytes: bytes(11)}, "false", true},
+		{"above", ResourceStats{Timestamp: now, WorkingSetBytes: bytes(90), AvailableBytes: bytes(10)}, "true", true},
+	} {
+		have, ok := withNearOOM(report.MakeNode("pod"), tc.stats).Latest.Lookup(NearOOM)
+		if ok != tc.wantOK || have != tc.want {
+			t.Errorf("%s: expected %q (%v), got %q (%v)", tc.name, tc.want, tc.wantOK, have, ok)
+		}
+	}
+}
+
+func TestKubeletReporter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != kubeletSummaryPath || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"pods": [{
+			"podRef": {"name": "app", "namespace": "default", "uid": "pod1"},
+			"memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 95, "availableBytes": 5},
+			"containers": [
+				{"name": "app", "cpu": {"time": "2019-01-01T00:00:00Z", "usageNanoCores": 100}, "memory": {"time": "2019-01-01T00:00:00Z", "workingSetBytes": 95}}
+			]
+		}]}`)
+	}))
+	defer ts.Close()
+	k := newKubeletReporter(ts.URL, "token", http.DefaultTransport)
+	k.update()
+
+	rpt, err := k.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := rpt.Pod.Nodes[report.MakePodNodeID("pod1")]
+	if m, ok := pod.Metrics.Lookup(docker.MemoryUsage); !ok || m.Max != 95 {
+		t.Errorf("Expected pod to have a memory usage metric, got %v", m)
+	}
+	if have, ok := pod.Latest.Lookup(NearOOM); !ok || have != "true" {
+		t.Errorf("Expected pod using 95%% of its memory limit to be near OOM, got %q", have)
+	}
+
+	// Containers get their usage by their labels, without that reported by
+	// the runtime being replaced
+	rpt = report.MakeReport()
+	labels := map[string]string{"io.kubernetes.pod.uid": "pod1", "io.kubernetes.container.name": "app"}
+	rpt.Container.AddNode(report.MakeNode(report.MakeContainerNodeID("abc123")).
+		AddPrefixPropertyList(docker.LabelPrefix, labels).
+		WithMetrics(report.Metrics{docker.MemoryUsage: report.MakeSingletonMetric(time.Now(), 42)}))
+	rpt.Container.AddNode(report.MakeNode(report.MakeContainerNodeID("def456")))
+	rpt, err = k.Tag(rpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := rpt.Container.Nodes[report.MakeContainerNodeID("abc123")]
+	if _, ok := container.Metrics.Lookup(docker.CPUTotalUsage); !ok {
+		t.Errorf("Expected container to have a CPU usage metric")
+	}
+	if m, _ := container.Metrics.Lookup(docker.MemoryUsage); m.Max != 42 {
+		t.Errorf("Expected the runtime's memory usage to be kept, got %v", m)
+	}
+	if len(rpt.Container.Nodes[report.MakeContainerNodeID("def456")].Metrics) != 0 {
+		t.Errorf("Expected container of no pod to have no metrics")
+	}
+
+	// Stats the kubelet doesn't give are dropped
+	ts.Close()
+	k.update()
+	if rpt, _ := k.Report(); len(rpt.Pod.Nodes) != 0 {
+		t.Errorf("Expected no pods without stats, got %v", rpt.Pod.Nodes)
+	}
+}
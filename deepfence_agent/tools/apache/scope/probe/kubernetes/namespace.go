@@ -1,11 +1,47 @@
 package kubernetes
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/weaveworks/scope/report"
 
 	apiv1 "k8s.io/api/core/v1"
 )
 
+// These constants are keys used in node metadata
+const (
+	PSSEnforce          = report.KubernetesPSSEnforce
+	PSSAudit            = report.KubernetesPSSAudit
+	PSSWarn             = report.KubernetesPSSWarn
+	NamespacePSSLevel   = report.KubernetesNamespacePSSLevel
+	AnnotationPrefix    = report.KubernetesAnnotationPrefix
+	PodPhasePrefix      = report.KubernetesPodPhasePrefix
+	ResourceQuotaPrefix = report.KubernetesResourceQuotaPrefix
+	LimitRangePrefix    = report.KubernetesLimitRangePrefix
+
+	ResourceQuotaName     = "kubernetes_resource_quota_name"
+	ResourceQuotaResource = "kubernetes_resource_quota_resource"
+	ResourceQuotaUsed     = "kubernetes_resource_quota_used"
+	ResourceQuotaHard     = "kubernetes_resource_quota_hard"
+
+	LimitRangeName           = "kubernetes_limit_range_name"
+	LimitRangeType           = "kubernetes_limit_range_type"
+	LimitRangeResource       = "kubernetes_limit_range_resource"
+	LimitRangeMin            = "kubernetes_limit_range_min"
+	LimitRangeMax            = "kubernetes_limit_range_max"
+	LimitRangeDefault        = "kubernetes_limit_range_default"
+	LimitRangeDefaultRequest = "kubernetes_limit_range_default_request"
+)
+
+// Labels setting the Pod Security admission level of a namespace, and the
+// latest fields we report them under.
+var pssLabels = map[string]string{
+	"pod-security.kubernetes.io/enforce": PSSEnforce,
+	"pod-security.kubernetes.io/audit":   PSSAudit,
+	"pod-security.kubernetes.io/warn":    PSSWarn,
+}
+
 // NamespaceResource represents a Kubernetes namespace
 // `Namespace` is already taken in meta.go
 type NamespaceResource interface {
@@ -24,5 +60,105 @@ func NewNamespace(ns *apiv1.Namespace) NamespaceResource {
 }
 
 func (ns *namespace) GetNode() report.Node {
-	return ns.MetaNode(report.MakeNamespaceNodeID(ns.UID()))
+	latests := map[string]string{}
+	for label, key := range pssLabels {
+		if level, ok := ns.ns.Labels[label]; ok {
+			latests[key] = level
+		}
+	}
+	return ns.MetaNode(report.MakeNamespaceNodeID(ns.UID())).
+		WithLatests(latests).
+		AddPrefixPropertyList(AnnotationPrefix, ns.ns.Annotations)
+}
+
+// resourceQuotaRows summarizes the resource quotas of a namespace, one row
+// per quota and resource.
+func resourceQuotaRows(quotas []*apiv1.ResourceQuota) []report.Row {
+	rows := []report.Row{}
+	for _, quota := range quotas {
+		for _, name := range sortedResourceNames(quota.Status.Hard) {
+			hard := quota.Status.Hard[name]
+			used := quota.Status.Used[name]
+			rows = append(rows, report.Row{
+				ID: quota.Name + "/" + string(name),
+				Entries: map[string]string{
+					ResourceQuotaName:     quota.Name,
+					ResourceQuotaResource: string(name),
+					ResourceQuotaUsed:     used.String(),
+					ResourceQuotaHard:     hard.String(),
+				},
+			})
+		}
+	}
+	return rows
+}
+
+// limitRangeRows summarizes the limit ranges of a namespace, one row per
+// limit range, type of object and resource.
+func limitRangeRows(limitRanges []*apiv1.LimitRange) []report.Row {
+	rows := []report.Row{}
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			// Every resource constrained one way or another
+			names := apiv1.ResourceList{}
+			for _, list := range []apiv1.ResourceList{item.Min, item.Max, item.Default, item.DefaultRequest} {
+				for name, quantity := range list {
+					names[name] = quantity
+				}
+			}
+			for _, name := range sortedResourceNames(names) {
+				entries := map[string]string{
+					LimitRangeName:     limitRange.Name,
+					LimitRangeType:     string(item.Type),
+					LimitRangeResource: string(name),
+				}
+				for key, list := range map[string]apiv1.ResourceList{
+					LimitRangeMin:            item.Min,
+					LimitRangeMax:            item.Max,
+					LimitRangeDefault:        item.Default,
+					LimitRangeDefaultRequest: item.DefaultRequest,
+				} {
+					if quantity, ok := list[name]; ok {
+						entries[key] = quantity.String()
+					}
+				}
+				rows = append(rows, report.Row{
+					ID:      fmt.Sprintf("%s/%s/%s", limitRange.Name, item.Type, name),
+					Entries: entries,
+				})
+			}
+		}
+	}
+	return rows
+}
+
+func sortedResourceNames(list apiv1.ResourceList) []apiv1.ResourceName {
+	names := make([]apiv1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// withNamespacePSSLevel stamps pods with the Pod Security admission level
+// enforced in their namespace.
+func withNamespacePSSLevel(pods, namespaces report.Topology) report.Topology {
+	levels := map[string]string{}
+	for _, n := range namespaces.Nodes {
+		name, _ := n.Latest.Lookup(Name)
+		if level, ok := n.Latest.Lookup(PSSEnforce); ok {
+			levels[name] = level
+		}
+	}
+	if len(levels) == 0 {
+		return pods
+	}
+	for id, pod := range pods.Nodes {
+		namespace, _ := pod.Latest.Lookup(Namespace)
+		if level, ok := levels[namespace]; ok {
+			pods.Nodes[id] = pod.WithLatests(map[string]string{NamespacePSSLevel: level})
+		}
+	}
+	return pods
 }
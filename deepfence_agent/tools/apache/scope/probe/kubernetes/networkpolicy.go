@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	IngressIsolated     = report.KubernetesIngressIsolated
+	EgressIsolated      = report.KubernetesEgressIsolated
+	NetworkPolicyPrefix = report.KubernetesNetworkPolicyPrefix
+
+	NetworkPolicyTypes = "kubernetes_network_policy_types"
+)
+
+// NetworkPolicy represents a Kubernetes network policy
+type NetworkPolicy interface {
+	Meta
+	Selector() (labels.Selector, error)
+	PolicyTypes() []string
+}
+
+type networkPolicy struct {
+	*networkingv1.NetworkPolicy
+	Meta
+}
+
+// NewNetworkPolicy creates a new NetworkPolicy
+func NewNetworkPolicy(p *networkingv1.NetworkPolicy) NetworkPolicy {
+	return &networkPolicy{NetworkPolicy: p, Meta: meta{p.ObjectMeta}}
+}
+
+// Selector returns the selector of the pods the policy applies to. An empty
+// selector selects every pod in the namespace.
+func (p *networkPolicy) Selector() (labels.Selector, error) {
+	return metav1.LabelSelectorAsSelector(&p.Spec.PodSelector)
+}
+
+// PolicyTypes returns the directions of traffic the policy isolates pods
+// for.  When not given explicitly, policies always isolate ingress, and
+// egress only if they have egress rules.
+func (p *networkPolicy) PolicyTypes() []string {
+	types := []string{}
+	if len(p.Spec.PolicyTypes) > 0 {
+		for _, t := range p.Spec.PolicyTypes {
+			types = append(types, string(t))
+		}
+		return types
+	}
+	types = append(types, string(networkingv1.PolicyTypeIngress))
+	if len(p.Spec.Egress) > 0 {
+		types = append(types, string(networkingv1.PolicyTypeEgress))
+	}
+	return types
+}
+
+type networkPolicySelector struct {
+	policy   NetworkPolicy
+	selector labels.Selector
+}
+
+func makeNetworkPolicySelectors(policies []NetworkPolicy) ([]networkPolicySelector, error) {
+	selectors := make([]networkPolicySelector, 0, len(policies))
+	for _, policy := range policies {
+		selector, err := policy.Selector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, networkPolicySelector{policy: policy, selector: selector})
+	}
+	return selectors, nil
+}
+
+func (s networkPolicySelector) selects(pod Pod) bool {
+	return s.policy.Namespace() == pod.Namespace() && s.selector.Matches(labels.Set(pod.Labels()))
+}
+
+// withNetworkPolicies records on the node of pod whether it is isolated by
+// network policies, and which policies select it.
+func withNetworkPolicies(node report.Node, pod Pod, selectors []networkPolicySelector) report.Node {
+	ingressIsolated, egressIsolated := false, false
+	rows := []report.Row{}
+	for _, s := range selectors {
+		if !s.selects(pod) {
+			continue
+		}
+		types := s.policy.PolicyTypes()
+		for _, t := range types {
+			switch networkingv1.PolicyType(t) {
+			case networkingv1.PolicyTypeIngress:
+				ingressIsolated = true
+			case networkingv1.PolicyTypeEgress:
+				egressIsolated = true
+			}
+		}
+		rows = append(rows, report.Row{
+			ID:      s.policy.Name(),
+			Entries: map[string]string{NetworkPolicyTypes: strings.Join(types, ",")},
+		})
+	}
+	return node.WithLatests(map[string]string{
+		IngressIsolated: fmt.Sprint(ingressIsolated),
+		EgressIsolated:  fmt.Sprint(egressIsolated),
+	}).AddPrefixMulticolumnTable(NetworkPolicyPrefix, rows)
+}
+
+// withoutNetworkPolicy clears the row of policy from the network policies
+// table of a node, so it doesn't linger from earlier reports once the
+// policy no longer selects the pod.
+func withoutNetworkPolicy(node report.Node, policy NetworkPolicy) report.Node {
+	return node.AddPrefixMulticolumnTable(NetworkPolicyPrefix, []report.Row{{
+		ID:      policy.Name(),
+		Entries: map[string]string{NetworkPolicyTypes: ""},
+	}})
+}
@@ -1,12 +1,59 @@
 package kubernetes
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	NodeReady           = report.KubernetesNodeReady
+	MemoryPressure      = report.KubernetesMemoryPressure
+	DiskPressure        = report.KubernetesDiskPressure
+	PIDPressure         = report.KubernetesPIDPressure
+	Unschedulable       = report.KubernetesUnschedulable
+	Taints              = report.KubernetesTaints
+	KubeletVersion      = report.KubernetesKubeletVersion
+	RuntimeVersion      = report.KubernetesRuntimeVersion
+	NodeConditionPrefix = report.KubernetesNodeConditionPrefix
+	NodeResourcePrefix  = report.KubernetesNodeResourcePrefix
+
+	NodeConditionStatus         = "kubernetes_node_condition_status"
+	NodeConditionReason         = "kubernetes_node_condition_reason"
+	NodeConditionMessage        = "kubernetes_node_condition_message"
+	NodeConditionLastTransition = "kubernetes_node_condition_last_transition"
+	NodeResourceCapacity        = "kubernetes_node_resource_capacity"
+	NodeResourceAllocatable     = "kubernetes_node_resource_allocatable"
+)
+
+// Labels of the cloud region of a node: the well-known label, and the one
+// it replaced, which older clusters still set.
+const (
+	regionLabel           = "topology.kubernetes.io/region"
+	deprecatedRegionLabel = "failure-domain.beta.kubernetes.io/region"
 )
 
+// nodeConditionKeys are the node conditions reported as latest fields of
+// the host, on top of the conditions table.
+var nodeConditionKeys = map[apiv1.NodeConditionType]string{
+	apiv1.NodeReady:          NodeReady,
+	apiv1.NodeMemoryPressure: MemoryPressure,
+	apiv1.NodeDiskPressure:   DiskPressure,
+	apiv1.NodePIDPressure:    PIDPressure,
+}
+
 // NodeResource represents a Kubernetes node
 type NodeResource interface {
 	Meta
+	Unschedulable() bool
+	Region() string
+	GetNode() report.Node
 }
 
 type nodeResource struct {
@@ -20,4 +67,91 @@ func NewNodeResource(n *apiv1.Node) NodeResource {
 		Node: n,
 		Meta: meta{n.ObjectMeta},
 	}
-}
\ No newline at end of file
+}
+
+// Unschedulable returns true if the node is cordoned.
+func (n *nodeResource) Unschedulable() bool {
+	return n.Spec.Unschedulable
+}
+
+// Region returns the cloud region of the node, from its labels, or "" if
+// it isn't labelled with one.
+func (n *nodeResource) Region() string {
+	if region, ok := n.Labels()[regionLabel]; ok {
+		return region
+	}
+	return n.Labels()[deprecatedRegionLabel]
+}
+
+func taintString(t apiv1.Taint) string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
+
+func (n *nodeResource) conditions() []report.Row {
+	rows := make([]report.Row, 0, len(n.Status.Conditions))
+	for _, c := range n.Status.Conditions {
+		entries := map[string]string{
+			NodeConditionStatus:         string(c.Status),
+			NodeConditionLastTransition: c.LastTransitionTime.Format(time.RFC3339Nano),
+		}
+		if c.Reason != "" {
+			entries[NodeConditionReason] = c.Reason
+		}
+		if c.Message != "" {
+			entries[NodeConditionMessage] = c.Message
+		}
+		rows = append(rows, report.Row{ID: string(c.Type), Entries: entries})
+	}
+	return rows
+}
+
+func (n *nodeResource) resources() []report.Row {
+	names := map[apiv1.ResourceName]struct{}{}
+	for name := range n.Status.Capacity {
+		names[name] = struct{}{}
+	}
+	for name := range n.Status.Allocatable {
+		names[name] = struct{}{}
+	}
+	rows := make([]report.Row, 0, len(names))
+	for name := range names {
+		entries := map[string]string{}
+		if q, ok := n.Status.Capacity[name]; ok {
+			entries[NodeResourceCapacity] = q.String()
+		}
+		if q, ok := n.Status.Allocatable[name]; ok {
+			entries[NodeResourceAllocatable] = q.String()
+		}
+		rows = append(rows, report.Row{ID: string(name), Entries: entries})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows
+}
+
+// GetNode returns the status of the node, as a host node to be merged with
+// the one reported by the host probe.
+func (n *nodeResource) GetNode() report.Node {
+	latest := map[string]string{
+		Unschedulable:  fmt.Sprint(n.Unschedulable()),
+		KubeletVersion: n.Status.NodeInfo.KubeletVersion,
+		RuntimeVersion: n.Status.NodeInfo.ContainerRuntimeVersion,
+	}
+	for _, c := range n.Status.Conditions {
+		if key, ok := nodeConditionKeys[c.Type]; ok {
+			latest[key] = string(c.Status)
+		}
+	}
+	if len(n.Spec.Taints) > 0 {
+		taints := make([]string, 0, len(n.Spec.Taints))
+		for _, t := range n.Spec.Taints {
+			taints = append(taints, taintString(t))
+		}
+		latest[Taints] = strings.Join(taints, ", ")
+	}
+	return report.MakeNodeWith(report.MakeHostNodeID(n.Name()), latest).
+		AddPrefixMulticolumnTable(NodeConditionPrefix, n.conditions()).
+		AddPrefixMulticolumnTable(NodeResourcePrefix, n.resources())
+}
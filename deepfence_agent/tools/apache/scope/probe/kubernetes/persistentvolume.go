@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"reflect"
+	"strings"
 
 	"github.com/weaveworks/scope/report"
 	apiv1 "k8s.io/api/core/v1"
@@ -27,16 +28,18 @@ func NewPersistentVolume(p *apiv1.PersistentVolume) PersistentVolume {
 	return &persistentVolume{PersistentVolume: p, Meta: meta{p.ObjectMeta}}
 }
 
-// GetAccessMode returns access mode associated with PV
+// GetAccessMode returns the access modes the PV supports.  A volume can
+// only be mounted using one access mode at a time, even if it supports many.
 func (p *persistentVolume) GetAccessMode() string {
-	var accessMode string
+	return accessModes(p.Spec.AccessModes)
+}
 
-	if len(p.Spec.AccessModes) > 0 {
-		// A volume can only be mounted using one access mode at a time,
-		//even if it supports many.
-		accessMode = string(p.Spec.AccessModes[0])
+func accessModes(modes []apiv1.PersistentVolumeAccessMode) string {
+	result := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		result = append(result, string(mode))
 	}
-	return accessMode
+	return strings.Join(result, ", ")
 }
 
 // GetVolume returns volume name
@@ -69,14 +72,21 @@ func (p *persistentVolume) GetNode(probeID string) report.Node {
 		NodeType:              "Persistent Volume",
 		VolumeClaim:           p.GetVolume(),
 		StorageClassName:      p.Spec.StorageClassName,
-		Status:                string(p.Status.Phase),
+		Phase:                 string(p.Status.Phase),
 		AccessModes:           p.GetAccessMode(),
+		ReclaimPolicy:         string(p.Spec.PersistentVolumeReclaimPolicy),
 		report.ControlProbeID: probeID,
 	}
 
 	if p.GetStorageDriver() != "" {
 		latests[StorageDriver] = p.GetStorageDriver()
 	}
+	if p.Spec.CSI != nil {
+		latests[CSIDriver] = p.Spec.CSI.Driver
+	}
+	if capacity, ok := p.Spec.Capacity[apiv1.ResourceStorage]; ok {
+		latests[VolumeCapacity] = capacity.String()
+	}
 
 	return p.MetaNode(report.MakePersistentVolumeNodeID(p.UID())).WithLatests(latests)
 		//.WithLatestActiveControls(Describe)
@@ -48,10 +48,13 @@ func (p *persistentVolumeClaim) GetStorageClass() string {
 	return storageClassName
 }
 
-// GetCapacity returns the storage size of PVC
+// GetCapacity returns the storage size of PVC: that of the volume it is
+// bound to, or else what it requests.
 func (p *persistentVolumeClaim) GetCapacity() string {
-	capacity := p.Spec.Resources.Requests[apiv1.ResourceStorage]
-	if capacity.String() != "" {
+	if capacity, ok := p.Status.Capacity[apiv1.ResourceStorage]; ok {
+		return capacity.String()
+	}
+	if capacity, ok := p.Spec.Resources.Requests[apiv1.ResourceStorage]; ok {
 		return capacity.String()
 	}
 	return ""
@@ -61,7 +64,7 @@ func (p *persistentVolumeClaim) GetCapacity() string {
 func (p *persistentVolumeClaim) GetNode(probeID string) report.Node {
 	latests := map[string]string{
 		NodeType:              "Persistent Volume Claim",
-		Status:                string(p.Status.Phase),
+		Phase:                 string(p.Status.Phase),
 		VolumeName:            p.Spec.VolumeName,
 		StorageClassName:      p.GetStorageClass(),
 		report.ControlProbeID: probeID,
@@ -70,6 +73,11 @@ func (p *persistentVolumeClaim) GetNode(probeID string) report.Node {
 	if p.GetCapacity() != "" {
 		latests[VolumeCapacity] = p.GetCapacity()
 	}
+	if modes := accessModes(p.Status.AccessModes); modes != "" {
+		latests[AccessModes] = modes
+	} else if modes := accessModes(p.Spec.AccessModes); modes != "" {
+		latests[AccessModes] = modes
+	}
 
 	return p.MetaNode(report.MakePersistentVolumeClaimNodeID(p.UID())).
 		WithLatests(latests)
@@ -3,7 +3,9 @@ package kubernetes
 import (
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/scope/report"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -21,10 +23,15 @@ type Pod interface {
 	Meta
 	AddParent(topology, id string)
 	NodeName() string
+	State() string
 	GetNode(probeID string) report.Node
 	RestartCount() uint
 	ContainerNames() []string
 	VolumeClaimNames() []string
+	ServiceAccountName() string
+	AutomountServiceAccountToken(sa *apiv1.ServiceAccount) bool
+	ImagePullSecrets() []string
+	Scheduling(pendingThreshold time.Duration, now time.Time) map[string]string
 }
 
 type pod struct {
@@ -108,7 +115,9 @@ func (p *pod) GetNode(probeID string) report.Node {
 	}
 	p.parents = p.parents.AddString(report.KubernetesCluster, kubernetesClusterNodeId)
 	p.parents = p.parents.AddString(report.CloudProvider, cloudProviderNodeId)
+	requests, limits := podResources(p.Spec)
 	return p.MetaNode(report.MakePodNodeID(p.UID())).WithLatests(latests).
+		WithMetrics(resourceMetrics(requests, limits, 1, mtime.Now())).
 		WithParents(p.parents)
 	//  WithLatestActiveControls(DeletePod)
 	//	WithLatestActiveControls(GetLogs, DeletePod, Describe)
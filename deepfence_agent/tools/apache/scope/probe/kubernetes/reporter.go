@@ -2,17 +2,24 @@ package kubernetes
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/probe"
 	"github.com/weaveworks/scope/probe/controls"
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("kubernetes")
+
 // These constants are keys used in node metadata
 const (
 	IP                 = report.KubernetesIP
@@ -35,6 +42,9 @@ const (
 	VolumeSnapshotName = report.KubernetesVolumeSnapshotName
 	SnapshotData       = report.KubernetesSnapshotData
 	VolumeCapacity     = report.KubernetesVolumeCapacity
+	Phase              = report.KubernetesPhase
+	CSIDriver          = report.KubernetesCSIDriver
+	VolumeInUse        = report.KubernetesVolumeInUse
 	k8sClusterId       = report.KubernetesClusterId
 	k8sClusterName     = report.KubernetesClusterName
 )
@@ -61,12 +71,90 @@ var (
 		Created:               {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 6},
 		RestartCount:          {ID: RestartCount, Label: "Restart #", From: report.FromLatest, Priority: 7},
 		IsInHostNetwork:       {ID: IsInHostNetwork, Label: "Host Network", From: report.FromLatest, Priority: 8},
-		k8sClusterId:          {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 9},
-		k8sClusterName:        {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 10},
-		report.ControlProbeID: {ID: report.ControlProbeID, Label: "Probe ID", From: report.FromLatest, Priority: 11},
+		ExternallyExposed:     {ID: ExternallyExposed, Label: "Externally exposed", From: report.FromLatest, Priority: 9},
+		IngressIsolated:       {ID: IngressIsolated, Label: "Ingress isolated", From: report.FromLatest, Priority: 10},
+		EgressIsolated:        {ID: EgressIsolated, Label: "Egress isolated", From: report.FromLatest, Priority: 11},
+		NearOOM:               {ID: NearOOM, Label: "Near OOM", From: report.FromLatest, Priority: 12},
+		NamespacePSSLevel:     {ID: NamespacePSSLevel, Label: "Pod security level", From: report.FromLatest, Priority: 13},
+		ServiceAccount:        {ID: ServiceAccount, Label: "Service account", From: report.FromLatest, Priority: 14},
+		AutomountToken:        {ID: AutomountToken, Label: "Token mounted", From: report.FromLatest, Priority: 15},
+		ClusterAdmin:          {ID: ClusterAdmin, Label: "Cluster admin", From: report.FromLatest, Priority: 16},
+		ImagePullSecrets:      {ID: ImagePullSecrets, Label: "Image pull secrets", From: report.FromLatest, Priority: 17},
+		NodeSelector:          {ID: NodeSelector, Label: "Node selector", From: report.FromLatest, Priority: 18},
+		Tolerations:           {ID: Tolerations, Label: "Tolerations", From: report.FromLatest, Priority: 19},
+		Affinity:              {ID: Affinity, Label: "Affinity", From: report.FromLatest, Priority: 20},
+		SchedulingReason:      {ID: SchedulingReason, Label: "Unschedulable", From: report.FromLatest, Priority: 21},
+		FailedScheduling:      {ID: FailedScheduling, Label: "Last scheduling failure", From: report.FromLatest, Priority: 22},
+		PendingTooLong:        {ID: PendingTooLong, Label: "Pending too long", From: report.FromLatest, Priority: 23},
+		k8sClusterId:          {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 24},
+		k8sClusterName:        {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 25},
+		report.ControlProbeID: {ID: report.ControlProbeID, Label: "Probe ID", From: report.FromLatest, Priority: 26},
 	}
 
-	PodMetricTemplates = docker.ContainerMetricTemplates
+	PodMetricTemplates = docker.ContainerMetricTemplates.Merge(ResourceMetricTemplates)
+
+	PodTableTemplates = TableTemplates.Merge(WarningEventTableTemplates).Merge(report.TableTemplates{
+		NetworkPolicyPrefix: {
+			ID:     NetworkPolicyPrefix,
+			Label:  "Network policies",
+			Type:   report.MulticolumnTableType,
+			Prefix: NetworkPolicyPrefix,
+			Columns: []report.Column{
+				{ID: NetworkPolicyTypes, Label: "Policy types"},
+			},
+		},
+	})
+
+	NamespaceMetadataTemplates = report.MetadataTemplates{
+		Created:        {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 1},
+		PSSEnforce:     {ID: PSSEnforce, Label: "Pod security (enforce)", From: report.FromLatest, Priority: 2},
+		PSSAudit:       {ID: PSSAudit, Label: "Pod security (audit)", From: report.FromLatest, Priority: 3},
+		PSSWarn:        {ID: PSSWarn, Label: "Pod security (warn)", From: report.FromLatest, Priority: 4},
+		k8sClusterId:   {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 5},
+		k8sClusterName: {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 6},
+	}
+
+	NamespaceTableTemplates = TableTemplates.Merge(report.TableTemplates{
+		AnnotationPrefix: {
+			ID:     AnnotationPrefix,
+			Label:  "Kubernetes annotations",
+			Type:   report.PropertyListType,
+			Prefix: AnnotationPrefix,
+		},
+		PodPhasePrefix: {
+			ID:     PodPhasePrefix,
+			Label:  "Pods by state",
+			Type:   report.PropertyListType,
+			Prefix: PodPhasePrefix,
+		},
+		ResourceQuotaPrefix: {
+			ID:     ResourceQuotaPrefix,
+			Label:  "Resource quotas",
+			Type:   report.MulticolumnTableType,
+			Prefix: ResourceQuotaPrefix,
+			Columns: []report.Column{
+				{ID: ResourceQuotaName, Label: "Quota"},
+				{ID: ResourceQuotaResource, Label: "Resource"},
+				{ID: ResourceQuotaUsed, Label: "Used"},
+				{ID: ResourceQuotaHard, Label: "Hard"},
+			},
+		},
+		LimitRangePrefix: {
+			ID:     LimitRangePrefix,
+			Label:  "Limit ranges",
+			Type:   report.MulticolumnTableType,
+			Prefix: LimitRangePrefix,
+			Columns: []report.Column{
+				{ID: LimitRangeName, Label: "Limit range"},
+				{ID: LimitRangeType, Label: "Type"},
+				{ID: LimitRangeResource, Label: "Resource"},
+				{ID: LimitRangeMin, Label: "Min"},
+				{ID: LimitRangeMax, Label: "Max"},
+				{ID: LimitRangeDefault, Label: "Default limit"},
+				{ID: LimitRangeDefaultRequest, Label: "Default request"},
+			},
+		},
+	})
 
 	ServiceMetadataTemplates = report.MetadataTemplates{
 		Namespace:             {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 2},
@@ -77,13 +165,100 @@ var (
 		Type:                  {ID: Type, Label: "Type", From: report.FromLatest, Priority: 7},
 		Ports:                 {ID: Ports, Label: "Ports", From: report.FromLatest, Priority: 8},
 		IngressIp:             {ID: IngressIp, Label: "Ingress IP", From: report.FromLatest, Priority: 9},
-		k8sClusterId:          {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 10},
-		k8sClusterName:        {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 11},
-		report.ControlProbeID: {ID: report.ControlProbeID, Label: "Probe ID", From: report.FromLatest, Priority: 12},
+		ExternalIPs:           {ID: ExternalIPs, Label: "External IPs", From: report.FromLatest, Priority: 10},
+		ExternalHostnames:     {ID: ExternalHostnames, Label: "External hostnames", From: report.FromLatest, Priority: 11},
+		NodePorts:             {ID: NodePorts, Label: "Node ports", From: report.FromLatest, Priority: 12},
+		ExternallyExposed:     {ID: ExternallyExposed, Label: "Externally exposed", From: report.FromLatest, Priority: 13},
+		k8sClusterId:          {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 14},
+		k8sClusterName:        {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 15},
+		report.ControlProbeID: {ID: report.ControlProbeID, Label: "Probe ID", From: report.FromLatest, Priority: 16},
 	}
 
 	ServiceMetricTemplates = PodMetricTemplates
 
+	// HostMetadataTemplates are merged with those of the host probe, hence
+	// the priorities following on from those.
+	HostMetadataTemplates = report.MetadataTemplates{
+		NodeReady:      {ID: NodeReady, Label: "Node ready", From: report.FromLatest, Priority: 34},
+		MemoryPressure: {ID: MemoryPressure, Label: "Memory pressure", From: report.FromLatest, Priority: 35},
+		DiskPressure:   {ID: DiskPressure, Label: "Disk pressure", From: report.FromLatest, Priority: 36},
+		PIDPressure:    {ID: PIDPressure, Label: "PID pressure", From: report.FromLatest, Priority: 37},
+		Unschedulable:  {ID: Unschedulable, Label: "Cordoned", From: report.FromLatest, Priority: 38},
+		Taints:         {ID: Taints, Label: "Taints", From: report.FromLatest, Priority: 39},
+		KubeletVersion: {ID: KubeletVersion, Label: "Kubelet version", From: report.FromLatest, Priority: 40},
+		RuntimeVersion: {ID: RuntimeVersion, Label: "Container runtime", From: report.FromLatest, Priority: 41},
+	}
+
+	HostTableTemplates = WarningEventTableTemplates.Merge(report.TableTemplates{
+		NodeConditionPrefix: {
+			ID:     NodeConditionPrefix,
+			Label:  "Node conditions",
+			Type:   report.MulticolumnTableType,
+			Prefix: NodeConditionPrefix,
+			Columns: []report.Column{
+				{ID: NodeConditionStatus, Label: "Status"},
+				{ID: NodeConditionReason, Label: "Reason"},
+				{ID: NodeConditionMessage, Label: "Message"},
+				{ID: NodeConditionLastTransition, Label: "Since", DataType: report.DateTime},
+			},
+		},
+		NodeResourcePrefix: {
+			ID:     NodeResourcePrefix,
+			Label:  "Node resources",
+			Type:   report.MulticolumnTableType,
+			Prefix: NodeResourcePrefix,
+			Columns: []report.Column{
+				{ID: NodeResourceCapacity, Label: "Capacity"},
+				{ID: NodeResourceAllocatable, Label: "Allocatable"},
+			},
+		},
+	})
+
+	IngressMetadataTemplates = report.MetadataTemplates{
+		NodeType:        {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
+		Namespace:       {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 2},
+		Created:         {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 3},
+		IngressHosts:    {ID: IngressHosts, Label: "Hosts", From: report.FromLatest, Priority: 4},
+		IngressTLSHosts: {ID: IngressTLSHosts, Label: "TLS hosts", From: report.FromLatest, Priority: 5},
+		IngressBackends: {ID: IngressBackends, Label: "Services", From: report.FromLatest, Priority: 6},
+		IngressIp:       {ID: IngressIp, Label: "Address", From: report.FromLatest, Priority: 7},
+		k8sClusterId:    {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 8},
+		k8sClusterName:  {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 9},
+	}
+
+	CustomResourceMetadataTemplates = report.MetadataTemplates{
+		NodeType:           {ID: NodeType, Label: "Kind", From: report.FromLatest, Priority: 1},
+		Namespace:          {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 2},
+		Created:            {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 3},
+		CustomResourceType: {ID: CustomResourceType, Label: "Resource", From: report.FromLatest, Priority: 4},
+		k8sClusterId:       {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 5},
+		k8sClusterName:     {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 6},
+	}
+
+	CustomResourceTableTemplates = TableTemplates.Merge(report.TableTemplates{
+		CustomFieldPrefix: {
+			ID:     CustomFieldPrefix,
+			Label:  "Status",
+			Type:   report.PropertyListType,
+			Prefix: CustomFieldPrefix,
+		},
+	})
+
+	IngressTableTemplates = TableTemplates.Merge(report.TableTemplates{
+		IngressRulePrefix: {
+			ID:     IngressRulePrefix,
+			Label:  "Rules",
+			Type:   report.MulticolumnTableType,
+			Prefix: IngressRulePrefix,
+			Columns: []report.Column{
+				{ID: IngressRuleHost, Label: "Host"},
+				{ID: IngressRulePath, Label: "Path"},
+				{ID: IngressRuleBackend, Label: "Backend"},
+				{ID: IngressRuleTLS, Label: "TLS"},
+			},
+		},
+	})
+
 	DeploymentMetadataTemplates = report.MetadataTemplates{
 		NodeType:           {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
 		Namespace:          {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 2},
@@ -92,8 +267,15 @@ var (
 		DesiredReplicas:    {ID: DesiredReplicas, Label: "Desired replicas", From: report.FromLatest, Datatype: report.Number, Priority: 5},
 		report.Pod:         {ID: report.Pod, Label: "# Pods", From: report.FromCounters, Datatype: report.Number, Priority: 6},
 		Strategy:           {ID: Strategy, Label: "Strategy", From: report.FromLatest, Priority: 7},
-		k8sClusterId:       {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 8},
-		k8sClusterName:     {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 9},
+		HPA:                {ID: HPA, Label: "Autoscaler", From: report.FromLatest, Priority: 8},
+		HPAMinReplicas:     {ID: HPAMinReplicas, Label: "Min replicas", From: report.FromLatest, Datatype: report.Number, Priority: 9},
+		HPAMaxReplicas:     {ID: HPAMaxReplicas, Label: "Max replicas", From: report.FromLatest, Datatype: report.Number, Priority: 10},
+		HPACurrentReplicas: {ID: HPACurrentReplicas, Label: "Current replicas", From: report.FromLatest, Datatype: report.Number, Priority: 11},
+		HPADesiredReplicas: {ID: HPADesiredReplicas, Label: "Autoscaler desired replicas", From: report.FromLatest, Datatype: report.Number, Priority: 12},
+		HPATargets:         {ID: HPATargets, Label: "Autoscaler targets", From: report.FromLatest, Priority: 13},
+		HPALastScale:       {ID: HPALastScale, Label: "Last scaled", From: report.FromLatest, Datatype: report.DateTime, Priority: 14},
+		k8sClusterId:       {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 15},
+		k8sClusterName:     {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 16},
 	}
 
 	DeploymentMetricTemplates = PodMetricTemplates
@@ -130,10 +312,12 @@ var (
 		Schedule:       {ID: Schedule, Label: "Schedule", From: report.FromLatest, Priority: 4},
 		LastScheduled:  {ID: LastScheduled, Label: "Last scheduled", From: report.FromLatest, Datatype: report.DateTime, Priority: 5},
 		Suspended:      {ID: Suspended, Label: "Suspended", From: report.FromLatest, Priority: 6},
-		ActiveJobs:     {ID: ActiveJobs, Label: "# Jobs", From: report.FromLatest, Datatype: report.Number, Priority: 7},
-		report.Pod:     {ID: report.Pod, Label: "# Pods", From: report.FromCounters, Datatype: report.Number, Priority: 8},
-		k8sClusterId:   {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 9},
-		k8sClusterName: {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 10},
+		ActiveJobs:     {ID: ActiveJobs, Label: "Active jobs", From: report.FromLatest, Datatype: report.Number, Priority: 7},
+		SucceededJobs:  {ID: SucceededJobs, Label: "Succeeded jobs", From: report.FromLatest, Datatype: report.Number, Priority: 8},
+		FailedJobs:     {ID: FailedJobs, Label: "Failed jobs", From: report.FromLatest, Datatype: report.Number, Priority: 9},
+		report.Pod:     {ID: report.Pod, Label: "# Pods", From: report.FromCounters, Datatype: report.Number, Priority: 10},
+		k8sClusterId:   {ID: k8sClusterId, Label: "Kubernetes Cluster Id", From: report.FromLatest, Priority: 11},
+		k8sClusterName: {ID: k8sClusterName, Label: "Kubernetes Cluster Name", From: report.FromLatest, Priority: 12},
 	}
 
 	CronJobMetricTemplates = PodMetricTemplates
@@ -142,18 +326,23 @@ var (
 		NodeType:         {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
 		VolumeClaim:      {ID: VolumeClaim, Label: "Volume claim", From: report.FromLatest, Priority: 2},
 		StorageClassName: {ID: StorageClassName, Label: "Storage class", From: report.FromLatest, Priority: 3},
+		VolumeCapacity:   {ID: VolumeCapacity, Label: "Capacity", From: report.FromLatest, Priority: 4},
 		AccessModes:      {ID: AccessModes, Label: "Access modes", From: report.FromLatest, Priority: 5},
-		Status:           {ID: Status, Label: "Status", From: report.FromLatest, Priority: 6},
+		Phase:            {ID: Phase, Label: "Phase", From: report.FromLatest, Priority: 6},
 		StorageDriver:    {ID: StorageDriver, Label: "Storage driver", From: report.FromLatest, Priority: 7},
+		CSIDriver:        {ID: CSIDriver, Label: "CSI driver", From: report.FromLatest, Priority: 8},
+		ReclaimPolicy:    {ID: ReclaimPolicy, Label: "Reclaim policy", From: report.FromLatest, Priority: 9},
 	}
 
 	PersistentVolumeClaimMetadataTemplates = report.MetadataTemplates{
 		NodeType:         {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
 		Namespace:        {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 2},
-		Status:           {ID: Status, Label: "Status", From: report.FromLatest, Priority: 3},
+		Phase:            {ID: Phase, Label: "Phase", From: report.FromLatest, Priority: 3},
 		VolumeName:       {ID: VolumeName, Label: "Volume", From: report.FromLatest, Priority: 4},
 		StorageClassName: {ID: StorageClassName, Label: "Storage class", From: report.FromLatest, Priority: 5},
 		VolumeCapacity:   {ID: VolumeCapacity, Label: "Capacity", From: report.FromLatest, Priority: 6},
+		AccessModes:      {ID: AccessModes, Label: "Access modes", From: report.FromLatest, Priority: 7},
+		VolumeInUse:      {ID: VolumeInUse, Label: "Used by pods", From: report.FromLatest, Priority: 8},
 	}
 
 	StorageClassMetadataTemplates = report.MetadataTemplates{
@@ -176,11 +365,16 @@ var (
 	}
 
 	JobMetadataTemplates = report.MetadataTemplates{
-		NodeType:   {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
-		Name:       {ID: Name, Label: "Name", From: report.FromLatest, Priority: 2},
-		Namespace:  {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 3},
-		Created:    {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 4},
-		report.Pod: {ID: report.Pod, Label: "# Pods", From: report.FromCounters, Datatype: report.Number, Priority: 5},
+		NodeType:       {ID: NodeType, Label: "Type", From: report.FromLatest, Priority: 1},
+		Name:           {ID: Name, Label: "Name", From: report.FromLatest, Priority: 2},
+		Namespace:      {ID: Namespace, Label: "Namespace", From: report.FromLatest, Priority: 3},
+		Created:        {ID: Created, Label: "Created", From: report.FromLatest, Datatype: report.DateTime, Priority: 4},
+		StartTime:      {ID: StartTime, Label: "Started", From: report.FromLatest, Datatype: report.DateTime, Priority: 5},
+		CompletionTime: {ID: CompletionTime, Label: "Finished", From: report.FromLatest, Datatype: report.DateTime, Priority: 6},
+		ActivePods:     {ID: ActivePods, Label: "Active pods", From: report.FromLatest, Datatype: report.Number, Priority: 7},
+		SucceededPods:  {ID: SucceededPods, Label: "Succeeded pods", From: report.FromLatest, Datatype: report.Number, Priority: 8},
+		FailedPods:     {ID: FailedPods, Label: "Failed pods", From: report.FromLatest, Datatype: report.Number, Priority: 9},
+		report.Pod:     {ID: report.Pod, Label: "# Pods", From: report.FromCounters, Datatype: report.Number, Priority: 10},
 	}
 
 	JobMetricTemplates = PodMetricTemplates
@@ -226,32 +420,42 @@ type Reporter struct {
 	hostID             string
 	handlerRegistry    *controls.HandlerRegistry
 	nodeName           string
+	controlsEnabled    bool
+	logsAllowed        bool
+	logsCheckedAt      time.Time
+	pendingThreshold   time.Duration
 	k8sClusterTopology report.Topology
+	cluster            string // set when the probe watches several clusters
 }
 
 // NewReporter makes a new Reporter
-func NewReporter(client Client, pipes controls.PipeClient, probeID string, hostID string, probe *probe.Probe, handlerRegistry *controls.HandlerRegistry, nodeName string) *Reporter {
+func NewReporter(client Client, pipes controls.PipeClient, probeID string, hostID string, probe *probe.Probe, handlerRegistry *controls.HandlerRegistry, nodeName string, controlsEnabled bool, pendingThreshold time.Duration) *Reporter {
 	kubernetesClusterId = os.Getenv(k8sClusterId)
 	kubernetesClusterNodeId = report.MakeKubernetesClusterNodeID(kubernetesClusterId)
 	kubernetesClusterName = os.Getenv(k8sClusterName)
 
 	reporter := &Reporter{
-		client:          client,
-		pipes:           pipes,
-		probeID:         probeID,
-		probe:           probe,
-		hostID:          hostID,
-		handlerRegistry: handlerRegistry,
-		nodeName:        nodeName,
+		client:           client,
+		pipes:            pipes,
+		probeID:          probeID,
+		probe:            probe,
+		hostID:           hostID,
+		handlerRegistry:  handlerRegistry,
+		nodeName:         nodeName,
+		controlsEnabled:  controlsEnabled,
+		pendingThreshold: pendingThreshold,
 	}
 	k8sClusterTopology, _ := reporter.kubernetesClusterTopology()
 	reporter.k8sClusterTopology = k8sClusterTopology
 	client.WatchPods(reporter.podEvent)
+	client.WatchNetworkPolicies(reporter.networkPolicyEvent)
+	reporter.registerControls()
 	return reporter
 }
 
 // Stop unregisters controls.
 func (r *Reporter) Stop() {
+	r.deregisterControls()
 }
 
 // Name of this reporter, for metrics gathering
@@ -281,6 +485,44 @@ func (r *Reporter) podEvent(e Event, pod Pod) {
 	}
 }
 
+// networkPolicyEvent publishes the network policy coverage of the pods in
+// the namespace of a policy which changed, so it shows without waiting for
+// the next full report, clearing the row of the policy from the pods it no
+// longer selects.  It is called before the client's store is updated.
+func (r *Reporter) networkPolicyEvent(e Event, policy NetworkPolicy) {
+	policies := []NetworkPolicy{}
+	r.client.WalkNetworkPolicies(func(p NetworkPolicy) error {
+		if p.UID() != policy.UID() {
+			policies = append(policies, p)
+		}
+		return nil
+	})
+	if e != DELETE {
+		policies = append(policies, policy)
+	}
+	selectors, err := makeNetworkPolicySelectors(policies)
+	if err != nil {
+		log.Warnf("kubernetes: invalid network policy %s/%s: %v", policy.Namespace(), policy.Name(), err)
+		return
+	}
+	rpt := report.MakeReport()
+	rpt.Shortcut = true
+	r.client.WalkPods(func(p Pod) error {
+		// as in podEvent, filter out non-local pods if we have been given a node name
+		if p.Namespace() != policy.Namespace() || (r.nodeName != "" && p.NodeName() != r.nodeName) {
+			return nil
+		}
+		node := withNetworkPolicies(report.MakeNode(report.MakePodNodeID(p.UID())), p, selectors)
+		// The policy changed is the last of the selectors, unless deleted
+		if e == DELETE || !selectors[len(selectors)-1].selects(p) {
+			node = withoutNetworkPolicy(node, policy)
+		}
+		rpt.Pod.AddNode(node)
+		return nil
+	})
+	r.probe.Publish(rpt)
+}
+
 // IsPauseImageName indicates whether an image name corresponds to a
 // kubernetes pause container image.
 func IsPauseImageName(imageName string) bool {
@@ -348,8 +590,20 @@ func (r *Tagger) Tag(rpt report.Report) (report.Report, error) {
 
 // Report generates a Report containing Container and ContainerImage topologies
 func (r *Reporter) Report() (report.Report, error) {
+	result, err := r.report()
+	if err != nil || r.cluster == "" {
+		return result, err
+	}
+	return withClusterPrefix(result, r.cluster), nil
+}
+
+func (r *Reporter) report() (report.Report, error) {
 	result := report.MakeReport()
-	serviceTopology, services, err := r.serviceTopology()
+	ingressTopology, ingresses, err := r.ingressTopology()
+	if err != nil {
+		return result, err
+	}
+	serviceTopology, services, err := r.serviceTopology(ingresses)
 	if err != nil {
 		return result, err
 	}
@@ -361,7 +615,7 @@ func (r *Reporter) Report() (report.Report, error) {
 	if err != nil {
 		return result, err
 	}
-	cronJobTopology, cronJobs, err := r.cronJobTopology()
+	cronJobTopology, _, err := r.cronJobTopology()
 	if err != nil {
 		return result, err
 	}
@@ -373,7 +627,24 @@ func (r *Reporter) Report() (report.Report, error) {
 	if err != nil {
 		return result, err
 	}
-	podTopology, err := r.podTopology(services, deployments, daemonSets, statefulSets, cronJobs, jobs)
+	networkPolicies, err := r.networkPolicies()
+	if err != nil {
+		return result, err
+	}
+	persistentVolumeClaimTopology, claims, err := r.persistentVolumeClaimTopology()
+	if err != nil {
+		return result, err
+	}
+	podTopology, err := r.podTopology(services, deployments, daemonSets, statefulSets, jobs, networkPolicies, claims)
+	if err != nil {
+		return result, err
+	}
+	podTopology = markExposedPods(podTopology, serviceTopology)
+	customResourceTopology, err := r.customResourceTopology()
+	if err != nil {
+		return result, err
+	}
+	podTopology, err = r.withCustomResourceParents(podTopology, customResourceTopology)
 	if err != nil {
 		return result, err
 	}
@@ -381,18 +652,28 @@ func (r *Reporter) Report() (report.Report, error) {
 	if err != nil {
 		return result, err
 	}
-	//persistentVolumeTopology, _, err := r.persistentVolumeTopology()
-	//if err != nil {
-	//	return result, err
-	//}
-	//persistentVolumeClaimTopology, _, err := r.persistentVolumeClaimTopology()
-	//if err != nil {
-	//	return result, err
-	//}
-	//storageClassTopology, _, err := r.storageClassTopology()
-	//if err != nil {
-	//	return result, err
-	//}
+	podTopology = withNamespacePSSLevel(podTopology, namespaceTopology)
+	hostTopology, regions, err := r.hostTopology()
+	if err != nil {
+		return result, err
+	}
+	events, err := r.events()
+	if err != nil {
+		return result, err
+	}
+	warnings := warningEventRows(events)
+	podTopology = withFailedScheduling(podTopology, failedSchedulingMessages(events))
+	podTopology = withWarningEvents(podTopology, warnings)
+	deploymentTopology = withWarningEvents(deploymentTopology, warnings)
+	hostTopology = withWarningEvents(hostTopology, warnings)
+	persistentVolumeTopology, _, err := r.persistentVolumeTopology()
+	if err != nil {
+		return result, err
+	}
+	storageClassTopology, _, err := r.storageClassTopology()
+	if err != nil {
+		return result, err
+	}
 	//volumeSnapshotTopology, _, err := r.volumeSnapshotTopology()
 	//if err != nil {
 	//	return result, err
@@ -401,7 +682,7 @@ func (r *Reporter) Report() (report.Report, error) {
 	//if err != nil {
 	//	return result, err
 	//}
-	result.KubernetesCluster = result.KubernetesCluster.Merge(r.k8sClusterTopology)
+	result.KubernetesCluster = result.KubernetesCluster.Merge(r.clusterTopology(regions))
 	result.Pod = result.Pod.Merge(podTopology)
 	result.Service = result.Service.Merge(serviceTopology)
 	result.DaemonSet = result.DaemonSet.Merge(daemonSetTopology)
@@ -409,12 +690,15 @@ func (r *Reporter) Report() (report.Report, error) {
 	result.CronJob = result.CronJob.Merge(cronJobTopology)
 	result.Deployment = result.Deployment.Merge(deploymentTopology)
 	result.Namespace = result.Namespace.Merge(namespaceTopology)
-	//result.PersistentVolume = result.PersistentVolume.Merge(persistentVolumeTopology)
-	//result.PersistentVolumeClaim = result.PersistentVolumeClaim.Merge(persistentVolumeClaimTopology)
-	//result.StorageClass = result.StorageClass.Merge(storageClassTopology)
+	result.PersistentVolume = result.PersistentVolume.Merge(persistentVolumeTopology)
+	result.PersistentVolumeClaim = result.PersistentVolumeClaim.Merge(persistentVolumeClaimTopology)
+	result.StorageClass = result.StorageClass.Merge(storageClassTopology)
 	//result.VolumeSnapshot = result.VolumeSnapshot.Merge(volumeSnapshotTopology)
 	//result.VolumeSnapshotData = result.VolumeSnapshotData.Merge(volumeSnapshotDataTopology)
 	result.Job = result.Job.Merge(jobTopology)
+	result.Ingress = result.Ingress.Merge(ingressTopology)
+	result.CustomResource = result.CustomResource.Merge(customResourceTopology)
+	result.Host = result.Host.Merge(hostTopology)
 	return result, nil
 }
 
@@ -429,36 +713,173 @@ func (r *Reporter) kubernetesClusterTopology() (report.Topology, error) {
 	return result, nil
 }
 
-func (r *Reporter) serviceTopology() (report.Topology, []Service, error) {
+// cloudProvider returns the cloud provider the cluster runs on, or "" if
+// it wasn't detected.
+func (r *Reporter) cloudProvider() string {
+	for _, n := range r.k8sClusterTopology.Nodes {
+		if cloudProvider, ok := n.Latest.Lookup(report.CloudProvider); ok {
+			return cloudProvider
+		}
+	}
+	return ""
+}
+
+// clusterTopology returns the cluster node, with the cloud regions of its
+// kubernetes nodes as parents.  A cluster can span several regions.
+func (r *Reporter) clusterTopology(regions report.StringSet) report.Topology {
+	result := r.k8sClusterTopology.Copy()
+	if len(regions) == 0 {
+		return result
+	}
+	for id, n := range result.Nodes {
+		result.Nodes[id] = n.WithParents(n.Parents.Add(report.CloudRegion, regions))
+	}
+	return result
+}
+
+func (r *Reporter) ingressTopology() (report.Topology, []Ingress, error) {
+	var (
+		result = report.MakeTopology().
+			WithMetadataTemplates(IngressMetadataTemplates).
+			WithTableTemplates(IngressTableTemplates)
+		ingresses = []Ingress{}
+	)
+	err := r.client.WalkIngresses(func(i Ingress) error {
+		result.AddNode(i.GetNode(r.probeID))
+		ingresses = append(ingresses, i)
+		return nil
+	})
+	return result, ingresses, err
+}
+
+func (r *Reporter) customResourceTopology() (report.Topology, error) {
+	result := report.MakeTopology().
+		WithMetadataTemplates(CustomResourceMetadataTemplates).
+		WithTableTemplates(CustomResourceTableTemplates)
+	err := r.client.WalkCustomResources(func(c CustomResource) error {
+		result.AddNode(c.GetNode(r.probeID))
+		return nil
+	})
+	return result, err
+}
+
+// withCustomResourceParents adds the custom resources which own pods,
+// directly or through their controllers, as parents of the pods.
+func (r *Reporter) withCustomResourceParents(pods, customResources report.Topology) (report.Topology, error) {
+	if len(customResources.Nodes) == 0 {
+		return pods, nil
+	}
+	resources := map[string]struct{}{}
+	for id := range customResources.Nodes {
+		if uid, ok := report.ParseCustomResourceNodeID(id); ok {
+			resources[uid] = struct{}{}
+		}
+	}
+	owners := ownerGraph{}
+	err := r.client.WalkOwnerReferences(func(uid string, refs []metav1.OwnerReference) error {
+		owners.add(uid, refs)
+		return nil
+	})
+	if err != nil {
+		return pods, err
+	}
+	for id, node := range pods.Nodes {
+		uid, ok := report.ParsePodNodeID(id)
+		if !ok {
+			continue
+		}
+		for _, owner := range owners.customResources(uid, resources) {
+			node = node.WithParent(report.CustomResource, report.MakeCustomResourceNodeID(owner))
+		}
+		pods.Nodes[id] = node
+	}
+	return pods, nil
+}
+
+func (r *Reporter) serviceTopology(ingresses []Ingress) (report.Topology, []Service, error) {
 	var (
 		result = report.MakeTopology().
 			WithMetadataTemplates(ServiceMetadataTemplates).
 			WithMetricTemplates(ServiceMetricTemplates).
 			WithTableTemplates(TableTemplates)
 		services = []Service{}
+		// ingresses routing to each service, by namespace/name
+		backends = map[string][]string{}
 	)
+	for _, i := range ingresses {
+		for _, name := range i.BackendServices() {
+			key := i.Namespace() + "/" + name
+			backends[key] = append(backends[key], report.MakeIngressNodeID(i.UID()))
+		}
+	}
 	//result.Controls.AddControl(DescribeControl)
 	err := r.client.WalkServices(func(s Service) error {
-		result.AddNode(s.GetNode(r.probeID))
+		node := s.GetNode(r.probeID)
+		if ingressIDs, ok := backends[s.Namespace()+"/"+s.Name()]; ok {
+			node = node.WithLatests(map[string]string{ExternallyExposed: "true"})
+			for _, id := range ingressIDs {
+				node = node.WithParent(report.Ingress, id)
+			}
+		}
+		result.AddNode(node)
 		services = append(services, s)
 		return nil
 	})
 	return result, services, err
 }
 
+// markExposedPods marks pods backing an externally exposed service as
+// exposed themselves.
+func markExposedPods(pods, services report.Topology) report.Topology {
+	exposed := map[string]struct{}{}
+	for id, n := range services.Nodes {
+		if value, ok := n.Latest.Lookup(ExternallyExposed); ok && value == "true" {
+			exposed[id] = struct{}{}
+		}
+	}
+	if len(exposed) == 0 {
+		return pods
+	}
+	for id, n := range pods.Nodes {
+		serviceIDs, _ := n.Parents.Lookup(report.Service)
+		for _, serviceID := range serviceIDs {
+			if _, ok := exposed[serviceID]; ok {
+				pods.Nodes[id] = n.WithLatests(map[string]string{ExternallyExposed: "true"})
+				break
+			}
+		}
+	}
+	return pods
+}
+
 func (r *Reporter) deploymentTopology() (report.Topology, []Deployment, error) {
 	var (
 		result = report.MakeTopology().
 			WithMetadataTemplates(DeploymentMetadataTemplates).
 			WithMetricTemplates(DeploymentMetricTemplates).
-			WithTableTemplates(TableTemplates)
+			WithTableTemplates(TableTemplates.Merge(WarningEventTableTemplates))
 		deployments = []Deployment{}
 	)
 	result.Controls.AddControls(ScalingControls)
 	//result.Controls.AddControl(DescribeControl)
 
-	err := r.client.WalkDeployments(func(d Deployment) error {
-		result.AddNode(d.GetNode(r.probeID))
+	autoscalers := map[string]HorizontalPodAutoscaler{}
+	err := r.client.WalkHorizontalPodAutoscalers(func(h HorizontalPodAutoscaler) error {
+		if kind, name := h.ScaleTarget(); kind == "Deployment" {
+			autoscalers[h.Namespace()+"/"+name] = h
+		}
+		return nil
+	})
+	if err != nil {
+		return result, deployments, err
+	}
+
+	err = r.client.WalkDeployments(func(d Deployment) error {
+		node := d.GetNode(r.probeID)
+		if h, ok := autoscalers[d.Namespace()+"/"+d.Name()]; ok {
+			node = h.Stamp(node)
+		}
+		result.AddNode(node)
 		deployments = append(deployments, d)
 		return nil
 	})
@@ -536,8 +957,23 @@ func (r *Reporter) persistentVolumeClaimTopology() (report.Topology, []Persisten
 	//	Rank:  0,
 	//})
 	//result.Controls.AddControl(DescribeControl)
-	err := r.client.WalkPersistentVolumeClaims(func(p PersistentVolumeClaim) error {
-		result.AddNode(p.GetNode(r.probeID))
+	// Claims used by no pod are still reported, flagged as such, so that
+	// orphaned storage can be found.
+	used := map[string]struct{}{}
+	err := r.client.WalkPods(func(p Pod) error {
+		for _, claimName := range p.VolumeClaimNames() {
+			used[p.Namespace()+"/"+claimName] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, persistentVolumeClaims, err
+	}
+	err = r.client.WalkPersistentVolumeClaims(func(p PersistentVolumeClaim) error {
+		_, inUse := used[p.Namespace()+"/"+p.Name()]
+		result.AddNode(p.GetNode(r.probeID).WithLatests(map[string]string{
+			VolumeInUse: strconv.FormatBool(inUse),
+		}))
 		persistentVolumeClaims = append(persistentVolumeClaims, p)
 		return nil
 	})
@@ -628,27 +1064,32 @@ func match(namespace string, selector labels.Selector, topology, id string) func
 	}
 }
 
-func (r *Reporter) podTopology(services []Service, deployments []Deployment, daemonSets []DaemonSet, statefulSets []StatefulSet, cronJobs []CronJob, jobs []Job) (report.Topology, error) {
+func (r *Reporter) networkPolicies() ([]NetworkPolicy, error) {
+	policies := []NetworkPolicy{}
+	err := r.client.WalkNetworkPolicies(func(p NetworkPolicy) error {
+		policies = append(policies, p)
+		return nil
+	})
+	return policies, err
+}
+
+func (r *Reporter) podTopology(services []Service, deployments []Deployment, daemonSets []DaemonSet, statefulSets []StatefulSet, jobs []Job, networkPolicies []NetworkPolicy, claims []PersistentVolumeClaim) (report.Topology, error) {
 	var (
 		pods = report.MakeTopology().
 			WithMetadataTemplates(PodMetadataTemplates).
 			WithMetricTemplates(PodMetricTemplates).
-			WithTableTemplates(TableTemplates)
+			WithTableTemplates(PodTableTemplates)
 		selectors = []func(labelledChild){}
 	)
-	//pods.Controls.AddControl(report.Control{
-	//	ID:    GetLogs,
-	//	Human: "Get logs",
-	//	Icon:  "fa fa-desktop",
-	//	Rank:  0,
-	//})
-	//pods.Controls.AddControl(report.Control{
-	//	ID:           DeletePod,
-	//	Human:        "Delete",
-	//	Icon:         "far fa-trash-alt",
-	//	Confirmation: "Are you sure you want to delete this pod?",
-	//	Rank:         3,
-	//})
+	activeControls := []string{}
+	if r.canGetLogs() {
+		pods.Controls.AddControl(getLogsControl)
+		activeControls = append(activeControls, GetLogs)
+	}
+	if r.controlsEnabled {
+		pods.Controls.AddControl(deletePodControl)
+		activeControls = append(activeControls, DeletePod)
+	}
 	//pods.Controls.AddControl(DescribeControl)
 	for _, service := range services {
 		selectors = append(selectors, match(
@@ -694,34 +1135,50 @@ func (r *Reporter) podTopology(services []Service, deployments []Deployment, dae
 			report.MakeStatefulSetNodeID(statefulSet.UID()),
 		))
 	}
-	for _, cronJob := range cronJobs {
-		cronJobSelectors, err := cronJob.Selectors()
+	for _, job := range jobs {
+		selector, err := job.Selector()
 		if err != nil {
 			return pods, err
 		}
-		for _, selector := range cronJobSelectors {
-			selectors = append(selectors, match(
-				cronJob.Namespace(),
-				selector,
-				report.CronJob,
-				report.MakeCronJobNodeID(cronJob.UID()),
-			))
-		}
-		for _, job := range jobs {
-			selector, err := job.Selector()
-			if err != nil {
-				return pods, err
-			}
+		selectors = append(selectors, match(
+			job.Namespace(),
+			selector,
+			report.Job,
+			report.MakeJobNodeID(job.UID()),
+		))
+		// Pods of jobs created by a cron job belong to it too, so they can be
+		// grouped under it even once the job is gone.
+		if cronJobUID := job.CronJobUID(); cronJobUID != "" {
 			selectors = append(selectors, match(
 				job.Namespace(),
 				selector,
-				report.Job,
-				report.MakeJobNodeID(job.UID()),
+				report.CronJob,
+				report.MakeCronJobNodeID(cronJobUID),
 			))
 		}
 	}
 
-	err := r.client.WalkPods(func(p Pod) error {
+	policySelectors, err := makeNetworkPolicySelectors(networkPolicies)
+	if err != nil {
+		return pods, err
+	}
+
+	claimIDs := map[string]string{}
+	for _, claim := range claims {
+		claimIDs[claim.Namespace()+"/"+claim.Name()] = report.MakePersistentVolumeClaimNodeID(claim.UID())
+	}
+
+	serviceAccounts := map[string]*apiv1.ServiceAccount{}
+	err = r.client.WalkServiceAccounts(func(sa *apiv1.ServiceAccount) error {
+		serviceAccounts[serviceAccountKey(sa.Namespace, sa.Name)] = sa
+		return nil
+	})
+	if err != nil {
+		return pods, err
+	}
+
+	now := mtime.Now()
+	err = r.client.WalkPods(func(p Pod) error {
 		// filter out non-local pods: we only want to report local ones for performance reasons.
 		//if r.nodeName != "" {
 		//	if p.NodeName() != r.nodeName {
@@ -731,16 +1188,122 @@ func (r *Reporter) podTopology(services []Service, deployments []Deployment, dae
 		for _, selector := range selectors {
 			selector(p)
 		}
-		pods.AddNode(p.GetNode(r.probeID))
+		for _, claimName := range p.VolumeClaimNames() {
+			if id, ok := claimIDs[p.Namespace()+"/"+claimName]; ok {
+				p.AddParent(report.PersistentVolumeClaim, id)
+			}
+		}
+		node := withNetworkPolicies(p.GetNode(r.probeID), p, policySelectors)
+		node = withServiceAccount(node, p,
+			serviceAccounts[serviceAccountKey(p.Namespace(), p.ServiceAccountName())],
+			r.client.IsClusterAdmin(p.Namespace(), p.ServiceAccountName()))
+		node = node.WithLatests(p.Scheduling(r.pendingThreshold, now))
+		if len(activeControls) > 0 {
+			node = node.WithLatestActiveControls(activeControls...)
+		}
+		pods.AddNode(node)
 		return nil
 	})
 	return pods, err
 }
 
+// hostTopology reports the status of our own kubernetes node, to be merged
+// into the host node of the same name: the probes on the other nodes report
+// theirs.  Without a node of our own, as when reporting clusters from
+// outside, all of them are reported.  It also returns the cloud regions of
+// all the nodes, which the cluster spans.
+func (r *Reporter) hostTopology() (report.Topology, report.StringSet, error) {
+	result := report.MakeTopology().
+		WithMetadataTemplates(HostMetadataTemplates).
+		WithTableTemplates(HostTableTemplates)
+	if r.controlsEnabled {
+		result.Controls.AddControl(cordonControl)
+		result.Controls.AddControl(uncordonControl)
+	}
+	regions := report.MakeStringSet()
+	cloudProvider := r.cloudProvider()
+	err := r.client.WalkNodes(func(n NodeResource) error {
+		var regionID string
+		if region := n.Region(); region != "" && cloudProvider != "" {
+			regionID = report.MakeProviderRegionNodeID(cloudProvider, region)
+			regions = regions.Add(regionID)
+		}
+		if r.nodeName != "" && n.Name() != r.nodeName {
+			return nil
+		}
+		node := n.GetNode().WithParent(report.KubernetesCluster, kubernetesClusterNodeId)
+		if regionID != "" {
+			node = node.WithParent(report.CloudRegion, regionID)
+		}
+		// Controls on a host are routed to the probe running there
+		if r.controlsEnabled && n.Name() == r.nodeName {
+			if n.Unschedulable() {
+				node = node.WithLatestActiveControls(UncordonNode)
+			} else {
+				node = node.WithLatestActiveControls(CordonNode)
+			}
+		}
+		result.AddNode(node)
+		return nil
+	})
+	return result, regions, err
+}
+
+func (r *Reporter) events() ([]*apiv1.Event, error) {
+	events := []*apiv1.Event{}
+	err := r.client.WalkEvents(func(e *apiv1.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
 func (r *Reporter) namespaceTopology() (report.Topology, error) {
-	result := report.MakeTopology()
-	err := r.client.WalkNamespaces(func(ns NamespaceResource) error {
-		result.AddNode(ns.GetNode())
+	result := report.MakeTopology().
+		WithMetadataTemplates(NamespaceMetadataTemplates).
+		WithTableTemplates(NamespaceTableTemplates)
+
+	states := map[string]map[string]string{}
+	counts := map[string]map[string]int{}
+	err := r.client.WalkPods(func(p Pod) error {
+		if counts[p.Namespace()] == nil {
+			counts[p.Namespace()] = map[string]int{}
+		}
+		counts[p.Namespace()][p.State()]++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	for namespace, byState := range counts {
+		states[namespace] = map[string]string{}
+		for state, count := range byState {
+			states[namespace][state] = strconv.Itoa(count)
+		}
+	}
+
+	quotas := map[string][]*apiv1.ResourceQuota{}
+	err = r.client.WalkResourceQuotas(func(q *apiv1.ResourceQuota) error {
+		quotas[q.Namespace] = append(quotas[q.Namespace], q)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	limitRanges := map[string][]*apiv1.LimitRange{}
+	err = r.client.WalkLimitRanges(func(l *apiv1.LimitRange) error {
+		limitRanges[l.Namespace] = append(limitRanges[l.Namespace], l)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = r.client.WalkNamespaces(func(ns NamespaceResource) error {
+		result.AddNode(ns.GetNode().
+			AddPrefixPropertyList(PodPhasePrefix, states[ns.Name()]).
+			AddPrefixMulticolumnTable(ResourceQuotaPrefix, resourceQuotaRows(quotas[ns.Name()])).
+			AddPrefixMulticolumnTable(LimitRangePrefix, limitRangeRows(limitRanges[ns.Name()])))
 		return nil
 	})
 	return result, err
@@ -3,14 +3,25 @@ package kubernetes_test
 import (
 	"fmt"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	apiv1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	k8smeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/controls"
@@ -107,6 +118,7 @@ func newMockClient() *mockClient {
 	return &mockClient{
 		pods:     []kubernetes.Pod{pod1, pod2},
 		services: []kubernetes.Service{service1},
+		cordoned: map[string]bool{},
 		logs:     map[string]io.ReadCloser{},
 	}
 }
@@ -115,10 +127,36 @@ type mockClient struct {
 	pods        []kubernetes.Pod
 	services    []kubernetes.Service
 	deployments []kubernetes.Deployment
+	cronJobs    []kubernetes.CronJob
+	jobs        []kubernetes.Job
+	ingresses   []kubernetes.Ingress
+	policies    []kubernetes.NetworkPolicy
+	nodes       []kubernetes.NodeResource
+	autoscalers []kubernetes.HorizontalPodAutoscaler
+	volumes     []kubernetes.PersistentVolume
+	claims      []kubernetes.PersistentVolumeClaim
+	namespaces  []kubernetes.NamespaceResource
+	quotas      []*apiv1.ResourceQuota
+	limitRanges []*apiv1.LimitRange
+	events      []*apiv1.Event
+	accounts    []*apiv1.ServiceAccount
+	admins      map[string]bool
+	resources   []kubernetes.CustomResource
+	owners      map[string][]metav1.OwnerReference
+	deleted     []string
+	cordoned    map[string]bool
+	controlErr  error
+	logsDenied  bool
 	logs        map[string]io.ReadCloser
+	logRequests []logRequest
 }
 
 func (c *mockClient) WalkNodes(f func(kubernetes.NodeResource) error) error {
+	for _, node := range c.nodes {
+		if err := f(node); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -154,6 +192,11 @@ func (c *mockClient) WalkStatefulSets(f func(kubernetes.StatefulSet) error) erro
 	return nil
 }
 func (c *mockClient) WalkCronJobs(f func(kubernetes.CronJob) error) error {
+	for _, cronJob := range c.cronJobs {
+		if err := f(cronJob); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 func (c *mockClient) WalkDeployments(f func(kubernetes.Deployment) error) error {
@@ -165,12 +208,78 @@ func (c *mockClient) WalkDeployments(f func(kubernetes.Deployment) error) error
 	return nil
 }
 func (c *mockClient) WalkNamespaces(f func(kubernetes.NamespaceResource) error) error {
+	for _, ns := range c.namespaces {
+		if err := f(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkResourceQuotas(f func(*apiv1.ResourceQuota) error) error {
+	for _, q := range c.quotas {
+		if err := f(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkEvents(f func(*apiv1.Event) error) error {
+	for _, e := range c.events {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkServiceAccounts(f func(*apiv1.ServiceAccount) error) error {
+	for _, sa := range c.accounts {
+		if err := f(sa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) IsClusterAdmin(namespace, serviceAccount string) bool {
+	return c.admins[namespace+"/"+serviceAccount]
+}
+func (c *mockClient) WalkCustomResources(f func(kubernetes.CustomResource) error) error {
+	for _, r := range c.resources {
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkOwnerReferences(f func(string, []metav1.OwnerReference) error) error {
+	for uid, owners := range c.owners {
+		if err := f(uid, owners); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkLimitRanges(f func(*apiv1.LimitRange) error) error {
+	for _, l := range c.limitRanges {
+		if err := f(l); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 func (c *mockClient) WalkPersistentVolumes(f func(kubernetes.PersistentVolume) error) error {
+	for _, volume := range c.volumes {
+		if err := f(volume); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 func (c *mockClient) WalkPersistentVolumeClaims(f func(kubernetes.PersistentVolumeClaim) error) error {
+	for _, claim := range c.claims {
+		if err := f(claim); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 func (c *mockClient) WalkStorageClasses(f func(kubernetes.StorageClass) error) error {
@@ -183,17 +292,66 @@ func (c *mockClient) WalkVolumeSnapshotData(f func(kubernetes.VolumeSnapshotData
 	return nil
 }
 func (c *mockClient) WalkJobs(f func(kubernetes.Job) error) error {
+	for _, job := range c.jobs {
+		if err := f(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkIngresses(f func(kubernetes.Ingress) error) error {
+	for _, ingress := range c.ingresses {
+		if err := f(ingress); err != nil {
+			return err
+		}
+	}
 	return nil
 }
-func (*mockClient) WatchPods(func(kubernetes.Event, kubernetes.Pod)) {}
-func (c *mockClient) GetLogs(namespaceID, podName string, _ []string) (io.ReadCloser, error) {
+func (c *mockClient) WalkNetworkPolicies(f func(kubernetes.NetworkPolicy) error) error {
+	for _, policy := range c.policies {
+		if err := f(policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *mockClient) WalkHorizontalPodAutoscalers(f func(kubernetes.HorizontalPodAutoscaler) error) error {
+	for _, h := range c.autoscalers {
+		if err := f(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (*mockClient) WatchPods(func(kubernetes.Event, kubernetes.Pod))                      {}
+func (*mockClient) WatchNetworkPolicies(func(kubernetes.Event, kubernetes.NetworkPolicy)) {}
+func (c *mockClient) GetLogs(namespaceID, podName string, containerNames []string, options apiv1.PodLogOptions) (io.ReadCloser, error) {
 	r, ok := c.logs[namespaceID+";"+podName]
 	if !ok {
 		return nil, fmt.Errorf("Not found")
 	}
+	c.logRequests = append(c.logRequests, logRequest{containerNames, options})
 	return r, nil
 }
-func (c *mockClient) DeletePod(namespaceID, podID string) error {
+func (c *mockClient) CanI(verb, resource, subresource string) (bool, error) {
+	return !c.logsDenied, nil
+}
+func (c *mockClient) DeletePod(namespaceID, podID string, gracePeriodSeconds *int64) error {
+	if c.controlErr != nil {
+		return c.controlErr
+	}
+	deleted := namespaceID + "/" + podID
+	if gracePeriodSeconds != nil {
+		deleted += fmt.Sprintf(" (%ds)", *gracePeriodSeconds)
+	}
+	c.deleted = append(c.deleted, deleted)
+	return nil
+}
+func (c *mockClient) CordonNode(name string, cordon bool) error {
+	if c.controlErr != nil {
+		return c.controlErr
+	}
+	c.cordoned[name] = cordon
 	return nil
 }
 func (c *mockClient) ScaleUp(namespaceID, id string) error {
@@ -215,6 +373,21 @@ func (c *mockClient) Describe(namespaceID, resourceID string, groupKind schema.G
 	return nil, nil
 }
 
+type logRequest struct {
+	containerNames []string
+	options        apiv1.PodLogOptions
+}
+
+type mockLogs struct {
+	io.Reader
+	closed bool
+}
+
+func (l *mockLogs) Close() error {
+	l.closed = true
+	return nil
+}
+
 type mockPipeClient map[string]xfer.Pipe
 
 func (c mockPipeClient) PipeConnection(appID, id string, pipe xfer.Pipe) error {
@@ -233,7 +406,7 @@ func TestReporter(t *testing.T) {
 	pod2ID := report.MakePodNodeID(pod2UID)
 	serviceID := report.MakeServiceNodeID(serviceUID)
 	hr := controls.NewDefaultHandlerRegistry()
-	rpt, _ := kubernetes.NewReporter(newMockClient(), nil, "probe-id", "foo", nil, hr, nodeName).Report()
+	rpt, _ := kubernetes.NewReporter(newMockClient(), nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
 
 	// Reporter should have added the following pods
 	for _, pod := range []struct {
@@ -312,6 +485,1129 @@ func TestReporter(t *testing.T) {
 
 }
 
+func TestReporterJobs(t *testing.T) {
+	cronJobUID := types.UID("cronjob1234")
+	apiCronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nightly",
+			UID:       cronJobUID,
+			Namespace: "ping",
+		},
+		Spec: batchv1beta1.CronJobSpec{Schedule: "0 3 * * *"},
+	}
+	makeJob := func(name string, selector map[string]string, conditions ...batchv1.JobCondition) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				UID:       types.UID(name),
+				Namespace: "ping",
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "CronJob", Name: apiCronJob.Name, UID: cronJobUID},
+				},
+			},
+			Spec: batchv1.JobSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: selector},
+			},
+			Status: batchv1.JobStatus{Conditions: conditions},
+		}
+	}
+	running := makeJob("nightly-3", map[string]string{"ponger": "true"})
+	running.Status.Active = 2
+	succeeded := makeJob("nightly-2", map[string]string{"job": "nightly-2"},
+		batchv1.JobCondition{Type: batchv1.JobComplete, Status: apiv1.ConditionTrue})
+	failed := makeJob("nightly-1", map[string]string{"job": "nightly-1"},
+		batchv1.JobCondition{Type: batchv1.JobFailed, Status: apiv1.ConditionTrue})
+
+	mockK8s := newMockClient()
+	mockK8s.jobs = []kubernetes.Job{kubernetes.NewJob(running), kubernetes.NewJob(succeeded), kubernetes.NewJob(failed)}
+	mockK8s.cronJobs = []kubernetes.CronJob{kubernetes.NewCronJob(&apiCronJob, map[types.UID]*batchv1.Job{
+		running.UID:   running,
+		succeeded.UID: succeeded,
+		failed.UID:    failed,
+	})}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	cronJobID := report.MakeCronJobNodeID(string(cronJobUID))
+	jobID := report.MakeJobNodeID(string(running.UID))
+
+	// Pods are owned by their job, and through it by the cron job
+	for _, podID := range []string{report.MakePodNodeID(pod1UID), report.MakePodNodeID(pod2UID)} {
+		node := rpt.Pod.Nodes[podID]
+		if parents, ok := node.Parents.Lookup(report.Job); !ok || !parents.Contains(jobID) {
+			t.Errorf("Expected pod %s to have parent job %q, got %q", podID, jobID, parents)
+		}
+		if parents, ok := node.Parents.Lookup(report.CronJob); !ok || !parents.Contains(cronJobID) {
+			t.Errorf("Expected pod %s to have parent cron job %q, got %q", podID, cronJobID, parents)
+		}
+	}
+
+	{
+		node, ok := rpt.Job.Nodes[jobID]
+		if !ok {
+			t.Fatalf("Expected report to have job %q, but not found", jobID)
+		}
+		if parents, ok := node.Parents.Lookup(report.CronJob); !ok || !parents.Contains(cronJobID) {
+			t.Errorf("Expected job %s to have parent cron job %q, got %q", jobID, cronJobID, parents)
+		}
+		if have, ok := node.Latest.Lookup(kubernetes.ActivePods); !ok || have != "2" {
+			t.Errorf("Expected job %s to have 2 active pods, got %q", jobID, have)
+		}
+		if _, ok := node.Latest.Lookup(kubernetes.CompletionTime); ok {
+			t.Errorf("Expected running job %s not to have a completion time", jobID)
+		}
+	}
+
+	{
+		node, ok := rpt.CronJob.Nodes[cronJobID]
+		if !ok {
+			t.Fatalf("Expected report to have cron job %q, but not found", cronJobID)
+		}
+		for k, want := range map[string]string{
+			kubernetes.Schedule:      "0 3 * * *",
+			kubernetes.ActiveJobs:    "1",
+			kubernetes.SucceededJobs: "1",
+			kubernetes.FailedJobs:    "1",
+		} {
+			if have, ok := node.Latest.Lookup(k); !ok || have != want {
+				t.Errorf("Expected cron job %s latest %q: %q, got %q", cronJobID, k, want, have)
+			}
+		}
+	}
+}
+
+func TestReporterIngress(t *testing.T) {
+	internalService := apiService1
+	internalService.Spec = apiv1.ServiceSpec{
+		Type:      apiv1.ServiceTypeClusterIP,
+		ClusterIP: "10.0.1.1",
+		Selector:  map[string]string{"ponger": "true"},
+	}
+	internalService.Status = apiv1.ServiceStatus{}
+	nodePortService := apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "admin",
+			UID:       types.UID("service5678"),
+			Namespace: "ping",
+		},
+		Spec: apiv1.ServiceSpec{
+			Type:  apiv1.ServiceTypeNodePort,
+			Ports: []apiv1.ServicePort{{Protocol: "TCP", Port: 8080, NodePort: 30080}},
+		},
+	}
+	apiIngress := extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pong",
+			UID:       types.UID("ingress1234"),
+			Namespace: "ping",
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			TLS: []extensionsv1beta1.IngressTLS{{Hosts: []string{"*.example.com"}}},
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "api.example.com",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+						Paths: []extensionsv1beta1.HTTPIngressPath{{
+							Path:    "/v1",
+							Backend: extensionsv1beta1.IngressBackend{ServiceName: "pongservice", ServicePort: intstr.FromInt(6379)},
+						}},
+					}},
+				},
+				{
+					// Not covered by the wildcard certificate, which only
+					// matches a single label.
+					Host: "a.b.example.com",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+						Paths: []extensionsv1beta1.HTTPIngressPath{{
+							Path:    "/",
+							Backend: extensionsv1beta1.IngressBackend{ServiceName: "pongservice", ServicePort: intstr.FromString("redis")},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	mockK8s := newMockClient()
+	mockK8s.services = []kubernetes.Service{kubernetes.NewService(&internalService), kubernetes.NewService(&nodePortService)}
+	hr := controls.NewDefaultHandlerRegistry()
+
+	// Without the ingress, nothing is exposed
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+	serviceID := report.MakeServiceNodeID(serviceUID)
+	pod1ID := report.MakePodNodeID(pod1UID)
+	if _, ok := rpt.Service.Nodes[serviceID].Latest.Lookup(kubernetes.ExternallyExposed); ok {
+		t.Errorf("Expected ClusterIP service not to be externally exposed")
+	}
+	if _, ok := rpt.Pod.Nodes[pod1ID].Latest.Lookup(kubernetes.ExternallyExposed); ok {
+		t.Errorf("Expected pod not to be externally exposed")
+	}
+	{
+		node := rpt.Service.Nodes[report.MakeServiceNodeID("service5678")]
+		for k, want := range map[string]string{
+			kubernetes.ExternallyExposed: "true",
+			kubernetes.NodePorts:         "30080",
+		} {
+			if have, ok := node.Latest.Lookup(k); !ok || have != want {
+				t.Errorf("Expected NodePort service latest %q: %q, got %q", k, want, have)
+			}
+		}
+	}
+
+	mockK8s.ingresses = []kubernetes.Ingress{kubernetes.NewIngress(&apiIngress)}
+	rpt, _ = kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+	ingressID := report.MakeIngressNodeID("ingress1234")
+
+	node, ok := rpt.Ingress.Nodes[ingressID]
+	if !ok {
+		t.Fatalf("Expected report to have ingress %q, but not found", ingressID)
+	}
+	for k, want := range map[string]string{
+		kubernetes.IngressHosts:    "a.b.example.com,api.example.com",
+		kubernetes.IngressTLSHosts: "*.example.com",
+		kubernetes.IngressBackends: "pongservice",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected ingress latest %q: %q, got %q", k, want, have)
+		}
+	}
+	rules := node.ExtractMulticolumnTable(report.TableTemplate{
+		Prefix:  kubernetes.IngressRulePrefix,
+		Columns: []report.Column{{ID: kubernetes.IngressRuleBackend}, {ID: kubernetes.IngressRuleTLS}},
+	})
+	wantRules := map[string][2]string{
+		"api.example.com/v1": {"pongservice:6379", "true"},
+		"a.b.example.com/":   {"pongservice:redis", "false"},
+	}
+	if len(rules) != len(wantRules) {
+		t.Errorf("Expected %d ingress rules, got %v", len(wantRules), rules)
+	}
+	for _, row := range rules {
+		want := wantRules[row.ID]
+		if row.Entries[kubernetes.IngressRuleBackend] != want[0] || row.Entries[kubernetes.IngressRuleTLS] != want[1] {
+			t.Errorf("Expected ingress rule %q to have backend %q and TLS %q, got %v", row.ID, want[0], want[1], row.Entries)
+		}
+	}
+
+	// The service is exposed through the ingress, and so are its pods
+	if parents, ok := rpt.Service.Nodes[serviceID].Parents.Lookup(report.Ingress); !ok || !parents.Contains(ingressID) {
+		t.Errorf("Expected service to have parent ingress %q, got %q", ingressID, parents)
+	}
+	for _, n := range []report.Node{
+		rpt.Service.Nodes[serviceID],
+		rpt.Pod.Nodes[pod1ID],
+		rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)],
+	} {
+		if have, ok := n.Latest.Lookup(kubernetes.ExternallyExposed); !ok || have != "true" {
+			t.Errorf("Expected %s to be externally exposed, got %q", n.ID, have)
+		}
+	}
+}
+
+func TestReporterNetworkPolicies(t *testing.T) {
+	makePolicy := func(name, namespace string, selector metav1.LabelSelector, policyTypes ...networkingv1.PolicyType) kubernetes.NetworkPolicy {
+		return kubernetes.NewNetworkPolicy(&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name), Namespace: namespace},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: selector,
+				PolicyTypes: policyTypes,
+			},
+		})
+	}
+	pod1 := apiPod1
+	pod1.ObjectMeta.Labels = map[string]string{"ponger": "true", "tier": "db"}
+	pod2 := apiPod2
+	pod2.ObjectMeta.Labels = map[string]string{"ponger": "true", "tier": "web"}
+	pod1ID, pod2ID := report.MakePodNodeID(pod1UID), report.MakePodNodeID(pod2UID)
+
+	for _, tc := range []struct {
+		name     string
+		policies []kubernetes.NetworkPolicy
+		// policies expected per pod, and whether it is ingress/egress isolated
+		want map[string][]string
+	}{
+		{
+			name:     "no policies",
+			policies: nil,
+			want: map[string][]string{
+				pod1ID: {"false", "false"},
+				pod2ID: {"false", "false"},
+			},
+		},
+		{
+			name: "matchLabels",
+			policies: []kubernetes.NetworkPolicy{
+				makePolicy("db-ingress", "ping", metav1.LabelSelector{MatchLabels: map[string]string{"tier": "db"}}),
+			},
+			want: map[string][]string{
+				pod1ID: {"true", "false", "db-ingress"},
+				pod2ID: {"false", "false"},
+			},
+		},
+		{
+			name: "matchExpressions",
+			policies: []kubernetes.NetworkPolicy{
+				makePolicy("no-egress", "ping", metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}},
+				}}, networkingv1.PolicyTypeEgress),
+			},
+			want: map[string][]string{
+				pod1ID: {"false", "false"},
+				pod2ID: {"false", "true", "no-egress"},
+			},
+		},
+		{
+			name: "empty selector selects the whole namespace",
+			policies: []kubernetes.NetworkPolicy{
+				makePolicy("default-deny", "ping", metav1.LabelSelector{}, networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress),
+				makePolicy("other-namespace", "pong", metav1.LabelSelector{}),
+			},
+			want: map[string][]string{
+				pod1ID: {"true", "true", "default-deny"},
+				pod2ID: {"true", "true", "default-deny"},
+			},
+		},
+	} {
+		mockK8s := newMockClient()
+		mockK8s.pods = []kubernetes.Pod{kubernetes.NewPod(&pod1), kubernetes.NewPod(&pod2)}
+		mockK8s.policies = tc.policies
+		hr := controls.NewDefaultHandlerRegistry()
+		rpt, err := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		for podID, want := range tc.want {
+			node := rpt.Pod.Nodes[podID]
+			if have, _ := node.Latest.Lookup(kubernetes.IngressIsolated); have != want[0] {
+				t.Errorf("%s: expected pod %s to have ingress isolated %q, got %q", tc.name, podID, want[0], have)
+			}
+			if have, _ := node.Latest.Lookup(kubernetes.EgressIsolated); have != want[1] {
+				t.Errorf("%s: expected pod %s to have egress isolated %q, got %q", tc.name, podID, want[1], have)
+			}
+			rows := node.ExtractMulticolumnTable(kubernetes.PodTableTemplates[kubernetes.NetworkPolicyPrefix])
+			policies := []string{}
+			for _, row := range rows {
+				policies = append(policies, row.ID)
+			}
+			if !reflect.DeepEqual(want[2:], policies) {
+				t.Errorf("%s: expected pod %s to be selected by %v, got %v", tc.name, podID, want[2:], policies)
+			}
+		}
+	}
+}
+
+func TestReporterNodes(t *testing.T) {
+	apiNode := apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName, UID: types.UID("node1234")},
+		Spec: apiv1.NodeSpec{
+			Unschedulable: true,
+			Taints: []apiv1.Taint{
+				{Key: "node.kubernetes.io/memory-pressure", Effect: apiv1.TaintEffectNoSchedule},
+				{Key: "dedicated", Value: "db", Effect: apiv1.TaintEffectNoExecute},
+			},
+		},
+		Status: apiv1.NodeStatus{
+			Conditions: []apiv1.NodeCondition{
+				{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue},
+				{Type: apiv1.NodeMemoryPressure, Status: apiv1.ConditionTrue, Reason: "KubeletHasInsufficientMemory"},
+				{Type: apiv1.NodeDiskPressure, Status: apiv1.ConditionFalse},
+			},
+			Capacity: apiv1.ResourceList{
+				apiv1.ResourceMemory: resource.MustParse("8Gi"),
+				apiv1.ResourcePods:   resource.MustParse("110"),
+			},
+			Allocatable: apiv1.ResourceList{
+				apiv1.ResourceMemory: resource.MustParse("7Gi"),
+				apiv1.ResourcePods:   resource.MustParse("110"),
+			},
+			NodeInfo: apiv1.NodeSystemInfo{
+				KubeletVersion:          "v1.13.1",
+				ContainerRuntimeVersion: "docker://18.9.0",
+			},
+		},
+	}
+	mockK8s := newMockClient()
+	mockK8s.nodes = []kubernetes.NodeResource{kubernetes.NewNodeResource(&apiNode)}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	hostID := report.MakeHostNodeID(nodeName)
+	node, ok := rpt.Host.Nodes[hostID]
+	if !ok {
+		t.Fatalf("Expected report to have host %q, but not found", hostID)
+	}
+	for k, want := range map[string]string{
+		kubernetes.NodeReady:      "True",
+		kubernetes.MemoryPressure: "True",
+		kubernetes.DiskPressure:   "False",
+		kubernetes.Unschedulable:  "true",
+		kubernetes.Taints:         "node.kubernetes.io/memory-pressure:NoSchedule, dedicated=db:NoExecute",
+		kubernetes.KubeletVersion: "v1.13.1",
+		kubernetes.RuntimeVersion: "docker://18.9.0",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected host latest %q: %q, got %q", k, want, have)
+		}
+	}
+	if _, ok := node.Latest.Lookup(kubernetes.PIDPressure); ok {
+		t.Errorf("Expected host not to have a PID pressure condition")
+	}
+
+	conditions := node.ExtractMulticolumnTable(kubernetes.HostTableTemplates[kubernetes.NodeConditionPrefix])
+	if len(conditions) != 3 {
+		t.Errorf("Expected 3 node conditions, got %v", conditions)
+	}
+	resources := node.ExtractMulticolumnTable(kubernetes.HostTableTemplates[kubernetes.NodeResourcePrefix])
+	want := []report.Row{
+		{ID: "memory", Entries: map[string]string{
+			kubernetes.NodeResourceCapacity:    "8Gi",
+			kubernetes.NodeResourceAllocatable: "7Gi",
+		}},
+		{ID: "pods", Entries: map[string]string{
+			kubernetes.NodeResourceCapacity:    "110",
+			kubernetes.NodeResourceAllocatable: "110",
+		}},
+	}
+	if !reflect.DeepEqual(want, resources) {
+		t.Errorf("Expected node resources %v, got %v", want, resources)
+	}
+}
+
+func TestReporterCloudRegions(t *testing.T) {
+	node := func(name string, labels map[string]string) kubernetes.NodeResource {
+		return kubernetes.NewNodeResource(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}})
+	}
+	mockK8s := newMockClient()
+	mockK8s.nodes = []kubernetes.NodeResource{
+		node(nodeName, map[string]string{"topology.kubernetes.io/region": "us-east-1"}),
+		node("b", map[string]string{"failure-domain.beta.kubernetes.io/region": "us-west-2"}),
+		node("c", nil),
+	}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	if len(rpt.KubernetesCluster.Nodes) != 1 {
+		t.Fatalf("Expected a single cluster node, got %v", rpt.KubernetesCluster.Nodes)
+	}
+	var cluster report.Node
+	for _, n := range rpt.KubernetesCluster.Nodes {
+		cluster = n
+	}
+	cloudProvider, _ := cluster.Latest.Lookup(report.CloudProvider)
+	east := report.MakeProviderRegionNodeID(cloudProvider, "us-east-1")
+	west := report.MakeProviderRegionNodeID(cloudProvider, "us-west-2")
+
+	// Only our own node is reported as a host, the probes on the others
+	// report theirs
+	if len(rpt.Host.Nodes) != 1 {
+		t.Fatalf("Expected a single host, got %v", rpt.Host.Nodes)
+	}
+	host := rpt.Host.Nodes[report.MakeHostNodeID(nodeName)]
+	if have, _ := host.Parents.Lookup(report.CloudRegion); !reflect.DeepEqual(report.MakeStringSet(east), have) {
+		t.Errorf("Expected host to have regions %v, got %v", []string{east}, have)
+	}
+	if have, _ := host.Parents.Lookup(report.KubernetesCluster); !have.Contains(cluster.ID) {
+		t.Errorf("Expected host to have parent cluster %q, got %v", cluster.ID, have)
+	}
+	if have, _ := cluster.Parents.Lookup(report.CloudRegion); !reflect.DeepEqual(report.MakeStringSet(east, west), have) {
+		t.Errorf("Expected cluster to have regions %v, got %v", []string{east, west}, have)
+	}
+}
+
+func TestReporterResources(t *testing.T) {
+	resources := apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("250m"),
+			apiv1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Limits: apiv1.ResourceList{
+			apiv1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+	podSpec := apiv1.PodSpec{
+		NodeName: nodeName,
+		Containers: []apiv1.Container{
+			{Name: "app", Resources: resources},
+			{Name: "sidecar", Resources: resources},
+		},
+	}
+	apiPod := apiPod1
+	apiPod.Spec = podSpec
+	replicas := int32(3)
+	apiDeployment := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pong", Namespace: "ping", UID: types.UID("deployment1234")},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"ponger": "true"}},
+			Template: apiv1.PodTemplateSpec{Spec: podSpec},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 3},
+	}
+	minReplicas, utilization, currentUtilization := int32(2), int32(80), int32(45)
+	lastScale := metav1.NewTime(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC))
+	apiHPA := autoscalingv2beta2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "pong-hpa", Namespace: "ping"},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{Kind: "Deployment", Name: "pong"},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    10,
+			Metrics: []autoscalingv2beta2.MetricSpec{
+				{
+					Type: autoscalingv2beta2.ResourceMetricSourceType,
+					Resource: &autoscalingv2beta2.ResourceMetricSource{
+						Name:   apiv1.ResourceCPU,
+						Target: autoscalingv2beta2.MetricTarget{Type: autoscalingv2beta2.UtilizationMetricType, AverageUtilization: &utilization},
+					},
+				},
+				{
+					Type: autoscalingv2beta2.PodsMetricSourceType,
+					Pods: &autoscalingv2beta2.PodsMetricSource{
+						Metric: autoscalingv2beta2.MetricIdentifier{Name: "requests_per_second"},
+						Target: autoscalingv2beta2.MetricTarget{Type: autoscalingv2beta2.AverageValueMetricType, AverageValue: resource.NewQuantity(100, resource.DecimalSI)},
+					},
+				},
+			},
+		},
+		Status: autoscalingv2beta2.HorizontalPodAutoscalerStatus{
+			LastScaleTime:   &lastScale,
+			CurrentReplicas: 3,
+			DesiredReplicas: 4,
+			CurrentMetrics: []autoscalingv2beta2.MetricStatus{
+				{
+					Type: autoscalingv2beta2.ResourceMetricSourceType,
+					Resource: &autoscalingv2beta2.ResourceMetricStatus{
+						Name:    apiv1.ResourceCPU,
+						Current: autoscalingv2beta2.MetricValueStatus{AverageUtilization: &currentUtilization},
+					},
+				},
+			},
+		},
+	}
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{kubernetes.NewPod(&apiPod)}
+	mockK8s.deployments = []kubernetes.Deployment{kubernetes.NewDeployment(&apiDeployment)}
+	mockK8s.autoscalers = []kubernetes.HorizontalPodAutoscaler{kubernetes.NewHorizontalPodAutoscaler(&apiHPA)}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	checkMetrics := func(node report.Node, want map[string]float64) {
+		for id, value := range want {
+			metric, ok := node.Metrics.Lookup(id)
+			if !ok {
+				t.Errorf("Expected %s to have metric %s", node.ID, id)
+				continue
+			}
+			if sample, ok := metric.LastSample(); !ok || sample.Value != value {
+				t.Errorf("Expected %s metric %s to be %v, got %v", node.ID, id, value, sample.Value)
+			}
+		}
+		if _, ok := node.Metrics.Lookup(kubernetes.CPULimit); ok {
+			t.Errorf("Expected %s not to have a CPU limit", node.ID)
+		}
+	}
+	checkMetrics(rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)], map[string]float64{
+		kubernetes.CPURequest:    0.5,
+		kubernetes.MemoryRequest: 128 << 20,
+		kubernetes.MemoryLimit:   256 << 20,
+	})
+	deployment := rpt.Deployment.Nodes[report.MakeDeploymentNodeID("deployment1234")]
+	checkMetrics(deployment, map[string]float64{
+		kubernetes.CPURequest:    1.5,
+		kubernetes.MemoryRequest: 3 * 128 << 20,
+		kubernetes.MemoryLimit:   3 * 256 << 20,
+	})
+
+	for k, want := range map[string]string{
+		kubernetes.HPA:                "pong-hpa",
+		kubernetes.HPAMinReplicas:     "2",
+		kubernetes.HPAMaxReplicas:     "10",
+		kubernetes.HPACurrentReplicas: "3",
+		kubernetes.HPADesiredReplicas: "4",
+		kubernetes.HPATargets:         "cpu: 45%/80%, requests_per_second: <unknown>/100",
+		kubernetes.HPALastScale:       "2021-03-04T05:06:07Z",
+	} {
+		if have, ok := deployment.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected deployment latest %q: %q, got %q", k, want, have)
+		}
+	}
+}
+
+func TestReporterVolumes(t *testing.T) {
+	storageClass := "fast"
+	claim := func(name, uid string, phase apiv1.PersistentVolumeClaimPhase) kubernetes.PersistentVolumeClaim {
+		return kubernetes.NewPersistentVolumeClaim(&apiv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ping", UID: types.UID(uid)},
+			Spec: apiv1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass,
+				AccessModes:      []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce},
+				Resources: apiv1.ResourceRequirements{
+					Requests: apiv1.ResourceList{apiv1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+			Status: apiv1.PersistentVolumeClaimStatus{Phase: phase},
+		})
+	}
+	apiPod := apiPod1
+	apiPod.Spec.Volumes = []apiv1.Volume{{
+		Name: "data",
+		VolumeSource: apiv1.VolumeSource{
+			PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+		},
+	}}
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{kubernetes.NewPod(&apiPod), pod2}
+	mockK8s.claims = []kubernetes.PersistentVolumeClaim{
+		claim("data", "claim1", apiv1.ClaimBound),
+		claim("orphan", "claim2", apiv1.ClaimPending),
+	}
+	mockK8s.volumes = []kubernetes.PersistentVolume{
+		kubernetes.NewPersistentVolume(&apiv1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv1", UID: types.UID("volume1")},
+			Spec: apiv1.PersistentVolumeSpec{
+				Capacity:                      apiv1.ResourceList{apiv1.ResourceStorage: resource.MustParse("1Gi")},
+				AccessModes:                   []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce, apiv1.ReadOnlyMany},
+				StorageClassName:              storageClass,
+				PersistentVolumeReclaimPolicy: apiv1.PersistentVolumeReclaimRetain,
+				PersistentVolumeSource: apiv1.PersistentVolumeSource{
+					CSI: &apiv1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com"},
+				},
+			},
+			Status: apiv1.PersistentVolumeStatus{Phase: apiv1.VolumeReleased},
+		}),
+	}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	claim1ID := report.MakePersistentVolumeClaimNodeID("claim1")
+	for id, want := range map[string]map[string]string{
+		claim1ID: {
+			kubernetes.Phase:          "Bound",
+			kubernetes.VolumeInUse:    "true",
+			kubernetes.VolumeCapacity: "1Gi",
+			kubernetes.AccessModes:    "ReadWriteOnce",
+		},
+		report.MakePersistentVolumeClaimNodeID("claim2"): {
+			kubernetes.Phase:       "Pending",
+			kubernetes.VolumeInUse: "false",
+		},
+	} {
+		node, ok := rpt.PersistentVolumeClaim.Nodes[id]
+		if !ok {
+			t.Errorf("Expected report to have claim %q", id)
+			continue
+		}
+		for k, v := range want {
+			if have, ok := node.Latest.Lookup(k); !ok || have != v {
+				t.Errorf("Expected claim %s latest %q: %q, got %q", id, k, v, have)
+			}
+		}
+	}
+
+	volume := rpt.PersistentVolume.Nodes[report.MakePersistentVolumeNodeID("volume1")]
+	for k, want := range map[string]string{
+		kubernetes.Phase:          "Released",
+		kubernetes.VolumeCapacity: "1Gi",
+		kubernetes.AccessModes:    "ReadWriteOnce, ReadOnlyMany",
+		kubernetes.StorageDriver:  "CSI",
+		kubernetes.CSIDriver:      "ebs.csi.aws.com",
+		kubernetes.ReclaimPolicy:  "Retain",
+	} {
+		if have, ok := volume.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected volume latest %q: %q, got %q", k, want, have)
+		}
+	}
+
+	pod := rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)]
+	if have, ok := pod.Parents.Lookup(report.PersistentVolumeClaim); !ok || !reflect.DeepEqual(report.MakeStringSet(claim1ID), have) {
+		t.Errorf("Expected pod to have claim parent %q, got %v", claim1ID, have)
+	}
+	if have, ok := rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)].Parents.Lookup(report.PersistentVolumeClaim); ok {
+		t.Errorf("Expected pod without volumes to have no claim parents, got %v", have)
+	}
+}
+
+func TestReporterNamespaces(t *testing.T) {
+	running, pending, terminating := apiPod1, apiPod2, apiPod1
+	running.Status.Phase = apiv1.PodRunning
+	pending.Status.Phase = apiv1.PodPending
+	terminating.UID = "terminating"
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{kubernetes.NewPod(&running), kubernetes.NewPod(&pending), kubernetes.NewPod(&terminating)}
+	mockK8s.namespaces = []kubernetes.NamespaceResource{
+		kubernetes.NewNamespace(&apiv1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ping",
+				UID:  types.UID("ns1"),
+				Labels: map[string]string{
+					"pod-security.kubernetes.io/enforce": "baseline",
+					"pod-security.kubernetes.io/warn":    "restricted",
+				},
+				Annotations: map[string]string{"owner": "team-ping"},
+			},
+		}),
+	}
+	mockK8s.quotas = []*apiv1.ResourceQuota{{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "ping"},
+		Status: apiv1.ResourceQuotaStatus{
+			Hard: apiv1.ResourceList{apiv1.ResourcePods: resource.MustParse("10")},
+			Used: apiv1.ResourceList{apiv1.ResourcePods: resource.MustParse("2")},
+		},
+	}}
+	mockK8s.limitRanges = []*apiv1.LimitRange{{
+		ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "ping"},
+		Spec: apiv1.LimitRangeSpec{Limits: []apiv1.LimitRangeItem{{
+			Type:    apiv1.LimitTypeContainer,
+			Default: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")},
+			Max:     apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("1Gi")},
+		}}},
+	}}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	node, ok := rpt.Namespace.Nodes[report.MakeNamespaceNodeID("ns1")]
+	if !ok {
+		t.Fatalf("Expected report to have namespace ns1")
+	}
+	for k, want := range map[string]string{
+		kubernetes.PSSEnforce:                     "baseline",
+		kubernetes.PSSWarn:                        "restricted",
+		kubernetes.AnnotationPrefix + "owner":     "team-ping",
+		kubernetes.PodPhasePrefix + "Pending":     "1",
+		kubernetes.PodPhasePrefix + "Running":     "1",
+		kubernetes.PodPhasePrefix + "Terminating": "1",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected namespace latest %q: %q, got %q", k, want, have)
+		}
+	}
+	if _, ok := node.Latest.Lookup(kubernetes.PSSAudit); ok {
+		t.Errorf("Expected namespace not to have an audit level")
+	}
+
+	quotas := node.ExtractMulticolumnTable(rpt.Namespace.TableTemplates[kubernetes.ResourceQuotaPrefix])
+	if want := []report.Row{{
+		ID: "compute/pods",
+		Entries: map[string]string{
+			kubernetes.ResourceQuotaName:     "compute",
+			kubernetes.ResourceQuotaResource: "pods",
+			kubernetes.ResourceQuotaUsed:     "2",
+			kubernetes.ResourceQuotaHard:     "10",
+		},
+	}}; !reflect.DeepEqual(want, quotas) {
+		t.Errorf("Expected quota rows %v, got %v", want, quotas)
+	}
+	limits := node.ExtractMulticolumnTable(rpt.Namespace.TableTemplates[kubernetes.LimitRangePrefix])
+	if want := []report.Row{{
+		ID: "defaults/Container/memory",
+		Entries: map[string]string{
+			kubernetes.LimitRangeName:     "defaults",
+			kubernetes.LimitRangeType:     "Container",
+			kubernetes.LimitRangeResource: "memory",
+			kubernetes.LimitRangeMax:      "1Gi",
+			kubernetes.LimitRangeDefault:  "512Mi",
+		},
+	}}; !reflect.DeepEqual(want, limits) {
+		t.Errorf("Expected limit range rows %v, got %v", want, limits)
+	}
+
+	for _, id := range []string{report.MakePodNodeID(pod1UID), report.MakePodNodeID(pod2UID)} {
+		if have, ok := rpt.Pod.Nodes[id].Latest.Lookup(kubernetes.NamespacePSSLevel); !ok || have != "baseline" {
+			t.Errorf("Expected pod %s to have namespace level %q, got %q", id, "baseline", have)
+		}
+	}
+}
+
+func TestReporterWarningEvents(t *testing.T) {
+	mockK8s := newMockClient()
+	mockK8s.nodes = []kubernetes.NodeResource{
+		kubernetes.NewNodeResource(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}),
+	}
+	mockK8s.events = []*apiv1.Event{
+		{
+			InvolvedObject: apiv1.ObjectReference{Kind: "Pod", Name: "pong-a", Namespace: "ping", UID: types.UID(pod1UID)},
+			Type:           apiv1.EventTypeWarning,
+			Reason:         "Failed",
+			Message:        "Failed to pull image",
+			Count:          4,
+		},
+		{
+			InvolvedObject: apiv1.ObjectReference{Kind: "Node", Name: nodeName},
+			Type:           apiv1.EventTypeWarning,
+			Reason:         "FreeDiskSpaceFailed",
+		},
+	}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	template := kubernetes.WarningEventTableTemplates[kubernetes.WarningEventPrefix]
+	rows := rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)].ExtractMulticolumnTable(template)
+	if len(rows) != 1 || rows[0].Entries[kubernetes.WarningEventMessage] != "Failed to pull image" || rows[0].Entries[kubernetes.WarningEventCount] != "4" {
+		t.Errorf("Expected pod to have the image pull warning, got %v", rows)
+	}
+	if rows := rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)].ExtractMulticolumnTable(template); len(rows) != 0 {
+		t.Errorf("Expected other pod not to have warnings, got %v", rows)
+	}
+	rows = rpt.Host.Nodes[report.MakeHostNodeID(nodeName)].ExtractMulticolumnTable(template)
+	if len(rows) != 1 || rows[0].ID != "FreeDiskSpaceFailed" {
+		t.Errorf("Expected host to have the disk space warning, got %v", rows)
+	}
+}
+
+func TestReporterServiceAccounts(t *testing.T) {
+	no := false
+	admin := apiPod2
+	admin.Spec.ServiceAccountName = "deployer"
+	admin.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{pod1, kubernetes.NewPod(&admin)}
+	mockK8s.accounts = []*apiv1.ServiceAccount{{
+		ObjectMeta:                   metav1.ObjectMeta{Name: "deployer", Namespace: "ping"},
+		AutomountServiceAccountToken: &no,
+	}}
+	mockK8s.admins = map[string]bool{"ping/deployer": true}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	for id, want := range map[string]map[string]string{
+		report.MakePodNodeID(pod1UID): {
+			kubernetes.ServiceAccount:        "default",
+			kubernetes.AutomountToken:        "true",
+			kubernetes.ClusterAdmin:          "false",
+			kubernetes.DefaultSATokenMounted: "true",
+		},
+		report.MakePodNodeID(pod2UID): {
+			kubernetes.ServiceAccount:   "deployer",
+			kubernetes.AutomountToken:   "false",
+			kubernetes.ClusterAdmin:     "true",
+			kubernetes.ImagePullSecrets: "registry, mirror",
+		},
+	} {
+		node := rpt.Pod.Nodes[id]
+		for k, v := range want {
+			if have, ok := node.Latest.Lookup(k); !ok || have != v {
+				t.Errorf("Expected pod %s latest %q: %q, got %q", id, k, v, have)
+			}
+		}
+	}
+	if _, ok := rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)].Latest.Lookup(kubernetes.DefaultSATokenMounted); ok {
+		t.Errorf("Expected pod not running as the default service account not to be flagged")
+	}
+}
+
+func TestReporterScheduling(t *testing.T) {
+	pending := apiPod2
+	pending.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	pending.Spec.NodeName = ""
+	pending.Spec.NodeSelector = map[string]string{"disk": "ssd", "zone": "a"}
+	pending.Spec.Tolerations = []apiv1.Toleration{
+		{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "db", Effect: apiv1.TaintEffectNoSchedule},
+		{Operator: apiv1.TolerationOpExists},
+	}
+	pending.Spec.Affinity = &apiv1.Affinity{
+		PodAntiAffinity: &apiv1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				TopologyKey:   "kubernetes.io/hostname",
+			}},
+		},
+	}
+	pending.Status = apiv1.PodStatus{
+		Phase: apiv1.PodPending,
+		Conditions: []apiv1.PodCondition{{
+			Type:    apiv1.PodScheduled,
+			Status:  apiv1.ConditionFalse,
+			Reason:  "Unschedulable",
+			Message: "0/3 nodes are available",
+		}},
+	}
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{pod1, kubernetes.NewPod(&pending)}
+	mockK8s.events = []*apiv1.Event{{
+		InvolvedObject: apiv1.ObjectReference{Kind: "Pod", UID: types.UID(pod2UID)},
+		Type:           apiv1.EventTypeWarning,
+		Reason:         "FailedScheduling",
+		Message:        "0/3 nodes are available: 3 node(s) didn't match node selector.",
+	}}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	node := rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)]
+	for k, want := range map[string]string{
+		kubernetes.NodeSelector:     "disk=ssd, zone=a",
+		kubernetes.Tolerations:      "dedicated=db:NoSchedule, *",
+		kubernetes.Affinity:         "pod anti-affinity required: app=db per kubernetes.io/hostname",
+		kubernetes.SchedulingReason: "Unschedulable: 0/3 nodes are available",
+		kubernetes.FailedScheduling: "0/3 nodes are available: 3 node(s) didn't match node selector.",
+		kubernetes.PendingTooLong:   "true",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected pending pod latest %q: %q, got %q", k, want, have)
+		}
+	}
+	for _, k := range []string{kubernetes.NodeSelector, kubernetes.SchedulingReason, kubernetes.PendingTooLong} {
+		if have, ok := rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)].Latest.Lookup(k); ok {
+			t.Errorf("Expected running pod not to have %q, got %q", k, have)
+		}
+	}
+}
+
+func TestReporterCustomResources(t *testing.T) {
+	fields, err := kubernetes.ParseCustomResourceFields(`Phase:.status.phase,Ready:.status.conditions[?(@.type=="Ready")].status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := kubernetes.NewCustomResource(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata": map[string]interface{}{
+			"name":      "pong",
+			"namespace": "ping",
+			"uid":       "rollout1234",
+			"labels":    map[string]interface{}{"app": "pong"},
+		},
+		"status": map[string]interface{}{
+			"phase": "Healthy",
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			},
+		},
+	}}, schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}, fields)
+	mockK8s := newMockClient()
+	mockK8s.resources = []kubernetes.CustomResource{rollout}
+	// pod1 belongs to a replica set of the rollout, pod2 to nothing known.
+	mockK8s.owners = map[string][]metav1.OwnerReference{
+		pod1UID:          {{Kind: "ReplicaSet", UID: "replicaset1234"}},
+		pod2UID:          {{Kind: "ReplicaSet", UID: "replicaset5678"}},
+		"replicaset1234": {{Kind: "Rollout", UID: "rollout1234"}},
+	}
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+
+	id := report.MakeCustomResourceNodeID("rollout1234")
+	node, ok := rpt.CustomResource.Nodes[id]
+	if !ok {
+		t.Fatalf("Expected report to have custom resource %q, got %v", id, rpt.CustomResource.Nodes)
+	}
+	for k, want := range map[string]string{
+		kubernetes.Name:                        "pong",
+		kubernetes.Namespace:                   "ping",
+		kubernetes.NodeType:                    "Rollout",
+		kubernetes.CustomResourceType:          "argoproj.io/v1alpha1/rollouts",
+		kubernetes.CustomFieldPrefix + "Phase": "Healthy",
+		kubernetes.CustomFieldPrefix + "Ready": "True",
+		kubernetes.LabelPrefix + "app":         "pong",
+	} {
+		if have, ok := node.Latest.Lookup(k); !ok || have != want {
+			t.Errorf("Expected custom resource latest %q: %q, got %q", k, want, have)
+		}
+	}
+	if parents, _ := rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)].Parents.Lookup(report.CustomResource); !reflect.DeepEqual(parents, report.MakeStringSet(id)) {
+		t.Errorf("Expected pod of the rollout's replica set to have it as a parent, got %v", parents)
+	}
+	if parents, ok := rpt.Pod.Nodes[report.MakePodNodeID(pod2UID)].Parents.Lookup(report.CustomResource); ok {
+		t.Errorf("Expected other pod not to have a custom resource parent, got %v", parents)
+	}
+}
+
+func TestClusterReporter(t *testing.T) {
+	mockK8s := newMockClient()
+	mockK8s.nodes = []kubernetes.NodeResource{
+		kubernetes.NewNodeResource(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}),
+	}
+	reporter := kubernetes.NewClusterReporter(mockK8s, "probe-id", "foo", nil, "staging", kubernetes.DefaultPendingThreshold)
+	defer reporter.Stop()
+	rpt, err := reporter.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := rpt.Pod.Nodes[report.MakePodNodeID(pod1UID)]; ok {
+		t.Errorf("Expected pod IDs to be prefixed with the cluster name")
+	}
+	podID := kubernetes.ClusterNodeID("staging", report.MakePodNodeID(pod1UID))
+	pod, ok := rpt.Pod.Nodes[podID]
+	if !ok {
+		t.Fatalf("Expected report to have pod %q", podID)
+	}
+	if pod.ID != podID {
+		t.Errorf("Expected pod to have ID %q, got %q", podID, pod.ID)
+	}
+	if have, ok := pod.Latest.Lookup(report.KubernetesClusterName); !ok || have != "staging" {
+		t.Errorf("Expected pod to be stamped with the cluster name, got %q", have)
+	}
+	serviceID := kubernetes.ClusterNodeID("staging", report.MakeServiceNodeID(serviceUID))
+	if parents, ok := pod.Parents.Lookup(report.Service); !ok || !parents.Contains(serviceID) {
+		t.Errorf("Expected pod to have parent service %q, got %v", serviceID, parents)
+	}
+	clusterID := report.MakeKubernetesClusterNodeID("staging")
+	if parents, ok := pod.Parents.Lookup(report.KubernetesCluster); !ok || !parents.Contains(clusterID) {
+		t.Errorf("Expected pod to have parent cluster %q, got %v", clusterID, parents)
+	}
+	if _, ok := rpt.KubernetesCluster.Nodes[clusterID]; !ok || len(rpt.KubernetesCluster.Nodes) != 1 {
+		t.Errorf("Expected a single cluster node %q, got %v", clusterID, rpt.KubernetesCluster.Nodes)
+	}
+	if _, ok := rpt.Host.Nodes[kubernetes.ClusterNodeID("staging", report.MakeHostNodeID(nodeName))]; !ok {
+		t.Errorf("Expected host IDs to be prefixed with the cluster name")
+	}
+	if len(rpt.Pod.Controls) != 0 {
+		t.Errorf("Expected no controls, got %v", rpt.Pod.Controls)
+	}
+}
+
+func TestReporterControls(t *testing.T) {
+	pod1ID := report.MakePodNodeID(pod1UID)
+	hostID := report.MakeHostNodeID(nodeName)
+	mockK8s := newMockClient()
+	mockK8s.logsDenied = true
+	mockK8s.nodes = []kubernetes.NodeResource{
+		kubernetes.NewNodeResource(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}),
+		kubernetes.NewNodeResource(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "othernode"}}),
+	}
+
+	// Controls are off by default
+	hr := controls.NewDefaultHandlerRegistry()
+	rpt, _ := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+	if controls, ok := rpt.Pod.Nodes[pod1ID].Latest.Lookup(report.NodeActiveControls); ok {
+		t.Errorf("Expected no pod controls, got %q", controls)
+	}
+	if resp := hr.HandleControlRequest(xfer.Request{NodeID: pod1ID, Control: kubernetes.DeletePod}); resp.Error == "" {
+		t.Errorf("Expected delete pod control not to be registered")
+	}
+
+	reporter := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, true, kubernetes.DefaultPendingThreshold)
+	rpt, _ = reporter.Report()
+	if have, want := rpt.Pod.Nodes[pod1ID].ActiveControls(), []string{kubernetes.DeletePod}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected pod controls %v, got %v", want, have)
+	}
+	if have, want := rpt.Host.Nodes[hostID].ActiveControls(), []string{kubernetes.CordonNode}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected host controls %v, got %v", want, have)
+	}
+	if other, ok := rpt.Host.Nodes[report.MakeHostNodeID("othernode")]; ok {
+		t.Errorf("Expected no other hosts, got %v", other)
+	}
+
+	for _, req := range []xfer.Request{
+		{NodeID: pod1ID, Control: kubernetes.DeletePod},
+		{NodeID: pod1ID, Control: kubernetes.DeletePod, ControlArgs: map[string]string{kubernetes.GracePeriodArg: "0"}},
+		{NodeID: hostID, Control: kubernetes.CordonNode},
+	} {
+		if resp := hr.HandleControlRequest(req); resp.Error != "" {
+			t.Errorf("Unexpected error for %s: %s", req.Control, resp.Error)
+		}
+	}
+	if want := []string{"ping/pong-a", "ping/pong-a (0s)"}; !reflect.DeepEqual(want, mockK8s.deleted) {
+		t.Errorf("Expected deleted pods %v, got %v", want, mockK8s.deleted)
+	}
+	if cordoned, ok := mockK8s.cordoned[nodeName]; !ok || !cordoned {
+		t.Errorf("Expected node %s to be cordoned", nodeName)
+	}
+
+	for _, req := range []xfer.Request{
+		{NodeID: report.MakePodNodeID("nosuchpod"), Control: kubernetes.DeletePod},
+		{NodeID: pod1ID, Control: kubernetes.DeletePod, ControlArgs: map[string]string{kubernetes.GracePeriodArg: "-1"}},
+	} {
+		if resp := hr.HandleControlRequest(req); resp.Error == "" {
+			t.Errorf("Expected an error for %v", req)
+		}
+	}
+
+	// RBAC denials are explained
+	mockK8s.controlErr = apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, nodeName, fmt.Errorf("no"))
+	resp := hr.HandleControlRequest(xfer.Request{NodeID: hostID, Control: kubernetes.UncordonNode})
+	if !strings.Contains(resp.Error, "service account needs RBAC permission to patch nodes") {
+		t.Errorf("Expected an RBAC error, got %q", resp.Error)
+	}
+
+	reporter.Stop()
+	if resp := hr.HandleControlRequest(xfer.Request{NodeID: pod1ID, Control: kubernetes.DeletePod}); resp.Error == "" {
+		t.Errorf("Expected delete pod control to be deregistered")
+	}
+}
+
+func TestReporterGetLogs(t *testing.T) {
+	apiPod := apiPod1
+	apiPod.Spec.Containers = []apiv1.Container{{Name: "app"}, {Name: "sidecar"}}
+	pod := kubernetes.NewPod(&apiPod)
+	podID := report.MakePodNodeID(pod1UID)
+	mockK8s := newMockClient()
+	mockK8s.pods = []kubernetes.Pod{pod}
+	logs := &mockLogs{Reader: strings.NewReader("app: hello\n")}
+	mockK8s.logs["ping;pong-a"] = logs
+	pipes := mockPipeClient{}
+	hr := controls.NewDefaultHandlerRegistry()
+	reporter := kubernetes.NewReporter(mockK8s, pipes, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold)
+	defer reporter.Stop()
+
+	rpt, _ := reporter.Report()
+	if have, want := rpt.Pod.Nodes[podID].ActiveControls(), []string{kubernetes.GetLogs}; !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected pod controls %v, got %v", want, have)
+	}
+
+	resp := hr.HandleControlRequest(xfer.Request{
+		AppID:   "appID",
+		NodeID:  podID,
+		Control: kubernetes.GetLogs,
+		ControlArgs: map[string]string{
+			kubernetes.TailLinesArg:    "10",
+			kubernetes.SinceSecondsArg: "60",
+			kubernetes.FollowArg:       "false",
+		},
+	})
+	if resp.Error != "" || resp.Pipe == "" {
+		t.Fatalf("Expected a pipe, got %+v", resp)
+	}
+	tailLines, sinceSeconds := int64(10), int64(60)
+	want := logRequest{
+		containerNames: []string{"app", "sidecar"},
+		options:        apiv1.PodLogOptions{Timestamps: true, TailLines: &tailLines, SinceSeconds: &sinceSeconds},
+	}
+	if len(mockK8s.logRequests) != 1 || !reflect.DeepEqual(want, mockK8s.logRequests[0]) {
+		t.Errorf("Expected log request %+v, got %+v", want, mockK8s.logRequests)
+	}
+
+	// The stream stops when the pipe is closed
+	pipe, ok := pipes[resp.Pipe]
+	if !ok {
+		t.Fatalf("Expected pipe %s to be connected", resp.Pipe)
+	}
+	_, end := pipe.Ends()
+	buf := make([]byte, 64)
+	if n, err := end.Read(buf); err != nil || string(buf[:n]) != "app: hello\n" {
+		t.Errorf("Expected the logs, got %q, %v", buf[:n], err)
+	}
+	pipes.PipeClose("appID", resp.Pipe)
+	if !logs.closed {
+		t.Errorf("Expected the log stream to be closed with the pipe")
+	}
+
+	// A single container can be chosen, and has to exist
+	resp = hr.HandleControlRequest(xfer.Request{NodeID: podID, Control: kubernetes.GetLogs, ControlArgs: map[string]string{kubernetes.ContainerArg: "sidecar"}})
+	if have := mockK8s.logRequests[len(mockK8s.logRequests)-1]; resp.Error != "" || !reflect.DeepEqual([]string{"sidecar"}, have.containerNames) || !have.options.Follow {
+		t.Errorf("Expected to follow the sidecar's logs, got %+v, %+v", resp, have)
+	}
+	for _, args := range []map[string]string{
+		{kubernetes.ContainerArg: "nosuchcontainer"},
+		{kubernetes.TailLinesArg: "-1"},
+		{kubernetes.SinceSecondsArg: "never"},
+		{kubernetes.FollowArg: "maybe"},
+	} {
+		if resp := hr.HandleControlRequest(xfer.Request{NodeID: podID, Control: kubernetes.GetLogs, ControlArgs: args}); resp.Error == "" {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+
+	// Without permission to read logs, the control is hidden
+	mockK8s.logsDenied = true
+	rpt, _ = kubernetes.NewReporter(mockK8s, pipes, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold).Report()
+	if controls, ok := rpt.Pod.Nodes[podID].Latest.Lookup(report.NodeActiveControls); ok {
+		t.Errorf("Expected no pod controls, got %q", controls)
+	}
+	if _, ok := rpt.Pod.Controls[kubernetes.GetLogs]; ok {
+		t.Errorf("Expected the get logs control to be hidden")
+	}
+}
+
 func BenchmarkReporter(b *testing.B) {
 	hr := controls.NewDefaultHandlerRegistry()
 	mockK8s := newMockClient()
@@ -337,7 +1633,7 @@ func BenchmarkReporter(b *testing.B) {
 		}
 		mockK8s.deployments = append(mockK8s.deployments, kubernetes.NewDeployment(&deployment))
 	}
-	reporter := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName)
+	reporter := kubernetes.NewReporter(mockK8s, nil, "probe-id", "foo", nil, hr, nodeName, false, kubernetes.DefaultPendingThreshold)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
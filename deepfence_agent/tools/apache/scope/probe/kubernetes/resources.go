@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metrics and metadata
+const (
+	CPURequest    = report.KubernetesCPURequest
+	CPULimit      = report.KubernetesCPULimit
+	MemoryRequest = report.KubernetesMemoryRequest
+	MemoryLimit   = report.KubernetesMemoryLimit
+	NearOOM       = report.KubernetesNearOOM
+
+	// nearOOMRatio is the fraction of its memory limit a pod has to use to
+	// be considered close to being OOM killed.
+	nearOOMRatio = 0.9
+)
+
+// ResourceMetricTemplates show the resources requested by, and the limits
+// of, a pod or workload.  CPU is in cores.
+var ResourceMetricTemplates = report.MetricTemplates{
+	CPURequest:    {ID: CPURequest, Label: "CPU request", Format: report.DefaultFormat, Priority: 3},
+	CPULimit:      {ID: CPULimit, Label: "CPU limit", Format: report.DefaultFormat, Priority: 4},
+	MemoryRequest: {ID: MemoryRequest, Label: "Memory request", Format: report.FilesizeFormat, Priority: 5},
+	MemoryLimit:   {ID: MemoryLimit, Label: "Memory limit", Format: report.FilesizeFormat, Priority: 6},
+}
+
+// podResources sums the requests and limits of the containers of a pod.
+func podResources(spec apiv1.PodSpec) (requests, limits apiv1.ResourceList) {
+	requests, limits = apiv1.ResourceList{}, apiv1.ResourceList{}
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+	return requests, limits
+}
+
+func addResources(total, list apiv1.ResourceList) {
+	for name, quantity := range list {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// resourceMetrics turns requests and limits into metrics, scaled by
+// replicas.  Resources which aren't set are left out.
+func resourceMetrics(requests, limits apiv1.ResourceList, replicas int, now time.Time) report.Metrics {
+	metrics := report.Metrics{}
+	add := func(id string, list apiv1.ResourceList, name apiv1.ResourceName) {
+		quantity, ok := list[name]
+		if !ok || quantity.IsZero() {
+			return
+		}
+		value := float64(quantity.MilliValue()) / 1000
+		if name == apiv1.ResourceMemory {
+			value = float64(quantity.Value())
+		}
+		metrics[id] = report.MakeSingletonMetric(now, value*float64(replicas))
+	}
+	add(CPURequest, requests, apiv1.ResourceCPU)
+	add(CPULimit, limits, apiv1.ResourceCPU)
+	add(MemoryRequest, requests, apiv1.ResourceMemory)
+	add(MemoryLimit, limits, apiv1.ResourceMemory)
+	return metrics
+}
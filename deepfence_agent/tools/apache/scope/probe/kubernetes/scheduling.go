@@ -0,0 +1,185 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	NodeSelector           = report.KubernetesNodeSelector
+	Tolerations            = report.KubernetesTolerations
+	Affinity               = report.KubernetesAffinity
+	SchedulingReason       = report.KubernetesSchedulingReason
+	FailedScheduling       = report.KubernetesFailedScheduling
+	PendingTooLong         = report.KubernetesPendingTooLong
+	failedSchedulingReason = "FailedScheduling"
+
+	// DefaultPendingThreshold is how long a pod can be pending before we
+	// consider it stuck.
+	DefaultPendingThreshold = 5 * time.Minute
+)
+
+func selectorString(selector map[string]string) string {
+	pairs := make([]string, 0, len(selector))
+	for k, v := range selector {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func tolerationString(t apiv1.Toleration) string {
+	s := t.Key
+	if t.Operator == apiv1.TolerationOpExists {
+		if s == "" {
+			s = "*"
+		}
+	} else if t.Value != "" {
+		s += "=" + t.Value
+	}
+	if t.Effect != "" {
+		s += ":" + string(t.Effect)
+	}
+	return s
+}
+
+func nodeSelectorTermString(term apiv1.NodeSelectorTerm) string {
+	exprs := make([]string, 0, len(term.MatchExpressions))
+	for _, e := range term.MatchExpressions {
+		if len(e.Values) == 0 {
+			exprs = append(exprs, fmt.Sprintf("%s %s", e.Key, e.Operator))
+		} else {
+			exprs = append(exprs, fmt.Sprintf("%s %s (%s)", e.Key, e.Operator, strings.Join(e.Values, ", ")))
+		}
+	}
+	return strings.Join(exprs, " and ")
+}
+
+func podAffinityTermString(term apiv1.PodAffinityTerm) string {
+	selector := "all pods"
+	if term.LabelSelector != nil {
+		selector = metav1.FormatLabelSelector(term.LabelSelector)
+	}
+	return fmt.Sprintf("%s per %s", selector, term.TopologyKey)
+}
+
+// affinitySummary describes the node affinity, pod affinity and pod
+// anti-affinity of a pod, e.g.
+// "node affinity required: zone In (a, b); pod anti-affinity preferred: app=web per kubernetes.io/hostname"
+func affinitySummary(a *apiv1.Affinity) string {
+	if a == nil {
+		return ""
+	}
+	parts := []string{}
+	if na := a.NodeAffinity; na != nil {
+		if na.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+			terms := []string{}
+			for _, term := range na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				terms = append(terms, nodeSelectorTermString(term))
+			}
+			parts = append(parts, "node affinity required: "+strings.Join(terms, " or "))
+		}
+		if len(na.PreferredDuringSchedulingIgnoredDuringExecution) > 0 {
+			terms := []string{}
+			for _, term := range na.PreferredDuringSchedulingIgnoredDuringExecution {
+				terms = append(terms, nodeSelectorTermString(term.Preference))
+			}
+			parts = append(parts, "node affinity preferred: "+strings.Join(terms, " or "))
+		}
+	}
+	podTerms := func(kind string, required []apiv1.PodAffinityTerm, preferred []apiv1.WeightedPodAffinityTerm) {
+		if len(required) > 0 {
+			terms := []string{}
+			for _, term := range required {
+				terms = append(terms, podAffinityTermString(term))
+			}
+			parts = append(parts, kind+" required: "+strings.Join(terms, ", "))
+		}
+		if len(preferred) > 0 {
+			terms := []string{}
+			for _, term := range preferred {
+				terms = append(terms, podAffinityTermString(term.PodAffinityTerm))
+			}
+			parts = append(parts, kind+" preferred: "+strings.Join(terms, ", "))
+		}
+	}
+	if pa := a.PodAffinity; pa != nil {
+		podTerms("pod affinity", pa.RequiredDuringSchedulingIgnoredDuringExecution, pa.PreferredDuringSchedulingIgnoredDuringExecution)
+	}
+	if pa := a.PodAntiAffinity; pa != nil {
+		podTerms("pod anti-affinity", pa.RequiredDuringSchedulingIgnoredDuringExecution, pa.PreferredDuringSchedulingIgnoredDuringExecution)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Scheduling explains where the pod can run, and if it is pending, why it
+// isn't running yet.
+func (p *pod) Scheduling(pendingThreshold time.Duration, now time.Time) map[string]string {
+	latests := map[string]string{}
+	if len(p.Spec.NodeSelector) > 0 {
+		latests[NodeSelector] = selectorString(p.Spec.NodeSelector)
+	}
+	if len(p.Spec.Tolerations) > 0 {
+		tolerations := make([]string, 0, len(p.Spec.Tolerations))
+		for _, t := range p.Spec.Tolerations {
+			tolerations = append(tolerations, tolerationString(t))
+		}
+		latests[Tolerations] = strings.Join(tolerations, ", ")
+	}
+	if affinity := affinitySummary(p.Spec.Affinity); affinity != "" {
+		latests[Affinity] = affinity
+	}
+	if p.Status.Phase != apiv1.PodPending {
+		return latests
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == apiv1.PodScheduled && c.Status == apiv1.ConditionFalse {
+			latests[SchedulingReason] = strings.TrimPrefix(c.Reason+": "+c.Message, ": ")
+		}
+	}
+	if pendingThreshold > 0 && now.Sub(p.CreationTimestamp.Time) > pendingThreshold {
+		latests[PendingTooLong] = "true"
+	}
+	return latests
+}
+
+// failedSchedulingMessages returns the message of the latest
+// FailedScheduling event of each pod, by node ID.
+func failedSchedulingMessages(events []*apiv1.Event) map[string]string {
+	messages := map[string]string{}
+	lastSeen := map[string]time.Time{}
+	for _, e := range events {
+		if e.Reason != failedSchedulingReason || e.InvolvedObject.Kind != "Pod" {
+			continue
+		}
+		id := report.MakePodNodeID(string(e.InvolvedObject.UID))
+		if t := eventLastSeen(e); !t.Before(lastSeen[id]) {
+			messages[id], lastSeen[id] = e.Message, t
+		}
+	}
+	return messages
+}
+
+// withFailedScheduling adds why the scheduler last failed to place them to
+// the pending pods of a topology.
+func withFailedScheduling(pods report.Topology, messages map[string]string) report.Topology {
+	for id, node := range pods.Nodes {
+		message, ok := messages[id]
+		if !ok {
+			continue
+		}
+		if state, _ := node.Latest.Lookup(State); state != string(apiv1.PodPending) {
+			continue
+		}
+		pods.Nodes[id] = node.WithLatests(map[string]string{FailedScheduling: message})
+	}
+	return pods
+}
@@ -12,7 +12,11 @@ import (
 
 // These constants are keys used in node metadata
 const (
-	PublicIP = report.KubernetesPublicIP
+	PublicIP          = report.KubernetesPublicIP
+	ExternalIPs       = report.KubernetesExternalIPs
+	ExternalHostnames = report.KubernetesExternalHostnames
+	NodePorts         = report.KubernetesNodePorts
+	ExternallyExposed = report.KubernetesExternallyExposed
 )
 
 // Service represents a Kubernetes service
@@ -22,6 +26,7 @@ type Service interface {
 	Selector() labels.Selector
 	ClusterIP() string
 	LoadBalancerIP() string
+	ExternallyExposed() bool
 }
 
 type service struct {
@@ -74,12 +79,49 @@ func (s *service) GetNode(probeID string) report.Node {
 		}
 		latest[Ports] = portStr[:len(portStr)-1]
 	}
+	externalIPs := append([]string{}, s.Spec.ExternalIPs...)
+	var externalHostnames, nodePorts []string
+	for _, ing := range s.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			externalIPs = append(externalIPs, ing.IP)
+		}
+		if ing.Hostname != "" {
+			externalHostnames = append(externalHostnames, ing.Hostname)
+		}
+	}
+	for _, p := range s.Spec.Ports {
+		if p.NodePort != 0 {
+			nodePorts = append(nodePorts, fmt.Sprint(p.NodePort))
+		}
+	}
+	if len(externalIPs) > 0 {
+		latest[ExternalIPs] = strings.Join(externalIPs, ",")
+	}
+	if len(externalHostnames) > 0 {
+		latest[ExternalHostnames] = strings.Join(externalHostnames, ",")
+	}
+	if len(nodePorts) > 0 {
+		latest[NodePorts] = strings.Join(nodePorts, ",")
+	}
+	if s.ExternallyExposed() {
+		latest[ExternallyExposed] = "true"
+	}
 	return s.MetaNode(report.MakeServiceNodeID(s.UID())).WithLatests(latest).
 		WithParent(report.KubernetesCluster, kubernetesClusterNodeId).
 		WithParent(report.CloudProvider, cloudProviderNodeId)
 	//.WithLatestActiveControls(Describe, GetKubeCniPlugin)
 }
 
+// ExternallyExposed returns true if the service can be reached from outside
+// the cluster by itself, i.e. not counting ingresses routing to it.
+func (s *service) ExternallyExposed() bool {
+	switch s.Spec.Type {
+	case apiv1.ServiceTypeNodePort, apiv1.ServiceTypeLoadBalancer:
+		return true
+	}
+	return len(s.Spec.ExternalIPs) > 0
+}
+
 func (s *service) ClusterIP() string {
 	return s.Spec.ClusterIP
 }
@@ -93,3 +135,17 @@ func (s *service) LoadBalancerIP() string {
 	// If s.Status.LoadBalancer.Ingress is empty, then check s.Spec.LoadBalancerIP
 	return s.Spec.LoadBalancerIP
 }
+
+// loadBalancerAddresses returns the IPs, or else hostnames, a load balancer
+// is reachable at.
+func loadBalancerAddresses(ingresses []apiv1.LoadBalancerIngress) []string {
+	addresses := []string{}
+	for _, ing := range ingresses {
+		if ing.IP != "" {
+			addresses = append(addresses, ing.IP)
+		} else if ing.Hostname != "" {
+			addresses = append(addresses, ing.Hostname)
+		}
+	}
+	return addresses
+}
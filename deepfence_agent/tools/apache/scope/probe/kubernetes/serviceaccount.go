@@ -0,0 +1,133 @@
+package kubernetes
+
+import (
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// These constants are keys used in node metadata
+const (
+	ServiceAccount        = report.KubernetesServiceAccount
+	AutomountToken        = report.KubernetesAutomountToken
+	ImagePullSecrets      = report.KubernetesImagePullSecrets
+	ClusterAdmin          = report.KubernetesClusterAdmin
+	DefaultSATokenMounted = report.KubernetesDefaultTokenMounted
+
+	defaultServiceAccount = "default"
+	clusterAdminRole      = "cluster-admin"
+
+	// Groups every service account, and every service account of a
+	// namespace, belong to.
+	allServiceAccountsGroup       = "system:serviceaccounts"
+	namespaceServiceAccountsGroup = "system:serviceaccounts:"
+)
+
+func serviceAccountKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// clusterAdmins are the service accounts bound to the cluster-admin role,
+// either cluster wide or in their namespace, directly or through their
+// groups.
+type clusterAdmins struct {
+	accounts   map[string]bool
+	namespaces map[string]bool
+	all        bool
+}
+
+func (c clusterAdmins) has(namespace, name string) bool {
+	return c.all || c.namespaces[namespace] || c.accounts[serviceAccountKey(namespace, name)]
+}
+
+func (c *clusterAdmins) addSubjects(subjects []rbacv1.Subject) {
+	for _, s := range subjects {
+		switch {
+		case s.Kind == rbacv1.ServiceAccountKind:
+			c.accounts[serviceAccountKey(s.Namespace, s.Name)] = true
+		case s.Kind == rbacv1.GroupKind && s.Name == allServiceAccountsGroup:
+			c.all = true
+		case s.Kind == rbacv1.GroupKind && strings.HasPrefix(s.Name, namespaceServiceAccountsGroup):
+			c.namespaces[strings.TrimPrefix(s.Name, namespaceServiceAccountsGroup)] = true
+		}
+	}
+}
+
+// makeClusterAdmins computes which service accounts are bound to the
+// cluster-admin cluster role.
+func makeClusterAdmins(roleBindings []*rbacv1.RoleBinding, clusterRoleBindings []*rbacv1.ClusterRoleBinding) clusterAdmins {
+	result := clusterAdmins{accounts: map[string]bool{}, namespaces: map[string]bool{}}
+	isClusterAdmin := func(ref rbacv1.RoleRef) bool {
+		return ref.Kind == "ClusterRole" && ref.Name == clusterAdminRole
+	}
+	for _, b := range clusterRoleBindings {
+		if isClusterAdmin(b.RoleRef) {
+			result.addSubjects(b.Subjects)
+		}
+	}
+	for _, b := range roleBindings {
+		if !isClusterAdmin(b.RoleRef) {
+			continue
+		}
+		// Service accounts bound in a RoleBinding default to its namespace
+		subjects := make([]rbacv1.Subject, 0, len(b.Subjects))
+		for _, s := range b.Subjects {
+			if s.Kind == rbacv1.ServiceAccountKind && s.Namespace == "" {
+				s.Namespace = b.Namespace
+			}
+			subjects = append(subjects, s)
+		}
+		result.addSubjects(subjects)
+	}
+	return result
+}
+
+func (p *pod) ServiceAccountName() string {
+	if p.Spec.ServiceAccountName == "" {
+		return defaultServiceAccount
+	}
+	return p.Spec.ServiceAccountName
+}
+
+// AutomountServiceAccountToken says whether the token of the pod's service
+// account is mounted in it.  The pod's setting takes precedence over the
+// service account's, and it is mounted unless either says otherwise.
+func (p *pod) AutomountServiceAccountToken(sa *apiv1.ServiceAccount) bool {
+	if p.Spec.AutomountServiceAccountToken != nil {
+		return *p.Spec.AutomountServiceAccountToken
+	}
+	if sa != nil && sa.AutomountServiceAccountToken != nil {
+		return *sa.AutomountServiceAccountToken
+	}
+	return true
+}
+
+func (p *pod) ImagePullSecrets() []string {
+	secrets := make([]string, 0, len(p.Spec.ImagePullSecrets))
+	for _, s := range p.Spec.ImagePullSecrets {
+		secrets = append(secrets, s.Name)
+	}
+	return secrets
+}
+
+// withServiceAccount adds what the pod can do with the API server by way
+// of its service account to its node.
+func withServiceAccount(node report.Node, p Pod, sa *apiv1.ServiceAccount, clusterAdmin bool) report.Node {
+	mounted := p.AutomountServiceAccountToken(sa)
+	latests := map[string]string{
+		ServiceAccount: p.ServiceAccountName(),
+		AutomountToken: strconv.FormatBool(mounted),
+		ClusterAdmin:   strconv.FormatBool(clusterAdmin),
+	}
+	if secrets := p.ImagePullSecrets(); len(secrets) > 0 {
+		latests[ImagePullSecrets] = strings.Join(secrets, ", ")
+	}
+	if mounted && p.ServiceAccountName() == defaultServiceAccount {
+		latests[DefaultSATokenMounted] = "true"
+	}
+	return node.WithLatests(latests)
+}
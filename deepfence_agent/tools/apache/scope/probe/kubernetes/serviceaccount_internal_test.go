@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMakeClusterAdmins(t *testing.T) {
+	clusterAdmin := rbacv1.RoleRef{Kind: "ClusterRole", Name: clusterAdminRole}
+	admins := makeClusterAdmins(
+		[]*rbacv1.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "local-admin", Namespace: "ci"},
+				RoleRef:    clusterAdmin,
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "runner"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "view", Namespace: "ci"},
+				RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "viewer"}},
+			},
+		},
+		[]*rbacv1.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "admins"},
+				RoleRef:    clusterAdmin,
+				Subjects: []rbacv1.Subject{
+					{Kind: rbacv1.ServiceAccountKind, Name: "tiller", Namespace: "kube-system"},
+					{Kind: rbacv1.GroupKind, Name: "system:serviceaccounts:dev"},
+					{Kind: rbacv1.UserKind, Name: "alice"},
+				},
+			},
+		},
+	)
+
+	for _, tc := range []struct {
+		namespace, name string
+		want            bool
+	}{
+		{"ci", "runner", true},
+		{"ci", "viewer", false},
+		{"kube-system", "tiller", true},
+		{"kube-system", "default", false},
+		{"dev", "anything", true},
+		{"", "alice", false},
+	} {
+		if have := admins.has(tc.namespace, tc.name); have != tc.want {
+			t.Errorf("%s/%s: expected cluster admin=%v, got %v", tc.namespace, tc.name, tc.want, have)
+		}
+	}
+}
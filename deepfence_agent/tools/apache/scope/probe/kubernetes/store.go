@@ -1,8 +1,12 @@
 package kubernetes
 
 import (
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/weaveworks/common/mtime"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -92,3 +96,131 @@ func (e *eventStore) Replace(os []interface{}, ver string) error {
 
 	return e.Store.Replace(os, ver)
 }
+
+// invalidatingStore is a Store which calls invalidate after an object is
+// added, removed or updated, so that what's computed from the store isn't
+// computed again from what it held before.
+type invalidatingStore struct {
+	invalidate func()
+	cache.Store
+}
+
+// newInvalidatingStore creates a new Store which calls invalidate after every
+// change.
+func newInvalidatingStore(invalidate func(), keyFunc cache.KeyFunc) cache.Store {
+	return &invalidatingStore{invalidate: invalidate, Store: cache.NewStore(keyFunc)}
+}
+
+func (s *invalidatingStore) Add(o interface{}) error {
+	defer s.invalidate()
+	return s.Store.Add(o)
+}
+
+func (s *invalidatingStore) Update(o interface{}) error {
+	defer s.invalidate()
+	return s.Store.Update(o)
+}
+
+func (s *invalidatingStore) Delete(o interface{}) error {
+	defer s.invalidate()
+	return s.Store.Delete(o)
+}
+
+func (s *invalidatingStore) Replace(os []interface{}, ver string) error {
+	defer s.invalidate()
+	return s.Store.Replace(os, ver)
+}
+
+// boundedEventStore is a Store of events which holds only those which last
+// happened less than maxAge ago, and at most maxCount of them, dropping the
+// oldest, so that a burst of events can't grow the probe without bound.
+type boundedEventStore struct {
+	mtx      sync.Mutex
+	maxAge   time.Duration
+	maxCount int
+	cache.Store
+}
+
+// newBoundedEventStore creates a new Store of at most maxCount events, of
+// less than maxAge ago.  A zero maxAge keeps events of any age.
+func newBoundedEventStore(maxAge time.Duration, maxCount int) *boundedEventStore {
+	return &boundedEventStore{
+		maxAge:   maxAge,
+		maxCount: maxCount,
+		Store:    cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
+}
+
+func (s *boundedEventStore) Add(o interface{}) error {
+	return s.Update(o)
+}
+
+func (s *boundedEventStore) Update(o interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if e, ok := o.(*apiv1.Event); ok && eventExpired(e, s.maxAge, mtime.Now()) {
+		if _, exists, _ := s.Store.Get(o); exists {
+			return s.Store.Delete(o)
+		}
+		return nil
+	}
+	if err := s.Store.Update(o); err != nil {
+		return err
+	}
+	if len(s.Store.ListKeys()) > s.maxCount {
+		s.bound(mtime.Now())
+	}
+	return nil
+}
+
+func (s *boundedEventStore) Delete(o interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.Store.Delete(o)
+}
+
+func (s *boundedEventStore) Replace(os []interface{}, ver string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.Store.Replace(os, ver); err != nil {
+		return err
+	}
+	s.bound(mtime.Now())
+	return nil
+}
+
+// Events returns the events which haven't expired as of now, dropping
+// those which have.
+func (s *boundedEventStore) Events(now time.Time) []*apiv1.Event {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.bound(now)
+}
+
+// bound drops the expired events, and the oldest of the rest beyond
+// maxCount, returning those which are kept.  It must be
+// called with the lock held.
+func (s *boundedEventStore) bound(now time.Time) []*apiv1.Event {
+	events := make([]*apiv1.Event, 0, len(s.Store.ListKeys()))
+	for _, o := range s.Store.List() {
+		e, ok := o.(*apiv1.Event)
+		if !ok {
+			continue
+		}
+		if eventExpired(e, s.maxAge, now) {
+			s.Store.Delete(e)
+			continue
+		}
+		events = append(events, e)
+	}
+	if len(events) <= s.maxCount {
+		return events
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventLastSeen(events[i]).After(eventLastSeen(events[j]))
+	})
+	for _, e := range events[s.maxCount:] {
+		s.Store.Delete(e)
+	}
+	return events[:s.maxCount]
+}
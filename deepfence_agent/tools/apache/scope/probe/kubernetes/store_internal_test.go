@@ -0,0 +1,83 @@
+package kubernetes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInvalidatingStore(t *testing.T) {
+	var store cache.Store
+	// What's seen on invalidation is the store after the change
+	var seen []int
+	store = newInvalidatingStore(func() { seen = append(seen, len(store.List())) }, cache.MetaNamespaceKeyFunc)
+	sa := &apiv1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "sa"}}
+	store.Add(sa)
+	store.Update(sa)
+	store.Delete(sa)
+	store.Replace([]interface{}{sa}, "1")
+	want := []int{1, 1, 0, 1}
+	if len(seen) != len(want) {
+		t.Fatalf("want %v, have %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("want %v, have %v", want, seen)
+		}
+	}
+}
+
+func TestBoundedEventStore(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	event := func(name string, ago time.Duration) *apiv1.Event {
+		return &apiv1.Event{
+			ObjectMeta:    metav1.ObjectMeta{Namespace: "ns", Name: name},
+			LastTimestamp: metav1.NewTime(now.Add(-ago)),
+		}
+	}
+	names := func(events []*apiv1.Event) map[string]bool {
+		result := map[string]bool{}
+		for _, e := range events {
+			result[e.Name] = true
+		}
+		return result
+	}
+
+	store := newBoundedEventStore(time.Hour, 3)
+	// Expired events aren't kept, and expiring ones are dropped
+	store.Add(event("old", 2*time.Hour))
+	store.Add(event("a", 50*time.Minute))
+	store.Update(event("a", 2*time.Hour))
+	if have := store.List(); len(have) != 0 {
+		t.Errorf("Expected no events, have %v", have)
+	}
+
+	// Beyond the cap, the oldest are dropped
+	for i := 0; i < 4; i++ {
+		store.Add(event(fmt.Sprint(i), time.Duration(4-i)*10*time.Minute))
+	}
+	if have := names(store.Events(now)); len(have) != 3 || have["0"] {
+		t.Errorf("Expected the three latest events, have %v", have)
+	}
+	store.Replace([]interface{}{event("4", 0), event("5", 0), event("6", 0), event("7", 30*time.Minute)}, "1")
+	if have := names(store.Events(now)); len(have) != 3 || have["7"] {
+		t.Errorf("Expected the three latest events, have %v", have)
+	}
+
+	// Events expire as time passes
+	store.Add(event("8", 0))
+	if have := names(store.Events(now.Add(90 * time.Minute))); len(have) != 0 {
+		t.Errorf("Expected the events to have expired, have %v", have)
+	}
+	if have := store.List(); len(have) != 0 {
+		t.Errorf("Expected the expired events to be dropped, have %v", have)
+	}
+}
@@ -0,0 +1,129 @@
+package kubernetes
+
+import (
+	apiappsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// maxAnnotationLength bounds the size of the annotations we cache.
+	// Longer ones are generated by tools, and never reported.
+	maxAnnotationLength   = 1024
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// TransformFunc strips an object of what isn't reported before it is
+// cached.  It may modify the object.
+type TransformFunc func(interface{}) interface{}
+
+// transforms says how to strip the objects of high cardinality resources.
+var transforms = map[string]TransformFunc{
+	"pods":        stripPod,
+	"replicasets": stripReplicaSet,
+}
+
+type transformStore struct {
+	cache.Store
+	transform TransformFunc
+}
+
+// NewTransformStore creates a new Store which transforms objects before
+// storing them.
+func NewTransformStore(store cache.Store, transform TransformFunc) cache.Store {
+	return &transformStore{Store: store, transform: transform}
+}
+
+func (t *transformStore) Add(o interface{}) error {
+	return t.Store.Add(t.transform(o))
+}
+
+func (t *transformStore) Update(o interface{}) error {
+	return t.Store.Update(t.transform(o))
+}
+
+func (t *transformStore) Replace(os []interface{}, ver string) error {
+	transformed := make([]interface{}, 0, len(os))
+	for _, o := range os {
+		transformed = append(transformed, t.transform(o))
+	}
+	return t.Store.Replace(transformed, ver)
+}
+
+func stripObjectMeta(m *metav1.ObjectMeta) {
+	for k, v := range m.Annotations {
+		if k == lastAppliedAnnotation || len(v) > maxAnnotationLength {
+			delete(m.Annotations, k)
+		}
+	}
+	m.Initializers = nil
+}
+
+// stripPod drops the parts of a pod the reporter doesn't use: most of the
+// definition of its containers and volumes, and the images they run.
+func stripPod(o interface{}) interface{} {
+	p, ok := o.(*apiv1.Pod)
+	if !ok {
+		return o
+	}
+	stripObjectMeta(&p.ObjectMeta)
+
+	containers := make([]apiv1.Container, 0, len(p.Spec.Containers))
+	for _, c := range p.Spec.Containers {
+		containers = append(containers, apiv1.Container{Name: c.Name, Resources: c.Resources})
+	}
+	p.Spec.Containers = containers
+	p.Spec.InitContainers = nil
+
+	volumes := []apiv1.Volume{}
+	for _, v := range p.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			volumes = append(volumes, apiv1.Volume{
+				Name:         v.Name,
+				VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: v.PersistentVolumeClaim},
+			})
+		}
+	}
+	p.Spec.Volumes = volumes
+	p.Spec.HostAliases = nil
+	p.Spec.DNSConfig = nil
+
+	for i := range p.Status.ContainerStatuses {
+		cs := &p.Status.ContainerStatuses[i]
+		cs.Image, cs.ImageID = "", ""
+		cs.LastTerminationState = apiv1.ContainerState{}
+	}
+	p.Status.InitContainerStatuses = nil
+	return p
+}
+
+// stripEvent keeps what warningEventRows summarizes.
+func stripEvent(o interface{}) interface{} {
+	e, ok := o.(*apiv1.Event)
+	if !ok {
+		return o
+	}
+	e.Annotations, e.Labels = nil, nil
+	e.Message = truncateMessage(e.Message)
+	e.Related = nil
+	e.Source = apiv1.EventSource{}
+	e.ReportingController, e.ReportingInstance, e.Action = "", "", ""
+	return e
+}
+
+// stripReplicaSet keeps what is needed to follow the owners of pods.
+func stripReplicaSet(o interface{}) interface{} {
+	rs, ok := o.(*apiappsv1.ReplicaSet)
+	if !ok {
+		return o
+	}
+	return &apiappsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            rs.Name,
+			Namespace:       rs.Namespace,
+			UID:             rs.UID,
+			OwnerReferences: rs.OwnerReferences,
+		},
+	}
+}
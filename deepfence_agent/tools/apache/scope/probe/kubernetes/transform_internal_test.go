@@ -0,0 +1,132 @@
+package kubernetes
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/common/test"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// syntheticPod is a pod the size of those typically deployed by helm
+// charts: several containers with environments, probes and volumes.
+func syntheticPod(i int) *apiv1.Pod {
+	containers := []apiv1.Container{}
+	statuses := []apiv1.ContainerStatus{}
+	for _, name := range []string{"app", "sidecar", "proxy"} {
+		env := []apiv1.EnvVar{}
+		for j := 0; j < 20; j++ {
+			env = append(env, apiv1.EnvVar{Name: fmt.Sprintf("SETTING_%d", j), Value: strings.Repeat("v", 40)})
+		}
+		containers = append(containers, apiv1.Container{
+			Name:    name,
+			Image:   "registry.example.com/team/" + name + ":1.2.3",
+			Command: []string{"/bin/" + name, "--config", "/etc/" + name + "/config.yaml"},
+			Env:     env,
+			Resources: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("100m")},
+				Limits:   apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			VolumeMounts: []apiv1.VolumeMount{{Name: "config", MountPath: "/etc/" + name}},
+			LivenessProbe: &apiv1.Probe{Handler: apiv1.Handler{
+				HTTPGet: &apiv1.HTTPGetAction{Path: "/healthz"},
+			}},
+		})
+		statuses = append(statuses, apiv1.ContainerStatus{
+			Name:         name,
+			ContainerID:  fmt.Sprintf("docker://%064d", i),
+			Image:        "registry.example.com/team/" + name + ":1.2.3",
+			ImageID:      "docker-pullable://registry.example.com/team/" + name + "@sha256:" + strings.Repeat("0", 64),
+			RestartCount: 2,
+		})
+	}
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("app-%d", i),
+			Namespace: "default",
+			UID:       types.UID(fmt.Sprintf("uid-%d", i)),
+			Labels:    map[string]string{"app": "app", "release": "prod"},
+			Annotations: map[string]string{
+				lastAppliedAnnotation:  strings.Repeat("{}", 2000),
+				"prometheus.io/scrape": "true",
+			},
+		},
+		Spec: apiv1.PodSpec{
+			NodeName:           "node1",
+			ServiceAccountName: "app",
+			Containers:         containers,
+			InitContainers:     containers[:1],
+			Volumes: []apiv1.Volume{
+				{Name: "config", VolumeSource: apiv1.VolumeSource{
+					ConfigMap: &apiv1.ConfigMapVolumeSource{LocalObjectReference: apiv1.LocalObjectReference{Name: "config"}},
+				}},
+				{Name: "data", VolumeSource: apiv1.VolumeSource{
+					PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+				}},
+			},
+			ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "registry"}},
+		},
+		Status: apiv1.PodStatus{
+			Phase:             apiv1.PodRunning,
+			PodIP:             "10.0.0.1",
+			ContainerStatuses: statuses,
+		},
+	}
+}
+
+func TestStripPodKeepsReportedFields(t *testing.T) {
+	mtime.NowForce(time.Now())
+	defer mtime.NowReset()
+
+	want := NewPod(syntheticPod(1)).GetNode("probe")
+	have := NewPod(stripPod(syntheticPod(1)).(*apiv1.Pod)).GetNode("probe")
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected stripping the pod not to change its node: %v", test.Diff(want, have))
+	}
+
+	p := stripPod(syntheticPod(1)).(*apiv1.Pod)
+	if _, ok := p.Annotations[lastAppliedAnnotation]; ok {
+		t.Errorf("Expected last applied configuration to be dropped")
+	}
+	if len(p.Spec.Volumes) != 1 || p.Spec.Containers[0].Env != nil {
+		t.Errorf("Expected volumes and containers to be stripped, got %v", p.Spec)
+	}
+}
+
+// BenchmarkPodCacheHeap measures the heap used by a cache of 10k pods,
+// as stored before and after stripping them.
+func BenchmarkPodCacheHeap(b *testing.B) {
+	for _, bm := range []struct {
+		name      string
+		transform TransformFunc
+	}{
+		{"full", func(o interface{}) interface{} { return o }},
+		{"stripped", stripPod},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				store := NewTransformStore(cache.NewStore(cache.MetaNamespaceKeyFunc), bm.transform)
+				for i := 0; i < 10000; i++ {
+					store.Add(syntheticPod(i))
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/10000, "heap-bytes/pod")
+				runtime.KeepAlive(store)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package plugins_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/probe/plugins"
+	"github.com/weaveworks/scope/probe/plugins/sdk"
+	"github.com/weaveworks/scope/report"
+)
+
+// grpcPlugin reports a host with the number of times its control was
+// invoked, and streams a report when updated.
+type grpcPlugin struct {
+	invoked int
+	updated chan int
+}
+
+func (p *grpcPlugin) Spec() xfer.PluginSpec {
+	return xfer.PluginSpec{ID: "grpc-plugin", Label: "gRPC plugin"}
+}
+
+func (p *grpcPlugin) report(n int) report.Report {
+	rpt := report.MakeReport()
+	rpt.Host.Controls.AddControl(report.Control{ID: "invoke", Human: "Invoke"})
+	rpt.Host = rpt.Host.WithMetadataTemplates(report.MetadataTemplates{
+		"invoked": {ID: "invoked", Label: "Invoked", From: report.FromLatest},
+	})
+	rpt.Host.AddNode(report.MakeNodeWith("host1", map[string]string{
+		"invoked": strconv.Itoa(n),
+	}).WithLatestActiveControls("invoke"))
+	return rpt
+}
+
+func (p *grpcPlugin) Report(ctx context.Context) (report.Report, error) {
+	return p.report(p.invoked), nil
+}
+
+func (p *grpcPlugin) HandleControl(ctx context.Context, req xfer.Request) (xfer.Response, *report.Report) {
+	if req.NodeID != "host1" {
+		return xfer.ResponseErrorf("no such node %s", req.NodeID), nil
+	}
+	p.invoked++
+	rpt := p.report(p.invoked)
+	return xfer.Response{Value: "invoked"}, &rpt
+}
+
+func (p *grpcPlugin) Updates(ctx context.Context, updates chan<- report.Report) error {
+	for {
+		select {
+		case n := <-p.updated:
+			select {
+			case updates <- p.report(n):
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+type chanPublisher chan report.Report
+
+func (p chanPublisher) Publish(rpt report.Report) { p <- rpt }
+
+func invoked(t *testing.T, rpt report.Report) string {
+	node, ok := rpt.Host.Nodes["host1"]
+	if !ok {
+		t.Fatalf("host1 not in report: %v", rpt.Host.Nodes)
+	}
+	n, _ := node.Latest.Lookup("invoked")
+	return n
+}
+
+func TestRegistryGRPCPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plugin := &grpcPlugin{updated: make(chan int)}
+	served := make(chan error, 1)
+	go func() { served <- sdk.Serve(ctx, dir, plugin) }()
+	socket := filepath.Join(dir, "grpc-plugin.grpc")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	publisher := make(chanPublisher, 1)
+	r, err := plugins.NewRegistry(dir, "1", map[string]string{"probe_id": "probe1", "api_version": "1"}, handlerRegistry, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The plugin's report is merged into the registry's
+	rpt, err := r.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec, ok := rpt.Plugins.Lookup("grpc-plugin"); !ok || spec.APIVersion != "1" || spec.Label != "gRPC plugin" {
+		t.Fatalf("unexpected plugins: %v", rpt.Plugins)
+	}
+	if n := invoked(t, rpt); n != "0" {
+		t.Fatalf("expected 0 invocations, got %s", n)
+	}
+	if _, ok := rpt.Host.MetadataTemplates[report.PluginTemplateKey("grpc-plugin", "invoked")]; !ok || len(rpt.Host.MetadataTemplates) != 1 {
+		t.Fatalf("expected the plugin's templates to be namespaced, got %v", rpt.Host.MetadataTemplates)
+	}
+	var control string
+	for id := range rpt.Host.Controls {
+		control = id
+	}
+	if control == "" || control == "invoke" {
+		t.Fatalf("expected the plugin's control to be registered, got %v", rpt.Host.Controls)
+	}
+
+	// Controls are dispatched to the plugin, and its shortcut report published
+	res := handlerRegistry.HandleControlRequest(xfer.Request{NodeID: "host1", Control: control})
+	if res.Error != "" || res.Value != "invoked" {
+		t.Fatalf("unexpected response: %v", res)
+	}
+	select {
+	case shortcut := <-publisher:
+		if !shortcut.Shortcut || invoked(t, shortcut) != "1" {
+			t.Fatalf("unexpected shortcut report: %v", shortcut)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no shortcut report published")
+	}
+	res = handlerRegistry.HandleControlRequest(xfer.Request{NodeID: "host2", Control: control})
+	if res.Error != "no such node host2" {
+		t.Fatalf("unexpected response: %v", res)
+	}
+
+	// Streamed updates are published
+	select {
+	case plugin.updated <- 5:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates not streamed")
+	}
+	select {
+	case update := <-publisher:
+		if !update.Shortcut || invoked(t, update) != "5" {
+			t.Fatalf("unexpected update: %v", update)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update published")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
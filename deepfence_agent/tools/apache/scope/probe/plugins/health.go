@@ -0,0 +1,168 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys for the plugins table of the host node.
+const (
+	PluginPrefix    = report.HostPluginPrefix
+	PluginLabel     = "host_plugin_label"
+	PluginStatus    = "host_plugin_status"
+	PluginLatency   = "host_plugin_latency_ms"
+	PluginFailures  = "host_plugin_failures"
+	PluginLastError = "host_plugin_last_error"
+)
+
+// PluginTableTemplates is the table of the health of plugins on the host
+// node.
+var PluginTableTemplates = report.TableTemplates{
+	PluginPrefix: {
+		ID:     PluginPrefix,
+		Label:  "Plugins",
+		Type:   report.MulticolumnTableType,
+		Prefix: PluginPrefix,
+		Columns: []report.Column{
+			{ID: PluginLabel, Label: "Plugin"},
+			{ID: PluginStatus, Label: "Status"},
+			{ID: PluginLatency, Label: "Latency (ms)", DataType: report.Number},
+			{ID: PluginFailures, Label: "Failures", DataType: report.Number},
+			{ID: PluginLastError, Label: "Last error"},
+		},
+	},
+}
+
+// Plugins failing to report quarantineThreshold times in a row are
+// quarantined: not asked for reports for quarantineInitial, doubling each
+// time they fail again, up to quarantineMax.  Exposed for testing.
+var (
+	quarantineThreshold = 3
+	quarantineInitial   = 10 * time.Second
+	quarantineMax       = 5 * time.Minute
+)
+
+// PluginHealth is the health of a plugin's reports.
+type PluginHealth struct {
+	ID                  string        `json:"id"`
+	Socket              string        `json:"socket"`
+	Status              string        `json:"status"`
+	Latency             time.Duration `json:"latency"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastError           string        `json:"lastError,omitempty"`
+	QuarantinedUntil    time.Time     `json:"quarantinedUntil,omitempty"`
+}
+
+// pluginHealth is what's tracked of the health of a plugin.
+type pluginHealth struct {
+	latency             time.Duration
+	consecutiveFailures int
+	lastError           string
+	quarantinedUntil    time.Time
+	quarantine          time.Duration
+}
+
+// quarantined returns whether the plugin isn't to be asked for a report
+// at now.
+func (h *pluginHealth) quarantined(now time.Time) bool {
+	return now.Before(h.quarantinedUntil)
+}
+
+// record records a report of the plugin, which took latency and failed
+// with err if not nil, quarantining the plugin once it's failed
+// quarantineThreshold times in a row, for twice as long as last time.
+func (h *pluginHealth) record(socket string, now time.Time, latency time.Duration, err error) {
+	h.latency = latency
+	if err == nil {
+		if h.quarantine > 0 {
+			log.Infof("plugins: %s: recovered after %d failures", socket, h.consecutiveFailures)
+		}
+		*h = pluginHealth{latency: latency}
+		return
+	}
+	h.consecutiveFailures++
+	h.lastError = err.Error()
+	if h.consecutiveFailures < quarantineThreshold {
+		return
+	}
+	if h.quarantine == 0 {
+		h.quarantine = quarantineInitial
+	} else if h.quarantine *= 2; h.quarantine > quarantineMax {
+		h.quarantine = quarantineMax
+	}
+	h.quarantinedUntil = now.Add(h.quarantine)
+	log.Warningf("plugins: %s: quarantined for %v after %d failures: %v", socket, h.quarantine, h.consecutiveFailures, err)
+}
+
+func (h *pluginHealth) status(now time.Time) string {
+	switch {
+	case h.quarantined(now):
+		return "quarantined"
+	case h.consecutiveFailures > 0:
+		return "failing"
+	}
+	return "ok"
+}
+
+// Health returns the health of the plugins, by socket.
+func (r *Registry) Health() []PluginHealth {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.health(mtime.Now())
+}
+
+// health returns the health of the plugins; the registry must be locked.
+func (r *Registry) health(now time.Time) []PluginHealth {
+	health := make([]PluginHealth, 0, len(r.pluginsBySocket))
+	for _, p := range r.sorted() {
+		health = append(health, PluginHealth{
+			ID:                  p.PluginSpec.ID,
+			Socket:              p.socket,
+			Status:              p.health.status(now),
+			Latency:             p.health.latency,
+			ConsecutiveFailures: p.health.consecutiveFailures,
+			LastError:           p.health.lastError,
+			QuarantinedUntil:    p.health.quarantinedUntil,
+		})
+	}
+	return health
+}
+
+// ServeHTTP serves the health of the plugins, as JSON.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.Health()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// addHealth adds the health of the plugins to the host node.
+func addHealth(rpt *report.Report, hostNodeID string, health []PluginHealth) {
+	rows := make([]report.Row, 0, len(health))
+	for _, h := range health {
+		entries := map[string]string{
+			PluginLabel:    h.ID,
+			PluginStatus:   h.Status,
+			PluginLatency:  strconv.FormatInt(int64(h.Latency/time.Millisecond), 10),
+			PluginFailures: strconv.Itoa(h.ConsecutiveFailures),
+		}
+		if h.LastError != "" {
+			entries[PluginLastError] = h.LastError
+		}
+		rows = append(rows, report.Row{ID: h.ID, Entries: entries})
+	}
+	rpt.Host.AddNode(report.MakeNode(hostNodeID).AddPrefixMulticolumnTable(PluginPrefix, rows))
+	rpt.Host = rpt.Host.WithTableTemplates(PluginTableTemplates)
+}
+
+// errQuarantined is the error of quarantined plugins' reports.
+func errQuarantined(h *pluginHealth) error {
+	return fmt.Errorf("quarantined until %s after %d failures: %s", h.quarantinedUntil.Format(time.RFC3339), h.consecutiveFailures, h.lastError)
+}
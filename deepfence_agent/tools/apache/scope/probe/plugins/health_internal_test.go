@@ -0,0 +1,138 @@
+package plugins
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+// servePlugin serves an HTTP plugin on a unix socket in dir.
+func servePlugin(t *testing.T, dir, id string, handler http.Handler) func() {
+	l, err := net.Listen("unix", filepath.Join(dir, id+".sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	return func() { server.Close() }
+}
+
+func TestRegistryQuarantinesMisbehavingPlugins(t *testing.T) {
+	oldThreshold, oldInitial := quarantineThreshold, quarantineInitial
+	quarantineThreshold, quarantineInitial = 2, time.Minute
+	defer func() { quarantineThreshold, quarantineInitial = oldThreshold, oldInitial }()
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer servePlugin(t, dir, "good", stringHandler(http.StatusOK, `{"Plugins":[{"id":"good","label":"good","interfaces":["reporter"],"api_version":"1"}]}`))()
+	// The slow plugin hangs until the request is cancelled, until it's fixed
+	var slowRequests int32
+	var fixed atomic.Value
+	fixed.Store(false)
+	defer servePlugin(t, dir, "slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&slowRequests, 1)
+		if !fixed.Load().(bool) {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{"Plugins":[{"id":"slow","label":"slow","interfaces":["reporter"],"api_version":"1"}]}`))
+	}))()
+
+	r, err := NewRegistry(dir, "1", map[string]string{"host_id": "host1"}, controls.NewDefaultHandlerRegistry(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	health := func() map[string]PluginHealth {
+		byID := map[string]PluginHealth{}
+		for _, h := range r.Health() {
+			byID[h.ID] = h
+		}
+		return byID
+	}
+	reportOnce := func() report.Report {
+		start := time.Now()
+		rpt, err := r.Report()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The slow plugin doesn't delay the report
+		if elapsed := time.Since(start); elapsed > 2*pluginTimeout {
+			t.Fatalf("report took %v", elapsed)
+		}
+		if spec, ok := rpt.Plugins.Lookup("good"); !ok || spec.Status != "ok" {
+			t.Fatalf("good plugin's report missing: %v", rpt.Plugins)
+		}
+		return rpt
+	}
+
+	reportOnce()
+	if h := health()["slow"]; h.ConsecutiveFailures != 1 || h.Status != "failing" || !strings.Contains(h.LastError, "no report within") {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+	if h := health()["good"]; h.ConsecutiveFailures != 0 || h.Status != "ok" {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+
+	// Quarantined after the second failure, and not asked again
+	rpt := reportOnce()
+	if h := health()["slow"]; h.Status != "quarantined" || !h.QuarantinedUntil.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+	rows := rpt.Host.Nodes[report.MakeHostNodeID("host1")].ExtractMulticolumnTable(PluginTableTemplates[PluginPrefix])
+	if len(rows) != 2 || rows[1].ID != "slow" || rows[1].Entries[PluginStatus] != "quarantined" || rows[1].Entries[PluginFailures] != "2" {
+		t.Fatalf("unexpected plugins table: %v", rows)
+	}
+	reportOnce()
+	if n := atomic.LoadInt32(&slowRequests); n != 2 {
+		t.Fatalf("expected 2 requests to the slow plugin, got %d", n)
+	}
+
+	// Re-probed once the quarantine is over, and quarantined for twice as long
+	now = now.Add(time.Minute)
+	mtime.NowForce(now)
+	reportOnce()
+	if h := health()["slow"]; h.ConsecutiveFailures != 3 || !h.QuarantinedUntil.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+
+	// Recovers once fixed
+	fixed.Store(true)
+	now = now.Add(2 * time.Minute)
+	mtime.NowForce(now)
+	reportOnce()
+	if h := health()["slow"]; h.ConsecutiveFailures != 0 || h.Status != "ok" || h.LastError != "" {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/plugins", nil))
+	var served []PluginHealth
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 2 || served[0].ID != "good" || served[1].Status != "ok" {
+		t.Fatalf("unexpected plugins served: %+v", served)
+	}
+}
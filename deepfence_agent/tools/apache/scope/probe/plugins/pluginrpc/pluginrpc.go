@@ -0,0 +1,233 @@
+// Package pluginrpc is the gRPC API of probe plugins, as plugin.proto
+// defines it: its messages, a client for the probe, and the service for
+// plugins, which the sdk package implements for plugin authors.
+package pluginrpc
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// SocketExt is the extension of the sockets of gRPC plugins in the plugins
+// directory, which is followed by the ID of the plugin.
+const SocketExt = ".grpc"
+
+// The messages of plugin.proto, by reflection on their tags, as for the
+// protobuf encoding of reports.
+type (
+	// ReportRequest asks a plugin for its report.
+	ReportRequest struct {
+		Handshake map[string]string `protobuf:"bytes,1,rep,name=handshake,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	}
+	// ReportResponse is a report of a plugin, as EncodeReport encodes it.
+	ReportResponse struct {
+		Report []byte `protobuf:"bytes,1,opt,name=report,proto3"`
+	}
+	// ControlRequest asks a plugin to handle a control.
+	ControlRequest struct {
+		Handshake   map[string]string `protobuf:"bytes,1,rep,name=handshake,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+		AppID       string            `protobuf:"bytes,2,opt,name=app_id,proto3"`
+		NodeID      string            `protobuf:"bytes,3,opt,name=node_id,proto3"`
+		Control     string            `protobuf:"bytes,4,opt,name=control,proto3"`
+		ControlArgs map[string]string `protobuf:"bytes,5,rep,name=control_args,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	}
+	// ControlResponse is the response of a plugin to a control: the JSON of
+	// an xfer.Response, and a shortcut report, if any, as EncodeReport
+	// encodes it.
+	ControlResponse struct {
+		Response       []byte `protobuf:"bytes,1,opt,name=response,proto3"`
+		ShortcutReport []byte `protobuf:"bytes,2,opt,name=shortcut_report,proto3"`
+	}
+	// StreamUpdatesRequest asks a plugin to stream its reports as things
+	// change.
+	StreamUpdatesRequest struct {
+		Handshake map[string]string `protobuf:"bytes,1,rep,name=handshake,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	}
+)
+
+func (m *ReportRequest) Reset()         { *m = ReportRequest{} }
+func (m *ReportRequest) String() string { return proto.CompactTextString(m) }
+func (*ReportRequest) ProtoMessage()    {}
+
+func (m *ReportResponse) Reset()         { *m = ReportResponse{} }
+func (m *ReportResponse) String() string { return proto.CompactTextString(m) }
+func (*ReportResponse) ProtoMessage()    {}
+
+func (m *ControlRequest) Reset()         { *m = ControlRequest{} }
+func (m *ControlRequest) String() string { return proto.CompactTextString(m) }
+func (*ControlRequest) ProtoMessage()    {}
+
+func (m *ControlResponse) Reset()         { *m = ControlResponse{} }
+func (m *ControlResponse) String() string { return proto.CompactTextString(m) }
+func (*ControlResponse) ProtoMessage()    {}
+
+func (m *StreamUpdatesRequest) Reset()         { *m = StreamUpdatesRequest{} }
+func (m *StreamUpdatesRequest) String() string { return proto.CompactTextString(m) }
+func (*StreamUpdatesRequest) ProtoMessage()    {}
+
+// EncodeReport encodes a report for ReportResponse and ControlResponse, as
+// a gzipped protobuf.
+func EncodeReport(rpt report.Report) ([]byte, error) {
+	buf, err := rpt.WriteProtobuf()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeReport decodes a report encoded by EncodeReport.
+func DecodeReport(ctx context.Context, buf []byte) (report.Report, error) {
+	rpt, err := report.MakeFromBinary(ctx, bytes.NewReader(buf), true, 3)
+	if err != nil {
+		return report.MakeReport(), err
+	}
+	return *rpt, nil
+}
+
+const serviceName = "plugin.Plugin"
+
+// PluginClient is the client of the Plugin service.
+type PluginClient interface {
+	Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error)
+	HandleControl(ctx context.Context, in *ControlRequest, opts ...grpc.CallOption) (*ControlResponse, error)
+	StreamUpdates(ctx context.Context, in *StreamUpdatesRequest, opts ...grpc.CallOption) (UpdatesClient, error)
+}
+
+// UpdatesClient receives the reports streamed by StreamUpdates.
+type UpdatesClient interface {
+	Recv() (*ReportResponse, error)
+	grpc.ClientStream
+}
+
+type pluginClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewPluginClient makes a client of the Plugin service of a connection.
+func NewPluginClient(cc *grpc.ClientConn) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error) {
+	out := new(ReportResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Report", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) HandleControl(ctx context.Context, in *ControlRequest, opts ...grpc.CallOption) (*ControlResponse, error) {
+	out := new(ControlResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/HandleControl", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) StreamUpdates(ctx context.Context, in *StreamUpdatesRequest, opts ...grpc.CallOption) (UpdatesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/StreamUpdates", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &updatesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type updatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *updatesClient) Recv() (*ReportResponse, error) {
+	m := new(ReportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PluginServer is the server of the Plugin service.
+type PluginServer interface {
+	Report(context.Context, *ReportRequest) (*ReportResponse, error)
+	HandleControl(context.Context, *ControlRequest) (*ControlResponse, error)
+	StreamUpdates(*StreamUpdatesRequest, UpdatesServer) error
+}
+
+// UpdatesServer sends the reports streamed by StreamUpdates.
+type UpdatesServer interface {
+	Send(*ReportResponse) error
+	grpc.ServerStream
+}
+
+// RegisterPluginServer registers the server of the Plugin service with a
+// gRPC server.
+func RegisterPluginServer(s *grpc.Server, srv PluginServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func reportHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Report"}
+	return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Report(ctx, req.(*ReportRequest))
+	})
+}
+
+func handleControlHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ControlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).HandleControl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/HandleControl"}
+	return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).HandleControl(ctx, req.(*ControlRequest))
+	})
+}
+
+func streamUpdatesHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamUpdatesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PluginServer).StreamUpdates(m, &updatesServer{stream})
+}
+
+type updatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *updatesServer) Send(m *ReportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Report", Handler: reportHandler},
+		{MethodName: "HandleControl", Handler: handleControlHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StreamUpdates", Handler: streamUpdatesHandler, ServerStreams: true},
+	},
+	Metadata: "plugin.proto",
+}
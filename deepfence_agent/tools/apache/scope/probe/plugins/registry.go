@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,20 +16,28 @@ import (
 	"time"
 
 	"context"
-	log "github.com/sirupsen/logrus"
 	"github.com/ugorji/go/codec"
 	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/weaveworks/common/backoff"
 	"github.com/weaveworks/common/fs"
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/common/logger"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/probe/plugins/pluginrpc"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("plugins")
+
 // Exposed for testing
 var (
 	transport                 = makeUnixRoundTripper
+	grpcTransport             = dialUnixGRPC
 	maxResponseBytes    int64 = 50 * 1024 * 1024
 	errResponseTooLarge       = fmt.Errorf("response must be shorter than 50MB")
 	validPluginName           = regexp.MustCompile("^[A-Za-z0-9]+([-][A-Za-z0-9]+)*$")
@@ -37,6 +46,9 @@ var (
 const (
 	pluginTimeout    = 500 * time.Millisecond
 	scanningInterval = 5 * time.Second
+	// How long to wait before streaming the updates of gRPC plugins again
+	// once their stream ends
+	updatesBackoff = time.Second
 )
 
 // ReportPublisher is an interface for publishing reports immediately
@@ -60,7 +72,8 @@ type Registry struct {
 }
 
 // NewRegistry creates a new registry which watches the given dir root for new
-// plugins, and adds them.
+// plugins, and adds them.  The health of the plugins is added to the node of
+// the host_id of the handshake metadata, if any.
 func NewRegistry(rootPath, apiVersion string, handshakeMetadata map[string]string, handlerRegistry *controls.HandlerRegistry, publisher ReportPublisher) (*Registry, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	r := &Registry{
@@ -118,13 +131,7 @@ func (r *Registry) scan() error {
 			pluginsByID[plugin.PluginSpec.ID] = plugin
 			continue
 		}
-		tr, err := transport(path, pluginTimeout)
-		if err != nil {
-			log.Warningf("plugins: error loading plugin %s: %v", path, err)
-			continue
-		}
-		client := &http.Client{Transport: tr, Timeout: pluginTimeout}
-		plugin, err := NewPlugin(r.context, path, client, r.apiVersion, r.handshakeMetadata)
+		plugin, err := r.loadPlugin(path)
 		if err != nil {
 			log.Warningf("plugins: error loading plugin %s: %v", path, err)
 			continue
@@ -147,6 +154,30 @@ func (r *Registry) scan() error {
 	return nil
 }
 
+// loadPlugin loads the plugin of a socket: a gRPC plugin if its name ends
+// in pluginrpc.SocketExt, and an HTTP plugin otherwise.
+func (r *Registry) loadPlugin(path string) (*Plugin, error) {
+	if filepath.Ext(path) == pluginrpc.SocketExt {
+		conn, err := grpcTransport(path, pluginTimeout)
+		if err != nil {
+			return nil, err
+		}
+		plugin, err := NewGRPCPlugin(r.context, path, conn, r.apiVersion, r.handshakeMetadata)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		plugin.streamUpdates(r.update)
+		return plugin, nil
+	}
+	tr, err := transport(path, pluginTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: tr, Timeout: pluginTimeout}
+	return NewPlugin(r.context, path, client, r.apiVersion, r.handshakeMetadata)
+}
+
 // sockets recursively finds all unix sockets under the path provided
 func (r *Registry) sockets(path string) ([]string, error) {
 	var (
@@ -181,14 +212,24 @@ func (r *Registry) sockets(path string) ([]string, error) {
 func (r *Registry) forEach(lock sync.Locker, f func(p *Plugin)) {
 	lock.Lock()
 	defer lock.Unlock()
+	for _, plugin := range r.sorted() {
+		f(plugin)
+	}
+}
+
+// sorted returns the plugins sorted by socket; the registry must be
+// locked.
+func (r *Registry) sorted() []*Plugin {
 	paths := []string{}
 	for path := range r.pluginsBySocket {
 		paths = append(paths, path)
 	}
 	sort.Strings(paths)
+	plugins := make([]*Plugin, 0, len(paths))
 	for _, path := range paths {
-		f(r.pluginsBySocket[path])
+		plugins = append(plugins, r.pluginsBySocket[path])
 	}
+	return plugins
 }
 
 // ForEach walks through all the plugins running f for each one.
@@ -210,20 +251,78 @@ func (r *Registry) Implementers(iface string, f func(p *Plugin)) {
 // Name implements the Reporter interface
 func (r *Registry) Name() string { return "plugins" }
 
-// Report implements the Reporter interface
+// fetchedReport is a report fetched from a plugin, and how long it took.
+type fetchedReport struct {
+	plugin  *Plugin
+	report  report.Report
+	err     error
+	latency time.Duration
+}
+
+// Report implements the Reporter interface.  Plugins are asked for their
+// reports at once, and those which don't respond within pluginTimeout are
+// left out, so that one slow plugin doesn't delay the report; quarantined
+// plugins aren't asked.  The templates of plugins are namespaced by plugin,
+// and resolved against the built-in ones by the app.
 func (r *Registry) Report() (report.Report, error) {
 	rpt := report.MakeReport()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := mtime.Now()
+	plugins := r.sorted()
+	// Buffered, as plugins may respond after being left out
+	fetched := make(chan fetchedReport, len(plugins))
+	pending := 0
+	for _, plugin := range plugins {
+		if plugin.health.quarantined(now) {
+			continue
+		}
+		pending++
+		go func(plugin *Plugin) {
+			start := time.Now()
+			rpt, err := plugin.fetchReport()
+			fetched <- fetchedReport{plugin: plugin, report: rpt, err: err, latency: time.Since(start)}
+		}(plugin)
+	}
+	reports := map[*Plugin]fetchedReport{}
+	timeout := time.NewTimer(pluginTimeout)
+	defer timeout.Stop()
+collect:
+	for ; pending > 0; pending-- {
+		select {
+		case f := <-fetched:
+			reports[f.plugin] = f
+		case <-timeout.C:
+			break collect
+		}
+	}
+
 	// All plugins are assumed to (and must) implement reporter
-	r.forEach(&r.lock, func(plugin *Plugin) {
-		pluginReport, err := plugin.Report()
-		if err != nil {
-			log.Errorf("plugins: %s: /report error: %v", plugin.socket, err)
+	for _, plugin := range plugins {
+		var pluginReport report.Report
+		if plugin.health.quarantined(now) {
+			pluginReport, _ = plugin.checkedReport(report.MakeReport(), errQuarantined(&plugin.health))
+		} else {
+			fetched, ok := reports[plugin]
+			if !ok {
+				fetched = fetchedReport{err: fmt.Errorf("no report within %v", pluginTimeout), latency: pluginTimeout}
+			}
+			var err error
+			pluginReport, err = plugin.checkedReport(fetched.report, fetched.err)
+			if err != nil {
+				log.Errorf("plugins: %s: /report error: %v", plugin.socket, err)
+			}
+			plugin.health.record(plugin.socket, now, fetched.latency, err)
 		}
 		if plugin.Implements("controller") {
 			r.updateAndRegisterControlsInReport(&pluginReport)
 		}
+		pluginReport.NamespacePluginTemplates(plugin.PluginSpec.ID)
 		rpt.UnsafeMerge(pluginReport)
-	})
+	}
+	if hostID := r.handshakeMetadata["host_id"]; hostID != "" && len(plugins) > 0 {
+		addHealth(&rpt, report.MakeHostNodeID(hostID), r.health(now))
+	}
 	return rpt, nil
 }
 
@@ -298,15 +397,41 @@ func (r *Registry) pluginControlHandler(req xfer.Request) xfer.Response {
 	if plugin, found := r.pluginsByID[pluginID]; found {
 		response := plugin.Control(req)
 		if response.ShortcutReport != nil {
-			r.updateAndRegisterControlsInReport(response.ShortcutReport)
-			response.ShortcutReport.Shortcut = true
-			r.publisher.Publish(*response.ShortcutReport)
+			r.publishShortcut(plugin, *response.ShortcutReport)
 		}
 		return response.Response
 	}
 	return xfer.ResponseErrorf("plugin %s not found", pluginID)
 }
 
+// update publishes a report streamed by a gRPC plugin, if it's still
+// loaded.  As with its reports, the plugin's spec is taken from it.
+func (r *Registry) update(plugin *Plugin, rpt report.Report) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if plugin.context.Err() != nil || r.pluginsByID[plugin.PluginSpec.ID] != plugin {
+		return
+	}
+	if err := plugin.checkReport(rpt); err != nil {
+		log.Warningf("plugins: %s: error in update: %v", plugin.socket, err)
+		return
+	}
+	r.publishShortcut(plugin, rpt)
+}
+
+// publishShortcut publishes a report of a plugin straight away, as a
+// shortcut report, with its templates namespaced as in Report.
+func (r *Registry) publishShortcut(plugin *Plugin, rpt report.Report) {
+	if plugin.Implements("controller") {
+		r.updateAndRegisterControlsInReport(&rpt)
+	}
+	rpt.NamespacePluginTemplates(plugin.PluginSpec.ID)
+	rpt.Shortcut = true
+	if r.publisher != nil {
+		r.publisher.Publish(rpt)
+	}
+}
+
 func realPluginAndControlID(fakeID string) (string, string) {
 	parts := strings.SplitN(fakeID, "~", 2)
 	if len(parts) != 2 {
@@ -356,6 +481,9 @@ type Plugin struct {
 	expectedAPIVersion string
 	handshakeMetadata  url.Values
 	client             *http.Client
+	health             pluginHealth
+	rpc                pluginrpc.PluginClient // of gRPC plugins, rather than client
+	conn               io.Closer
 	cancel             context.CancelFunc
 	backoff            backoff.Interface
 }
@@ -386,29 +514,69 @@ func NewPlugin(ctx context.Context, socket string, client *http.Client, expected
 	return plugin, nil
 }
 
+// NewGRPCPlugin loads and initializes a new gRPC plugin, connected by
+// conn.
+func NewGRPCPlugin(ctx context.Context, socket string, conn *grpc.ClientConn, expectedAPIVersion string, handshakeMetadata map[string]string) (*Plugin, error) {
+	plugin, err := NewPlugin(ctx, socket, nil, expectedAPIVersion, handshakeMetadata)
+	if err != nil {
+		return nil, err
+	}
+	plugin.rpc = pluginrpc.NewPluginClient(conn)
+	plugin.conn = conn
+	return plugin, nil
+}
+
+// handshake returns the handshake metadata of gRPC requests.
+func (p *Plugin) handshake() map[string]string {
+	handshake := make(map[string]string, len(p.handshakeMetadata))
+	for k := range p.handshakeMetadata {
+		handshake[k] = p.handshakeMetadata.Get(k)
+	}
+	return handshake
+}
+
 // Report gets the latest report from the plugin
 func (p *Plugin) Report() (result report.Report, err error) {
+	return p.checkedReport(p.fetchReport())
+}
+
+// fetchReport fetches the latest report from the plugin, without checking
+// it, which is safe to do concurrently with everything but Close.
+func (p *Plugin) fetchReport() (result report.Report, err error) {
 	result = report.MakeReport()
-	defer func() {
-		p.setStatus(err)
-		result.Plugins = result.Plugins.Add(p.PluginSpec)
-		if err != nil {
-			result = report.MakeReport()
-			result.Plugins = xfer.MakePluginSpecs(p.PluginSpec)
-		}
-	}()
+	if p.rpc != nil {
+		return p.grpcReport()
+	}
+	err = p.get("/report", p.handshakeMetadata, &result)
+	return result, err
+}
 
-	if err := p.get("/report", p.handshakeMetadata, &result); err != nil {
-		return result, err
+// checkedReport checks a report fetched from the plugin, returning a
+// report of the plugin's spec and status alone if it failed.
+func (p *Plugin) checkedReport(result report.Report, err error) (report.Report, error) {
+	if err == nil {
+		err = p.checkReport(result)
+	}
+	p.setStatus(err)
+	result.Plugins = result.Plugins.Add(p.PluginSpec)
+	if err != nil {
+		result = report.MakeReport()
+		result.Plugins = xfer.MakePluginSpecs(p.PluginSpec)
 	}
+	return result, err
+}
+
+// checkReport checks the spec of the plugin in a report of it, and takes
+// it as the plugin's.
+func (p *Plugin) checkReport(result report.Report) (err error) {
 	if result.Plugins.Size() != 1 {
-		return result, fmt.Errorf("report must contain exactly one plugin (found %d)", result.Plugins.Size())
+		return fmt.Errorf("report must contain exactly one plugin (found %d)", result.Plugins.Size())
 	}
 
 	key := result.Plugins.Keys()[0]
 	spec, _ := result.Plugins.Lookup(key)
 	if spec.ID != p.PluginSpec.ID {
-		return result, fmt.Errorf("plugin must not change its id (is %q, should be %q)", spec.ID, p.PluginSpec.ID)
+		return fmt.Errorf("plugin must not change its id (is %q, should be %q)", spec.ID, p.PluginSpec.ID)
 	}
 	p.PluginSpec = spec
 
@@ -421,7 +589,17 @@ func (p *Plugin) Report() (result report.Report, err error) {
 		err = fmt.Errorf("spec must implement the \"reporter\" interface")
 	}
 
-	return result, err
+	return err
+}
+
+func (p *Plugin) grpcReport() (report.Report, error) {
+	ctx, cancel := context.WithTimeout(p.context, pluginTimeout)
+	defer cancel()
+	resp, err := p.rpc.Report(ctx, &pluginrpc.ReportRequest{Handshake: p.handshake()})
+	if err != nil {
+		return report.MakeReport(), err
+	}
+	return pluginrpc.DecodeReport(ctx, resp.Report)
 }
 
 // Control sends a control message to a plugin
@@ -434,7 +612,9 @@ func (p *Plugin) Control(request xfer.Request) (res PluginResponse) {
 		}
 	}()
 
-	if p.Implements("controller") {
+	if p.Implements("controller") && p.rpc != nil {
+		res, err = p.grpcControl(request)
+	} else if p.Implements("controller") {
 		err = p.post("/control", p.handshakeMetadata, request, &res)
 	} else {
 		err = fmt.Errorf("the %s plugin does not implement the controller interface", p.PluginSpec.Label)
@@ -442,6 +622,60 @@ func (p *Plugin) Control(request xfer.Request) (res PluginResponse) {
 	return res
 }
 
+func (p *Plugin) grpcControl(request xfer.Request) (PluginResponse, error) {
+	ctx, cancel := context.WithTimeout(p.context, pluginTimeout)
+	defer cancel()
+	resp, err := p.rpc.HandleControl(ctx, &pluginrpc.ControlRequest{
+		Handshake:   p.handshake(),
+		AppID:       request.AppID,
+		NodeID:      request.NodeID,
+		Control:     request.Control,
+		ControlArgs: request.ControlArgs,
+	})
+	if err != nil {
+		return PluginResponse{}, err
+	}
+	var res PluginResponse
+	if err := json.Unmarshal(resp.Response, &res.Response); err != nil {
+		return PluginResponse{}, fmt.Errorf("decoding error: %s", err)
+	}
+	if len(resp.ShortcutReport) > 0 {
+		rpt, err := pluginrpc.DecodeReport(ctx, resp.ShortcutReport)
+		if err != nil {
+			return PluginResponse{}, fmt.Errorf("decoding error: %s", err)
+		}
+		res.ShortcutReport = &rpt
+	}
+	return res, nil
+}
+
+// streamUpdates streams the reports of a gRPC plugin as things change to
+// update, until it's closed, streaming them again after updatesBackoff
+// whenever the stream ends, unless the plugin doesn't stream updates.
+func (p *Plugin) streamUpdates(update func(*Plugin, report.Report)) {
+	p.backoff = backoff.New(func() (bool, error) {
+		stream, err := p.rpc.StreamUpdates(p.context, &pluginrpc.StreamUpdatesRequest{Handshake: p.handshake()})
+		for err == nil {
+			var resp *pluginrpc.ReportResponse
+			if resp, err = stream.Recv(); err != nil {
+				break
+			}
+			rpt, err := pluginrpc.DecodeReport(p.context, resp.Report)
+			if err != nil {
+				log.Warningf("plugins: %s: error decoding update: %v", p.socket, err)
+				continue
+			}
+			update(p, rpt)
+		}
+		if p.context.Err() != nil || status.Code(err) == codes.Unimplemented {
+			return true, nil
+		}
+		return false, err
+	}, fmt.Sprintf("streaming updates of plugin %s", p.socket))
+	p.backoff.SetInitialBackoff(updatesBackoff)
+	go p.backoff.Start()
+}
+
 // Implements checks if the plugin implements the given interface
 func (p *Plugin) Implements(iface string) bool {
 	for _, i := range p.PluginSpec.Interfaces {
@@ -507,8 +741,13 @@ func getResult(body io.ReadCloser, result interface{}) error {
 
 // Close closes the client
 func (p *Plugin) Close() {
+	p.cancel()
 	if p.backoff != nil {
-		p.backoff.Stop()
+		// Not waited for, as plugins are closed with the registry locked,
+		// which updates lock; they're dropped once the plugin is closed.
+		go p.backoff.Stop()
+	}
+	if p.conn != nil {
+		p.conn.Close()
 	}
-	p.cancel()
 }
@@ -0,0 +1,169 @@
+// Package sdk helps write gRPC probe plugins in Go: plugins implement
+// Plugin, and ControlHandler and Updater if they have controls or stream
+// updates, and Serve serves them on a socket in the probe's plugins
+// directory.
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe/plugins/pluginrpc"
+	"github.com/weaveworks/scope/report"
+)
+
+// Interfaces of plugins, as in their specs
+const (
+	Reporter   = "reporter"
+	Controller = "controller"
+)
+
+// Plugin is a probe plugin.  Its spec is added to its reports, with the
+// interfaces it implements and the API version of the probe, if not set.
+type Plugin interface {
+	Spec() xfer.PluginSpec
+	Report(ctx context.Context) (report.Report, error)
+}
+
+// ControlHandler is implemented by plugins with controls in their reports,
+// which they're asked to handle, responding with a shortcut report if the
+// control changed them, such that the app shows the change straight away.
+type ControlHandler interface {
+	HandleControl(ctx context.Context, req xfer.Request) (xfer.Response, *report.Report)
+}
+
+// Updater is implemented by plugins which send their reports as things
+// change, on updates, until ctx is done.
+type Updater interface {
+	Updates(ctx context.Context, updates chan<- report.Report) error
+}
+
+// Serve serves a plugin on the socket <dir>/<plugin id>.grpc, where dir
+// is the probe's plugins directory or a directory under it, until ctx is
+// done.
+func Serve(ctx context.Context, dir string, p Plugin) error {
+	socket := filepath.Join(dir, p.Spec().ID+pluginrpc.SocketExt)
+	os.Remove(socket)
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	s := grpc.NewServer()
+	pluginrpc.RegisterPluginServer(s, NewServer(p))
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	if err := s.Serve(lis); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// NewServer makes the gRPC server of a plugin, for plugins serving it
+// themselves.
+func NewServer(p Plugin) pluginrpc.PluginServer {
+	return &server{plugin: p}
+}
+
+type server struct {
+	plugin Plugin
+}
+
+// spec returns the spec of the plugin, with its interfaces and the API
+// version of the probe filled in.
+func (s *server) spec(handshake map[string]string) xfer.PluginSpec {
+	spec := s.plugin.Spec()
+	if len(spec.Interfaces) == 0 {
+		spec.Interfaces = []string{Reporter}
+		if _, ok := s.plugin.(ControlHandler); ok {
+			spec.Interfaces = append(spec.Interfaces, Controller)
+		}
+	}
+	if spec.APIVersion == "" {
+		spec.APIVersion = handshake["api_version"]
+	}
+	if spec.Label == "" {
+		spec.Label = spec.ID
+	}
+	return spec
+}
+
+func (s *server) encode(rpt report.Report, handshake map[string]string) ([]byte, error) {
+	rpt.Plugins = xfer.MakePluginSpecs(s.spec(handshake))
+	return pluginrpc.EncodeReport(rpt)
+}
+
+func (s *server) Report(ctx context.Context, req *pluginrpc.ReportRequest) (*pluginrpc.ReportResponse, error) {
+	rpt, err := s.plugin.Report(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	buf, err := s.encode(rpt, req.Handshake)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pluginrpc.ReportResponse{Report: buf}, nil
+}
+
+func (s *server) HandleControl(ctx context.Context, req *pluginrpc.ControlRequest) (*pluginrpc.ControlResponse, error) {
+	controller, ok := s.plugin.(ControlHandler)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the plugin has no controls")
+	}
+	res, shortcut := controller.HandleControl(ctx, xfer.Request{
+		AppID:       req.AppID,
+		NodeID:      req.NodeID,
+		Control:     req.Control,
+		ControlArgs: req.ControlArgs,
+	})
+	out := &pluginrpc.ControlResponse{}
+	var err error
+	if out.Response, err = json.Marshal(res); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if shortcut != nil {
+		if out.ShortcutReport, err = s.encode(*shortcut, req.Handshake); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return out, nil
+}
+
+func (s *server) StreamUpdates(req *pluginrpc.StreamUpdatesRequest, stream pluginrpc.UpdatesServer) error {
+	updater, ok := s.plugin.(Updater)
+	if !ok {
+		return status.Error(codes.Unimplemented, "the plugin doesn't stream updates")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	updates := make(chan report.Report)
+	done := make(chan error, 1)
+	go func() { done <- updater.Updates(ctx, updates) }()
+	for {
+		select {
+		case rpt := <-updates:
+			buf, err := s.encode(rpt, req.Handshake)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(&pluginrpc.ReportResponse{Report: buf}); err != nil {
+				return err
+			}
+		case err := <-done:
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			return nil
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"net"
 	"net/http"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 func makeUnixRoundTripper(address string, timeout time.Duration) (http.RoundTripper, error) {
@@ -14,3 +16,9 @@ func makeUnixRoundTripper(address string, timeout time.Duration) (http.RoundTrip
 	}
 	return rt, nil
 }
+
+func dialUnixGRPC(address string, timeout time.Duration) (*grpc.ClientConn, error) {
+	return grpc.Dial(address, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}), grpc.WithTimeout(timeout))
+}
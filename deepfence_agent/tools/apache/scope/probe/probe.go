@@ -1,28 +1,58 @@
 package probe
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
-	log "github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
 	"github.com/weaveworks/common/mtime"
 	"golang.org/x/time/rate"
 
+	"github.com/weaveworks/scope/common/logger"
+	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/report"
 )
 
+var log = logger.Component("probe")
+
 const (
 	spiedReportBufferSize    = 16
 	shortcutReportBufferSize = 1024
 )
 
+// ReportControl is the control asking a probe for a report there and then.
+// It takes the largest report to respond with, in bytes, as MaxBytesArg.
+const (
+	ReportControl = "probe_report"
+	MaxBytesArg   = "max_bytes"
+)
+
 // ReportPublisher publishes reports, probably to a remote collector.
 type ReportPublisher interface {
 	Publish(r report.Report) error
 }
 
+// DeltaPublisher is a ReportPublisher which can also publish the deltas
+// between consecutive reports, to collectors which take them.  It publishes
+// the report whole to the others, and when the delta is nil.
+type DeltaPublisher interface {
+	ReportPublisher
+	PublishDelta(r report.Report, delta *report.Delta) error
+}
+
+// PublishTracker is a ReportPublisher which publishes reports in the
+// background, and knows when one last reached an app rather than being
+// queued.
+type PublishTracker interface {
+	LastPublished() time.Time
+}
+
 // Probe sits there, generating and publishing reports.
 type Probe struct {
 	spyInterval, publishInterval time.Duration
@@ -30,16 +60,37 @@ type Probe struct {
 	rateLimiter                  *rate.Limiter
 	ticksPerFullReport           int
 	noControls                   bool
+	budget                       ReportBudget
+	deltas                       bool
+
+	// Adaptive publishing, stretching the publish interval up to
+	// maxPublishInterval while nothing changes; disabled if zero
+	maxPublishInterval time.Duration
+	volatileKeys       map[string]struct{}
+	resetInterval      chan struct{}
+
+	// Sheds collectors while over the CPU budget; nil for no budget
+	shedder *shedder
+
+	// The publish interval and the reporters disabled may be changed
+	// while the probe runs, e.g. by reloading its config
+	mtx             sync.Mutex
+	disabled        map[string]struct{}
+	intervalChanged chan struct{}
+
+	heartbeats *heartbeats
 
 	tickers   []Ticker
 	reporters []Reporter
 	taggers   []Tagger
+	limiters  []Limiter
 
 	quit chan struct{}
 	done sync.WaitGroup
 
 	spiedReports    chan report.Report
 	shortcutReports chan report.Report
+	reportRequests  chan chan report.Report
 }
 
 // Tagger tags nodes with value-add node metadata.
@@ -48,6 +99,15 @@ type Tagger interface {
 	Tag(r report.Report) (report.Report, error)
 }
 
+// Limiter limits what is published of the reports spied: the reports
+// spied between publishes add up, so limits on each of them aren't limits
+// on what is published.
+type Limiter interface {
+	// Limit limits, in place, the report merged from those spied since
+	// the last publish.
+	Limit(r *report.Report)
+}
+
 // Reporter generates Reports.
 type Reporter interface {
 	Name() string
@@ -92,6 +152,10 @@ func New(
 		quit:               make(chan struct{}),
 		spiedReports:       make(chan report.Report, spiedReportBufferSize),
 		shortcutReports:    make(chan report.Report, shortcutReportBufferSize),
+		reportRequests:     make(chan chan report.Report),
+		resetInterval:      make(chan struct{}, 1),
+		intervalChanged:    make(chan struct{}, 1),
+		heartbeats:         newHeartbeats(),
 	}
 	return result
 }
@@ -106,13 +170,107 @@ func (p *Probe) AddReporter(rs ...Reporter) {
 	p.reporters = append(p.reporters, rs...)
 }
 
+// AddLimiter adds a new Limiter to the Probe
+func (p *Probe) AddLimiter(ls ...Limiter) {
+	p.limiters = append(p.limiters, ls...)
+}
+
 // AddTicker adds a new Ticker to the Probe
 func (p *Probe) AddTicker(ts ...Ticker) {
 	p.tickers = append(p.tickers, ts...)
 }
 
+// SetReportBudget sets the budget reports are trimmed to before they're
+// published.
+func (p *Probe) SetReportBudget(budget ReportBudget) {
+	p.budget = budget
+}
+
+// SetPublishDeltas sets whether the reports between full ones are published
+// as deltas, if the publisher can, rather than with unchanged nodes left out.
+func (p *Probe) SetPublishDeltas(deltas bool) {
+	p.deltas = deltas
+}
+
+// SetAdaptivePublish sets the probe to stretch the publish interval, up to
+// maxInterval, while the reports spied don't change, telling them apart
+// but for their metrics and the latest values of volatileKeys.  The
+// interval doubles every report published unchanged, and is back to the
+// base one on a change, a shortcut report or ResetPublishInterval.
+func (p *Probe) SetAdaptivePublish(maxInterval time.Duration, volatileKeys ...string) {
+	p.maxPublishInterval = maxInterval
+	p.volatileKeys = map[string]struct{}{}
+	for _, k := range volatileKeys {
+		p.volatileKeys[k] = struct{}{}
+	}
+}
+
+// ResetPublishInterval puts the publish interval back to the base one, if
+// stretched, e.g. on a control which is likely to change things.
+func (p *Probe) ResetPublishInterval() {
+	select {
+	case p.resetInterval <- struct{}{}:
+	default:
+	}
+}
+
+// SetPublishInterval changes how often the probe publishes reports, from
+// the next publish on.
+func (p *Probe) SetPublishInterval(interval time.Duration) {
+	p.mtx.Lock()
+	p.publishInterval = interval
+	p.mtx.Unlock()
+	p.rateLimiter.SetLimit(rate.Every(interval / 100))
+	select {
+	case p.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Probe) getPublishInterval() time.Duration {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.publishInterval
+}
+
+// DisableReporters stops the probe running the reporters of the given
+// names, running all others again.  It fails, disabling none, if the probe
+// has no reporter of one of the names.
+func (p *Probe) DisableReporters(names ...string) error {
+	disabled := map[string]struct{}{}
+	for _, name := range names {
+		found := false
+		for _, rep := range p.reporters {
+			found = found || rep.Name() == name
+		}
+		if !found {
+			return fmt.Errorf("no reporter named %q", name)
+		}
+		disabled[name] = struct{}{}
+	}
+	p.mtx.Lock()
+	p.disabled = disabled
+	p.mtx.Unlock()
+	return nil
+}
+
+// activeReporters returns the reporters not disabled.
+func (p *Probe) activeReporters() []Reporter {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var reporters []Reporter
+	for _, rep := range p.reporters {
+		if _, ok := p.disabled[rep.Name()]; ok {
+			continue
+		}
+		reporters = append(reporters, rep)
+	}
+	return reporters
+}
+
 // Start starts the probe
 func (p *Probe) Start() {
+	p.heartbeats.start()
 	p.done.Add(2)
 	go p.spyLoop()
 	go p.publishLoop()
@@ -132,20 +290,67 @@ func (p *Probe) Publish(rpt report.Report) {
 	p.shortcutReports <- rpt
 }
 
+// HandleReportControl spies a report immediately, rather than waiting for
+// the spy tick, and responds with it as JSON, sanitised and trimmed as it
+// would be when published.  The report is also published as usual.
+func (p *Probe) HandleReportControl(req xfer.Request) xfer.Response {
+	rpt, err := p.SpyReport()
+	if err != nil {
+		return xfer.ResponseError(err)
+	}
+
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, &codec.JsonHandle{}).Encode(&rpt); err != nil {
+		return xfer.ResponseError(err)
+	}
+	if maxBytes, err := strconv.Atoi(req.ControlArgs[MaxBytesArg]); err == nil && maxBytes > 0 && buf.Len() > maxBytes {
+		return xfer.ResponseErrorf("report of %d bytes is larger than %d", buf.Len(), maxBytes)
+	}
+	return xfer.Response{Value: buf.String()}
+}
+
+// SpyReport spies a report immediately, rather than waiting for the spy
+// tick, and returns it sanitised and trimmed as it would be when published.
+// The report is also published as usual.
+func (p *Probe) SpyReport() (report.Report, error) {
+	reply := make(chan report.Report, 1)
+	select {
+	case p.reportRequests <- reply:
+	case <-p.quit:
+		return report.MakeReport(), errors.New("probe is stopping")
+	}
+	rpt := (<-reply).Copy()
+	if p.noControls {
+		rpt.WalkTopologies(func(t *report.Topology) {
+			t.Controls = report.Controls{}
+		})
+	}
+	p.limit(&rpt)
+	p.trim(&rpt)
+	return rpt, nil
+}
+
 func (p *Probe) spyLoop() {
 	defer p.done.Done()
 	spyTick := time.Tick(p.spyInterval)
 
 	for {
+		var reply chan report.Report
 		select {
 		case <-spyTick:
-			p.tick()
-			rpt := p.report()
-			rpt = p.tag(rpt)
-			p.spiedReports <- rpt
+		case reply = <-p.reportRequests:
 		case <-p.quit:
 			return
 		}
+		p.shed()
+		p.tick()
+		rpt := p.report()
+		rpt = p.tag(rpt)
+		p.heartbeats.beat(ReportStage)
+		p.spiedReports <- rpt
+		if reply != nil {
+			reply <- rpt
+		}
 	}
 }
 
@@ -164,8 +369,9 @@ func (p *Probe) tick() {
 }
 
 func (p *Probe) report() report.Report {
-	reports := make(chan report.Report, len(p.reporters))
-	for _, rep := range p.reporters {
+	reporters := p.activeReporters()
+	reports := make(chan report.Report, len(reporters))
+	for _, rep := range reporters {
 		go func(rep Reporter) {
 			t := time.Now()
 			timer := time.AfterFunc(p.spyInterval, func() { log.Warningf("%v reporter took longer than %v", rep.Name(), p.spyInterval) })
@@ -236,34 +442,97 @@ ForLoop:
 	return rpt, count
 }
 
+// limit applies the limiters to the report, in place.
+func (p *Probe) limit(rpt *report.Report) {
+	for _, limiter := range p.limiters {
+		limiter.Limit(rpt)
+	}
+}
+
+// trim trims the report, in place, to the budget of the probe.
+func (p *Probe) trim(rpt *report.Report) {
+	if err := p.budget.Trim(rpt); err != nil {
+		log.Errorf("Error trimming report: %v", err)
+	}
+}
+
 func (p *Probe) publishLoop() {
 	defer p.done.Done()
 	startTime := mtime.Now()
-	pubTick := time.Tick(p.publishInterval)
+	interval := p.getPublishInterval()
+	pubTick := time.NewTicker(interval)
+	defer pubTick.Stop()
 	publishCount := 0
-	var lastFullReport report.Report
+	deltaPublisher, deltas := p.publisher.(DeltaPublisher)
+	deltas = deltas && p.deltas
+	// The last full report published, or with deltas, the last report
+	var lastReport report.Report
+
+	// With adaptive publishing, the reports spied are held over for as
+	// many publish ticks as the interval is stretched, unless they change
+	var (
+		adaptive   = p.maxPublishInterval > interval
+		maxStretch = int(p.maxPublishInterval / interval)
+		stretch    = 1
+		held       = 0
+		pending    = report.MakeReport()
+		hashes     map[string]uint64
+	)
 
 	for {
 		var err error
 		select {
-		case <-pubTick:
-			rpt, count := p.drainAndSanitise(report.MakeReport(), p.spiedReports)
-			if count == 0 {
+		case <-pubTick.C:
+			rpt, count := p.drainAndSanitise(pending, p.spiedReports)
+			if count == 0 && held == 0 {
 				continue // No data has been collected - don't bother publishing.
 			}
+			if adaptive {
+				newHashes := topologyHashes(rpt, p.volatileKeys)
+				changed := !sameHashes(hashes, newHashes)
+				hashes = newHashes
+				if changed {
+					stretch = 1
+				}
+				if held++; held < stretch {
+					pending = rpt
+					continue
+				}
+				held, pending = 0, report.MakeReport()
+				if !changed && stretch < maxStretch {
+					stretch *= 2
+					if stretch > maxStretch {
+						stretch = maxStretch
+					}
+				}
+			}
 
+			p.limit(&rpt)
 			fullReport := (publishCount % p.ticksPerFullReport) == 0
-			if !fullReport {
-				rpt.UnsafeUnMerge(lastFullReport)
+			if !fullReport && !deltas {
+				rpt.UnsafeUnMerge(lastReport)
 			}
 			rpt.Window = mtime.Now().Sub(startTime)
 			startTime = mtime.Now()
-			err = p.publisher.Publish(rpt)
+			p.trim(&rpt)
+			if deltas {
+				var delta *report.Delta
+				if !fullReport {
+					d := lastReport.Diff(rpt)
+					delta = &d
+				}
+				err = deltaPublisher.PublishDelta(rpt, delta)
+			} else {
+				err = p.publisher.Publish(rpt)
+			}
 			if err == nil {
-				if fullReport {
-					lastFullReport = rpt
+				if fullReport || deltas {
+					lastReport = rpt
 				}
 				publishCount++
+				if _, tracked := p.publisher.(PublishTracker); !tracked {
+					p.heartbeats.beat(PublishStage)
+				}
 			} else {
 				// If we failed to send then drop back to full report next time
 				publishCount = 0
@@ -271,7 +540,20 @@ func (p *Probe) publishLoop() {
 
 		case rpt := <-p.shortcutReports:
 			rpt, _ = p.drainAndSanitise(rpt, p.shortcutReports)
+			p.trim(&rpt)
 			err = p.publisher.Publish(rpt)
+			stretch = 1
+
+		case <-p.resetInterval:
+			stretch = 1
+
+		case <-p.intervalChanged:
+			interval = p.getPublishInterval()
+			pubTick.Stop()
+			pubTick = time.NewTicker(interval)
+			adaptive = p.maxPublishInterval > interval
+			maxStretch = int(p.maxPublishInterval / interval)
+			stretch = 1
 
 		case <-p.quit:
 			return
@@ -1,6 +1,8 @@
 package probe
 
 import (
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -82,3 +84,231 @@ func TestProbe(t *testing.T) {
 		return <-pub.have
 	})
 }
+
+type mockDeltaPublisher struct {
+	mockPublisher
+	deltas chan *report.Delta
+}
+
+func (m mockDeltaPublisher) PublishDelta(r report.Report, delta *report.Delta) error {
+	m.deltas <- delta
+	return m.Publish(r)
+}
+
+func TestProbeDeltas(t *testing.T) {
+	want := report.MakeReport()
+	want.Endpoint.AddNode(report.MakeNodeWith("a", map[string]string{"b": "c"}))
+
+	pub := mockDeltaPublisher{mockPublisher{make(chan report.Report, 10)}, make(chan *report.Delta, 10)}
+	p := New(10*time.Millisecond, 30*time.Millisecond, pub, 3, false)
+	p.SetPublishDeltas(true)
+	p.AddReporter(mockReporter{want})
+	p.Start()
+	defer p.Stop()
+
+	// A full report every third, with deltas from the one before in between
+	var last report.Report
+	for i := 0; i < 6; i++ {
+		var delta *report.Delta
+		select {
+		case delta = <-pub.deltas:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		have := <-pub.have
+		if full := i%3 == 0; full != (delta == nil) {
+			t.Fatalf("%d: expected a full report %v, got delta %v", i, full, delta)
+		}
+		if delta != nil {
+			patched, err := last.Patch(*delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, patched) {
+				t.Errorf("%d: expected %v, got %v", i, have, patched)
+			}
+		}
+		last = have
+	}
+}
+
+// changingReporter reports an endpoint node for each of its IDs, with a
+// latest value changing every report.
+type changingReporter struct {
+	mtx     sync.Mutex
+	ids     []string
+	reports int
+}
+
+func (r *changingReporter) Report() (report.Report, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.reports++
+	rpt := report.MakeReport()
+	for _, id := range r.ids {
+		rpt.Endpoint.AddNode(report.MakeNodeWith(id, map[string]string{"uptime": strconv.Itoa(r.reports)}))
+	}
+	return rpt, nil
+}
+
+func (*changingReporter) Name() string { return "Changing" }
+
+func (r *changingReporter) add(id string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.ids = append(r.ids, id)
+}
+
+func TestProbeAdaptivePublish(t *testing.T) {
+	const publishInterval = 20 * time.Millisecond
+	reporter := &changingReporter{ids: []string{"a"}}
+	pub := mockPublisher{make(chan report.Report, 100)}
+	p := New(5*time.Millisecond, publishInterval, pub, 1, false)
+	p.SetAdaptivePublish(10*publishInterval, "uptime")
+	p.AddReporter(reporter)
+	p.Start()
+	defer p.Stop()
+
+	next := func() report.Report {
+		select {
+		case rpt := <-pub.have:
+			return rpt
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		return report.Report{}
+	}
+	// The interval is stretched while nothing but volatile values change,
+	// the windows of the reports covering it
+	stretched := func() {
+		for i := 0; i < 10; i++ {
+			if next().Window >= 4*publishInterval {
+				return
+			}
+		}
+		t.Fatal("Expected the publish interval to be stretched")
+	}
+	// The interval is back to the base one within a few reports
+	snappedBack := func(what string) {
+		for i := 0; i < 4; i++ {
+			if next().Window < 3*publishInterval {
+				return
+			}
+		}
+		t.Fatalf("Expected the publish interval back to the base one on %s", what)
+	}
+
+	stretched()
+	reporter.add("b")
+	for i := 0; ; i++ {
+		if _, ok := next().Endpoint.Nodes["b"]; ok {
+			break
+		}
+		if i == 10 {
+			t.Fatal("Expected the change to be published")
+		}
+	}
+	snappedBack("a change")
+
+	stretched()
+	p.ResetPublishInterval()
+	snappedBack("a control")
+}
+
+func TestProbeReconfigure(t *testing.T) {
+	const publishInterval = 10 * time.Millisecond
+	reporter := &changingReporter{ids: []string{"a"}}
+	pub := mockPublisher{make(chan report.Report, 100)}
+	p := New(5*time.Millisecond, publishInterval, pub, 1, false)
+	p.AddReporter(reporter)
+
+	if err := p.DisableReporters("Changing", "Missing"); err == nil {
+		t.Error("Expected reporters the probe doesn't have not to be disabled")
+	}
+	if have := p.report(); len(have.Endpoint.Nodes) != 1 {
+		t.Errorf("Expected the reporter still enabled, have %v", have.Endpoint.Nodes)
+	}
+	if err := p.DisableReporters("Changing"); err != nil {
+		t.Fatal(err)
+	}
+	if have := p.report(); len(have.Endpoint.Nodes) != 0 {
+		t.Errorf("Expected the reporter disabled, have %v", have.Endpoint.Nodes)
+	}
+	if err := p.DisableReporters(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Start()
+	defer p.Stop()
+	next := func() report.Report {
+		select {
+		case rpt := <-pub.have:
+			return rpt
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		return report.Report{}
+	}
+	next()
+	p.SetPublishInterval(10 * publishInterval)
+	for i := 0; ; i++ {
+		if next().Window >= 8*publishInterval {
+			break
+		}
+		if i == 4 {
+			t.Fatal("Expected the new publish interval")
+		}
+	}
+}
+
+type countingReporter struct {
+	mtx   sync.Mutex
+	count int
+}
+
+func (c *countingReporter) Report() (report.Report, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.count++
+	rpt := report.MakeReport()
+	rpt.Endpoint.AddNode(report.MakeNode(strconv.Itoa(c.count)))
+	return rpt, nil
+}
+
+func (*countingReporter) Name() string { return "Counting" }
+
+type mockLimiter struct {
+	seen chan int
+}
+
+func (m mockLimiter) Limit(rpt *report.Report) {
+	select {
+	case m.seen <- len(rpt.Endpoint.Nodes):
+	default:
+	}
+	rpt.Endpoint = report.MakeTopology()
+}
+
+func TestProbeLimitsPublishedReports(t *testing.T) {
+	pub := mockPublisher{make(chan report.Report, 10)}
+	limiter := mockLimiter{make(chan int, 10)}
+
+	p := New(10*time.Millisecond, 100*time.Millisecond, pub, 1, false)
+	p.AddReporter(&countingReporter{})
+	p.AddLimiter(limiter)
+	p.Start()
+	defer p.Stop()
+
+	// The limiter is given the reports spied since the last publish, merged
+	select {
+	case rpt := <-pub.have:
+		if seen := <-limiter.seen; seen < 2 {
+			t.Errorf("Expected the limiter to see the merged reports, saw %d endpoints", seen)
+		}
+		if len(rpt.Endpoint.Nodes) != 0 {
+			t.Errorf("Expected the published report to be limited, got %d endpoints", len(rpt.Endpoint.Nodes))
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	}
+}
@@ -0,0 +1,86 @@
+package process
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+)
+
+// cgroupExpiry is how long the cgroups of a process are cached: processes
+// seldom move between cgroups, but those started into a container are
+// moved into its cgroup shortly after they start.
+const cgroupExpiry = time.Minute
+
+// CgroupCache caches the cgroups of processes, as read by ReadCgroup, so
+// they aren't read for every process on every report.  Each tick forgets
+// the processes which have exited, or whose PID has been reused, according
+// to the walker.
+type CgroupCache struct {
+	mtx     sync.Mutex
+	read    func(pid int) (string, bool)
+	walker  Walker
+	entries map[int]cgroupEntry
+}
+
+type cgroupEntry struct {
+	cgroups   string
+	ok        bool
+	readAt    time.Time
+	startTime uint64 // zero until the tick after the read
+}
+
+// NewCgroupCache returns a CgroupCache reading the cgroups of processes
+// with read, and those running with walker.
+func NewCgroupCache(read func(pid int) (string, bool), walker Walker) *CgroupCache {
+	return &CgroupCache{
+		read:    read,
+		walker:  walker,
+		entries: map[int]cgroupEntry{},
+	}
+}
+
+// Name of this ticker, for metrics gathering
+func (*CgroupCache) Name() string { return "Cgroups" }
+
+// Read returns the cgroups of process pid, from the cache unless they were
+// read more than cgroupExpiry ago.
+func (c *CgroupCache) Read(pid int) (string, bool) {
+	now := mtime.Now()
+	c.mtx.Lock()
+	entry, ok := c.entries[pid]
+	c.mtx.Unlock()
+	if ok && now.Sub(entry.readAt) < cgroupExpiry {
+		return entry.cgroups, entry.ok
+	}
+
+	cgroups, found := c.read(pid)
+	c.mtx.Lock()
+	c.entries[pid] = cgroupEntry{cgroups: cgroups, ok: found, readAt: now, startTime: entry.startTime}
+	c.mtx.Unlock()
+	return cgroups, found
+}
+
+// Tick forgets the cgroups of the processes which aren't running anymore.
+func (c *CgroupCache) Tick() error {
+	running := map[int]uint64{}
+	if err := c.walker.Walk(func(p, _ Process) {
+		running[p.PID] = p.StartTime
+	}); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for pid, entry := range c.entries {
+		startTime, ok := running[pid]
+		switch {
+		case !ok, entry.startTime != 0 && entry.startTime != startTime:
+			delete(c.entries, pid)
+		case entry.startTime == 0:
+			entry.startTime = startTime
+			c.entries[pid] = entry
+		}
+	}
+	return nil
+}
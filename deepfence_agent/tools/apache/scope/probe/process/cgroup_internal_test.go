@@ -0,0 +1,46 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+)
+
+func TestCgroupCache(t *testing.T) {
+	now := time.Now()
+	mtime.NowForce(now)
+	defer mtime.NowReset()
+
+	reads := map[int]int{}
+	read := func(pid int) (string, bool) {
+		reads[pid]++
+		return "0::/system.slice/docker.service", true
+	}
+	walker := staticWalker{{PID: 1, StartTime: 10}, {PID: 2, StartTime: 20}}
+	cache := NewCgroupCache(read, &walker)
+
+	cache.Read(1)
+	cache.Read(2)
+	cache.Tick()
+	cache.Read(1)
+	cache.Read(2)
+	if reads[1] != 1 || reads[2] != 1 {
+		t.Errorf("Expected the cgroups of each process to be read once, got %v", reads)
+	}
+
+	// Process 2 exits and its PID is reused.
+	walker = staticWalker{{PID: 1, StartTime: 10}, {PID: 2, StartTime: 30}}
+	cache.Tick()
+	cache.Read(1)
+	cache.Read(2)
+	if reads[1] != 1 || reads[2] != 2 {
+		t.Errorf("Expected the cgroups of the reused PID to be read again, got %v", reads)
+	}
+
+	mtime.NowForce(now.Add(cgroupExpiry))
+	cache.Read(1)
+	if reads[1] != 2 {
+		t.Errorf("Expected expired cgroups to be read again, got %v", reads)
+	}
+}
@@ -0,0 +1,28 @@
+package process
+
+import (
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys of the details of processes, reported with --probe.proc.details.
+const (
+	ExePath              = "process_exe"
+	ExeSHA256            = "process_exe_sha256"
+	RunningDeletedBinary = "running_deleted_binary"
+	ListeningPorts       = "process_listening_ports"
+
+	// DefaultHashMaxSize is the size of the largest executables hashed.
+	DefaultHashMaxSize = 100 * 1024 * 1024
+
+	// Hashing the executables of many new processes at once could take a
+	// while, so only so many are hashed each time the processes are walked.
+	maxHashesPerWalk = 20
+)
+
+// DetailsMetadataTemplates are the templates of the details of processes.
+var DetailsMetadataTemplates = report.MetadataTemplates{
+	ExePath:              {ID: ExePath, Label: "Executable", From: report.FromLatest, Priority: 5},
+	ExeSHA256:            {ID: ExeSHA256, Label: "Executable SHA256", From: report.FromLatest, Priority: 6},
+	RunningDeletedBinary: {ID: RunningDeletedBinary, Label: "Deleted executable", From: report.FromLatest, Priority: 7},
+	ListeningPorts:       {ID: ListeningPorts, Label: "Listening ports", From: report.FromLatest, Priority: 8},
+}
@@ -0,0 +1,17 @@
+package process
+
+// Details of processes are not read on Darwin.
+type Details struct{}
+
+// NewDetails makes a new Details.
+func NewDetails(procRoot string, hashMaxSize int64) *Details {
+	return &Details{}
+}
+
+func (d *Details) beginWalk() {}
+
+func (d *Details) endWalk() {}
+
+func (d *Details) latests(pid int) map[string]string {
+	return map[string]string{}
+}
@@ -0,0 +1,212 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/weaveworks/common/fs"
+)
+
+const (
+	deletedSuffix = " (deleted)"
+	socketPrefix  = "socket:["
+	tcpListen     = "0A"
+)
+
+// fileID tells apart the versions of executables.
+type fileID struct {
+	dev, ino    uint64
+	size, mtime int64
+}
+
+// Details reads the executables of processes, their hashes, and the ports
+// they listen on.  It's used by the one goroutine walking the processes.
+type Details struct {
+	procRoot    string
+	hashMaxSize int64
+
+	hashes     map[fileID]string // of the executables seen in the previous walk
+	seenHashes map[fileID]string // of those seen in this walk
+	hashBudget int
+
+	// the listening sockets of each network namespace, seen in this walk
+	listeners map[uint64]map[uint64]uint16
+}
+
+// NewDetails makes a new Details.  Executables larger than hashMaxSize are
+// not hashed, and zero disables hashing.
+func NewDetails(procRoot string, hashMaxSize int64) *Details {
+	return &Details{
+		procRoot:    procRoot,
+		hashMaxSize: hashMaxSize,
+		hashes:      map[fileID]string{},
+	}
+}
+
+// beginWalk is called before walking the processes.
+func (d *Details) beginWalk() {
+	d.seenHashes = map[fileID]string{}
+	d.hashBudget = maxHashesPerWalk
+	d.listeners = map[uint64]map[uint64]uint16{}
+}
+
+// endWalk is called after walking the processes, forgetting the hashes of
+// executables no longer running.
+func (d *Details) endWalk() {
+	d.hashes, d.seenHashes = d.seenHashes, nil
+	d.listeners = nil
+}
+
+// latests returns the details of the process.
+func (d *Details) latests(pid int) map[string]string {
+	result := map[string]string{}
+	dir := path.Join(d.procRoot, strconv.Itoa(pid))
+	exe, err := os.Readlink(path.Join(dir, "exe"))
+	if err != nil {
+		// Kernel threads have no executable
+		return result
+	}
+	if strings.HasSuffix(exe, deletedSuffix) {
+		exe = strings.TrimSuffix(exe, deletedSuffix)
+		result[RunningDeletedBinary] = "true"
+	}
+	result[ExePath] = exe
+	// The executable is read through proc, as it may be in the mount
+	// namespace of a container, or deleted
+	if hash, ok := d.hash(path.Join(dir, "exe")); ok {
+		result[ExeSHA256] = hash
+	}
+	if ports := d.listeningPorts(dir); len(ports) > 0 {
+		result[ListeningPorts] = strings.Join(ports, ",")
+	}
+	return result
+}
+
+// hash returns the sha256 of the file, hashing it once for all processes
+// running it.
+func (d *Details) hash(filename string) (string, bool) {
+	if d.hashMaxSize <= 0 {
+		return "", false
+	}
+	var stat syscall.Stat_t
+	if err := fs.Stat(filename, &stat); err != nil || stat.Size > d.hashMaxSize {
+		return "", false
+	}
+	id := fileID{dev: uint64(stat.Dev), ino: stat.Ino, size: stat.Size, mtime: stat.Mtim.Nano()}
+	if hash, ok := d.seenHashes[id]; ok {
+		return hash, true
+	}
+	if hash, ok := d.hashes[id]; ok {
+		d.seenHashes[id] = hash
+		return hash, true
+	}
+	if d.hashBudget <= 0 {
+		return "", false
+	}
+	d.hashBudget--
+	f, err := fs.Open(filename)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(f, d.hashMaxSize)); err != nil {
+		return "", false
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	d.seenHashes[id] = hash
+	return hash, true
+}
+
+// listeningPorts returns the TCP ports the process listens on, sorted.
+func (d *Details) listeningPorts(dir string) []string {
+	fds, err := fs.ReadDirNames(path.Join(dir, "fd"))
+	if err != nil {
+		return nil
+	}
+	var sockets []uint64
+	for _, fd := range fds {
+		target, err := os.Readlink(path.Join(dir, "fd", fd))
+		if err != nil || !strings.HasPrefix(target, socketPrefix) {
+			continue
+		}
+		if inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, socketPrefix), "]"), 10, 64); err == nil {
+			sockets = append(sockets, inode)
+		}
+	}
+	if len(sockets) == 0 {
+		return nil
+	}
+	listeners := d.namespaceListeners(dir)
+	ports := map[uint16]struct{}{}
+	for _, inode := range sockets {
+		if port, ok := listeners[inode]; ok {
+			ports[port] = struct{}{}
+		}
+	}
+	sorted := make([]int, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, int(port))
+	}
+	sort.Ints(sorted)
+	result := make([]string, 0, len(sorted))
+	for _, port := range sorted {
+		result = append(result, strconv.Itoa(port))
+	}
+	return result
+}
+
+// namespaceListeners returns the ports of the listening sockets, by inode,
+// of the network namespace of the process, reading them once per walk.
+func (d *Details) namespaceListeners(dir string) map[uint64]uint16 {
+	var stat syscall.Stat_t
+	if err := fs.Stat(path.Join(dir, "ns", "net"), &stat); err != nil {
+		return parseListeners(dir)
+	}
+	listeners, ok := d.listeners[stat.Ino]
+	if !ok {
+		listeners = parseListeners(dir)
+		d.listeners[stat.Ino] = listeners
+	}
+	return listeners
+}
+
+// parseListeners reads the listening sockets from /proc/PID/net/tcp{,6}.
+func parseListeners(dir string) map[uint64]uint16 {
+	result := map[uint64]uint16{}
+	for _, file := range []string{"tcp", "tcp6"} {
+		buf, err := fs.ReadFile(path.Join(dir, "net", file))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(buf))
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != tcpListen {
+				continue
+			}
+			colon := strings.LastIndexByte(fields[1], ':')
+			if colon < 0 {
+				continue
+			}
+			port, err := strconv.ParseUint(fields[1][colon+1:], 16, 16)
+			if err != nil {
+				continue
+			}
+			if inode, err := strconv.ParseUint(fields[9], 10, 64); err == nil {
+				result[inode] = uint16(port)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const netTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0
+`
+
+// makeProc makes a process in a fake proc, running exe, with sockets.
+func makeProc(t *testing.T, procRoot, pid, exe string, sockets ...string) {
+	dir := filepath.Join(procRoot, pid)
+	for _, sub := range []string{"fd", "ns", "net"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+	for i, socket := range sockets {
+		if err := os.Symlink("socket:["+socket+"]", filepath.Join(dir, "fd", string(rune('3'+i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/dev/null", filepath.Join(dir, "fd", "0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ns", "net"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(netTCP), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "details")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := []byte("#!/bin/sh\necho hello\n")
+	exe := filepath.Join(dir, "server")
+	if err := ioutil.WriteFile(exe, binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(binary)
+	hash := hex.EncodeToString(sum[:])
+
+	procRoot := filepath.Join(dir, "proc")
+	makeProc(t, procRoot, "1", exe, "1001", "1003")
+	makeProc(t, procRoot, "2", exe+" (deleted)")
+
+	d := NewDetails(procRoot, DefaultHashMaxSize)
+	d.beginWalk()
+	want := map[string]string{ExePath: exe, ExeSHA256: hash, ListeningPorts: "8080"}
+	if have := d.latests(1); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	// The link of a deleted binary doesn't resolve in the fake proc, so it
+	// has no hash
+	want = map[string]string{ExePath: exe, RunningDeletedBinary: "true"}
+	if have := d.latests(2); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+	d.endWalk()
+	if len(d.hashes) != 1 {
+		t.Errorf("Expected the hash to be kept for the next walk, got %v", d.hashes)
+	}
+
+	// Kernel threads have no executable
+	d.beginWalk()
+	if have := d.latests(3); len(have) != 0 {
+		t.Errorf("Expected no details, got %v", have)
+	}
+	d.endWalk()
+	if len(d.hashes) != 0 {
+		t.Errorf("Expected the hashes of executables no longer running to be forgotten, got %v", d.hashes)
+	}
+
+	// Executables too large aren't hashed, and hashing is rate-limited
+	d = NewDetails(procRoot, int64(len(binary)-1))
+	d.beginWalk()
+	if _, ok := d.latests(1)[ExeSHA256]; ok {
+		t.Errorf("Expected no hash of an executable too large")
+	}
+	d = NewDetails(procRoot, DefaultHashMaxSize)
+	d.beginWalk()
+	d.hashBudget = 0
+	if _, ok := d.latests(1)[ExeSHA256]; ok {
+		t.Errorf("Expected no hash once the budget is spent")
+	}
+}
+
+func TestParseListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(netTCP), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]uint16{1001: 8080, 2002: 22}
+	if have := parseListeners(dir); !reflect.DeepEqual(want, have) {
+		t.Errorf("Expected %v, got %v", want, have)
+	}
+}
@@ -0,0 +1,121 @@
+package process
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Keys of the lineage of processes.
+const (
+	LineageTablePrefix = "process_lineage_"
+	LineageName        = "process_lineage_name"
+	LineagePID         = "process_lineage_pid"
+	SuspiciousLineage  = "suspicious_lineage"
+
+	// maxAncestors is the number of ancestors in the lineage of processes.
+	maxAncestors = 5
+)
+
+// LineageMetadataTemplates are the templates of the flag of suspicious
+// lineages.
+var LineageMetadataTemplates = report.MetadataTemplates{
+	SuspiciousLineage: {ID: SuspiciousLineage, Label: "Suspicious lineage", From: report.FromLatest, Priority: 9},
+}
+
+// LineageTableTemplates are the templates of the lineage of processes.
+var LineageTableTemplates = report.TableTemplates{
+	LineageTablePrefix: {
+		ID:     LineageTablePrefix,
+		Label:  "Lineage",
+		Type:   report.MulticolumnTableType,
+		Prefix: LineageTablePrefix,
+		Columns: []report.Column{
+			{ID: LineageName, Label: "Name"},
+			{ID: LineagePID, Label: "PID", DataType: report.Number},
+		},
+	},
+}
+
+var (
+	shells = map[string]struct{}{
+		"sh": {}, "ash": {}, "bash": {}, "dash": {}, "ksh": {}, "zsh": {}, "busybox": {},
+	}
+	interpreters = []string{"python", "perl", "ruby", "node", "php", "lua"}
+	runtimeShims = map[string]struct{}{
+		"conmon": {}, "runc": {}, "crun": {},
+	}
+)
+
+// ancestors returns up to maxAncestors ancestors of p, its parent first.
+// A PID can be reused once its process is gone, so a "parent" which
+// started after its child is a newer process, and the lineage stops there.
+func ancestors(processes map[int]Process, p Process) []Process {
+	var result []Process
+	for len(result) < maxAncestors && p.PPID > 0 && p.PPID != p.PID {
+		parent, ok := processes[p.PPID]
+		if !ok || parent.StartTime > p.StartTime {
+			break
+		}
+		result = append(result, parent)
+		p = parent
+	}
+	return result
+}
+
+// lineageRows are the rows of the lineage table, by depth.
+func lineageRows(ancestors []Process) []report.Row {
+	rows := make([]report.Row, 0, len(ancestors))
+	for i, ancestor := range ancestors {
+		rows = append(rows, report.Row{
+			ID: strconv.Itoa(i + 1),
+			Entries: map[string]string{
+				LineageName: ancestor.Name,
+				LineagePID:  strconv.Itoa(ancestor.PID),
+			},
+		})
+	}
+	return rows
+}
+
+func isShellOrInterpreter(name string) bool {
+	// login shells are named eg -bash
+	name = strings.TrimPrefix(path.Base(name), "-")
+	if _, ok := shells[name]; ok {
+		return true
+	}
+	for _, interpreter := range interpreters {
+		// eg python3, python3.8
+		if strings.HasPrefix(name, interpreter) && strings.Trim(name[len(interpreter):], "0123456789.") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isRuntimeShim(name string) bool {
+	name = path.Base(name)
+	if strings.HasPrefix(name, "containerd-shim") || strings.HasPrefix(name, "docker-containerd-shim") {
+		return true
+	}
+	_, ok := runtimeShims[name]
+	return ok
+}
+
+// suspiciousLineage tells whether p is a shell or an interpreter started
+// by a container runtime shim, but left in the shim's cgroup rather than
+// in that of a container: the processes of containers, including those of
+// `docker exec`, are all moved into the container's cgroup.
+func suspiciousLineage(p Process, ancestors []Process, cgroup func(pid int) (string, bool)) bool {
+	if len(ancestors) == 0 || !isShellOrInterpreter(p.Name) || !isRuntimeShim(ancestors[0].Name) {
+		return false
+	}
+	child, ok := cgroup(p.PID)
+	if !ok {
+		return false
+	}
+	parent, ok := cgroup(ancestors[0].PID)
+	return ok && child == parent
+}
@@ -0,0 +1,6 @@
+package process
+
+// ReadCgroup returns nil, as processes have no cgroups on Darwin.
+func ReadCgroup(procRoot string) func(pid int) (string, bool) {
+	return nil
+}
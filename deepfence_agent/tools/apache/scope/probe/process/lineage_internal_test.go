@@ -0,0 +1,82 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func makeProcesses(processes ...Process) map[int]Process {
+	result := map[int]Process{}
+	for _, p := range processes {
+		result[p.PID] = p
+	}
+	return result
+}
+
+func pids(processes []Process) []int {
+	result := []int{}
+	for _, p := range processes {
+		result = append(result, p.PID)
+	}
+	return result
+}
+
+func TestAncestors(t *testing.T) {
+	processes := makeProcesses(
+		Process{PID: 1, Name: "/sbin/init", StartTime: 1},
+		Process{PID: 10, PPID: 1, Name: "/usr/bin/containerd", StartTime: 100},
+		Process{PID: 20, PPID: 10, Name: "/usr/bin/containerd-shim-runc-v2", StartTime: 200},
+		Process{PID: 30, PPID: 20, Name: "nginx", StartTime: 300},
+		Process{PID: 40, PPID: 30, Name: "nginx", StartTime: 400},
+		Process{PID: 50, PPID: 40, Name: "sh", StartTime: 500},
+		Process{PID: 60, PPID: 50, Name: "curl", StartTime: 600},
+		// The parent of this process is gone, and its PID reused
+		Process{PID: 70, PPID: 80, Name: "orphan", StartTime: 700},
+		Process{PID: 80, PPID: 1, Name: "newer", StartTime: 800},
+	)
+	for _, tc := range []struct {
+		pid  int
+		want []int
+	}{
+		{1, []int{}},
+		{30, []int{20, 10, 1}},
+		{60, []int{50, 40, 30, 20, 10}},
+		{70, []int{}},
+	} {
+		if have := pids(ancestors(processes, processes[tc.pid])); !reflect.DeepEqual(tc.want, have) {
+			t.Errorf("%d: expected %v, got %v", tc.pid, tc.want, have)
+		}
+	}
+
+	rows := lineageRows(ancestors(processes, processes[30]))
+	if len(rows) != 3 || rows[0].ID != "1" || rows[0].Entries[LineageName] != "/usr/bin/containerd-shim-runc-v2" || rows[0].Entries[LineagePID] != "20" {
+		t.Errorf("Unexpected rows %v", rows)
+	}
+}
+
+func TestSuspiciousLineage(t *testing.T) {
+	const (
+		shimCgroup      = "0::/system.slice/containerd.service\n"
+		containerCgroup = "0::/system.slice/docker-0123456789abcdef.scope\n"
+	)
+	cgroups := map[int]string{20: shimCgroup, 30: containerCgroup, 40: shimCgroup, 50: shimCgroup}
+	cgroup := func(pid int) (string, bool) {
+		c, ok := cgroups[pid]
+		return c, ok
+	}
+	processes := makeProcesses(
+		Process{PID: 20, Name: "/usr/bin/containerd-shim-runc-v2", StartTime: 200},
+		// The shell of `docker exec`, in the container's cgroup
+		Process{PID: 30, PPID: 20, Name: "/bin/sh", StartTime: 300},
+		// A shell left in the shim's cgroup
+		Process{PID: 40, PPID: 20, Name: "-bash", StartTime: 400},
+		Process{PID: 50, PPID: 20, Name: "python3.8", StartTime: 500},
+		Process{PID: 60, PPID: 20, Name: "nginx", StartTime: 600},
+	)
+	for pid, want := range map[int]bool{20: false, 30: false, 40: true, 50: true, 60: false} {
+		p := processes[pid]
+		if have := suspiciousLineage(p, ancestors(processes, p), cgroup); want != have {
+			t.Errorf("%d: expected %v, got %v", pid, want, have)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package process
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/weaveworks/common/fs"
+)
+
+// ReadCgroup returns a function reading the cgroups of processes, from
+// /proc/PID/cgroup, for their lineage to be checked.
+func ReadCgroup(procRoot string) func(pid int) (string, bool) {
+	return func(pid int) (string, bool) {
+		buf, err := fs.ReadFile(path.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+		if err != nil {
+			return "", false
+		}
+		return string(buf), true
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/weaveworks/scope/report"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -50,13 +51,23 @@ type Reporter struct {
 	noCommandLineArguments bool
 	reportCacheData        reportCache
 	hostName               string
+	details                *Details
+	inContainer            func(pid int) bool
+	cgroup                 func(pid int) (string, bool)
+	shed                   int32 // atomic
 }
 
 // Jiffies is the type for the function used to fetch the elapsed jiffies.
 type Jiffies func() (uint64, float64, error)
 
-// NewReporter makes a new Reporter.
-func NewReporter(walker Walker, scope string, jiffies Jiffies, noCommandLineArguments bool) *Reporter {
+// NewReporter makes a new Reporter.  The details of processes are reported
+// when details isn't nil.  The CPU and memory of processes for which
+// inContainer is true are left out, when it isn't nil, as those of their
+// containers are read from their cgroups; those of the host's own processes
+// are still sampled one by one.  Shells and interpreters left in the cgroup
+// of the runtime shim which started them are flagged in the lineage of
+// processes when cgroup, reading the cgroups of processes, isn't nil.
+func NewReporter(walker Walker, scope string, jiffies Jiffies, noCommandLineArguments bool, details *Details, inContainer func(pid int) bool, cgroup func(pid int) (string, bool)) *Reporter {
 	r := &Reporter{
 		scope:                  scope,
 		walker:                 walker,
@@ -64,6 +75,9 @@ func NewReporter(walker Walker, scope string, jiffies Jiffies, noCommandLineArgu
 		noCommandLineArguments: noCommandLineArguments,
 		reportCacheData:        reportCache{},
 		hostName:               hostname.Get(),
+		details:                details,
+		inContainer:            inContainer,
+		cgroup:                 cgroup,
 	}
 	go r.updateProcessCache()
 	return r
@@ -72,6 +86,19 @@ func NewReporter(walker Walker, scope string, jiffies Jiffies, noCommandLineArgu
 // Name of this reporter, for metrics gathering
 func (Reporter) Name() string { return "Process" }
 
+// Shed sets the reporter to leave out the metrics of processes, and its
+// walker, if it can, to walk them less often.
+func (r *Reporter) Shed(shed bool) {
+	var value int32
+	if shed {
+		value = 1
+	}
+	atomic.StoreInt32(&r.shed, value)
+	if w, ok := r.walker.(interface{ Shed(bool) }); ok {
+		w.Shed(shed)
+	}
+}
+
 func (r *Reporter) updateProcessCache() {
 
 	processData, err := r.processTopology()
@@ -113,13 +140,25 @@ func (r *Reporter) Report() (report.Report, error) {
 func (r *Reporter) processTopology() (report.Topology, error) {
 	t := report.MakeTopology().
 		WithMetadataTemplates(MetadataTemplates).
-		WithMetricTemplates(MetricTemplates)
+		WithMetricTemplates(MetricTemplates).
+		WithTableTemplates(LineageTableTemplates)
 	now := mtime.Now()
 	deltaTotal, maxCPU, err := r.jiffies()
 	if err != nil {
 		return t, err
 	}
+	if r.cgroup != nil {
+		t = t.WithMetadataTemplates(LineageMetadataTemplates)
+	}
+	if r.details != nil {
+		t = t.WithMetadataTemplates(DetailsMetadataTemplates)
+		r.details.beginWalk()
+		defer r.details.endWalk()
+	}
 
+	processes := map[int]Process{}
+	nodes := map[int]report.Node{}
+	shed := atomic.LoadInt32(&r.shed) == 1
 	err = r.walker.Walk(func(p, prev Process) {
 		pidstr := strconv.Itoa(p.PID)
 		nodeID := report.MakeProcessNodeID(r.scope, pidstr)
@@ -143,19 +182,42 @@ func (r *Reporter) processTopology() (report.Topology, error) {
 			node = node.WithLatest(PPID, now, strconv.Itoa(p.PPID))
 		}
 
-		var metrics = report.Metrics{
-			MemoryUsage:    report.MakeSingletonMetric(now, float64(p.RSSBytes)).WithMax(float64(p.RSSBytesLimit)),
-			OpenFilesCount: report.MakeSingletonMetric(now, float64(p.OpenFilesCount)).WithMax(float64(p.OpenFilesLimit)),
+		// Shed, processes are reported without their metrics
+		if !shed {
+			var metrics = report.Metrics{
+				OpenFilesCount: report.MakeSingletonMetric(now, float64(p.OpenFilesCount)).WithMax(float64(p.OpenFilesLimit)),
+			}
+			if r.inContainer == nil || !r.inContainer(p.PID) {
+				metrics[MemoryUsage] = report.MakeSingletonMetric(now, float64(p.RSSBytes)).WithMax(float64(p.RSSBytesLimit))
+				if deltaTotal > 0 {
+					cpuUsage := float64(p.Jiffies-prev.Jiffies) / float64(deltaTotal) * 100.
+					metrics[CPUUsage] = report.MakeSingletonMetric(now, cpuUsage).WithMax(maxCPU)
+				}
+			}
+			node = node.WithMetrics(metrics)
 		}
-		if deltaTotal > 0 {
-			cpuUsage := float64(p.Jiffies-prev.Jiffies) / float64(deltaTotal) * 100.
-			metrics[CPUUsage] = report.MakeSingletonMetric(now, cpuUsage).WithMax(maxCPU)
+		if r.details != nil {
+			node = node.WithLatests(r.details.latests(p.PID))
 		}
 
-		node = node.WithMetrics(metrics)
-
-		t.AddNode(node)
+		processes[p.PID] = p
+		nodes[p.PID] = node
 	})
 
+	// The lineage of processes is only known once they have all been walked
+	for pid, node := range nodes {
+		t.AddNode(r.withLineage(node, processes, processes[pid]))
+	}
 	return t, err
 }
+
+func (r *Reporter) withLineage(node report.Node, processes map[int]Process, p Process) report.Node {
+	ancestors := ancestors(processes, p)
+	if len(ancestors) == 0 {
+		return node
+	}
+	if r.cgroup != nil && suspiciousLineage(p, ancestors, r.cgroup) {
+		node = node.WithLatest(SuspiciousLineage, mtime.Now(), "true")
+	}
+	return node.AddPrefixMulticolumnTable(LineageTablePrefix, lineageRows(ancestors))
+}
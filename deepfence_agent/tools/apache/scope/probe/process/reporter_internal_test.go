@@ -0,0 +1,68 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/weaveworks/scope/report"
+)
+
+type staticWalker []Process
+
+func (w staticWalker) Walk(f func(Process, Process)) error {
+	for _, p := range w {
+		f(p, Process{})
+	}
+	return nil
+}
+
+func TestProcessTopologyInContainer(t *testing.T) {
+	r := &Reporter{
+		walker:      staticWalker{{PID: 1, Jiffies: 10}, {PID: 2, Jiffies: 10}},
+		jiffies:     func() (uint64, float64, error) { return 100, 100., nil },
+		inContainer: func(pid int) bool { return pid == 2 },
+	}
+	topology, err := r.processTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := topology.Nodes[report.MakeProcessNodeID("", "1")]
+	if _, ok := host.Metrics[CPUUsage]; !ok {
+		t.Errorf("Expected the CPU of the host's process, got %v", host.Metrics)
+	}
+	if _, ok := host.Metrics[MemoryUsage]; !ok {
+		t.Errorf("Expected the memory of the host's process, got %v", host.Metrics)
+	}
+	container := topology.Nodes[report.MakeProcessNodeID("", "2")]
+	if _, ok := container.Metrics[CPUUsage]; ok {
+		t.Errorf("Expected no CPU for the container's process, got %v", container.Metrics)
+	}
+	if _, ok := container.Metrics[MemoryUsage]; ok {
+		t.Errorf("Expected no memory for the container's process, got %v", container.Metrics)
+	}
+	if _, ok := container.Metrics[OpenFilesCount]; !ok {
+		t.Errorf("Expected the open files of the container's process, got %v", container.Metrics)
+	}
+}
+
+func TestProcessTopologyLineage(t *testing.T) {
+	// Without the details of processes
+	r := &Reporter{
+		walker: staticWalker{
+			{PID: 20, Name: "/usr/bin/containerd-shim-runc-v2", StartTime: 200},
+			{PID: 40, PPID: 20, Name: "-bash", StartTime: 400},
+		},
+		jiffies: func() (uint64, float64, error) { return 100, 100., nil },
+		cgroup:  func(pid int) (string, bool) { return "0::/system.slice/containerd.service\n", true },
+	}
+	topology, err := r.processTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell := topology.Nodes[report.MakeProcessNodeID("", "40")]
+	if rows := shell.ExtractMulticolumnTable(topology.TableTemplates[LineageTablePrefix]); len(rows) != 1 {
+		t.Errorf("Expected the lineage of the shell, got %v", rows)
+	}
+	if suspicious, _ := shell.Latest.Lookup(SuspiciousLineage); suspicious != "true" {
+		t.Errorf("Expected the shell to be flagged, got %q", suspicious)
+	}
+}
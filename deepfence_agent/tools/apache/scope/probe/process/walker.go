@@ -1,6 +1,13 @@
 package process
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// shedTicks is how many ticks of a CachingWalker walk the processes once,
+// while it is shed.
+const shedTicks = 10
 
 // Process represents a single process.
 type Process struct {
@@ -9,6 +16,7 @@ type Process struct {
 	Cmdline           string
 	Threads           int
 	Jiffies           uint64
+	StartTime         uint64 // in jiffies since boot
 	RSSBytes          uint64
 	RSSBytesLimit     uint64
 	OpenFilesCount    int
@@ -28,6 +36,9 @@ type CachingWalker struct {
 	previousByPID map[int]Process
 	cacheLock     sync.RWMutex
 	source        Walker
+
+	shed  int32 // atomic
+	ticks int
 }
 
 // NewCachingWalker returns a new CachingWalker
@@ -49,8 +60,26 @@ func (c *CachingWalker) Walk(f func(Process, Process)) error {
 	return nil
 }
 
+// Shed sets the walker to walk the processes only every shedTicks ticks,
+// as walking /proc is the most expensive thing the probe does on hosts
+// with many processes.  Those walked last are walked in between.
+func (c *CachingWalker) Shed(shed bool) {
+	var value int32
+	if shed {
+		value = 1
+	}
+	atomic.StoreInt32(&c.shed, value)
+}
+
 // Tick updates cached copy of process list
 func (c *CachingWalker) Tick() error {
+	if atomic.LoadInt32(&c.shed) == 1 {
+		if c.ticks++; c.ticks%shedTicks != 0 {
+			return nil
+		}
+	} else {
+		c.ticks = 0
+	}
 	newCache := map[int]Process{}
 	err := c.source.Walk(func(p, _ Process) {
 		newCache[p.PID] = p
@@ -74,7 +74,7 @@ func parseIntWithSpaces(buf *[]byte, pos *int) (ret int) {
 }
 
 // readStats reads and parses '/proc/<pid>/stat' files
-func readStats(path string) (ppid, threads int, jiffies, rss, rssLimit uint64, err error) {
+func readStats(path string) (ppid, threads int, jiffies, startTime, rss, rssLimit uint64, err error) {
 	const (
 		// /proc/<pid>/stat field positions, counting from zero
 		// see "man 5 proc"
@@ -83,6 +83,7 @@ func readStats(path string) (ppid, threads int, jiffies, rss, rssLimit uint64, e
 		procStatFieldUserJiffies int = 13
 		procStatFieldSysJiffies  int = 14
 		procStatFieldThreads     int = 19
+		procStatFieldStartTime   int = 21
 		procStatFieldRssPages    int = 23
 		procStatFieldRssLimit    int = 24
 	)
@@ -119,7 +120,10 @@ func readStats(path string) (ppid, threads int, jiffies, rss, rssLimit uint64, e
 	skipNSpaces(&buf, &pos, procStatFieldThreads-procStatFieldSysJiffies)
 	threads = parseIntWithSpaces(&buf, &pos)
 
-	skipNSpaces(&buf, &pos, procStatFieldRssPages-procStatFieldThreads)
+	skipNSpaces(&buf, &pos, procStatFieldStartTime-procStatFieldThreads)
+	startTime = parseUint64WithSpaces(&buf, &pos)
+
+	skipNSpaces(&buf, &pos, procStatFieldRssPages-procStatFieldStartTime)
 	rssPages = parseUint64WithSpaces(&buf, &pos)
 
 	pos++ // 1 space between rssPages and rssLimit
@@ -223,7 +227,7 @@ func (w *walker) Walk(f func(Process, Process)) error {
 			continue
 		}
 
-		ppid, threads, jiffies, rss, rssLimit, err := readStats(path.Join(w.procRoot, filename, "stat"))
+		ppid, threads, jiffies, startTime, rss, rssLimit, err := readStats(path.Join(w.procRoot, filename, "stat"))
 		if err != nil {
 			continue
 		}
@@ -268,6 +272,7 @@ func (w *walker) Walk(f func(Process, Process)) error {
 			Cmdline:           cmdline,
 			Threads:           threads,
 			Jiffies:           jiffies,
+			StartTime:         startTime,
 			RSSBytes:          rss,
 			RSSBytesLimit:     rssLimit,
 			OpenFilesCount:    openFilesCount,
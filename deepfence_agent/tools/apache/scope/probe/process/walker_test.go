@@ -58,6 +58,38 @@ func TestCache(t *testing.T) {
 	}
 }
 
+func TestCacheShed(t *testing.T) {
+	walker := &mockWalker{processes: []process.Process{{PID: 1, Name: "init"}}}
+	cachingWalker := process.NewCachingWalker(walker)
+	cachingWalker.Shed(true)
+
+	// Shed, the processes are walked only every so many ticks
+	walked := 0
+	for i := 0; i < 20; i++ {
+		walker.processes = []process.Process{{PID: 1, Name: "init", Threads: i}}
+		if err := cachingWalker.Tick(); err != nil {
+			t.Fatal(err)
+		}
+		cachingWalker.Walk(func(p, _ process.Process) {
+			if p.Threads == i {
+				walked++
+			}
+		})
+	}
+	if walked != 2 {
+		t.Errorf("Expected the processes walked twice, were %d times", walked)
+	}
+
+	cachingWalker.Shed(false)
+	walker.processes = []process.Process{}
+	if err := cachingWalker.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := all(cachingWalker); len(have) != 0 {
+		t.Errorf("Expected the processes walked once restored, have %v", have)
+	}
+}
+
 func all(w process.Walker) (map[process.Process]struct{}, error) {
 	all := map[process.Process]struct{}{}
 	err := w.Walk(func(p, _ process.Process) {
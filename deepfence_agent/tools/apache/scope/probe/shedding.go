@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+const (
+	// Collectors shed are restored one at a time, after this many spy
+	// cycles in a row with the probe's CPU usage under shedHeadroom of
+	// its budget
+	shedRestoreCycles = 3
+	shedHeadroom      = 0.7
+)
+
+// Sheddable is a collector of the probe which can shed some of what it
+// collects, to save CPU, while the probe is over its CPU budget: detail
+// rather than the topology itself, and the work of collecting it.
+type Sheddable interface {
+	Name() string
+	// Shed sets whether to shed, as that changes.
+	Shed(bool)
+}
+
+// shedder sheds the collectors of the probe, the most expensive first,
+// one more every spy cycle the probe uses more CPU than its budget, and
+// restores them once there is headroom again.
+type shedder struct {
+	budget     float64     // in cores
+	collectors []Sheddable // in order
+
+	mtx      sync.Mutex
+	shed     int // how many of collectors are shed
+	calm     int // spy cycles in a row under the headroom
+	lastCPU  time.Duration
+	lastTime time.Time
+}
+
+func newShedder(budget float64, collectors []Sheddable) *shedder {
+	return &shedder{budget: budget, collectors: collectors}
+}
+
+// update takes the CPU time used by the probe so far, at now, shedding or
+// restoring collectors as the usage since the last update warrants.
+func (s *shedder) update(cpu time.Duration, now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	lastCPU, lastTime := s.lastCPU, s.lastTime
+	s.lastCPU, s.lastTime = cpu, now
+	if lastTime.IsZero() || !now.After(lastTime) {
+		return
+	}
+	usage := float64(cpu-lastCPU) / float64(now.Sub(lastTime))
+	switch {
+	case usage > s.budget:
+		s.calm = 0
+		if s.shed < len(s.collectors) {
+			log.Warnf("Probe using %.2f cores, over its budget of %.2f: shedding %s", usage, s.budget, s.collectors[s.shed].Name())
+			s.collectors[s.shed].Shed(true)
+			s.shed++
+		}
+	case usage < s.budget*shedHeadroom:
+		if s.shed == 0 {
+			return
+		}
+		if s.calm++; s.calm >= shedRestoreCycles {
+			s.calm = 0
+			s.shed--
+			log.Infof("Probe using %.2f cores, within its budget of %.2f: restoring %s", usage, s.budget, s.collectors[s.shed].Name())
+			s.collectors[s.shed].Shed(false)
+		}
+	default:
+		s.calm = 0
+	}
+}
+
+// shedCollectors returns the names of the collectors shed.
+func (s *shedder) shedCollectors() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	names := []string{}
+	for _, c := range s.collectors[:s.shed] {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// cpuTime returns the CPU time, user and system, used by the probe.
+func cpuTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+// shed updates the shedder of the probe, if any, with the CPU time used.
+func (p *Probe) shed() {
+	if p.shedder == nil {
+		return
+	}
+	cpu, err := cpuTime()
+	if err != nil {
+		log.Errorf("Error getting the CPU time of the probe: %v", err)
+		return
+	}
+	p.shedder.update(cpu, mtime.Now())
+}
+
+// SetCPUBudget sets the probe to keep within a CPU budget, in cores, by
+// shedding collectors, the first first, while it uses more.  Reporters and
+// tickers shed keep running, collecting less.
+func (p *Probe) SetCPUBudget(cores float64, collectors ...Sheddable) {
+	p.shedder = newShedder(cores, collectors)
+}
+
+// ShedReporter returns a Reporter of the collectors shed, on the node of
+// the host hostID, so it's clear why their data is missing.
+func (p *Probe) ShedReporter(hostID string) Reporter {
+	return ReporterFunc("Shedding", func() (report.Report, error) {
+		rpt := report.MakeReport()
+		if p.shedder == nil {
+			return rpt, nil
+		}
+		if shed := p.shedder.shedCollectors(); len(shed) > 0 {
+			rpt.Host.AddNode(report.MakeNodeWith(report.MakeHostNodeID(hostID), map[string]string{
+				report.HostShedCollectors: strings.Join(shed, ", "),
+			}))
+		}
+		return rpt, nil
+	})
+}
@@ -0,0 +1,96 @@
+package probe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// mockSheddable reports a node, with a metric unless shed.
+type mockSheddable struct {
+	name string
+	shed bool
+}
+
+func (m *mockSheddable) Name() string   { return m.name }
+func (m *mockSheddable) Shed(shed bool) { m.shed = shed }
+
+func (m *mockSheddable) Report() (report.Report, error) {
+	rpt := report.MakeReport()
+	node := report.MakeNode("a")
+	if !m.shed {
+		node = node.WithMetrics(report.Metrics{"cpu": report.MakeSingletonMetric(time.Now(), 1)})
+	}
+	rpt.Endpoint.AddNode(node)
+	return rpt, nil
+}
+
+func TestShedder(t *testing.T) {
+	endpoint, process := &mockSheddable{name: "Endpoint"}, &mockSheddable{name: "Process"}
+	s := newShedder(1, []Sheddable{endpoint, process})
+	var (
+		now = time.Now()
+		cpu time.Duration
+	)
+	s.update(cpu, now)
+	for i, step := range []struct {
+		cores float64
+		want  []string
+	}{
+		{2, []string{"Endpoint"}},
+		{2, []string{"Endpoint", "Process"}},
+		{2, []string{"Endpoint", "Process"}},
+		// Restored one at a time, after a few cycles with headroom
+		{0.5, []string{"Endpoint", "Process"}},
+		{0.5, []string{"Endpoint", "Process"}},
+		{0.5, []string{"Endpoint"}},
+		// Within the budget, but without much headroom
+		{0.5, []string{"Endpoint"}},
+		{0.9, []string{"Endpoint"}},
+		{0.5, []string{"Endpoint"}},
+		{0.5, []string{"Endpoint"}},
+		{0.5, []string{}},
+		{0.1, []string{}},
+	} {
+		now = now.Add(time.Second)
+		cpu += time.Duration(step.cores * float64(time.Second))
+		s.update(cpu, now)
+		if have := s.shedCollectors(); !reflect.DeepEqual(step.want, have) {
+			t.Errorf("%d: want %v shed, have %v", i, step.want, have)
+		}
+		// The collectors are told as they're shed and restored
+		if have := []bool{endpoint.shed, process.shed}; !reflect.DeepEqual([]bool{len(step.want) > 0, len(step.want) > 1}, have) {
+			t.Errorf("%d: want %v shed, have %v", i, step.want, have)
+		}
+	}
+}
+
+func TestProbeSheds(t *testing.T) {
+	mock := &mockSheddable{name: "Mock"}
+	p := New(0, 0, nil, 1, false)
+	p.AddReporter(mock)
+	p.SetCPUBudget(1, mock)
+	shedReporter := p.ShedReporter("host1")
+	p.AddReporter(shedReporter)
+
+	have := p.report()
+	if len(have.Endpoint.Nodes["a"].Metrics) != 1 || len(have.Host.Nodes) != 0 {
+		t.Fatalf("Expected the node with its metric, and no collectors shed, have %v", have)
+	}
+
+	now := time.Now()
+	p.shedder.update(0, now)
+	p.shedder.update(2*time.Second, now.Add(time.Second))
+	have = p.report()
+	// Shed, the reporter still reports, with less detail
+	node, ok := have.Endpoint.Nodes["a"]
+	if !ok || len(node.Metrics) != 0 {
+		t.Errorf("Expected the node without its metric, have %v", have.Endpoint.Nodes)
+	}
+	host := have.Host.Nodes[report.MakeHostNodeID("host1")]
+	if shed, _ := host.Latest.Lookup(report.HostShedCollectors); shed != "Mock" {
+		t.Errorf("Expected the collectors shed on the host node, have %q", shed)
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"os"
+	"os/signal"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/goji/httpauth"
@@ -32,11 +36,14 @@ import (
 	"github.com/weaveworks/scope/common/weave"
 	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
 )
 
 const (
 	memcacheUpdateInterval = 1 * time.Minute
 	httpTimeout            = 90 * time.Second
+	eventsKept             = 1000 // recent events kept per tenant
 )
 
 var (
@@ -51,21 +58,35 @@ var (
 func registerAppMetrics() {
 	prometheus.MustRegister(requestDuration)
 	billing.MustRegisterMetrics()
+	app.MustRegisterMetrics()
 }
 
 var registerAppMetricsOnce sync.Once
 
 // Router creates the mux for all the various app components.
-func router(collector app.Collector, controlRouter app.ControlRouter, pipeRouter app.PipeRouter, externalUI bool, capabilities map[string]bool, metricsGraphURL string) http.Handler {
+func router(collector app.Collector, controlRouter app.ControlRouter, pipeRouter app.PipeRouter, probeRegistry app.ProbeRegistry, secretFindings *app.SecretFindingsStore, complianceResults *app.ComplianceResultsStore, destinations *app.DestinationTracker, events *app.EventStream, externalUI bool, capabilities map[string]bool, metricsGraphURL string) http.Handler {
 	router := mux.NewRouter().SkipClean(true)
 
 	// We pull in the http.DefaultServeMux to get the pprof routes
 	router.Path("/metrics").Handler(promhttp.Handler())
 
-	app.RegisterReportPostHandler(collector, router)
-	app.RegisterControlRoutes(router, controlRouter)
+	app.RegisterHandshakeHandler(router, probeRegistry, capabilities)
+	app.RegisterReportPostHandler(collector, router, probeRegistry)
+	app.RegisterControlRoutes(router, controlRouter, collector)
 	app.RegisterPipeRoutes(router, pipeRouter)
-	app.RegisterTopologyRoutes(router, app.WebReporter{Reporter: collector, MetricsGraphURL: metricsGraphURL}, capabilities)
+	app.RegisterSecretFindingsHandler(router, secretFindings)
+	app.RegisterComplianceResultsHandler(router, complianceResults)
+	var reporter app.Reporter = app.VulnerabilityScansReporter{Reporter: collector, Statuses: app.VulnerabilityStatuses}
+	reporter = app.ComplianceResultsReporter{
+		Reporter: app.SecretFindingsReporter{Reporter: reporter, Findings: secretFindings},
+		Results:  complianceResults,
+	}
+	if destinations != nil {
+		reporter = app.DestinationsReporter{Reporter: reporter, Tracker: destinations}
+	}
+	app.RegisterEventRoutes(router, events)
+	app.RegisterTopologyRoutes(router, app.WebReporter{Reporter: reporter, MetricsGraphURL: metricsGraphURL}, capabilities)
+	app.RegisterProbeRoutes(router, collector, probeRegistry)
 	app.RegisterAdminRoutes(router, collector)
 	//go app.CacheTopology(collector)
 
@@ -88,8 +109,33 @@ func router(collector app.Collector, controlRouter app.ControlRouter, pipeRouter
 	return middlewares.Wrap(router)
 }
 
+// reloadOnSIGHUP reads the tokens of tokenAuth again on each SIGHUP.
+func reloadOnSIGHUP(tokenAuth *app.TokenAuth) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := tokenAuth.Reload(); err != nil {
+			log.Errorf("Error reloading API tokens, keeping those read before: %v", err)
+			continue
+		}
+		log.Infof("Reloaded API tokens")
+	}
+}
+
+// exemptPath serves requests for path with exempt, and others with handler.
+func exemptPath(path string, exempt, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			exempt.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func collectorFactory(userIDer multitenant.UserIDer, collectorURL, s3URL string, storeInterval time.Duration, natsHostname string,
-	memcacheConfig multitenant.MemcacheConfig, window time.Duration, maxTopNodes int, createTables bool) (app.Collector, error) {
+	memcacheConfig multitenant.MemcacheConfig, window time.Duration, maxTopNodes int, latestMaxAge time.Duration,
+	metricsDownsampleAge time.Duration, metricsDownsamplePoints int, createTables bool) (app.Collector, error) {
 	if collectorURL == "local" {
 		return app.NewCollector(window), nil
 	} else if collectorURL == "async" {
@@ -139,6 +185,10 @@ func collectorFactory(userIDer multitenant.UserIDer, collectorURL, s3URL string,
 				MemcacheClient: memcacheClient,
 				Window:         window,
 				MaxTopNodes:    maxTopNodes,
+				LatestMaxAge:   latestMaxAge,
+
+				MetricsDownsampleAge:    metricsDownsampleAge,
+				MetricsDownsamplePoints: metricsDownsamplePoints,
 			},
 		)
 		if err != nil {
@@ -216,10 +266,31 @@ func pipeRouterFactory(userIDer multitenant.UserIDer, pipeRouterURL, consulInf s
 	return nil, fmt.Errorf("Invalid pipe router '%s'", pipeRouterURL)
 }
 
+func probeRegistryFactory(userIDer multitenant.UserIDer, probeRegistryURL string, expiry time.Duration) (app.ProbeRegistry, error) {
+	if probeRegistryURL == "local" {
+		return app.NewLocalProbeRegistry(expiry), nil
+	}
+
+	parsed, err := url.Parse(probeRegistryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme == "consul" {
+		consulClient, err := multitenant.NewConsulClient(parsed.Host)
+		if err != nil {
+			return nil, err
+		}
+		return multitenant.NewConsulProbeRegistry(consulClient, strings.TrimPrefix(parsed.Path, "/"), userIDer, expiry), nil
+	}
+
+	return nil, fmt.Errorf("Invalid probe registry '%s'", probeRegistryURL)
+}
+
 // Main runs the app
 func appMain(flags appFlags) {
 	setLogLevel(flags.logLevel)
-	setLogFormatter(flags.logPrefix)
+	setLogFormatter(flags.logFormat, flags.logPrefix)
 	runtime.SetBlockProfileRate(flags.blockProfileRate)
 
 	registerAppMetricsOnce.Do(registerAppMetrics)
@@ -235,13 +306,60 @@ func appMain(flags appFlags) {
 	rand.Seed(time.Now().UnixNano())
 	app.UniqueID = strconv.FormatInt(rand.Int63(), 16)
 	app.Version = version
+	app.ClockSkewThreshold = flags.clockSkewThreshold
+	app.MaxReportSize = flags.maxReportSize
+	app.ReportLimits = report.Limits{
+		MaxNodes:     flags.reportMaxNodes,
+		MaxIDLength:  flags.reportMaxIDLength,
+		MaxClockSkew: flags.reportMaxClockSkew,
+	}
+	app.SanitizeReports = flags.sanitizeReports
+	app.MaxReportAge = flags.reportMaxAge
+	app.MaxHistoryWindow = flags.historyMaxWindow
+	app.WebsocketSendDeadline = flags.wsSendDeadline
+	app.RenderMaxNodes = flags.renderMaxNodes
+	app.RenderTimeout = flags.renderTimeout
+	app.RenderConcurrency = flags.renderConcurrency
+	app.ConfigureRenderCache(flags.renderCacheSize, flags.renderCacheTTL)
+	app.ProbeReportTimeout = flags.probeReportTimeout
+	app.ProbeReportMaxBytes = flags.probeReportMaxBytes
+	app.ConfigureControlRateLimits(flags.controlUserRate, flags.controlHostRate)
+	app.BatchControlParallelism = flags.controlBatchParallelism
+	app.BatchControlTimeout = flags.controlBatchTimeout
+	if flags.exposureWeights != "" {
+		weights, err := render.LoadExposureWeights(flags.exposureWeights)
+		if err != nil {
+			log.Fatalf("Error loading exposure weights: %v", err)
+		}
+		app.ExposureWeights = weights
+	}
+	if flags.controlAuditLog != "" {
+		auditLog, err := os.OpenFile(flags.controlAuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("Error opening control audit log: %v", err)
+		}
+		defer auditLog.Close()
+		app.ControlAuditLog = log.New()
+		app.ControlAuditLog.Out = auditLog
+		app.ControlAuditLog.Formatter = &log.JSONFormatter{}
+	}
 	log.Infof("app starting, version %s, ID %s", app.Version, app.UniqueID)
 	logCensoredArgs()
 
+	if flags.cloudRangesRefresh > 0 {
+		go app.RefreshCloudRanges(context.Background(), app.CloudRangeSources{
+			AWS:   flags.cloudRangesAWS,
+			GCP:   flags.cloudRangesGCP,
+			Azure: flags.cloudRangesAzure,
+		}, flags.cloudRangesRefresh)
+	}
+
 	userIDer := multitenant.NoopUserIDer
 	if flags.userIDHeader != "" {
 		userIDer = multitenant.UserIDHeader(flags.userIDHeader)
+		app.WithTenantID = multitenant.WithUserIDHeader(flags.userIDHeader)
 	}
+	app.TenantID = userIDer
 
 	collector, err := collectorFactory(
 		userIDer, flags.collectorURL, flags.s3URL, flags.storeInterval, flags.natsHostname,
@@ -253,11 +371,14 @@ func appMain(flags appFlags) {
 			Service:          flags.memcachedService,
 			CompressionLevel: flags.memcachedCompressionLevel,
 		},
-		flags.window, flags.maxTopNodes, flags.awsCreateTables)
+		flags.window, flags.maxTopNodes, flags.latestMaxAge,
+		flags.metricsDownsampleAge, flags.metricsDownsamplePoints, flags.awsCreateTables)
 	if err != nil {
 		log.Fatalf("Error creating collector: %v", err)
 		return
 	}
+	// Before wrapping, which hides whether it can keep state
+	states, _ := collector.(app.StateStore)
 
 	if flags.BillingEmitterConfig.Enabled {
 		billingEmitter, err := emitterFactory(collector, flags.BillingClientConfig, userIDer, flags.BillingEmitterConfig)
@@ -281,6 +402,12 @@ func appMain(flags appFlags) {
 		return
 	}
 
+	probeRegistry, err := probeRegistryFactory(userIDer, flags.probeRegistryURL, flags.probeExpiry)
+	if err != nil {
+		log.Fatalf("Error creating probe registry: %v", err)
+		return
+	}
+
 	// Start background version checking
 	// checkpoint.CheckInterval(&checkpoint.CheckParams{
 	// 	Product: "deepfence-topology",
@@ -310,9 +437,79 @@ func appMain(flags appFlags) {
 
 	capabilities := map[string]bool{
 		xfer.HistoricReportsCapability: collector.HasHistoricReports(),
+		xfer.ProtobufReportsCapability: true,
+		xfer.ReportDeltasCapability:    true,
+		xfer.ProtobufIDTableCapability: true,
+		xfer.ReportChecksumCapability:  true,
+	}
+	events := app.NewEventStream(eventsKept)
+	var destinations *app.DestinationTracker
+	if flags.destinationsLearning > 0 {
+		ignore, err := app.ParseDestinationIgnores(flags.destinationsIgnore)
+		if err != nil {
+			log.Fatalf("Error parsing destinations to ignore: %v", err)
+			return
+		}
+		// The state of the AWS collector is in S3, for the replicas of the
+		// app to share; that of others is kept on disk, if asked to, so as
+		// to outlive the app
+		if flags.destinationsStateDir != "" && !strings.HasPrefix(flags.collectorURL, "dynamodb:") {
+			if states, err = app.NewFileStateStore(flags.destinationsStateDir); err != nil {
+				log.Fatalf("Error opening the state of destinations: %v", err)
+				return
+			}
+		}
+		destinations = app.NewDestinationTracker(app.DestinationOptions{
+			Learning: flags.destinationsLearning,
+			FlagFor:  flags.destinationsFlagFor,
+			Ignore:   ignore,
+			Store:    states,
+		}, events)
+		// Destinations are learned as reports are added
+		collector = app.DestinationsCollector{Collector: collector, Tracker: destinations}
+	}
+
+	rules, err := app.ParseEventRules(flags.eventRules)
+	if err != nil {
+		log.Fatalf("Invalid value for -app.events.rules: %v", err)
+		return
+	}
+	var webhooks []app.WebhookTarget
+	for _, u := range strings.Split(flags.webhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			webhooks = append(webhooks, app.WebhookTarget{URL: u, Secret: flags.webhookSecret})
+		}
+	}
+	if flags.webhookConfig != "" {
+		config, err := app.ReadWebhookConfig(flags.webhookConfig)
+		if err != nil {
+			log.Fatalf("Error reading -app.webhooks.config: %v", err)
+			return
+		}
+		if config.Rules != nil {
+			rules = config.Rules
+		}
+		webhooks = append(webhooks, config.Targets...)
+	}
+	if len(webhooks) > 0 {
+		sink, err := app.NewWebhookSink(webhooks, app.DefaultWebhookOptions)
+		if err != nil {
+			log.Fatalf("Invalid webhook: %v", err)
+			return
+		}
+		events.AddSink(sink)
+		go sink.Run(context.Background())
 	}
+	var ruleEvaluator *app.RuleEvaluator
+	if len(rules) > 0 {
+		ruleEvaluator = app.NewRuleEvaluator(rules, probeRegistry, events)
+		scans := app.VulnerabilityScansReporter{Reporter: collector, Statuses: app.VulnerabilityStatuses}
+		go ruleEvaluator.Watch(context.Background(), scans)
+	}
+
 	logger := logging.Logrus(log.StandardLogger())
-	handler := router(collector, controlRouter, pipeRouter, flags.externalUI, capabilities, flags.metricsGraphURL)
+	drainer := app.NewDrainer(time.Second)
+	handler := drainer.Wrap(router(collector, controlRouter, pipeRouter, probeRegistry, app.NewSecretFindingsStore(flags.secretFindingsTTL), app.NewComplianceResultsStore(flags.complianceStaleAfter), destinations, events, flags.externalUI, capabilities, flags.metricsGraphURL))
 	if flags.logHTTP {
 		handler = middleware.Log{
 			Log:               logger,
@@ -320,6 +517,18 @@ func appMain(flags appFlags) {
 		}.Wrap(handler)
 	}
 
+	unauthed := handler
+	if flags.authTokensFile != "" {
+		tokenAuth, err := app.NewTokenAuth(flags.authTokensFile, flags.authProbeTokensFile)
+		if err != nil {
+			log.Fatalf("Error reading API tokens: %v", err)
+			return
+		}
+		log.Infof("Token authentication enabled")
+		go reloadOnSIGHUP(tokenAuth)
+		handler = tokenAuth.Wrap(handler)
+	}
+
 	if flags.basicAuth {
 		log.Infof("Basic authentication enabled")
 		handler = httpauth.SimpleBasicAuth(flags.username, flags.password)(handler)
@@ -327,6 +536,21 @@ func appMain(flags appFlags) {
 		log.Infof("Basic authentication disabled")
 	}
 
+	if flags.metricsWithoutAuth && (flags.basicAuth || flags.authTokensFile != "") {
+		log.Infof("Authentication disabled for /metrics")
+		handler = exemptPath("/metrics", unauthed, handler)
+	}
+
+	if len(flags.corsOrigins) > 0 {
+		log.Infof("CORS enabled for %v", flags.corsOrigins)
+		handler = app.CORS{Origins: flags.corsOrigins}.Wrap(handler)
+	}
+	if prefix := strings.Trim(flags.httpPrefix, "/"); prefix != "" {
+		app.URLPrefix = "/" + prefix
+		log.Infof("Serving under %s", app.URLPrefix)
+		handler = app.StripPrefix(app.URLPrefix, handler)
+	}
+
 	server := &graceful.Server{
 		// we want to manage the stop condition ourselves below
 		NoSignalHandling: true,
@@ -349,28 +573,39 @@ func appMain(flags appFlags) {
 	signals.SignalHandlerLoop(
 		logger,
 		stopper{
-			Server:      server,
-			StopTimeout: flags.stopTimeout,
+			Server:       server,
+			StopTimeout:  flags.stopTimeout,
+			Drainer:      drainer,
+			DrainTimeout: flags.drainTimeout,
 		},
 	)
 }
 
 // stopper adapts graceful.Server's interface to signals.SignalReceiver's interface.
 type stopper struct {
-	Server      *graceful.Server
-	StopTimeout time.Duration
+	Server       *graceful.Server
+	StopTimeout  time.Duration
+	Drainer      *app.Drainer
+	DrainTimeout time.Duration
 }
 
 // Stop implements signals.SignalReceiver's Stop method.
 func (c stopper) Stop() error {
-	// stop listening, wait for any active connections to finish
+	// refuse publishes, say goodbye to probes, and wait for reports being added
+	ctx, cancel := context.WithTimeout(context.Background(), c.DrainTimeout)
+	defer cancel()
+	if err := c.Drainer.Drain(ctx); err != nil {
+		log.Warnf("Reports still being added after %v: %v", c.DrainTimeout, err)
+	}
+	// stop listening, wait for any active connections to finish; pending
+	// reports and billing events are flushed when the collector is closed
 	c.Server.Stop(c.StopTimeout)
 	<-c.Server.StopChan()
 	return nil
 }
 
 func newWeavePublisher(dockerEndpoint, weaveAddr, weaveHostname, containerName string) (*app.WeavePublisher, error) {
-	dockerClient, err := docker.NewDockerClientStub(dockerEndpoint)
+	dockerClient, err := docker.NewDockerClientStub(dockerEndpoint, docker.TLSOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +28,7 @@ func TestMakeContainerFiltersFromFlags(t *testing.T) {
 }
 
 func TestLogCensoredArgs(t *testing.T) {
-	setupFlags(&flags{})
+	setupFlags(flag.CommandLine, &flags{})
 	args := []string{
 		"-probe.token=secret",
 		"-service-token=secret",
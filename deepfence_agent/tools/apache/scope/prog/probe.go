@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"math/rand"
@@ -28,6 +29,7 @@ import (
 	"github.com/weaveworks/scope/common/hostname"
 	"github.com/weaveworks/scope/common/weave"
 	"github.com/weaveworks/scope/common/xfer"
+	criruntime "github.com/weaveworks/scope/cri/runtime"
 	"github.com/weaveworks/scope/probe"
 	"github.com/weaveworks/scope/probe/appclient"
 	"github.com/weaveworks/scope/probe/awsecs"
@@ -86,10 +88,25 @@ func checkNewScopeVersion(flags probeFlags) {
 	}()
 }
 
-func maybeExportProfileData(flags probeFlags) {
+func maybeExportProfileData(flags probeFlags, p *probe.Probe) {
 	if flags.httpListen != "" {
+		http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			if flags.readyIntervals > 0 {
+				if err := p.Ready(flags.readyIntervals); err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+			}
+			fmt.Fprintln(w, "ok")
+		})
 		go func() {
 			http.Handle("/metrics", promhttp.Handler())
+			log.Infof("Profiling data being exported to %s", flags.httpListen)
+			log.Infof("go tool pprof http://%s/debug/pprof/{profile,heap,block}", flags.httpListen)
+			log.Infof("Profiling endpoint %s terminated: %v", flags.httpListen, http.ListenAndServe(flags.httpListen, nil))
 		}()
 	}
 }
@@ -97,7 +114,7 @@ func maybeExportProfileData(flags probeFlags) {
 // Main runs the probe
 func probeMain(flags probeFlags, targets []appclient.Target) {
 	setLogLevel(flags.logLevel)
-	setLogFormatter(flags.logPrefix)
+	setLogFormatter(flags.logFormat, flags.logPrefix)
 
 	if flags.basicAuth {
 		log.Infof("Basic authentication enabled")
@@ -160,6 +177,29 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 	log.Infof("probe starting, version %s, ID %s", version, probeID)
 	//checkNewScopeVersion(flags)
 	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	if len(flags.deniedControls) > 0 {
+		log.Infof("Denying controls %v", flags.deniedControls)
+		handlerRegistry.Deny(flags.deniedControls...)
+	}
+	var spools *appclient.Spools
+	if flags.spoolDir != "" {
+		spools = appclient.NewSpools(flags.spoolDir, flags.spoolMaxBytes)
+	}
+	var proxyURL *url.URL
+	if flags.httpProxy != "" {
+		var err error
+		if proxyURL, err = url.Parse(flags.httpProxy); err != nil {
+			// Not logging the URL, holding the proxy's credentials
+			log.Fatal("Invalid value for -probe.http.proxy")
+		}
+	}
+	var caCerts *appclient.CACertFile
+	if flags.caCert != "" {
+		var err error
+		if caCerts, err = appclient.NewCACertFile(flags.caCert); err != nil {
+			log.Fatalf("Error reading CA certificates: %v", err)
+		}
+	}
 	clientFactory := func(hostname string, url url.URL) (appclient.AppClient, error) {
 		token := flags.token
 		if url.User != nil {
@@ -177,10 +217,19 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 			ProbeVersion: version,
 			ProbeID:      probeID,
 			Insecure:     flags.insecure,
+			Proxy:        proxyURL,
+			CACerts:      caCerts,
+		}
+		if spools != nil {
+			spool, err := spools.Open(hostname)
+			if err != nil {
+				log.Errorf("Error opening spool of %s, not spooling: %v", hostname, err)
+			} else {
+				probeConfig.Spool = spool
+			}
 		}
 		return appclient.NewAppClient(
-			probeConfig, hostname, url,
-			xfer.ControlHandlerFunc(handlerRegistry.HandleControlRequest),
+			probeConfig, hostname, url, handlerRegistry,
 		)
 	}
 
@@ -188,6 +237,11 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 		probe.ReportPublisher
 		controls.PipeClient
 	}
+	var publishTargets *appclient.PublishTargets
+	budget := probe.ReportBudget{
+		MaxSize:      flags.reportMaxSize,
+		MaxProcesses: flags.reportMaxProcesses,
+	}
 	if flags.printOnStdout {
 		if len(targets) > 0 {
 			log.Warnf("Dumping to stdout only: targets %v will be ignored", targets)
@@ -239,11 +293,29 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 			}
 		}
 		clients = multiClients
+		budget.Negotiated = multiClients.MaxReportSize
+		publishTargets = appclient.NewPublishTargets(hostID, multiClients, handlerRegistry)
+		defer publishTargets.Stop()
 	}
 
 	p := probe.New(flags.spyInterval, flags.publishInterval, clients, flags.ticksPerFullReport, flags.noControls)
+	p.SetReportBudget(budget)
+	p.SetPublishDeltas(flags.publishDeltas)
+	if flags.maxPublishInterval > 0 {
+		// Uptimes and clocks change every report, whether or not
+		// anything else does
+		p.SetAdaptivePublish(flags.maxPublishInterval, host.Uptime, host.ClockWallTime, host.ClockMonotonic, docker.ContainerUptime)
+		handlerRegistry.Watch(func(xfer.Request) { p.ResetPublishInterval() })
+	}
 	p.AddTagger(probe.NewTopologyTagger())
-	var processCache *process.CachingWalker
+	handlerRegistry.Register(probe.ReportControl, p.HandleReportControl)
+	diagnostics := host.DiagnosticsSources{Report: p.SpyReport}
+	var (
+		processCache     *process.CachingWalker
+		cgroups          func(pid int) (string, bool)
+		processReporter  *process.Reporter
+		endpointReporter *endpoint.Reporter
+	)
 	if flags.kubernetesEnabled {
 		// If KUBERNETES_SERVICE_HOST env is not there, get it from kube-proxy container in this host
 		// KUBERNETES_PORT_443_TCP_PROTO="tcp"
@@ -299,44 +371,124 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 		log.Error(err.Error())
 	}
 
+	cgroupMetrics, err := docker.NewCgroupMetrics(flags.cgroupMetrics, flags.cgroupRoot)
+	if err != nil {
+		log.Errorf("Cgroup metrics: %v", err)
+	} else if cgroupMetrics != nil {
+		p.AddReporter(cgroupMetrics)
+	}
+
 	if flags.kubernetesRole != kubernetesRoleCluster {
-		hostReporter, cloudProvider, cloudRegion := host.NewReporter(hostID, hostName, probeID, version, clients, handlerRegistry)
+		hostReporter, cloudProvider, cloudRegion := host.NewReporter(host.ReporterConfig{
+			HostID:                   hostID,
+			HostName:                 hostName,
+			ProbeID:                  probeID,
+			Version:                  version,
+			Pipes:                    clients,
+			HandlerRegistry:          handlerRegistry,
+			IncludeVirtualInterfaces: flags.hostVirtualInterfaces,
+			DiskPressureThreshold:    flags.hostDiskPressure,
+			CloudMetadata:            flags.cloudMetadata,
+			PackageInventory:         flags.hostPackageInventory,
+			SystemdUnits:             flags.hostSystemdUnits,
+			Sensors:                  flags.hostSensors,
+		})
 		defer hostReporter.Stop()
 		p.AddReporter(hostReporter)
 		p.AddTagger(host.NewTagger(hostID, cloudProvider, cloudRegion))
 
+		if flags.kubernetesEnabled && flags.kubeletSummary {
+			if kubelet, err := kubernetes.NewKubeletReporter(flags.kubernetesClientConfig, flags.kubeletURL, flags.kubeletInsecure); err == nil {
+				defer kubelet.Stop()
+				p.AddReporter(kubelet)
+				p.AddTagger(kubelet)
+			} else {
+				log.Errorf("Kubernetes: cannot collect stats from the kubelet: %v", err)
+			}
+		}
+
 		if flags.procEnabled {
 			processCache = process.NewCachingWalker(process.NewWalker(flags.procRoot, false))
 			p.AddTicker(processCache)
-			p.AddReporter(process.NewReporter(processCache, hostID, process.GetDeltaTotalJiffies, flags.noCommandLineArguments))
+			if read := process.ReadCgroup(flags.procRoot); read != nil {
+				cgroupCache := process.NewCgroupCache(read, processCache)
+				p.AddTicker(cgroupCache)
+				cgroups = cgroupCache.Read
+			}
+			var details *process.Details
+			if flags.procDetails {
+				details = process.NewDetails(flags.procRoot, flags.procHashMaxSize)
+			}
+			var inContainer func(int) bool
+			if cgroupMetrics != nil {
+				inContainer = docker.ProcessInContainer(cgroups)
+			}
+			processReporter = process.NewReporter(processCache, hostID, process.GetDeltaTotalJiffies, flags.noCommandLineArguments, details, inContainer, cgroups)
+			p.AddReporter(processReporter)
 		}
 
 		if flags.endpointEnabled {
-			dnsSnooper, err := endpoint.NewDNSSnooper()
-			if err != nil {
-				log.Errorf("Failed to start DNS snooper: nodes for external services will be less accurate: %s", err)
-			} else {
-				defer dnsSnooper.Stop()
+			var dnsSnooper *endpoint.DNSSnooper
+			if flags.dnsSnooper {
+				var err error
+				dnsSnooper, err = endpoint.NewDNSSnooper()
+				if err != nil {
+					log.Errorf("Failed to start DNS snooper: nodes for external services will be less accurate: %s", err)
+				} else {
+					defer dnsSnooper.Stop()
+				}
 			}
 
-			endpointReporter := endpoint.NewReporter(endpoint.ReporterConfig{
-				HostID:       hostID,
-				HostName:     hostName,
-				SpyProcs:     flags.spyProcs,
-				UseConntrack: flags.useConntrack,
-				WalkProc:     flags.procEnabled,
-				UseEbpfConn:  flags.useEbpfConn,
-				ProcRoot:     flags.procRoot,
-				BufferSize:   flags.conntrackBufferSize,
-				ProcessCache: processCache,
-				DNSSnooper:   dnsSnooper,
+			var tlsSnooper *endpoint.TLSSnooper
+			if flags.tlsSnooper {
+				var (
+					ports []uint16
+					err   error
+				)
+				for _, port := range strings.Split(flags.tlsSnooperPorts, ",") {
+					var p uint64
+					if p, err = strconv.ParseUint(strings.TrimSpace(port), 10, 16); err != nil {
+						break
+					}
+					ports = append(ports, uint16(p))
+				}
+				if err == nil {
+					tlsSnooper, err = endpoint.NewTLSSnooper(ports)
+				}
+				if err != nil {
+					log.Errorf("Failed to start TLS snooper: connections will have no TLS server names: %s", err)
+				} else {
+					defer tlsSnooper.Stop()
+				}
+			}
+
+			endpointReporter = endpoint.NewReporter(endpoint.ReporterConfig{
+				HostID:              hostID,
+				HostName:            hostName,
+				SpyProcs:            flags.spyProcs,
+				UseConntrack:        flags.useConntrack,
+				WalkProc:            flags.procEnabled,
+				UseEbpfConn:         flags.useEbpfConn,
+				ProcRoot:            flags.procRoot,
+				BufferSize:          flags.conntrackBufferSize,
+				ConntrackAccounting: flags.conntrackAccounting,
+				ProcessCache:        processCache,
+				DNSSnooper:          dnsSnooper,
+				TLSSnooper:          tlsSnooper,
+				MaxListeningPorts:   flags.listeningPortsMax,
+				UDPIdleExpiry:       flags.udpIdleExpiry,
+				MaxConnections:      flags.maxConnections,
+				TruncateConnections: flags.truncateConnections,
 			})
 			defer endpointReporter.Stop()
 			p.AddReporter(endpointReporter)
+			p.AddLimiter(endpointReporter)
+			p.AddTagger(endpoint.NewListeningPortTagger(flags.procRoot))
 		}
 
 	}
 
+	var dockerRegistry docker.Registry
 	if flags.dockerEnabled {
 		// Don't add the bridge in Kubernetes since container IPs are global and
 		// shouldn't be scoped
@@ -348,16 +500,34 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 		options := docker.RegistryOptions{
 			Interval:               flags.dockerInterval,
 			Pipes:                  clients,
-			CollectStats:           true,
+			CollectStats:           cgroupMetrics == nil,
+			StatsInterval:          flags.dockerStatsInterval,
+			StatsMaxContainers:     flags.dockerStatsMax,
 			HostID:                 hostID,
 			HandlerRegistry:        handlerRegistry,
+			DockerEndpoint:         flags.dockerEndpoint,
+			DockerTLS:              flags.dockerTLS,
+			LabelFilter:            flags.dockerLabels,
 			NoCommandLineArguments: flags.noCommandLineArguments,
 			NoEnvironmentVariables: flags.noEnvironmentVariables,
+			ScanAPI: docker.ScanAPIOptions{
+				URL:      flags.dockerScanAPI,
+				Token:    flags.token,
+				Insecure: flags.insecure,
+			},
+		}
+		diagnostics.DockerInfo = func(context.Context) (interface{}, error) {
+			client, err := docker.NewDockerClientStub(flags.dockerEndpoint, flags.dockerTLS)
+			if err != nil {
+				return nil, err
+			}
+			return client.Info()
 		}
 		if registry, err := docker.NewRegistry(options); err == nil {
 			defer registry.Stop()
+			dockerRegistry = registry
 			if flags.procEnabled {
-				p.AddTagger(docker.NewTagger(registry, processCache))
+				p.AddTagger(docker.NewTagger(registry, processCache, cgroups))
 			}
 			p.AddReporter(docker.NewReporter(registry, hostID, probeID, p))
 		} else {
@@ -371,13 +541,47 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 			log.Errorf("CRI: failed to start registry: %v", err)
 		} else {
 			p.AddReporter(cri.NewReporter(runtimeClient, imageClient))
+			diagnostics.CRIInfo = func(ctx context.Context) (interface{}, error) {
+				version, err := runtimeClient.Version(ctx, &criruntime.VersionRequest{})
+				if err != nil {
+					return nil, err
+				}
+				status, err := runtimeClient.Status(ctx, &criruntime.StatusRequest{Verbose: true})
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"version": version, "status": status}, nil
+			}
+		}
+	}
+
+	if flags.kubernetesRole != kubernetesRoleCluster {
+		d := host.NewDiagnostics(hostID, diagnostics, clients, handlerRegistry)
+		defer d.Stop()
+		p.AddReporter(d)
+		if publishTargets != nil {
+			p.AddReporter(publishTargets)
 		}
 	}
 
-	if flags.kubernetesEnabled && flags.kubernetesRole != kubernetesRoleHost {
+	if flags.kubernetesEnabled && flags.kubernetesRole != kubernetesRoleHost && flags.kubernetesContexts != "" {
+		// Each cluster gets its own client, which is started and reconnects
+		// on its own, so one unreachable cluster doesn't hold up the others.
+		for _, context := range strings.Split(flags.kubernetesContexts, ",") {
+			context = strings.TrimSpace(context)
+			if context == "" {
+				continue
+			}
+			config := flags.kubernetesClientConfig
+			config.Context = context
+			reporter := kubernetes.StartClusterReporter(config, probeID, hostID, p, flags.kubernetesPending)
+			defer reporter.Stop()
+			p.AddReporter(reporter)
+		}
+	} else if flags.kubernetesEnabled && flags.kubernetesRole != kubernetesRoleHost {
 		if client, err := kubernetes.NewClient(flags.kubernetesClientConfig); err == nil {
 			defer client.Stop()
-			reporter := kubernetes.NewReporter(client, clients, probeID, hostID, p, handlerRegistry, flags.kubernetesNodeName)
+			reporter := kubernetes.NewReporter(client, clients, probeID, hostID, p, handlerRegistry, flags.kubernetesNodeName, flags.kubernetesControls, flags.kubernetesPending)
 			defer reporter.Stop()
 			p.AddReporter(reporter)
 			go client.InitCNIPlugin()
@@ -419,6 +623,7 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 			pluginAPIVersion,
 			map[string]string{
 				"probe_id":    probeID,
+				"host_id":     hostID,
 				"api_version": pluginAPIVersion,
 			},
 			handlerRegistry,
@@ -429,10 +634,36 @@ func probeMain(flags probeFlags, targets []appclient.Target) {
 		} else {
 			defer pluginRegistry.Close()
 			p.AddReporter(pluginRegistry)
+			http.Handle("/plugins", pluginRegistry)
+		}
+	}
+
+	if flags.maxCPU > 0 {
+		// The detail most expensive to collect on busy hosts, shed first
+		// to last
+		var sheddable []probe.Sheddable
+		if endpointReporter != nil {
+			sheddable = append(sheddable, endpointReporter)
+		}
+		if processReporter != nil {
+			sheddable = append(sheddable, processReporter)
+		}
+		p.SetCPUBudget(flags.maxCPU, sheddable...)
+		p.AddReporter(p.ShedReporter(hostID))
+	}
+	if err := p.DisableReporters(flags.disabledReporters...); err != nil {
+		log.Fatalf("Invalid value for -probe.reporters.disable: %v", err)
+	}
+	if flags.configFile != "" {
+		reloader, err := newConfigReloader(flags.configFile, os.Args[1:], hostID, p, dockerRegistry, handlerRegistry)
+		if err != nil {
+			log.Fatalf("Invalid value for -probe.config: %v", err)
 		}
+		defer reloader.Stop()
+		p.AddReporter(reloader)
 	}
 
-	maybeExportProfileData(flags)
+	maybeExportProfileData(flags, p)
 
 	p.Start()
 	signals.SignalHandlerLoop(
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/weaveworks/scope/common/logger"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+)
+
+// reloadConfigControl is the control of the host reading the config file
+// of the probe again.
+const reloadConfigControl = "host_reload_config"
+
+// runtimeFlags are the flags whose changes are applied on reload; changes
+// to the others take a restart.
+var runtimeFlags = map[string]struct{}{
+	"probe.publish.interval":     {},
+	"probe.docker.include-label": {},
+	"probe.docker.exclude-label": {},
+	"probe.reporters.disable":    {},
+	"probe.log.level":            {},
+	"debug":                      {},
+}
+
+// readConfigFile reads a YAML config file of flag names and their values,
+// lists of them for flags which may be given more than once.
+func readConfigFile(path string) (map[string][]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	// Numbers kept as written, not as floats
+	useNumber := func(d *json.Decoder) *json.Decoder {
+		d.UseNumber()
+		return d
+	}
+	if err := yaml.Unmarshal(buf, &config, useNumber); err != nil {
+		return nil, err
+	}
+	values := map[string][]string{}
+	for name, v := range config {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				if _, ok := e.(map[string]interface{}); ok {
+					return nil, fmt.Errorf("invalid value for %s", name)
+				}
+				values[name] = append(values[name], fmt.Sprint(e))
+			}
+		case map[string]interface{}, nil:
+			return nil, fmt.Errorf("invalid value for %s", name)
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values, nil
+}
+
+// applyConfigFile sets the flags of fs to the values in the config file at
+// path, but for those given on the command line, which take precedence.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	config, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil || name == "probe.config" {
+			return fmt.Errorf("unknown flag %s", name)
+		}
+		if given[name] {
+			log.Infof("Flag %s is given on the command line, ignoring it in %s", name, path)
+			continue
+		}
+		for _, v := range config[name] {
+			// Not logging the value, which may be a secret
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("invalid value for %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadFlags parses the command line args into flags from scratch, then
+// applies the config file at path.  It returns the flags of the probe, and
+// the values of all flags by name.
+func loadFlags(args []string, path string) (probeFlags, map[string]string, error) {
+	var f flags
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	setupFlags(fs, &f)
+	f.app.BillingEmitterConfig.RegisterFlags(fs)
+	f.app.BillingClientConfig.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return probeFlags{}, nil, err
+	}
+	if err := applyConfigFile(fs, path); err != nil {
+		return probeFlags{}, nil, err
+	}
+	if f.debug {
+		f.probe.logLevel = "debug"
+	}
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return f.probe, values, nil
+}
+
+// configReloader reads the config file of the probe again on SIGHUP or
+// reloadConfigControl, applying the changes to runtimeFlags, and logging
+// those to other flags, which take a restart.  A config failing to load
+// or validate changes nothing.
+type configReloader struct {
+	path            string
+	args            []string
+	hostID          string
+	probe           *probe.Probe
+	dockerRegistry  docker.Registry // nil without docker
+	handlerRegistry *controls.HandlerRegistry
+
+	mtx     sync.Mutex
+	running map[string]string // values of the flags applied, by name
+	hup     chan os.Signal
+}
+
+// newConfigReloader makes a new configReloader of the config file at path,
+// given the command line args, registering its control.
+func newConfigReloader(path string, args []string, hostID string, p *probe.Probe, dockerRegistry docker.Registry, handlerRegistry *controls.HandlerRegistry) (*configReloader, error) {
+	_, running, err := loadFlags(args, path)
+	if err != nil {
+		return nil, err
+	}
+	r := &configReloader{
+		path:            path,
+		args:            args,
+		hostID:          hostID,
+		probe:           p,
+		dockerRegistry:  dockerRegistry,
+		handlerRegistry: handlerRegistry,
+		running:         running,
+		hup:             make(chan os.Signal, 1),
+	}
+	handlerRegistry.Register(reloadConfigControl, r.handleControl)
+	signal.Notify(r.hup, syscall.SIGHUP)
+	go r.loop()
+	return r, nil
+}
+
+// Stop deregisters the control, and stops reloading on SIGHUP.
+func (r *configReloader) Stop() {
+	r.handlerRegistry.Rm(reloadConfigControl)
+	signal.Stop(r.hup)
+	close(r.hup)
+}
+
+func (r *configReloader) loop() {
+	for range r.hup {
+		if err := r.reload(); err != nil {
+			log.Errorf("Error reloading %s, keeping the config running: %v", r.path, err)
+		}
+	}
+}
+
+// Name of this reporter.
+func (*configReloader) Name() string { return "Config" }
+
+// Report adds the control to the host node.
+func (r *configReloader) Report() (report.Report, error) {
+	rpt := report.MakeReport()
+	rpt.Host.Controls.AddControl(report.Control{
+		ID:    reloadConfigControl,
+		Human: "Reload probe config",
+		Icon:  "fa fa-refresh",
+		Rank:  11,
+	})
+	rpt.Host.AddNode(report.MakeNode(report.MakeHostNodeID(r.hostID)).WithLatestActiveControls(reloadConfigControl))
+	return rpt, nil
+}
+
+func (r *configReloader) handleControl(req xfer.Request) xfer.Response {
+	if err := r.reload(); err != nil {
+		log.Errorf("Error reloading %s, keeping the config running: %v", r.path, err)
+		return xfer.ResponseError(err)
+	}
+	return xfer.Response{}
+}
+
+// reload reads the config file again, validating the changes to
+// runtimeFlags before applying any.
+func (r *configReloader) reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	next, values, err := loadFlags(r.args, r.path)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for name, v := range values {
+		if r.running[name] != v {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	isChanged := func(names ...string) bool {
+		for _, name := range names {
+			if r.running[name] != values[name] {
+				return true
+			}
+		}
+		return false
+	}
+
+	levels, err := logger.ParseLevels(next.logLevel)
+	if err != nil {
+		return err
+	}
+	if next.publishInterval <= 0 {
+		return fmt.Errorf("invalid publish interval %v", next.publishInterval)
+	}
+	// Disabling reporters goes first, as it's the one change which may
+	// still fail, on reporters the probe doesn't have
+	if isChanged("probe.reporters.disable") {
+		if err := r.probe.DisableReporters(next.disabledReporters...); err != nil {
+			return err
+		}
+	}
+	if isChanged("probe.publish.interval") {
+		r.probe.SetPublishInterval(next.publishInterval)
+	}
+	if isChanged("probe.docker.include-label", "probe.docker.exclude-label") && r.dockerRegistry != nil {
+		r.dockerRegistry.SetLabelFilter(next.dockerLabels)
+	}
+	if isChanged("probe.log.level", "debug") {
+		levels.Set()
+	}
+
+	for _, name := range changed {
+		if _, ok := runtimeFlags[name]; ok {
+			log.Infof("Reloaded flag %s from %s", name, r.path)
+			r.running[name] = values[name]
+		} else {
+			log.Warnf("Flag %s changed in %s, which takes a restart to apply", name, r.path)
+		}
+	}
+	return nil
+}
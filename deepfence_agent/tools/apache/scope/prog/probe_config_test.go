@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/probe"
+	"github.com/weaveworks/scope/probe/controls"
+	"github.com/weaveworks/scope/report"
+)
+
+func TestConfigReload(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.InfoLevel)
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "probe.yaml")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("probe.publish.interval: 5s\n")
+
+	p := probe.New(time.Second, 3*time.Second, nil, 1, false)
+	p.AddReporter(probe.ReporterFunc("Mock", func() (report.Report, error) {
+		return report.MakeReport(), nil
+	}))
+	handlerRegistry := controls.NewDefaultHandlerRegistry()
+	args := []string{"-mode=probe", "-probe.config=" + path, "-probe.spy.interval=2s"}
+	r, err := newConfigReloader(path, args, "host1", p, nil, handlerRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	assert.Equal(t, "5s", r.running["probe.publish.interval"])
+
+	reload := func() xfer.Response {
+		return handlerRegistry.HandleControlRequest(xfer.Request{
+			AppID:   "app",
+			NodeID:  report.MakeHostNodeID("host1"),
+			Control: reloadConfigControl,
+		})
+	}
+
+	// Settings applied at runtime, and those which take a restart or are
+	// given on the command line, are told apart
+	hook := test.NewGlobal()
+	writeConfig(`
+probe.publish.interval: 1s
+probe.log.level: debug
+probe.reporters.disable: [Mock]
+probe.conntrack.buffersize: 1048576
+probe.spy.interval: 1s
+`)
+	res := reload()
+	assert.Empty(t, res.Error)
+	assert.Equal(t, log.DebugLevel, log.GetLevel())
+	assert.Equal(t, "1s", r.running["probe.publish.interval"])
+	assert.Equal(t, "Mock", r.running["probe.reporters.disable"])
+	assert.Equal(t, "4194304", r.running["probe.conntrack.buffersize"])
+	assert.Equal(t, "2s", r.running["probe.spy.interval"])
+	var restarts []string
+	for _, e := range hook.AllEntries() {
+		if e.Level == log.WarnLevel {
+			restarts = append(restarts, e.Message)
+		}
+	}
+	assert.Equal(t, []string{"Flag probe.conntrack.buffersize changed in " + path + ", which takes a restart to apply"}, restarts)
+
+	// Configs failing to load or validate leave the running one untouched
+	for _, config := range []string{
+		"probe.publish.interval: 2s\nprobe.log.level: loud\n",
+		"probe.publish.interval: 2s\nprobe.reporters.disable: [Missing]\n",
+		"probe.publish.interval: 2s\nprobe.no-such-flag: true\n",
+		"probe.publish.interval: soon\n",
+		"probe.publish.interval: [2s\n",
+	} {
+		writeConfig(config)
+		res := reload()
+		assert.NotEmpty(t, res.Error, config)
+		assert.Equal(t, "1s", r.running["probe.publish.interval"], config)
+		assert.Equal(t, log.DebugLevel, log.GetLevel(), config)
+	}
+}
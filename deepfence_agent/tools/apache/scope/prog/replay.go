@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/weaveworks/scope/app"
+)
+
+// replayMain replays stored reports against an app, printing the stats of
+// the publishes.  INT/TERM stop it early.
+func replayMain(cfg app.ReplayConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	log.Infof("Replaying reports in %s against %s", cfg.Dir, cfg.Target)
+	stats, err := app.Replay(ctx, cfg)
+	fmt.Println(stats)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("Error replaying reports: %v", err)
+	}
+}
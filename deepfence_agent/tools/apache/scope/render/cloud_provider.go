@@ -1,6 +1,8 @@
 package render
 
 import (
+	"context"
+
 	"github.com/weaveworks/scope/report"
 )
 
@@ -13,3 +15,73 @@ var CloudRegionRenderer = renderParents(
 	report.Host, []string{report.CloudRegion}, "",
 	HostRenderer,
 )
+
+// CloudHierarchyRenderer renders the cloud providers, their regions, the
+// kubernetes clusters in those, and the hosts, each with only its immediate
+// parent in the hierarchy provider -> region -> cluster -> host as parent.
+// Nodes whose parents are missing from the report hang off the nearest
+// ancestor which isn't, or are roots, as are all hosts of reports from
+// probes which don't report the cloud topologies.
+var CloudHierarchyRenderer Renderer = cloudHierarchy{}
+
+// cloudParentTopologies are the topologies of the possible parents of the
+// nodes of each topology of the hierarchy, nearest first.
+var cloudParentTopologies = map[string][]string{
+	report.CloudProvider:     nil,
+	report.CloudRegion:       {report.CloudProvider},
+	report.KubernetesCluster: {report.CloudRegion, report.CloudProvider},
+	report.Host:              {report.KubernetesCluster, report.CloudRegion, report.CloudProvider},
+}
+
+type cloudHierarchy struct{}
+
+// Render implements Renderer
+func (cloudHierarchy) Render(ctx context.Context, rpt report.Report) Nodes {
+	nodes := report.Nodes{}
+	for topology := range cloudParentTopologies {
+		for id, n := range TopologySelector(topology).Render(ctx, rpt).Nodes {
+			nodes[id] = n
+		}
+	}
+
+	// Clusters reported without their regions are in those of their hosts
+	hostRegions := map[string]report.StringSet{}
+	for _, n := range nodes {
+		if n.Topology != report.Host {
+			continue
+		}
+		regions, _ := n.Parents.Lookup(report.CloudRegion)
+		clusters, _ := n.Parents.Lookup(report.KubernetesCluster)
+		for _, cluster := range clusters {
+			hostRegions[cluster], _ = hostRegions[cluster].Merge(regions)
+		}
+	}
+
+	result := make(report.Nodes, len(nodes))
+	for id, n := range nodes {
+		parents := n.Parents
+		if _, ok := parents.Lookup(report.CloudRegion); !ok && n.Topology == report.KubernetesCluster && len(hostRegions[id]) > 0 {
+			parents = parents.Add(report.CloudRegion, hostRegions[id])
+		}
+		result[id] = n.PruneParents().WithParents(immediateCloudParent(nodes, n.Topology, parents))
+	}
+	return Nodes{Nodes: result}
+}
+
+// immediateCloudParent returns the parents of the nearest topology of a
+// node's possible parents which are among nodes.
+func immediateCloudParent(nodes report.Nodes, topology string, parents report.Sets) report.Sets {
+	for _, parentTopology := range cloudParentTopologies[topology] {
+		ids, _ := parents.Lookup(parentTopology)
+		present := []string{}
+		for _, id := range ids {
+			if _, ok := nodes[id]; ok {
+				present = append(present, id)
+			}
+		}
+		if len(present) > 0 {
+			return report.MakeSets().Add(parentTopology, report.MakeStringSet(present...))
+		}
+	}
+	return report.MakeSets()
+}
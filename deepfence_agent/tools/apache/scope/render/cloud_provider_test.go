@@ -0,0 +1,108 @@
+package render_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+var (
+	awsID       = report.MakeCloudProviderNodeID("aws")
+	usEastID    = report.MakeProviderRegionNodeID("aws", "us-east-1")
+	usWestID    = report.MakeProviderRegionNodeID("aws", "us-west-2")
+	prodID      = report.MakeKubernetesClusterNodeID("prod")
+	legacyID    = report.MakeKubernetesClusterNodeID("legacy")
+	clusterHost = report.MakeHostNodeID("node1")
+	legacyHost  = report.MakeHostNodeID("node2")
+	regionHost  = report.MakeHostNodeID("vm")
+	oldHost     = report.MakeHostNodeID("old")
+)
+
+// cloudReport is the merged report of the probes on the hosts, and those
+// watching the clusters.
+func cloudReport() report.Report {
+	rpt := report.MakeReport()
+	rpt.CloudProvider.AddNode(report.MakeNode(awsID).WithTopology(report.CloudProvider))
+	for _, region := range []string{usEastID, usWestID} {
+		rpt.CloudRegion.AddNode(report.MakeNode(region).WithTopology(report.CloudRegion).
+			WithParent(report.CloudProvider, awsID))
+	}
+	rpt.KubernetesCluster.AddNode(report.MakeNode(prodID).WithTopology(report.KubernetesCluster).
+		WithParent(report.CloudProvider, awsID).
+		WithParent(report.CloudRegion, usEastID))
+	// Reported by a kubernetes probe from before clusters had regions
+	rpt.KubernetesCluster.AddNode(report.MakeNode(legacyID).WithTopology(report.KubernetesCluster).
+		WithParent(report.CloudProvider, awsID))
+
+	host := func(id string) report.Node {
+		return report.MakeNode(id).WithTopology(report.Host).
+			WithParent(report.Host, id).
+			WithParent(report.CloudProvider, awsID)
+	}
+	rpt.Host.AddNode(host(clusterHost).
+		WithParent(report.CloudRegion, usEastID).
+		WithParent(report.KubernetesCluster, prodID))
+	rpt.Host.AddNode(host(legacyHost).
+		WithParent(report.CloudRegion, usWestID).
+		WithParent(report.KubernetesCluster, legacyID))
+	rpt.Host.AddNode(host(regionHost).
+		WithParent(report.CloudRegion, usWestID))
+	// Reported by a probe from before the cloud topologies
+	rpt.Host.AddNode(report.MakeNode(oldHost).WithTopology(report.Host))
+	return rpt
+}
+
+func TestCloudHierarchyRenderer(t *testing.T) {
+	have := render.CloudHierarchyRenderer.Render(context.Background(), cloudReport()).Nodes
+	for id, want := range map[string]report.Sets{
+		awsID:       report.MakeSets(),
+		usEastID:    report.MakeSets().Add(report.CloudProvider, report.MakeStringSet(awsID)),
+		usWestID:    report.MakeSets().Add(report.CloudProvider, report.MakeStringSet(awsID)),
+		prodID:      report.MakeSets().Add(report.CloudRegion, report.MakeStringSet(usEastID)),
+		legacyID:    report.MakeSets().Add(report.CloudRegion, report.MakeStringSet(usWestID)),
+		clusterHost: report.MakeSets().Add(report.KubernetesCluster, report.MakeStringSet(prodID)),
+		legacyHost:  report.MakeSets().Add(report.KubernetesCluster, report.MakeStringSet(legacyID)),
+		regionHost:  report.MakeSets().Add(report.CloudRegion, report.MakeStringSet(usWestID)),
+		oldHost:     report.MakeSets(),
+	} {
+		node, ok := have[id]
+		if !ok {
+			t.Errorf("Expected node %q", id)
+			continue
+		}
+		if !reflect.DeepEqual(want, node.Parents) {
+			t.Errorf("Expected %q to have parents %v, got %v", id, want, node.Parents)
+		}
+	}
+	if len(have) != 9 {
+		t.Errorf("Expected 9 nodes, got %d", len(have))
+	}
+}
+
+func TestCloudHierarchyRendererMissingParents(t *testing.T) {
+	// A host whose cluster and region weren't reported hangs off its
+	// provider
+	rpt := cloudReport()
+	rpt.KubernetesCluster = report.MakeTopology()
+	rpt.CloudRegion = report.MakeTopology()
+	have := render.CloudHierarchyRenderer.Render(context.Background(), rpt).Nodes
+	want := report.MakeSets().Add(report.CloudProvider, report.MakeStringSet(awsID))
+	if !reflect.DeepEqual(want, have[clusterHost].Parents) {
+		t.Errorf("Expected %v, got %v", want, have[clusterHost].Parents)
+	}
+
+	// And with no cloud topologies at all, the hosts are all roots
+	rpt.CloudProvider = report.MakeTopology()
+	have = render.CloudHierarchyRenderer.Render(context.Background(), rpt).Nodes
+	for id, node := range have {
+		if len(node.Parents.Keys()) != 0 {
+			t.Errorf("Expected %q to have no parents, got %v", id, node.Parents)
+		}
+	}
+	if len(have) != 4 {
+		t.Errorf("Expected the 4 hosts, got %v", have)
+	}
+}
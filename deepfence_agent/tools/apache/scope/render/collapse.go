@@ -0,0 +1,145 @@
+package render
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// OthersID is the ID of pseudo nodes of the nodes collapsed into "N others".
+const OthersID = "others"
+
+// OthersIDPrefix is the prefix of the pseudo nodes of the nodes collapsed
+// into "N others", which is followed by the topology of the nodes and the
+// ID of their parent.
+var OthersIDPrefix = MakePseudoNodeID(OthersID, "")
+
+// MakeOthersNodeID makes the ID of the pseudo node of the nodes of a
+// topology collapsed under a parent.
+func MakeOthersNodeID(topology, parentID string) string {
+	return MakePseudoNodeID(OthersID, topology, parentID)
+}
+
+// ParseOthersNodeID returns the topology of the nodes collapsed into the
+// pseudo node of an ID made by MakeOthersNodeID, and the ID of their parent.
+func ParseOthersNodeID(nodeID string) (topology string, parentID string, ok bool) {
+	if !strings.HasPrefix(nodeID, OthersIDPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(nodeID[len(OthersIDPrefix):], ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// RankByConnections ranks nodes by their edges, in and out, in
+// CollapseLongTail.
+const RankByConnections = ""
+
+// CollapseLongTail is a transformer keeping the top K nodes of Topology of
+// each parent of ParentTopology, ranked by the latest value of Metric, and
+// collapsing the rest into a pseudo node with them as children, their
+// metrics summed and a count of them.  The nodes of the parents in Expand
+// are all kept, as are nodes of other topologies or without a parent.
+type CollapseLongTail struct {
+	Topology       string
+	ParentTopology string
+	K              int
+	Metric         string
+	Expand         report.StringSet
+}
+
+// Transform implements Transformer
+func (c CollapseLongTail) Transform(input Nodes) Nodes {
+	if c.K <= 0 {
+		return input
+	}
+	children := map[string][]report.Node{}
+	for _, n := range input.Nodes {
+		if parentID, ok := c.parent(n); ok {
+			children[parentID] = append(children[parentID], n)
+		}
+	}
+	var scores map[string]float64
+	if c.Metric == RankByConnections {
+		scores = connectionCounts(input.Nodes)
+	}
+	collapsed := map[string]string{}
+	for parentID, nodes := range children {
+		if len(nodes) <= c.K {
+			continue
+		}
+		score := func(n report.Node) float64 {
+			if scores != nil {
+				return scores[n.ID]
+			}
+			if sample, ok := n.Metrics[c.Metric].LastSample(); ok {
+				return sample.Value
+			}
+			return 0
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if si, sj := score(nodes[i]), score(nodes[j]); si != sj {
+				return si > sj
+			}
+			return nodes[i].ID < nodes[j].ID
+		})
+		for _, n := range nodes[c.K:] {
+			collapsed[n.ID] = parentID
+		}
+	}
+	if len(collapsed) == 0 {
+		return input
+	}
+
+	ret := newJoinResults(nil)
+	metrics := map[string]report.Metrics{}
+	for _, n := range input.Nodes {
+		parentID, ok := collapsed[n.ID]
+		if !ok {
+			ret.passThrough(n)
+			continue
+		}
+		othersID := MakeOthersNodeID(c.Topology, parentID)
+		ret.addChild(n, othersID, Pseudo)
+		metrics[othersID] = metrics[othersID].Sum(n.Metrics)
+	}
+	output := ret.result(input)
+	for othersID, m := range metrics {
+		others := output.Nodes[othersID]
+		_, parentID, _ := ParseOthersNodeID(othersID)
+		others.Metrics = m
+		output.Nodes[othersID] = others.WithParent(c.ParentTopology, parentID)
+	}
+	output.Filtered = input.Filtered
+	return output
+}
+
+// parent returns the ID of the parent of n whose nodes may be collapsed.
+func (c CollapseLongTail) parent(n report.Node) (string, bool) {
+	if n.Topology != c.Topology {
+		return "", false
+	}
+	parents, ok := n.Parents.Lookup(c.ParentTopology)
+	if !ok || len(parents) == 0 || c.Expand.Contains(parents[0]) {
+		return "", false
+	}
+	return parents[0], true
+}
+
+// connectionCounts counts the edges of each of nodes, in and out.
+func connectionCounts(nodes report.Nodes) map[string]float64 {
+	counts := make(map[string]float64, len(nodes))
+	for id, n := range nodes {
+		for _, dst := range n.Adjacency {
+			if dst == id {
+				continue
+			}
+			counts[id]++
+			counts[dst]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,110 @@
+package render_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+const cpuMetric = "process_cpu_usage_percent"
+
+// longTailNodes makes processes with the given CPU usages on host1, one of
+// which connects to each of the others, and one on host2.
+func longTailNodes(now time.Time, cpu ...float64) render.Nodes {
+	nodes := report.Nodes{}
+	process := func(host string, pid int, cpu float64) report.Node {
+		n := report.MakeNode(report.MakeProcessNodeID(host, fmt.Sprint(pid))).
+			WithTopology(report.Process).
+			WithParent(report.Host, report.MakeHostNodeID(host)).
+			WithMetrics(report.Metrics{cpuMetric: report.MakeSingletonMetric(now, cpu).WithMax(100)})
+		nodes[n.ID] = n
+		return n
+	}
+	client := process("host1", 1, 0)
+	for i, usage := range cpu {
+		n := process("host1", i+2, usage)
+		client = client.WithAdjacent(n.ID)
+	}
+	nodes[client.ID] = client
+	process("host2", 1, 0)
+	return render.Nodes{Nodes: nodes, Filtered: 3}
+}
+
+func TestCollapseLongTail(t *testing.T) {
+	var (
+		now      = time.Now()
+		nodes    = longTailNodes(now, 50, 10, 20, 5)
+		host1    = report.MakeHostNodeID("host1")
+		othersID = render.MakeOthersNodeID(report.Process, host1)
+		pid      = func(pid int) string { return report.MakeProcessNodeID("host1", fmt.Sprint(pid)) }
+		collapse = render.CollapseLongTail{
+			Topology:       report.Process,
+			ParentTopology: report.Host,
+			K:              2,
+			Metric:         cpuMetric,
+		}
+	)
+
+	output := collapse.Transform(nodes)
+	// The top two of host1 by CPU are kept, and host2 has only one
+	for _, id := range []string{pid(2), pid(4), report.MakeProcessNodeID("host2", "1"), othersID} {
+		if _, ok := output.Nodes[id]; !ok {
+			t.Errorf("Expected %s, have %v", id, output.Nodes)
+		}
+	}
+	if len(output.Nodes) != 4 {
+		t.Errorf("Expected 4 nodes, have %d", len(output.Nodes))
+	}
+	if output.Filtered != 3 {
+		t.Errorf("Expected the filtered kept, have %d", output.Filtered)
+	}
+
+	others := output.Nodes[othersID]
+	if count, _ := others.LookupCounter(report.Process); count != 3 {
+		t.Errorf("Expected 3 others, have %d", count)
+	}
+	if have := others.Children.Size(); have != 3 {
+		t.Errorf("Expected 3 children, have %d", have)
+	}
+	want := report.MakeSingletonMetric(now, 10+5+0).WithMax(100)
+	if have := others.Metrics[cpuMetric]; !reflect.DeepEqual(want, have) {
+		t.Errorf("diff: %s", test.Diff(want, have))
+	}
+	if parents, _ := others.Parents.Lookup(report.Host); !parents.Contains(host1) {
+		t.Errorf("Expected others of %s, have %v", host1, parents)
+	}
+	// The edges of the collapsed nodes are those of the others
+	if want := report.MakeIDList(pid(2), pid(4), othersID); !reflect.DeepEqual(want, others.Adjacency) {
+		t.Errorf("diff: %s", test.Diff(want, others.Adjacency))
+	}
+
+	// Ranked by connections, the client is kept
+	collapse.Metric = render.RankByConnections
+	output = collapse.Transform(nodes)
+	if _, ok := output.Nodes[pid(1)]; !ok {
+		t.Errorf("Expected the client kept, have %v", output.Nodes)
+	}
+
+	// Expanded, all are kept
+	collapse.Expand = report.MakeStringSet(host1)
+	output = collapse.Transform(nodes)
+	if len(output.Nodes) != len(nodes.Nodes) {
+		t.Errorf("Expected all %d nodes, have %d", len(nodes.Nodes), len(output.Nodes))
+	}
+}
+
+func TestParseOthersNodeID(t *testing.T) {
+	host := report.MakeHostNodeID("host:1")
+	topology, parentID, ok := render.ParseOthersNodeID(render.MakeOthersNodeID(report.Container, host))
+	if !ok || topology != report.Container || parentID != host {
+		t.Errorf("Expected %s of %s, have %s of %s", report.Container, host, topology, parentID)
+	}
+	if _, _, ok := render.ParseOthersNodeID(render.MakePseudoNodeID("other")); ok {
+		t.Error("Expected a pseudo node not of others unparsed")
+	}
+}
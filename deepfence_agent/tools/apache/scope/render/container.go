@@ -148,7 +148,8 @@ func (r containerWithImageNameRenderer) Render(ctx context.Context, rpt report.R
 		imageNodeID := report.MakeContainerImageNodeID(fmt.Sprintf("%s:%s", imageName, imageTag))
 
 		c.Latest = c.Latest.Propagate(image.Latest, report.DockerImageName, report.DockerImageTag,
-			report.DockerImageSize, report.DockerImageVirtualSize, report.DockerImageCreatedAt, report.DockerImageLabelPrefix+"deepfence.role")
+			report.DockerImageSize, report.DockerImageVirtualSize, report.DockerImageCreatedAt, report.DockerImageLabelPrefix+"deepfence.role",
+			report.ScanStatusPrefix+report.VulnerabilityScan)
 
 		c.Parents = c.Parents.
 			Delete(report.ContainerImage).
@@ -46,3 +46,46 @@ func CensorNodeSummaries(summaries NodeSummaries, cfg report.CensorConfig) NodeS
 	}
 	return censored
 }
+
+// SelectFields restricts the metadata, metrics and tables of node summaries
+// to those whose IDs are among fields, for clients which only show some of
+// them.  All are kept if fields is empty.
+func SelectFields(summaries NodeSummaries, fields []string) NodeSummaries {
+	if len(fields) == 0 {
+		return summaries
+	}
+	selected := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		selected[field] = struct{}{}
+	}
+	result := make(NodeSummaries, len(summaries))
+	for key, s := range summaries {
+		result[key] = selectNodeSummaryFields(s, selected)
+	}
+	return result
+}
+
+func selectNodeSummaryFields(s NodeSummary, selected map[string]struct{}) NodeSummary {
+	var (
+		metadata []report.MetadataRow
+		metrics  []report.MetricRow
+		tables   []report.Table
+	)
+	for _, row := range s.Metadata {
+		if _, ok := selected[row.ID]; ok {
+			metadata = append(metadata, row)
+		}
+	}
+	for _, row := range s.Metrics {
+		if _, ok := selected[row.ID]; ok {
+			metrics = append(metrics, row)
+		}
+	}
+	for _, table := range s.Tables {
+		if _, ok := selected[table.ID]; ok {
+			tables = append(tables, table)
+		}
+	}
+	s.Metadata, s.Metrics, s.Tables = metadata, metrics, tables
+	return s
+}
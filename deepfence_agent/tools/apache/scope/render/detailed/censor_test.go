@@ -226,3 +226,43 @@ func TestCensorNodeSummaries(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectFields(t *testing.T) {
+	summaries := detailed.NodeSummaries{
+		"a": detailed.NodeSummary{
+			Metadata: []report.MetadataRow{
+				{ID: "pid", Label: "PID", Value: "1"},
+				{ID: "cmdline", Label: "Command", Value: "prog"},
+			},
+			Metrics: []report.MetricRow{
+				{ID: "process_cpu_usage_percent", Value: 0.5},
+				{ID: "process_memory_usage_bytes", Value: 1024},
+			},
+			Tables: []report.Table{
+				{ID: "docker_env_", Rows: []report.Row{{ID: "env_var"}}},
+			},
+		},
+	}
+
+	if have := detailed.SelectFields(summaries, nil); !reflect.DeepEqual(summaries, have) {
+		t.Errorf("no fields - %s", test.Diff(summaries, have))
+	}
+
+	want := detailed.NodeSummaries{
+		"a": detailed.NodeSummary{
+			Metadata: []report.MetadataRow{
+				{ID: "cmdline", Label: "Command", Value: "prog"},
+			},
+			Metrics: []report.MetricRow{
+				{ID: "process_cpu_usage_percent", Value: 0.5},
+			},
+		},
+	}
+	have := detailed.SelectFields(summaries, []string{"cmdline", "process_cpu_usage_percent", "other"})
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("some fields - %s", test.Diff(want, have))
+	}
+	if len(summaries["a"].Metadata) != 2 {
+		t.Errorf("Expected the summaries selected from not to be modified, got %v", summaries)
+	}
+}
@@ -67,10 +67,11 @@ type connection struct {
 type connectionCounters struct {
 	counted map[string]struct{}
 	counts  map[connection]int
+	sampled map[connection]struct{} // counting connections aggregated by the probe
 }
 
 func newConnectionCounters() *connectionCounters {
-	return &connectionCounters{counted: map[string]struct{}{}, counts: map[connection]int{}}
+	return &connectionCounters{counted: map[string]struct{}{}, counts: map[connection]int{}, sampled: map[connection]struct{}{}}
 }
 
 func (c *connectionCounters) add(dns report.DNSRecords, outgoing bool, localNode, remoteNode, localEndpoint, remoteEndpoint report.Node) {
@@ -110,10 +111,13 @@ func (c *connectionCounters) add(dns report.DNSRecords, outgoing bool, localNode
 
 	c.counted[connectionID] = struct{}{}
 	c.counts[conn] += count
+	if _, ok := srcEndpoint.Latest.Lookup(report.ConnectionsSampled); ok {
+		c.sampled[conn] = struct{}{}
+	}
 }
 
 func internetAddr(dns report.DNSRecords, node report.Node, ep report.Node) (string, bool) {
-	if !render.IsInternetNode(node) {
+	if !render.IsExternalNode(node) {
 		return "", true
 	}
 	_, addr, _, ok := report.ParseEndpointNodeID(ep.ID)
@@ -143,6 +147,10 @@ func (c *connectionCounters) rows(r report.Report, ns report.Nodes, includeLocal
 			connection.Label = row.remoteAddr
 			connection.LabelMinor = ""
 		}
+		if _, ok := c.sampled[row]; ok {
+			// The probe only reported some of the connections
+			connection.LabelMinor = fmt.Sprintf("≈%d connections", count)
+		}
 		if includeLocal {
 			connection.Metadata = append(connection.Metadata,
 				report.MetadataRow{
@@ -184,7 +192,7 @@ func incomingConnectionsSummary(topologyID string, r report.Report, n report.Nod
 	}
 
 	columnHeaders := NormalColumns
-	if render.IsInternetNode(n) {
+	if render.IsExternalNode(n) {
 		columnHeaders = InternetColumns
 	}
 	return ConnectionsSummary{
@@ -192,11 +200,29 @@ func incomingConnectionsSummary(topologyID string, r report.Report, n report.Nod
 		TopologyID:  topologyID,
 		Label:       "Inbound",
 		Columns:     columnHeaders,
-		Connections: counts.rows(r, ns, render.IsInternetNode(n)),
+		Connections: counts.rows(r, ns, render.IsExternalNode(n)),
 	}
 }
 
 func outgoingConnectionsSummary(topologyID string, r report.Report, n report.Node, ns report.Nodes) ConnectionsSummary {
+	counts := outgoingConnectionCounters(r, n, ns)
+
+	columnHeaders := NormalColumns
+	if render.IsExternalNode(n) {
+		columnHeaders = InternetColumns
+	}
+	return ConnectionsSummary{
+		ID:          "outgoing-connections",
+		TopologyID:  topologyID,
+		Label:       "Outbound",
+		Columns:     columnHeaders,
+		Connections: counts.rows(r, ns, render.IsExternalNode(n)),
+	}
+}
+
+// outgoingConnectionCounters counts the connections from n to the nodes of
+// ns it is adjacent to.
+func outgoingConnectionCounters(r report.Report, n report.Node, ns report.Nodes) *connectionCounters {
 	localEndpoints := endpointChildrenOf(n)
 	counts := newConnectionCounters()
 
@@ -214,18 +240,7 @@ func outgoingConnectionsSummary(topologyID string, r report.Report, n report.Nod
 			}
 		}
 	}
-
-	columnHeaders := NormalColumns
-	if render.IsInternetNode(n) {
-		columnHeaders = InternetColumns
-	}
-	return ConnectionsSummary{
-		ID:          "outgoing-connections",
-		TopologyID:  topologyID,
-		Label:       "Outbound",
-		Columns:     columnHeaders,
-		Connections: counts.rows(r, ns, render.IsInternetNode(n)),
-	}
+	return counts
 }
 
 func endpointChildrenOf(n report.Node) []report.Node {
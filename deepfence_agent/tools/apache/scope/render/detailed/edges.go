@@ -0,0 +1,52 @@
+package detailed
+
+import (
+	"sort"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// EdgeSummary is summary information about an edge of a topology: the
+// connections from a node to one it is adjacent to.
+type EdgeSummary struct {
+	Source      string   `json:"source"`
+	Target      string   `json:"target"`
+	Connections int      `json:"connections"`
+	Ports       []string `json:"ports,omitempty"` // the destination ports
+}
+
+// EdgeSummaries summarises the edges from each of nodes to the nodes of ns,
+// the whole rendered topology, in order of source and then target.  The
+// connections are counted as in the outbound connections of the details of
+// a node, so a connection of endpoints children of more than one node
+// counts for each of them.
+func EdgeSummaries(r report.Report, nodes, ns report.Nodes) []EdgeSummary {
+	result := []EdgeSummary{}
+	for _, n := range nodes {
+		counts := outgoingConnectionCounters(r, n, ns)
+		byTarget := map[string]int{}
+		ports := map[string]report.StringSet{}
+		for conn, count := range counts.counts {
+			byTarget[conn.remoteNodeID] += count
+			ports[conn.remoteNodeID] = ports[conn.remoteNodeID].Add(conn.port)
+		}
+		for _, target := range n.Adjacency {
+			if _, ok := ns[target]; !ok || target == n.ID {
+				continue
+			}
+			result = append(result, EdgeSummary{
+				Source:      n.ID,
+				Target:      target,
+				Connections: byTarget[target],
+				Ports:       ports[target],
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Source != result[j].Source {
+			return result[i].Source < result[j].Source
+		}
+		return result[i].Target < result[j].Target
+	})
+	return result
+}
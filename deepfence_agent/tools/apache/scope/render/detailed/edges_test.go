@@ -0,0 +1,36 @@
+package detailed_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/render/detailed"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func TestEdgeSummaries(t *testing.T) {
+	nodes := render.PodRenderer.Render(context.Background(), fixture.Report).Nodes
+	page := report.Nodes{fixture.ClientPodNodeID: nodes[fixture.ClientPodNodeID]}
+
+	have := detailed.EdgeSummaries(fixture.Report, page, nodes)
+	want := []detailed.EdgeSummary{{
+		Source:      fixture.ClientPodNodeID,
+		Target:      fixture.ServerPodNodeID,
+		Connections: 2,
+		Ports:       []string{fixture.ServerPort},
+	}}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Only edges to nodes rendered are summarised
+	nodes = nodes.Copy()
+	delete(nodes, fixture.ServerPodNodeID)
+	if have := detailed.EdgeSummaries(fixture.Report, page, nodes); len(have) != 0 {
+		t.Errorf("Expected no edges, have %v", have)
+	}
+}
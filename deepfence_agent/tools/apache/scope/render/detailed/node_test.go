@@ -11,14 +11,13 @@ import (
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/detailed"
-	"github.com/weaveworks/scope/render/expected"
 	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test/fixture"
 	"github.com/weaveworks/scope/test/reflect"
 )
 
 func child(t *testing.T, r render.Renderer, id string) detailed.NodeSummary {
-	s, ok := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, r.Render(context.Background(), fixture.Report).Nodes[id])
+	s, ok := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, r.Render(context.Background(), fixture.Report).Nodes[id], false, false)
 	if !ok {
 		t.Fatalf("Expected node %s to be summarizable, but wasn't", id)
 	}
@@ -34,7 +33,6 @@ func TestMakeDetailedHostNode(t *testing.T) {
 	renderableNode := renderableNodes[fixture.ClientHostNodeID]
 	have := detailed.MakeNode("hosts", detailed.RenderContext{Report: fixture.Report}, renderableNodes, renderableNode)
 
-	containerImageNodeSummary := child(t, render.ContainerImageRenderer, expected.ClientContainerImageNodeID)
 	containerNodeSummary := child(t, render.ContainerRenderer, fixture.ClientContainerNodeID)
 	process1NodeSummary := child(t, render.ProcessRenderer, fixture.ClientProcess1NodeID)
 	process2NodeSummary := child(t, render.ProcessRenderer, fixture.ClientProcess2NodeID)
@@ -43,7 +41,7 @@ func TestMakeDetailedHostNode(t *testing.T) {
 		NodeSummary: detailed.NodeSummary{
 			BasicNodeSummary: detailed.BasicNodeSummary{
 				ID:         fixture.ClientHostNodeID,
-				Label:      "client",
+				Label:      "client.hostname.com",
 				LabelMinor: "hostname.com",
 				Rank:       "hostname.com",
 				Pseudo:     false,
@@ -129,12 +127,6 @@ func TestMakeDetailedHostNode(t *testing.T) {
 				},
 				Nodes: []detailed.NodeSummary{process1NodeSummary, process2NodeSummary},
 			},
-			{
-				Label:      "Container images",
-				TopologyID: "containers-by-image",
-				Columns:    []detailed.Column{},
-				Nodes:      []detailed.NodeSummary{containerImageNodeSummary},
-			},
 		},
 		Connections: []detailed.ConnectionsSummary{
 			{
@@ -153,7 +145,7 @@ func TestMakeDetailedHostNode(t *testing.T) {
 					{
 						ID:         connectionID(fixture.ServerHostNodeID, ""),
 						NodeID:     fixture.ServerHostNodeID,
-						Label:      "server",
+						Label:      "server.hostname.com",
 						LabelMinor: "hostname.com",
 						Metadata: []report.MetadataRow{
 							{
@@ -191,15 +183,15 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				ID:         id,
 				Label:      "server",
 				LabelMinor: "server.hostname.com",
-				Rank:       fixture.ServerContainerImageName,
+				Rank:       fixture.ServerHostID,
 				Shape:      "hexagon",
 				Tag:        "",
 				Pseudo:     false,
 			},
 			Metadata: []report.MetadataRow{
-				{ID: "docker_image_name", Label: "Image name", Value: fixture.ServerContainerImageName, Priority: 2},
 				{ID: "docker_container_state_human", Label: "State", Value: "running", Priority: 4},
 				{ID: "docker_container_id", Label: "ID", Value: fixture.ServerContainerID, Priority: 11, Truncate: 12},
+				{ID: "docker_image_id", Label: "Image ID", Value: fixture.ServerContainerImageID, Priority: 14, Truncate: 12},
 			},
 			Metrics: []report.MetricRow{
 				{
@@ -221,8 +213,8 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 			},
 			Parents: []detailed.Parent{
 				{
-					ID:         expected.ServerContainerImageNodeID,
-					Label:      fixture.ServerContainerImageName,
+					ID:         fixture.ServerContainerImageNodeID,
+					Label:      fixture.ServerContainerImageID,
 					TopologyID: "containers-by-image",
 				},
 				{
@@ -232,7 +224,7 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				},
 				{
 					ID:         fixture.ServerHostNodeID,
-					Label:      "server",
+					Label:      "server.hostname.com",
 					TopologyID: "hosts",
 				},
 			},
@@ -339,7 +331,7 @@ func TestMakeDetailedPodNode(t *testing.T) {
 				},
 				{
 					ID:         fixture.ServerHostNodeID,
-					Label:      "server",
+					Label:      "server.hostname.com",
 					TopologyID: "hosts",
 				},
 			},
@@ -20,7 +20,9 @@ var parentTopologies = []string{
 	report.DaemonSet,
 	report.StatefulSet,
 	report.CronJob,
+	report.CustomResource,
 	report.Service,
+	report.PersistentVolumeClaim,
 	report.ECSTask,
 	report.ECSService,
 	report.SwarmService,
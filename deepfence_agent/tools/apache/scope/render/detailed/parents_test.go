@@ -8,7 +8,6 @@ import (
 	"github.com/weaveworks/common/test"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/detailed"
-	"github.com/weaveworks/scope/render/expected"
 	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test/fixture"
 	"github.com/weaveworks/scope/test/reflect"
@@ -33,26 +32,28 @@ func TestParents(t *testing.T) {
 			want: nil,
 		},
 		{
+			// As reported, as the images of the fixture have no tags, which
+			// those rendered need
 			name: "Container image",
-			node: render.ContainerImageRenderer.Render(ctx, fixture.Report).Nodes[expected.ClientContainerImageNodeID],
+			node: fixture.Report.ContainerImage.Nodes[fixture.ClientContainerImageNodeID],
 			want: []detailed.Parent{
-				{ID: fixture.ClientHostNodeID, Label: "client", TopologyID: "hosts"},
+				{ID: fixture.ClientHostNodeID, Label: fixture.ClientHostID, TopologyID: "hosts"},
 			},
 		},
 		{
 			name: "Container",
 			node: render.ContainerWithImageNameRenderer.Render(ctx, fixture.Report).Nodes[fixture.ClientContainerNodeID],
 			want: []detailed.Parent{
-				{ID: expected.ClientContainerImageNodeID, Label: fixture.ClientContainerImageName, TopologyID: "containers-by-image"},
+				{ID: fixture.ClientContainerImageNodeID, Label: fixture.ClientContainerImageID, TopologyID: "containers-by-image"},
 				{ID: fixture.ClientPodNodeID, Label: "pong-a", TopologyID: "pods"},
-				{ID: fixture.ClientHostNodeID, Label: "client", TopologyID: "hosts"},
+				{ID: fixture.ClientHostNodeID, Label: fixture.ClientHostID, TopologyID: "hosts"},
 			},
 		},
 		{
 			node: render.ProcessRenderer.Render(ctx, fixture.Report).Nodes[fixture.ClientProcess1NodeID],
 			want: []detailed.Parent{
 				{ID: fixture.ClientContainerNodeID, Label: fixture.ClientContainerName, TopologyID: "containers"},
-				{ID: fixture.ClientHostNodeID, Label: "client", TopologyID: "hosts"},
+				{ID: fixture.ClientHostNodeID, Label: fixture.ClientHostID, TopologyID: "hosts"},
 			},
 		},
 	} {
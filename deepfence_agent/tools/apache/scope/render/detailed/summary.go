@@ -3,7 +3,10 @@ package detailed
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 
@@ -56,6 +59,9 @@ type BasicNodeSummary struct {
 	Tag        string `json:"tag,omitempty"`
 	Stack      bool   `json:"stack,omitempty"`
 	Pseudo     bool   `json:"pseudo,omitempty"`
+
+	// Connections are those of external destinations.
+	Connections int `json:"connections,omitempty"`
 }
 
 // NodeSummary is summary information about a Node.
@@ -66,6 +72,7 @@ type NodeSummary struct {
 	Metrics           []report.MetricRow   `json:"metrics,omitempty"`
 	Tables            []report.Table       `json:"tables,omitempty"`
 	Adjacency         report.IDList        `json:"adjacency,omitempty"`
+	ScanStatuses      report.ScanStatuses  `json:"scan_statuses,omitempty"`
 	ImmediateParentID string               `json:"immediate_parent_id"`
 }
 
@@ -84,6 +91,8 @@ var renderers = map[string]func(BasicNodeSummary, report.Node) BasicNodeSummary{
 	report.StatefulSet:           podGroupNodeSummary,
 	report.CronJob:               podGroupNodeSummary,
 	report.Job:                   podGroupNodeSummary,
+	report.Ingress:               ingressNodeSummary,
+	report.CustomResource:        customResourceNodeSummary,
 	report.ECSTask:               ecsTaskNodeSummary,
 	report.ECSService:            ecsServiceNodeSummary,
 	report.SwarmService:          swarmServiceNodeSummary,
@@ -112,6 +121,8 @@ var primaryAPITopology = map[string]string{
 	report.CronJob:               "kube-controllers",
 	report.Job:                   "kube-controllers",
 	report.Service:               "services",
+	report.Ingress:               "services",
+	report.CustomResource:        "kube-controllers",
 	report.ECSTask:               "ecs-tasks",
 	report.ECSService:            "ecs-services",
 	report.SwarmService:          "swarm-services",
@@ -176,16 +187,109 @@ func MakeNodeSummary(rc RenderContext, n report.Node, ignoreMetadata bool, ignor
 		if topology, ok := rc.Topology(n.Topology); ok {
 			if ignoreMetadata == false {
 				summary.Metadata = topology.MetadataTemplates.MetadataRows(n)
+				if score, ok := n.Latest.Lookup(render.ExposureScore); ok {
+					summary.Metadata = append(summary.Metadata, exposureScoreRow(score))
+				}
+				if destinations, ok := n.Latest.Lookup(render.NewExternalDestination); ok {
+					summary.Metadata = append(summary.Metadata, report.MetadataRow{
+						ID:       render.NewExternalDestination,
+						Label:    "New external destinations",
+						Value:    destinations,
+						Priority: 1.6,
+					})
+				}
+				summary.ScanStatuses = n.ScanStatuses()
 			}
 			if ignoreMetrics == false {
 				summary.Metrics = topology.MetricTemplates.MetricRows(n)
 			}
 			summary.Tables = topology.TableTemplates.Tables(n)
+			if n.Topology == report.Host || n.Topology == report.Container {
+				if table, ok := complianceTable(n.ScanStatuses()); ok {
+					summary.Tables = append(summary.Tables, table)
+				}
+			}
+		}
+	}
+	// Nodes collapsed into others have their metrics summed
+	if topologyID, _, ok := render.ParseOthersNodeID(n.ID); ok && !ignoreMetrics {
+		if topology, ok := rc.Topology(topologyID); ok {
+			summary.Metrics = topology.MetricTemplates.MetricRows(n)
 		}
 	}
 	return RenderMetricURLs(summary, n, rc.Report, rc.MetricsGraphURL), true
 }
 
+// exposureScoreRow is the metadata row of the exposure score of a node, as
+// scored by the app, sortable as a number.
+func exposureScoreRow(score string) report.MetadataRow {
+	return report.MetadataRow{
+		ID:       render.ExposureScore,
+		Label:    "Exposure score",
+		Value:    score,
+		Priority: 1.5,
+		Datatype: report.Number,
+	}
+}
+
+// Columns of the compliance table of hosts and containers
+const (
+	complianceTableID      = "compliance"
+	complianceBenchmark    = "benchmark"
+	complianceVersion      = "version"
+	complianceCategory     = "category"
+	complianceStatus       = "status"
+	complianceLastScan     = "last_scan"
+	compliancePass         = "pass"
+	complianceFail         = "fail"
+	complianceWarn         = "warn"
+	complianceRowSeparator = "/"
+)
+
+// complianceTable is the table of the results of the compliance checks of
+// each benchmark in statuses, a row per category of checks.
+func complianceTable(statuses report.ScanStatuses) (report.Table, bool) {
+	table := report.Table{
+		ID:    complianceTableID,
+		Label: "Compliance",
+		Type:  report.MulticolumnTableType,
+		Columns: []report.Column{
+			{ID: complianceBenchmark, Label: "Benchmark"},
+			{ID: complianceVersion, Label: "Version"},
+			{ID: complianceCategory, Label: "Category"},
+			{ID: compliancePass, Label: "Pass", DataType: report.Number},
+			{ID: complianceFail, Label: "Fail", DataType: report.Number},
+			{ID: complianceWarn, Label: "Warn", DataType: report.Number},
+			{ID: complianceStatus, Label: "Status"},
+			{ID: complianceLastScan, Label: "Last scan", DataType: report.DateTime},
+		},
+	}
+	for _, scanType := range statuses.Types() {
+		benchmark, ok := report.ParseComplianceScanType(scanType)
+		if !ok {
+			continue
+		}
+		scan := statuses[scanType]
+		for category, counts := range scan.Categories {
+			table.Rows = append(table.Rows, report.Row{
+				ID: benchmark + complianceRowSeparator + category,
+				Entries: map[string]string{
+					complianceBenchmark: benchmark,
+					complianceVersion:   scan.Version,
+					complianceCategory:  category,
+					compliancePass:      strconv.Itoa(counts[compliancePass]),
+					complianceFail:      strconv.Itoa(counts[complianceFail]),
+					complianceWarn:      strconv.Itoa(counts[complianceWarn]),
+					complianceStatus:    scan.Status,
+					complianceLastScan:  scan.LastScan.UTC().Format(time.RFC3339Nano),
+				},
+			})
+		}
+	}
+	sort.Slice(table.Rows, func(i, j int) bool { return table.Rows[i].ID < table.Rows[j].ID })
+	return table, len(table.Rows) > 0
+}
+
 // SummarizeMetrics returns a copy of the NodeSummary where the metrics are
 // replaced with their summaries
 func (n NodeSummary) SummarizeMetrics() NodeSummary {
@@ -207,6 +311,9 @@ func pseudoNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	if n.ID != render.IncomingInternetID && n.ID != render.OutgoingInternetID {
 		prefix, shape, found = matchesKnownNodes(n)
 	}
+	if count, ok := n.Latest.Lookup(render.ConnectionCount); ok {
+		base.Connections, _ = strconv.Atoi(count)
+	}
 
 	switch {
 	case n.ID == render.IncomingInternetID:
@@ -235,6 +342,40 @@ func pseudoNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 		base.LabelMinor = n.ID[len(render.UncontainedIDPrefix):]
 		base.Shape = report.Square
 		base.Stack = true
+	case strings.HasPrefix(n.ID, render.ExternalIDPrefix):
+		// render as an external destination, split from the internet
+		incoming, kind, name, _ := render.ParseExternalNodeID(n.ID)
+		switch kind {
+		case render.ExternalCloud:
+			base.Label = strings.TrimSpace(strings.Replace(name, "/", " ", 1))
+		case render.ExternalInternal:
+			base.Label = render.ExternalInternalMajor
+		default:
+			base.Label = name
+		}
+		base.LabelMinor = render.OutboundMinor
+		if incoming {
+			base.LabelMinor = render.InboundMinor
+		}
+		base.Shape = report.Cloud
+	case strings.HasPrefix(n.ID, render.SeverityIDPrefix):
+		// render as a group of containers of a severity
+		containers, _ := n.LookupCounter(report.Container)
+		base.Label = fmt.Sprintf("%s (%d)", render.SeverityLabels[n.ID[len(render.SeverityIDPrefix):]], containers)
+		base.LabelMinor = ""
+		base.Shape = report.Square
+		base.Stack = true
+	case strings.HasPrefix(n.ID, render.OthersIDPrefix):
+		// render as the nodes of a parent collapsed past the top few
+		topology, parentID, _ := render.ParseOthersNodeID(n.ID)
+		others, _ := n.LookupCounter(topology)
+		base.Label = fmt.Sprintf("%d others", others)
+		base.LabelMinor = parentID
+		if id, _, ok := report.ParseNodeID(parentID); ok {
+			base.LabelMinor = id
+		}
+		base.Shape = report.Square
+		base.Stack = true
 	case strings.HasPrefix(n.ID, render.UnmanagedIDPrefix):
 		// render as an unmanaged node
 		base.Label = render.UnmanagedMajor
@@ -292,11 +433,10 @@ func processNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 
 func containerNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	var (
-		containerName    = GetRenderableContainerName(n)
-		hostName         = report.ExtractHostID(n)
-		imageName, _     = n.Latest.Lookup(docker.ImageName)
-		imageTag, _      = n.Latest.Lookup(docker.ImageTag)
-		imageNameWithTag = fmt.Sprintf("%s:%s", imageName, imageTag)
+		containerName = GetRenderableContainerName(n)
+		hostName      = report.ExtractHostID(n)
+		imageName, _  = n.Latest.Lookup(docker.ImageName)
+		imageTag, _   = n.Latest.Lookup(docker.ImageTag)
 	)
 	base.Label = containerName
 	base.LabelMinor = hostName
@@ -307,7 +447,7 @@ func containerNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary
 	} else {
 		base.Rank = base.Label
 	}
-	base.Image = imageNameWithTag
+	base.Image = imageNameWithTag(imageName, imageTag)
 	return base
 }
 
@@ -342,16 +482,15 @@ func cloudRegionNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSumma
 
 func containerImageNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	var (
-		imageName, _     = n.Latest.Lookup(docker.ImageName)
-		imageTag, _      = n.Latest.Lookup(docker.ImageTag)
-		imageNameWithTag = fmt.Sprintf("%s:%s", imageName, imageTag)
+		imageName, _ = n.Latest.Lookup(docker.ImageName)
+		imageTag, _  = n.Latest.Lookup(docker.ImageTag)
 		//imageNameWithoutTag = docker.ImageNameWithoutTag(imageName)
 	)
 	switch {
 	//case imageNameWithoutTag != "" && imageNameWithoutTag != ImageNameNone:
 	//	base.Label = imageNameWithoutTag
 	case imageName != "" && imageName != ImageNameNone && imageTag != "" && imageTag != ImageNameNone:
-		base.Label = imageNameWithTag
+		base.Label = imageNameWithTag(imageName, imageTag)
 	default:
 		// The id can be an image id or an image name. Ideally we'd
 		// truncate the former but not the latter, but short of
@@ -361,10 +500,18 @@ func containerImageNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSu
 	base.LabelMinor = pluralize(n, report.Container, "container", "containers")
 	base.Rank = base.Label
 	base.Stack = true
-	base.Image = imageNameWithTag
+	base.Image = imageNameWithTag(imageName, imageTag)
 	return base
 }
 
+// imageNameWithTag returns the name of an image with its tag, if it has one.
+func imageNameWithTag(name, tag string) string {
+	if name == "" || tag == "" {
+		return name
+	}
+	return name + ":" + tag
+}
+
 func addKubernetesLabelAndRank(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	var (
 		name, _      = n.Latest.Lookup(kubernetes.Name)
@@ -407,6 +554,22 @@ func podGroupNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary
 	return base
 }
 
+func ingressNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
+	base = addKubernetesLabelAndRank(base, n)
+	if hosts, ok := n.Latest.Lookup(kubernetes.IngressHosts); ok {
+		base.LabelMinor = hosts
+	}
+	return base
+}
+
+func customResourceNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
+	base = podGroupNodeSummary(base, n)
+	if kind, ok := n.Latest.Lookup(kubernetes.NodeType); ok {
+		base.LabelMinor = fmt.Sprintf("%s of %s", kind, pluralize(n, report.Pod, "pod", "pods"))
+	}
+	return base
+}
+
 func ecsTaskNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	base.Label, _ = n.Latest.Lookup(awsecs.TaskFamily)
 	if base.Label == "" {
@@ -460,13 +623,23 @@ func weaveNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	return base
 }
 
+// Volumes and claims which aren't bound show their phase, so that unbound
+// claims and released or failed volumes stand out.
 func persistentVolumeNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	base = addKubernetesLabelAndRank(base, n)
+	if phase, ok := n.Latest.Lookup(kubernetes.Phase); ok && phase != "Bound" {
+		base.LabelMinor = phase
+	}
 	return base
 }
 
 func persistentVolumeClaimNodeSummary(base BasicNodeSummary, n report.Node) BasicNodeSummary {
 	base = addKubernetesLabelAndRank(base, n)
+	if phase, ok := n.Latest.Lookup(kubernetes.Phase); ok && phase != "Bound" {
+		base.LabelMinor = phase
+	} else if inUse, _ := n.Latest.Lookup(kubernetes.VolumeInUse); inUse == "false" {
+		base.LabelMinor = "not used by any pod"
+	}
 	return base
 }
 
@@ -544,6 +717,10 @@ func Summaries(ctx context.Context, rc RenderContext, rns report.Nodes, adjacenc
 
 	result := NodeSummaries{}
 	for id, node := range rns {
+		// Those summarised in time, if cut short
+		if render.CutShort(ctx) {
+			break
+		}
 		if adjacency == false {
 			node.Adjacency = report.MakeIDList()
 		}
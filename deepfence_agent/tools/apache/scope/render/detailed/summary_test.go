@@ -8,6 +8,7 @@ import (
 
 	"github.com/weaveworks/common/mtime"
 	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/common/xfer"
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/render"
@@ -21,7 +22,7 @@ import (
 func TestSummaries(t *testing.T) {
 	{
 		// Just a convenient source of some rendered nodes
-		have := detailed.Summaries(context.Background(), detailed.RenderContext{Report: fixture.Report}, render.ProcessRenderer.Render(context.Background(), fixture.Report).Nodes)
+		have := detailed.Summaries(context.Background(), detailed.RenderContext{Report: fixture.Report}, render.ProcessRenderer.Render(context.Background(), fixture.Report).Nodes, true)
 		// The ids of the processes rendered above
 		expectedIDs := []string{
 			fixture.ClientProcess1NodeID,
@@ -53,7 +54,7 @@ func TestSummaries(t *testing.T) {
 		processNode.Metrics = processNode.Metrics.Copy()
 		processNode.Metrics[process.CPUUsage] = metric
 		input.Process.Nodes[fixture.ClientProcess1NodeID] = processNode
-		have := detailed.Summaries(context.Background(), detailed.RenderContext{Report: input}, render.ProcessRenderer.Render(context.Background(), input).Nodes)
+		have := detailed.Summaries(context.Background(), detailed.RenderContext{Report: input}, render.ProcessRenderer.Render(context.Background(), input).Nodes, true)
 
 		node, ok := have[fixture.ClientProcess1NodeID]
 		if !ok {
@@ -128,6 +129,7 @@ func TestMakeNodeSummary(t *testing.T) {
 					Label:      fixture.ClientContainerName,
 					LabelMinor: fixture.ClientHostName,
 					Rank:       fixture.ClientContainerImageName,
+					Image:      fixture.ClientContainerImageName,
 					Shape:      "hexagon",
 					Tag:        "",
 				},
@@ -148,12 +150,15 @@ func TestMakeNodeSummary(t *testing.T) {
 					Label:      fixture.ClientContainerImageName,
 					LabelMinor: "1 container",
 					Rank:       fixture.ClientContainerImageName,
+					Image:      fixture.ClientContainerImageName,
 					Shape:      "hexagon",
 					Tag:        "",
 					Stack:      true,
 				},
 				Metadata: []report.MetadataRow{
 					{ID: report.Container, Label: "# Containers", Value: "1", Priority: 2, Datatype: report.Number},
+					{ID: docker.ImageName, Label: "Image name", Value: fixture.ClientContainerImageName, Priority: 4},
+					{ID: docker.ImageID, Label: "Image ID", Value: fixture.ClientContainerImageID, Priority: 8, Truncate: 12},
 				},
 				Adjacency: report.MakeIDList(expected.ServerContainerImageNodeID),
 			},
@@ -165,7 +170,7 @@ func TestMakeNodeSummary(t *testing.T) {
 			want: detailed.NodeSummary{
 				BasicNodeSummary: detailed.BasicNodeSummary{
 					ID:         fixture.ClientHostNodeID,
-					Label:      "client",
+					Label:      fixture.ClientHostID,
 					LabelMinor: "hostname.com",
 					Rank:       "hostname.com",
 					Shape:      "circle",
@@ -195,7 +200,7 @@ func TestMakeNodeSummary(t *testing.T) {
 		},
 	}
 	for _, testcase := range testcases {
-		have, ok := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, testcase.input)
+		have, ok := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, testcase.input, false, false)
 		if ok != testcase.ok {
 			t.Errorf("%s: MakeNodeSummary failed: expected ok value to be: %v", testcase.name, testcase.ok)
 			continue
@@ -220,6 +225,8 @@ func TestMakeNodeSummaryNoMetadata(t *testing.T) {
 		report.DaemonSet:      report.MakeDaemonSetNodeID("005e2999-d429-11e7-8535-0a41257e78e8"),
 		report.StatefulSet:    report.MakeStatefulSetNodeID("005e2999-d429-11e7-8535-0a41257e78e8"),
 		report.CronJob:        report.MakeCronJobNodeID("005e2999-d429-11e7-8535-0a41257e78e8"),
+		report.Ingress:        report.MakeIngressNodeID("005e2999-d429-11e7-8535-0a41257e78e8"),
+		report.CustomResource: report.MakeCustomResourceNodeID("005e2999-d429-11e7-8535-0a41257e78e8"),
 		report.ECSTask:        report.MakeECSTaskNodeID("arn:aws:ecs:us-east-1:012345678910:task/1dc5c17a-422b-4dc4-b493-371970c6c4d6"),
 		report.ECSService:     report.MakeECSServiceNodeID("cluster", "service"),
 		report.SwarmService:   report.MakeSwarmServiceNodeID("0001accbecc2c95e650fe641926fb923b7cc307a71101a1200af3759227b6d7d"),
@@ -227,7 +234,7 @@ func TestMakeNodeSummaryNoMetadata(t *testing.T) {
 		report.Overlay:        report.MakeOverlayNodeID("", "3e:ca:14:ca:12:5c"),
 		processNameTopology:   "/home/weave/scope",
 	} {
-		summary, b := detailed.MakeNodeSummary(detailed.RenderContext{}, report.MakeNode(id).WithTopology(topology))
+		summary, b := detailed.MakeNodeSummary(detailed.RenderContext{}, report.MakeNode(id).WithTopology(topology), false, false)
 		switch {
 		case !b:
 			t.Errorf("Node Summary missing for topology %s, id %s", topology, id)
@@ -269,7 +276,7 @@ func TestNodeMetadata(t *testing.T) {
 		},
 	}
 	for _, input := range inputs {
-		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, input.node)
+		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, input.node, false, false)
 		have := summary.Metadata
 		if !reflect.DeepEqual(input.want, have) {
 			t.Errorf("%s: %s", input.name, test.Diff(input.want, have))
@@ -277,6 +284,50 @@ func TestNodeMetadata(t *testing.T) {
 	}
 }
 
+func TestNodePluginMetadata(t *testing.T) {
+	rpt := report.MakeReport()
+	rpt.Plugins = rpt.Plugins.Add(xfer.PluginSpec{ID: "iowait"})
+	rpt.Host = rpt.Host.WithMetadataTemplates(report.MetadataTemplates{
+		report.HostName: {ID: report.HostName, Label: "Hostname", From: report.FromLatest, Priority: 11},
+		// Overridden by built-in templates
+		report.PluginTemplateKey("iowait", "name"):   {ID: report.HostName, Label: "Plugin hostname", Priority: 1},
+		report.PluginTemplateKey("iowait", "iowait"): {ID: "iowait", Label: "IO Wait", Datatype: report.Number, Priority: 5.5},
+		// Left over by an unregistered plugin
+		report.PluginTemplateKey("gone", "gone"): {ID: "gone", Label: "Gone", Priority: 2},
+	}).WithTableTemplates(report.TableTemplates{
+		report.PluginTemplateKey("iowait", "devices"): {ID: "devices", Label: "Devices", Prefix: "iowait_device_", Type: report.PropertyListType},
+	})
+	node := report.MakeNodeWith(report.MakeHostNodeID("host1"), map[string]string{
+		report.HostName:     "host1",
+		"iowait":            "0.5",
+		"gone":              "yes",
+		"iowait_device_sda": "0.2",
+	}).WithTopology(report.Host)
+	rpt.Host.AddNode(node)
+
+	summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: rpt.ResolvePluginTemplates()}, node, false, false)
+	wantMetadata := []report.MetadataRow{
+		{ID: "iowait", Label: "IO Wait", Value: "0.5", Priority: 5.5, Datatype: report.Number},
+		{ID: report.HostName, Label: "Hostname", Value: "host1", Priority: 11},
+	}
+	if !reflect.DeepEqual(wantMetadata, summary.Metadata) {
+		t.Errorf("%s", test.Diff(wantMetadata, summary.Metadata))
+	}
+	wantTables := []report.Table{
+		{
+			ID:    "devices",
+			Label: "Devices",
+			Type:  report.PropertyListType,
+			Rows: []report.Row{
+				{ID: "label_sda", Entries: map[string]string{"label": "sda", "value": "0.2"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(wantTables, summary.Tables) {
+		t.Errorf("%s", test.Diff(wantTables, summary.Tables))
+	}
+}
+
 func TestNodeMetrics(t *testing.T) {
 	inputs := []struct {
 		name string
@@ -370,7 +421,7 @@ func TestNodeMetrics(t *testing.T) {
 		},
 	}
 	for _, input := range inputs {
-		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, input.node)
+		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: fixture.Report}, input.node, false, false)
 		have := summary.Metrics
 		if !reflect.DeepEqual(input.want, have) {
 			t.Errorf("%s: %s", input.name, test.Diff(input.want, have))
@@ -435,6 +486,17 @@ func TestNodeTables(t *testing.T) {
 					Label: "Environment variables",
 					Rows:  []report.Row{},
 				},
+				{
+					ID:    docker.ContainerExitHistoryTablePrefix,
+					Type:  report.MulticolumnTableType,
+					Label: "Exit history",
+					Columns: []report.Column{
+						{ID: docker.ContainerExitFinishedAt, Label: "Exited", DataType: report.DateTime},
+						{ID: docker.ContainerExitCode, Label: "Exit code", DataType: report.Number},
+						{ID: docker.ContainerExitOOMKilled, Label: "OOM killed"},
+					},
+					Rows: []report.Row{},
+				},
 				{
 					ID:    docker.LabelPrefix,
 					Type:  report.PropertyListType,
@@ -467,7 +529,7 @@ func TestNodeTables(t *testing.T) {
 		},
 	}
 	for _, input := range inputs {
-		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: input.rpt}, input.node)
+		summary, _ := detailed.MakeNodeSummary(detailed.RenderContext{Report: input.rpt}, input.node, false, false)
 		have := summary.Tables
 		if !reflect.DeepEqual(input.want, have) {
 			t.Errorf("%s: %s", input.name, test.Diff(input.want, have))
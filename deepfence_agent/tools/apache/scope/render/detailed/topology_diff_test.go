@@ -1,6 +1,8 @@
 package detailed_test
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
 	"sort"
 	"testing"
@@ -108,3 +110,60 @@ func TestTopoDiff(t *testing.T) {
 		}
 	}
 }
+
+// applyDiff applies a diff to the topology a client has, as the UI does.
+func applyDiff(topo detailed.NodeSummaries, diff detailed.Diff) detailed.NodeSummaries {
+	result := detailed.NodeSummaries{}
+	if !diff.Reset {
+		for id, n := range topo {
+			result[id] = n
+		}
+	}
+	for _, id := range diff.Remove {
+		delete(result, id)
+	}
+	for _, n := range append(diff.Add, diff.Update...) {
+		result[n.ID] = n
+	}
+	return result
+}
+
+// Clients applying the diffs of a sequence of topologies, or of any
+// subsequence of it as when intermediate topologies are dropped for slow
+// clients, end up with the last topology.
+func TestTopoDiffReplay(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	topologies := make([]detailed.NodeSummaries, 50)
+	for i := range topologies {
+		topo := detailed.NodeSummaries{}
+		for j := 0; j < 20; j++ {
+			if r.Intn(2) == 0 {
+				continue
+			}
+			id := fmt.Sprintf("node%d", j)
+			topo[id] = detailed.NodeSummary{
+				BasicNodeSummary: detailed.BasicNodeSummary{
+					ID:    id,
+					Label: fmt.Sprintf("label%d", r.Intn(3)),
+				},
+			}
+		}
+		topologies[i] = topo
+	}
+
+	for _, every := range []int{1, 2, 7} {
+		var sent, client detailed.NodeSummaries
+		for i := 0; i < len(topologies); i += every {
+			client = applyDiff(client, detailed.TopoDiff(sent, topologies[i]))
+			sent = topologies[i]
+			if !reflect.DeepEqual(sent, client) {
+				t.Fatalf("every %d, topology %d: %s", every, i, test.Diff(sent, client))
+			}
+		}
+		last := topologies[len(topologies)-1]
+		client = applyDiff(client, detailed.TopoDiff(sent, last))
+		if !reflect.DeepEqual(last, client) {
+			t.Errorf("every %d, last topology: %s", every, test.Diff(last, client))
+		}
+	}
+}
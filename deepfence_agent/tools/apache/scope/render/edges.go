@@ -0,0 +1,115 @@
+package render
+
+import (
+	"github.com/weaveworks/scope/report"
+)
+
+// ProtocolTCP is the protocol of connections whose endpoints have no
+// report.ProtocolHint, which the probes only give UDP ones.
+const ProtocolTCP = "tcp"
+
+// EdgeConnection is the destination port and protocol of connections
+// along an edge.
+type EdgeConnection struct {
+	Port     string
+	Protocol string
+}
+
+// EdgeConnections are the destination ports and protocols of the
+// connections along an edge.  A pair of nodes may talk on several.
+type EdgeConnections map[EdgeConnection]struct{}
+
+// Connections returns the ports and protocols of the connections from the
+// endpoints children of source to those of target.  The renderers joining
+// endpoints to containers, and lifting those to pods and up, carry the
+// endpoints along as children, so this holds of the edges of any topology
+// whose nodes have endpoints children.
+func Connections(source, target report.Node) EdgeConnections {
+	targetEndpoints := map[string]report.Node{}
+	target.Children.ForEach(func(child report.Node) {
+		if child.Topology == report.Endpoint {
+			targetEndpoints[child.ID] = child
+		}
+	})
+	result := EdgeConnections{}
+	source.Children.ForEach(func(child report.Node) {
+		if child.Topology != report.Endpoint {
+			return
+		}
+		for _, dst := range child.Adjacency {
+			dstEndpoint, ok := targetEndpoints[dst]
+			if !ok {
+				continue
+			}
+			// The port connected to, before any NAT
+			if copyID, ok := dstEndpoint.Latest.Lookup(report.CopyOf); ok {
+				dst = copyID
+			}
+			_, _, port, _ := report.ParseEndpointNodeID(dst)
+			protocol, ok := child.Latest.Lookup(report.ProtocolHint)
+			if !ok {
+				protocol = ProtocolTCP
+			}
+			result[EdgeConnection{Port: port, Protocol: protocol}] = struct{}{}
+		}
+	})
+	return result
+}
+
+// EdgeFilter is a transformer keeping only the edges with a connection on
+// any of Ports and of any of Protocols; either matches all if empty.  Nodes
+// left without edges by it are dropped, unless KeepIsolated, but not those
+// which had none to begin with.
+type EdgeFilter struct {
+	Ports        report.StringSet
+	Protocols    report.StringSet
+	KeepIsolated bool
+}
+
+func (f EdgeFilter) matches(connections EdgeConnections) bool {
+	for c := range connections {
+		if (len(f.Ports) == 0 || f.Ports.Contains(c.Port)) &&
+			(len(f.Protocols) == 0 || f.Protocols.Contains(c.Protocol)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Transform implements Transformer
+func (f EdgeFilter) Transform(input Nodes) Nodes {
+	if len(f.Ports) == 0 && len(f.Protocols) == 0 {
+		return input
+	}
+	var (
+		output    = make(report.Nodes, len(input.Nodes))
+		connected = map[string]struct{}{} // before filtering, either way
+		kept      = map[string]struct{}{}
+	)
+	for id, n := range input.Nodes {
+		adjacency := report.MakeIDList()
+		for _, dst := range n.Adjacency {
+			target, ok := input.Nodes[dst]
+			if !ok {
+				continue
+			}
+			connected[id], connected[dst] = struct{}{}, struct{}{}
+			if f.matches(Connections(n, target)) {
+				adjacency = adjacency.Add(dst)
+				kept[id], kept[dst] = struct{}{}, struct{}{}
+			}
+		}
+		n.Adjacency = adjacency
+		output[id] = n
+	}
+	filtered := input.Filtered
+	if !f.KeepIsolated {
+		for id := range connected {
+			if _, ok := kept[id]; !ok {
+				delete(output, id)
+				filtered++
+			}
+		}
+	}
+	return Nodes{Nodes: output, Filtered: filtered}
+}
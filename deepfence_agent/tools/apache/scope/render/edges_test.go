@@ -0,0 +1,123 @@
+package render_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// edgesReport is a report of containers, each in a pod of its name, on a
+// host, which connect to each other: the client to the database on two
+// ports, to DNS over UDP and to its web interface, and to a cache, as does
+// a worker.
+func edgesReport() report.Report {
+	var (
+		rpt    = report.MakeReport()
+		hostID = "edges-host"
+		port   = 40000
+		ips    = map[string]string{}
+	)
+	rpt.Host.AddNode(report.MakeNode(report.MakeHostNodeID(hostID)).WithTopology(report.Host).
+		WithSets(report.MakeSets().Add(report.HostLocalNetworks, report.MakeStringSet("192.168.0.0/16"))))
+	for i, name := range []string{"client", "db", "dns", "cache", "worker"} {
+		ip := fmt.Sprintf("192.168.0.%d", i+1)
+		ips[name] = ip
+		podID := report.MakePodNodeID(name)
+		rpt.Pod.AddNode(report.MakeNodeWith(podID, map[string]string{
+			report.KubernetesName: name,
+		}).WithTopology(report.Pod))
+		rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID(name), map[string]string{
+			report.DockerContainerID:   name,
+			report.DockerContainerName: name,
+			report.HostNodeID:          report.MakeHostNodeID(hostID),
+		}).WithSets(report.MakeSets().
+			Add(report.DockerContainerIPs, report.MakeStringSet(ip)).
+			Add(report.DockerContainerIPsWithScopes, report.MakeStringSet(report.MakeAddressNodeID("", ip))),
+		).WithTopology(report.Container).WithParent(report.Pod, podID))
+	}
+	connect := func(from, to, toPort string, latests map[string]string) {
+		port++
+		dstID := report.MakeEndpointNodeID(hostID, "", ips[to], toPort)
+		rpt.Endpoint.AddNode(report.MakeNodeWith(dstID, latests).WithTopology(report.Endpoint))
+		rpt.Endpoint.AddNode(report.MakeNodeWith(report.MakeEndpointNodeID(hostID, "", ips[from], fmt.Sprint(port)), latests).
+			WithTopology(report.Endpoint).WithAdjacent(dstID))
+	}
+	connect("client", "db", "5432", nil)
+	connect("client", "db", "9187", nil)
+	connect("client", "dns", "53", map[string]string{report.ProtocolHint: "udp"})
+	connect("client", "dns", "80", nil)
+	connect("client", "cache", "6379", nil)
+	connect("worker", "cache", "6379", nil)
+	return rpt
+}
+
+func TestConnections(t *testing.T) {
+	rpt := edgesReport()
+	for _, c := range []struct {
+		renderer       render.Renderer
+		source, target string
+	}{
+		{render.ContainerWithImageNameRenderer, report.MakeContainerNodeID("client"), report.MakeContainerNodeID("db")},
+		{render.PodRenderer, report.MakePodNodeID("client"), report.MakePodNodeID("db")},
+	} {
+		nodes := c.renderer.Render(context.Background(), rpt).Nodes
+		want := render.EdgeConnections{
+			{Port: "5432", Protocol: render.ProtocolTCP}: {},
+			{Port: "9187", Protocol: render.ProtocolTCP}: {},
+		}
+		if have := render.Connections(nodes[c.source], nodes[c.target]); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", c.source, test.Diff(want, have))
+		}
+	}
+}
+
+func TestEdgeFilter(t *testing.T) {
+	nodes := render.ContainerWithImageNameRenderer.Render(context.Background(), edgesReport())
+	container := report.MakeContainerNodeID
+	for _, c := range []struct {
+		filter render.EdgeFilter
+		want   map[string]report.IDList
+	}{
+		{render.EdgeFilter{Ports: report.MakeStringSet("5432", "3306")}, map[string]report.IDList{
+			container("client"): report.MakeIDList(container("db")),
+			container("db"):     report.MakeIDList(),
+		}},
+		{render.EdgeFilter{Protocols: report.MakeStringSet("udp")}, map[string]report.IDList{
+			container("client"): report.MakeIDList(container("dns")),
+			container("dns"):    report.MakeIDList(),
+		}},
+		{render.EdgeFilter{Ports: report.MakeStringSet("6379"), Protocols: report.MakeStringSet(render.ProtocolTCP)}, map[string]report.IDList{
+			container("client"): report.MakeIDList(container("cache")),
+			container("worker"): report.MakeIDList(container("cache")),
+			container("cache"):  report.MakeIDList(),
+		}},
+		// Ports and protocols match together, not the DNS over UDP and
+		// the web interface over TCP
+		{render.EdgeFilter{Ports: report.MakeStringSet("53"), Protocols: report.MakeStringSet(render.ProtocolTCP)}, map[string]report.IDList{}},
+		// Nodes left without edges are kept if asked
+		{render.EdgeFilter{Ports: report.MakeStringSet("53"), KeepIsolated: true}, map[string]report.IDList{
+			container("client"): report.MakeIDList(container("dns")),
+			container("db"):     report.MakeIDList(),
+			container("dns"):    report.MakeIDList(),
+			container("cache"):  report.MakeIDList(),
+			container("worker"): report.MakeIDList(),
+		}},
+	} {
+		output := c.filter.Transform(nodes)
+		have := map[string]report.IDList{}
+		for id, n := range output.Nodes {
+			have[id] = n.Adjacency
+		}
+		if !reflect.DeepEqual(c.want, have) {
+			t.Errorf("%+v: %s", c.filter, test.Diff(c.want, have))
+		}
+		if output.Filtered != len(nodes.Nodes)-len(output.Nodes) {
+			t.Errorf("%+v: expected %d filtered, have %d", c.filter, len(nodes.Nodes)-len(output.Nodes), output.Filtered)
+		}
+	}
+}
@@ -51,5 +51,6 @@ func (e mapEndpoints) Render(ctx context.Context, rpt report.Report) Nodes {
 			ret.addChild(n, id, e.topology)
 		}
 	}
+	countConnections(ret, endpoints.Nodes)
 	return ret.result(endpoints)
 }
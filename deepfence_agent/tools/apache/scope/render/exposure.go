@@ -0,0 +1,256 @@
+package render
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/report"
+)
+
+// ExposureScore is the key added to Node.Latest by ScoreExposure, of how
+// exposed to attack a container or pod is.
+const ExposureScore = "exposure_score"
+
+// Factors of exposure, as weighed by ExposureWeights.
+const (
+	ExposureExternallyExposed = "externally_exposed"
+	ExposureInternetInbound   = "internet_inbound"
+	ExposureVulnerabilities   = "vulnerabilities"
+	ExposurePrivileged        = "privileged"
+	ExposureHostNamespaces    = "host_namespaces"
+	ExposurePosture           = "posture"
+)
+
+// ExposureWeights weigh the factors of the exposure of containers and pods.
+// The score of a node is the sum of the weights of the factors it has:
+//
+//   - externally_exposed: a service, ingress, node port or public IP
+//     reaches the pod, or the pod of the container, from outside the cluster
+//   - internet_inbound: connections from the internet to the node were seen
+//   - vulnerabilities: the weight of each severity times the number of
+//     vulnerabilities of that severity in the image, up to max_vulnerabilities
+//   - privileged, host_namespaces: the container runs privileged, or in
+//     the network or PID namespace of the host
+//   - posture: scaled by how far below 100 the security score of the
+//     container is
+//
+// Pods take the worst of the vulnerabilities and posture of their
+// containers.
+type ExposureWeights struct {
+	ExternallyExposed  float64            `json:"externally_exposed"`
+	InternetInbound    float64            `json:"internet_inbound"`
+	Severities         map[string]float64 `json:"severities"`
+	MaxVulnerabilities float64            `json:"max_vulnerabilities"`
+	Privileged         float64            `json:"privileged"`
+	HostNamespaces     float64            `json:"host_namespaces"`
+	Posture            float64            `json:"posture"`
+}
+
+// DefaultExposureWeights score nodes up to 100, reached by an exposed,
+// internet-facing, badly vulnerable and privileged node.
+var DefaultExposureWeights = ExposureWeights{
+	ExternallyExposed: 25,
+	InternetInbound:   25,
+	Severities: map[string]float64{
+		SeverityCritical: 5,
+		SeverityHigh:     2,
+		SeverityMedium:   0.5,
+		SeverityLow:      0.1,
+	},
+	MaxVulnerabilities: 25,
+	Privileged:         15,
+	HostNamespaces:     5,
+	Posture:            5,
+}
+
+// LoadExposureWeights reads ExposureWeights from a JSON file.  Weights the
+// file leaves out are those of DefaultExposureWeights.
+func LoadExposureWeights(path string) (ExposureWeights, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ExposureWeights{}, err
+	}
+	defer f.Close()
+	weights := DefaultExposureWeights
+	weights.Severities = nil
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&weights); err != nil {
+		return ExposureWeights{}, err
+	}
+	if weights.Severities == nil {
+		weights.Severities = DefaultExposureWeights.Severities
+	}
+	return weights, nil
+}
+
+// Exposure is the score of a node, and how much each factor added to it.
+type Exposure struct {
+	Score   float64            `json:"score"`
+	Factors map[string]float64 `json:"factors,omitempty"`
+}
+
+func (e *Exposure) add(factor string, weight float64) {
+	if weight <= 0 {
+		return
+	}
+	if e.Factors == nil {
+		e.Factors = map[string]float64{}
+	}
+	e.Factors[factor] = roundScore(weight)
+	e.Score += weight
+}
+
+// roundScore rounds scores to 2 decimal places, so that they don't depend
+// on the order floating point weights were added in.
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+// ScoreExposure is a transformer adding the ExposureScore of the container
+// and pod nodes rendered, looking up the pods of containers and images
+// without scan statuses in Report.  It goes before any filters, so that
+// the score doesn't depend on what is shown.
+type ScoreExposure struct {
+	Report  report.Report
+	Weights ExposureWeights
+}
+
+// Transform implements Transformer
+func (s ScoreExposure) Transform(input Nodes) Nodes {
+	exposures := s.Exposures(input.Nodes)
+	if len(exposures) == 0 {
+		return input
+	}
+	output := make(report.Nodes, len(input.Nodes))
+	now := mtime.Now()
+	for id, n := range input.Nodes {
+		if exposure, ok := exposures[id]; ok {
+			n = n.WithLatest(ExposureScore, now, strconv.FormatFloat(exposure.Score, 'f', -1, 64))
+		}
+		output[id] = n
+	}
+	return Nodes{Nodes: output, Filtered: input.Filtered}
+}
+
+// Exposures returns the Exposure of the container and pod nodes, by ID.
+func (s ScoreExposure) Exposures(nodes report.Nodes) map[string]Exposure {
+	internet := internetInbound(nodes)
+	result := map[string]Exposure{}
+	for id, n := range nodes {
+		if n.Topology == report.Container || n.Topology == report.Pod {
+			result[id] = s.Score(n, internet[id])
+		}
+	}
+	return result
+}
+
+// internetInbound returns the IDs of the nodes with connections from the
+// internet, or from cloud services or names in it.
+func internetInbound(nodes report.Nodes) map[string]bool {
+	result := map[string]bool{}
+	for id, n := range nodes {
+		incoming, kind, _, external := ParseExternalNodeID(id)
+		if id != IncomingInternetID && !(external && incoming && kind != ExternalInternal) {
+			continue
+		}
+		for _, adj := range n.Adjacency {
+			result[adj] = true
+		}
+	}
+	return result
+}
+
+// Score returns the Exposure of a container or pod node.
+func (s ScoreExposure) Score(n report.Node, internetInbound bool) Exposure {
+	var e Exposure
+	if s.externallyExposed(n) {
+		e.add(ExposureExternallyExposed, s.Weights.ExternallyExposed)
+	}
+	if internetInbound {
+		e.add(ExposureInternetInbound, s.Weights.InternetInbound)
+	}
+	var containers []report.Node
+	if n.Topology == report.Pod {
+		n.Children.ForEach(func(child report.Node) {
+			if child.Topology == report.Container {
+				containers = append(containers, child)
+			}
+		})
+	} else {
+		containers = []report.Node{n}
+	}
+	var vulnerabilities, privileged, hostNamespaces, posture float64
+	for _, c := range containers {
+		vulnerabilities = math.Max(vulnerabilities, s.vulnerabilities(c))
+		if isTrue(c, report.DockerPrivileged) {
+			privileged = s.Weights.Privileged
+		}
+		if isTrue(c, report.DockerHostNetwork) || isTrue(c, report.DockerHostPID) {
+			hostNamespaces = s.Weights.HostNamespaces
+		}
+		if value, ok := c.Latest.Lookup(report.DockerSecurityScore); ok {
+			if score, err := strconv.Atoi(value); err == nil && score >= 0 && score <= 100 {
+				posture = math.Max(posture, s.Weights.Posture*float64(100-score)/100)
+			}
+		}
+	}
+	e.add(ExposureVulnerabilities, vulnerabilities)
+	e.add(ExposurePrivileged, privileged)
+	e.add(ExposureHostNamespaces, hostNamespaces)
+	e.add(ExposurePosture, posture)
+	e.Score = roundScore(e.Score)
+	return e
+}
+
+// externallyExposed says whether a pod, or the pod of a container, is
+// reachable from outside the cluster.
+func (s ScoreExposure) externallyExposed(n report.Node) bool {
+	if isTrue(n, report.KubernetesExternallyExposed) {
+		return true
+	}
+	if n.Topology != report.Container {
+		return false
+	}
+	pods, _ := n.Parents.Lookup(report.Pod)
+	for _, podID := range pods {
+		if pod, ok := s.Report.Pod.Nodes[podID]; ok && isTrue(pod, report.KubernetesExternallyExposed) {
+			return true
+		}
+	}
+	return false
+}
+
+// vulnerabilities weighs the vulnerabilities of the image of a container,
+// found by its latest vulnerability scan.
+func (s ScoreExposure) vulnerabilities(c report.Node) float64 {
+	scan, ok := c.LookupScanStatus(report.VulnerabilityScan)
+	if !ok {
+		imageID, _ := c.Latest.Lookup(report.DockerImageID)
+		image, found := s.Report.ContainerImage.Nodes[report.MakeContainerImageNodeID(imageID)]
+		if !found {
+			return 0
+		}
+		if scan, ok = image.LookupScanStatus(report.VulnerabilityScan); !ok {
+			return 0
+		}
+	}
+	var weight float64
+	for _, severity := range Severities[:4] {
+		for name, count := range scan.Counts {
+			if strings.ToLower(name) == severity {
+				weight += s.Weights.Severities[severity] * float64(count)
+			}
+		}
+	}
+	return math.Min(weight, s.Weights.MaxVulnerabilities)
+}
+
+func isTrue(n report.Node, key string) bool {
+	value, _ := n.Latest.Lookup(key)
+	return value == "true"
+}
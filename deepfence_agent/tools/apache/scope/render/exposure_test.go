@@ -0,0 +1,193 @@
+package render_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+var exposureScanTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func exposureContainer(id string, latests map[string]string, counts map[string]int) report.Node {
+	n := report.MakeNodeWith(report.MakeContainerNodeID(id), latests).WithTopology(report.Container)
+	if counts != nil {
+		n = n.WithScanStatus(report.ScanStatus{
+			Type:     report.VulnerabilityScan,
+			Status:   report.ScanStatusComplete,
+			Counts:   counts,
+			LastScan: exposureScanTime,
+		})
+	}
+	return n
+}
+
+// exposureFixture is a graph of:
+//   - web, a privileged container of an exposed pod, with 3 critical
+//     vulnerabilities, taking connections from the internet
+//   - api, a container of the same pod, in the host network, whose image
+//     has 10 high vulnerabilities, not scanned as the container
+//   - db, a container with a security score of 60, connected to from api
+//     and from a private network
+//   - the pod of web and api
+func exposureFixture() (report.Report, report.Nodes) {
+	podID := report.MakePodNodeID("pod-1")
+	rpt := report.MakeReport()
+	rpt.Pod.AddNode(report.MakeNodeWith(podID, map[string]string{
+		report.KubernetesExternallyExposed: "true",
+	}).WithTopology(report.Pod))
+	rpt.ContainerImage.AddNode(report.MakeNode(report.MakeContainerImageNodeID("api-image")).
+		WithTopology(report.ContainerImage).
+		WithScanStatus(report.ScanStatus{
+			Type:     report.VulnerabilityScan,
+			Status:   report.ScanStatusComplete,
+			Counts:   map[string]int{"High": 10},
+			LastScan: exposureScanTime,
+		}))
+
+	web := exposureContainer("web", map[string]string{
+		report.DockerPrivileged:    "true",
+		report.DockerSecurityScore: "30",
+	}, map[string]int{"critical": 3, "low": 5}).
+		WithParent(report.Pod, podID).
+		WithAdjacent(report.MakeContainerNodeID("api"))
+	api := exposureContainer("api", map[string]string{
+		report.DockerImageID:     "api-image",
+		report.DockerHostNetwork: "true",
+	}, nil).
+		WithParent(report.Pod, podID).
+		WithAdjacent(report.MakeContainerNodeID("db"))
+	db := exposureContainer("db", map[string]string{
+		report.DockerSecurityScore: "60",
+	}, map[string]int{})
+	pod := rpt.Pod.Nodes[podID].WithChildren(report.MakeNodeSet(web, api))
+
+	internal := render.MakeExternalNodeID(true, render.ExternalInternal, "")
+	nodes := report.Nodes{
+		web.ID: web,
+		api.ID: api,
+		db.ID:  db,
+		pod.ID: pod,
+		render.IncomingInternetID: report.MakeNode(render.IncomingInternetID).
+			WithTopology(render.Pseudo).
+			WithAdjacent(web.ID).
+			WithAdjacent(pod.ID),
+		internal: report.MakeNode(internal).WithTopology(render.Pseudo).WithAdjacent(db.ID),
+	}
+	return rpt, nodes
+}
+
+func TestScoreExposure(t *testing.T) {
+	rpt, nodes := exposureFixture()
+	scorer := render.ScoreExposure{Report: rpt, Weights: render.DefaultExposureWeights}
+	want := map[string]render.Exposure{
+		report.MakeContainerNodeID("web"): {Score: 84, Factors: map[string]float64{
+			render.ExposureExternallyExposed: 25,
+			render.ExposureInternetInbound:   25,
+			render.ExposureVulnerabilities:   15.5, // 3 * 5 + 5 * 0.1
+			render.ExposurePrivileged:        15,
+			render.ExposurePosture:           3.5, // 5 * (100 - 30) / 100
+		}},
+		report.MakeContainerNodeID("api"): {Score: 50, Factors: map[string]float64{
+			render.ExposureExternallyExposed: 25,
+			render.ExposureVulnerabilities:   20, // of its image
+			render.ExposureHostNamespaces:    5,
+		}},
+		report.MakeContainerNodeID("db"): {Score: 2, Factors: map[string]float64{
+			render.ExposurePosture: 2,
+		}},
+		report.MakePodNodeID("pod-1"): {Score: 93.5, Factors: map[string]float64{
+			render.ExposureExternallyExposed: 25,
+			render.ExposureInternetInbound:   25,
+			render.ExposureVulnerabilities:   20,
+			render.ExposurePrivileged:        15,
+			render.ExposureHostNamespaces:    5,
+			render.ExposurePosture:           3.5,
+		}},
+	}
+	// Deterministic, whatever order the nodes are walked in
+	for i := 0; i < 10; i++ {
+		if have := scorer.Exposures(nodes); !reflect.DeepEqual(want, have) {
+			t.Fatalf("want %v, have %v", want, have)
+		}
+	}
+
+	output := scorer.Transform(render.Nodes{Nodes: nodes, Filtered: 1})
+	if output.Filtered != 1 || len(output.Nodes) != len(nodes) {
+		t.Errorf("Expected all the nodes, have %v", output)
+	}
+	for id, exposure := range want {
+		score, _ := output.Nodes[id].Latest.Lookup(render.ExposureScore)
+		if want := strconv.FormatFloat(exposure.Score, 'f', -1, 64); score != want {
+			t.Errorf("%s: expected a score of %s, have %q", id, want, score)
+		}
+	}
+	if _, ok := output.Nodes[render.IncomingInternetID].Latest.Lookup(render.ExposureScore); ok {
+		t.Error("Expected pseudo nodes not to be scored")
+	}
+}
+
+func TestScoreExposureWeights(t *testing.T) {
+	rpt, nodes := exposureFixture()
+	// Only internet connections count
+	weights := render.ExposureWeights{InternetInbound: 10}
+	have := render.ScoreExposure{Report: rpt, Weights: weights}.Exposures(nodes)
+	for id, score := range map[string]float64{
+		report.MakeContainerNodeID("web"): 10,
+		report.MakeContainerNodeID("api"): 0,
+		report.MakeContainerNodeID("db"):  0,
+		report.MakePodNodeID("pod-1"):     10,
+	} {
+		if have[id].Score != score {
+			t.Errorf("%s: want %v, have %v", id, score, have[id])
+		}
+	}
+}
+
+func TestLoadExposureWeights(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exposure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	weights, err := render.LoadExposureWeights(write("partial.json", `{"internet_inbound": 40, "privileged": 0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := render.DefaultExposureWeights
+	want.InternetInbound, want.Privileged = 40, 0
+	if !reflect.DeepEqual(want, weights) {
+		t.Errorf("want %+v, have %+v", want, weights)
+	}
+
+	weights, err = render.LoadExposureWeights(write("severities.json", `{"severities": {"critical": 10}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(weights.Severities, map[string]float64{"critical": 10}) {
+		t.Errorf("Expected the severities of the file, have %v", weights.Severities)
+	}
+
+	for _, content := range []string{`{"internet": 40}`, `{"privileged": "high"}`, `not json`} {
+		if _, err := render.LoadExposureWeights(write("bad.json", content)); err == nil {
+			t.Errorf("Expected %s to be refused", content)
+		}
+	}
+	if _, err := render.LoadExposureWeights(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected a missing file to be refused")
+	}
+}
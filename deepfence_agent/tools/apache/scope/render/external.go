@@ -0,0 +1,240 @@
+package render
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Kinds of external destination, which external pseudo nodes are split by,
+// apart from the rest of the internet.
+const (
+	ExternalCloud    = "cloud"    // the published IP ranges of a cloud service
+	ExternalDNS      = "dns"      // a name the address was looked up by
+	ExternalInternal = "internal" // a private network the probes don't see
+)
+
+// ExternalInternalMajor is the label of the pseudo nodes of private networks.
+const ExternalInternalMajor = "Unmanaged internal"
+
+// ExternalID is the ID of the pseudo nodes of external destinations.
+const ExternalID = "external"
+
+// ExternalIDPrefix is the prefix of the IDs of the pseudo nodes of external
+// destinations, other than the internet ones.
+var ExternalIDPrefix = MakePseudoNodeID(ExternalID, "")
+
+// ConnectionCount is the key in Node.Latest of the number of connections of
+// the pseudo nodes of external destinations, including the internet ones.
+const ConnectionCount = "connection_count"
+
+// NewExternalDestination is the key in Node.Latest of the external
+// destinations a workload started talking to after learning those it talks
+// to, comma separated, as flagged by the app.
+const NewExternalDestination = "new_external_destination"
+
+// MakeExternalNodeID returns the ID of the pseudo node of an external
+// destination, apart for incoming and outgoing connections, as the internet
+// nodes are.
+func MakeExternalNodeID(incoming bool, kind, name string) string {
+	direction := "out"
+	if incoming {
+		direction = "in"
+	}
+	return MakePseudoNodeID(ExternalID, direction, kind, name)
+}
+
+// ParseExternalNodeID parses the ID of the pseudo node of an external
+// destination.
+func ParseExternalNodeID(nodeID string) (incoming bool, kind, name string, ok bool) {
+	if !strings.HasPrefix(nodeID, ExternalIDPrefix) {
+		return false, "", "", false
+	}
+	parts := strings.SplitN(nodeID[len(ExternalIDPrefix):], ":", 3)
+	if len(parts) != 3 {
+		return false, "", "", false
+	}
+	return parts[0] == "in", parts[1], parts[2], true
+}
+
+// IsExternalNode checks if the node is the pseudo node of an external
+// destination, including the internet.
+func IsExternalNode(n report.Node) bool {
+	return IsInternetNode(n) || strings.HasPrefix(n.ID, ExternalIDPrefix)
+}
+
+// CloudRange is a published IP range of a cloud service.
+type CloudRange struct {
+	Provider string // such as "AWS"
+	Service  string // such as "S3"
+	Region   string
+	Net      *net.IPNet
+}
+
+// cloudRanges are CloudRanges by the length of their prefix, and the
+// address of their network, with the lengths, longest first.
+type cloudRanges struct {
+	byPrefix map[int]map[string]CloudRange
+	prefixes []int
+}
+
+var knownCloudRanges atomic.Value // *cloudRanges
+
+// SetCloudRanges sets the published IP ranges of cloud services external
+// destinations are matched against, in place of those set before.  Where
+// ranges overlap, the narrowest matches, and then the first given.
+func SetCloudRanges(ranges []CloudRange) {
+	result := &cloudRanges{byPrefix: map[int]map[string]CloudRange{}}
+	for _, r := range ranges {
+		if r.Net == nil {
+			continue
+		}
+		ones, _ := r.Net.Mask.Size()
+		byNet, ok := result.byPrefix[ones]
+		if !ok {
+			byNet = map[string]CloudRange{}
+			result.byPrefix[ones] = byNet
+			result.prefixes = append(result.prefixes, ones)
+		}
+		key := string(r.Net.IP)
+		if _, ok := byNet[key]; !ok {
+			byNet[key] = r
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(result.prefixes)))
+	knownCloudRanges.Store(result)
+}
+
+// LookupCloudRange returns the cloud range an IP address is in, if any.
+func LookupCloudRange(ip net.IP) (CloudRange, bool) {
+	ranges, _ := knownCloudRanges.Load().(*cloudRanges)
+	if ranges == nil {
+		return CloudRange{}, false
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	for _, ones := range ranges.prefixes {
+		if ones > bits {
+			continue
+		}
+		network := ip.Mask(net.CIDRMask(ones, bits))
+		if r, ok := ranges.byPrefix[ones][string(network)]; ok && len(r.Net.IP) == len(network) {
+			return r, true
+		}
+	}
+	return CloudRange{}, false
+}
+
+var privateNetworks = func() []*net.IPNet {
+	var result []*net.IPNet
+	// RFC1918; external addresses are only parsed as IPv4
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		result = append(result, network)
+	}
+	return result
+}()
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// externalDestinationID returns the ID of the pseudo node of the external
+// destination of an endpoint at an address outside the local networks: the
+// cloud service whose range it's in, or else the name it was looked up by,
+// or else the private networks, or else the internet.
+func externalDestinationID(rpt report.Report, n report.Node, ip net.IP) string {
+	incoming := len(n.Adjacency) > 0
+	if r, ok := LookupCloudRange(ip); ok {
+		return MakeExternalNodeID(incoming, ExternalCloud, r.Provider+"/"+r.Service)
+	}
+	_, addr, _, _ := report.ParseEndpointNodeID(n.ID)
+	// Only forward names, as reverse ones tend to name the host, not the
+	// service
+	if names := rpt.DNS[addr].Forward; len(names) > 0 {
+		return MakeExternalNodeID(incoming, ExternalDNS, names[0])
+	}
+	if isPrivateIP(ip) {
+		return MakeExternalNodeID(incoming, ExternalInternal, "")
+	}
+	if incoming {
+		return IncomingInternetID
+	}
+	return OutgoingInternetID
+}
+
+// countConnections sets the ConnectionCount of the external pseudo nodes in
+// ret, mapped from the endpoints: their edges, either way.
+func countConnections(ret joinResults, endpoints report.Nodes) {
+	counts := map[string]int{}
+	for _, n := range endpoints {
+		from := ret.mapped[n.ID]
+		if _, ok := ret.nodes[from]; ok && IsExternalNode(ret.nodes[from]) {
+			counts[from] += len(n.Adjacency)
+		}
+		for _, adj := range n.Adjacency {
+			if to, ok := ret.mapped[adj]; ok && to != from && IsExternalNode(ret.nodes[to]) {
+				counts[to]++
+			}
+		}
+	}
+	now := mtime.Now()
+	for id, count := range counts {
+		ret.nodes[id] = ret.nodes[id].WithLatest(ConnectionCount, now, strconv.Itoa(count))
+	}
+}
+
+// MergeExternal is a transformer merging the pseudo nodes of external
+// destinations into the incoming and outgoing internet nodes, with their
+// children, adding up their connections.
+type MergeExternal struct{}
+
+// Transform implements Transformer
+func (MergeExternal) Transform(input Nodes) Nodes {
+	ret := newJoinResults(nil)
+	var external []report.Node
+	for _, n := range input.Nodes {
+		if _, _, _, ok := ParseExternalNodeID(n.ID); ok {
+			external = append(external, n)
+			continue
+		}
+		ret.passThrough(n)
+	}
+	if len(external) == 0 {
+		return input
+	}
+	now := mtime.Now()
+	for _, n := range external {
+		id := OutgoingInternetID
+		if incoming, _, _, _ := ParseExternalNodeID(n.ID); incoming {
+			id = IncomingInternetID
+		}
+		internet, ok := ret.nodes[id]
+		if !ok {
+			internet = report.MakeNode(id).WithTopology(Pseudo)
+		}
+		internet.Children.UnsafeMerge(n.Children)
+		count, _ := internet.Latest.Lookup(ConnectionCount)
+		total, _ := strconv.Atoi(count)
+		count, _ = n.Latest.Lookup(ConnectionCount)
+		c, _ := strconv.Atoi(count)
+		ret.nodes[id] = internet.WithLatest(ConnectionCount, now, strconv.Itoa(total+c))
+		ret.mapChild(n.ID, id)
+	}
+	output := ret.result(input)
+	output.Filtered = input.Filtered
+	return output
+}
@@ -0,0 +1,180 @@
+package render_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func cloudRange(provider, service, cidr string) render.CloudRange {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return render.CloudRange{Provider: provider, Service: service, Net: network}
+}
+
+func TestLookupCloudRange(t *testing.T) {
+	render.SetCloudRanges([]render.CloudRange{
+		cloudRange("AWS", "", "52.0.0.0/8"),
+		cloudRange("AWS", "S3", "52.216.0.0/15"),
+		cloudRange("AWS", "EC2", "52.216.0.0/15"),
+		cloudRange("GCP", "", "2600:1900::/35"),
+	})
+	defer render.SetCloudRanges(nil)
+
+	for ip, want := range map[string]string{
+		"52.216.1.1":     "S3", // the narrowest, and then the first given
+		"52.1.1.1":       "",
+		"2600:1900::1":   "GCP",
+		"8.8.8.8":        "none",
+		"::ffff:8.8.8.8": "none",
+	} {
+		have := "none"
+		if r, ok := render.LookupCloudRange(net.ParseIP(ip)); ok {
+			have = r.Service
+			if r.Service == "" && r.Provider == "GCP" {
+				have = r.Provider
+			}
+		}
+		if want != have {
+			t.Errorf("%s: want %q, have %q", ip, want, have)
+		}
+	}
+}
+
+// externalReport is a report of a process connecting out to each address,
+// once for each port, and the process connected to from an address.
+func externalReport(incoming string, outgoing map[string]int) report.Report {
+	var (
+		rpt       = report.MakeReport()
+		hostID    = report.MakeHostNodeID("host")
+		processID = report.MakeProcessNodeID("host", "1")
+		port      = 10000
+	)
+	rpt.Host.AddNode(report.MakeNode(hostID).WithTopology(report.Host).
+		WithSets(report.MakeSets().Add(report.HostLocalNetworks, report.MakeStringSet("192.168.0.0/16"))))
+	rpt.Process.AddNode(report.MakeNodeWith(processID, map[string]string{
+		report.PID:        "1",
+		report.HostNodeID: hostID,
+	}).WithTopology(report.Process))
+	local := func() report.Node {
+		port++
+		return report.MakeNodeWith(report.MakeEndpointNodeID("host", "", "192.168.1.1", fmt.Sprint(port)), map[string]string{
+			report.PID:        "1",
+			report.HostNodeID: hostID,
+		}).WithTopology(report.Endpoint)
+	}
+	for addr, count := range outgoing {
+		remoteID := report.MakeEndpointNodeID("", "", addr, "443")
+		rpt.Endpoint.AddNode(report.MakeNode(remoteID).WithTopology(report.Endpoint))
+		for i := 0; i < count; i++ {
+			rpt.Endpoint.AddNode(local().WithAdjacent(remoteID))
+		}
+	}
+	server := local()
+	rpt.Endpoint.AddNode(server)
+	rpt.Endpoint.AddNode(report.MakeNode(report.MakeEndpointNodeID("", "", incoming, "50000")).
+		WithTopology(report.Endpoint).WithAdjacent(server.ID))
+	return rpt
+}
+
+func connectionCounts(nodes report.Nodes) map[string]string {
+	counts := map[string]string{}
+	for id, n := range nodes {
+		if count, ok := n.Latest.Lookup(render.ConnectionCount); ok {
+			counts[id] = count
+		}
+	}
+	return counts
+}
+
+func TestExternalDestinations(t *testing.T) {
+	render.SetCloudRanges([]render.CloudRange{
+		cloudRange("AWS", "", "52.0.0.0/8"),
+		cloudRange("AWS", "S3", "52.216.0.0/15"),
+	})
+	defer render.SetCloudRanges(nil)
+
+	rpt := externalReport("5.6.7.8", map[string]int{
+		"52.216.1.1": 2,
+		"52.1.1.1":   1,
+		"1.1.1.1":    1,
+		"10.1.1.1":   1,
+		"1.2.3.4":    1,
+	})
+	rpt.DNS["1.1.1.1"] = report.DNSRecord{Forward: report.MakeStringSet("one.one.one.one")}
+
+	have := render.ProcessRenderer.Render(context.Background(), rpt).Nodes
+	want := map[string]string{
+		render.MakeExternalNodeID(false, render.ExternalCloud, "AWS/S3"):        "2",
+		render.MakeExternalNodeID(false, render.ExternalCloud, "AWS/"):          "1",
+		render.MakeExternalNodeID(false, render.ExternalDNS, "one.one.one.one"): "1",
+		render.MakeExternalNodeID(false, render.ExternalInternal, ""):           "1",
+		render.OutgoingInternetID: "1",
+		render.IncomingInternetID: "1",
+	}
+	if counts := connectionCounts(have); !reflect.DeepEqual(want, counts) {
+		t.Error(test.Diff(want, counts))
+	}
+	process := have[report.MakeProcessNodeID("host", "1")]
+	if len(process.Adjacency) != 5 {
+		t.Errorf("Expected the process to connect to 5 destinations, have %v", process.Adjacency)
+	}
+
+	// Falling back to the internet nodes adds up their connections
+	merged := render.MergeExternal{}.Transform(render.Nodes{Nodes: have}).Nodes
+	want = map[string]string{
+		render.OutgoingInternetID: "6",
+		render.IncomingInternetID: "1",
+	}
+	if counts := connectionCounts(merged); !reflect.DeepEqual(want, counts) {
+		t.Error(test.Diff(want, counts))
+	}
+	process = merged[report.MakeProcessNodeID("host", "1")]
+	if !reflect.DeepEqual(report.MakeIDList(render.OutgoingInternetID), process.Adjacency) {
+		t.Errorf("Expected the process to connect to the internet, have %v", process.Adjacency)
+	}
+	if children := merged[render.OutgoingInternetID].Children; children.Size() != 5 {
+		t.Errorf("Expected the outgoing endpoints as children of the internet, have %v", children)
+	}
+}
+
+func TestParseExternalNodeID(t *testing.T) {
+	id := render.MakeExternalNodeID(true, render.ExternalDNS, "api.example.com:8443")
+	incoming, kind, name, ok := render.ParseExternalNodeID(id)
+	if !ok || !incoming || kind != render.ExternalDNS || name != "api.example.com:8443" {
+		t.Errorf("%s: have %v, %q, %q, %v", id, incoming, kind, name, ok)
+	}
+	if _, _, _, ok := render.ParseExternalNodeID(render.OutgoingInternetID); ok {
+		t.Error("Expected the internet not to parse")
+	}
+}
+
+func TestFilterExternalAdjacencies(t *testing.T) {
+	// Edges from incoming external nodes to outgoing ones are artifacts,
+	// whichever the nodes are
+	in := render.MakeExternalNodeID(true, render.ExternalDNS, "in.example.com")
+	out := render.MakeExternalNodeID(false, render.ExternalCloud, "AWS/S3")
+	process := report.MakeProcessNodeID("host", "1")
+	nodes := report.Nodes{
+		in:                        report.MakeNode(in).WithTopology(render.Pseudo).WithAdjacent(out, process),
+		render.IncomingInternetID: report.MakeNode(render.IncomingInternetID).WithTopology(render.Pseudo).WithAdjacent(render.OutgoingInternetID, out),
+		render.OutgoingInternetID: report.MakeNode(render.OutgoingInternetID).WithTopology(render.Pseudo),
+		out:                       report.MakeNode(out).WithTopology(render.Pseudo),
+		process:                   report.MakeNode(process).WithTopology(report.Process),
+	}
+	have := render.FilterUnconnected.Transform(render.Nodes{Nodes: nodes}).Nodes
+	if _, ok := have[out]; ok {
+		t.Errorf("Expected %s to be unconnected, have %v", out, have[out])
+	}
+	if adjacency := have[in].Adjacency; !reflect.DeepEqual(report.MakeIDList(process), adjacency) {
+		t.Errorf("Expected only the edge to the process, have %v", adjacency)
+	}
+}
@@ -164,23 +164,37 @@ func connected(nodes report.Nodes) map[string]struct{} {
 	return res
 }
 
-// filterInternetAdjacencies filters out edges between the incoming
-// and outgoing internet node. These are typically artifacts of
-// imperfect connection tracking, e.g. when VIPs and NAT traversal are
-// in use.
+// filterInternetAdjacencies filters out edges from the incoming internet
+// and external destination nodes to the outgoing ones. These are typically
+// artifacts of imperfect connection tracking, e.g. when VIPs and NAT
+// traversal are in use.
 func filterInternetAdjacencies(nodes report.Nodes) {
-	incomingInternet, ok := nodes[IncomingInternetID]
-	if !ok {
-		return
-	}
-	newAdjacency := report.MakeIDList()
-	for _, dstID := range incomingInternet.Adjacency {
-		if dstID != OutgoingInternetID {
-			newAdjacency = newAdjacency.Add(dstID)
+	for id, n := range nodes {
+		if incoming, ok := externalDirection(id); !ok || !incoming {
+			continue
 		}
+		newAdjacency := report.MakeIDList()
+		for _, dstID := range n.Adjacency {
+			if incoming, ok := externalDirection(dstID); !ok || incoming {
+				newAdjacency = newAdjacency.Add(dstID)
+			}
+		}
+		n.Adjacency = newAdjacency
+		nodes[id] = n
+	}
+}
+
+// externalDirection returns whether the ID is of an incoming or outgoing
+// internet or external destination node, or false if it's of neither.
+func externalDirection(id string) (incoming bool, ok bool) {
+	switch id {
+	case IncomingInternetID:
+		return true, true
+	case OutgoingInternetID:
+		return false, true
 	}
-	incomingInternet.Adjacency = newAdjacency
-	nodes[IncomingInternetID] = incomingInternet
+	incoming, _, _, ok = ParseExternalNodeID(id)
+	return incoming, ok
 }
 
 // ColorConnected colors nodes with the IsConnectedMark key if they
@@ -237,6 +251,20 @@ func IsRunning(n report.Node) bool {
 // IsStopped checks if the node is *not* a running docker container
 var IsStopped = Complement(IsRunning)
 
+// HasSecrets checks if the latest secret scan of the node found any secrets
+func HasSecrets(n report.Node) bool {
+	scan, ok := n.LookupScanStatus(report.SecretScan)
+	if !ok {
+		return false
+	}
+	for _, count := range scan.Counts {
+		if count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // IsApplication checks if the node is an "application" node
 func IsApplication(n report.Node) bool {
 	containerName, _ := n.Latest.Lookup(report.DockerContainerName)
@@ -295,9 +323,9 @@ func DoesNotHaveLabel(labelKey string, labelValue string) FilterFunc {
 }
 
 // IsNotPseudo returns true if the node is not a pseudo node
-// or internet/service nodes.
+// or external/service nodes.
 func IsNotPseudo(n report.Node) bool {
-	return n.Topology != Pseudo || IsInternetNode(n) || strings.HasPrefix(n.ID, ServiceNodeIDPrefix)
+	return n.Topology != Pseudo || IsExternalNode(n) || strings.HasPrefix(n.ID, ServiceNodeIDPrefix)
 }
 
 var (
@@ -308,7 +336,7 @@ var (
 		report.Pod:               {report.Host: struct{}{}, report.KubernetesCluster: struct{}{}, report.CloudRegion: struct{}{}, report.CloudProvider: struct{}{}},
 		report.Service:           {report.KubernetesCluster: struct{}{}, report.CloudProvider: struct{}{}},
 		report.Host:              {report.KubernetesCluster: struct{}{}, report.CloudRegion: struct{}{}, report.CloudProvider: struct{}{}},
-		report.KubernetesCluster: {report.CloudProvider: struct{}{}},
+		report.KubernetesCluster: {report.CloudRegion: struct{}{}, report.CloudProvider: struct{}{}},
 		report.CloudRegion:       {report.CloudProvider: struct{}{}},
 	}
 )
@@ -91,16 +91,12 @@ func externalNodeID(rpt report.Report, n report.Node, addr string, local report.
 		return ServiceNodeIDPrefix + hostname, true
 	}
 
-	// If the dstNodeAddr is not in a network local to this report, we emit an
-	// internet pseudoNode
+	// If the dstNodeAddr is not in a network local to this report, we emit a
+	// pseudoNode of where it is
 	// Create a buffer on the stack of this function, so we don't need to allocate in ParseIP
 	var into [5]byte // one extra byte to save a memory allocation in critbitgo
 	if ip := report.ParseIP([]byte(addr), into[:4]); ip != nil && !local.Contains(ip) {
-		// emit one internet node for incoming, one for outgoing
-		if len(n.Adjacency) > 0 {
-			return IncomingInternetID, true
-		}
-		return OutgoingInternetID, true
+		return externalDestinationID(rpt, n, ip), true
 	}
 
 	// The node is not external
@@ -0,0 +1,177 @@
+package render
+
+import (
+	"context"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// UnknownNamespace is the namespace of pods whose namespace isn't reported,
+// such as those of nodes running only CRI, and of containers outside pods.
+const UnknownNamespace = "unknown"
+
+// namespacedTopologies are the topologies of kubernetes objects which live
+// in a namespace, and clusteredTopologies those of the rest of a cluster.
+var (
+	namespacedTopologies = map[string]struct{}{
+		report.Pod: {}, report.Service: {}, report.Deployment: {}, report.ReplicaSet: {}, report.DaemonSet: {},
+		report.StatefulSet: {}, report.CronJob: {}, report.Job: {}, report.PersistentVolumeClaim: {},
+		report.Ingress: {}, report.CustomResource: {}, report.VolumeSnapshot: {},
+	}
+	clusteredTopologies = map[string]struct{}{
+		report.PersistentVolume: {}, report.StorageClass: {}, report.VolumeSnapshotData: {},
+	}
+)
+
+// KubernetesScope is the kubernetes namespace and cluster a render is
+// scoped to, by name; either is unscoped if empty.  Unlike filters, which
+// apply to the rendered nodes, the report is pruned to the scope before it
+// is rendered, so the joins only ever see the nodes in scope.
+type KubernetesScope struct {
+	Namespace string
+	Cluster   string
+}
+
+// Scoped returns a renderer rendering the report pruned to a scope.
+func Scoped(scope KubernetesScope, r Renderer) Renderer {
+	if scope == (KubernetesScope{}) {
+		return r
+	}
+	return scopedRenderer{scope: scope, Renderer: r}
+}
+
+type scopedRenderer struct {
+	Renderer
+	scope KubernetesScope
+}
+
+// Render implements Renderer
+func (r scopedRenderer) Render(ctx context.Context, rpt report.Report) Nodes {
+	return r.Renderer.Render(ctx, r.scope.Prune(rpt))
+}
+
+// namespaceOf returns the namespace of a node, from its kubernetes metadata
+// or the labels of its container, or else UnknownNamespace.  Swarm stacks
+// are taken to be namespaces too, as the namespace option picks them.
+func namespaceOf(n report.Node) string {
+	for _, key := range []string{report.KubernetesNamespace, report.DockerLabelPrefix + k8sNamespaceLabel, report.DockerLabelPrefix + swarmNamespaceLabel} {
+		if value, ok := n.Latest.Lookup(key); ok && value != "" {
+			return value
+		}
+	}
+	return UnknownNamespace
+}
+
+func (s KubernetesScope) inNamespace(n report.Node) bool {
+	if s.Namespace == "" {
+		return true
+	}
+	namespace := namespaceOf(n)
+	return namespace == s.Namespace || (namespace == UnknownNamespace && s.Namespace == report.DockerDefaultNamespace)
+}
+
+// Prune returns rpt with only the nodes in scope, and an ID of its own so
+// that renders of it are cached apart from those of rpt.  The topologies of
+// the kubernetes objects are pruned to the scope, and objects outside any
+// namespace are in no namespace.  So are containers, by their pod or else
+// their labels and host, and processes and endpoints by their container, or
+// host when outside one.  Hosts are pruned to the cluster only.  rpt is not
+// modified.
+func (s KubernetesScope) Prune(rpt report.Report) report.Report {
+	if s == (KubernetesScope{}) {
+		return rpt
+	}
+	// Clusters are reported by ID, and named in their nodes
+	clusters := map[string]struct{}{}
+	if s.Cluster != "" {
+		clusters[report.MakeKubernetesClusterNodeID(s.Cluster)] = struct{}{}
+		for id, n := range rpt.KubernetesCluster.Nodes {
+			if name, _ := n.Latest.Lookup(report.KubernetesClusterName); name == s.Cluster {
+				clusters[id] = struct{}{}
+			}
+		}
+	}
+	inCluster := func(n report.Node) bool {
+		if s.Cluster == "" {
+			return true
+		}
+		ids, _ := n.Parents.Lookup(report.KubernetesCluster)
+		for _, id := range ids {
+			if _, ok := clusters[id]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := rpt
+	out.ID = rpt.ID + ";namespace=" + s.Namespace + ";cluster=" + s.Cluster
+	out.KubernetesCluster = pruneTopology(rpt.KubernetesCluster, func(n report.Node) bool {
+		_, ok := clusters[n.ID]
+		return s.Cluster == "" || ok
+	})
+	out.Namespace = pruneTopology(rpt.Namespace, func(n report.Node) bool {
+		name, _ := n.Latest.Lookup(report.KubernetesName)
+		return inCluster(n) && (s.Namespace == "" || name == s.Namespace)
+	})
+	out.WalkNamedTopologies(func(name string, t *report.Topology) {
+		if _, ok := namespacedTopologies[name]; ok {
+			*t = pruneTopology(*t, func(n report.Node) bool {
+				return inCluster(n) && s.inNamespace(n)
+			})
+		} else if _, ok := clusteredTopologies[name]; ok {
+			// These are in no namespace
+			*t = pruneTopology(*t, func(n report.Node) bool {
+				return inCluster(n) && s.Namespace == ""
+			})
+		}
+	})
+	out.Host = pruneTopology(rpt.Host, inCluster)
+
+	onHost := func(n report.Node) bool {
+		_, ok := out.Host.Nodes[report.MakeHostNodeID(report.ExtractHostID(n))]
+		return ok
+	}
+	out.Container = pruneTopology(rpt.Container, func(n report.Node) bool {
+		if pods, ok := n.Parents.Lookup(report.Pod); ok {
+			// By the pod it's in, if that's reported
+			for _, pod := range pods {
+				if _, ok := rpt.Pod.Nodes[pod]; ok {
+					_, ok = out.Pod.Nodes[pod]
+					return ok
+				}
+			}
+		}
+		return s.inNamespace(n) && (s.Cluster == "" || onHost(n))
+	})
+	out.Process = pruneTopology(rpt.Process, func(n report.Node) bool {
+		if containerID, ok := n.Latest.Lookup(report.DockerContainerID); ok {
+			_, ok := out.Container.Nodes[report.MakeContainerNodeID(containerID)]
+			return ok
+		}
+		// Processes outside containers are in no namespace
+		return s.Namespace == "" && onHost(n)
+	})
+	out.Endpoint = pruneTopology(rpt.Endpoint, func(n report.Node) bool {
+		// Endpoints of processes pruned would make them up again
+		pid, ok := n.Latest.Lookup(report.PID)
+		if !ok {
+			return true
+		}
+		_, ok = out.Process.Nodes[report.MakeProcessNodeID(report.ExtractHostID(n), pid)]
+		return ok
+	})
+	return out
+}
+
+// pruneTopology returns t with only the nodes kept.  t is not modified.
+func pruneTopology(t report.Topology, keep func(report.Node) bool) report.Topology {
+	nodes := make(report.Nodes, len(t.Nodes))
+	for id, n := range t.Nodes {
+		if keep(n) {
+			nodes[id] = n
+		}
+	}
+	t.Nodes = nodes
+	return t
+}
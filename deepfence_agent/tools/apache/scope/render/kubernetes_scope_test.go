@@ -0,0 +1,146 @@
+package render_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// namespacedReport is a report of a cluster of a host running pods in each
+// of namespaces, with a container of a process each, and a pod without a
+// namespace, as on nodes running only CRI.
+func namespacedReport(namespaces, pods int) report.Report {
+	var (
+		rpt       = report.MakeReport()
+		hostID    = report.MakeHostNodeID("host")
+		clusterID = report.MakeKubernetesClusterNodeID("cluster-id")
+		pid       = 0
+	)
+	rpt.ID = "namespaced"
+	rpt.KubernetesCluster.AddNode(report.MakeNodeWith(clusterID, map[string]string{
+		report.KubernetesClusterName: "prod",
+	}).WithTopology(report.KubernetesCluster))
+	rpt.Host.AddNode(report.MakeNode(hostID).WithTopology(report.Host).WithParent(report.KubernetesCluster, clusterID))
+	addPod := func(namespace, name string) {
+		podID := report.MakePodNodeID(namespace + "-" + name)
+		latest := map[string]string{report.KubernetesName: name}
+		if namespace != "" {
+			latest[report.KubernetesNamespace] = namespace
+		}
+		rpt.Pod.AddNode(report.MakeNodeWith(podID, latest).WithTopology(report.Pod).
+			WithParent(report.KubernetesCluster, clusterID).WithParent(report.Host, hostID))
+		containerID := namespace + "-" + name
+		rpt.Container.AddNode(report.MakeNodeWith(report.MakeContainerNodeID(containerID), map[string]string{
+			report.DockerContainerID: containerID,
+			report.HostNodeID:        hostID,
+		}).WithTopology(report.Container).WithParent(report.Pod, podID).WithParent(report.Host, hostID))
+		pid++
+		rpt.Process.AddNode(report.MakeNodeWith(report.MakeProcessNodeID("host", fmt.Sprint(pid)), map[string]string{
+			report.PID:               fmt.Sprint(pid),
+			report.HostNodeID:        hostID,
+			report.DockerContainerID: containerID,
+		}).WithTopology(report.Process).WithParent(report.Container, report.MakeContainerNodeID(containerID)))
+	}
+	for n := 0; n < namespaces; n++ {
+		for p := 0; p < pods; p++ {
+			addPod(fmt.Sprintf("namespace%d", n), fmt.Sprintf("pod%d", p))
+		}
+	}
+	addPod("", "cri")
+	// A process outside any container
+	rpt.Process.AddNode(report.MakeNodeWith(report.MakeProcessNodeID("host", "0"), map[string]string{
+		report.PID:        "0",
+		report.HostNodeID: hostID,
+	}).WithTopology(report.Process))
+	return rpt
+}
+
+func topologyIDs(t report.Topology) []string {
+	ids := []string{}
+	for id := range t.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestKubernetesScopePrune(t *testing.T) {
+	rpt := namespacedReport(2, 1)
+	for _, c := range []struct {
+		scope render.KubernetesScope
+		pods  []string
+		hosts int
+		procs int
+	}{
+		{render.KubernetesScope{}, []string{"-cri", "namespace0-pod0", "namespace1-pod0"}, 1, 4},
+		{render.KubernetesScope{Namespace: "namespace1"}, []string{"namespace1-pod0"}, 1, 1},
+		{render.KubernetesScope{Namespace: render.UnknownNamespace}, []string{"-cri"}, 1, 1},
+		// Clusters go by name or ID
+		{render.KubernetesScope{Cluster: "prod"}, []string{"-cri", "namespace0-pod0", "namespace1-pod0"}, 1, 4},
+		{render.KubernetesScope{Cluster: "cluster-id", Namespace: "namespace0"}, []string{"namespace0-pod0"}, 1, 1},
+		{render.KubernetesScope{Cluster: "staging"}, []string{}, 0, 0},
+	} {
+		have := c.scope.Prune(rpt)
+		want := []string{}
+		for _, pod := range c.pods {
+			want = append(want, report.MakePodNodeID(pod))
+		}
+		if !reflect.DeepEqual(want, topologyIDs(have.Pod)) {
+			t.Errorf("%+v: %s", c.scope, test.Diff(want, topologyIDs(have.Pod)))
+		}
+		if len(have.Container.Nodes) != len(c.pods) || len(have.Host.Nodes) != c.hosts || len(have.Process.Nodes) != c.procs {
+			t.Errorf("%+v: want %d containers, %d hosts and %d processes, have %d, %d and %d", c.scope, len(c.pods), c.hosts, c.procs,
+				len(have.Container.Nodes), len(have.Host.Nodes), len(have.Process.Nodes))
+		}
+		if c.scope != (render.KubernetesScope{}) && have.ID == rpt.ID {
+			t.Errorf("%+v: expected a report of its own", c.scope)
+		}
+	}
+	if len(rpt.Pod.Nodes) != 3 {
+		t.Error("Expected the report not to be modified")
+	}
+}
+
+func TestScopedPodRender(t *testing.T) {
+	rpt := namespacedReport(3, 2)
+	have := render.Scoped(render.KubernetesScope{Namespace: "namespace2"}, render.PodRenderer).Render(context.Background(), rpt)
+	want := []string{report.MakePodNodeID("namespace2-pod0"), report.MakePodNodeID("namespace2-pod1")}
+	if !reflect.DeepEqual(want, topologyIDs(report.Topology{Nodes: have.Nodes})) {
+		t.Error(test.Diff(want, topologyIDs(report.Topology{Nodes: have.Nodes})))
+	}
+
+	// Renders of the whole report are cached apart
+	all := render.Render(context.Background(), rpt, render.PodRenderer, render.IsTopology(report.Pod))
+	if len(all.Nodes) != 3*2+1 {
+		t.Errorf("Expected all the pods, have %d", len(all.Nodes))
+	}
+}
+
+func BenchmarkPodRenderAllNamespaces(b *testing.B) {
+	benchmarkScopedPodRender(b, render.KubernetesScope{})
+}
+
+func BenchmarkPodRenderOneNamespace(b *testing.B) {
+	benchmarkScopedPodRender(b, render.KubernetesScope{Namespace: "namespace7"})
+}
+
+func benchmarkScopedPodRender(b *testing.B, scope render.KubernetesScope) {
+	rpt := namespacedReport(50, 40)
+	renderer := render.Scoped(scope, render.PodRenderer)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		render.ResetCache()
+		b.StartTimer()
+		benchmarkRenderResult = render.Render(context.Background(), rpt, renderer, render.FilterUnconnectedPseudo)
+		if len(benchmarkRenderResult.Nodes) == 0 {
+			b.Errorf("Rendered topology contained no nodes")
+		}
+	}
+}
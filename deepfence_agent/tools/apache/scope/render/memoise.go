@@ -40,7 +40,8 @@ func Memoise(r Renderer) Renderer {
 // Render produces a set of Nodes given a Report.  Ideally, it just
 // retrieves a promise from the cache and returns its value, otherwise
 // it stores a new promise and fulfils it by calling through to
-// m.Renderer.
+// m.Renderer.  Renders cut short by their deadline fulfil the promise
+// incomplete, and those waiting on it render the report themselves.
 func (m *memoise) Render(ctx context.Context, rpt report.Report) Nodes {
 	key := fmt.Sprintf("%s-%s", rpt.ID, m.id)
 
@@ -48,7 +49,10 @@ func (m *memoise) Render(ctx context.Context, rpt report.Report) Nodes {
 	v, err := renderCache.Get(key)
 	if err == nil {
 		m.Unlock()
-		return v.(*promise).Get()
+		if output, complete := v.(*promise).Get(); complete {
+			return output
+		}
+		return m.Renderer.Render(ctx, rpt)
 	}
 	promise := newPromise()
 	renderCache.Set(key, promise)
@@ -56,26 +60,32 @@ func (m *memoise) Render(ctx context.Context, rpt report.Report) Nodes {
 
 	output := m.Renderer.Render(ctx, rpt)
 
-	promise.Set(output)
+	// Renders cut short aren't kept
+	complete := !CutShort(ctx)
+	if !complete {
+		renderCache.Remove(key)
+	}
+	promise.Set(output, complete)
 
 	return output
 }
 
 type promise struct {
-	val  Nodes
-	done chan struct{}
+	val      Nodes
+	complete bool
+	done     chan struct{}
 }
 
 func newPromise() *promise {
 	return &promise{done: make(chan struct{})}
 }
 
-func (p *promise) Set(val Nodes) {
-	p.val = val
+func (p *promise) Set(val Nodes, complete bool) {
+	p.val, p.complete = val, complete
 	close(p.done)
 }
 
-func (p *promise) Get() Nodes {
+func (p *promise) Get() (Nodes, bool) {
 	<-p.done
-	return p.val
+	return p.val, p.complete
 }
@@ -3,6 +3,7 @@ package render_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/common/test"
 	"github.com/weaveworks/scope/render"
@@ -64,3 +65,36 @@ func TestMemoise(t *testing.T) {
 		t.Errorf("Expected renderer to have been called again after cache reset")
 	}
 }
+
+func TestMemoiseCutShort(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	r := renderFunc(func(ctx context.Context, rpt report.Report) render.Nodes {
+		if render.CutShort(ctx) {
+			close(started)
+			<-release
+			return render.Nodes{Nodes: report.Nodes{}}
+		}
+		return render.Nodes{Nodes: report.Nodes{rpt.ID: report.MakeNode(rpt.ID)}}
+	})
+	m := render.Memoise(r)
+	rpt := report.MakeReport()
+
+	// Those waiting on a render cut short render the report themselves
+	cutShort := render.WithDeadline(context.Background(), time.Now())
+	go m.Render(cutShort, rpt)
+	<-started
+	result := make(chan render.Nodes)
+	go func() { result <- m.Render(context.Background(), rpt) }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if output := <-result; len(output.Nodes) != 1 {
+		t.Errorf("Expected a complete render, got: %v", output)
+	}
+
+	// Cancelling a render doesn't cut it short
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if output := m.Render(ctx, report.MakeReport()); len(output.Nodes) != 1 {
+		t.Errorf("Expected a complete render, got: %v", output)
+	}
+}
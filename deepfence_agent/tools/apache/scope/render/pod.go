@@ -30,6 +30,7 @@ func renderKubernetesTopologies(rpt report.Report) bool {
 		&rpt.PersistentVolumeClaim,
 		&rpt.StorageClass,
 		&rpt.Job,
+		&rpt.CustomResource,
 	}
 	for _, t := range topologies {
 		if len(t.Nodes) > 0 {
@@ -112,7 +113,7 @@ var PodServiceRenderer = ConditionalRenderer(renderKubernetesTopologies,
 // not memoised
 var KubeControllerRenderer = ConditionalRenderer(renderKubernetesTopologies,
 	renderParents(
-		report.Pod, []string{report.Deployment, report.DaemonSet, report.StatefulSet, report.CronJob, report.Job}, UnmanagedID,
+		report.Pod, []string{report.Deployment, report.DaemonSet, report.StatefulSet, report.CronJob, report.Job, report.CustomResource}, UnmanagedID,
 		PodRenderer,
 	),
 )
@@ -0,0 +1,319 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// ParseQuery parses a query selecting nodes into a filter of them, for
+// topologies too big to send to the UI whole.  A query is terms separated
+// by spaces, which nodes must all match:
+//
+//	state:running          a latest value, exactly
+//	image:~"nginx:*"       a latest value by glob, * matching any text and ? any character
+//	name:{web,db}          a latest value, one of a set
+//	label:app=nginx        a docker or kubernetes label, matched as latest values are
+//	label:app              a label, set to anything
+//	parent:host            a parent in a topology
+//	parent:host=~"ip-10-*" a parent in a topology, its ID matched as latest values are
+//	-state:running         not matching, with - or ! in front
+//
+// Values with spaces, or any of the characters ",{}", are quoted, with \
+// escaping quotes and itself.  Fields other than label and parent are the
+// keys of latest values, or the aliases in queryAliases.  The filter is nil
+// for an empty query.
+func ParseQuery(q string) (FilterFunc, error) {
+	p := queryParser{query: q}
+	var terms []FilterFunc
+	for {
+		p.skipSpaces()
+		if p.done() {
+			break
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return ComposeFilterFuncs(terms...), nil
+}
+
+// QueryError is the error of a query which doesn't parse.
+type QueryError struct {
+	Query  string
+	Offset int // in bytes
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query %q at %d: %s", e.Query, e.Offset, e.Msg)
+}
+
+// queryAliases are the keys of the latest values query fields stand for,
+// across topologies.
+var queryAliases = map[string][]string{
+	"state": {report.DockerContainerState, report.KubernetesState},
+	"image": {report.DockerImageName},
+	"name":  {report.DockerContainerName, report.KubernetesName, report.HostName, report.Name},
+}
+
+// queryLabelPrefixes prefix the latest keys of labels.
+var queryLabelPrefixes = []string{
+	report.DockerLabelPrefix,
+	report.DockerImageLabelPrefix,
+	"kubernetes_labels_",
+}
+
+type queryParser struct {
+	query string
+	pos   int
+}
+
+func (p *queryParser) done() bool { return p.pos >= len(p.query) }
+
+func (p *queryParser) peek() byte { return p.query[p.pos] }
+
+func (p *queryParser) fail(format string, args ...interface{}) error {
+	return &QueryError{Query: p.query, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) skipSpaces() {
+	for !p.done() && p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+// term parses a term, up to the next space.
+func (p *queryParser) term() (FilterFunc, error) {
+	negated := false
+	if c := p.peek(); c == '-' || c == '!' {
+		negated = true
+		p.pos++
+	}
+	field := p.word(":")
+	if field == "" {
+		return nil, p.fail("expected a field")
+	}
+	if p.done() || p.peek() != ':' {
+		return nil, p.fail("expected : after %q", field)
+	}
+	p.pos++
+
+	var (
+		term FilterFunc
+		err  error
+	)
+	switch field {
+	case "label":
+		term, err = p.keyed(labelTerm)
+	case "parent":
+		term, err = p.keyed(parentTerm)
+	default:
+		keys, ok := queryAliases[field]
+		if !ok {
+			keys = []string{field}
+		}
+		var match func(string) bool
+		if match, err = p.match(); err == nil {
+			term = latestTerm(keys, match)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() && p.peek() != ' ' {
+		return nil, p.fail("expected a space")
+	}
+	if negated {
+		inner := term
+		term = func(n report.Node) bool { return !inner(n) }
+	}
+	return term, nil
+}
+
+// keyed parses the key of a label or parent term, and what its values are
+// to match, if anything.
+func (p *queryParser) keyed(makeTerm func(key string, match func(string) bool) FilterFunc) (FilterFunc, error) {
+	key := p.word("=")
+	if key == "" {
+		return nil, p.fail("expected a key")
+	}
+	if p.done() || p.peek() != '=' {
+		return makeTerm(key, nil), nil
+	}
+	p.pos++
+	match, err := p.match()
+	if err != nil {
+		return nil, err
+	}
+	return makeTerm(key, match), nil
+}
+
+// word parses text up to a space or any of the stop characters.
+func (p *queryParser) word(stop string) string {
+	start := p.pos
+	for !p.done() && p.peek() != ' ' && p.peek() != '"' && strings.IndexByte(stop, p.peek()) < 0 {
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+// match parses what a value is to match: exactly, by glob, or in a set.
+func (p *queryParser) match() (func(string) bool, error) {
+	if p.done() {
+		return nil, p.fail("expected a value")
+	}
+	switch p.peek() {
+	case '~':
+		p.pos++
+		pattern, err := p.value(" ")
+		if err != nil {
+			return nil, err
+		}
+		return func(s string) bool { return globMatch(pattern, s) }, nil
+	case '{':
+		p.pos++
+		set := map[string]struct{}{}
+		for {
+			value, err := p.value(",} ")
+			if err != nil {
+				return nil, err
+			}
+			set[value] = struct{}{}
+			if p.done() {
+				return nil, p.fail("expected }")
+			}
+			switch p.peek() {
+			case '}':
+				p.pos++
+				return func(s string) bool { _, ok := set[s]; return ok }, nil
+			case ',':
+				p.pos++
+			default:
+				return nil, p.fail("expected , or }")
+			}
+		}
+	default:
+		value, err := p.value(" ")
+		if err != nil {
+			return nil, err
+		}
+		return func(s string) bool { return s == value }, nil
+	}
+}
+
+// value parses a value, quoted, or up to any of the stop characters.
+func (p *queryParser) value(stop string) (string, error) {
+	if p.done() || p.peek() != '"' {
+		start := p.pos
+		for !p.done() && strings.IndexByte(stop, p.peek()) < 0 {
+			if c := p.peek(); c == '"' || c == '{' || c == '}' || c == ',' {
+				return "", p.fail("unexpected %q; quote values with it", c)
+			}
+			p.pos++
+		}
+		if p.pos == start {
+			return "", p.fail("expected a value")
+		}
+		return p.query[start:p.pos], nil
+	}
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.done() {
+				return "", p.fail("unterminated escape")
+			}
+			b.WriteByte(p.peek())
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.fail("unterminated quote")
+}
+
+func latestTerm(keys []string, match func(string) bool) FilterFunc {
+	return func(n report.Node) bool {
+		for _, key := range keys {
+			if value, ok := n.Latest.Lookup(key); ok && match(value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func labelTerm(key string, match func(string) bool) FilterFunc {
+	keys := make([]string, 0, len(queryLabelPrefixes))
+	for _, prefix := range queryLabelPrefixes {
+		keys = append(keys, prefix+key)
+	}
+	if match == nil {
+		match = func(string) bool { return true }
+	}
+	return latestTerm(keys, match)
+}
+
+func parentTerm(topology string, match func(string) bool) FilterFunc {
+	return func(n report.Node) bool {
+		ids, ok := n.Parents.Lookup(topology)
+		if !ok {
+			return false
+		}
+		if match == nil {
+			return len(ids) > 0
+		}
+		for _, id := range ids {
+			if match(id) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// globMatch reports whether s matches pattern, in which * matches any text
+// and ? any one character; there are no escapes.
+func globMatch(pattern, s string) bool {
+	// Where to resume after the last *, if what follows it doesn't match
+	starPattern, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			starPattern, starS = p, i
+			p++
+		case p < len(pattern) && pattern[p] == '?':
+			_, size := utf8.DecodeRuneInString(s[i:])
+			p++
+			i += size
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case starPattern >= 0:
+			// Let the * take one more character
+			_, size := utf8.DecodeRuneInString(s[starS:])
+			starS += size
+			p, i = starPattern+1, starS
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
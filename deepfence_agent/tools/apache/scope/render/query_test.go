@@ -0,0 +1,179 @@
+package render_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+)
+
+var (
+	nginx = report.MakeNode("nginx;<container>").
+		WithLatests(map[string]string{
+			report.DockerContainerState:                  "running",
+			report.DockerImageName:                       "docker.io/library/nginx:1.21",
+			report.DockerContainerName:                   "web server",
+			report.DockerLabelPrefix + "app":             "nginx",
+			report.DockerLabelPrefix + "tier":            "front end",
+			report.DockerImageLabelPrefix + "maintainer": "NGINX \"Docker\" Maintainers",
+		}).
+		WithParent(report.Host, "ip-10-0-0-1;<host>").
+		WithParent(report.ContainerImage, "nginx;<container_image>")
+	redis = report.MakeNode("redis;<container>").
+		WithLatests(map[string]string{
+			report.DockerContainerState: "stopped",
+			report.DockerImageName:      "redis:6",
+			report.DockerContainerName:  "db",
+		}).
+		WithParent(report.Host, "ip-10-0-0-2;<host>")
+	pod = report.MakeNode("pod;<pod>").
+		WithLatests(map[string]string{
+			report.KubernetesState:  "running",
+			report.KubernetesName:   "web",
+			"kubernetes_labels_app": "nginx",
+		})
+)
+
+func TestParseQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  []report.Node
+	}{
+		{"", []report.Node{nginx, redis, pod}},
+		{"   ", []report.Node{nginx, redis, pod}},
+		{"state:running", []report.Node{nginx, pod}},
+		{report.DockerContainerState + ":running", []report.Node{nginx}},
+		{"-state:running", []report.Node{redis}},
+		{"!state:running", []report.Node{redis}},
+		{`name:"web server"`, []report.Node{nginx}},
+		{"name:{db,web}", []report.Node{redis, pod}},
+		{`name:{"web server",db}`, []report.Node{nginx, redis}},
+		{"image:~*nginx:*", []report.Node{nginx}},
+		{`image:~"redis:?"`, []report.Node{redis}},
+		{"image:~*", []report.Node{nginx, redis}},
+		{"image:~redis", nil},
+		{"label:app=nginx", []report.Node{nginx, pod}},
+		{`label:tier="front end"`, []report.Node{nginx}},
+		{`label:maintainer=~"*\"Docker\"*"`, []report.Node{nginx}},
+		{"label:tier", []report.Node{nginx}},
+		{"-label:app", []report.Node{redis}},
+		{"parent:host", []report.Node{nginx, redis}},
+		{"parent:host=~ip-10-0-0-2;*", []report.Node{redis}},
+		{"parent:container_image -parent:host=~*-2;*", []report.Node{nginx}},
+		{"state:running  label:app=nginx   parent:host", []report.Node{nginx}},
+		{"no_such_key:x", nil},
+	} {
+		filter, err := render.ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+		var have []report.Node
+		for _, n := range []report.Node{nginx, redis, pod} {
+			if filter == nil || filter(n) {
+				have = append(have, n)
+			}
+		}
+		if !sameNodes(tc.want, have) {
+			t.Errorf("%q: expected %v, got %v", tc.query, ids(tc.want), ids(have))
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query  string
+		offset int
+	}{
+		{"state", 5},
+		{":running", 0},
+		{"-", 1},
+		{"state:", 6},
+		{`state:"running`, 14},
+		{`state:"running\`, 15},
+		{`state:"running"x`, 15},
+		{"state:run,ning", 9},
+		{"name:{db,web", 12},
+		{"name:{db web}", 8},
+		{"name:{}", 6},
+		{"label:=nginx", 6},
+		{"label:app=", 10},
+		{`label:maintainer=~*"Docker"*`, 19},
+		{"parent:", 7},
+	} {
+		_, err := render.ParseQuery(tc.query)
+		qerr, ok := err.(*render.QueryError)
+		if !ok {
+			t.Errorf("%q: expected a query error, got %v", tc.query, err)
+			continue
+		}
+		if qerr.Offset != tc.offset {
+			t.Errorf("%q: expected an error at %d, got %v", tc.query, tc.offset, qerr)
+		}
+	}
+}
+
+// Any query either fails to parse, or makes a filter; neither panics.
+func TestParseQueryRandom(t *testing.T) {
+	var (
+		seeds = []string{
+			"state:running",
+			`label:app=nginx image:~"nginx:*" -parent:host={"a b",c}`,
+			`name:"\"\\"`,
+			"!parent:host=~*?*",
+		}
+		alphabet = []byte(` :=~*?!-,{}"\abnx`)
+		random   = rand.New(rand.NewSource(1))
+	)
+	for i := 0; i < 10000; i++ {
+		query := []byte(seeds[random.Intn(len(seeds))])
+		for j := random.Intn(4); j >= 0; j-- {
+			at := random.Intn(len(query) + 1)
+			switch c := alphabet[random.Intn(len(alphabet))]; random.Intn(3) {
+			case 0:
+				query = append(query[:at], append([]byte{c}, query[at:]...)...)
+			case 1:
+				if at < len(query) {
+					query = append(query[:at], query[at+1:]...)
+				}
+			default:
+				if at < len(query) {
+					query[at] = c
+				}
+			}
+		}
+		filter, err := render.ParseQuery(string(query))
+		if err != nil {
+			if _, ok := err.(*render.QueryError); !ok {
+				t.Fatalf("%q: unexpected error %v", query, err)
+			}
+			continue
+		}
+		if filter != nil {
+			for _, n := range []report.Node{nginx, redis, pod} {
+				filter(n)
+			}
+		}
+	}
+}
+
+func sameNodes(a, b []report.Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+func ids(nodes []report.Node) []string {
+	result := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, n.ID)
+	}
+	return result
+}
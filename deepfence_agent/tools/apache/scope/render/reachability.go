@@ -0,0 +1,70 @@
+package render
+
+import (
+	"strconv"
+
+	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/scope/report"
+)
+
+// HopsMark is the key added to Node.Latest by Reachable, of how many hops
+// of adjacency a node is from the node reached from.
+const HopsMark = "hops"
+
+// Reachable is a transformer keeping only the nodes reachable from a node
+// within a number of hops of adjacency - the blast radius of the node - and
+// marking each with HopsMark.  Edges are followed either way, as
+// connections carry traffic both ways, and edges to the nodes dropped are
+// dropped too.  Nothing is kept if the node isn't there.
+type Reachable struct {
+	From string
+	Hops int
+}
+
+// Transform implements Transformer
+func (r Reachable) Transform(input Nodes) Nodes {
+	if _, ok := input.Nodes[r.From]; !ok {
+		return Nodes{Nodes: report.Nodes{}, Filtered: input.Filtered + len(input.Nodes)}
+	}
+	neighbours := map[string][]string{}
+	for id, node := range input.Nodes {
+		for _, adj := range node.Adjacency {
+			if _, ok := input.Nodes[adj]; ok && adj != id {
+				neighbours[id] = append(neighbours[id], adj)
+				neighbours[adj] = append(neighbours[adj], id)
+			}
+		}
+	}
+
+	// Breadth first, so each node is reached by the fewest hops, and only
+	// once, however many cycles there are
+	hops := map[string]int{r.From: 0}
+	frontier := []string{r.From}
+	for hop := 1; hop <= r.Hops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, id := range frontier {
+			for _, adj := range neighbours[id] {
+				if _, ok := hops[adj]; !ok {
+					hops[adj] = hop
+					next = append(next, adj)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	now := mtime.Now()
+	output := make(report.Nodes, len(hops))
+	for id, hop := range hops {
+		node := input.Nodes[id]
+		adjacency := report.MakeIDList()
+		for _, adj := range node.Adjacency {
+			if _, ok := hops[adj]; ok {
+				adjacency = adjacency.Add(adj)
+			}
+		}
+		node.Adjacency = adjacency
+		output[id] = node.WithLatest(HopsMark, now, strconv.Itoa(hop))
+	}
+	return Nodes{Nodes: output, Filtered: input.Filtered + len(input.Nodes) - len(output)}
+}
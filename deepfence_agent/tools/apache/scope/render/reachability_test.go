@@ -0,0 +1,62 @@
+package render_test
+
+import (
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// reachabilityGraph is a ring of a -> b -> c -> d -> a, with e connecting
+// to c from outside it, f hanging off d, and g on its own.
+var reachabilityGraph = render.Nodes{Nodes: report.Nodes{
+	"a": report.MakeNode("a").WithAdjacent("b"),
+	"b": report.MakeNode("b").WithAdjacent("c"),
+	"c": report.MakeNode("c").WithAdjacent("d"),
+	"d": report.MakeNode("d").WithAdjacent("a").WithAdjacent("f"),
+	"e": report.MakeNode("e").WithAdjacent("c"),
+	"f": report.MakeNode("f"),
+	"g": report.MakeNode("g"),
+}}
+
+func reachableHops(nodes render.Nodes) map[string]string {
+	hops := map[string]string{}
+	for id, node := range nodes.Nodes {
+		hops[id], _ = node.Latest.Lookup(render.HopsMark)
+	}
+	return hops
+}
+
+func TestReachable(t *testing.T) {
+	for _, c := range []struct {
+		from string
+		hops int
+		want map[string]string
+	}{
+		{"a", 0, map[string]string{"a": "0"}},
+		{"a", 1, map[string]string{"a": "0", "b": "1", "d": "1"}},
+		{"a", 2, map[string]string{"a": "0", "b": "1", "d": "1", "c": "2", "f": "2"}},
+		// The ring doesn't lead around it again
+		{"a", 10, map[string]string{"a": "0", "b": "1", "d": "1", "c": "2", "f": "2", "e": "3"}},
+		// Edges are followed against their direction
+		{"e", 1, map[string]string{"e": "0", "c": "1"}},
+		{"g", 5, map[string]string{"g": "0"}},
+		{"missing", 5, map[string]string{}},
+	} {
+		have := render.Reachable{From: c.from, Hops: c.hops}.Transform(reachabilityGraph)
+		if !reflect.DeepEqual(c.want, reachableHops(have)) {
+			t.Errorf("%s within %d hops: %s", c.from, c.hops, test.Diff(c.want, reachableHops(have)))
+		}
+		if want := len(reachabilityGraph.Nodes) - len(c.want); have.Filtered != want {
+			t.Errorf("%s within %d hops: want %d filtered, have %d", c.from, c.hops, want, have.Filtered)
+		}
+	}
+
+	// Edges to nodes too far away are dropped
+	have := render.Reachable{From: "a", Hops: 1}.Transform(reachabilityGraph)
+	if want := report.MakeIDList("a"); !reflect.DeepEqual(want, have.Nodes["d"].Adjacency) {
+		t.Error(test.Diff(want, have.Nodes["d"].Adjacency))
+	}
+}
@@ -2,6 +2,7 @@ package render
 
 import (
 	"context"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
@@ -27,6 +28,21 @@ type Renderer interface {
 	Render(context.Context, report.Report) Nodes
 }
 
+type deadlineKey struct{}
+
+// WithDeadline returns a context under which renders past the deadline are
+// cut short, giving the nodes rendered so far.  Renders are only cut short
+// by their deadline, not by ctx being cancelled.
+func WithDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, deadlineKey{}, deadline)
+}
+
+// CutShort returns whether renders under ctx are past their deadline.
+func CutShort(ctx context.Context) bool {
+	deadline, ok := ctx.Value(deadlineKey{}).(time.Time)
+	return ok && !time.Now().Before(deadline)
+}
+
 // Nodes is the result of Rendering
 type Nodes struct {
 	report.Nodes
@@ -62,7 +78,10 @@ func (ts Transformers) Transform(nodes Nodes) Nodes {
 func Render(ctx context.Context, rpt report.Report, renderer Renderer, transformer Transformer) Nodes {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Render:"+typeName(renderer))
 	defer span.Finish()
-	return transformer.Transform(renderer.Render(ctx, rpt))
+	nodes := transformer.Transform(renderer.Render(ctx, rpt))
+	span.SetTag("nodes", len(nodes.Nodes))
+	span.SetTag("filtered", nodes.Filtered)
+	return nodes
 }
 
 // Reduce renderer is a Renderer which merges together the output of several
@@ -123,8 +142,12 @@ func (m Map) Render(ctx context.Context, rpt report.Report) Nodes {
 		output = newJoinResults(nil)
 	)
 
-	// Rewrite all the nodes according to the map function
+	// Rewrite all the nodes according to the map function, as many as there
+	// is time for
 	for _, inRenderable := range input.Nodes {
+		if CutShort(ctx) {
+			break
+		}
 		outRenderable := m.MapFunc(inRenderable)
 		if outRenderable.ID != "" {
 			output.add(inRenderable.ID, outRenderable)
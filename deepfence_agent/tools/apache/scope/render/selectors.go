@@ -31,6 +31,8 @@ var (
 	SelectStatefulSet           = TopologySelector(report.StatefulSet)
 	SelectCronJob               = TopologySelector(report.CronJob)
 	SelectJob                   = TopologySelector(report.Job)
+	SelectIngress               = TopologySelector(report.Ingress)
+	SelectCustomResource        = TopologySelector(report.CustomResource)
 	SelectECSTask               = TopologySelector(report.ECSTask)
 	SelectECSService            = TopologySelector(report.ECSService)
 	SelectSwarmService          = TopologySelector(report.SwarmService)
@@ -0,0 +1,84 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Severities of the vulnerabilities of container images, worst first, and
+// of the images which have none and those which were never scanned.
+const (
+	SeverityCritical  = "critical"
+	SeverityHigh      = "high"
+	SeverityMedium    = "medium"
+	SeverityLow       = "low"
+	SeverityNone      = "none"
+	SeverityUnscanned = "unscanned"
+)
+
+// Severities are the severities containers are grouped by, worst first.
+var Severities = []string{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityNone, SeverityUnscanned}
+
+// SeverityLabels are the labels of the groups of each severity.
+var SeverityLabels = map[string]string{
+	SeverityCritical:  "Critical",
+	SeverityHigh:      "High",
+	SeverityMedium:    "Medium",
+	SeverityLow:       "Low",
+	SeverityNone:      "No vulnerabilities",
+	SeverityUnscanned: "Unscanned",
+}
+
+// SeverityID is the ID of pseudo nodes grouping containers by severity.
+const SeverityID = "severity"
+
+// SeverityIDPrefix is the prefix of the pseudo nodes grouping containers by
+// severity, which is followed by the severity.
+var SeverityIDPrefix = MakePseudoNodeID(SeverityID, "")
+
+// WorstSeverity returns the worst severity of the vulnerabilities found by
+// the latest vulnerability scan of a node, SeverityNone if it found none,
+// or SeverityUnscanned if it has no complete scan.
+func WorstSeverity(n report.Node) string {
+	scan, ok := n.LookupScanStatus(report.VulnerabilityScan)
+	if !ok {
+		return SeverityUnscanned
+	}
+	for _, severity := range Severities[:4] {
+		for s, count := range scan.Counts {
+			if count > 0 && strings.ToLower(s) == severity {
+				return severity
+			}
+		}
+	}
+	if scan.Status != report.ScanStatusComplete {
+		return SeverityUnscanned
+	}
+	severity := strings.ToLower(scan.Severity)
+	if _, ok := SeverityLabels[severity]; ok && severity != SeverityUnscanned {
+		return severity
+	}
+	return SeverityNone
+}
+
+// GroupBySeverity is a transformer grouping containers into pseudo nodes by
+// the WorstSeverity of their image, with the containers as children.
+// Other nodes are kept as they are, and edges are rewritten to the groups.
+// It comes after filters, so the groups count only the containers shown.
+type GroupBySeverity struct{}
+
+// Transform implements Transformer
+func (GroupBySeverity) Transform(input Nodes) Nodes {
+	ret := newJoinResults(nil)
+	for _, n := range input.Nodes {
+		if n.Topology != report.Container {
+			ret.passThrough(n)
+			continue
+		}
+		ret.addChild(n, SeverityIDPrefix+WorstSeverity(n), Pseudo)
+	}
+	output := ret.result(input)
+	output.Filtered = input.Filtered
+	return output
+}
@@ -0,0 +1,76 @@
+package render_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func scannedContainer(id string, scan *report.ScanStatus) report.Node {
+	n := report.MakeNode(id).WithTopology(report.Container)
+	if scan != nil {
+		scan.Type = report.VulnerabilityScan
+		scan.LastScan = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		n = n.WithScanStatus(*scan)
+	}
+	return n
+}
+
+func TestWorstSeverity(t *testing.T) {
+	for want, scan := range map[string]*report.ScanStatus{
+		render.SeverityCritical:  {Status: report.ScanStatusComplete, Counts: map[string]int{"low": 4, "Critical": 1}},
+		render.SeverityHigh:      {Status: report.ScanStatusComplete, Counts: map[string]int{"critical": 0, "high": 2}},
+		render.SeverityMedium:    {Status: report.ScanStatusComplete, Severity: "medium"},
+		render.SeverityNone:      {Status: report.ScanStatusComplete},
+		render.SeverityUnscanned: {Status: report.ScanStatusNeverScanned},
+	} {
+		if have := render.WorstSeverity(scannedContainer("c", scan)); want != have {
+			t.Errorf("%v: want %s, have %s", scan, want, have)
+		}
+	}
+	if have := render.WorstSeverity(scannedContainer("c", nil)); have != render.SeverityUnscanned {
+		t.Errorf("Expected a container without a scan to be unscanned, have %s", have)
+	}
+}
+
+func TestGroupBySeverity(t *testing.T) {
+	critical := func() *report.ScanStatus {
+		return &report.ScanStatus{Status: report.ScanStatusComplete, Counts: map[string]int{"critical": 1}}
+	}
+	input := render.Nodes{Filtered: 2, Nodes: report.Nodes{
+		"a":        scannedContainer("a", critical()).WithAdjacent("b"),
+		"b":        scannedContainer("b", critical()),
+		"c":        scannedContainer("c", nil).WithAdjacent(render.OutgoingInternetID),
+		"internet": report.MakeNode(render.OutgoingInternetID).WithTopology(render.Pseudo),
+	}}
+	have := render.GroupBySeverity{}.Transform(input)
+
+	criticalID := render.SeverityIDPrefix + render.SeverityCritical
+	unscannedID := render.SeverityIDPrefix + render.SeverityUnscanned
+	want := map[string][]string{
+		criticalID:                {criticalID},
+		unscannedID:               {render.OutgoingInternetID},
+		render.OutgoingInternetID: nil,
+	}
+	got := map[string][]string{}
+	for id, n := range have.Nodes {
+		got[id] = n.Adjacency
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Error(test.Diff(want, got))
+	}
+	if count, _ := have.Nodes[criticalID].LookupCounter(report.Container); count != 2 {
+		t.Errorf("Expected 2 critical containers, have %d", count)
+	}
+	children := have.Nodes[criticalID].Children
+	if _, ok := children.Lookup("a"); !ok || children.Size() != 2 {
+		t.Errorf("Expected the critical containers as children, have %v", children)
+	}
+	if have.Filtered != input.Filtered {
+		t.Errorf("Expected %d filtered, have %d", input.Filtered, have.Filtered)
+	}
+}
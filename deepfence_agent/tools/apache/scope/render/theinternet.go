@@ -258,6 +258,17 @@ func purgeKnownServiceCache() {
 	knownServiceCache = lru.New(10000)
 }
 
+// ParseServiceNodeID parses the ID of the pseudo node of a known service,
+// returning the name of the service and the host name connected to.
+func ParseServiceNodeID(nodeID string) (service, hostname string, ok bool) {
+	for _, serviceRegexpMatcher := range KnownServiceRegexpMatchers {
+		if strings.HasPrefix(nodeID, serviceRegexpMatcher.Prefix) {
+			return serviceRegexpMatcher.Name, nodeID[len(serviceRegexpMatcher.Prefix):], true
+		}
+	}
+	return "", "", false
+}
+
 // TODO: Make it user-customizable https://github.com/weaveworks/scope/issues/1876
 // NB: this is a hotspot in rendering performance.
 func isKnownService(hostname string) string {
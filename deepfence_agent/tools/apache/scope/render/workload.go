@@ -0,0 +1,32 @@
+package render
+
+import (
+	"github.com/weaveworks/scope/report"
+)
+
+// WorkloadRenderer is a Renderer of the dependencies between workloads:
+// kubernetes Deployments, StatefulSets and DaemonSets, from their pods, and
+// Swarm services, from their containers.  Workloads are adjacent whenever
+// any of their members are, and pods selected by more than one workload
+// count for each.  Workloads are told apart by ID, which is unique across
+// clusters, so those of the same name in different clusters are not
+// merged.  Pods of no workload are mapped to 'Unmanaged'.
+//
+// not memoised
+var WorkloadRenderer = MakeReduce(
+	ConditionalRenderer(renderKubernetesTopologies,
+		renderParents(
+			report.Pod, []string{report.Deployment, report.StatefulSet, report.DaemonSet}, UnmanagedID,
+			PodRenderer,
+		),
+	),
+	ConditionalRenderer(renderSwarmTopologies,
+		renderParents(
+			report.Container, []string{report.SwarmService}, "",
+			MakeFilter(
+				IsRunning,
+				ContainerWithImageNameRenderer,
+			),
+		),
+	),
+)
@@ -0,0 +1,87 @@
+package render_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/fixture"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+var (
+	webDeploymentID    = report.MakeDeploymentNodeID("web-uid")
+	otherWebID         = report.MakeDeploymentNodeID("other-cluster-web-uid")
+	apiDeploymentID    = report.MakeDeploymentNodeID("api-uid")
+	canaryDeploymentID = report.MakeDeploymentNodeID("api-canary-uid")
+	otherClusterID     = report.MakeKubernetesClusterNodeID("other-cluster")
+	fixtureClusterID   = report.MakeKubernetesClusterNodeID("cluster")
+	deploymentIDs      = []string{canaryDeploymentID, apiDeploymentID, otherWebID, webDeploymentID}
+)
+
+// workloadReport is the fixture with the client pod in a deployment, and
+// the server pod selected by two deployments, as their selectors overlap.
+// A deployment of the same name as the client's, in another cluster, has
+// no pods.
+func workloadReport() report.Report {
+	rpt := fixture.Report.Copy()
+	rpt.Deployment = report.MakeTopology()
+	deployment := func(id, name, cluster string) report.Node {
+		return report.MakeNodeWith(id, map[string]string{
+			report.KubernetesName:      name,
+			report.KubernetesNamespace: fixture.KubernetesNamespace,
+		}).WithTopology(report.Deployment).WithParent(report.KubernetesCluster, cluster)
+	}
+	rpt.Deployment.AddNode(deployment(webDeploymentID, "web", fixtureClusterID))
+	rpt.Deployment.AddNode(deployment(otherWebID, "web", otherClusterID))
+	rpt.Deployment.AddNode(deployment(apiDeploymentID, "api", fixtureClusterID))
+	rpt.Deployment.AddNode(deployment(canaryDeploymentID, "api-canary", fixtureClusterID))
+	rpt.Pod.Nodes[fixture.ClientPodNodeID] = rpt.Pod.Nodes[fixture.ClientPodNodeID].
+		WithParent(report.Deployment, webDeploymentID)
+	rpt.Pod.Nodes[fixture.ServerPodNodeID] = rpt.Pod.Nodes[fixture.ServerPodNodeID].
+		WithParent(report.Deployment, apiDeploymentID).WithParent(report.Deployment, canaryDeploymentID)
+	return rpt
+}
+
+func TestWorkloadRenderer(t *testing.T) {
+	have := render.Render(context.Background(), workloadReport(), render.WorkloadRenderer, render.IsTopology(report.Deployment)).Nodes
+
+	// Deployments of the same name in different clusters aren't merged
+	if ids := topologyIDs(report.Topology{Nodes: have}); !reflect.DeepEqual(deploymentIDs, ids) {
+		t.Error(test.Diff(deploymentIDs, ids))
+	}
+
+	// The server pod's connections count for both its deployments
+	want := report.MakeIDList(apiDeploymentID, canaryDeploymentID)
+	if adjacency := have[webDeploymentID].Adjacency; !reflect.DeepEqual(want, adjacency) {
+		t.Error(test.Diff(want, adjacency))
+	}
+	for _, id := range []string{apiDeploymentID, canaryDeploymentID} {
+		if _, ok := have[id].Children.Lookup(fixture.ServerPodNodeID); !ok {
+			t.Errorf("Expected the server pod to be a child of %s", id)
+		}
+	}
+	if len(have[otherWebID].Adjacency) != 0 || have[otherWebID].Children.Size() != 0 {
+		t.Errorf("Expected the deployment of the other cluster to be on its own, have %v", have[otherWebID])
+	}
+}
+
+func TestWorkloadRendererSwarm(t *testing.T) {
+	rpt := fixture.Report.Copy()
+	serviceID := report.MakeSwarmServiceNodeID("swarm-service-id")
+	rpt.SwarmService = report.MakeTopology()
+	rpt.SwarmService.AddNode(report.MakeNode(serviceID).WithTopology(report.SwarmService))
+	rpt.Container.Nodes[fixture.ServerContainerNodeID] = rpt.Container.Nodes[fixture.ServerContainerNodeID].
+		WithParent(report.SwarmService, serviceID)
+
+	have := render.WorkloadRenderer.Render(context.Background(), rpt).Nodes
+	service, ok := have[serviceID]
+	if !ok {
+		t.Fatal("Expected the swarm service")
+	}
+	if _, ok := service.Children.Lookup(fixture.ServerContainerNodeID); !ok {
+		t.Errorf("Expected the server container to be a child of the swarm service, have %v", service.Children)
+	}
+}
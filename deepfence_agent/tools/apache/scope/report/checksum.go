@@ -0,0 +1,102 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Checksum is the sha256 of a report, or delta, as encoded before being
+// gzipped, in lowercase hex.  Probes send it for apps to check reports
+// arrive intact.
+type Checksum string
+
+// SumPayload returns the Checksum of an encoded report, not gzipped.
+func SumPayload(payload []byte) Checksum {
+	h := sha256.New()
+	h.Write(payload)
+	return sum(h)
+}
+
+func (c Checksum) String() string {
+	return string(c)
+}
+
+// Bytes returns the sha256 of a Checksum, nil if it isn't valid hex.
+func (c Checksum) Bytes() []byte {
+	buf, err := hex.DecodeString(string(c))
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// ParseChecksum parses a Checksum, as formatted by String.
+func ParseChecksum(s string) (Checksum, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) != sha256.Size {
+		return "", fmt.Errorf("checksum of %d bytes, not %d", len(buf), sha256.Size)
+	}
+	return Checksum(hex.EncodeToString(buf)), nil
+}
+
+// WriteBinaryChecksum writes a Report as WriteBinary does, also returning
+// its Checksum, summed while encoding.
+func (rep Report) WriteBinaryChecksum() (*bytes.Buffer, Checksum, error) {
+	h := sha256.New()
+	buf, err := rep.writeBinary(h)
+	return buf, sum(h), err
+}
+
+// WriteProtobufChecksum writes a Report as WriteProtobuf does, also
+// returning its Checksum.
+func (rep Report) WriteProtobufChecksum() (*bytes.Buffer, Checksum, error) {
+	h := sha256.New()
+	buf, err := rep.writeProtobuf(nil, h)
+	return buf, sum(h), err
+}
+
+// WriteProtobufIDTableChecksum writes a Report as WriteProtobufIDTable
+// does, also returning its Checksum.
+func (rep Report) WriteProtobufIDTableChecksum() (*bytes.Buffer, Checksum, error) {
+	h := sha256.New()
+	buf, err := rep.writeProtobuf(&idTable{index: map[string]uint64{}}, h)
+	return buf, sum(h), err
+}
+
+// WriteBinaryChecksum writes a Delta as WriteBinary does, also returning
+// its Checksum, summed while encoding.
+func (d Delta) WriteBinaryChecksum() (*bytes.Buffer, Checksum, error) {
+	h := sha256.New()
+	buf, err := d.writeBinary(h)
+	return buf, sum(h), err
+}
+
+func sum(h hash.Hash) Checksum {
+	return Checksum(hex.EncodeToString(h.Sum(nil)))
+}
+
+// writeGzipped gzips what encode writes into a new buffer, also writing it
+// to h, if any, before gzipping.
+func writeGzipped(h hash.Hash, encode func(io.Writer) error) (*bytes.Buffer, error) {
+	w := &bytes.Buffer{}
+	gzwriter := gzipWriterPool.Get().(*gzip.Writer)
+	gzwriter.Reset(w)
+	defer gzipWriterPool.Put(gzwriter)
+	var encoder io.Writer = gzwriter
+	if h != nil {
+		encoder = io.MultiWriter(gzwriter, h)
+	}
+	if err := encode(encoder); err != nil {
+		return nil, err
+	}
+	gzwriter.Close() // otherwise the content won't get flushed to the output stream
+	return w, nil
+}
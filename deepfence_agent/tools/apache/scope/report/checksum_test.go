@@ -0,0 +1,42 @@
+package report_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/weaveworks/scope/report"
+)
+
+func TestChecksum(t *testing.T) {
+	rpt := makeTestReport()
+	for name, write := range map[string]func() (*bytes.Buffer, report.Checksum, error){
+		"msgpack":  rpt.WriteBinaryChecksum,
+		"protobuf": rpt.WriteProtobufChecksum,
+		"id table": rpt.WriteProtobufIDTableChecksum,
+		"delta":    report.MakeReport().Diff(rpt).WriteBinaryChecksum,
+	} {
+		buf, sum, err := write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzr, err := gzip.NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := ioutil.ReadAll(gzr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := report.SumPayload(payload); sum != want {
+			t.Errorf("%s: expected the checksum of the payload %s, got %s", name, want, sum)
+		}
+		if parsed, err := report.ParseChecksum(sum.String()); err != nil || parsed != sum {
+			t.Errorf("%s: expected %s to parse back, got %s: %v", name, sum, parsed, err)
+		}
+	}
+	if _, err := report.ParseChecksum("abcd"); err == nil {
+		t.Error("Expected a short checksum to be refused")
+	}
+}
@@ -10,6 +10,9 @@ type Control struct {
 	Icon         string `json:"icon"` // from https://fortawesome.github.io/Font-Awesome/cheatsheet/ please
 	Confirmation string `json:"confirmation,omitempty"`
 	Rank         int    `json:"rank"`
+	// Destructive controls, such as those stopping or deleting what they're
+	// on, are rate limited by the app and their invocations audited.
+	Destructive bool `json:"destructive,omitempty"`
 }
 
 // Merge merges other with cs, returning a fresh Controls.
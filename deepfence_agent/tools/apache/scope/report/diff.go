@@ -0,0 +1,352 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/ugorji/go/codec"
+)
+
+// Delta is the difference between two consecutive reports of a probe, as
+// made by Diff and applied by Patch.  Most of every report is the same as
+// the one before, so publishing deltas rather than whole reports cuts the
+// bandwidth of stable hosts by an order of magnitude.
+type Delta struct {
+	// Report is the later report, without its nodes: its other fields,
+	// and the templates and controls of its topologies, are small enough
+	// to carry whole.
+	Report Report
+
+	// Topologies are the changes to the nodes of the topologies, by name.
+	Topologies map[string]TopologyDelta
+}
+
+// TopologyDelta is the difference between the nodes of a topology in two
+// reports.
+type TopologyDelta struct {
+	// Added are the nodes which are new, or have changed topology, whole.
+	Added Nodes `json:"added,omitempty"`
+
+	// Updated are the changes to the nodes in both reports.
+	Updated []NodeDelta `json:"updated,omitempty"`
+
+	// Removed are the IDs of the nodes not in the later report.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// NodeDelta is the difference between two versions of a node.  The sections
+// left nil are unchanged; the others are replaced whole, apart from the
+// metrics, to which samples are appended.
+type NodeDelta struct {
+	ID        string           `json:"id"`
+	Sets      *Sets            `json:"sets,omitempty"`
+	Adjacency *IDList          `json:"adjacency,omitempty"`
+	Edges     *EdgeMetadatas   `json:"edges,omitempty"`
+	Latest    *StringLatestMap `json:"latest,omitempty"`
+	Parents   *Sets            `json:"parents,omitempty"`
+	Children  *NodeSet         `json:"children,omitempty"`
+
+	// LatestTimestamp, if set, is the timestamp of all the latest values,
+	// which are otherwise unchanged.  Probes set all the values of a node
+	// at once, so this saves resending them every report.
+	LatestTimestamp time.Time `json:"latestTimestamp,omitempty"`
+
+	Metrics        map[string]MetricDelta `json:"metrics,omitempty"`
+	RemovedMetrics []string               `json:"removedMetrics,omitempty"`
+}
+
+// MetricDelta is the difference between two versions of a metric: the
+// number of samples dropped from its start, and those appended to its end.
+type MetricDelta struct {
+	Dropped int      `json:"dropped,omitempty"`
+	Samples []Sample `json:"samples,omitempty"`
+	Min     float64  `json:"min"`
+	Max     float64  `json:"max"`
+}
+
+// Diff returns the delta from r to next, the report following it.
+func (r Report) Diff(next Report) Delta {
+	d := Delta{Report: next, Topologies: map[string]TopologyDelta{}}
+	d.Report.WalkTopologies(func(t *Topology) {
+		t.Nodes = Nodes{}
+	})
+	next.WalkNamedTopologies(func(name string, t *Topology) {
+		if td := r.topology(name).Nodes.diff(t.Nodes); len(td.Added) > 0 || len(td.Updated) > 0 || len(td.Removed) > 0 {
+			d.Topologies[name] = td
+		}
+	})
+	return d
+}
+
+func (n Nodes) diff(next Nodes) TopologyDelta {
+	td := TopologyDelta{}
+	for id, node := range next {
+		prev, ok := n[id]
+		if !ok || prev.Topology != node.Topology {
+			if td.Added == nil {
+				td.Added = Nodes{}
+			}
+			td.Added[id] = node
+		} else if nd, changed := prev.diff(node); changed {
+			td.Updated = append(td.Updated, nd)
+		}
+	}
+	for id := range n {
+		if _, ok := next[id]; !ok {
+			td.Removed = append(td.Removed, id)
+		}
+	}
+	sort.Slice(td.Updated, func(i, j int) bool { return td.Updated[i].ID < td.Updated[j].ID })
+	sort.Strings(td.Removed)
+	return td
+}
+
+func (n Node) diff(next Node) (NodeDelta, bool) {
+	nd := NodeDelta{ID: next.ID}
+	changed := false
+	if !n.Sets.DeepEqual(next.Sets) {
+		nd.Sets, changed = &next.Sets, true
+	}
+	if !n.Adjacency.Equal(next.Adjacency) {
+		nd.Adjacency, changed = &next.Adjacency, true
+	}
+	if !n.Edges.DeepEqual(next.Edges) {
+		nd.Edges, changed = &next.Edges, true
+	}
+	if !n.Parents.DeepEqual(next.Parents) {
+		nd.Parents, changed = &next.Parents, true
+	}
+	if !n.Children.DeepEqual(next.Children) {
+		nd.Children, changed = &next.Children, true
+	}
+	if !n.Latest.DeepEqual(next.Latest) {
+		if ts, ok := next.Latest.sharedTimestamp(); ok && n.Latest.EqualIgnoringTimestamps(next.Latest) {
+			nd.LatestTimestamp = ts
+		} else {
+			nd.Latest = &next.Latest
+		}
+		changed = true
+	}
+	for key, metric := range next.Metrics {
+		if prev, ok := n.Metrics[key]; !ok || !prev.equal(metric) {
+			if nd.Metrics == nil {
+				nd.Metrics = map[string]MetricDelta{}
+			}
+			nd.Metrics[key] = prev.diff(metric)
+			changed = true
+		}
+	}
+	for key := range n.Metrics {
+		if _, ok := next.Metrics[key]; !ok {
+			nd.RemovedMetrics = append(nd.RemovedMetrics, key)
+			changed = true
+		}
+	}
+	sort.Strings(nd.RemovedMetrics)
+	return nd, changed
+}
+
+// sharedTimestamp is the timestamp of all the values of m, if they have
+// the same one.
+func (m StringLatestMap) sharedTimestamp() (time.Time, bool) {
+	if len(m) == 0 {
+		return time.Time{}, false
+	}
+	ts := m[0].Timestamp
+	for _, e := range m[1:] {
+		if !e.Timestamp.Equal(ts) {
+			return time.Time{}, false
+		}
+	}
+	return ts, !ts.IsZero()
+}
+
+// withTimestamp returns a copy of m, with all its values at ts.
+func (m StringLatestMap) withTimestamp(ts time.Time) StringLatestMap {
+	result := make(StringLatestMap, len(m))
+	for i, e := range m {
+		e.Timestamp = ts
+		result[i] = e
+	}
+	return result
+}
+
+func (s Sample) equal(other Sample) bool {
+	return s.Timestamp.Equal(other.Timestamp) && s.Value == other.Value
+}
+
+func (m Metric) equal(other Metric) bool {
+	if len(m.Samples) != len(other.Samples) || m.Min != other.Min || m.Max != other.Max {
+		return false
+	}
+	for i := range m.Samples {
+		if !m.Samples[i].equal(other.Samples[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diff appends the samples of next after those of m which next keeps, or
+// when it keeps none in order, drops them all and appends all of next.
+func (m Metric) diff(next Metric) MetricDelta {
+	md := MetricDelta{Dropped: len(m.Samples), Samples: next.Samples, Min: next.Min, Max: next.Max}
+	if len(next.Samples) == 0 {
+		return md
+	}
+	first := sort.Search(len(m.Samples), func(i int) bool {
+		return !m.Samples[i].Timestamp.Before(next.first())
+	})
+	kept := m.Samples[first:]
+	if len(kept) > len(next.Samples) {
+		return md
+	}
+	for i := range kept {
+		if !kept[i].equal(next.Samples[i]) {
+			return md
+		}
+	}
+	md.Dropped, md.Samples = first, next.Samples[len(kept):]
+	return md
+}
+
+// Patch applies the delta d, from r, returning the report following r.  It
+// fails if d isn't from r, as far as that can be told.  The nodes of the
+// result are copied, so that they can be modified without modifying r.
+func (r Report) Patch(d Delta) (Report, error) {
+	result := d.Report
+	for name := range d.Topologies {
+		if r.topology(name) == nil {
+			return result, fmt.Errorf("delta of unknown topology %q", name)
+		}
+	}
+	var err error
+	result.WalkNamedTopologies(func(name string, t *Topology) {
+		prev := r.topology(name).Nodes
+		td, ok := d.Topologies[name]
+		if !ok {
+			t.Nodes = prev.Copy()
+			return
+		}
+		nodes, patchErr := prev.patch(td)
+		if patchErr != nil && err == nil {
+			err = fmt.Errorf("%s: %v", name, patchErr)
+		}
+		t.Nodes = nodes
+	})
+	return result, err
+}
+
+func (n Nodes) patch(td TopologyDelta) (Nodes, error) {
+	result := n.Copy()
+	if result == nil {
+		result = Nodes{}
+	}
+	for _, id := range td.Removed {
+		if _, ok := result[id]; !ok {
+			return nil, fmt.Errorf("removed node %q not found", id)
+		}
+		delete(result, id)
+	}
+	for id, node := range td.Added {
+		result[id] = node
+	}
+	for _, nd := range td.Updated {
+		prev, ok := result[nd.ID]
+		if !ok {
+			return nil, fmt.Errorf("updated node %q not found", nd.ID)
+		}
+		node, err := prev.patch(nd)
+		if err != nil {
+			return nil, fmt.Errorf("node %q: %v", nd.ID, err)
+		}
+		result[nd.ID] = node
+	}
+	return result, nil
+}
+
+func (n Node) patch(nd NodeDelta) (Node, error) {
+	if nd.Sets != nil {
+		n.Sets = *nd.Sets
+	}
+	if nd.Adjacency != nil {
+		n.Adjacency = *nd.Adjacency
+	}
+	if nd.Edges != nil {
+		n.Edges = *nd.Edges
+	}
+	if nd.Parents != nil {
+		n.Parents = *nd.Parents
+	}
+	if nd.Children != nil {
+		n.Children = *nd.Children
+	}
+	if nd.Latest != nil {
+		n.Latest = *nd.Latest
+	} else if !nd.LatestTimestamp.IsZero() {
+		n.Latest = n.Latest.withTimestamp(nd.LatestTimestamp)
+	}
+	if len(nd.Metrics) == 0 && len(nd.RemovedMetrics) == 0 {
+		return n, nil
+	}
+	metrics := n.Metrics.Copy()
+	for _, key := range nd.RemovedMetrics {
+		delete(metrics, key)
+	}
+	for key, md := range nd.Metrics {
+		prev := metrics[key]
+		if md.Dropped > len(prev.Samples) {
+			return n, fmt.Errorf("metric %q: can't drop %d of %d samples", key, md.Dropped, len(prev.Samples))
+		}
+		var samples []Sample
+		if kept := len(prev.Samples) - md.Dropped; kept+len(md.Samples) > 0 {
+			samples = make([]Sample, 0, kept+len(md.Samples))
+			samples = append(append(samples, prev.Samples[md.Dropped:]...), md.Samples...)
+		}
+		metrics[key] = Metric{Samples: samples, Min: md.Min, Max: md.Max}
+	}
+	n.Metrics = metrics
+	return n, nil
+}
+
+// WriteBinary writes a Delta as a gzipped msgpack into a bytes.Buffer
+func (d Delta) WriteBinary() (*bytes.Buffer, error) {
+	return d.writeBinary(nil)
+}
+
+func (d Delta) writeBinary(h hash.Hash) (*bytes.Buffer, error) {
+	return writeGzipped(h, func(w io.Writer) error {
+		return codec.NewEncoder(w, &codec.MsgpackHandle{}).Encode(&d)
+	})
+}
+
+// MakeDeltaFromBinary reads a Delta written by WriteBinary.
+func MakeDeltaFromBinary(ctx context.Context, r io.Reader, gzipped bool) (*Delta, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "report.MakeDeltaFromBinary")
+	defer span.Finish()
+	var err error
+	if gzipped {
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	d := Delta{Report: MakeReport()}
+	if err := codec.NewDecoderBytes(buf.Bytes(), &codec.MsgpackHandle{}).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
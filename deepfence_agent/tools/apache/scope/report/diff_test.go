@@ -0,0 +1,185 @@
+package report_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	s_reflect "github.com/weaveworks/scope/test/reflect"
+)
+
+func TestDiffPatch(t *testing.T) {
+	var (
+		t1, t2, t3 = time.Unix(1, 0).UTC(), time.Unix(2, 0).UTC(), time.Unix(3, 0).UTC()
+		process    = func(id string, ts time.Time) report.Node {
+			return report.MakeNode(id).WithTopology(report.Process).
+				WithLatest(report.PID, ts, id).
+				WithLatest(report.Name, ts, "nginx")
+		}
+		withCPU = func(n report.Node, samples ...report.Sample) report.Node {
+			return n.WithMetric("cpu", report.MakeMetric(samples))
+		}
+		prev = report.MakeReport()
+	)
+	prev.TS = t1
+	prev.Process.AddNode(withCPU(process("1", t1), report.Sample{Timestamp: t1, Value: 1}))
+	prev.Process.AddNode(process("2", t1))
+	prev.Process.AddNode(process("3", t1).WithSets(report.MakeSets().Add("args", report.MakeStringSet("-v"))))
+	prev.Process.AddNode(process("4", t1))
+	prev.Container.AddNode(report.MakeNode("c").WithTopology(report.Container))
+
+	for _, tc := range []struct {
+		name    string
+		next    func() report.Report
+		updated int
+	}{
+		{"unchanged", prev.Copy, 0},
+		{"later", func() report.Report {
+			next := report.MakeReport()
+			next.TS = t2
+			// Only the timestamps of the latest values change
+			next.Process.AddNode(withCPU(process("1", t2), report.Sample{Timestamp: t1, Value: 1}))
+			next.Process.AddNode(process("2", t2))
+			next.Process.AddNode(process("3", t2).WithSets(report.MakeSets().Add("args", report.MakeStringSet("-v"))))
+			next.Process.AddNode(process("4", t2))
+			next.Container.AddNode(report.MakeNode("c").WithTopology(report.Container))
+			return next
+		}, 4},
+		{"changed", func() report.Report {
+			next := report.MakeReport()
+			next.TS = t3
+			next.Window = time.Second
+			// A sample appended, and one dropped
+			next.Process.AddNode(withCPU(process("1", t1), report.Sample{Timestamp: t1, Value: 1}, report.Sample{Timestamp: t3, Value: 3}))
+			// A value changed, and a metric added
+			next.Process.AddNode(withCPU(process("2", t1).WithLatest(report.Name, t3, "apache"), report.Sample{Timestamp: t3, Value: 2}))
+			// Sets, adjacency and parents changed
+			next.Process.AddNode(process("3", t1).WithAdjacent("4").WithParent(report.Container, "c"))
+			// Process 4 gone, and 5 new
+			next.Process.AddNode(process("5", t3))
+			// And the container changed topology
+			next.Container.AddNode(report.MakeNode("c").WithTopology(report.Pod))
+			next.Process.Controls.AddControl(report.Control{ID: "kill", Human: "Kill"})
+			return next
+		}, 3},
+		{"metrics removed", func() report.Report {
+			next := prev.Copy()
+			next.Process.AddNode(process("1", t1))
+			next.Process.Nodes["1"] = process("1", t1)
+			return next
+		}, 1},
+	} {
+		next := tc.next()
+		d := prev.Diff(next)
+		updated := 0
+		for _, td := range d.Topologies {
+			updated += len(td.Updated)
+		}
+		if updated != tc.updated {
+			t.Errorf("%s: expected %d nodes updated, got %d: %v", tc.name, tc.updated, updated, d.Topologies)
+		}
+		have, err := prev.Patch(d)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !s_reflect.DeepEqual(next, have) {
+			t.Errorf("%s: expected %v, got %v", tc.name, next, have)
+		}
+	}
+
+	if d := prev.Diff(prev); len(d.Topologies) != 0 {
+		t.Errorf("Expected no changes from a report to itself, got %v", d.Topologies)
+	}
+}
+
+func TestDiffMetricsAppend(t *testing.T) {
+	samples := func(from, to int) []report.Sample {
+		result := []report.Sample{}
+		for i := from; i < to; i++ {
+			result = append(result, report.Sample{Timestamp: time.Unix(int64(i), 0).UTC(), Value: float64(i)})
+		}
+		return result
+	}
+	node := func(samples []report.Sample) report.Report {
+		r := report.MakeReport()
+		r.Host.AddNode(report.MakeNode("h").WithTopology(report.Host).WithMetric("load", report.MakeMetric(samples)))
+		return r
+	}
+	for _, tc := range []struct {
+		prev, next []report.Sample
+		appended   int
+	}{
+		{samples(0, 10), samples(5, 15), 5}, // a rolling window
+		{samples(0, 10), samples(0, 11), 1}, // only appended to
+		{samples(0, 1), samples(1, 2), 1},   // singletons
+		{samples(0, 10), samples(20, 25), 5},
+		{samples(0, 10), samples(3, 6), 3}, // not a suffix, so whole
+		{samples(0, 10), nil, 0},
+	} {
+		prev, next := node(tc.prev), node(tc.next)
+		d := prev.Diff(next)
+		md := d.Topologies[report.Host].Updated[0].Metrics["load"]
+		if len(md.Samples) != tc.appended {
+			t.Errorf("%v -> %v: expected %d samples appended, got %d", tc.prev, tc.next, tc.appended, len(md.Samples))
+		}
+		have, err := prev.Patch(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s_reflect.DeepEqual(next, have) {
+			t.Errorf("%v -> %v: got %v", tc.prev, tc.next, have.Host.Nodes["h"].Metrics)
+		}
+	}
+}
+
+func TestPatchWrongBase(t *testing.T) {
+	prev, next, other := report.MakeReport(), report.MakeReport(), report.MakeReport()
+	prev.Process.AddNode(report.MakeNodeWith("1", map[string]string{"a": "b"}).WithTopology(report.Process))
+	next.Process.AddNode(report.MakeNodeWith("1", map[string]string{"a": "c"}).WithTopology(report.Process))
+	if _, err := other.Patch(prev.Diff(next)); err == nil {
+		t.Error("Expected an error patching a node which isn't there")
+	}
+	if _, err := other.Patch(prev.Diff(report.MakeReport())); err == nil {
+		t.Error("Expected an error removing a node which isn't there")
+	}
+}
+
+func TestDiffPatchRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	prev := randomReport(random)
+	for i := 0; i < 200; i++ {
+		// Successive random reports share node IDs, so have nodes
+		// added, changed and removed between them
+		next := randomReport(random)
+		have, err := prev.Patch(prev.Diff(next))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !s_reflect.DeepEqual(next, have) {
+			t.Fatalf("%d: expected %v, got %v", i, next, have)
+		}
+		prev = next
+	}
+}
+
+func TestDeltaRoundtrip(t *testing.T) {
+	prev, next := makeTestReport(), makeTestReport()
+	next.Process.AddNode(report.MakeNodeWith("new", map[string]string{"a": "b"}).WithTopology(report.Process))
+	d := prev.Diff(next)
+	buf, err := d.WriteBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := report.MakeDeltaFromBinary(context.Background(), buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := prev.Patch(*have)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s_reflect.DeepEqual(next, patched) {
+		t.Errorf("expected %v, got %v", next, patched)
+	}
+}
@@ -0,0 +1,153 @@
+package report
+
+import (
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+	"github.com/weaveworks/ps"
+)
+
+// EdgeMetadata describes the traffic over an edge from a node to another:
+// the bytes and packets sent from the node (egress), and back to it
+// (ingress).  Probes only report what they count, such as conntrack does
+// with accounting enabled, and the counts add up as reports are merged.
+type EdgeMetadata struct {
+	EgressPacketCount  uint64 `json:"egress_packet_count,omitempty"`
+	IngressPacketCount uint64 `json:"ingress_packet_count,omitempty"`
+	EgressByteCount    uint64 `json:"egress_byte_count,omitempty"`
+	IngressByteCount   uint64 `json:"ingress_byte_count,omitempty"`
+}
+
+// Merge returns the sum of the counts of e and other.
+func (e EdgeMetadata) Merge(other EdgeMetadata) EdgeMetadata {
+	return EdgeMetadata{
+		EgressPacketCount:  e.EgressPacketCount + other.EgressPacketCount,
+		IngressPacketCount: e.IngressPacketCount + other.IngressPacketCount,
+		EgressByteCount:    e.EgressByteCount + other.EgressByteCount,
+		IngressByteCount:   e.IngressByteCount + other.IngressByteCount,
+	}
+}
+
+func (e EdgeMetadata) String() string {
+	return fmt.Sprintf("{egress %d bytes %d packets, ingress %d bytes %d packets}",
+		e.EgressByteCount, e.EgressPacketCount, e.IngressByteCount, e.IngressPacketCount)
+}
+
+// EdgeMetadatas are the metadata of the edges from a node, keyed on the ID
+// of the node at the other end.
+// It is immutable.
+type EdgeMetadatas struct {
+	psMap ps.Map
+}
+
+var emptyEdgeMetadatas = EdgeMetadatas{ps.NewMap()}
+
+// MakeEdgeMetadatas returns an empty EdgeMetadatas
+func MakeEdgeMetadatas() EdgeMetadatas {
+	return emptyEdgeMetadatas
+}
+
+// Add merges value into the metadata of the edge to key.
+func (c EdgeMetadatas) Add(key string, value EdgeMetadata) EdgeMetadatas {
+	if c.psMap == nil {
+		c = emptyEdgeMetadatas
+	}
+	if existing, ok := c.psMap.Lookup(key); ok {
+		value = existing.(EdgeMetadata).Merge(value)
+	}
+	return EdgeMetadatas{c.psMap.Set(key, value)}
+}
+
+// Lookup returns the metadata of the edge to key.
+func (c EdgeMetadatas) Lookup(key string) (EdgeMetadata, bool) {
+	if c.psMap != nil {
+		if existing, ok := c.psMap.Lookup(key); ok {
+			return existing.(EdgeMetadata), true
+		}
+	}
+	return EdgeMetadata{}, false
+}
+
+// Size returns the number of edges
+func (c EdgeMetadatas) Size() int {
+	if c.psMap == nil {
+		return 0
+	}
+	return c.psMap.Size()
+}
+
+// Merge merges two sets of edge metadata into a fresh one, adding up the
+// counts of the edges in both.
+func (c EdgeMetadatas) Merge(other EdgeMetadatas) EdgeMetadatas {
+	var (
+		cSize     = c.Size()
+		otherSize = other.Size()
+		result    = c.psMap
+		iter      = other.psMap
+	)
+	switch {
+	case cSize == 0:
+		return other
+	case otherSize == 0:
+		return c
+	case cSize < otherSize:
+		result, iter = iter, result
+	}
+	iter.ForEach(func(key string, value interface{}) {
+		if existing, ok := result.Lookup(key); ok {
+			value = existing.(EdgeMetadata).Merge(value.(EdgeMetadata))
+		}
+		result = result.Set(key, value)
+	})
+	return EdgeMetadatas{result}
+}
+
+// ForEach executes fn on the metadata of each edge
+func (c EdgeMetadatas) ForEach(fn func(k string, v EdgeMetadata)) {
+	if c.psMap != nil {
+		c.psMap.ForEach(func(key string, value interface{}) {
+			fn(key, value.(EdgeMetadata))
+		})
+	}
+}
+
+func (c EdgeMetadatas) String() string {
+	return mapToString(c.psMap)
+}
+
+// DeepEqual tests equality with other EdgeMetadatas
+func (c EdgeMetadatas) DeepEqual(d EdgeMetadatas) bool {
+	return mapEqual(c.psMap, d.psMap, func(a, b interface{}) bool {
+		return a.(EdgeMetadata) == b.(EdgeMetadata)
+	})
+}
+
+// CodecEncodeSelf implements codec.Selfer
+func (c *EdgeMetadatas) CodecEncodeSelf(encoder *codec.Encoder) {
+	mapWrite(c.psMap, encoder, func(encoder *codec.Encoder, val interface{}) {
+		e := val.(EdgeMetadata)
+		encoder.Encode(&e)
+	})
+}
+
+// CodecDecodeSelf implements codec.Selfer
+func (c *EdgeMetadatas) CodecDecodeSelf(decoder *codec.Decoder) {
+	out := mapRead(decoder, func(isNil bool) interface{} {
+		var value EdgeMetadata
+		if !isNil {
+			decoder.Decode(&value)
+		}
+		return value
+	})
+	*c = EdgeMetadatas{out}
+}
+
+// MarshalJSON shouldn't be used, use CodecEncodeSelf instead
+func (EdgeMetadatas) MarshalJSON() ([]byte, error) {
+	panic("MarshalJSON shouldn't be used, use CodecEncodeSelf instead")
+}
+
+// UnmarshalJSON shouldn't be used, use CodecDecodeSelf instead
+func (*EdgeMetadatas) UnmarshalJSON(b []byte) error {
+	panic("UnmarshalJSON shouldn't be used, use CodecDecodeSelf instead")
+}
@@ -0,0 +1,55 @@
+package report_test
+
+import (
+	"testing"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func TestEdgeMetadatasMerge(t *testing.T) {
+	var (
+		ab = report.EdgeMetadata{EgressByteCount: 100, EgressPacketCount: 2, IngressByteCount: 10}
+		ac = report.EdgeMetadata{IngressByteCount: 5, IngressPacketCount: 1}
+	)
+	for _, testcase := range []struct {
+		a, b report.EdgeMetadatas
+		want report.EdgeMetadatas
+	}{
+		{report.MakeEdgeMetadatas(), report.MakeEdgeMetadatas(), report.MakeEdgeMetadatas()},
+		{
+			report.MakeEdgeMetadatas(),
+			report.MakeEdgeMetadatas().Add("b", ab),
+			report.MakeEdgeMetadatas().Add("b", ab),
+		},
+		{
+			report.MakeEdgeMetadatas().Add("c", ac),
+			report.MakeEdgeMetadatas().Add("b", ab),
+			report.MakeEdgeMetadatas().Add("b", ab).Add("c", ac),
+		},
+		{
+			// The counts of the same edge add up
+			report.MakeEdgeMetadatas().Add("b", ab).Add("c", ac),
+			report.MakeEdgeMetadatas().Add("b", ab),
+			report.MakeEdgeMetadatas().
+				Add("b", report.EdgeMetadata{EgressByteCount: 200, EgressPacketCount: 4, IngressByteCount: 20}).
+				Add("c", ac),
+		},
+	} {
+		if have := testcase.a.Merge(testcase.b); !reflect.DeepEqual(testcase.want, have) {
+			t.Errorf("%v + %v: expected %v, got %v", testcase.a, testcase.b, testcase.want, have)
+		}
+	}
+}
+
+func TestNodeWithEdge(t *testing.T) {
+	md := report.EdgeMetadata{EgressByteCount: 100}
+	n := report.MakeNode("a").WithEdge("b", md)
+	if !n.Adjacency.Contains("b") {
+		t.Errorf("Expected b to be adjacent, got %v", n.Adjacency)
+	}
+	merged := n.Merge(report.MakeNode("a").WithEdge("b", md))
+	if have, _ := merged.Edges.Lookup("b"); have.EgressByteCount != 200 {
+		t.Errorf("Expected the counts of merged nodes to add up, got %v", have)
+	}
+}
@@ -162,6 +162,18 @@ var (
 	// ParseJobNodeID parses a job node ID
 	ParseJobNodeID = parseSingleComponentID("job")
 
+	// MakeIngressNodeID produces an ingress node ID from its composite parts.
+	MakeIngressNodeID = makeSingleComponentID("ingress")
+
+	// ParseIngressNodeID parses an ingress node ID
+	ParseIngressNodeID = parseSingleComponentID("ingress")
+
+	// MakeCustomResourceNodeID produces a custom resource node ID from its composite parts.
+	MakeCustomResourceNodeID = makeSingleComponentID("custom_resource")
+
+	// ParseCustomResourceNodeID parses a custom resource node ID
+	ParseCustomResourceNodeID = parseSingleComponentID("custom_resource")
+
 	// MakeCloudProviderNodeID produces a cloud provider node ID from its composite parts.
 	MakeCloudProviderNodeID = makeSingleComponentID("cloud_provider")
 
@@ -229,6 +241,13 @@ var (
 	ParseVolumeSnapshotDataNodeID = parseSingleComponentID("volume_snapshot_data")
 )
 
+// MakeProviderRegionNodeID produces the cloud region node ID of a region of
+// a cloud provider.  Providers name their regions independently, so the ID
+// of a region includes its provider.
+func MakeProviderRegionNodeID(cloudProvider, cloudRegion string) string {
+	return MakeCloudRegionNodeID(cloudRegion + "-" + cloudProvider)
+}
+
 // makeSingleComponentID makes a single-component node id encoder
 func makeSingleComponentID(tag string) func(string) string {
 	return func(id string) string {
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"github.com/weaveworks/ps"
+)
+
+// interner interns strings, so that each distinct string is allocated once
+// however many times it is repeated: the node IDs of a report are repeated
+// in adjacencies, parents and children, and the latest keys and many of the
+// values across the nodes of each topology.  An interner is scoped to a
+// single operation, such as decoding a report, and dropped with it, rather
+// than shared, so that it doesn't grow without bound.  It isn't safe for
+// concurrent use.
+type interner map[string]string
+
+// bytes returns b as a string, which is only allocated the first time it
+// is seen.
+func (in interner) bytes(b []byte) string {
+	if s, ok := in[string(b)]; ok {
+		return s
+	}
+	s := lookupCommonKey(b)
+	in[s] = s
+	return s
+}
+
+// string returns the string equal to s which was seen first, so that
+// strings decoded separately share a single allocation.
+func (in interner) string(s string) string {
+	if t, ok := in[s]; ok {
+		return t
+	}
+	s = commonKey(s)
+	in[s] = s
+	return s
+}
+
+// intern interns the strings of a decoded report in place.  The codec
+// decoders allocate each string as they read it, so without this a report
+// would keep a copy of a node ID for every adjacency and parent that
+// refers to it.
+func (r *Report) intern() {
+	in := interner{}
+	r.WalkTopologies(func(t *Topology) {
+		for id, n := range t.Nodes {
+			t.Nodes[id] = n.intern(in, id)
+		}
+	})
+}
+
+func (n Node) intern(in interner, id string) Node {
+	n.ID = in.string(id)
+	n.Topology = in.string(n.Topology)
+	for i, a := range n.Adjacency {
+		n.Adjacency[i] = in.string(a)
+	}
+	for i := range n.Latest {
+		n.Latest[i].key = in.string(n.Latest[i].key)
+		n.Latest[i].Value = in.string(n.Latest[i].Value)
+	}
+	n.Parents = n.Parents.intern(in)
+	if n.Edges.Size() > 0 {
+		edges := ps.NewMap()
+		n.Edges.ForEach(func(to string, e EdgeMetadata) {
+			edges = edges.UnsafeMutableSet(in.string(to), e)
+		})
+		n.Edges = EdgeMetadatas{edges}
+	}
+	return n
+}
+
+func (s Sets) intern(in interner) Sets {
+	if s.psMap == nil || s.psMap.IsNil() {
+		return s
+	}
+	out := ps.NewMap()
+	s.psMap.ForEach(func(key string, val interface{}) {
+		set := val.(StringSet)
+		for i, v := range set {
+			set[i] = in.string(v)
+		}
+		out = out.UnsafeMutableSet(in.string(key), set)
+	})
+	return Sets{out}
+}
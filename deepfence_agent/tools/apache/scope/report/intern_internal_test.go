@@ -0,0 +1,66 @@
+package report
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// sameString is whether a and b point to the same bytes.
+func sameString(a, b string) bool {
+	return *(*uintptr)(unsafe.Pointer(&a)) == *(*uintptr)(unsafe.Pointer(&b))
+}
+
+// clone returns a copy of s which doesn't share its bytes, as decoding
+// would.
+func clone(s string) string {
+	return string([]byte(s))
+}
+
+func TestReportIntern(t *testing.T) {
+	var (
+		host   = MakeHostNodeID("node1")
+		local  = MakeEndpointNodeID("node1", "", "10.0.0.1", "80")
+		remote = MakeEndpointNodeID("node2", "", "10.0.0.2", "80")
+		rpt    = MakeReport()
+	)
+	rpt.Host.AddNode(MakeNode(clone(host)).WithTopology(Host))
+	rpt.Endpoint.AddNode(MakeNodeWith(clone(local), map[string]string{HostNodeID: clone(host)}).WithAdjacent(clone(remote)))
+	rpt.Endpoint.AddNode(MakeNodeWith(clone(remote), map[string]string{HostNodeID: clone(host)}).WithAdjacent(clone(local)))
+	rpt.Container.AddNode(MakeNode(clone("c1")).WithParent(Host, clone(host)))
+	want := rpt.Copy()
+	rpt.intern()
+	if !reflect.DeepEqual(want, rpt) {
+		t.Fatalf("%v != %v", want, rpt)
+	}
+
+	if !sameString(rpt.Endpoint.Nodes[local].ID, rpt.Endpoint.Nodes[remote].Adjacency[0]) {
+		t.Error("Expected the ID of the endpoint to be shared with the adjacency of its peer")
+	}
+	value, _ := rpt.Endpoint.Nodes[local].Latest.Lookup(HostNodeID)
+	if !sameString(rpt.Host.Nodes[host].ID, value) {
+		t.Error("Expected the ID of the host to be shared with the latest values of the endpoints")
+	}
+	parents, _ := rpt.Container.Nodes["c1"].Parents.Lookup(Host)
+	if !sameString(rpt.Host.Nodes[host].ID, parents[0]) {
+		t.Error("Expected the ID of the host to be shared with the parents of the container")
+	}
+}
+
+func TestMakeNodeWithCommonKeys(t *testing.T) {
+	n := MakeNodeWith("n", map[string]string{clone(HostNodeID): "node1"})
+	if !sameString(n.Latest[0].key, HostNodeID) {
+		t.Error("Expected the latest key to be the common key")
+	}
+}
+
+func TestAddNodeKeepsID(t *testing.T) {
+	topology := MakeTopology()
+	id := MakeHostNodeID("node1")
+	topology.AddNode(MakeNode(id))
+	topology.AddNode(MakeNode(clone(id)).WithLatests(map[string]string{HostName: "node1"}))
+	if !sameString(topology.Nodes[id].ID, id) {
+		t.Error("Expected the ID of the merged node to be the one the topology held")
+	}
+}
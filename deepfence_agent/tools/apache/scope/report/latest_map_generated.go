@@ -45,7 +45,12 @@ func (m StringLatestMap) Size() int {
 // Merge produces a StringLatestMap containing the keys from both inputs.
 // When both inputs contain the same key, the newer value is used.
 // Tries to return one of its inputs, if that already holds the correct result.
+// Past MaxLatestEntries keys, the oldest values are evicted.
 func (m StringLatestMap) Merge(n StringLatestMap) StringLatestMap {
+	return m.merge(n).evictOldest(MaxLatestEntries)
+}
+
+func (m StringLatestMap) merge(n StringLatestMap) StringLatestMap {
 	switch {
 	case len(m) == 0:
 		return n
@@ -132,6 +137,12 @@ func (m StringLatestMap) LookupEntry(key string) (string, time.Time, bool) {
 	return zero, time.Time{}, false
 }
 
+// Timestamp returns when the value for the given key was last set.
+func (m StringLatestMap) Timestamp(key string) (time.Time, bool) {
+	_, timestamp, ok := m.LookupEntry(key)
+	return timestamp, ok
+}
+
 // locate the position where key should go, and make room for it if not there already
 func (m *StringLatestMap) locate(key string) int {
 	i := sort.Search(len(*m), func(i int) bool {
@@ -175,6 +186,54 @@ func (m StringLatestMap) ForEach(fn func(k string, timestamp time.Time, v string
 	}
 }
 
+// Prune returns the StringLatestMap without the values set before
+// olderThan.  It returns m itself if there are none.
+func (m StringLatestMap) Prune(olderThan time.Time) StringLatestMap {
+	kept := 0
+	for _, e := range m {
+		if !e.Timestamp.Before(olderThan) {
+			kept++
+		}
+	}
+	if kept == len(m) {
+		return m
+	}
+	out := make([]stringLatestEntry, 0, kept)
+	for _, e := range m {
+		if !e.Timestamp.Before(olderThan) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// evictOldest returns the StringLatestMap with only its max newest
+// values, or m itself if it has no more than that; max <= 0 means no
+// limit.  Values set at the same time are kept in key order.
+func (m StringLatestMap) evictOldest(max int) StringLatestMap {
+	if max <= 0 || len(m) <= max {
+		return m
+	}
+	byAge := make([]int, len(m))
+	for i := range byAge {
+		byAge[i] = i
+	}
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return m[byAge[i]].Timestamp.After(m[byAge[j]].Timestamp)
+	})
+	keep := make([]bool, len(m))
+	for _, i := range byAge[:max] {
+		keep[i] = true
+	}
+	out := make([]stringLatestEntry, 0, max)
+	for i := range m {
+		if keep[i] {
+			out = append(out, m[i])
+		}
+	}
+	return out
+}
+
 // String returns the StringLatestMap's string representation.
 func (m StringLatestMap) String() string {
 	buf := bytes.NewBufferString("{")
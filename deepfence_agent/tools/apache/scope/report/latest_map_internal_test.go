@@ -3,6 +3,7 @@ package report
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -133,6 +134,123 @@ func TestLatestMapMerge(t *testing.T) {
 	}
 }
 
+func TestLatestMapTimestamp(t *testing.T) {
+	now := time.Now()
+	have := MakeStringLatestMap().
+		Set("foo", now.Add(-1), "Baz").
+		Set("foo", now, "Bar")
+	if ts, ok := have.Timestamp("foo"); !ok || !ts.Equal(now) {
+		t.Errorf("Expected foo to have been set at %v, got %v", now, ts)
+	}
+	if ts, ok := have.Timestamp("bar"); ok || !ts.IsZero() {
+		t.Errorf("Expected bar not to have been set, got %v", ts)
+	}
+}
+
+func TestLatestMapPrune(t *testing.T) {
+	now := time.Now()
+	have := MakeStringLatestMap().
+		Set("old", now.Add(-time.Hour), "a").
+		Set("then", now.Add(-time.Minute), "b").
+		Set("now", now, "c")
+	want := MakeStringLatestMap().
+		Set("then", now.Add(-time.Minute), "b").
+		Set("now", now, "c")
+	if pruned := have.Prune(now.Add(-time.Minute)); !reflect.DeepEqual(want, pruned) {
+		t.Errorf(test.Diff(want, pruned))
+	}
+	if pruned := have.Prune(now.Add(-2 * time.Hour)); !reflect.DeepEqual(have, pruned) {
+		t.Errorf(test.Diff(have, pruned))
+	}
+	if pruned := have.Prune(now.Add(time.Second)); len(pruned) != 0 {
+		t.Errorf("Expected everything pruned, got %v", pruned)
+	}
+}
+
+func TestLatestMapMergeMaxEntries(t *testing.T) {
+	defer func(max int) { MaxLatestEntries = max }(MaxLatestEntries)
+	MaxLatestEntries = 3
+
+	now := time.Now()
+	a := MakeStringLatestMap().
+		Set("a", now.Add(-4), "1").
+		Set("b", now.Add(-1), "2").
+		Set("c", now.Add(-3), "3")
+	b := MakeStringLatestMap().
+		Set("c", now, "4").
+		Set("d", now.Add(-2), "5")
+	want := MakeStringLatestMap().
+		Set("b", now.Add(-1), "2").
+		Set("c", now, "4").
+		Set("d", now.Add(-2), "5")
+	if have := a.Merge(b); !reflect.DeepEqual(want, have) {
+		t.Errorf(test.Diff(want, have))
+	}
+	if have := b.Merge(a); !reflect.DeepEqual(want, have) {
+		t.Errorf(test.Diff(want, have))
+	}
+}
+
+// Merging keeps the newest value of every key, whichever way round, and
+// with MaxLatestEntries set, only the newest of those.
+func TestLatestMapMergeRandom(t *testing.T) {
+	defer func(max int) { MaxLatestEntries = max }(MaxLatestEntries)
+
+	random := rand.New(rand.NewSource(1))
+	start := time.Now()
+	randomMap := func() StringLatestMap {
+		m := MakeStringLatestMap()
+		for i := random.Intn(20); i > 0; i-- {
+			// Timestamps unique, so that the newest is well defined
+			m = m.Set(fmt.Sprint(random.Intn(30)), start.Add(time.Duration(random.Int63())), fmt.Sprint(random.Int()))
+		}
+		return m
+	}
+	for i := 0; i < 1000; i++ {
+		MaxLatestEntries = random.Intn(3) * random.Intn(20)
+		a, b := randomMap(), randomMap()
+
+		newest := map[string]stringLatestEntry{}
+		for _, m := range []StringLatestMap{a, b} {
+			for _, e := range m {
+				if prev, ok := newest[e.key]; !ok || prev.Timestamp.Before(e.Timestamp) {
+					newest[e.key] = e
+				}
+			}
+		}
+		expected := len(newest)
+		if MaxLatestEntries > 0 && expected > MaxLatestEntries {
+			expected = MaxLatestEntries
+		}
+
+		have := a.Merge(b)
+		if !reflect.DeepEqual(have, b.Merge(a)) {
+			t.Fatalf("%d: merge isn't commutative: %v, %v", i, have, b.Merge(a))
+		}
+		if len(have) != expected {
+			t.Fatalf("%d: expected %d values, got %d: %v", i, expected, len(have), have)
+		}
+		oldest := time.Time{}
+		for j, e := range have {
+			if j > 0 && have[j-1].key >= e.key {
+				t.Fatalf("%d: keys out of order: %v", i, have)
+			}
+			if want := newest[e.key]; !want.Equal(&e) {
+				t.Fatalf("%d: expected %s to be %v, got %v", i, e.key, want.String(), e.String())
+			}
+			if oldest.IsZero() || e.Timestamp.Before(oldest) {
+				oldest = e.Timestamp
+			}
+		}
+		// Nothing evicted newer than what was kept
+		for key, e := range newest {
+			if _, ok := have.Timestamp(key); !ok && !e.Timestamp.Before(oldest) {
+				t.Fatalf("%d: evicted %s, newer than %v: %v", i, key, oldest, have)
+			}
+		}
+	}
+}
+
 func makeBenchmarkMap(start, finish int, timestamp time.Time) StringLatestMap {
 	ret := MakeStringLatestMap()
 	for i := start; i < finish; i++ {
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits bound what is accepted in reports from probes, which can't be
+// trusted to be well-formed.  A zero limit isn't checked.
+type Limits struct {
+	MaxNodes     int           // nodes per topology
+	MaxIDLength  int           // bytes per node ID
+	MaxClockSkew time.Duration // how far in the future timestamps may be
+}
+
+// Classes of violations of Limits.  Node IDs with control characters or
+// which aren't UTF-8, and metrics with values which aren't numbers, are
+// always violations.
+const (
+	ViolationNodeCount = "node_count"
+	ViolationNodeID    = "node_id"
+	ViolationMetric    = "metric"
+	ViolationTimestamp = "timestamp"
+)
+
+// Violation is something in a report which violates Limits.
+type Violation struct {
+	Class    string
+	Topology string
+	NodeID   string // empty for violations of the whole topology
+	Detail   string
+}
+
+func (v Violation) String() string {
+	if v.NodeID == "" {
+		return fmt.Sprintf("%s: %s: %s", v.Class, v.Topology, v.Detail)
+	}
+	return fmt.Sprintf("%s: %s node %q: %s", v.Class, v.Topology, v.NodeID, v.Detail)
+}
+
+// ValidateLimits returns the violations of limits in the report, as
+// received at now.  Each node is reported at most once, for the first
+// violation found.
+func (r Report) ValidateLimits(limits Limits, now time.Time) []Violation {
+	var (
+		violations []Violation
+		future     time.Time
+	)
+	if limits.MaxClockSkew > 0 {
+		future = now.Add(limits.MaxClockSkew)
+	}
+	r.WalkNamedTopologies(func(name string, t *Topology) {
+		if limits.MaxNodes > 0 && len(t.Nodes) > limits.MaxNodes {
+			violations = append(violations, Violation{
+				Class:    ViolationNodeCount,
+				Topology: name,
+				Detail:   fmt.Sprintf("%d nodes, over the limit of %d", len(t.Nodes), limits.MaxNodes),
+			})
+			return
+		}
+		for id, n := range t.Nodes {
+			if class, detail := n.violation(id, limits, future); class != "" {
+				violations = append(violations, Violation{
+					Class:    class,
+					Topology: name,
+					NodeID:   id,
+					Detail:   detail,
+				})
+			}
+		}
+	})
+	return violations
+}
+
+// violation returns the class and details of the first violation of limits
+// by a node, if any.  Timestamps after future, unless it is zero, are
+// violations.
+func (n Node) violation(id string, limits Limits, future time.Time) (string, string) {
+	switch {
+	case limits.MaxIDLength > 0 && len(id) > limits.MaxIDLength:
+		return ViolationNodeID, fmt.Sprintf("%d bytes long, over the limit of %d", len(id), limits.MaxIDLength)
+	case !utf8.ValidString(id):
+		return ViolationNodeID, "not UTF-8"
+	}
+	for _, c := range id {
+		if unicode.IsControl(c) {
+			return ViolationNodeID, fmt.Sprintf("control character %U", c)
+		}
+	}
+
+	for key, metric := range n.Metrics {
+		if !isFinite(metric.Min) || !isFinite(metric.Max) {
+			return ViolationMetric, fmt.Sprintf("%s bounds %v, %v", key, metric.Min, metric.Max)
+		}
+		for _, s := range metric.Samples {
+			if !isFinite(s.Value) {
+				return ViolationMetric, fmt.Sprintf("%s value %v", key, s.Value)
+			}
+			if !future.IsZero() && s.Timestamp.After(future) {
+				return ViolationTimestamp, fmt.Sprintf("%s sampled at %v", key, s.Timestamp)
+			}
+		}
+	}
+
+	if !future.IsZero() {
+		for _, e := range n.Latest {
+			if e.Timestamp.After(future) {
+				return ViolationTimestamp, fmt.Sprintf("%s set at %v", e.key, e.Timestamp)
+			}
+		}
+	}
+	return "", ""
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// Sanitize strips the nodes in violation from the report: all of them for
+// violations of the node count of a topology.  The node maps of the
+// topologies stripped are replaced rather than modified, as they may be
+// shared, for instance with the report kept to patch deltas onto.
+func (r *Report) Sanitize(violations []Violation) {
+	stripped := map[string]bool{}
+	for _, v := range violations {
+		t := r.topology(v.Topology)
+		if t == nil {
+			continue
+		}
+		if v.NodeID == "" {
+			t.Nodes = Nodes{}
+			stripped[v.Topology] = true
+			continue
+		}
+		if !stripped[v.Topology] {
+			t.Nodes = t.Nodes.Copy()
+			stripped[v.Topology] = true
+		}
+		delete(t.Nodes, v.NodeID)
+	}
+}
@@ -0,0 +1,84 @@
+package report_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+var (
+	limitsNow = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	limits    = report.Limits{MaxNodes: 3, MaxIDLength: 32, MaxClockSkew: time.Hour}
+)
+
+func limitsTestReport() report.Report {
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNode("good;<host>").
+		WithLatest("hostname", limitsNow.Add(time.Minute), "good").
+		WithMetrics(report.Metrics{"cpu": report.MakeSingletonMetric(limitsNow, 0.5)}))
+	rpt.Host.AddNode(report.MakeNode("long;<host>" + strings.Repeat("x", 32)))
+	rpt.Host.AddNode(report.MakeNode("bad\x00;<host>"))
+	rpt.Container.AddNode(report.MakeNode("bad\xff;<container>"))
+	rpt.Container.AddNode(report.MakeNode("nan;<container>").
+		WithMetrics(report.Metrics{"cpu": report.MakeSingletonMetric(limitsNow, math.NaN())}))
+	rpt.Container.AddNode(report.MakeNode("future;<container>").
+		WithLatest("name", limitsNow.Add(2*time.Hour), "future"))
+	for _, id := range []string{"a", "b", "c", "d"} {
+		rpt.Process.AddNode(report.MakeNode("host;" + id))
+	}
+	return rpt
+}
+
+func TestValidateLimits(t *testing.T) {
+	rpt := limitsTestReport()
+	classes := map[string]string{}
+	for _, v := range rpt.ValidateLimits(limits, limitsNow) {
+		classes[v.Topology+" "+v.NodeID] = v.Class
+	}
+	want := map[string]string{
+		"host long;<host>" + strings.Repeat("x", 32): report.ViolationNodeID,
+		"host bad\x00;<host>":                        report.ViolationNodeID,
+		"container bad\xff;<container>":              report.ViolationNodeID,
+		"container nan;<container>":                  report.ViolationMetric,
+		"container future;<container>":               report.ViolationTimestamp,
+		"process ":                                   report.ViolationNodeCount,
+	}
+	if !reflect.DeepEqual(want, classes) {
+		t.Error(test.Diff(want, classes))
+	}
+
+	// With no limits, only the IDs and metrics which are never valid are
+	if have := rpt.ValidateLimits(report.Limits{}, limitsNow); len(have) != 3 {
+		t.Errorf("Expected 3 violations without limits, got %v", have)
+	}
+	if have := report.MakeReport().ValidateLimits(limits, limitsNow); len(have) != 0 {
+		t.Errorf("Expected no violations, got %v", have)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	rpt := limitsTestReport()
+	original := rpt.Copy()
+	hostNodes := rpt.Host.Nodes
+
+	rpt.Sanitize(rpt.ValidateLimits(limits, limitsNow))
+	if have := rpt.ValidateLimits(limits, limitsNow); len(have) != 0 {
+		t.Errorf("Expected no violations once sanitized, got %v", have)
+	}
+	if _, ok := rpt.Host.Nodes["good;<host>"]; !ok || len(rpt.Host.Nodes) != 1 {
+		t.Errorf("Expected only the good host to be kept, got %v", rpt.Host.Nodes)
+	}
+	if len(rpt.Container.Nodes) != 0 || len(rpt.Process.Nodes) != 0 {
+		t.Errorf("Expected no containers or processes, got %v and %v", rpt.Container.Nodes, rpt.Process.Nodes)
+	}
+
+	// The nodes of the original topologies are left as they were
+	if len(hostNodes) != len(original.Host.Nodes) {
+		t.Errorf("Expected the original hosts not to be modified, got %v", hostNodes)
+	}
+}
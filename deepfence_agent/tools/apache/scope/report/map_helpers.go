@@ -142,6 +142,10 @@ func mapWrite(m ps.Map, encoder *codec.Encoder, encodeValue func(*codec.Encoder,
 
 // Now follow helpers for StringLatestMap
 
+// MaxLatestEntries bounds the number of values in each latest map: merging
+// past it evicts the oldest.  Zero means no limit.
+var MaxLatestEntries = 0
+
 // These let us sort a StringLatestMap strings by key
 func (m StringLatestMap) Len() int           { return len(m) }
 func (m StringLatestMap) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -169,7 +173,7 @@ func (m StringLatestMap) addMapEntries(ts time.Time, n map[string]string) String
 	out := make(StringLatestMap, len(m), len(m)+len(n))
 	copy(out, m)
 	for k, v := range n {
-		out = append(out, stringLatestEntry{key: k, Value: v, Timestamp: ts})
+		out = append(out, stringLatestEntry{key: commonKey(k), Value: v, Timestamp: ts})
 	}
 	return out.sortedAndDeduplicated()
 }
@@ -180,7 +184,7 @@ func (m StringLatestMap) Propagate(from StringLatestMap, keys ...string) StringL
 	copy(out, m)
 	for _, k := range keys {
 		if v, ts, ok := from.LookupEntry(k); ok {
-			out = append(out, stringLatestEntry{key: k, Value: v, Timestamp: ts})
+			out = append(out, stringLatestEntry{key: commonKey(k), Value: v, Timestamp: ts})
 		}
 	}
 	return out.sortedAndDeduplicated()
@@ -8,8 +8,13 @@ const (
 	// probe/endpoint
 	ReverseDNSNames = "reverse_dns_names"
 	SnoopedDNSNames = "snooped_dns_names"
+	DNSName         = "dns_name"
 	CopyOf          = "copy_of"
 	ConnectionCount = "conn_count"
+	ProtocolHint    = "protocol_hint"
+	// ConnectionsSampled marks endpoints onto which connections were
+	// aggregated, as the probe saw too many
+	ConnectionsSampled = "conn_sampled"
 
 	// probe/process
 	PID     = "pid"
@@ -29,6 +34,11 @@ const (
 	DockerServiceName            = "service_name"
 	DockerStackNamespace         = "stack_namespace"
 	DockerDefaultNamespace       = "No stack"
+	DockerSwarmServiceImage      = "docker_swarm_service_image"
+	DockerSwarmServiceMode       = "docker_swarm_service_mode"
+	DockerSwarmServiceReplicas   = "docker_swarm_service_replicas"
+	DockerSwarmServiceRunning    = "docker_swarm_service_running"
+	DockerSwarmServiceUpdate     = "docker_swarm_service_update_status"
 	DockerStopContainer          = "docker_stop_container"
 	DockerStartContainer         = "docker_start_container"
 	DockerRestartContainer       = "docker_restart_container"
@@ -50,6 +60,22 @@ const (
 	DockerContainerUptime        = "docker_container_uptime"
 	DockerContainerRestartCount  = "docker_container_restart_count"
 	DockerContainerNetworkMode   = "docker_container_network_mode"
+	DockerPrivileged             = "docker_privileged"
+	DockerHostNetwork            = "docker_host_network"
+	DockerHostPID                = "docker_host_pid"
+	DockerCapAdd                 = "docker_cap_add"
+	DockerSecurityOpt            = "docker_security_opt"
+	DockerReadonlyRootfs         = "docker_readonly_rootfs"
+	DockerSecurityScore          = "docker_security_score"
+	DockerConnected              = "docker_connected"
+	DockerOOMKilled              = "docker_oom_killed"
+	DockerLastExitCode           = "docker_last_exit_code"
+	DockerLastExitTime           = "docker_last_exit_time"
+	DockerExitHistoryTablePrefix = "docker_exit_history_"
+	DockerImageRegistry          = "docker_image_registry"
+	DockerImageProvenancePrefix  = "docker_image_provenance_"
+	DockerImageRepoTags          = "docker_image_repo_tags"
+	DockerImageRepoDigests       = "docker_image_repo_digests"
 	DockerEnvPrefix              = "docker_env_"
 	// probe/kubernetes
 	KubernetesName                 = "kubernetes_name"
@@ -79,6 +105,13 @@ const (
 	KubernetesSuspended            = "kubernetes_suspended"
 	KubernetesLastScheduled        = "kubernetes_last_scheduled"
 	KubernetesActiveJobs           = "kubernetes_active_jobs"
+	KubernetesSucceededJobs        = "kubernetes_succeeded_jobs"
+	KubernetesFailedJobs           = "kubernetes_failed_jobs"
+	KubernetesActivePods           = "kubernetes_active_pods"
+	KubernetesSucceededPods        = "kubernetes_succeeded_pods"
+	KubernetesFailedPods           = "kubernetes_failed_pods"
+	KubernetesStartTime            = "kubernetes_start_time"
+	KubernetesCompletionTime       = "kubernetes_completion_time"
 	KubernetesType                 = "kubernetes_type"
 	KubernetesPorts                = "kubernetes_ports"
 	KubernetesVolumeClaim          = "kubernetes_volume_claim"
@@ -99,6 +132,64 @@ const (
 	KubernetesDescribe             = "kubernetes_describe"
 	KubernetesClusterId            = "kubernetes_cluster_id"
 	KubernetesClusterName          = "kubernetes_cluster_name"
+	KubernetesIngressHosts         = "kubernetes_ingress_hosts"
+	KubernetesIngressTLSHosts      = "kubernetes_ingress_tls_hosts"
+	KubernetesIngressBackends      = "kubernetes_ingress_backends"
+	KubernetesIngressRulePrefix    = "kubernetes_ingress_rule_"
+	KubernetesExternalIPs          = "kubernetes_external_ips"
+	KubernetesExternalHostnames    = "kubernetes_external_hostnames"
+	KubernetesNodePorts            = "kubernetes_node_ports"
+	KubernetesExternallyExposed    = "externally_exposed"
+	KubernetesIngressIsolated      = "network_policy_ingress_isolated"
+	KubernetesEgressIsolated       = "network_policy_egress_isolated"
+	KubernetesNetworkPolicyPrefix  = "kubernetes_network_policy_"
+	KubernetesNodeReady            = "kubernetes_node_ready"
+	KubernetesMemoryPressure       = "kubernetes_memory_pressure"
+	KubernetesDiskPressure         = "kubernetes_disk_pressure"
+	KubernetesPIDPressure          = "kubernetes_pid_pressure"
+	KubernetesUnschedulable        = "kubernetes_unschedulable"
+	KubernetesTaints               = "kubernetes_taints"
+	KubernetesKubeletVersion       = "kubernetes_kubelet_version"
+	KubernetesRuntimeVersion       = "kubernetes_container_runtime_version"
+	KubernetesNodeConditionPrefix  = "kubernetes_node_condition_"
+	KubernetesNodeResourcePrefix   = "kubernetes_node_resource_"
+	KubernetesCPURequest           = "cpu_request"
+	KubernetesCPULimit             = "cpu_limit"
+	KubernetesMemoryRequest        = "memory_request"
+	KubernetesMemoryLimit          = "memory_limit"
+	KubernetesNearOOM              = "near_oom"
+	KubernetesHPA                  = "kubernetes_hpa"
+	KubernetesHPAMinReplicas       = "kubernetes_hpa_min_replicas"
+	KubernetesHPAMaxReplicas       = "kubernetes_hpa_max_replicas"
+	KubernetesHPACurrentReplicas   = "kubernetes_hpa_current_replicas"
+	KubernetesHPADesiredReplicas   = "kubernetes_hpa_desired_replicas"
+	KubernetesHPATargets           = "kubernetes_hpa_targets"
+	KubernetesHPALastScale         = "kubernetes_hpa_last_scale"
+	KubernetesPhase                = "kubernetes_phase"
+	KubernetesCSIDriver            = "kubernetes_csi_driver"
+	KubernetesVolumeInUse          = "kubernetes_volume_in_use"
+	KubernetesPSSEnforce           = "kubernetes_pss_enforce"
+	KubernetesPSSAudit             = "kubernetes_pss_audit"
+	KubernetesPSSWarn              = "kubernetes_pss_warn"
+	KubernetesNamespacePSSLevel    = "namespace_pss_level"
+	KubernetesAnnotationPrefix     = "kubernetes_annotations_"
+	KubernetesPodPhasePrefix       = "kubernetes_pod_phase_"
+	KubernetesResourceQuotaPrefix  = "kubernetes_resource_quota_"
+	KubernetesLimitRangePrefix     = "kubernetes_limit_range_"
+	KubernetesWarningEventPrefix   = "kubernetes_warning_events_"
+	KubernetesServiceAccount       = "kubernetes_service_account"
+	KubernetesAutomountToken       = "kubernetes_automount_token"
+	KubernetesImagePullSecrets     = "kubernetes_image_pull_secrets"
+	KubernetesClusterAdmin         = "kubernetes_cluster_admin"
+	KubernetesDefaultTokenMounted  = "default_sa_token_mounted"
+	KubernetesNodeSelector         = "kubernetes_node_selector"
+	KubernetesTolerations          = "kubernetes_tolerations"
+	KubernetesAffinity             = "kubernetes_affinity"
+	KubernetesSchedulingReason     = "kubernetes_scheduling_reason"
+	KubernetesFailedScheduling     = "kubernetes_failed_scheduling"
+	KubernetesPendingTooLong       = "pending_too_long"
+	KubernetesCustomResource       = "kubernetes_custom_resource"
+	KubernetesCustomFieldPrefix    = "kubernetes_custom_field_"
 	// probe/awsecs
 	ECSCluster             = "ecs_cluster"
 	ECSCreatedAt           = "ecs_created_at"
@@ -108,16 +199,25 @@ const (
 	ECSScaleUp             = "ecs_scale_up"
 	ECSScaleDown           = "ecs_scale_down"
 	// probe/host
-	Timestamp         = "ts"
-	HostName          = "host_name"
-	HostLocalNetworks = "local_networks"
-	OS                = "os"
-	KernelVersion     = "kernel_version"
-	Uptime            = "uptime"
-	Load1             = "load1"
-	HostCPUUsage      = "host_cpu_usage_percent"
-	HostMemoryUsage   = "host_mem_usage_bytes"
-	ScopeVersion      = "host_scope_version"
+	Timestamp               = "ts"
+	HostName                = "host_name"
+	HostLocalNetworks       = "local_networks"
+	OS                      = "os"
+	KernelVersion           = "kernel_version"
+	Uptime                  = "uptime"
+	Load1                   = "load1"
+	HostCPUUsage            = "host_cpu_usage_percent"
+	HostMemoryUsage         = "host_mem_usage_bytes"
+	ScopeVersion            = "host_scope_version"
+	HostInterfacePrefix     = "host_interface_"
+	HostMountPrefix         = "host_mount_"
+	HostListeningPortPrefix = "host_listening_port_"
+	HostPluginPrefix        = "host_plugin_"
+	HostPublishTargetPrefix = "host_publish_target_"
+	HostShedCollectors      = "host_shed_collectors"
+	HostRootFSUsage         = "host_root_fs_usage_percent"
+	HostRootFSInodeUsage    = "host_root_fs_inode_usage_percent"
+	HostDiskPressure        = "disk_pressure_warning"
 
 	CloudProviderServerless = "Serverless"
 	// probe/overlay/weave
@@ -159,7 +259,9 @@ var commonKeys = map[string]string{
 
 	ReverseDNSNames: ReverseDNSNames,
 	SnoopedDNSNames: SnoopedDNSNames,
+	DNSName:         DNSName,
 	CopyOf:          CopyOf,
+	ProtocolHint:    ProtocolHint,
 
 	PID:     PID,
 	Name:    Name,
@@ -176,6 +278,11 @@ var commonKeys = map[string]string{
 	DockerIsInHostNetwork:        DockerIsInHostNetwork,
 	DockerServiceName:            DockerServiceName,
 	DockerStackNamespace:         DockerStackNamespace,
+	DockerSwarmServiceImage:      DockerSwarmServiceImage,
+	DockerSwarmServiceMode:       DockerSwarmServiceMode,
+	DockerSwarmServiceReplicas:   DockerSwarmServiceReplicas,
+	DockerSwarmServiceRunning:    DockerSwarmServiceRunning,
+	DockerSwarmServiceUpdate:     DockerSwarmServiceUpdate,
 	DockerStopContainer:          DockerStopContainer,
 	DockerStartContainer:         DockerStartContainer,
 	DockerRestartContainer:       DockerRestartContainer,
@@ -197,6 +304,20 @@ var commonKeys = map[string]string{
 	DockerContainerUptime:        DockerContainerUptime,
 	DockerContainerRestartCount:  DockerContainerRestartCount,
 	DockerContainerNetworkMode:   DockerContainerNetworkMode,
+	DockerPrivileged:             DockerPrivileged,
+	DockerHostNetwork:            DockerHostNetwork,
+	DockerHostPID:                DockerHostPID,
+	DockerCapAdd:                 DockerCapAdd,
+	DockerSecurityOpt:            DockerSecurityOpt,
+	DockerReadonlyRootfs:         DockerReadonlyRootfs,
+	DockerSecurityScore:          DockerSecurityScore,
+	DockerConnected:              DockerConnected,
+	DockerOOMKilled:              DockerOOMKilled,
+	DockerLastExitCode:           DockerLastExitCode,
+	DockerLastExitTime:           DockerLastExitTime,
+	DockerImageRegistry:          DockerImageRegistry,
+	DockerImageRepoTags:          DockerImageRepoTags,
+	DockerImageRepoDigests:       DockerImageRepoDigests,
 
 	KubernetesName:                 KubernetesName,
 	KubernetesNamespace:            KubernetesNamespace,
@@ -224,6 +345,13 @@ var commonKeys = map[string]string{
 	KubernetesSuspended:            KubernetesSuspended,
 	KubernetesLastScheduled:        KubernetesLastScheduled,
 	KubernetesActiveJobs:           KubernetesActiveJobs,
+	KubernetesSucceededJobs:        KubernetesSucceededJobs,
+	KubernetesFailedJobs:           KubernetesFailedJobs,
+	KubernetesActivePods:           KubernetesActivePods,
+	KubernetesSucceededPods:        KubernetesSucceededPods,
+	KubernetesFailedPods:           KubernetesFailedPods,
+	KubernetesStartTime:            KubernetesStartTime,
+	KubernetesCompletionTime:       KubernetesCompletionTime,
 	KubernetesType:                 KubernetesType,
 	KubernetesPorts:                KubernetesPorts,
 
@@ -250,6 +378,15 @@ var commonKeys = map[string]string{
 	WeavePeerNickName: WeavePeerNickName,
 }
 
+// commonKey returns the common key equal to k, if there is one, so that
+// keys built at runtime don't each keep their own copy.
+func commonKey(k string) string {
+	if key, ok := commonKeys[k]; ok {
+		return key
+	}
+	return k
+}
+
 func lookupCommonKey(b []byte) string {
 	if key, ok := commonKeys[string(b)]; ok {
 		return key
@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"context"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -41,16 +42,14 @@ func (StdoutPublisher) Publish(rep Report) error {
 
 // WriteBinary writes a Report as a gzipped msgpack into a bytes.Buffer
 func (rep Report) WriteBinary() (*bytes.Buffer, error) {
-	w := &bytes.Buffer{}
-	gzwriter := gzipWriterPool.Get().(*gzip.Writer)
-	gzwriter.Reset(w)
-	defer gzipWriterPool.Put(gzwriter)
-	//if err := codec.NewEncoder(gzwriter, &codec.BincHandle{}).Encode(&rep); err != nil {
-	if err := codec.NewEncoder(gzwriter, &codec.MsgpackHandle{}).Encode(&rep); err != nil {
-		return nil, err
-	}
-	gzwriter.Close() // otherwise the content won't get flushed to the output stream
-	return w, nil
+	return rep.writeBinary(nil)
+}
+
+func (rep Report) writeBinary(h hash.Hash) (*bytes.Buffer, error) {
+	return writeGzipped(h, func(w io.Writer) error {
+		//return codec.NewEncoder(w, &codec.BincHandle{}).Encode(&rep)
+		return codec.NewEncoder(w, &codec.MsgpackHandle{}).Encode(&rep)
+	})
 }
 
 type byteCounter struct {
@@ -74,7 +73,8 @@ var gzipWriterPool = &sync.Pool{
 }
 
 // MakeFromBinary constructs a Report from binary data.
-// variable msgpack = 0 means json, msgpack = 1 means use msgpack code, msgpack = 2 means use binc codec
+// variable msgpack = 0 means json, msgpack = 1 means use msgpack code, msgpack = 2 means use binc codec,
+// msgpack = 3 means protobuf
 func MakeFromBinary(ctx context.Context, r io.Reader, gzipped bool, msgpack int) (*Report, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "report.ReadBinary")
 	defer span.Finish()
@@ -102,8 +102,15 @@ func MakeFromBinary(ctx context.Context, r io.Reader, gzipped bool, msgpack int)
 		return nil, err
 	}
 	rep := MakeReport()
-	if err := codec.NewDecoderBytes(buf.Bytes(), codecHandle(msgpack)).Decode(&rep); err != nil {
-		return nil, err
+	if msgpack == 3 {
+		if err := rep.readProtobuf(buf.Bytes()); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := codec.NewDecoderBytes(buf.Bytes(), codecHandle(msgpack)).Decode(&rep); err != nil {
+			return nil, err
+		}
+		rep.intern()
 	}
 	log.Debugf(
 		"Received report sizes: compressed %d bytes, uncompressed %d bytes (%.2f%%)",
@@ -117,7 +124,7 @@ func MakeFromBinary(ctx context.Context, r io.Reader, gzipped bool, msgpack int)
 
 // MakeFromFile construct a Report from a file, with the encoding
 // determined by the extension (".msgpack" or ".json", with an
-// optional ".gz").
+// optional ".gz", or ".pb.gz" for protobuf).
 func MakeFromFile(ctx context.Context, path string) (rpt *Report, _ error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -135,16 +142,28 @@ func MakeFromFile(ctx context.Context, path string) (rpt *Report, _ error) {
 
 // WriteToFile writes a Report to a file. The encoding is determined
 // by the file extension (".msgpack" or ".json", with an optional
-// ".gz").
+// ".gz", or ".pb.gz" for protobuf).
 func (rep *Report) WriteToFile(path string) error {
+	msgpack, gzipped, err := fileType(path)
+	if err != nil {
+		return err
+	}
+	if msgpack == 3 && !gzipped {
+		return fmt.Errorf("Protobuf reports are written gzipped: %v", path)
+	}
+
 	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	msgpack, gzipped, err := fileType(path)
-	if err != nil {
+	if msgpack == 3 {
+		buf, err := rep.WriteProtobuf()
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteTo(f)
 		return err
 	}
 
@@ -177,6 +196,8 @@ func fileType(path string) (msgpack int, gzipped bool, err error) {
 		return 1, gzipped, nil
 	case ".binc":
 		return 2, gzipped, nil
+	case ".pb":
+		return 3, gzipped, nil
 	default:
 		return 3, false, fmt.Errorf("Unsupported file extension: %v", fileType)
 	}
@@ -186,7 +207,11 @@ func codecHandle(msgpack int) codec.Handle {
 	if (msgpack == 0) { 
 		return &codec.JsonHandle{}
 	} else if (msgpack == 1) {
-		return &codec.MsgpackHandle{}
+		h := &codec.MsgpackHandle{}
+		// Intern the keys of the maps of nodes and metrics; the rest of
+		// the strings are interned once the report is decoded
+		h.InternString = true
+		return h
 	} else if (msgpack == 2) {
 		return &codec.BincHandle{}
 	}
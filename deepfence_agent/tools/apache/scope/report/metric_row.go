@@ -106,7 +106,8 @@ func (m *MetricRow) CodecDecodeSelf(decoder *codec.Decoder) {
 }
 
 // MetricRowsByPriority implements sort.Interface, so we can sort the rows by
-// priority before rendering them to the UI.
+// priority before rendering them to the UI. Rows of the same priority are
+// sorted by ID, so their order is stable.
 type MetricRowsByPriority []MetricRow
 
 // Len is part of sort.Interface.
@@ -121,5 +122,8 @@ func (m MetricRowsByPriority) Swap(i, j int) {
 
 // Less is part of sort.Interface.
 func (m MetricRowsByPriority) Less(i, j int) bool {
-	return m[i].Priority < m[j].Priority
+	if m[i].Priority != m[j].Priority {
+		return m[i].Priority < m[j].Priority
+	}
+	return m[i].ID < m[j].ID
 }
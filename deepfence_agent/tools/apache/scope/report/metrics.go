@@ -2,6 +2,7 @@ package report
 
 import (
 	"math"
+	"sort"
 	"time"
 )
 
@@ -34,6 +35,20 @@ func (m Metrics) Merge(other Metrics) Metrics {
 	return result
 }
 
+// Sum adds up two sets of metrics into a fresh set, summing those in both,
+// as of a group of nodes.
+func (m Metrics) Sum(other Metrics) Metrics {
+	result := m.Copy()
+	for k, v := range other {
+		if rv, ok := result[k]; ok {
+			result[k] = rv.Sum(v)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
 // Copy returns a value copy of the sets map.
 func (m Metrics) Copy() Metrics {
 	result := make(Metrics, len(m))
@@ -174,6 +189,52 @@ func (m Metric) Merge(other Metric) Metric {
 	}
 }
 
+// Sum adds up two Metrics, as of two nodes together, and returns a new
+// result.  There is a sample at the time of each sample of either, of the
+// sum of the latest samples of both as of then, since the two are rarely
+// sampled at the same times.  The Max is the larger of the two, unless a sum
+// is over it, so that the sum of usages of some capacity stays against it.
+func (m Metric) Sum(other Metric) Metric {
+	switch {
+	case len(m.Samples) == 0:
+		return other
+	case len(other.Samples) == 0:
+		return m
+	}
+
+	var (
+		samplesOut       = make([]Sample, 0, len(m.Samples)+len(other.Samples))
+		mI, otherI       int
+		mLast, otherLast float64
+	)
+	for mI < len(m.Samples) || otherI < len(other.Samples) {
+		var timestamp time.Time
+		switch {
+		case otherI >= len(other.Samples):
+			timestamp = m.Samples[mI].Timestamp
+		case mI >= len(m.Samples):
+			timestamp = other.Samples[otherI].Timestamp
+		case other.Samples[otherI].Timestamp.Before(m.Samples[mI].Timestamp):
+			timestamp = other.Samples[otherI].Timestamp
+		default:
+			timestamp = m.Samples[mI].Timestamp
+		}
+		if mI < len(m.Samples) && m.Samples[mI].Timestamp.Equal(timestamp) {
+			mLast = m.Samples[mI].Value
+			mI++
+		}
+		if otherI < len(other.Samples) && other.Samples[otherI].Timestamp.Equal(timestamp) {
+			otherLast = other.Samples[otherI].Value
+			otherI++
+		}
+		samplesOut = append(samplesOut, Sample{Timestamp: timestamp, Value: mLast + otherLast})
+	}
+
+	result := MakeMetric(samplesOut)
+	result.Max = math.Max(result.Max, math.Max(m.Max, other.Max))
+	return result
+}
+
 // LastSample obtains the last sample of the metric
 func (m Metric) LastSample() (Sample, bool) {
 	if m.Samples == nil {
@@ -181,3 +242,106 @@ func (m Metric) LastSample() (Sample, bool) {
 	}
 	return m.Samples[len(m.Samples)-1], true
 }
+
+// Downsample returns m with its samples reduced to at most points, for
+// storing long series.  The samples are split into windows of equal
+// duration, and of each window only the first, lowest, highest and last
+// samples are kept, so the series keeps its first and last samples, which
+// sparklines are drawn between, and its peaks.  Min and Max are unchanged.
+// Targets of fewer than four points are taken as four.
+func (m Metric) Downsample(points int) Metric {
+	if points < 4 {
+		points = 4
+	}
+	if len(m.Samples) <= points {
+		return m
+	}
+
+	type window struct{ first, min, max, last int }
+	var (
+		windows  = make([]window, points/4)
+		span     = m.last().Sub(m.first()) + 1
+		previous = -1
+	)
+	for i, s := range m.Samples {
+		w := int(float64(s.Timestamp.Sub(m.first())) / float64(span) * float64(len(windows)))
+		if w >= len(windows) {
+			w = len(windows) - 1
+		}
+		if w != previous {
+			windows[w] = window{i, i, i, i}
+			previous = w
+			continue
+		}
+		if s.Value < m.Samples[windows[w].min].Value {
+			windows[w].min = i
+		}
+		if s.Value > m.Samples[windows[w].max].Value {
+			windows[w].max = i
+		}
+		windows[w].last = i
+	}
+
+	samplesOut := make([]Sample, 0, points)
+	previous = -1
+	for _, w := range windows {
+		// Empty windows are skipped, as none of their indices are after
+		// previous.
+		kept := []int{w.first, w.min, w.max, w.last}
+		if kept[1] > kept[2] {
+			kept[1], kept[2] = kept[2], kept[1]
+		}
+		for _, i := range kept {
+			if i > previous {
+				samplesOut = append(samplesOut, m.Samples[i])
+				previous = i
+			}
+		}
+	}
+	return Metric{
+		Samples: samplesOut,
+		Min:     m.Min,
+		Max:     m.Max,
+	}
+}
+
+// DownsampleBefore returns m with its samples from before t downsampled to
+// at most points, and those from t on kept as they are.
+func (m Metric) DownsampleBefore(t time.Time, points int) Metric {
+	older := sort.Search(len(m.Samples), func(i int) bool {
+		return !m.Samples[i].Timestamp.Before(t)
+	})
+	if older <= points {
+		return m
+	}
+	downsampled := Metric{Samples: m.Samples[:older]}.Downsample(points)
+	samplesOut := make([]Sample, 0, len(downsampled.Samples)+len(m.Samples)-older)
+	samplesOut = append(samplesOut, downsampled.Samples...)
+	samplesOut = append(samplesOut, m.Samples[older:]...)
+	return Metric{
+		Samples: samplesOut,
+		Min:     m.Min,
+		Max:     m.Max,
+	}
+}
+
+// DownsampleBefore returns a fresh copy of m with the samples of each metric
+// from before t downsampled to at most points.  m is returned as it is if
+// no metric needs downsampling.
+func (m Metrics) DownsampleBefore(t time.Time, points int) Metrics {
+	var result Metrics
+	for k, v := range m {
+		downsampled := v.DownsampleBefore(t, points)
+		if len(downsampled.Samples) == len(v.Samples) {
+			continue
+		}
+		if result == nil {
+			result = m.Copy()
+		}
+		result[k] = downsampled
+	}
+	if result == nil {
+		return m
+	}
+	return result
+}
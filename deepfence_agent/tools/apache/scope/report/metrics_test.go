@@ -94,6 +94,60 @@ func TestMetricMerge(t *testing.T) {
 	}
 }
 
+func TestMetricsSum(t *testing.T) {
+	t1 := time.Now()
+	t2 := time.Now().Add(1 * time.Minute)
+
+	metrics1 := report.Metrics{
+		"metric1": report.MakeMetric([]report.Sample{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 2}}),
+		"metric2": report.MakeSingletonMetric(t1, 3),
+	}
+	metrics2 := report.Metrics{
+		"metric1": report.MakeSingletonMetric(t2, 4),
+		"metric3": report.MakeSingletonMetric(t2, 5),
+	}
+	want := report.Metrics{
+		"metric1": report.MakeMetric([]report.Sample{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 6}}),
+		"metric2": report.MakeSingletonMetric(t1, 3),
+		"metric3": report.MakeSingletonMetric(t2, 5),
+	}
+	have := metrics1.Sum(metrics2)
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("diff: %s", test.Diff(want, have))
+	}
+	if len(metrics1) != 2 {
+		t.Errorf("Expected the summed metrics unchanged, have %v", metrics1)
+	}
+}
+
+func TestMetricSum(t *testing.T) {
+	t1 := time.Now()
+	t2 := time.Now().Add(1 * time.Minute)
+	t3 := time.Now().Add(2 * time.Minute)
+	t4 := time.Now().Add(3 * time.Minute)
+
+	metric1 := report.MakeMetric([]report.Sample{{Timestamp: t2, Value: 20}, {Timestamp: t3, Value: 30}}).WithMax(100)
+	metric2 := report.MakeMetric([]report.Sample{{Timestamp: t1, Value: 10}, {Timestamp: t3, Value: 80}, {Timestamp: t4, Value: 40}}).WithMax(100)
+
+	want := report.Metric{
+		Samples: []report.Sample{{Timestamp: t1, Value: 10}, {Timestamp: t2, Value: 30}, {Timestamp: t3, Value: 110}, {Timestamp: t4, Value: 70}},
+		Min:     10,
+		Max:     110,
+	}
+	for _, have := range []report.Metric{metric1.Sum(metric2), metric2.Sum(metric1)} {
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("diff: %s", test.Diff(want, have))
+		}
+	}
+
+	// The max is kept if the sums are within it
+	checkMetric(t, report.MakeSingletonMetric(t1, 1).WithMax(100).Sum(report.MakeSingletonMetric(t1, 2).WithMax(100)), 3, 100)
+	// And summing nothing changes nothing
+	if have := metric1.Sum(report.Metric{}); !reflect.DeepEqual(metric1, have) {
+		t.Errorf("diff: %s", test.Diff(metric1, have))
+	}
+}
+
 func TestMetricMarshalling(t *testing.T) {
 	t1 := time.Now().UTC()
 	t2 := time.Now().UTC().Add(1 * time.Minute)
@@ -144,3 +198,114 @@ func TestMetricMarshalling(t *testing.T) {
 	}
 
 }
+
+func downsampleTestMetric(start time.Time, n int) report.Metric {
+	samples := make([]report.Sample, n)
+	for i := range samples {
+		samples[i] = report.Sample{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Value:     float64((i * 37) % 101),
+		}
+	}
+	return report.MakeMetric(samples)
+}
+
+func TestMetricDownsample(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	metric := downsampleTestMetric(start, 1000)
+
+	for _, points := range []int{4, 10, 30, 100, 999} {
+		have := metric.Downsample(points)
+		if n := have.Len(); n > points || n < 2 {
+			t.Errorf("%d: expected at most %d samples, got %d", points, points, n)
+		}
+		checkMetric(t, have, metric.Min, metric.Max)
+		if first := have.Samples[0]; first != metric.Samples[0] {
+			t.Errorf("%d: expected the first sample %v, got %v", points, metric.Samples[0], first)
+		}
+		if last, _ := have.LastSample(); last != metric.Samples[len(metric.Samples)-1] {
+			t.Errorf("%d: expected the last sample %v, got %v", points, metric.Samples[len(metric.Samples)-1], last)
+		}
+
+		// The extremes of each window are kept, and samples stay in order
+		var min, max float64 = have.Samples[0].Value, have.Samples[0].Value
+		for i, s := range have.Samples {
+			if i > 0 && !s.Timestamp.After(have.Samples[i-1].Timestamp) {
+				t.Fatalf("%d: samples out of order at %d", points, i)
+			}
+			if s.Value < min {
+				min = s.Value
+			}
+			if s.Value > max {
+				max = s.Value
+			}
+		}
+		if min != metric.Min || max != metric.Max {
+			t.Errorf("%d: expected samples between %f and %f, got %f and %f", points, metric.Min, metric.Max, min, max)
+		}
+	}
+
+	// Short series are left as they are
+	if have := metric.Downsample(1000); !reflect.DeepEqual(metric, have) {
+		t.Error(test.Diff(metric, have))
+	}
+	single := report.MakeSingletonMetric(start, 1)
+	if have := single.Downsample(1); !reflect.DeepEqual(single, have) {
+		t.Error(test.Diff(single, have))
+	}
+}
+
+func TestMetricDownsampleBefore(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	metric := downsampleTestMetric(start, 100)
+	before := start.Add(60 * time.Second)
+
+	have := metric.DownsampleBefore(before, 8)
+	checkMetric(t, have, metric.Min, metric.Max)
+	want := metric.Samples[60:]
+	if n := have.Len(); n > 8+len(want) {
+		t.Fatalf("Expected at most %d samples, got %d", 8+len(want), n)
+	}
+	if newer := have.Samples[have.Len()-len(want):]; !reflect.DeepEqual(want, newer) {
+		t.Error(test.Diff(want, newer))
+	}
+	if have.Samples[0] != metric.Samples[0] {
+		t.Errorf("Expected the first sample %v, got %v", metric.Samples[0], have.Samples[0])
+	}
+
+	// Metrics with no more older samples than points are left as they are
+	metrics := report.Metrics{"long": metric, "short": downsampleTestMetric(start, 8)}
+	if have := metrics.DownsampleBefore(start.Add(5*time.Second), 8); !reflect.DeepEqual(metrics, have) {
+		t.Error(test.Diff(metrics, have))
+	}
+	downsampled := metrics.DownsampleBefore(before, 8)
+	if n := downsampled["long"].Len(); n >= metric.Len() {
+		t.Errorf("Expected fewer than %d samples, got %d", metric.Len(), n)
+	}
+	if !reflect.DeepEqual(metrics["short"], downsampled["short"]) {
+		t.Error(test.Diff(metrics["short"], downsampled["short"]))
+	}
+	if metrics["long"].Len() != metric.Len() {
+		t.Error("Expected the original metrics not to be modified")
+	}
+}
+
+func TestReportDownsampleMetrics(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	metric := downsampleTestMetric(start, 200)
+	rpt := report.MakeReport()
+	rpt.Host.AddNode(report.MakeNode("host").WithMetrics(report.Metrics{"cpu": metric}))
+	rpt.Container.AddNode(report.MakeNode("container"))
+
+	rpt.DownsampleMetrics(start.Add(time.Hour), 20)
+	have, _ := rpt.Host.Nodes["host"].Metrics.Lookup("cpu")
+	if want := metric.Downsample(20); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Downsampled series survive being stored
+	roundtripped := protobufRoundtrip(t, rpt)
+	if !reflect.DeepEqual(rpt.Host, roundtripped.Host) {
+		t.Error(test.Diff(rpt.Host, roundtripped.Host))
+	}
+}
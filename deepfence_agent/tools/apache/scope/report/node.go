@@ -16,6 +16,7 @@ type Node struct {
 	Topology       string          `json:"topology,omitempty"`
 	Sets           Sets            `json:"sets,omitempty"`
 	Adjacency      IDList          `json:"adjacency,omitempty"`
+	Edges          EdgeMetadatas   `json:"edges,omitempty"`
 	Latest         StringLatestMap `json:"latest,omitempty"`
 	Metrics        Metrics         `json:"metrics,omitempty" deepequal:"nil==empty"`
 	Parents        Sets            `json:"parents,omitempty"`
@@ -28,6 +29,7 @@ func MakeNode(id string) Node {
 		ID:             id,
 		Sets:           MakeSets(),
 		Adjacency:      MakeIDList(),
+		Edges:          MakeEdgeMetadatas(),
 		Latest:         MakeStringLatestMap(),
 		Metrics:        Metrics{},
 		Parents:        MakeSets(),
@@ -131,6 +133,14 @@ func (n Node) WithAdjacent(a ...string) Node {
 	return n
 }
 
+// WithEdge returns a fresh copy of n, with 'dst' added to Adjacency and md
+// added to the metadata of the edge to it.
+func (n Node) WithEdge(dst string, md EdgeMetadata) Node {
+	n.Adjacency = n.Adjacency.Add(dst)
+	n.Edges = n.Edges.Add(dst, md)
+	return n
+}
+
 // WithLatestActiveControls says which controls are active on this node.
 // Implemented as a delimiter-separated string in Latest
 func (n Node) WithLatestActiveControls(cs ...string) Node {
@@ -193,6 +203,7 @@ func (n Node) Merge(other Node) Node {
 		Topology:       topology,
 		Sets:           n.Sets.Merge(other.Sets),
 		Adjacency:      n.Adjacency.Merge(other.Adjacency),
+		Edges:          n.Edges.Merge(other.Edges),
 		Latest:         n.Latest.Merge(other.Latest),
 		Metrics:        n.Metrics.Merge(other.Metrics),
 		Parents:        n.Parents.Merge(other.Parents),
@@ -200,6 +211,28 @@ func (n Node) Merge(other Node) Node {
 	}
 }
 
+// newest returns the time of the newest of the latest values and metric
+// samples of n and its children, and false if they have none.
+func (n Node) newest() (time.Time, bool) {
+	var result time.Time
+	for _, e := range n.Latest {
+		if e.Timestamp.After(result) {
+			result = e.Timestamp
+		}
+	}
+	for _, m := range n.Metrics {
+		if len(m.Samples) > 0 && m.last().After(result) {
+			result = m.last()
+		}
+	}
+	n.Children.ForEach(func(child Node) {
+		if ts, ok := child.newest(); ok && ts.After(result) {
+			result = ts
+		}
+	})
+	return result, !result.IsZero()
+}
+
 // UnsafeUnMerge removes data from n that would be added by merging other,
 // modifying the original.
 // returns true if n.Merge(other) is the same as n
@@ -235,6 +268,6 @@ func (n *Node) UnsafeUnMerge(other Node) bool {
 		remove = false
 	}
 	// counters and children are not created in the probe so we don't check those
-	// metrics don't overlap so just check if we have any
-	return remove && len(n.Metrics) == 0
+	// metrics and edge counts don't overlap so just check if we have any
+	return remove && len(n.Metrics) == 0 && n.Edges.Size() == 0
 }
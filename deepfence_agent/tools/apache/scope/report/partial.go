@@ -0,0 +1,411 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/ugorji/go/codec"
+)
+
+// PartialReport is what's read of a report by ReadPartial: the number of
+// nodes of each topology, and only the topologies asked for.  Reading it
+// takes a fraction of the time and memory of decoding the whole report, as
+// the nodes of the other topologies are skipped over rather than decoded.
+type PartialReport struct {
+	ID     string
+	Window time.Duration
+
+	// NodeCounts are the number of nodes of the topologies, by name.
+	NodeCounts map[string]int
+
+	// HostIDs are the IDs of the nodes of the host topology.
+	HostIDs []string
+
+	// Topologies are the topologies asked for, decoded, by name.
+	Topologies map[string]Topology
+}
+
+// MakePartialReport makes the partial report of an already decoded report,
+// for the paths given one rather than its encoding.
+func MakePartialReport(r Report, topologies ...string) *PartialReport {
+	p := &PartialReport{
+		ID:         r.ID,
+		Window:     r.Window,
+		NodeCounts: map[string]int{},
+		Topologies: map[string]Topology{},
+	}
+	r.WalkNamedTopologies(func(name string, t *Topology) {
+		p.NodeCounts[name] = len(t.Nodes)
+	})
+	for id := range r.Host.Nodes {
+		p.HostIDs = append(p.HostIDs, id)
+	}
+	sort.Strings(p.HostIDs)
+	for _, name := range topologies {
+		if t := r.topology(name); t != nil {
+			p.Topologies[name] = *t
+		}
+	}
+	return p
+}
+
+// Summary is the same as that of the whole report.
+func (p PartialReport) Summary() string {
+	ret := ""
+	if len(p.HostIDs) == 1 {
+		ret = p.HostIDs[0] + ": "
+	}
+	counts := []string{}
+	for _, name := range topologyNames {
+		if count := p.NodeCounts[name]; count > 0 {
+			counts = append(counts, fmt.Sprintf("%s:%d", name, count))
+		}
+	}
+	return ret + strings.Join(counts, ", ")
+}
+
+// topologyKeys maps the keys of the topologies in encoded reports, which
+// are the names of their fields, to their names.
+var topologyKeys = func() map[string]string {
+	result := map[string]string{}
+	r := Report{}
+	v := reflect.ValueOf(&r).Elem()
+	for _, name := range topologyNames {
+		t := r.topology(name)
+		for i := 0; i < v.NumField(); i++ {
+			if field, ok := v.Field(i).Addr().Interface().(*Topology); ok && field == t {
+				result[v.Type().Field(i).Name] = name
+			}
+		}
+	}
+	return result
+}()
+
+// ReadPartial reads the partial report of a report encoded as msgpack,
+// decoding only the named topologies.
+func ReadPartial(ctx context.Context, r io.Reader, gzipped bool, topologies ...string) (*PartialReport, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "report.ReadPartial")
+	defer span.Finish()
+	var err error
+	if gzipped {
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	selected := map[string]struct{}{}
+	for _, name := range topologies {
+		selected[name] = struct{}{}
+	}
+	p := &PartialReport{
+		NodeCounts: map[string]int{},
+		Topologies: map[string]Topology{},
+	}
+	s := &msgpackScanner{buf: buf.Bytes()}
+	fields, err := s.readMapLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < fields; i++ {
+		key, err := s.readString()
+		if err != nil {
+			return nil, err
+		}
+		name, isTopology := topologyKeys[string(key)]
+		switch {
+		case isTopology:
+			start := s.pos
+			count, hostIDs, err := s.readTopology(name == Host)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			p.NodeCounts[name] = count
+			if name == Host {
+				p.HostIDs = hostIDs
+			}
+			if _, ok := selected[name]; ok {
+				t := MakeTopology()
+				if err := codec.NewDecoderBytes(s.buf[start:s.pos], &codec.MsgpackHandle{}).Decode(&t); err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				p.Topologies[name] = t
+			}
+		case string(key) == "ID":
+			id, err := s.readString()
+			if err != nil {
+				return nil, err
+			}
+			p.ID = string(id)
+		case string(key) == "Window":
+			window, err := s.readInt()
+			if err != nil {
+				return nil, err
+			}
+			p.Window = time.Duration(window)
+		default:
+			if err := s.skip(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(p.HostIDs)
+	return p, nil
+}
+
+// msgpackScanner reads msgpack in place, without decoding what it skips
+// over.
+type msgpackScanner struct {
+	buf []byte
+	pos int
+}
+
+var errShortMsgpack = fmt.Errorf("msgpack: unexpected end of input")
+
+func (s *msgpackScanner) next(n int) ([]byte, error) {
+	if n < 0 || len(s.buf)-s.pos < n {
+		return nil, errShortMsgpack
+	}
+	b := s.buf[s.pos : s.pos+n]
+	s.pos += n
+	return b, nil
+}
+
+func (s *msgpackScanner) readByte() (byte, error) {
+	b, err := s.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readLength reads the big-endian length of n bytes following a type.
+func (s *msgpackScanner) readLength(n int) (int, error) {
+	b, err := s.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+func (s *msgpackScanner) readMapLen() (int, error) {
+	c, err := s.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c >= 0x80 && c <= 0x8f:
+		return int(c & 0x0f), nil
+	case c == 0xc0: // nil
+		return 0, nil
+	case c == 0xde:
+		return s.readLength(2)
+	case c == 0xdf:
+		return s.readLength(4)
+	}
+	return 0, fmt.Errorf("msgpack: expected a map, got 0x%x", c)
+}
+
+// readString returns the bytes of a string, which are only valid as long
+// as the buffer.
+func (s *msgpackScanner) readString() ([]byte, error) {
+	c, err := s.readByte()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		n = int(c & 0x1f)
+	case c == 0xc0: // nil
+		return nil, nil
+	case c == 0xd9, c == 0xc4:
+		n, err = s.readLength(1)
+	case c == 0xda, c == 0xc5:
+		n, err = s.readLength(2)
+	case c == 0xdb, c == 0xc6:
+		n, err = s.readLength(4)
+	default:
+		return nil, fmt.Errorf("msgpack: expected a string, got 0x%x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.next(n)
+}
+
+func (s *msgpackScanner) readInt() (int64, error) {
+	c, err := s.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if c <= 0x7f || c >= 0xe0 { // fixints
+		return int64(int8(c)), nil
+	}
+	var size int
+	switch c {
+	case 0xcc, 0xd0:
+		size = 1
+	case 0xcd, 0xd1:
+		size = 2
+	case 0xce, 0xd2:
+		size = 4
+	case 0xcf, 0xd3:
+		size = 8
+	default:
+		return 0, fmt.Errorf("msgpack: expected an integer, got 0x%x", c)
+	}
+	b, err := s.next(size)
+	if err != nil {
+		return 0, err
+	}
+	signed := c >= 0xd0
+	switch size {
+	case 1:
+		if signed {
+			return int64(int8(b[0])), nil
+		}
+		return int64(b[0]), nil
+	case 2:
+		if signed {
+			return int64(int16(binary.BigEndian.Uint16(b))), nil
+		}
+		return int64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		if signed {
+			return int64(int32(binary.BigEndian.Uint32(b))), nil
+		}
+		return int64(binary.BigEndian.Uint32(b)), nil
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// readTopology reads the number of nodes of a topology, and their IDs if
+// asked for, skipping over everything else.
+func (s *msgpackScanner) readTopology(withIDs bool) (int, []string, error) {
+	fields, err := s.readMapLen()
+	if err != nil {
+		return 0, nil, err
+	}
+	count, ids := 0, []string(nil)
+	for i := 0; i < fields; i++ {
+		key, err := s.readString()
+		if err != nil {
+			return 0, nil, err
+		}
+		if string(key) != "nodes" {
+			if err := s.skip(); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if count, err = s.readMapLen(); err != nil {
+			return 0, nil, err
+		}
+		for j := 0; j < count; j++ {
+			id, err := s.readString()
+			if err != nil {
+				return 0, nil, err
+			}
+			if withIDs {
+				ids = append(ids, string(id))
+			}
+			if err := s.skip(); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return count, ids, nil
+}
+
+// skip skips over the next value, however deeply nested.
+func (s *msgpackScanner) skip() error {
+	for pending := 1; pending > 0; pending-- {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+		var n int
+		switch {
+		case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+		case c >= 0x80 && c <= 0x8f:
+			pending += 2 * int(c&0x0f)
+		case c >= 0x90 && c <= 0x9f:
+			pending += int(c & 0x0f)
+		case c >= 0xa0 && c <= 0xbf:
+			n = int(c & 0x1f)
+		case c == 0xc4, c == 0xd9:
+			n, err = s.readLength(1)
+		case c == 0xc5, c == 0xda:
+			n, err = s.readLength(2)
+		case c == 0xc6, c == 0xdb:
+			n, err = s.readLength(4)
+		case c == 0xc7: // ext, with its type
+			n, err = s.readLength(1)
+			n++
+		case c == 0xc8:
+			n, err = s.readLength(2)
+			n++
+		case c == 0xc9:
+			n, err = s.readLength(4)
+			n++
+		case c == 0xcc, c == 0xd0:
+			n = 1
+		case c == 0xcd, c == 0xd1:
+			n = 2
+		case c == 0xca, c == 0xce, c == 0xd2:
+			n = 4
+		case c == 0xcb, c == 0xcf, c == 0xd3:
+			n = 8
+		case c >= 0xd4 && c <= 0xd8: // fixext, with its type
+			n = 1<<(c-0xd4) + 1
+		case c == 0xdc:
+			n, err = s.readLength(2)
+			pending += n
+			n = 0
+		case c == 0xdd:
+			n, err = s.readLength(4)
+			pending += n
+			n = 0
+		case c == 0xde:
+			n, err = s.readLength(2)
+			pending += 2 * n
+			n = 0
+		case c == 0xdf:
+			n, err = s.readLength(4)
+			pending += 2 * n
+			n = 0
+		default:
+			return fmt.Errorf("msgpack: invalid type 0x%x", c)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.next(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
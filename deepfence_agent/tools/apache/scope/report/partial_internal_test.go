@@ -0,0 +1,172 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+var benchReportFile = flag.String("bench-report-file", "", "gzipped msgpack report, such as one captured from a busy probe, to benchmark decoding with")
+
+func makePartialTestReport(processes int) Report {
+	r := MakeReport()
+	r.ID = "1234"
+	r.Window = 15 * time.Second
+	r.Host.AddNode(MakeNode(MakeHostNodeID("host1")))
+	r.Container.AddNode(MakeNode(MakeContainerNodeID("a")))
+	r.Container.AddNode(MakeNode(MakeContainerNodeID("b")))
+	r.Overlay.AddNode(MakeNode(MakeOverlayNodeID(WeaveOverlayPeerPrefix, "00:00:00:00:00:01")))
+	for i := 0; i < processes; i++ {
+		pid := fmt.Sprint(i)
+		r.Process.AddNode(MakeNode(MakeProcessNodeID("host1", pid)).
+			WithSets(MakeSets().Add("args", MakeStringSet("--id", pid))).
+			WithParent(Container, MakeContainerNodeID("a")))
+		r.Endpoint.AddNode(MakeNode(MakeEndpointNodeID("host1", "", "10.0.0.1", pid)).
+			WithAdjacent(MakeEndpointNodeID("", "", "10.0.0.2", "80")))
+	}
+	return r
+}
+
+func TestReadPartial(t *testing.T) {
+	r := makePartialTestReport(10)
+	buf, err := r.WriteBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadPartial(context.Background(), buf, true, Overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != "1234" || p.Window != 15*time.Second {
+		t.Errorf("Unexpected ID %q and window %v", p.ID, p.Window)
+	}
+	want := map[string]int{}
+	r.WalkNamedTopologies(func(name string, t *Topology) {
+		want[name] = len(t.Nodes)
+	})
+	if !reflect.DeepEqual(want, p.NodeCounts) {
+		t.Errorf("Expected node counts %v, got %v", want, p.NodeCounts)
+	}
+	if !reflect.DeepEqual([]string{MakeHostNodeID("host1")}, p.HostIDs) {
+		t.Errorf("Unexpected host IDs %v", p.HostIDs)
+	}
+	if len(p.Topologies) != 1 || len(p.Topologies[Overlay].Nodes) != 1 {
+		t.Errorf("Expected only the overlay topology, got %v", p.Topologies)
+	}
+	if have, want := p.Summary(), r.Summary(); have != want {
+		t.Errorf("Expected the summary %q, got %q", want, have)
+	}
+	if have, want := MakePartialReport(r, Overlay), p; !reflect.DeepEqual(want.NodeCounts, have.NodeCounts) || have.Summary() != want.Summary() {
+		t.Errorf("Expected the partial report of the decoded report to be the same, got %v", have)
+	}
+}
+
+func TestMsgpackScannerSkip(t *testing.T) {
+	for _, v := range []interface{}{
+		nil, true, false,
+		0, 1, -1, -32, -33, 127, 128, 255, 256, 65535, 65536, -129, -32769, 1 << 40, -1 << 40,
+		1.5, float32(1.5),
+		"", "a", strings.Repeat("a", 31), strings.Repeat("a", 32), strings.Repeat("a", 256), strings.Repeat("a", 65536),
+		[]byte{1, 2, 3},
+		[]interface{}{}, []interface{}{1, "a", nil}, make([]interface{}, 16), make([]interface{}, 65536),
+		map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1, 2, map[string]interface{}{}}}},
+		time.Unix(1, 2),
+	} {
+		var buf []byte
+		if err := codec.NewEncoderBytes(&buf, &codec.MsgpackHandle{}).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		// Followed by another value, which mustn't be skipped
+		buf = append(buf, 0x01)
+		s := &msgpackScanner{buf: buf}
+		if err := s.skip(); err != nil {
+			t.Errorf("%T: %v", v, err)
+		} else if s.pos != len(buf)-1 {
+			t.Errorf("%T: expected to skip %d bytes, skipped %d", v, len(buf)-1, s.pos)
+		}
+	}
+
+	for _, buf := range [][]byte{
+		{},
+		{0xa5, 'a'},           // a string too short
+		{0x92, 0x01},          // an array too short
+		{0xde, 0x00, 0x01, 1}, // a map too short
+		{0xc1},                // never used
+	} {
+		s := &msgpackScanner{buf: buf}
+		if err := s.skip(); err == nil {
+			t.Errorf("%x: expected an error", buf)
+		}
+	}
+}
+
+// benchmarkReport is the captured report given by -bench-report-file, or a
+// big one.
+func benchmarkReport(b *testing.B) []byte {
+	if *benchReportFile != "" {
+		buf, err := ioutil.ReadFile(*benchReportFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return buf
+	}
+	buf, err := makePartialTestReport(50000).WriteBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// benchmarkProtobufReport is benchmarkReport encoded as protobuf.
+func benchmarkProtobufReport(b *testing.B) []byte {
+	rpt := makePartialTestReport(50000)
+	if *benchReportFile != "" {
+		r, err := MakeFromBinary(context.Background(), bytes.NewReader(benchmarkReport(b)), true, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rpt = *r
+	}
+	buf, err := rpt.WriteProtobuf()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkMakeFromBinary(b *testing.B) {
+	benchmarkMakeFromBinary(b, benchmarkReport(b), 1)
+}
+
+func BenchmarkMakeFromBinaryProtobuf(b *testing.B) {
+	benchmarkMakeFromBinary(b, benchmarkProtobufReport(b), 3)
+}
+
+func benchmarkMakeFromBinary(b *testing.B, buf []byte, msgpack int) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MakeFromBinary(context.Background(), bytes.NewReader(buf), true, msgpack); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPartial(b *testing.B) {
+	buf := benchmarkReport(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadPartial(context.Background(), bytes.NewReader(buf), true, Overlay); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"sort"
+	"strings"
+)
+
+// pluginTemplatePrefix namespaces the metadata and table templates
+// contributed by plugins, keyed plugin:<plugin id>/<key> so that they
+// can't overwrite the built-in templates when merged.
+const pluginTemplatePrefix = "plugin:"
+
+// PluginTemplateKey returns the key of a template contributed by a plugin.
+func PluginTemplateKey(pluginID, key string) string {
+	return pluginTemplatePrefix + pluginID + "/" + key
+}
+
+// ParsePluginTemplateKey returns the plugin which contributed the template
+// of a key, and its key in the plugin's report, if it was contributed by
+// a plugin.
+func ParsePluginTemplateKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, pluginTemplatePrefix) {
+		return "", "", false
+	}
+	fields := strings.SplitN(key[len(pluginTemplatePrefix):], "/", 2)
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// NamespacePluginTemplates rekeys the metadata and table templates of the
+// report of a plugin with PluginTemplateKey.
+func (r *Report) NamespacePluginTemplates(pluginID string) {
+	r.WalkTopologies(func(t *Topology) {
+		if len(t.MetadataTemplates) > 0 {
+			templates := make(MetadataTemplates, len(t.MetadataTemplates))
+			for key, template := range t.MetadataTemplates {
+				if _, _, ok := ParsePluginTemplateKey(key); !ok {
+					key = PluginTemplateKey(pluginID, key)
+				}
+				templates[key] = template
+			}
+			t.MetadataTemplates = templates
+		}
+		if len(t.TableTemplates) > 0 {
+			templates := make(TableTemplates, len(t.TableTemplates))
+			for key, template := range t.TableTemplates {
+				if _, _, ok := ParsePluginTemplateKey(key); !ok {
+					key = PluginTemplateKey(pluginID, key)
+				}
+				templates[key] = template
+			}
+			t.TableTemplates = templates
+		}
+	})
+}
+
+// ResolvePluginTemplates returns a copy of the report in which the
+// templates contributed by plugins are merged into the built-in ones:
+// those of plugins no longer in the report are dropped, as are those for
+// the same fields as built-in templates, or as the templates of plugins
+// with lower IDs.  The nodes are shared with the report.
+func (r Report) ResolvePluginTemplates() Report {
+	r.WalkTopologies(func(t *Topology) {
+		t.MetadataTemplates = t.MetadataTemplates.resolvePlugins(r)
+		t.TableTemplates = t.TableTemplates.resolvePlugins(r)
+	})
+	return r
+}
+
+// pluginKeys returns the keys of the templates contributed by plugins
+// still in the report, in order, or nil if there are none at all.
+func pluginKeys(r Report, keys []string) ([]string, bool) {
+	var resolved []string
+	namespaced := false
+	for _, key := range keys {
+		pluginID, _, ok := ParsePluginTemplateKey(key)
+		if !ok {
+			continue
+		}
+		namespaced = true
+		if _, ok := r.Plugins.Lookup(pluginID); ok {
+			resolved = append(resolved, key)
+		}
+	}
+	sort.Strings(resolved)
+	return resolved, namespaced
+}
+
+func (e MetadataTemplates) resolvePlugins(r Report) MetadataTemplates {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	plugins, namespaced := pluginKeys(r, keys)
+	if !namespaced {
+		return e
+	}
+	result := MetadataTemplates{}
+	fields := map[string]struct{}{}
+	for key, template := range e {
+		if _, _, ok := ParsePluginTemplateKey(key); !ok {
+			result[key] = template
+			fields[template.ID] = struct{}{}
+		}
+	}
+	for _, key := range plugins {
+		template := e[key]
+		if _, ok := fields[template.ID]; ok {
+			continue
+		}
+		result[key] = template
+		fields[template.ID] = struct{}{}
+	}
+	return result
+}
+
+func (t TableTemplates) resolvePlugins(r Report) TableTemplates {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	plugins, namespaced := pluginKeys(r, keys)
+	if !namespaced {
+		return t
+	}
+	result := TableTemplates{}
+	prefixes := map[string]struct{}{}
+	for key, template := range t {
+		if _, _, ok := ParsePluginTemplateKey(key); !ok {
+			result[key] = template
+			prefixes[template.Prefix] = struct{}{}
+		}
+	}
+	for _, key := range plugins {
+		template := t[key]
+		if _, ok := prefixes[template.Prefix]; ok {
+			continue
+		}
+		result[key] = template
+		prefixes[template.Prefix] = struct{}{}
+	}
+	return result
+}
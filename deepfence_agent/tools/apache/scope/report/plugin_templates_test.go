@@ -0,0 +1,89 @@
+package report_test
+
+import (
+	"testing"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+func TestPluginTemplateKey(t *testing.T) {
+	key := report.PluginTemplateKey("iowait", "iowait/device")
+	if pluginID, k, ok := report.ParsePluginTemplateKey(key); !ok || pluginID != "iowait" || k != "iowait/device" {
+		t.Errorf("unexpected parse of %q: %q %q %v", key, pluginID, k, ok)
+	}
+	if _, _, ok := report.ParsePluginTemplateKey("host_name"); ok {
+		t.Errorf("built-in key parsed as a plugin's")
+	}
+}
+
+func TestNamespacePluginTemplates(t *testing.T) {
+	rpt := report.MakeReport()
+	rpt.Host = rpt.Host.WithMetadataTemplates(report.MetadataTemplates{
+		"iowait": {ID: "iowait", Label: "IO Wait"},
+	}).WithTableTemplates(report.TableTemplates{
+		"devices": {ID: "devices", Prefix: "iowait_device_"},
+	})
+	devices := rpt.Host.TableTemplates["devices"]
+	rpt.NamespacePluginTemplates("iowait")
+	// Idempotent
+	rpt.NamespacePluginTemplates("iowait")
+
+	wantMetadata := report.MetadataTemplates{
+		report.PluginTemplateKey("iowait", "iowait"): {ID: "iowait", Label: "IO Wait"},
+	}
+	if !reflect.DeepEqual(wantMetadata, rpt.Host.MetadataTemplates) {
+		t.Errorf("%s", test.Diff(wantMetadata, rpt.Host.MetadataTemplates))
+	}
+	wantTables := report.TableTemplates{
+		report.PluginTemplateKey("iowait", "devices"): devices,
+	}
+	if !reflect.DeepEqual(wantTables, rpt.Host.TableTemplates) {
+		t.Errorf("%s", test.Diff(wantTables, rpt.Host.TableTemplates))
+	}
+}
+
+func TestResolvePluginTemplates(t *testing.T) {
+	rpt := report.MakeReport()
+	rpt.Plugins = rpt.Plugins.Add(xfer.PluginSpec{ID: "a"}, xfer.PluginSpec{ID: "b"})
+	rpt.Host = rpt.Host.WithMetadataTemplates(report.MetadataTemplates{
+		report.HostName:                         {ID: report.HostName, Label: "Hostname"},
+		report.PluginTemplateKey("a", "name"):   {ID: report.HostName, Label: "A hostname"},
+		report.PluginTemplateKey("a", "iowait"): {ID: "iowait", Label: "A IO Wait"},
+		report.PluginTemplateKey("b", "iowait"): {ID: "iowait", Label: "B IO Wait"},
+		report.PluginTemplateKey("b", "load"):   {ID: "load", Label: "Load"},
+		report.PluginTemplateKey("c", "gone"):   {ID: "gone", Label: "Gone"},
+	}).WithTableTemplates(report.TableTemplates{
+		"host_plugin_":                         {ID: "host_plugin_", Prefix: "host_plugin_"},
+		report.PluginTemplateKey("b", "table"): {ID: "plugins", Prefix: "host_plugin_"},
+		report.PluginTemplateKey("c", "table"): {ID: "gone", Prefix: "gone_"},
+	})
+	rpt.Container = rpt.Container.WithMetadataTemplates(report.MetadataTemplates{
+		"container_id": {ID: "container_id", Label: "ID"},
+	})
+	resolved := rpt.ResolvePluginTemplates()
+
+	wantMetadata := report.MetadataTemplates{
+		report.HostName:                         {ID: report.HostName, Label: "Hostname"},
+		report.PluginTemplateKey("a", "iowait"): {ID: "iowait", Label: "A IO Wait"},
+		report.PluginTemplateKey("b", "load"):   {ID: "load", Label: "Load"},
+	}
+	if !reflect.DeepEqual(wantMetadata, resolved.Host.MetadataTemplates) {
+		t.Errorf("%s", test.Diff(wantMetadata, resolved.Host.MetadataTemplates))
+	}
+	wantTables := report.TableTemplates{
+		"host_plugin_": rpt.Host.TableTemplates["host_plugin_"],
+	}
+	if !reflect.DeepEqual(wantTables, resolved.Host.TableTemplates) {
+		t.Errorf("%s", test.Diff(wantTables, resolved.Host.TableTemplates))
+	}
+	if !reflect.DeepEqual(rpt.Container.MetadataTemplates, resolved.Container.MetadataTemplates) {
+		t.Errorf("%s", test.Diff(rpt.Container.MetadataTemplates, resolved.Container.MetadataTemplates))
+	}
+	// The report itself is left alone
+	if len(rpt.Host.MetadataTemplates) != 6 {
+		t.Errorf("report modified: %v", rpt.Host.MetadataTemplates)
+	}
+}
@@ -0,0 +1,450 @@
+package report
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/weaveworks/scope/common/xfer"
+)
+
+// The messages of report.proto.  Fields unknown to this version, from
+// newer probes, are kept in XXX_unrecognized rather than failing decoding.
+// Reports, topologies and nodes are only encoded with these: they are
+// decoded by hand, in protobuf_decode.go.
+// The tags of strings leave out proto3, which would have them checked to be
+// UTF-8: command lines, labels and such needn't be.
+
+type pbReport struct {
+	TS               int64                   `protobuf:"varint,1,opt,name=ts,proto3"`
+	Topologies       map[string]*pbTopology  `protobuf:"bytes,2,rep,name=topologies,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	DNS              map[string]*pbDNSRecord `protobuf:"bytes,3,rep,name=dns,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Sampling         *pbSampling             `protobuf:"bytes,4,opt,name=sampling,proto3"`
+	Truncation       *pbTruncation           `protobuf:"bytes,5,opt,name=truncation,proto3"`
+	Window           int64                   `protobuf:"varint,6,opt,name=window,proto3"`
+	Shortcut         bool                    `protobuf:"varint,7,opt,name=shortcut,proto3"`
+	Plugins          []*pbPluginSpec         `protobuf:"bytes,8,rep,name=plugins,proto3"`
+	ID               string                  `protobuf:"bytes,9,opt,name=id"`
+	IDs              []string                `protobuf:"bytes,10,rep,name=ids"`
+	XXX_unrecognized []byte
+}
+
+type pbTopology struct {
+	Shape             string                         `protobuf:"bytes,1,opt,name=shape"`
+	Tag               string                         `protobuf:"bytes,2,opt,name=tag"`
+	Label             string                         `protobuf:"bytes,3,opt,name=label"`
+	LabelPlural       string                         `protobuf:"bytes,4,opt,name=label_plural"`
+	Nodes             []*pbNode                      `protobuf:"bytes,5,rep,name=nodes,proto3"`
+	Controls          map[string]*pbControl          `protobuf:"bytes,6,rep,name=controls,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	MetadataTemplates map[string]*pbMetadataTemplate `protobuf:"bytes,7,rep,name=metadata_templates,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	MetricTemplates   map[string]*pbMetricTemplate   `protobuf:"bytes,8,rep,name=metric_templates,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	TableTemplates    map[string]*pbTableTemplate    `protobuf:"bytes,9,rep,name=table_templates,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_unrecognized  []byte
+}
+
+type pbNode struct {
+	ID               string               `protobuf:"bytes,1,opt,name=id"`
+	Topology         string               `protobuf:"bytes,2,opt,name=topology"`
+	Sets             []*pbStringSet       `protobuf:"bytes,3,rep,name=sets,proto3"`
+	Adjacency        []string             `protobuf:"bytes,4,rep,name=adjacency"`
+	Latest           []*pbLatestEntry     `protobuf:"bytes,5,rep,name=latest,proto3"`
+	Metrics          map[string]*pbMetric `protobuf:"bytes,6,rep,name=metrics,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Parents          []*pbStringSet       `protobuf:"bytes,7,rep,name=parents,proto3"`
+	Children         []*pbNode            `protobuf:"bytes,8,rep,name=children,proto3"`
+	AdjacencyIDs     []uint64             `protobuf:"varint,9,rep,packed,name=adjacency_ids,proto3"`
+	Edges            []*pbEdgeMetadata    `protobuf:"bytes,10,rep,name=edges,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbEdgeMetadata struct {
+	To                 string `protobuf:"bytes,1,opt,name=to"`
+	EgressPacketCount  uint64 `protobuf:"varint,2,opt,name=egress_packet_count,proto3"`
+	IngressPacketCount uint64 `protobuf:"varint,3,opt,name=ingress_packet_count,proto3"`
+	EgressByteCount    uint64 `protobuf:"varint,4,opt,name=egress_byte_count,proto3"`
+	IngressByteCount   uint64 `protobuf:"varint,5,opt,name=ingress_byte_count,proto3"`
+	XXX_unrecognized   []byte
+}
+
+type pbStringSet struct {
+	Key              string   `protobuf:"bytes,1,opt,name=key"`
+	Values           []string `protobuf:"bytes,2,rep,name=values"`
+	ValueIDs         []uint64 `protobuf:"varint,3,rep,packed,name=value_ids,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbLatestEntry struct {
+	Key              string `protobuf:"bytes,1,opt,name=key"`
+	Timestamp        int64  `protobuf:"varint,2,opt,name=timestamp,proto3"`
+	Value            string `protobuf:"bytes,3,opt,name=value"`
+	XXX_unrecognized []byte
+}
+
+type pbMetric struct {
+	Samples          []*pbSample `protobuf:"bytes,1,rep,name=samples,proto3"`
+	Min              float64     `protobuf:"fixed64,2,opt,name=min,proto3"`
+	Max              float64     `protobuf:"fixed64,3,opt,name=max,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbSample struct {
+	Timestamp        int64   `protobuf:"varint,1,opt,name=timestamp,proto3"`
+	Value            float64 `protobuf:"fixed64,2,opt,name=value,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbControl struct {
+	ID               string `protobuf:"bytes,1,opt,name=id"`
+	Human            string `protobuf:"bytes,2,opt,name=human"`
+	Icon             string `protobuf:"bytes,3,opt,name=icon"`
+	Confirmation     string `protobuf:"bytes,4,opt,name=confirmation"`
+	Rank             int64  `protobuf:"varint,5,opt,name=rank,proto3"`
+	Destructive      bool   `protobuf:"varint,6,opt,name=destructive,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbMetadataTemplate struct {
+	ID               string  `protobuf:"bytes,1,opt,name=id"`
+	Label            string  `protobuf:"bytes,2,opt,name=label"`
+	Truncate         int64   `protobuf:"varint,3,opt,name=truncate,proto3"`
+	Datatype         string  `protobuf:"bytes,4,opt,name=datatype"`
+	Priority         float64 `protobuf:"fixed64,5,opt,name=priority,proto3"`
+	From             string  `protobuf:"bytes,6,opt,name=from"`
+	XXX_unrecognized []byte
+}
+
+type pbMetricTemplate struct {
+	ID               string  `protobuf:"bytes,1,opt,name=id"`
+	Label            string  `protobuf:"bytes,2,opt,name=label"`
+	Format           string  `protobuf:"bytes,3,opt,name=format"`
+	Group            string  `protobuf:"bytes,4,opt,name=group"`
+	Priority         float64 `protobuf:"fixed64,5,opt,name=priority,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbTableTemplate struct {
+	ID               string            `protobuf:"bytes,1,opt,name=id"`
+	Label            string            `protobuf:"bytes,2,opt,name=label"`
+	Prefix           string            `protobuf:"bytes,3,opt,name=prefix"`
+	Type             string            `protobuf:"bytes,4,opt,name=type"`
+	Columns          []*pbColumn       `protobuf:"bytes,5,rep,name=columns,proto3"`
+	FixedRows        map[string]string `protobuf:"bytes,6,rep,name=fixed_rows,proto3" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	XXX_unrecognized []byte
+}
+
+type pbColumn struct {
+	ID               string `protobuf:"bytes,1,opt,name=id"`
+	Label            string `protobuf:"bytes,2,opt,name=label"`
+	DataType         string `protobuf:"bytes,3,opt,name=data_type"`
+	XXX_unrecognized []byte
+}
+
+type pbDNSRecord struct {
+	Forward          []string `protobuf:"bytes,1,rep,name=forward"`
+	Reverse          []string `protobuf:"bytes,2,rep,name=reverse"`
+	XXX_unrecognized []byte
+}
+
+type pbSampling struct {
+	Count            uint64 `protobuf:"varint,1,opt,name=count,proto3"`
+	Total            uint64 `protobuf:"varint,2,opt,name=total,proto3"`
+	XXX_unrecognized []byte
+}
+
+type pbTruncation struct {
+	EndpointAdjacencies int64 `protobuf:"varint,1,opt,name=endpoint_adjacencies,proto3"`
+	CommandLines        int64 `protobuf:"varint,2,opt,name=command_lines,proto3"`
+	Processes           int64 `protobuf:"varint,3,opt,name=processes,proto3"`
+	XXX_unrecognized    []byte
+}
+
+type pbPluginSpec struct {
+	ID               string   `protobuf:"bytes,1,opt,name=id"`
+	Label            string   `protobuf:"bytes,2,opt,name=label"`
+	Description      string   `protobuf:"bytes,3,opt,name=description"`
+	Interfaces       []string `protobuf:"bytes,4,rep,name=interfaces"`
+	APIVersion       string   `protobuf:"bytes,5,opt,name=api_version"`
+	Status           string   `protobuf:"bytes,6,opt,name=status"`
+	XXX_unrecognized []byte
+}
+
+func (m *pbReport) Reset()         { *m = pbReport{} }
+func (m *pbReport) String() string { return proto.CompactTextString(m) }
+func (*pbReport) ProtoMessage()    {}
+
+func (m *pbTopology) Reset()         { *m = pbTopology{} }
+func (m *pbTopology) String() string { return proto.CompactTextString(m) }
+func (*pbTopology) ProtoMessage()    {}
+
+func (m *pbNode) Reset()         { *m = pbNode{} }
+func (m *pbNode) String() string { return proto.CompactTextString(m) }
+func (*pbNode) ProtoMessage()    {}
+
+func (m *pbEdgeMetadata) Reset()         { *m = pbEdgeMetadata{} }
+func (m *pbEdgeMetadata) String() string { return proto.CompactTextString(m) }
+func (*pbEdgeMetadata) ProtoMessage()    {}
+
+func (m *pbStringSet) Reset()         { *m = pbStringSet{} }
+func (m *pbStringSet) String() string { return proto.CompactTextString(m) }
+func (*pbStringSet) ProtoMessage()    {}
+
+func (m *pbLatestEntry) Reset()         { *m = pbLatestEntry{} }
+func (m *pbLatestEntry) String() string { return proto.CompactTextString(m) }
+func (*pbLatestEntry) ProtoMessage()    {}
+
+func (m *pbMetric) Reset()         { *m = pbMetric{} }
+func (m *pbMetric) String() string { return proto.CompactTextString(m) }
+func (*pbMetric) ProtoMessage()    {}
+
+func (m *pbSample) Reset()         { *m = pbSample{} }
+func (m *pbSample) String() string { return proto.CompactTextString(m) }
+func (*pbSample) ProtoMessage()    {}
+
+func (m *pbControl) Reset()         { *m = pbControl{} }
+func (m *pbControl) String() string { return proto.CompactTextString(m) }
+func (*pbControl) ProtoMessage()    {}
+
+func (m *pbMetadataTemplate) Reset()         { *m = pbMetadataTemplate{} }
+func (m *pbMetadataTemplate) String() string { return proto.CompactTextString(m) }
+func (*pbMetadataTemplate) ProtoMessage()    {}
+
+func (m *pbMetricTemplate) Reset()         { *m = pbMetricTemplate{} }
+func (m *pbMetricTemplate) String() string { return proto.CompactTextString(m) }
+func (*pbMetricTemplate) ProtoMessage()    {}
+
+func (m *pbTableTemplate) Reset()         { *m = pbTableTemplate{} }
+func (m *pbTableTemplate) String() string { return proto.CompactTextString(m) }
+func (*pbTableTemplate) ProtoMessage()    {}
+
+func (m *pbColumn) Reset()         { *m = pbColumn{} }
+func (m *pbColumn) String() string { return proto.CompactTextString(m) }
+func (*pbColumn) ProtoMessage()    {}
+
+func (m *pbDNSRecord) Reset()         { *m = pbDNSRecord{} }
+func (m *pbDNSRecord) String() string { return proto.CompactTextString(m) }
+func (*pbDNSRecord) ProtoMessage()    {}
+
+func (m *pbSampling) Reset()         { *m = pbSampling{} }
+func (m *pbSampling) String() string { return proto.CompactTextString(m) }
+func (*pbSampling) ProtoMessage()    {}
+
+func (m *pbTruncation) Reset()         { *m = pbTruncation{} }
+func (m *pbTruncation) String() string { return proto.CompactTextString(m) }
+func (*pbTruncation) ProtoMessage()    {}
+
+func (m *pbPluginSpec) Reset()         { *m = pbPluginSpec{} }
+func (m *pbPluginSpec) String() string { return proto.CompactTextString(m) }
+func (*pbPluginSpec) ProtoMessage()    {}
+
+// WriteProtobuf writes a Report as a gzipped protobuf into a bytes.Buffer
+func (rep Report) WriteProtobuf() (*bytes.Buffer, error) {
+	return rep.writeProtobuf(nil, nil)
+}
+
+// WriteProtobufIDTable writes a Report as WriteProtobuf does, but with the
+// node IDs of adjacencies and parents in a table, and referred to by their
+// indices, which makes reports with many connections much smaller.  Only
+// apps with the protobuf_id_table capability can read these; they read
+// either.
+func (rep Report) WriteProtobufIDTable() (*bytes.Buffer, error) {
+	return rep.writeProtobuf(&idTable{index: map[string]uint64{}}, nil)
+}
+
+func (rep Report) writeProtobuf(ids *idTable, h hash.Hash) (*bytes.Buffer, error) {
+	buf, err := proto.Marshal(rep.toProtobuf(ids))
+	if err != nil {
+		return nil, err
+	}
+	return writeGzipped(h, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// readProtobuf decodes a protobuf into the report, which should have been
+// made with MakeReport.
+func (rep *Report) readProtobuf(buf []byte) error {
+	d := pbDecoder{strings: interner{}}
+	return d.report(rep, buf)
+}
+
+// unixNano is the time in nanoseconds since the epoch, keeping zero for
+// unset times.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+// idTable numbers the node IDs of the adjacencies and parents of a report
+// in order of first use, for them to be encoded once.
+type idTable struct {
+	ids   []string
+	index map[string]uint64
+}
+
+func (t *idTable) indices(ids []string) []uint64 {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := make([]uint64, len(ids))
+	for i, id := range ids {
+		n, ok := t.index[id]
+		if !ok {
+			n = uint64(len(t.ids))
+			t.index[id] = n
+			t.ids = append(t.ids, id)
+		}
+		result[i] = n
+	}
+	return result
+}
+
+// toProtobuf converts the report, with the node IDs of its adjacencies and
+// parents in ids, unless it is nil.
+func (rep Report) toProtobuf(ids *idTable) *pbReport {
+	p := &pbReport{
+		TS:         unixNano(rep.TS),
+		Topologies: map[string]*pbTopology{},
+		Sampling:   &pbSampling{Count: rep.Sampling.Count, Total: rep.Sampling.Total},
+		Truncation: &pbTruncation{
+			EndpointAdjacencies: int64(rep.Truncation.EndpointAdjacencies),
+			CommandLines:        int64(rep.Truncation.CommandLines),
+			Processes:           int64(rep.Truncation.Processes),
+		},
+		Window:   int64(rep.Window),
+		Shortcut: rep.Shortcut,
+		ID:       rep.ID,
+	}
+	rep.WalkNamedTopologies(func(name string, t *Topology) {
+		p.Topologies[name] = t.toProtobuf(ids)
+	})
+	if ids != nil {
+		p.IDs = ids.ids
+	}
+	if len(rep.DNS) > 0 {
+		p.DNS = make(map[string]*pbDNSRecord, len(rep.DNS))
+		for key, record := range rep.DNS {
+			p.DNS[key] = &pbDNSRecord{Forward: []string(record.Forward), Reverse: []string(record.Reverse)}
+		}
+	}
+	rep.Plugins.ForEach(func(spec xfer.PluginSpec) {
+		p.Plugins = append(p.Plugins, &pbPluginSpec{
+			ID:          spec.ID,
+			Label:       spec.Label,
+			Description: spec.Description,
+			Interfaces:  spec.Interfaces,
+			APIVersion:  spec.APIVersion,
+			Status:      spec.Status,
+		})
+	})
+	return p
+}
+
+func (t Topology) toProtobuf(ids *idTable) *pbTopology {
+	p := &pbTopology{
+		Shape:       t.Shape,
+		Tag:         t.Tag,
+		Label:       t.Label,
+		LabelPlural: t.LabelPlural,
+		Nodes:       make([]*pbNode, 0, len(t.Nodes)),
+	}
+	for _, node := range t.Nodes {
+		p.Nodes = append(p.Nodes, node.toProtobuf(ids))
+	}
+	if len(t.Controls) > 0 {
+		p.Controls = make(map[string]*pbControl, len(t.Controls))
+		for key, c := range t.Controls {
+			p.Controls[key] = &pbControl{ID: c.ID, Human: c.Human, Icon: c.Icon, Confirmation: c.Confirmation, Rank: int64(c.Rank), Destructive: c.Destructive}
+		}
+	}
+	if len(t.MetadataTemplates) > 0 {
+		p.MetadataTemplates = make(map[string]*pbMetadataTemplate, len(t.MetadataTemplates))
+		for key, m := range t.MetadataTemplates {
+			p.MetadataTemplates[key] = &pbMetadataTemplate{ID: m.ID, Label: m.Label, Truncate: int64(m.Truncate), Datatype: m.Datatype, Priority: m.Priority, From: m.From}
+		}
+	}
+	if len(t.MetricTemplates) > 0 {
+		p.MetricTemplates = make(map[string]*pbMetricTemplate, len(t.MetricTemplates))
+		for key, m := range t.MetricTemplates {
+			p.MetricTemplates[key] = &pbMetricTemplate{ID: m.ID, Label: m.Label, Format: m.Format, Group: m.Group, Priority: m.Priority}
+		}
+	}
+	if len(t.TableTemplates) > 0 {
+		p.TableTemplates = make(map[string]*pbTableTemplate, len(t.TableTemplates))
+		for key, table := range t.TableTemplates {
+			pt := &pbTableTemplate{ID: table.ID, Label: table.Label, Prefix: table.Prefix, Type: table.Type, FixedRows: table.FixedRows}
+			for _, c := range table.Columns {
+				pt.Columns = append(pt.Columns, &pbColumn{ID: c.ID, Label: c.Label, DataType: c.DataType})
+			}
+			p.TableTemplates[key] = pt
+		}
+	}
+	return p
+}
+
+func (n Node) toProtobuf(ids *idTable) *pbNode {
+	p := &pbNode{
+		ID:       n.ID,
+		Topology: n.Topology,
+		Sets:     setsToProtobuf(n.Sets, nil),
+		Parents:  setsToProtobuf(n.Parents, ids),
+	}
+	if ids != nil {
+		p.AdjacencyIDs = ids.indices(n.Adjacency)
+	} else {
+		p.Adjacency = []string(n.Adjacency)
+	}
+	n.Edges.ForEach(func(to string, e EdgeMetadata) {
+		p.Edges = append(p.Edges, &pbEdgeMetadata{To: to, EgressPacketCount: e.EgressPacketCount, IngressPacketCount: e.IngressPacketCount, EgressByteCount: e.EgressByteCount, IngressByteCount: e.IngressByteCount})
+	})
+	if len(n.Latest) > 0 {
+		p.Latest = make([]*pbLatestEntry, 0, len(n.Latest))
+		n.Latest.ForEach(func(key string, timestamp time.Time, value string) {
+			p.Latest = append(p.Latest, &pbLatestEntry{Key: key, Timestamp: unixNano(timestamp), Value: value})
+		})
+	}
+	if len(n.Metrics) > 0 {
+		p.Metrics = make(map[string]*pbMetric, len(n.Metrics))
+		for key, m := range n.Metrics {
+			pm := &pbMetric{Samples: make([]*pbSample, 0, len(m.Samples)), Min: m.Min, Max: m.Max}
+			for _, s := range m.Samples {
+				pm.Samples = append(pm.Samples, &pbSample{Timestamp: unixNano(s.Timestamp), Value: s.Value})
+			}
+			p.Metrics[key] = pm
+		}
+	}
+	n.Children.ForEach(func(child Node) {
+		p.Children = append(p.Children, child.toProtobuf(ids))
+	})
+	return p
+}
+
+// setsToProtobuf converts the sets, with their values in ids, unless it is
+// nil.
+func setsToProtobuf(s Sets, ids *idTable) []*pbStringSet {
+	keys := s.Keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	result := make([]*pbStringSet, 0, len(keys))
+	for _, key := range keys {
+		values, _ := s.Lookup(key)
+		if ids != nil {
+			result = append(result, &pbStringSet{Key: key, ValueIDs: ids.indices(values)})
+		} else {
+			result = append(result, &pbStringSet{Key: key, Values: []string(values)})
+		}
+	}
+	return result
+}
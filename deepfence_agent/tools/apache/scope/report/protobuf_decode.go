@@ -0,0 +1,614 @@
+package report
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/weaveworks/scope/common/xfer"
+)
+
+// Reports, their topologies and nodes are decoded by hand rather than by
+// proto.Unmarshal, so that their strings are interned straight from the
+// encoded bytes, and nodes are built without going through the pb types.
+// The small messages, whose strings aren't repeated, are still left to
+// proto.Unmarshal.  As with proto.Unmarshal, fields unknown to this
+// version, or of an unexpected wire type, are skipped.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errTruncated = errors.New("protobuf: truncated message")
+	errIDIndex   = errors.New("protobuf: node ID index out of range")
+)
+
+// pbReader reads the fields of an encoded message.
+type pbReader struct {
+	buf []byte
+	err error
+}
+
+// next reads the key of the next field, returning false at the end of the
+// message or on error.
+func (r *pbReader) next() (field, wire int, ok bool) {
+	if r.err != nil || len(r.buf) == 0 {
+		return 0, 0, false
+	}
+	key := r.varint()
+	if r.err != nil {
+		return 0, 0, false
+	}
+	return int(key >> 3), int(key & 7), true
+}
+
+func (r *pbReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *pbReader) varint() uint64 {
+	// Most are keys and lengths of under 128
+	if len(r.buf) > 0 && r.buf[0] < 0x80 {
+		x := uint64(r.buf[0])
+		r.buf = r.buf[1:]
+		return x
+	}
+	x, n := proto.DecodeVarint(r.buf)
+	if n == 0 {
+		r.fail(errTruncated)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+func (r *pbReader) fixed64() uint64 {
+	if len(r.buf) < 8 {
+		r.fail(errTruncated)
+		return 0
+	}
+	x := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return x
+}
+
+func (r *pbReader) float64() float64 {
+	return math.Float64frombits(r.fixed64())
+}
+
+// bytes reads a length-delimited field.  The result is part of the buffer
+// being read, so must be copied to be kept.
+func (r *pbReader) bytes() []byte {
+	n := r.varint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.fail(errTruncated)
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *pbReader) skip(wire int) {
+	switch wire {
+	case wireVarint:
+		r.varint()
+	case wireFixed64:
+		r.fixed64()
+	case wireBytes:
+		r.bytes()
+	case wireFixed32:
+		if len(r.buf) < 4 {
+			r.fail(errTruncated)
+			return
+		}
+		r.buf = r.buf[4:]
+	default:
+		r.fail(fmt.Errorf("protobuf: unsupported wire type %d", wire))
+	}
+}
+
+// count returns the number of fields of a message with the given number,
+// without reading them.
+func (r pbReader) count(field int) int {
+	n := 0
+	for {
+		f, wire, ok := r.next()
+		if !ok {
+			return n
+		}
+		if f == field {
+			n++
+		}
+		r.skip(wire)
+	}
+}
+
+// failWith fails r if the reader of one of its fields failed.
+func (r *pbReader) failWith(field *pbReader) {
+	if field.err != nil {
+		r.fail(field.err)
+	}
+}
+
+// mapEntry reads an entry of a map field.  value is nil if the entry has
+// none.
+func (r *pbReader) mapEntry() (key, value []byte) {
+	entry := pbReader{buf: r.bytes()}
+	defer r.failWith(&entry)
+	for {
+		field, wire, ok := entry.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			key = entry.bytes()
+		case field == 2 && wire == wireBytes:
+			value = entry.bytes()
+		default:
+			entry.skip(wire)
+		}
+	}
+	return key, value
+}
+
+// pbDecoder decodes reports, interning their strings.
+type pbDecoder struct {
+	strings interner
+
+	// ids is the report's table of node IDs, if it has one.
+	ids []string
+
+	// values is reused to collect the values of string sets.
+	values []string
+}
+
+func (d *pbDecoder) string(r *pbReader) string {
+	return d.strings.bytes(r.bytes())
+}
+
+func (d *pbDecoder) report(rep *Report, buf []byte) error {
+	// The table of node IDs is encoded after the topologies which refer
+	// to it
+	d.ids = d.idTable(buf)
+	r := pbReader{buf: buf}
+	specs := []xfer.PluginSpec{}
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			rep.TS = fromUnixNano(int64(r.varint()))
+		case field == 2 && wire == wireBytes:
+			key, value := r.mapEntry()
+			// Topologies unknown to this version are left out
+			if topology := rep.topology(d.strings.bytes(key)); topology != nil && value != nil {
+				t, err := d.topology(value)
+				if err != nil {
+					return err
+				}
+				*topology = t
+			}
+		case field == 3 && wire == wireBytes:
+			key, value := r.mapEntry()
+			if value == nil {
+				continue
+			}
+			record := pbDNSRecord{}
+			if err := proto.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			rep.DNS[string(key)] = DNSRecord{Forward: MakeStringSet(record.Forward...), Reverse: MakeStringSet(record.Reverse...)}
+		case field == 4 && wire == wireBytes:
+			sampling := pbSampling{}
+			if err := proto.Unmarshal(r.bytes(), &sampling); err != nil {
+				return err
+			}
+			rep.Sampling = Sampling{Count: sampling.Count, Total: sampling.Total}
+		case field == 5 && wire == wireBytes:
+			truncation := pbTruncation{}
+			if err := proto.Unmarshal(r.bytes(), &truncation); err != nil {
+				return err
+			}
+			rep.Truncation = Truncation{
+				EndpointAdjacencies: int(truncation.EndpointAdjacencies),
+				CommandLines:        int(truncation.CommandLines),
+				Processes:           int(truncation.Processes),
+			}
+		case field == 6 && wire == wireVarint:
+			rep.Window = time.Duration(r.varint())
+		case field == 7 && wire == wireVarint:
+			rep.Shortcut = r.varint() != 0
+		case field == 8 && wire == wireBytes:
+			spec := pbPluginSpec{}
+			if err := proto.Unmarshal(r.bytes(), &spec); err != nil {
+				return err
+			}
+			specs = append(specs, xfer.PluginSpec{
+				ID:          spec.ID,
+				Label:       spec.Label,
+				Description: spec.Description,
+				Interfaces:  spec.Interfaces,
+				APIVersion:  spec.APIVersion,
+				Status:      spec.Status,
+			})
+		case field == 9 && wire == wireBytes:
+			rep.ID = string(r.bytes())
+		default:
+			r.skip(wire)
+		}
+	}
+	rep.Plugins = xfer.MakePluginSpecs(specs...)
+	return r.err
+}
+
+// idTable reads the table of node IDs of a report, skipping the other
+// fields.  Failures are left to be found reading them.
+func (d *pbDecoder) idTable(buf []byte) []string {
+	var (
+		ids []string
+		r   = pbReader{buf: buf}
+	)
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			return ids
+		}
+		if field == 10 && wire == wireBytes {
+			ids = append(ids, d.string(&r))
+		} else {
+			r.skip(wire)
+		}
+	}
+}
+
+// appendIDs appends the node IDs of a field of indices into the table,
+// packed or not, to ids.
+func (d *pbDecoder) appendIDs(r *pbReader, wire int, ids []string) []string {
+	if wire == wireVarint {
+		return d.appendID(r, r.varint(), ids)
+	}
+	packed := pbReader{buf: r.bytes()}
+	defer r.failWith(&packed)
+	for len(packed.buf) > 0 {
+		ids = d.appendID(&packed, packed.varint(), ids)
+	}
+	return ids
+}
+
+func (d *pbDecoder) appendID(r *pbReader, i uint64, ids []string) []string {
+	if r.err != nil {
+		return ids
+	}
+	if i >= uint64(len(d.ids)) {
+		r.fail(errIDIndex)
+		return ids
+	}
+	return append(ids, d.ids[i])
+}
+
+func (d *pbDecoder) topology(buf []byte) (Topology, error) {
+	t := MakeTopology()
+	r := pbReader{buf: buf}
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		if wire != wireBytes {
+			r.skip(wire)
+			continue
+		}
+		switch field {
+		case 1:
+			t.Shape = d.string(&r)
+		case 2:
+			t.Tag = d.string(&r)
+		case 3:
+			t.Label = d.string(&r)
+		case 4:
+			t.LabelPlural = d.string(&r)
+		case 5:
+			node, err := d.node(r.bytes())
+			if err != nil {
+				return t, err
+			}
+			t.Nodes[node.ID] = node
+		case 6:
+			key, value := r.mapEntry()
+			if value == nil {
+				continue
+			}
+			c := pbControl{}
+			if err := proto.Unmarshal(value, &c); err != nil {
+				return t, err
+			}
+			t.Controls[string(key)] = Control{ID: c.ID, Human: c.Human, Icon: c.Icon, Confirmation: c.Confirmation, Rank: int(c.Rank), Destructive: c.Destructive}
+		case 7:
+			key, value := r.mapEntry()
+			if t.MetadataTemplates == nil {
+				t.MetadataTemplates = MetadataTemplates{}
+			}
+			if value == nil {
+				continue
+			}
+			m := pbMetadataTemplate{}
+			if err := proto.Unmarshal(value, &m); err != nil {
+				return t, err
+			}
+			t.MetadataTemplates[string(key)] = MetadataTemplate{ID: m.ID, Label: m.Label, Truncate: int(m.Truncate), Datatype: m.Datatype, Priority: m.Priority, From: m.From}
+		case 8:
+			key, value := r.mapEntry()
+			if t.MetricTemplates == nil {
+				t.MetricTemplates = MetricTemplates{}
+			}
+			if value == nil {
+				continue
+			}
+			m := pbMetricTemplate{}
+			if err := proto.Unmarshal(value, &m); err != nil {
+				return t, err
+			}
+			t.MetricTemplates[string(key)] = MetricTemplate{ID: m.ID, Label: m.Label, Format: m.Format, Group: m.Group, Priority: m.Priority}
+		case 9:
+			key, value := r.mapEntry()
+			if t.TableTemplates == nil {
+				t.TableTemplates = TableTemplates{}
+			}
+			if value == nil {
+				continue
+			}
+			pt := pbTableTemplate{}
+			if err := proto.Unmarshal(value, &pt); err != nil {
+				return t, err
+			}
+			table := TableTemplate{ID: pt.ID, Label: pt.Label, Prefix: pt.Prefix, Type: pt.Type, FixedRows: pt.FixedRows}
+			for _, c := range pt.Columns {
+				if c != nil {
+					table.Columns = append(table.Columns, Column{ID: c.ID, Label: c.Label, DataType: c.DataType})
+				}
+			}
+			t.TableTemplates[string(key)] = table
+		default:
+			r.skip(wire)
+		}
+	}
+	return t, r.err
+}
+
+func (d *pbDecoder) node(buf []byte) (Node, error) {
+	var (
+		n         = MakeNode("")
+		r         = pbReader{buf: buf}
+		adjacency []string
+		latest    StringLatestMap
+		children  []Node
+	)
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		if field == 9 && (wire == wireBytes || wire == wireVarint) {
+			adjacency = d.appendIDs(&r, wire, adjacency)
+			continue
+		}
+		if wire != wireBytes {
+			r.skip(wire)
+			continue
+		}
+		switch field {
+		case 1:
+			n.ID = d.string(&r)
+		case 2:
+			n.Topology = d.string(&r)
+		case 3:
+			key, values := d.stringSet(&r)
+			n.Sets = n.Sets.Add(key, values)
+		case 4:
+			if adjacency == nil {
+				adjacency = make([]string, 0, pbReader{buf: buf}.count(4))
+			}
+			adjacency = append(adjacency, d.string(&r))
+		case 5:
+			if latest == nil {
+				latest = make(StringLatestMap, 0, pbReader{buf: buf}.count(5))
+			}
+			latest = append(latest, d.latestEntry(&r))
+		case 6:
+			key, value := r.mapEntry()
+			if value != nil {
+				mr := pbReader{buf: value}
+				n.Metrics[d.strings.bytes(key)] = d.metric(&mr)
+				r.failWith(&mr)
+			}
+		case 7:
+			key, values := d.stringSet(&r)
+			n.Parents = n.Parents.Add(key, values)
+		case 8:
+			child, err := d.node(r.bytes())
+			if err != nil {
+				return n, err
+			}
+			children = append(children, child)
+		case 10:
+			to, e := d.edgeMetadata(&r)
+			n.Edges = n.Edges.Add(to, e)
+		default:
+			r.skip(wire)
+		}
+	}
+	if len(adjacency) > 0 {
+		n.Adjacency = MakeIDList(adjacency...)
+	}
+	if len(latest) > 0 {
+		n.Latest = latest.sortedByKey()
+	}
+	if len(children) > 0 {
+		n.Children = MakeNodeSet(children...)
+	}
+	return n, r.err
+}
+
+// sortedByKey sorts the entries of m, collected in no particular order, by
+// key, keeping the last of any with the same key, as setting them in turn
+// would.
+func (m StringLatestMap) sortedByKey() StringLatestMap {
+	sort.SliceStable(m, func(i, j int) bool { return m[i].key < m[j].key })
+	result := m[:0]
+	for i := range m {
+		if i+1 < len(m) && m[i+1].key == m[i].key {
+			continue
+		}
+		result = append(result, m[i])
+	}
+	return result
+}
+
+// The messages of nodes are read from the node's reader, which is failed
+// if they can't be.
+
+func (d *pbDecoder) stringSet(parent *pbReader) (string, StringSet) {
+	var (
+		key string
+		r   = pbReader{buf: parent.bytes()}
+	)
+	defer parent.failWith(&r)
+	d.values = d.values[:0]
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			key = d.string(&r)
+		case field == 2 && wire == wireBytes:
+			d.values = append(d.values, d.string(&r))
+		case field == 3 && (wire == wireBytes || wire == wireVarint):
+			d.values = d.appendIDs(&r, wire, d.values)
+		default:
+			r.skip(wire)
+		}
+	}
+	return key, MakeStringSet(d.values...)
+}
+
+func (d *pbDecoder) latestEntry(parent *pbReader) stringLatestEntry {
+	var (
+		e stringLatestEntry
+		r = pbReader{buf: parent.bytes()}
+	)
+	defer parent.failWith(&r)
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			e.key = d.string(&r)
+		case field == 2 && wire == wireVarint:
+			e.Timestamp = fromUnixNano(int64(r.varint()))
+		case field == 3 && wire == wireBytes:
+			e.Value = d.string(&r)
+		default:
+			r.skip(wire)
+		}
+	}
+	return e
+}
+
+func (d *pbDecoder) edgeMetadata(parent *pbReader) (string, EdgeMetadata) {
+	var (
+		to string
+		e  EdgeMetadata
+		r  = pbReader{buf: parent.bytes()}
+	)
+	defer parent.failWith(&r)
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			to = d.string(&r)
+		case field == 2 && wire == wireVarint:
+			e.EgressPacketCount = r.varint()
+		case field == 3 && wire == wireVarint:
+			e.IngressPacketCount = r.varint()
+		case field == 4 && wire == wireVarint:
+			e.EgressByteCount = r.varint()
+		case field == 5 && wire == wireVarint:
+			e.IngressByteCount = r.varint()
+		default:
+			r.skip(wire)
+		}
+	}
+	return to, e
+}
+
+// metric reads a metric from the value of a map entry, rather than from the
+// node's reader.
+func (d *pbDecoder) metric(r *pbReader) Metric {
+	m := Metric{Samples: make([]Sample, 0, r.count(1))}
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			m.Samples = append(m.Samples, d.sample(r))
+		case field == 2 && wire == wireFixed64:
+			m.Min = r.float64()
+		case field == 3 && wire == wireFixed64:
+			m.Max = r.float64()
+		default:
+			r.skip(wire)
+		}
+	}
+	return m
+}
+
+func (d *pbDecoder) sample(parent *pbReader) Sample {
+	var (
+		s Sample
+		r = pbReader{buf: parent.bytes()}
+	)
+	defer parent.failWith(&r)
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			s.Timestamp = fromUnixNano(int64(r.varint()))
+		case field == 2 && wire == wireFixed64:
+			s.Value = r.float64()
+		default:
+			r.skip(wire)
+		}
+	}
+	return s
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+// Reports of probes from before the cloud topologies were added have none
+// of them, and decode with them empty.
+func TestProtobufWithoutCloudTopologies(t *testing.T) {
+	old := MakeReport()
+	old.Host.AddNode(MakeNodeWith(MakeHostNodeID("node1"), map[string]string{"host_name": "node1"}).WithTopology(Host))
+	p := old.toProtobuf(nil)
+	for _, name := range []string{CloudProvider, CloudRegion, KubernetesCluster} {
+		delete(p.Topologies, name)
+	}
+	buf, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := MakeReport()
+	if err := have.readProtobuf(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(old, have) {
+		t.Errorf("%v != %v", old, have)
+	}
+
+	// And merge with the reports of newer probes
+	provider := MakeCloudProviderNodeID("aws")
+	newer := MakeReport()
+	newer.CloudProvider.AddNode(MakeNode(provider).WithTopology(CloudProvider))
+	newer.Host.AddNode(MakeNode(MakeHostNodeID("node1")).WithTopology(Host).WithParent(CloudProvider, provider))
+	have.UnsafeMerge(newer)
+	if _, ok := have.CloudProvider.Nodes[provider]; !ok {
+		t.Errorf("Expected the cloud provider to have been merged, got %v", have.CloudProvider.Nodes)
+	}
+	if parents, _ := have.Host.Nodes[MakeHostNodeID("node1")].Parents.Lookup(CloudProvider); !parents.Contains(provider) {
+		t.Errorf("Expected the host to have parent %q, got %v", provider, parents)
+	}
+}
+
+func TestReadProtobufInterns(t *testing.T) {
+	var (
+		host     = MakeHostNodeID("node1")
+		local    = MakeEndpointNodeID("node1", "", "10.0.0.1", "80")
+		remote   = MakeEndpointNodeID("node2", "", "10.0.0.2", "80")
+		rpt      = MakeReport()
+		endpoint = func(id, peer string) Node {
+			return MakeNodeWith(id, map[string]string{HostNodeID: host}).WithTopology(Endpoint).WithAdjacent(peer)
+		}
+	)
+	rpt.Host.AddNode(MakeNode(host).WithTopology(Host))
+	rpt.Endpoint.AddNode(endpoint(local, remote))
+	rpt.Endpoint.AddNode(endpoint(remote, local))
+	buf, err := proto.Marshal(rpt.toProtobuf(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := MakeReport()
+	if err := have.readProtobuf(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rpt, have) {
+		t.Fatalf("%v != %v", rpt, have)
+	}
+
+	if !sameString(have.Endpoint.Nodes[local].ID, have.Endpoint.Nodes[remote].Adjacency[0]) {
+		t.Error("Expected the ID of the endpoint to be shared with the adjacency of its peer")
+	}
+	value, _ := have.Endpoint.Nodes[local].Latest.Lookup(HostNodeID)
+	if !sameString(have.Host.Nodes[host].ID, value) {
+		t.Error("Expected the ID of the host to be shared with the latest values of the endpoints")
+	}
+	if !sameString(have.Endpoint.Nodes[local].Topology, have.Endpoint.Nodes[remote].Topology) {
+		t.Error("Expected the topologies of nodes to be shared")
+	}
+}
+
+// Reports cut short anywhere fail to decode, or decode what they hold,
+// rather than panicking.
+func TestReadProtobufTruncated(t *testing.T) {
+	rpt := makePartialTestReport(3)
+	rpt.Process.AddNode(MakeNode("p").WithMetric("cpu", MakeSingletonMetric(time.Unix(1, 0), 1)).WithChild(MakeNode("c")))
+	buf, err := proto.Marshal(rpt.toProtobuf(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for i := range buf {
+		have := MakeReport()
+		if err := have.readProtobuf(buf[:i]); err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Error("Expected truncated reports to fail to decode")
+	}
+}
+
+// Reports with ID tables decode the same whether or not their indices are
+// packed, and fail to with indices out of the table.
+func TestReadProtobufIDTable(t *testing.T) {
+	rpt := MakeReport()
+	rpt.Endpoint.AddNode(MakeNode("a").WithAdjacent("b").WithAdjacent("c").WithParent(Host, "h"))
+	rpt.Endpoint.AddNode(MakeNode("b").WithAdjacent("c").WithParent(Host, "h"))
+	p := rpt.toProtobuf(&idTable{index: map[string]uint64{}})
+	if len(p.IDs) != 3 {
+		t.Errorf("Expected each ID once, got %v", p.IDs)
+	}
+	packed, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The indices unpacked, as proto2 encoders would write them
+	for _, n := range p.Topologies[Endpoint].Nodes {
+		for _, i := range n.AdjacencyIDs {
+			n.XXX_unrecognized = append(n.XXX_unrecognized, proto.EncodeVarint(9<<3|wireVarint)...)
+			n.XXX_unrecognized = append(n.XXX_unrecognized, proto.EncodeVarint(i)...)
+		}
+		n.AdjacencyIDs = nil
+	}
+	unpacked, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, buf := range [][]byte{packed, unpacked} {
+		have := MakeReport()
+		if err := have.readProtobuf(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rpt.Endpoint.Nodes, have.Endpoint.Nodes) {
+			t.Errorf("Expected %v, got %v", rpt.Endpoint.Nodes, have.Endpoint.Nodes)
+		}
+	}
+
+	p.IDs = p.IDs[:1]
+	buf, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := MakeReport()
+	if err := have.readProtobuf(buf); err != errIDIndex {
+		t.Errorf("Expected %v, got %v", errIDIndex, err)
+	}
+}
@@ -0,0 +1,269 @@
+package report_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/common/xfer"
+	"github.com/weaveworks/scope/report"
+	s_reflect "github.com/weaveworks/scope/test/reflect"
+)
+
+func protobufRoundtrip(t testing.TB, r report.Report) report.Report {
+	return protobufRoundtripWith(t, r, report.Report.WriteProtobuf)
+}
+
+func protobufRoundtripWith(t testing.TB, r report.Report, encode func(report.Report) (*bytes.Buffer, error)) report.Report {
+	buf, err := encode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := report.MakeFromBinary(context.Background(), buf, true, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *r2
+}
+
+func TestProtobufRoundtrip(t *testing.T) {
+	for _, r := range []report.Report{report.MakeReport(), makeTestReport()} {
+		if have := protobufRoundtrip(t, r); !s_reflect.DeepEqual(r, have) {
+			t.Errorf("%v != %v", r, have)
+		}
+	}
+}
+
+// randomReport makes a report with a bit of everything, at random.
+func randomReport(random *rand.Rand) report.Report {
+	str := func() string {
+		b := make([]byte, random.Intn(8))
+		random.Read(b)
+		return string(b)
+	}
+	ts := func() time.Time {
+		return time.Unix(0, random.Int63()).UTC()
+	}
+	strs := func() []string {
+		result := make([]string, random.Intn(3))
+		for i := range result {
+			result[i] = str()
+		}
+		return result
+	}
+	ids := 0
+	var node func(depth int) report.Node
+	node = func(depth int) report.Node {
+		ids++
+		n := report.MakeNode(fmt.Sprintf("node%d", ids)).WithTopology(str())
+		for i := random.Intn(3); i > 0; i-- {
+			n = n.WithSet(str(), report.MakeStringSet(strs()...))
+			n = n.WithParent(str(), str())
+			n = n.WithLatest(str(), ts(), str())
+			n = n.WithAdjacent(str())
+			n = n.WithEdge(str(), report.EdgeMetadata{EgressByteCount: random.Uint64(), IngressPacketCount: random.Uint64()})
+			n = n.WithMetric(str(), report.MakeMetric([]report.Sample{{Timestamp: ts(), Value: random.Float64()}}))
+			if depth < 2 {
+				n = n.WithChild(node(depth + 1))
+			}
+		}
+		return n
+	}
+
+	r := report.MakeReport()
+	r.TS = ts()
+	r.Window = time.Duration(random.Int63())
+	r.Shortcut = random.Intn(2) == 0
+	r.Sampling = report.Sampling{Count: random.Uint64(), Total: random.Uint64()}
+	r.Truncation = report.Truncation{EndpointAdjacencies: random.Int(), CommandLines: random.Int(), Processes: random.Int()}
+	r.DNS[str()] = report.DNSRecord{Forward: report.MakeStringSet(strs()...), Reverse: report.MakeStringSet(strs()...)}
+	r.Plugins = xfer.MakePluginSpecs(xfer.PluginSpec{ID: str(), Label: str(), Interfaces: []string{"reporter"}, APIVersion: "1"})
+	r.WalkTopologies(func(t *report.Topology) {
+		for i := random.Intn(4); i > 0; i-- {
+			t.AddNode(node(0))
+		}
+		if random.Intn(2) == 0 {
+			id := str()
+			t.Controls.AddControl(report.Control{ID: id, Human: str(), Icon: str(), Rank: random.Intn(10), Destructive: random.Intn(2) == 0})
+			*t = t.WithMetadataTemplates(report.MetadataTemplates{id: {ID: id, Label: str(), Truncate: random.Intn(10), Priority: random.Float64(), From: report.FromLatest}}).
+				WithMetricTemplates(report.MetricTemplates{id: {ID: id, Label: str(), Format: report.PercentFormat, Priority: random.Float64()}}).
+				WithTableTemplates(report.TableTemplates{id: {ID: id, Label: str(), Prefix: str(), Type: report.PropertyListType, FixedRows: map[string]string{str(): str()}}})
+		}
+	})
+	return r
+}
+
+func TestProtobufRoundtripRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		r := randomReport(random)
+		if have := protobufRoundtrip(t, r); !s_reflect.DeepEqual(r, have) {
+			t.Fatalf("%d: %v != %v", i, r, have)
+		}
+		if have := protobufRoundtripWith(t, r, report.Report.WriteProtobufIDTable); !s_reflect.DeepEqual(r, have) {
+			t.Fatalf("%d: with ID table: %v != %v", i, r, have)
+		}
+	}
+}
+
+func TestProtobufUnknownFields(t *testing.T) {
+	r := makeTestReport()
+	buf, err := r.WriteProtobuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Field 100 of the report, a varint, and field 101, bytes, as added by
+	// newer probes; and a topology unknown to this version
+	raw = append(raw, 0xa0, 0x06, 42, 0xaa, 0x06, 3, 'a', 'b', 'c')
+	future := []byte{10, 6, 'f', 'u', 't', 'u', 'r', 'e', 18, 0}
+	raw = append(append(raw, 2<<3|2, byte(len(future))), future...)
+
+	have, err := report.MakeFromBinary(context.Background(), bytes.NewReader(raw), false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s_reflect.DeepEqual(r, *have) {
+		t.Errorf("%v != %v", r, *have)
+	}
+}
+
+// benchmarkReports are reports of about the size of those of busy hosts.
+func benchmarkReports(n int) []report.Report {
+	reports := make([]report.Report, n)
+	for i := range reports {
+		reports[i] = report.MakeReport()
+		for j := 0; j < 2000; j++ {
+			id := fmt.Sprintf("host%d;%d", i, j)
+			reports[i].Process.AddNode(report.MakeNodeWith(id, map[string]string{report.PID: fmt.Sprint(j), report.Name: "nginx", report.Cmdline: "nginx: worker process"}).
+				WithMetric("process_cpu_usage_percent", report.MakeSingletonMetric(time.Unix(int64(j), 0).UTC(), 0.5)))
+		}
+	}
+	return reports
+}
+
+func benchmarkEncode(b *testing.B, encode func(report.Report) (*bytes.Buffer, error)) {
+	r := benchmarkReports(1)[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encode(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecodeMerge(b *testing.B, encode func(report.Report) (*bytes.Buffer, error), format int, merge bool) {
+	encoded := [][]byte{}
+	for _, r := range benchmarkReports(5) {
+		buf, err := encode(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		encoded = append(encoded, buf.Bytes())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		merged := report.MakeReport()
+		for _, buf := range encoded {
+			r, err := report.MakeFromBinary(context.Background(), bytes.NewReader(buf), true, format)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if merge {
+				merged.UnsafeMerge(*r)
+			}
+		}
+	}
+}
+
+// endpointReport is a report of a host with many connections, whose
+// endpoints are adjacent to few remote ones, and parented to few processes.
+func endpointReport() report.Report {
+	r := report.MakeReport()
+	for i := 0; i < 5000; i++ {
+		local := report.MakeEndpointNodeID("host", "", "10.0.0.1", strconv.Itoa(30000+i))
+		remote := report.MakeEndpointNodeID("", "", fmt.Sprintf("10.0.1.%d", i%50), "443")
+		process := report.MakeProcessNodeID("host", strconv.Itoa(i%20))
+		r.Endpoint.AddNode(report.MakeNode(local).WithTopology(report.Endpoint).WithAdjacent(remote).
+			WithParent(report.Process, process).WithParent(report.Host, report.MakeHostNodeID("host")))
+		r.Endpoint.AddNode(report.MakeNode(remote).WithTopology(report.Endpoint))
+	}
+	return r
+}
+
+func benchmarkEncodedSize(b *testing.B, encode func(report.Report) (*bytes.Buffer, error)) {
+	r := endpointReport()
+	b.ResetTimer()
+	var size int
+	for i := 0; i < b.N; i++ {
+		buf, err := encode(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = buf.Len()
+	}
+	b.ReportMetric(float64(size), "bytes/report")
+}
+
+func BenchmarkProtobufEncodeEndpoints(b *testing.B) {
+	benchmarkEncodedSize(b, report.Report.WriteProtobuf)
+}
+
+func BenchmarkProtobufEncodeEndpointsIDTable(b *testing.B) {
+	benchmarkEncodedSize(b, report.Report.WriteProtobufIDTable)
+}
+
+func BenchmarkMsgpackEncode(b *testing.B)  { benchmarkEncode(b, report.Report.WriteBinary) }
+func BenchmarkProtobufEncode(b *testing.B) { benchmarkEncode(b, report.Report.WriteProtobuf) }
+
+func BenchmarkMsgpackDecode(b *testing.B) {
+	benchmarkDecodeMerge(b, report.Report.WriteBinary, 1, false)
+}
+
+func BenchmarkProtobufDecode(b *testing.B) {
+	benchmarkDecodeMerge(b, report.Report.WriteProtobuf, 3, false)
+}
+
+func BenchmarkMsgpackDecodeMerge(b *testing.B) {
+	benchmarkDecodeMerge(b, report.Report.WriteBinary, 1, true)
+}
+
+func BenchmarkProtobufDecodeMerge(b *testing.B) {
+	benchmarkDecodeMerge(b, report.Report.WriteProtobuf, 3, true)
+}
+
+// BenchmarkProtobufDecodeMerged decodes a report of the size of those the
+// app stores, merged from those of many hosts: node IDs are repeated in the
+// adjacencies of endpoints and the parents of containers and processes,
+// and latest keys and values across nodes.
+func BenchmarkProtobufDecodeMerged(b *testing.B) {
+	r := report.MakeReport()
+	r.UnsafeMergeAll(mergeBenchmarkReports(20))
+	buf, err := r.WriteProtobuf()
+	if err != nil {
+		b.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := report.MakeFromBinary(context.Background(), bytes.NewReader(encoded), true, 3); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
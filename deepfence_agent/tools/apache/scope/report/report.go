@@ -3,7 +3,10 @@ package report
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/weaveworks/scope/common/xfer"
@@ -37,6 +40,8 @@ const (
 	VolumeSnapshot        = "volume_snapshot"
 	VolumeSnapshotData    = "volume_snapshot_data"
 	Job                   = "job"
+	Ingress               = "ingress"
+	CustomResource        = "custom_resource"
 
 	// Shapes used for different nodes
 	Circle         = "circle"
@@ -275,6 +280,8 @@ var topologyNames = []string{
 	VolumeSnapshot,
 	VolumeSnapshotData,
 	Job,
+	Ingress,
+	CustomResource,
 }
 
 // Report is the core data type. It's produced by probes, and consumed and
@@ -402,6 +409,15 @@ type Report struct {
 	// Job represent all Kubernetes Job on hosts running probes.
 	Job Topology
 
+	// Ingress represent all Kubernetes Ingresses. Metadata includes the hosts,
+	// paths and backing services. Edges are not present.
+	Ingress Topology
+
+	// CustomResource represent the objects of the custom resources the probes
+	// are configured to watch. Metadata includes their kind and selected
+	// status fields. Edges are not present.
+	CustomResource Topology
+
 	DNS DNSRecords `json:"DNS,omitempty" deepequal:"nil==empty"`
 	// Backwards-compatibility for an accident in commit 951629a / release 1.11.6.
 	BugDNS DNSRecords `json:"nodes,omitempty"`
@@ -409,6 +425,10 @@ type Report struct {
 	// Sampling data for this report.
 	Sampling Sampling
 
+	// Truncation is what the probe trimmed from this report, to keep it
+	// under the maximum size of reports.
+	Truncation Truncation
+
 	// Window is the amount of time that this report purports to represent.
 	// Windows must be carefully merged. They should only be added when
 	// reports cover non-overlapping periods of time. By default, we assume
@@ -535,25 +555,35 @@ func MakeReport() Report {
 			WithShape(DottedTriangle).
 			WithLabel("job", "jobs"),
 
+		Ingress: MakeTopology().
+			WithShape(Cloud).
+			WithLabel("ingress", "ingresses"),
+
+		CustomResource: MakeTopology().
+			WithShape(Hexagon).
+			WithLabel("custom resource", "custom resources"),
+
 		DNS: DNSRecords{},
 
-		Sampling: Sampling{},
-		Window:   0,
-		Plugins:  xfer.MakePluginSpecs(),
-		ID:       fmt.Sprintf("%d", rand.Int63()),
+		Sampling:   Sampling{},
+		Truncation: Truncation{},
+		Window:     0,
+		Plugins:    xfer.MakePluginSpecs(),
+		ID:         fmt.Sprintf("%d", rand.Int63()),
 	}
 }
 
 // Copy returns a value copy of the report.
 func (r Report) Copy() Report {
 	newReport := Report{
-		TS:       r.TS,
-		DNS:      r.DNS.Copy(),
-		Sampling: r.Sampling,
-		Window:   r.Window,
-		Shortcut: r.Shortcut,
-		Plugins:  r.Plugins.Copy(),
-		ID:       fmt.Sprintf("%d", rand.Int63()),
+		TS:         r.TS,
+		DNS:        r.DNS.Copy(),
+		Sampling:   r.Sampling,
+		Truncation: r.Truncation,
+		Window:     r.Window,
+		Shortcut:   r.Shortcut,
+		Plugins:    r.Plugins.Copy(),
+		ID:         fmt.Sprintf("%d", rand.Int63()),
 	}
 	newReport.WalkPairedTopologies(&r, func(newTopology, oldTopology *Topology) {
 		*newTopology = oldTopology.Copy()
@@ -563,17 +593,97 @@ func (r Report) Copy() Report {
 
 // UnsafeMerge merges another Report into the receiver. The original is modified.
 func (r *Report) UnsafeMerge(other Report) {
+	r.unsafeMergeFields(other)
+	r.WalkPairedTopologies(&other, func(ourTopology, theirTopology *Topology) {
+		ourTopology.UnsafeMerge(*theirTopology)
+	})
+}
+
+// UnsafeMergeAll merges others into the receiver, giving the same result as
+// calling UnsafeMerge with each of them in turn.  The topologies are
+// independent of each other, so are merged concurrently, on up to
+// GOMAXPROCS goroutines.  The original is modified.
+func (r *Report) UnsafeMergeAll(others []Report) {
+	for _, other := range others {
+		r.unsafeMergeFields(other)
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(topologyNames) {
+		workers = len(topologyNames)
+	}
+	if workers <= 1 || len(others) <= 1 {
+		for _, name := range topologyNames {
+			r.unsafeMergeTopology(name, others)
+		}
+		return
+	}
+
+	// Biggest first, so that they aren't left to the end on one goroutine
+	sizes := map[string]int{}
+	for _, name := range topologyNames {
+		for i := range others {
+			sizes[name] += len(others[i].topology(name).Nodes)
+		}
+	}
+	names := make([]string, len(topologyNames))
+	copy(names, topologyNames)
+	sort.SliceStable(names, func(i, j int) bool { return sizes[names[i]] > sizes[names[j]] })
+
+	queue := make(chan string, len(names))
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				r.unsafeMergeTopology(name, others)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// UnsafeMergeAllWindowed merges others into the receiver as UnsafeMergeAll
+// does, then drops the nodes whose newest data is from before cutoff, such
+// as those of containers which exited long before the end of the window the
+// reports were received in.  Nodes with no timestamped data at all, such
+// as those of remote endpoints, which are only adjacent to others, are
+// kept: there is nothing to tell their age by.  Adjacencies to nodes
+// dropped are left for renderers, which ignore them as they do those to
+// nodes not reported.  The original is modified.
+func (r *Report) UnsafeMergeAllWindowed(others []Report, cutoff time.Time) {
+	r.UnsafeMergeAll(others)
+	r.WalkTopologies(func(t *Topology) {
+		t.Nodes = t.Nodes.dropBefore(cutoff)
+	})
+}
+
+// unsafeMergeFields merges the fields of other, apart from its topologies,
+// into the receiver.
+func (r *Report) unsafeMergeFields(other Report) {
 	// Merged report has the earliest non-zero timestamp
 	if !other.TS.IsZero() && (r.TS.IsZero() || other.TS.Before(r.TS)) {
 		r.TS = other.TS
 	}
 	r.DNS = r.DNS.Merge(other.DNS)
 	r.Sampling = r.Sampling.Merge(other.Sampling)
+	r.Truncation = r.Truncation.Merge(other.Truncation)
 	r.Window = r.Window + other.Window
 	r.Plugins = r.Plugins.Merge(other.Plugins)
-	r.WalkPairedTopologies(&other, func(ourTopology, theirTopology *Topology) {
-		ourTopology.UnsafeMerge(*theirTopology)
-	})
+}
+
+// unsafeMergeTopology merges the named topology of each of others, in
+// order, into that of the receiver.  It touches no other topology.
+func (r *Report) unsafeMergeTopology(name string, others []Report) {
+	ours := r.topology(name)
+	for i := range others {
+		ours.UnsafeMerge(*others[i].topology(name))
+	}
 }
 
 // UnsafeUnMerge removes any information from r that would be added by merging other.
@@ -586,6 +696,64 @@ func (r *Report) UnsafeUnMerge(other Report) {
 	})
 }
 
+// PruneLatest drops the latest values set before olderThan from all the
+// nodes of the report.  The original is modified.
+func (r *Report) PruneLatest(olderThan time.Time) {
+	r.WalkTopologies(func(t *Topology) {
+		for id, n := range t.Nodes {
+			if latest := n.Latest.Prune(olderThan); len(latest) != len(n.Latest) {
+				n.Latest = latest
+				t.Nodes[id] = n
+			}
+		}
+	})
+}
+
+// DownsampleMetrics downsamples the samples of the metrics of all the nodes
+// of the report from before olderThan to at most points per metric.  The
+// original is modified.
+func (r *Report) DownsampleMetrics(olderThan time.Time, points int) {
+	r.WalkTopologies(func(t *Topology) {
+		for id, n := range t.Nodes {
+			if len(n.Metrics) > 0 {
+				n.Metrics = n.Metrics.DownsampleBefore(olderThan, points)
+				t.Nodes[id] = n
+			}
+		}
+	})
+}
+
+// ShiftTimestamps moves the time of the report, and of the latest values and
+// metric samples of all its nodes, by d, as when replaying a report
+// recorded earlier.  The original is modified.
+func (r *Report) ShiftTimestamps(d time.Duration) {
+	r.TS = r.TS.Add(d)
+	r.WalkTopologies(func(t *Topology) {
+		for id, n := range t.Nodes {
+			// Keys are unchanged, so the entries stay sorted
+			latest := make(StringLatestMap, 0, len(n.Latest))
+			for _, e := range n.Latest {
+				e.Timestamp = e.Timestamp.Add(d)
+				latest = append(latest, e)
+			}
+			n.Latest = latest
+			if len(n.Metrics) > 0 {
+				metrics := make(Metrics, len(n.Metrics))
+				for k, m := range n.Metrics {
+					samples := make([]Sample, len(m.Samples))
+					for i, s := range m.Samples {
+						samples[i] = Sample{Timestamp: s.Timestamp.Add(d), Value: s.Value}
+					}
+					m.Samples = samples
+					metrics[k] = m
+				}
+				n.Metrics = metrics
+			}
+			t.Nodes[id] = n
+		}
+	})
+}
+
 // WalkTopologies iterates through the Topologies of the report,
 // potentially modifying them
 func (r *Report) WalkTopologies(f func(*Topology)) {
@@ -666,6 +834,10 @@ func (r *Report) topology(name string) *Topology {
 		return &r.VolumeSnapshotData
 	case Job:
 		return &r.Job
+	case Ingress:
+		return &r.Ingress
+	case CustomResource:
+		return &r.CustomResource
 	}
 	return nil
 }
@@ -757,6 +929,29 @@ func (s Sampling) Merge(other Sampling) Sampling {
 	}
 }
 
+// Truncation counts what the probe trimmed from a report, in the order it
+// trims things, to keep it under the maximum size of reports.
+type Truncation struct {
+	EndpointAdjacencies int // connections dropped
+	CommandLines        int // of processes, stripped of their arguments
+	Processes           int // dropped past the maximum number of processes
+}
+
+// Truncated tells whether anything was trimmed.
+func (t Truncation) Truncated() bool {
+	return t != Truncation{}
+}
+
+// Merge combines two truncations by adding them up, and returns the result.
+// The original is not modified.
+func (t Truncation) Merge(other Truncation) Truncation {
+	return Truncation{
+		EndpointAdjacencies: t.EndpointAdjacencies + other.EndpointAdjacencies,
+		CommandLines:        t.CommandLines + other.CommandLines,
+		Processes:           t.Processes + other.Processes,
+	}
+}
+
 const (
 	// HostNodeID is a metadata foreign key, linking a node in any topology to
 	// a node in the host topology. That host node is the origin host, where
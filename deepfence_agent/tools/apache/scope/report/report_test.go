@@ -1,7 +1,11 @@
 package report_test
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
@@ -134,3 +138,296 @@ func TestReportUnMerge(t *testing.T) {
 		t.Error(test.Diff(expected, r2))
 	}
 }
+
+func TestReportPruneLatest(t *testing.T) {
+	now := time.Now()
+	r := report.MakeReport()
+	r.Process.AddNode(report.MakeNode("a").
+		WithLatest("old", now.Add(-time.Hour), "1").
+		WithLatest("new", now, "2"))
+	r.Host.AddNode(report.MakeNode("b").WithLatest("new", now, "3"))
+	r.PruneLatest(now.Add(-time.Minute))
+
+	if _, ok := r.Process.Nodes["a"].Latest.Lookup("old"); ok {
+		t.Error("Expected the old value to have been pruned")
+	}
+	if v, ok := r.Process.Nodes["a"].Latest.Lookup("new"); !ok || v != "2" {
+		t.Errorf("Expected the new value to have been kept, got %q", v)
+	}
+	if v, ok := r.Host.Nodes["b"].Latest.Lookup("new"); !ok || v != "3" {
+		t.Errorf("Expected the new value to have been kept, got %q", v)
+	}
+}
+
+func TestReportShiftTimestamps(t *testing.T) {
+	then := time.Now().Add(-time.Hour)
+	r := report.MakeReport()
+	r.TS = then
+	r.Host.AddNode(report.MakeNode("a").
+		WithLatest("x", then, "1").
+		WithLatest("y", then.Add(time.Second), "2").
+		WithMetric("load", report.MakeSingletonMetric(then, 0.5)))
+	original := r.Copy()
+	r.ShiftTimestamps(time.Hour)
+
+	now := then.Add(time.Hour)
+	if !r.TS.Equal(now) {
+		t.Errorf("Expected the report moved to %v, got %v", now, r.TS)
+	}
+	n := r.Host.Nodes["a"]
+	if v, ts, ok := n.Latest.LookupEntry("y"); !ok || v != "2" || !ts.Equal(now.Add(time.Second)) {
+		t.Errorf("Expected the latest value moved, got %q at %v", v, ts)
+	}
+	if ts := n.Metrics["load"].Samples[0].Timestamp; !ts.Equal(now) {
+		t.Errorf("Expected the metric moved, got %v", ts)
+	}
+	if ts, _ := original.Host.Nodes["a"].Latest.Timestamp("x"); !ts.Equal(then) {
+		t.Errorf("Expected copies of the report untouched, got %v", ts)
+	}
+}
+
+// cloudHierarchyReports are the reports of a host probe and a kubernetes
+// probe in a cluster on AWS.
+func cloudHierarchyReports() (report.Report, report.Report) {
+	var (
+		provider = report.MakeCloudProviderNodeID("aws")
+		region   = report.MakeProviderRegionNodeID("aws", "us-east-1")
+		cluster  = report.MakeKubernetesClusterNodeID("prod")
+		host     = report.MakeHostNodeID("node1")
+	)
+	hostProbe := report.MakeReport()
+	hostProbe.CloudProvider.AddNode(report.MakeNode(provider).WithTopology(report.CloudProvider))
+	hostProbe.CloudRegion.AddNode(report.MakeNode(region).WithTopology(report.CloudRegion).
+		WithParent(report.CloudProvider, provider))
+	hostProbe.Host.AddNode(report.MakeNode(host).WithTopology(report.Host).
+		WithParent(report.CloudProvider, provider).
+		WithParent(report.CloudRegion, region).
+		WithParent(report.KubernetesCluster, cluster))
+
+	k8sProbe := report.MakeReport()
+	k8sProbe.KubernetesCluster.AddNode(report.MakeNode(cluster).WithTopology(report.KubernetesCluster).
+		WithParent(report.CloudProvider, provider).
+		WithParent(report.CloudRegion, region))
+	k8sProbe.Host.AddNode(report.MakeNode(host).
+		WithParent(report.CloudRegion, region).
+		WithParent(report.KubernetesCluster, cluster))
+	return hostProbe, k8sProbe
+}
+
+func TestReportCloudHierarchy(t *testing.T) {
+	hostProbe, k8sProbe := cloudHierarchyReports()
+	merged := report.MakeReport()
+	merged.UnsafeMerge(hostProbe)
+	merged.UnsafeMerge(k8sProbe)
+	other := k8sProbe.Copy()
+	other.UnsafeMerge(hostProbe)
+	if !s_reflect.DeepEqual(merged, other) {
+		t.Errorf("Expected merging either way round to agree: %s", test.Diff(merged, other))
+	}
+
+	for _, tc := range []struct {
+		topology, id string
+		parents      map[string][]string
+	}{
+		{report.CloudRegion, report.MakeProviderRegionNodeID("aws", "us-east-1"), map[string][]string{
+			report.CloudProvider: {report.MakeCloudProviderNodeID("aws")},
+		}},
+		{report.KubernetesCluster, report.MakeKubernetesClusterNodeID("prod"), map[string][]string{
+			report.CloudProvider: {report.MakeCloudProviderNodeID("aws")},
+			report.CloudRegion:   {report.MakeProviderRegionNodeID("aws", "us-east-1")},
+		}},
+		{report.Host, report.MakeHostNodeID("node1"), map[string][]string{
+			report.CloudProvider:     {report.MakeCloudProviderNodeID("aws")},
+			report.CloudRegion:       {report.MakeProviderRegionNodeID("aws", "us-east-1")},
+			report.KubernetesCluster: {report.MakeKubernetesClusterNodeID("prod")},
+		}},
+	} {
+		topology, _ := merged.Topology(tc.topology)
+		node, ok := topology.Nodes[tc.id]
+		if !ok {
+			t.Errorf("Expected %s node %q", tc.topology, tc.id)
+			continue
+		}
+		for parentTopology, want := range tc.parents {
+			if have, _ := node.Parents.Lookup(parentTopology); !reflect.DeepEqual(report.MakeStringSet(want...), have) {
+				t.Errorf("Expected %q to have %s parents %v, got %v", tc.id, parentTopology, want, have)
+			}
+		}
+	}
+
+	if have := protobufRoundtrip(t, merged); !s_reflect.DeepEqual(merged, have) {
+		t.Errorf("%v != %v", merged, have)
+	}
+}
+
+func TestReportUnsafeMergeAll(t *testing.T) {
+	// Make sure the topologies really are merged concurrently
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		// Successive random reports share node IDs, so have nodes merged
+		reports := make([]report.Report, random.Intn(8))
+		for j := range reports {
+			reports[j] = randomReport(random)
+			reports[j].WalkNamedTopologies(func(name string, t *report.Topology) {
+				for id, n := range t.Nodes {
+					n.Topology = name
+					t.Nodes[id] = n
+				}
+			})
+		}
+		serial, parallel := report.MakeReport(), report.MakeReport()
+		for _, r := range reports {
+			serial.UnsafeMerge(r)
+		}
+		parallel.UnsafeMergeAll(reports)
+		parallel.ID = serial.ID
+		if !s_reflect.DeepEqual(serial, parallel) {
+			t.Fatalf("%d: expected %v, got %v", i, serial, parallel)
+		}
+	}
+}
+
+func TestReportUnsafeMergeAllWindowed(t *testing.T) {
+	var (
+		cutoff = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		old    = cutoff.Add(-time.Minute)
+		recent = cutoff.Add(time.Second)
+	)
+	r1, r2 := report.MakeReport(), report.MakeReport()
+	// Stale in one report, but not the other
+	r1.Container.AddNode(report.MakeNode("restarted").WithLatest("state", old, "exited"))
+	r2.Container.AddNode(report.MakeNode("restarted").WithLatest("state", recent, "running"))
+	// Stale in both
+	r1.Container.AddNode(report.MakeNode("exited").WithLatest("state", old, "exited").
+		WithMetric("cpu", report.MakeSingletonMetric(old, 1)))
+	r2.Container.AddNode(report.MakeNode("exited").WithLatest("state", old, "exited"))
+	// Dated by a metric, and by a child
+	r1.Host.AddNode(report.MakeNode("host").WithLatest("name", old, "host").
+		WithMetric("cpu", report.MakeSingletonMetric(recent, 1)))
+	r1.Pod.AddNode(report.MakeNode("pod").WithChild(report.MakeNode("child").WithLatest("name", recent, "child")))
+	// With nothing to tell its age by
+	r2.Endpoint.AddNode(report.MakeNode("remote").WithAdjacent("local"))
+	originals := []report.Report{r1.Copy(), r2.Copy()}
+
+	have := report.MakeReport()
+	have.UnsafeMergeAllWindowed([]report.Report{r1, r2}, cutoff)
+	for _, tc := range []struct {
+		topology report.Topology
+		id       string
+		kept     bool
+	}{
+		{have.Container, "restarted", true},
+		{have.Container, "exited", false},
+		{have.Host, "host", true},
+		{have.Pod, "pod", true},
+		{have.Endpoint, "remote", true},
+	} {
+		if _, ok := tc.topology.Nodes[tc.id]; ok != tc.kept {
+			t.Errorf("%s: expected kept %v, got %v", tc.id, tc.kept, ok)
+		}
+	}
+
+	// The reports merged aren't modified, even where their nodes are
+	// shared with the result
+	for i, r := range []report.Report{r1, r2} {
+		if !s_reflect.DeepEqual(originals[i].Container, r.Container) {
+			t.Errorf("%d: %s", i, test.Diff(originals[i].Container, r.Container))
+		}
+	}
+
+	// Which UnsafeMergeAll leaves as they were
+	all := report.MakeReport()
+	all.UnsafeMergeAll([]report.Report{r1, r2})
+	if _, ok := all.Container.Nodes["exited"]; !ok {
+		t.Error("Expected UnsafeMergeAll to keep stale nodes")
+	}
+}
+
+// mergeBenchmarkReports makes reports from n hosts of a cluster, roughly
+// the shape of those of real probes: mostly endpoints, connected to those of
+// other hosts, and processes, with the images of their containers shared.
+func mergeBenchmarkReports(n int) []report.Report {
+	var (
+		random = rand.New(rand.NewSource(1))
+		ts     = time.Unix(1e9, 0).UTC()
+		metric = func() report.Metric {
+			samples := make([]report.Sample, 15)
+			for i := range samples {
+				samples[i] = report.Sample{Timestamp: ts.Add(time.Duration(i) * time.Second), Value: random.Float64() * 100}
+			}
+			return report.MakeMetric(samples)
+		}
+		hostIP = func(host int) string { return fmt.Sprintf("10.0.%d.%d", host/256, host%256) }
+	)
+	reports := make([]report.Report, n)
+	for h := range reports {
+		r := report.MakeReport()
+		r.TS = ts
+		r.Window = 15 * time.Second
+		hostID := report.MakeHostNodeID(strconv.Itoa(h))
+		r.Host.AddNode(report.MakeNodeWith(hostID, map[string]string{
+			report.HostName: strconv.Itoa(h),
+		}).WithTopology(report.Host).WithMetric("host_cpu_usage_percent", metric()).WithMetric("host_mem_usage_bytes", metric()))
+
+		for c := 0; c < 30; c++ {
+			imageID := report.MakeContainerImageNodeID(strconv.Itoa(random.Intn(40)))
+			containerID := report.MakeContainerNodeID(fmt.Sprintf("%d-%d", h, c))
+			r.ContainerImage.AddNode(report.MakeNodeWith(imageID, map[string]string{
+				report.DockerImageName: imageID,
+			}).WithTopology(report.ContainerImage).WithParent(report.Host, hostID))
+			r.Container.AddNode(report.MakeNodeWith(containerID, map[string]string{
+				report.DockerContainerID:    containerID,
+				report.DockerContainerName:  containerID,
+				report.DockerContainerState: "running",
+				report.DockerImageID:        imageID,
+			}).WithTopology(report.Container).WithMetric("docker_cpu_total_usage", metric()).WithMetric("docker_memory_usage", metric()).
+				WithParent(report.Host, hostID).WithParent(report.ContainerImage, imageID))
+		}
+		for p := 0; p < 150; p++ {
+			r.Process.AddNode(report.MakeNodeWith(report.MakeProcessNodeID(hostID, strconv.Itoa(p)), map[string]string{
+				report.PID:     strconv.Itoa(p),
+				report.Name:    "nginx",
+				report.Cmdline: "nginx -g daemon off;",
+			}).WithTopology(report.Process).WithMetric("process_cpu_usage_percent", metric()).WithParent(report.Host, hostID))
+		}
+		for e := 0; e < 400; e++ {
+			peer := random.Intn(n)
+			id := report.MakeEndpointNodeID(hostID, "", hostIP(h), strconv.Itoa(30000+e))
+			peerID := report.MakeEndpointNodeID(report.MakeHostNodeID(strconv.Itoa(peer)), "", hostIP(peer), strconv.Itoa(8000+random.Intn(10)))
+			r.Endpoint.AddNode(report.MakeNodeWith(id, map[string]string{
+				report.PID: strconv.Itoa(e % 150),
+			}).WithTopology(report.Endpoint).WithAdjacent(peerID))
+			// The connection seen from the other end, too
+			r.Endpoint.AddNode(report.MakeNode(peerID).WithTopology(report.Endpoint).WithAdjacent(id))
+		}
+		reports[h] = r
+	}
+	return reports
+}
+
+// Merging the topologies of these in parallel can't take less time than
+// merging their endpoints, which is about 85% of the work of merging them
+// one at a time, so UnsafeMergeAll saves at most a sixth of the time of
+// UnsafeMerge, given at least two cores.  On one core, they're the same.
+// Compare them with -cpu 1,2,4.
+func BenchmarkReportUnsafeMerge(b *testing.B) {
+	reports := mergeBenchmarkReports(200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := report.MakeReport()
+		for _, other := range reports {
+			r.UnsafeMerge(other)
+		}
+	}
+}
+
+func BenchmarkReportUnsafeMergeAll(b *testing.B) {
+	reports := mergeBenchmarkReports(200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := report.MakeReport()
+		r.UnsafeMergeAll(reports)
+	}
+}
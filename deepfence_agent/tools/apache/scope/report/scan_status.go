@@ -0,0 +1,173 @@
+package report
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of scan whose status nodes carry.
+const (
+	VulnerabilityScan = "vulnerability"
+	SecretScan        = "secret"
+	ComplianceScan    = "compliance"
+)
+
+// Statuses of scans, apart from the free-form summaries of some.
+const (
+	ScanStatusNeverScanned = "never_scanned"
+	ScanStatusQueued       = "queued"
+	ScanStatusInProgress   = "in_progress"
+	ScanStatusComplete     = "complete"
+	ScanStatusError        = "error"
+
+	// ScanStatusStale is of complete scans too old to be trusted
+	ScanStatusStale = "stale"
+)
+
+// ScanStatusPrefix prefixes the latest keys of the scan statuses of nodes,
+// which are followed by the type of scan.
+const ScanStatusPrefix = "scan_status_"
+
+// ScanStatus is the status of the latest scan of a node of one type.
+type ScanStatus struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+
+	// Severity is the overall severity of the findings, as rated by the
+	// scanner.
+	Severity string `json:"severity,omitempty"`
+
+	// Counts are the numbers of findings, by severity.
+	Counts map[string]int `json:"counts,omitempty"`
+
+	// TopRules are the names of the rules most findings were of, for
+	// scanners with rules, such as secret scans.
+	TopRules []string `json:"top_rules,omitempty"`
+
+	// Version is the version of the benchmark checked against, for
+	// compliance scans.
+	Version string `json:"version,omitempty"`
+
+	// Categories are the numbers of findings by category, then by result,
+	// for scanners grouping their checks, such as compliance scans.
+	Categories map[string]map[string]int `json:"categories,omitempty"`
+
+	LastScan time.Time `json:"last_scan"`
+}
+
+// ComplianceScanType returns the type of the scan statuses of compliance
+// checks against a benchmark, such as "cis-docker".  Each benchmark has its
+// own type, so that the checks of different benchmarks coexist on nodes.
+func ComplianceScanType(benchmark string) string {
+	return ComplianceScan + "_" + benchmark
+}
+
+// ParseComplianceScanType returns the benchmark of a type of scan returned
+// by ComplianceScanType.
+func ParseComplianceScanType(scanType string) (string, bool) {
+	const prefix = ComplianceScan + "_"
+	if !strings.HasPrefix(scanType, prefix) || len(scanType) == len(prefix) {
+		return "", false
+	}
+	return scanType[len(prefix):], true
+}
+
+// Merge returns the status of the more recent of the two scans.  Until it
+// has findings, such as while it's queued, it has those of the other.
+func (s ScanStatus) Merge(other ScanStatus) ScanStatus {
+	latest, prev := s, other
+	if other.LastScan.After(s.LastScan) {
+		latest, prev = other, s
+	}
+	if latest.Counts == nil && latest.Status != ScanStatusComplete {
+		latest.Severity, latest.Counts = prev.Severity, prev.Counts
+	}
+	return latest
+}
+
+// ScanStatuses are the statuses of the latest scans of a node, by type.
+type ScanStatuses map[string]ScanStatus
+
+// Add returns ss with s added, unless ss has a more recent scan of the
+// same type.  ss is not modified.
+func (ss ScanStatuses) Add(s ScanStatus) ScanStatuses {
+	return ss.Merge(ScanStatuses{s.Type: s})
+}
+
+// Merge returns the more recent scan of each type of ss and other.
+func (ss ScanStatuses) Merge(other ScanStatuses) ScanStatuses {
+	result := make(ScanStatuses, len(ss)+len(other))
+	for t, s := range ss {
+		result[t] = s
+	}
+	for t, s := range other {
+		if prev, ok := result[t]; ok {
+			s = prev.Merge(s)
+		}
+		result[t] = s
+	}
+	return result
+}
+
+// Types returns the types of scan in ss, sorted.
+func (ss ScanStatuses) Types() []string {
+	types := make([]string, 0, len(ss))
+	for t := range ss {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// WithScanStatus returns a fresh copy of n, with the status of a scan set,
+// unless n has a more recent scan of the same type.  Scan statuses are kept
+// in the latest values of nodes, JSON-encoded, timestamped with the time of
+// the scan, so that merging nodes keeps the most recent scan of each type.
+func (n Node) WithScanStatus(s ScanStatus) Node {
+	key := ScanStatusPrefix + s.Type
+	if ts, ok := n.Latest.Timestamp(key); ok && ts.After(s.LastScan) {
+		return n
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return n
+	}
+	return n.WithLatest(key, s.LastScan, string(buf))
+}
+
+// LookupScanStatus returns the status of the latest scan of n of a type.
+func (n Node) LookupScanStatus(scanType string) (ScanStatus, bool) {
+	value, ok := n.Latest.Lookup(ScanStatusPrefix + scanType)
+	if !ok {
+		return ScanStatus{}, false
+	}
+	return decodeScanStatus(scanType, value)
+}
+
+// ScanStatuses returns the statuses of the latest scans of n, of all types.
+func (n Node) ScanStatuses() ScanStatuses {
+	var result ScanStatuses
+	n.Latest.ForEach(func(key string, _ time.Time, value string) {
+		if !strings.HasPrefix(key, ScanStatusPrefix) {
+			return
+		}
+		scanType := key[len(ScanStatusPrefix):]
+		if s, ok := decodeScanStatus(scanType, value); ok {
+			if result == nil {
+				result = ScanStatuses{}
+			}
+			result[scanType] = s
+		}
+	})
+	return result
+}
+
+func decodeScanStatus(scanType, value string) (ScanStatus, bool) {
+	var s ScanStatus
+	if err := json.Unmarshal([]byte(value), &s); err != nil || s.Type != scanType {
+		return ScanStatus{}, false
+	}
+	return s, true
+}
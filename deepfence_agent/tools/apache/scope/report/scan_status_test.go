@@ -0,0 +1,176 @@
+package report_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/common/test"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test/reflect"
+)
+
+var (
+	scanned         = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	vulnerabilities = report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusComplete,
+		Severity: "high",
+		Counts:   map[string]int{"critical": 0, "high": 3, "low": 12},
+		LastScan: scanned,
+	}
+	secrets = report.ScanStatus{
+		Type:     report.SecretScan,
+		Status:   report.ScanStatusInProgress,
+		LastScan: scanned.Add(time.Hour),
+	}
+)
+
+func TestScanStatusJSONRoundtrip(t *testing.T) {
+	want := report.ScanStatuses{}.Add(vulnerabilities).Add(secrets)
+	buf, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have report.ScanStatuses
+	if err := json.Unmarshal(buf, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestNodeScanStatus(t *testing.T) {
+	node := report.MakeNode("host").WithScanStatus(vulnerabilities).WithScanStatus(secrets)
+
+	if have, ok := node.LookupScanStatus(report.VulnerabilityScan); !ok || !reflect.DeepEqual(vulnerabilities, have) {
+		t.Error(test.Diff(vulnerabilities, have))
+	}
+	if have, ok := node.LookupScanStatus(report.ComplianceScan); ok {
+		t.Errorf("Expected no compliance scan, got %v", have)
+	}
+	if ts, ok := node.Latest.Timestamp(report.ScanStatusPrefix + report.SecretScan); !ok || !ts.Equal(secrets.LastScan) {
+		t.Errorf("Expected the status to be timestamped %v, got %v", secrets.LastScan, ts)
+	}
+	want := report.ScanStatuses{report.VulnerabilityScan: vulnerabilities, report.SecretScan: secrets}
+	if have := node.ScanStatuses(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Statuses which don't decode are ignored
+	node = node.WithLatest(report.ScanStatusPrefix+report.ComplianceScan, scanned, "not json")
+	if have, ok := node.LookupScanStatus(report.ComplianceScan); ok {
+		t.Errorf("Expected no compliance scan, got %v", have)
+	}
+	if have := node.ScanStatuses(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if have := report.MakeNode("other").ScanStatuses(); have != nil {
+		t.Errorf("Expected no scans, got %v", have)
+	}
+}
+
+func TestScanStatusMerge(t *testing.T) {
+	older, newer := vulnerabilities, vulnerabilities
+	older.Status, older.LastScan = report.ScanStatusError, scanned.Add(-time.Hour)
+	newer.Status, newer.LastScan = report.ScanStatusQueued, scanned.Add(time.Hour)
+
+	for _, tc := range []struct {
+		name string
+		a, b report.ScanStatus
+	}{
+		{"older", older, vulnerabilities},
+		{"newer", vulnerabilities, newer},
+	} {
+		want := tc.b
+		if have := tc.a.Merge(tc.b); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(want, have))
+		}
+		if have := tc.b.Merge(tc.a); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(want, have))
+		}
+		if have := (report.ScanStatuses{}).Add(tc.a).Add(tc.b)[report.VulnerabilityScan]; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(want, have))
+		}
+		if have := (report.ScanStatuses{}).Add(tc.b).Add(tc.a)[report.VulnerabilityScan]; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(want, have))
+		}
+
+		// Setting an older scan on a node doesn't replace a newer one
+		node := report.MakeNode("host").WithScanStatus(tc.b).WithScanStatus(tc.a)
+		if have, _ := node.LookupScanStatus(report.VulnerabilityScan); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(want, have))
+		}
+
+		// Nor does merging nodes, whichever way round, nor of other types
+		a := report.MakeNode("host").WithScanStatus(tc.a).WithScanStatus(secrets)
+		b := report.MakeNode("host").WithScanStatus(tc.b)
+		wantAll := report.ScanStatuses{report.VulnerabilityScan: want, report.SecretScan: secrets}
+		if have := a.Merge(b).ScanStatuses(); !reflect.DeepEqual(wantAll, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(wantAll, have))
+		}
+		if have := b.Merge(a).ScanStatuses(); !reflect.DeepEqual(wantAll, have) {
+			t.Errorf("%s: %s", tc.name, test.Diff(wantAll, have))
+		}
+	}
+}
+
+func TestScanStatusMergeQueued(t *testing.T) {
+	// A scan queued has the findings of the last until it has its own
+	queued := report.ScanStatus{
+		Type:     report.VulnerabilityScan,
+		Status:   report.ScanStatusQueued,
+		LastScan: scanned.Add(time.Hour),
+	}
+	want := queued
+	want.Severity, want.Counts = vulnerabilities.Severity, vulnerabilities.Counts
+	if have := vulnerabilities.Merge(queued); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if have := queued.Merge(vulnerabilities); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Once complete, it has only its own
+	complete := queued
+	complete.Status = report.ScanStatusComplete
+	if have := vulnerabilities.Merge(complete); !reflect.DeepEqual(complete, have) {
+		t.Error(test.Diff(complete, have))
+	}
+}
+
+func TestComplianceScanTypes(t *testing.T) {
+	docker := report.ScanStatus{
+		Type:       report.ComplianceScanType("cis-docker"),
+		Status:     report.ScanStatusComplete,
+		Version:    "1.2.0",
+		Counts:     map[string]int{"pass": 10, "fail": 2},
+		Categories: map[string]map[string]int{"host": {"pass": 10, "fail": 2}},
+		LastScan:   scanned,
+	}
+	kubernetes := docker
+	kubernetes.Type, kubernetes.Version = report.ComplianceScanType("cis-kubernetes"), "1.6.0"
+
+	// The checks of each benchmark are kept, whatever order they're set in
+	want := report.ScanStatuses{docker.Type: docker, kubernetes.Type: kubernetes}
+	for _, node := range []report.Node{
+		report.MakeNode("host").WithScanStatus(docker).WithScanStatus(kubernetes),
+		report.MakeNode("host").WithScanStatus(kubernetes).Merge(report.MakeNode("host").WithScanStatus(docker)),
+	} {
+		if have := node.ScanStatuses(); !reflect.DeepEqual(want, have) {
+			t.Error(test.Diff(want, have))
+		}
+	}
+
+	for scanType, want := range map[string]string{
+		docker.Type:                 "cis-docker",
+		report.ComplianceScan:       "",
+		report.VulnerabilityScan:    "",
+		report.ComplianceScan + "_": "",
+	} {
+		if have, ok := report.ParseComplianceScanType(scanType); have != want || ok != (want != "") {
+			t.Errorf("%s: want %q, have %q", scanType, want, have)
+		}
+	}
+}
@@ -43,6 +43,12 @@ func (node Node) AddPrefixPropertyList(prefix string, propertyList map[string]st
 	return node
 }
 
+// WithTableTruncationCount records how many rows were left out of the
+// table with the given prefix, returning a new node.
+func (node Node) WithTableTruncationCount(prefix string, count int) Node {
+	return node.WithLatest(truncationCountPrefix+prefix, mtime.Now(), fmt.Sprintf("%d", count))
+}
+
 // WithoutPrefix returns the string with trimmed prefix and a
 // boolean information of whether that prefix was really there.
 // NOTE: Consider moving this function to utilities.
@@ -50,7 +56,9 @@ func WithoutPrefix(s string, prefix string) (string, bool) {
 	return strings.TrimPrefix(s, prefix), len(prefix) > 0 && strings.HasPrefix(s, prefix)
 }
 
-// ExtractMulticolumnTable returns the rows to build a multicolumn table from this node
+// ExtractMulticolumnTable returns the rows to build a multicolumn table from this node.
+// Rows whose values are all empty are left out: probes write those to clear
+// rows of earlier reports, which the node may have been merged with.
 func (node Node) ExtractMulticolumnTable(template TableTemplate) (rows []Row) {
 	rowsMapByID := map[string]Row{}
 
@@ -80,7 +88,12 @@ func (node Node) ExtractMulticolumnTable(template TableTemplate) (rows []Row) {
 	// Gather a list of rows.
 	rows = make([]Row, 0, len(rowsMapByID))
 	for _, row := range rowsMapByID {
-		rows = append(rows, row)
+		for _, value := range row.Entries {
+			if value != "" {
+				rows = append(rows, row)
+				break
+			}
+		}
 	}
 
 	// Return the rows sorted by ID.
@@ -123,8 +136,8 @@ func (node Node) ExtractPropertyList(template TableTemplate) (rows []Row) {
 // generic table from this node. It also returns the number of rows,
 // if any, that were truncated. The probes used to limit the number of
 // labels, env vars and Weave Net connections they report, but this
-// logic has since been removed. So the code here dealing with
-// truncation is only retained in order to handle legacy reports.
+// logic has since been removed; only the listening ports of hosts are
+// still truncated.
 func (node Node) ExtractTable(template TableTemplate) (rows []Row, truncationCount int) {
 	switch template.Type {
 	case MulticolumnTableType:
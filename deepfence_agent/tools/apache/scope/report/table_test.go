@@ -46,6 +46,30 @@ func TestMulticolumnTables(t *testing.T) {
 	}
 }
 
+func TestMulticolumnTablesClearedRows(t *testing.T) {
+	want := []report.Row{
+		{
+			ID:      "row1",
+			Entries: map[string]string{"col1": "r1c1"},
+		},
+	}
+
+	nmd := report.MakeNode("foo1")
+	nmd = nmd.AddPrefixMulticolumnTable("foo_", append(want, report.Row{
+		ID:      "row2",
+		Entries: map[string]string{"col1": ""},
+	}))
+
+	have, _ := nmd.ExtractTable(report.TableTemplate{
+		Type:   report.MulticolumnTableType,
+		Prefix: "foo_",
+	})
+
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
 func TestPrefixPropertyLists(t *testing.T) {
 	want := []report.Row{
 		{
@@ -3,6 +3,7 @@ package report
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Topology describes a specific view of a network. It consists of
@@ -128,6 +129,8 @@ func (t Topology) WithLabel(label, labelPlural string) Topology {
 func (t Topology) AddNode(node Node) {
 	if existing, ok := t.Nodes[node.ID]; ok {
 		node = node.Merge(existing)
+		// Keep the ID the topology already holds, rather than a copy
+		node.ID = existing.ID
 	}
 	t.Nodes[node.ID] = node
 }
@@ -259,6 +262,26 @@ func (n Nodes) Merge(other Nodes) Nodes {
 	return cp
 }
 
+// dropBefore returns n without the nodes whose newest data is from before
+// cutoff, keeping those with no timestamped data.  n is returned as it is
+// if none are dropped, and otherwise not modified, as it may be shared.
+func (n Nodes) dropBefore(cutoff time.Time) Nodes {
+	var result Nodes
+	for id, node := range n {
+		if newest, ok := node.newest(); !ok || !newest.Before(cutoff) {
+			continue
+		}
+		if result == nil {
+			result = n.Copy()
+		}
+		delete(result, id)
+	}
+	if result == nil {
+		return n
+	}
+	return result
+}
+
 // UnsafeMerge merges the other object into this one, modifying the original.
 func (n *Nodes) UnsafeMerge(other Nodes) {
 	for k, v := range other {